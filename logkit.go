@@ -23,6 +23,7 @@ import (
 	_ "github.com/qiniu/logkit/transforms/builtin"
 	. "github.com/qiniu/logkit/utils/models"
 	utilsos "github.com/qiniu/logkit/utils/os"
+	"github.com/qiniu/logkit/utils/tlspolicy"
 )
 
 //Config of logkit
@@ -39,6 +40,8 @@ type Config struct {
 	CleanSelfLogCnt   int      `json:"clean_self_cnt"`
 	TimeLayouts       []string `json:"timeformat_layouts"`
 	StaticRootPath    string   `json:"static_root_path"`
+	// TLSPolicy 为 fips 时所有 TLS 连接只允许 TLS1.2 以及经过认证的密码套件
+	TLSPolicy string `json:"tls_policy"`
 	mgr.ManagerConfig
 }
 
@@ -67,6 +70,9 @@ The commands & flags are:
 
   -f <file>          configuration file to load
 
+  meta inspect|repair <runner>
+                     inspect or repair the meta of a runner offline, see "logkit meta" for details
+
 Examples:
 
   # start logkit
@@ -248,11 +254,16 @@ func main() {
 	case *upgrade:
 		cli.CheckAndUpgrade(NextVersion)
 		return
+	case flag.Arg(0) == "meta":
+		os.Exit(runMetaCommand(flag.Args()[1:], *confName, os.Stdout))
 	}
 
 	if err := config.LoadEx(&conf, *confName); err != nil {
 		log.Fatal("config.Load failed:", err)
 	}
+	if err := tlspolicy.SetPolicy(conf.TLSPolicy); err != nil {
+		log.Fatal(err)
+	}
 	if conf.TimeLayouts != nil {
 		times.AddLayout(conf.TimeLayouts)
 	}
@@ -300,6 +311,13 @@ func main() {
 		log.Fatalf("watch path error %v", err)
 	}
 	m.RestoreWebDir()
+	if tlspolicy.Enabled() {
+		report := m.TLSPolicyReport()
+		for _, issue := range report {
+			log.Warnf("tls policy: %v", issue)
+		}
+		log.Infof("tls policy is enabled, %d non-compliant configs found", len(report))
+	}
 
 	stopClean := make(chan struct{}, 0)
 	defer close(stopClean)
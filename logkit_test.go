@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -82,3 +83,43 @@ func Test_cleanlogkitlog(t *testing.T) {
 		}
 	}
 }
+
+func Test_runMetaCommand(t *testing.T) {
+	root, err := ioutil.TempDir("", "Test_runMetaCommand")
+	assert.NoError(t, err)
+	defer os.RemoveAll(root)
+	logFile := filepath.Join(root, "a.log")
+	assert.NoError(t, ioutil.WriteFile(logFile, []byte("abc\n"), 0644))
+	metaDir := filepath.Join(root, "meta")
+	assert.NoError(t, os.MkdirAll(metaDir, 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(metaDir, "file.meta"), []byte(logFile+"\t100\n"), 0644))
+
+	confsDir := filepath.Join(root, "confs")
+	assert.NoError(t, os.MkdirAll(confsDir, 0755))
+	runnerConf := `{"name":"meta_runner","reader":{"mode":"file","log_path":"` + logFile + `","meta_path":"` + metaDir + `"},"senders":[{"sender_type":"discard"}]}`
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(confsDir, "runner.conf"), []byte(runnerConf), 0644))
+	confName := filepath.Join(root, "logkit.conf")
+	assert.NoError(t, ioutil.WriteFile(confName, []byte(`{"confs_path":["`+filepath.Join(root, "conf*")+`"],"rest_dir":"`+filepath.Join(root, "rest")+`"}`), 0644))
+
+	var out bytes.Buffer
+	assert.Equal(t, 1, runMetaCommand([]string{"inspect", "meta_runner"}, confName, &out))
+	assert.Contains(t, out.String(), "offset: "+logFile+" offset=100 size=4 encoding=text")
+	assert.Contains(t, out.String(), "[offset_beyond_size]")
+
+	out.Reset()
+	assert.Equal(t, 0, runMetaCommand([]string{"repair", "meta_runner"}, confName, &out))
+	assert.Contains(t, out.String(), "meta has been backed up to "+metaDir+".backup-")
+	content, err := ioutil.ReadFile(filepath.Join(metaDir, "file.meta"))
+	assert.NoError(t, err)
+	assert.Equal(t, logFile+"\t0\n", string(content))
+
+	out.Reset()
+	assert.Equal(t, 0, runMetaCommand([]string{"inspect", "-json", metaDir}, confName, &out))
+	assert.Contains(t, out.String(), `"offset": 0`)
+
+	out.Reset()
+	assert.Equal(t, 1, runMetaCommand([]string{"inspect", "not_exist"}, confName, &out))
+	assert.Contains(t, out.String(), "runner not_exist is not found")
+	assert.Equal(t, 1, runMetaCommand([]string{"unknown", metaDir}, confName, &out))
+	assert.Equal(t, 1, runMetaCommand(nil, confName, &out))
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	config "github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/mgr"
+	"github.com/qiniu/logkit/queue"
+	"github.com/qiniu/logkit/reader"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const metaUsage = `logkit meta, inspect and repair the meta of a runner offline.
+
+Usage:
+
+  logkit [-f <file>] meta inspect [-json] <runner>
+  logkit [-f <file>] meta repair <runner>
+
+<runner> is the runner name in the configs of confs_path and rest_dir, or the meta directory itself.
+
+  inspect            print offsets, submetas of tailx and dirx, ft queue depth and detected problems,
+                     exit with 1 when any problem is found.
+  repair             backup the meta directory to <dir>.backup-<time> and repair the problems:
+                       offset_beyond_size   reset the offset to 0, the file has been truncated or replaced
+                       orphan_submeta       remove the submeta whose file or directory does not exist
+                       corrupt_meta         remove the broken file.meta
+                     stop the runner before repairing.
+
+Examples:
+
+  logkit -f logkit.conf meta inspect nginx_runner
+  logkit meta repair ./meta/nginx_runner_1234567890
+`
+
+// metaOutput 为 inspect -json 的输出
+type metaOutput struct {
+	*reader.MetaReport
+	Queues []queue.DiskQueueState `json:"ft_queues"`
+}
+
+// runMetaCommand 执行 logkit meta 子命令，返回进程的退出码
+func runMetaCommand(args []string, confName string, out io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(out, metaUsage)
+		return 1
+	}
+	fs := flag.NewFlagSet("meta "+args[0], flag.ContinueOnError)
+	fs.SetOutput(out)
+	jsonOutput := fs.Bool("json", false, "print the report as json")
+	if err := fs.Parse(args[1:]); err != nil || fs.NArg() != 1 {
+		fmt.Fprint(out, metaUsage)
+		return 1
+	}
+	dir, err := resolveMetaDir(fs.Arg(0), confName)
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 1
+	}
+	report, err := reader.InspectMeta(dir)
+	if err != nil {
+		fmt.Fprintf(out, "inspect meta %v error: %v\n", dir, err)
+		return 1
+	}
+	queues, err := queue.ReadDiskQueueStates(filepath.Join(dir, reader.FtSaveLogPath))
+	if err != nil {
+		fmt.Fprintf(out, "inspect ft queues error: %v\n", err)
+		return 1
+	}
+
+	switch args[0] {
+	case "inspect":
+		if *jsonOutput {
+			data, err := json.MarshalIndent(metaOutput{MetaReport: report, Queues: queues}, "", "  ")
+			if err != nil {
+				fmt.Fprintln(out, err)
+				return 1
+			}
+			fmt.Fprintln(out, string(data))
+		} else {
+			printMetaReport(out, report, queues)
+		}
+		if len(report.Problems) > 0 || hasQueueProblem(queues) {
+			return 1
+		}
+		return 0
+	case "repair":
+		printMetaReport(out, report, queues)
+		backup, repaired, err := reader.RepairMeta(report)
+		if backup != "" {
+			fmt.Fprintf(out, "\nmeta has been backed up to %v\n", backup)
+		}
+		for _, p := range repaired {
+			fmt.Fprintf(out, "repaired [%v] %v\n", p.Kind, p.Path)
+		}
+		if err != nil {
+			fmt.Fprintln(out, err)
+			return 1
+		}
+		if len(repaired) == 0 {
+			fmt.Fprintln(out, "\nnothing to repair")
+		}
+		for _, p := range report.Problems {
+			if !p.Repairable() {
+				fmt.Fprintf(out, "not repaired [%v] %v: %v\n", p.Kind, p.Path, p.Detail)
+			}
+		}
+		if hasQueueProblem(queues) {
+			fmt.Fprintln(out, "problems of ft queues are corrected by logkit automatically when the runner starts")
+		}
+		return 0
+	}
+	fmt.Fprint(out, metaUsage)
+	return 1
+}
+
+func hasQueueProblem(queues []queue.DiskQueueState) bool {
+	for _, q := range queues {
+		if q.Problem != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveMetaDir runner 为已经存在的目录时直接作为 meta 目录，否则在 confName 的 confs_path 和 rest_dir 中查找同名的 runner
+func resolveMetaDir(runner, confName string) (string, error) {
+	if fi, err := os.Stat(runner); err == nil && fi.IsDir() {
+		return runner, nil
+	}
+	var c Config
+	if err := config.LoadEx(&c, confName); err != nil {
+		return "", fmt.Errorf("%v is not a meta directory, and load %v error: %v", runner, confName, err)
+	}
+	restDir := c.RestDir
+	if restDir == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return "", err
+		}
+		restDir = wd + mgr.DEFAULT_LOGKIT_REST_DIR
+	}
+	return findRunnerMetaDir(runner, append(c.ConfsPath, restDir))
+}
+
+// findRunnerMetaDir 与 Manager 加载配置的方式相同，confsPaths 中的每一项为目录的 glob 模式
+func findRunnerMetaDir(runner string, confsPaths []string) (string, error) {
+	for _, pattern := range confsPaths {
+		dirs, err := filepath.Glob(pattern)
+		if err != nil {
+			continue
+		}
+		for _, dir := range dirs {
+			files, err := ioutil.ReadDir(dir)
+			if err != nil {
+				continue
+			}
+			for _, f := range files {
+				if f.IsDir() || !strings.HasSuffix(f.Name(), ".conf") {
+					continue
+				}
+				var rc mgr.RunnerConfig
+				if err = config.LoadEx(&rc, filepath.Join(dir, f.Name())); err != nil || rc.RunnerName != runner || rc.ReaderConfig == nil {
+					continue
+				}
+				rc.ReaderConfig[GlobalKeyName] = rc.RunnerName
+				_, _, metaPath, err := reader.GetMetaOption(rc.ReaderConfig)
+				if err != nil {
+					return "", fmt.Errorf("get meta path of runner %v error: %v", runner, err)
+				}
+				return metaPath, nil
+			}
+		}
+	}
+	return "", errors.New("runner " + runner + " is not found in confs_path and rest_dir")
+}
+
+func printMetaReport(out io.Writer, report *reader.MetaReport, queues []queue.DiskQueueState) {
+	fmt.Fprintf(out, "meta: %v\n", report.Dir)
+	if report.Offset != nil {
+		fmt.Fprintf(out, "offset: %v\n", formatMetaOffset(*report.Offset))
+	}
+	fmt.Fprintf(out, "\nsubmetas (%d):\n", len(report.SubMetas))
+	for _, sub := range report.SubMetas {
+		fmt.Fprintf(out, "  %v\n", formatMetaOffset(sub))
+	}
+	fmt.Fprintf(out, "\nft queues (%d):\n", len(queues))
+	for _, q := range queues {
+		fmt.Fprintf(out, "  %v depth=%d bytes=%d read=%d,%d write=%d,%d\n", filepath.Join(q.Dir, q.Name),
+			q.Depth, q.Bytes, q.ReadFileNum, q.ReadPos, q.WriteFileNum, q.WritePos)
+	}
+	problems := len(report.Problems)
+	for _, q := range queues {
+		if q.Problem != "" {
+			problems++
+		}
+	}
+	fmt.Fprintf(out, "\nproblems (%d):\n", problems)
+	for _, p := range report.Problems {
+		fmt.Fprintf(out, "  [%v] %v: %v\n", p.Kind, p.Path, p.Detail)
+	}
+	for _, q := range queues {
+		if q.Problem != "" {
+			fmt.Fprintf(out, "  [ft_queue] %v: %v\n", filepath.Join(q.Dir, q.Name), q.Problem)
+		}
+	}
+}
+
+func formatMetaOffset(o reader.MetaOffset) string {
+	size := "missing"
+	if o.Size >= 0 {
+		size = fmt.Sprintf("%d", o.Size)
+	}
+	return fmt.Sprintf("%v offset=%d size=%v encoding=%v", o.File, o.Offset, size, o.Encoding)
+}
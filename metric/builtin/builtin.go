@@ -2,10 +2,17 @@ package builtin
 
 import (
 	_ "github.com/qiniu/logkit/metric/curl"
+	_ "github.com/qiniu/logkit/metric/etcd"
+	_ "github.com/qiniu/logkit/metric/ipmi"
+	_ "github.com/qiniu/logkit/metric/kafka"
+	_ "github.com/qiniu/logkit/metric/postgres"
+	_ "github.com/qiniu/logkit/metric/redis"
 	_ "github.com/qiniu/logkit/metric/system"
 	_ "github.com/qiniu/logkit/metric/telegraf"
 	_ "github.com/qiniu/logkit/metric/telegraf/docker"
 	_ "github.com/qiniu/logkit/metric/telegraf/elasticsearch"
 	_ "github.com/qiniu/logkit/metric/telegraf/httpresponse"
 	_ "github.com/qiniu/logkit/metric/telegraf/memcached"
+	_ "github.com/qiniu/logkit/metric/webserver"
+	_ "github.com/qiniu/logkit/metric/zookeeper"
 )
@@ -14,6 +14,7 @@ import (
 
 	"github.com/qiniu/logkit/metric"
 	. "github.com/qiniu/logkit/utils/models"
+	"github.com/qiniu/logkit/utils/tlspolicy"
 )
 
 const (
@@ -134,6 +135,7 @@ func (s *HttpStats) Collect() (datas []map[string]interface{}, err error) {
 					Deadline:  time.Now().Add(DefaultTimeOut),
 					KeepAlive: DefaultTimeOut,
 				}).Dial,
+				TLSClientConfig: tlspolicy.Apply(nil),
 			},
 		}
 		resp, err := client.Do(request) //发送请求
@@ -0,0 +1,319 @@
+package etcd
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qiniu/logkit/metric"
+	"github.com/qiniu/logkit/reader/prometheus"
+	. "github.com/qiniu/logkit/utils/models"
+	"github.com/qiniu/logkit/utils/tlspolicy"
+)
+
+const (
+	TypeMetricEtcd   = "etcd"
+	MetricEtcdUsages = "etcd(etcd)"
+
+	// TypeMetricEtcd 信息中的字段
+	KeyEtcdEndpoint           = "etcd_endpoint"
+	KeyEtcdHealthy            = "etcd_healthy"
+	KeyEtcdVersion            = "etcd_version"
+	KeyEtcdHasLeader          = "etcd_server_has_leader"
+	KeyEtcdIsLeader           = "etcd_server_is_leader"
+	KeyEtcdLeaderChanges      = "etcd_server_leader_changes_seen_total"
+	KeyEtcdProposalsCommitted = "etcd_server_proposals_committed_total"
+	KeyEtcdProposalsApplied   = "etcd_server_proposals_applied_total"
+	KeyEtcdProposalsPending   = "etcd_server_proposals_pending"
+	KeyEtcdProposalsFailed    = "etcd_server_proposals_failed_total"
+	KeyEtcdDbSize             = "etcd_mvcc_db_total_size_in_bytes"
+	KeyEtcdDbSizeInUse        = "etcd_mvcc_db_total_size_in_use_in_bytes"
+	KeyEtcdKeys               = "etcd_debugging_mvcc_keys_total"
+	KeyEtcdGrpcReceived       = "etcd_network_client_grpc_received_bytes_total"
+	KeyEtcdGrpcSent           = "etcd_network_client_grpc_sent_bytes_total"
+	KeyEtcdWalFsyncAvg        = "etcd_disk_wal_fsync_duration_seconds_avg"
+	KeyEtcdBackendCommitAvg   = "etcd_disk_backend_commit_duration_seconds_avg"
+
+	// Config 中的字段
+	ConfigEtcdEndpoints          = "etcd_endpoints"
+	ConfigEtcdTimeout            = "timeout"
+	ConfigEtcdCAFile             = "ca_file"
+	ConfigEtcdCertFile           = "cert_file"
+	ConfigEtcdKeyFile            = "key_file"
+	ConfigEtcdInsecureSkipVerify = "insecure_skip_verify"
+
+	defaultTimeout = 5 * time.Second
+)
+
+// KeyEtcdUsages TypeMetricEtcd 的字段名称
+var KeyEtcdUsages = KeyValueSlice{
+	{KeyEtcdEndpoint, "etcd 地址", ""},
+	{KeyEtcdHealthy, "是否正常(/health 返回 true 为1，否则为0)", ""},
+	{KeyEtcdVersion, "版本", ""},
+	{KeyEtcdHasLeader, "是否有 leader", ""},
+	{KeyEtcdIsLeader, "是否为 leader", ""},
+	{KeyEtcdLeaderChanges, "leader 切换次数", ""},
+	{KeyEtcdProposalsCommitted, "已提交的提案数", ""},
+	{KeyEtcdProposalsApplied, "已应用的提案数", ""},
+	{KeyEtcdProposalsPending, "等待中的提案数", ""},
+	{KeyEtcdProposalsFailed, "失败的提案数", ""},
+	{KeyEtcdDbSize, "数据库大小(bytes)", ""},
+	{KeyEtcdDbSizeInUse, "数据库使用中的大小(bytes)", ""},
+	{KeyEtcdKeys, "key 数量", ""},
+	{KeyEtcdGrpcReceived, "客户端 grpc 接收字节数", ""},
+	{KeyEtcdGrpcSent, "客户端 grpc 发送字节数", ""},
+	{KeyEtcdWalFsyncAvg, "WAL fsync 平均耗时(s)", ""},
+	{KeyEtcdBackendCommitAvg, "后端提交平均耗时(s)", ""},
+}
+
+// gauges 为 /metrics 中需要采集的指标，同名不同标签的样本累加
+var gauges = map[string]bool{
+	KeyEtcdHasLeader:          true,
+	KeyEtcdIsLeader:           true,
+	KeyEtcdLeaderChanges:      true,
+	KeyEtcdProposalsCommitted: true,
+	KeyEtcdProposalsApplied:   true,
+	KeyEtcdProposalsPending:   true,
+	KeyEtcdProposalsFailed:    true,
+	KeyEtcdDbSize:             true,
+	KeyEtcdDbSizeInUse:        true,
+	KeyEtcdKeys:               true,
+	KeyEtcdGrpcReceived:       true,
+	KeyEtcdGrpcSent:           true,
+}
+
+// histograms 为需要计算平均耗时的 histogram，key 为指标名，value 为输出的字段名
+var histograms = map[string]string{
+	"etcd_disk_wal_fsync_duration_seconds":      KeyEtcdWalFsyncAvg,
+	"etcd_disk_backend_commit_duration_seconds": KeyEtcdBackendCommitAvg,
+}
+
+type EtcdStats struct {
+	Endpoints          string `json:"etcd_endpoints"`
+	Timeout            string `json:"timeout"`
+	CAFile             string `json:"ca_file"`
+	CertFile           string `json:"cert_file"`
+	KeyFile            string `json:"key_file"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+
+	client *http.Client
+}
+
+func (*EtcdStats) Name() string {
+	return TypeMetricEtcd
+}
+
+func (*EtcdStats) Usages() string {
+	return MetricEtcdUsages
+}
+
+func (*EtcdStats) Tags() []string {
+	return []string{KeyEtcdEndpoint}
+}
+
+func (*EtcdStats) Config() map[string]interface{} {
+	configOptions := []Option{
+		{
+			KeyName:      ConfigEtcdEndpoints,
+			ChooseOnly:   false,
+			Default:      "http://localhost:2379",
+			DefaultNoUse: true,
+			Description:  "etcd 地址(逗号分隔多个)(" + ConfigEtcdEndpoints + ")",
+			Type:         metric.ConfigTypeString,
+		},
+		{
+			KeyName:      ConfigEtcdTimeout,
+			ChooseOnly:   false,
+			Default:      "5s",
+			DefaultNoUse: false,
+			Description:  "单个节点采集超时时间(" + ConfigEtcdTimeout + ")",
+			Type:         metric.ConfigTypeString,
+		},
+		{
+			KeyName:      ConfigEtcdCAFile,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "CA 证书路径(" + ConfigEtcdCAFile + ")",
+			Type:         metric.ConfigTypeString,
+		},
+		{
+			KeyName:      ConfigEtcdCertFile,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "客户端证书路径(" + ConfigEtcdCertFile + ")",
+			Type:         metric.ConfigTypeString,
+		},
+		{
+			KeyName:      ConfigEtcdKeyFile,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "客户端私钥路径(" + ConfigEtcdKeyFile + ")",
+			Type:         metric.ConfigTypeString,
+		},
+		{
+			KeyName:       ConfigEtcdInsecureSkipVerify,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{"false", "true"},
+			Default:       "false",
+			DefaultNoUse:  false,
+			Description:   "是否跳过证书校验(" + ConfigEtcdInsecureSkipVerify + ")",
+			Type:          metric.ConfigTypeBool,
+		},
+	}
+	return map[string]interface{}{
+		metric.OptionString:     configOptions,
+		metric.AttributesString: KeyEtcdUsages,
+	}
+}
+
+func (s *EtcdStats) init() error {
+	if s.client != nil {
+		return nil
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: s.InsecureSkipVerify}
+	if s.CAFile != "" {
+		ca, err := ioutil.ReadFile(s.CAFile)
+		if err != nil {
+			return fmt.Errorf("metric %v read %v error %v", TypeMetricEtcd, ConfigEtcdCAFile, err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return fmt.Errorf("metric %v %v %v contains no valid certificate", TypeMetricEtcd, ConfigEtcdCAFile, s.CAFile)
+		}
+	}
+	if s.CertFile != "" || s.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(s.CertFile, s.KeyFile)
+		if err != nil {
+			return fmt.Errorf("metric %v load client certificate error %v", TypeMetricEtcd, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	timeout, err := time.ParseDuration(s.Timeout)
+	if err != nil || timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	s.client = &http.Client{
+		Timeout:   timeout,
+		Transport: &http.Transport{TLSClientConfig: tlspolicy.Apply(tlsConfig)},
+	}
+	return nil
+}
+
+// Collect 并发采集所有节点，/health 请求失败的节点也会输出一条 etcd_healthy 为0的数据，同时返回最后一个错误
+func (s *EtcdStats) Collect() (datas []map[string]interface{}, err error) {
+	if err = s.init(); err != nil {
+		return nil, err
+	}
+	var (
+		wg   sync.WaitGroup
+		lock sync.Mutex
+	)
+	for _, endpoint := range strings.Split(s.Endpoints, ",") {
+		endpoint = strings.TrimSuffix(strings.TrimSpace(endpoint), "/")
+		if endpoint == "" {
+			continue
+		}
+		if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+			endpoint = "http://" + endpoint
+		}
+		wg.Add(1)
+		go func(endpoint string) {
+			defer wg.Done()
+			data, cerr := s.collectEndpoint(endpoint)
+			lock.Lock()
+			defer lock.Unlock()
+			if cerr != nil {
+				err = fmt.Errorf("collect etcd %v error: %v", endpoint, cerr)
+			}
+			datas = append(datas, data)
+		}(endpoint)
+	}
+	wg.Wait()
+	return datas, err
+}
+
+func (s *EtcdStats) collectEndpoint(endpoint string) (map[string]interface{}, error) {
+	data := map[string]interface{}{
+		KeyEtcdEndpoint: endpoint,
+		KeyEtcdHealthy:  0,
+	}
+	resp, err := s.client.Get(endpoint + "/health")
+	if err != nil {
+		return data, err
+	}
+	// 不健康时 /health 返回 503，body 中同样有 health 字段
+	var health struct {
+		Health string `json:"health"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&health)
+	resp.Body.Close()
+	if err != nil {
+		return data, fmt.Errorf("decode /health response with status %v error %v", resp.Status, err)
+	}
+	if health.Health == "true" {
+		data[KeyEtcdHealthy] = 1
+	}
+
+	resp, err = s.client.Get(endpoint + "/metrics")
+	if err != nil {
+		return data, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return data, fmt.Errorf("get /metrics unexpected status %v", resp.Status)
+	}
+	samples, err := prometheus.ParseText(resp.Body)
+	if err != nil {
+		return data, err
+	}
+	for k, v := range ParseMetrics(samples) {
+		data[k] = v
+	}
+	return data, nil
+}
+
+// ParseMetrics 从 /metrics 的样本中提取需要的指标，histogram 转换为平均值
+func ParseMetrics(samples []prometheus.Sample) map[string]interface{} {
+	var (
+		stats  = make(map[string]interface{})
+		sums   = make(map[string]float64)
+		counts = make(map[string]float64)
+	)
+	for _, sample := range samples {
+		if gauges[sample.Name] {
+			v, _ := stats[sample.Name].(float64)
+			stats[sample.Name] = v + sample.Value
+			continue
+		}
+		if sample.Name == "etcd_server_version" {
+			stats[KeyEtcdVersion] = sample.Labels["server_version"]
+			continue
+		}
+		if name := strings.TrimSuffix(sample.Name, "_sum"); histograms[name] != "" {
+			sums[name] += sample.Value
+		} else if name := strings.TrimSuffix(sample.Name, "_count"); histograms[name] != "" {
+			counts[name] += sample.Value
+		}
+	}
+	for name, key := range histograms {
+		if counts[name] > 0 {
+			stats[key] = sums[name] / counts[name]
+		}
+	}
+	return stats
+}
+
+func init() {
+	metric.Add(TypeMetricEtcd, func() metric.Collector {
+		return &EtcdStats{}
+	})
+}
@@ -0,0 +1,82 @@
+package etcd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/qiniu/logkit/reader/prometheus"
+)
+
+const metricsOutput = `# TYPE etcd_server_has_leader gauge
+etcd_server_has_leader 1
+# TYPE etcd_server_is_leader gauge
+etcd_server_is_leader 0
+# TYPE etcd_server_version gauge
+etcd_server_version{server_version="3.4.13"} 1
+# TYPE etcd_server_proposals_committed_total gauge
+etcd_server_proposals_committed_total 1024
+# TYPE etcd_mvcc_db_total_size_in_bytes gauge
+etcd_mvcc_db_total_size_in_bytes 2.097152e+06
+# TYPE etcd_network_client_grpc_sent_bytes_total counter
+etcd_network_client_grpc_sent_bytes_total 300
+# TYPE etcd_disk_wal_fsync_duration_seconds histogram
+etcd_disk_wal_fsync_duration_seconds_bucket{le="0.001"} 2
+etcd_disk_wal_fsync_duration_seconds_bucket{le="+Inf"} 4
+etcd_disk_wal_fsync_duration_seconds_sum 0.02
+etcd_disk_wal_fsync_duration_seconds_count 4
+# TYPE go_goroutines gauge
+go_goroutines 80
+`
+
+func TestParseMetrics(t *testing.T) {
+	samples, err := prometheus.ParseText(strings.NewReader(metricsOutput))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		KeyEtcdHasLeader:          float64(1),
+		KeyEtcdIsLeader:           float64(0),
+		KeyEtcdVersion:            "3.4.13",
+		KeyEtcdProposalsCommitted: float64(1024),
+		KeyEtcdDbSize:             float64(2097152),
+		KeyEtcdGrpcSent:           float64(300),
+		KeyEtcdWalFsyncAvg:        0.005,
+	}, ParseMetrics(samples))
+}
+
+func TestCollect(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/health":
+			w.Write([]byte(`{"health":"true"}`))
+		case "/metrics":
+			w.Write([]byte(metricsOutput))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer healthy.Close()
+	unhealthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"health":"false"}`))
+	}))
+	defer unhealthy.Close()
+
+	s := &EtcdStats{Endpoints: healthy.URL + "/, " + strings.TrimPrefix(unhealthy.URL, "http://"), Timeout: "1s"}
+	datas, err := s.Collect()
+	assert.Error(t, err)
+	require.Len(t, datas, 2)
+	for _, data := range datas {
+		if data[KeyEtcdEndpoint] == unhealthy.URL {
+			assert.Equal(t, map[string]interface{}{KeyEtcdEndpoint: unhealthy.URL, KeyEtcdHealthy: 0}, data)
+			continue
+		}
+		assert.Equal(t, healthy.URL, data[KeyEtcdEndpoint])
+		assert.Equal(t, 1, data[KeyEtcdHealthy])
+		assert.Equal(t, "3.4.13", data[KeyEtcdVersion])
+		assert.Equal(t, float64(1), data[KeyEtcdHasLeader])
+	}
+}
@@ -0,0 +1,367 @@
+package ipmi
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/json-iterator/go"
+
+	"github.com/qiniu/logkit/metric"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const (
+	TypeMetricIpmi   = "ipmi"
+	MetricIpmiUsages = "硬件传感器(ipmi)"
+
+	// TypeMetricIpmi 信息中的字段
+	KeyIpmiServer   = "ipmi_server"
+	KeyIpmiProtocol = "ipmi_protocol"
+	KeyIpmiName     = "ipmi_name"
+	KeyIpmiType     = "ipmi_type"
+	KeyIpmiValue    = "ipmi_value"
+	KeyIpmiUnit     = "ipmi_unit"
+	KeyIpmiStatus   = "ipmi_status"
+	KeyIpmiHealthy  = "ipmi_healthy"
+
+	// Config 中的字段
+	ConfigIpmiServers  = "bmc_servers"
+	ConfigIpmiToolPath = "ipmitool_path"
+	ConfigIpmiTimeout  = "timeout"
+
+	ProtocolIpmi    = "ipmi"
+	ProtocolRedfish = "redfish"
+
+	SensorTemperature = "temperature"
+	SensorFan         = "fan"
+	SensorPower       = "power"
+	SensorPSU         = "psu"
+	SensorVoltage     = "voltage"
+	SensorCurrent     = "current"
+	SensorOther       = "other"
+
+	defaultTimeout = 20 * time.Second
+)
+
+// KeyIpmiUsages TypeMetricIpmi 的字段名称
+var KeyIpmiUsages = KeyValueSlice{
+	{KeyIpmiServer, "BMC 地址", ""},
+	{KeyIpmiProtocol, "采集协议(ipmi/redfish)", ""},
+	{KeyIpmiName, "传感器名称", ""},
+	{KeyIpmiType, "传感器类型(temperature/fan/power/psu/voltage/current/other)", ""},
+	{KeyIpmiValue, "传感器读数", ""},
+	{KeyIpmiUnit, "读数单位", ""},
+	{KeyIpmiStatus, "传感器状态", ""},
+	{KeyIpmiHealthy, "是否正常(1正常，0异常)", ""},
+}
+
+// BMC 一台服务器的 BMC 连接信息，每台可以使用不同的账号
+type BMC struct {
+	Protocol string `json:"protocol"`
+	Host     string `json:"host"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// Interface ipmitool 的 -I 参数，默认为 lanplus，host 为空时读取本机
+	Interface string `json:"interface"`
+	// InsecureSkipVerify redfish 使用 https 时是否跳过证书校验，BMC 通常为自签名证书
+	InsecureSkipVerify bool `json:"insecure_skip_verify"`
+}
+
+// Sensor 一个传感器的读数
+type Sensor struct {
+	Name   string
+	Type   string
+	Value  *float64
+	Unit   string
+	Status string
+	OK     bool
+}
+
+type IpmiStats struct {
+	Servers      string `json:"bmc_servers"`
+	IpmiToolPath string `json:"ipmitool_path"`
+	Timeout      string `json:"timeout"`
+
+	parsed string
+	bmcs   []BMC
+}
+
+var execCommand = exec.Command
+
+func (*IpmiStats) Name() string {
+	return TypeMetricIpmi
+}
+
+func (*IpmiStats) Usages() string {
+	return MetricIpmiUsages
+}
+
+func (*IpmiStats) Tags() []string {
+	return []string{KeyIpmiServer, KeyIpmiProtocol, KeyIpmiName, KeyIpmiType}
+}
+
+func (*IpmiStats) Config() map[string]interface{} {
+	configOptions := []Option{
+		{
+			KeyName:      ConfigIpmiServers,
+			ChooseOnly:   false,
+			Default:      `[{"protocol":"ipmi","host":"10.0.0.1","username":"admin","password":"admin"},{"protocol":"redfish","host":"https://10.0.0.2","username":"root","password":"calvin","insecure_skip_verify":true}]`,
+			DefaultNoUse: true,
+			Description:  "BMC 列表(" + ConfigIpmiServers + ")",
+			Type:         metric.ConfigTypeString,
+		},
+		{
+			KeyName:      ConfigIpmiToolPath,
+			ChooseOnly:   false,
+			Default:      "ipmitool",
+			DefaultNoUse: false,
+			Description:  "ipmitool 路径(" + ConfigIpmiToolPath + ")",
+			Type:         metric.ConfigTypeString,
+		},
+		{
+			KeyName:      ConfigIpmiTimeout,
+			ChooseOnly:   false,
+			Default:      "20s",
+			DefaultNoUse: false,
+			Description:  "单台 BMC 采集超时时间(" + ConfigIpmiTimeout + ")",
+			Type:         metric.ConfigTypeString,
+		},
+	}
+	return map[string]interface{}{
+		metric.OptionString:     configOptions,
+		metric.AttributesString: KeyIpmiUsages,
+	}
+}
+
+func (s *IpmiStats) init() error {
+	if s.parsed == s.Servers && s.bmcs != nil {
+		return nil
+	}
+	var bmcs []BMC
+	if err := jsoniter.Unmarshal([]byte(s.Servers), &bmcs); err != nil {
+		return fmt.Errorf("metric %v unmarshal %v error %v", TypeMetricIpmi, ConfigIpmiServers, err)
+	}
+	for i := range bmcs {
+		if bmcs[i].Protocol == "" {
+			bmcs[i].Protocol = ProtocolIpmi
+		}
+		bmcs[i].Protocol = strings.ToLower(bmcs[i].Protocol)
+		switch bmcs[i].Protocol {
+		case ProtocolIpmi:
+			if bmcs[i].Interface == "" {
+				bmcs[i].Interface = "lanplus"
+			}
+		case ProtocolRedfish:
+			if bmcs[i].Host == "" {
+				return fmt.Errorf("metric %v redfish host should not be empty", TypeMetricIpmi)
+			}
+		default:
+			return fmt.Errorf("metric %v protocol %q is not supported", TypeMetricIpmi, bmcs[i].Protocol)
+		}
+	}
+	s.bmcs = bmcs
+	s.parsed = s.Servers
+	return nil
+}
+
+func (s *IpmiStats) timeout() time.Duration {
+	timeout, err := time.ParseDuration(s.Timeout)
+	if err != nil || timeout <= 0 {
+		return defaultTimeout
+	}
+	return timeout
+}
+
+// Collect 并发采集所有 BMC，单台失败时返回其余 BMC 的数据和最后一个错误
+func (s *IpmiStats) Collect() (datas []map[string]interface{}, err error) {
+	if err = s.init(); err != nil {
+		return nil, err
+	}
+	var (
+		wg   sync.WaitGroup
+		lock sync.Mutex
+	)
+	for _, bmc := range s.bmcs {
+		wg.Add(1)
+		go func(bmc BMC) {
+			defer wg.Done()
+			var (
+				sensors []Sensor
+				cerr    error
+			)
+			if bmc.Protocol == ProtocolRedfish {
+				sensors, cerr = collectRedfish(bmc, s.timeout())
+			} else {
+				sensors, cerr = s.collectIpmi(bmc)
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			if cerr != nil {
+				err = fmt.Errorf("collect %v %v error: %v", bmc.Protocol, bmc.Host, cerr)
+			}
+			for _, sensor := range sensors {
+				datas = append(datas, sensorData(bmc, sensor))
+			}
+		}(bmc)
+	}
+	wg.Wait()
+	return datas, err
+}
+
+func sensorData(bmc BMC, sensor Sensor) map[string]interface{} {
+	server := bmc.Host
+	if server == "" {
+		server = "localhost"
+	}
+	data := map[string]interface{}{
+		KeyIpmiServer:   server,
+		KeyIpmiProtocol: bmc.Protocol,
+		KeyIpmiName:     sensor.Name,
+		KeyIpmiType:     sensor.Type,
+		KeyIpmiUnit:     sensor.Unit,
+		KeyIpmiStatus:   sensor.Status,
+		KeyIpmiHealthy:  0,
+	}
+	if sensor.OK {
+		data[KeyIpmiHealthy] = 1
+	}
+	if sensor.Value != nil {
+		data[KeyIpmiValue] = *sensor.Value
+	}
+	return data
+}
+
+func (s *IpmiStats) collectIpmi(bmc BMC) ([]Sensor, error) {
+	path := s.IpmiToolPath
+	if path == "" {
+		path = "ipmitool"
+	}
+	var args []string
+	if bmc.Host != "" {
+		// 密码通过环境变量传入，避免出现在进程列表中
+		args = append(args, "-I", bmc.Interface, "-H", bmc.Host, "-U", bmc.Username, "-E")
+	}
+	args = append(args, "sdr")
+
+	cmd := execCommand(path, args...)
+	if bmc.Host != "" {
+		cmd.Env = append(os.Environ(), "IPMI_PASSWORD="+bmc.Password)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+		}
+	case <-time.After(s.timeout()):
+		cmd.Process.Kill()
+		return nil, errors.New("ipmitool timeout")
+	}
+	return ParseSdr(stdout.Bytes()), nil
+}
+
+var valueRegex = regexp.MustCompile(`^(-?[0-9.]+)\s*(.*)$`)
+
+// ParseSdr 解析 ipmitool sdr 的输出，每行格式为 "名称 | 读数 单位 | 状态"
+func ParseSdr(out []byte) []Sensor {
+	var sensors []Sensor
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		parts := strings.Split(scanner.Text(), "|")
+		if len(parts) < 3 {
+			continue
+		}
+		sensor := Sensor{
+			Name:   strings.TrimSpace(parts[0]),
+			Status: strings.TrimSpace(parts[2]),
+		}
+		reading := strings.TrimSpace(parts[1])
+		if sensor.Name == "" {
+			continue
+		}
+		// 未安装或者已禁用的传感器不输出
+		if sensor.Status == "ns" || reading == "disabled" {
+			continue
+		}
+		if m := valueRegex.FindStringSubmatch(reading); m != nil && !strings.HasPrefix(reading, "0x") {
+			if v, err := strconv.ParseFloat(m[1], 64); err == nil {
+				sensor.Value = &v
+				sensor.Unit = normalizeUnit(m[2])
+			}
+		}
+		sensor.Type = sensorType(sensor.Name, sensor.Unit)
+		sensor.OK = sensor.Status == "ok"
+		sensors = append(sensors, sensor)
+	}
+	return sensors
+}
+
+func normalizeUnit(unit string) string {
+	switch strings.ToLower(strings.TrimSpace(unit)) {
+	case "degrees c":
+		return "celsius"
+	case "degrees f":
+		return "fahrenheit"
+	case "rpm":
+		return "rpm"
+	case "watts":
+		return "watts"
+	case "volts":
+		return "volts"
+	case "amps":
+		return "amps"
+	case "percent":
+		return "percent"
+	}
+	return strings.ToLower(strings.Replace(strings.TrimSpace(unit), " ", "_", -1))
+}
+
+func sensorType(name, unit string) string {
+	lower := strings.ToLower(name)
+	if strings.HasPrefix(lower, "ps") || strings.Contains(lower, "psu") || strings.Contains(lower, "power supply") {
+		return SensorPSU
+	}
+	switch unit {
+	case "celsius", "fahrenheit":
+		return SensorTemperature
+	case "rpm":
+		return SensorFan
+	case "watts":
+		return SensorPower
+	case "volts":
+		return SensorVoltage
+	case "amps":
+		return SensorCurrent
+	}
+	if strings.Contains(lower, "fan") {
+		return SensorFan
+	}
+	if strings.Contains(lower, "temp") {
+		return SensorTemperature
+	}
+	return SensorOther
+}
+
+func init() {
+	metric.Add(TypeMetricIpmi, func() metric.Collector {
+		return &IpmiStats{}
+	})
+}
@@ -0,0 +1,91 @@
+package ipmi
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const sdrOutput = `CPU Temp         | 45 degrees C      | ok
+System Fan 1     | 4200 RPM          | ok
+PS1 Status       | 0x01              | ok
+PS2 Status       | 0x0a              | cr
+Pwr Consumption  | 182 Watts         | ok
+VCore            | 1.02 Volts        | nc
+Fan 5            | disabled          | ns
+Chassis Intru    | 0x00              | ok
+`
+
+func TestParseSdr(t *testing.T) {
+	sensors := ParseSdr([]byte(sdrOutput))
+	assert.Len(t, sensors, 7)
+
+	assert.Equal(t, "CPU Temp", sensors[0].Name)
+	assert.Equal(t, SensorTemperature, sensors[0].Type)
+	assert.Equal(t, "celsius", sensors[0].Unit)
+	assert.Equal(t, float64(45), *sensors[0].Value)
+	assert.True(t, sensors[0].OK)
+
+	assert.Equal(t, SensorFan, sensors[1].Type)
+	assert.Equal(t, SensorPSU, sensors[2].Type)
+	assert.Nil(t, sensors[2].Value)
+	assert.False(t, sensors[3].OK)
+	assert.Equal(t, SensorPower, sensors[4].Type)
+	assert.Equal(t, SensorVoltage, sensors[5].Type)
+	assert.Equal(t, "nc", sensors[5].Status)
+	assert.Equal(t, SensorOther, sensors[6].Type)
+}
+
+func TestRedfish(t *testing.T) {
+	responses := map[string]string{
+		"/redfish/v1/Chassis":           `{"Members":[{"@odata.id":"/redfish/v1/Chassis/1"}]}`,
+		"/redfish/v1/Chassis/1":         `{"Thermal":{"@odata.id":"/redfish/v1/Chassis/1/Thermal"},"Power":{"@odata.id":"/redfish/v1/Chassis/1/Power"}}`,
+		"/redfish/v1/Chassis/1/Thermal": `{"Temperatures":[{"Name":"Inlet Temp","ReadingCelsius":24,"Status":{"State":"Enabled","Health":"OK"}}],"Fans":[{"FanName":"Fan1","Reading":6000,"ReadingUnits":"RPM","Status":{"State":"Enabled","Health":"OK"}},{"Name":"Fan2","Status":{"State":"Absent"}}]}`,
+		"/redfish/v1/Chassis/1/Power":   `{"PowerControl":[{"Name":"System Power","PowerConsumedWatts":350,"Status":{"State":"Enabled","Health":"OK"}}],"PowerSupplies":[{"Name":"PSU1","LastPowerOutputWatts":175,"Status":{"State":"Enabled","Health":"OK"}},{"Name":"PSU2","Status":{"State":"UnavailableOffline","Health":"Critical"}}]}`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != "root" || pass != "calvin" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		resp, ok := responses[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, resp)
+	}))
+	defer server.Close()
+
+	s := &IpmiStats{Servers: `[{"protocol":"redfish","host":"` + server.URL + `","username":"root","password":"calvin"}]`}
+	datas, err := s.Collect()
+	assert.NoError(t, err)
+	assert.Len(t, datas, 5)
+	assert.Equal(t, map[string]interface{}{
+		KeyIpmiServer:   server.URL,
+		KeyIpmiProtocol: ProtocolRedfish,
+		KeyIpmiName:     "Inlet Temp",
+		KeyIpmiType:     SensorTemperature,
+		KeyIpmiValue:    float64(24),
+		KeyIpmiUnit:     "celsius",
+		KeyIpmiStatus:   "ok",
+		KeyIpmiHealthy:  1,
+	}, datas[0])
+	assert.Equal(t, "Fan1", datas[1][KeyIpmiName])
+	assert.Equal(t, float64(350), datas[2][KeyIpmiValue])
+	assert.Equal(t, SensorPSU, datas[4][KeyIpmiType])
+	assert.Equal(t, 0, datas[4][KeyIpmiHealthy])
+	assert.Equal(t, "critical", datas[4][KeyIpmiStatus])
+
+	s = &IpmiStats{Servers: `[{"protocol":"redfish","host":"` + server.URL + `","username":"root","password":"wrong"}]`}
+	_, err = s.Collect()
+	assert.Error(t, err)
+
+	s = &IpmiStats{Servers: `[{"protocol":"snmp"}]`}
+	_, err = s.Collect()
+	assert.Error(t, err)
+}
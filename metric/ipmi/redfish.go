@@ -0,0 +1,174 @@
+package ipmi
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/qiniu/logkit/utils/tlspolicy"
+)
+
+type redfishLink struct {
+	ID string `json:"@odata.id"`
+}
+
+type redfishStatus struct {
+	State  string `json:"State"`
+	Health string `json:"Health"`
+}
+
+type redfishChassis struct {
+	Members []redfishLink `json:"Members"`
+}
+
+type redfishChassisItem struct {
+	Thermal redfishLink `json:"Thermal"`
+	Power   redfishLink `json:"Power"`
+}
+
+type redfishThermal struct {
+	Temperatures []struct {
+		Name           string        `json:"Name"`
+		ReadingCelsius *float64      `json:"ReadingCelsius"`
+		Status         redfishStatus `json:"Status"`
+	} `json:"Temperatures"`
+	Fans []struct {
+		Name         string        `json:"Name"`
+		FanName      string        `json:"FanName"`
+		Reading      *float64      `json:"Reading"`
+		ReadingUnits string        `json:"ReadingUnits"`
+		Status       redfishStatus `json:"Status"`
+	} `json:"Fans"`
+}
+
+type redfishPower struct {
+	PowerControl []struct {
+		Name               string        `json:"Name"`
+		PowerConsumedWatts *float64      `json:"PowerConsumedWatts"`
+		Status             redfishStatus `json:"Status"`
+	} `json:"PowerControl"`
+	PowerSupplies []struct {
+		Name                 string        `json:"Name"`
+		LastPowerOutputWatts *float64      `json:"LastPowerOutputWatts"`
+		Status               redfishStatus `json:"Status"`
+	} `json:"PowerSupplies"`
+}
+
+type redfishClient struct {
+	base   string
+	bmc    BMC
+	client *http.Client
+}
+
+func (c *redfishClient) get(path string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, c.base+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.bmc.Username != "" {
+		req.SetBasicAuth(c.bmc.Username, c.bmc.Password)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("get %v unexpected status %v", path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// redfishSensor 将 redfish 的状态转换为传感器状态，未安装的设备返回 false
+func redfishSensor(name, typ, unit string, value *float64, status redfishStatus) (Sensor, bool) {
+	if status.State == "Absent" {
+		return Sensor{}, false
+	}
+	health := strings.ToLower(status.Health)
+	if health == "" {
+		health = "unknown"
+	}
+	return Sensor{
+		Name:   name,
+		Type:   typ,
+		Value:  value,
+		Unit:   unit,
+		Status: health,
+		OK:     health == "ok",
+	}, true
+}
+
+// collectRedfish 遍历所有 Chassis，读取温度、风扇、功率以及电源状态
+func collectRedfish(bmc BMC, timeout time.Duration) ([]Sensor, error) {
+	base := strings.TrimSuffix(bmc.Host, "/")
+	if !strings.HasPrefix(base, "http://") && !strings.HasPrefix(base, "https://") {
+		base = "https://" + base
+	}
+	c := &redfishClient{
+		base: base,
+		bmc:  bmc,
+		client: &http.Client{
+			Timeout: timeout,
+			Transport: &http.Transport{
+				TLSClientConfig: tlspolicy.Apply(&tls.Config{InsecureSkipVerify: bmc.InsecureSkipVerify}),
+			},
+		},
+	}
+
+	var chassis redfishChassis
+	if err := c.get("/redfish/v1/Chassis", &chassis); err != nil {
+		return nil, err
+	}
+	var sensors []Sensor
+	for _, member := range chassis.Members {
+		var item redfishChassisItem
+		if err := c.get(member.ID, &item); err != nil {
+			return sensors, err
+		}
+		if item.Thermal.ID != "" {
+			var thermal redfishThermal
+			if err := c.get(item.Thermal.ID, &thermal); err != nil {
+				return sensors, err
+			}
+			for _, t := range thermal.Temperatures {
+				if s, ok := redfishSensor(t.Name, SensorTemperature, "celsius", t.ReadingCelsius, t.Status); ok {
+					sensors = append(sensors, s)
+				}
+			}
+			for _, f := range thermal.Fans {
+				name := f.Name
+				if name == "" {
+					name = f.FanName
+				}
+				unit := strings.ToLower(f.ReadingUnits)
+				if unit == "" {
+					unit = "rpm"
+				}
+				if s, ok := redfishSensor(name, SensorFan, unit, f.Reading, f.Status); ok {
+					sensors = append(sensors, s)
+				}
+			}
+		}
+		if item.Power.ID != "" {
+			var power redfishPower
+			if err := c.get(item.Power.ID, &power); err != nil {
+				return sensors, err
+			}
+			for _, p := range power.PowerControl {
+				if s, ok := redfishSensor(p.Name, SensorPower, "watts", p.PowerConsumedWatts, p.Status); ok {
+					sensors = append(sensors, s)
+				}
+			}
+			for _, p := range power.PowerSupplies {
+				if s, ok := redfishSensor(p.Name, SensorPSU, "watts", p.LastPowerOutputWatts, p.Status); ok {
+					sensors = append(sensors, s)
+				}
+			}
+		}
+	}
+	return sensors, nil
+}
@@ -0,0 +1,284 @@
+package kafka
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/qiniu/logkit/metric"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const (
+	TypeMetricKafka   = "kafka"
+	MetricKafkaUsages = "Kafka集群(kafka)"
+
+	// TypeMetricKafka 信息中的字段，kafka_type 为 broker 的数据只有 broker 相关字段，为 topic 的数据只有 topic 相关字段
+	KeyKafkaType              = "kafka_type"
+	KeyKafkaBroker            = "kafka_broker"
+	KeyKafkaBrokerID          = "kafka_broker_id"
+	KeyKafkaConnected         = "kafka_connected"
+	KeyKafkaController        = "kafka_controller"
+	KeyKafkaLeaderPartitions  = "kafka_leader_partitions"
+	KeyKafkaReplicas          = "kafka_replicas"
+	KeyKafkaTopic             = "kafka_topic"
+	KeyKafkaPartitions        = "kafka_partitions"
+	KeyKafkaUnderReplicated   = "kafka_under_replicated_partitions"
+	KeyKafkaOfflinePartitions = "kafka_offline_partitions"
+	KeyKafkaNewestOffset      = "kafka_newest_offset"
+	KeyKafkaMessages          = "kafka_messages"
+
+	// KeyKafkaType 的取值
+	KafkaTypeBroker = "broker"
+	KafkaTypeTopic  = "topic"
+
+	// Config 中的字段
+	ConfigKafkaBrokers          = "kafka_brokers"
+	ConfigKafkaTopics           = "kafka_topics"
+	ConfigKafkaTimeout          = "timeout"
+	ConfigKafkaIncludeInternals = "include_internal_topics"
+
+	defaultTimeout      = 10 * time.Second
+	internalTopicPrefix = "__"
+)
+
+// KeyKafkaUsages TypeMetricKafka 的字段名称
+var KeyKafkaUsages = KeyValueSlice{
+	{KeyKafkaType, "数据类型(broker/topic)", ""},
+	{KeyKafkaBroker, "broker 地址", ""},
+	{KeyKafkaBrokerID, "broker id", ""},
+	{KeyKafkaConnected, "broker 是否可以连接(1可以，0不可以)", ""},
+	{KeyKafkaController, "broker 是否为 controller(1是，0不是)", ""},
+	{KeyKafkaLeaderPartitions, "broker 作为 leader 的分区数", ""},
+	{KeyKafkaReplicas, "broker 上的副本数", ""},
+	{KeyKafkaTopic, "topic 名称", ""},
+	{KeyKafkaPartitions, "topic 分区数", ""},
+	{KeyKafkaUnderReplicated, "topic 副本不同步的分区数", ""},
+	{KeyKafkaOfflinePartitions, "topic 没有 leader 的分区数", ""},
+	{KeyKafkaNewestOffset, "topic 所有分区最新 offset 之和", ""},
+	{KeyKafkaMessages, "topic 所有分区保留的消息数之和", ""},
+}
+
+type KafkaStats struct {
+	Brokers          string `json:"kafka_brokers"`
+	Topics           string `json:"kafka_topics"`
+	Timeout          string `json:"timeout"`
+	IncludeInternals bool   `json:"include_internal_topics"`
+}
+
+func (*KafkaStats) Name() string {
+	return TypeMetricKafka
+}
+
+func (*KafkaStats) Usages() string {
+	return MetricKafkaUsages
+}
+
+func (*KafkaStats) Tags() []string {
+	return []string{KeyKafkaType, KeyKafkaBroker, KeyKafkaTopic}
+}
+
+func (*KafkaStats) Config() map[string]interface{} {
+	configOptions := []Option{
+		{
+			KeyName:      ConfigKafkaBrokers,
+			ChooseOnly:   false,
+			Default:      "localhost:9092",
+			DefaultNoUse: true,
+			Description:  "broker 地址(逗号分隔多个)(" + ConfigKafkaBrokers + ")",
+			Type:         metric.ConfigTypeString,
+		},
+		{
+			KeyName:      ConfigKafkaTopics,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "采集的 topic(逗号分隔多个，为空时采集所有)(" + ConfigKafkaTopics + ")",
+			Type:         metric.ConfigTypeString,
+		},
+		{
+			KeyName:      ConfigKafkaTimeout,
+			ChooseOnly:   false,
+			Default:      "10s",
+			DefaultNoUse: false,
+			Description:  "网络超时时间(" + ConfigKafkaTimeout + ")",
+			Type:         metric.ConfigTypeString,
+		},
+		{
+			KeyName:       ConfigKafkaIncludeInternals,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{"false", "true"},
+			Default:       "false",
+			DefaultNoUse:  false,
+			Description:   "是否采集以__开头的内部 topic(" + ConfigKafkaIncludeInternals + ")",
+			Type:          metric.ConfigTypeBool,
+		},
+	}
+	return map[string]interface{}{
+		metric.OptionString:     configOptions,
+		metric.AttributesString: KeyKafkaUsages,
+	}
+}
+
+func (s *KafkaStats) config() *sarama.Config {
+	timeout, err := time.ParseDuration(s.Timeout)
+	if err != nil || timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	cfg := sarama.NewConfig()
+	cfg.ClientID = "logkit"
+	// 0.10 开始 metadata 中才有 controller
+	cfg.Version = sarama.V0_10_0_0
+	cfg.Net.DialTimeout = timeout
+	cfg.Net.ReadTimeout = timeout
+	cfg.Net.WriteTimeout = timeout
+	cfg.Metadata.Retry.Max = 1
+	return cfg
+}
+
+func (s *KafkaStats) topics(client sarama.Client) ([]string, error) {
+	var topics []string
+	if strings.TrimSpace(s.Topics) != "" {
+		for _, topic := range strings.Split(s.Topics, ",") {
+			if topic = strings.TrimSpace(topic); topic != "" {
+				topics = append(topics, topic)
+			}
+		}
+		return topics, nil
+	}
+	all, err := client.Topics()
+	if err != nil {
+		return nil, err
+	}
+	for _, topic := range all {
+		if !s.IncludeInternals && strings.HasPrefix(topic, internalTopicPrefix) {
+			continue
+		}
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics, nil
+}
+
+// Collect 通过 metadata 和 offset 请求采集 broker 和 topic 的状态，不依赖 JMX，
+// 单个 topic 采集失败时返回其余的数据和最后一个错误
+func (s *KafkaStats) Collect() (datas []map[string]interface{}, err error) {
+	var brokers []string
+	for _, broker := range strings.Split(s.Brokers, ",") {
+		if broker = strings.TrimSpace(broker); broker != "" {
+			brokers = append(brokers, broker)
+		}
+	}
+	if len(brokers) == 0 {
+		return nil, fmt.Errorf("metric %v %v should not be empty", TypeMetricKafka, ConfigKafkaBrokers)
+	}
+	cfg := s.config()
+	client, err := sarama.NewClient(brokers, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("metric %v connect to %v error: %v", TypeMetricKafka, s.Brokers, err)
+	}
+	defer client.Close()
+
+	topics, err := s.topics(client)
+	if err != nil {
+		return nil, fmt.Errorf("metric %v list topics error: %v", TypeMetricKafka, err)
+	}
+	var (
+		leaders  = make(map[int32]int)
+		replicas = make(map[int32]int)
+	)
+	for _, topic := range topics {
+		data, terr := collectTopic(client, topic, leaders, replicas)
+		if terr != nil {
+			err = fmt.Errorf("metric %v collect topic %v error: %v", TypeMetricKafka, topic, terr)
+		}
+		if data != nil {
+			datas = append(datas, data)
+		}
+	}
+
+	var controllerID int32 = -1
+	if controller, cerr := client.Controller(); cerr == nil {
+		controllerID = controller.ID()
+	}
+	for _, broker := range client.Brokers() {
+		data := map[string]interface{}{
+			KeyKafkaType:             KafkaTypeBroker,
+			KeyKafkaBroker:           broker.Addr(),
+			KeyKafkaBrokerID:         broker.ID(),
+			KeyKafkaConnected:        0,
+			KeyKafkaController:       0,
+			KeyKafkaLeaderPartitions: leaders[broker.ID()],
+			KeyKafkaReplicas:         replicas[broker.ID()],
+		}
+		if broker.ID() == controllerID {
+			data[KeyKafkaController] = 1
+		}
+		// Open 为异步连接，Connected 会等待连接结束
+		broker.Open(cfg)
+		if connected, _ := broker.Connected(); connected {
+			data[KeyKafkaConnected] = 1
+		}
+		datas = append(datas, data)
+	}
+	return datas, err
+}
+
+// collectTopic 统计 topic 的分区状态和 offset，同时累加每个 broker 的 leader 分区数和副本数
+func collectTopic(client sarama.Client, topic string, leaders, replicas map[int32]int) (map[string]interface{}, error) {
+	partitions, err := client.Partitions(topic)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		underReplicated, offline int
+		newest, messages         int64
+		lastErr                  error
+	)
+	for _, partition := range partitions {
+		reps, _ := client.Replicas(topic, partition)
+		isr, _ := client.InSyncReplicas(topic, partition)
+		for _, id := range reps {
+			replicas[id]++
+		}
+		if len(isr) < len(reps) {
+			underReplicated++
+		}
+		leader, err := client.Leader(topic, partition)
+		if err != nil {
+			offline++
+			continue
+		}
+		leaders[leader.ID()]++
+		newestOffset, err := client.GetOffset(topic, partition, sarama.OffsetNewest)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		oldestOffset, err := client.GetOffset(topic, partition, sarama.OffsetOldest)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		newest += newestOffset
+		messages += newestOffset - oldestOffset
+	}
+	return map[string]interface{}{
+		KeyKafkaType:              KafkaTypeTopic,
+		KeyKafkaTopic:             topic,
+		KeyKafkaPartitions:        len(partitions),
+		KeyKafkaUnderReplicated:   underReplicated,
+		KeyKafkaOfflinePartitions: offline,
+		KeyKafkaNewestOffset:      newest,
+		KeyKafkaMessages:          messages,
+	}, lastErr
+}
+
+func init() {
+	metric.Add(TypeMetricKafka, func() metric.Collector {
+		return &KafkaStats{}
+	})
+}
@@ -0,0 +1,65 @@
+package kafka
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollect(t *testing.T) {
+	broker1 := sarama.NewMockBroker(t, 1)
+	defer broker1.Close()
+	broker2 := sarama.NewMockBroker(t, 2)
+	defer broker2.Close()
+
+	metadata := &sarama.MetadataResponse{Version: 1, ControllerID: 1}
+	metadata.AddBroker(broker1.Addr(), broker1.BrokerID())
+	metadata.AddBroker(broker2.Addr(), broker2.BrokerID())
+	metadata.AddTopicPartition("app", 0, 1, []int32{1, 2}, []int32{1, 2}, nil, sarama.ErrNoError)
+	metadata.AddTopicPartition("app", 1, 1, []int32{1, 2}, []int32{1}, nil, sarama.ErrNoError)
+	metadata.AddTopicPartition("app", 2, -1, []int32{2}, []int32{}, nil, sarama.ErrLeaderNotAvailable)
+	metadata.AddTopicPartition("__consumer_offsets", 0, 2, []int32{2}, []int32{2}, nil, sarama.ErrNoError)
+	offsets := sarama.NewMockOffsetResponse(t).
+		SetOffset("app", 0, sarama.OffsetNewest, 100).
+		SetOffset("app", 0, sarama.OffsetOldest, 40).
+		SetOffset("app", 1, sarama.OffsetNewest, 50).
+		SetOffset("app", 1, sarama.OffsetOldest, 0)
+	for _, b := range []*sarama.MockBroker{broker1, broker2} {
+		b.SetHandlerByMap(map[string]sarama.MockResponse{
+			"MetadataRequest": sarama.NewMockWrapper(metadata),
+			"OffsetRequest":   offsets,
+		})
+	}
+
+	s := &KafkaStats{Brokers: broker1.Addr(), Timeout: "1s"}
+	datas, err := s.Collect()
+	assert.NoError(t, err)
+	require.Len(t, datas, 3)
+	assert.Equal(t, map[string]interface{}{
+		KeyKafkaType:              KafkaTypeTopic,
+		KeyKafkaTopic:             "app",
+		KeyKafkaPartitions:        3,
+		KeyKafkaUnderReplicated:   2,
+		KeyKafkaOfflinePartitions: 1,
+		KeyKafkaNewestOffset:      int64(150),
+		KeyKafkaMessages:          int64(110),
+	}, datas[0])
+	brokers := map[int32]map[string]interface{}{}
+	for _, data := range datas[1:] {
+		assert.Equal(t, KafkaTypeBroker, data[KeyKafkaType])
+		assert.Equal(t, 1, data[KeyKafkaConnected])
+		brokers[data[KeyKafkaBrokerID].(int32)] = data
+	}
+	require.Len(t, brokers, 2)
+	assert.Equal(t, 1, brokers[1][KeyKafkaController])
+	assert.Equal(t, 2, brokers[1][KeyKafkaLeaderPartitions])
+	assert.Equal(t, 2, brokers[1][KeyKafkaReplicas])
+	assert.Equal(t, 0, brokers[2][KeyKafkaController])
+	assert.Equal(t, 0, brokers[2][KeyKafkaLeaderPartitions])
+	assert.Equal(t, 3, brokers[2][KeyKafkaReplicas])
+
+	_, err = (&KafkaStats{}).Collect()
+	assert.Error(t, err)
+}
@@ -0,0 +1,349 @@
+package redis
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+
+	"github.com/qiniu/logkit/metric"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const (
+	TypeMetricRedis   = "redis"
+	MetricRedisUsages = "Redis(redis)"
+
+	// TypeMetricRedis 信息中的字段，redis_type 为 server 的数据还包含 INFO 中所有数值字段，字段名加上 redis_ 前缀
+	KeyRedisType   = "redis_type"
+	KeyRedisServer = "redis_server"
+
+	// server 数据的字段
+	KeyRedisUp           = "redis_up"
+	KeyRedisPingLatency  = "redis_ping_latency_ms"
+	KeyRedisVersion      = "redis_redis_version"
+	KeyRedisRole         = "redis_role"
+	KeyRedisClients      = "redis_connected_clients"
+	KeyRedisUsedMemory   = "redis_used_memory"
+	KeyRedisOpsPerSec    = "redis_instantaneous_ops_per_sec"
+	KeyRedisHitRatio     = "redis_keyspace_hit_ratio"
+	KeyRedisReplLag      = "redis_master_last_io_seconds_ago"
+	KeyRedisEvictedKeys  = "redis_evicted_keys"
+	KeyRedisRejectedConn = "redis_rejected_connections"
+
+	// keyspace 数据的字段
+	KeyRedisDB      = "redis_db"
+	KeyRedisKeys    = "redis_keys"
+	KeyRedisExpires = "redis_expires"
+	KeyRedisAvgTTL  = "redis_avg_ttl"
+
+	// latency 数据的字段，需要开启 latency-monitor-threshold
+	KeyRedisLatencyEvent  = "redis_latency_event"
+	KeyRedisLatencyTime   = "redis_latency_time"
+	KeyRedisLatencyLatest = "redis_latency_latest_ms"
+	KeyRedisLatencyMax    = "redis_latency_max_ms"
+
+	// KeyRedisType 的取值
+	RedisTypeServer   = "server"
+	RedisTypeKeyspace = "keyspace"
+	RedisTypeLatency  = "latency"
+
+	// Config 中的字段
+	ConfigRedisServers = "redis_servers"
+	ConfigRedisTimeout = "timeout"
+
+	defaultTimeout = 5 * time.Second
+)
+
+// KeyRedisUsages TypeMetricRedis 的字段名称
+var KeyRedisUsages = KeyValueSlice{
+	{KeyRedisType, "数据类型(server/keyspace/latency)", ""},
+	{KeyRedisServer, "服务器地址", ""},
+	{KeyRedisUp, "是否可以连接(1可以，0不可以)", ""},
+	{KeyRedisPingLatency, "PING 耗时(ms)", ""},
+	{KeyRedisVersion, "版本", ""},
+	{KeyRedisRole, "角色(master/slave)", ""},
+	{KeyRedisClients, "客户端连接数", ""},
+	{KeyRedisUsedMemory, "使用的内存(bytes)", ""},
+	{KeyRedisOpsPerSec, "每秒命令数", ""},
+	{KeyRedisHitRatio, "key 命中率", ""},
+	{KeyRedisReplLag, "距离上次与主库通信的秒数(从库)", ""},
+	{KeyRedisEvictedKeys, "被驱逐的 key 数", ""},
+	{KeyRedisRejectedConn, "被拒绝的连接数", ""},
+	{KeyRedisDB, "数据库(db0、db1...)", ""},
+	{KeyRedisKeys, "key 数量", ""},
+	{KeyRedisExpires, "设置了过期时间的 key 数量", ""},
+	{KeyRedisAvgTTL, "平均过期时间(ms)", ""},
+	{KeyRedisLatencyEvent, "延迟事件名称", ""},
+	{KeyRedisLatencyTime, "最近一次延迟事件的时间，unix 秒", ""},
+	{KeyRedisLatencyLatest, "最近一次延迟(ms)", ""},
+	{KeyRedisLatencyMax, "最大延迟(ms)", ""},
+}
+
+// infoStrings INFO 中需要保留的字符串字段
+var infoStrings = map[string]bool{
+	"redis_version":      true,
+	"redis_mode":         true,
+	"role":               true,
+	"master_link_status": true,
+}
+
+// RedisServer 一台 Redis 的连接信息
+type RedisServer struct {
+	Addr     string `json:"addr"`
+	Password string `json:"password"`
+}
+
+type RedisStats struct {
+	Servers string `json:"redis_servers"`
+	Timeout string `json:"timeout"`
+
+	parsed  string
+	clients map[string]*redis.Client
+}
+
+func (*RedisStats) Name() string {
+	return TypeMetricRedis
+}
+
+func (*RedisStats) Usages() string {
+	return MetricRedisUsages
+}
+
+func (*RedisStats) Tags() []string {
+	return []string{KeyRedisType, KeyRedisServer, KeyRedisDB, KeyRedisLatencyEvent}
+}
+
+func (*RedisStats) Config() map[string]interface{} {
+	configOptions := []Option{
+		{
+			KeyName:      ConfigRedisServers,
+			ChooseOnly:   false,
+			Default:      `[{"addr":"localhost:6379","password":""}]`,
+			DefaultNoUse: true,
+			Description:  "服务器列表(" + ConfigRedisServers + ")",
+			Type:         metric.ConfigTypeString,
+		},
+		{
+			KeyName:      ConfigRedisTimeout,
+			ChooseOnly:   false,
+			Default:      "5s",
+			DefaultNoUse: false,
+			Description:  "网络超时时间(" + ConfigRedisTimeout + ")",
+			Type:         metric.ConfigTypeString,
+		},
+	}
+	return map[string]interface{}{
+		metric.OptionString:     configOptions,
+		metric.AttributesString: KeyRedisUsages,
+	}
+}
+
+func (s *RedisStats) init() error {
+	if s.parsed == s.Servers && s.clients != nil {
+		return nil
+	}
+	var servers []RedisServer
+	if err := json.Unmarshal([]byte(s.Servers), &servers); err != nil {
+		return fmt.Errorf("metric %v unmarshal %v error %v", TypeMetricRedis, ConfigRedisServers, err)
+	}
+	timeout, err := time.ParseDuration(s.Timeout)
+	if err != nil || timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	clients := make(map[string]*redis.Client)
+	for _, server := range servers {
+		if server.Addr == "" {
+			closeClients(clients)
+			return fmt.Errorf("metric %v addr should not be empty", TypeMetricRedis)
+		}
+		clients[server.Addr] = redis.NewClient(&redis.Options{
+			Addr:         server.Addr,
+			Password:     server.Password,
+			DialTimeout:  timeout,
+			ReadTimeout:  timeout,
+			WriteTimeout: timeout,
+			PoolSize:     1,
+		})
+	}
+	closeClients(s.clients)
+	s.clients = clients
+	s.parsed = s.Servers
+	return nil
+}
+
+func closeClients(clients map[string]*redis.Client) {
+	for _, client := range clients {
+		client.Close()
+	}
+}
+
+// Collect 并发采集所有服务器，连接失败的服务器只输出 redis_up 为0的 server 数据，同时返回最后一个错误
+func (s *RedisStats) Collect() (datas []map[string]interface{}, err error) {
+	if err = s.init(); err != nil {
+		return nil, err
+	}
+	var (
+		wg   sync.WaitGroup
+		lock sync.Mutex
+	)
+	for addr, client := range s.clients {
+		wg.Add(1)
+		go func(addr string, client *redis.Client) {
+			defer wg.Done()
+			sdatas, cerr := collectServer(addr, client)
+			lock.Lock()
+			defer lock.Unlock()
+			if cerr != nil {
+				err = fmt.Errorf("collect redis %v error: %v", addr, cerr)
+			}
+			datas = append(datas, sdatas...)
+		}(addr, client)
+	}
+	wg.Wait()
+	return datas, err
+}
+
+func collectServer(addr string, client *redis.Client) ([]map[string]interface{}, error) {
+	status := map[string]interface{}{
+		KeyRedisType:   RedisTypeServer,
+		KeyRedisServer: addr,
+		KeyRedisUp:     0,
+	}
+	datas := []map[string]interface{}{status}
+	start := time.Now()
+	if err := client.Ping().Err(); err != nil {
+		return datas, err
+	}
+	status[KeyRedisUp] = 1
+	status[KeyRedisPingLatency] = float64(time.Since(start)) / float64(time.Millisecond)
+
+	info, err := client.Info("all").Result()
+	if err != nil {
+		return datas, err
+	}
+	stats, keyspaces := ParseInfo(info)
+	for k, v := range stats {
+		status[k] = v
+	}
+	for _, keyspace := range keyspaces {
+		keyspace[KeyRedisType] = RedisTypeKeyspace
+		keyspace[KeyRedisServer] = addr
+		datas = append(datas, keyspace)
+	}
+
+	// 低版本或者禁用了 LATENCY 命令时忽略
+	cmd := redis.NewSliceCmd("latency", "latest")
+	client.Process(cmd)
+	if events, err := cmd.Result(); err == nil {
+		for _, event := range ParseLatency(events) {
+			event[KeyRedisType] = RedisTypeLatency
+			event[KeyRedisServer] = addr
+			datas = append(datas, event)
+		}
+	}
+	return datas, nil
+}
+
+// ParseInfo 解析 INFO 的输出，返回 server 数据的字段以及每个数据库的 keyspace 数据
+func ParseInfo(info string) (map[string]interface{}, []map[string]interface{}) {
+	var (
+		stats     = make(map[string]interface{})
+		keyspaces []map[string]interface{}
+		section   string
+	)
+	scanner := bufio.NewScanner(strings.NewReader(info))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			section = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(line, "#")))
+			continue
+		}
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key, value := kv[0], kv[1]
+		switch section {
+		case "keyspace":
+			keyspaces = append(keyspaces, parseKeyspace(key, value))
+			continue
+		case "commandstats", "latencystats", "errorstats":
+			continue
+		}
+		if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+			stats["redis_"+key] = i
+		} else if f, err := strconv.ParseFloat(value, 64); err == nil {
+			stats["redis_"+key] = f
+		} else if infoStrings[key] {
+			stats["redis_"+key] = value
+		}
+	}
+	hits, _ := stats["redis_keyspace_hits"].(int64)
+	misses, _ := stats["redis_keyspace_misses"].(int64)
+	if hits+misses > 0 {
+		stats[KeyRedisHitRatio] = float64(hits) / float64(hits+misses)
+	}
+	return stats, keyspaces
+}
+
+// parseKeyspace 解析 keyspace 中的一行，格式为 db0:keys=1,expires=0,avg_ttl=0
+func parseKeyspace(db, value string) map[string]interface{} {
+	data := map[string]interface{}{KeyRedisDB: db}
+	for _, field := range strings.Split(value, ",") {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		i, err := strconv.ParseInt(kv[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch kv[0] {
+		case "keys":
+			data[KeyRedisKeys] = i
+		case "expires":
+			data[KeyRedisExpires] = i
+		case "avg_ttl":
+			data[KeyRedisAvgTTL] = i
+		}
+	}
+	return data
+}
+
+// ParseLatency 解析 LATENCY LATEST 的返回，每个事件为 [名称, 时间, 最近一次延迟, 最大延迟]
+func ParseLatency(events []interface{}) []map[string]interface{} {
+	var datas []map[string]interface{}
+	for _, event := range events {
+		fields, ok := event.([]interface{})
+		if !ok || len(fields) < 4 {
+			continue
+		}
+		name, ok := fields[0].(string)
+		if !ok {
+			continue
+		}
+		data := map[string]interface{}{KeyRedisLatencyEvent: name}
+		for i, key := range []string{KeyRedisLatencyTime, KeyRedisLatencyLatest, KeyRedisLatencyMax} {
+			if v, ok := fields[i+1].(int64); ok {
+				data[key] = v
+			}
+		}
+		datas = append(datas, data)
+	}
+	return datas
+}
+
+func init() {
+	metric.Add(TypeMetricRedis, func() metric.Collector {
+		return &RedisStats{}
+	})
+}
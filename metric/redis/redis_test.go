@@ -0,0 +1,139 @@
+package redis
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const infoOutput = "# Server\r\nredis_version:5.0.7\r\nredis_mode:standalone\r\nos:Linux 5.4.0 x86_64\r\nuptime_in_seconds:3600\r\n\r\n" +
+	"# Clients\r\nconnected_clients:12\r\n\r\n" +
+	"# Memory\r\nused_memory:1048576\r\nmem_fragmentation_ratio:1.25\r\n\r\n" +
+	"# Stats\r\nkeyspace_hits:90\r\nkeyspace_misses:10\r\n\r\n" +
+	"# Replication\r\nrole:master\r\nconnected_slaves:0\r\n\r\n" +
+	"# Commandstats\r\ncmdstat_get:calls=100,usec=200,usec_per_call=2.00\r\n\r\n" +
+	"# Keyspace\r\ndb0:keys=100,expires=10,avg_ttl=3000\r\ndb2:keys=5,expires=0,avg_ttl=0\r\n"
+
+func TestParseInfo(t *testing.T) {
+	stats, keyspaces := ParseInfo(infoOutput)
+	assert.Equal(t, "5.0.7", stats[KeyRedisVersion])
+	assert.Equal(t, "master", stats[KeyRedisRole])
+	assert.Equal(t, int64(12), stats[KeyRedisClients])
+	assert.Equal(t, int64(1048576), stats[KeyRedisUsedMemory])
+	assert.Equal(t, 1.25, stats["redis_mem_fragmentation_ratio"])
+	assert.Equal(t, 0.9, stats[KeyRedisHitRatio])
+	assert.NotContains(t, stats, "redis_os")
+	assert.NotContains(t, stats, "redis_cmdstat_get")
+	assert.Equal(t, []map[string]interface{}{
+		{KeyRedisDB: "db0", KeyRedisKeys: int64(100), KeyRedisExpires: int64(10), KeyRedisAvgTTL: int64(3000)},
+		{KeyRedisDB: "db2", KeyRedisKeys: int64(5), KeyRedisExpires: int64(0), KeyRedisAvgTTL: int64(0)},
+	}, keyspaces)
+}
+
+func TestParseLatency(t *testing.T) {
+	events := []interface{}{
+		[]interface{}{"command", int64(1600000000), int64(250), int64(1000)},
+		[]interface{}{"bad"},
+	}
+	assert.Equal(t, []map[string]interface{}{{
+		KeyRedisLatencyEvent:  "command",
+		KeyRedisLatencyTime:   int64(1600000000),
+		KeyRedisLatencyLatest: int64(250),
+		KeyRedisLatencyMax:    int64(1000),
+	}}, ParseLatency(events))
+}
+
+// fakeRedis 按照 RESP 协议应答 PING、INFO 和 LATENCY LATEST
+func fakeRedis(t *testing.T) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					args, err := readCommand(r)
+					if err != nil {
+						return
+					}
+					switch strings.ToLower(args[0]) {
+					case "ping":
+						conn.Write([]byte("+PONG\r\n"))
+					case "info":
+						fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(infoOutput), infoOutput)
+					case "latency":
+						conn.Write([]byte("*1\r\n*4\r\n$7\r\ncommand\r\n:1600000000\r\n:250\r\n:1000\r\n"))
+					default:
+						conn.Write([]byte("-ERR unknown command\r\n"))
+					}
+				}
+			}(conn)
+		}
+	}()
+	return ln
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		if _, err = r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		if args[i], err = r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		args[i] = strings.TrimSpace(args[i])
+	}
+	return args, nil
+}
+
+func TestCollect(t *testing.T) {
+	ln := fakeRedis(t)
+	defer ln.Close()
+	addr := ln.Addr().String()
+	down, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	downAddr := down.Addr().String()
+	down.Close()
+
+	s := &RedisStats{Servers: `[{"addr":"` + addr + `"},{"addr":"` + downAddr + `"}]`, Timeout: "1s"}
+	datas, err := s.Collect()
+	assert.Error(t, err)
+	types := make(map[string]int)
+	for _, data := range datas {
+		if data[KeyRedisServer] == downAddr {
+			assert.Equal(t, map[string]interface{}{KeyRedisType: RedisTypeServer, KeyRedisServer: downAddr, KeyRedisUp: 0}, data)
+			continue
+		}
+		types[data[KeyRedisType].(string)]++
+		switch data[KeyRedisType] {
+		case RedisTypeServer:
+			assert.Equal(t, 1, data[KeyRedisUp])
+			assert.Equal(t, "5.0.7", data[KeyRedisVersion])
+		case RedisTypeLatency:
+			assert.Equal(t, int64(250), data[KeyRedisLatencyLatest])
+		}
+	}
+	assert.Equal(t, map[string]int{RedisTypeServer: 1, RedisTypeKeyspace: 2, RedisTypeLatency: 1}, types)
+	assert.NoError(t, s.init())
+	closeClients(s.clients)
+}
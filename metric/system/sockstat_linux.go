@@ -0,0 +1,403 @@
+// +build linux
+
+package system
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/qiniu/logkit/metric"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const (
+	TypeMetricSockstat   = "sockstat"
+	MetricSockstatUsages = "连接状态及进程归属(sockstat)"
+
+	// TypeMetricSockstat 信息中的字段
+	KeySockstatKind           = "sockstat_kind"
+	KeySockstatTcpTotal       = "sockstat_tcp_total"
+	KeySockstatUdpTotal       = "sockstat_udp_total"
+	KeySockstatConntrackCount = "sockstat_conntrack_count"
+	KeySockstatConntrackMax   = "sockstat_conntrack_max"
+	KeySockstatConntrackUsage = "sockstat_conntrack_usage"
+	KeySockstatPort           = "sockstat_port"
+	KeySockstatBacklog        = "sockstat_listen_backlog"
+	KeySockstatBacklogMax     = "sockstat_listen_backlog_max"
+	KeySockstatPortGroup      = "sockstat_port_group"
+	KeySockstatPid            = "sockstat_pid"
+	KeySockstatProcess        = "sockstat_process"
+	KeySockstatProcessSockets = "sockstat_sockets"
+	KeySockstatTcpStatePrefix = "sockstat_tcp_"
+	KeySockstatTcpEstablished = "sockstat_tcp_established"
+	KeySockstatTcpTimeWait    = "sockstat_tcp_time_wait"
+	KeySockstatTcpCloseWait   = "sockstat_tcp_close_wait"
+	KeySockstatTcpListen      = "sockstat_tcp_listen"
+	KeySockstatTcpSynRecv     = "sockstat_tcp_syn_recv"
+
+	SockstatKindSummary   = "summary"
+	SockstatKindListen    = "listen"
+	SockstatKindPortGroup = "port_group"
+	SockstatKindProcess   = "process"
+
+	// Config 中的字段
+	ConfigSockstatPortGroups = "port_groups"
+	ConfigSockstatProcess    = "process_attribution"
+
+	defaultSockstatProcessTopN = 20
+)
+
+// tcpStates /proc/net/tcp 中 st 字段对应的状态
+var tcpStates = map[string]string{
+	"01": "established",
+	"02": "syn_sent",
+	"03": "syn_recv",
+	"04": "fin_wait1",
+	"05": "fin_wait2",
+	"06": "time_wait",
+	"07": "close",
+	"08": "close_wait",
+	"09": "last_ack",
+	"0A": "listen",
+	"0B": "closing",
+}
+
+// KeySockstatUsages TypeMetricSockstat 的字段名称
+var KeySockstatUsages = KeyValueSlice{
+	{KeySockstatKind, "数据类别(summary/listen/port_group/process)", ""},
+	{KeySockstatTcpTotal, "TCP连接总数", ""},
+	{KeySockstatUdpTotal, "UDP套接字总数", ""},
+	{KeySockstatTcpEstablished, "ESTABLISHED状态的连接数", ""},
+	{KeySockstatTcpTimeWait, "TIME_WAIT状态的连接数", ""},
+	{KeySockstatTcpCloseWait, "CLOSE_WAIT状态的连接数", ""},
+	{KeySockstatTcpSynRecv, "SYN_RECV状态的连接数", ""},
+	{KeySockstatTcpListen, "LISTEN状态的连接数", ""},
+	{KeySockstatConntrackCount, "conntrack表当前条目数", ""},
+	{KeySockstatConntrackMax, "conntrack表最大条目数", ""},
+	{KeySockstatConntrackUsage, "conntrack表使用率(%)", ""},
+	{KeySockstatPort, "监听端口", ""},
+	{KeySockstatBacklog, "监听端口当前全连接队列长度", ""},
+	{KeySockstatBacklogMax, "监听端口全连接队列上限", ""},
+	{KeySockstatPortGroup, "端口分组名称", ""},
+	{KeySockstatPid, "进程ID", ""},
+	{KeySockstatProcess, "进程名称", ""},
+	{KeySockstatProcessSockets, "进程持有的TCP连接数", ""},
+}
+
+type tcpSocket struct {
+	localPort int
+	state     string
+	txQueue   int64
+	rxQueue   int64
+	inode     string
+}
+
+type portGroup struct {
+	name   string
+	ranges [][2]int
+}
+
+func (g portGroup) contains(port int) bool {
+	for _, r := range g.ranges {
+		if port >= r[0] && port <= r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+type SockStats struct {
+	PortGroups         string `json:"port_groups"`
+	ProcessAttribution bool   `json:"process_attribution"`
+
+	procRoot string
+}
+
+func (*SockStats) Name() string {
+	return TypeMetricSockstat
+}
+
+func (*SockStats) Usages() string {
+	return MetricSockstatUsages
+}
+
+func (*SockStats) Tags() []string {
+	return []string{KeySockstatKind, KeySockstatPort, KeySockstatPortGroup, KeySockstatPid, KeySockstatProcess}
+}
+
+func (*SockStats) Config() map[string]interface{} {
+	configOptions := []Option{
+		{
+			KeyName:      ConfigSockstatPortGroups,
+			ChooseOnly:   false,
+			Default:      "",
+			Placeholder:  "web=80,443;db=3306,6379;app=8000-8100",
+			DefaultNoUse: false,
+			Description:  "端口分组(" + ConfigSockstatPortGroups + ")",
+			Type:         metric.ConfigTypeString,
+		},
+		{
+			KeyName:       ConfigSockstatProcess,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{false, true},
+			Default:       false,
+			DefaultNoUse:  false,
+			Description:   "按进程统计连接数(" + ConfigSockstatProcess + ")",
+			Type:          metric.ConfigTypeBool,
+		},
+	}
+	return map[string]interface{}{
+		metric.OptionString:     configOptions,
+		metric.AttributesString: KeySockstatUsages,
+	}
+}
+
+// parsePortGroups 解析形如 "web=80,443;app=8000-8100" 的端口分组配置
+func parsePortGroups(s string) ([]portGroup, error) {
+	var groups []portGroup
+	for _, item := range strings.Split(s, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		idx := strings.Index(item, "=")
+		if idx <= 0 {
+			return nil, fmt.Errorf("port group %q is invalid, format should be name=port1,port2", item)
+		}
+		group := portGroup{name: strings.TrimSpace(item[:idx])}
+		for _, p := range strings.Split(item[idx+1:], ",") {
+			p = strings.TrimSpace(p)
+			if p == "" {
+				continue
+			}
+			bounds := strings.SplitN(p, "-", 2)
+			start, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+			if err != nil {
+				return nil, fmt.Errorf("port group %q port %q is invalid", group.name, p)
+			}
+			end := start
+			if len(bounds) == 2 {
+				if end, err = strconv.Atoi(strings.TrimSpace(bounds[1])); err != nil || end < start {
+					return nil, fmt.Errorf("port group %q port range %q is invalid", group.name, p)
+				}
+			}
+			group.ranges = append(group.ranges, [2]int{start, end})
+		}
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
+
+// readTCPSockets 读取 /proc/net/tcp 和 /proc/net/tcp6
+func (s *SockStats) readTCPSockets() ([]tcpSocket, error) {
+	var sockets []tcpSocket
+	for _, name := range []string{"tcp", "tcp6"} {
+		f, err := os.Open(filepath.Join(s.procRoot, "net", name))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			// 跳过表头
+			if len(fields) < 10 || fields[0] == "sl" {
+				continue
+			}
+			state, ok := tcpStates[strings.ToUpper(fields[3])]
+			if !ok {
+				continue
+			}
+			sock := tcpSocket{state: state, inode: fields[9]}
+			if idx := strings.LastIndex(fields[1], ":"); idx >= 0 {
+				port, _ := strconv.ParseInt(fields[1][idx+1:], 16, 32)
+				sock.localPort = int(port)
+			}
+			if queues := strings.SplitN(fields[4], ":", 2); len(queues) == 2 {
+				sock.txQueue, _ = strconv.ParseInt(queues[0], 16, 64)
+				sock.rxQueue, _ = strconv.ParseInt(queues[1], 16, 64)
+			}
+			sockets = append(sockets, sock)
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sockets, nil
+}
+
+func (s *SockStats) countLines(names ...string) int {
+	var count int
+	for _, name := range names {
+		content, err := ioutil.ReadFile(filepath.Join(s.procRoot, "net", name))
+		if err != nil {
+			continue
+		}
+		// 第一行为表头
+		if lines := strings.Count(strings.TrimSpace(string(content)), "\n"); lines > 0 {
+			count += lines
+		}
+	}
+	return count
+}
+
+func (s *SockStats) readInt(path string) (int64, bool) {
+	content, err := ioutil.ReadFile(filepath.Join(s.procRoot, path))
+	if err != nil {
+		return 0, false
+	}
+	v, err := strconv.ParseInt(strings.TrimSpace(string(content)), 10, 64)
+	return v, err == nil
+}
+
+func newStateCounts() map[string]interface{} {
+	data := make(map[string]interface{}, len(tcpStates)+1)
+	for _, state := range tcpStates {
+		data[KeySockstatTcpStatePrefix+state] = 0
+	}
+	return data
+}
+
+func (s *SockStats) Collect() (datas []map[string]interface{}, err error) {
+	groups, err := parsePortGroups(s.PortGroups)
+	if err != nil {
+		return nil, err
+	}
+	sockets, err := s.readTCPSockets()
+	if err != nil {
+		return nil, fmt.Errorf("error getting tcp sockets: %v", err)
+	}
+
+	summary := newStateCounts()
+	summary[KeySockstatKind] = SockstatKindSummary
+	groupDatas := make([]map[string]interface{}, len(groups))
+	for i, g := range groups {
+		groupDatas[i] = newStateCounts()
+		groupDatas[i][KeySockstatKind] = SockstatKindPortGroup
+		groupDatas[i][KeySockstatPortGroup] = g.name
+	}
+	listens := make(map[int]map[string]interface{})
+	for _, sock := range sockets {
+		key := KeySockstatTcpStatePrefix + sock.state
+		summary[key] = summary[key].(int) + 1
+		for i, g := range groups {
+			if g.contains(sock.localPort) {
+				groupDatas[i][key] = groupDatas[i][key].(int) + 1
+			}
+		}
+		// LISTEN 状态的 socket，rx_queue 为当前全连接队列长度，tx_queue 为队列上限
+		if sock.state == "listen" {
+			data, ok := listens[sock.localPort]
+			if !ok {
+				data = map[string]interface{}{
+					KeySockstatKind:       SockstatKindListen,
+					KeySockstatPort:       sock.localPort,
+					KeySockstatBacklog:    int64(0),
+					KeySockstatBacklogMax: int64(0),
+				}
+				listens[sock.localPort] = data
+			}
+			data[KeySockstatBacklog] = data[KeySockstatBacklog].(int64) + sock.rxQueue
+			data[KeySockstatBacklogMax] = data[KeySockstatBacklogMax].(int64) + sock.txQueue
+		}
+	}
+	summary[KeySockstatTcpTotal] = len(sockets)
+	summary[KeySockstatUdpTotal] = s.countLines("udp", "udp6")
+	if count, ok := s.readInt("sys/net/netfilter/nf_conntrack_count"); ok {
+		summary[KeySockstatConntrackCount] = count
+		if max, ok := s.readInt("sys/net/netfilter/nf_conntrack_max"); ok && max > 0 {
+			summary[KeySockstatConntrackMax] = max
+			summary[KeySockstatConntrackUsage] = float64(count) * 100 / float64(max)
+		}
+	}
+
+	datas = append(datas, summary)
+	ports := make([]int, 0, len(listens))
+	for port := range listens {
+		ports = append(ports, port)
+	}
+	sort.Ints(ports)
+	for _, port := range ports {
+		datas = append(datas, listens[port])
+	}
+	datas = append(datas, groupDatas...)
+	if s.ProcessAttribution {
+		datas = append(datas, s.processDatas(sockets)...)
+	}
+	return datas, nil
+}
+
+// processDatas 通过 /proc/[pid]/fd 找到 socket 所属进程，输出连接数最多的进程
+func (s *SockStats) processDatas(sockets []tcpSocket) []map[string]interface{} {
+	inodes := make(map[string]tcpSocket, len(sockets))
+	for _, sock := range sockets {
+		inodes[sock.inode] = sock
+	}
+	procs, err := ioutil.ReadDir(s.procRoot)
+	if err != nil {
+		return nil
+	}
+	var datas []map[string]interface{}
+	for _, proc := range procs {
+		pid, err := strconv.Atoi(proc.Name())
+		if err != nil || !proc.IsDir() {
+			continue
+		}
+		fdDir := filepath.Join(s.procRoot, proc.Name(), "fd")
+		fds, err := ioutil.ReadDir(fdDir)
+		if err != nil {
+			// 没有权限读取其他用户的进程
+			continue
+		}
+		var data map[string]interface{}
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			if err != nil || !strings.HasPrefix(link, "socket:[") {
+				continue
+			}
+			sock, ok := inodes[strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]")]
+			if !ok {
+				continue
+			}
+			if data == nil {
+				data = newStateCounts()
+				data[KeySockstatKind] = SockstatKindProcess
+				data[KeySockstatPid] = pid
+				data[KeySockstatProcessSockets] = 0
+				comm, _ := ioutil.ReadFile(filepath.Join(s.procRoot, proc.Name(), "comm"))
+				data[KeySockstatProcess] = strings.TrimSpace(string(comm))
+			}
+			key := KeySockstatTcpStatePrefix + sock.state
+			data[key] = data[key].(int) + 1
+			data[KeySockstatProcessSockets] = data[KeySockstatProcessSockets].(int) + 1
+		}
+		if data != nil {
+			datas = append(datas, data)
+		}
+	}
+	sort.SliceStable(datas, func(i, j int) bool {
+		return datas[i][KeySockstatProcessSockets].(int) > datas[j][KeySockstatProcessSockets].(int)
+	})
+	if len(datas) > defaultSockstatProcessTopN {
+		datas = datas[:defaultSockstatProcessTopN]
+	}
+	return datas
+}
+
+func init() {
+	metric.Add(TypeMetricSockstat, func() metric.Collector {
+		return &SockStats{
+			procRoot: "/proc",
+		}
+	})
+}
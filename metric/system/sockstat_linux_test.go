@@ -0,0 +1,87 @@
+// +build linux
+
+package system
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testProcNetTcp = `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000:0050 00000000:0000 0A 00000080:00000003 00:00000000 00000000     0        0 1001 1 0000000000000000 100 0 0 10 0
+   1: 0100007F:0050 0100007F:C350 01 00000000:00000000 00:00000000 00000000     0        0 1002 1 0000000000000000 20 4 30 10 -1
+   2: 0100007F:0050 0100007F:C351 06 00000000:00000000 03:00000DA4 00000000     0        0 0 3 0000000000000000
+   3: 0100007F:0CEA 0100007F:C352 08 00000000:00000000 00:00000000 00000000     0        0 1003 1 0000000000000000 20 4 30 10 -1
+`
+
+const testProcNetTcp6 = `  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000000000000000000000000000:0050 00000000000000000000000000000000:0000 0A 00000080:00000001 00:00000000 00000000     0        0 1004 1 0000000000000000 100 0 0 10 0
+`
+
+func TestSockStats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestSockStats")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	write := func(path, content string) {
+		assert.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, path)), 0755))
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, path), []byte(content), 0644))
+	}
+	write("net/tcp", testProcNetTcp)
+	write("net/tcp6", testProcNetTcp6)
+	write("net/udp", "  sl  local_address\n   0: 00000000:0035\n   1: 00000000:0044\n")
+	write("sys/net/netfilter/nf_conntrack_count", "750\n")
+	write("sys/net/netfilter/nf_conntrack_max", "1000\n")
+	write("123/comm", "nginx\n")
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "123", "fd"), 0755))
+	assert.NoError(t, os.Symlink("socket:[1001]", filepath.Join(dir, "123", "fd", "3")))
+	assert.NoError(t, os.Symlink("socket:[1002]", filepath.Join(dir, "123", "fd", "4")))
+	assert.NoError(t, os.Symlink("/dev/null", filepath.Join(dir, "123", "fd", "5")))
+
+	s := &SockStats{
+		PortGroups:         "web=80,443;app=3000-3500",
+		ProcessAttribution: true,
+		procRoot:           dir,
+	}
+	datas, err := s.Collect()
+	assert.NoError(t, err)
+	assert.Len(t, datas, 5)
+
+	summary := datas[0]
+	assert.Equal(t, SockstatKindSummary, summary[KeySockstatKind])
+	assert.Equal(t, 5, summary[KeySockstatTcpTotal])
+	assert.Equal(t, 2, summary[KeySockstatTcpListen])
+	assert.Equal(t, 1, summary[KeySockstatTcpEstablished])
+	assert.Equal(t, 1, summary[KeySockstatTcpTimeWait])
+	assert.Equal(t, 1, summary[KeySockstatTcpCloseWait])
+	assert.Equal(t, 2, summary[KeySockstatUdpTotal])
+	assert.Equal(t, int64(750), summary[KeySockstatConntrackCount])
+	assert.Equal(t, float64(75), summary[KeySockstatConntrackUsage])
+
+	// ipv4 和 ipv6 监听同一端口时合并
+	assert.Equal(t, map[string]interface{}{
+		KeySockstatKind:       SockstatKindListen,
+		KeySockstatPort:       80,
+		KeySockstatBacklog:    int64(4),
+		KeySockstatBacklogMax: int64(256),
+	}, datas[1])
+
+	assert.Equal(t, "web", datas[2][KeySockstatPortGroup])
+	assert.Equal(t, 2, datas[2][KeySockstatTcpListen])
+	assert.Equal(t, 1, datas[2][KeySockstatTcpEstablished])
+	assert.Equal(t, "app", datas[3][KeySockstatPortGroup])
+	assert.Equal(t, 1, datas[3][KeySockstatTcpCloseWait])
+
+	assert.Equal(t, SockstatKindProcess, datas[4][KeySockstatKind])
+	assert.Equal(t, 123, datas[4][KeySockstatPid])
+	assert.Equal(t, "nginx", datas[4][KeySockstatProcess])
+	assert.Equal(t, 2, datas[4][KeySockstatProcessSockets])
+
+	s.PortGroups = "web"
+	_, err = s.Collect()
+	assert.Error(t, err)
+}
@@ -0,0 +1,180 @@
+package webserver
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/qiniu/logkit/metric"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const (
+	TypeMetricApache   = "apache"
+	MetricApacheUsages = "Apache状态(apache)"
+
+	// TypeMetricApache 信息中的字段，endpoints 中配置的 tags 也会加入到数据中
+	KeyApacheURL           = "apache_url"
+	KeyApacheUp            = "apache_up"
+	KeyApacheTotalAccesses = "apache_total_accesses"
+	KeyApacheTotalKBytes   = "apache_total_kbytes"
+	KeyApacheUptime        = "apache_uptime"
+	KeyApacheCPULoad       = "apache_cpu_load"
+	KeyApacheBusyWorkers   = "apache_busy_workers"
+	KeyApacheIdleWorkers   = "apache_idle_workers"
+	KeyApacheConnsTotal    = "apache_conns_total"
+	KeyApacheRequestRate   = "apache_requests_per_sec"
+
+	// Scoreboard 中每种状态的 worker 数
+	KeyApacheWaiting     = "apache_scoreboard_waiting"
+	KeyApacheStarting    = "apache_scoreboard_starting"
+	KeyApacheReading     = "apache_scoreboard_reading"
+	KeyApacheSending     = "apache_scoreboard_sending"
+	KeyApacheKeepalive   = "apache_scoreboard_keepalive"
+	KeyApacheDNSLookup   = "apache_scoreboard_dnslookup"
+	KeyApacheClosing     = "apache_scoreboard_closing"
+	KeyApacheLogging     = "apache_scoreboard_logging"
+	KeyApacheFinishing   = "apache_scoreboard_finishing"
+	KeyApacheIdleCleanup = "apache_scoreboard_idle_cleanup"
+	KeyApacheOpen        = "apache_scoreboard_open"
+)
+
+// KeyApacheUsages TypeMetricApache 的字段名称
+var KeyApacheUsages = KeyValueSlice{
+	{KeyApacheURL, "状态页地址", ""},
+	{KeyApacheUp, "状态页是否可以访问(1可以，0不可以)", ""},
+	{KeyApacheTotalAccesses, "请求总数", ""},
+	{KeyApacheTotalKBytes, "响应总字节数(KB)", ""},
+	{KeyApacheUptime, "运行时间(s)", ""},
+	{KeyApacheCPULoad, "CPU 负载", ""},
+	{KeyApacheBusyWorkers, "繁忙的 worker 数", ""},
+	{KeyApacheIdleWorkers, "空闲的 worker 数", ""},
+	{KeyApacheConnsTotal, "连接数(event MPM)", ""},
+	{KeyApacheRequestRate, "每秒请求数(两次采集之间，第一次为启动以来的平均值)", ""},
+	{KeyApacheWaiting, "等待连接的 worker 数", ""},
+	{KeyApacheStarting, "正在启动的 worker 数", ""},
+	{KeyApacheReading, "正在读取请求的 worker 数", ""},
+	{KeyApacheSending, "正在返回响应的 worker 数", ""},
+	{KeyApacheKeepalive, "keepalive 的 worker 数", ""},
+	{KeyApacheDNSLookup, "正在 DNS 查询的 worker 数", ""},
+	{KeyApacheClosing, "正在关闭连接的 worker 数", ""},
+	{KeyApacheLogging, "正在写日志的 worker 数", ""},
+	{KeyApacheFinishing, "正在优雅退出的 worker 数", ""},
+	{KeyApacheIdleCleanup, "正在清理的 worker 数", ""},
+	{KeyApacheOpen, "空闲的 worker 槽位数", ""},
+}
+
+// apacheFields mod_status ?auto 输出中需要采集的字段
+var apacheFields = map[string]string{
+	"Total Accesses": KeyApacheTotalAccesses,
+	"Total kBytes":   KeyApacheTotalKBytes,
+	"Uptime":         KeyApacheUptime,
+	"CPULoad":        KeyApacheCPULoad,
+	"BusyWorkers":    KeyApacheBusyWorkers,
+	"IdleWorkers":    KeyApacheIdleWorkers,
+	"ConnsTotal":     KeyApacheConnsTotal,
+	"ReqPerSec":      KeyApacheRequestRate,
+}
+
+// scoreboardKeys Scoreboard 中每个字符对应的状态
+var scoreboardKeys = map[rune]string{
+	'_': KeyApacheWaiting,
+	'S': KeyApacheStarting,
+	'R': KeyApacheReading,
+	'W': KeyApacheSending,
+	'K': KeyApacheKeepalive,
+	'D': KeyApacheDNSLookup,
+	'C': KeyApacheClosing,
+	'L': KeyApacheLogging,
+	'G': KeyApacheFinishing,
+	'I': KeyApacheIdleCleanup,
+	'.': KeyApacheOpen,
+}
+
+// ApacheStats 采集 mod_status 的状态页，地址需要带上 ?auto 参数
+type ApacheStats struct {
+	statusPage
+}
+
+func (*ApacheStats) Name() string {
+	return TypeMetricApache
+}
+
+func (*ApacheStats) Usages() string {
+	return MetricApacheUsages
+}
+
+func (*ApacheStats) Tags() []string {
+	return []string{KeyApacheURL}
+}
+
+func (*ApacheStats) Config() map[string]interface{} {
+	return map[string]interface{}{
+		metric.OptionString:     endpointOptions("mod_status", "http://localhost/server-status?auto"),
+		metric.AttributesString: KeyApacheUsages,
+	}
+}
+
+func (s *ApacheStats) Collect() ([]map[string]interface{}, error) {
+	return s.collect(TypeMetricApache, KeyApacheURL, KeyApacheUp, func(endpoint Endpoint, body []byte) (map[string]interface{}, error) {
+		data, err := ParseModStatus(body)
+		if err != nil {
+			return nil, err
+		}
+		if accesses, ok := data[KeyApacheTotalAccesses].(int64); ok {
+			if rate, ok := s.rate(endpoint.URL, accesses, time.Now()); ok {
+				data[KeyApacheRequestRate] = rate
+			}
+		}
+		return data, nil
+	})
+}
+
+// ParseModStatus 解析 mod_status ?auto 的输出，每行格式为 "key: value"
+func ParseModStatus(body []byte) (map[string]interface{}, error) {
+	data := make(map[string]interface{})
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		name, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if name == "Scoreboard" {
+			for _, key := range scoreboardKeys {
+				data[key] = int64(0)
+			}
+			for _, c := range value {
+				if key, ok := scoreboardKeys[c]; ok {
+					data[key] = data[key].(int64) + 1
+				}
+			}
+			continue
+		}
+		key, ok := apacheFields[name]
+		if !ok {
+			continue
+		}
+		if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+			data[key] = i
+		} else if f, err := strconv.ParseFloat(value, 64); err == nil {
+			data[key] = f
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("unexpected mod_status output, make sure the url ends with ?auto: %q", string(body))
+	}
+	return data, nil
+}
+
+func init() {
+	metric.Add(TypeMetricApache, func() metric.Collector {
+		return &ApacheStats{}
+	})
+}
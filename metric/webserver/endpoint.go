@@ -0,0 +1,173 @@
+package webserver
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/qiniu/logkit/metric"
+	. "github.com/qiniu/logkit/utils/models"
+	"github.com/qiniu/logkit/utils/tlspolicy"
+)
+
+const (
+	// Config 中的字段
+	ConfigEndpoints = "endpoints"
+	ConfigTimeout   = "timeout"
+
+	defaultTimeout = 5 * time.Second
+)
+
+// Endpoint 一个状态页的地址，Tags 会作为字段加入该地址采集到的数据中
+type Endpoint struct {
+	URL                string            `json:"url"`
+	Username           string            `json:"username"`
+	Password           string            `json:"password"`
+	InsecureSkipVerify bool              `json:"insecure_skip_verify"`
+	Tags               map[string]string `json:"tags"`
+}
+
+type counter struct {
+	value int64
+	time  time.Time
+}
+
+// statusPage 为 nginx 和 apache 共用的配置和请求逻辑
+type statusPage struct {
+	Endpoints string `json:"endpoints"`
+	Timeout   string `json:"timeout"`
+
+	parsed    string
+	endpoints []Endpoint
+	// last 记录每个地址上一次采集的累计请求数，用于计算每秒请求数
+	last map[string]counter
+	lock sync.Mutex
+}
+
+func endpointOptions(name, example string) []Option {
+	return []Option{
+		{
+			KeyName:      ConfigEndpoints,
+			ChooseOnly:   false,
+			Default:      `[{"url":"` + example + `","tags":{"idc":"a"}}]`,
+			DefaultNoUse: true,
+			Description:  name + " 状态页列表(" + ConfigEndpoints + ")",
+			Type:         metric.ConfigTypeString,
+		},
+		{
+			KeyName:      ConfigTimeout,
+			ChooseOnly:   false,
+			Default:      "5s",
+			DefaultNoUse: false,
+			Description:  "单个状态页请求超时时间(" + ConfigTimeout + ")",
+			Type:         metric.ConfigTypeString,
+		},
+	}
+}
+
+func (s *statusPage) init(tp string) error {
+	if s.parsed == s.Endpoints && s.endpoints != nil {
+		return nil
+	}
+	var endpoints []Endpoint
+	if err := json.Unmarshal([]byte(s.Endpoints), &endpoints); err != nil {
+		return fmt.Errorf("metric %v unmarshal %v error %v", tp, ConfigEndpoints, err)
+	}
+	for _, endpoint := range endpoints {
+		if endpoint.URL == "" {
+			return fmt.Errorf("metric %v endpoint url should not be empty", tp)
+		}
+	}
+	s.endpoints = endpoints
+	s.parsed = s.Endpoints
+	s.last = make(map[string]counter)
+	return nil
+}
+
+func (s *statusPage) timeout() time.Duration {
+	timeout, err := time.ParseDuration(s.Timeout)
+	if err != nil || timeout <= 0 {
+		return defaultTimeout
+	}
+	return timeout
+}
+
+// collect 并发请求所有状态页并调用 parse 解析，请求失败的地址只输出 up 为0的数据，同时返回最后一个错误
+func (s *statusPage) collect(tp, keyURL, keyUp string, parse func(Endpoint, []byte) (map[string]interface{}, error)) (datas []map[string]interface{}, err error) {
+	if err = s.init(tp); err != nil {
+		return nil, err
+	}
+	var (
+		wg   sync.WaitGroup
+		lock sync.Mutex
+	)
+	for _, endpoint := range s.endpoints {
+		wg.Add(1)
+		go func(endpoint Endpoint) {
+			defer wg.Done()
+			data := map[string]interface{}{keyUp: 0}
+			body, cerr := s.fetch(endpoint)
+			if cerr == nil {
+				var stats map[string]interface{}
+				if stats, cerr = parse(endpoint, body); cerr == nil {
+					data = stats
+					data[keyUp] = 1
+				}
+			}
+			for k, v := range endpoint.Tags {
+				data[k] = v
+			}
+			data[keyURL] = endpoint.URL
+			lock.Lock()
+			defer lock.Unlock()
+			if cerr != nil {
+				err = fmt.Errorf("collect %v %v error: %v", tp, endpoint.URL, cerr)
+			}
+			datas = append(datas, data)
+		}(endpoint)
+	}
+	wg.Wait()
+	return datas, err
+}
+
+func (s *statusPage) fetch(endpoint Endpoint) ([]byte, error) {
+	client := &http.Client{
+		Timeout: s.timeout(),
+		Transport: &http.Transport{
+			TLSClientConfig: tlspolicy.Apply(&tls.Config{InsecureSkipVerify: endpoint.InsecureSkipVerify}),
+		},
+	}
+	req, err := http.NewRequest(http.MethodGet, endpoint.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if endpoint.Username != "" {
+		req.SetBasicAuth(endpoint.Username, endpoint.Password)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %v", resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// rate 根据上一次采集的累计值计算每秒的增量，第一次采集或者计数被重置时返回 false
+func (s *statusPage) rate(url string, value int64, now time.Time) (float64, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	last, ok := s.last[url]
+	s.last[url] = counter{value: value, time: now}
+	seconds := now.Sub(last.time).Seconds()
+	if !ok || value < last.value || seconds <= 0 {
+		return 0, false
+	}
+	return float64(value-last.value) / seconds, true
+}
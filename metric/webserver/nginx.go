@@ -0,0 +1,136 @@
+package webserver
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/qiniu/logkit/metric"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const (
+	TypeMetricNginx   = "nginx"
+	MetricNginxUsages = "Nginx状态(nginx)"
+
+	// TypeMetricNginx 信息中的字段，endpoints 中配置的 tags 也会加入到数据中
+	KeyNginxURL         = "nginx_url"
+	KeyNginxUp          = "nginx_up"
+	KeyNginxActive      = "nginx_active"
+	KeyNginxAccepts     = "nginx_accepts"
+	KeyNginxHandled     = "nginx_handled"
+	KeyNginxRequests    = "nginx_requests"
+	KeyNginxReading     = "nginx_reading"
+	KeyNginxWriting     = "nginx_writing"
+	KeyNginxWaiting     = "nginx_waiting"
+	KeyNginxRequestRate = "nginx_requests_per_sec"
+)
+
+// KeyNginxUsages TypeMetricNginx 的字段名称
+var KeyNginxUsages = KeyValueSlice{
+	{KeyNginxURL, "状态页地址", ""},
+	{KeyNginxUp, "状态页是否可以访问(1可以，0不可以)", ""},
+	{KeyNginxActive, "活跃连接数", ""},
+	{KeyNginxAccepts, "接受的连接总数", ""},
+	{KeyNginxHandled, "处理的连接总数", ""},
+	{KeyNginxRequests, "请求总数", ""},
+	{KeyNginxReading, "正在读取请求头的连接数", ""},
+	{KeyNginxWriting, "正在返回响应的连接数", ""},
+	{KeyNginxWaiting, "空闲的 keepalive 连接数", ""},
+	{KeyNginxRequestRate, "每秒请求数(两次采集之间)", ""},
+}
+
+// NginxStats 采集 ngx_http_stub_status_module 的状态页
+type NginxStats struct {
+	statusPage
+}
+
+func (*NginxStats) Name() string {
+	return TypeMetricNginx
+}
+
+func (*NginxStats) Usages() string {
+	return MetricNginxUsages
+}
+
+func (*NginxStats) Tags() []string {
+	return []string{KeyNginxURL}
+}
+
+func (*NginxStats) Config() map[string]interface{} {
+	return map[string]interface{}{
+		metric.OptionString:     endpointOptions("stub_status", "http://localhost/nginx_status"),
+		metric.AttributesString: KeyNginxUsages,
+	}
+}
+
+func (s *NginxStats) Collect() ([]map[string]interface{}, error) {
+	return s.collect(TypeMetricNginx, KeyNginxURL, KeyNginxUp, func(endpoint Endpoint, body []byte) (map[string]interface{}, error) {
+		data, err := ParseStubStatus(body)
+		if err != nil {
+			return nil, err
+		}
+		if rate, ok := s.rate(endpoint.URL, data[KeyNginxRequests].(int64), time.Now()); ok {
+			data[KeyNginxRequestRate] = rate
+		}
+		return data, nil
+	})
+}
+
+// ParseStubStatus 解析 stub_status 的输出，依次为活跃连接数、累计的连接数和请求数以及各状态的连接数
+func ParseStubStatus(body []byte) (map[string]interface{}, error) {
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) < 4 || !strings.HasPrefix(lines[0], "Active connections:") {
+		return nil, fmt.Errorf("unexpected stub_status output: %q", string(body))
+	}
+	data := make(map[string]interface{})
+	active, err := strconv.ParseInt(strings.TrimSpace(strings.TrimPrefix(lines[0], "Active connections:")), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	data[KeyNginxActive] = active
+
+	counters := strings.Fields(lines[2])
+	if len(counters) != 3 {
+		return nil, fmt.Errorf("unexpected stub_status counters: %q", lines[2])
+	}
+	for i, key := range []string{KeyNginxAccepts, KeyNginxHandled, KeyNginxRequests} {
+		if data[key], err = strconv.ParseInt(counters[i], 10, 64); err != nil {
+			return nil, err
+		}
+	}
+
+	fields := strings.Fields(lines[3])
+	for i := 0; i+1 < len(fields); i += 2 {
+		var key string
+		switch fields[i] {
+		case "Reading:":
+			key = KeyNginxReading
+		case "Writing:":
+			key = KeyNginxWriting
+		case "Waiting:":
+			key = KeyNginxWaiting
+		default:
+			continue
+		}
+		if data[key], err = strconv.ParseInt(fields[i+1], 10, 64); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+func init() {
+	metric.Add(TypeMetricNginx, func() metric.Collector {
+		return &NginxStats{}
+	})
+}
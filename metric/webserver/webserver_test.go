@@ -0,0 +1,109 @@
+package webserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/json-iterator/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const stubStatus = `Active connections: 291
+server accepts handled requests
+ 16630948 16630948 31070465
+Reading: 6 Writing: 179 Waiting: 106
+`
+
+const modStatus = `localhost
+ServerVersion: Apache/2.4.41 (Unix)
+ServerMPM: event
+Total Accesses: 1000
+Total kBytes: 5678
+CPULoad: .0123
+Uptime: 3600
+ReqPerSec: .277778
+BusyWorkers: 2
+IdleWorkers: 3
+ConnsTotal: 4
+Scoreboard: _W_K_R..
+`
+
+func TestParseStubStatus(t *testing.T) {
+	data, err := ParseStubStatus([]byte(stubStatus))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		KeyNginxActive:   int64(291),
+		KeyNginxAccepts:  int64(16630948),
+		KeyNginxHandled:  int64(16630948),
+		KeyNginxRequests: int64(31070465),
+		KeyNginxReading:  int64(6),
+		KeyNginxWriting:  int64(179),
+		KeyNginxWaiting:  int64(106),
+	}, data)
+
+	_, err = ParseStubStatus([]byte("<html>404</html>"))
+	assert.Error(t, err)
+}
+
+func TestParseModStatus(t *testing.T) {
+	data, err := ParseModStatus([]byte(modStatus))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1000), data[KeyApacheTotalAccesses])
+	assert.Equal(t, 0.0123, data[KeyApacheCPULoad])
+	assert.Equal(t, 0.277778, data[KeyApacheRequestRate])
+	assert.Equal(t, int64(3), data[KeyApacheWaiting])
+	assert.Equal(t, int64(1), data[KeyApacheSending])
+	assert.Equal(t, int64(1), data[KeyApacheKeepalive])
+	assert.Equal(t, int64(1), data[KeyApacheReading])
+	assert.Equal(t, int64(2), data[KeyApacheOpen])
+	assert.Equal(t, int64(0), data[KeyApacheClosing])
+
+	_, err = ParseModStatus([]byte("<html>server status</html>"))
+	assert.Error(t, err)
+}
+
+func TestCollect(t *testing.T) {
+	var requests int64 = 100
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, _ := r.BasicAuth(); user != "admin" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("Active connections: 1\nserver accepts handled requests\n 10 10 " +
+			strconv.FormatInt(atomic.AddInt64(&requests, 100), 10) + "\nReading: 0 Writing: 1 Waiting: 0\n"))
+	}))
+	defer server.Close()
+
+	s := &NginxStats{}
+	require.NoError(t, jsoniter.Unmarshal([]byte(`{"timeout":"1s","endpoints":"[{\"url\":\"`+server.URL+`\",\"username\":\"admin\",\"password\":\"secret\",\"tags\":{\"idc\":\"a\"}},{\"url\":\"`+server.URL+`/denied\",\"tags\":{\"idc\":\"b\"}}]"}`), s))
+	datas, err := s.Collect()
+	assert.Error(t, err)
+	require.Len(t, datas, 2)
+	for _, data := range datas {
+		if data["idc"] == "b" {
+			assert.Equal(t, map[string]interface{}{KeyNginxURL: server.URL + "/denied", KeyNginxUp: 0, "idc": "b"}, data)
+			continue
+		}
+		assert.Equal(t, server.URL, data[KeyNginxURL])
+		assert.Equal(t, 1, data[KeyNginxUp])
+		assert.Equal(t, int64(200), data[KeyNginxRequests])
+		assert.NotContains(t, data, KeyNginxRequestRate)
+	}
+
+	// 第二次采集时根据两次的请求数计算每秒请求数
+	time.Sleep(10 * time.Millisecond)
+	s.Endpoints = `[{"url":"` + server.URL + `","username":"admin","password":"secret"}]`
+	datas, err = s.Collect()
+	assert.NoError(t, err)
+	require.Len(t, datas, 1)
+	assert.NotContains(t, datas[0], KeyNginxRequestRate)
+	time.Sleep(10 * time.Millisecond)
+	datas, err = s.Collect()
+	assert.NoError(t, err)
+	assert.True(t, datas[0][KeyNginxRequestRate].(float64) > 0)
+}
@@ -0,0 +1,211 @@
+package zookeeper
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qiniu/logkit/metric"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const (
+	TypeMetricZookeeper   = "zookeeper"
+	MetricZookeeperUsages = "ZooKeeper(zookeeper)"
+
+	// TypeMetricZookeeper 信息中的字段，其余字段为 mntr 命令返回的原始字段
+	KeyZkServer              = "zk_server"
+	KeyZkHealthy             = "zk_healthy"
+	KeyZkServerState         = "zk_server_state"
+	KeyZkVersion             = "zk_version"
+	KeyZkAvgLatency          = "zk_avg_latency"
+	KeyZkMaxLatency          = "zk_max_latency"
+	KeyZkOutstandingRequests = "zk_outstanding_requests"
+	KeyZkAliveConnections    = "zk_num_alive_connections"
+	KeyZkZnodeCount          = "zk_znode_count"
+	KeyZkWatchCount          = "zk_watch_count"
+	KeyZkEphemeralsCount     = "zk_ephemerals_count"
+	KeyZkDataSize            = "zk_approximate_data_size"
+	KeyZkOpenFds             = "zk_open_file_descriptor_count"
+	KeyZkFollowers           = "zk_followers"
+	KeyZkSyncedFollowers     = "zk_synced_followers"
+	KeyZkPendingSyncs        = "zk_pending_syncs"
+
+	// Config 中的字段
+	ConfigZkServers = "zk_servers"
+	ConfigZkTimeout = "timeout"
+
+	defaultTimeout = 5 * time.Second
+)
+
+// KeyZookeeperUsages TypeMetricZookeeper 的字段名称
+var KeyZookeeperUsages = KeyValueSlice{
+	{KeyZkServer, "ZooKeeper 地址", ""},
+	{KeyZkHealthy, "是否正常(ruok 返回 imok 为1，否则为0)", ""},
+	{KeyZkServerState, "节点角色(leader/follower/standalone)", ""},
+	{KeyZkVersion, "版本", ""},
+	{KeyZkAvgLatency, "平均延迟(ms)", ""},
+	{KeyZkMaxLatency, "最大延迟(ms)", ""},
+	{KeyZkOutstandingRequests, "排队中的请求数", ""},
+	{KeyZkAliveConnections, "连接数", ""},
+	{KeyZkZnodeCount, "znode 数量", ""},
+	{KeyZkWatchCount, "watch 数量", ""},
+	{KeyZkEphemeralsCount, "临时节点数量", ""},
+	{KeyZkDataSize, "数据大小(bytes)", ""},
+	{KeyZkOpenFds, "打开的文件描述符数", ""},
+	{KeyZkFollowers, "follower 数量(仅 leader)", ""},
+	{KeyZkSyncedFollowers, "已同步的 follower 数量(仅 leader)", ""},
+	{KeyZkPendingSyncs, "等待同步的数量(仅 leader)", ""},
+}
+
+type ZookeeperStats struct {
+	Servers string `json:"zk_servers"`
+	Timeout string `json:"timeout"`
+}
+
+func (*ZookeeperStats) Name() string {
+	return TypeMetricZookeeper
+}
+
+func (*ZookeeperStats) Usages() string {
+	return MetricZookeeperUsages
+}
+
+func (*ZookeeperStats) Tags() []string {
+	return []string{KeyZkServer}
+}
+
+func (*ZookeeperStats) Config() map[string]interface{} {
+	configOptions := []Option{
+		{
+			KeyName:      ConfigZkServers,
+			ChooseOnly:   false,
+			Default:      "localhost:2181",
+			DefaultNoUse: true,
+			Description:  "ZooKeeper 地址(逗号分隔多个)(" + ConfigZkServers + ")",
+			Type:         metric.ConfigTypeString,
+		},
+		{
+			KeyName:      ConfigZkTimeout,
+			ChooseOnly:   false,
+			Default:      "5s",
+			DefaultNoUse: false,
+			Description:  "单个节点采集超时时间(" + ConfigZkTimeout + ")",
+			Type:         metric.ConfigTypeString,
+		},
+	}
+	return map[string]interface{}{
+		metric.OptionString:     configOptions,
+		metric.AttributesString: KeyZookeeperUsages,
+	}
+}
+
+func (s *ZookeeperStats) timeout() time.Duration {
+	timeout, err := time.ParseDuration(s.Timeout)
+	if err != nil || timeout <= 0 {
+		return defaultTimeout
+	}
+	return timeout
+}
+
+// Collect 并发采集所有节点，连接失败的节点也会输出一条 zk_healthy 为0的数据，同时返回最后一个错误
+func (s *ZookeeperStats) Collect() (datas []map[string]interface{}, err error) {
+	var (
+		wg   sync.WaitGroup
+		lock sync.Mutex
+	)
+	for _, server := range strings.Split(s.Servers, ",") {
+		server = strings.TrimSpace(server)
+		if server == "" {
+			continue
+		}
+		wg.Add(1)
+		go func(server string) {
+			defer wg.Done()
+			data, cerr := s.collectServer(server)
+			lock.Lock()
+			defer lock.Unlock()
+			if cerr != nil {
+				err = fmt.Errorf("collect zookeeper %v error: %v", server, cerr)
+			}
+			datas = append(datas, data)
+		}(server)
+	}
+	wg.Wait()
+	return datas, err
+}
+
+func (s *ZookeeperStats) collectServer(server string) (map[string]interface{}, error) {
+	data := map[string]interface{}{
+		KeyZkServer:  server,
+		KeyZkHealthy: 0,
+	}
+	out, err := FourLetterWord(server, "ruok", s.timeout())
+	if err != nil {
+		return data, err
+	}
+	if strings.TrimSpace(string(out)) == "imok" {
+		data[KeyZkHealthy] = 1
+	}
+	out, err = FourLetterWord(server, "mntr", s.timeout())
+	if err != nil {
+		return data, err
+	}
+	stats, err := ParseMntr(out)
+	for k, v := range stats {
+		data[k] = v
+	}
+	return data, err
+}
+
+// FourLetterWord 向 ZooKeeper 发送四字命令并读取全部返回，服务端返回后会主动关闭连接
+func FourLetterWord(server, cmd string, timeout time.Duration) ([]byte, error) {
+	conn, err := net.DialTimeout("tcp", server, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	if _, err = conn.Write([]byte(cmd)); err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(conn)
+}
+
+// ParseMntr 解析 mntr 的输出，每行格式为 "key\tvalue"，数值转换为整数或者浮点数
+func ParseMntr(out []byte) (map[string]interface{}, error) {
+	stats := make(map[string]interface{})
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		parts := strings.SplitN(line, "\t", 2)
+		if len(parts) != 2 {
+			// 没有加入 4lw.commands.whitelist 时返回的是一句提示
+			return stats, fmt.Errorf("unexpected mntr output: %v", line)
+		}
+		key, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+			stats[key] = i
+		} else if f, err := strconv.ParseFloat(value, 64); err == nil {
+			stats[key] = f
+		} else {
+			stats[key] = value
+		}
+	}
+	return stats, scanner.Err()
+}
+
+func init() {
+	metric.Add(TypeMetricZookeeper, func() metric.Collector {
+		return &ZookeeperStats{}
+	})
+}
@@ -0,0 +1,76 @@
+package zookeeper
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const mntrOutput = "zk_version\t3.4.14-4c25d480e66aadd371de8bd2fd8da255ac140bcf, built on 03/06/2019 16:18 GMT\n" +
+	"zk_avg_latency\t0\n" +
+	"zk_max_latency\t12\n" +
+	"zk_num_alive_connections\t3\n" +
+	"zk_server_state\tleader\n" +
+	"zk_znode_count\t42\n" +
+	"zk_approximate_data_size\t1024\n" +
+	"zk_followers\t2\n" +
+	"zk_synced_followers\t2\n" +
+	"zk_avg_request_latency\t0.5\n"
+
+func fakeZookeeper(t *testing.T, responses map[string]string) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			cmd := make([]byte, 4)
+			if _, err := conn.Read(cmd); err == nil {
+				conn.Write([]byte(responses[string(cmd)]))
+			}
+			conn.Close()
+		}
+	}()
+	return ln
+}
+
+func TestParseMntr(t *testing.T) {
+	stats, err := ParseMntr([]byte(mntrOutput))
+	assert.NoError(t, err)
+	assert.Equal(t, "leader", stats[KeyZkServerState])
+	assert.Equal(t, int64(12), stats[KeyZkMaxLatency])
+	assert.Equal(t, int64(42), stats[KeyZkZnodeCount])
+	assert.Equal(t, 0.5, stats["zk_avg_request_latency"])
+
+	_, err = ParseMntr([]byte("mntr is not executed because it is not in the whitelist.\n"))
+	assert.Error(t, err)
+}
+
+func TestCollect(t *testing.T) {
+	zk := fakeZookeeper(t, map[string]string{"ruok": "imok", "mntr": mntrOutput})
+	defer zk.Close()
+	healthy := zk.Addr().String()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	down := ln.Addr().String()
+	ln.Close()
+
+	s := &ZookeeperStats{Servers: healthy + ", " + down, Timeout: "1s"}
+	datas, err := s.Collect()
+	assert.Error(t, err)
+	require.Len(t, datas, 2)
+	for _, data := range datas {
+		if data[KeyZkServer] == down {
+			assert.Equal(t, map[string]interface{}{KeyZkServer: down, KeyZkHealthy: 0}, data)
+			continue
+		}
+		assert.Equal(t, healthy, data[KeyZkServer])
+		assert.Equal(t, 1, data[KeyZkHealthy])
+		assert.Equal(t, "leader", data[KeyZkServerState])
+		assert.Equal(t, int64(3), data[KeyZkAliveConnections])
+	}
+}
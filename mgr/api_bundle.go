@@ -0,0 +1,49 @@
+package mgr
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo"
+
+	"github.com/qiniu/logkit/utils/bundle"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+// get /logkit/bundles 获取所有数据包的状态
+func (rs *RestService) GetBundles() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if rs.mgr.bundles == nil {
+			return RespSuccess(c, []bundle.Status{})
+		}
+		return RespSuccess(c, rs.mgr.bundles.Status())
+	}
+}
+
+// post /logkit/bundles/<name>/update 立即检查并更新数据包
+func (rs *RestService) PostBundleUpdate() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		name := c.Param("name")
+		if rs.mgr.bundles == nil {
+			return RespError(c, http.StatusNotFound, ErrBundleUpdate, fmt.Sprintf("data bundle %q not found", name))
+		}
+		if err := rs.mgr.bundles.Update(name); err != nil {
+			return RespError(c, http.StatusBadRequest, ErrBundleUpdate, err.Error())
+		}
+		return RespSuccess(c, nil)
+	}
+}
+
+// post /logkit/bundles/<name>/rollback 将数据包回滚到上一个版本
+func (rs *RestService) PostBundleRollback() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		name := c.Param("name")
+		if rs.mgr.bundles == nil {
+			return RespError(c, http.StatusNotFound, ErrBundleRollback, fmt.Sprintf("data bundle %q not found", name))
+		}
+		if err := rs.mgr.bundles.Rollback(name); err != nil {
+			return RespError(c, http.StatusBadRequest, ErrBundleRollback, err.Error())
+		}
+		return RespSuccess(c, nil)
+	}
+}
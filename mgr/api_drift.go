@@ -0,0 +1,12 @@
+package mgr
+
+import (
+	"github.com/labstack/echo"
+)
+
+// get /logkit/drift 获取正在运行的 runner 与配置文件之间的偏差
+func (rs *RestService) GetConfigDrifts() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return RespSuccess(c, rs.mgr.ConfigDrifts())
+	}
+}
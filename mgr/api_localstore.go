@@ -0,0 +1,71 @@
+package mgr
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+
+	senderConf "github.com/qiniu/logkit/sender/config"
+	"github.com/qiniu/logkit/sender/localstore"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+// parseLocalStoreQuery 解析查询参数，时间为 RFC3339 格式，filter 的格式为 key:value
+func parseLocalStoreQuery(c echo.Context) (q localstore.Query, err error) {
+	if start := c.QueryParam("start"); start != "" {
+		if q.Start, err = time.Parse(time.RFC3339, start); err != nil {
+			return q, fmt.Errorf("invalid start %q: %v", start, err)
+		}
+	}
+	if end := c.QueryParam("end"); end != "" {
+		if q.End, err = time.Parse(time.RFC3339, end); err != nil {
+			return q, fmt.Errorf("invalid end %q: %v", end, err)
+		}
+	}
+	if !q.Start.IsZero() && !q.End.IsZero() && q.End.Before(q.Start) {
+		return q, fmt.Errorf("end %v is before start %v", q.End, q.Start)
+	}
+	for _, filter := range c.QueryParams()["filter"] {
+		idx := strings.Index(filter, ":")
+		if idx <= 0 {
+			return q, fmt.Errorf("invalid filter %q, should be key:value", filter)
+		}
+		if q.Filters == nil {
+			q.Filters = make(map[string]string)
+		}
+		q.Filters[filter[:idx]] = filter[idx+1:]
+	}
+	q.Keyword = c.QueryParam("keyword")
+	if limit := c.QueryParam("limit"); limit != "" {
+		if q.Limit, err = strconv.Atoi(limit); err != nil || q.Limit <= 0 {
+			return q, fmt.Errorf("invalid limit %q", limit)
+		}
+	}
+	return q, nil
+}
+
+// get /logkit/localstore/<name>?start=<RFC3339>&end=<RFC3339>&filter=<key:value>&keyword=<keyword>&limit=<limit>
+func (rs *RestService) GetLocalStore() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		name := c.Param("name")
+		if name == "" {
+			return RespError(c, http.StatusBadRequest, ErrRunnerLocalStore, "runner name is empty")
+		}
+		q, err := parseLocalStoreQuery(c)
+		if err != nil {
+			return RespError(c, http.StatusBadRequest, ErrRunnerLocalStore, err.Error())
+		}
+		records, err := rs.mgr.QueryLocalStore(name, q)
+		if err != nil {
+			if IsNotSupport(err) {
+				err = fmt.Errorf("runner %s has no %s sender", name, senderConf.TypeLocalStore)
+			}
+			return RespError(c, http.StatusBadRequest, ErrRunnerLocalStore, err.Error())
+		}
+		return RespSuccess(c, records)
+	}
+}
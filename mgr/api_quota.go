@@ -0,0 +1,12 @@
+package mgr
+
+import (
+	"github.com/labstack/echo"
+)
+
+// get /logkit/quotas 获取所有配额的用量
+func (rs *RestService) GetQuotas() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return RespSuccess(c, rs.mgr.QuotaStatus())
+	}
+}
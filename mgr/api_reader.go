@@ -1,7 +1,9 @@
 package mgr
 
 import (
+	"errors"
 	"net/http"
+	"regexp"
 	"sort"
 
 	"github.com/qiniu/logkit/conf"
@@ -66,3 +68,68 @@ func (rs *RestService) PostReaderCheck() echo.HandlerFunc {
 		return RespSuccess(c, nil)
 	}
 }
+
+// HeadPatternRequest 推断或应用多行日志行首正则的请求，head_pattern 为空时根据 samples 推断
+type HeadPatternRequest struct {
+	HeadPattern string   `json:"head_pattern"`
+	Samples     []string `json:"samples"`
+}
+
+// POST /logkit/reader/headpattern 根据样例日志推断多行日志的行首正则
+func (rs *RestService) PostReaderHeadPattern() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var req HeadPatternRequest
+		if err := c.Bind(&req); err != nil {
+			return RespError(c, http.StatusBadRequest, ErrReadHeadPattern, err.Error())
+		}
+		suggestion, err := reader.InferHeadPattern(req.Samples)
+		if err != nil {
+			return RespError(c, http.StatusBadRequest, ErrReadHeadPattern, err.Error())
+		}
+		return RespSuccess(c, suggestion)
+	}
+}
+
+// POST /logkit/configs/<name>/headpattern 将行首正则应用到 runner 的 reader 配置并重启 runner，
+// 没有指定 head_pattern 时使用根据 samples 推断的结果
+func (rs *RestService) PostConfigHeadPattern() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		name := c.Param("name")
+		if name == "" {
+			return RespError(c, http.StatusBadRequest, ErrRunnerUpdate, "config name is empty")
+		}
+		var req HeadPatternRequest
+		if err := c.Bind(&req); err != nil {
+			return RespError(c, http.StatusBadRequest, ErrReadHeadPattern, err.Error())
+		}
+		suggestion, err := headPatternOf(req)
+		if err != nil {
+			return RespError(c, http.StatusBadRequest, ErrReadHeadPattern, err.Error())
+		}
+		_, runnerConf, err := rs.mgr.getDeepCopyConfig(name)
+		if err != nil {
+			return RespError(c, http.StatusBadRequest, ErrConfigName, err.Error())
+		}
+		if runnerConf.ReaderConfig == nil {
+			runnerConf.ReaderConfig = conf.MapConf{}
+		}
+		runnerConf.ReaderConfig[KeyHeadPattern] = suggestion.HeadPattern
+		if err = rs.mgr.UpdateRunner(name, runnerConf); err != nil {
+			return RespError(c, http.StatusBadRequest, ErrRunnerUpdate, err.Error())
+		}
+		return RespSuccess(c, suggestion)
+	}
+}
+
+func headPatternOf(req HeadPatternRequest) (reader.HeadPatternSuggestion, error) {
+	if req.HeadPattern == "" {
+		if len(req.Samples) == 0 {
+			return reader.HeadPatternSuggestion{}, errors.New("head_pattern and samples can not both be empty")
+		}
+		return reader.InferHeadPattern(req.Samples)
+	}
+	if _, err := regexp.Compile(req.HeadPattern); err != nil {
+		return reader.HeadPatternSuggestion{}, err
+	}
+	return reader.HeadPatternSuggestion{HeadPattern: req.HeadPattern}, nil
+}
@@ -3,8 +3,11 @@ package mgr
 import (
 	"net/http"
 	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/json-iterator/go"
+	"github.com/qiniu/logkit/reader"
 	"github.com/qiniu/logkit/reader/config"
 	"github.com/stretchr/testify/assert"
 )
@@ -93,4 +96,71 @@ func readerAPITest(p *testParam) {
 		t.Fatalf("respBody %v unmarshal failed, error is %v", respBody, err)
 	}
 	assert.Equal(t, config.ModeToolTips, got5.Data)
+
+	var got6 respHeadPattern
+	url = "http://127.0.0.1" + rs.address + "/logkit/reader/headpattern"
+	samples := `{"samples":["2018-01-02 15:04:05 ERROR boom\njava.lang.RuntimeException\n\tat a.b(c.java:1)","2018-01-02 15:04:06 INFO ok"]}`
+	respCode, respBody, err = makeRequest(url, http.MethodPost, []byte(samples))
+	assert.NoError(t, err, string(respBody))
+	assert.Equal(t, http.StatusOK, respCode)
+	if err = jsoniter.Unmarshal(respBody, &got6); err != nil {
+		t.Fatalf("respBody %v unmarshal failed, error is %v", respBody, err)
+	}
+	assert.Equal(t, `^\d{4}[-/]\d{2}[-/]\d{2}[T ]\d{2}:\d{2}:\d{2}`, got6.Data.HeadPattern)
+	assert.Equal(t, 2, got6.Data.Matched)
+	assert.True(t, got6.Data.Multiline)
+
+	respCode, respBody, err = makeRequest(url, http.MethodPost, []byte(`{"samples":[]}`))
+	assert.NoError(t, err, string(respBody))
+	assert.Equal(t, http.StatusBadRequest, respCode)
+}
+
+type respHeadPattern struct {
+	Code string                       `json:"code"`
+	Data reader.HeadPatternSuggestion `json:"data"`
+}
+
+func runnerHeadPatternTest(p *testParam) {
+	t := p.t
+	rd := p.rd
+	rs := p.rs
+	runnerName := "runnerHeadPatternTest"
+	testDir := filepath.Join(rd, runnerName)
+	logDir := filepath.Join(testDir, "logdir")
+	metaDir := filepath.Join(testDir, "meta")
+	resvPath := filepath.Join(testDir, "sender", "resv")
+	if err := mkTestDir(testDir, logDir, metaDir, filepath.Dir(resvPath)); err != nil {
+		t.Fatalf("mkdir test path error %v", err)
+	}
+	defer os.RemoveAll(testDir)
+	runnerConf, err := getRunnerConfig(runnerName, logDir, metaDir, config.ModeDir, resvPath)
+	if err != nil {
+		t.Fatalf("get runner config failed, error is %v", err)
+	}
+	url := "http://127.0.0.1" + rs.address + "/logkit/configs/" + runnerName
+	respCode, respBody, err := makeRequest(url, http.MethodPost, runnerConf)
+	assert.NoError(t, err, string(respBody))
+	assert.Equal(t, http.StatusOK, respCode)
+	time.Sleep(time.Second)
+
+	// 不合法的正则不会修改配置
+	respCode, respBody, err = makeRequest(url+"/headpattern", http.MethodPost, []byte(`{"head_pattern":"^[abc"}`))
+	assert.NoError(t, err, string(respBody))
+	assert.Equal(t, http.StatusBadRequest, respCode)
+
+	respCode, respBody, err = makeRequest(url+"/headpattern", http.MethodPost, []byte(`{"samples":["[INFO] start\n  detail","[WARN] slow"]}`))
+	assert.NoError(t, err, string(respBody))
+	assert.Equal(t, http.StatusOK, respCode)
+	var got respRunnerConfig
+	respCode, respBody, err = makeRequest(url, http.MethodGet, []byte{})
+	assert.NoError(t, err, string(respBody))
+	assert.Equal(t, http.StatusOK, respCode)
+	if err = jsoniter.Unmarshal(respBody, &got); err != nil {
+		t.Fatalf("respBody %v unmarshal failed, error is %v", respBody, err)
+	}
+	assert.Equal(t, `^\[?(TRACE|DEBUG|INFO|WARN|WARNING|ERROR|FATAL|CRITICAL)\b`, got.Data.ReaderConfig[config.KeyHeadPattern])
+
+	respCode, respBody, err = makeRequest("http://127.0.0.1"+rs.address+"/logkit/configs/notexist/headpattern", http.MethodPost, []byte(`{"head_pattern":"^\\d"}`))
+	assert.NoError(t, err, string(respBody))
+	assert.Equal(t, http.StatusBadRequest, respCode)
 }
@@ -0,0 +1,12 @@
+package mgr
+
+import (
+	"github.com/labstack/echo"
+)
+
+// get /logkit/recovery 获取 runner 启动时的恢复报告
+func (rs *RestService) GetRecoveryReports() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return RespSuccess(c, rs.mgr.RecoveryReports())
+	}
+}
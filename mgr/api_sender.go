@@ -62,6 +62,21 @@ func (rs *RestService) PostSend() echo.HandlerFunc {
 	}
 }
 
+// POST /logkit/sender/preview 生成发送样例数据的请求但不发送，用于预览数据模板的渲染结果
+func (rs *RestService) PostSenderPreview() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var senderConfig map[string]interface{} // request body params in map format
+		if err := c.Bind(&senderConfig); err != nil {
+			return RespError(c, http.StatusBadRequest, ErrSendSend, err.Error())
+		}
+		requests, err := PreviewRequests(senderConfig)
+		if err != nil {
+			return RespError(c, http.StatusBadRequest, ErrSendSend, err.Error())
+		}
+		return RespSuccess(c, requests)
+	}
+}
+
 // POST /logkit/sender/check 请求校验sender配置
 func (rs *RestService) PostSenderCheck() echo.HandlerFunc {
 	return func(c echo.Context) error {
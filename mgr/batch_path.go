@@ -0,0 +1,127 @@
+package mgr
+
+import (
+	"time"
+
+	"github.com/qiniu/log"
+
+	"github.com/qiniu/logkit/parser"
+	"github.com/qiniu/logkit/reader"
+	"github.com/qiniu/logkit/sender"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+// batchPath 判断 runner 能否直接将 parser 解析出的 Batch 交给 sender 发送，省去每条数据分配一个 map 的开销。
+// 需要显式开启 batch_path，parser 支持 BatchParser、所有 sender 都支持 BatchSender(开启 fault_tolerant 的 sender 不支持)，
+// 并且没有配置 transformer 以及路由、影子流水线等需要逐条处理 Data 的功能，不满足时返回 nil
+func (r *LogExportRunner) batchPath() (parser.BatchParser, []sender.BatchSender) {
+	if !r.BatchPath || r.SendRaw || len(r.transformers) > 0 {
+		return nil, nil
+	}
+	bp, ok := r.parser.(parser.BatchParser)
+	if !ok {
+		return nil, nil
+	}
+	if _, ok := r.parser.(parser.Flushable); ok {
+		return nil, nil
+	}
+	if _, ok := r.reader.(reader.DataReader); ok {
+		return nil, nil
+	}
+	if r.router != nil || r.barrierQuorum > 0 || r.shadow != nil || r.canary != nil ||
+		r.schemaDrift != nil || r.latencySLO != nil || r.parseErrors != nil {
+		return nil, nil
+	}
+	senders := make([]sender.BatchSender, 0, len(r.senders))
+	for _, s := range r.senders {
+		bs, ok := s.(sender.BatchSender)
+		if !ok {
+			return nil, nil
+		}
+		senders = append(senders, bs)
+	}
+	return bp, senders
+}
+
+// readBatch 读取数据并直接解析为 Batch。需要为每条数据加上标签、数据来源等信息或者正在抓取调试数据时，
+// 通过 Batch.Datas() 转换为 Data 按原有的流程处理，此时返回的 Batch 为 nil
+func (r *LogExportRunner) readBatch(bp parser.BatchParser, dataSourceTag string) (*Batch, []Data) {
+	var curTimeStr string
+	lines, froms, _, records := r.rawReadLines(dataSourceTag)
+	r.tracker.Track("finish rawReadLines")
+	if r.ReadTime {
+		curTimeStr = time.Now().Format("2006-01-02 15:04:05.999")
+	}
+	if len(lines) <= 0 {
+		r.debug.debugf("Runner[%v] fetched 0 lines", r.Name())
+		return nil, nil
+	}
+
+	batch, err := bp.ParseBatch(lines)
+	r.tracker.Track("finish parse data")
+	se, err := r.addParseStats(len(lines), err)
+	if batch == nil || batch.Len() <= 0 {
+		r.debug.debugf("Runner[%v] received parsed data length = 0", r.Name())
+		return nil, nil
+	}
+
+	tags := r.dataTags(curTimeStr)
+	if len(tags) > 0 || dataSourceTag != "" || len(records) > 0 || r.sourceTagger() != nil ||
+		r.meta.GetEncodeTag() != "" || r.debug.capturing() {
+		datas := batch.Datas()
+		if r.debug.capturing() {
+			r.debug.record(lines, datas, err)
+		}
+		return nil, r.decorateDatas(datas, froms, records, se, tags, dataSourceTag)
+	}
+	return batch, nil
+}
+
+// sendBatch 将 Batch 发送到所有 sender，全部发送成功时返回 true
+func (r *LogExportRunner) sendBatch(senders []sender.BatchSender, batch *Batch) bool {
+	for i, s := range r.senders {
+		if !r.trySendBatch(s, senders[i], batch, r.MaxBatchTryTimes) {
+			log.Errorf("Runner[%v] failed to send data finally", r.Name())
+			return false
+		}
+	}
+	return true
+}
+
+// trySendBatch 先以 Batch 发送一次，失败时转换为 Data 交给 trySend 按原有的策略重试，部分失败时只重试失败的数据
+func (r *LogExportRunner) trySendBatch(s sender.Sender, bs sender.BatchSender, batch *Batch, times int) bool {
+	err := bs.SendBatch(batch)
+	se, ok := err.(*StatsError)
+	if ok && se.Errors == 0 {
+		err = nil
+	}
+	var (
+		success int64
+		datas   []Data
+	)
+	if err == nil {
+		success = int64(batch.Len())
+		r.sendPolicy.resume()
+	} else if ok && se.SendError != nil {
+		failed := se.SendError.GetFailDatas()
+		success = int64(batch.Len() - len(failed))
+		datas = sender.ConvertDatas(failed)
+		err = se.SendError
+	} else {
+		datas = batch.Datas()
+	}
+
+	r.rsMutex.Lock()
+	info := r.rs.SenderStats[s.Name()]
+	info.Success += success
+	if err != nil {
+		r.addSendError(s.Name(), &info, err)
+	}
+	r.rs.SenderStats[s.Name()] = info
+	r.rsMutex.Unlock()
+	if err == nil {
+		return true
+	}
+	log.Warnf("Runner[%v] send batch to %v error: %v, retry %d datas", r.Name(), s.Name(), err, len(datas))
+	return r.trySend(s, datas, times)
+}
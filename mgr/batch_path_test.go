@@ -0,0 +1,227 @@
+package mgr
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/json-iterator/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/qiniu/logkit/cleaner"
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/parser"
+	"github.com/qiniu/logkit/reader"
+	"github.com/qiniu/logkit/sender"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+// batchSender 分别记录通过 Send 和 SendBatch 收到的数据
+type batchSender struct {
+	mux     sync.Mutex
+	datas   []Data
+	batches []Data
+}
+
+func (s *batchSender) Name() string { return "batch_sender" }
+
+func (s *batchSender) Send(datas []Data) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.datas = append(s.datas, datas...)
+	return nil
+}
+
+func (s *batchSender) SendBatch(batch *Batch) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.batches = append(s.batches, batch.Datas()...)
+	return nil
+}
+
+func (s *batchSender) Close() error { return nil }
+
+func runBatchPath(t *testing.T, name string, readerConf string) *batchSender {
+	dir := name
+	assert.NoError(t, os.Mkdir(dir, DefaultDirPerm))
+	defer os.RemoveAll(dir)
+	logPath := filepath.Join(dir, "test.log")
+	line := `111.111.111.101 - - [30/Aug/2016:14:03:37 +0800] "GET /s5/M00/CE/91/xaxsxsxsxs HTTP/1.1" 200 4962 "http://www.abc.cn" "Mozilla/5.0 (Windows NT 6.1; WOW64)" 0.204`
+	assert.NoError(t, ioutil.WriteFile(logPath, []byte(line+"\n"+line+"\n"), DefaultFilePerm))
+
+	config := `{
+			"name":"` + name + `",
+			"batch_len":2,
+			"batch_path":true,
+			"reader":{
+				"mode":"file",
+				"meta_path":"./` + name + `/meta",
+				"log_path":"./` + name + `/test.log",
+				"read_from":"oldest"` + readerConf + `
+			},
+			"parser":{
+				"name":"nginx",
+				"type":"nginx",
+				"nginx_log_format_regex":"^(?P<remote_addr>[^ ]*) - (?P<remote_user>[^ ]*) \\[(?P<time_local>[^]]*)\\] \"(?P<request>[^\"]*)\" (?P<status>[^ ]*) (?P<body_bytes_sent>[^ ]*) \"(?P<http_referer>[^\"]*)\" \"(?P<http_user_agent>[^\"]*)\" (?P<request_time>[^ ]*)$",
+				"nginx_schema":"remote_addr string, status long"
+			},
+			"senders":[{
+				"name":"batch_sender",
+				"sender_type":"batch_mock",
+				"fault_tolerant":"false"
+			}]
+		}`
+	rc := RunnerConfig{}
+	assert.NoError(t, jsoniter.Unmarshal([]byte(config), &rc))
+	s := &batchSender{}
+	sr := sender.NewRegistry()
+	assert.NoError(t, sr.RegisterSender("batch_mock", func(conf.MapConf) (sender.Sender, error) { return s, nil }))
+	rr, err := NewCustomRunner(rc, make(chan cleaner.CleanSignal), reader.NewRegistry(), parser.NewRegistry(), sr)
+	assert.NoError(t, err)
+	go rr.Run()
+	time.Sleep(2 * time.Second)
+	rr.Stop()
+	r := rr.(*LogExportRunner)
+	r.rsMutex.RLock()
+	assert.EqualValues(t, 2, r.rs.SenderStats["batch_sender"].Success)
+	r.rsMutex.RUnlock()
+	return s
+}
+
+func TestRunWithBatchPath(t *testing.T) {
+	t.Parallel()
+	s := runBatchPath(t, "TestRunWithBatchPath", "")
+	assert.Empty(t, s.datas)
+	assert.Len(t, s.batches, 2)
+	for _, d := range s.batches {
+		assert.Equal(t, "111.111.111.101", d["remote_addr"])
+		assert.Equal(t, int64(200), d["status"])
+	}
+
+	// 需要为每条数据加上数据来源时转换为 Data 发送
+	s = runBatchPath(t, "TestRunWithBatchPathDataSource", `,"datasource_tag":"source"`)
+	assert.Empty(t, s.batches)
+	assert.Len(t, s.datas, 2)
+	for _, d := range s.datas {
+		assert.Equal(t, "111.111.111.101", d["remote_addr"])
+		assert.NotEmpty(t, d["source"])
+	}
+}
+
+// producedMessages 从 MockBroker 收到的 ProduceRequest 中取出所有消息，格式为 "topic json"，json 的 key 按字典序排列。
+// sarama 没有导出 ProduceRequest 中的消息，只能通过反射读取
+func producedMessages(t *testing.T, broker *sarama.MockBroker) []string {
+	var msgs []string
+	for _, rr := range broker.History() {
+		req, ok := rr.Request.(*sarama.ProduceRequest)
+		if !ok {
+			continue
+		}
+		records := reflect.ValueOf(req).Elem().FieldByName("records")
+		for _, topic := range records.MapKeys() {
+			partitions := records.MapIndex(topic)
+			for _, partition := range partitions.MapKeys() {
+				set := partitions.MapIndex(partition).FieldByName("MsgSet")
+				require.False(t, set.IsNil())
+				messages := set.Elem().FieldByName("Messages")
+				for i := 0; i < messages.Len(); i++ {
+					value := messages.Index(i).Elem().FieldByName("Msg").Elem().FieldByName("Value").Bytes()
+					var data map[string]interface{}
+					require.NoError(t, json.Unmarshal(value, &data))
+					canonical, err := json.Marshal(data)
+					require.NoError(t, err)
+					msgs = append(msgs, topic.String()+" "+string(canonical))
+				}
+			}
+		}
+	}
+	sort.Strings(msgs)
+	return msgs
+}
+
+func runKafkaPath(t *testing.T, name string, batchPath bool) []string {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	metadata := sarama.NewMockMetadataResponse(t).SetBroker(broker.Addr(), broker.BrokerID())
+	for _, topic := range []string{"topic_a", "topic_b", "default_topic"} {
+		metadata.SetLeader(topic, 0, broker.BrokerID())
+	}
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": metadata,
+		"ProduceRequest":  sarama.NewMockProduceResponse(t),
+	})
+
+	dir := name
+	assert.NoError(t, os.Mkdir(dir, DefaultDirPerm))
+	defer os.RemoveAll(dir)
+	lines := []string{
+		`111.111.111.101 - - [30/Aug/2016:14:03:37 +0800] "GET /a HTTP/1.1" 200 4962 "topic_a" "Mozilla/5.0" 0.204`,
+		`111.111.111.102 - - [30/Aug/2016:14:03:38 +0800] "GET /b HTTP/1.1" 404 0 "" "curl" 1.5`,
+		`111.111.111.103 - - [30/Aug/2016:14:03:39 +0800] "POST /c HTTP/1.1" 500 12 "topic_b" "curl" 0.01`,
+	}
+	var content string
+	for _, line := range lines {
+		content += line + "\n"
+	}
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "test.log"), []byte(content), DefaultFilePerm))
+
+	config := `{
+			"name":"` + name + `",
+			"batch_len":3,
+			"batch_path":` + fmt.Sprint(batchPath) + `,
+			"reader":{
+				"mode":"file",
+				"meta_path":"./` + name + `/meta",
+				"log_path":"./` + name + `/test.log",
+				"read_from":"oldest"
+			},
+			"parser":{
+				"name":"nginx",
+				"type":"nginx",
+				"nginx_log_format_regex":"^(?P<remote_addr>[^ ]*) - (?P<remote_user>[^ ]*) \\[(?P<time_local>[^]]*)\\] \"(?P<request>[^\"]*)\" (?P<status>[^ ]*) (?P<body_bytes_sent>[^ ]*) \"(?P<http_referer>[^\"]*)\" \"(?P<http_user_agent>[^\"]*)\" (?P<request_time>[^ ]*)$",
+				"nginx_schema":"remote_addr string, status long, request_time float"
+			},
+			"senders":[{
+				"name":"kafka_sender",
+				"sender_type":"kafka",
+				"kafka_host":"` + broker.Addr() + `",
+				"kafka_topic":"%{[http_referer]},default_topic",
+				"fault_tolerant":"false"
+			}]
+		}`
+	rc := RunnerConfig{}
+	require.NoError(t, jsoniter.Unmarshal([]byte(config), &rc))
+	rr, err := NewCustomRunner(rc, make(chan cleaner.CleanSignal), reader.NewRegistry(), parser.NewRegistry(), sender.NewRegistry())
+	require.NoError(t, err)
+	r := rr.(*LogExportRunner)
+	_, senders := r.batchPath()
+	assert.Equal(t, batchPath, senders != nil)
+	go rr.Run()
+	time.Sleep(2 * time.Second)
+	rr.Stop()
+	r.rsMutex.RLock()
+	assert.EqualValues(t, len(lines), r.rs.SenderStats["kafka_sender"].Success)
+	r.rsMutex.RUnlock()
+	return producedMessages(t, broker)
+}
+
+// 开启 batch_path 后 kafka sender 通过 SendBatch 发送的消息与 Send 完全相同，包括根据字段选择 topic
+func TestRunWithBatchPathKafka(t *testing.T) {
+	t.Parallel()
+	sent := runKafkaPath(t, "TestRunWithBatchPathKafkaSend", false)
+	batched := runKafkaPath(t, "TestRunWithBatchPathKafkaBatch", true)
+	require.Len(t, sent, 3)
+	assert.Equal(t, sent, batched)
+	assert.Equal(t, `default_topic {"body_bytes_sent":"0","http_referer":"","http_user_agent":"curl","remote_addr":"111.111.111.102","remote_user":"-","request":"GET /b HTTP/1.1","request_time":1.5,"status":404,"time_local":"30/Aug/2016:14:03:38 +0800"}`, sent[0])
+	assert.Contains(t, sent[1], `topic_a {"body_bytes_sent":"4962","http_referer":"topic_a",`)
+	assert.Contains(t, sent[2], `topic_b {"body_bytes_sent":"12","http_referer":"topic_b",`)
+}
@@ -0,0 +1,200 @@
+package mgr
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/qiniu/log"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/sender"
+	senderConf "github.com/qiniu/logkit/sender/config"
+	"github.com/qiniu/logkit/utils"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const (
+	// CanaryStatsPrefix 灰度 sender 的统计信息在 SenderStats 中的名称前缀
+	CanaryStatsPrefix = "canary_"
+
+	defaultCanaryQueueSize = 16
+)
+
+// CanaryConfig 灰度发送配置，按比例将数据复制一份发送到候选 sender，用于迁移前使用真实流量验证新的发送目的地
+type CanaryConfig struct {
+	// Percent 复制到候选 sender 的数据比例，取值 (0, 100]
+	Percent float64 `json:"percent"`
+	// SenderConfig 候选 sender 的配置
+	SenderConfig conf.MapConf `json:"sender"`
+	// QueueSize 等待发送的批次数，队列满时丢弃并计入错误，避免拖慢主流程
+	QueueSize int `json:"queue_size,omitempty"`
+}
+
+// canary 灰度发送，数据在独立的协程中发送，失败不重试也不影响主 sender
+type canary struct {
+	name    string
+	percent float64
+	sender  sender.Sender
+	random  *rand.Rand
+
+	dataChan  chan []Data
+	wg        sync.WaitGroup
+	chanMutex sync.Mutex
+	closed    bool
+
+	statsMutex sync.RWMutex
+	stats      StatsInfo
+}
+
+func newCanary(runnerName string, c *CanaryConfig, sr *sender.Registry, ftSaveLogPath string) (*canary, error) {
+	if c == nil {
+		return nil, nil
+	}
+	if c.Percent <= 0 || c.Percent > 100 {
+		return nil, fmt.Errorf("canary percent %v should be in (0, 100]", c.Percent)
+	}
+	if len(c.SenderConfig) == 0 {
+		return nil, errors.New("canary sender config is empty")
+	}
+	senderConfig := conf.MapConf{}
+	for k, v := range c.SenderConfig {
+		senderConfig[k] = v
+	}
+	name := senderConfig[senderConf.KeyName]
+	if name == "" {
+		name = senderConfig[senderConf.KeySenderType]
+	}
+	// 使用单独的磁盘队列目录，避免与同类型的主 sender 冲突
+	s, err := sr.NewSender(senderConfig, filepath.Join(ftSaveLogPath, "canary"))
+	if err != nil {
+		return nil, fmt.Errorf("runner %v create canary sender error, %v", runnerName, err)
+	}
+	return startCanary(CanaryStatsPrefix+name, c.Percent, c.QueueSize, s), nil
+}
+
+func startCanary(name string, percent float64, queueSize int, s sender.Sender) *canary {
+	if queueSize <= 0 {
+		queueSize = defaultCanaryQueueSize
+	}
+	cn := &canary{
+		name:     name,
+		percent:  percent,
+		sender:   s,
+		random:   rand.New(rand.NewSource(time.Now().UnixNano())),
+		dataChan: make(chan []Data, queueSize),
+	}
+	cn.wg.Add(1)
+	go cn.run()
+	return cn
+}
+
+func (c *canary) Name() string {
+	return c.name
+}
+
+func (c *canary) pick(datas []Data) []Data {
+	if c.percent >= 100 {
+		return datas
+	}
+	var picked []Data
+	for _, d := range datas {
+		if c.random.Float64()*100 < c.percent {
+			picked = append(picked, d)
+		}
+	}
+	return picked
+}
+
+// Sample 按比例抽取数据并深度拷贝，需要在主 sender 发送之前调用，防止数据被主 sender 修改
+func (c *canary) Sample(datas []Data) []Data {
+	sampled := c.pick(datas)
+	if len(sampled) == 0 {
+		return nil
+	}
+	var copied []Data
+	utils.DeepCopyByJSON(&copied, &sampled)
+	return copied
+}
+
+// Feed 将抽样后的数据放入发送队列，队列满时直接丢弃
+func (c *canary) Feed(datas []Data) {
+	if len(datas) == 0 {
+		return
+	}
+	c.chanMutex.Lock()
+	defer c.chanMutex.Unlock()
+	if c.closed {
+		return
+	}
+	select {
+	case c.dataChan <- datas:
+	default:
+		c.addStats(0, int64(len(datas)), "canary queue is full, datas dropped")
+	}
+}
+
+func (c *canary) run() {
+	defer c.wg.Done()
+	for datas := range c.dataChan {
+		c.send(datas)
+	}
+}
+
+func (c *canary) send(datas []Data) {
+	err := c.sender.Send(datas)
+	if err == nil {
+		c.addStats(int64(len(datas)), 0, "")
+		return
+	}
+	if se, ok := err.(*StatsError); ok {
+		if se.Errors == 0 {
+			c.addStats(int64(len(datas)), 0, "")
+			return
+		}
+		success := se.Success
+		if se.SendError != nil {
+			success = int64(len(datas) - len(se.SendError.GetFailDatas()))
+		}
+		c.addStats(success, int64(len(datas))-success, err.Error())
+		return
+	}
+	c.addStats(0, int64(len(datas)), err.Error())
+}
+
+func (c *canary) addStats(success, errs int64, lastError string) {
+	c.statsMutex.Lock()
+	defer c.statsMutex.Unlock()
+	c.stats.Success += success
+	c.stats.Errors += errs
+	if lastError != "" {
+		c.stats.LastError = TruncateStrSize(lastError, DefaultTruncateMaxSize)
+	}
+}
+
+func (c *canary) Stats() StatsInfo {
+	c.statsMutex.RLock()
+	defer c.statsMutex.RUnlock()
+	return c.stats
+}
+
+// Close 等待队列中的数据发送完毕后关闭候选 sender
+func (c *canary) Close() error {
+	c.chanMutex.Lock()
+	if c.closed {
+		c.chanMutex.Unlock()
+		return nil
+	}
+	c.closed = true
+	close(c.dataChan)
+	c.chanMutex.Unlock()
+	c.wg.Wait()
+	err := c.sender.Close()
+	if err != nil {
+		log.Errorf("canary sender %v close error %v", c.name, err)
+	}
+	return err
+}
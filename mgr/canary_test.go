@@ -0,0 +1,52 @@
+package mgr
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/sender"
+	"github.com/qiniu/logkit/sender/mock"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+func TestCanary(t *testing.T) {
+	sr := sender.NewRegistry()
+	_, err := newCanary("test", &CanaryConfig{Percent: 0, SenderConfig: conf.MapConf{"sender_type": "mock"}}, sr, "")
+	assert.Error(t, err)
+	_, err = newCanary("test", &CanaryConfig{Percent: 10}, sr, "")
+	assert.Error(t, err)
+	cn, err := newCanary("test", nil, sr, "")
+	assert.NoError(t, err)
+	assert.Nil(t, cn)
+
+	s, err := mock.NewSender(conf.MapConf{"name": "new_cluster"})
+	assert.NoError(t, err)
+	cn = startCanary(CanaryStatsPrefix+"new_cluster", 100, 0, s)
+	sampled := []Data{{"a": "1"}, {"a": "2"}}
+	assert.Equal(t, sampled, cn.pick(sampled))
+	cn.Feed(sampled)
+	assert.NoError(t, cn.Close())
+	assert.Equal(t, StatsInfo{Success: 2}, cn.Stats())
+	assert.Equal(t, 1, s.(*mock.Sender).SendCount())
+	// 关闭后的数据直接忽略
+	cn.Feed(sampled)
+	assert.NoError(t, cn.Close())
+
+	s, err = mock.NewSender(conf.MapConf{"is_req_err": "true"})
+	assert.NoError(t, err)
+	cn = startCanary(CanaryStatsPrefix+"mock", 10, 0, s)
+	datas := make([]Data, 10000)
+	for i := range datas {
+		datas[i] = Data{"i": i}
+	}
+	sampled = cn.pick(datas)
+	assert.InDelta(t, 1000, len(sampled), 200)
+	cn.Feed(sampled)
+	assert.NoError(t, cn.Close())
+	stats := cn.Stats()
+	assert.Equal(t, int64(1), stats.Errors)
+	assert.Equal(t, int64(len(sampled)-1), stats.Success)
+	assert.NotEmpty(t, stats.LastError)
+}
@@ -0,0 +1,341 @@
+// Package client 是 logkit 管理接口(/logkit)的 go 客户端，接口文档可以通过 /logkit/openapi.json 获取
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/qiniu/logkit/mgr"
+	"github.com/qiniu/logkit/reader"
+	"github.com/qiniu/logkit/sender"
+	"github.com/qiniu/logkit/utils/bundle"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const defaultTimeout = time.Minute
+
+// Error 管理接口返回的错误，Code 为 logkit 的错误码，含义可以通过 ErrorCodes 获取
+type Error struct {
+	StatusCode int    `json:"-"`
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("logkit api error, status %d, code %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// Client logkit 管理接口的客户端，可以在多个 goroutine 中同时使用
+type Client struct {
+	endpoint   string
+	httpClient *http.Client
+}
+
+// New 创建客户端，endpoint 为 logkit 的地址，如 http://127.0.0.1:3000，没有协议时默认使用 http，
+// httpClient 为 nil 时使用超时时间为 1 分钟的默认客户端
+func New(endpoint string, httpClient *http.Client) *Client {
+	if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+		endpoint = "http://" + endpoint
+	}
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: defaultTimeout}
+	}
+	return &Client{
+		endpoint:   strings.TrimSuffix(endpoint, "/"),
+		httpClient: httpClient,
+	}
+}
+
+// Do 请求 /logkit 下的接口，path 不包含 /logkit 前缀，body 不为 nil 时编码为 json 作为请求体，
+// 请求成功时将返回结果中的 data 字段解码到 data 中，data 为 nil 时忽略返回结果
+func (c *Client) Do(method, path string, query url.Values, body, data interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		bts, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(bts)
+	}
+	reqURL := c.endpoint + mgr.PREFIX + path
+	if len(query) > 0 {
+		reqURL += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, reqURL, reqBody)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set(ContentTypeHeader, ApplicationJson)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		apiErr := &Error{StatusCode: resp.StatusCode}
+		if err = json.Unmarshal(respBody, apiErr); err != nil || apiErr.Code == "" {
+			apiErr.Message = strings.TrimSpace(string(respBody))
+		}
+		return apiErr
+	}
+	if data == nil {
+		return nil
+	}
+	ret := struct {
+		Code string          `json:"code"`
+		Data json.RawMessage `json:"data"`
+	}{}
+	if err = json.Unmarshal(respBody, &ret); err != nil {
+		return fmt.Errorf("unmarshal response of %s %s error %v", method, path, err)
+	}
+	if len(ret.Data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(ret.Data, data)
+}
+
+func runnerPath(name string, action ...string) string {
+	return "/configs/" + url.PathEscape(name) + strings.Join(action, "")
+}
+
+// Status 获取所有 runner 的运行状态
+func (c *Client) Status() (map[string]mgr.RunnerStatus, error) {
+	var status map[string]mgr.RunnerStatus
+	err := c.Do(http.MethodGet, "/status", nil, nil, &status)
+	return status, err
+}
+
+// Errors 获取所有 runner 的错误信息
+func (c *Client) Errors() (map[string]mgr.ErrorsResult, error) {
+	var errs map[string]mgr.ErrorsResult
+	err := c.Do(http.MethodGet, "/errors", nil, nil, &errs)
+	return errs, err
+}
+
+// RunnerErrors 获取 runner 的错误信息
+func (c *Client) RunnerErrors(name string) (mgr.ErrorsResult, error) {
+	var errs mgr.ErrorsResult
+	err := c.Do(http.MethodGet, "/errors/"+url.PathEscape(name), nil, nil, &errs)
+	return errs, err
+}
+
+// Completions 获取 runner 已读完文件的校验记录
+func (c *Client) Completions(name string) ([]reader.CompletionRecord, error) {
+	var records []reader.CompletionRecord
+	err := c.Do(http.MethodGet, "/completions/"+url.PathEscape(name), nil, nil, &records)
+	return records, err
+}
+
+// ErrorCodes 获取错误码的说明
+func (c *Client) ErrorCodes() (map[string]string, error) {
+	var codes map[string]string
+	err := c.Do(http.MethodGet, "/errorcode", nil, nil, &codes)
+	return codes, err
+}
+
+// Configs 获取所有 runner 的配置，配置中的敏感信息已被隐藏
+func (c *Client) Configs() (map[string]mgr.RunnerConfig, error) {
+	var configs map[string]mgr.RunnerConfig
+	err := c.Do(http.MethodGet, "/configs", nil, nil, &configs)
+	return configs, err
+}
+
+// Config 获取 runner 的配置
+func (c *Client) Config(name string) (mgr.RunnerConfig, error) {
+	var config mgr.RunnerConfig
+	err := c.Do(http.MethodGet, runnerPath(name), nil, nil, &config)
+	return config, err
+}
+
+// AddConfig 添加 runner
+func (c *Client) AddConfig(name string, config mgr.RunnerConfig) error {
+	return c.Do(http.MethodPost, runnerPath(name), nil, config, nil)
+}
+
+// UpdateConfig 更新 runner 的配置
+func (c *Client) UpdateConfig(name string, config mgr.RunnerConfig) error {
+	return c.Do(http.MethodPut, runnerPath(name), nil, config, nil)
+}
+
+// DeleteConfig 删除 runner
+func (c *Client) DeleteConfig(name string) error {
+	return c.Do(http.MethodDelete, runnerPath(name), nil, nil, nil)
+}
+
+// ValidateConfig 检查配置中废弃、冲突以及可疑的配置项，不会创建 runner
+func (c *Client) ValidateConfig(name string, config mgr.RunnerConfig) (mgr.ValidateResult, error) {
+	var result mgr.ValidateResult
+	err := c.Do(http.MethodPost, runnerPath(name, "/validate"), nil, config, &result)
+	return result, err
+}
+
+// StartRunner 启动 runner
+func (c *Client) StartRunner(name string) error {
+	return c.Do(http.MethodPost, runnerPath(name, "/start"), nil, nil, nil)
+}
+
+// StopRunner 停止 runner
+func (c *Client) StopRunner(name string) error {
+	return c.Do(http.MethodPost, runnerPath(name, "/stop"), nil, nil, nil)
+}
+
+// ResetRunner 重置 runner，清空读取进度
+func (c *Client) ResetRunner(name string) error {
+	return c.Do(http.MethodPost, runnerPath(name, "/reset"), nil, nil, nil)
+}
+
+// DrainRunner 停止读取并等待已读取的数据发送完毕后停止 runner，timeout 为 0 时使用服务端的默认值
+func (c *Client) DrainRunner(name string, timeout time.Duration) error {
+	var query url.Values
+	if timeout > 0 {
+		query = url.Values{"timeout": {timeout.String()}}
+	}
+	return c.Do(http.MethodPost, runnerPath(name, "/drain"), query, nil, nil)
+}
+
+// FlushRunner 立即发送 runner 所有 sender 中缓存的数据，返回每个 sender 的结果，timeout 为 0 时使用服务端的默认值
+func (c *Client) FlushRunner(name string, timeout time.Duration) ([]mgr.SenderFlushResult, error) {
+	var query url.Values
+	if timeout > 0 {
+		query = url.Values{"timeout": {timeout.String()}}
+	}
+	var results []mgr.SenderFlushResult
+	err := c.Do(http.MethodPost, runnerPath(name, "/flush"), query, nil, &results)
+	return results, err
+}
+
+// SetRunnerDebug 临时打开 runner 的 debug 日志，duration 为 0 时立即恢复，返回自动恢复的时间
+func (c *Client) SetRunnerDebug(name string, duration time.Duration) (time.Time, error) {
+	query := url.Values{"duration": {duration.String()}}
+	var result mgr.DebugResult
+	err := c.Do(http.MethodPost, runnerPath(name, "/debug"), query, nil, &result)
+	return result.DebugUntil, err
+}
+
+// StartCapture 开始抓取 runner 接下来读取的 lines 条原始数据以及解析结果，lines 为 0 时使用服务端的默认值
+func (c *Client) StartCapture(name string, lines int) error {
+	var query url.Values
+	if lines > 0 {
+		query = url.Values{"lines": {strconv.Itoa(lines)}}
+	}
+	return c.Do(http.MethodPost, runnerPath(name, "/capture"), query, nil, nil)
+}
+
+// Capture 下载抓取结果的 tar.gz 压缩包
+func (c *Client) Capture(name string) ([]byte, error) {
+	resp, err := c.httpClient.Get(c.endpoint + mgr.PREFIX + runnerPath(name, "/capture"))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		apiErr := &Error{StatusCode: resp.StatusCode}
+		if err = json.Unmarshal(body, apiErr); err != nil || apiErr.Code == "" {
+			apiErr.Message = strings.TrimSpace(string(body))
+		}
+		return nil, apiErr
+	}
+	return body, nil
+}
+
+// Requests 获取 runner 中开启了请求记录的 sender 最近发送的请求
+func (c *Client) Requests(name string) ([]mgr.SenderRequests, error) {
+	var requests []mgr.SenderRequests
+	err := c.Do(http.MethodGet, runnerPath(name, "/requests"), nil, nil, &requests)
+	return requests, err
+}
+
+// ReplayRequest 重新发送 runner 第 senderIndex 个 sender 记录的 id 请求，返回重放的结果
+func (c *Client) ReplayRequest(name string, senderIndex int, id int64) (*sender.CapturedRequest, error) {
+	var result sender.CapturedRequest
+	path := runnerPath(name, "/requests/", strconv.Itoa(senderIndex), "/", strconv.FormatInt(id, 10), "/replay")
+	if err := c.Do(http.MethodPost, path, nil, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Runners 获取 runner 名称列表，selector 为标签选择器，为空时返回所有 runner
+func (c *Client) Runners(selector string) ([]string, error) {
+	var query url.Values
+	if selector != "" {
+		query = url.Values{"selector": {selector}}
+	}
+	var names []string
+	err := c.Do(http.MethodGet, "/runners", query, nil, &names)
+	return names, err
+}
+
+// Bulk 批量操作标签满足选择器的 runner，op 为 start、stop、drain 或者 delete
+func (c *Client) Bulk(op string, req mgr.BulkRequest) ([]mgr.BulkResult, error) {
+	var results []mgr.BulkResult
+	err := c.Do(http.MethodPost, "/runners/bulk/"+url.PathEscape(op), nil, req, &results)
+	return results, err
+}
+
+// Version 获取 logkit 版本
+func (c *Client) Version() (string, error) {
+	var version mgr.Version
+	err := c.Do(http.MethodGet, "/version", nil, nil, &version)
+	return version.Version, err
+}
+
+// Bundles 获取所有数据包的状态
+func (c *Client) Bundles() ([]bundle.Status, error) {
+	var status []bundle.Status
+	err := c.Do(http.MethodGet, "/bundles", nil, nil, &status)
+	return status, err
+}
+
+// UpdateBundle 立即检查并更新数据包
+func (c *Client) UpdateBundle(name string) error {
+	return c.Do(http.MethodPost, "/bundles/"+url.PathEscape(name)+"/update", nil, nil, nil)
+}
+
+// RollbackBundle 将数据包回滚到上一个版本
+func (c *Client) RollbackBundle(name string) error {
+	return c.Do(http.MethodPost, "/bundles/"+url.PathEscape(name)+"/rollback", nil, nil, nil)
+}
+
+// RecoveryReports 获取所有 runner 启动时的恢复报告
+func (c *Client) RecoveryReports() ([]mgr.RecoveryReport, error) {
+	var reports []mgr.RecoveryReport
+	err := c.Do(http.MethodGet, "/recovery", nil, nil, &reports)
+	return reports, err
+}
+
+// OpenAPI 获取管理接口的 OpenAPI 文档
+func (c *Client) OpenAPI() ([]byte, error) {
+	resp, err := c.httpClient.Get(c.endpoint + mgr.PREFIX + "/openapi.json")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &Error{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(body))}
+	}
+	return body, nil
+}
@@ -0,0 +1,125 @@
+package client
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/qiniu/logkit/mgr"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+func TestClient(t *testing.T) {
+	type request struct {
+		method, uri, body string
+	}
+	var last request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		last = request{r.Method, r.URL.RequestURI(), string(body)}
+		w.Header().Set(ContentTypeHeader, ApplicationJson)
+		switch r.URL.Path {
+		case "/logkit/status":
+			w.Write([]byte(`{"code":"L200","data":{"r1":{"name":"r1","readDataCount":10,"runningStatus":"running"}}}`))
+		case "/logkit/configs/r1":
+			w.Write([]byte(`{"code":"L200","data":{"name":"r1","reader":{"mode":"dir"},"labels":{"env":"prod"}}}`))
+		case "/logkit/configs/r1/validate":
+			w.Write([]byte(`{"code":"L200","data":{"issues":[{"code":"deprecated","section":"reader","keys":["log_path"],"message":"m"}]}}`))
+		case "/logkit/configs/r1/flush":
+			w.Write([]byte(`{"code":"L200","data":[{"index":0,"name":"ft","error":"1 batches are still pending after 1s","cost_ms":1000},{"index":1,"name":"file","skipped":true,"cost_ms":0}]}`))
+		case "/logkit/runners/bulk/stop":
+			w.Write([]byte(`{"code":"L200","data":[{"runner":"r1"},{"runner":"r2","skipped":true}]}`))
+		case "/logkit/configs/bad":
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"code":"L1104","message":"runner bad is not found"}`))
+		case "/logkit/configs/down":
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte("bad gateway"))
+		default:
+			w.Write([]byte(`{"code":"L200"}`))
+		}
+	}))
+	defer server.Close()
+	c := New(server.URL+"/", nil)
+
+	status, err := c.Status()
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), status["r1"].ReadDataCount)
+	assert.Equal(t, mgr.RunnerRunning, status["r1"].RunningStatus)
+
+	config, err := c.Config("r1")
+	require.NoError(t, err)
+	assert.Equal(t, "dir", config.ReaderConfig["mode"])
+	assert.Equal(t, map[string]string{"env": "prod"}, config.Labels)
+
+	config.Labels["env"] = "dev"
+	assert.NoError(t, c.UpdateConfig("r1", config))
+	assert.Equal(t, http.MethodPut, last.method)
+	var sent mgr.RunnerConfig
+	require.NoError(t, json.Unmarshal([]byte(last.body), &sent))
+	assert.Equal(t, "dev", sent.Labels["env"])
+
+	result, err := c.ValidateConfig("r1", config)
+	require.NoError(t, err)
+	assert.Len(t, result.Issues, 1)
+	assert.Equal(t, "/logkit/configs/r1/validate", last.uri)
+
+	assert.NoError(t, c.DrainRunner("r 1", 30*time.Second))
+	assert.Equal(t, request{http.MethodPost, "/logkit/configs/r%201/drain?timeout=30s", ""}, last)
+	flushed, err := c.FlushRunner("r1", time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, []mgr.SenderFlushResult{
+		{Index: 0, Name: "ft", Error: "1 batches are still pending after 1s", CostMs: 1000},
+		{Index: 1, Name: "file", Skipped: true},
+	}, flushed)
+	assert.Equal(t, request{http.MethodPost, "/logkit/configs/r1/flush?timeout=1s", ""}, last)
+	assert.NoError(t, c.DeleteConfig("r1"))
+	assert.Equal(t, http.MethodDelete, last.method)
+
+	_, err = c.ReplayRequest("r1", 1, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, request{http.MethodPost, "/logkit/configs/r1/requests/1/3/replay", ""}, last)
+
+	names, err := c.Runners("env=prod")
+	assert.NoError(t, err)
+	assert.Nil(t, names)
+	assert.Equal(t, "/logkit/runners?selector=env%3Dprod", last.uri)
+
+	results, err := c.Bulk("stop", mgr.BulkRequest{Selector: "env=prod"})
+	require.NoError(t, err)
+	assert.Equal(t, []mgr.BulkResult{{Runner: "r1"}, {Runner: "r2", Skipped: true}}, results)
+	assert.JSONEq(t, `{"selector":"env=prod"}`, last.body)
+
+	_, err = c.Config("bad")
+	apiErr, ok := err.(*Error)
+	require.True(t, ok)
+	assert.Equal(t, &Error{StatusCode: http.StatusBadRequest, Code: "L1104", Message: "runner bad is not found"}, apiErr)
+
+	err = c.Do(http.MethodGet, "/configs/down", nil, nil, nil)
+	assert.Equal(t, &Error{StatusCode: http.StatusBadGateway, Message: "bad gateway"}, err)
+}
+
+func TestClientWithRestService(t *testing.T) {
+	router := echo.New()
+	mgr.NewRestService(&mgr.Manager{ManagerConfig: mgr.ManagerConfig{DisableWeb: true}, Version: "v1.2.3"}, router)
+	server := httptest.NewServer(router)
+	defer server.Close()
+	c := New(server.URL, nil)
+
+	version, err := c.Version()
+	assert.NoError(t, err)
+	assert.Equal(t, "v1.2.3", version)
+
+	spec, err := c.OpenAPI()
+	require.NoError(t, err)
+	var doc map[string]interface{}
+	require.NoError(t, json.Unmarshal(spec, &doc))
+	assert.Contains(t, doc["paths"], "/logkit/configs/{name}")
+}
@@ -225,6 +225,54 @@ func SendData(senderConfig map[string]interface{}) error {
 	return nil
 }
 
+// PreviewRequests 按照 sender 配置生成发送 sampleLog 中样例数据的请求但不发送，只包含支持预览的 sender
+func PreviewRequests(senderConfig map[string]interface{}) ([]SenderRequests, error) {
+	if senderConfig == nil {
+		return nil, errors.New("sender config cannot be empty")
+	}
+
+	sendersConf, err := getSendersConfig(senderConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	senders, err := getSenders(sendersConf)
+	defer os.RemoveAll(filepath.Join(MetaTmp, reader.FtSaveLogPath))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for _, s := range senders {
+			s.Close()
+		}
+	}()
+
+	router, err := getRouter(senderConfig, len(senders))
+	if err != nil {
+		return nil, err
+	}
+
+	datas, err := getDataFromSenderConfig(senderConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]SenderRequests, 0)
+	senderDataList := classifySenderData(senders, datas, router)
+	for index, s := range senders {
+		ps, ok := s.(sender.RequestPreviewSender)
+		if !ok || len(senderDataList[index]) == 0 {
+			continue
+		}
+		request, err := ps.PreviewRequest(senderDataList[index])
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, SenderRequests{Index: index, Name: s.Name(), Requests: []sender.CapturedRequest{*request}})
+	}
+	return results, nil
+}
+
 func getSendersConfig(senderConfig map[string]interface{}) ([]conf.MapConf, error) {
 	config := senderConfig[KeySendConfig]
 	byteSendersConfig, err := jsoniter.Marshal(config)
@@ -0,0 +1,228 @@
+package mgr
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qiniu/log"
+
+	"github.com/qiniu/logkit/sender"
+	"github.com/qiniu/logkit/utils"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const (
+	DefaultDebugDuration = 10 * time.Minute
+	// MaxDebugDuration 临时打开 debug 日志的最长时间，避免忘记关闭导致日志量一直很大
+	MaxDebugDuration = 24 * time.Hour
+
+	DefaultCaptureLines = 100
+	MaxCaptureLines     = 10000
+)
+
+// Debuggable 表示 runner 可以临时提升自身的日志级别，并抓取原始数据与解析结果用于排查问题
+type Debuggable interface {
+	SetDebug(duration time.Duration) time.Time
+	StartCapture(lines int) error
+	GetCapture() (*DebugCapture, error)
+	CapturedRequests() []SenderRequests
+	ReplayRequest(senderIndex int, id int64) (*sender.CapturedRequest, error)
+}
+
+// DebugResult 打开 debug 日志的结果，DebugUntil 之后自动恢复，为零值表示已经恢复
+type DebugResult struct {
+	DebugUntil time.Time `json:"debug_until"`
+}
+
+// SenderRequests sender 记录的最近发送的请求，Index 为 sender 在配置中的序号
+type SenderRequests struct {
+	Index    int                      `json:"index"`
+	Name     string                   `json:"name"`
+	Requests []sender.CapturedRequest `json:"requests"`
+}
+
+// DebugCapture 抓取到的原始数据以及对应的解析结果，原始数据达到 Lines 条后停止抓取，
+// 解析结果按批次记录，最后一批的解析结果可能包含超出 Lines 部分的原始数据
+type DebugCapture struct {
+	RunnerName  string    `json:"runner_name"`
+	Lines       int       `json:"lines"`
+	StartedAt   time.Time `json:"started_at"`
+	FinishedAt  time.Time `json:"finished_at"`
+	Finished    bool      `json:"finished"`
+	Raw         []string  `json:"-"`
+	Parsed      []Data    `json:"-"`
+	ParseErrors []string  `json:"parse_errors,omitempty"`
+}
+
+// Bundle 将抓取结果打包为 tar.gz，包含 capture.json、raw.log 和 parsed.json
+func (dc *DebugCapture) Bundle() ([]byte, error) {
+	info, err := json.MarshalIndent(dc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	var raw bytes.Buffer
+	for _, line := range dc.Raw {
+		raw.WriteString(line)
+		if !strings.HasSuffix(line, "\n") {
+			raw.WriteByte('\n')
+		}
+	}
+	parsed := dc.Parsed
+	if parsed == nil {
+		parsed = []Data{}
+	}
+	parsedBytes, err := json.MarshalIndent(parsed, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	files := []struct {
+		name string
+		body []byte
+	}{
+		{"capture.json", info},
+		{"raw.log", raw.Bytes()},
+		{"parsed.json", parsedBytes},
+	}
+	now := time.Now()
+	for _, f := range files {
+		hdr := &tar.Header{Name: f.name, Mode: 0644, Size: int64(len(f.body)), ModTime: now}
+		if err = tw.WriteHeader(hdr); err != nil {
+			return nil, err
+		}
+		if _, err = tw.Write(f.body); err != nil {
+			return nil, err
+		}
+	}
+	if err = tw.Close(); err != nil {
+		return nil, err
+	}
+	if err = gw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// runnerDebug runner 的临时调试状态，debug 日志到期后自动恢复
+type runnerDebug struct {
+	name    string
+	mutex   sync.Mutex
+	until   time.Time
+	capture *DebugCapture
+}
+
+func newRunnerDebug(name string) *runnerDebug {
+	return &runnerDebug{name: name}
+}
+
+// enable 打开 debug 日志 duration 时间，duration 为 0 时立即恢复，返回 debug 日志的截止时间
+func (d *runnerDebug) enable(duration time.Duration) time.Time {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if duration <= 0 {
+		d.until = time.Time{}
+		log.Infof("Runner[%v] debug logging is disabled", d.name)
+		return d.until
+	}
+	d.until = time.Now().Add(duration)
+	log.Infof("Runner[%v] debug logging is enabled until %v", d.name, d.until.Format(time.RFC3339))
+	return d.until
+}
+
+func (d *runnerDebug) enabled() bool {
+	if d == nil {
+		return false
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return !d.until.IsZero() && time.Now().Before(d.until)
+}
+
+// debugf debug 日志打开时使用 info 级别输出，否则与 log.Debugf 相同
+func (d *runnerDebug) debugf(format string, v ...interface{}) {
+	if d.enabled() {
+		log.Infof("[DEBUG] "+format, v...)
+		return
+	}
+	log.Debugf(format, v...)
+}
+
+func (d *runnerDebug) startCapture(lines int) error {
+	if lines <= 0 || lines > MaxCaptureLines {
+		return fmt.Errorf("capture lines %d must be in (0, %d]", lines, MaxCaptureLines)
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.capture != nil && !d.capture.Finished {
+		return errors.New("another capture is in progress")
+	}
+	d.capture = &DebugCapture{
+		RunnerName: d.name,
+		Lines:      lines,
+		StartedAt:  time.Now(),
+	}
+	log.Infof("Runner[%v] start to capture next %d lines", d.name, lines)
+	return nil
+}
+
+func (d *runnerDebug) capturing() bool {
+	if d == nil {
+		return false
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.capture != nil && !d.capture.Finished
+}
+
+// record 记录一批原始数据与解析结果
+func (d *runnerDebug) record(lines []string, datas []Data, parseErr error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	dc := d.capture
+	if dc == nil || dc.Finished {
+		return
+	}
+	if left := dc.Lines - len(dc.Raw); len(lines) > left {
+		lines = lines[:left]
+	}
+	dc.Raw = append(dc.Raw, lines...)
+	if len(datas) > 0 {
+		// 解析结果之后还会被 transformer 修改，需要复制一份
+		var copied []Data
+		utils.DeepCopyByJSON(&copied, &datas)
+		dc.Parsed = append(dc.Parsed, copied...)
+	}
+	if parseErr != nil {
+		dc.ParseErrors = append(dc.ParseErrors, parseErr.Error())
+	}
+	// 不使用 parser 的 reader 没有原始数据，按解析结果的条数计算
+	if len(dc.Raw) >= dc.Lines || (len(dc.Raw) == 0 && len(dc.Parsed) >= dc.Lines) {
+		dc.Finished = true
+		dc.FinishedAt = time.Now()
+		log.Infof("Runner[%v] capture of %d lines is finished", d.name, dc.Lines)
+	}
+}
+
+// getCapture 返回当前抓取结果的副本，抓取还未结束时返回已经抓取到的部分
+func (d *runnerDebug) getCapture() (*DebugCapture, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.capture == nil {
+		return nil, errors.New("no capture was started")
+	}
+	dc := *d.capture
+	dc.Raw = append([]string(nil), d.capture.Raw...)
+	dc.Parsed = append([]Data(nil), d.capture.Parsed...)
+	dc.ParseErrors = append([]string(nil), d.capture.ParseErrors...)
+	return &dc, nil
+}
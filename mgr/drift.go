@@ -0,0 +1,342 @@
+package mgr
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/json-iterator/go"
+
+	"github.com/qiniu/log"
+
+	config "github.com/qiniu/logkit/conf"
+	senderConf "github.com/qiniu/logkit/sender/config"
+	"github.com/qiniu/logkit/utils"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+// 配置偏差的类型
+const (
+	// DriftModified 配置文件被修改，修改后的配置没有生效
+	DriftModified = "modified"
+	// DriftNotApplied 配置文件没有对应的 runner，加载或者启动失败
+	DriftNotApplied = "not_applied"
+	// DriftRemoved 配置文件已经删除，runner 仍然存在
+	DriftRemoved = "removed"
+	// DriftInvalid 配置文件无法解析
+	DriftInvalid = "invalid"
+)
+
+// driftIgnoredFields 运行时才会设置或者修改的字段，不参与比较
+var driftIgnoredFields = []string{"createtime", "web_folder", "is_stopped", "from_server"}
+
+// DriftConfig 定期比较正在运行的 runner 与配置目录(confs_path 以及 rest_dir)中声明的配置
+type DriftConfig struct {
+	// CheckInterval 检查的间隔，如 1m，为空时不检查
+	CheckInterval string `json:"check_interval,omitempty"`
+	// AutoReconcile 为 true 时按照声明的配置重新加载、添加或者移除发生偏差的 runner，
+	// 同一个版本的配置文件只会自动处理一次，处理后仍然存在的偏差需要人工介入
+	AutoReconcile bool `json:"auto_reconcile,omitempty"`
+}
+
+// ConfigDrift 正在运行的 runner 与配置文件中声明的配置之间的偏差
+type ConfigDrift struct {
+	RunnerName string `json:"runnerName,omitempty"`
+	ConfPath   string `json:"confPath"`
+	Kind       string `json:"kind"`
+	// Fields 与声明的配置不同的配置项，如 reader、senders
+	Fields []string `json:"fields,omitempty"`
+	Error  string   `json:"error,omitempty"`
+	// DetectedAt 第一次发现该偏差的时间
+	DetectedAt string `json:"detectedAt"`
+	// ReconciledAt 最近一次自动处理该偏差的时间
+	ReconciledAt string `json:"reconciledAt,omitempty"`
+}
+
+type driftDetector struct {
+	interval      time.Duration
+	autoReconcile bool
+	stop          chan struct{}
+
+	mux        sync.RWMutex
+	drifts     map[string]ConfigDrift // 配置文件路径 -> 最近一次检查发现的偏差
+	reconciled map[string]string      // 配置文件路径 -> 已经自动处理过的配置文件版本
+}
+
+func newDriftDetector(c DriftConfig) (*driftDetector, error) {
+	interval, err := time.ParseDuration(c.CheckInterval)
+	if err != nil {
+		return nil, fmt.Errorf("drift check_interval %v", err)
+	}
+	if interval <= 0 {
+		return nil, fmt.Errorf("drift check_interval %v must be positive", c.CheckInterval)
+	}
+	return &driftDetector{
+		interval:      interval,
+		autoReconcile: c.AutoReconcile,
+		stop:          make(chan struct{}),
+		drifts:        make(map[string]ConfigDrift),
+		reconciled:    make(map[string]string),
+	}, nil
+}
+
+func (d *driftDetector) get(confPath string) (ConfigDrift, bool) {
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+	drift, ok := d.drifts[confPath]
+	return drift, ok
+}
+
+// normalizeConfig 去掉运行时才会设置的字段以及 token 等鉴权信息，转换为通用的 map 用于比较
+func normalizeConfig(c RunnerConfig) (map[string]interface{}, error) {
+	var cp RunnerConfig
+	utils.DeepCopyByJSON(&cp, &c)
+	cp = TrimSecretInfo(cp, false)
+	for _, sc := range cp.SendersConfig {
+		delete(sc, senderConf.InnerUserAgent)
+	}
+	data, err := jsoniter.Marshal(cp)
+	if err != nil {
+		return nil, err
+	}
+	var ret map[string]interface{}
+	if err = jsoniter.Unmarshal(data, &ret); err != nil {
+		return nil, err
+	}
+	for _, field := range driftIgnoredFields {
+		delete(ret, field)
+	}
+	return ret, nil
+}
+
+// diffFields 返回两份配置中不同的顶层配置项
+func diffFields(declared, running map[string]interface{}) []string {
+	var fields []string
+	for k, v := range declared {
+		if !reflect.DeepEqual(v, running[k]) {
+			fields = append(fields, k)
+		}
+	}
+	for k := range running {
+		if _, ok := declared[k]; !ok {
+			fields = append(fields, k)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// declaredDirs 返回声明配置的目录，开启 ServerBackup 时 rest_dir 中没有配置文件，不作为声明的来源
+func (m *Manager) declaredDirs() []string {
+	m.watcherMux.RLock()
+	patterns := append([]string(nil), m.confsPaths...)
+	m.watcherMux.RUnlock()
+	var dirs []string
+	for _, pattern := range patterns {
+		paths, err := filepath.Glob(pattern)
+		if err != nil {
+			log.Errorf("filepath.Glob(%s) error: %v", pattern, err)
+			continue
+		}
+		dirs = append(dirs, paths...)
+	}
+	if !m.ServerBackup {
+		dirs = append(dirs, m.RestDir)
+	}
+	return dirs
+}
+
+// declaredConfigs 读取配置目录中声明的所有配置，key 与 runnerConfigs 一致为配置文件的真实路径，
+// 无法解析的配置文件记录在 errs 中，dirs 为读取过的目录
+func (m *Manager) declaredConfigs() (dirs map[string]bool, confs map[string]RunnerConfig, errs map[string]error) {
+	dirs = make(map[string]bool)
+	confs = make(map[string]RunnerConfig)
+	errs = make(map[string]error)
+	for _, dir := range m.declaredDirs() {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log.Errorf("ioutil.ReadDir(%s) error: %v", dir, err)
+			}
+			continue
+		}
+		dirs[dir] = true
+		if realDir, _, err := GetRealPath(dir); err == nil {
+			dirs[realDir] = true
+		}
+		for _, f := range files {
+			if f.IsDir() || !strings.HasSuffix(f.Name(), ".conf") {
+				continue
+			}
+			confPath, _, err := GetRealPath(filepath.Join(dir, f.Name()))
+			if err != nil {
+				continue
+			}
+			var conf RunnerConfig
+			if err = config.LoadEx(&conf, confPath); err != nil {
+				errs[confPath] = err
+				continue
+			}
+			confs[confPath] = conf
+		}
+	}
+	return dirs, confs, errs
+}
+
+// detectDrifts 比较正在运行的 runner 与配置文件中声明的配置，返回所有的偏差
+func (m *Manager) detectDrifts() []ConfigDrift {
+	dirs, declared, errs := m.declaredConfigs()
+
+	running := make(map[string]map[string]interface{})
+	names := make(map[string]string)
+	m.runnerLock.RLock()
+	for confPath, conf := range m.runnerConfigs {
+		names[confPath] = conf.RunnerName
+		if _, ok := declared[confPath]; !ok {
+			continue
+		}
+		normalized, err := normalizeConfig(conf)
+		if err != nil {
+			log.Errorf("Runner[%v] normalize running config error: %v", conf.RunnerName, err)
+			continue
+		}
+		running[confPath] = normalized
+	}
+	m.runnerLock.RUnlock()
+
+	var drifts []ConfigDrift
+	for confPath, conf := range declared {
+		name, ok := names[confPath]
+		if !ok {
+			drifts = append(drifts, ConfigDrift{RunnerName: conf.RunnerName, ConfPath: confPath, Kind: DriftNotApplied})
+			continue
+		}
+		current, ok := running[confPath]
+		if !ok {
+			continue
+		}
+		normalized, err := normalizeConfig(conf)
+		if err != nil {
+			log.Errorf("Runner[%v] normalize declared config %s error: %v", conf.RunnerName, confPath, err)
+			continue
+		}
+		if fields := diffFields(normalized, current); len(fields) > 0 {
+			drifts = append(drifts, ConfigDrift{RunnerName: name, ConfPath: confPath, Kind: DriftModified, Fields: fields})
+		}
+	}
+	for confPath, err := range errs {
+		drifts = append(drifts, ConfigDrift{RunnerName: names[confPath], ConfPath: confPath, Kind: DriftInvalid, Error: err.Error()})
+	}
+	for confPath, name := range names {
+		if _, ok := declared[confPath]; ok {
+			continue
+		}
+		if _, ok := errs[confPath]; ok || !dirs[filepath.Dir(confPath)] {
+			continue
+		}
+		if _, err := os.Stat(confPath); os.IsNotExist(err) {
+			drifts = append(drifts, ConfigDrift{RunnerName: name, ConfPath: confPath, Kind: DriftRemoved})
+		}
+	}
+	sort.Slice(drifts, func(i, j int) bool { return drifts[i].ConfPath < drifts[j].ConfPath })
+	return drifts
+}
+
+// checkDrift 检查并记录配置偏差，开启 auto_reconcile 时自动处理
+func (m *Manager) checkDrift(now time.Time) {
+	d := m.drift
+	drifts := m.detectDrifts()
+	current := make(map[string]ConfigDrift, len(drifts))
+	d.mux.Lock()
+	for _, drift := range drifts {
+		if prev, ok := d.drifts[drift.ConfPath]; ok && prev.Kind == drift.Kind {
+			drift.DetectedAt = prev.DetectedAt
+			drift.ReconciledAt = prev.ReconciledAt
+		} else {
+			drift.DetectedAt = now.Format(time.RFC3339)
+			log.Warnf("Runner[%v] config %s drifted from running state: %s %s", drift.RunnerName, drift.ConfPath, drift.Kind, strings.Join(drift.Fields, ","))
+		}
+		current[drift.ConfPath] = drift
+	}
+	d.drifts = current
+	d.mux.Unlock()
+
+	if !d.autoReconcile {
+		return
+	}
+	for _, drift := range drifts {
+		if m.reconcile(drift) {
+			d.mux.Lock()
+			if dr, ok := d.drifts[drift.ConfPath]; ok {
+				dr.ReconciledAt = now.Format(time.RFC3339)
+				d.drifts[drift.ConfPath] = dr
+			}
+			d.mux.Unlock()
+		}
+	}
+}
+
+// reconcile 按照声明的配置处理偏差，同一个版本的配置文件只处理一次，返回是否进行了处理
+func (m *Manager) reconcile(drift ConfigDrift) bool {
+	if drift.Kind == DriftInvalid {
+		return false
+	}
+	version := drift.Kind
+	if fi, err := os.Stat(drift.ConfPath); err == nil {
+		version = fmt.Sprintf("%s/%d/%d", drift.Kind, fi.ModTime().UnixNano(), fi.Size())
+	}
+	d := m.drift
+	d.mux.Lock()
+	if d.reconciled[drift.ConfPath] == version {
+		d.mux.Unlock()
+		return false
+	}
+	d.reconciled[drift.ConfPath] = version
+	d.mux.Unlock()
+
+	log.Infof("Runner[%v] reconcile %s config %s", drift.RunnerName, drift.Kind, drift.ConfPath)
+	switch drift.Kind {
+	case DriftModified:
+		m.Remove(drift.ConfPath)
+		m.Add(drift.ConfPath)
+	case DriftNotApplied:
+		m.Add(drift.ConfPath)
+	case DriftRemoved:
+		m.Remove(drift.ConfPath)
+	}
+	return true
+}
+
+func (m *Manager) runDriftCheck() {
+	ticker := time.NewTicker(m.drift.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.drift.stop:
+			return
+		case now := <-ticker.C:
+			m.checkDrift(now)
+		}
+	}
+}
+
+// ConfigDrifts 返回最近一次检查发现的所有配置偏差，没有开启检查时返回空
+func (m *Manager) ConfigDrifts() []ConfigDrift {
+	drifts := []ConfigDrift{}
+	if m.drift == nil {
+		return drifts
+	}
+	m.drift.mux.RLock()
+	for _, drift := range m.drift.drifts {
+		drifts = append(drifts, drift)
+	}
+	m.drift.mux.RUnlock()
+	sort.Slice(drifts, func(i, j int) bool { return drifts[i].ConfPath < drifts[j].ConfPath })
+	return drifts
+}
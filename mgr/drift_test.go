@@ -0,0 +1,132 @@
+package mgr
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/json-iterator/go"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/qiniu/logkit/conf"
+	senderConf "github.com/qiniu/logkit/sender/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+func TestNewDriftDetector(t *testing.T) {
+	d, err := newDriftDetector(DriftConfig{CheckInterval: "1m", AutoReconcile: true})
+	assert.NoError(t, err)
+	assert.Equal(t, time.Minute, d.interval)
+	assert.True(t, d.autoReconcile)
+
+	for _, interval := range []string{"abc", "0s", "-1m"} {
+		_, err = newDriftDetector(DriftConfig{CheckInterval: interval})
+		assert.Error(t, err, interval)
+	}
+}
+
+func TestDetectDrifts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestDetectDrifts")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	dir, _, err = GetRealPath(dir)
+	assert.NoError(t, err)
+
+	newConf := func(name, logPath string) RunnerConfig {
+		return RunnerConfig{
+			RunnerInfo:    RunnerInfo{RunnerName: name},
+			ReaderConfig:  conf.MapConf{"mode": "tailx", "log_path": logPath},
+			ParserConf:    conf.MapConf{"type": "raw"},
+			SendersConfig: []conf.MapConf{{"sender_type": "discard"}},
+		}
+	}
+	write := func(name string, c RunnerConfig) string {
+		data, err := jsoniter.Marshal(c)
+		assert.NoError(t, err)
+		path := filepath.Join(dir, name+".conf")
+		assert.NoError(t, ioutil.WriteFile(path, data, 0644))
+		return path
+	}
+
+	drift, err := newDriftDetector(DriftConfig{CheckInterval: "1m"})
+	assert.NoError(t, err)
+	m := &Manager{
+		ManagerConfig: ManagerConfig{ServerBackup: true},
+		watcherMux:    new(sync.RWMutex),
+		confsPaths:    []string{dir},
+		runners:       map[string]Runner{},
+		runnerConfigs: map[string]RunnerConfig{},
+		runnerPaths:   map[string]string{},
+		drift:         drift,
+	}
+	run := func(path string, c RunnerConfig) {
+		m.runnerConfigs[path] = c
+		m.runnerPaths[c.RunnerName] = path
+		m.runners[path] = &drainRunner{name: c.RunnerName}
+	}
+
+	// 运行时设置的字段以及 token 不算偏差
+	same := write("same", newConf("same", "/var/log/same/*.log"))
+	running := newConf("same", "/var/log/same/*.log")
+	running.CreateTime = time.Now().Format(time.RFC3339Nano)
+	running.SendersConfig[0][senderConf.InnerUserAgent] = "logkit/test"
+	running.SendersConfig[0][SchemaFreeTokensPrefix+"pipeline_get_repo_token"] = "token"
+	run(same, running)
+
+	modified := write("modified", newConf("modified", "/var/log/new/*.log"))
+	run(modified, newConf("modified", "/var/log/old/*.log"))
+
+	notApplied := write("notapplied", newConf("notapplied", "/var/log/a/*.log"))
+
+	removed := filepath.Join(dir, "removed.conf")
+	run(removed, newConf("removed", "/var/log/b/*.log"))
+
+	invalid := filepath.Join(dir, "invalid.conf")
+	assert.NoError(t, ioutil.WriteFile(invalid, []byte("{"), 0644))
+	// 不在配置目录中的 runner 不检查
+	run("/tmp/TestDetectDrifts/other.conf", newConf("other", "/var/log/c/*.log"))
+
+	m.checkDrift(time.Now())
+	drifts := m.ConfigDrifts()
+	if !assert.Len(t, drifts, 4) {
+		return
+	}
+	assert.Equal(t, invalid, drifts[0].ConfPath)
+	assert.Equal(t, DriftInvalid, drifts[0].Kind)
+	assert.NotEmpty(t, drifts[0].Error)
+	assert.Equal(t, modified, drifts[1].ConfPath)
+	assert.Equal(t, DriftModified, drifts[1].Kind)
+	assert.Equal(t, []string{"reader"}, drifts[1].Fields)
+	assert.Equal(t, notApplied, drifts[2].ConfPath)
+	assert.Equal(t, DriftNotApplied, drifts[2].Kind)
+	assert.Equal(t, "notapplied", drifts[2].RunnerName)
+	assert.Equal(t, removed, drifts[3].ConfPath)
+	assert.Equal(t, DriftRemoved, drifts[3].Kind)
+
+	status := m.Status()
+	assert.Nil(t, status["same"].ConfigDrift)
+	if assert.NotNil(t, status["modified"].ConfigDrift) {
+		assert.Equal(t, DriftModified, status["modified"].ConfigDrift.Kind)
+	}
+	detectedAt := drifts[1].DetectedAt
+
+	// 按声明的配置移除已经删除配置文件的 runner，同一个版本只处理一次
+	assert.True(t, m.reconcile(drifts[3]))
+	assert.False(t, m.reconcile(drifts[3]))
+	_, ok := m.runners[removed]
+	assert.False(t, ok)
+	assert.False(t, m.reconcile(drifts[0]))
+
+	m.checkDrift(time.Now().Add(time.Minute))
+	drifts = m.ConfigDrifts()
+	assert.Len(t, drifts, 3)
+	assert.Equal(t, detectedAt, drifts[1].DetectedAt)
+
+	// 配置文件恢复一致后不再报告偏差
+	write("modified", newConf("modified", "/var/log/old/*.log"))
+	m.checkDrift(time.Now())
+	assert.Len(t, m.ConfigDrifts(), 2)
+}
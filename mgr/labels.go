@@ -0,0 +1,197 @@
+package mgr
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	BulkStart  = "start"
+	BulkStop   = "stop"
+	BulkDelete = "delete"
+	BulkDrain  = "drain"
+
+	DefaultDrainTimeout = 30 * time.Second
+)
+
+var (
+	labelKeyRegex   = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9_./-]*[A-Za-z0-9])?$`)
+	labelValueRegex = regexp.MustCompile(`^[A-Za-z0-9_.-]*$`)
+)
+
+// validateLabels 检查 runner 的标签，key 由字母、数字以及 _./- 组成，value 由字母、数字以及 _.- 组成
+func validateLabels(labels map[string]string) error {
+	for k, v := range labels {
+		if !labelKeyRegex.MatchString(k) {
+			return fmt.Errorf("invalid label key %q", k)
+		}
+		if !labelValueRegex.MatchString(v) {
+			return fmt.Errorf("invalid value %q of label %q", v, k)
+		}
+	}
+	return nil
+}
+
+const (
+	selectEqual     = "="
+	selectNotEqual  = "!="
+	selectExists    = "exists"
+	selectNotExists = "!exists"
+)
+
+type labelRequirement struct {
+	key   string
+	op    string
+	value string
+}
+
+func (r labelRequirement) matches(labels map[string]string) bool {
+	value, ok := labels[r.key]
+	switch r.op {
+	case selectEqual:
+		return ok && value == r.value
+	case selectNotEqual:
+		return !ok || value != r.value
+	case selectExists:
+		return ok
+	case selectNotExists:
+		return !ok
+	}
+	return false
+}
+
+// LabelSelector 由逗号分隔的多个条件组成，所有条件都满足时匹配，支持 key=value、key!=value、key（存在）以及 !key（不存在）
+type LabelSelector []labelRequirement
+
+// ParseLabelSelector 解析标签选择器，如 "app=nginx,env!=test"
+func ParseLabelSelector(selector string) (LabelSelector, error) {
+	var s LabelSelector
+	for _, item := range strings.Split(selector, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		var r labelRequirement
+		switch {
+		case strings.Contains(item, selectNotEqual):
+			kv := strings.SplitN(item, selectNotEqual, 2)
+			r = labelRequirement{key: strings.TrimSpace(kv[0]), op: selectNotEqual, value: strings.TrimSpace(kv[1])}
+		case strings.Contains(item, selectEqual):
+			kv := strings.SplitN(item, selectEqual, 2)
+			r = labelRequirement{key: strings.TrimSpace(kv[0]), op: selectEqual, value: strings.TrimSpace(kv[1])}
+		case strings.HasPrefix(item, "!"):
+			r = labelRequirement{key: strings.TrimSpace(item[1:]), op: selectNotExists}
+		default:
+			r = labelRequirement{key: item, op: selectExists}
+		}
+		if !labelKeyRegex.MatchString(r.key) {
+			return nil, fmt.Errorf("invalid label selector %q: invalid key %q", item, r.key)
+		}
+		if !labelValueRegex.MatchString(r.value) {
+			return nil, fmt.Errorf("invalid label selector %q: invalid value %q", item, r.value)
+		}
+		s = append(s, r)
+	}
+	if len(s) == 0 {
+		return nil, errors.New("label selector is empty")
+	}
+	return s, nil
+}
+
+// Matches 判断标签是否满足所有条件
+func (s LabelSelector) Matches(labels map[string]string) bool {
+	for _, r := range s {
+		if !r.matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// BulkResult 批量操作中一个 runner 的结果，Skipped 表示 runner 已经处于目标状态
+type BulkResult struct {
+	Runner  string `json:"runner"`
+	Skipped bool   `json:"skipped,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// SelectRunners 返回标签满足 selector 的 runner 名称以及是否已经停止，按名称排序
+func (m *Manager) SelectRunners(selector LabelSelector) (names []string, stopped map[string]bool) {
+	stopped = make(map[string]bool)
+	m.runnerLock.RLock()
+	for _, conf := range m.runnerConfigs {
+		if selector.Matches(conf.Labels) {
+			names = append(names, conf.RunnerName)
+			stopped[conf.RunnerName] = conf.IsStopped
+		}
+	}
+	m.runnerLock.RUnlock()
+	sort.Strings(names)
+	return names, stopped
+}
+
+// DrainRunner 停止读取新的数据，等待已经读取的数据发送完毕后停止 runner，不支持排空的 runner 直接停止
+func (m *Manager) DrainRunner(name string, timeout time.Duration) error {
+	filename, conf, err := m.getDeepCopyConfig(name)
+	if err != nil {
+		return err
+	}
+	if conf.IsStopped {
+		return fmt.Errorf("runner %v has already stopped", filename)
+	}
+	// 排空可能需要较长时间，不能持有 runnerLock，排空之后 StopRunner 中再次调用的 Stop 直接返回
+	if r, ok := m.readRunners(filename); ok {
+		if dr, ok := r.(Drainable); ok {
+			dr.Drain(timeout)
+		}
+	}
+	return m.StopRunner(name)
+}
+
+// BulkOperate 对标签满足 selector 的所有 runner 并发执行 op 操作，drainTimeout 只在 op 为 drain 时生效
+func (m *Manager) BulkOperate(op string, selector LabelSelector, drainTimeout time.Duration) ([]BulkResult, error) {
+	var do func(name string) error
+	// skip 判断 runner 是否已经处于操作的目标状态
+	var skip func(stopped bool) bool
+	switch op {
+	case BulkStart:
+		do = m.StartRunner
+		skip = func(stopped bool) bool { return !stopped }
+	case BulkStop:
+		do = m.StopRunner
+		skip = func(stopped bool) bool { return stopped }
+	case BulkDrain:
+		do = func(name string) error { return m.DrainRunner(name, drainTimeout) }
+		skip = func(stopped bool) bool { return stopped }
+	case BulkDelete:
+		do = m.DeleteRunner
+		skip = func(bool) bool { return false }
+	default:
+		return nil, fmt.Errorf("unknown bulk operation %q", op)
+	}
+
+	names, stopped := m.SelectRunners(selector)
+	results := make([]BulkResult, len(names))
+	wg := new(sync.WaitGroup)
+	for i, name := range names {
+		results[i].Runner = name
+		if skip(stopped[name]) {
+			results[i].Skipped = true
+			continue
+		}
+		wg.Add(1)
+		go func(result *BulkResult) {
+			defer wg.Done()
+			if err := do(result.Runner); err != nil {
+				result.Error = err.Error()
+			}
+		}(&results[i])
+	}
+	wg.Wait()
+	return results, nil
+}
@@ -0,0 +1,170 @@
+package mgr
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/qiniu/logkit/sender"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+type drainRunner struct {
+	name    string
+	mutex   sync.Mutex
+	drained time.Duration
+	stops   int
+}
+
+func (r *drainRunner) Name() string       { return r.name }
+func (r *drainRunner) Run()               {}
+func (r *drainRunner) Cleaner() CleanInfo { return CleanInfo{} }
+func (r *drainRunner) Status() RunnerStatus {
+	return RunnerStatus{Name: r.name, RunningStatus: RunnerRunning}
+}
+func (r *drainRunner) Stop() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.stops++
+}
+func (r *drainRunner) Drain(timeout time.Duration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.drained = timeout
+}
+
+type queueSender struct {
+	depth int64
+}
+
+func (s *queueSender) Name() string      { return "queueSender" }
+func (s *queueSender) Send([]Data) error { return nil }
+func (s *queueSender) Close() error      { return nil }
+func (s *queueSender) QueueDepth() int64 {
+	if atomic.LoadInt64(&s.depth) > 0 {
+		return atomic.AddInt64(&s.depth, -1)
+	}
+	return 0
+}
+
+type flushSender struct {
+	queueSender
+	err error
+}
+
+func (s *flushSender) Flush(timeout time.Duration) error { return s.err }
+
+func TestParseLabelSelector(t *testing.T) {
+	selector, err := ParseLabelSelector("app=nginx, env!=test,owner,!deprecated")
+	assert.NoError(t, err)
+	assert.Len(t, selector, 4)
+	assert.True(t, selector.Matches(map[string]string{"app": "nginx", "env": "prod", "owner": ""}))
+	assert.True(t, selector.Matches(map[string]string{"app": "nginx", "owner": "ops"}))
+	assert.False(t, selector.Matches(map[string]string{"app": "nginx", "env": "test", "owner": "ops"}))
+	assert.False(t, selector.Matches(map[string]string{"app": "nginx", "owner": "ops", "deprecated": "true"}))
+	assert.False(t, selector.Matches(map[string]string{"app": "redis", "owner": "ops"}))
+	assert.False(t, selector.Matches(nil))
+
+	for _, s := range []string{"", " , ", "app=a=b", "=nginx", "a b=c", "!"} {
+		_, err = ParseLabelSelector(s)
+		assert.Error(t, err, s)
+	}
+
+	assert.NoError(t, validateLabels(map[string]string{"app": "nginx", "k8s.io/name": "web-1", "empty": ""}))
+	assert.Error(t, validateLabels(map[string]string{"app": "a,b"}))
+	assert.Error(t, validateLabels(map[string]string{"-app": "nginx"}))
+}
+
+func TestBulkOperate(t *testing.T) {
+	m := &Manager{
+		ManagerConfig: ManagerConfig{ServerBackup: true},
+		runners:       map[string]Runner{},
+		runnerConfigs: map[string]RunnerConfig{},
+		runnerPaths:   map[string]string{},
+	}
+	add := func(name string, labels map[string]string, stopped bool) *drainRunner {
+		confPath := "/tmp/TestBulkOperate/" + name + ".conf"
+		m.runnerConfigs[confPath] = RunnerConfig{RunnerInfo: RunnerInfo{RunnerName: name, Labels: labels}, IsStopped: stopped}
+		m.runnerPaths[name] = confPath
+		if stopped {
+			return nil
+		}
+		r := &drainRunner{name: name}
+		m.runners[confPath] = r
+		return r
+	}
+	nginx1 := add("nginx1", map[string]string{"app": "nginx"}, false)
+	nginx2 := add("nginx2", map[string]string{"app": "nginx", "env": "test"}, false)
+	add("nginx3", map[string]string{"app": "nginx"}, true)
+	redis := add("redis", map[string]string{"app": "redis"}, false)
+
+	selector, err := ParseLabelSelector("app=nginx")
+	assert.NoError(t, err)
+	names, _ := m.SelectRunners(selector)
+	assert.Equal(t, []string{"nginx1", "nginx2", "nginx3"}, names)
+
+	_, err = m.BulkOperate("restart", selector, time.Second)
+	assert.Error(t, err)
+
+	results, err := m.BulkOperate(BulkDrain, selector, time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, []BulkResult{{Runner: "nginx1"}, {Runner: "nginx2"}, {Runner: "nginx3", Skipped: true}}, results)
+	for _, r := range []*drainRunner{nginx1, nginx2} {
+		assert.Equal(t, time.Second, r.drained)
+		assert.Equal(t, 1, r.stops)
+	}
+	assert.Equal(t, time.Duration(0), redis.drained)
+	assert.Equal(t, 0, redis.stops)
+	for _, name := range []string{"nginx1", "nginx2"} {
+		assert.True(t, m.runnerConfigs[m.runnerPaths[name]].IsStopped)
+	}
+	assert.False(t, m.runnerConfigs[m.runnerPaths["redis"]].IsStopped)
+	assert.Len(t, m.runners, 1)
+
+	// 已经停止的 runner 被跳过
+	selector, err = ParseLabelSelector("app=nginx,env=test")
+	assert.NoError(t, err)
+	results, err = m.BulkOperate(BulkStop, selector, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []BulkResult{{Runner: "nginx2", Skipped: true}}, results)
+}
+
+func TestWaitQueueDrained(t *testing.T) {
+	s := &queueSender{depth: 3}
+	r := &LogExportRunner{RunnerInfo: RunnerInfo{RunnerName: "TestWaitQueueDrained"}, senders: []sender.Sender{s}}
+	start := time.Now()
+	r.waitQueueDrained(5 * time.Second)
+	assert.Equal(t, int64(0), atomic.LoadInt64(&s.depth))
+	assert.True(t, time.Since(start) < 5*time.Second)
+
+	// 超时后放弃等待
+	s.depth = 1000
+	start = time.Now()
+	r.waitQueueDrained(200 * time.Millisecond)
+	assert.True(t, time.Since(start) >= 200*time.Millisecond)
+	assert.True(t, atomic.LoadInt64(&s.depth) > 0)
+}
+
+func TestFlushSenders(t *testing.T) {
+	r := &LogExportRunner{RunnerInfo: RunnerInfo{RunnerName: "TestFlushSenders"}, senders: []sender.Sender{
+		&queueSender{},
+		&flushSender{},
+		&flushSender{err: errors.New("2 batches are still pending after 1s")},
+	}}
+	results := r.FlushSenders(time.Second)
+	require.Len(t, results, 3)
+	assert.Equal(t, SenderFlushResult{Index: 0, Name: "queueSender", Skipped: true}, results[0])
+	assert.Equal(t, "", results[1].Error)
+	assert.False(t, results[1].Skipped)
+	assert.Equal(t, 2, results[2].Index)
+	assert.Equal(t, "2 batches are still pending after 1s", results[2].Error)
+
+	m := &Manager{runnerConfigs: map[string]RunnerConfig{}, runners: map[string]Runner{}, runnerPaths: map[string]string{}}
+	_, err := m.FlushRunner("TestFlushSenders", time.Second)
+	assert.Error(t, err)
+}
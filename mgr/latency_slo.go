@@ -0,0 +1,267 @@
+package mgr
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/qiniu/log"
+
+	"github.com/qiniu/logkit/sender"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const (
+	defaultLatencyAdjustInterval = time.Minute
+	defaultLatencyWarnDuration   = 10 * time.Minute
+	// 大于该值的数字时间戳按毫秒处理，否则按秒处理
+	unixMillisThreshold = 1e12
+)
+
+// LatencySLOConfig 端到端延迟目标，记录每批数据从事件时间到发送成功的延迟，超过目标时在 runner 状态中告警，
+// 并在配置的上限之内逐步增大批次的行数、字节数以及 sender 的发送并发数
+type LatencySLOConfig struct {
+	// MaxLatency 目标的最大延迟，如 30s，必须配置
+	MaxLatency string `json:"max_latency"`
+	// TimeField 事件时间所在的字段，可以是时间类型、RFC3339 格式的字符串或者秒、毫秒的时间戳，
+	// 为空或者数据中没有该字段时使用开始读取这批数据的时间
+	TimeField string `json:"time_field,omitempty"`
+	// MaxBatchLen 自动调整时 batch_len 的上限，每次调整翻倍，为 0 时不调整
+	MaxBatchLen int `json:"max_batch_len,omitempty"`
+	// MaxBatchSize 自动调整时 batch_size 的上限，每次调整翻倍，为 0 时不调整
+	MaxBatchSize int `json:"max_batch_size,omitempty"`
+	// MaxSenderProcs 自动调整时每个 sender 发送并发数(ft_procs)的上限，每次调整加 1，为 0 时不调整，
+	// 只对开启了 fault_tolerant 的 sender 生效
+	MaxSenderProcs int `json:"max_sender_procs,omitempty"`
+	// AdjustInterval 两次自动调整之间的最小间隔，默认为 1m
+	AdjustInterval string `json:"adjust_interval,omitempty"`
+	// WarnDuration 最近一次超过目标后状态中保留告警的时长，默认为 10m
+	WarnDuration string `json:"warn_duration,omitempty"`
+}
+
+// LatencySLOStats 端到端延迟的统计，Breached 表示 warn_duration 内延迟超过过目标
+type LatencySLOStats struct {
+	Target      string         `json:"target"`
+	LatencyMs   int64          `json:"latency_ms"`
+	PeakMs      int64          `json:"peak_ms"`
+	Breached    bool           `json:"breached"`
+	Breaches    int64          `json:"breaches"`
+	LastBreach  string         `json:"last_breach,omitempty"`
+	Adjustments int64          `json:"adjustments"`
+	BatchLen    int            `json:"batch_len,omitempty"`
+	BatchSize   int            `json:"batch_size,omitempty"`
+	SenderProcs map[string]int `json:"sender_procs,omitempty"`
+	Warning     string         `json:"warning,omitempty"`
+}
+
+func (s LatencySLOStats) clone() *LatencySLOStats {
+	dst := s
+	if s.SenderProcs != nil {
+		dst.SenderProcs = make(map[string]int, len(s.SenderProcs))
+		for k, v := range s.SenderProcs {
+			dst.SenderProcs[k] = v
+		}
+	}
+	return &dst
+}
+
+// latencySLO 记录一个 runner 的端到端延迟，Observe 只在 Run 中调用
+type latencySLO struct {
+	runnerName     string
+	target         time.Duration
+	timeField      string
+	maxBatchLen    int
+	maxBatchSize   int
+	maxSenderProcs int
+	adjustInterval time.Duration
+	warnDuration   time.Duration
+
+	lastAdjust time.Time
+
+	mutex      sync.Mutex
+	stats      LatencySLOStats
+	lastBreach time.Time
+}
+
+func newLatencySLO(runnerName string, c *LatencySLOConfig) (*latencySLO, error) {
+	if c == nil {
+		return nil, nil
+	}
+	target, err := time.ParseDuration(c.MaxLatency)
+	if err != nil || target <= 0 {
+		return nil, fmt.Errorf("runner %v latency_slo max_latency %q should be a positive duration like 30s", runnerName, c.MaxLatency)
+	}
+	if c.MaxBatchLen < 0 || c.MaxBatchSize < 0 || c.MaxSenderProcs < 0 {
+		return nil, fmt.Errorf("runner %v latency_slo max_batch_len, max_batch_size and max_sender_procs should not be negative", runnerName)
+	}
+	l := &latencySLO{
+		runnerName:     runnerName,
+		target:         target,
+		timeField:      c.TimeField,
+		maxBatchLen:    c.MaxBatchLen,
+		maxBatchSize:   c.MaxBatchSize,
+		maxSenderProcs: c.MaxSenderProcs,
+		adjustInterval: defaultLatencyAdjustInterval,
+		warnDuration:   defaultLatencyWarnDuration,
+		stats:          LatencySLOStats{Target: target.String()},
+	}
+	if c.AdjustInterval != "" {
+		if l.adjustInterval, err = time.ParseDuration(c.AdjustInterval); err != nil {
+			return nil, fmt.Errorf("runner %v parse latency_slo adjust_interval %q error, %v", runnerName, c.AdjustInterval, err)
+		}
+	}
+	if c.WarnDuration != "" {
+		if l.warnDuration, err = time.ParseDuration(c.WarnDuration); err != nil {
+			return nil, fmt.Errorf("runner %v parse latency_slo warn_duration %q error, %v", runnerName, c.WarnDuration, err)
+		}
+	}
+	return l, nil
+}
+
+// Oldest 返回一批数据中最早的事件时间，没有配置 time_field 或者数据中没有可以识别的时间时为 readTime
+func (l *latencySLO) Oldest(datas []Data, readTime time.Time) time.Time {
+	oldest := readTime
+	if l.timeField == "" {
+		return oldest
+	}
+	for _, data := range datas {
+		if t, ok := eventTime(data[l.timeField]); ok && t.Before(oldest) {
+			oldest = t
+		}
+	}
+	return oldest
+}
+
+// eventTime 将事件时间字段的值转换为时间
+func eventTime(v interface{}) (time.Time, bool) {
+	var n float64
+	switch t := v.(type) {
+	case time.Time:
+		return t, !t.IsZero()
+	case string:
+		tm, err := time.Parse(time.RFC3339Nano, t)
+		return tm, err == nil
+	case json.Number:
+		f, err := t.Float64()
+		if err != nil {
+			return time.Time{}, false
+		}
+		n = f
+	case int:
+		n = float64(t)
+	case int64:
+		n = float64(t)
+	case float64:
+		n = t
+	default:
+		return time.Time{}, false
+	}
+	if n <= 0 {
+		return time.Time{}, false
+	}
+	if n > unixMillisThreshold {
+		return time.Unix(0, int64(n*float64(time.Millisecond))), true
+	}
+	return time.Unix(0, int64(n*float64(time.Second))), true
+}
+
+// Observe 记录一批数据发送成功时的延迟，超过目标并且距离上次调整超过 adjust_interval 时返回 true
+func (l *latencySLO) Observe(oldest, sent time.Time) bool {
+	latency := sent.Sub(oldest)
+	if latency < 0 {
+		latency = 0
+	}
+	ms := int64(latency / time.Millisecond)
+	breached := latency > l.target
+
+	l.mutex.Lock()
+	l.stats.LatencyMs = ms
+	if ms > l.stats.PeakMs {
+		l.stats.PeakMs = ms
+	}
+	if breached {
+		l.stats.Breaches++
+		l.lastBreach = sent
+		l.stats.LastBreach = sent.Format(time.RFC3339)
+	}
+	l.mutex.Unlock()
+
+	if !breached || sent.Sub(l.lastAdjust) < l.adjustInterval {
+		return false
+	}
+	l.lastAdjust = sent
+	log.Warnf("Runner[%v] end-to-end latency %v exceeds the target %v", l.runnerName, latency, l.target)
+	return true
+}
+
+// Adjust 在上限之内增大批次的行数、字节数以及 sender 的发送并发数，返回调整后的批次行数和字节数
+func (l *latencySLO) Adjust(batchLen, batchSize int, senders []sender.Sender) (int, int) {
+	adjusted := false
+	// batch_len 小于等于 0 时不限制行数，不需要调整
+	if l.maxBatchLen > 0 && batchLen > 0 && batchLen < l.maxBatchLen {
+		batchLen = minInt(batchLen*2, l.maxBatchLen)
+		adjusted = true
+	}
+	if l.maxBatchSize > 0 && batchSize > 0 && batchSize < l.maxBatchSize {
+		batchSize = minInt(batchSize*2, l.maxBatchSize)
+		adjusted = true
+	}
+	procs := make(map[string]int)
+	for _, s := range senders {
+		ps, ok := s.(sender.ProcsSender)
+		if !ok {
+			continue
+		}
+		n := ps.Procs()
+		if l.maxSenderProcs > 0 && n < l.maxSenderProcs {
+			n = ps.SetProcs(n + 1)
+			adjusted = true
+		}
+		procs[s.Name()] = n
+	}
+	if adjusted {
+		log.Warnf("Runner[%v] increase batch_len to %d, batch_size to %d and sender procs to %v to meet the latency target %v",
+			l.runnerName, batchLen, batchSize, procs, l.target)
+	}
+
+	l.mutex.Lock()
+	if adjusted {
+		l.stats.Adjustments++
+	}
+	l.stats.BatchLen = batchLen
+	l.stats.BatchSize = batchSize
+	if len(procs) > 0 {
+		l.stats.SenderProcs = procs
+	}
+	l.mutex.Unlock()
+	return batchLen, batchSize
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Stats 返回延迟的统计，warn_duration 内超过过目标时设置 Breached 和 Warning
+func (l *latencySLO) Stats() *LatencySLOStats {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	stats := l.stats.clone()
+	if !l.lastBreach.IsZero() && time.Since(l.lastBreach) < l.warnDuration {
+		stats.Breached = true
+		stats.Warning = fmt.Sprintf("end-to-end latency exceeded the target %v at %v, peak latency %dms",
+			l.target, stats.LastBreach, stats.PeakMs)
+	}
+	return stats
+}
+
+// observeLatency 记录发送成功的一批数据的延迟，超过目标时调整批次大小和 sender 的发送并发数
+func (r *LogExportRunner) observeLatency(oldest time.Time) {
+	if r.latencySLO == nil || !r.latencySLO.Observe(oldest, time.Now()) {
+		return
+	}
+	r.MaxBatchLen, r.MaxBatchSize = r.latencySLO.Adjust(r.MaxBatchLen, r.MaxBatchSize, r.senders)
+}
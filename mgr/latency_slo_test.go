@@ -0,0 +1,122 @@
+package mgr
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/qiniu/logkit/sender"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+type procsSender struct {
+	sender.Sender
+	name  string
+	procs int
+}
+
+func (s *procsSender) Name() string {
+	return s.name
+}
+
+func (s *procsSender) Procs() int {
+	return s.procs
+}
+
+func (s *procsSender) SetProcs(procs int) int {
+	if procs > s.procs {
+		s.procs = procs
+	}
+	return s.procs
+}
+
+func TestEventTime(t *testing.T) {
+	now := time.Unix(1546300800, 0)
+	for _, v := range []interface{}{now, "2019-01-01T00:00:00Z", int64(1546300800), 1546300800, 1546300800000.0, json.Number("1546300800000")} {
+		tm, ok := eventTime(v)
+		assert.True(t, ok, v)
+		assert.True(t, now.Equal(tm), v)
+	}
+	for _, v := range []interface{}{nil, "", "yesterday", time.Time{}, int64(0), json.Number("x"), true} {
+		_, ok := eventTime(v)
+		assert.False(t, ok, v)
+	}
+}
+
+func TestLatencySLO(t *testing.T) {
+	l, err := newLatencySLO("test", nil)
+	assert.NoError(t, err)
+	assert.Nil(t, l)
+	for _, c := range []*LatencySLOConfig{
+		{},
+		{MaxLatency: "-1s"},
+		{MaxLatency: "1s", MaxBatchLen: -1},
+		{MaxLatency: "1s", AdjustInterval: "abc"},
+		{MaxLatency: "1s", WarnDuration: "abc"},
+	} {
+		_, err = newLatencySLO("test", c)
+		assert.Error(t, err, c)
+	}
+
+	l, err = newLatencySLO("test", &LatencySLOConfig{
+		MaxLatency:     "10s",
+		TimeField:      "time",
+		MaxBatchLen:    300,
+		MaxBatchSize:   1024,
+		MaxSenderProcs: 3,
+		AdjustInterval: "1m",
+	})
+	require.NoError(t, err)
+	read := time.Now()
+	// 没有事件时间的数据使用读取时间
+	assert.Equal(t, read, l.Oldest([]Data{{"a": 1}}, read))
+	oldest := l.Oldest([]Data{{"time": read.Add(-5 * time.Second).Format(time.RFC3339Nano)}, {"time": read.Add(-20 * time.Second)}, {"time": "x"}}, read)
+	assert.True(t, read.Add(-20*time.Second).Equal(oldest))
+
+	assert.False(t, l.Observe(read, read.Add(time.Second)))
+	stats := l.Stats()
+	assert.Equal(t, "10s", stats.Target)
+	assert.Equal(t, int64(1000), stats.LatencyMs)
+	assert.False(t, stats.Breached)
+	assert.Empty(t, stats.Warning)
+
+	// 超过目标后需要调整，adjust_interval 内不再重复调整
+	sent := read.Add(time.Second)
+	assert.True(t, l.Observe(oldest, sent))
+	assert.False(t, l.Observe(oldest, sent.Add(time.Second)))
+	ft := &procsSender{name: "ft", procs: 2}
+	senders := []sender.Sender{ft, &procsSender{name: "capped", procs: 4}}
+	batchLen, batchSize := l.Adjust(100, 1000, senders)
+	assert.Equal(t, 200, batchLen)
+	assert.Equal(t, 1024, batchSize)
+	assert.Equal(t, 3, ft.procs)
+
+	// 达到上限后不再调整
+	assert.True(t, l.Observe(oldest, sent.Add(time.Minute)))
+	batchLen, batchSize = l.Adjust(batchLen, batchSize, senders)
+	assert.Equal(t, 300, batchLen)
+	assert.Equal(t, 1024, batchSize)
+	batchLen, batchSize = l.Adjust(batchLen, batchSize, senders)
+	assert.Equal(t, 300, batchLen)
+	// batch_len 为 0 时不限制行数，不调整
+	batchLen, _ = l.Adjust(0, batchSize, senders)
+	assert.Equal(t, 0, batchLen)
+
+	stats = l.Stats()
+	assert.True(t, stats.Breached)
+	assert.NotEmpty(t, stats.Warning)
+	assert.Equal(t, int64(3), stats.Breaches)
+	assert.Equal(t, int64(2), stats.Adjustments)
+	assert.Equal(t, int64(81000), stats.PeakMs)
+	assert.Equal(t, map[string]int{"ft": 3, "capped": 4}, stats.SenderProcs)
+	// 返回的是副本
+	stats.SenderProcs["ft"] = 10
+	assert.Equal(t, 3, l.Stats().SenderProcs["ft"])
+
+	// 超过 warn_duration 后不再告警
+	l.lastBreach = time.Now().Add(-time.Hour)
+	assert.False(t, l.Stats().Breached)
+}
@@ -0,0 +1,171 @@
+package mgr
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/qiniu/log"
+
+	"github.com/qiniu/logkit/conf"
+	parserconfig "github.com/qiniu/logkit/parser/config"
+	. "github.com/qiniu/logkit/reader/config"
+	senderConf "github.com/qiniu/logkit/sender/config"
+)
+
+// 配置检查问题的编码
+const (
+	LintDeprecatedKey      = "deprecated_key"      // 使用了已经废弃的配置项
+	LintConflictingOptions = "conflicting_options" // 配置项之间互相冲突，其中一部分不会生效
+	LintSuspiciousValue    = "suspicious_value"    // 配置项的值合法，但很可能不是期望的值
+)
+
+const (
+	minStatInterval      = time.Second
+	minTailxPollInterval = 100 * time.Millisecond
+)
+
+// LintIssue 配置检查发现的问题，Section 为问题所在的配置段，如 reader、parser、senders[0]
+type LintIssue struct {
+	Code    string   `json:"code"`
+	Section string   `json:"section"`
+	Keys    []string `json:"keys"`
+	Message string   `json:"message"`
+}
+
+func (i LintIssue) String() string {
+	return fmt.Sprintf("[%s] %s %s: %s", i.Code, i.Section, strings.Join(i.Keys, ","), i.Message)
+}
+
+// ValidateResult 是 validate API 的返回结果
+type ValidateResult struct {
+	Issues []LintIssue `json:"issues"`
+}
+
+type deprecatedKey struct {
+	section     string
+	mode        string // 只检查该类型的 reader 或 parser
+	key         string
+	replacement string
+}
+
+var deprecatedKeys = []deprecatedKey{
+	{section: "reader", mode: ModeScript, key: KeyLogPath, replacement: KeyScriptContent},
+	{section: "parser", mode: parserconfig.TypeCSV, key: parserconfig.KeyCSVLabels, replacement: parserconfig.KeyLabels},
+}
+
+// LintRunnerConfig 检查 runner 配置中废弃的配置项、互相冲突的配置项以及可疑的值，不检查 runner 能否创建成功
+func LintRunnerConfig(rc RunnerConfig) []LintIssue {
+	var issues []LintIssue
+	issues = append(issues, lintDeprecated(rc)...)
+	issues = append(issues, lintReader(rc.ReaderConfig)...)
+	for i, sc := range rc.SendersConfig {
+		issues = append(issues, lintSender("senders["+strconv.Itoa(i)+"]", sc)...)
+	}
+	return issues
+}
+
+func lintDeprecated(rc RunnerConfig) []LintIssue {
+	var issues []LintIssue
+	readerMode, _ := rc.ReaderConfig.GetStringOr(KeyMode, "")
+	parserType, _ := rc.ParserConf.GetStringOr(parserconfig.KeyParserType, "")
+	for _, d := range deprecatedKeys {
+		c, mode := rc.ReaderConfig, readerMode
+		if d.section == "parser" {
+			c, mode = rc.ParserConf, parserType
+		}
+		if mode != d.mode {
+			continue
+		}
+		if _, ok := c[d.key]; !ok {
+			continue
+		}
+		issues = append(issues, LintIssue{
+			Code:    LintDeprecatedKey,
+			Section: d.section,
+			Keys:    []string{d.key},
+			Message: fmt.Sprintf("%q is deprecated, use %q instead", d.key, d.replacement),
+		})
+	}
+	return issues
+}
+
+// getDuration 返回配置项的值，没有配置或者无法解析时 ok 为 false，无法解析的情况由创建 reader 时报错
+func getDuration(c conf.MapConf, key string) (d time.Duration, ok bool) {
+	value, err := c.GetString(key)
+	if err != nil {
+		return 0, false
+	}
+	d, err = time.ParseDuration(value)
+	return d, err == nil
+}
+
+func lintReader(c conf.MapConf) []LintIssue {
+	var issues []LintIssue
+	if expire, ok := getDuration(c, KeyExpire); ok && expire == 0 {
+		if submetaExpire, ok := getDuration(c, KeySubmetaExpire); ok && submetaExpire > 0 {
+			issues = append(issues, LintIssue{
+				Code:    LintConflictingOptions,
+				Section: "reader",
+				Keys:    []string{KeyExpire, KeySubmetaExpire},
+				Message: fmt.Sprintf("%q takes no effect when %q is 0, files never expire", KeySubmetaExpire, KeyExpire),
+			})
+		}
+		if expireDelete, _ := c.GetBoolOr(KeyExpireDelete, false); expireDelete {
+			issues = append(issues, LintIssue{
+				Code:    LintConflictingOptions,
+				Section: "reader",
+				Keys:    []string{KeyExpire, KeyExpireDelete},
+				Message: fmt.Sprintf("%q takes no effect when %q is 0, files never expire", KeyExpireDelete, KeyExpire),
+			})
+		}
+	}
+	if statInterval, ok := getDuration(c, KeyStatInterval); ok && statInterval < minStatInterval {
+		issues = append(issues, LintIssue{
+			Code:    LintSuspiciousValue,
+			Section: "reader",
+			Keys:    []string{KeyStatInterval},
+			Message: fmt.Sprintf("%q %v is less than %v, scanning log path too often costs much cpu", KeyStatInterval, statInterval, minStatInterval),
+		})
+	}
+	if pollInterval, ok := getDuration(c, KeyTailxPollInterval); ok && pollInterval < minTailxPollInterval {
+		issues = append(issues, LintIssue{
+			Code:    LintSuspiciousValue,
+			Section: "reader",
+			Keys:    []string{KeyTailxPollInterval},
+			Message: fmt.Sprintf("%q %v is less than %v, polling idle files too often costs much cpu", KeyTailxPollInterval, pollInterval, minTailxPollInterval),
+		})
+	}
+	return issues
+}
+
+func lintSender(section string, c conf.MapConf) []LintIssue {
+	if faultTolerant, _ := c.GetBoolOr(senderConf.KeyFaultTolerant, true); faultTolerant {
+		return nil
+	}
+	var ftKeys []string
+	for key := range c {
+		if strings.HasPrefix(key, "ft_") {
+			ftKeys = append(ftKeys, key)
+		}
+	}
+	if len(ftKeys) == 0 {
+		return nil
+	}
+	sort.Strings(ftKeys)
+	return []LintIssue{{
+		Code:    LintConflictingOptions,
+		Section: section,
+		Keys:    append([]string{senderConf.KeyFaultTolerant}, ftKeys...),
+		Message: fmt.Sprintf("%s take no effect when %q is false", strings.Join(ftKeys, ", "), senderConf.KeyFaultTolerant),
+	}}
+}
+
+// logLintIssues 加载配置时输出配置检查发现的问题，问题不影响 runner 的创建
+func logLintIssues(rc RunnerConfig) {
+	for _, issue := range LintRunnerConfig(rc) {
+		log.Warnf("Runner[%v] config lint: %v", rc.RunnerName, issue)
+	}
+}
@@ -0,0 +1,72 @@
+package mgr
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/qiniu/logkit/conf"
+	parserconfig "github.com/qiniu/logkit/parser/config"
+	. "github.com/qiniu/logkit/reader/config"
+	senderConf "github.com/qiniu/logkit/sender/config"
+)
+
+func TestLintRunnerConfig(t *testing.T) {
+	rc := RunnerConfig{
+		RunnerInfo: RunnerInfo{RunnerName: "TestLintRunnerConfig"},
+		ReaderConfig: conf.MapConf{
+			KeyMode:          ModeTailx,
+			KeyLogPath:       "/var/log/*.log",
+			KeyExpire:        "0s",
+			KeySubmetaExpire: "720h",
+			KeyExpireDelete:  "true",
+			KeyStatInterval:  "500ms",
+		},
+		ParserConf: conf.MapConf{
+			parserconfig.KeyParserType: parserconfig.TypeCSV,
+			parserconfig.KeyCSVLabels:  "machine nb110",
+		},
+		SendersConfig: []conf.MapConf{
+			{senderConf.KeySenderType: senderConf.TypeDiscard},
+			{
+				senderConf.KeySenderType:    senderConf.TypeDiscard,
+				senderConf.KeyFaultTolerant: "false",
+				senderConf.KeyFtStrategy:    senderConf.KeyFtStrategyConcurrent,
+				senderConf.KeyFtSaveLogPath: "/tmp/ft",
+			},
+		},
+	}
+	issues := LintRunnerConfig(rc)
+	assert.Equal(t, []LintIssue{
+		{Code: LintDeprecatedKey, Section: "parser", Keys: []string{parserconfig.KeyCSVLabels}},
+		{Code: LintConflictingOptions, Section: "reader", Keys: []string{KeyExpire, KeySubmetaExpire}},
+		{Code: LintConflictingOptions, Section: "reader", Keys: []string{KeyExpire, KeyExpireDelete}},
+		{Code: LintSuspiciousValue, Section: "reader", Keys: []string{KeyStatInterval}},
+		{Code: LintConflictingOptions, Section: "senders[1]", Keys: []string{senderConf.KeyFaultTolerant, senderConf.KeyFtSaveLogPath, senderConf.KeyFtStrategy}},
+	}, trimLintMessages(issues))
+
+	// script reader 的 log_path 已经废弃
+	rc = RunnerConfig{ReaderConfig: conf.MapConf{KeyMode: ModeScript, KeyLogPath: "/tmp/a.sh"}}
+	assert.Equal(t, []LintIssue{
+		{Code: LintDeprecatedKey, Section: "reader", Keys: []string{KeyLogPath}},
+	}, trimLintMessages(LintRunnerConfig(rc)))
+
+	rc = RunnerConfig{
+		ReaderConfig: conf.MapConf{
+			KeyMode:          ModeTailx,
+			KeyExpire:        "24h",
+			KeySubmetaExpire: "720h",
+			KeyStatInterval:  "3m",
+		},
+		ParserConf:    conf.MapConf{parserconfig.KeyParserType: parserconfig.TypeCSV, parserconfig.KeyLabels: "machine nb110"},
+		SendersConfig: []conf.MapConf{{senderConf.KeySenderType: senderConf.TypeDiscard, senderConf.KeyFtStrategy: senderConf.KeyFtStrategyConcurrent}},
+	}
+	assert.Empty(t, LintRunnerConfig(rc))
+}
+
+func trimLintMessages(issues []LintIssue) []LintIssue {
+	for i := range issues {
+		issues[i].Message = ""
+	}
+	return issues
+}
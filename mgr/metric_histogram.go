@@ -0,0 +1,112 @@
+package mgr
+
+import (
+	"math"
+	"sort"
+)
+
+// histogram 按对数分桶的直方图，与 HDR histogram 的思路相同，桶的宽度与数值成正比，
+// 任意分位数的相对误差不超过 accuracy，占用的内存只与数值的量级范围有关，与数据条数无关
+type histogram struct {
+	gamma    float64
+	logGamma float64
+
+	positive map[int]int64
+	negative map[int]int64
+	zero     int64
+
+	count    int64
+	min, max float64
+}
+
+func newHistogram(accuracy float64) *histogram {
+	gamma := (1 + accuracy) / (1 - accuracy)
+	return &histogram{
+		gamma:    gamma,
+		logGamma: math.Log(gamma),
+		positive: make(map[int]int64),
+		negative: make(map[int]int64),
+	}
+}
+
+// bucket 返回绝对值 v 所在桶的编号，第 i 个桶的范围为 (gamma^(i-1), gamma^i]
+func (h *histogram) bucket(v float64) int {
+	return int(math.Ceil(math.Log(v) / h.logGamma))
+}
+
+// bucketValue 返回桶的代表值，与桶内任意数值的相对误差不超过 accuracy
+func (h *histogram) bucketValue(i int) float64 {
+	return 2 * math.Pow(h.gamma, float64(i)) / (h.gamma + 1)
+}
+
+func (h *histogram) Add(v float64) {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return
+	}
+	if h.count == 0 || v < h.min {
+		h.min = v
+	}
+	if h.count == 0 || v > h.max {
+		h.max = v
+	}
+	h.count++
+	switch {
+	case v > 0:
+		h.positive[h.bucket(v)]++
+	case v < 0:
+		h.negative[h.bucket(-v)]++
+	default:
+		h.zero++
+	}
+}
+
+// Quantile 返回 q(0 到 1 之间)分位数的近似值，结果不会超出最小值和最大值的范围
+func (h *histogram) Quantile(q float64) float64 {
+	if h.count == 0 {
+		return 0
+	}
+	if q <= 0 {
+		return h.min
+	}
+	if q >= 1 {
+		return h.max
+	}
+	rank := int64(q * float64(h.count-1))
+
+	// 负数桶的编号越大数值越小，按数值从小到大依次累加
+	var seen int64
+	value, found := 0.0, false
+	for _, i := range sortedBuckets(h.negative, true) {
+		if seen += h.negative[i]; seen > rank {
+			value, found = -h.bucketValue(i), true
+			break
+		}
+	}
+	if !found {
+		if seen += h.zero; seen > rank {
+			value, found = 0, true
+		}
+	}
+	if !found {
+		for _, i := range sortedBuckets(h.positive, false) {
+			if seen += h.positive[i]; seen > rank {
+				value = h.bucketValue(i)
+				break
+			}
+		}
+	}
+	return math.Max(h.min, math.Min(h.max, value))
+}
+
+func sortedBuckets(buckets map[int]int64, desc bool) []int {
+	keys := make([]int, 0, len(buckets))
+	for i := range buckets {
+		keys = append(keys, i)
+	}
+	if desc {
+		sort.Sort(sort.Reverse(sort.IntSlice(keys)))
+	} else {
+		sort.Ints(keys)
+	}
+	return keys
+}
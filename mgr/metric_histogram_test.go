@@ -0,0 +1,40 @@
+package mgr
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistogramQuantile(t *testing.T) {
+	h := newHistogram(0.01)
+	assert.Equal(t, float64(0), h.Quantile(0.5))
+
+	values := make([]float64, 0, 10000)
+	for i := 0; i < 10000; i++ {
+		v := math.Exp(rand.NormFloat64()*2) - 1
+		values = append(values, v)
+		h.Add(v)
+	}
+	h.Add(math.NaN())
+	sort.Float64s(values)
+	assert.Equal(t, int64(len(values)), h.count)
+	assert.Equal(t, values[0], h.Quantile(0))
+	assert.Equal(t, values[len(values)-1], h.Quantile(1))
+	for _, q := range []float64{0.1, 0.5, 0.9, 0.99} {
+		expect := values[int(q*float64(len(values)-1))]
+		assert.InDelta(t, expect, h.Quantile(q), math.Abs(expect)*0.01+1e-9, "quantile %v", q)
+	}
+
+	// 负数和零
+	h = newHistogram(0.01)
+	for _, v := range []float64{-100, -10, 0, 0, 10} {
+		h.Add(v)
+	}
+	assert.InEpsilon(t, -10, h.Quantile(0.25), 0.01)
+	assert.Equal(t, float64(0), h.Quantile(0.5))
+	assert.Equal(t, float64(-100), h.Quantile(0))
+}
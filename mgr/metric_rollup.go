@@ -0,0 +1,297 @@
+package mgr
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/qiniu/logkit/metric"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const (
+	RollupSum  = "sum"
+	RollupAvg  = "avg"
+	RollupMax  = "max"
+	RollupMin  = "min"
+	RollupLast = "last"
+	// RollupHistogram 将窗口内的数值放入直方图，输出配置的分位数以及 count、min、max
+	RollupHistogram = "histogram"
+
+	defaultHistogramAccuracy = 0.01
+)
+
+var defaultRollupPercentiles = []float64{50, 90, 99}
+
+// MetricRollupConfig metric runner 发送前的预聚合配置，tag 相同的数据在一个窗口内聚合为一条
+type MetricRollupConfig struct {
+	// Window 聚合窗口，单位为秒，需要大于采集间隔
+	Window int `json:"window"`
+	// TagKeys 用于分组的字段，为空时所有字符串类型的字段都作为 tag
+	TagKeys []string `json:"tag_keys,omitempty"`
+	// Methods 指定字段的聚合方式，未指定的数值字段使用 DefaultMethod
+	Methods       map[string]string `json:"methods,omitempty"`
+	DefaultMethod string            `json:"default_method,omitempty"`
+	// Percentiles histogram 聚合输出的百分位数，如 99.9 输出为 <字段名>_p99_9，默认为 50、90、99
+	Percentiles []float64 `json:"percentiles,omitempty"`
+	// HistogramAccuracy histogram 分位数的相对误差，默认为 0.01
+	HistogramAccuracy float64 `json:"histogram_accuracy,omitempty"`
+}
+
+func validRollupMethod(method string) bool {
+	switch method {
+	case RollupSum, RollupAvg, RollupMax, RollupMin, RollupLast, RollupHistogram:
+		return true
+	}
+	return false
+}
+
+type rollupField struct {
+	method string
+	value  float64
+	count  int
+	hist   *histogram
+}
+
+type rollupGroup struct {
+	tags   Data
+	fields map[string]*rollupField
+	// others 非数值且非 tag 的字段，保留最后一个值
+	others Data
+}
+
+type metricRollup struct {
+	window        time.Duration
+	tagKeys       map[string]bool
+	methods       map[string]string
+	defaultMethod string
+	percentiles   []float64
+	accuracy      float64
+
+	start  time.Time
+	groups map[string]*rollupGroup
+	order  []string
+}
+
+func newMetricRollup(c *MetricRollupConfig, collectInterval time.Duration) (*metricRollup, error) {
+	if c == nil {
+		return nil, nil
+	}
+	window := time.Duration(c.Window) * time.Second
+	if window <= collectInterval {
+		return nil, fmt.Errorf("metric rollup window %v should be larger than collect interval %v", window, collectInterval)
+	}
+	if c.DefaultMethod == "" {
+		c.DefaultMethod = RollupAvg
+	}
+	if !validRollupMethod(c.DefaultMethod) {
+		return nil, fmt.Errorf("metric rollup default method %q is not supported", c.DefaultMethod)
+	}
+	for field, method := range c.Methods {
+		if !validRollupMethod(method) {
+			return nil, fmt.Errorf("metric rollup method %q of field %v is not supported", method, field)
+		}
+	}
+	percentiles := c.Percentiles
+	if len(percentiles) == 0 {
+		percentiles = defaultRollupPercentiles
+	}
+	for _, p := range percentiles {
+		if p <= 0 || p > 100 {
+			return nil, fmt.Errorf("metric rollup percentile %v should be in (0, 100]", p)
+		}
+	}
+	accuracy := c.HistogramAccuracy
+	if accuracy == 0 {
+		accuracy = defaultHistogramAccuracy
+	}
+	if accuracy < 0 || accuracy >= 1 {
+		return nil, fmt.Errorf("metric rollup histogram accuracy %v should be in (0, 1)", accuracy)
+	}
+	r := &metricRollup{
+		window:        window,
+		methods:       c.Methods,
+		defaultMethod: c.DefaultMethod,
+		percentiles:   percentiles,
+		accuracy:      accuracy,
+	}
+	if len(c.TagKeys) > 0 {
+		r.tagKeys = make(map[string]bool, len(c.TagKeys))
+		for _, k := range c.TagKeys {
+			r.tagKeys[k] = true
+		}
+	}
+	r.reset(time.Now())
+	return r, nil
+}
+
+func (r *metricRollup) reset(now time.Time) {
+	r.start = now
+	r.groups = make(map[string]*rollupGroup)
+	r.order = r.order[:0]
+}
+
+func toFloat64(v interface{}) (float64, bool) {
+	switch val := v.(type) {
+	case float64:
+		return val, true
+	case float32:
+		return float64(val), true
+	case int:
+		return float64(val), true
+	case int8:
+		return float64(val), true
+	case int16:
+		return float64(val), true
+	case int32:
+		return float64(val), true
+	case int64:
+		return float64(val), true
+	case uint:
+		return float64(val), true
+	case uint8:
+		return float64(val), true
+	case uint16:
+		return float64(val), true
+	case uint32:
+		return float64(val), true
+	case uint64:
+		return float64(val), true
+	}
+	return 0, false
+}
+
+func (r *metricRollup) isTag(key string, value interface{}) bool {
+	if r.tagKeys != nil {
+		return r.tagKeys[key]
+	}
+	_, ok := value.(string)
+	return ok
+}
+
+func (r *metricRollup) groupKey(tags Data) string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = fmt.Sprintf("%s=%v", k, tags[k])
+	}
+	return strings.Join(parts, "\x00")
+}
+
+// Add 将数据加入当前窗口
+func (r *metricRollup) Add(datas []Data) {
+	for _, data := range datas {
+		tags := Data{}
+		for k, v := range data {
+			if k == metric.Timestamp {
+				continue
+			}
+			if r.isTag(k, v) {
+				tags[k] = v
+			}
+		}
+		key := r.groupKey(tags)
+		group, ok := r.groups[key]
+		if !ok {
+			group = &rollupGroup{tags: tags, fields: make(map[string]*rollupField), others: Data{}}
+			r.groups[key] = group
+			r.order = append(r.order, key)
+		}
+		for k, v := range data {
+			if k == metric.Timestamp {
+				continue
+			}
+			if _, isTag := tags[k]; isTag {
+				continue
+			}
+			value, ok := toFloat64(v)
+			if !ok {
+				group.others[k] = v
+				continue
+			}
+			field, ok := group.fields[k]
+			if !ok {
+				method := r.methods[k]
+				if method == "" {
+					method = r.defaultMethod
+				}
+				field = &rollupField{method: method, value: value, count: 1}
+				if method == RollupHistogram {
+					field.hist = newHistogram(r.accuracy)
+					field.hist.Add(value)
+				}
+				group.fields[k] = field
+				continue
+			}
+			field.count++
+			switch field.method {
+			case RollupSum, RollupAvg:
+				field.value += value
+			case RollupMax:
+				if value > field.value {
+					field.value = value
+				}
+			case RollupMin:
+				if value < field.value {
+					field.value = value
+				}
+			case RollupLast:
+				field.value = value
+			case RollupHistogram:
+				field.hist.Add(value)
+			}
+		}
+	}
+}
+
+// Due 判断当前窗口是否已经结束
+func (r *metricRollup) Due(now time.Time) bool {
+	return now.Sub(r.start) >= r.window
+}
+
+// Flush 输出当前窗口的聚合结果并开始新的窗口，时间戳为窗口结束时间
+func (r *metricRollup) Flush(now time.Time) []Data {
+	datas := make([]Data, 0, len(r.order))
+	timestamp := now.Format(time.RFC3339Nano)
+	for _, key := range r.order {
+		group := r.groups[key]
+		data := make(Data, len(group.tags)+len(group.fields)+len(group.others)+1)
+		for k, v := range group.others {
+			data[k] = v
+		}
+		for k, v := range group.tags {
+			data[k] = v
+		}
+		for k, field := range group.fields {
+			switch field.method {
+			case RollupAvg:
+				data[k] = field.value / float64(field.count)
+			case RollupHistogram:
+				r.flushHistogram(data, k, field.hist)
+			default:
+				data[k] = field.value
+			}
+		}
+		data[metric.Timestamp] = timestamp
+		datas = append(datas, data)
+	}
+	r.reset(now)
+	return datas
+}
+
+// flushHistogram 将直方图展开为 <字段名>_count、_min、_max 以及每个百分位数对应的 <字段名>_p<百分位数>
+func (r *metricRollup) flushHistogram(data Data, key string, h *histogram) {
+	data[key+"_count"] = h.count
+	data[key+"_min"] = h.min
+	data[key+"_max"] = h.max
+	for _, p := range r.percentiles {
+		name := strings.Replace(strconv.FormatFloat(p, 'f', -1, 64), ".", "_", -1)
+		data[key+"_p"+name] = h.Quantile(p / 100)
+	}
+}
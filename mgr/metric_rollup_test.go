@@ -0,0 +1,126 @@
+package mgr
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/metric"
+	"github.com/qiniu/logkit/sender"
+	"github.com/qiniu/logkit/sender/mock"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+func TestMetricRollup(t *testing.T) {
+	_, err := newMetricRollup(&MetricRollupConfig{Window: 10}, 30*time.Second)
+	assert.Error(t, err)
+	_, err = newMetricRollup(&MetricRollupConfig{Window: 60, DefaultMethod: "median"}, 10*time.Second)
+	assert.Error(t, err)
+	r, err := newMetricRollup(nil, 10*time.Second)
+	assert.NoError(t, err)
+	assert.Nil(t, r)
+
+	r, err = newMetricRollup(&MetricRollupConfig{
+		Window:  60,
+		Methods: map[string]string{"cpu_usage_max": RollupMax, "net_bytes": RollupSum},
+	}, 10*time.Second)
+	assert.NoError(t, err)
+
+	now := time.Now()
+	assert.False(t, r.Due(now))
+	r.Add([]Data{
+		{"hostname": "h1", "cpu_usage": 10, "cpu_usage_max": 10, "net_bytes": int64(100), "up": true, metric.Timestamp: "t1"},
+		{"hostname": "h2", "cpu_usage": 50},
+	})
+	r.Add([]Data{
+		{"hostname": "h1", "cpu_usage": 30.0, "cpu_usage_max": 30, "net_bytes": int64(200), "up": false, metric.Timestamp: "t2"},
+	})
+	flushTime := now.Add(time.Minute)
+	assert.True(t, r.Due(flushTime))
+	datas := r.Flush(flushTime)
+	assert.Equal(t, []Data{
+		{
+			"hostname":       "h1",
+			"cpu_usage":      float64(20),
+			"cpu_usage_max":  float64(30),
+			"net_bytes":      float64(300),
+			"up":             false,
+			metric.Timestamp: flushTime.Format(time.RFC3339Nano),
+		},
+		{
+			"hostname":       "h2",
+			"cpu_usage":      float64(50),
+			metric.Timestamp: flushTime.Format(time.RFC3339Nano),
+		},
+	}, datas)
+	assert.False(t, r.Due(flushTime))
+	assert.Len(t, r.Flush(flushTime), 0)
+
+	// 指定 tag 字段时，其余字符串字段保留最后一个值
+	r, err = newMetricRollup(&MetricRollupConfig{Window: 60, TagKeys: []string{"hostname"}, DefaultMethod: RollupMin}, 10*time.Second)
+	assert.NoError(t, err)
+	r.Add([]Data{
+		{"hostname": "h1", "disk": "sda", "disk_util": 5},
+		{"hostname": "h1", "disk": "sdb", "disk_util": 3},
+	})
+	datas = r.Flush(flushTime)
+	assert.Len(t, datas, 1)
+	assert.Equal(t, float64(3), datas[0]["disk_util"])
+	assert.Equal(t, "sdb", datas[0]["disk"])
+}
+
+func TestMetricRollupHistogram(t *testing.T) {
+	_, err := newMetricRollup(&MetricRollupConfig{Window: 60, Percentiles: []float64{0}}, 10*time.Second)
+	assert.Error(t, err)
+	_, err = newMetricRollup(&MetricRollupConfig{Window: 60, HistogramAccuracy: 1}, 10*time.Second)
+	assert.Error(t, err)
+
+	r, err := newMetricRollup(&MetricRollupConfig{
+		Window:      60,
+		Methods:     map[string]string{"latency": RollupHistogram},
+		Percentiles: []float64{50, 99.9},
+	}, 10*time.Second)
+	assert.NoError(t, err)
+	var datas []Data
+	for i := 1; i <= 1000; i++ {
+		datas = append(datas, Data{"path": "/api", "latency": i})
+	}
+	r.Add(datas)
+	flushTime := time.Now().Add(time.Minute)
+	datas = r.Flush(flushTime)
+	assert.Len(t, datas, 1)
+	assert.Equal(t, "/api", datas[0]["path"])
+	assert.Equal(t, int64(1000), datas[0]["latency_count"])
+	assert.Equal(t, float64(1), datas[0]["latency_min"])
+	assert.Equal(t, float64(1000), datas[0]["latency_max"])
+	assert.InEpsilon(t, 500, datas[0]["latency_p50"], 0.01)
+	assert.InEpsilon(t, 999, datas[0]["latency_p99_9"], 0.01)
+	assert.Nil(t, datas[0]["latency"])
+}
+
+func TestMetricRunnerStopFlushRollup(t *testing.T) {
+	rollup, err := newMetricRollup(&MetricRollupConfig{Window: 60}, 10*time.Second)
+	assert.NoError(t, err)
+	s, err := mock.NewSender(conf.MapConf{})
+	assert.NoError(t, err)
+	r := &MetricRunner{
+		RunnerName: "TestMetricRunnerStopFlushRollup",
+		rollup:     rollup,
+		senders:    []sender.Sender{s},
+		exitChan:   make(chan struct{}),
+		rs:         &RunnerStatus{SenderStats: map[string]StatsInfo{}},
+		rsMutex:    new(sync.RWMutex),
+	}
+	r.rollup.Add([]Data{{"hostname": "h1", "cpu_usage": 10}, {"hostname": "h1", "cpu_usage": 30}})
+	go func() { r.exitChan <- struct{}{} }()
+
+	// 窗口未到期，停止时同样发送预聚合的结果
+	r.Stop()
+	datas := s.(*mock.Sender).Datas
+	assert.Len(t, datas, 1)
+	assert.Equal(t, float64(20), datas[0]["cpu_usage"])
+	assert.Len(t, r.rollup.Flush(time.Now()), 0)
+}
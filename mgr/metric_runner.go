@@ -46,11 +46,13 @@ var (
 type MetricRunner struct {
 	RunnerName string `json:"name"`
 	envTag     string
+	tagPolicy  TagConflictPolicy
 
 	collectors   []metric.Collector
 	senders      []sender.Sender
 	transformers map[string][]transforms.Transformer
 	commonTrans  []transforms.Transformer
+	rollup       *metricRollup
 
 	collectInterval time.Duration
 	rs              *RunnerStatus
@@ -179,6 +181,14 @@ func NewMetricRunner(rc RunnerConfig, sr *sender.Registry) (runner *MetricRunner
 	if err != nil {
 		return nil, err
 	}
+	rollup, err := newMetricRollup(rc.MetricRollup, interval)
+	if err != nil {
+		return nil, err
+	}
+	tagPolicy, err := NewTagConflictPolicy(rc.TagConflictPolicy, rc.TagConflictPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("runner %v %v", rc.RunnerName, err)
+	}
 
 	senders := make([]sender.Sender, 0)
 	for _, senderConfig := range rc.SendersConfig {
@@ -228,8 +238,10 @@ func NewMetricRunner(rc RunnerConfig, sr *sender.Registry) (runner *MetricRunner
 		collectors:      collectors,
 		transformers:    transformers,
 		commonTrans:     commonTransformers,
+		rollup:          rollup,
 		senders:         senders,
 		envTag:          rc.EnvTag,
+		tagPolicy:       tagPolicy,
 	}
 	runner.StatusRestore()
 	return
@@ -334,12 +346,23 @@ func (r *MetricRunner) Run() {
 			time.Sleep(r.collectInterval)
 			continue
 		}
+		var conflicts int64
 		if len(tags) > 0 {
-			datas = AddTagsToData(tags, datas, r.Name())
+			conflicts = r.tagPolicy.AddTags(tags, datas)
 		}
 		r.rsMutex.Lock()
 		r.rs.ReadDataCount += int64(dataCnt)
+		r.rs.TagConflicts += conflicts
 		r.rsMutex.Unlock()
+		// 开启预聚合时，窗口结束后才发送聚合结果
+		if r.rollup != nil {
+			r.rollup.Add(datas)
+			if !r.rollup.Due(time.Now()) {
+				time.Sleep(r.collectInterval)
+				continue
+			}
+			datas = r.rollup.Flush(time.Now())
+		}
 		r.lastSend = time.Now()
 		for _, s := range r.senders {
 			if !r.trySend(s, datas, 3) {
@@ -414,6 +437,7 @@ func (mr *MetricRunner) Stop() {
 	select {
 	case <-mr.exitChan:
 		log.Warnf("MetricRunner " + mr.Name() + " has been stopped ")
+		mr.flushRollup()
 	case <-timer.C:
 		log.Warnf("MetricRunner " + mr.Name() + " exited timeout ")
 	}
@@ -436,6 +460,23 @@ func (mr *MetricRunner) Stop() {
 	}
 }
 
+// flushRollup 在关闭 sender 之前发送预聚合中尚未到期的窗口，只在 Run 退出后调用
+func (mr *MetricRunner) flushRollup() {
+	if mr.rollup == nil {
+		return
+	}
+	datas := mr.rollup.Flush(time.Now())
+	if len(datas) == 0 {
+		return
+	}
+	for _, s := range mr.senders {
+		if !mr.trySend(s, datas, 1) {
+			log.Errorf("runner[%v]: failed to send pending rollup data: << %v >>", mr.RunnerName, datas)
+			break
+		}
+	}
+}
+
 func (mr *MetricRunner) Reset() (err error) {
 	var errMsg string
 	if err = mr.meta.Reset(); err != nil {
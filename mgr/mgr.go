@@ -25,7 +25,9 @@ import (
 	"github.com/qiniu/logkit/self"
 	"github.com/qiniu/logkit/sender"
 	senderConf "github.com/qiniu/logkit/sender/config"
+	"github.com/qiniu/logkit/sender/localstore"
 	"github.com/qiniu/logkit/utils"
+	"github.com/qiniu/logkit/utils/bundle"
 	. "github.com/qiniu/logkit/utils/models"
 	utilsos "github.com/qiniu/logkit/utils/os"
 )
@@ -43,6 +45,15 @@ type ManagerConfig struct {
 	DisableWeb   bool          `json:"disable_web"`
 	ServerBackup bool          `json:"-"`
 	AuditDir     string        `json:"audit_dir"`
+	// DataBundles 需要定期更新的辅助数据，如 GeoIP 数据库、grok pattern、SNMP MIB
+	DataBundles []bundle.Config `json:"data_bundles"`
+	// TLSCertFile 和 TLSKeyFile 均不为空时管理接口使用 https
+	TLSCertFile string `json:"tls_cert_file"`
+	TLSKeyFile  string `json:"tls_key_file"`
+	// Quotas 按 runner 或标签分组的资源配额，同一组内的 runner 共享上限
+	Quotas []QuotaConfig `json:"quotas,omitempty"`
+	// Drift 定期检查正在运行的 runner 与配置文件是否一致，没有配置检查间隔时不检查
+	Drift DriftConfig `json:"drift"`
 
 	CollectLog
 }
@@ -77,19 +88,31 @@ type Manager struct {
 	runnerPaths map[string]string
 	// runnerConfigs 存储了当前每个 runner 对应的 config
 	runnerConfigs map[string]RunnerConfig
+	// supervisions 存储了每个 runner 的自动重启状态，同样由 runnerLock 保护
+	supervisions map[string]*supervision
+	// supervisorStop 关闭后不再自动重启 runner
+	supervisorStop chan struct{}
 
 	audit     *audit.Audit
 	auditChan chan audit.Message
 
-	watchers  map[string]*fsnotify.Watcher // inode到watcher的映射表
-	rregistry *reader.Registry
-	pregistry *parser.Registry
-	sregistry *sender.Registry
+	watchers   map[string]*fsnotify.Watcher // inode到watcher的映射表
+	confsPaths []string                     // Watch 监听的配置目录，由 watcherMux 保护
+	rregistry  *reader.Registry
+	pregistry  *parser.Registry
+	sregistry  *sender.Registry
 
 	Version    string
 	SystemInfo string
 
 	CollectLogRunner *self.LogRunner
+	bundles          *bundle.Manager
+	quotas           *quotaManager
+	drift            *driftDetector
+
+	// recoveries 存储了每个 runner 第一次启动时的恢复报告，由 recoveryLock 保护
+	recoveryLock sync.RWMutex
+	recoveries   map[string]RecoveryReport
 }
 
 func NewManager(conf ManagerConfig) (*Manager, error) {
@@ -148,6 +171,8 @@ func NewCustomManager(conf ManagerConfig, rr *reader.Registry, pr *parser.Regist
 		runners:          make(map[string]Runner),
 		runnerConfigs:    make(map[string]RunnerConfig),
 		runnerPaths:      make(map[string]string),
+		supervisions:     make(map[string]*supervision),
+		supervisorStop:   make(chan struct{}),
 		watchers:         make(map[string]*fsnotify.Watcher),
 		rregistry:        rr,
 		pregistry:        pr,
@@ -157,6 +182,25 @@ func NewCustomManager(conf ManagerConfig, rr *reader.Registry, pr *parser.Regist
 		auditChan:        make(chan audit.Message, 100),
 		CollectLogRunner: collectLogRunner,
 	}
+	if len(conf.DataBundles) > 0 {
+		m.bundles, err = bundle.NewManager(conf.DataBundles)
+		if err != nil {
+			return nil, err
+		}
+		m.bundles.Start()
+	}
+	if len(conf.Quotas) > 0 {
+		m.quotas, err = newQuotaManager(conf.Quotas)
+		if err != nil {
+			return nil, err
+		}
+		go m.runQuotas()
+	}
+	if conf.Drift.CheckInterval != "" {
+		if m.drift, err = newDriftDetector(conf.Drift); err != nil {
+			return nil, err
+		}
+	}
 	return m, nil
 }
 
@@ -166,6 +210,9 @@ func (m *Manager) UpdateReaderRegister() {
 
 func (m *Manager) Stop() error {
 	m.runnerLock.Lock()
+	if m.supervisorStop != nil {
+		close(m.supervisorStop)
+	}
 	for _, runner := range m.runners {
 		runner.Stop()
 		runnerStatus, ok := runner.(StatusPersistable)
@@ -189,6 +236,15 @@ func (m *Manager) Stop() error {
 	if m.CollectLogRunner != nil {
 		m.CollectLogRunner.Stop()
 	}
+	if m.bundles != nil {
+		m.bundles.Stop()
+	}
+	if m.quotas != nil {
+		close(m.quotas.stop)
+	}
+	if m.drift != nil {
+		close(m.drift.stop)
+	}
 	return nil
 }
 
@@ -221,6 +277,7 @@ func (m *Manager) RemoveWithConfig(confPath string, isDelete bool) (err error) {
 	delete(m.runners, confPath)
 	if isDelete {
 		delete(m.runnerConfigs, confPath)
+		delete(m.supervisions, confPath)
 	}
 	log.Infof("runner %s be removed, total %d", runner.Name(), len(m.runners))
 	if runnerStatus, ok := runner.(StatusPersistable); ok {
@@ -318,6 +375,25 @@ func (m *Manager) ForkRunner(confPath string, config RunnerConfig, returnOnErr b
 	var err error
 	i := 0
 	config.AuditChan = m.auditChan
+	policy, err := parseRestartPolicy(config.RestartPolicy)
+	if err != nil {
+		err = fmt.Errorf("runner %v %v", config.RunnerName, err)
+		if !returnOnErr {
+			log.Error(err)
+		}
+		return err
+	}
+	if err = validateLabels(config.Labels); err != nil {
+		err = fmt.Errorf("runner %v %v", config.RunnerName, err)
+		if !returnOnErr {
+			log.Error(err)
+		}
+		return err
+	}
+	logLintIssues(config)
+	if !config.IsStopped {
+		m.recordRecovery(config)
+	}
 	for {
 		if m.IsRunning(confPath) {
 			err = fmt.Errorf("%s already added - ", confPath)
@@ -383,7 +459,9 @@ func (m *Manager) ForkRunner(confPath string, config RunnerConfig, returnOnErr b
 
 	m.addCleanQueue(runner.Cleaner())
 	log.Infof("Runner[%v] added: %#v", config.RunnerName, confPath)
-	go runner.Run()
+	sv := m.getSupervision(confPath)
+	sv.reset()
+	go m.superviseRunner(confPath, config, runner, policy, sv)
 	m.runners[confPath] = runner
 	if !exist {
 		m.runnerPaths[config.RunnerName] = confPath
@@ -593,6 +671,9 @@ func (m *Manager) addWatchers(confsPath []string) (err error) {
 }
 
 func (m *Manager) Watch(confsPath []string) (err error) {
+	m.watcherMux.Lock()
+	m.confsPaths = append(m.confsPaths, confsPath...)
+	m.watcherMux.Unlock()
 	err = m.addWatchers(confsPath)
 	if err != nil {
 		log.Errorf("addWatchers error : %v", err)
@@ -600,6 +681,9 @@ func (m *Manager) Watch(confsPath []string) (err error) {
 	go m.detectMoreWatchers(confsPath)
 	go m.clean()
 	go m.auditLog()
+	if m.drift != nil {
+		go m.runDriftCheck()
+	}
 	return
 }
 
@@ -627,17 +711,17 @@ func (m *Manager) Status() (rss map[string]RunnerStatus) {
 	rss = make(map[string]RunnerStatus)
 	for key, conf := range m.runnerConfigs {
 		if r, ex := m.runners[key]; ex {
-			rss[r.Name()] = r.Status()
+			rss[r.Name()] = m.supervisedStatus(key, r.Status())
 			continue
 		}
-		rss[conf.RunnerName] = RunnerStatus{
+		rss[conf.RunnerName] = m.supervisedStatus(key, RunnerStatus{
 			Name:           conf.RunnerName,
 			ReaderStats:    StatsInfo{},
 			ParserStats:    StatsInfo{},
 			TransformStats: make(map[string]StatsInfo),
 			SenderStats:    make(map[string]StatsInfo),
 			RunningStatus:  RunnerStopped,
-		}
+		})
 	}
 	return rss
 }
@@ -676,6 +760,116 @@ func (m *Manager) Error(name string) (rss ErrorsResult, err error) {
 	return rss, ErrNotExist
 }
 
+func (m *Manager) Completions(name string) ([]reader.CompletionRecord, error) {
+	m.runnerLock.RLock()
+	defer m.runnerLock.RUnlock()
+	for key := range m.runnerConfigs {
+		if r, ex := m.runners[key]; ex {
+			if r.Name() != name {
+				continue
+			}
+
+			if runnerCompletions, ok := r.(RunnerCompletions); ok {
+				return runnerCompletions.GetCompletions()
+			}
+			return nil, ErrNotSupport
+		}
+	}
+	return nil, ErrNotExist
+}
+
+// FlushRunner 立即发送 runner 所有 sender 中缓存的数据，返回每个 sender 的结果
+func (m *Manager) FlushRunner(name string, timeout time.Duration) ([]SenderFlushResult, error) {
+	filename, conf, err := m.getDeepCopyConfig(name)
+	if err != nil {
+		return nil, err
+	}
+	if conf.IsStopped {
+		return nil, fmt.Errorf("runner %v has already stopped", filename)
+	}
+	// flush 可能需要较长时间，不能持有 runnerLock
+	r, ok := m.readRunners(filename)
+	if !ok {
+		return nil, ErrNotExist
+	}
+	fr, ok := r.(Flushable)
+	if !ok {
+		return nil, ErrNotSupport
+	}
+	return fr.FlushSenders(timeout), nil
+}
+
+// QueryLocalStore 查询 runner 的 localstore sender 保存的数据
+func (m *Manager) QueryLocalStore(name string, q localstore.Query) ([]localstore.Record, error) {
+	if _, ok := m.GetRunnerPath(name); !ok {
+		return nil, ErrNotExist
+	}
+	return localstore.QueryRunner(name, q)
+}
+
+// debuggable 找到名称为 name 并且支持调试的 runner
+func (m *Manager) debuggable(name string) (Debuggable, error) {
+	m.runnerLock.RLock()
+	defer m.runnerLock.RUnlock()
+	for key := range m.runnerConfigs {
+		if r, ex := m.runners[key]; ex {
+			if r.Name() != name {
+				continue
+			}
+
+			if dr, ok := r.(Debuggable); ok {
+				return dr, nil
+			}
+			return nil, ErrNotSupport
+		}
+	}
+	return nil, ErrNotExist
+}
+
+// SetRunnerDebug 临时打开 runner 的 debug 日志，返回自动恢复的时间
+func (m *Manager) SetRunnerDebug(name string, duration time.Duration) (time.Time, error) {
+	dr, err := m.debuggable(name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return dr.SetDebug(duration), nil
+}
+
+// StartRunnerCapture 开始抓取 runner 接下来读取的 lines 条原始数据以及解析结果
+func (m *Manager) StartRunnerCapture(name string, lines int) error {
+	dr, err := m.debuggable(name)
+	if err != nil {
+		return err
+	}
+	return dr.StartCapture(lines)
+}
+
+func (m *Manager) RunnerCapture(name string) (*DebugCapture, error) {
+	dr, err := m.debuggable(name)
+	if err != nil {
+		return nil, err
+	}
+	return dr.GetCapture()
+}
+
+// RunnerRequests 返回 runner 中开启了请求记录的 sender 最近发送的请求
+func (m *Manager) RunnerRequests(name string) ([]SenderRequests, error) {
+	dr, err := m.debuggable(name)
+	if err != nil {
+		return nil, err
+	}
+	return dr.CapturedRequests(), nil
+}
+
+// ReplayRunnerRequest 重新发送 runner 第 senderIndex 个 sender 记录的请求
+func (m *Manager) ReplayRunnerRequest(name string, senderIndex int, id int64) (*sender.CapturedRequest, error) {
+	dr, err := m.debuggable(name)
+	if err != nil {
+		return nil, err
+	}
+	return dr.ReplayRequest(senderIndex, id)
+}
+
 func (m *Manager) Configs() (rss map[string]RunnerConfig) {
 	rss = make(map[string]RunnerConfig)
 	tmpRss := make(map[string]RunnerConfig)
@@ -705,17 +899,17 @@ func (m *Manager) StatusAndConfig() (rs map[string]RunnerStatus, rc map[string]R
 		tmpRc[key] = conf
 
 		if r, ex := m.runners[key]; ex {
-			rs[r.Name()] = r.Status()
+			rs[r.Name()] = m.supervisedStatus(key, r.Status())
 			continue
 		}
-		rs[conf.RunnerName] = RunnerStatus{
+		rs[conf.RunnerName] = m.supervisedStatus(key, RunnerStatus{
 			Name:           conf.RunnerName,
 			ReaderStats:    StatsInfo{},
 			ParserStats:    StatsInfo{},
 			TransformStats: make(map[string]StatsInfo),
 			SenderStats:    make(map[string]StatsInfo),
 			RunningStatus:  RunnerStopped,
-		}
+		})
 	}
 	utils.DeepCopyByJSON(&rc, &tmpRc)
 
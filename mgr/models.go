@@ -32,6 +32,27 @@ type RunnerStatus struct {
 	Tag              string `json:"tag,omitempty"`
 	Url              string `json:"url,omitempty"`
 
+	// Restarts runner 异常退出后的重启记录
+	Restarts []RestartRecord `json:"restarts,omitempty"`
+	// ShadowStats 影子流水线与正式流水线的差异统计，没有配置影子流水线时为空
+	ShadowStats *ShadowStats `json:"shadowStats,omitempty"`
+	// TagConflicts 标签与解析出的字段同名的次数，处理方式由 tag_conflict_policy 决定
+	TagConflicts int64 `json:"tagConflicts,omitempty"`
+	// SchemaDrift 发送数据的字段变化统计，没有配置 schema_drift 时为空
+	SchemaDrift *SchemaDriftStats `json:"schemaDrift,omitempty"`
+	// SendErrorPolicy 被拒绝和认证失败的发送错误统计，没有发生过时为空
+	SendErrorPolicy *SendErrorStats `json:"sendErrorPolicy,omitempty"`
+	// LatencySLO 端到端延迟及自动调整的统计，没有配置 latency_slo 时为空
+	LatencySLO *LatencySLOStats `json:"latencySLO,omitempty"`
+	// ReaderFiles 正在读取的每个文件的读取进度，仅 tailx 等同时读取多个文件的 reader 支持
+	ReaderFiles []FileStatus `json:"readerFiles,omitempty"`
+	// ReaderClients 监听端口接收数据的 reader 每个客户端的统计，仅 syslog 等 reader 支持
+	ReaderClients []ClientStatus `json:"readerClients,omitempty"`
+	// CatchUpETA 按 ReaderFiles 最近的读取速度读完所有 lag 预计需要的秒数，无法估计时为 -1
+	CatchUpETA int64 `json:"catchUpEta,omitempty"`
+	// ConfigDrift 与配置文件中声明的配置之间的偏差，没有偏差或者没有开启检查时为空
+	ConfigDrift *ConfigDrift `json:"configDrift,omitempty"`
+
 	//仅作为将history error同步上传到服务端时使用
 	HistorySyncErrors CompatibleErrorResult `json:"history_errors"`
 }
@@ -68,24 +89,59 @@ func (src *RunnerStatus) Clone() RunnerStatus {
 	dst.RunningStatus = src.RunningStatus
 	dst.Tag = src.Tag
 	dst.Url = src.Url
+	dst.TagConflicts = src.TagConflicts
+	dst.CatchUpETA = src.CatchUpETA
+	if src.Restarts != nil {
+		dst.Restarts = append([]RestartRecord(nil), src.Restarts...)
+	}
+	if src.ReaderFiles != nil {
+		dst.ReaderFiles = append([]FileStatus(nil), src.ReaderFiles...)
+	}
+	if src.ReaderClients != nil {
+		dst.ReaderClients = append([]ClientStatus(nil), src.ReaderClients...)
+	}
+	if src.ShadowStats != nil {
+		dst.ShadowStats = src.ShadowStats.clone()
+	}
+	if src.SchemaDrift != nil {
+		dst.SchemaDrift = src.SchemaDrift.clone()
+	}
+	if src.SendErrorPolicy != nil {
+		dst.SendErrorPolicy = src.SendErrorPolicy.clone()
+	}
+	if src.LatencySLO != nil {
+		dst.LatencySLO = src.LatencySLO.clone()
+	}
+	if src.ConfigDrift != nil {
+		drift := *src.ConfigDrift
+		drift.Fields = append([]string(nil), src.ConfigDrift.Fields...)
+		dst.ConfigDrift = &drift
+	}
 	return dst
 }
 
 // RunnerConfig 从多数据源读取，经过解析后，发往多个数据目的地
 type RunnerConfig struct {
 	RunnerInfo
-	SourceData    string                   `json:"sourceData,omitempty"`
-	MetricConfig  []MetricConfig           `json:"metric,omitempty"`
-	ReaderConfig  conf.MapConf             `json:"reader"`
-	CleanerConfig conf.MapConf             `json:"cleaner,omitempty"`
-	ParserConf    conf.MapConf             `json:"parser"`
-	Transforms    []map[string]interface{} `json:"transforms,omitempty"`
-	SendersConfig []conf.MapConf           `json:"senders"`
-	Router        router.RouterConfig      `json:"router,omitempty"`
-	IsInWebFolder bool                     `json:"web_folder,omitempty"`
-	IsStopped     bool                     `json:"is_stopped,omitempty"`
-	IsFromServer  bool                     `json:"from_server,omitempty"` // 判读是否从服务器拉取的配置
-	AuditChan     chan<- audit.Message     `json:"-"`
+	SourceData       string                   `json:"sourceData,omitempty"`
+	MetricConfig     []MetricConfig           `json:"metric,omitempty"`
+	MetricRollup     *MetricRollupConfig      `json:"metric_rollup,omitempty"`
+	ReaderConfig     conf.MapConf             `json:"reader"`
+	CleanerConfig    conf.MapConf             `json:"cleaner,omitempty"`
+	ParserConf       conf.MapConf             `json:"parser"`
+	Transforms       []map[string]interface{} `json:"transforms,omitempty"`
+	SendersConfig    []conf.MapConf           `json:"senders"`
+	Router           router.RouterConfig      `json:"router,omitempty"`
+	Canary           *CanaryConfig            `json:"canary,omitempty"`
+	ParseErrorOutput *ParseErrorOutputConfig  `json:"parse_error_output,omitempty"`
+	Shadow           *ShadowConfig            `json:"shadow,omitempty"`
+	SchemaDrift      *SchemaDriftConfig       `json:"schema_drift,omitempty"`
+	SendErrorPolicy  *SendErrorPolicyConfig   `json:"send_error_policy,omitempty"`
+	LatencySLO       *LatencySLOConfig        `json:"latency_slo,omitempty"`
+	IsInWebFolder    bool                     `json:"web_folder,omitempty"`
+	IsStopped        bool                     `json:"is_stopped,omitempty"`
+	IsFromServer     bool                     `json:"from_server,omitempty"` // 判读是否从服务器拉取的配置
+	AuditChan        chan<- audit.Message     `json:"-"`
 }
 
 type RunnerInfo struct {
@@ -99,12 +155,27 @@ type RunnerInfo struct {
 	MaxReaderCloseWaitTime int    `json:"max_reader_close_wait_time,omitempty"` // runner 等待reader close时间，
 	ErrorsListCap          int    `json:"errors_list_cap"`                      // 记录错误信息的最大条数
 	SyncEvery              int    `json:"sync_every,omitempty"`                 // 每多少次sync一下，填小于的0数字表示stop时sync，正整数表示发送成功多少次以后同步，填0或1就是每次发送成功都同步，兼容原来不配置的逻辑
+	SendBarrier            string `json:"send_barrier,omitempty"`               // 多个sender时提交meta的条件，all表示所有sender发送成功，quorum表示至少send_quorum个sender发送成功，为空表示不等待
+	SendQuorum             int    `json:"send_quorum,omitempty"`                // send_barrier为quorum时需要发送成功的sender数，默认为半数以上
+	SendQuorumDiscard      bool   `json:"send_quorum_discard,omitempty"`        // send_barrier为quorum且少于全部sender时必须开启，表示接受达到quorum后丢弃其余sender仍未发送成功的数据
+	ParseWorkers           int    `json:"parse_workers,omitempty"`              // 并行解析的协程数，小于等于1时串行解析，只适用于不需要跨行保存状态的解析器
+	ParseOrder             string `json:"parse_order,omitempty"`                // 并行解析时的顺序保证，source(默认)保证同一数据源的数据按顺序解析发送，none 不保证顺序，数据平均分给各个协程
+	BatchPath              bool   `json:"batch_path,omitempty"`                 // 满足条件时将解析出的 Batch 直接交给 sender 发送，不再逐条转换为 Data，默认关闭
 	CreateTime             string `json:"createtime"`
 	EnvTag                 string `json:"env_tag,omitempty"` // 用这个字段的值来获取环境变量, 作为 tag 添加到数据中
 	ExtraInfo              bool   `json:"extra_info"`
 	LogAudit               bool   `json:"log_audit"`
 	SendRaw                bool   `json:"send_raw"`  //使用发送原始字符串的接口，而不是Data
 	ReadTime               bool   `json:"read_time"` // 读取时间
+	// TagConflictPolicy 标签(tag 文件、环境变量、extra_info、读取时间)与解析出的字段同名时的处理策略，
+	// parsed_wins(默认)保留解析出的字段，tag_wins 使用标签覆盖，prefix_tags 将标签加上 TagConflictPrefix 前缀后添加
+	TagConflictPolicy string `json:"tag_conflict_policy,omitempty"`
+	// TagConflictPrefix prefix_tags 策略使用的前缀，默认为 tag_
+	TagConflictPrefix string `json:"tag_conflict_prefix,omitempty"`
+	// RestartPolicy runner panic 后的自动重启策略，为空时使用默认策略
+	RestartPolicy *RestartPolicy `json:"restart_policy,omitempty"`
+	// Labels runner 的标签，用于按照标签选择器批量操作 runner
+	Labels map[string]string `json:"labels,omitempty"`
 }
 
 type ErrorsList struct {
@@ -121,7 +192,7 @@ type ErrorsResult struct {
 	SendErrors      map[string][]equeue.ErrorInfo `json:"send_errors"`
 }
 
-//为了兼容之前的消息传递是errorqueue的结构
+// 为了兼容之前的消息传递是errorqueue的结构
 type CompatibleErrorResult struct {
 	ReadErrors      *ErrorStatistic            `json:"read_errors"`
 	ParseErrors     *ErrorStatistic            `json:"parse_errors"`
@@ -136,7 +207,7 @@ func NewErrorsList() *ErrorsList {
 	}
 }
 
-//Reset 清空列表
+// Reset 清空列表
 func (list *ErrorsList) Reset() {
 	list.ReadErrors = nil
 	list.ParseErrors = nil
@@ -144,7 +215,7 @@ func (list *ErrorsList) Reset() {
 	list.SendErrors = nil
 }
 
-//List 复制出一个顺序的 Errors
+// List 复制出一个顺序的 Errors
 func (list *ErrorsList) List() (dst ErrorsResult) {
 	if list.Empty() {
 		return ErrorsResult{}
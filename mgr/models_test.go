@@ -83,6 +83,30 @@ func TestRunnerStatusClone(t *testing.T) {
 		rs.SenderStats["hah"] = StatsInfo{Success: 2}
 		assert.Equal(t, exp, got)
 	}
+
+	// 文件读取进度
+	{
+		rs := &RunnerStatus{
+			ReaderFiles: []FileStatus{{Path: "a.log", Offset: 3, Size: 5, Lag: 2}},
+		}
+		got := rs.Clone()
+		assert.Equal(t, []FileStatus{{Path: "a.log", Offset: 3, Size: 5, Lag: 2}}, got.ReaderFiles)
+
+		rs.ReaderFiles[0].Offset = 5
+		assert.EqualValues(t, 3, got.ReaderFiles[0].Offset)
+	}
+	{
+		rs := &RunnerStatus{CatchUpETA: 30}
+		got := rs.Clone()
+		assert.EqualValues(t, 30, got.CatchUpETA)
+	}
+	{
+		rs := &RunnerStatus{ConfigDrift: &ConfigDrift{Kind: DriftModified, Fields: []string{"reader"}}}
+		got := rs.Clone()
+		rs.ConfigDrift.Fields[0] = "parser"
+		assert.Equal(t, DriftModified, got.ConfigDrift.Kind)
+		assert.Equal(t, []string{"reader"}, got.ConfigDrift.Fields)
+	}
 }
 
 func TestErrList(t *testing.T) {
@@ -0,0 +1,390 @@
+package mgr
+
+import (
+	"net/http"
+	"path"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/reader"
+	"github.com/qiniu/logkit/router"
+	"github.com/qiniu/logkit/sender"
+	"github.com/qiniu/logkit/sender/localstore"
+	"github.com/qiniu/logkit/utils/bundle"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const openAPIVersion = "3.0.0"
+
+// apiParam 接口的 query 参数
+type apiParam struct {
+	Name        string
+	Description string
+}
+
+// apiRoute 描述一个管理接口，Request 和 Response 为请求体和返回结果中 data 字段的类型示例，nil 表示没有
+type apiRoute struct {
+	Method      string
+	Path        string
+	Tag         string
+	Summary     string
+	Query       []apiParam
+	Request     interface{}
+	Response    interface{}
+	RawResponse bool   // 返回结果不使用 code、data 包装
+	ContentType string // RawResponse 的返回类型，为空时为 json
+}
+
+var (
+	clusterQuery = []apiParam{
+		{"tag", "只选择该标签的 slave"},
+		{"url", "只选择该地址的 slave"},
+	}
+	usagesResp  = KeyValueSlice{}
+	optionsResp = map[string][]Option{}
+)
+
+// apiRoutes 所有管理接口，在 NewRestService 中注册的接口需要同时在这里添加
+var apiRoutes = []apiRoute{
+	{Method: http.MethodGet, Path: "/status", Tag: "runner", Summary: "获取所有 runner 的运行状态", Response: map[string]RunnerStatus{}},
+	{Method: http.MethodGet, Path: "/errors", Tag: "runner", Summary: "获取所有 runner 的错误信息", Response: map[string]ErrorsResult{}},
+	{Method: http.MethodGet, Path: "/errors/:name", Tag: "runner", Summary: "获取 runner 的错误信息", Response: ErrorsResult{}},
+	{Method: http.MethodGet, Path: "/completions/:name", Tag: "runner", Summary: "获取 runner 已读完文件的校验记录", Response: []reader.CompletionRecord{}},
+	{Method: http.MethodGet, Path: "/errorcode", Tag: "runner", Summary: "获取错误码的说明", Response: map[string]string{}},
+
+	{Method: http.MethodGet, Path: "/configs", Tag: "config", Summary: "获取所有 runner 的配置，敏感信息已隐藏", Response: map[string]RunnerConfig{}},
+	{Method: http.MethodGet, Path: "/configs/:name", Tag: "config", Summary: "获取 runner 的配置", Response: RunnerConfig{}},
+	{Method: http.MethodPost, Path: "/configs/:name", Tag: "config", Summary: "添加 runner", Request: RunnerConfig{}},
+	{Method: http.MethodPost, Path: "/configs/:name/stop", Tag: "config", Summary: "停止 runner"},
+	{Method: http.MethodPost, Path: "/configs/:name/start", Tag: "config", Summary: "启动 runner"},
+	{Method: http.MethodPost, Path: "/configs/:name/reset", Tag: "config", Summary: "重置 runner，清空读取进度"},
+	{Method: http.MethodPost, Path: "/configs/:name/drain", Tag: "config", Summary: "停止读取并等待已读取的数据发送完毕后停止 runner",
+		Query: []apiParam{{"timeout", "等待的最长时间，如 30s"}}},
+	{Method: http.MethodPost, Path: "/configs/:name/flush", Tag: "config", Summary: "立即发送 runner 所有 sender 中缓存的数据，返回每个 sender 的结果",
+		Query: []apiParam{{"timeout", "每个 sender 等待的最长时间，如 30s"}}, Response: []SenderFlushResult{}},
+	{Method: http.MethodPost, Path: "/configs/:name/validate", Tag: "config", Summary: "检查配置中废弃、冲突以及可疑的配置项", Request: RunnerConfig{}, Response: ValidateResult{}},
+	{Method: http.MethodPost, Path: "/configs/:name/debug", Tag: "config", Summary: "临时打开 runner 的 debug 日志，到期后自动恢复",
+		Query: []apiParam{{"duration", "打开的时长，如 10m，为 0 时立即恢复"}}, Response: DebugResult{}},
+	{Method: http.MethodPost, Path: "/configs/:name/capture", Tag: "config", Summary: "开始抓取 runner 接下来读取的原始数据以及解析结果",
+		Query: []apiParam{{"lines", "抓取的原始数据条数，默认为 100"}}},
+	{Method: http.MethodGet, Path: "/configs/:name/capture", Tag: "config", Summary: "下载抓取结果的 tar.gz 压缩包，包含 capture.json、raw.log 和 parsed.json",
+		RawResponse: true, ContentType: "application/gzip"},
+	{Method: http.MethodGet, Path: "/configs/:name/requests", Tag: "config", Summary: "获取开启了 http_sender_capture_requests 的 sender 最近发送的请求，敏感信息已隐藏",
+		Response: []SenderRequests{}},
+	{Method: http.MethodPost, Path: "/configs/:name/requests/:index/:id/replay", Tag: "config", Summary: "重新发送第 index 个 sender 记录的请求，返回重放的结果",
+		Response: sender.CapturedRequest{}},
+	{Method: http.MethodPost, Path: "/configs/:name/headpattern", Tag: "config", Summary: "设置 runner 多行日志的行首正则并重启 runner，没有指定 head_pattern 时根据 samples 推断",
+		Request: HeadPatternRequest{}, Response: reader.HeadPatternSuggestion{}},
+	{Method: http.MethodPut, Path: "/configs/:name", Tag: "config", Summary: "更新 runner 的配置", Request: RunnerConfig{}},
+	{Method: http.MethodDelete, Path: "/configs/:name", Tag: "config", Summary: "删除 runner"},
+
+	{Method: http.MethodGet, Path: "/runners", Tag: "runner", Summary: "获取 runner 名称列表",
+		Query: []apiParam{{"selector", "标签选择器，如 env=prod,team!=ops"}}, Response: []string{}},
+	{Method: http.MethodPost, Path: "/runners/bulk/:op", Tag: "runner", Summary: "批量操作标签满足选择器的 runner，op 为 start、stop、drain 或者 delete",
+		Request: BulkRequest{}, Response: []BulkResult{}},
+
+	{Method: http.MethodGet, Path: "/reader/usages", Tag: "reader", Summary: "获取 reader 的类型说明", Response: usagesResp},
+	{Method: http.MethodGet, Path: "/reader/tooltips", Tag: "reader", Summary: "获取 reader 的类型提示", Response: usagesResp},
+	{Method: http.MethodGet, Path: "/reader/options", Tag: "reader", Summary: "获取 reader 的配置项", Response: optionsResp},
+	{Method: http.MethodPost, Path: "/reader/read", Tag: "reader", Summary: "按照 reader 配置读取样例数据", Request: conf.MapConf{}, Response: []string{}},
+	{Method: http.MethodPost, Path: "/reader/check", Tag: "reader", Summary: "检查 reader 配置", Request: conf.MapConf{}},
+	{Method: http.MethodPost, Path: "/reader/headpattern", Tag: "reader", Summary: "根据样例日志推断多行日志的行首正则",
+		Request: HeadPatternRequest{}, Response: reader.HeadPatternSuggestion{}},
+
+	{Method: http.MethodGet, Path: "/cleaner/options", Tag: "cleaner", Summary: "获取 cleaner 的配置项", Response: []Option{}},
+
+	{Method: http.MethodGet, Path: "/parser/usages", Tag: "parser", Summary: "获取 parser 的类型说明", Response: usagesResp},
+	{Method: http.MethodGet, Path: "/parser/tooltips", Tag: "parser", Summary: "获取 parser 的类型提示", Response: usagesResp},
+	{Method: http.MethodGet, Path: "/parser/options", Tag: "parser", Summary: "获取 parser 的配置项", Response: optionsResp},
+	{Method: http.MethodPost, Path: "/parser/parse", Tag: "parser", Summary: "使用 parser 配置解析 sampleLog 中的样例数据", Request: conf.MapConf{}, Response: PostParseRet{}},
+	{Method: http.MethodGet, Path: "/parser/samplelogs", Tag: "parser", Summary: "获取各类 parser 的样例日志", Response: map[string]string{}},
+	{Method: http.MethodPost, Path: "/parser/check", Tag: "parser", Summary: "检查 parser 配置", Request: conf.MapConf{}},
+
+	{Method: http.MethodGet, Path: "/transformer/usages", Tag: "transformer", Summary: "获取 transformer 的类型说明", Response: usagesResp},
+	{Method: http.MethodGet, Path: "/transformer/options", Tag: "transformer", Summary: "获取 transformer 的配置项", Response: optionsResp},
+	{Method: http.MethodGet, Path: "/transformer/sampleconfigs", Tag: "transformer", Summary: "获取 transformer 的样例配置", Response: map[string]string{}},
+	{Method: http.MethodPost, Path: "/transformer/transform", Tag: "transformer", Summary: "使用 transformer 配置处理 sampleLog 中的样例数据", Request: map[string]interface{}{}, Response: []Data{}},
+	{Method: http.MethodPost, Path: "/transformer/check", Tag: "transformer", Summary: "检查 transformer 配置", Request: map[string]interface{}{}},
+
+	{Method: http.MethodGet, Path: "/sender/usages", Tag: "sender", Summary: "获取 sender 的类型说明", Response: usagesResp},
+	{Method: http.MethodGet, Path: "/sender/options", Tag: "sender", Summary: "获取 sender 的配置项", Response: optionsResp},
+	{Method: http.MethodPost, Path: "/sender/send", Tag: "sender", Summary: "使用 sender 配置发送 sampleLog 中的样例数据", Request: map[string]interface{}{}},
+	{Method: http.MethodPost, Path: "/sender/check", Tag: "sender", Summary: "检查 sender 配置", Request: map[string]interface{}{}},
+	{Method: http.MethodPost, Path: "/sender/preview", Tag: "sender", Summary: "生成发送 sampleLog 中样例数据的请求但不发送，用于预览数据模板的渲染结果",
+		Request: map[string]interface{}{}, Response: []SenderRequests{}},
+	{Method: http.MethodGet, Path: "/sender/router/usage", Tag: "sender", Summary: "获取 sender router 匹配方式的说明", Response: usagesResp},
+	{Method: http.MethodGet, Path: "/sender/router/option", Tag: "sender", Summary: "获取 sender router 的配置项", Response: []Option{}},
+	{Method: http.MethodGet, Path: "/localstore/:name", Tag: "sender", Summary: "按时间从新到旧查询 runner 的 localstore sender 保存的数据",
+		Query: []apiParam{
+			{"start", "开始时间，RFC3339 格式"},
+			{"end", "结束时间，RFC3339 格式"},
+			{"filter", "字段过滤条件，格式为 key:value，可以指定多个"},
+			{"keyword", "只返回包含该关键字的数据"},
+			{"limit", "最多返回的条数，默认 100，最大 10000"},
+		}, Response: []localstore.Record{}},
+
+	{Method: http.MethodGet, Path: "/metric/keys", Tag: "metric", Summary: "获取各类 metric 的字段", Response: map[string]interface{}{}},
+	{Method: http.MethodGet, Path: "/metric/usages", Tag: "metric", Summary: "获取 metric 的类型说明", Response: []Option{}},
+	{Method: http.MethodGet, Path: "/metric/options", Tag: "metric", Summary: "获取 metric 的配置项", Response: map[string]interface{}{}},
+
+	{Method: http.MethodGet, Path: "/version", Tag: "system", Summary: "获取 logkit 版本", Response: Version{}},
+	{Method: http.MethodGet, Path: "/openapi.json", Tag: "system", Summary: "获取管理接口的 OpenAPI 文档", Response: map[string]interface{}{}, RawResponse: true},
+
+	{Method: http.MethodGet, Path: "/bundles", Tag: "bundle", Summary: "获取所有数据包的状态", Response: []bundle.Status{}},
+	{Method: http.MethodPost, Path: "/bundles/:name/update", Tag: "bundle", Summary: "立即检查并更新数据包"},
+	{Method: http.MethodPost, Path: "/bundles/:name/rollback", Tag: "bundle", Summary: "将数据包回滚到上一个版本"},
+	{Method: http.MethodGet, Path: "/quotas", Tag: "quota", Summary: "获取所有配额的用量", Response: []QuotaStatus{}},
+	{Method: http.MethodGet, Path: "/drift", Tag: "config", Summary: "获取正在运行的 runner 与配置文件之间的偏差", Response: []ConfigDrift{}},
+	{Method: http.MethodGet, Path: "/recovery", Tag: "runner", Summary: "获取 runner 启动时恢复的读取位置、发送队列积压和死信大小", Response: []RecoveryReport{}},
+
+	{Method: http.MethodGet, Path: "/cluster/ping", Tag: "cluster", Summary: "检查 master 是否可用"},
+	{Method: http.MethodGet, Path: "/cluster/ismaster", Tag: "cluster", Summary: "判断是否为 master", Response: false},
+	{Method: http.MethodPost, Path: "/cluster/register", Tag: "cluster", Summary: "slave 向 master 注册", Request: RegisterReq{}},
+	{Method: http.MethodPost, Path: "/cluster/tag", Tag: "cluster", Summary: "修改 slave 的标签", Request: TagReq{}},
+	{Method: http.MethodGet, Path: "/cluster/slaves", Tag: "cluster", Summary: "获取 slave 列表", Query: clusterQuery, Response: []Slave{}},
+	{Method: http.MethodDelete, Path: "/cluster/slaves", Tag: "cluster", Summary: "删除 slave", Query: clusterQuery},
+	{Method: http.MethodPost, Path: "/cluster/slaves/tag", Tag: "cluster", Summary: "修改 slave 的标签", Query: clusterQuery, Request: TagReq{}},
+	{Method: http.MethodGet, Path: "/cluster/status", Tag: "cluster", Summary: "获取所有 slave 的 runner 状态", Query: clusterQuery, Response: map[string]ClusterStatus{}},
+	{Method: http.MethodGet, Path: "/cluster/runners", Tag: "cluster", Summary: "获取所有 slave 的 runner 名称列表", Query: clusterQuery, Response: []string{}},
+	{Method: http.MethodGet, Path: "/cluster/configs", Tag: "cluster", Summary: "获取所有 slave 的 runner 配置", Query: clusterQuery, Response: map[string]SlaveConfig{}},
+	{Method: http.MethodGet, Path: "/cluster/configs/:name", Tag: "cluster", Summary: "获取 slave 上 runner 的配置", Query: clusterQuery, Response: RunnerConfig{}},
+	{Method: http.MethodPost, Path: "/cluster/configs/:name", Tag: "cluster", Summary: "在 slave 上添加 runner", Query: clusterQuery, Request: RunnerConfig{}},
+	{Method: http.MethodPut, Path: "/cluster/configs/:name", Tag: "cluster", Summary: "更新 slave 上 runner 的配置", Query: clusterQuery, Request: RunnerConfig{}},
+	{Method: http.MethodDelete, Path: "/cluster/configs/:name", Tag: "cluster", Summary: "删除 slave 上的 runner", Query: clusterQuery},
+	{Method: http.MethodPost, Path: "/cluster/configs/:name/stop", Tag: "cluster", Summary: "停止 slave 上的 runner", Query: clusterQuery},
+	{Method: http.MethodPost, Path: "/cluster/configs/:name/start", Tag: "cluster", Summary: "启动 slave 上的 runner", Query: clusterQuery},
+	{Method: http.MethodPost, Path: "/cluster/configs/:name/reset", Tag: "cluster", Summary: "重置 slave 上的 runner", Query: clusterQuery},
+}
+
+var pathParamRegex = regexp.MustCompile(`:(\w+)`)
+
+// schemaBuilder 根据 go 类型生成 json schema，具名的结构体放在 components 中引用
+type schemaBuilder struct {
+	schemas map[string]interface{}
+	names   map[reflect.Type]string
+}
+
+var (
+	timeType        = reflect.TypeOf(time.Time{})
+	durationType    = reflect.TypeOf(time.Duration(0))
+	routerConfType  = reflect.TypeOf(router.RouterConfig{})
+	emptyJSONSchema = map[string]interface{}{}
+)
+
+func (b *schemaBuilder) schema(t reflect.Type) map[string]interface{} {
+	if t == nil {
+		return emptyJSONSchema
+	}
+	switch t {
+	case timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case durationType:
+		return map[string]interface{}{"type": "integer", "format": "int64", "description": "纳秒"}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return b.schema(t.Elem())
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]interface{}{"type": "integer", "format": "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": b.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + b.define(t)}
+	}
+	// interface{} 等无法确定的类型
+	return emptyJSONSchema
+}
+
+// define 在 components 中定义具名结构体，不同包中同名的结构体加上包名区分
+func (b *schemaBuilder) define(t reflect.Type) string {
+	if name, ok := b.names[t]; ok {
+		return name
+	}
+	name := t.Name()
+	if _, ok := b.schemas[name]; ok {
+		name = strings.Title(path.Base(t.PkgPath())) + name
+	}
+	b.names[t] = name
+	// 先占位，避免结构体中引用自身时无限递归
+	b.schemas[name] = emptyJSONSchema
+	b.schemas[name] = b.structSchema(t)
+	return name
+}
+
+// structSchema 按照 encoding/json 的规则生成结构体的字段，匿名嵌入的结构体字段会展开
+func (b *schemaBuilder) structSchema(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	b.addFields(t, properties)
+	return map[string]interface{}{"type": "object", "properties": properties}
+}
+
+func (b *schemaBuilder) addFields(t reflect.Type, properties map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		ft := field.Type
+		if field.Anonymous && name == "" {
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				b.addFields(ft, properties)
+				continue
+			}
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		if ft.Kind() == reflect.Chan || ft.Kind() == reflect.Func {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		// router 配置中有 interface{} 等复杂字段，直接按照 object 处理
+		if ft == routerConfType {
+			properties[name] = map[string]interface{}{"type": "object"}
+			continue
+		}
+		properties[name] = b.schema(ft)
+	}
+}
+
+func jsonContent(schema interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"application/json": map[string]interface{}{"schema": schema},
+	}
+}
+
+// openAPISpec 生成管理接口的文档(OpenAPI 3.0)，除了 openapi.json 自身，所有接口的返回结果都使用 code、data 包装
+func openAPISpec(version string) map[string]interface{} {
+	b := &schemaBuilder{
+		schemas: map[string]interface{}{
+			"Error": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"code":    map[string]interface{}{"type": "string"},
+					"message": map[string]interface{}{"type": "string"},
+				},
+			},
+		},
+		names: map[reflect.Type]string{},
+	}
+	errorResp := map[string]interface{}{
+		"description": "请求失败",
+		"content":     jsonContent(map[string]interface{}{"$ref": "#/components/schemas/Error"}),
+	}
+
+	paths := map[string]interface{}{}
+	for _, route := range apiRoutes {
+		op := map[string]interface{}{
+			"summary":     route.Summary,
+			"tags":        []string{route.Tag},
+			"operationId": strings.ToLower(route.Method) + pathParamRegex.ReplaceAllString(route.Path, "{$1}"),
+		}
+
+		var params []interface{}
+		for _, m := range pathParamRegex.FindAllStringSubmatch(route.Path, -1) {
+			params = append(params, map[string]interface{}{
+				"name":     m[1],
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]interface{}{"type": "string"},
+			})
+		}
+		for _, q := range route.Query {
+			params = append(params, map[string]interface{}{
+				"name":        q.Name,
+				"in":          "query",
+				"description": q.Description,
+				"schema":      map[string]interface{}{"type": "string"},
+			})
+		}
+		if len(params) > 0 {
+			op["parameters"] = params
+		}
+
+		if route.Request != nil {
+			op["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  jsonContent(b.schema(reflect.TypeOf(route.Request))),
+			}
+		}
+
+		var respSchema map[string]interface{}
+		respContent := jsonContent
+		if route.RawResponse && route.ContentType != "" {
+			respSchema = map[string]interface{}{"type": "string", "format": "binary"}
+			respContent = func(schema interface{}) map[string]interface{} {
+				return map[string]interface{}{route.ContentType: map[string]interface{}{"schema": schema}}
+			}
+		} else if route.RawResponse {
+			respSchema = b.schema(reflect.TypeOf(route.Response))
+		} else {
+			properties := map[string]interface{}{
+				"code": map[string]interface{}{"type": "string", "example": ErrNothing},
+			}
+			if route.Response != nil {
+				properties["data"] = b.schema(reflect.TypeOf(route.Response))
+			}
+			respSchema = map[string]interface{}{"type": "object", "properties": properties}
+		}
+		op["responses"] = map[string]interface{}{
+			"200": map[string]interface{}{
+				"description": "请求成功",
+				"content":     respContent(respSchema),
+			},
+			"default": errorResp,
+		}
+
+		specPath := PREFIX + pathParamRegex.ReplaceAllString(route.Path, "{$1}")
+		item, ok := paths[specPath].(map[string]interface{})
+		if !ok {
+			item = map[string]interface{}{}
+			paths[specPath] = item
+		}
+		item[strings.ToLower(route.Method)] = op
+	}
+
+	return map[string]interface{}{
+		"openapi": openAPIVersion,
+		"info": map[string]interface{}{
+			"title":   "logkit management API",
+			"version": version,
+		},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": b.schemas},
+	}
+}
+
+// get /logkit/openapi.json 获取管理接口的 OpenAPI 文档，文档直接返回，不使用 code、data 包装
+func (rs *RestService) GetOpenAPI() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return c.JSON(http.StatusOK, openAPISpec(rs.mgr.Version))
+	}
+}
@@ -0,0 +1,70 @@
+package mgr
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenAPISpec(t *testing.T) {
+	router := echo.New()
+	m := &Manager{ManagerConfig: ManagerConfig{DisableWeb: true}, Version: "v1.0.0"}
+	NewRestService(m, router)
+
+	req := httptest.NewRequest(http.MethodGet, PREFIX+"/openapi.json", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var spec struct {
+		OpenAPI string `json:"openapi"`
+		Info    struct {
+			Version string `json:"version"`
+		} `json:"info"`
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]json.RawMessage `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &spec))
+	assert.Equal(t, openAPIVersion, spec.OpenAPI)
+	assert.Equal(t, "v1.0.0", spec.Info.Version)
+
+	// 所有注册的接口都需要有文档，文档中也不能有不存在的接口
+	registered := make(map[string]bool)
+	for _, route := range router.Routes() {
+		if !strings.HasPrefix(route.Path, PREFIX) {
+			continue
+		}
+		path := pathParamRegex.ReplaceAllString(route.Path, "{$1}")
+		key := strings.ToLower(route.Method) + " " + path
+		registered[key] = true
+		_, ok := spec.Paths[path][strings.ToLower(route.Method)]
+		assert.True(t, ok, "%v is not documented", key)
+	}
+	for path, item := range spec.Paths {
+		for method := range item {
+			assert.True(t, registered[method+" "+path], "%v %v is not registered", method, path)
+		}
+	}
+
+	// 匿名嵌入的结构体字段需要展开，json 中忽略的字段不出现
+	runnerConfig := spec.Components.Schemas["RunnerConfig"].Properties
+	assert.Contains(t, runnerConfig, "name")
+	assert.Contains(t, runnerConfig, "labels")
+	assert.Contains(t, runnerConfig, "reader")
+	assert.NotContains(t, runnerConfig, "AuditChan")
+	assert.NotContains(t, runnerConfig, "RunnerInfo")
+	assert.Contains(t, spec.Components.Schemas["RunnerStatus"].Properties, "shadowStats")
+	assert.NotContains(t, spec.Components.Schemas["RunnerStatus"].Properties, "lastState")
+	assert.Contains(t, spec.Components.Schemas, "ShadowStats")
+	assert.Contains(t, spec.Components.Schemas, "Error")
+}
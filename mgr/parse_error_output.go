@@ -0,0 +1,143 @@
+package mgr
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/parser"
+	parserconfig "github.com/qiniu/logkit/parser/config"
+	"github.com/qiniu/logkit/sender"
+	senderConf "github.com/qiniu/logkit/sender/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const (
+	// ParseErrorStatsPrefix 解析失败旁路 sender 的统计信息在 SenderStats 中的名称前缀
+	ParseErrorStatsPrefix = "parse_error_"
+
+	// 旁路输出的字段
+	ParseErrorFieldRaw       = "raw"
+	ParseErrorFieldError     = "error"
+	ParseErrorFieldSource    = "source"
+	ParseErrorFieldRunner    = "runner"
+	ParseErrorFieldParser    = "parser"
+	ParseErrorFieldTimestamp = "timestamp"
+)
+
+// ParseErrorOutputConfig 解析失败数据的旁路输出，将解析失败的原始数据连同失败原因和数据源发送到单独的 sender(如 file sender)，
+// 便于离线修正解析规则。依赖解析器在 pandora_stash 中保留解析失败的数据，因此不能与 disable_record_errdata 同时使用
+type ParseErrorOutputConfig struct {
+	// SenderConfig 接收解析失败数据的 sender 的配置
+	SenderConfig conf.MapConf `json:"sender"`
+	// KeepInMain 为 true 时解析失败的数据仍然以 pandora_stash 的形式发送到主 sender，默认只发送到旁路
+	KeepInMain bool `json:"keep_in_main,omitempty"`
+	// QueueSize 等待发送的批次数，队列满时丢弃并计入错误，避免拖慢主流程
+	QueueSize int `json:"queue_size,omitempty"`
+}
+
+// parseErrorOutput 在独立的协程中发送解析失败的数据，发送方式与灰度 sender 相同，失败不重试也不影响主 sender
+type parseErrorOutput struct {
+	*canary
+	keepInMain bool
+}
+
+func newParseErrorOutput(runnerName string, c *ParseErrorOutputConfig, parserConf conf.MapConf, sr *sender.Registry, ftSaveLogPath string) (*parseErrorOutput, error) {
+	if c == nil {
+		return nil, nil
+	}
+	if len(c.SenderConfig) == 0 {
+		return nil, errors.New("parse_error_output sender config is empty")
+	}
+	if disabled, _ := parserConf.GetBoolOr(parserconfig.KeyDisableRecordErrData, false); disabled {
+		return nil, fmt.Errorf("runner %v parse_error_output can not be used when parser %v is enabled", runnerName, parserconfig.KeyDisableRecordErrData)
+	}
+	senderConfig := conf.MapConf{}
+	for k, v := range c.SenderConfig {
+		senderConfig[k] = v
+	}
+	name := senderConfig[senderConf.KeyName]
+	if name == "" {
+		name = senderConfig[senderConf.KeySenderType]
+	}
+	// 使用单独的磁盘队列目录，避免与同类型的主 sender 冲突
+	s, err := sr.NewSender(senderConfig, filepath.Join(ftSaveLogPath, "parse_error"))
+	if err != nil {
+		return nil, fmt.Errorf("runner %v create parse_error_output sender error, %v", runnerName, err)
+	}
+	return &parseErrorOutput{
+		canary:     startCanary(ParseErrorStatsPrefix+name, 100, c.QueueSize, s),
+		keepInMain: c.KeepInMain,
+	}, nil
+}
+
+// dataSources 按照 addSourceToData 的规则返回每条数据对应的数据源，无法对应时为空
+func dataSources(froms []string, se *StatsError, n int) []string {
+	sources := make([]string, n)
+	eql := len(froms) == n
+	j := 0
+	for i, from := range froms {
+		if j >= n {
+			break
+		}
+		if !eql && se != nil && se.ErrorIndexIn(i) {
+			continue
+		}
+		sources[j] = from
+		j++
+	}
+	return sources
+}
+
+// splitParseErrors 将解析失败的数据(带有 pandora_stash 的数据)发送到旁路，没有配置 keep_in_main 时从 datas 中去掉。
+// 多条数据解析失败时逐条重新解析得到各自的失败原因，需要跨行保存状态的解析器只能使用整批的最后一个错误
+func (r *LogExportRunner) splitParseErrors(datas []Data, froms []string, se *StatsError) []Data {
+	if r.parseErrors == nil || se == nil || se.Errors == 0 {
+		return datas
+	}
+	var (
+		sources []string
+		errs    []Data
+		kept    = datas[:0]
+		now     = time.Now().Format(time.RFC3339Nano)
+	)
+	if len(froms) >= len(datas) {
+		sources = dataSources(froms, se, len(datas))
+	}
+	_, flushable := r.parser.(parser.Flushable)
+	for i, data := range datas {
+		raw, ok := data[KeyPandoraStash].(string)
+		if !ok {
+			kept = append(kept, data)
+			continue
+		}
+		reason := se.LastError
+		if se.Errors > 1 && !flushable {
+			if _, err := r.parser.Parse([]string{raw}); err != nil {
+				if pse, ok := err.(*StatsError); !ok {
+					reason = err.Error()
+				} else if pse.LastError != "" {
+					reason = pse.LastError
+				}
+			}
+		}
+		e := Data{
+			ParseErrorFieldRaw:       raw,
+			ParseErrorFieldError:     reason,
+			ParseErrorFieldRunner:    r.RunnerName,
+			ParseErrorFieldParser:    r.parser.Name(),
+			ParseErrorFieldTimestamp: now,
+		}
+		if sources != nil && sources[i] != "" {
+			e[ParseErrorFieldSource] = sources[i]
+		}
+		errs = append(errs, e)
+		if r.parseErrors.keepInMain {
+			kept = append(kept, data)
+		}
+	}
+	r.parseErrors.Feed(errs)
+	return kept
+}
@@ -0,0 +1,85 @@
+package mgr
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/sender"
+	"github.com/qiniu/logkit/sender/mock"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+// stashParser 以 bad 开头的行解析失败，原始数据放在 pandora_stash 中
+type stashParser struct{}
+
+func (p *stashParser) Name() string { return "stash" }
+
+func (p *stashParser) Parse(lines []string) ([]Data, error) {
+	se := &StatsError{}
+	var datas []Data
+	for _, line := range lines {
+		if strings.HasPrefix(line, "bad") {
+			se.AddErrors()
+			se.LastError = "invalid " + line
+			datas = append(datas, Data{KeyPandoraStash: line})
+			continue
+		}
+		se.AddSuccess()
+		datas = append(datas, Data{"line": line})
+	}
+	return datas, se
+}
+
+func TestParseErrorOutput(t *testing.T) {
+	sr := sender.NewRegistry()
+	_, err := newParseErrorOutput("test", &ParseErrorOutputConfig{}, conf.MapConf{}, sr, "")
+	assert.Error(t, err)
+	_, err = newParseErrorOutput("test", &ParseErrorOutputConfig{SenderConfig: conf.MapConf{"sender_type": "mock"}},
+		conf.MapConf{"disable_record_errdata": "true"}, sr, "")
+	assert.Error(t, err)
+	pe, err := newParseErrorOutput("test", nil, conf.MapConf{}, sr, "")
+	assert.NoError(t, err)
+	assert.Nil(t, pe)
+
+	s, err := mock.NewSender(conf.MapConf{"name": "errors"})
+	assert.NoError(t, err)
+	r := &LogExportRunner{parser: &stashParser{}}
+	r.RunnerName = "test"
+	r.parseErrors = &parseErrorOutput{canary: startCanary(ParseErrorStatsPrefix+"errors", 100, 0, s)}
+
+	lines := []string{"ok1", "bad1", "ok2", "bad2"}
+	froms := []string{"a.log", "a.log", "b.log", "b.log"}
+	datas, err := r.parser.Parse(lines)
+	kept := r.splitParseErrors(datas, froms, err.(*StatsError))
+	assert.Equal(t, []Data{{"line": "ok1"}, {"line": "ok2"}}, kept)
+	assert.NoError(t, r.parseErrors.Close())
+	assert.Equal(t, StatsInfo{Success: 2}, r.parseErrors.Stats())
+
+	// 每条数据有各自的失败原因和数据源
+	errs := s.(*mock.Sender).Datas
+	assert.Len(t, errs, 2)
+	for i, raw := range []string{"bad1", "bad2"} {
+		assert.Equal(t, raw, errs[i][ParseErrorFieldRaw])
+		assert.Equal(t, "invalid "+raw, errs[i][ParseErrorFieldError])
+		assert.Equal(t, froms[2*i+1], errs[i][ParseErrorFieldSource])
+		assert.Equal(t, "test", errs[i][ParseErrorFieldRunner])
+		assert.Equal(t, "stash", errs[i][ParseErrorFieldParser])
+		assert.NotEmpty(t, errs[i][ParseErrorFieldTimestamp])
+	}
+
+	// keep_in_main 时主 sender 仍然发送解析失败的数据，没有解析失败时不发送到旁路
+	s, err = mock.NewSender(conf.MapConf{"name": "errors"})
+	assert.NoError(t, err)
+	r.parseErrors = &parseErrorOutput{canary: startCanary(ParseErrorStatsPrefix+"errors", 100, 0, s), keepInMain: true}
+	datas, err = r.parser.Parse(lines)
+	assert.Len(t, r.splitParseErrors(datas, nil, err.(*StatsError)), 4)
+	datas, err = r.parser.Parse([]string{"ok3"})
+	assert.Len(t, r.splitParseErrors(datas, nil, err.(*StatsError)), 1)
+	assert.NoError(t, r.parseErrors.Close())
+	errs = s.(*mock.Sender).Datas
+	assert.Len(t, errs, 2)
+	assert.Nil(t, errs[0][ParseErrorFieldSource])
+}
@@ -0,0 +1,154 @@
+package mgr
+
+import (
+	"hash/fnv"
+	"strconv"
+	"sync"
+
+	"github.com/qiniu/logkit/parser"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+// minParallelParseLines 每个解析协程至少分到的行数，行数太少时并行解析的收益抵不上调度的开销
+const minParallelParseLines = 256
+
+// parse 解析一批数据，配置了 parse_workers 时将数据分段后并行解析，再按分段的顺序合并结果。
+// parse_order 为 source(默认)时按 keys 中的数据源哈希分段，同一数据源的数据总是由同一个协程按读取顺序解析，
+// 返回的 froms 与合并后的顺序一致；为 none 时将数据按顺序平均分段。
+// 需要跨行保存状态的解析器(Flushable)只能串行解析
+func (r *LogExportRunner) parse(lines, froms, keys []string) ([]Data, []string, error) {
+	workers := r.ParseWorkers
+	if max := len(lines) / minParallelParseLines; workers > max {
+		workers = max
+	}
+	if workers <= 1 {
+		datas, err := r.parser.Parse(lines)
+		return datas, froms, err
+	}
+	if _, ok := r.parser.(parser.Flushable); ok {
+		datas, err := r.parser.Parse(lines)
+		return datas, froms, err
+	}
+
+	var bounds []int
+	// 前置 transformer 改变了行数时无法对应数据源，只能平均分段
+	if r.ParseOrder != ParseOrderNone && len(keys) == len(lines) {
+		lines, froms, bounds = groupBySource(lines, froms, keys, workers)
+	} else {
+		bounds = make([]int, workers+1)
+		for i := 1; i <= workers; i++ {
+			bounds[i] = len(lines) * i / workers
+		}
+	}
+
+	type result struct {
+		datas []Data
+		err   error
+	}
+	var (
+		wg      sync.WaitGroup
+		results = make([]result, workers)
+	)
+	for i := 0; i < workers; i++ {
+		if bounds[i] == bounds[i+1] {
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			datas, err := r.parser.Parse(lines[bounds[i]:bounds[i+1]])
+			results[i] = result{datas: datas, err: err}
+		}(i)
+	}
+	wg.Wait()
+
+	// 合并各段的统计，DatasourceSkipIndex 需要加上分段的起始位置
+	var (
+		datas []Data
+		se    = &StatsError{}
+	)
+	for i, res := range results {
+		datas = append(datas, res.datas...)
+		switch err := res.err.(type) {
+		case nil:
+			se.Success += int64(bounds[i+1] - bounds[i])
+		case *StatsError:
+			se.Success += err.Success
+			se.Errors += err.Errors
+			if err.LastError != "" {
+				se.LastError = err.LastError
+			}
+			for _, idx := range err.DatasourceSkipIndex {
+				se.DatasourceSkipIndex = append(se.DatasourceSkipIndex, idx+bounds[i])
+			}
+		default:
+			se.Errors++
+			se.LastError = err.Error()
+		}
+	}
+	return datas, froms, se
+}
+
+// parseWithRecords 与 parse 相同，records 为每条数据需要附加的字段，与 lines 一一对应时按照并行解析后的顺序与 froms 一起重新排列
+func (r *LogExportRunner) parseWithRecords(lines, froms, keys []string, records []map[string]interface{}) ([]Data, []string, []map[string]interface{}, error) {
+	if len(records) == 0 || len(records) != len(lines) {
+		datas, froms, err := r.parse(lines, froms, keys)
+		return datas, froms, records, err
+	}
+	// 用序号代替 froms 传给 parse，再按返回的序号排列 froms 和 records
+	indexes := make([]string, len(records))
+	for i := range indexes {
+		indexes[i] = strconv.Itoa(i)
+	}
+	datas, indexes, err := r.parse(lines, indexes, keys)
+	sortedRecords := make([]map[string]interface{}, 0, len(records))
+	var sortedFroms []string
+	if len(froms) == len(records) {
+		sortedFroms = make([]string, 0, len(froms))
+	}
+	for _, index := range indexes {
+		i, _ := strconv.Atoi(index)
+		sortedRecords = append(sortedRecords, records[i])
+		if sortedFroms != nil {
+			sortedFroms = append(sortedFroms, froms[i])
+		}
+	}
+	if sortedFroms == nil {
+		sortedFroms = froms
+	}
+	return datas, sortedFroms, sortedRecords, err
+}
+
+// groupBySource 按数据源的哈希将数据分给 workers 个协程，返回按协程重新排列后的数据以及每个协程的分段边界，
+// 同一数据源的数据在重新排列后保持原有的顺序。froms 与 lines 一一对应时一起重新排列
+func groupBySource(lines, froms, keys []string, workers int) ([]string, []string, []int) {
+	groups := make([][]int, workers)
+	for i, key := range keys {
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		w := h.Sum32() % uint32(workers)
+		groups[w] = append(groups[w], i)
+	}
+
+	var (
+		sorted      = make([]string, 0, len(lines))
+		sortedFroms []string
+		bounds      = make([]int, workers+1)
+	)
+	if len(froms) == len(lines) {
+		sortedFroms = make([]string, 0, len(froms))
+	}
+	for w, group := range groups {
+		for _, i := range group {
+			sorted = append(sorted, lines[i])
+			if sortedFroms != nil {
+				sortedFroms = append(sortedFroms, froms[i])
+			}
+		}
+		bounds[w+1] = len(sorted)
+	}
+	if sortedFroms == nil {
+		sortedFroms = froms
+	}
+	return sorted, sortedFroms, bounds
+}
@@ -0,0 +1,147 @@
+package mgr
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+// lineParser 将每行解析为 {"line": 行}，内容为 bad 的行解析失败
+type lineParser struct{}
+
+func (p *lineParser) Name() string { return "line" }
+
+func (p *lineParser) Parse(lines []string) ([]Data, error) {
+	se := &StatsError{}
+	var datas []Data
+	for i, line := range lines {
+		if line == "bad" {
+			se.AddErrors()
+			se.LastError = "bad line"
+			se.DatasourceSkipIndex = append(se.DatasourceSkipIndex, i)
+			continue
+		}
+		se.AddSuccess()
+		datas = append(datas, Data{"line": line})
+	}
+	return datas, se
+}
+
+func TestParseWorkers(t *testing.T) {
+	var lines []string
+	for i := 0; i < 2000; i++ {
+		if i%500 == 7 {
+			lines = append(lines, "bad")
+			continue
+		}
+		lines = append(lines, fmt.Sprint(i))
+	}
+
+	r := &LogExportRunner{parser: &lineParser{}}
+	serial, _, serr := r.parse(lines, nil, nil)
+
+	r.ParseWorkers = 4
+	datas, _, err := r.parse(lines, nil, nil)
+	assert.Equal(t, serial, datas)
+	se, ok := err.(*StatsError)
+	assert.True(t, ok)
+	assert.Equal(t, int64(4), se.Errors)
+	assert.Equal(t, int64(1996), se.Success)
+	assert.Equal(t, "bad line", se.LastError)
+	assert.Equal(t, serr.(*StatsError).DatasourceSkipIndex, se.DatasourceSkipIndex)
+	assert.Equal(t, []int{7, 507, 1007, 1507}, se.DatasourceSkipIndex)
+
+	// 行数太少时串行解析
+	datas, _, err = r.parse(lines[:10], nil, nil)
+	assert.Len(t, datas, 9)
+	assert.Equal(t, int64(1), err.(*StatsError).Errors)
+}
+
+func TestParseWorkersSourceOrder(t *testing.T) {
+	var lines, froms []string
+	for i := 0; i < 2000; i++ {
+		from := fmt.Sprintf("file%d", i%7)
+		froms = append(froms, from)
+		if i == 100 {
+			lines = append(lines, "bad")
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s %d", from, i))
+	}
+
+	r := &LogExportRunner{parser: &lineParser{}}
+	r.ParseWorkers = 4
+	datas, sorted, err := r.parse(lines, froms, froms)
+	se := err.(*StatsError)
+	assert.Equal(t, int64(1), se.Errors)
+	assert.Len(t, datas, 1999)
+	assert.Len(t, sorted, 2000)
+
+	// 返回的 froms 跳过解析失败的行后与数据一一对应，同一数据源的数据保持读取的顺序
+	datas = addSourceToData(sorted, se, datas, "source", "TestParseWorkersSourceOrder")
+	last := make(map[string]int)
+	for _, data := range datas {
+		var (
+			from string
+			idx  int
+		)
+		_, err := fmt.Sscanf(data["line"].(string), "%s %d", &from, &idx)
+		assert.NoError(t, err)
+		assert.Equal(t, from, data["source"])
+		if prev, ok := last[from]; ok {
+			assert.True(t, idx > prev, "%s: %d after %d", from, idx, prev)
+		}
+		last[from] = idx
+	}
+	assert.Len(t, last, 7)
+
+	// 前置 transformer 改变了行数时平均分段
+	datas, _, _ = r.parse(lines, nil, froms[1:])
+	assert.Equal(t, "file0 0", datas[0]["line"])
+
+	// none 不按数据源分组
+	r.ParseOrder = ParseOrderNone
+	serial, _ := (&lineParser{}).Parse(lines)
+	datas, sorted, _ = r.parse(lines, froms, froms)
+	assert.Equal(t, serial, datas)
+	assert.Equal(t, froms, sorted)
+}
+
+func TestParseWithRecords(t *testing.T) {
+	var lines, froms []string
+	var records []map[string]interface{}
+	for i := 0; i < 2000; i++ {
+		from := fmt.Sprintf("topic/%d", i%5)
+		froms = append(froms, from)
+		lines = append(lines, fmt.Sprintf("%s %d", from, i))
+		records = append(records, map[string]interface{}{"kafka_offset": int64(i)})
+	}
+
+	r := &LogExportRunner{parser: &lineParser{}}
+	r.ParseWorkers = 4
+	datas, sortedFroms, sortedRecords, err := r.parseWithRecords(lines, froms, froms, records)
+	assert.Equal(t, int64(0), err.(*StatsError).Errors)
+	assert.Len(t, sortedFroms, 2000)
+	assert.Len(t, sortedRecords, 2000)
+
+	// records 与 froms 按照并行解析后的顺序重新排列，仍然与数据一一对应
+	datas = addSourceToData(sortedFroms, nil, datas, "source", "TestParseWithRecords")
+	datas = addRecordTagsToData(sortedRecords, nil, datas)
+	for _, data := range datas {
+		var (
+			from string
+			idx  int64
+		)
+		_, err := fmt.Sscanf(data["line"].(string), "%s %d", &from, &idx)
+		assert.NoError(t, err)
+		assert.Equal(t, from, data["source"])
+		assert.Equal(t, idx, data["kafka_offset"])
+	}
+
+	// 前置 transformer 改变了行数时不重新排列
+	_, _, sortedRecords, _ = r.parseWithRecords(lines[1:], nil, nil, records)
+	assert.Equal(t, records, sortedRecords)
+}
@@ -0,0 +1,393 @@
+package mgr
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/qiniu/log"
+
+	"github.com/qiniu/logkit/reader"
+	"github.com/qiniu/logkit/sender"
+)
+
+var (
+	// quotaCheckInterval 统计配额用量以及调整打开文件数上限的间隔
+	quotaCheckInterval = 2 * time.Second
+	// quotaPauseInterval 磁盘队列超过配额后暂停读取时，重新检查配额的间隔
+	quotaPauseInterval = time.Second
+)
+
+// QuotaConfig 多个团队共用一个 logkit 时，按 runner 名称或者标签选择器将 runner 分组，
+// 同一组内的 runner 共享发送速度、磁盘队列大小以及打开文件数的上限，上限为 0 表示不限制
+type QuotaConfig struct {
+	Name string `json:"name"`
+	// Runners 属于该配额的 runner 名称
+	Runners []string `json:"runners,omitempty"`
+	// Selector 标签选择器，匹配的 runner 属于该配额，与 Runners 同时配置时满足任意一个即属于该配额
+	Selector string `json:"selector,omitempty"`
+	// MaxSendBytesPerSec 每秒最多发送的字节数，按读取的原始数据大小计算，超过后等待
+	MaxSendBytesPerSec int64 `json:"max_send_bytes_per_sec,omitempty"`
+	// MaxDiskQueueBytes 容错 sender 磁盘队列最多占用的字节数，超过后暂停读取，直到队列中的数据发送出去
+	MaxDiskQueueBytes int64 `json:"max_disk_queue_bytes,omitempty"`
+	// MaxOpenFiles 最多同时打开的文件数，平均分配给组内的 runner，已经打开的文件不受影响
+	MaxOpenFiles int `json:"max_open_files,omitempty"`
+}
+
+// QuotaStatus 配额的用量
+type QuotaStatus struct {
+	Name               string   `json:"name"`
+	Runners            []string `json:"runners"`
+	MaxSendBytesPerSec int64    `json:"max_send_bytes_per_sec,omitempty"`
+	SendBytesPerSec    int64    `json:"send_bytes_per_sec"`
+	SendBytes          int64    `json:"send_bytes"`
+	// SendThrottled 因为超过发送速度而等待的次数
+	SendThrottled     int64 `json:"send_throttled"`
+	MaxDiskQueueBytes int64 `json:"max_disk_queue_bytes,omitempty"`
+	DiskQueueBytes    int64 `json:"disk_queue_bytes"`
+	// DiskQueueExceeded 为 true 时组内的 runner 暂停读取
+	DiskQueueExceeded bool `json:"disk_queue_exceeded,omitempty"`
+	MaxOpenFiles      int  `json:"max_open_files,omitempty"`
+	OpenFiles         int  `json:"open_files"`
+	// OpenFilesPerRunner 分配给组内每个 runner 的打开文件数上限
+	OpenFilesPerRunner int    `json:"open_files_per_runner,omitempty"`
+	UpdateTime         string `json:"update_time,omitempty"`
+}
+
+// quotaUsage 一个 runner 占用的资源
+type quotaUsage struct {
+	diskQueueBytes int64
+	openFiles      int
+}
+
+// quotaRunner 能够按配额限制的 runner
+type quotaRunner interface {
+	setQuotas(quotas []*quota)
+	quotaUsage() quotaUsage
+	setOpenFilesLimit(limit int)
+}
+
+type quota struct {
+	QuotaConfig
+	selector LabelSelector
+	runners  map[string]bool
+
+	mux  sync.Mutex
+	next time.Time // 按发送速度，下一次可以发送的时间
+
+	sendBytes    int64 // 原子操作
+	throttled    int64 // 原子操作
+	diskExceeded int32 // 原子操作
+
+	statusMux sync.RWMutex
+	status    QuotaStatus
+	lastBytes int64
+	lastCheck time.Time
+}
+
+func newQuota(c QuotaConfig) (*quota, error) {
+	if c.Name == "" {
+		return nil, errors.New("quota name can not be empty")
+	}
+	if len(c.Runners) == 0 && c.Selector == "" {
+		return nil, fmt.Errorf("quota %q should set runners or selector", c.Name)
+	}
+	if c.MaxSendBytesPerSec < 0 || c.MaxDiskQueueBytes < 0 || c.MaxOpenFiles < 0 {
+		return nil, fmt.Errorf("quota %q limits can not be negative", c.Name)
+	}
+	if c.MaxSendBytesPerSec == 0 && c.MaxDiskQueueBytes == 0 && c.MaxOpenFiles == 0 {
+		return nil, fmt.Errorf("quota %q has no limit", c.Name)
+	}
+	q := &quota{QuotaConfig: c, runners: make(map[string]bool, len(c.Runners))}
+	for _, name := range c.Runners {
+		q.runners[name] = true
+	}
+	if c.Selector != "" {
+		selector, err := ParseLabelSelector(c.Selector)
+		if err != nil {
+			return nil, fmt.Errorf("quota %q %v", c.Name, err)
+		}
+		q.selector = selector
+	}
+	q.status = QuotaStatus{
+		Name:               c.Name,
+		Runners:            []string{},
+		MaxSendBytesPerSec: c.MaxSendBytesPerSec,
+		MaxDiskQueueBytes:  c.MaxDiskQueueBytes,
+		MaxOpenFiles:       c.MaxOpenFiles,
+	}
+	return q, nil
+}
+
+func (q *quota) matches(name string, labels map[string]string) bool {
+	if q.runners[name] {
+		return true
+	}
+	return len(q.selector) > 0 && q.selector.Matches(labels)
+}
+
+// reserve 预留 size 字节的发送额度，返回需要等待的时间
+func (q *quota) reserve(size int64, now time.Time) time.Duration {
+	atomic.AddInt64(&q.sendBytes, size)
+	if q.MaxSendBytesPerSec <= 0 {
+		return 0
+	}
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	if q.next.Before(now) {
+		q.next = now
+	}
+	wait := q.next.Sub(now)
+	q.next = q.next.Add(time.Duration(float64(size) / float64(q.MaxSendBytesPerSec) * float64(time.Second)))
+	return wait
+}
+
+// waitSend 按发送速度上限等待，stopped 返回 true 时立即返回，避免停止 runner 时长时间阻塞
+func (q *quota) waitSend(size int64, stopped func() bool) {
+	wait := q.reserve(size, time.Now())
+	if wait <= 0 {
+		return
+	}
+	atomic.AddInt64(&q.throttled, 1)
+	for wait > 0 && !stopped() {
+		sleep := wait
+		if sleep > time.Second {
+			sleep = time.Second
+		}
+		time.Sleep(sleep)
+		wait -= sleep
+	}
+}
+
+func (q *quota) isDiskExceeded() bool {
+	return atomic.LoadInt32(&q.diskExceeded) > 0
+}
+
+// openFilesPerRunner 将打开文件数上限平均分配给组内的 runner，每个 runner 至少可以打开一个文件
+func (q *quota) openFilesPerRunner(members int) int {
+	if q.MaxOpenFiles <= 0 || members == 0 {
+		return 0
+	}
+	limit := q.MaxOpenFiles / members
+	if limit < 1 {
+		limit = 1
+	}
+	return limit
+}
+
+// update 根据组内 runner 的用量更新配额状态
+func (q *quota) update(names []string, usages []quotaUsage, now time.Time) {
+	var diskQueueBytes int64
+	var openFiles int
+	for _, u := range usages {
+		diskQueueBytes += u.diskQueueBytes
+		openFiles += u.openFiles
+	}
+	exceeded := q.MaxDiskQueueBytes > 0 && diskQueueBytes >= q.MaxDiskQueueBytes
+	if exceeded && !q.isDiskExceeded() {
+		log.Warnf("quota %q disk queue bytes %d exceeds %d, runners %v pause reading", q.Name, diskQueueBytes, q.MaxDiskQueueBytes, names)
+	} else if !exceeded && q.isDiskExceeded() {
+		log.Infof("quota %q disk queue bytes %d is below %d, runners %v resume reading", q.Name, diskQueueBytes, q.MaxDiskQueueBytes, names)
+	}
+	if exceeded {
+		atomic.StoreInt32(&q.diskExceeded, 1)
+	} else {
+		atomic.StoreInt32(&q.diskExceeded, 0)
+	}
+
+	sendBytes := atomic.LoadInt64(&q.sendBytes)
+	q.statusMux.Lock()
+	defer q.statusMux.Unlock()
+	if !q.lastCheck.IsZero() {
+		if elapsed := now.Sub(q.lastCheck).Seconds(); elapsed > 0 {
+			q.status.SendBytesPerSec = int64(float64(sendBytes-q.lastBytes) / elapsed)
+		}
+	}
+	q.lastBytes = sendBytes
+	q.lastCheck = now
+	q.status.Runners = names
+	q.status.SendBytes = sendBytes
+	q.status.SendThrottled = atomic.LoadInt64(&q.throttled)
+	q.status.DiskQueueBytes = diskQueueBytes
+	q.status.DiskQueueExceeded = exceeded
+	q.status.OpenFiles = openFiles
+	q.status.OpenFilesPerRunner = q.openFilesPerRunner(len(names))
+	q.status.UpdateTime = now.Format(time.RFC3339)
+}
+
+func (q *quota) getStatus() QuotaStatus {
+	q.statusMux.RLock()
+	defer q.statusMux.RUnlock()
+	status := q.status
+	status.Runners = append([]string{}, q.status.Runners...)
+	return status
+}
+
+// quotaManager 定期统计各个配额的用量，并将配额分配给匹配的 runner
+type quotaManager struct {
+	quotas []*quota
+	stop   chan struct{}
+}
+
+func newQuotaManager(configs []QuotaConfig) (*quotaManager, error) {
+	qm := &quotaManager{stop: make(chan struct{})}
+	names := make(map[string]bool, len(configs))
+	for _, c := range configs {
+		q, err := newQuota(c)
+		if err != nil {
+			return nil, err
+		}
+		if names[c.Name] {
+			return nil, fmt.Errorf("duplicate quota name %q", c.Name)
+		}
+		names[c.Name] = true
+		qm.quotas = append(qm.quotas, q)
+	}
+	return qm, nil
+}
+
+func (m *Manager) runQuotas() {
+	ticker := time.NewTicker(quotaCheckInterval)
+	defer ticker.Stop()
+	for {
+		m.checkQuotas(time.Now())
+		select {
+		case <-m.quotas.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkQuotas 重新计算每个 runner 所属的配额，runner 同时属于多个配额时需要满足所有配额的限制
+func (m *Manager) checkQuotas(now time.Time) {
+	type member struct {
+		name   string
+		labels map[string]string
+		runner quotaRunner
+	}
+	var members []member
+	m.runnerLock.RLock()
+	for path, r := range m.runners {
+		qr, ok := r.(quotaRunner)
+		if !ok {
+			continue
+		}
+		conf := m.runnerConfigs[path]
+		members = append(members, member{name: conf.RunnerName, labels: conf.Labels, runner: qr})
+	}
+	m.runnerLock.RUnlock()
+	sort.Slice(members, func(i, j int) bool { return members[i].name < members[j].name })
+
+	usages := make([]quotaUsage, len(members))
+	for i := range members {
+		usages[i] = members[i].runner.quotaUsage()
+	}
+	assigned := make([][]*quota, len(members))
+	openFilesLimits := make([]int, len(members))
+	for _, q := range m.quotas.quotas {
+		var idxs []int
+		names := []string{}
+		for i := range members {
+			if q.matches(members[i].name, members[i].labels) {
+				idxs = append(idxs, i)
+				names = append(names, members[i].name)
+			}
+		}
+		groupUsages := make([]quotaUsage, 0, len(idxs))
+		limit := q.openFilesPerRunner(len(idxs))
+		for _, i := range idxs {
+			groupUsages = append(groupUsages, usages[i])
+			assigned[i] = append(assigned[i], q)
+			if limit > 0 && (openFilesLimits[i] == 0 || limit < openFilesLimits[i]) {
+				openFilesLimits[i] = limit
+			}
+		}
+		q.update(names, groupUsages, now)
+	}
+	for i := range members {
+		members[i].runner.setQuotas(assigned[i])
+		members[i].runner.setOpenFilesLimit(openFilesLimits[i])
+	}
+}
+
+// QuotaStatus 返回所有配额的用量
+func (m *Manager) QuotaStatus() []QuotaStatus {
+	if m.quotas == nil {
+		return []QuotaStatus{}
+	}
+	status := make([]QuotaStatus, 0, len(m.quotas.quotas))
+	for _, q := range m.quotas.quotas {
+		status = append(status, q.getStatus())
+	}
+	return status
+}
+
+func (r *LogExportRunner) setQuotas(quotas []*quota) {
+	r.quotas.Store(quotas)
+}
+
+func (r *LogExportRunner) getQuotas() []*quota {
+	quotas, _ := r.quotas.Load().([]*quota)
+	return quotas
+}
+
+func (r *LogExportRunner) quotaUsage() quotaUsage {
+	var usage quotaUsage
+	for _, s := range r.senders {
+		if qs, ok := s.(sender.QueueBytesSender); ok {
+			usage.diskQueueBytes += qs.QueueBytes()
+		}
+	}
+	if or, ok := r.reader.(reader.OpenFilesReader); ok {
+		usage.openFiles = or.OpenFiles()
+	}
+	return usage
+}
+
+func (r *LogExportRunner) setOpenFilesLimit(limit int) {
+	if or, ok := r.reader.(reader.OpenFilesReader); ok {
+		or.SetOpenFilesLimit(limit)
+	}
+}
+
+// waitDiskQuota 所属配额的磁盘队列超过上限时暂停读取，直到队列中的数据发送出去，runner 停止时返回 false
+func (r *LogExportRunner) waitDiskQuota() bool {
+	paused := false
+	for {
+		if atomic.LoadInt32(&r.stopped) > 0 {
+			return false
+		}
+		exceeded := false
+		for _, q := range r.getQuotas() {
+			if q.isDiskExceeded() {
+				exceeded = true
+				break
+			}
+		}
+		if !exceeded {
+			if paused {
+				r.debug.debugf("Runner[%v] disk queue quota is released, resume reading", r.Name())
+			}
+			return true
+		}
+		if !paused {
+			r.debug.debugf("Runner[%v] disk queue quota is exceeded, pause reading", r.Name())
+			paused = true
+		}
+		time.Sleep(quotaPauseInterval)
+	}
+}
+
+// waitSendQuota 按所属配额的发送速度上限等待
+func (r *LogExportRunner) waitSendQuota(size int64) {
+	stopped := func() bool {
+		return atomic.LoadInt32(&r.stopped) > 0
+	}
+	for _, q := range r.getQuotas() {
+		q.waitSend(size, stopped)
+	}
+}
@@ -0,0 +1,96 @@
+package mgr
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeQuotaRunner struct {
+	Runner
+	usage  quotaUsage
+	quotas []*quota
+	limit  int
+}
+
+func (r *fakeQuotaRunner) setQuotas(quotas []*quota)   { r.quotas = quotas }
+func (r *fakeQuotaRunner) quotaUsage() quotaUsage      { return r.usage }
+func (r *fakeQuotaRunner) setOpenFilesLimit(limit int) { r.limit = limit }
+
+func TestNewQuotaManager(t *testing.T) {
+	for _, c := range []QuotaConfig{
+		{Runners: []string{"a"}, MaxOpenFiles: 1},
+		{Name: "q", MaxOpenFiles: 1},
+		{Name: "q", Runners: []string{"a"}},
+		{Name: "q", Runners: []string{"a"}, MaxSendBytesPerSec: -1},
+		{Name: "q", Selector: "=a", MaxOpenFiles: 1},
+	} {
+		_, err := newQuotaManager([]QuotaConfig{c})
+		assert.Error(t, err, "%+v", c)
+	}
+	_, err := newQuotaManager([]QuotaConfig{
+		{Name: "q", Runners: []string{"a"}, MaxOpenFiles: 1},
+		{Name: "q", Runners: []string{"b"}, MaxOpenFiles: 1},
+	})
+	assert.Error(t, err)
+}
+
+func TestQuotaReserve(t *testing.T) {
+	q, err := newQuota(QuotaConfig{Name: "q", Runners: []string{"a"}, MaxSendBytesPerSec: 100})
+	assert.NoError(t, err)
+	now := time.Now()
+	assert.Equal(t, time.Duration(0), q.reserve(50, now))
+	assert.Equal(t, 500*time.Millisecond, q.reserve(100, now))
+	assert.Equal(t, 1500*time.Millisecond, q.reserve(10, now))
+	// 空闲一段时间后不会累积额度
+	assert.Equal(t, time.Duration(0), q.reserve(10, now.Add(time.Minute)))
+	assert.EqualValues(t, 170, q.sendBytes)
+}
+
+func TestCheckQuotas(t *testing.T) {
+	qm, err := newQuotaManager([]QuotaConfig{
+		{Name: "team-a", Selector: "team=a", MaxDiskQueueBytes: 100, MaxOpenFiles: 10},
+		{Name: "single", Runners: []string{"r2"}, MaxOpenFiles: 3, MaxSendBytesPerSec: 1000},
+	})
+	assert.NoError(t, err)
+	r1 := &fakeQuotaRunner{usage: quotaUsage{diskQueueBytes: 60, openFiles: 4}}
+	r2 := &fakeQuotaRunner{usage: quotaUsage{diskQueueBytes: 50, openFiles: 2}}
+	r3 := &fakeQuotaRunner{usage: quotaUsage{diskQueueBytes: 1000, openFiles: 100}}
+	m := &Manager{
+		runners: map[string]Runner{"p1": r1, "p2": r2, "p3": r3},
+		runnerConfigs: map[string]RunnerConfig{
+			"p1": {RunnerInfo: RunnerInfo{RunnerName: "r1", Labels: map[string]string{"team": "a"}}},
+			"p2": {RunnerInfo: RunnerInfo{RunnerName: "r2", Labels: map[string]string{"team": "a"}}},
+			"p3": {RunnerInfo: RunnerInfo{RunnerName: "r3", Labels: map[string]string{"team": "b"}}},
+		},
+		quotas: qm,
+	}
+	now := time.Now()
+	m.checkQuotas(now)
+
+	assert.Len(t, r1.quotas, 1)
+	assert.Len(t, r2.quotas, 2)
+	assert.Len(t, r3.quotas, 0)
+	assert.Equal(t, 5, r1.limit)
+	// 同时属于两个配额时使用较小的上限
+	assert.Equal(t, 3, r2.limit)
+	assert.Equal(t, 0, r3.limit)
+	assert.True(t, qm.quotas[0].isDiskExceeded())
+	assert.False(t, qm.quotas[1].isDiskExceeded())
+
+	qm.quotas[1].reserve(2000, now)
+	r1.usage.diskQueueBytes = 0
+	m.checkQuotas(now.Add(2 * time.Second))
+	assert.False(t, qm.quotas[0].isDiskExceeded())
+
+	status := m.QuotaStatus()
+	assert.Len(t, status, 2)
+	assert.Equal(t, []string{"r1", "r2"}, status[0].Runners)
+	assert.EqualValues(t, 50, status[0].DiskQueueBytes)
+	assert.Equal(t, 6, status[0].OpenFiles)
+	assert.Equal(t, 5, status[0].OpenFilesPerRunner)
+	assert.Equal(t, []string{"r2"}, status[1].Runners)
+	assert.EqualValues(t, 1000, status[1].SendBytesPerSec)
+	assert.EqualValues(t, 2000, status[1].SendBytes)
+}
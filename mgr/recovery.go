@@ -0,0 +1,179 @@
+package mgr
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/qiniu/log"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/queue"
+	"github.com/qiniu/logkit/reader"
+	. "github.com/qiniu/logkit/reader/config"
+	senderConf "github.com/qiniu/logkit/sender/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+// defaultSubmetaExpire 与 tailx、dirx 中 submeta_expire 的默认值相同
+const defaultSubmetaExpire = "720h"
+
+// DeadLetterFile 死信文件及其大小
+type DeadLetterFile struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// RecoveryReport runner 启动时从磁盘恢复的状态，用于评估崩溃或者重启之后需要追赶的数据量。
+// 每个 runner 只在进程内第一次启动时生成一次
+type RecoveryReport struct {
+	RunnerName string `json:"runnerName"`
+	Time       string `json:"time"`
+	MetaDir    string `json:"metaDir,omitempty"`
+	// Offsets 恢复的读取位置，包括 tailx、dirx 的 submeta，kafka 等由服务端记录读取位置的 reader 没有
+	Offsets []reader.MetaOffset `json:"offsets,omitempty"`
+	// UnreadBytes 各个读取位置到文件末尾之间尚未读取的字节数之和
+	UnreadBytes int64 `json:"unreadBytes"`
+	// FtQueues 启动时 ft_sender 磁盘队列中积压的数据
+	FtQueues     []queue.DiskQueueState `json:"ftQueues,omitempty"`
+	FtQueueDepth int64                  `json:"ftQueueDepth"`
+	FtQueueBytes int64                  `json:"ftQueueBytes"`
+	// DeadLetters send_error_policy 中配置的死信文件
+	DeadLetters     []DeadLetterFile `json:"deadLetters,omitempty"`
+	DeadLetterBytes int64            `json:"deadLetterBytes"`
+	// PrunedSubMetas 启动时删除的孤立并且过期的 submeta
+	PrunedSubMetas []reader.MetaOffset `json:"prunedSubMetas,omitempty"`
+	// Problems meta 检查发现的其他问题，需要时通过 meta repair 修复
+	Problems []reader.MetaProblem `json:"problems,omitempty"`
+	Error    string               `json:"error,omitempty"`
+}
+
+// buildRecoveryReport 检查 runner 的 meta 目录、ft_sender 磁盘队列和死信文件，检查失败的部分记录在 Error 中
+func buildRecoveryReport(rc RunnerConfig) RecoveryReport {
+	report := RecoveryReport{RunnerName: rc.RunnerName, Time: time.Now().Format(time.RFC3339Nano)}
+	var errs []string
+	if rc.ReaderConfig != nil {
+		readerConf := make(conf.MapConf, len(rc.ReaderConfig)+1)
+		for k, v := range rc.ReaderConfig {
+			readerConf[k] = v
+		}
+		readerConf[GlobalKeyName] = rc.RunnerName
+		if _, _, metaDir, err := reader.GetMetaOption(readerConf); err != nil {
+			errs = append(errs, fmt.Sprintf("get meta path error: %v", err))
+		} else {
+			report.MetaDir = metaDir
+			if err = report.inspectMeta(readerConf); err != nil {
+				errs = append(errs, err.Error())
+			}
+		}
+	}
+
+	ftDirs := make(map[string]bool)
+	if report.MetaDir != "" {
+		ftDirs[filepath.Join(report.MetaDir, reader.FtSaveLogPath)] = true
+	}
+	for _, senderConfig := range rc.SendersConfig {
+		if dir := senderConfig[senderConf.KeyFtSaveLogPath]; dir != "" {
+			ftDirs[dir] = true
+		}
+	}
+	for dir := range ftDirs {
+		states, err := queue.ReadDiskQueueStates(dir)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("read ft queues in %v error: %v", dir, err))
+		}
+		for _, state := range states {
+			report.FtQueues = append(report.FtQueues, state)
+			report.FtQueueDepth += state.Depth
+			report.FtQueueBytes += state.Bytes
+		}
+	}
+	sort.Slice(report.FtQueues, func(i, j int) bool {
+		return filepath.Join(report.FtQueues[i].Dir, report.FtQueues[i].Name) < filepath.Join(report.FtQueues[j].Dir, report.FtQueues[j].Name)
+	})
+
+	if rc.SendErrorPolicy != nil && rc.SendErrorPolicy.DeadLetterDir != "" {
+		paths, _ := filepath.Glob(filepath.Join(rc.SendErrorPolicy.DeadLetterDir, rc.RunnerName, "*"+deadLetterSuffix))
+		for _, path := range paths {
+			fi, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			report.DeadLetters = append(report.DeadLetters, DeadLetterFile{Path: path, Size: fi.Size()})
+			report.DeadLetterBytes += fi.Size()
+		}
+	}
+	report.Error = strings.Join(errs, "; ")
+	return report
+}
+
+// inspectMeta 记录 meta 目录中恢复的读取位置，并删除孤立并且超过 submeta_expire 的 submeta
+func (report *RecoveryReport) inspectMeta(readerConf conf.MapConf) error {
+	if _, err := os.Stat(report.MetaDir); os.IsNotExist(err) {
+		return nil
+	}
+	meta, err := reader.InspectMeta(report.MetaDir)
+	if err != nil {
+		return fmt.Errorf("inspect meta %v error: %v", report.MetaDir, err)
+	}
+	expireStr, _ := readerConf.GetStringOr(KeySubmetaExpire, defaultSubmetaExpire)
+	expire, err := time.ParseDuration(expireStr)
+	if err != nil {
+		return fmt.Errorf("parse %v error: %v", KeySubmetaExpire, err)
+	}
+	report.PrunedSubMetas, err = reader.PruneOrphanSubMetas(meta, expire)
+	if meta.Offset != nil {
+		report.Offsets = append(report.Offsets, *meta.Offset)
+	}
+	report.Offsets = append(report.Offsets, meta.SubMetas...)
+	for _, offset := range report.Offsets {
+		if offset.Size > offset.Offset {
+			report.UnreadBytes += offset.Size - offset.Offset
+		}
+	}
+	report.Problems = meta.Problems
+	if err != nil {
+		return fmt.Errorf("prune submetas in %v error: %v", report.MetaDir, err)
+	}
+	return nil
+}
+
+// recordRecovery 在 runner 第一次启动前生成并记录恢复报告，同一个 runner 在进程内只生成一次
+func (m *Manager) recordRecovery(rc RunnerConfig) {
+	m.recoveryLock.Lock()
+	if _, ok := m.recoveries[rc.RunnerName]; ok {
+		m.recoveryLock.Unlock()
+		return
+	}
+	if m.recoveries == nil {
+		m.recoveries = make(map[string]RecoveryReport)
+	}
+	report := buildRecoveryReport(rc)
+	m.recoveries[rc.RunnerName] = report
+	m.recoveryLock.Unlock()
+
+	log.Infof("Runner[%v] recovery report: %d offsets with %d bytes unread, %d ft queues with %d records (%d bytes) backlog, %d dead letter bytes, %d submetas pruned",
+		rc.RunnerName, len(report.Offsets), report.UnreadBytes, len(report.FtQueues), report.FtQueueDepth, report.FtQueueBytes,
+		report.DeadLetterBytes, len(report.PrunedSubMetas))
+	for _, p := range report.Problems {
+		log.Warnf("Runner[%v] recovery found %v in %v: %v", rc.RunnerName, p.Kind, p.Path, p.Detail)
+	}
+	if report.Error != "" {
+		log.Warnf("Runner[%v] recovery report incomplete: %v", rc.RunnerName, report.Error)
+	}
+}
+
+// RecoveryReports 返回所有 runner 的恢复报告，按 runner 名称排序
+func (m *Manager) RecoveryReports() []RecoveryReport {
+	m.recoveryLock.RLock()
+	defer m.recoveryLock.RUnlock()
+	reports := make([]RecoveryReport, 0, len(m.recoveries))
+	for _, report := range m.recoveries {
+		reports = append(reports, report)
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].RunnerName < reports[j].RunnerName })
+	return reports
+}
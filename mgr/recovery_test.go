@@ -0,0 +1,70 @@
+package mgr
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/reader"
+	. "github.com/qiniu/logkit/reader/config"
+	senderConf "github.com/qiniu/logkit/sender/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+func TestRecoveryReport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestRecoveryReport")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	logPath := filepath.Join(dir, "test.log")
+	assert.NoError(t, ioutil.WriteFile(logPath, []byte("0123456789"), 0644))
+	metaDir := filepath.Join(dir, "meta")
+	ftDir := filepath.Join(dir, "ft")
+	deadLetterDir := filepath.Join(dir, "deadletter")
+
+	rc := RunnerConfig{
+		RunnerInfo:      RunnerInfo{RunnerName: "runner1"},
+		ReaderConfig:    conf.MapConf{KeyMode: ModeFile, KeyLogPath: logPath, KeyMetaPath: metaDir},
+		SendersConfig:   []conf.MapConf{{senderConf.KeySenderType: "discard", senderConf.KeyFtSaveLogPath: ftDir}},
+		SendErrorPolicy: &SendErrorPolicyConfig{DeadLetterDir: deadLetterDir},
+	}
+	meta, err := reader.NewMetaWithConf(conf.MapConf{KeyMode: ModeFile, KeyLogPath: logPath, KeyMetaPath: metaDir, GlobalKeyName: "runner1"})
+	assert.NoError(t, err)
+	assert.NoError(t, meta.WriteOffset(logPath, 4))
+	assert.NoError(t, os.MkdirAll(filepath.Join(ftDir, "sender1"), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(ftDir, "sender1", "queue.diskqueue.meta.dat"), []byte("3\n0,0\n0,30\n"), 0644))
+	assert.NoError(t, os.MkdirAll(filepath.Join(deadLetterDir, "runner1"), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(deadLetterDir, "runner1", "sender1"+deadLetterSuffix), []byte("rejected\n"), 0644))
+
+	m := &Manager{}
+	m.recordRecovery(rc)
+	reports := m.RecoveryReports()
+	assert.Len(t, reports, 1)
+	report := reports[0]
+	assert.Equal(t, "", report.Error)
+	assert.Equal(t, "runner1", report.RunnerName)
+	assert.Equal(t, metaDir, report.MetaDir)
+	assert.Len(t, report.Offsets, 1)
+	assert.Equal(t, logPath, report.Offsets[0].File)
+	assert.EqualValues(t, 6, report.UnreadBytes)
+	assert.Len(t, report.FtQueues, 1)
+	assert.EqualValues(t, 3, report.FtQueueDepth)
+	assert.Equal(t, []DeadLetterFile{{Path: filepath.Join(deadLetterDir, "runner1", "sender1"+deadLetterSuffix), Size: 9}}, report.DeadLetters)
+	assert.EqualValues(t, 9, report.DeadLetterBytes)
+
+	// 同一个 runner 只在第一次启动时生成报告
+	assert.NoError(t, meta.WriteOffset(logPath, 10))
+	m.recordRecovery(rc)
+	reports = m.RecoveryReports()
+	assert.Len(t, reports, 1)
+	assert.EqualValues(t, 6, reports[0].UnreadBytes)
+
+	m.recordRecovery(RunnerConfig{RunnerInfo: RunnerInfo{RunnerName: "metric"}})
+	reports = m.RecoveryReports()
+	assert.Len(t, reports, 2)
+	assert.Equal(t, "metric", reports[0].RunnerName)
+	assert.Equal(t, RecoveryReport{RunnerName: "metric", Time: reports[0].Time}, reports[0])
+}
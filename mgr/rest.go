@@ -1,6 +1,7 @@
 package mgr
 
 import (
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -21,6 +22,7 @@ import (
 	"github.com/qiniu/logkit/utils"
 	. "github.com/qiniu/logkit/utils/models"
 	utilsos "github.com/qiniu/logkit/utils/os"
+	"github.com/qiniu/logkit/utils/tlspolicy"
 )
 
 var DEFAULT_PORT = 3000
@@ -60,6 +62,9 @@ func NewRestService(mgr *Manager, router *echo.Echo) *RestService {
 	router.GET(PREFIX+"/errors", rs.GetErrors())
 	router.GET(PREFIX+"/errors/:name", rs.GetError())
 
+	// 获取已读完文件校验记录 API
+	router.GET(PREFIX+"/completions/:name", rs.GetCompletions())
+
 	// error code humanize
 	router.GET(PREFIX+"/errorcode", rs.GetErrorCodeHumanize())
 
@@ -70,11 +75,21 @@ func NewRestService(mgr *Manager, router *echo.Echo) *RestService {
 	router.POST(PREFIX+"/configs/:name/stop", rs.PostConfigStop())
 	router.POST(PREFIX+"/configs/:name/start", rs.PostConfigStart())
 	router.POST(PREFIX+"/configs/:name/reset", rs.PostConfigReset())
+	router.POST(PREFIX+"/configs/:name/drain", rs.PostConfigDrain())
+	router.POST(PREFIX+"/configs/:name/flush", rs.PostConfigFlush())
+	router.POST(PREFIX+"/configs/:name/validate", rs.PostConfigValidate())
+	router.POST(PREFIX+"/configs/:name/debug", rs.PostConfigDebug())
+	router.POST(PREFIX+"/configs/:name/capture", rs.PostConfigCapture())
+	router.POST(PREFIX+"/configs/:name/headpattern", rs.PostConfigHeadPattern())
+	router.GET(PREFIX+"/configs/:name/capture", rs.GetConfigCapture())
+	router.GET(PREFIX+"/configs/:name/requests", rs.GetConfigRequests())
+	router.POST(PREFIX+"/configs/:name/requests/:index/:id/replay", rs.PostConfigRequestReplay())
 	router.PUT(PREFIX+"/configs/:name", rs.PutConfig())
 	router.DELETE(PREFIX+"/configs/:name", rs.DeleteConfig())
 
 	// runners API
 	router.GET(PREFIX+"/runners", rs.GetRunners())
+	router.POST(PREFIX+"/runners/bulk/:op", rs.PostRunnersBulk())
 
 	//reader API
 	router.GET(PREFIX+"/reader/usages", rs.GetReaderUsages())
@@ -82,6 +97,7 @@ func NewRestService(mgr *Manager, router *echo.Echo) *RestService {
 	router.GET(PREFIX+"/reader/options", rs.GetReaderKeyOptions())
 	router.POST(PREFIX+"/reader/read", rs.PostRead())
 	router.POST(PREFIX+"/reader/check", rs.PostReaderCheck())
+	router.POST(PREFIX+"/reader/headpattern", rs.PostReaderHeadPattern())
 
 	//cleaner API
 	router.GET(PREFIX+"/cleaner/options", rs.GetCleanerKeyOptions())
@@ -106,6 +122,7 @@ func NewRestService(mgr *Manager, router *echo.Echo) *RestService {
 	router.GET(PREFIX+"/sender/options", rs.GetSenderKeyOptions())
 	router.POST(PREFIX+"/sender/send", rs.PostSend())
 	router.POST(PREFIX+"/sender/check", rs.PostSenderCheck())
+	router.POST(PREFIX+"/sender/preview", rs.PostSenderPreview())
 	router.GET(PREFIX+"/sender/router/usage", rs.GetSenderRouterUsage())
 	router.GET(PREFIX+"/sender/router/option", rs.GetSenderRouterOption())
 
@@ -116,6 +133,24 @@ func NewRestService(mgr *Manager, router *echo.Echo) *RestService {
 
 	//version
 	router.GET(PREFIX+"/version", rs.GetVersion())
+	router.GET(PREFIX+"/openapi.json", rs.GetOpenAPI())
+
+	//data bundle API
+	router.GET(PREFIX+"/bundles", rs.GetBundles())
+	router.POST(PREFIX+"/bundles/:name/update", rs.PostBundleUpdate())
+	router.POST(PREFIX+"/bundles/:name/rollback", rs.PostBundleRollback())
+
+	//quota API
+	router.GET(PREFIX+"/quotas", rs.GetQuotas())
+
+	//config drift API
+	router.GET(PREFIX+"/drift", rs.GetConfigDrifts())
+
+	//recovery report API
+	router.GET(PREFIX+"/recovery", rs.GetRecoveryReports())
+
+	//localstore API
+	router.GET(PREFIX+"/localstore/:name", rs.GetLocalStore())
 
 	//cluster API
 	router.GET(PREFIX+"/cluster/ping", rs.Ping())
@@ -147,6 +182,14 @@ func NewRestService(mgr *Manager, router *echo.Echo) *RestService {
 		log.Warn("logkit web service was disabled")
 		return rs
 	}
+	var tlsConfig *tls.Config
+	if mgr.TLSCertFile != "" && mgr.TLSKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(mgr.TLSCertFile, mgr.TLSKeyFile)
+		if err != nil {
+			log.Fatalf("load RestService certificate %v and key %v error %v", mgr.TLSCertFile, mgr.TLSKeyFile, err)
+		}
+		tlsConfig = tlspolicy.Apply(&tls.Config{Certificates: []tls.Certificate{cert}})
+	}
 	for {
 		if port > 10000 {
 			log.Fatal("bind port failed too many times, exit...")
@@ -159,7 +202,10 @@ func NewRestService(mgr *Manager, router *echo.Echo) *RestService {
 		if mgr.BindHost != "" {
 			address, httpschema = RemoveHttpProtocal(mgr.BindHost)
 		}
-		listener, err = httpserve(address, router)
+		if tlsConfig != nil {
+			httpschema = "https://"
+		}
+		listener, err = httpserve(address, router, tlsConfig)
 		if err != nil {
 			err = fmt.Errorf("bind address %v for RestService error %v", address, err)
 			if mgr.BindHost != "" {
@@ -281,9 +327,37 @@ func (rs *RestService) GetError() echo.HandlerFunc {
 	}
 }
 
-// get /logkit/runners
+// get /logkit/completions/<name>
+func (rs *RestService) GetCompletions() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var name string
+		if name = c.Param("name"); name == "" {
+			errMsg := "runner name is empty"
+			return RespError(c, http.StatusBadRequest, ErrRunnerCompletionGet, errMsg)
+		}
+
+		records, err := rs.mgr.Completions(name)
+		if err != nil {
+			return RespError(c, http.StatusBadRequest, ErrRunnerCompletionGet, err.Error())
+		}
+		return RespSuccess(c, records)
+	}
+}
+
+// get /logkit/runners?selector=<label selector>
 func (rs *RestService) GetRunners() echo.HandlerFunc {
 	return func(c echo.Context) error {
+		if selectorStr := c.QueryParam("selector"); selectorStr != "" {
+			selector, err := ParseLabelSelector(selectorStr)
+			if err != nil {
+				return RespError(c, http.StatusBadRequest, ErrConfigName, err.Error())
+			}
+			names, _ := rs.mgr.SelectRunners(selector)
+			if names == nil {
+				names = make([]string, 0)
+			}
+			return RespSuccess(c, names)
+		}
 		runnerNameList := make([]string, 0)
 		rs.mgr.runnerLock.RLock()
 		for _, conf := range rs.mgr.runnerConfigs {
@@ -294,6 +368,49 @@ func (rs *RestService) GetRunners() echo.HandlerFunc {
 	}
 }
 
+// BulkRequest 批量操作 runner 的请求
+type BulkRequest struct {
+	Selector     string `json:"selector"`
+	DrainTimeout string `json:"drain_timeout,omitempty"`
+}
+
+func parseDrainTimeout(timeout string) (time.Duration, error) {
+	if timeout == "" {
+		return DefaultDrainTimeout, nil
+	}
+	dur, err := time.ParseDuration(timeout)
+	if err != nil {
+		return 0, fmt.Errorf("parse drain timeout %q error: %v", timeout, err)
+	}
+	if dur <= 0 {
+		return 0, fmt.Errorf("drain timeout %q must be positive", timeout)
+	}
+	return dur, nil
+}
+
+// POST /logkit/runners/bulk/<op>，op 为 start、stop、drain 或者 delete
+func (rs *RestService) PostRunnersBulk() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var req BulkRequest
+		if err := c.Bind(&req); err != nil {
+			return RespError(c, http.StatusBadRequest, ErrRunnerBulk, err.Error())
+		}
+		selector, err := ParseLabelSelector(req.Selector)
+		if err != nil {
+			return RespError(c, http.StatusBadRequest, ErrRunnerBulk, err.Error())
+		}
+		timeout, err := parseDrainTimeout(req.DrainTimeout)
+		if err != nil {
+			return RespError(c, http.StatusBadRequest, ErrRunnerBulk, err.Error())
+		}
+		results, err := rs.mgr.BulkOperate(c.Param("op"), selector, timeout)
+		if err != nil {
+			return RespError(c, http.StatusBadRequest, ErrRunnerBulk, err.Error())
+		}
+		return RespSuccess(c, results)
+	}
+}
+
 // get /logkit/configs
 func (rs *RestService) GetConfigs() echo.HandlerFunc {
 	return func(c echo.Context) error {
@@ -429,6 +546,186 @@ func (rs *RestService) PostConfigStop() echo.HandlerFunc {
 	}
 }
 
+// POST /logkit/configs/<name>/drain?timeout=30s
+func (rs *RestService) PostConfigDrain() echo.HandlerFunc {
+	return func(c echo.Context) (err error) {
+		var name string
+		if name = c.Param("name"); name == "" {
+			errMsg := "config name is empty"
+			return RespError(c, http.StatusBadRequest, ErrRunnerDrain, errMsg)
+		}
+		timeout, err := parseDrainTimeout(c.QueryParam("timeout"))
+		if err != nil {
+			return RespError(c, http.StatusBadRequest, ErrRunnerDrain, err.Error())
+		}
+		if err = rs.mgr.DrainRunner(name, timeout); err != nil {
+			return RespError(c, http.StatusBadRequest, ErrRunnerDrain, err.Error())
+		}
+		return RespSuccess(c, nil)
+	}
+}
+
+// POST /logkit/configs/<name>/flush?timeout=30s
+// 立即发送 runner 所有 sender 中缓存的数据，返回每个 sender 的结果，部分 sender 失败时仍返回成功
+func (rs *RestService) PostConfigFlush() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var name string
+		if name = c.Param("name"); name == "" {
+			errMsg := "config name is empty"
+			return RespError(c, http.StatusBadRequest, ErrRunnerFlush, errMsg)
+		}
+		timeout, err := parseDrainTimeout(c.QueryParam("timeout"))
+		if err != nil {
+			return RespError(c, http.StatusBadRequest, ErrRunnerFlush, err.Error())
+		}
+		results, err := rs.mgr.FlushRunner(name, timeout)
+		if err != nil {
+			return RespError(c, http.StatusBadRequest, ErrRunnerFlush, err.Error())
+		}
+		return RespSuccess(c, results)
+	}
+}
+
+// POST /logkit/configs/<name>/debug?duration=10m
+// 临时打开 runner 的 debug 日志，到期后自动恢复，duration 为 0 时立即恢复
+func (rs *RestService) PostConfigDebug() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var name string
+		if name = c.Param("name"); name == "" {
+			errMsg := "config name is empty"
+			return RespError(c, http.StatusBadRequest, ErrRunnerDebug, errMsg)
+		}
+		duration := DefaultDebugDuration
+		if durationStr := c.QueryParam("duration"); durationStr != "" {
+			var err error
+			if duration, err = time.ParseDuration(durationStr); err != nil {
+				return RespError(c, http.StatusBadRequest, ErrRunnerDebug, fmt.Sprintf("parse duration %q error: %v", durationStr, err))
+			}
+			if duration < 0 || duration > MaxDebugDuration {
+				return RespError(c, http.StatusBadRequest, ErrRunnerDebug, fmt.Sprintf("duration %q must be in [0, %v]", durationStr, MaxDebugDuration))
+			}
+		}
+		until, err := rs.mgr.SetRunnerDebug(name, duration)
+		if err != nil {
+			return RespError(c, http.StatusBadRequest, ErrRunnerDebug, err.Error())
+		}
+		return RespSuccess(c, DebugResult{DebugUntil: until})
+	}
+}
+
+// POST /logkit/configs/<name>/capture?lines=100
+// 开始抓取 runner 接下来读取到的原始数据以及解析结果
+func (rs *RestService) PostConfigCapture() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var name string
+		if name = c.Param("name"); name == "" {
+			errMsg := "config name is empty"
+			return RespError(c, http.StatusBadRequest, ErrRunnerCapture, errMsg)
+		}
+		lines := DefaultCaptureLines
+		if linesStr := c.QueryParam("lines"); linesStr != "" {
+			var err error
+			if lines, err = strconv.Atoi(linesStr); err != nil {
+				return RespError(c, http.StatusBadRequest, ErrRunnerCapture, fmt.Sprintf("parse lines %q error: %v", linesStr, err))
+			}
+		}
+		if err := rs.mgr.StartRunnerCapture(name, lines); err != nil {
+			return RespError(c, http.StatusBadRequest, ErrRunnerCapture, err.Error())
+		}
+		return RespSuccess(c, nil)
+	}
+}
+
+// GET /logkit/configs/<name>/capture
+// 下载抓取结果的 tar.gz 压缩包，抓取还未结束时返回已经抓取到的部分
+func (rs *RestService) GetConfigCapture() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var name string
+		if name = c.Param("name"); name == "" {
+			errMsg := "config name is empty"
+			return RespError(c, http.StatusBadRequest, ErrRunnerCapture, errMsg)
+		}
+		capture, err := rs.mgr.RunnerCapture(name)
+		if err != nil {
+			return RespError(c, http.StatusBadRequest, ErrRunnerCapture, err.Error())
+		}
+		bundle, err := capture.Bundle()
+		if err != nil {
+			return RespError(c, http.StatusInternalServerError, ErrRunnerCapture, err.Error())
+		}
+		filename := fmt.Sprintf("%s-capture-%s.tar.gz", name, capture.StartedAt.Format("20060102150405"))
+		c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+		return c.Blob(http.StatusOK, "application/gzip", bundle)
+	}
+}
+
+// GET /logkit/configs/<name>/requests
+// 获取 runner 中开启了请求记录的 sender 最近发送的请求，敏感信息已隐藏
+func (rs *RestService) GetConfigRequests() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var name string
+		if name = c.Param("name"); name == "" {
+			errMsg := "config name is empty"
+			return RespError(c, http.StatusBadRequest, ErrRunnerCapture, errMsg)
+		}
+		requests, err := rs.mgr.RunnerRequests(name)
+		if err != nil {
+			return RespError(c, http.StatusBadRequest, ErrRunnerCapture, err.Error())
+		}
+		return RespSuccess(c, requests)
+	}
+}
+
+// POST /logkit/configs/<name>/requests/<index>/<id>/replay
+// 重新发送第 index 个 sender 记录的 id 请求，返回重放的结果
+func (rs *RestService) PostConfigRequestReplay() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var name string
+		if name = c.Param("name"); name == "" {
+			errMsg := "config name is empty"
+			return RespError(c, http.StatusBadRequest, ErrRunnerCapture, errMsg)
+		}
+		index, err := strconv.Atoi(c.Param("index"))
+		if err != nil {
+			return RespError(c, http.StatusBadRequest, ErrRunnerCapture, fmt.Sprintf("parse sender index %q error: %v", c.Param("index"), err))
+		}
+		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			return RespError(c, http.StatusBadRequest, ErrRunnerCapture, fmt.Sprintf("parse request id %q error: %v", c.Param("id"), err))
+		}
+		result, err := rs.mgr.ReplayRunnerRequest(name, index, id)
+		if err != nil {
+			return RespError(c, http.StatusBadRequest, ErrRunnerCapture, err.Error())
+		}
+		return RespSuccess(c, result)
+	}
+}
+
+// POST /logkit/configs/<name>/validate
+// 检查配置中废弃的配置项、互相冲突的配置项以及可疑的值，不会创建 runner
+func (rs *RestService) PostConfigValidate() echo.HandlerFunc {
+	return func(c echo.Context) (err error) {
+		var name string
+		if name = c.Param("name"); name == "" {
+			errMsg := "config name is empty"
+			return RespError(c, http.StatusBadRequest, ErrRunnerValidate, errMsg)
+		}
+		var nconf RunnerConfig
+		if err = c.Bind(&nconf); err != nil {
+			return RespError(c, http.StatusBadRequest, ErrRunnerValidate, err.Error())
+		}
+		nconf.RunnerName = name
+		nconf.ParserConf = parser.ConvertWebParserConfig(nconf.ParserConf)
+		if _, err = parseRestartPolicy(nconf.RestartPolicy); err != nil {
+			return RespError(c, http.StatusBadRequest, ErrRunnerValidate, err.Error())
+		}
+		if err = validateLabels(nconf.Labels); err != nil {
+			return RespError(c, http.StatusBadRequest, ErrRunnerValidate, err.Error())
+		}
+		return RespSuccess(c, ValidateResult{Issues: LintRunnerConfig(nconf)})
+	}
+}
+
 // Delete /logkit/configs/<name>
 func (rs *RestService) DeleteConfig() echo.HandlerFunc {
 	return func(c echo.Context) (err error) {
@@ -495,7 +792,8 @@ func (ln tcpKeepAliveListener) Accept() (c net.Conn, err error) {
 	return tc, nil
 }
 
-func httpserve(addr string, mux http.Handler) (listener net.Listener, err error) {
+// httpserve 启动 http 服务，tlsConfig 不为 nil 时使用 https
+func httpserve(addr string, mux http.Handler, tlsConfig *tls.Config) (listener net.Listener, err error) {
 	if addr == "" {
 		addr = ":http"
 	}
@@ -504,9 +802,13 @@ func httpserve(addr string, mux http.Handler) (listener net.Listener, err error)
 		return
 	}
 
-	srv := &http.Server{Addr: addr, Handler: mux}
+	var ln net.Listener = tcpKeepAliveListener{listener.(*net.TCPListener)}
+	if tlsConfig != nil {
+		ln = tls.NewListener(ln, tlsConfig)
+	}
+	srv := &http.Server{Addr: addr, Handler: mux, TLSConfig: tlsConfig}
 	go func() {
-		log.Error(srv.Serve(tcpKeepAliveListener{listener.(*net.TCPListener)}))
+		log.Error(srv.Serve(ln))
 	}()
 	return
 }
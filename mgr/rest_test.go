@@ -328,6 +328,7 @@ func TestWebRest(t *testing.T) {
 		"getErrorCodeTest":            getErrorCodeTest,
 		"getRunnersTest":              getRunnersTest,
 		"senderRouterTest":            senderRouterTest,
+		"runnerHeadPatternTest":       runnerHeadPatternTest,
 	}
 	wg := &sync.WaitGroup{}
 	wg.Add(len(funcMap))
@@ -51,6 +51,21 @@ const (
 
 	RunnerRunning = "running"
 	RunnerStopped = "stopped"
+	// RunnerRestarting runner 异常退出，正在等待重启
+	RunnerRestarting = "restarting"
+	// RunnerDisabled runner 连续异常退出超过最大重启次数，已被禁用
+	RunnerDisabled = "disabled"
+
+	// SendBarrierAll 所有 sender 都发送成功后才提交 meta
+	SendBarrierAll = "all"
+	// SendBarrierQuorum 至少 SendQuorum 个 sender 发送成功后就提交 meta，其余 sender 仍未发送成功的数据被丢弃，
+	// 之后也不会再发送，因此 SendQuorum 小于 sender 数时需要开启 SendQuorumDiscard
+	SendBarrierQuorum = "quorum"
+
+	// ParseOrderSource 同一数据源(文件、kafka 分区等)的数据固定交给同一个解析协程，按读取的顺序解析和发送
+	ParseOrderSource = "source"
+	// ParseOrderNone 不保证同一数据源的顺序，数据平均分给各个解析协程，数据源很少时吞吐更高
+	ParseOrderNone = "none"
 )
 
 type Runner interface {
@@ -61,10 +76,34 @@ type Runner interface {
 	Status() RunnerStatus
 }
 
+// Drainable 表示 runner 可以在停止前把已经读取的数据发送完毕
+type Drainable interface {
+	Drain(timeout time.Duration)
+}
+
+// Flushable 表示 runner 可以立即发送所有 sender 中缓存的数据
+type Flushable interface {
+	FlushSenders(timeout time.Duration) []SenderFlushResult
+}
+
+// SenderFlushResult 单个 sender 的 flush 结果，Index 为 sender 在配置中的序号，
+// Skipped 表示该 sender 没有缓存数据，不需要 flush
+type SenderFlushResult struct {
+	Index   int    `json:"index"`
+	Name    string `json:"name"`
+	Skipped bool   `json:"skipped,omitempty"`
+	Error   string `json:"error,omitempty"`
+	CostMs  int64  `json:"cost_ms"`
+}
+
 type RunnerErrors interface {
 	GetErrors() ErrorsResult
 }
 
+type RunnerCompletions interface {
+	GetCompletions() ([]reader.CompletionRecord, error)
+}
+
 type TokenRefreshable interface {
 	TokenRefresh(AuthTokens) error
 }
@@ -77,18 +116,30 @@ type StatusPersistable interface {
 var (
 	_ Resetable  = &LogExportRunner{}
 	_ Deleteable = &LogExportRunner{}
+	_ Drainable  = &LogExportRunner{}
+	_ Flushable  = &LogExportRunner{}
 )
 
 type LogExportRunner struct {
 	RunnerInfo
 
-	stopped      int32
-	exitChan     chan struct{}
-	reader       reader.Reader
-	cleaner      *cleaner.Cleaner
-	parser       parser.Parser
-	senders      []sender.Sender
-	router       *router.Router
+	stopped     int32
+	stopping    int32
+	exitChan    chan struct{}
+	reader      reader.Reader
+	cleaner     *cleaner.Cleaner
+	parser      parser.Parser
+	senders     []sender.Sender
+	encodeCache *sender.EncodeCache
+	canary      *canary
+	parseErrors *parseErrorOutput
+	shadow      *shadow
+	schemaDrift *schemaDrift
+	sendPolicy  *sendPolicy
+	latencySLO  *latencySLO
+	router      *router.Router
+	// readerPanics reader 后台 goroutine 的 panic 原因，由 Run 重新 panic 后交给 supervisor 重启
+	readerPanics chan string
 	transformers []transforms.Transformer
 	historyError *ErrorsList
 
@@ -105,6 +156,17 @@ type LogExportRunner struct {
 	syncInc   int
 	tracker   *utils.Tracker
 	auditChan chan<- audit.Message
+
+	// barrierQuorum 提交 meta 前需要全部发送成功的 sender 数，0 表示不等待
+	barrierQuorum int
+	// shadowBatch 本次读取被影子流水线抽中的数据，只在 Run 中使用
+	shadowBatch *shadowBatch
+	// tagPolicy 标签与解析出的字段同名时的处理策略
+	tagPolicy TagConflictPolicy
+	// debug 临时打开的 debug 日志以及原始数据的抓取
+	debug *runnerDebug
+	// quotas 所属的配额([]*quota)，由 manager 定期更新
+	quotas atomic.Value
 }
 
 // NewRunner 创建Runner
@@ -167,6 +229,7 @@ func NewLogExportRunnerWithService(info RunnerInfo, reader reader.Reader, cleane
 		rsMutex:      new(sync.RWMutex),
 		tracker:      utils.NewTracker(),
 		historyMutex: new(sync.RWMutex),
+		debug:        newRunnerDebug(info.RunnerName),
 	}
 
 	if reader == nil {
@@ -179,6 +242,8 @@ func NewLogExportRunnerWithService(info RunnerInfo, reader reader.Reader, cleane
 		return
 	}
 	runner.meta = meta
+	runner.readerPanics = make(chan string, 1)
+	meta.SetPanicHandler(runner.reportReaderPanic)
 	if cleaner == nil {
 		log.Debugf("%v's cleaner was disabled", info.RunnerName)
 	}
@@ -196,7 +261,24 @@ func NewLogExportRunnerWithService(info RunnerInfo, reader reader.Reader, cleane
 		return
 	}
 	runner.senders = senders
+	// 多个 sender 共享同一批数据时，同一种格式的序列化结果只计算一次
+	runner.encodeCache = sender.ShareEncodeCache(senders)
 	runner.router = router
+	runner.barrierQuorum, err = getBarrierQuorum(info, len(senders))
+	if err != nil {
+		return
+	}
+	switch info.ParseOrder {
+	case "", ParseOrderSource, ParseOrderNone:
+	default:
+		err = fmt.Errorf("runner %v parse_order %q is not supported, only %q or %q", info.RunnerName, info.ParseOrder, ParseOrderSource, ParseOrderNone)
+		return
+	}
+	runner.tagPolicy, err = NewTagConflictPolicy(info.TagConflictPolicy, info.TagConflictPrefix)
+	if err != nil {
+		err = fmt.Errorf("runner %v %v", info.RunnerName, err)
+		return
+	}
 	runner.StatusRestore()
 	return runner, nil
 }
@@ -336,10 +418,79 @@ func NewLogExportRunner(rc RunnerConfig, cleanChan chan<- cleaner.CleanSignal, r
 	if err != nil {
 		return nil, fmt.Errorf("runner %v add sender router error, %v", rc.RunnerName, err)
 	}
+	ls, err := newLatencySLO(rc.RunnerName, rc.LatencySLO)
+	if err != nil {
+		return nil, err
+	}
+	if rc.Canary != nil && rc.SendRaw {
+		return nil, fmt.Errorf("runner %v canary sender is not supported when send_raw is enabled", rc.RunnerName)
+	}
+	if rc.Shadow != nil {
+		if rc.SendRaw {
+			return nil, fmt.Errorf("runner %v shadow pipeline is not supported when send_raw is enabled", rc.RunnerName)
+		}
+		if _, ok := rd.(reader.DataReader); ok {
+			return nil, fmt.Errorf("runner %v shadow pipeline is not supported by reader %v which does not use parser", rc.RunnerName, rd.Name())
+		}
+	}
+	if rc.ParseErrorOutput != nil {
+		if rc.SendRaw {
+			return nil, fmt.Errorf("runner %v parse_error_output is not supported when send_raw is enabled", rc.RunnerName)
+		}
+		if _, ok := rd.(reader.DataReader); ok {
+			return nil, fmt.Errorf("runner %v parse_error_output is not supported by reader %v which does not use parser", rc.RunnerName, rd.Name())
+		}
+	}
+	var (
+		cn *canary
+		pe *parseErrorOutput
+		sh *shadow
+		sd *schemaDrift
+		sp *sendPolicy
+	)
+	defer func() {
+		if err == nil {
+			return
+		}
+		if cn != nil {
+			cn.Close()
+		}
+		if pe != nil {
+			pe.Close()
+		}
+		if sh != nil {
+			sh.Close()
+		}
+		if sd != nil {
+			sd.Close()
+		}
+		sp.Close()
+	}()
+	if cn, err = newCanary(rc.RunnerName, rc.Canary, sr, meta.FtSaveLogPath()); err != nil {
+		return nil, err
+	}
+	if pe, err = newParseErrorOutput(rc.RunnerName, rc.ParseErrorOutput, rc.ParserConf, sr, meta.FtSaveLogPath()); err != nil {
+		return nil, err
+	}
+	if sh, err = newShadow(rc.RunnerName, rc.Shadow, rc.ParserConf, pr); err != nil {
+		return nil, err
+	}
+	if sd, err = newSchemaDrift(rc.RunnerName, rc.SchemaDrift); err != nil {
+		return nil, err
+	}
+	if sp, err = newSendPolicy(rc.RunnerName, rc.SendErrorPolicy, senders); err != nil {
+		return nil, err
+	}
 	runner, err = NewLogExportRunnerWithService(runnerInfo, rd, cl, ps, transformers, senders, router, meta)
 	if err != nil {
 		return runner, err
 	}
+	runner.canary = cn
+	runner.parseErrors = pe
+	runner.shadow = sh
+	runner.schemaDrift = sd
+	runner.sendPolicy = sp
+	runner.latencySLO = ls
 	if runner.LogAudit {
 		if rc.AuditChan == nil {
 			runner.LogAudit = false
@@ -420,6 +571,7 @@ func (r *LogExportRunner) tryRawSend(s sender.Sender, datas []string, times int)
 		err = rawSender.RawSend(datas)
 		if err == nil {
 			successDatasLen += int64(len(datas))
+			r.sendPolicy.resume()
 			break
 		}
 
@@ -427,6 +579,7 @@ func (r *LogExportRunner) tryRawSend(s sender.Sender, datas []string, times int)
 		if ok {
 			if se.Errors == 0 {
 				successDatasLen += int64(len(datas))
+				r.sendPolicy.resume()
 				break
 			}
 
@@ -441,21 +594,21 @@ func (r *LogExportRunner) tryRawSend(s sender.Sender, datas []string, times int)
 			}
 		}
 
-		info.LastError = TruncateStrSize(err.Error(), DefaultTruncateMaxSize)
-		r.historyMutex.Lock()
-		if r.historyError.SendErrors == nil {
-			r.historyError.SendErrors = make(map[string]*equeue.ErrorQueue)
-		}
-		if r.historyError.SendErrors[s.Name()] == nil {
-			r.historyError.SendErrors[s.Name()] = equeue.New(r.ErrorsListCap)
-		}
-		r.historyError.SendErrors[s.Name()].Put(equeue.NewError(info.LastError))
-		r.historyMutex.Unlock()
+		r.addSendError(s.Name(), &info, err)
 
 		//FaultTolerant Sender 正常的错误会在backupqueue里面记录，自己重试，此处无需重试
 		if se != nil && se.Ft && se.FtNotRetry {
 			break
 		}
+		class := sender.ClassifyError(err)
+		if class == sender.ErrorClassRejected && r.sendPolicy.rejectEnabled() {
+			// 被服务端拒绝的数据重试也不会成功
+			r.sendPolicy.reject(s.Name(), err, nil, datas)
+			break
+		}
+		if class == sender.ErrorClassAuth && !r.waitAuthPause(s.Name(), err) {
+			return false
+		}
 		time.Sleep(time.Second)
 		_, ok = err.(*reqerr.SendError)
 		if ok {
@@ -487,10 +640,46 @@ func (r *LogExportRunner) tryRawSend(s sender.Sender, datas []string, times int)
 	return true
 }
 
+// getBarrierQuorum 根据 send_barrier 配置计算提交 meta 前需要发送成功的 sender 数
+func getBarrierQuorum(info RunnerInfo, senderCnt int) (int, error) {
+	var quorum int
+	switch info.SendBarrier {
+	case "":
+		return 0, nil
+	case SendBarrierAll:
+		quorum = senderCnt
+	case SendBarrierQuorum:
+		quorum = info.SendQuorum
+		if quorum <= 0 {
+			quorum = senderCnt/2 + 1
+		}
+		if quorum > senderCnt {
+			return 0, fmt.Errorf("runner %v send_quorum %d is larger than sender count %d", info.RunnerName, quorum, senderCnt)
+		}
+		if quorum < senderCnt && !info.SendQuorumDiscard {
+			return 0, fmt.Errorf("runner %v send_barrier %q discards datas of the senders still failing after %d of %d senders succeed, set send_quorum_discard to accept it or use %q",
+				info.RunnerName, SendBarrierQuorum, quorum, senderCnt, SendBarrierAll)
+		}
+	default:
+		return 0, fmt.Errorf("runner %v send_barrier %q is not supported, only %q or %q", info.RunnerName, info.SendBarrier, SendBarrierAll, SendBarrierQuorum)
+	}
+	if info.SendRaw {
+		return 0, fmt.Errorf("runner %v send_barrier is not supported when send_raw is enabled", info.RunnerName)
+	}
+	return quorum, nil
+}
+
 // trySend 尝试发送数据，如果此时runner退出返回false，其他情况无论是达到最大重试次数还是发送成功，都返回true
 func (r *LogExportRunner) trySend(s sender.Sender, datas []Data, times int) bool {
+	sent, _ := r.trySendRemain(s, datas, times)
+	return sent
+}
+
+// trySendRemain 与 trySend 相同，同时返回达到最大重试次数后仍然没有发送成功的数据
+// 容错 sender 已经写入 backupqueue 的数据由容错 sender 自行重试，视为发送成功
+func (r *LogExportRunner) trySendRemain(s sender.Sender, datas []Data, times int) (sent bool, remain []Data) {
 	if len(datas) <= 0 {
-		return true
+		return true, nil
 	}
 	r.rsMutex.Lock()
 	if _, ok := r.rs.SenderStats[s.Name()]; !ok {
@@ -509,11 +698,12 @@ func (r *LogExportRunner) trySend(s sender.Sender, datas []Data, times int) bool
 	for {
 		// 至少尝试一次。如果任务已经停止，那么只尝试一次
 		if cnt > 1 && atomic.LoadInt32(&r.stopped) > 0 {
-			return false
+			return false, datas
 		}
 		err = s.Send(datas)
 		if err == nil {
 			successDatasLen += int64(len(datas))
+			r.sendPolicy.resume()
 			break
 		}
 
@@ -521,6 +711,7 @@ func (r *LogExportRunner) trySend(s sender.Sender, datas []Data, times int) bool
 		if ok {
 			if se.Errors == 0 {
 				successDatasLen += int64(len(datas))
+				r.sendPolicy.resume()
 				break
 			}
 
@@ -535,32 +726,31 @@ func (r *LogExportRunner) trySend(s sender.Sender, datas []Data, times int) bool
 			}
 		}
 
-		info.LastError = TruncateStrSize(err.Error(), DefaultTruncateMaxSize)
-		r.historyMutex.Lock()
-		if r.historyError.SendErrors == nil {
-			r.historyError.SendErrors = make(map[string]*equeue.ErrorQueue)
-		}
-		if r.historyError.SendErrors[s.Name()] == nil {
-			r.historyError.SendErrors[s.Name()] = equeue.New(r.ErrorsListCap)
-		}
-		r.historyError.SendErrors[s.Name()].Put(equeue.NewError(info.LastError))
-		r.historyMutex.Unlock()
+		r.addSendError(s.Name(), &info, err)
 
 		//FaultTolerant Sender 正常的错误会在backupqueue里面记录，自己重试，此处无需重试
 		if se != nil && se.Ft && se.FtNotRetry {
 			break
 		}
-		time.Sleep(time.Second)
 		sendError, ok := err.(*reqerr.SendError)
+		class := sender.ClassifyError(err)
+		if class == sender.ErrorClassRejected && r.sendPolicy.rejectEnabled() {
+			// 被服务端拒绝的数据重试也不会成功
+			if ok {
+				datas = sender.ConvertDatas(sendError.GetFailDatas())
+			}
+			r.sendPolicy.reject(s.Name(), err, datas, nil)
+			break
+		}
+		if class == sender.ErrorClassAuth && !r.waitAuthPause(s.Name(), err) {
+			return false, datas
+		}
+		time.Sleep(time.Second)
 		if ok {
 			datas = sender.ConvertDatas(sendError.GetFailDatas())
 			//无限重试的，除非遇到关闭
 			if atomic.LoadInt32(&r.stopped) > 0 {
-				return false
-			}
-			if sendError.ErrorType == sender.TypeMarshalError {
-				log.Errorf("Runner[%v] datas marshal failed, discard datas, send error %v, failed datas (length %v): %v", r.RunnerName, se.Error(), cnt, datas)
-				break
+				return false, datas
 			}
 			log.Errorf("Runner[%v] send error %v for %v times, failed datas length %v will retry send it", r.RunnerName, se.Error(), cnt, len(datas))
 			cnt++
@@ -569,6 +759,7 @@ func (r *LogExportRunner) trySend(s sender.Sender, datas []Data, times int) bool
 
 		if err == ErrQueueClosed {
 			log.Errorf("Runner[%v] send to closed queue, discard datas, send error %v, failed datas (length %v): %v", r.RunnerName, se.Error(), cnt, datas)
+			remain = datas
 			break
 		}
 		if times <= 0 || cnt < times {
@@ -576,6 +767,7 @@ func (r *LogExportRunner) trySend(s sender.Sender, datas []Data, times int) bool
 			continue
 		}
 		log.Errorf("Runner[%v] retry send %v times, but still error %v, total %v data lines", r.RunnerName, cnt, err, len(datas))
+		remain = datas
 		break
 	}
 
@@ -584,9 +776,77 @@ func (r *LogExportRunner) trySend(s sender.Sender, datas []Data, times int) bool
 	r.rsMutex.Lock()
 	r.rs.SenderStats[s.Name()] = info
 	r.rsMutex.Unlock()
+	return true, remain
+}
+
+// addSendError 记录 sender 最近一次的错误以及错误历史
+func (r *LogExportRunner) addSendError(name string, info *StatsInfo, err error) {
+	info.LastError = TruncateStrSize(err.Error(), DefaultTruncateMaxSize)
+	r.historyMutex.Lock()
+	if r.historyError.SendErrors == nil {
+		r.historyError.SendErrors = make(map[string]*equeue.ErrorQueue)
+	}
+	if r.historyError.SendErrors[name] == nil {
+		r.historyError.SendErrors[name] = equeue.New(r.ErrorsListCap)
+	}
+	r.historyError.SendErrors[name].Put(equeue.NewError(info.LastError))
+	r.historyMutex.Unlock()
+}
+
+// waitAuthPause 认证失败后暂停发送，如果此时runner退出返回false
+func (r *LogExportRunner) waitAuthPause(senderName string, err error) bool {
+	deadline := time.Now().Add(r.sendPolicy.authFailed(senderName, err))
+	for time.Now().Before(deadline) {
+		if atomic.LoadInt32(&r.stopped) > 0 {
+			return false
+		}
+		time.Sleep(time.Second)
+	}
 	return true
 }
 
+// sendWithBarrier 发送数据直到至少 barrierQuorum 个 sender 全部发送成功，未成功的 sender 只重发失败的数据，
+// 如果此时runner退出返回false，此时不应提交 meta。达到 barrierQuorum 后其余 sender 仍未发送成功的数据被丢弃，
+// 这些 sender 恢复后只会收到之后的数据
+func (r *LogExportRunner) sendWithBarrier(senderDataList [][]Data) bool {
+	pending := make(map[int][]Data, len(r.senders))
+	for index := range r.senders {
+		pending[index] = senderDataList[index]
+	}
+	acked := 0
+	for {
+		for index, s := range r.senders {
+			datas, ok := pending[index]
+			if !ok {
+				continue
+			}
+			sent, remain := r.trySendRemain(s, datas, r.MaxBatchTryTimes)
+			if !sent {
+				return false
+			}
+			if len(remain) > 0 {
+				pending[index] = remain
+				continue
+			}
+			delete(pending, index)
+			acked++
+		}
+		if acked >= r.barrierQuorum {
+			if len(pending) > 0 {
+				for index, datas := range pending {
+					log.Warnf("Runner[%v] send barrier reached %d of %d senders, sender %v still failed, discard %d datas", r.RunnerName, acked, len(r.senders), r.senders[index].Name(), len(datas))
+				}
+			}
+			return true
+		}
+		if atomic.LoadInt32(&r.stopped) > 0 {
+			return false
+		}
+		log.Errorf("Runner[%v] send barrier waiting, %d of %d senders acknowledged, need %d, retry failed senders", r.RunnerName, acked, len(r.senders), r.barrierQuorum)
+		time.Sleep(time.Second)
+	}
+}
+
 func getSampleContent(line string, maxBatchSize int) string {
 	if len(line) <= maxBatchSize {
 		return line
@@ -610,6 +870,7 @@ func (r *LogExportRunner) readDatas(dr reader.DataReader, dataSourceTag string)
 	)
 	for !utils.BatchFullOrTimeout(r.RunnerName, &r.stopped, r.batchLen, r.batchSize, r.lastSend,
 		r.MaxBatchLen, r.MaxBatchSize, r.MaxBatchInterval) {
+		r.checkReaderPanic()
 		data, bytes, err = dr.ReadData()
 		if err != nil {
 			log.Errorf("Runner[%v] data reader %s - error: %v, sleep 1 second...", r.Name(), r.reader.Name(), err)
@@ -617,7 +878,7 @@ func (r *LogExportRunner) readDatas(dr reader.DataReader, dataSourceTag string)
 			break
 		}
 		if len(data) <= 0 {
-			log.Debugf("Runner[%v] data reader %s got empty data", r.Name(), r.reader.Name())
+			r.debug.debugf("Runner[%v] data reader %s got empty data", r.Name(), r.reader.Name())
 			continue
 		}
 		if len(dataSourceTag) > 0 {
@@ -644,17 +905,48 @@ func (r *LogExportRunner) readDatas(dr reader.DataReader, dataSourceTag string)
 		r.rs.ReaderStats.LastError = ""
 	}
 	r.rsMutex.Unlock()
+	if r.debug.capturing() && len(datas) > 0 {
+		r.debug.record(nil, datas, nil)
+	}
 	return datas
 }
 
-func (r *LogExportRunner) rawReadLines(dataSourceTag string) (lines, froms []string) {
+// sourceTagger 返回需要按数据源为数据附加字段的 reader，不需要时返回 nil
+func (r *LogExportRunner) sourceTagger() reader.SourceTagsReader {
+	if str, ok := r.reader.(reader.SourceTagsReader); ok && str.SourceTagsEnabled() {
+		return str
+	}
+	return nil
+}
+
+// recordTagger 返回需要为每条数据附加字段的 reader，不需要时返回 nil
+func (r *LogExportRunner) recordTagger() reader.RecordTagsReader {
+	if rtr, ok := r.reader.(reader.RecordTagsReader); ok && rtr.RecordTagsEnabled() {
+		return rtr
+	}
+	return nil
+}
+
+// sourceKey 返回最近一次读出的数据所在的数据源，用于并行解析时将同一数据源的数据交给同一个协程
+func (r *LogExportRunner) sourceKey() string {
+	if pr, ok := r.reader.(reader.PartitionReader); ok {
+		return pr.Partition()
+	}
+	return r.reader.Source()
+}
+
+func (r *LogExportRunner) rawReadLines(dataSourceTag string) (lines, froms, keys []string, records []map[string]interface{}) {
 	var line string
 	var err error
+	needSource := dataSourceTag != "" || r.sourceTagger() != nil || r.parseErrors != nil
+	recorder := r.recordTagger()
+	needKey := r.ParseWorkers > 1 && r.ParseOrder != ParseOrderNone
 	for !utils.BatchFullOrTimeout(r.RunnerName, &r.stopped, r.batchLen, r.batchSize, r.lastSend,
 		r.MaxBatchLen, r.MaxBatchSize, r.MaxBatchInterval) {
+		r.checkReaderPanic()
 		line, err = r.reader.ReadLine()
 		if os.IsNotExist(err) {
-			log.Debugf("Runner[%v] reader %s - error: %v, sleep 3 second...", r.Name(), r.reader.Name(), err)
+			r.debug.debugf("Runner[%v] reader %s - error: %v, sleep 3 second...", r.Name(), r.reader.Name(), err)
 			time.Sleep(3 * time.Second)
 			break
 		}
@@ -664,7 +956,7 @@ func (r *LogExportRunner) rawReadLines(dataSourceTag string) (lines, froms []str
 			break
 		}
 		if len(line) <= 0 {
-			log.Debugf("Runner[%v] reader %s no more content fetched sleep 1 second...", r.Name(), r.reader.Name())
+			r.debug.debugf("Runner[%v] reader %s no more content fetched sleep 1 second...", r.Name(), r.reader.Name())
 			time.Sleep(1 * time.Second)
 			continue
 		}
@@ -672,9 +964,15 @@ func (r *LogExportRunner) rawReadLines(dataSourceTag string) (lines, froms []str
 			continue
 		}
 		lines = append(lines, line)
-		if dataSourceTag != "" {
+		if needSource {
 			froms = append(froms, r.reader.Source())
 		}
+		if needKey {
+			keys = append(keys, r.sourceKey())
+		}
+		if recorder != nil {
+			records = append(records, recorder.RecordTags())
+		}
 
 		r.batchLen++
 		r.batchSize += int64(len(line))
@@ -696,7 +994,26 @@ func (r *LogExportRunner) rawReadLines(dataSourceTag string) (lines, froms []str
 		r.rs.ReaderStats.LastError = ""
 	}
 	r.rsMutex.Unlock()
-	return lines, froms
+	return lines, froms, keys, records
+}
+
+// readEventLines 取出 reader 产生的事件数据，发送原始数据时以 json 格式追加到这一批数据中
+func (r *LogExportRunner) readEventLines() []string {
+	er, ok := r.reader.(reader.EventReader)
+	if !ok {
+		return nil
+	}
+	events := er.Events()
+	lines := make([]string, 0, len(events))
+	for _, event := range events {
+		line, err := jsoniter.Marshal(event)
+		if err != nil {
+			log.Errorf("Runner[%v] marshal reader event %v error: %v", r.Name(), event, err)
+			continue
+		}
+		lines = append(lines, string(line))
+	}
+	return lines
 }
 
 func (r *LogExportRunner) readLines(dataSourceTag string) []Data {
@@ -704,8 +1021,12 @@ func (r *LogExportRunner) readLines(dataSourceTag string) []Data {
 		err        error
 		curTimeStr string
 	)
-	lines, froms := r.rawReadLines(dataSourceTag)
+	lines, froms, keys, records := r.rawReadLines(dataSourceTag)
 	r.tracker.Track("finish rawReadLines")
+	if r.shadow != nil {
+		r.shadowBatch = r.shadow.Sample(lines, froms)
+	}
+	rawLines := lines
 	for i := range r.transformers {
 		if r.transformers[i].Stage() == transforms.StageBeforeParser {
 			lines, err = r.transformers[i].RawTransform(lines)
@@ -721,7 +1042,7 @@ func (r *LogExportRunner) readLines(dataSourceTag string) []Data {
 
 	linenums := len(lines)
 	if linenums <= 0 {
-		log.Debugf("Runner[%v] fetched 0 lines", r.Name())
+		r.debug.debugf("Runner[%v] fetched 0 lines", r.Name())
 		_, ok := r.parser.(parser.Flushable)
 		if ok {
 			lines = []string{config.PandoraParseFlushSignal}
@@ -731,9 +1052,24 @@ func (r *LogExportRunner) readLines(dataSourceTag string) []Data {
 	}
 
 	// parse data
-	var numErrs int64
-	datas, err := r.parser.Parse(lines)
+	datas, froms, records, err := r.parseWithRecords(lines, froms, keys, records)
 	r.tracker.Track("finish parse data")
+	se, err := r.addParseStats(linenums, err)
+	if r.debug.capturing() && len(rawLines) > 0 {
+		r.debug.record(rawLines, datas, err)
+	}
+
+	// send data
+	if len(datas) <= 0 {
+		r.debug.debugf("Runner[%v] received parsed data length = 0", r.Name())
+		return []Data{}
+	}
+	return r.decorateDatas(datas, froms, records, se, r.dataTags(curTimeStr), dataSourceTag)
+}
+
+// addParseStats 记录解析的统计信息和错误，返回解析返回的 StatsError 以及需要记录的错误，没有错误时返回的错误为 nil
+func (r *LogExportRunner) addParseStats(linenums int, err error) (*StatsError, error) {
+	var numErrs int64
 	se, ok := err.(*StatsError)
 	r.rsMutex.Lock()
 	if ok {
@@ -763,16 +1099,14 @@ func (r *LogExportRunner) readLines(dataSourceTag string) []Data {
 	r.rsMutex.Unlock()
 	if err != nil {
 		errMsg := fmt.Sprintf("Runner[%v] parser %s error : %v ", r.Name(), r.parser.Name(), err.Error())
-		log.Debugf(errMsg)
+		r.debug.debugf("%s", errMsg)
 		(&SchemaErr{}).Output(numErrs, errors.New(errMsg))
 	}
+	return se, err
+}
 
-	// send data
-	if len(datas) <= 0 {
-		log.Debugf("Runner[%v] received parsed data length = 0", r.Name())
-		return []Data{}
-	}
-
+// dataTags 返回需要加到每条数据中的标签，curTimeStr 为配置了 read_time 时的读取时间
+func (r *LogExportRunner) dataTags(curTimeStr string) map[string]interface{} {
 	tags := r.meta.GetTags()
 	if r.ExtraInfo {
 		tags = MergeEnvTags(r.EnvTag, tags)
@@ -781,8 +1115,18 @@ func (r *LogExportRunner) readLines(dataSourceTag string) []Data {
 	if r.ReadTime {
 		tags["lst"] = curTimeStr
 	}
+	return tags
+}
+
+// decorateDatas 为解析后的数据加上标签、数据来源、记录元数据以及编码信息，并拆分出解析失败的数据
+func (r *LogExportRunner) decorateDatas(datas []Data, froms []string, records []map[string]interface{}, se *StatsError,
+	tags map[string]interface{}, dataSourceTag string) []Data {
+	if r.shadowBatch != nil {
+		r.shadowBatch.setExtraInfo(tags, dataSourceTag, r.meta.GetEncodeTag(), r.meta.GetEncodingWay())
+		r.shadowBatch.tagPolicy = r.tagPolicy
+	}
 	if len(tags) > 0 {
-		datas = AddTagsToData(tags, datas, r.Name())
+		r.addTags(tags, datas)
 	}
 
 	// 把 source 加到 data 里，前提是认为 []line 变成 []data 以后是一一对应的，一旦错位就不加
@@ -794,16 +1138,30 @@ func (r *LogExportRunner) readLines(dataSourceTag string) []Data {
 			var selen int
 			if se != nil {
 				selen = len(se.DatasourceSkipIndex)
-				log.Debugf("Runner[%v] datasourcetag add error, datas %v datasourceSkipIndex %v froms %v", r.Name(), datas, se.DatasourceSkipIndex, froms)
+				r.debug.debugf("Runner[%v] datasourcetag add error, datas %v datasourceSkipIndex %v froms %v", r.Name(), datas, se.DatasourceSkipIndex, froms)
 			}
 			log.Errorf("Runner[%v] datasourcetag add error, datas(TOTAL %v), datasourceSkipIndex(TOTAL %v) not match with froms(TOTAL %v)", r.Name(), len(datas), selen, len(froms))
 		}
 	}
+	if tagger := r.sourceTagger(); tagger != nil {
+		if len(datas) <= len(froms) {
+			datas = addSourceTagsToData(froms, se, datas, tagger)
+		} else {
+			log.Errorf("Runner[%v] source tags add error, datas(TOTAL %v) not match with froms(TOTAL %v)", r.Name(), len(datas), len(froms))
+		}
+	}
+	if len(records) > 0 {
+		if len(datas) <= len(records) {
+			datas = addRecordTagsToData(records, se, datas)
+		} else {
+			log.Errorf("Runner[%v] record tags add error, datas(TOTAL %v) not match with records(TOTAL %v)", r.Name(), len(datas), len(records))
+		}
+	}
 	encodeTag := r.meta.GetEncodeTag()
 	if encodeTag != "" {
 		addEncodeToData(datas, encodeTag, r.meta.GetEncodingWay(), r.Name())
 	}
-	return datas
+	return r.splitParseErrors(datas, froms, se)
 }
 
 func (r *LogExportRunner) addResetStat() {
@@ -859,6 +1217,10 @@ func (r *LogExportRunner) Run() {
 	if r.cleaner != nil {
 		go r.cleaner.Run()
 	}
+	batchParser, batchSenders := r.batchPath()
+	if batchParser != nil {
+		log.Infof("Runner[%v] parser %s and senders support batch, send parsed batches directly", r.RunnerName, r.parser.Name())
+	}
 	defer close(r.exitChan)
 	defer func() {
 		// recover when runner is stopped
@@ -872,25 +1234,32 @@ func (r *LogExportRunner) Run() {
 
 	for {
 		if atomic.LoadInt32(&r.stopped) > 0 {
-			log.Debugf("Runner[%v] exited from run", r.Name())
+			r.debug.debugf("Runner[%v] exited from run", r.Name())
 			r.reader.SyncMeta()
 			if atomic.LoadInt32(&r.stopped) < 2 {
 				r.exitChan <- struct{}{}
 			}
 			return
 		}
+		r.checkReaderPanic()
+		if !r.waitDiskQuota() {
+			continue
+		}
 		r.tracker.Reset()
+		readTime := time.Now()
 		if r.SendRaw {
-			lines, _ := r.rawReadLines(r.meta.GetDataSourceTag())
+			lines, _, _, _ := r.rawReadLines(r.meta.GetDataSourceTag())
+			lines = append(lines, r.readEventLines()...)
 			r.tracker.Track("finish rawReadLines")
 			batchLen, batchSize := r.batchLen, r.batchSize
 			r.addResetStat()
 			// send data
 			if len(lines) <= 0 {
-				log.Debugf("Runner[%v] received read data length = 0", r.Name())
+				r.debug.debugf("Runner[%v] received read data length = 0", r.Name())
 				continue
 			}
-			log.Debugf("Runner[%v] reader %s start to send at: %v", r.Name(), r.reader.Name(), time.Now().Format(time.RFC3339))
+			r.waitSendQuota(batchSize)
+			r.debug.debugf("Runner[%v] reader %s start to send at: %v", r.Name(), r.reader.Name(), time.Now().Format(time.RFC3339))
 			success := true
 			dataLen := len(lines)
 			for _, s := range r.senders {
@@ -903,24 +1272,53 @@ func (r *LogExportRunner) Run() {
 			r.tracker.Track("finish Sender")
 			if success {
 				r.syncAndLog(batchLen, batchSize, int64(dataLen))
+				r.observeLatency(readTime)
 			}
-			log.Debugf("Runner[%v] send %s finish to send at: %v", r.Name(), r.reader.Name(), time.Now().Format(time.RFC3339))
-			log.Debug(r.tracker.Print())
+			r.debug.debugf("Runner[%v] send %s finish to send at: %v", r.Name(), r.reader.Name(), time.Now().Format(time.RFC3339))
+			r.debug.debugf("%s", r.tracker.Print())
 			continue
 		}
 		// read data
 		var err error
 		var datas []Data
-		if dr, ok := r.reader.(reader.DataReader); ok {
+		var batch *Batch
+		if batchParser != nil {
+			batch, datas = r.readBatch(batchParser, r.meta.GetDataSourceTag())
+			r.tracker.Track("finish readBatch")
+		} else if dr, ok := r.reader.(reader.DataReader); ok {
 			datas = r.readDatas(dr, r.meta.GetDataSourceTag())
 			r.tracker.Track("finish readDatas")
 		} else {
 			datas = r.readLines(r.meta.GetDataSourceTag())
 			r.tracker.Track("finish readLines")
 		}
+		if er, ok := r.reader.(reader.EventReader); ok {
+			events := er.Events()
+			if batch != nil {
+				for _, event := range events {
+					batch.AppendData(event)
+				}
+			} else {
+				datas = append(datas, events...)
+			}
+		}
 		batchLen, batchSize := r.batchLen, r.batchSize
 		r.addResetStat()
+		if batch != nil {
+			r.waitSendQuota(batchSize)
+			dataLen := batch.Len()
+			success := r.sendBatch(batchSenders, batch)
+			r.encodeCache.Reset()
+			r.tracker.Track("finish Sender")
+			if success {
+				r.syncAndLog(batchLen, batchSize, int64(dataLen))
+				r.observeLatency(readTime)
+			}
+			r.debug.debugf("%s", r.tracker.Print())
+			continue
+		}
 		if len(datas) <= 0 {
+			r.feedShadow(datas)
 			continue
 		}
 
@@ -969,25 +1367,60 @@ func (r *LogExportRunner) Run() {
 			}
 		}
 		r.tracker.Track("finish transformers")
+		r.feedShadow(datas)
+		if r.schemaDrift != nil {
+			r.schemaDrift.Check(datas)
+		}
 		dataLen := len(datas)
-		log.Debugf("Runner[%v] reader %s start to send at: %v", r.Name(), r.reader.Name(), time.Now().Format(time.RFC3339))
+		// 发送时 sender 可能修改数据，在发送前取出事件时间
+		oldest := readTime
+		if r.latencySLO != nil {
+			oldest = r.latencySLO.Oldest(datas, readTime)
+		}
+		r.waitSendQuota(batchSize)
+		r.debug.debugf("Runner[%v] reader %s start to send at: %v", r.Name(), r.reader.Name(), time.Now().Format(time.RFC3339))
 		success := true
+		var canaryDatas []Data
+		if r.canary != nil {
+			canaryDatas = r.canary.Sample(datas)
+		}
 		senderDataList := classifySenderData(r.senders, datas, r.router)
-		for index, s := range r.senders {
-			if !r.trySend(s, senderDataList[index], r.MaxBatchTryTimes) {
+		if r.barrierQuorum > 0 {
+			if !r.sendWithBarrier(senderDataList) {
 				success = false
-				log.Errorf("Runner[%v] failed to send data finally", r.Name())
-				break
+				log.Errorf("Runner[%v] failed to send data finally, meta will not be synced", r.Name())
+			}
+		} else {
+			for index, s := range r.senders {
+				if !r.trySend(s, senderDataList[index], r.MaxBatchTryTimes) {
+					success = false
+					log.Errorf("Runner[%v] failed to send data finally", r.Name())
+					break
+				}
 			}
 		}
+		if success && r.canary != nil {
+			r.canary.Feed(canaryDatas)
+		}
+		r.encodeCache.Reset()
 		r.tracker.Track("finish Sender")
 
 		if success {
 			r.syncAndLog(batchLen, batchSize, int64(dataLen))
+			r.observeLatency(oldest)
 		}
-		log.Debugf("Runner[%v] send %s finish to send at: %v", r.Name(), r.reader.Name(), time.Now().Format(time.RFC3339))
-		log.Debug(r.tracker.Print())
+		r.debug.debugf("Runner[%v] send %s finish to send at: %v", r.Name(), r.reader.Name(), time.Now().Format(time.RFC3339))
+		r.debug.debugf("%s", r.tracker.Print())
+	}
+}
+
+// feedShadow 将本次抽中的数据以及正式流水线的处理结果交给影子流水线
+func (r *LogExportRunner) feedShadow(datas []Data) {
+	if r.shadowBatch == nil {
+		return
 	}
+	r.shadow.Feed(r.shadowBatch, datas)
+	r.shadowBatch = nil
 }
 
 func classifySenderData(senders []sender.Sender, datas []Data, router *router.Router) [][]Data {
@@ -1027,6 +1460,18 @@ func classifySenderData(senders []sender.Sender, datas []Data, router *router.Ro
 	return senderDataList
 }
 
+// addTags 按照 tag_conflict_policy 将标签加到数据中，并统计与解析出的字段冲突的次数
+func (r *LogExportRunner) addTags(tags map[string]interface{}, datas []Data) {
+	conflicts := r.tagPolicy.AddTags(tags, datas)
+	if conflicts <= 0 {
+		return
+	}
+	r.debug.debugf("Runner[%v] %d tags conflict with parsed fields, resolved by policy %q", r.Name(), conflicts, r.tagPolicy.Policy)
+	r.rsMutex.Lock()
+	r.rs.TagConflicts += conflicts
+	r.rsMutex.Unlock()
+}
+
 func addSourceToData(sourceFroms []string, se *StatsError, datas []Data, datasourceTagName, runnerName string) []Data {
 	j := 0
 	eql := len(sourceFroms) == len(datas)
@@ -1052,6 +1497,62 @@ func addSourceToData(sourceFroms []string, se *StatsError, datas []Data, datasou
 	return datas
 }
 
+// addSourceTagsToData 与 addSourceToData 相同按顺序将数据对应到 froms，添加 reader 按数据源返回的字段，
+// 不覆盖解析出的同名字段
+func addSourceTagsToData(sourceFroms []string, se *StatsError, datas []Data, tagger reader.SourceTagsReader) []Data {
+	j := 0
+	eql := len(sourceFroms) == len(datas)
+	cache := make(map[string]map[string]interface{})
+	for i, v := range sourceFroms {
+		if !eql && se != nil && se.ErrorIndexIn(i) {
+			continue
+		}
+		if eql {
+			j = i
+		}
+		if j >= len(datas) {
+			continue
+		}
+		tags, ok := cache[v]
+		if !ok {
+			tags = tagger.SourceTags(v)
+			cache[v] = tags
+		}
+		for k, tag := range tags {
+			if _, ok := datas[j][k]; !ok {
+				datas[j][k] = tag
+			}
+		}
+		j++
+	}
+	return datas
+}
+
+// addRecordTagsToData 与 addSourceTagsToData 相同按顺序将数据对应到 records，添加每条数据各自的字段，
+// 不覆盖解析出的同名字段
+func addRecordTagsToData(records []map[string]interface{}, se *StatsError, datas []Data) []Data {
+	j := 0
+	eql := len(records) == len(datas)
+	for i, tags := range records {
+		if !eql && se != nil && se.ErrorIndexIn(i) {
+			continue
+		}
+		if eql {
+			j = i
+		}
+		if j >= len(datas) {
+			continue
+		}
+		for k, tag := range tags {
+			if _, ok := datas[j][k]; !ok {
+				datas[j][k] = tag
+			}
+		}
+		j++
+	}
+	return datas
+}
+
 func addEncodeToData(datas []Data, encodeTag, encode, runnerName string) {
 	for idx := range datas {
 		if dt, ok := datas[idx][encodeTag]; ok {
@@ -1066,6 +1567,68 @@ func addEncodeToData(datas []Data, encodeTag, encode, runnerName string) {
 // 先停Reader，不再读取，然后停Run函数，让读取的都转到发送，最后停Sender结束整个过程。
 // Parser 无状态，无需stop。
 func (r *LogExportRunner) Stop() {
+	r.stop(0)
+}
+
+// Drain 与 Stop 相同，但是在关闭 sender 之前等待 sender 队列中的数据发送完毕，最多等待 timeout，超时后剩余的数据保留在容错队列中
+func (r *LogExportRunner) Drain(timeout time.Duration) {
+	r.stop(timeout)
+}
+
+// FlushSenders 并发 flush 所有 sender，每个 sender 最多等待 timeout，runner 中还未交给 sender 的数据不会被发送
+func (r *LogExportRunner) FlushSenders(timeout time.Duration) []SenderFlushResult {
+	results := make([]SenderFlushResult, len(r.senders))
+	var wg sync.WaitGroup
+	for i, s := range r.senders {
+		results[i] = SenderFlushResult{Index: i, Name: s.Name()}
+		fs, ok := s.(sender.FlushSender)
+		if !ok {
+			results[i].Skipped = true
+			continue
+		}
+		wg.Add(1)
+		go func(res *SenderFlushResult, fs sender.FlushSender) {
+			defer wg.Done()
+			start := time.Now()
+			if err := fs.Flush(timeout); err != nil {
+				res.Error = err.Error()
+				log.Warnf("Runner[%v] Sender[%v] flush error: %v", r.Name(), res.Name, err)
+			}
+			res.CostMs = int64(time.Since(start) / time.Millisecond)
+		}(&results[i], fs)
+	}
+	wg.Wait()
+	return results
+}
+
+// waitQueueDrained 等待所有 sender 队列中的数据发送完毕
+func (r *LogExportRunner) waitQueueDrained(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for {
+		var depth int64
+		for _, s := range r.senders {
+			if qs, ok := s.(sender.QueueSender); ok {
+				depth += qs.QueueDepth()
+			}
+		}
+		if depth == 0 {
+			log.Infof("Runner[%v] sender queues are drained", r.Name())
+			return
+		}
+		if time.Now().After(deadline) {
+			log.Warnf("Runner[%v] drain timeout after %v, %d batches are kept in sender queues", r.Name(), timeout, depth)
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// stop 只会执行一次，Drain 之后 manager 移除 runner 时再次调用 Stop 直接返回
+func (r *LogExportRunner) stop(drainTimeout time.Duration) {
+	if !atomic.CompareAndSwapInt32(&r.stopping, 0, 1) {
+		log.Debugf("Runner[%v] is already stopping or stopped", r.Name())
+		return
+	}
 	log.Infof("Runner[%v] wait for reader %v to stop", r.Name(), r.reader.Name())
 	err := r.reader.Close()
 	if err != nil {
@@ -1092,6 +1655,10 @@ func (r *LogExportRunner) Stop() {
 		atomic.AddInt32(&r.stopped, 1)
 	}
 
+	if drainTimeout > 0 {
+		r.waitQueueDrained(drainTimeout)
+	}
+
 	for _, t := range r.transformers {
 		if c, ok := t.(io.Closer); ok {
 			if err := c.Close(); err != nil {
@@ -1109,6 +1676,23 @@ func (r *LogExportRunner) Stop() {
 			log.Warnf("Runner[%v] sender %v closed", r.Name(), s.Name())
 		}
 	}
+	if r.canary != nil {
+		if err := r.canary.Close(); err == nil {
+			log.Warnf("Runner[%v] canary sender %v closed", r.Name(), r.canary.Name())
+		}
+	}
+	if r.parseErrors != nil {
+		if err := r.parseErrors.Close(); err == nil {
+			log.Warnf("Runner[%v] parse error sender %v closed", r.Name(), r.parseErrors.Name())
+		}
+	}
+	if r.shadow != nil {
+		r.shadow.Close()
+	}
+	if r.schemaDrift != nil {
+		r.schemaDrift.Close()
+	}
+	r.sendPolicy.Close()
 
 	if r.cleaner != nil {
 		r.cleaner.Close()
@@ -1169,6 +1753,16 @@ func (r *LogExportRunner) LagStats() (rl *LagInfo, err error) {
 	return
 }
 
+// catchUpETA 所有文件的 lag 之和按所有文件的读取速度之和估计读完需要的秒数
+func catchUpETA(files []FileStatus) int64 {
+	var lag, rate int64
+	for _, f := range files {
+		lag += f.Lag
+		rate += f.ReadRate
+	}
+	return CatchUpETA(lag, float64(rate))
+}
+
 func getTrend(old, new int64) string {
 	if old <= new-1 {
 		return SpeedUp
@@ -1179,6 +1773,60 @@ func getTrend(old, new int64) string {
 	return SpeedStable
 }
 
+// GetCompletions 返回 reader 记录的已读完文件的校验值，reader 不支持时返回 ErrNotSupport
+// SetDebug 临时打开 runner 的 debug 日志，duration 之后自动恢复，duration 为 0 时立即恢复
+func (r *LogExportRunner) SetDebug(duration time.Duration) time.Time {
+	return r.debug.enable(duration)
+}
+
+// StartCapture 开始抓取接下来读取到的 lines 条原始数据以及解析结果
+func (r *LogExportRunner) StartCapture(lines int) error {
+	if r.SendRaw {
+		return errors.New("capture is not supported when send_raw is enabled")
+	}
+	return r.debug.startCapture(lines)
+}
+
+func (r *LogExportRunner) GetCapture() (*DebugCapture, error) {
+	return r.debug.getCapture()
+}
+
+// CapturedRequests 返回开启了请求记录的 sender 最近发送的请求
+func (r *LogExportRunner) CapturedRequests() []SenderRequests {
+	results := make([]SenderRequests, 0)
+	for i, s := range r.senders {
+		cs, ok := s.(sender.RequestCaptureSender)
+		if !ok {
+			continue
+		}
+		if requests := cs.CapturedRequests(); requests != nil {
+			results = append(results, SenderRequests{Index: i, Name: s.Name(), Requests: requests})
+		}
+	}
+	return results
+}
+
+// ReplayRequest 重新发送第 senderIndex 个 sender 记录的请求
+func (r *LogExportRunner) ReplayRequest(senderIndex int, id int64) (*sender.CapturedRequest, error) {
+	if senderIndex < 0 || senderIndex >= len(r.senders) {
+		return nil, fmt.Errorf("sender index %d is out of range [0, %d)", senderIndex, len(r.senders))
+	}
+	cs, ok := r.senders[senderIndex].(sender.RequestCaptureSender)
+	if !ok {
+		return nil, ErrNotSupport
+	}
+	log.Infof("Runner[%v] replay request %d of sender %v", r.Name(), id, r.senders[senderIndex].Name())
+	return cs.ReplayRequest(id)
+}
+
+func (r *LogExportRunner) GetCompletions() ([]reader.CompletionRecord, error) {
+	cr, ok := r.reader.(reader.CompletionReader)
+	if !ok {
+		return nil, ErrNotSupport
+	}
+	return cr.Completions()
+}
+
 func (r *LogExportRunner) GetErrors() ErrorsResult {
 	r.historyMutex.RLock()
 	defer r.historyMutex.RUnlock()
@@ -1222,6 +1870,13 @@ func (r *LogExportRunner) getRefreshStatus(elaspedtime float64) RunnerStatus {
 	if rl != nil {
 		r.rs.Lag = *rl
 	}
+	if dr, ok := r.reader.(reader.DetailStatusReader); ok {
+		r.rs.ReaderFiles = dr.DetailStatus()
+		r.rs.CatchUpETA = catchUpETA(r.rs.ReaderFiles)
+	}
+	if cr, ok := r.reader.(reader.ClientStatusReader); ok {
+		r.rs.ReaderClients = cr.ClientStatus()
+	}
 
 	r.rs.Elaspedtime += elaspedtime
 	r.rs.lastState = now
@@ -1269,6 +1924,22 @@ func (r *LogExportRunner) getRefreshStatus(elaspedtime float64) RunnerStatus {
 			r.rs.SenderStats[r.senders[i].Name()] = senderStats
 		}
 	}
+	if r.canary != nil {
+		r.rs.SenderStats[r.canary.Name()] = r.canary.Stats()
+	}
+	if r.parseErrors != nil {
+		r.rs.SenderStats[r.parseErrors.Name()] = r.parseErrors.Stats()
+	}
+	if r.shadow != nil {
+		r.rs.ShadowStats = r.shadow.Stats()
+	}
+	if r.schemaDrift != nil {
+		r.rs.SchemaDrift = r.schemaDrift.Stats()
+	}
+	r.rs.SendErrorPolicy = r.sendPolicy.Stats()
+	if r.latencySLO != nil {
+		r.rs.LatencySLO = r.latencySLO.Stats()
+	}
 
 	for k, v := range r.rs.SenderStats {
 		if lv, ok := r.lastRs.SenderStats[k]; ok {
@@ -1293,7 +1964,7 @@ func calcSpeedTrend(old, new StatsInfo, elaspedtime float64) (speed int64, trend
 	return
 }
 
-//Compatible 用于新老配置的兼容
+// Compatible 用于新老配置的兼容
 func Compatible(rc RunnerConfig) RunnerConfig {
 	//兼容qiniulog与reader多行的配置
 	if rc.ParserConf == nil {
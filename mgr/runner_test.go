@@ -25,6 +25,7 @@ import (
 	"github.com/qiniu/logkit/parser/qiniu"
 	"github.com/qiniu/logkit/reader"
 	readerConf "github.com/qiniu/logkit/reader/config"
+	"github.com/qiniu/logkit/reader/tailx"
 	"github.com/qiniu/logkit/router"
 	"github.com/qiniu/logkit/sender"
 	_ "github.com/qiniu/logkit/sender/builtin"
@@ -741,7 +742,7 @@ func Test_QiniulogRun(t *testing.T) {
 	}
 	ls, err := runner.LagStats()
 	assert.NoError(t, err)
-	assert.Equal(t, &LagInfo{0, "bytes", 0, 0}, ls)
+	assert.Equal(t, &LagInfo{0, "bytes", 0, 0, nil}, ls)
 }
 
 func TestCreateTransforms(t *testing.T) {
@@ -945,6 +946,14 @@ func TestGetTrend(t *testing.T) {
 	assert.Equal(t, SpeedStable, getTrend(1, 1))
 }
 
+func TestCatchUpETA(t *testing.T) {
+	t.Parallel()
+	assert.EqualValues(t, 0, catchUpETA(nil))
+	assert.EqualValues(t, 0, catchUpETA([]FileStatus{{Lag: 0, ReadRate: 0}}))
+	assert.EqualValues(t, -1, catchUpETA([]FileStatus{{Lag: 10, ReadRate: 0}}))
+	assert.EqualValues(t, 4, catchUpETA([]FileStatus{{Lag: 250, ReadRate: 50}, {Lag: 100, ReadRate: 50}}))
+}
+
 func TestSpeedTrend(t *testing.T) {
 	t.Parallel()
 	tests := []struct {
@@ -1227,6 +1236,61 @@ func TestAddDatasource(t *testing.T) {
 
 }
 
+type mapSourceTagger map[string]map[string]interface{}
+
+func (m mapSourceTagger) SourceTagsEnabled() bool { return true }
+
+func (m mapSourceTagger) SourceTags(source string) map[string]interface{} { return m[source] }
+
+func TestAddSourceTagsToData(t *testing.T) {
+	t.Parallel()
+	tagger := mapSourceTagger{
+		"a": {"k8s_pod": "pod-a", "f1": "tag"},
+		"b": {"k8s_pod": "pod-b"},
+	}
+	datas := []Data{{"f1": "1"}, {"f2": "2"}, {"f3": "3"}}
+	gots := addSourceTagsToData([]string{"a", "b", "c"}, nil, datas, tagger)
+	assert.Equal(t, []Data{
+		{"f1": "1", "k8s_pod": "pod-a"},
+		{"f2": "2", "k8s_pod": "pod-b"},
+		{"f3": "3"},
+	}, gots)
+
+	// 解析失败的数据跳过对应的数据源
+	se := &StatsError{DatasourceSkipIndex: []int{0}}
+	datas = []Data{{"f2": "2"}, {"f3": "3"}}
+	gots = addSourceTagsToData([]string{"a", "b", "a"}, se, datas, tagger)
+	assert.Equal(t, []Data{
+		{"f2": "2", "k8s_pod": "pod-b"},
+		{"f3": "3", "k8s_pod": "pod-a", "f1": "tag"},
+	}, gots)
+}
+
+func TestAddRecordTagsToData(t *testing.T) {
+	t.Parallel()
+	records := []map[string]interface{}{
+		{"kafka_offset": int64(1), "f1": "tag"},
+		{"kafka_offset": int64(2)},
+		nil,
+	}
+	datas := []Data{{"f1": "1"}, {"f2": "2"}, {"f3": "3"}}
+	gots := addRecordTagsToData(records, nil, datas)
+	assert.Equal(t, []Data{
+		{"f1": "1", "kafka_offset": int64(1)},
+		{"f2": "2", "kafka_offset": int64(2)},
+		{"f3": "3"},
+	}, gots)
+
+	// 解析失败的数据跳过对应的字段
+	se := &StatsError{DatasourceSkipIndex: []int{0}}
+	datas = []Data{{"f2": "2"}, {"f3": "3"}}
+	gots = addRecordTagsToData(records, se, datas)
+	assert.Equal(t, []Data{
+		{"f2": "2", "kafka_offset": int64(2)},
+		{"f3": "3"},
+	}, gots)
+}
+
 func TestAddEncode(t *testing.T) {
 	t.Parallel()
 	datas := []Data{
@@ -1551,6 +1615,60 @@ func TestRunWithExtra(t *testing.T) {
 	assert.Equal(t, 8, len(res[0]))
 }
 
+func TestRunWithFileEvents(t *testing.T) {
+	t.Parallel()
+	dir := "TestRunWithFileEvents"
+	assert.NoError(t, os.Mkdir(dir, DefaultDirPerm))
+	defer os.RemoveAll(dir)
+	logPath := filepath.Join(dir, "test.log")
+	assert.NoError(t, ioutil.WriteFile(logPath, []byte("line1\n"), DefaultFilePerm))
+
+	config := `{
+			"name":"TestRunWithFileEvents",
+			"batch_len":1,
+			"reader":{
+				"mode":"tailx",
+				"meta_path":"./TestRunWithFileEvents/meta",
+				"log_path":"./TestRunWithFileEvents/*.log",
+				"read_from":"oldest",
+				"file_events":"true"
+			},
+			"parser":{
+				"name":"testraw",
+				"type":"raw"
+			},
+			"senders":[{
+				"name":"mock_sender",
+				"sender_type":"mock"
+			}]
+		}`
+	rc := RunnerConfig{}
+	assert.NoError(t, jsoniter.Unmarshal([]byte(config), &rc))
+	rr, err := NewCustomRunner(rc, make(chan cleaner.CleanSignal), reader.NewRegistry(), parser.NewRegistry(), sender.NewRegistry())
+	assert.NoError(t, err)
+	go rr.Run()
+	time.Sleep(3 * time.Second)
+	rr.Stop()
+
+	// 事件数据不经过 raw parser，直接作为一条数据发送，mock sender 的名称中包含收到的数据
+	name := rr.(*LogExportRunner).senders[0].Name()
+	var datas []Data
+	assert.NoError(t, jsoniter.Unmarshal([]byte(name[strings.Index(name, " ")+1:]), &datas))
+	var events, lines int
+	for _, d := range datas {
+		if d[tailx.KeyFileEvent] != nil {
+			events++
+			assert.Equal(t, tailx.FileEventDiscovered, d[tailx.KeyFileEvent])
+			assert.Nil(t, d["raw"])
+			continue
+		}
+		lines++
+		assert.Equal(t, "line1\n", d["raw"])
+	}
+	assert.Equal(t, 1, events)
+	assert.Equal(t, 1, lines)
+}
+
 func TestRunWithDataSource(t *testing.T) {
 	t.Parallel()
 	cur, err := os.Getwd()
@@ -2342,3 +2460,104 @@ func Test_getSampleContent(t *testing.T) {
 	assert.EqualValues(t, 1024, len(getSampleContent(test, 1024)))
 	assert.EqualValues(t, 1039, len(getSampleContent(test, 1039)))
 }
+
+type flakySender struct {
+	name  string
+	fails int
+	datas []Data
+}
+
+func (s *flakySender) Name() string { return s.name }
+
+func (s *flakySender) Send(datas []Data) error {
+	if s.fails > 0 {
+		s.fails--
+		return fmt.Errorf("%v send failed", s.name)
+	}
+	s.datas = append(s.datas, datas...)
+	return nil
+}
+
+func (s *flakySender) Close() error { return nil }
+
+func TestSendWithBarrier(t *testing.T) {
+	quorum, err := getBarrierQuorum(RunnerInfo{}, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, quorum)
+	quorum, err = getBarrierQuorum(RunnerInfo{SendBarrier: SendBarrierAll}, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, quorum)
+	quorum, err = getBarrierQuorum(RunnerInfo{SendBarrier: SendBarrierQuorum, SendQuorumDiscard: true}, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, quorum)
+	// quorum 模式会丢弃未发送成功的 sender 的数据，需要显式开启 send_quorum_discard
+	_, err = getBarrierQuorum(RunnerInfo{SendBarrier: SendBarrierQuorum}, 3)
+	assert.Error(t, err)
+	quorum, err = getBarrierQuorum(RunnerInfo{SendBarrier: SendBarrierQuorum, SendQuorum: 3}, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, quorum)
+	_, err = getBarrierQuorum(RunnerInfo{SendBarrier: SendBarrierQuorum, SendQuorum: 4}, 3)
+	assert.Error(t, err)
+	_, err = getBarrierQuorum(RunnerInfo{SendBarrier: "any"}, 3)
+	assert.Error(t, err)
+	_, err = getBarrierQuorum(RunnerInfo{SendBarrier: SendBarrierAll, SendRaw: true}, 3)
+	assert.Error(t, err)
+
+	s1 := &flakySender{name: "s1"}
+	s2 := &flakySender{name: "s2", fails: 2}
+	r := &LogExportRunner{
+		RunnerInfo:    RunnerInfo{RunnerName: "TestSendWithBarrier", MaxBatchTryTimes: 1, ErrorsListCap: 10},
+		senders:       []sender.Sender{s1, s2},
+		rs:            &RunnerStatus{SenderStats: make(map[string]StatsInfo)},
+		rsMutex:       new(sync.RWMutex),
+		historyMutex:  new(sync.RWMutex),
+		historyError:  NewErrorsList(),
+		barrierQuorum: 2,
+	}
+	datas := []Data{{"a": 1}, {"a": 2}}
+	// s2 失败两次，需要等待 s2 重试成功，s1 不会重复发送
+	assert.True(t, r.sendWithBarrier([][]Data{datas, datas}))
+	assert.Len(t, s1.datas, 2)
+	assert.Len(t, s2.datas, 2)
+	assert.Equal(t, StatsInfo{Success: 2, LastError: ""}, r.rs.SenderStats["s1"])
+	assert.Equal(t, int64(2), r.rs.SenderStats["s2"].Success)
+
+	// 达到 quorum 后不再等待失败的 sender，它的这批数据被丢弃，恢复后只收到之后的数据
+	s2.fails = 1
+	r.barrierQuorum = 1
+	lost := []Data{{"a": 3}, {"a": 4}}
+	failed := r.rs.SenderStats["s2"].Errors
+	assert.True(t, r.sendWithBarrier([][]Data{lost, lost}))
+	assert.Len(t, s1.datas, 4)
+	assert.Equal(t, failed+2, r.rs.SenderStats["s2"].Errors)
+	next := []Data{{"a": 5}}
+	assert.True(t, r.sendWithBarrier([][]Data{next, next}))
+	assert.Equal(t, []Data{{"a": 1}, {"a": 2}, {"a": 3}, {"a": 4}, {"a": 5}}, s1.datas)
+	assert.Equal(t, []Data{{"a": 1}, {"a": 2}, {"a": 5}}, s2.datas)
+
+	// runner 退出时未达到 quorum，不提交 meta
+	s2.fails = 100
+	r.barrierQuorum = 2
+	r.stopped = 1
+	assert.False(t, r.sendWithBarrier([][]Data{datas, datas}))
+}
+
+func TestAddTagsWithConflictPolicy(t *testing.T) {
+	r := &LogExportRunner{
+		RunnerInfo: RunnerInfo{RunnerName: "TestAddTagsWithConflictPolicy"},
+		rs:         &RunnerStatus{},
+		rsMutex:    new(sync.RWMutex),
+	}
+	tags := map[string]interface{}{"host": "h1", "env": "prod"}
+	datas := []Data{{"host": "parsed"}, {"msg": "m"}}
+	r.addTags(tags, datas)
+	assert.Equal(t, []Data{{"host": "parsed", "env": "prod"}, {"msg": "m", "host": "h1", "env": "prod"}}, datas)
+	assert.Equal(t, int64(1), r.rs.TagConflicts)
+
+	r.tagPolicy, _ = NewTagConflictPolicy(TagConflictPrefixTags, "")
+	datas = []Data{{"host": "parsed", "env": "dev"}}
+	r.addTags(tags, datas)
+	assert.Equal(t, []Data{{"host": "parsed", "env": "dev", "tag_host": "h1", "tag_env": "prod"}}, datas)
+	assert.Equal(t, int64(3), r.rs.TagConflicts)
+	assert.Equal(t, int64(3), r.rs.Clone().TagConflicts)
+}
@@ -0,0 +1,294 @@
+package mgr
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qiniu/log"
+
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const (
+	// SchemaDriftNewField 出现了之前没有的字段
+	SchemaDriftNewField = "new_field"
+	// SchemaDriftTypeChanged 字段出现了之前没有的类型
+	SchemaDriftTypeChanged = "type_changed"
+	// SchemaDriftTooManyFields 字段数超过了 max_fields，之后出现的新字段不再记录
+	SchemaDriftTooManyFields = "too_many_fields"
+
+	defaultSchemaLearnBatches = 10
+	defaultSchemaMaxFields    = 1000
+	defaultSchemaWarnDuration = time.Hour
+	// 展开嵌套字段的最大深度，与 elasticsearch 的 object 字段对应
+	maxSchemaFieldDepth = 5
+	// 状态中最多保留的最近变化数
+	maxSchemaRecentDrifts = 20
+)
+
+// SchemaDriftConfig 字段变化告警配置，记录发送前数据中出现过的字段及类型，学习期之后出现新字段或字段类型变化时
+// 在 runner 状态中告警，并可以通过 webhook 通知
+type SchemaDriftConfig struct {
+	// LearnBatches 学习期的批次数，学习期内出现的字段和类型不告警，默认为 10
+	LearnBatches int `json:"learn_batches,omitempty"`
+	// MaxFields 最多记录的字段数，避免动态字段名导致无限增长，默认为 1000
+	MaxFields int `json:"max_fields,omitempty"`
+	// IgnoreFields 不检查的字段，嵌套字段使用 . 连接，如 a.b
+	IgnoreFields []string `json:"ignore_fields,omitempty"`
+	// WarnDuration 最近一次变化后状态中保留告警的时长，默认为 1h
+	WarnDuration string `json:"warn_duration,omitempty"`
+	// WebhookURL 发生变化时以 POST json 的方式通知的地址，为空时不通知
+	WebhookURL string `json:"webhook_url,omitempty"`
+}
+
+// SchemaDrift 一次字段变化
+type SchemaDrift struct {
+	Time     time.Time `json:"time"`
+	Field    string    `json:"field,omitempty"`
+	Kind     string    `json:"kind"`
+	OldTypes []string  `json:"old_types,omitempty"`
+	NewType  string    `json:"new_type,omitempty"`
+}
+
+// SchemaDriftStats 字段变化的统计，Warning 不为空表示 warn_duration 内发生过变化
+type SchemaDriftStats struct {
+	Learning  bool          `json:"learning"`
+	Fields    int           `json:"fields"`
+	Drifts    int64         `json:"drifts"`
+	Recent    []SchemaDrift `json:"recent,omitempty"`
+	Warning   string        `json:"warning,omitempty"`
+	LastError string        `json:"last_error,omitempty"`
+}
+
+func (s SchemaDriftStats) clone() *SchemaDriftStats {
+	dst := s
+	if s.Recent != nil {
+		dst.Recent = append([]SchemaDrift(nil), s.Recent...)
+	}
+	return &dst
+}
+
+// SchemaDriftEvent webhook 通知的内容
+type SchemaDriftEvent struct {
+	Runner   string        `json:"runner"`
+	Hostname string        `json:"hostname,omitempty"`
+	Drifts   []SchemaDrift `json:"drifts"`
+}
+
+// schemaDrift 记录一个 runner 发送的数据中出现过的字段和类型
+type schemaDrift struct {
+	runnerName   string
+	learnBatches int
+	maxFields    int
+	ignore       map[string]bool
+	warnDuration time.Duration
+	hostname     string
+	notifier     *webhookNotifier
+
+	mutex     sync.Mutex
+	batches   int
+	fields    map[string]map[string]bool
+	overflow  bool
+	drifts    int64
+	recent    []SchemaDrift
+	lastDrift time.Time
+	lastError string
+}
+
+func newSchemaDrift(runnerName string, c *SchemaDriftConfig) (*schemaDrift, error) {
+	if c == nil {
+		return nil, nil
+	}
+	s := &schemaDrift{
+		runnerName:   runnerName,
+		learnBatches: c.LearnBatches,
+		maxFields:    c.MaxFields,
+		ignore:       make(map[string]bool, len(c.IgnoreFields)),
+		warnDuration: defaultSchemaWarnDuration,
+		fields:       make(map[string]map[string]bool),
+	}
+	if s.learnBatches <= 0 {
+		s.learnBatches = defaultSchemaLearnBatches
+	}
+	if s.maxFields <= 0 {
+		s.maxFields = defaultSchemaMaxFields
+	}
+	for _, field := range c.IgnoreFields {
+		s.ignore[field] = true
+	}
+	if c.WarnDuration != "" {
+		d, err := time.ParseDuration(c.WarnDuration)
+		if err != nil {
+			return nil, fmt.Errorf("runner %v parse schema_drift warn_duration %q error, %v", runnerName, c.WarnDuration, err)
+		}
+		s.warnDuration = d
+	}
+	if c.WebhookURL != "" {
+		if err := checkWebhookURL(c.WebhookURL); err != nil {
+			return nil, fmt.Errorf("runner %v schema_drift %v", runnerName, err)
+		}
+		s.hostname, _ = os.Hostname()
+		s.notifier = newWebhookNotifier(c.WebhookURL, s.setError)
+	}
+	return s, nil
+}
+
+// Check 记录一批数据中的字段和类型，返回学习期之后新出现的变化
+func (s *schemaDrift) Check(datas []Data) []SchemaDrift {
+	if len(datas) == 0 {
+		return nil
+	}
+	now := time.Now()
+	s.mutex.Lock()
+	s.batches++
+	learning := s.batches <= s.learnBatches
+	var drifts []SchemaDrift
+	for _, data := range datas {
+		drifts = s.walk("", map[string]interface{}(data), 1, learning, now, drifts)
+	}
+	if len(drifts) > 0 {
+		s.drifts += int64(len(drifts))
+		s.lastDrift = now
+		s.recent = append(s.recent, drifts...)
+		if len(s.recent) > maxSchemaRecentDrifts {
+			s.recent = append([]SchemaDrift(nil), s.recent[len(s.recent)-maxSchemaRecentDrifts:]...)
+		}
+	}
+	s.mutex.Unlock()
+
+	if len(drifts) == 0 {
+		return nil
+	}
+	log.Warnf("Runner[%v] schema drift detected: %v", s.runnerName, describeSchemaDrifts(drifts))
+	if s.notifier != nil {
+		s.notifier.notify(SchemaDriftEvent{Runner: s.runnerName, Hostname: s.hostname, Drifts: drifts})
+	}
+	return drifts
+}
+
+func (s *schemaDrift) walk(prefix string, data map[string]interface{}, depth int, learning bool, now time.Time, drifts []SchemaDrift) []SchemaDrift {
+	for k, v := range data {
+		field := k
+		if prefix != "" {
+			field = prefix + "." + k
+		}
+		if s.ignore[field] || v == nil {
+			continue
+		}
+		typ := schemaFieldType(v)
+		if nested, ok := v.(map[string]interface{}); ok && depth < maxSchemaFieldDepth {
+			drifts = s.walk(field, nested, depth+1, learning, now, drifts)
+		} else if nested, ok := v.(Data); ok && depth < maxSchemaFieldDepth {
+			drifts = s.walk(field, map[string]interface{}(nested), depth+1, learning, now, drifts)
+		}
+		types, ok := s.fields[field]
+		if !ok {
+			if len(s.fields) >= s.maxFields {
+				if !s.overflow {
+					s.overflow = true
+					drifts = append(drifts, SchemaDrift{Time: now, Kind: SchemaDriftTooManyFields})
+				}
+				continue
+			}
+			s.fields[field] = map[string]bool{typ: true}
+			if !learning {
+				drifts = append(drifts, SchemaDrift{Time: now, Field: field, Kind: SchemaDriftNewField, NewType: typ})
+			}
+			continue
+		}
+		if types[typ] {
+			continue
+		}
+		if !learning {
+			drifts = append(drifts, SchemaDrift{Time: now, Field: field, Kind: SchemaDriftTypeChanged, OldTypes: sortedTypes(types), NewType: typ})
+		}
+		types[typ] = true
+	}
+	return drifts
+}
+
+// schemaFieldType 返回值对应的类型名称，与 elasticsearch 的动态映射类型保持一致
+func schemaFieldType(v interface{}) string {
+	switch val := v.(type) {
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return "long"
+	case float32, float64:
+		return "float"
+	case json.Number:
+		if strings.ContainsAny(string(val), ".eE") {
+			return "float"
+		}
+		return "long"
+	case time.Time:
+		return "date"
+	case map[string]interface{}, Data:
+		return "object"
+	case []interface{}, []string, []int, []int64, []float64, []map[string]interface{}:
+		return "array"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+func sortedTypes(types map[string]bool) []string {
+	ret := make([]string, 0, len(types))
+	for typ := range types {
+		ret = append(ret, typ)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func describeSchemaDrifts(drifts []SchemaDrift) string {
+	descs := make([]string, 0, len(drifts))
+	for _, d := range drifts {
+		switch d.Kind {
+		case SchemaDriftNewField:
+			descs = append(descs, fmt.Sprintf("new field %v(%v)", d.Field, d.NewType))
+		case SchemaDriftTypeChanged:
+			descs = append(descs, fmt.Sprintf("field %v type %v -> %v", d.Field, strings.Join(d.OldTypes, "|"), d.NewType))
+		default:
+			descs = append(descs, "too many fields, new fields are not tracked any more")
+		}
+	}
+	return strings.Join(descs, ", ")
+}
+
+func (s *schemaDrift) setError(err string) {
+	log.Warnf("Runner[%v] schema drift notify failed, %v", s.runnerName, err)
+	s.mutex.Lock()
+	s.lastError = err
+	s.mutex.Unlock()
+}
+
+func (s *schemaDrift) Stats() *SchemaDriftStats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stats := SchemaDriftStats{
+		Learning:  s.batches <= s.learnBatches,
+		Fields:    len(s.fields),
+		Drifts:    s.drifts,
+		Recent:    s.recent,
+		LastError: s.lastError,
+	}
+	if !s.lastDrift.IsZero() && time.Since(s.lastDrift) < s.warnDuration && len(s.recent) > 0 {
+		stats.Warning = fmt.Sprintf("schema drift at %v: %v", s.lastDrift.Format(time.RFC3339), describeSchemaDrifts(s.recent[len(s.recent)-1:]))
+	}
+	return stats.clone()
+}
+
+// Close 等待队列中的通知发送完毕
+func (s *schemaDrift) Close() {
+	if s.notifier != nil {
+		s.notifier.close()
+	}
+}
@@ -0,0 +1,93 @@
+package mgr
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+func TestSchemaDrift(t *testing.T) {
+	sd, err := newSchemaDrift("test", nil)
+	assert.NoError(t, err)
+	assert.Nil(t, sd)
+	_, err = newSchemaDrift("test", &SchemaDriftConfig{WarnDuration: "abc"})
+	assert.Error(t, err)
+	_, err = newSchemaDrift("test", &SchemaDriftConfig{WebhookURL: "127.0.0.1"})
+	assert.Error(t, err)
+
+	sd, err = newSchemaDrift("test", &SchemaDriftConfig{LearnBatches: 2, IgnoreFields: []string{"msg.raw"}})
+	require.NoError(t, err)
+	defer sd.Close()
+	assert.Empty(t, sd.Check([]Data{{"a": "x", "b": int64(1)}}))
+	// 学习期内出现的字段和类型不告警
+	assert.Empty(t, sd.Check([]Data{{"a": "y", "c": 1.5, "msg": map[string]interface{}{"raw": 1}}}))
+	stats := sd.Stats()
+	assert.True(t, stats.Learning)
+	assert.Equal(t, 4, stats.Fields)
+
+	assert.Empty(t, sd.Check([]Data{{"a": "z", "b": int64(2), "msg": map[string]interface{}{"raw": "ignored"}}}))
+	drifts := sd.Check([]Data{{"a": "z", "b": "2", "d": true, "msg": map[string]interface{}{"level": "info"}}, {"b": "3"}})
+	require.Len(t, drifts, 3)
+	byField := make(map[string]SchemaDrift)
+	for _, d := range drifts {
+		byField[d.Field] = d
+	}
+	assert.Equal(t, SchemaDriftTypeChanged, byField["b"].Kind)
+	assert.Equal(t, []string{"long"}, byField["b"].OldTypes)
+	assert.Equal(t, "string", byField["b"].NewType)
+	assert.Equal(t, SchemaDriftNewField, byField["d"].Kind)
+	assert.Equal(t, "boolean", byField["d"].NewType)
+	assert.Equal(t, SchemaDriftNewField, byField["msg.level"].Kind)
+
+	// 已经告警过的类型不再重复告警
+	assert.Empty(t, sd.Check([]Data{{"b": int64(4)}, {"b": "5"}}))
+
+	stats = sd.Stats()
+	assert.False(t, stats.Learning)
+	assert.Equal(t, int64(3), stats.Drifts)
+	assert.Len(t, stats.Recent, 3)
+	assert.NotEmpty(t, stats.Warning)
+	// 返回的是副本
+	stats.Recent[0].Field = "changed"
+	assert.NotEqual(t, "changed", sd.Stats().Recent[0].Field)
+}
+
+func TestSchemaDriftMaxFields(t *testing.T) {
+	sd, err := newSchemaDrift("test", &SchemaDriftConfig{LearnBatches: 1, MaxFields: 2, WarnDuration: "0s"})
+	require.NoError(t, err)
+	defer sd.Close()
+	sd.Check([]Data{{"a": 1}})
+	drifts := sd.Check([]Data{{"b": 1, "c": 1, "d": 1}})
+	assert.Len(t, drifts, 2)
+	assert.Empty(t, sd.Check([]Data{{"e": 1}}))
+	stats := sd.Stats()
+	assert.Equal(t, 2, stats.Fields)
+	assert.Empty(t, stats.Warning)
+}
+
+func TestSchemaDriftWebhook(t *testing.T) {
+	events := make(chan SchemaDriftEvent, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event SchemaDriftEvent
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&event))
+		events <- event
+	}))
+	defer server.Close()
+
+	sd, err := newSchemaDrift("test", &SchemaDriftConfig{LearnBatches: 1, WebhookURL: server.URL})
+	require.NoError(t, err)
+	sd.Check([]Data{{"a": 1}})
+	sd.Check([]Data{{"a": 1, "b": "x"}})
+	sd.Close()
+	event := <-events
+	assert.Equal(t, "test", event.Runner)
+	require.Len(t, event.Drifts, 1)
+	assert.Equal(t, "b", event.Drifts[0].Field)
+	assert.Empty(t, sd.Stats().LastError)
+}
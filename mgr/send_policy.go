@@ -0,0 +1,252 @@
+package mgr
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/qiniu/log"
+
+	"github.com/qiniu/logkit/sender"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const (
+	defaultAuthPause = time.Minute
+	deadLetterSuffix = ".deadletter"
+)
+
+var deadLetterNameReplacer = regexp.MustCompile(`[^\w.-]+`)
+
+// SendErrorPolicyConfig 按照发送错误的分类处理失败的数据：可以重试的错误继续重试；
+// 被服务端拒绝的数据重试也不会成功，写入死信文件后不再重试；认证失败时暂停发送 auth_pause 后再重试，并告警。
+// 没有配置时被拒绝的数据与其他错误一样按原有的策略重试，不会被丢弃
+type SendErrorPolicyConfig struct {
+	// DeadLetterDir 被拒绝的数据写入的目录，每个 sender 一个文件，为空时丢弃被拒绝的数据
+	DeadLetterDir string `json:"dead_letter_dir,omitempty"`
+	// DeadLetterMaxSize 每个死信文件的最大字节数，超过后丢弃之后被拒绝的数据，默认为 100MB
+	DeadLetterMaxSize int64 `json:"dead_letter_max_size,omitempty"`
+	// AuthPause 认证失败后暂停发送的时长，默认为 1m
+	AuthPause string `json:"auth_pause,omitempty"`
+	// WebhookURL 数据被拒绝或认证失败时以 POST json 的方式通知的地址，为空时不通知
+	WebhookURL string `json:"webhook_url,omitempty"`
+}
+
+// SendErrorStats 按分类处理发送错误的统计，没有发生过被拒绝或认证失败的错误时为空
+type SendErrorStats struct {
+	Rejected     int64  `json:"rejected"`
+	DeadLetters  int64  `json:"dead_letters"`
+	AuthFailures int64  `json:"auth_failures"`
+	PausedUntil  string `json:"paused_until,omitempty"`
+	LastError    string `json:"last_error,omitempty"`
+	NotifyError  string `json:"notify_error,omitempty"`
+}
+
+func (s SendErrorStats) clone() *SendErrorStats {
+	dst := s
+	return &dst
+}
+
+// SendErrorEvent webhook 通知的内容
+type SendErrorEvent struct {
+	Runner   string `json:"runner"`
+	Hostname string `json:"hostname,omitempty"`
+	Sender   string `json:"sender"`
+	Class    string `json:"class"`
+	Count    int    `json:"count,omitempty"`
+	Error    string `json:"error"`
+}
+
+// sendPolicy 一个 runner 中所有 sender 共用的发送错误处理策略
+type sendPolicy struct {
+	runnerName  string
+	authPause   time.Duration
+	deadLetters map[string]*sender.DeadLetter
+	hostname    string
+	notifier    *webhookNotifier
+	// rejects 显式配置了 send_error_policy 时被拒绝的数据才不再重试
+	rejects bool
+
+	mutex       sync.Mutex
+	stats       SendErrorStats
+	pausedUntil time.Time
+}
+
+// newSendPolicy 创建发送错误处理策略，c 为空时使用默认配置：被拒绝的数据继续重试，认证失败暂停 1m
+func newSendPolicy(runnerName string, c *SendErrorPolicyConfig, senders []sender.Sender) (*sendPolicy, error) {
+	p := &sendPolicy{
+		runnerName:  runnerName,
+		authPause:   defaultAuthPause,
+		deadLetters: make(map[string]*sender.DeadLetter),
+	}
+	if c == nil {
+		return p, nil
+	}
+	p.rejects = true
+	if c.AuthPause != "" {
+		d, err := time.ParseDuration(c.AuthPause)
+		if err != nil {
+			return nil, fmt.Errorf("runner %v parse send_error_policy auth_pause %q error, %v", runnerName, c.AuthPause, err)
+		}
+		if d < time.Second {
+			return nil, fmt.Errorf("runner %v send_error_policy auth_pause %v is less than 1s", runnerName, d)
+		}
+		p.authPause = d
+	}
+	if c.WebhookURL != "" {
+		if err := checkWebhookURL(c.WebhookURL); err != nil {
+			return nil, fmt.Errorf("runner %v send_error_policy %v", runnerName, err)
+		}
+	}
+	for _, s := range senders {
+		var dl *sender.DeadLetter
+		if c.DeadLetterDir != "" {
+			name := deadLetterNameReplacer.ReplaceAllString(s.Name(), "_")
+			var err error
+			dl, err = sender.NewDeadLetter(filepath.Join(c.DeadLetterDir, runnerName, name+deadLetterSuffix), c.DeadLetterMaxSize)
+			if err != nil {
+				p.Close()
+				return nil, fmt.Errorf("runner %v create dead letter for sender %v error, %v", runnerName, s.Name(), err)
+			}
+			p.deadLetters[s.Name()] = dl
+		}
+		// 容错 sender 在后台重试，需要自己处理被拒绝的数据
+		if ds, ok := s.(sender.DeadLetterSender); ok {
+			ds.SetDeadLetter(dl)
+		}
+	}
+	if c.WebhookURL != "" {
+		p.hostname, _ = os.Hostname()
+		p.notifier = newWebhookNotifier(c.WebhookURL, p.setNotifyError)
+	}
+	return p, nil
+}
+
+// rejectEnabled 返回被服务端拒绝的数据是否交给 reject 处理，没有配置 send_error_policy 时与之前一样继续重试
+func (p *sendPolicy) rejectEnabled() bool {
+	return p != nil && p.rejects
+}
+
+// reject 处理被服务端拒绝的数据，不再重试，p 为空时直接丢弃
+func (p *sendPolicy) reject(senderName string, err error, datas []Data, lines []string) {
+	total := len(datas) + len(lines)
+	if p == nil {
+		log.Errorf("Sender[%v] %d datas are rejected and discarded: %v", senderName, total, err)
+		return
+	}
+	var written int
+	if dl, ok := p.deadLetters[senderName]; ok {
+		var werr error
+		if lines != nil {
+			written, werr = dl.WriteRaw(senderName, err, lines)
+		} else {
+			written, werr = dl.Write(senderName, err, datas)
+		}
+		if werr != nil {
+			log.Errorf("Runner[%v] Sender[%v] write rejected datas to dead letter %v error %v, %d datas are discarded", p.runnerName, senderName, dl.Path(), werr, total-written)
+		} else {
+			log.Warnf("Runner[%v] Sender[%v] %d datas are rejected and written to dead letter %v: %v", p.runnerName, senderName, written, dl.Path(), err)
+		}
+	} else {
+		log.Errorf("Runner[%v] Sender[%v] %d datas are rejected and discarded: %v", p.runnerName, senderName, total, err)
+	}
+
+	p.mutex.Lock()
+	p.stats.Rejected += int64(total)
+	p.stats.LastError = TruncateStrSize(err.Error(), DefaultTruncateMaxSize)
+	p.mutex.Unlock()
+	p.notify(senderName, sender.ErrorClassRejected, total, err)
+}
+
+// authFailed 记录认证失败，返回重试之前需要暂停的时长，只在开始暂停时通知一次
+func (p *sendPolicy) authFailed(senderName string, err error) time.Duration {
+	if p == nil {
+		log.Errorf("Sender[%v] auth failed, pause sending for %v: %v", senderName, defaultAuthPause, err)
+		return defaultAuthPause
+	}
+	now := time.Now()
+	p.mutex.Lock()
+	first := p.pausedUntil.IsZero()
+	p.pausedUntil = now.Add(p.authPause)
+	p.stats.AuthFailures++
+	p.stats.PausedUntil = p.pausedUntil.Format(time.RFC3339)
+	p.stats.LastError = TruncateStrSize(err.Error(), DefaultTruncateMaxSize)
+	p.mutex.Unlock()
+
+	log.Errorf("Runner[%v] Sender[%v] auth failed, pause sending for %v: %v", p.runnerName, senderName, p.authPause, err)
+	if first {
+		p.notify(senderName, sender.ErrorClassAuth, 0, err)
+	}
+	return p.authPause
+}
+
+// resume 发送成功后结束暂停状态
+func (p *sendPolicy) resume() {
+	if p == nil {
+		return
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.pausedUntil.IsZero() {
+		return
+	}
+	p.pausedUntil = time.Time{}
+	p.stats.PausedUntil = ""
+	log.Infof("Runner[%v] sending is resumed after auth failure", p.runnerName)
+}
+
+func (p *sendPolicy) notify(senderName string, class sender.ErrorClass, count int, err error) {
+	if p.notifier == nil {
+		return
+	}
+	p.notifier.notify(SendErrorEvent{
+		Runner:   p.runnerName,
+		Hostname: p.hostname,
+		Sender:   senderName,
+		Class:    string(class),
+		Count:    count,
+		Error:    TruncateStrSize(err.Error(), DefaultTruncateMaxSize),
+	})
+}
+
+func (p *sendPolicy) setNotifyError(err string) {
+	log.Warnf("Runner[%v] send error notify failed, %v", p.runnerName, err)
+	p.mutex.Lock()
+	p.stats.NotifyError = err
+	p.mutex.Unlock()
+}
+
+// Stats 没有发生过被拒绝或认证失败的错误时返回 nil，死信数包含容错 sender 写入的部分
+func (p *sendPolicy) Stats() *SendErrorStats {
+	if p == nil {
+		return nil
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	stats := p.stats
+	for _, dl := range p.deadLetters {
+		written, _ := dl.Stats()
+		stats.DeadLetters += written
+	}
+	if stats.Rejected == 0 && stats.AuthFailures == 0 && stats.DeadLetters == 0 {
+		return nil
+	}
+	return stats.clone()
+}
+
+func (p *sendPolicy) Close() {
+	if p == nil {
+		return
+	}
+	if p.notifier != nil {
+		p.notifier.close()
+	}
+	for name, dl := range p.deadLetters {
+		if err := dl.Close(); err != nil {
+			log.Errorf("Runner[%v] close dead letter of sender %v error %v", p.runnerName, name, err)
+		}
+	}
+}
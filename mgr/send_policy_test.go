@@ -0,0 +1,146 @@
+package mgr
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/qiniu/logkit/sender"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+func TestSendPolicy(t *testing.T) {
+	_, err := newSendPolicy("test", &SendErrorPolicyConfig{AuthPause: "abc"}, nil)
+	assert.Error(t, err)
+	_, err = newSendPolicy("test", &SendErrorPolicyConfig{AuthPause: "10ms"}, nil)
+	assert.Error(t, err)
+	_, err = newSendPolicy("test", &SendErrorPolicyConfig{WebhookURL: "localhost:80"}, nil)
+	assert.Error(t, err)
+
+	dir, err := ioutil.TempDir("", "TestSendPolicy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s := &flakySender{name: "pandora:a/b"}
+	p, err := newSendPolicy("test", &SendErrorPolicyConfig{DeadLetterDir: dir, AuthPause: "2s"}, []sender.Sender{s})
+	require.NoError(t, err)
+	assert.Nil(t, p.Stats())
+
+	p.reject(s.Name(), &sender.HTTPStatusError{StatusCode: 400, Body: "invalid field"}, []Data{{"a": 1}, {"a": 2}}, nil)
+	stats := p.Stats()
+	require.NotNil(t, stats)
+	assert.Equal(t, int64(2), stats.Rejected)
+	assert.Equal(t, int64(2), stats.DeadLetters)
+	assert.Equal(t, "invalid field", stats.LastError)
+
+	assert.Equal(t, 2*time.Second, p.authFailed(s.Name(), errors.New("unauthorized")))
+	stats = p.Stats()
+	assert.Equal(t, int64(1), stats.AuthFailures)
+	assert.NotEmpty(t, stats.PausedUntil)
+	p.resume()
+	assert.Empty(t, p.Stats().PausedUntil)
+	p.Close()
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, "test", "pandora_a_b.deadletter"))
+	require.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(content), "\n"))
+
+	assert.True(t, p.rejectEnabled())
+
+	// 没有配置时被拒绝的数据继续重试
+	var nilPolicy *sendPolicy
+	assert.False(t, nilPolicy.rejectEnabled())
+	nilPolicy.reject("s", errors.New("rejected"), []Data{{"a": 1}}, nil)
+	nilPolicy.resume()
+	assert.Nil(t, nilPolicy.Stats())
+}
+
+func TestSendPolicyWebhook(t *testing.T) {
+	events := make(chan SendErrorEvent, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event SendErrorEvent
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&event))
+		events <- event
+	}))
+	defer server.Close()
+
+	p, err := newSendPolicy("test", &SendErrorPolicyConfig{WebhookURL: server.URL}, nil)
+	require.NoError(t, err)
+	p.reject("s", errors.New("rejected"), nil, []string{"a", "b"})
+	// 认证失败只在开始暂停时通知一次
+	p.authFailed("s", errors.New("unauthorized"))
+	p.authFailed("s", errors.New("unauthorized"))
+	p.Close()
+	close(events)
+
+	var got []SendErrorEvent
+	for event := range events {
+		got = append(got, event)
+	}
+	require.Len(t, got, 2)
+	assert.Equal(t, string(sender.ErrorClassRejected), got[0].Class)
+	assert.Equal(t, 2, got[0].Count)
+	assert.Equal(t, string(sender.ErrorClassAuth), got[1].Class)
+	assert.Empty(t, p.Stats().NotifyError)
+}
+
+type rejectSender struct {
+	sends int
+}
+
+func (s *rejectSender) Name() string { return "reject" }
+
+func (s *rejectSender) Send(datas []Data) error {
+	s.sends++
+	return &sender.HTTPStatusError{StatusCode: http.StatusBadRequest, Body: "invalid data"}
+}
+
+func (s *rejectSender) Close() error { return nil }
+
+func newRejectRunner(name string, c *SendErrorPolicyConfig) *LogExportRunner {
+	r := &LogExportRunner{
+		RunnerInfo:   RunnerInfo{RunnerName: name, ErrorsListCap: 10},
+		rs:           &RunnerStatus{SenderStats: make(map[string]StatsInfo)},
+		rsMutex:      new(sync.RWMutex),
+		historyMutex: new(sync.RWMutex),
+		historyError: NewErrorsList(),
+	}
+	r.sendPolicy, _ = newSendPolicy(r.RunnerName, c, nil)
+	return r
+}
+
+func TestTrySendRejected(t *testing.T) {
+	s := &rejectSender{}
+	r := newRejectRunner("TestTrySendRejected", &SendErrorPolicyConfig{})
+	// 配置了 send_error_policy 时被拒绝的数据不再重试，即使设置了无限重试
+	sent, remain := r.trySendRemain(s, []Data{{"a": 1}, {"a": 2}}, 0)
+	assert.True(t, sent)
+	assert.Empty(t, remain)
+	assert.Equal(t, 1, s.sends)
+	assert.Equal(t, int64(2), r.rs.SenderStats["reject"].Errors)
+	assert.Equal(t, int64(2), r.sendPolicy.Stats().Rejected)
+}
+
+// 没有配置 send_error_policy 时被拒绝的数据与之前一样按 MaxBatchTryTimes 重试，不会被丢弃
+func TestTrySendRejectedDefault(t *testing.T) {
+	s := &rejectSender{}
+	r := newRejectRunner("TestTrySendRejectedDefault", nil)
+	datas := []Data{{"a": 1}, {"a": 2}}
+	sent, remain := r.trySendRemain(s, datas, 2)
+	assert.True(t, sent)
+	assert.Equal(t, datas, remain)
+	assert.Equal(t, 2, s.sends)
+	assert.Equal(t, int64(2), r.rs.SenderStats["reject"].Errors)
+	assert.Nil(t, r.sendPolicy.Stats())
+}
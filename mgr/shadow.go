@@ -0,0 +1,349 @@
+package mgr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/qiniu/log"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/parser"
+	"github.com/qiniu/logkit/transforms"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const (
+	defaultShadowQueueSize = 16
+	// 差异统计中最多记录的字段数，避免动态字段名导致统计无限增长
+	maxShadowDiffFields = 100
+)
+
+// ShadowConfig 影子流水线配置，按比例抽取原始数据的批次，使用候选的 parser 和 transforms 处理后与正式流水线的结果比较，
+// 处理结果不发送，用于修改配置前使用真实流量评估新配置
+type ShadowConfig struct {
+	// Percent 抽取的批次比例，取值 (0, 100]
+	Percent float64 `json:"percent"`
+	// ParserConf 候选 parser 的配置，为空时与正式流水线相同
+	ParserConf conf.MapConf `json:"parser,omitempty"`
+	// Transforms 候选 transforms 的配置
+	Transforms []map[string]interface{} `json:"transforms,omitempty"`
+	// QueueSize 等待处理的批次数，队列满时丢弃，避免拖慢主流程
+	QueueSize int `json:"queue_size,omitempty"`
+}
+
+// FieldDiff 一个字段在影子流水线与正式流水线结果中的差异次数
+type FieldDiff struct {
+	Added   int64 `json:"added"`   // 只出现在影子流水线的结果中
+	Removed int64 `json:"removed"` // 只出现在正式流水线的结果中
+	Changed int64 `json:"changed"` // 两边都有但值不同
+}
+
+// ShadowStats 影子流水线的差异统计，只有两边结果条数相同的批次才按顺序逐条比较字段
+type ShadowStats struct {
+	Batches          int64                `json:"batches"`
+	DroppedBatches   int64                `json:"dropped_batches"`
+	UnalignedBatches int64                `json:"unaligned_batches"`
+	ParseSuccess     int64                `json:"parse_success"`
+	ParseErrors      int64                `json:"parse_errors"`
+	TransformErrors  int64                `json:"transform_errors"`
+	PrimaryRecords   int64                `json:"primary_records"`
+	ShadowRecords    int64                `json:"shadow_records"`
+	MatchedRecords   int64                `json:"matched_records"`
+	FieldDiffs       map[string]FieldDiff `json:"field_diffs,omitempty"`
+	LastError        string               `json:"last_error,omitempty"`
+}
+
+func (s ShadowStats) clone() *ShadowStats {
+	dst := s
+	if s.FieldDiffs != nil {
+		dst.FieldDiffs = make(map[string]FieldDiff, len(s.FieldDiffs))
+		for k, v := range s.FieldDiffs {
+			dst.FieldDiffs[k] = v
+		}
+	}
+	return &dst
+}
+
+// shadowBatch 被抽取的一批原始数据，以及正式流水线添加到数据中的附加信息
+type shadowBatch struct {
+	lines []string
+	froms []string
+
+	tags          map[string]interface{}
+	dataSourceTag string
+	encodeTag     string
+	encoding      string
+	// tagPolicy 与正式流水线相同的标签冲突处理策略
+	tagPolicy TagConflictPolicy
+
+	// expected 正式流水线的处理结果，每个字段的值为 json 编码之后的字符串
+	expected []map[string]string
+}
+
+// setExtraInfo 记录正式流水线添加到数据中的标签、数据来源以及编码，tags 会被复制
+func (b *shadowBatch) setExtraInfo(tags map[string]interface{}, dataSourceTag, encodeTag, encoding string) {
+	b.tags = make(map[string]interface{}, len(tags))
+	for k, v := range tags {
+		b.tags[k] = v
+	}
+	b.dataSourceTag, b.encodeTag, b.encoding = dataSourceTag, encodeTag, encoding
+}
+
+// shadow 影子流水线，数据在独立的协程中处理，不影响正式流水线
+type shadow struct {
+	runnerName   string
+	percent      float64
+	parser       parser.Parser
+	transformers []transforms.Transformer
+	random       *rand.Rand
+
+	batchChan chan *shadowBatch
+	wg        sync.WaitGroup
+	chanMutex sync.Mutex
+	closed    bool
+
+	statsMutex sync.RWMutex
+	stats      ShadowStats
+}
+
+func newShadow(runnerName string, c *ShadowConfig, parserConf conf.MapConf, pr *parser.Registry) (*shadow, error) {
+	if c == nil {
+		return nil, nil
+	}
+	if c.Percent <= 0 || c.Percent > 100 {
+		return nil, fmt.Errorf("shadow percent %v should be in (0, 100]", c.Percent)
+	}
+	if len(c.ParserConf) > 0 {
+		parserConf = c.ParserConf
+	}
+	shadowParserConf := conf.MapConf{}
+	for k, v := range parserConf {
+		shadowParserConf[k] = v
+	}
+	shadowParserConf[KeyRunnerName] = runnerName
+	ps, err := pr.NewLogParser(shadowParserConf)
+	if err != nil {
+		return nil, fmt.Errorf("runner %v create shadow parser error, %v", runnerName, err)
+	}
+	transformers, err := createTransformers(RunnerConfig{Transforms: c.Transforms})
+	if err != nil {
+		return nil, fmt.Errorf("runner %v create shadow transformers error, %v", runnerName, err)
+	}
+	return startShadow(runnerName, c.Percent, c.QueueSize, ps, transformers), nil
+}
+
+func startShadow(runnerName string, percent float64, queueSize int, ps parser.Parser, transformers []transforms.Transformer) *shadow {
+	if queueSize <= 0 {
+		queueSize = defaultShadowQueueSize
+	}
+	s := &shadow{
+		runnerName:   runnerName,
+		percent:      percent,
+		parser:       ps,
+		transformers: transformers,
+		random:       rand.New(rand.NewSource(time.Now().UnixNano())),
+		batchChan:    make(chan *shadowBatch, queueSize),
+	}
+	s.wg.Add(1)
+	go s.run()
+	return s
+}
+
+// Sample 按比例抽取一批原始数据，需要在正式流水线的 parser 前的 transforms 之前调用，未抽中时返回 nil
+func (s *shadow) Sample(lines, froms []string) *shadowBatch {
+	if len(lines) == 0 || (s.percent < 100 && s.random.Float64()*100 >= s.percent) {
+		return nil
+	}
+	return &shadowBatch{
+		lines: append([]string(nil), lines...),
+		froms: append([]string(nil), froms...),
+	}
+}
+
+// Feed 记录正式流水线的处理结果后放入处理队列，需要在 sender 发送之前调用，防止数据被 sender 修改，队列满时直接丢弃
+func (s *shadow) Feed(b *shadowBatch, datas []Data) {
+	b.expected = make([]map[string]string, 0, len(datas))
+	for _, d := range datas {
+		b.expected = append(b.expected, flattenData(d))
+	}
+	s.chanMutex.Lock()
+	defer s.chanMutex.Unlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.batchChan <- b:
+	default:
+		s.statsMutex.Lock()
+		s.stats.DroppedBatches++
+		s.statsMutex.Unlock()
+	}
+}
+
+// flattenData 将每个字段的值编码为 json 字符串，便于比较不同类型的值
+func flattenData(d Data) map[string]string {
+	flat := make(map[string]string, len(d))
+	for k, v := range d {
+		bts, err := json.Marshal(v)
+		if err != nil {
+			flat[k] = fmt.Sprint(v)
+			continue
+		}
+		flat[k] = string(bts)
+	}
+	return flat
+}
+
+func (s *shadow) run() {
+	defer s.wg.Done()
+	for b := range s.batchChan {
+		s.process(b)
+	}
+}
+
+// process 使用候选的 parser 和 transforms 处理一批数据，流程与正式流水线相同
+func (s *shadow) process(b *shadowBatch) {
+	var (
+		lastErr              error
+		transformErrs        int64
+		parseSuccess, errNum int64
+	)
+	lines := b.lines
+	for _, t := range s.transformers {
+		if t.Stage() != transforms.StageBeforeParser {
+			continue
+		}
+		var err error
+		if lines, err = t.RawTransform(lines); err != nil {
+			transformErrs++
+			lastErr = err
+		}
+	}
+
+	datas, err := s.parser.Parse(lines)
+	se, ok := err.(*StatsError)
+	switch {
+	case ok:
+		parseSuccess, errNum = se.Success, se.Errors
+		if se.Errors > 0 || se.LastError != "" {
+			lastErr = errors.New(se.LastError)
+		}
+	case err != nil:
+		errNum = 1
+		lastErr = err
+	default:
+		parseSuccess = int64(len(lines))
+	}
+
+	if len(datas) > 0 {
+		if len(b.tags) > 0 {
+			b.tagPolicy.AddTags(b.tags, datas)
+		}
+		if b.dataSourceTag != "" && len(datas) <= len(b.froms) {
+			datas = addSourceToData(b.froms, se, datas, b.dataSourceTag, s.runnerName)
+		}
+		if b.encodeTag != "" {
+			addEncodeToData(datas, b.encodeTag, b.encoding, s.runnerName)
+		}
+		for _, t := range s.transformers {
+			if t.Stage() != transforms.StageAfterParser {
+				continue
+			}
+			if datas, err = t.Transform(datas); err != nil {
+				transformErrs++
+				lastErr = err
+			}
+		}
+	}
+
+	s.statsMutex.Lock()
+	defer s.statsMutex.Unlock()
+	s.stats.Batches++
+	s.stats.ParseSuccess += parseSuccess
+	s.stats.ParseErrors += errNum
+	s.stats.TransformErrors += transformErrs
+	if lastErr != nil {
+		s.stats.LastError = TruncateStrSize(lastErr.Error(), DefaultTruncateMaxSize)
+	}
+	s.compare(b.expected, datas)
+}
+
+// compare 比较两边的结果，需要持有 statsMutex
+func (s *shadow) compare(expected []map[string]string, datas []Data) {
+	s.stats.PrimaryRecords += int64(len(expected))
+	s.stats.ShadowRecords += int64(len(datas))
+	if len(expected) != len(datas) {
+		s.stats.UnalignedBatches++
+		return
+	}
+	if s.stats.FieldDiffs == nil {
+		s.stats.FieldDiffs = make(map[string]FieldDiff)
+	}
+	for i := range datas {
+		actual := flattenData(datas[i])
+		same := true
+		for k, v := range expected[i] {
+			av, ok := actual[k]
+			switch {
+			case !ok:
+				s.addFieldDiff(k, FieldDiff{Removed: 1})
+			case av != v:
+				s.addFieldDiff(k, FieldDiff{Changed: 1})
+			default:
+				continue
+			}
+			same = false
+		}
+		for k := range actual {
+			if _, ok := expected[i][k]; !ok {
+				s.addFieldDiff(k, FieldDiff{Added: 1})
+				same = false
+			}
+		}
+		if same {
+			s.stats.MatchedRecords++
+		}
+	}
+}
+
+func (s *shadow) addFieldDiff(key string, diff FieldDiff) {
+	old, ok := s.stats.FieldDiffs[key]
+	if !ok && len(s.stats.FieldDiffs) >= maxShadowDiffFields {
+		return
+	}
+	old.Added += diff.Added
+	old.Removed += diff.Removed
+	old.Changed += diff.Changed
+	s.stats.FieldDiffs[key] = old
+}
+
+func (s *shadow) Stats() *ShadowStats {
+	s.statsMutex.RLock()
+	defer s.statsMutex.RUnlock()
+	return s.stats.clone()
+}
+
+// Close 等待队列中的数据处理完毕后关闭候选 transforms
+func (s *shadow) Close() {
+	s.chanMutex.Lock()
+	if s.closed {
+		s.chanMutex.Unlock()
+		return
+	}
+	s.closed = true
+	close(s.batchChan)
+	s.chanMutex.Unlock()
+	s.wg.Wait()
+	for _, t := range s.transformers {
+		if c, ok := t.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				log.Warnf("Runner[%v] close shadow transform failed, %v", s.runnerName, err)
+			}
+		}
+	}
+}
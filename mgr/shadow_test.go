@@ -0,0 +1,86 @@
+package mgr
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/parser"
+	parserconfig "github.com/qiniu/logkit/parser/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+func TestShadow(t *testing.T) {
+	pr := parser.NewRegistry()
+	parserConf := conf.MapConf{parserconfig.KeyParserType: parserconfig.TypeRaw}
+	_, err := newShadow("test", &ShadowConfig{Percent: 0}, parserConf, pr)
+	assert.Error(t, err)
+	_, err = newShadow("test", &ShadowConfig{Percent: 100, Transforms: []map[string]interface{}{{"type": "not_exist"}}}, parserConf, pr)
+	assert.Error(t, err)
+	sh, err := newShadow("test", nil, parserConf, pr)
+	assert.NoError(t, err)
+	assert.Nil(t, sh)
+
+	sh, err = newShadow("test", &ShadowConfig{
+		Percent: 100,
+		ParserConf: conf.MapConf{
+			parserconfig.KeyParserType:  parserconfig.TypeCSV,
+			parserconfig.KeyCSVSchema:   "a string, b long",
+			parserconfig.KeyCSVSplitter: ",",
+		},
+		Transforms: []map[string]interface{}{
+			{"type": "replace", "stage": "after_parser", "key": "a", "old": "x", "new": "y"},
+		},
+	}, parserConf, pr)
+	assert.NoError(t, err)
+
+	// 两边结果条数相同时逐条比较字段
+	lines := []string{"x1,1", "z2,2"}
+	b := sh.Sample(lines, []string{"f1", "f2"})
+	assert.NotNil(t, b)
+	lines[0] = "modified by primary"
+	b.setExtraInfo(map[string]interface{}{"tag": "t"}, "source", "", "")
+	sh.Feed(b, []Data{
+		{"a": "x1", "b": 1, "tag": "t", "source": "f1", "c": "only primary"},
+		{"a": "z2", "b": int64(2), "tag": "t", "source": "f2"},
+	})
+
+	// 条数不同时只统计条数
+	b = sh.Sample([]string{"x3,3", "bad"}, nil)
+	sh.Feed(b, []Data{{"raw": "x3,3\nbad"}})
+	sh.Close()
+
+	stats := sh.Stats()
+	assert.Equal(t, int64(2), stats.Batches)
+	assert.Equal(t, int64(1), stats.UnalignedBatches)
+	assert.Equal(t, int64(3), stats.ParseSuccess)
+	assert.Equal(t, int64(1), stats.ParseErrors)
+	// 无法解析的数据被保留在 pandora_stash 中，没有字段 a
+	assert.Equal(t, int64(1), stats.TransformErrors)
+	assert.Equal(t, int64(3), stats.PrimaryRecords)
+	assert.Equal(t, int64(4), stats.ShadowRecords)
+	assert.Equal(t, int64(1), stats.MatchedRecords)
+	assert.Equal(t, map[string]FieldDiff{
+		"a": {Changed: 1},
+		"c": {Removed: 1},
+	}, stats.FieldDiffs)
+	assert.NotEmpty(t, stats.LastError)
+
+	// 关闭后的数据直接忽略
+	sh.Feed(&shadowBatch{lines: []string{"x4,4"}}, nil)
+	assert.Equal(t, int64(2), sh.Stats().Batches)
+}
+
+func TestShadowSample(t *testing.T) {
+	sh := startShadow("test", 10, 0, nil, nil)
+	defer sh.Close()
+	var sampled int
+	for i := 0; i < 10000; i++ {
+		if sh.Sample([]string{"a"}, nil) != nil {
+			sampled++
+		}
+	}
+	assert.InDelta(t, 1000, sampled, 200)
+	assert.Nil(t, sh.Sample(nil, nil))
+}
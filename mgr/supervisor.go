@@ -0,0 +1,323 @@
+package mgr
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/qiniu/log"
+)
+
+const (
+	defaultRestartMaxRetries     = 5
+	defaultRestartInitialBackoff = time.Second
+	defaultRestartMaxBackoff     = 5 * time.Minute
+	defaultRestartResetAfter     = 10 * time.Minute
+
+	// maxRestartHistory 每个 runner 保留的重启记录条数
+	maxRestartHistory = 20
+)
+
+// RestartPolicy runner 在 Run 中 panic 后的自动重启策略，等待时间从 initial_backoff 开始每次翻倍，最多不超过 max_backoff
+// reader 通过 Meta.Go 启动的后台 goroutine(tailx、dirx、socket、http、kafka、syslog、statsd)panic 时同样会触发重启，
+// 其他 reader 以及 sender、transformer 自行启动的 goroutine 中的 panic 仍然会导致进程退出
+type RestartPolicy struct {
+	MaxRetries     int    `json:"max_retries"`               // 连续重启的最大次数，超过后 runner 被禁用，小于 0 表示不限制，0 表示使用默认值
+	InitialBackoff string `json:"initial_backoff,omitempty"` // 第一次重启前的等待时间
+	MaxBackoff     string `json:"max_backoff,omitempty"`     // 最大等待时间
+	ResetAfter     string `json:"reset_after,omitempty"`     // runner 稳定运行超过该时间后重新计算连续重启次数
+}
+
+type restartPolicy struct {
+	maxRetries     int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	resetAfter     time.Duration
+}
+
+func parseDurationOr(str string, def time.Duration) (time.Duration, error) {
+	if str == "" {
+		return def, nil
+	}
+	dur, err := time.ParseDuration(str)
+	if err != nil {
+		return 0, err
+	}
+	if dur <= 0 {
+		return 0, fmt.Errorf("duration %v must be positive", str)
+	}
+	return dur, nil
+}
+
+// parseRestartPolicy 解析重启策略，policy 为 nil 时使用默认策略
+func parseRestartPolicy(policy *RestartPolicy) (p restartPolicy, err error) {
+	p = restartPolicy{
+		maxRetries:     defaultRestartMaxRetries,
+		initialBackoff: defaultRestartInitialBackoff,
+		maxBackoff:     defaultRestartMaxBackoff,
+		resetAfter:     defaultRestartResetAfter,
+	}
+	if policy == nil {
+		return p, nil
+	}
+	if policy.MaxRetries != 0 {
+		p.maxRetries = policy.MaxRetries
+	}
+	if p.initialBackoff, err = parseDurationOr(policy.InitialBackoff, defaultRestartInitialBackoff); err != nil {
+		return p, fmt.Errorf("restart_policy initial_backoff invalid: %v", err)
+	}
+	if p.maxBackoff, err = parseDurationOr(policy.MaxBackoff, defaultRestartMaxBackoff); err != nil {
+		return p, fmt.Errorf("restart_policy max_backoff invalid: %v", err)
+	}
+	if p.resetAfter, err = parseDurationOr(policy.ResetAfter, defaultRestartResetAfter); err != nil {
+		return p, fmt.Errorf("restart_policy reset_after invalid: %v", err)
+	}
+	if p.maxBackoff < p.initialBackoff {
+		p.maxBackoff = p.initialBackoff
+	}
+	return p, nil
+}
+
+// backoff 返回第 attempt 次重启前的等待时间，attempt 从 1 开始
+func (p restartPolicy) backoff(attempt int) time.Duration {
+	backoff := p.initialBackoff
+	for i := 1; i < attempt && backoff < p.maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > p.maxBackoff {
+		backoff = p.maxBackoff
+	}
+	return backoff
+}
+
+// RestartRecord 记录一次 runner 异常退出以及之后的处理
+type RestartRecord struct {
+	Time     time.Time `json:"time"`
+	Reason   string    `json:"reason"`
+	Attempt  int       `json:"attempt"`
+	Backoff  string    `json:"backoff,omitempty"`
+	Disabled bool      `json:"disabled,omitempty"`
+}
+
+// supervision 记录一个 runner 的重启状态，在 runner 重启之间保留
+type supervision struct {
+	mutex      sync.Mutex
+	attempts   int
+	restarting bool
+	disabled   bool
+	history    []RestartRecord
+}
+
+func (s *supervision) record(record RestartRecord) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.history = append(s.history, record)
+	if len(s.history) > maxRestartHistory {
+		s.history = s.history[len(s.history)-maxRestartHistory:]
+	}
+	s.restarting = !record.Disabled
+	s.disabled = record.Disabled
+}
+
+// reset 由用户重新启动 runner 时调用，保留重启记录
+func (s *supervision) reset() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.attempts = 0
+	s.restarting = false
+	s.disabled = false
+}
+
+// apply 将重启记录以及重启中、已禁用的状态写入 runner 状态
+func (s *supervision) apply(rs *RunnerStatus) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(s.history) > 0 {
+		rs.Restarts = append([]RestartRecord(nil), s.history...)
+	}
+	if rs.RunningStatus == RunnerStopped {
+		if s.disabled {
+			rs.RunningStatus = RunnerDisabled
+		} else if s.restarting {
+			rs.RunningStatus = RunnerRestarting
+		}
+	}
+}
+
+// runWithRecover 运行 runner，返回 panic 的原因，正常退出时返回空
+func runWithRecover(runner Runner) (reason string) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			reason = fmt.Sprintf("panic: %v", rec)
+			log.Errorf("Runner[%v] %s\nstack: %s", runner.Name(), reason, debug.Stack())
+		}
+	}()
+	runner.Run()
+	return ""
+}
+
+// reportReaderPanic 记录 reader 后台 goroutine 的 panic，Run 在下一次循环时重新 panic，由 supervisor 按重启策略处理
+func (r *LogExportRunner) reportReaderPanic(reason string) {
+	select {
+	case r.readerPanics <- reason:
+	default:
+		// 已经有一个 panic 等待处理，runner 重启时会重建 reader
+	}
+}
+
+// checkReaderPanic reader 后台 goroutine 已经 panic 时在 Run 的 goroutine 中重新 panic，
+// 读取循环中同样需要检查，否则攒批时要等到 batch_interval 超时才能发现
+func (r *LogExportRunner) checkReaderPanic() {
+	select {
+	case reason := <-r.readerPanics:
+		panic(reason)
+	default:
+	}
+}
+
+// stopWithRecover 停止异常退出的 runner，释放 reader、sender 等资源，runner 的状态可能已经损坏，因此同样需要 recover
+func stopWithRecover(runner Runner) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			log.Errorf("Runner[%v] stop after panic failed: %v", runner.Name(), rec)
+		}
+	}()
+	runner.Stop()
+}
+
+// getSupervision 返回 confPath 对应的重启状态，调用者需要持有 runnerLock
+func (m *Manager) getSupervision(confPath string) *supervision {
+	if m.supervisions == nil {
+		m.supervisions = make(map[string]*supervision)
+	}
+	sv, ok := m.supervisions[confPath]
+	if !ok {
+		sv = &supervision{}
+		m.supervisions[confPath] = sv
+	}
+	return sv
+}
+
+// supervisedStatus 补充重启记录以及配置偏差，调用者需要持有 runnerLock
+func (m *Manager) supervisedStatus(confPath string, rs RunnerStatus) RunnerStatus {
+	if sv, ok := m.supervisions[confPath]; ok {
+		sv.apply(&rs)
+	}
+	if m.drift != nil {
+		if drift, ok := m.drift.get(confPath); ok {
+			rs.ConfigDrift = &drift
+		}
+	}
+	return rs
+}
+
+// superviseRunner 运行 runner，panic 时按照重启策略重建并重新运行，超过最大重启次数后禁用 runner
+func (m *Manager) superviseRunner(confPath string, config RunnerConfig, runner Runner, policy restartPolicy, sv *supervision) {
+	for {
+		start := time.Now()
+		reason := runWithRecover(runner)
+		if reason == "" {
+			return
+		}
+		if !m.detachRunner(confPath, runner) {
+			// runner 已经被移除或者替换，无需重启
+			stopWithRecover(runner)
+			return
+		}
+		stopWithRecover(runner)
+
+		sv.mutex.Lock()
+		if time.Since(start) >= policy.resetAfter {
+			sv.attempts = 0
+		}
+		sv.mutex.Unlock()
+
+		var ok bool
+		if runner, ok = m.restartRunner(confPath, config, reason, policy, sv); !ok {
+			return
+		}
+	}
+}
+
+// restartRunner 等待后重建 runner，重建失败同样计入重启次数，返回 false 表示 runner 被禁用或者不需要再重启
+func (m *Manager) restartRunner(confPath string, config RunnerConfig, reason string, policy restartPolicy, sv *supervision) (Runner, bool) {
+	for {
+		sv.mutex.Lock()
+		sv.attempts++
+		attempt := sv.attempts
+		sv.mutex.Unlock()
+
+		if policy.maxRetries >= 0 && attempt > policy.maxRetries {
+			sv.record(RestartRecord{Time: time.Now(), Reason: reason, Attempt: attempt, Disabled: true})
+			m.disableRunner(confPath)
+			log.Errorf("Runner[%v] failed %d times in a row, exceed max retries %d, runner is disabled", config.RunnerName, attempt, policy.maxRetries)
+			return nil, false
+		}
+		backoff := policy.backoff(attempt)
+		sv.record(RestartRecord{Time: time.Now(), Reason: reason, Attempt: attempt, Backoff: backoff.String()})
+		log.Warnf("Runner[%v] will restart after %v, attempt %d", config.RunnerName, backoff, attempt)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-m.supervisorStop:
+			timer.Stop()
+			return nil, false
+		case <-timer.C:
+		}
+
+		runner, err := NewCustomRunner(config, m.cleanChan, m.rregistry, m.pregistry, m.sregistry)
+		if err != nil {
+			reason = fmt.Sprintf("recreate runner failed: %v", err)
+			log.Errorf("Runner[%v] %s", config.RunnerName, reason)
+			continue
+		}
+		if !m.attachRunner(confPath, runner) {
+			stopWithRecover(runner)
+			return nil, false
+		}
+		sv.mutex.Lock()
+		sv.restarting = false
+		sv.mutex.Unlock()
+		log.Infof("Runner[%v] restarted, attempt %d", config.RunnerName, attempt)
+		return runner, true
+	}
+}
+
+// detachRunner 将异常退出的 runner 从运行列表中移除，返回 false 表示 runner 已经被移除或者替换
+func (m *Manager) detachRunner(confPath string, runner Runner) bool {
+	m.runnerLock.Lock()
+	defer m.runnerLock.Unlock()
+	if current, ok := m.runners[confPath]; !ok || current != runner {
+		return false
+	}
+	m.removeCleanQueue(runner.Cleaner())
+	delete(m.runners, confPath)
+	return true
+}
+
+// attachRunner 将重建的 runner 加入运行列表，等待期间 runner 被删除、停止或者重新启动时返回 false
+func (m *Manager) attachRunner(confPath string, runner Runner) bool {
+	m.runnerLock.Lock()
+	defer m.runnerLock.Unlock()
+	conf, ok := m.runnerConfigs[confPath]
+	if !ok || conf.IsStopped {
+		return false
+	}
+	if _, ok := m.runners[confPath]; ok {
+		return false
+	}
+	m.addCleanQueue(runner.Cleaner())
+	m.runners[confPath] = runner
+	return true
+}
+
+// disableRunner 将 runner 标记为停止，可以通过启动接口重新启动
+func (m *Manager) disableRunner(confPath string) {
+	m.runnerLock.Lock()
+	defer m.runnerLock.Unlock()
+	if conf, ok := m.runnerConfigs[confPath]; ok {
+		conf.IsStopped = true
+		m.runnerConfigs[confPath] = conf
+	}
+}
@@ -0,0 +1,136 @@
+package mgr
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/parser"
+	parserConf "github.com/qiniu/logkit/parser/config"
+	"github.com/qiniu/logkit/reader"
+	. "github.com/qiniu/logkit/reader/config"
+	"github.com/qiniu/logkit/sender"
+	"github.com/qiniu/logkit/sender/discard"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+type panicRunner struct {
+	name    string
+	stopped chan struct{}
+}
+
+func (r *panicRunner) Name() string       { return r.name }
+func (r *panicRunner) Run()               { panic("boom") }
+func (r *panicRunner) Stop()              { close(r.stopped) }
+func (r *panicRunner) Cleaner() CleanInfo { return CleanInfo{} }
+func (r *panicRunner) Status() RunnerStatus {
+	return RunnerStatus{Name: r.name, RunningStatus: RunnerRunning}
+}
+
+func TestParseRestartPolicy(t *testing.T) {
+	p, err := parseRestartPolicy(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, defaultRestartMaxRetries, p.maxRetries)
+	assert.Equal(t, time.Second, p.backoff(1))
+	assert.Equal(t, 4*time.Second, p.backoff(3))
+	assert.Equal(t, defaultRestartMaxBackoff, p.backoff(100))
+
+	p, err = parseRestartPolicy(&RestartPolicy{MaxRetries: -1, InitialBackoff: "10s", MaxBackoff: "15s"})
+	assert.NoError(t, err)
+	assert.Equal(t, -1, p.maxRetries)
+	assert.Equal(t, 10*time.Second, p.backoff(1))
+	assert.Equal(t, 15*time.Second, p.backoff(2))
+
+	_, err = parseRestartPolicy(&RestartPolicy{InitialBackoff: "abc"})
+	assert.Error(t, err)
+	_, err = parseRestartPolicy(&RestartPolicy{ResetAfter: "-1s"})
+	assert.Error(t, err)
+}
+
+func TestSuperviseRunner(t *testing.T) {
+	confPath := "/tmp/TestSuperviseRunner.conf"
+	// 重建 runner 时缺少 reader 配置会失败，同样计入重启次数，最终 runner 被禁用
+	config := RunnerConfig{RunnerInfo: RunnerInfo{RunnerName: "TestSuperviseRunner"}}
+	m := &Manager{
+		runners:        map[string]Runner{},
+		runnerConfigs:  map[string]RunnerConfig{confPath: config},
+		supervisorStop: make(chan struct{}),
+	}
+	runner := &panicRunner{name: config.RunnerName, stopped: make(chan struct{})}
+	m.runners[confPath] = runner
+
+	policy, err := parseRestartPolicy(&RestartPolicy{MaxRetries: 2, InitialBackoff: "1ms"})
+	assert.NoError(t, err)
+	sv := m.getSupervision(confPath)
+	done := make(chan struct{})
+	go func() {
+		m.superviseRunner(confPath, config, runner, policy, sv)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("supervisor not exit")
+	}
+	<-runner.stopped
+
+	status := m.Status()[config.RunnerName]
+	assert.Equal(t, RunnerDisabled, status.RunningStatus)
+	assert.Len(t, status.Restarts, 3)
+	assert.Equal(t, "panic: boom", status.Restarts[0].Reason)
+	assert.Equal(t, "1ms", status.Restarts[0].Backoff)
+	assert.Equal(t, "2ms", status.Restarts[1].Backoff)
+	assert.Contains(t, status.Restarts[1].Reason, "recreate runner failed")
+	assert.True(t, status.Restarts[2].Disabled)
+	assert.True(t, m.runnerConfigs[confPath].IsStopped)
+	_, ok := m.runners[confPath]
+	assert.False(t, ok)
+}
+
+// panicReader 启动的后台 goroutine 会 panic
+type panicReader struct {
+	meta *reader.Meta
+}
+
+func (r *panicReader) Name() string                             { return "panic_reader" }
+func (r *panicReader) SetMode(mode string, v interface{}) error { return nil }
+func (r *panicReader) Source() string                           { return "panic_reader" }
+func (r *panicReader) SyncMeta()                                {}
+func (r *panicReader) Close() error                             { return nil }
+func (r *panicReader) ReadLine() (string, error) {
+	time.Sleep(10 * time.Millisecond)
+	return "", nil
+}
+func (r *panicReader) Start() error {
+	r.meta.Go("worker", func() { panic("boom") })
+	return nil
+}
+
+func TestRunnerReaderPanic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestRunnerReaderPanic")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	meta, err := reader.NewMetaWithConf(conf.MapConf{KeyMetaPath: dir, KeyLogPath: dir, KeyRunnerName: "TestRunnerReaderPanic", KeyMode: ModeFile})
+	assert.NoError(t, err)
+	p, err := parser.NewRegistry().NewLogParser(conf.MapConf{parserConf.KeyParserType: parserConf.TypeRaw})
+	assert.NoError(t, err)
+	s, err := discard.NewSender(conf.MapConf{})
+	assert.NoError(t, err)
+	runner, err := NewLogExportRunnerWithService(RunnerInfo{RunnerName: "TestRunnerReaderPanic"}, &panicReader{meta: meta}, nil, p, nil, []sender.Sender{s}, nil, meta)
+	assert.NoError(t, err)
+
+	// reader goroutine 的 panic 不会导致进程退出，而是由 Run 重新 panic 交给 supervisor
+	done := make(chan string)
+	go func() { done <- runWithRecover(runner) }()
+	select {
+	case reason := <-done:
+		assert.Contains(t, reason, "Runner[TestRunnerReaderPanic] reader worker panic: boom")
+	case <-time.After(5 * time.Second):
+		t.Fatal("reader panic not reported")
+	}
+	stopWithRecover(runner)
+}
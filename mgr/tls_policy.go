@@ -0,0 +1,88 @@
+package mgr
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/qiniu/logkit/conf"
+	. "github.com/qiniu/logkit/sender/config"
+)
+
+// 数据写入本地，不涉及网络传输的 sender
+var localSenders = map[string]bool{
+	TypeFile:    true,
+	TypeMock:    true,
+	TypeDiscard: true,
+	TypeCSV:     true,
+	TypeSQLFile: true,
+}
+
+// 客户端不支持配置 TLS 的 sender
+var plaintextSenders = map[string]bool{
+	TypeKafka:             true,
+	TypeMySQL:             true,
+	TypeMongodbAccumulate: true,
+}
+
+// 以这些后缀结尾的配置项为 sender 的服务地址
+var senderAddressSuffixes = []string{"_host", "_hosts", "_url", "_urls", "_endpoint", "_address"}
+
+// TLSPolicyReport 检查管理接口以及所有 runner 的 sender 是否符合 TLS 策略，返回不符合的配置说明
+func (m *Manager) TLSPolicyReport() []string {
+	var report []string
+	if !m.DisableWeb && (m.TLSCertFile == "" || m.TLSKeyFile == "") {
+		report = append(report, "RestService is served over plain http, set tls_cert_file and tls_key_file to enable https")
+	}
+
+	m.runnerLock.RLock()
+	for _, rc := range m.runnerConfigs {
+		senders := rc.SendersConfig
+		if rc.Canary != nil && rc.Canary.SenderConfig != nil {
+			senders = append(senders[:len(senders):len(senders)], rc.Canary.SenderConfig)
+		}
+		for _, sc := range senders {
+			for _, issue := range checkSenderTLS(sc) {
+				report = append(report, fmt.Sprintf("Runner[%v] %v", rc.RunnerName, issue))
+			}
+		}
+	}
+	m.runnerLock.RUnlock()
+	sort.Strings(report)
+	return report
+}
+
+// checkSenderTLS 返回 sender 配置中不符合 TLS 策略的地方，只能检查配置中显式填写的地址
+func checkSenderTLS(sc conf.MapConf) []string {
+	senderType, _ := sc.GetString(KeySenderType)
+	if localSenders[senderType] {
+		return nil
+	}
+	name, _ := sc.GetStringOr(KeyName, senderType)
+	if plaintextSenders[senderType] {
+		return []string{fmt.Sprintf("sender %v of type %v does not support tls", name, senderType)}
+	}
+
+	var issues []string
+	for key, value := range sc {
+		if !isSenderAddressKey(key) {
+			continue
+		}
+		for _, address := range conf.GetStringList(value) {
+			if !strings.HasPrefix(strings.ToLower(address), "https://") {
+				issues = append(issues, fmt.Sprintf("sender %v %v %q is not https", name, key, address))
+			}
+		}
+	}
+	sort.Strings(issues)
+	return issues
+}
+
+func isSenderAddressKey(key string) bool {
+	for _, suffix := range senderAddressSuffixes {
+		if strings.HasSuffix(key, suffix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,18 @@
+package mgr
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/qiniu/logkit/conf"
+)
+
+func TestCheckSenderTLS(t *testing.T) {
+	assert.Empty(t, checkSenderTLS(conf.MapConf{"sender_type": "file", "file_send_path": "/tmp/a"}))
+	assert.Equal(t, []string{"sender kafka of type kafka does not support tls"},
+		checkSenderTLS(conf.MapConf{"sender_type": "kafka", "kafka_host": "127.0.0.1:9092"}))
+	assert.Equal(t, []string{`sender es es_host "http://127.0.0.1:9200" is not https`},
+		checkSenderTLS(conf.MapConf{"sender_type": "elasticsearch", "name": "es", "es_host": "https://10.0.0.1:9200,http://127.0.0.1:9200"}))
+	assert.Empty(t, checkSenderTLS(conf.MapConf{"sender_type": "pandora", "pandora_host": "https://pipeline.qiniu.com"}))
+}
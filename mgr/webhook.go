@@ -0,0 +1,96 @@
+package mgr
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const (
+	defaultWebhookTimeout = 5 * time.Second
+	webhookQueueSize      = 16
+)
+
+// webhookNotifier 以 POST json 的方式异步发送通知，队列满时丢弃，避免拖慢主流程
+type webhookNotifier struct {
+	url     string
+	client  *http.Client
+	onError func(err string)
+
+	eventChan chan interface{}
+	wg        sync.WaitGroup
+	chanMutex sync.Mutex
+	closed    bool
+}
+
+func checkWebhookURL(url string) error {
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return fmt.Errorf("webhook_url %q should start with http:// or https://", url)
+	}
+	return nil
+}
+
+// newWebhookNotifier 创建通知队列，url 需要先通过 checkWebhookURL 检查，onError 在发送失败时调用
+func newWebhookNotifier(url string, onError func(err string)) *webhookNotifier {
+	w := &webhookNotifier{
+		url:       url,
+		client:    &http.Client{Timeout: defaultWebhookTimeout},
+		onError:   onError,
+		eventChan: make(chan interface{}, webhookQueueSize),
+	}
+	w.wg.Add(1)
+	go w.loop()
+	return w
+}
+
+func (w *webhookNotifier) notify(event interface{}) {
+	w.chanMutex.Lock()
+	defer w.chanMutex.Unlock()
+	if w.closed {
+		return
+	}
+	select {
+	case w.eventChan <- event:
+	default:
+		w.onError("webhook queue is full, drop notification")
+	}
+}
+
+func (w *webhookNotifier) loop() {
+	defer w.wg.Done()
+	for event := range w.eventChan {
+		body, err := json.Marshal(event)
+		if err != nil {
+			w.onError(err.Error())
+			continue
+		}
+		resp, err := w.client.Post(w.url, ApplicationJson, bytes.NewReader(body))
+		if err != nil {
+			w.onError(err.Error())
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			w.onError(fmt.Sprintf("webhook returns status %v", resp.Status))
+		}
+	}
+}
+
+// close 等待队列中的通知发送完毕
+func (w *webhookNotifier) close() {
+	w.chanMutex.Lock()
+	if w.closed {
+		w.chanMutex.Unlock()
+		return
+	}
+	w.closed = true
+	close(w.eventChan)
+	w.chanMutex.Unlock()
+	w.wg.Wait()
+}
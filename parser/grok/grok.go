@@ -4,9 +4,11 @@ import (
 	"bufio"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pkg/errors"
@@ -18,6 +20,7 @@ import (
 	"github.com/qiniu/logkit/parser"
 	. "github.com/qiniu/logkit/parser/config"
 	"github.com/qiniu/logkit/times"
+	"github.com/qiniu/logkit/utils/bundle"
 	. "github.com/qiniu/logkit/utils/models"
 )
 
@@ -27,8 +30,39 @@ const (
 
 const MaxGrokMultiLineBuffer = 64 * 1024 * 1024 // 64MB
 
+// patternGeneration grok pattern 数据包每次更新后加一，使用 pattern 文件的 parser 发现变化后重新编译
+var patternGeneration int64
+
 func init() {
 	parser.RegisterConstructor(TypeGrok, NewParser)
+	bundle.RegisterValidator(bundle.TypeGrok, validatePatternDir)
+	bundle.RegisterUpdateHook(onBundleUpdate)
+}
+
+// validatePatternDir 校验新下载的 pattern 目录中的所有文件能否一起编译
+func validatePatternDir(dir string) error {
+	var files []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	p := &Parser{CustomPatternFiles: files}
+	return p.compile()
+}
+
+func onBundleUpdate(typ, path string) error {
+	if typ == bundle.TypeGrok {
+		atomic.AddInt64(&patternGeneration, 1)
+	}
+	return nil
 }
 
 type Parser struct {
@@ -69,6 +103,12 @@ type Parser struct {
 
 	numRoutine  int
 	keepRawData bool
+
+	// rawCustomPatterns 配置中的 custom_patterns，compile 会修改 CustomPatterns，重新编译时从这里开始
+	rawCustomPatterns string
+	// reloadLock 重新编译 pattern 时替换 g、namedPatterns、typeMap、patterns，Parse 期间持有读锁
+	reloadLock sync.RWMutex
+	generation int64
 }
 
 func NewParser(c conf.MapConf) (parser.Parser, error) {
@@ -102,6 +142,8 @@ func NewParser(c conf.MapConf) (parser.Parser, error) {
 		Patterns:             patterns,
 		CustomPatterns:       customPatterns,
 		CustomPatternFiles:   customPatternFiles,
+		rawCustomPatterns:    customPatterns,
+		generation:           atomic.LoadInt64(&patternGeneration),
 		timeZoneOffset:       timeZoneOffset,
 		disableRecordErrData: disableRecordErrData,
 		numRoutine:           numRoutine,
@@ -152,6 +194,7 @@ func (p *Parser) compile() error {
 
 		scanner := bufio.NewScanner(bufio.NewReader(file))
 		err = p.addCustomPatterns(scanner)
+		file.Close()
 		if err != nil {
 			return err
 		}
@@ -160,6 +203,31 @@ func (p *Parser) compile() error {
 	return p.compileCustomPatterns()
 }
 
+// reload 在 grok pattern 数据包更新后重新编译 pattern 文件，编译失败时继续使用原来的 pattern
+func (p *Parser) reload() {
+	gen := atomic.LoadInt64(&patternGeneration)
+	if len(p.CustomPatternFiles) == 0 || gen == atomic.LoadInt64(&p.generation) {
+		return
+	}
+	p.reloadLock.Lock()
+	defer p.reloadLock.Unlock()
+	if gen == atomic.LoadInt64(&p.generation) {
+		return
+	}
+	atomic.StoreInt64(&p.generation, gen)
+	np := &Parser{
+		Patterns:           p.Patterns,
+		CustomPatterns:     p.rawCustomPatterns,
+		CustomPatternFiles: p.CustomPatternFiles,
+	}
+	if err := np.compile(); err != nil {
+		log.Errorf("Parser[%v]: reload grok pattern files %v error: %v, keep using the old patterns", p.name, p.CustomPatternFiles, err)
+		return
+	}
+	p.CustomPatterns, p.namedPatterns, p.typeMap, p.patterns, p.g = np.CustomPatterns, np.namedPatterns, np.typeMap, np.patterns, np.g
+	log.Infof("Parser[%v]: grok pattern files %v reloaded", p.name, p.CustomPatternFiles)
+}
+
 func (p *Parser) Name() string {
 	return p.name
 }
@@ -169,6 +237,9 @@ func (p *Parser) Type() string {
 }
 
 func (p *Parser) Parse(lines []string) ([]Data, error) {
+	p.reload()
+	p.reloadLock.RLock()
+	defer p.reloadLock.RUnlock()
 	var (
 		linesLen   = len(lines)
 		datas      = make([]Data, linesLen)
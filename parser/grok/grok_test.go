@@ -1,6 +1,9 @@
 package grok
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
 	"testing"
@@ -14,6 +17,7 @@ import (
 
 	. "github.com/qiniu/logkit/parser/config"
 	"github.com/qiniu/logkit/utils"
+	"github.com/qiniu/logkit/utils/bundle"
 	. "github.com/qiniu/logkit/utils/models"
 )
 
@@ -596,6 +600,41 @@ func TestCompileErrors(t *testing.T) {
 	assert.Error(t, p.compile())
 }
 
+func TestReloadPatternFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestReloadPatternFiles")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	patternFile := filepath.Join(dir, "patterns")
+	require.NoError(t, ioutil.WriteFile(patternFile, []byte("MYLOG %{WORD:word}\n"), 0644))
+
+	p, err := NewParser(conf.MapConf{
+		KeyParserName:             "test",
+		KeyGrokPatterns:           "%{MYLOG}",
+		KeyGrokCustomPatternFiles: patternFile,
+	})
+	require.NoError(t, err)
+	datas, err := p.Parse([]string{"abc 123"})
+	assert.NoError(t, err)
+	assert.Equal(t, []Data{{"word": "abc"}}, datas)
+
+	// 数据包校验失败时不替换，通过后更新 pattern 文件，parser 在下一次 Parse 时重新编译
+	require.NoError(t, ioutil.WriteFile(patternFile, []byte("MYLOG\n"), 0644))
+	assert.Error(t, validatePatternDir(dir))
+	require.NoError(t, ioutil.WriteFile(patternFile, []byte("MYLOG %{WORD:word} %{NUMBER:num:long}\n"), 0644))
+	assert.NoError(t, validatePatternDir(dir))
+	assert.NoError(t, onBundleUpdate(bundle.TypeGrok, dir))
+	datas, err = p.Parse([]string{"abc 123"})
+	assert.NoError(t, err)
+	assert.Equal(t, []Data{{"word": "abc", "num": int64(123)}}, datas)
+
+	// 重新编译失败时继续使用原来的 pattern
+	require.NoError(t, ioutil.WriteFile(patternFile, []byte("MYLOG\n"), 0644))
+	assert.NoError(t, onBundleUpdate(bundle.TypeGrok, dir))
+	datas, err = p.Parse([]string{"abc 123"})
+	assert.NoError(t, err)
+	assert.Equal(t, []Data{{"word": "abc", "num": int64(123)}}, datas)
+}
+
 func TestParseErrors(t *testing.T) {
 	// Parse fails because the pattern doesn't exist
 	p := &Parser{
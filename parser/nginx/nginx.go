@@ -20,6 +20,8 @@ import (
 	. "github.com/qiniu/logkit/utils/models"
 )
 
+var _ parser.BatchParser = &Parser{}
+
 func init() {
 	parser.RegisterConstructor(TypeNginx, NewParser)
 }
@@ -233,6 +235,113 @@ func (p *Parser) parse(line string) (Data, error) {
 	return entry, nil
 }
 
+// ParseBatch 与 Parse 的解析结果一致，但是结果按列存储在 Batch 中，避免每条数据分配一个 map
+func (p *Parser) ParseBatch(lines []string) (*Batch, error) {
+	var (
+		lineLen    = len(lines)
+		names      = p.regexp.SubexpNames()
+		fields     = make([]string, 0, len(names)+len(p.labels))
+		numRoutine = p.numRoutine
+		se         = &StatsError{}
+		wg         = new(sync.WaitGroup)
+	)
+	fields = append(fields, names[1:]...)
+	for _, l := range p.labels {
+		fields = append(fields, l.Name)
+	}
+	batch := NewBatch(fields, lineLen)
+	batch.AppendRows(lineLen)
+	cols := make([]int, len(names))
+	for i := 1; i < len(names); i++ {
+		cols[i] = batch.FieldIndex(names[i])
+	}
+
+	if lineLen < numRoutine {
+		numRoutine = lineLen
+	}
+	// 每个 goroutine 只写入自己负责的行，互不冲突
+	fieldNums := make([]int, lineLen)
+	errs := make([]error, lineLen)
+	for r := 0; r < numRoutine; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			for i := r; i < lineLen; i += numRoutine {
+				if len(strings.TrimSpace(lines[i])) > 0 {
+					fieldNums[i], errs[i] = p.parseRow(batch, i, lines[i], cols)
+				}
+			}
+		}(r)
+	}
+	wg.Wait()
+
+	keep := make([]bool, lineLen)
+	se.DatasourceSkipIndex = make([]int, 0)
+	for i, line := range lines {
+		if len(strings.TrimSpace(line)) == 0 {
+			se.DatasourceSkipIndex = append(se.DatasourceSkipIndex, i)
+			continue
+		}
+		if errs[i] != nil {
+			se.AddErrors()
+			se.LastError = errs[i].Error()
+			if !p.disableRecordErrData {
+				batch.Set(i, batch.AddField(KeyPandoraStash), line)
+			} else if !p.keepRawData {
+				se.DatasourceSkipIndex = append(se.DatasourceSkipIndex, i)
+			}
+			if p.keepRawData {
+				batch.Set(i, batch.AddField(KeyRawData), line)
+			}
+			keep[i] = !p.disableRecordErrData || p.keepRawData
+			continue
+		}
+		if fieldNums[i]+len(p.labels) < 1 { //数据为空时不发送
+			se.LastError = "parsed no data by line: " + line
+			se.AddErrors()
+			continue
+		}
+		se.AddSuccess()
+		if p.keepRawData {
+			batch.Set(i, batch.AddField(KeyRawData), line)
+		}
+		keep[i] = true
+	}
+	batch.Filter(keep)
+
+	if se.Errors == 0 && len(se.DatasourceSkipIndex) == 0 {
+		return batch, nil
+	}
+	return batch, se
+}
+
+// parseRow 将一行日志解析到 batch 的第 row 行，返回解析出的字段数
+func (p *Parser) parseRow(batch *Batch, row int, line string, cols []int) (int, error) {
+	line = strings.Trim(line, "\n")
+	re := p.regexp
+	fields := re.FindStringSubmatch(line)
+	if fields == nil {
+		return 0, fmt.Errorf("NginxParser fail to parse log line [%v], given format is [%v]", TruncateStrSize(line, DefaultTruncateMaxSize), re)
+	}
+	var num int
+	for i, name := range re.SubexpNames() {
+		if i == 0 {
+			continue
+		}
+		data, err := p.makeValue(name, fields[i])
+		if err != nil {
+			log.Warnf("Error %v, ignore this key %v ...", err, name)
+			continue
+		}
+		batch.Set(row, cols[i], data)
+		num++
+	}
+	for _, l := range p.labels {
+		batch.Set(row, batch.FieldIndex(l.Name), l.Value)
+	}
+	return num, nil
+}
+
 func (p *Parser) makeValue(name, raw string) (interface{}, error) {
 	valueType := p.schema[name]
 	switch DataType(valueType) {
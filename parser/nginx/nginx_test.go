@@ -192,3 +192,32 @@ func Benchmark_BenchNginxParser(b *testing.B) {
 	}
 	grokBench = m
 }
+
+func TestParseBatch(t *testing.T) {
+	for _, base := range []conf.MapConf{cfg, cfg2, cfg5} {
+		c := conf.MapConf{KeyKeepRawData: "true"}
+		for k, v := range base {
+			c[k] = v
+		}
+		c[KeyLabels] = "machine nb110"
+		p, err := NewNginxAccParser(c)
+		assert.NoError(t, err)
+		lines := append(append(append([]string{}, accLog1...), accErrLog...), accLog2...)
+		exp, expErr := p.Parse(lines)
+		batch, err := p.ParseBatch(lines)
+		assert.Equal(t, exp, batch.Datas())
+		assert.Equal(t, expErr, err)
+	}
+}
+
+var benchBatch *Batch
+
+func Benchmark_NginxParseBatch(b *testing.B) {
+	p, _ := NewNginxAccParser(cfg)
+
+	var m *Batch
+	for n := 0; n < b.N; n++ {
+		m, _ = p.ParseBatch(testData)
+	}
+	benchBatch = m
+}
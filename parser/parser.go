@@ -25,6 +25,11 @@ type ParserType interface {
 	Type() string
 }
 
+// BatchParser 将数据直接解析为按列存储的 Batch，避免每条数据分配一个 map
+type BatchParser interface {
+	ParseBatch(lines []string) (*Batch, error)
+}
+
 type Flushable interface {
 	Flush() (Data, error)
 }
@@ -159,6 +159,11 @@ func (d *diskQueue) Depth() int64 {
 	return atomic.LoadInt64(&d.depth) + atomic.LoadInt64(&d.depthMemory)
 }
 
+// DiskUsedBytes 返回队列文件当前占用的字节数，关闭磁盘空间限制时不统计，返回 0
+func (d *diskQueue) DiskUsedBytes() int64 {
+	return atomic.LoadInt64(&d.currentDiskUsedBytes)
+}
+
 // ReadChan returns the []byte channel for reading data
 func (d *diskQueue) ReadChan() <-chan []byte {
 	return d.readChan
@@ -574,6 +574,7 @@ func TestDiskQueueMaxDiskUsedBytes(t *testing.T) {
 	// 检查初始化时文件占用的字节
 	dq = NewDiskQueue(opts)
 	assert.Equal(t, int64(20), dq.(*diskQueue).currentDiskUsedBytes)
+	assert.Equal(t, int64(20), dq.(DiskUsageQueue).DiskUsedBytes())
 	for i := 0; i < len(puts); i++ {
 		assert.Error(t, dq.Put([]byte(puts[i])))
 	}
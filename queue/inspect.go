@@ -0,0 +1,93 @@
+package queue
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const diskQueueMetaSuffix = ".diskqueue.meta.dat"
+
+// DiskQueueState 磁盘队列 meta 文件中记录的状态，Problem 不为空时表示记录的读写位置不一致
+type DiskQueueState struct {
+	Name         string `json:"name"`
+	Dir          string `json:"dir"`
+	Depth        int64  `json:"depth"`
+	ReadFileNum  int64  `json:"read_file_num"`
+	ReadPos      int64  `json:"read_pos"`
+	WriteFileNum int64  `json:"write_file_num"`
+	WritePos     int64  `json:"write_pos"`
+	// Bytes 为尚未读取的数据文件的总大小
+	Bytes   int64  `json:"bytes"`
+	Problem string `json:"problem,omitempty"`
+}
+
+// ReadDiskQueueStates 读取 dir 下(包括子目录)所有磁盘队列的状态，不会修改任何文件
+func ReadDiskQueueStates(dir string) ([]DiskQueueState, error) {
+	var states []DiskQueueState
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(info.Name(), diskQueueMetaSuffix) {
+			return nil
+		}
+		state, err := readDiskQueueState(filepath.Dir(path), strings.TrimSuffix(info.Name(), diskQueueMetaSuffix))
+		if err != nil {
+			return fmt.Errorf("read %v error: %v", path, err)
+		}
+		states = append(states, state)
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return states, err
+}
+
+func readDiskQueueState(dir, name string) (DiskQueueState, error) {
+	state := DiskQueueState{Name: name, Dir: dir}
+	f, err := os.Open(filepath.Join(dir, name+diskQueueMetaSuffix))
+	if err != nil {
+		return state, err
+	}
+	defer f.Close()
+	if _, err = fmt.Fscanf(f, "%d\n%d,%d\n%d,%d\n",
+		&state.Depth,
+		&state.ReadFileNum, &state.ReadPos,
+		&state.WriteFileNum, &state.WritePos); err != nil {
+		return state, err
+	}
+
+	d := &diskQueue{name: name, dataPath: dir}
+	var missing []int64
+	for i := state.ReadFileNum; i <= state.WriteFileNum; i++ {
+		fi, err := os.Stat(d.fileName(i))
+		if err != nil {
+			if i < state.WriteFileNum || state.WritePos > 0 {
+				missing = append(missing, i)
+			}
+			continue
+		}
+		state.Bytes += fi.Size()
+		if i == state.ReadFileNum {
+			state.Bytes -= state.ReadPos
+		}
+	}
+
+	// 与 checkTailCorruption 的判断相同，这些问题会在队列打开后被自动纠正，但可能意味着数据丢失
+	switch {
+	case state.Depth < 0:
+		state.Problem = fmt.Sprintf("negative depth %d", state.Depth)
+	case state.ReadFileNum > state.WriteFileNum:
+		state.Problem = fmt.Sprintf("readFileNum > writeFileNum (%d > %d)", state.ReadFileNum, state.WriteFileNum)
+	case state.ReadFileNum == state.WriteFileNum && state.ReadPos > state.WritePos:
+		state.Problem = fmt.Sprintf("readPos > writePos (%d > %d)", state.ReadPos, state.WritePos)
+	case state.ReadFileNum == state.WriteFileNum && state.ReadPos == state.WritePos && state.Depth != 0:
+		state.Problem = fmt.Sprintf("depth %d at the tail of queue", state.Depth)
+	case len(missing) > 0 && state.Depth > 0:
+		state.Problem = fmt.Sprintf("data files %v are missing", missing)
+	}
+	return state, nil
+}
@@ -0,0 +1,58 @@
+package queue
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+func TestReadDiskQueueStates(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "TestReadDiskQueueStates")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	dq := NewDiskQueue(NewDiskQueueOptions{
+		Name:            "stream_local_save",
+		DataPath:        tmpDir,
+		MaxBytesPerFile: 1024,
+		MinMsgSize:      1,
+		MaxMsgSize:      1 << 10,
+		SyncEveryWrite:  1,
+		SyncEveryRead:   1,
+		SyncTimeout:     2 * time.Second,
+		WriteRateLimit:  10 * MB,
+	})
+	for _, msg := range []string{"a", "bb", "ccc"} {
+		assert.NoError(t, dq.Put([]byte(msg)))
+	}
+	<-dq.ReadChan()
+	assert.NoError(t, dq.Close())
+
+	sub := filepath.Join(tmpDir, "canary")
+	assert.NoError(t, os.MkdirAll(sub, 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(sub, "backup_local_save"+diskQueueMetaSuffix), []byte("3\n1,10\n1,10\n"), 0644))
+
+	states, err := ReadDiskQueueStates(tmpDir)
+	assert.NoError(t, err)
+	assert.Len(t, states, 2)
+	// 每条消息带 4 字节的长度头部
+	assert.Equal(t, DiskQueueState{
+		Name: "backup_local_save", Dir: sub, Depth: 3, ReadFileNum: 1, ReadPos: 10, WriteFileNum: 1, WritePos: 10,
+		Problem: "depth 3 at the tail of queue",
+	}, states[0])
+	assert.Equal(t, "stream_local_save", states[1].Name)
+	assert.EqualValues(t, 2, states[1].Depth)
+	assert.EqualValues(t, 5, states[1].ReadPos)
+	assert.EqualValues(t, 18, states[1].WritePos)
+	assert.EqualValues(t, 13, states[1].Bytes)
+	assert.Equal(t, "", states[1].Problem)
+
+	states, err = ReadDiskQueueStates(filepath.Join(tmpDir, "not_exist"))
+	assert.NoError(t, err)
+	assert.Len(t, states, 0)
+}
@@ -16,6 +16,12 @@ type BackendQueue interface {
 	Empty() error
 }
 
+// DiskUsageQueue 代表了能够统计占用磁盘空间的队列
+type DiskUsageQueue interface {
+	// DiskUsedBytes 返回队列文件当前占用的字节数
+	DiskUsedBytes() int64
+}
+
 // DataQueue 代表了无需编解码可直接放取 Data 的队列
 type DataQueue interface {
 	// PutDatas 用于存放一组数据
@@ -26,6 +26,7 @@ import (
 	"github.com/qiniu/logkit/conf"
 	"github.com/qiniu/logkit/reader"
 	. "github.com/qiniu/logkit/reader/config"
+	"github.com/qiniu/logkit/reader/extract"
 	"github.com/qiniu/logkit/reader/seqfile"
 	"github.com/qiniu/logkit/reader/singlefile"
 	. "github.com/qiniu/logkit/utils/models"
@@ -70,6 +71,9 @@ type BufReader struct {
 
 	Meta            *reader.Meta // 存放offset的元信息
 	multiLineRegexp *regexp.Regexp
+	framing         *reader.RecordFraming // 不为 nil 时按分隔符或长度前缀切分记录
+	flushTimeout    time.Duration         // 多行模式下缓存的内容超过该时间没有新的行时才发送，为 0 时读到文件末尾立即发送
+	lastAppend      time.Time             // 多行模式下最后一次读到新的行的时间
 
 	stats     StatsInfo
 	statsLock sync.RWMutex
@@ -179,6 +183,16 @@ func (b *BufReader) SetMode(mode string, v interface{}) (err error) {
 	return
 }
 
+// SetRecordFraming 设置按换行以外的方式切分记录，framing 为 nil 时按行读取
+func (b *BufReader) SetRecordFraming(framing *reader.RecordFraming) {
+	b.framing = framing
+}
+
+// SetFlushTimeout 设置多行模式下读到文件末尾后等待后续行的时间，超过该时间没有新的行时发送缓存的内容
+func (b *BufReader) SetFlushTimeout(timeout time.Duration) {
+	b.flushTimeout = timeout
+}
+
 func (b *BufReader) SetRunTime(mode string, v interface{}) (err error) {
 	b.runTime, err = reader.ParseRunTimeWithMode(mode, v)
 	return err
@@ -401,22 +415,87 @@ func (b *BufReader) readBytes(delim byte) ([]byte, error) {
 // For simple uses, a Scanner may be more convenient.
 func (b *BufReader) ReadString(delim byte) (ret string, err error) {
 	bytes, err := b.readBytes(delim)
-	ret = *(*string)(unsafe.Pointer(&bytes))
+	ret = b.decode(*(*string)(unsafe.Pointer(&bytes)))
+	return
+}
+
+func (b *BufReader) decode(ret string) string {
 	//默认都是utf-8
 	encodingWay := b.Meta.GetEncodingWay()
 	if encodingWay != "" && encodingWay != DefaultEncodingWay && encodingWay != "utf-8" && b.decoder != nil {
 		ret = b.decoder.ConvertString(ret)
 	}
-	return
+	return ret
+}
+
+// 按分隔符或长度前缀切分时缓冲区的上限，长度前缀最多占4个字节
+const maxFramedBufSize = reader.MaxRecordSize + 8
+
+// readRecord 读取一条记录，没有设置 framing 时按行读取
+func (b *BufReader) readRecord() (string, error) {
+	if b.framing == nil {
+		return b.ReadString('\n')
+	}
+	return b.readFramed()
+}
+
+// readFramed 按 framing 切分出一条记录，去掉分隔符或长度前缀后以换行结尾返回，与按行读取的结果一致。
+// 不完整的记录留在缓冲区中等待后续写入的数据，缓冲区放不下一条记录时扩大缓冲区
+func (b *BufReader) readFramed() (string, error) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	for {
+		if atomic.LoadInt32(&b.stopped) > 0 {
+			log.Debug("BufReader was stopped while reading...")
+			return "", nil
+		}
+		advance, token, err := b.framing.Split(b.buf[b.r:b.w], false)
+		if err != nil {
+			// 长度前缀不合法时无法找到下一条记录的开始，丢弃缓冲区中的数据
+			b.r = b.w
+			return "", err
+		}
+		if advance > 0 {
+			b.r += advance
+			return b.decode(string(token) + "\n"), nil
+		}
+		if b.err != nil {
+			return "", b.readErr()
+		}
+		if b.buffered() >= len(b.buf) && !b.grow() {
+			// 超过单条记录的上限仍然没有找到分隔符，整个缓冲区作为一条记录
+			line := string(b.buf[b.r:b.w])
+			b.r = b.w
+			log.Warnf("Runner[%v] %v record exceeds %d bytes without delimiter, send the buffered data as a record", b.Meta.RunnerName, b.Name(), reader.MaxRecordSize)
+			return b.decode(line + "\n"), nil
+		}
+		b.fill()
+	}
+}
+
+// grow 将缓冲区扩大一倍，已经达到上限时返回 false
+func (b *BufReader) grow() bool {
+	size := 2 * len(b.buf)
+	if size > maxFramedBufSize {
+		size = maxFramedBufSize
+	}
+	if size <= len(b.buf) {
+		return false
+	}
+	buf := make([]byte, size)
+	copy(buf, b.buf[:b.w])
+	b.buf = buf
+	return true
 }
 
 //ReadPattern读取日志直到匹配行首模式串
 func (b *BufReader) ReadPattern() (string, error) {
 	var maxTimes int = 0
 	for {
-		line, err := b.ReadString('\n')
+		line, err := b.readRecord()
 		//读取到line的情况
 		if len(line) > 0 {
+			b.lastAppend = time.Now()
 			if b.mutiLineCache.Size() <= 0 {
 				b.mutiLineCache.Set([]string{line})
 				continue
@@ -433,6 +512,10 @@ func (b *BufReader) ReadPattern() (string, error) {
 			maxTimes = 0
 		} else { //读取不到日志
 			if err != nil {
+				// 读到文件末尾时后续的行可能还没有写入，等待 flush timeout 之后再发送
+				if err == io.EOF && b.FlushWait() > 0 {
+					return "", err
+				}
 				line = string(b.mutiLineCache.Combine())
 				b.mutiLineCache.Set(make([]string, 0, 16))
 				return line, err
@@ -440,6 +523,9 @@ func (b *BufReader) ReadPattern() (string, error) {
 			maxTimes++
 			//对于又没有错误，也读取不到日志的情况，最多允许10次重试
 			if maxTimes > 10 {
+				if b.flushTimeout > 0 && b.FlushWait() <= 0 {
+					return b.FlushMultiLine(), nil
+				}
 				log.Debugf("Runner[%v] %v read empty line 10 times return empty", b.Meta.RunnerName, b.Name())
 				return "", nil
 			}
@@ -457,6 +543,39 @@ func (b *BufReader) FormMutiLine() []byte {
 	return b.mutiLineCache.Combine()
 }
 
+// MultiLineSize 返回多行模式下还没有组成完整日志的缓存字节数
+func (b *BufReader) MultiLineSize() int {
+	return b.mutiLineCache.TotalLen()
+}
+
+// Buffered 返回已经从底层读取但还没有返回的字节数，包括多行模式下的缓存
+func (b *BufReader) Buffered() int {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return b.buffered() + b.mutiLineCache.TotalLen()
+}
+
+// FlushWait 返回多行模式下缓存的内容还需要等待多久才会发送，没有设置 flush timeout、没有缓存或者已经超时时返回 0
+func (b *BufReader) FlushWait() time.Duration {
+	if b.flushTimeout <= 0 || b.mutiLineCache.Size() <= 0 {
+		return 0
+	}
+	if wait := b.flushTimeout - time.Since(b.lastAppend); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// FlushMultiLine 返回并清空多行模式下缓存的内容，用于内存不足时提前发送不完整的多行日志
+func (b *BufReader) FlushMultiLine() string {
+	if b.mutiLineCache.Size() <= 0 {
+		return ""
+	}
+	line := string(b.mutiLineCache.Combine())
+	b.mutiLineCache.Set(make([]string, 0, 16))
+	return line
+}
+
 //ReadLine returns a string line as a normal Reader
 func (b *BufReader) ReadLine() (ret string, err error) {
 	now := time.Now()
@@ -466,7 +585,7 @@ func (b *BufReader) ReadLine() (ret string, err error) {
 	}
 
 	if b.multiLineRegexp == nil {
-		ret, err = b.ReadString('\n')
+		ret, err = b.readRecord()
 		if os.IsNotExist(err) {
 			if b.lastErrShowTime.Add(5 * time.Second).Before(time.Now()) {
 				if !IsSelfRunner(b.Meta.RunnerName) {
@@ -623,7 +742,28 @@ func NewFileDirReader(meta *reader.Meta, conf conf.MapConf) (reader reader.Reade
 	}
 	fr.SkipFileFirstLine = skipFirstLine
 	fr.ReadSameInode = readSameInode
-	return NewReaderSize(fr, meta, bufSize)
+	return newReaderWithConf(fr, meta, bufSize, conf)
+}
+
+// newReaderWithConf 创建 BufReader 并按配置设置记录的切分方式以及多行日志的等待时间
+func newReaderWithConf(fr reader.FileReader, meta *reader.Meta, bufSize int, conf conf.MapConf) (*BufReader, error) {
+	framing, err := reader.NewRecordFraming(conf)
+	if err != nil {
+		fr.Close()
+		return nil, err
+	}
+	flushTimeout, err := reader.HeadPatternFlushTimeout(conf)
+	if err != nil {
+		fr.Close()
+		return nil, err
+	}
+	r, err := NewReaderSize(fr, meta, bufSize)
+	if err != nil {
+		return nil, err
+	}
+	r.SetRecordFraming(framing)
+	r.SetFlushTimeout(flushTimeout)
+	return r, nil
 }
 
 func NewSingleFileReader(meta *reader.Meta, conf conf.MapConf) (reader reader.Reader, err error) {
@@ -634,12 +774,20 @@ func NewSingleFileReader(meta *reader.Meta, conf conf.MapConf) (reader reader.Re
 	bufSize, _ := conf.GetIntOr(KeyBufSize, DefaultBufSize)
 	whence, _ := conf.GetStringOr(KeyWhence, WhenceOldest)
 	errDirectReturn, _ := conf.GetBoolOr(KeyErrDirectReturn, true)
+	readCompressed, _ := conf.GetBoolOr(KeyReadCompressed, false)
 
+	if readCompressed && extract.StreamFormat(logpath) != "" {
+		df, err := extract.NewDecompressedFile(meta, logpath)
+		if err != nil {
+			return nil, err
+		}
+		return newReaderWithConf(df, meta, bufSize, conf)
+	}
 	fr, err := singlefile.NewSingleFile(meta, logpath, whence, 0, errDirectReturn)
 	if err != nil {
 		return
 	}
-	return NewReaderSize(fr, meta, bufSize)
+	return newReaderWithConf(fr, meta, bufSize, conf)
 }
 
 func init() {
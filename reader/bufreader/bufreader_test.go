@@ -1,9 +1,11 @@
 package bufreader
 
 import (
+	"io"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -548,3 +550,104 @@ func Test_FileNotFound(t *testing.T) {
 }
 
 var lines = "123456789\n123456789\n123456789\n123456789\n"
+
+type chunkReader struct {
+	chunks []string
+}
+
+func (m *chunkReader) Name() string   { return "chunk" }
+func (m *chunkReader) Source() string { return "chunk" }
+func (m *chunkReader) Read(p []byte) (int, error) {
+	if len(m.chunks) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, m.chunks[0])
+	if n < len(m.chunks[0]) {
+		m.chunks[0] = m.chunks[0][n:]
+	} else {
+		m.chunks = m.chunks[1:]
+	}
+	return n, nil
+}
+func (m *chunkReader) SyncMeta() error { return nil }
+func (m *chunkReader) Close() error    { return nil }
+
+func Test_BuffReaderRecordFraming(t *testing.T) {
+	c := conf.MapConf{
+		KeyLogPath:  "logpath",
+		KeyMetaPath: MetaDir,
+		KeyMode:     ModeFile,
+	}
+	defer os.RemoveAll(MetaDir)
+	readAll := func(framing conf.MapConf, fr *chunkReader) []string {
+		meta, err := reader.NewMetaWithConf(c)
+		assert.NoError(t, err)
+		f, err := reader.NewRecordFraming(framing)
+		assert.NoError(t, err)
+		r, err := NewReaderSize(fr, meta, minReadBufferSize)
+		assert.NoError(t, err)
+		r.SetRecordFraming(f)
+		var ret []string
+		for i := 0; i < 100; i++ {
+			line, err := r.ReadLine()
+			if line != "" {
+				ret = append(ret, line)
+			}
+			if err == io.EOF && len(fr.chunks) == 0 {
+				break
+			}
+		}
+		return ret
+	}
+
+	// 单字节分隔符，记录中可以有换行，不完整的记录等待后续写入的数据
+	got := readAll(conf.MapConf{KeyRecordDelimiter: `\0`}, &chunkReader{chunks: []string{"a\nb\x00c", "d\x00", "tail"}})
+	assert.Equal(t, []string{"a\nb\n", "cd\n"}, got)
+
+	// 多字节分隔符跨越两次读取，记录超过初始缓冲区时扩大缓冲区
+	long := strings.Repeat("x", 100)
+	got = readAll(conf.MapConf{KeyRecordDelimiter: `\r\n--\r\n`}, &chunkReader{chunks: []string{"first\r\n-", "-\r\n" + long + "\r\n--\r\n"}})
+	assert.Equal(t, []string{"first\n", long + "\n"}, got)
+
+	got = readAll(conf.MapConf{KeyRecordLengthPrefix: RecordLengthUint16BE}, &chunkReader{chunks: []string{"\x00\x03ab", "c\x00\x00\x00\x14" + strings.Repeat("y", 20)}})
+	assert.Equal(t, []string{"abc\n", "\n", strings.Repeat("y", 20) + "\n"}, got)
+}
+
+func Test_BuffReaderFlushTimeout(t *testing.T) {
+	c := conf.MapConf{
+		KeyLogPath:  "logpath",
+		KeyMetaPath: MetaDir,
+		KeyMode:     ModeFile,
+	}
+	defer os.RemoveAll(MetaDir)
+	meta, err := reader.NewMetaWithConf(c)
+	assert.NoError(t, err)
+	fr := &chunkReader{chunks: []string{"2020 a\n", " a1\n2020 b\n", " b1\n"}}
+	r, err := NewReaderSize(fr, meta, minReadBufferSize)
+	assert.NoError(t, err)
+	assert.NoError(t, r.SetMode(ReadModeHeadPatternString, "^2020"))
+	r.SetFlushTimeout(200 * time.Millisecond)
+
+	var lines []string
+	for i := 0; i < 10 && len(fr.chunks) > 0; i++ {
+		line, _ := r.ReadLine()
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	assert.Equal(t, []string{"2020 a\n a1\n"}, lines)
+
+	// 读到文件末尾时没有超时，缓存的内容不发送，后续写入的行仍然属于同一条日志
+	line, err := r.ReadLine()
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, "", line)
+	assert.True(t, r.FlushWait() > 0)
+	fr.chunks = append(fr.chunks, " b2\n")
+	line, _ = r.ReadLine()
+	assert.Equal(t, "", line)
+
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, time.Duration(0), r.FlushWait())
+	line, _ = r.ReadLine()
+	assert.Equal(t, "2020 b\n b1\n b2\n", line)
+}
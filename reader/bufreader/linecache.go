@@ -6,6 +6,8 @@ import (
 
 type LineCache struct {
 	lines []string
+	// total lines 的总字节数，避免每次计算
+	total int
 	lock  *sync.RWMutex
 }
 
@@ -26,22 +28,23 @@ func (l *LineCache) Set(r []string) {
 	l.lock.Lock()
 	defer l.lock.Unlock()
 	l.lines = r
+	l.total = 0
+	for _, v := range r {
+		l.total += len(v)
+	}
 }
 
 func (l *LineCache) Append(r string) {
 	l.lock.Lock()
 	defer l.lock.Unlock()
 	l.lines = append(l.lines, r)
+	l.total += len(r)
 }
 
 func (l *LineCache) TotalLen() int {
 	l.lock.RLock()
 	defer l.lock.RUnlock()
-	var ret int
-	for _, v := range l.lines {
-		ret += len(v)
-	}
-	return ret
+	return l.total
 }
 
 func (l *LineCache) Combine() []byte {
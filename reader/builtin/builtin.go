@@ -4,21 +4,37 @@ package builtin
 import (
 	_ "github.com/qiniu/logkit/reader/autofile"
 	_ "github.com/qiniu/logkit/reader/bufreader"
+	_ "github.com/qiniu/logkit/reader/clickhouse"
 	_ "github.com/qiniu/logkit/reader/cloudtrail"
 	_ "github.com/qiniu/logkit/reader/cloudwatch"
 	_ "github.com/qiniu/logkit/reader/dirx"
 	_ "github.com/qiniu/logkit/reader/elastic"
+	_ "github.com/qiniu/logkit/reader/grpc"
 	_ "github.com/qiniu/logkit/reader/http"
 	_ "github.com/qiniu/logkit/reader/httpfetch"
+	_ "github.com/qiniu/logkit/reader/httpfile"
+	_ "github.com/qiniu/logkit/reader/journald"
 	_ "github.com/qiniu/logkit/reader/kafka"
+	_ "github.com/qiniu/logkit/reader/kmsg"
+	_ "github.com/qiniu/logkit/reader/mail"
+	_ "github.com/qiniu/logkit/reader/mmap"
 	_ "github.com/qiniu/logkit/reader/mockreader"
 	_ "github.com/qiniu/logkit/reader/mongo"
 	_ "github.com/qiniu/logkit/reader/mssql"
 	_ "github.com/qiniu/logkit/reader/mysql"
+	_ "github.com/qiniu/logkit/reader/nats"
+	_ "github.com/qiniu/logkit/reader/pgcdc"
 	_ "github.com/qiniu/logkit/reader/postgres"
+	_ "github.com/qiniu/logkit/reader/prometheus"
+	_ "github.com/qiniu/logkit/reader/pulsar"
 	_ "github.com/qiniu/logkit/reader/redis"
+	_ "github.com/qiniu/logkit/reader/s3"
 	_ "github.com/qiniu/logkit/reader/script"
 	_ "github.com/qiniu/logkit/reader/snmp"
 	_ "github.com/qiniu/logkit/reader/socket"
+	_ "github.com/qiniu/logkit/reader/sqlite"
+	_ "github.com/qiniu/logkit/reader/statsd"
+	_ "github.com/qiniu/logkit/reader/syslog"
 	_ "github.com/qiniu/logkit/reader/tailx"
+	_ "github.com/qiniu/logkit/reader/wineventlog"
 )
@@ -0,0 +1,500 @@
+package clickhouse
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/qiniu/log"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/reader"
+	. "github.com/qiniu/logkit/reader/config"
+	"github.com/qiniu/logkit/utils/magic"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+var (
+	_ reader.DaemonReader = &Reader{}
+	_ reader.StatsReader  = &Reader{}
+	_ reader.DataReader   = &Reader{}
+	_ reader.Reader       = &Reader{}
+)
+
+const (
+	DefaultPollInterval = time.Minute
+	DefaultBatchSize    = 10000
+)
+
+// 错误响应中最多读取的长度
+const maxErrorBody = 4096
+
+func init() {
+	reader.RegisterConstructor(ModeClickHouse, NewReader)
+}
+
+type readInfo struct {
+	data   Data
+	sql    string
+	cursor interface{}
+	bytes  int64
+}
+
+// Reader 通过 ClickHouse 的 HTTP 接口定时执行查询语句，查询结果以 JSONEachRow 格式逐行读取，
+// 配置了增量列时每条语句只读取该列大于上次读取进度的行，读取进度按原始语句记录在 meta 中
+type Reader struct {
+	meta *reader.Meta
+	// Note: 原子操作，用于表示 reader 整体的运行状态
+	status int32
+
+	stopChan chan struct{}
+	readChan chan readInfo
+	ctx      context.Context
+	cancel   context.CancelFunc
+
+	stats     StatsInfo
+	statsLock sync.RWMutex
+
+	client       *http.Client
+	endpoint     string
+	user         string
+	password     string
+	database     string
+	sqls         []string
+	cursorColumn string
+	batchSize    int
+	pollInterval time.Duration
+	whence       string
+
+	// 已经被上层读取的进度，SyncMeta 时写入 meta
+	stateLock sync.Mutex
+	cursors   map[string]interface{}
+	dirty     bool
+
+	// 只在执行查询的 goroutine 中使用，记录已经放入 readChan 的进度
+	fetched map[string]interface{}
+}
+
+func NewReader(meta *reader.Meta, c conf.MapConf) (reader.Reader, error) {
+	endpoint, err := c.GetString(KeyClickHouseURL)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = url.Parse(endpoint); err != nil {
+		return nil, fmt.Errorf("parse %v %q error: %v", KeyClickHouseURL, endpoint, err)
+	}
+	rawSQLs, err := c.GetString(KeyClickHouseSQL)
+	if err != nil {
+		return nil, err
+	}
+	sqls := splitSQLs(rawSQLs)
+	if len(sqls) == 0 {
+		return nil, fmt.Errorf("%v is empty", KeyClickHouseSQL)
+	}
+	user, _ := c.GetStringOr(KeyClickHouseUser, "")
+	password, err := c.GetPasswordEnvStringOr(KeyClickHousePassword, "")
+	if err != nil {
+		return nil, err
+	}
+	database, _ := c.GetStringOr(KeyClickHouseDatabase, DefaultClickHouseDatabase)
+	cursorColumn, _ := c.GetStringOr(KeyClickHouseCursorColumn, "")
+	batchSize, _ := c.GetIntOr(KeyClickHouseBatchSize, DefaultBatchSize)
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	pollIntervalStr, _ := c.GetStringOr(KeyClickHousePollInterval, DefaultPollInterval.String())
+	pollInterval, err := time.ParseDuration(pollIntervalStr)
+	if err != nil {
+		return nil, fmt.Errorf("parse %v %q error: %v", KeyClickHousePollInterval, pollIntervalStr, err)
+	}
+	if pollInterval <= 0 {
+		pollInterval = DefaultPollInterval
+	}
+	whence, _ := c.GetStringOr(KeyWhence, WhenceOldest)
+	if whence != WhenceOldest && whence != WhenceNewest {
+		return nil, fmt.Errorf("%v should be %v or %v", KeyWhence, WhenceOldest, WhenceNewest)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &Reader{
+		meta:         meta,
+		status:       StatusInit,
+		stopChan:     make(chan struct{}),
+		readChan:     make(chan readInfo, 100),
+		ctx:          ctx,
+		cancel:       cancel,
+		client:       &http.Client{},
+		endpoint:     strings.TrimSuffix(endpoint, "/") + "/",
+		user:         user,
+		password:     password,
+		database:     database,
+		sqls:         sqls,
+		cursorColumn: strings.TrimSpace(cursorColumn),
+		batchSize:    batchSize,
+		pollInterval: pollInterval,
+		whence:       whence,
+		cursors:      make(map[string]interface{}),
+		fetched:      make(map[string]interface{}),
+	}
+
+	cursors, err := readState(meta)
+	if err != nil {
+		if os.IsNotExist(err) {
+			log.Debugf("Runner[%v] %v recover from meta error %v, ignore...", meta.RunnerName, r.Name(), err)
+		} else {
+			log.Warnf("Runner[%v] %v recover from meta error %v, ignore...", meta.RunnerName, r.Name(), err)
+		}
+		return r, nil
+	}
+	for sql, cur := range cursors {
+		r.cursors[sql] = cur
+		r.fetched[sql] = cur
+	}
+	return r, nil
+}
+
+// splitSQLs 按分号拆分多条查询语句，忽略空语句
+func splitSQLs(s string) []string {
+	var sqls []string
+	for _, sql := range strings.Split(s, ";") {
+		if sql = strings.TrimSpace(sql); sql != "" {
+			sqls = append(sqls, sql)
+		}
+	}
+	return sqls
+}
+
+func readState(meta *reader.Meta) (map[string]interface{}, error) {
+	_, _, bufsize, err := meta.ReadBufMeta()
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, bufsize)
+	if _, err = meta.ReadBuf(buf); err != nil {
+		return nil, err
+	}
+	var cursors map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(buf))
+	// 数字类型的进度保持原样，避免 UInt64 等大整数损失精度
+	decoder.UseNumber()
+	if err = decoder.Decode(&cursors); err != nil {
+		return nil, err
+	}
+	return cursors, nil
+}
+
+func (r *Reader) isStopping() bool {
+	return atomic.LoadInt32(&r.status) == StatusStopping
+}
+
+func (r *Reader) hasStopped() bool {
+	return atomic.LoadInt32(&r.status) == StatusStopped
+}
+
+func (r *Reader) Name() string {
+	return "clickhouse:" + r.endpoint
+}
+
+func (r *Reader) SetMode(mode string, v interface{}) error {
+	return errors.New("clickhouse reader does not support read mode")
+}
+
+func (r *Reader) setStatsError(err string) {
+	r.statsLock.Lock()
+	defer r.statsLock.Unlock()
+	r.stats.LastError = err
+}
+
+func (r *Reader) Start() error {
+	if r.isStopping() || r.hasStopped() {
+		return errors.New("reader is stopping or has stopped")
+	}
+	if !atomic.CompareAndSwapInt32(&r.status, StatusInit, StatusRunning) {
+		log.Warnf("Runner[%v] %q daemon has already started and is running", r.meta.RunnerName, r.Name())
+		return nil
+	}
+
+	go r.run()
+	log.Infof("Runner[%v] %q daemon has started", r.meta.RunnerName, r.Name())
+	return nil
+}
+
+func (r *Reader) run() {
+	defer func() {
+		atomic.StoreInt32(&r.status, StatusStopped)
+		close(r.readChan)
+		log.Infof("Runner[%v] %q daemon has stopped from running", r.meta.RunnerName, r.Name())
+	}()
+
+	ticker := time.NewTicker(r.pollInterval)
+	defer ticker.Stop()
+	for {
+		err := r.poll(time.Now())
+		if r.isStopping() || r.hasStopped() {
+			return
+		}
+		if err != nil {
+			log.Errorf("Runner[%v] %q query error: %v", r.meta.RunnerName, r.Name(), err)
+			r.setStatsError(err.Error())
+		}
+		select {
+		case <-r.stopChan:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll 依次执行每条查询语句，配置了增量列时分批读取，直到读取的行数少于 batchSize
+func (r *Reader) poll(now time.Time) error {
+	for _, sql := range r.sqls {
+		rendered := strings.TrimSuffix(strings.TrimSpace(magic.GoMagic(sql, now)), ";")
+		if r.cursorColumn == "" {
+			if _, err := r.query(sql, rendered+" FORMAT JSONEachRow"); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if _, ok := r.fetched[sql]; !ok && r.whence == WhenceNewest {
+			// 没有读取记录且从最新位置读取时跳过已有的数据
+			cur, err := r.newest(rendered)
+			if err != nil {
+				return err
+			}
+			r.fetched[sql] = cur
+			r.commit(readInfo{sql: sql, cursor: cur})
+			continue
+		}
+		for {
+			rows, err := r.query(sql, r.incrementalSQL(rendered, r.fetched[sql]))
+			if err != nil {
+				return err
+			}
+			if rows < r.batchSize || r.isStopping() {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// incrementalSQL 返回读取 cursor 之后 batchSize 行的查询语句，cursor 为 nil 时从头读取
+func (r *Reader) incrementalSQL(rendered string, cursor interface{}) string {
+	column := quoteIdentifier(r.cursorColumn)
+	var where string
+	if cursor != nil {
+		where = fmt.Sprintf(" WHERE %s > %s", column, literal(cursor))
+	}
+	return fmt.Sprintf("SELECT * FROM (%s)%s ORDER BY %s LIMIT %d FORMAT JSONEachRow", rendered, where, column, r.batchSize)
+}
+
+// newest 返回查询结果中增量列的最大值
+func (r *Reader) newest(rendered string) (interface{}, error) {
+	column := quoteIdentifier(r.cursorColumn)
+	sql := fmt.Sprintf("SELECT max(%s) AS %s FROM (%s) FORMAT JSONEachRow", column, column, rendered)
+	resp, err := r.do(sql)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var row map[string]interface{}
+	decoder := json.NewDecoder(resp.Body)
+	decoder.UseNumber()
+	if err = decoder.Decode(&row); err != nil {
+		return nil, fmt.Errorf("decode max %v error: %v", r.cursorColumn, err)
+	}
+	return row[r.cursorColumn], nil
+}
+
+// query 执行查询并将结果逐行放入 readChan，返回读取的行数
+func (r *Reader) query(sql, query string) (int, error) {
+	resp, err := r.do(query)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	rows := 0
+	br := bufio.NewReader(resp.Body)
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			info, perr := r.parseRow(sql, line)
+			if perr != nil {
+				return rows, perr
+			}
+			select {
+			case <-r.stopChan:
+				return rows, nil
+			case r.readChan <- info:
+				rows++
+				if r.cursorColumn != "" {
+					r.fetched[sql] = info.cursor
+				}
+			}
+		}
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return rows, err
+		}
+	}
+}
+
+// parseRow 解析一行 JSONEachRow 格式的结果，查询过程中出错时 ClickHouse 会在结果中输出错误信息
+func (r *Reader) parseRow(sql string, line []byte) (readInfo, error) {
+	var row map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(line))
+	decoder.UseNumber()
+	if err := decoder.Decode(&row); err != nil {
+		return readInfo{}, fmt.Errorf("parse row %q error: %v", bytes.TrimSpace(line), err)
+	}
+	info := readInfo{data: make(Data, len(row)), sql: sql, bytes: int64(len(line))}
+	for k, v := range row {
+		if n, ok := v.(json.Number); ok {
+			if i, err := n.Int64(); err == nil {
+				v = i
+			} else if f, err := n.Float64(); err == nil {
+				v = f
+			}
+		}
+		info.data[k] = v
+	}
+	if r.cursorColumn != "" {
+		cursor, ok := row[r.cursorColumn]
+		if !ok || cursor == nil {
+			return readInfo{}, fmt.Errorf("cursor column %v is not found in the result of %q", r.cursorColumn, sql)
+		}
+		info.cursor = cursor
+	}
+	return info, nil
+}
+
+func (r *Reader) do(query string) (*http.Response, error) {
+	params := url.Values{}
+	params.Set("database", r.database)
+	// 64 位整数以数字输出，否则会被输出为字符串
+	params.Set("output_format_json_quote_64bit_integers", "0")
+	req, err := http.NewRequest(http.MethodPost, r.endpoint+"?"+params.Encode(), strings.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(r.ctx)
+	if r.user != "" {
+		req.Header.Set("X-ClickHouse-User", r.user)
+	}
+	if r.password != "" {
+		req.Header.Set("X-ClickHouse-Key", r.password)
+	}
+	log.Debugf("Runner[%v] %q execute query %q", r.meta.RunnerName, r.Name(), query)
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		resp.Body.Close()
+		return nil, fmt.Errorf("query %q returned %v: %s", query, resp.Status, bytes.TrimSpace(body))
+	}
+	return resp, nil
+}
+
+func quoteIdentifier(s string) string {
+	return "`" + strings.Replace(strings.Replace(s, `\`, `\\`, -1), "`", "\\`", -1) + "`"
+}
+
+// literal 将读取进度转换为查询语句中的常量，字符串类型的列(包括 DateTime)使用字符串常量
+func literal(v interface{}) string {
+	switch v := v.(type) {
+	case json.Number:
+		return v.String()
+	case string:
+		return "'" + strings.Replace(strings.Replace(v, `\`, `\\`, -1), "'", `\'`, -1) + "'"
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// commit 记录已经被上层读取的数据
+func (r *Reader) commit(info readInfo) {
+	if r.cursorColumn == "" {
+		return
+	}
+	r.stateLock.Lock()
+	defer r.stateLock.Unlock()
+	r.cursors[info.sql] = info.cursor
+	r.dirty = true
+}
+
+func (r *Reader) Source() string {
+	return r.endpoint
+}
+
+func (r *Reader) ReadLine() (string, error) {
+	return "", errors.New("method ReadLine is not supported, please use ReadData")
+}
+
+func (r *Reader) ReadData() (Data, int64, error) {
+	timer := time.NewTimer(time.Second)
+	defer timer.Stop()
+	select {
+	case info, ok := <-r.readChan:
+		if !ok {
+			return nil, 0, nil
+		}
+		r.commit(info)
+		return info.data, info.bytes, nil
+	case <-timer.C:
+	}
+
+	return nil, 0, nil
+}
+
+func (r *Reader) Status() StatsInfo {
+	r.statsLock.RLock()
+	defer r.statsLock.RUnlock()
+	return r.stats
+}
+
+func (r *Reader) SyncMeta() {
+	r.stateLock.Lock()
+	if !r.dirty {
+		r.stateLock.Unlock()
+		return
+	}
+	buf, err := json.Marshal(r.cursors)
+	r.dirty = false
+	r.stateLock.Unlock()
+
+	if err != nil {
+		log.Errorf("Runner[%v] %v marshal meta error %v", r.meta.RunnerName, r.Name(), err)
+		return
+	}
+	if err = r.meta.WriteBuf(buf, 0, 0, len(buf)); err != nil {
+		log.Errorf("Runner[%v] %v SyncMeta error %v", r.meta.RunnerName, r.Name(), err)
+	}
+}
+
+func (r *Reader) Close() error {
+	if !atomic.CompareAndSwapInt32(&r.status, StatusRunning, StatusStopping) {
+		log.Warnf("Runner[%v] reader %q is not running, close operation ignored", r.meta.RunnerName, r.Name())
+		return nil
+	}
+	log.Debugf("Runner[%v] %q daemon is stopping", r.meta.RunnerName, r.Name())
+	close(r.stopChan)
+	r.cancel()
+	return nil
+}
@@ -0,0 +1,206 @@
+package clickhouse
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/reader"
+	. "github.com/qiniu/logkit/reader/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+var (
+	whereRegex = regexp.MustCompile("WHERE `id` > (\\d+)")
+	limitRegex = regexp.MustCompile(`LIMIT (\d+)`)
+)
+
+// fakeClickHouse 模拟 ClickHouse 的 HTTP 接口，表中有 id 为 1 到 rows 的行
+type fakeClickHouse struct {
+	mu      sync.Mutex
+	rows    int
+	queries []string
+}
+
+func (f *fakeClickHouse) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+	query := string(body)
+	f.mu.Lock()
+	f.queries = append(f.queries, query)
+	rows := f.rows
+	f.mu.Unlock()
+
+	if req.Header.Get("X-ClickHouse-User") != "reader" || req.Header.Get("X-ClickHouse-Key") != "secret" ||
+		req.URL.Query().Get("database") != "logs" {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("Code: 516. DB::Exception: Authentication failed"))
+		return
+	}
+	if !strings.HasSuffix(query, " FORMAT JSONEachRow") || strings.Contains(query, "missing") {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Code: 60. DB::Exception: Table doesn't exist"))
+		return
+	}
+	if strings.Contains(query, "max(`id`)") {
+		fmt.Fprintf(w, "{\"id\":%d}\n", rows)
+		return
+	}
+	start, limit := 0, rows
+	if m := whereRegex.FindStringSubmatch(query); m != nil {
+		start, _ = strconv.Atoi(m[1])
+	}
+	if m := limitRegex.FindStringSubmatch(query); m != nil {
+		limit, _ = strconv.Atoi(m[1])
+	}
+	for id := start + 1; id <= rows && id <= start+limit; id++ {
+		fmt.Fprintf(w, "{\"id\":%d,\"msg\":\"log %d\",\"cost\":1.5}\n", id, id)
+	}
+}
+
+func (f *fakeClickHouse) takeQueries() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	queries := f.queries
+	f.queries = nil
+	return queries
+}
+
+func newTestReader(t *testing.T, c conf.MapConf) *Reader {
+	meta, err := reader.NewMetaWithConf(c)
+	require.NoError(t, err)
+	r, err := NewReader(meta, c)
+	require.NoError(t, err)
+	return r.(*Reader)
+}
+
+func readAll(t *testing.T, r *Reader) []Data {
+	var datas []Data
+	for {
+		select {
+		case info := <-r.readChan:
+			r.commit(info)
+			datas = append(datas, info.data)
+		default:
+			return datas
+		}
+	}
+}
+
+func TestClickHouseReader(t *testing.T) {
+	fake := &fakeClickHouse{rows: 5}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "clickhouse")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := conf.MapConf{
+		KeyMetaPath:               filepath.Join(dir, "meta"),
+		KeyMode:                   ModeClickHouse,
+		KeyRunnerName:             "TestClickHouseReader",
+		KeyClickHouseURL:          server.URL,
+		KeyClickHouseUser:         "reader",
+		KeyClickHousePassword:     "secret",
+		KeyClickHouseDatabase:     "logs",
+		KeyClickHouseSQL:          "select * from logs_@(YYYY)@(MM);",
+		KeyClickHouseCursorColumn: "id",
+		KeyClickHouseBatchSize:    "2",
+	}
+	now := time.Date(2019, 10, 16, 12, 0, 0, 0, time.Local)
+	r := newTestReader(t, c)
+	require.NoError(t, r.poll(now))
+	datas := readAll(t, r)
+	require.Len(t, datas, 5)
+	assert.Equal(t, Data{"id": int64(1), "msg": "log 1", "cost": 1.5}, datas[0])
+	assert.Equal(t, int64(5), datas[4]["id"])
+	// 按 batch_size 分页读取，读取的行数少于 batch_size 时结束
+	assert.Equal(t, []string{
+		"SELECT * FROM (select * from logs_201910) ORDER BY `id` LIMIT 2 FORMAT JSONEachRow",
+		"SELECT * FROM (select * from logs_201910) WHERE `id` > 2 ORDER BY `id` LIMIT 2 FORMAT JSONEachRow",
+		"SELECT * FROM (select * from logs_201910) WHERE `id` > 4 ORDER BY `id` LIMIT 2 FORMAT JSONEachRow",
+	}, fake.takeQueries())
+	r.SyncMeta()
+
+	// 重启后从 meta 中记录的位置继续读取
+	fake.mu.Lock()
+	fake.rows = 6
+	fake.mu.Unlock()
+	r = newTestReader(t, c)
+	require.NoError(t, r.poll(now))
+	datas = readAll(t, r)
+	require.Len(t, datas, 1)
+	assert.Equal(t, int64(6), datas[0]["id"])
+	assert.Equal(t, []string{
+		"SELECT * FROM (select * from logs_201910) WHERE `id` > 5 ORDER BY `id` LIMIT 2 FORMAT JSONEachRow",
+	}, fake.takeQueries())
+
+	// 从最新位置读取时跳过已有的数据
+	c[KeyMetaPath] = filepath.Join(dir, "meta_newest")
+	c[KeyWhence] = WhenceNewest
+	r = newTestReader(t, c)
+	require.NoError(t, r.poll(now))
+	assert.Empty(t, readAll(t, r))
+	require.NoError(t, r.poll(now))
+	assert.Empty(t, readAll(t, r))
+	assert.Equal(t, []string{
+		"SELECT max(`id`) AS `id` FROM (select * from logs_201910) FORMAT JSONEachRow",
+		"SELECT * FROM (select * from logs_201910) WHERE `id` > 6 ORDER BY `id` LIMIT 2 FORMAT JSONEachRow",
+	}, fake.takeQueries())
+
+	// 没有增量列时每次读取完整的查询结果
+	c[KeyMetaPath] = filepath.Join(dir, "meta_full")
+	c[KeyClickHouseCursorColumn] = ""
+	c[KeyClickHouseSQL] = "select * from logs; select * from missing"
+	r = newTestReader(t, c)
+	err = r.poll(now)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Table doesn't exist")
+	assert.Len(t, readAll(t, r), 6)
+	assert.Equal(t, []string{"select * from logs FORMAT JSONEachRow", "select * from missing FORMAT JSONEachRow"}, fake.takeQueries())
+
+	c[KeyClickHouseSQL] = " ; "
+	meta, err := reader.NewMetaWithConf(c)
+	require.NoError(t, err)
+	_, err = NewReader(meta, c)
+	assert.Error(t, err)
+}
+
+func TestClickHouseReaderRun(t *testing.T) {
+	fake := &fakeClickHouse{rows: 3}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "clickhouse")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	r := newTestReader(t, conf.MapConf{
+		KeyMetaPath:               filepath.Join(dir, "meta"),
+		KeyMode:                   ModeClickHouse,
+		KeyRunnerName:             "TestClickHouseReaderRun",
+		KeyClickHouseURL:          server.URL,
+		KeyClickHouseUser:         "reader",
+		KeyClickHousePassword:     "wrong",
+		KeyClickHouseDatabase:     "logs",
+		KeyClickHouseSQL:          "select * from logs",
+		KeyClickHouseCursorColumn: "id",
+	})
+	require.NoError(t, r.Start())
+	defer r.Close()
+	data, _, err := r.ReadData()
+	assert.NoError(t, err)
+	assert.Nil(t, data)
+	assert.Contains(t, r.Status().LastError, "Authentication failed")
+}
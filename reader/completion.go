@@ -0,0 +1,103 @@
+package reader
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const (
+	completionFileName = "file.completion"
+	ChecksumSHA256     = "sha256"
+)
+
+// CompletionRecord 记录一个读取完毕的文件的大小和校验值，用于与数据生产方的清单核对数据是否完整
+type CompletionRecord struct {
+	Path        string    `json:"path"`
+	Size        int64     `json:"size"`
+	Offset      int64     `json:"offset"` // 文件读取完毕时 meta 中记录的 offset，与 size 相等说明已经完整读取
+	Algorithm   string    `json:"algorithm"`
+	Checksum    string    `json:"checksum"`
+	CompletedAt time.Time `json:"completed_at"`
+}
+
+// CompletionReader 代表了能够提供已读完文件校验记录的读取器
+type CompletionReader interface {
+	Completions() ([]CompletionRecord, error)
+}
+
+// completionMux 保证同一进程内对 completion 文件的追加不会交错
+var completionMux sync.Mutex
+
+// FileChecksum 计算文件的 sha256 校验值以及实际参与计算的字节数
+func FileChecksum(path string) (checksum string, size int64, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	size, err = io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), size, nil
+}
+
+// CompletionFile 返回记录已读完文件校验值的文件地址
+func (m *Meta) CompletionFile() string {
+	return filepath.Join(m.Dir, completionFileName)
+}
+
+// AppendCompletion 将一条完成记录以 json 行的形式追加到 completion 文件中
+func (m *Meta) AppendCompletion(record CompletionRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	completionMux.Lock()
+	defer completionMux.Unlock()
+	f, err := os.OpenFile(m.CompletionFile(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, DefaultFilePerm)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// ReadCompletions 读取所有的完成记录，文件不存在时返回空
+func (m *Meta) ReadCompletions() ([]CompletionRecord, error) {
+	records := make([]CompletionRecord, 0)
+	f, err := os.Open(m.CompletionFile())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return records, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var record CompletionRecord
+		if err = json.Unmarshal(line, &record); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
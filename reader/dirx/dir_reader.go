@@ -107,8 +107,13 @@ func (dr *dirReader) Run() {
 				// 文件 EOF，同时没有任何内容，代表不是第一次 EOF，休息时间设置长一些
 				if err == io.EOF {
 					atomic.StoreInt32(&dr.inactive, 1)
-					log.Debugf("Runner[%v] log path[%v] reader met EOF and becomes inactive now, sleep 5 seconds", dr.runnerName, dr.originalPath)
-					time.Sleep(5 * time.Second)
+					// 有等待发送的多行日志时，超时后及时读取发送
+					wait := 5 * time.Second
+					if flushWait := dr.br.FlushWait(); flushWait > 0 && flushWait < wait {
+						wait = flushWait
+					}
+					log.Debugf("Runner[%v] log path[%v] reader met EOF and becomes inactive now, sleep %v", dr.runnerName, dr.originalPath, wait)
+					time.Sleep(wait)
 					continue
 				}
 
@@ -298,6 +303,7 @@ type newReaderOptions struct {
 	ValidFilesRegex    string
 	Whence             string
 	BufferSize         int
+	FlushTimeout       time.Duration
 
 	expireMap map[string]int64
 
@@ -317,6 +323,7 @@ func (drs *dirReaders) NewReader(opts newReaderOptions, notFirstTime bool) (*dir
 	}
 	subMeta.Readlimit = opts.Meta.Readlimit
 	subMeta.SetEncodingWay(opts.Meta.GetEncodingWay())
+	subMeta.SetMetaEncoding(opts.Meta.GetMetaEncoding())
 
 	isNewDir := opts.Meta.IsStatisticFileExist() || notFirstTime //是否为存量文件
 	if isNewDir && subMeta.IsNotExist() {
@@ -343,6 +350,7 @@ func (drs *dirReaders) NewReader(opts newReaderOptions, notFirstTime bool) (*dir
 		fri.Close()
 		return nil, fmt.Errorf("new buffer reader: %v", err)
 	}
+	br.SetFlushTimeout(opts.FlushTimeout)
 
 	dr := &dirReader{
 		status:       StatusInit,
@@ -72,6 +72,7 @@ type Reader struct {
 	validFilesRegex      string
 	whence               string
 	bufferSize           int
+	flushTimeout         time.Duration
 
 	expireMap map[string]int64
 
@@ -120,6 +121,10 @@ func NewReader(meta *reader.Meta, conf conf.MapConf) (reader.Reader, error) {
 	whence, _ := conf.GetStringOr(KeyWhence, WhenceOldest)
 	bufferSize, _ := conf.GetIntOr(KeyBufSize, bufreader.DefaultBufSize)
 	readSameInode, _ := conf.GetBoolOr(KeyReadSameInode, false)
+	flushTimeout, err := reader.HeadPatternFlushTimeout(conf)
+	if err != nil {
+		return nil, err
+	}
 
 	_, _, bufsize, err := meta.ReadBufMeta()
 	if err != nil {
@@ -172,6 +177,7 @@ func NewReader(meta *reader.Meta, conf conf.MapConf) (reader.Reader, error) {
 		whence:               whence,
 		bufferSize:           bufferSize,
 		readSameInode:        readSameInode,
+		flushTimeout:         flushTimeout,
 		expireMap:            make(map[string]int64),
 	}, nil
 }
@@ -296,6 +302,7 @@ func (r *Reader) statLogPath() {
 			ValidFilesRegex:    r.validFilesRegex,
 			Whence:             r.whence,
 			BufferSize:         r.bufferSize,
+			FlushTimeout:       r.flushTimeout,
 			MsgChan:            r.msgChan,
 			ErrChan:            r.errChan,
 			ReadSameInode:      r.readSameInode,
@@ -326,7 +333,7 @@ func (r *Reader) statLogPath() {
 			continue
 		}
 
-		go dr.Run()
+		r.meta.Go("dirx "+logPath, dr.Run)
 	}
 	if !r.notFirstTime {
 		r.notFirstTime = true
@@ -346,7 +353,7 @@ func (r *Reader) Start() error {
 		return nil
 	}
 
-	go func() {
+	r.meta.Go("dirx stat", func() {
 		ticker := time.NewTicker(r.statInterval)
 		defer ticker.Stop()
 		for {
@@ -362,10 +369,10 @@ func (r *Reader) Start() error {
 			case <-ticker.C:
 			}
 		}
-	}()
+	})
 
 	if r.expireDelete {
-		go func() {
+		r.meta.Go("dirx expire delete", func() {
 			for {
 				select {
 				case <-r.stopChan:
@@ -379,11 +386,11 @@ func (r *Reader) Start() error {
 					}
 				}
 			}
-		}()
+		})
 	}
 
 	if IsSubMetaExpire(r.submetaExpire, r.expire) {
-		go func() {
+		r.meta.Go("dirx submeta expire", func() {
 			ticker := time.NewTicker(time.Hour)
 			defer ticker.Stop()
 			for {
@@ -395,7 +402,7 @@ func (r *Reader) Start() error {
 				case <-ticker.C:
 				}
 			}
-		}()
+		})
 	}
 
 	log.Infof("Runner[%v] %q daemon has started", r.meta.RunnerName, r.Name())
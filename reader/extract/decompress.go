@@ -0,0 +1,278 @@
+package extract
+
+import (
+	"bufio"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/qiniu/log"
+
+	"github.com/qiniu/logkit/rateio"
+	"github.com/qiniu/logkit/reader"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const (
+	FormatGzip  = "gz"
+	FormatBzip2 = "bz2"
+	FormatZstd  = "zst"
+)
+
+// ErrZstdNotSupported 没有可用的 zstd 解码器
+var ErrZstdNotSupported = errors.New("zstd compressed file is not supported")
+
+// StreamFormat 返回可以流式解压读取的单个压缩文件的格式，.tar.gz 等归档文件以及非压缩文件返回空字符串
+func StreamFormat(path string) string {
+	switch {
+	case strings.HasSuffix(path, ".tar.gz"):
+		return ""
+	case strings.HasSuffix(path, ".gz"):
+		return FormatGzip
+	case strings.HasSuffix(path, ".bz2"):
+		return FormatBzip2
+	case strings.HasSuffix(path, ".zst"):
+		return FormatZstd
+	}
+	return ""
+}
+
+// countReader 记录从压缩文件中读取的字节数，用于计算 lag
+type countReader struct {
+	rd io.Reader
+	n  int64
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.rd.Read(p)
+	atomic.AddInt64(&c.n, int64(n))
+	return n, err
+}
+
+// DecompressedFile 边解压边读取单个压缩文件，读取位置按解压后的字节数记录在 meta 中，
+// 重新打开时解压并跳过已经读取的部分。压缩文件可能还在写入，解压时遇到不完整的数据按读到末尾处理，
+// 文件变大后重新打开继续读取
+type DecompressedFile struct {
+	meta   *reader.Meta
+	path   string
+	format string
+
+	f       *os.File
+	limiter io.Closer // 配置了读取速度限制时需要关闭
+	counter *countReader
+	rd      io.Reader
+	size    int64 // 打开时压缩文件的大小
+
+	offset     int64 // 解压后已经读取的字节数
+	truncated  bool  // 压缩数据不完整，等待文件继续写入
+	done       int32
+	stopped    int32
+	lastOffset int64 // 上次同步到 meta 的位置
+
+	mux sync.Mutex
+}
+
+// NewDecompressedFile 打开压缩文件并从 meta 中记录的位置继续读取，没有记录时从头读取
+func NewDecompressedFile(meta *reader.Meta, path string) (*DecompressedFile, error) {
+	format := StreamFormat(path)
+	switch format {
+	case FormatGzip, FormatBzip2:
+	case FormatZstd:
+		return nil, fmt.Errorf("%s: %v", path, ErrZstdNotSupported)
+	default:
+		return nil, fmt.Errorf("%s is not a compressed file", path)
+	}
+	d := &DecompressedFile{
+		meta:   meta,
+		path:   path,
+		format: format,
+	}
+	metafile, offset, err := meta.ReadOffset()
+	if err == nil && metafile == path {
+		d.offset = offset
+		d.lastOffset = offset
+	} else {
+		log.Debugf("Runner[%v] %v has no read offset in meta, read from the beginning", meta.RunnerName, path)
+	}
+	if err = d.open(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// open 打开压缩文件并跳过已经读取的 offset 个字节，调用方负责加锁
+func (d *DecompressedFile) open() error {
+	f, err := os.Open(d.path)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	var (
+		src     io.Reader = f
+		limiter io.Closer
+	)
+	if d.meta.Readlimit > 0 {
+		rr := rateio.NewRateReader(f, d.meta.Readlimit)
+		src, limiter = rr, rr
+	}
+	closeAll := func() {
+		if limiter != nil {
+			limiter.Close()
+		}
+		f.Close()
+	}
+	counter := &countReader{rd: src}
+	rd, err := d.newDecoder(bufio.NewReader(counter))
+	if err != nil {
+		closeAll()
+		return fmt.Errorf("open %s error: %v", d.path, err)
+	}
+	if d.offset > 0 {
+		skipped, err := io.CopyN(ioutil.Discard, rd, d.offset)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			closeAll()
+			return fmt.Errorf("skip %d bytes of %s error: %v", d.offset, d.path, err)
+		}
+		if skipped < d.offset {
+			d.truncated = true
+		}
+	}
+	d.closeFile()
+	d.f, d.limiter, d.counter, d.rd, d.size = f, limiter, counter, rd, fi.Size()
+	return nil
+}
+
+// closeFile 关闭当前打开的文件，调用方负责加锁
+func (d *DecompressedFile) closeFile() error {
+	if d.limiter != nil {
+		d.limiter.Close()
+		d.limiter = nil
+	}
+	if d.f == nil {
+		return nil
+	}
+	err := d.f.Close()
+	d.f = nil
+	return err
+}
+
+func (d *DecompressedFile) newDecoder(r io.Reader) (io.Reader, error) {
+	if d.format == FormatBzip2 {
+		return bzip2.NewReader(r), nil
+	}
+	return gzip.NewReader(r)
+}
+
+func (d *DecompressedFile) Name() string {
+	return "DecompressedFile:" + d.path
+}
+
+func (d *DecompressedFile) Source() string {
+	return d.path
+}
+
+func (d *DecompressedFile) Read(p []byte) (n int, err error) {
+	if atomic.LoadInt32(&d.stopped) > 0 {
+		return 0, errors.New("reader " + d.Name() + " has been exited")
+	}
+	if atomic.LoadInt32(&d.done) > 0 {
+		return 0, io.EOF
+	}
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	if d.truncated {
+		fi, err := os.Stat(d.path)
+		if err != nil || fi.Size() <= d.size {
+			return 0, io.EOF
+		}
+		d.truncated = false
+		if err = d.open(); err != nil {
+			return 0, err
+		}
+		if d.truncated {
+			return 0, io.EOF
+		}
+	}
+	n, err = d.rd.Read(p)
+	d.offset += int64(n)
+	switch err {
+	case io.EOF:
+		if n > 0 {
+			return n, nil
+		}
+		atomic.StoreInt32(&d.done, 1)
+	case io.ErrUnexpectedEOF:
+		d.truncated = true
+		if n > 0 {
+			return n, nil
+		}
+		return 0, io.EOF
+	}
+	return n, err
+}
+
+func (d *DecompressedFile) Close() error {
+	atomic.StoreInt32(&d.stopped, 1)
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	return d.closeFile()
+}
+
+func (d *DecompressedFile) SyncMeta() error {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	if d.offset == d.lastOffset {
+		return nil
+	}
+	if err := d.meta.WriteOffset(d.path, d.offset); err != nil {
+		return err
+	}
+	d.lastOffset = d.offset
+	return nil
+}
+
+// Offset 返回解压后已经读取的字节数
+func (d *DecompressedFile) Offset() int64 {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	return d.offset
+}
+
+// Lag 按压缩文件中还未解压的字节数计算
+func (d *DecompressedFile) Lag() (*LagInfo, error) {
+	if atomic.LoadInt32(&d.done) > 0 {
+		return &LagInfo{SizeUnit: "bytes"}, nil
+	}
+	d.mux.Lock()
+	counter := d.counter
+	d.mux.Unlock()
+	fi, err := os.Stat(d.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &LagInfo{SizeUnit: "bytes"}, nil
+		}
+		return &LagInfo{SizeUnit: "bytes"}, err
+	}
+	lag := fi.Size() - atomic.LoadInt64(&counter.n)
+	if lag < 0 {
+		lag = 0
+	}
+	return &LagInfo{Size: lag, SizeUnit: "bytes"}, nil
+}
+
+func (d *DecompressedFile) ReadDone() bool {
+	return atomic.LoadInt32(&d.done) > 0
+}
+
+var _ reader.FileReader = &DecompressedFile{}
@@ -0,0 +1,125 @@
+package extract
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/reader"
+	. "github.com/qiniu/logkit/reader/config"
+)
+
+func newTestMeta(t *testing.T, dir, path string) *reader.Meta {
+	meta, err := reader.NewMetaWithConf(conf.MapConf{
+		KeyLogPath:  path,
+		KeyMetaPath: filepath.Join(dir, "meta"),
+		KeyMode:     ModeFile,
+	})
+	assert.NoError(t, err)
+	return meta
+}
+
+func gzipData(t *testing.T, data string) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write([]byte(data))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestStreamFormat(t *testing.T) {
+	assert.Equal(t, FormatGzip, StreamFormat("a.log.1.gz"))
+	assert.Equal(t, FormatBzip2, StreamFormat("a.log.1.bz2"))
+	assert.Equal(t, FormatZstd, StreamFormat("a.log.1.zst"))
+	assert.Equal(t, "", StreamFormat("a.tar.gz"))
+	assert.Equal(t, "", StreamFormat("a.log"))
+
+	_, err := NewDecompressedFile(&reader.Meta{}, "a.log.1.zst")
+	assert.Error(t, err)
+}
+
+func TestDecompressedFileResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestDecompressedFileResume")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "a.log.1.gz")
+	content := strings.Repeat("0123456789\n", 100)
+	assert.NoError(t, ioutil.WriteFile(path, gzipData(t, content), 0644))
+	meta := newTestMeta(t, dir, path)
+
+	df, err := NewDecompressedFile(meta, path)
+	assert.NoError(t, err)
+	p := make([]byte, 22)
+	n, err := io.ReadFull(df, p)
+	assert.NoError(t, err)
+	assert.Equal(t, content[:22], string(p[:n]))
+	assert.EqualValues(t, 22, df.Offset())
+	assert.NoError(t, df.SyncMeta())
+	assert.NoError(t, df.Close())
+
+	// 重新打开后从解压后的读取位置继续读取
+	df, err = NewDecompressedFile(meta, path)
+	assert.NoError(t, err)
+	defer df.Close()
+	rest, err := ioutil.ReadAll(df)
+	assert.NoError(t, err)
+	assert.Equal(t, content[22:], string(rest))
+	assert.True(t, df.ReadDone())
+	assert.EqualValues(t, len(content), df.Offset())
+	lag, err := df.Lag()
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, lag.Size)
+}
+
+func TestDecompressedFileGrowing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestDecompressedFileGrowing")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "a.log.1.gz")
+	content := strings.Repeat("abcdefghijklmnopqrstuvwxyz\n", 2000)
+	data := gzipData(t, content)
+	half := len(data) / 2
+	assert.NoError(t, ioutil.WriteFile(path, data[:half], 0644))
+
+	df, err := NewDecompressedFile(newTestMeta(t, dir, path), path)
+	assert.NoError(t, err)
+	defer df.Close()
+	// 压缩文件还在写入时读到不完整的数据按文件末尾处理
+	first, err := ioutil.ReadAll(df)
+	assert.NoError(t, err)
+	assert.False(t, df.ReadDone())
+	assert.True(t, strings.HasPrefix(content, string(first)))
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	assert.NoError(t, err)
+	_, err = f.Write(data[half:])
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+	rest, err := ioutil.ReadAll(df)
+	assert.NoError(t, err)
+	assert.Equal(t, content, string(first)+string(rest))
+	assert.True(t, df.ReadDone())
+}
+
+func TestDecompressedFileBzip2(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestDecompressedFileBzip2")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := "testdata/c.log.bz2"
+	df, err := NewDecompressedFile(newTestMeta(t, dir, path), path)
+	assert.NoError(t, err)
+	defer df.Close()
+	assert.Equal(t, "DecompressedFile:testdata/c.log.bz2", df.Name())
+	data, err := ioutil.ReadAll(df)
+	assert.NoError(t, err)
+	assert.Equal(t, "line1\nline2\nline3\n", string(data))
+}
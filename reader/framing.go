@@ -0,0 +1,141 @@
+package reader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/qiniu/logkit/conf"
+	. "github.com/qiniu/logkit/reader/config"
+)
+
+// MaxRecordSize 按分隔符或长度前缀切分时单条记录的最大字节数
+const MaxRecordSize = 20 * 1024 * 1024
+
+var ErrRecordTooLarge = errors.New("record is too large")
+
+// RecordFraming 按换行以外的方式切分记录：单个或多个字节的分隔符，或者记录前的长度字段
+type RecordFraming struct {
+	delimiter  []byte
+	lengthSize int
+	order      binary.ByteOrder
+}
+
+// NewRecordFraming 根据 record_delimiter 和 record_length_prefix 创建 RecordFraming，
+// 都没有配置或者分隔符就是换行时返回 nil，按行读取
+func NewRecordFraming(c conf.MapConf) (*RecordFraming, error) {
+	lengthPrefix, _ := c.GetStringOr(KeyRecordLengthPrefix, "")
+	if lengthPrefix != "" {
+		f := &RecordFraming{order: binary.BigEndian}
+		switch lengthPrefix {
+		case RecordLengthUint8:
+			f.lengthSize = 1
+		case RecordLengthUint16BE:
+			f.lengthSize = 2
+		case RecordLengthUint16LE:
+			f.lengthSize, f.order = 2, binary.LittleEndian
+		case RecordLengthUint32BE:
+			f.lengthSize = 4
+		case RecordLengthUint32LE:
+			f.lengthSize, f.order = 4, binary.LittleEndian
+		default:
+			return nil, fmt.Errorf("%v %q is not supported", KeyRecordLengthPrefix, lengthPrefix)
+		}
+		return f, nil
+	}
+	delimiter, _ := c.GetStringOr(KeyRecordDelimiter, "")
+	if delimiter == "" {
+		return nil, nil
+	}
+	delim, err := ParseRecordDelimiter(delimiter)
+	if err != nil {
+		return nil, err
+	}
+	if string(delim) == "\n" {
+		return nil, nil
+	}
+	return &RecordFraming{delimiter: delim}, nil
+}
+
+// ParseRecordDelimiter 解析分隔符中的 \0、\n、\r、\t、\\ 和 \xHH 转义
+func ParseRecordDelimiter(s string) ([]byte, error) {
+	var ret []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			ret = append(ret, s[i])
+			continue
+		}
+		if i+1 >= len(s) {
+			return nil, fmt.Errorf("%v %q should not end with a single \\", KeyRecordDelimiter, s)
+		}
+		i++
+		switch s[i] {
+		case '0':
+			ret = append(ret, 0)
+		case 'n':
+			ret = append(ret, '\n')
+		case 'r':
+			ret = append(ret, '\r')
+		case 't':
+			ret = append(ret, '\t')
+		case '\\':
+			ret = append(ret, '\\')
+		case 'x':
+			if i+2 >= len(s) {
+				return nil, fmt.Errorf("%v %q has an incomplete \\x escape", KeyRecordDelimiter, s)
+			}
+			b, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+			if err != nil {
+				return nil, fmt.Errorf("%v %q has an invalid \\x escape", KeyRecordDelimiter, s)
+			}
+			ret = append(ret, byte(b))
+			i += 2
+		default:
+			return nil, fmt.Errorf("%v %q has an unknown escape \\%c", KeyRecordDelimiter, s, s[i])
+		}
+	}
+	return ret, nil
+}
+
+// Split 从 data 中切分出一条记录，返回值的含义与 bufio.SplitFunc 相同，可以直接用于 bufio.Scanner。
+// 数据不完整时返回 advance 为 0，atEOF 为 true 时按分隔符切分的最后一条记录可以没有分隔符
+func (f *RecordFraming) Split(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if f.lengthSize > 0 {
+		if len(data) < f.lengthSize {
+			if atEOF && len(data) > 0 {
+				return 0, nil, io.ErrUnexpectedEOF
+			}
+			return 0, nil, nil
+		}
+		var size uint64
+		switch f.lengthSize {
+		case 1:
+			size = uint64(data[0])
+		case 2:
+			size = uint64(f.order.Uint16(data))
+		case 4:
+			size = uint64(f.order.Uint32(data))
+		}
+		if size > MaxRecordSize {
+			return 0, nil, fmt.Errorf("%v: length prefix %d exceeds %d", ErrRecordTooLarge, size, MaxRecordSize)
+		}
+		end := f.lengthSize + int(size)
+		if len(data) < end {
+			if atEOF {
+				return 0, nil, io.ErrUnexpectedEOF
+			}
+			return 0, nil, nil
+		}
+		return end, data[f.lengthSize:end], nil
+	}
+	if i := bytes.Index(data, f.delimiter); i >= 0 {
+		return i + len(f.delimiter), data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
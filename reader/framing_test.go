@@ -0,0 +1,75 @@
+package reader
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/qiniu/logkit/conf"
+	. "github.com/qiniu/logkit/reader/config"
+)
+
+func TestParseRecordDelimiter(t *testing.T) {
+	tests := map[string]string{
+		`\0`:         "\x00",
+		`\r\n`:       "\r\n",
+		`||`:         "||",
+		`\x1e`:       "\x1e",
+		`a\\b\tc`:    "a\\b\tc",
+		`--\x00\x0A`: "--\x00\n",
+	}
+	for s, exp := range tests {
+		got, err := ParseRecordDelimiter(s)
+		assert.NoError(t, err, s)
+		assert.Equal(t, exp, string(got), s)
+	}
+	for _, s := range []string{`\`, `\x1`, `\xzz`, `\q`} {
+		_, err := ParseRecordDelimiter(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestNewRecordFraming(t *testing.T) {
+	f, err := NewRecordFraming(conf.MapConf{})
+	assert.NoError(t, err)
+	assert.Nil(t, f)
+	f, err = NewRecordFraming(conf.MapConf{KeyRecordDelimiter: `\n`})
+	assert.NoError(t, err)
+	assert.Nil(t, f)
+	_, err = NewRecordFraming(conf.MapConf{KeyRecordLengthPrefix: "uint64"})
+	assert.Error(t, err)
+
+	// 设置长度前缀后忽略分隔符
+	f, err = NewRecordFraming(conf.MapConf{KeyRecordLengthPrefix: RecordLengthUint32LE, KeyRecordDelimiter: `\0`})
+	assert.NoError(t, err)
+	scanner := bufio.NewScanner(strings.NewReader("\x02\x00\x00\x00ab\x01\x00\x00\x00c"))
+	scanner.Split(f.Split)
+	var got []string
+	for scanner.Scan() {
+		got = append(got, scanner.Text())
+	}
+	assert.NoError(t, scanner.Err())
+	assert.Equal(t, []string{"ab", "c"}, got)
+
+	// 长度前缀之后的数据不完整
+	scanner = bufio.NewScanner(strings.NewReader("\x05\x00\x00\x00ab"))
+	scanner.Split(f.Split)
+	assert.False(t, scanner.Scan())
+	assert.Error(t, scanner.Err())
+
+	_, _, err = f.Split([]byte("\xff\xff\xff\xff"), false)
+	assert.Error(t, err)
+
+	// 按分隔符切分时最后一条记录可以没有分隔符
+	f, err = NewRecordFraming(conf.MapConf{KeyRecordDelimiter: `\0`})
+	assert.NoError(t, err)
+	scanner = bufio.NewScanner(strings.NewReader("a\nb\x00c"))
+	scanner.Split(f.Split)
+	got = got[:0]
+	for scanner.Scan() {
+		got = append(got, scanner.Text())
+	}
+	assert.Equal(t, []string{"a\nb", "c"}, got)
+}
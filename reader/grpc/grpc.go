@@ -0,0 +1,432 @@
+package grpc
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/qiniu/log"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/reader"
+	. "github.com/qiniu/logkit/reader/config"
+	. "github.com/qiniu/logkit/utils/models"
+	"github.com/qiniu/logkit/utils/tlspolicy"
+)
+
+var (
+	_ reader.DaemonReader = &Reader{}
+	_ reader.StatsReader  = &Reader{}
+	_ reader.Reader       = &Reader{}
+)
+
+const (
+	methodPush = "/logkit.Ingest/Push"
+	methodSend = "/logkit.Ingest/Send"
+
+	shutdownTimeout = 3 * time.Second
+)
+
+func init() {
+	reader.RegisterConstructor(ModeGRPC, NewReader)
+}
+
+// pending 等待确认的批次，所有的行都被 ReadLine 读出并且 runner 调用 SyncMeta 后关闭 done
+type pending struct {
+	id     uint64
+	source string
+	lines  int
+	// remain 还没有被 ReadLine 读出的行数，只在 ReadLine 所在的协程中访问
+	remain int
+	done   chan struct{}
+}
+
+type readInfo struct {
+	line  string
+	batch *pending
+}
+
+// Reader 以 gRPC 服务的形式接收应用推送的日志，服务定义见 logkit.proto。
+// gRPC 基于 HTTP/2，标准库只在 TLS 连接上协商 HTTP/2，因此必须配置证书
+type Reader struct {
+	meta *reader.Meta
+	// Note: 原子操作，用于表示 reader 整体的运行状态
+	status int32
+
+	address        string
+	maxMessageSize int
+	maxInflight    int
+	tlsConfig      *tls.Config
+
+	listener net.Listener
+	server   *http.Server
+
+	stopChan chan struct{}
+	readChan chan readInfo
+	wg       sync.WaitGroup
+
+	// currentSource 最近一次 ReadLine 读出的数据所属的数据源，只在 ReadLine 所在的协程中访问
+	currentSource string
+
+	// readDone 所有行都已经读出、等待 SyncMeta 确认的批次
+	readDone []*pending
+	doneLock sync.Mutex
+
+	stats     StatsInfo
+	statsLock sync.RWMutex
+}
+
+func NewReader(meta *reader.Meta, c conf.MapConf) (reader.Reader, error) {
+	address, _ := c.GetStringOr(KeyGRPCAddress, DefaultGRPCAddress)
+	maxMessageSize, _ := c.GetIntOr(KeyGRPCMaxMessageSize, DefaultGRPCMaxMessageSize)
+	maxInflight, _ := c.GetIntOr(KeyGRPCMaxInflight, DefaultGRPCMaxInflight)
+	queueSize, _ := c.GetIntOr(KeyGRPCQueueSize, DefaultGRPCQueueSize)
+	certFile, _ := c.GetStringOr(KeyGRPCTLSCert, "")
+	keyFile, _ := c.GetStringOr(KeyGRPCTLSKey, "")
+	caFile, _ := c.GetStringOr(KeyGRPCTLSCA, "")
+
+	if maxMessageSize <= 0 {
+		return nil, fmt.Errorf("%v should be positive", KeyGRPCMaxMessageSize)
+	}
+	if maxInflight <= 0 {
+		return nil, fmt.Errorf("%v should be positive", KeyGRPCMaxInflight)
+	}
+	if queueSize <= 0 {
+		return nil, fmt.Errorf("%v should be positive", KeyGRPCQueueSize)
+	}
+	tlsConfig, err := loadTLSConfig(certFile, keyFile, caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Reader{
+		meta:           meta,
+		status:         StatusInit,
+		address:        address,
+		maxMessageSize: maxMessageSize,
+		maxInflight:    maxInflight,
+		tlsConfig:      tlsConfig,
+		stopChan:       make(chan struct{}),
+		readChan:       make(chan readInfo, queueSize),
+	}, nil
+}
+
+func loadTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("%v and %v are required, gRPC is served over HTTP/2 with TLS", KeyGRPCTLSCert, KeyGRPCTLSKey)
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load certificate %v and key %v error: %v", certFile, keyFile, err)
+	}
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if caFile != "" {
+		ca, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("read %v error: %v", KeyGRPCTLSCA, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificate found in %v %v", KeyGRPCTLSCA, caFile)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlspolicy.Apply(cfg), nil
+}
+
+func (r *Reader) isStopping() bool {
+	return atomic.LoadInt32(&r.status) == StatusStopping
+}
+
+func (r *Reader) hasStopped() bool {
+	return atomic.LoadInt32(&r.status) == StatusStopped
+}
+
+func (r *Reader) Name() string {
+	return "grpc:" + r.address
+}
+
+func (r *Reader) SetMode(mode string, v interface{}) error {
+	return errors.New("grpc reader does not support read mode")
+}
+
+func (r *Reader) setStatsError(err string) {
+	r.statsLock.Lock()
+	defer r.statsLock.Unlock()
+	r.stats.LastError = err
+}
+
+func (r *Reader) Start() error {
+	if r.isStopping() || r.hasStopped() {
+		return errors.New("reader is stopping or has stopped")
+	} else if !atomic.CompareAndSwapInt32(&r.status, StatusInit, StatusRunning) {
+		log.Warnf("Runner[%v] %q daemon has already started and is running", r.meta.RunnerName, r.Name())
+		return nil
+	}
+
+	ln, err := net.Listen("tcp", r.address)
+	if err != nil {
+		atomic.StoreInt32(&r.status, StatusInit)
+		return err
+	}
+	r.listener = ln
+	// ServeTLS 会在 NextProtos 中加入 h2，客户端通过 ALPN 协商使用 HTTP/2
+	r.server = &http.Server{
+		Handler:   r,
+		TLSConfig: r.tlsConfig,
+	}
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		if err := r.server.ServeTLS(ln, "", ""); err != nil && err != http.ErrServerClosed {
+			log.Errorf("Runner[%v] %q serve error: %v", r.meta.RunnerName, r.Name(), err)
+			r.setStatsError(err.Error())
+		}
+	}()
+	log.Infof("Runner[%v] %q daemon has started, listening on %v", r.meta.RunnerName, r.Name(), ln.Addr())
+	return nil
+}
+
+// ServeHTTP 处理 gRPC 请求，请求和响应的消息都以 5 字节的头部分隔，处理结果以 grpc-status 和 grpc-message trailer 返回
+func (r *Reader) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost || req.ProtoMajor != 2 {
+		http.Error(w, "gRPC requires POST over HTTP/2", http.StatusMethodNotAllowed)
+		return
+	}
+	if ct := req.Header.Get("Content-Type"); ct != "application/grpc" && !strings.HasPrefix(ct, "application/grpc+proto") &&
+		!strings.HasPrefix(ct, "application/grpc;") {
+		http.Error(w, "unsupported content type "+ct, http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+
+	var err error
+	switch encoding := req.Header.Get("Grpc-Encoding"); encoding {
+	case "", "identity", "gzip":
+		compressed := encoding == "gzip"
+		switch req.URL.Path {
+		case methodPush:
+			err = r.push(w, req, compressed)
+		case methodSend:
+			err = r.send(w, req, compressed)
+		default:
+			err = newStatusError(codeUnimplemented, "unknown method %v", req.URL.Path)
+		}
+	default:
+		w.Header().Set("Grpc-Accept-Encoding", "identity,gzip")
+		err = newStatusError(codeUnimplemented, "unsupported grpc-encoding %v", encoding)
+	}
+
+	code, msg := codeOK, ""
+	if err != nil {
+		if se, ok := err.(*statusError); ok {
+			code, msg = se.code, se.msg
+		} else {
+			code, msg = codeInternal, err.Error()
+		}
+		if code != codeCanceled {
+			log.Warnf("Runner[%v] %q %v from %v error: %v", r.meta.RunnerName, r.Name(), req.URL.Path, req.RemoteAddr, msg)
+			r.setStatsError(msg)
+		}
+	}
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(code))
+	w.Header().Set(http.TrailerPrefix+"Grpc-Message", encodeGrpcMessage(msg))
+}
+
+// push 处理 Push 流，读取批次在单独的协程中进行，当前协程按顺序等待批次被确认并返回 Ack。
+// 未确认的批次达到 maxInflight 时暂停读取，由 HTTP/2 的流控让客户端的写入阻塞
+func (r *Reader) push(w http.ResponseWriter, req *http.Request, compressed bool) error {
+	flusher, _ := w.(http.Flusher)
+	w.WriteHeader(http.StatusOK)
+	if flusher != nil {
+		flusher.Flush()
+	}
+
+	var (
+		inflight = make(chan *pending, r.maxInflight)
+		readErr  = make(chan error, 1)
+	)
+	// 提前返回时请求的 context 被取消，读取协程随之退出
+	go func() {
+		err := r.readBatches(req, compressed, inflight)
+		close(inflight)
+		readErr <- err
+	}()
+
+	for {
+		var (
+			p  *pending
+			ok bool
+		)
+		select {
+		case p, ok = <-inflight:
+		case <-r.stopChan:
+			return newStatusError(codeUnavailable, "reader is stopping")
+		}
+		if !ok {
+			return <-readErr
+		}
+		if err := r.waitAck(req, p); err != nil {
+			return err
+		}
+		if err := writeMessage(w, ack{id: p.id, accepted: uint32(p.lines)}.marshal()); err != nil {
+			return newStatusError(codeCanceled, "write ack error: %v", err)
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+// readBatches 读取 Push 流中的所有批次，交给 ReadLine 后放入 inflight 等待确认
+func (r *Reader) readBatches(req *http.Request, compressed bool, inflight chan<- *pending) error {
+	for {
+		msg, err := readMessage(req.Body, r.maxMessageSize, compressed)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			if _, ok := err.(*statusError); !ok {
+				err = newStatusError(codeCanceled, "read request error: %v", err)
+			}
+			return err
+		}
+		p, err := r.receive(req, msg)
+		if err != nil {
+			return err
+		}
+		select {
+		case inflight <- p:
+		case <-req.Context().Done():
+			return newStatusError(codeCanceled, "client canceled")
+		}
+	}
+}
+
+// send 处理 Send 请求，批次被确认后返回 Ack
+func (r *Reader) send(w http.ResponseWriter, req *http.Request, compressed bool) error {
+	msg, err := readMessage(req.Body, r.maxMessageSize, compressed)
+	if err == io.EOF {
+		return newStatusError(codeInvalidArgument, "request message is missing")
+	}
+	if err != nil {
+		return err
+	}
+	p, err := r.receive(req, msg)
+	if err != nil {
+		return err
+	}
+	if err = r.waitAck(req, p); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusOK)
+	return writeMessage(w, ack{id: p.id, accepted: uint32(p.lines)}.marshal())
+}
+
+// receive 解析一个批次并逐行放入读取队列，队列满时阻塞
+func (r *Reader) receive(req *http.Request, msg []byte) (*pending, error) {
+	bt, err := unmarshalBatch(msg)
+	if err != nil {
+		return nil, newStatusError(codeInvalidArgument, "unmarshal batch error: %v", err)
+	}
+	source := bt.source
+	if source == "" {
+		source, _, _ = net.SplitHostPort(req.RemoteAddr)
+	}
+	p := &pending{id: bt.id, source: source, lines: len(bt.lines), remain: len(bt.lines), done: make(chan struct{})}
+	if len(bt.lines) == 0 {
+		close(p.done)
+		return p, nil
+	}
+	for _, line := range bt.lines {
+		select {
+		case r.readChan <- readInfo{line: line, batch: p}:
+		case <-r.stopChan:
+			return nil, newStatusError(codeUnavailable, "reader is stopping")
+		case <-req.Context().Done():
+			return nil, newStatusError(codeCanceled, "client canceled")
+		}
+	}
+	return p, nil
+}
+
+// waitAck 等待批次被 runner 发送成功，reader 停止时没有确认的批次需要客户端重新推送
+func (r *Reader) waitAck(req *http.Request, p *pending) error {
+	select {
+	case <-p.done:
+		return nil
+	case <-r.stopChan:
+		return newStatusError(codeUnavailable, "reader is stopping, batch %d is not acknowledged", p.id)
+	case <-req.Context().Done():
+		return newStatusError(codeCanceled, "client canceled")
+	}
+}
+
+func (r *Reader) Source() string {
+	return r.currentSource
+}
+
+func (r *Reader) ReadLine() (string, error) {
+	timer := time.NewTimer(time.Second)
+	defer timer.Stop()
+	select {
+	case info := <-r.readChan:
+		r.currentSource = info.batch.source
+		info.batch.remain--
+		if info.batch.remain == 0 {
+			r.doneLock.Lock()
+			r.readDone = append(r.readDone, info.batch)
+			r.doneLock.Unlock()
+		}
+		return info.line, nil
+	case <-timer.C:
+	}
+
+	return "", nil
+}
+
+func (r *Reader) Status() StatsInfo {
+	r.statsLock.RLock()
+	defer r.statsLock.RUnlock()
+	return r.stats
+}
+
+// SyncMeta 在 runner 发送成功后调用，此时所有行都已经读出的批次可以确认
+func (r *Reader) SyncMeta() {
+	r.doneLock.Lock()
+	defer r.doneLock.Unlock()
+	for _, p := range r.readDone {
+		close(p.done)
+	}
+	r.readDone = nil
+}
+
+func (r *Reader) Close() error {
+	if !atomic.CompareAndSwapInt32(&r.status, StatusRunning, StatusStopping) {
+		log.Warnf("Runner[%v] reader %q is not running, close operation ignored", r.meta.RunnerName, r.Name())
+		return nil
+	}
+	log.Debugf("Runner[%v] %q daemon is stopping", r.meta.RunnerName, r.Name())
+	// 先让等待中的请求返回 UNAVAILABLE，再等待连接上的请求结束，超时后强制关闭连接
+	close(r.stopChan)
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	if err := r.server.Shutdown(ctx); err != nil {
+		r.server.Close()
+	}
+	cancel()
+	r.wg.Wait()
+	atomic.StoreInt32(&r.status, StatusStopped)
+	return nil
+}
@@ -0,0 +1,302 @@
+package grpc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/reader"
+	. "github.com/qiniu/logkit/reader/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+// writeCert 生成自签名的证书，返回证书和私钥的路径
+func writeCert(t *testing.T, dir, name string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	certPath, keyPath := filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	assert.NoError(t, ioutil.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.NoError(t, ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return certPath, keyPath
+}
+
+func newClient(t *testing.T, caPath string, certs ...tls.Certificate) *http.Client {
+	ca, err := ioutil.ReadFile(caPath)
+	assert.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(ca)
+	return &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{RootCAs: pool, Certificates: certs},
+		ForceAttemptHTTP2: true,
+	}}
+}
+
+func marshalBatch(id uint64, source string, lines ...string) []byte {
+	var buf []byte
+	buf = append(buf, 1<<3|proto.WireVarint)
+	buf = append(buf, proto.EncodeVarint(id)...)
+	for _, line := range lines {
+		buf = append(buf, 2<<3|proto.WireBytes)
+		buf = append(buf, proto.EncodeVarint(uint64(len(line)))...)
+		buf = append(buf, line...)
+	}
+	if source != "" {
+		buf = append(buf, 3<<3|proto.WireBytes)
+		buf = append(buf, proto.EncodeVarint(uint64(len(source)))...)
+		buf = append(buf, source...)
+	}
+	return buf
+}
+
+func frame(msg []byte) []byte {
+	var buf bytes.Buffer
+	writeMessage(&buf, msg)
+	return buf.Bytes()
+}
+
+func readAck(t *testing.T, r io.Reader) ack {
+	msg, err := readMessage(r, 1024, false)
+	assert.NoError(t, err)
+	a := ack{}
+	for len(msg) > 0 {
+		key, n := proto.DecodeVarint(msg)
+		v, m := proto.DecodeVarint(msg[n:])
+		msg = msg[n+m:]
+		switch key >> 3 {
+		case 1:
+			a.id = v
+		case 2:
+			a.accepted = uint32(v)
+		}
+	}
+	return a
+}
+
+func newGRPCReader(t *testing.T, c conf.MapConf) *Reader {
+	c[KeyMode] = ModeGRPC
+	c[KeyRunnerName] = "TestGRPCReader"
+	c[KeyGRPCAddress] = "127.0.0.1:0"
+	meta, err := reader.NewMetaWithConf(c)
+	assert.NoError(t, err)
+	rd, err := NewReader(meta, c)
+	assert.NoError(t, err)
+	r := rd.(*Reader)
+	assert.NoError(t, r.Start())
+	return r
+}
+
+func grpcRequest(r *Reader, method string, body io.Reader) *http.Request {
+	req, _ := http.NewRequest(http.MethodPost, "https://"+r.listener.Addr().String()+method, body)
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	return req
+}
+
+func readLines(t *testing.T, r *Reader, n int) []string {
+	var lines []string
+	for i := 0; i < 5 && len(lines) < n; i++ {
+		line, err := r.ReadLine()
+		assert.NoError(t, err)
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func TestGRPCPush(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestGRPCPush")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	certPath, keyPath := writeCert(t, dir, "server")
+	r := newGRPCReader(t, conf.MapConf{KeyGRPCTLSCert: certPath, KeyGRPCTLSKey: keyPath})
+	defer r.Close()
+	client := newClient(t, certPath)
+
+	pr, pw := io.Pipe()
+	respChan := make(chan *http.Response, 1)
+	go func() {
+		resp, err := client.Do(grpcRequest(r, methodPush, pr))
+		assert.NoError(t, err)
+		respChan <- resp
+	}()
+	_, err = pw.Write(frame(marshalBatch(1, "app1", "a", "b")))
+	assert.NoError(t, err)
+	_, err = pw.Write(frame(marshalBatch(2, "", "c")))
+	assert.NoError(t, err)
+	resp := <-respChan
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 2, resp.ProtoMajor)
+	assert.Equal(t, "application/grpc", resp.Header.Get("Content-Type"))
+
+	assert.Equal(t, []string{"a", "b"}, readLines(t, r, 2))
+	assert.Equal(t, "app1", r.Source())
+	// runner 发送成功(SyncMeta)后才确认，只确认所有行都已经读出的批次
+	r.SyncMeta()
+	assert.Equal(t, ack{id: 1, accepted: 2}, readAck(t, resp.Body))
+	assert.Equal(t, []string{"c"}, readLines(t, r, 1))
+	assert.Equal(t, "127.0.0.1", r.Source())
+	ackChan := make(chan ack, 1)
+	go func() {
+		ackChan <- readAck(t, resp.Body)
+	}()
+	select {
+	case a := <-ackChan:
+		t.Fatalf("unexpected ack %v before SyncMeta", a)
+	case <-time.After(200 * time.Millisecond):
+	}
+	r.SyncMeta()
+	assert.Equal(t, ack{id: 2, accepted: 1}, <-ackChan)
+
+	assert.NoError(t, pw.Close())
+	_, err = ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "0", resp.Trailer.Get("Grpc-Status"))
+
+	// reader 停止时空闲的流立即以 UNAVAILABLE 结束
+	pr, pw = io.Pipe()
+	defer pw.Close()
+	resp, err = client.Do(grpcRequest(r, methodPush, pr))
+	assert.NoError(t, err)
+	start := time.Now()
+	assert.NoError(t, r.Close())
+	assert.True(t, time.Since(start) < shutdownTimeout)
+	ioutil.ReadAll(resp.Body)
+	assert.Equal(t, "14", resp.Trailer.Get("Grpc-Status"))
+}
+
+func TestGRPCSend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestGRPCSend")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	certPath, keyPath := writeCert(t, dir, "server")
+	caPath, caKeyPath := writeCert(t, dir, "client")
+	r := newGRPCReader(t, conf.MapConf{KeyGRPCTLSCert: certPath, KeyGRPCTLSKey: keyPath, KeyGRPCTLSCA: caPath,
+		KeyGRPCMaxMessageSize: "64"})
+	defer r.Close()
+	clientCert, err := tls.LoadX509KeyPair(caPath, caKeyPath)
+	assert.NoError(t, err)
+	client := newClient(t, certPath, clientCert)
+
+	// gzip 压缩的消息
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write(marshalBatch(7, "svc", "x", "y"))
+	gw.Close()
+	body := frame(gz.Bytes())
+	body[0] = 1
+	req := grpcRequest(r, methodSend, bytes.NewReader(body))
+	req.Header.Set("Grpc-Encoding", "gzip")
+	respChan := make(chan *http.Response, 1)
+	go func() {
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		respChan <- resp
+	}()
+	assert.Equal(t, []string{"x", "y"}, readLines(t, r, 2))
+	select {
+	case <-respChan:
+		t.Fatal("unexpected response before SyncMeta")
+	case <-time.After(200 * time.Millisecond):
+	}
+	r.SyncMeta()
+	resp := <-respChan
+	assert.Equal(t, ack{id: 7, accepted: 2}, readAck(t, resp.Body))
+	ioutil.ReadAll(resp.Body)
+	assert.Equal(t, "0", resp.Trailer.Get("Grpc-Status"))
+
+	// 超过最大长度
+	resp, err = client.Do(grpcRequest(r, methodSend, bytes.NewReader(frame(marshalBatch(8, "", string(make([]byte, 64)))))))
+	assert.NoError(t, err)
+	ioutil.ReadAll(resp.Body)
+	assert.Equal(t, "8", resp.Trailer.Get("Grpc-Status"))
+
+	// 未知的方法
+	resp, err = client.Do(grpcRequest(r, "/logkit.Ingest/Unknown", bytes.NewReader(nil)))
+	assert.NoError(t, err)
+	ioutil.ReadAll(resp.Body)
+	assert.Equal(t, "12", resp.Trailer.Get("Grpc-Status"))
+
+	// 没有客户端证书
+	_, err = newClient(t, certPath).Do(grpcRequest(r, methodSend, bytes.NewReader(nil)))
+	assert.Error(t, err)
+
+	// reader 停止时未确认的批次返回 UNAVAILABLE
+	go func() {
+		resp, err := client.Do(grpcRequest(r, methodSend, bytes.NewReader(frame(marshalBatch(9, "", "z")))))
+		assert.NoError(t, err)
+		respChan <- resp
+	}()
+	assert.Equal(t, []string{"z"}, readLines(t, r, 1))
+	r.Close()
+	resp = <-respChan
+	ioutil.ReadAll(resp.Body)
+	assert.Equal(t, "14", resp.Trailer.Get("Grpc-Status"))
+}
+
+func TestUnmarshalBatch(t *testing.T) {
+	// 未知的字段被忽略
+	msg := marshalBatch(3, "src", "l1", "l2")
+	msg = append(msg, 4<<3|proto.WireVarint, 1, 5<<3|proto.WireFixed32, 0, 0, 0, 0)
+	bt, err := unmarshalBatch(msg)
+	assert.NoError(t, err)
+	assert.Equal(t, &batch{id: 3, lines: []string{"l1", "l2"}, source: "src"}, bt)
+
+	_, err = unmarshalBatch([]byte{2<<3 | proto.WireBytes, 5, 'a'})
+	assert.Error(t, err)
+	_, err = unmarshalBatch([]byte{2<<3 | proto.WireStartGroup})
+	assert.Error(t, err)
+
+	_, err = readMessage(bytes.NewReader(frame([]byte("abc"))[:6]), 16, false)
+	assert.Error(t, err)
+	_, err = readMessage(bytes.NewReader(nil), 16, false)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, "a%25b%0A", encodeGrpcMessage("a%b\n"))
+}
+
+func TestNewReaderError(t *testing.T) {
+	for _, c := range []conf.MapConf{
+		{},
+		{KeyGRPCTLSCert: "/not/exist.crt", KeyGRPCTLSKey: "/not/exist.key"},
+		{KeyGRPCTLSCert: "a", KeyGRPCTLSKey: "b", KeyGRPCMaxMessageSize: "0"},
+		{KeyGRPCTLSCert: "a", KeyGRPCTLSKey: "b", KeyGRPCMaxInflight: "0"},
+	} {
+		_, err := NewReader(nil, c)
+		assert.Error(t, err, c)
+	}
+}
@@ -0,0 +1,181 @@
+package grpc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/gogo/protobuf/proto"
+)
+
+// gRPC 状态码，见 https://github.com/grpc/grpc/blob/master/doc/statuscodes.md
+const (
+	codeOK                = 0
+	codeCanceled          = 1
+	codeInvalidArgument   = 3
+	codeResourceExhausted = 8
+	codeUnimplemented     = 12
+	codeInternal          = 13
+	codeUnavailable       = 14
+)
+
+// statusError 带有 gRPC 状态码的错误，在 trailer 中返回给客户端
+type statusError struct {
+	code int
+	msg  string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("grpc status %d: %s", e.code, e.msg)
+}
+
+func newStatusError(code int, format string, args ...interface{}) *statusError {
+	return &statusError{code: code, msg: fmt.Sprintf(format, args...)}
+}
+
+// batch 对应 logkit.proto 中的 Batch
+type batch struct {
+	id     uint64
+	lines  []string
+	source string
+}
+
+// ack 对应 logkit.proto 中的 Ack
+type ack struct {
+	id       uint64
+	accepted uint32
+}
+
+// unmarshalBatch 按 protobuf 的编码解析 Batch，忽略未知的字段
+func unmarshalBatch(b []byte) (*batch, error) {
+	bt := &batch{}
+	for len(b) > 0 {
+		key, n := proto.DecodeVarint(b)
+		if n == 0 {
+			return nil, errors.New("invalid field key")
+		}
+		b = b[n:]
+		field, wireType := key>>3, key&7
+		switch wireType {
+		case proto.WireVarint:
+			v, n := proto.DecodeVarint(b)
+			if n == 0 {
+				return nil, fmt.Errorf("invalid varint of field %d", field)
+			}
+			b = b[n:]
+			if field == 1 {
+				bt.id = v
+			}
+		case proto.WireBytes:
+			l, n := proto.DecodeVarint(b)
+			if n == 0 || l > uint64(len(b)-n) {
+				return nil, fmt.Errorf("invalid length of field %d", field)
+			}
+			v := b[n : n+int(l)]
+			b = b[n+int(l):]
+			switch field {
+			case 2:
+				bt.lines = append(bt.lines, string(v))
+			case 3:
+				bt.source = string(v)
+			}
+		case proto.WireFixed64:
+			if len(b) < 8 {
+				return nil, fmt.Errorf("invalid fixed64 of field %d", field)
+			}
+			b = b[8:]
+		case proto.WireFixed32:
+			if len(b) < 4 {
+				return nil, fmt.Errorf("invalid fixed32 of field %d", field)
+			}
+			b = b[4:]
+		default:
+			return nil, fmt.Errorf("unsupported wire type %d of field %d", wireType, field)
+		}
+	}
+	return bt, nil
+}
+
+func (a ack) marshal() []byte {
+	var buf []byte
+	if a.id != 0 {
+		buf = append(buf, 1<<3|proto.WireVarint)
+		buf = append(buf, proto.EncodeVarint(a.id)...)
+	}
+	if a.accepted != 0 {
+		buf = append(buf, 2<<3|proto.WireVarint)
+		buf = append(buf, proto.EncodeVarint(uint64(a.accepted))...)
+	}
+	return buf
+}
+
+// readMessage 读取一个 gRPC 消息：1 字节的压缩标志、4 字节大端的长度以及消息内容，流正常结束时返回 io.EOF
+func readMessage(r io.Reader, maxSize int, compressed bool) ([]byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, newStatusError(codeInvalidArgument, "unexpected EOF in message header")
+		}
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(header[1:])
+	if uint64(size) > uint64(maxSize) {
+		return nil, newStatusError(codeResourceExhausted, "message size %d exceeds limit %d", size, maxSize)
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, newStatusError(codeInvalidArgument, "unexpected EOF in message body")
+		}
+		return nil, err
+	}
+	switch header[0] {
+	case 0:
+		return msg, nil
+	case 1:
+		if !compressed {
+			return nil, newStatusError(codeInternal, "compressed message without grpc-encoding")
+		}
+		gr, err := gzip.NewReader(bytes.NewReader(msg))
+		if err != nil {
+			return nil, newStatusError(codeInternal, "decompress message error: %v", err)
+		}
+		defer gr.Close()
+		msg, err = ioutil.ReadAll(io.LimitReader(gr, int64(maxSize)+1))
+		if err != nil {
+			return nil, newStatusError(codeInternal, "decompress message error: %v", err)
+		}
+		if len(msg) > maxSize {
+			return nil, newStatusError(codeResourceExhausted, "decompressed message size exceeds limit %d", maxSize)
+		}
+		return msg, nil
+	default:
+		return nil, newStatusError(codeInternal, "invalid compressed flag %d", header[0])
+	}
+}
+
+// writeMessage 写入一个不压缩的 gRPC 消息
+func writeMessage(w io.Writer, msg []byte) error {
+	buf := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(buf[1:], uint32(len(msg)))
+	_, err := w.Write(append(buf, msg...))
+	return err
+}
+
+// encodeGrpcMessage 按 gRPC 协议对 grpc-message 做百分号编码
+func encodeGrpcMessage(msg string) string {
+	var buf bytes.Buffer
+	for i := 0; i < len(msg); i++ {
+		c := msg[i]
+		if c >= ' ' && c <= '~' && c != '%' {
+			buf.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&buf, "%%%02X", c)
+	}
+	return buf.String()
+}
@@ -0,0 +1,98 @@
+package reader
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// 推断时最多返回的合并结果条数
+const headPatternPreviewRecords = 5
+
+// HeadPatternSuggestion 根据样例日志推断出的多行日志行首正则
+type HeadPatternSuggestion struct {
+	HeadPattern string   `json:"head_pattern"`
+	Description string   `json:"description"`
+	Total       int      `json:"total"`     // 样例的非空行数
+	Matched     int      `json:"matched"`   // 匹配行首正则的行数，即合并后的日志条数
+	Multiline   bool     `json:"multiline"` // 是否有行需要合并到上一条日志
+	Records     []string `json:"records,omitempty"`
+}
+
+type headCandidate struct {
+	pattern     string
+	description string
+	re          *regexp.Regexp
+}
+
+// headCandidates 按从具体到宽泛的顺序排列，日志中的行首格式一般是固定的，第一个匹配首行的候选即为结果
+var headCandidates = compileHeadCandidates([][2]string{
+	{`^\d{4}[-/]\d{2}[-/]\d{2}[T ]\d{2}:\d{2}:\d{2}`, "行首为日期时间，如 2006-01-02 15:04:05"},
+	{`^\[\d{4}[-/]\d{2}[-/]\d{2}[T ]\d{2}:\d{2}:\d{2}`, "行首为方括号中的日期时间，如 [2006-01-02 15:04:05]"},
+	{`^\d{4}[-/]\d{2}[-/]\d{2}`, "行首为日期，如 2006-01-02"},
+	{`^\d{2}/[A-Z][a-z]{2}/\d{4}:\d{2}:\d{2}:\d{2}`, "行首为 nginx 格式的时间，如 02/Jan/2006:15:04:05"},
+	{`^\[\d{2}/[A-Z][a-z]{2}/\d{4}:\d{2}:\d{2}:\d{2}`, "行首为方括号中 nginx 格式的时间，如 [02/Jan/2006:15:04:05"},
+	{`^[A-Z][a-z]{2} [ \d]\d \d{2}:\d{2}:\d{2}`, "行首为 syslog 格式的时间，如 Jan  2 15:04:05"},
+	{`^\d{2}:\d{2}:\d{2}`, "行首为时间，如 15:04:05"},
+	{`^\[?(TRACE|DEBUG|INFO|WARN|WARNING|ERROR|FATAL|CRITICAL)\b`, "行首为日志级别，如 INFO 或 [ERROR]"},
+	{`^\[`, "行首为左方括号"},
+	{`^\S`, "行首不是空白字符，以空白字符开头的行合并到上一条日志"},
+})
+
+func compileHeadCandidates(candidates [][2]string) []headCandidate {
+	ret := make([]headCandidate, 0, len(candidates))
+	for _, c := range candidates {
+		ret = append(ret, headCandidate{pattern: c[0], description: c[1], re: regexp.MustCompile(c[0])})
+	}
+	return ret
+}
+
+// InferHeadPattern 根据样例日志推断多行日志的行首正则(head_pattern)，
+// 样例中的每个元素可以是一行或者多行，空行会被忽略
+func InferHeadPattern(samples []string) (HeadPatternSuggestion, error) {
+	var lines []string
+	for _, sample := range samples {
+		for _, line := range strings.Split(sample, "\n") {
+			line = strings.TrimRight(line, "\r")
+			if strings.TrimSpace(line) != "" {
+				lines = append(lines, line)
+			}
+		}
+	}
+	if len(lines) == 0 {
+		return HeadPatternSuggestion{}, errors.New("samples are empty")
+	}
+	for _, c := range headCandidates {
+		if !c.re.MatchString(lines[0]) {
+			continue
+		}
+		return c.suggest(lines), nil
+	}
+	return HeadPatternSuggestion{}, errors.New("can not infer head pattern from samples, the first line should start with a timestamp, a log level, a bracket or a non-blank character")
+}
+
+func (c *headCandidate) suggest(lines []string) HeadPatternSuggestion {
+	s := HeadPatternSuggestion{
+		HeadPattern: c.pattern,
+		Description: c.description,
+		Total:       len(lines),
+	}
+	var record []string
+	flush := func() {
+		if len(record) > 0 && len(s.Records) < headPatternPreviewRecords {
+			s.Records = append(s.Records, strings.Join(record, "\n"))
+		}
+		record = record[:0]
+	}
+	for _, line := range lines {
+		if c.re.MatchString(line) {
+			s.Matched++
+			flush()
+		} else {
+			s.Multiline = true
+		}
+		record = append(record, line)
+	}
+	flush()
+	return s
+}
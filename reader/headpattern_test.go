@@ -0,0 +1,65 @@
+package reader
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInferHeadPattern(t *testing.T) {
+	tests := []struct {
+		samples   []string
+		pattern   string
+		matched   int
+		multiline bool
+	}{
+		{
+			samples:   []string{"2018-01-02T15:04:05Z ERROR boom\njava.lang.RuntimeException: boom\n\tat a.b(c.java:1)\nCaused by: x\n2018-01-02T15:04:06Z INFO ok\n"},
+			pattern:   `^\d{4}[-/]\d{2}[-/]\d{2}[T ]\d{2}:\d{2}:\d{2}`,
+			matched:   2,
+			multiline: true,
+		},
+		{
+			samples:   []string{"[2018/01/02 15:04:05] start", "[2018/01/02 15:04:06] stop"},
+			pattern:   `^\[\d{4}[-/]\d{2}[-/]\d{2}[T ]\d{2}:\d{2}:\d{2}`,
+			matched:   2,
+			multiline: false,
+		},
+		{
+			samples:   []string{"Jan  2 15:04:05 host app: a\r\n  b\r\nJan 12 15:04:06 host app: c"},
+			pattern:   `^[A-Z][a-z]{2} [ \d]\d \d{2}:\d{2}:\d{2}`,
+			matched:   2,
+			multiline: true,
+		},
+		{
+			samples:   []string{"", "[ERROR] failed\ndetail", "WARN slow"},
+			pattern:   `^\[?(TRACE|DEBUG|INFO|WARN|WARNING|ERROR|FATAL|CRITICAL)\b`,
+			matched:   2,
+			multiline: true,
+		},
+		{
+			samples:   []string{"Traceback (most recent call last):\n  File \"a.py\", line 1\nValueError: x"},
+			pattern:   `^\S`,
+			matched:   2,
+			multiline: true,
+		},
+	}
+	for _, ti := range tests {
+		got, err := InferHeadPattern(ti.samples)
+		assert.NoError(t, err)
+		assert.Equal(t, ti.pattern, got.HeadPattern)
+		assert.Equal(t, ti.matched, got.Matched)
+		assert.Equal(t, ti.multiline, got.Multiline)
+		assert.Len(t, got.Records, ti.matched)
+	}
+
+	got, err := InferHeadPattern([]string{"2018-01-02 15:04:05 a\n b\n2018-01-02 15:04:06 c"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"2018-01-02 15:04:05 a\n b", "2018-01-02 15:04:06 c"}, got.Records)
+	assert.Equal(t, 3, got.Total)
+
+	_, err = InferHeadPattern([]string{"\n\n"})
+	assert.Error(t, err)
+	_, err = InferHeadPattern([]string{"  indented first line"})
+	assert.Error(t, err)
+}
@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"strings"
 	"sync"
@@ -58,7 +59,9 @@ type Reader struct {
 	paths       []string
 	wg          sync.WaitGroup
 
-	server *http.Server
+	server  *http.Server
+	quotas  *quotaManager // 配置了 API Key 时按 key 校验和限流，为 nil 表示不校验
+	webhook *webhook      // 配置了 webhook 类型时校验签名并按事件读取，为 nil 表示按行读取
 }
 
 func NewReader(meta *reader.Meta, conf conf.MapConf) (reader.Reader, error) {
@@ -76,6 +79,32 @@ func NewReader(meta *reader.Meta, conf conf.MapConf) (reader.Reader, error) {
 	}
 	address, _ = RemoveHttpProtocal(address)
 
+	var quotas *quotaManager
+	apiKeys, _ := conf.GetStringListOr(KeyHTTPAPIKeys, []string{})
+	if len(apiKeys) > 0 {
+		rateLimit, _ := conf.GetInt64Or(KeyHTTPKeyRateLimit, 0)
+		sizeLimit, _ := conf.GetInt64Or(KeyHTTPKeySizeLimit, 0)
+		var err error
+		quotas, err = parseAPIKeys(apiKeys, rateLimit, sizeLimit)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var hook *webhook
+	webhookType, _ := conf.GetStringOr(KeyHTTPWebhookType, "")
+	if webhookType != "" {
+		if quotas != nil {
+			return nil, fmt.Errorf("%v and %v can not be used together", KeyHTTPWebhookType, KeyHTTPAPIKeys)
+		}
+		secret, _ := conf.GetStringOr(KeyHTTPWebhookSecret, "")
+		var err error
+		hook, err = newWebhook(webhookType, secret)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	err := CreateDirIfNotExist(meta.BufFile())
 	if err != nil {
 		return nil, err
@@ -87,6 +116,8 @@ func NewReader(meta *reader.Meta, conf conf.MapConf) (reader.Reader, error) {
 		initErrLock: sync.RWMutex{},
 		address:     address,
 		paths:       paths,
+		quotas:      quotas,
+		webhook:     hook,
 	}, nil
 }
 
@@ -117,20 +148,24 @@ func (r *Reader) Start() error {
 	e := echo.New()
 	for _, path := range r.paths {
 		e.POST(path, r.postData())
+		if r.quotas != nil {
+			e.GET(path, r.getKeyStats())
+		}
 	}
+	e.GET(DefaultHTTPOpenAPIPath, r.getOpenAPI())
 
 	r.server = &http.Server{
 		Handler: e,
 		Addr:    r.address,
 	}
-	go func() {
+	r.meta.Go("http server", func() {
 		if err := r.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			log.Errorf("Runner[%v] %q daemon start HTTP server failed: %v", r.meta.RunnerName, r.Name(), err)
 			r.initErrLock.Lock()
 			r.initErr = err
 			r.initErrLock.Unlock()
 		}
-	}()
+	})
 	log.Infof("Runner[%v] %q daemon has started", r.meta.RunnerName, r.Name())
 	return nil
 }
@@ -179,8 +214,22 @@ func (r *Reader) Close() error {
 	return nil
 }
 
+// KeyStats 返回各个 API Key 的写入统计，未配置 API Key 时返回 nil
+func (r *Reader) KeyStats() map[string]KeyStats {
+	if r.quotas == nil {
+		return nil
+	}
+	return r.quotas.allStats()
+}
+
 func (r *Reader) postData() echo.HandlerFunc {
 	return func(c echo.Context) error {
+		if r.quotas != nil {
+			return r.postBatch(c)
+		}
+		if r.webhook != nil {
+			return r.postWebhook(c)
+		}
 		if err := r.pickUpData(c.Request()); err != nil {
 			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
 		}
@@ -188,24 +237,146 @@ func (r *Reader) postData() echo.HandlerFunc {
 	}
 }
 
-func (r *Reader) pickUpData(req *http.Request) (err error) {
+// postBatch 先读取整个请求再按 key 的配额校验，超出配额时整批拒绝并返回 429，由调用方稍后重试，
+// 单个请求超过每秒配额时重试也无法写入，返回 413，由调用方拆分后重新发送
+func (r *Reader) postBatch(c echo.Context) error {
+	req := c.Request()
+	key := getAPIKey(req)
+	if !r.quotas.isValid(key) {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "invalid api key"})
+	}
+	lines, size, err := r.readBatch(req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	switch err = r.quotas.acquire(key, int64(len(lines)), size, time.Now()); err {
+	case nil:
+	case errBatchTooLarge:
+		log.Debugf("Runner[%v] %q api key %q batch of %d records (%d bytes) is larger than the quota", r.meta.RunnerName, r.Name(), key, len(lines), size)
+		return c.JSON(http.StatusRequestEntityTooLarge, map[string]string{"error": err.Error()})
+	default:
+		log.Debugf("Runner[%v] %q api key %q quota exceeded, %d records rejected", r.meta.RunnerName, r.Name(), key, len(lines))
+		c.Response().Header().Set("Retry-After", "1")
+		return c.JSON(http.StatusTooManyRequests, map[string]string{"error": err.Error()})
+	}
+	for _, line := range lines {
+		if r.isStopping() || r.hasStopped() {
+			break
+		}
+		r.wg.Add(1)
+		r.readChan <- Details{
+			Content: line,
+			Path:    req.RequestURI,
+		}
+	}
+	return c.JSON(http.StatusOK, map[string]string{})
+}
+
+// postWebhook 校验签名后将整个请求体作为一个事件读取，签名错误返回 401
+func (r *Reader) postWebhook(c echo.Context) error {
+	req := c.Request()
+	defer req.Body.Close()
+	reqBody, err := openBody(req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(reqBody, DefaultMaxBodySize+1))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if len(body) > DefaultMaxBodySize {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "the request body is too large"})
+	}
+	if err = r.webhook.verify(req.Header, body); err != nil {
+		log.Warnf("Runner[%v] %q reject %v webhook from %v: %v", r.meta.RunnerName, r.Name(), r.webhook.source, req.RemoteAddr, err)
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": err.Error()})
+	}
+	record, err := r.webhook.record(req.Header, body)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if r.isStopping() || r.hasStopped() {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "reader is stopping"})
+	}
+	r.wg.Add(1)
+	r.readChan <- Details{
+		Content: record,
+		Path:    req.RequestURI,
+	}
+	return c.JSON(http.StatusOK, map[string]string{})
+}
+
+func (r *Reader) getKeyStats() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		stats, ok := r.quotas.stats(getAPIKey(c.Request()))
+		if !ok {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "invalid api key"})
+		}
+		return c.JSON(http.StatusOK, stats)
+	}
+}
+
+func (r *Reader) getOpenAPI() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return c.JSON(http.StatusOK, openAPISpec(r.paths, r.quotas != nil))
+	}
+}
+
+func getAPIKey(req *http.Request) string {
+	if key := req.Header.Get(DefaultHTTPAPIKeyHeader); key != "" {
+		return key
+	}
+	return req.URL.Query().Get("api_key")
+}
+
+func openBody(req *http.Request) (body io.ReadCloser, err error) {
 	if req.ContentLength > DefaultMaxBodySize {
-		return errors.New("the request body is too large")
+		return nil, errors.New("the request body is too large")
 	}
-	reqBody := req.Body
-	defer reqBody.Close()
 	contentEncoding := req.Header.Get(ContentEncodingHeader)
 	contentType := req.Header.Get(ContentTypeHeader)
 	if contentEncoding == "gzip" || contentType == "application/gzip" {
-		reqBody, err = gzip.NewReader(req.Body)
+		body, err = gzip.NewReader(req.Body)
 		if err != nil {
-			return fmt.Errorf("read gzip body error %v", err)
+			return nil, fmt.Errorf("read gzip body error %v", err)
 		}
+		return body, nil
+	}
+	return req.Body, nil
+}
+
+func (r *Reader) pickUpData(req *http.Request) (err error) {
+	defer req.Body.Close()
+	reqBody, err := openBody(req)
+	if err != nil {
+		return err
 	}
 	br := bufio.NewReader(reqBody)
 	return r.storageData(br, req.RequestURI)
 }
 
+func (r *Reader) readBatch(req *http.Request) (lines []string, size int64, err error) {
+	defer req.Body.Close()
+	reqBody, err := openBody(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	br := bufio.NewReader(reqBody)
+	for {
+		line, err := r.readLine(br)
+		if line != "" {
+			lines = append(lines, line)
+			size += int64(len(line))
+		}
+		if err == io.EOF {
+			return lines, size, nil
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+}
+
 func (r *Reader) storageData(br *bufio.Reader, path string) (err error) {
 	for {
 		line, err := r.readLine(br)
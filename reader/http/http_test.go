@@ -3,8 +3,16 @@ package http
 import (
 	"bytes"
 	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
 	"strings"
 	"sync"
@@ -126,6 +134,235 @@ func TestNewHttpReaderWithGzip(t *testing.T) {
 	}
 }
 
+func TestHttpReaderAPIKey(t *testing.T) {
+	readConf := conf.MapConf{
+		KeyMetaPath:   MetaDir,
+		KeyFileDone:   MetaDir,
+		KeyMode:       ModeHTTP,
+		KeyRunnerName: "TestHttpReaderAPIKey",
+	}
+	meta, err := reader.NewMetaWithConf(readConf)
+	assert.NoError(t, err)
+	r, err := NewReader(meta, conf.MapConf{
+		KeyHTTPServiceAddress: "127.0.0.1:7113",
+		KeyHTTPServicePath:    "/logkit/aaa",
+		KeyHTTPAPIKeys:        "team-a:1, team-b",
+		KeyHTTPKeySizeLimit:   "1024",
+	})
+	assert.NoError(t, err)
+	httpReader := r.(*Reader)
+	assert.NoError(t, httpReader.Start())
+	defer func() {
+		os.RemoveAll("./meta")
+		httpReader.Close()
+	}()
+
+	// CI 环境启动监听较慢，需要等待几秒
+	time.Sleep(3 * time.Second)
+
+	post := func(key, body string) int {
+		req, err := http.NewRequest(http.MethodPost, "http://127.0.0.1:7113/logkit/aaa", bytes.NewReader([]byte(body)))
+		assert.NoError(t, err)
+		if key != "" {
+			req.Header.Set(DefaultHTTPAPIKeyHeader, key)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	assert.Equal(t, http.StatusUnauthorized, post("", "abc"))
+	assert.Equal(t, http.StatusUnauthorized, post("team-c", "abc"))
+	// team-a 每秒只允许 1 条，超过每秒配额的请求无法通过重试写入
+	assert.Equal(t, http.StatusRequestEntityTooLarge, post("team-a", "abc\ndef"))
+
+	done := make(chan struct{})
+	go func() {
+		for _, exp := range []string{"abc", "def"} {
+			got, err := httpReader.ReadLine()
+			assert.NoError(t, err)
+			assert.Equal(t, exp, got)
+		}
+		close(done)
+	}()
+	assert.Equal(t, http.StatusOK, post("team-b", "abc\ndef\n"))
+	<-done
+
+	stats := httpReader.KeyStats()
+	assert.EqualValues(t, 1, stats["team-a"].Rejected)
+	assert.EqualValues(t, 2, stats["team-b"].Records)
+	assert.EqualValues(t, 6, stats["team-b"].Bytes)
+	assert.EqualValues(t, 1024, stats["team-b"].SizeLimit)
+
+	resp, err := http.Get("http://127.0.0.1:7113/logkit/aaa?api_key=team-b")
+	assert.NoError(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(body), `"records":2`)
+
+	resp, err = http.Get("http://127.0.0.1:7113" + DefaultHTTPOpenAPIPath)
+	assert.NoError(t, err)
+	body, err = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.NoError(t, err)
+	assert.Contains(t, string(body), DefaultHTTPAPIKeyHeader)
+}
+
+func TestQuotaManager(t *testing.T) {
+	_, err := parseAPIKeys([]string{"a:x"}, 0, 0)
+	assert.Error(t, err)
+	_, err = parseAPIKeys([]string{"a", "a"}, 0, 0)
+	assert.Error(t, err)
+
+	m, err := parseAPIKeys([]string{"a:10:100", "b::5"}, 3, 0)
+	assert.NoError(t, err)
+	now := time.Unix(1000, 0)
+	assert.NoError(t, m.acquire("a", 10, 100, now))
+	assert.Equal(t, errQuotaExceeded, m.acquire("a", 1, 1, now))
+	// 进入下一个窗口后配额恢复
+	assert.NoError(t, m.acquire("a", 1, 1, now.Add(time.Second)))
+
+	assert.NoError(t, m.acquire("b", 3, 5, now))
+	assert.Equal(t, errQuotaExceeded, m.acquire("b", 1, 0, now))
+	assert.Equal(t, errInvalidAPIKey, m.acquire("c", 1, 0, now))
+
+	// 超过每秒配额的批次在空窗口中同样被拒绝，不会一直返回 429
+	later := now.Add(time.Hour)
+	assert.Equal(t, errBatchTooLarge, m.acquire("a", 11, 1, later))
+	assert.Equal(t, errBatchTooLarge, m.acquire("b", 4, 0, later))
+	assert.Equal(t, errBatchTooLarge, m.acquire("b", 1, 6, later))
+	assert.NoError(t, m.acquire("b", 3, 5, later))
+	stats, _ := m.stats("b")
+	assert.EqualValues(t, 3, stats.Rejected)
+}
+
+func TestHttpReaderWebhook(t *testing.T) {
+	readConf := conf.MapConf{
+		KeyMetaPath:   MetaDir,
+		KeyFileDone:   MetaDir,
+		KeyMode:       ModeHTTP,
+		KeyRunnerName: "TestHttpReaderWebhook",
+	}
+	meta, err := reader.NewMetaWithConf(readConf)
+	assert.NoError(t, err)
+	_, err = NewReader(meta, conf.MapConf{KeyHTTPWebhookType: WebhookGitHub})
+	assert.Error(t, err)
+	_, err = NewReader(meta, conf.MapConf{KeyHTTPWebhookType: "bitbucket", KeyHTTPWebhookSecret: "s"})
+	assert.Error(t, err)
+
+	r, err := NewReader(meta, conf.MapConf{
+		KeyHTTPServiceAddress: "127.0.0.1:7114",
+		KeyHTTPServicePath:    "/logkit/hook",
+		KeyHTTPWebhookType:    WebhookGitHub,
+		KeyHTTPWebhookSecret:  "secret",
+	})
+	assert.NoError(t, err)
+	httpReader := r.(*Reader)
+	assert.NoError(t, httpReader.Start())
+	defer func() {
+		os.RemoveAll("./meta")
+		httpReader.Close()
+	}()
+
+	// CI 环境启动监听较慢，需要等待几秒
+	time.Sleep(3 * time.Second)
+
+	body := `{"action":"opened","number":1}`
+	post := func(sig string) int {
+		req, err := http.NewRequest(http.MethodPost, "http://127.0.0.1:7114/logkit/hook", bytes.NewReader([]byte(body)))
+		assert.NoError(t, err)
+		req.Header.Set("X-GitHub-Event", "pull_request")
+		req.Header.Set("X-GitHub-Delivery", "d-1")
+		if sig != "" {
+			req.Header.Set("X-Hub-Signature-256", sig)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	assert.Equal(t, http.StatusUnauthorized, post(""))
+	assert.Equal(t, http.StatusUnauthorized, post("sha256="+hmacHex(sha256.New, "wrong", body)))
+
+	done := make(chan struct{})
+	go func() {
+		got, err := httpReader.ReadLine()
+		assert.NoError(t, err)
+		var event map[string]interface{}
+		assert.NoError(t, json.Unmarshal([]byte(got), &event))
+		assert.Equal(t, "opened", event["action"])
+		assert.Equal(t, WebhookGitHub, event[WebhookSourceField])
+		assert.Equal(t, "pull_request", event[WebhookEventField])
+		assert.Equal(t, "d-1", event[WebhookDeliveryField])
+		close(done)
+	}()
+	assert.Equal(t, http.StatusOK, post("sha256="+hmacHex(sha256.New, "secret", body)))
+	<-done
+}
+
+func TestWebhookVerify(t *testing.T) {
+	body := []byte(`{"object_kind":"pipeline","build":{"phase":"COMPLETED"}}`)
+
+	gitlab, err := newWebhook(WebhookGitLab, "token")
+	assert.NoError(t, err)
+	header := http.Header{}
+	assert.Error(t, gitlab.verify(header, body))
+	header.Set("X-Gitlab-Token", "bad")
+	assert.Equal(t, errInvalidSignature, gitlab.verify(header, body))
+	header.Set("X-Gitlab-Token", "token")
+	header.Set("X-Gitlab-Event", "Pipeline Hook")
+	assert.NoError(t, gitlab.verify(header, body))
+	record, err := gitlab.record(header, body)
+	assert.NoError(t, err)
+	assert.Contains(t, record, `"webhook_event":"pipeline"`)
+	_, err = gitlab.record(header, []byte(`[1]`))
+	assert.Error(t, err)
+
+	// Standard Webhooks 签名
+	key := []byte("0123456789abcdef")
+	signing, err := newWebhook(WebhookGitLab, "whsec_"+base64.StdEncoding.EncodeToString(key))
+	assert.NoError(t, err)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("msg-1.1700000000."))
+	mac.Write(body)
+	header = http.Header{}
+	header.Set("Webhook-Id", "msg-1")
+	header.Set("Webhook-Timestamp", "1700000000")
+	header.Set("Webhook-Signature", "v1,bad v1,"+base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	assert.NoError(t, signing.verify(header, body))
+	header.Set("Webhook-Timestamp", "1700000001")
+	assert.Error(t, signing.verify(header, body))
+
+	jenkins, err := newWebhook(WebhookJenkins, "secret")
+	assert.NoError(t, err)
+	header = http.Header{}
+	header.Set("X-Jenkins-Signature", hmacHex(sha256.New, "secret", string(body)))
+	assert.NoError(t, jenkins.verify(header, body))
+	record, err = jenkins.record(header, body)
+	assert.NoError(t, err)
+	assert.Contains(t, record, `"webhook_event":"completed"`)
+
+	// GitHub 以表单发送时兼容旧的 sha1 签名
+	github, err := newWebhook(WebhookGitHub, "secret")
+	assert.NoError(t, err)
+	form := []byte("payload=" + url.QueryEscape(string(body)))
+	header = http.Header{}
+	header.Set("X-Hub-Signature", "sha1="+hmacHex(sha1.New, "secret", string(form)))
+	header.Set(ContentTypeHeader, "application/x-www-form-urlencoded")
+	assert.NoError(t, github.verify(header, form))
+	record, err = github.record(header, form)
+	assert.NoError(t, err)
+	assert.Contains(t, record, `"object_kind":"pipeline"`)
+}
+
+func hmacHex(h func() hash.Hash, secret, body string) string {
+	mac := hmac.New(h, []byte(secret))
+	mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
 func init() {
 	testData = []string{
 		"1234567890987654321",
@@ -0,0 +1,103 @@
+package http
+
+import (
+	. "github.com/qiniu/logkit/reader/config"
+)
+
+// openAPISpec 生成 http reader 对外提供的接口文档(OpenAPI 3.0)
+func openAPISpec(paths []string, withAPIKey bool) map[string]interface{} {
+	errorResp := func(desc string) map[string]interface{} {
+		return map[string]interface{}{
+			"description": desc,
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{
+					"schema": map[string]interface{}{"$ref": "#/components/schemas/Error"},
+				},
+			},
+		}
+	}
+
+	post := map[string]interface{}{
+		"summary":     "批量写入数据，每行为一条数据",
+		"description": "请求体可以使用 gzip 压缩，需设置 Content-Encoding: gzip 或 Content-Type: application/gzip",
+		"requestBody": map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				"text/plain":       map[string]interface{}{"schema": map[string]interface{}{"type": "string"}},
+				"application/gzip": map[string]interface{}{"schema": map[string]interface{}{"type": "string", "format": "binary"}},
+			},
+		},
+		"responses": map[string]interface{}{
+			"200": map[string]interface{}{"description": "写入成功"},
+			"400": errorResp("请求体无法解析或超过大小限制"),
+		},
+	}
+	components := map[string]interface{}{
+		"schemas": map[string]interface{}{
+			"Error": map[string]interface{}{
+				"type":       "object",
+				"properties": map[string]interface{}{"error": map[string]interface{}{"type": "string"}},
+			},
+		},
+	}
+
+	var get map[string]interface{}
+	if withAPIKey {
+		security := []map[string][]string{{"apiKey": {}}}
+		post["security"] = security
+		responses := post["responses"].(map[string]interface{})
+		responses["401"] = errorResp("API Key 不存在")
+		responses["429"] = errorResp("超出该 API Key 的配额，整批数据未写入，请在 Retry-After 秒后重试")
+		responses["413"] = errorResp("单个请求的条数或字节数超过了该 API Key 每秒的配额，重试也无法写入，请拆分后重新发送")
+		get = map[string]interface{}{
+			"summary":  "查询当前 API Key 的写入统计",
+			"security": security,
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "写入统计",
+					"content": map[string]interface{}{
+						"application/json": map[string]interface{}{
+							"schema": map[string]interface{}{"$ref": "#/components/schemas/KeyStats"},
+						},
+					},
+				},
+				"401": errorResp("API Key 不存在"),
+			},
+		}
+		integer := map[string]interface{}{"type": "integer", "format": "int64"}
+		properties := map[string]interface{}{}
+		for _, field := range []string{"records", "bytes", "requests", "rejected", "last_request",
+			"rate_limit", "size_limit", "window_record", "window_bytes"} {
+			properties[field] = integer
+		}
+		components["schemas"].(map[string]interface{})["KeyStats"] = map[string]interface{}{
+			"type":       "object",
+			"properties": properties,
+		}
+		components["securitySchemes"] = map[string]interface{}{
+			"apiKey": map[string]interface{}{
+				"type": "apiKey",
+				"in":   "header",
+				"name": DefaultHTTPAPIKeyHeader,
+			},
+		}
+	}
+
+	pathItems := make(map[string]interface{}, len(paths))
+	for _, path := range paths {
+		item := map[string]interface{}{"post": post}
+		if get != nil {
+			item["get"] = get
+		}
+		pathItems[path] = item
+	}
+	return map[string]interface{}{
+		"openapi": "3.0.0",
+		"info": map[string]interface{}{
+			"title":   "logkit http reader",
+			"version": "1.0.0",
+		},
+		"paths":      pathItems,
+		"components": components,
+	}
+}
@@ -0,0 +1,148 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KeyStats 单个 API Key 的写入统计
+type KeyStats struct {
+	Records      int64 `json:"records"`       // 已接收的条数
+	Bytes        int64 `json:"bytes"`         // 已接收的字节数
+	Requests     int64 `json:"requests"`      // 成功的请求数
+	Rejected     int64 `json:"rejected"`      // 因超出配额被拒绝的请求数
+	LastRequest  int64 `json:"last_request"`  // 最近一次请求的时间，unix 秒
+	RateLimit    int64 `json:"rate_limit"`    // 每秒条数限制，0 表示不限制
+	SizeLimit    int64 `json:"size_limit"`    // 每秒字节数限制，0 表示不限制
+	WindowRecord int64 `json:"window_record"` // 当前窗口已写入条数
+	WindowBytes  int64 `json:"window_bytes"`  // 当前窗口已写入字节数
+}
+
+var (
+	errInvalidAPIKey = errors.New("invalid api key")
+	errQuotaExceeded = errors.New("quota exceeded")
+	// errBatchTooLarge 单个请求的条数或字节数超过了每秒的配额，重试也无法写入
+	errBatchTooLarge = errors.New("batch is larger than the quota per second")
+)
+
+// keyQuota 以秒为窗口统计单个 key 的写入量
+type keyQuota struct {
+	rateLimit int64
+	sizeLimit int64
+
+	window       int64
+	windowRecord int64
+	windowBytes  int64
+	stats        KeyStats
+}
+
+type quotaManager struct {
+	mux    sync.Mutex
+	quotas map[string]*keyQuota
+}
+
+// parseAPIKeys 解析 key[:每秒条数[:每秒字节数]] 格式的配置，未指定配额的 key 使用默认配额
+func parseAPIKeys(keys []string, defaultRate, defaultSize int64) (*quotaManager, error) {
+	m := &quotaManager{quotas: make(map[string]*keyQuota)}
+	for _, k := range keys {
+		k = strings.TrimSpace(k)
+		if k == "" {
+			continue
+		}
+		parts := strings.Split(k, ":")
+		if len(parts) > 3 || parts[0] == "" {
+			return nil, fmt.Errorf("api key %q is invalid, format should be key[:rate[:size]]", k)
+		}
+		q := &keyQuota{rateLimit: defaultRate, sizeLimit: defaultSize}
+		limits := []*int64{&q.rateLimit, &q.sizeLimit}
+		for i, p := range parts[1:] {
+			if p == "" {
+				continue
+			}
+			limit, err := strconv.ParseInt(p, 10, 64)
+			if err != nil || limit < 0 {
+				return nil, fmt.Errorf("api key %q quota %q is invalid", parts[0], p)
+			}
+			*limits[i] = limit
+		}
+		if _, ok := m.quotas[parts[0]]; ok {
+			return nil, fmt.Errorf("api key %q is duplicated", parts[0])
+		}
+		m.quotas[parts[0]] = q
+	}
+	return m, nil
+}
+
+func (m *quotaManager) isValid(key string) bool {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	_, ok := m.quotas[key]
+	return ok
+}
+
+// acquire 检查 key 在当前窗口内是否还有足够的配额，有则计入，没有则记录一次拒绝。
+// 单个请求超过每秒配额时返回 errBatchTooLarge，超出当前窗口的剩余配额时返回 errQuotaExceeded
+func (m *quotaManager) acquire(key string, records, bytes int64, now time.Time) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	q, ok := m.quotas[key]
+	if !ok {
+		return errInvalidAPIKey
+	}
+	if sec := now.Unix(); sec != q.window {
+		q.window = sec
+		q.windowRecord = 0
+		q.windowBytes = 0
+	}
+	q.stats.LastRequest = now.Unix()
+	if (q.rateLimit > 0 && records > q.rateLimit) || (q.sizeLimit > 0 && bytes > q.sizeLimit) {
+		q.stats.Rejected++
+		return errBatchTooLarge
+	}
+	if (q.rateLimit > 0 && q.windowRecord+records > q.rateLimit) ||
+		(q.sizeLimit > 0 && q.windowBytes+bytes > q.sizeLimit) {
+		q.stats.Rejected++
+		return errQuotaExceeded
+	}
+	q.windowRecord += records
+	q.windowBytes += bytes
+	q.stats.Records += records
+	q.stats.Bytes += bytes
+	q.stats.Requests++
+	return nil
+}
+
+func (m *quotaManager) stats(key string) (KeyStats, bool) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	q, ok := m.quotas[key]
+	if !ok {
+		return KeyStats{}, false
+	}
+	return q.snapshot(), true
+}
+
+func (m *quotaManager) allStats() map[string]KeyStats {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	ret := make(map[string]KeyStats, len(m.quotas))
+	for key, q := range m.quotas {
+		ret[key] = q.snapshot()
+	}
+	return ret
+}
+
+func (q *keyQuota) snapshot() KeyStats {
+	stats := q.stats
+	stats.RateLimit = q.rateLimit
+	stats.SizeLimit = q.sizeLimit
+	if q.window == time.Now().Unix() {
+		stats.WindowRecord = q.windowRecord
+		stats.WindowBytes = q.windowBytes
+	}
+	return stats
+}
@@ -0,0 +1,190 @@
+package http
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"net/url"
+	"strings"
+
+	. "github.com/qiniu/logkit/reader/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+// webhook 事件中添加的字段
+const (
+	WebhookSourceField   = "webhook_source"
+	WebhookEventField    = "webhook_event"
+	WebhookDeliveryField = "webhook_delivery"
+)
+
+var errInvalidSignature = errors.New("invalid webhook signature")
+
+// webhook 按 GitHub、GitLab、Jenkins 的方式校验请求签名，并将事件解析为一条 json 数据
+type webhook struct {
+	source string
+	secret []byte
+}
+
+func newWebhook(source, secret string) (*webhook, error) {
+	source = strings.ToLower(strings.TrimSpace(source))
+	switch source {
+	case WebhookGitHub, WebhookGitLab, WebhookJenkins:
+	default:
+		return nil, fmt.Errorf("%v %q is not supported, should be one of %v, %v, %v", KeyHTTPWebhookType, source, WebhookGitHub, WebhookGitLab, WebhookJenkins)
+	}
+	if secret == "" {
+		return nil, fmt.Errorf("%v can not be empty when %v is %v", KeyHTTPWebhookSecret, KeyHTTPWebhookType, source)
+	}
+	return &webhook{source: source, secret: []byte(secret)}, nil
+}
+
+// verify 校验请求体的签名：
+// GitHub 使用 X-Hub-Signature-256(sha256=hex)，兼容旧的 X-Hub-Signature(sha1=hex)；
+// GitLab 使用 X-Gitlab-Token 携带密钥，或者按 Standard Webhooks 规范使用 webhook-signature 签名；
+// Jenkins 使用 X-Jenkins-Signature 携带 HMAC-SHA256 签名，可以带 sha256= 前缀
+func (w *webhook) verify(header http.Header, body []byte) error {
+	switch w.source {
+	case WebhookGitHub:
+		if sig := header.Get("X-Hub-Signature-256"); sig != "" {
+			return checkHexSignature(sha256.New, w.secret, body, sig, "sha256=")
+		}
+		if sig := header.Get("X-Hub-Signature"); sig != "" {
+			return checkHexSignature(sha1.New, w.secret, body, sig, "sha1=")
+		}
+	case WebhookGitLab:
+		if token := header.Get("X-Gitlab-Token"); token != "" {
+			if subtle.ConstantTimeCompare([]byte(token), w.secret) != 1 {
+				return errInvalidSignature
+			}
+			return nil
+		}
+		if sig := header.Get("Webhook-Signature"); sig != "" {
+			return w.checkStandardSignature(header, body, sig)
+		}
+	case WebhookJenkins:
+		if sig := header.Get("X-Jenkins-Signature"); sig != "" {
+			return checkHexSignature(sha256.New, w.secret, body, strings.TrimPrefix(sig, "sha256="), "")
+		}
+	}
+	return errors.New("webhook signature is missing")
+}
+
+func checkHexSignature(h func() hash.Hash, secret, body []byte, sig, prefix string) error {
+	if !strings.HasPrefix(sig, prefix) {
+		return errInvalidSignature
+	}
+	got, err := hex.DecodeString(strings.TrimPrefix(sig, prefix))
+	if err != nil {
+		return errInvalidSignature
+	}
+	mac := hmac.New(h, secret)
+	mac.Write(body)
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return errInvalidSignature
+	}
+	return nil
+}
+
+// checkStandardSignature 签名内容为 "webhook-id.webhook-timestamp.body"，
+// 密钥带 whsec_ 前缀时去掉前缀后按 base64 解码，webhook-signature 中可能有多个以空格分隔的 v1,签名
+func (w *webhook) checkStandardSignature(header http.Header, body []byte, sig string) error {
+	secret := w.secret
+	if s := string(secret); strings.HasPrefix(s, "whsec_") {
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(s, "whsec_"))
+		if err != nil {
+			return fmt.Errorf("decode %v error: %v", KeyHTTPWebhookSecret, err)
+		}
+		secret = decoded
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(header.Get("Webhook-Id") + "." + header.Get("Webhook-Timestamp") + "."))
+	mac.Write(body)
+	expect := mac.Sum(nil)
+	for _, s := range strings.Fields(sig) {
+		if !strings.HasPrefix(s, "v1,") {
+			continue
+		}
+		got, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(s, "v1,"))
+		if err == nil && hmac.Equal(got, expect) {
+			return nil
+		}
+	}
+	return errInvalidSignature
+}
+
+// record 将事件解析为 json 对象，添加事件来源、事件类型和投递 ID 后重新序列化为一条数据。
+// GitHub 以 application/x-www-form-urlencoded 发送时，事件在 payload 参数中
+func (w *webhook) record(header http.Header, body []byte) (string, error) {
+	if strings.HasPrefix(header.Get(ContentTypeHeader), "application/x-www-form-urlencoded") {
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return "", fmt.Errorf("parse webhook form body error %v", err)
+		}
+		body = []byte(values.Get("payload"))
+	}
+	event := make(map[string]interface{})
+	if err := json.Unmarshal(body, &event); err != nil {
+		return "", fmt.Errorf("webhook payload should be a json object: %v", err)
+	}
+
+	event[WebhookSourceField] = w.source
+	if typ := w.eventType(header, event); typ != "" {
+		event[WebhookEventField] = typ
+	}
+	if id := w.deliveryID(header); id != "" {
+		event[WebhookDeliveryField] = id
+	}
+	ret, err := json.Marshal(event)
+	if err != nil {
+		return "", err
+	}
+	return string(ret), nil
+}
+
+// eventType GitHub 使用 X-GitHub-Event，GitLab 优先使用事件中的 object_kind，Jenkins 优先使用 X-Jenkins-Event
+func (w *webhook) eventType(header http.Header, event map[string]interface{}) string {
+	switch w.source {
+	case WebhookGitHub:
+		return header.Get("X-GitHub-Event")
+	case WebhookGitLab:
+		if kind, ok := event["object_kind"].(string); ok && kind != "" {
+			return kind
+		}
+		return header.Get("X-Gitlab-Event")
+	case WebhookJenkins:
+		if typ := header.Get("X-Jenkins-Event"); typ != "" {
+			return typ
+		}
+		// Notification 插件的事件格式为 {"name":"job","build":{"phase":"COMPLETED",...}}
+		if build, ok := event["build"].(map[string]interface{}); ok {
+			if phase, ok := build["phase"].(string); ok {
+				return strings.ToLower(phase)
+			}
+		}
+	}
+	return ""
+}
+
+func (w *webhook) deliveryID(header http.Header) string {
+	switch w.source {
+	case WebhookGitHub:
+		return header.Get("X-GitHub-Delivery")
+	case WebhookGitLab:
+		if id := header.Get("X-Gitlab-Event-UUID"); id != "" {
+			return id
+		}
+		return header.Get("Webhook-Id")
+	case WebhookJenkins:
+		return header.Get("X-Jenkins-Delivery")
+	}
+	return ""
+}
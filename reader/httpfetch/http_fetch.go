@@ -23,6 +23,7 @@ import (
 	"github.com/qiniu/logkit/reader"
 	. "github.com/qiniu/logkit/reader/config"
 	. "github.com/qiniu/logkit/utils/models"
+	"github.com/qiniu/logkit/utils/tlspolicy"
 )
 
 var (
@@ -113,7 +114,7 @@ func NewReader(meta *reader.Meta, conf conf.MapConf) (reader.Reader, error) {
 			KeepAlive: 30 * time.Second,
 		}).Dial,
 		ResponseHeaderTimeout: time.Duration(respTimeout) * time.Second,
-		TLSClientConfig:       &tls.Config{},
+		TLSClientConfig:       tlspolicy.Apply(&tls.Config{}),
 	}
 
 	var pageNo, pageSize int64
@@ -0,0 +1,569 @@
+package httpfile
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/qiniu/log"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/reader"
+	. "github.com/qiniu/logkit/reader/config"
+	. "github.com/qiniu/logkit/utils/models"
+	"github.com/qiniu/logkit/utils/tlspolicy"
+)
+
+var (
+	_ reader.DaemonReader = &Reader{}
+	_ reader.StatsReader  = &Reader{}
+	_ reader.Reader       = &Reader{}
+)
+
+// ProgressFile 记录每个文件读取进度的 meta 文件名
+const ProgressFile = "httpfile.records"
+
+// 目录索引页面大小的上限，避免异常页面占用过多内存
+const maxIndexSize = 16 * 1024 * 1024
+
+var hrefRegexp = regexp.MustCompile(`(?i)href\s*=\s*["']([^"']+)["']`)
+
+func init() {
+	reader.RegisterConstructor(ModeHTTPFile, NewReader)
+}
+
+// Progress 单个文件的读取进度，Offset 为已经被读取的字节数
+type Progress struct {
+	Offset int64  `json:"offset"`
+	ETag   string `json:"etag,omitempty"`
+	Done   bool   `json:"done,omitempty"`
+}
+
+type readInfo struct {
+	url      string
+	line     string
+	progress Progress
+	// marker 为 true 时只更新进度，没有数据
+	marker bool
+}
+
+type Reader struct {
+	meta *reader.Meta
+	// Note: 原子操作，用于表示 reader 整体的运行状态
+	status int32
+	/*
+		Note: 原子操作，用于表示获取数据的线程运行状态
+
+		- StatusInit: 当前没有任务在执行
+		- StatusRunning: 当前有任务正在执行
+		- StatusStopping: 数据管道已经由上层关闭，执行中的任务完成时直接退出无需再处理
+	*/
+	routineStatus int32
+
+	stopChan chan struct{}
+	readChan chan readInfo
+	errChan  chan error
+
+	stats     StatsInfo
+	statsLock sync.RWMutex
+
+	urls     []string
+	index    string
+	pattern  string
+	interval time.Duration
+	headers  map[string]string
+
+	// progress 为已经被 ReadLine 读取的进度，用于写入 meta
+	progress    map[string]Progress
+	currentURL  string
+	progressMux sync.Mutex
+	// fetched 为已经发送到 readChan 的进度，只在获取数据的线程中使用
+	fetched map[string]Progress
+
+	client *http.Client
+}
+
+func NewReader(meta *reader.Meta, c conf.MapConf) (reader.Reader, error) {
+	urls, _ := c.GetStringListOr(KeyHTTPFileURLs, nil)
+	index, _ := c.GetStringOr(KeyHTTPFileIndex, "")
+	index = strings.TrimSpace(index)
+	if len(urls) == 0 && index == "" {
+		return nil, fmt.Errorf("%v and %v can not both be empty", KeyHTTPFileURLs, KeyHTTPFileIndex)
+	}
+	for i, u := range urls {
+		urls[i] = normalizeURL(u)
+	}
+	if index != "" {
+		index = normalizeURL(index)
+	}
+	pattern, _ := c.GetStringOr(KeyHTTPFilePattern, "*")
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("%v %q is invalid: %v", KeyHTTPFilePattern, pattern, err)
+	}
+	intervalStr, _ := c.GetStringOr(KeyHTTPFileInterval, "3m")
+	interval, err := time.ParseDuration(intervalStr)
+	if err != nil {
+		return nil, err
+	}
+	if interval <= 0 {
+		return nil, fmt.Errorf("%v should be positive", KeyHTTPFileInterval)
+	}
+	timeoutStr, _ := c.GetStringOr(KeyHTTPFileTimeout, "30s")
+	timeout, err := time.ParseDuration(timeoutStr)
+	if err != nil {
+		return nil, err
+	}
+
+	var headers map[string]string
+	headerStr, _ := c.GetStringOr(KeyHTTPFileHeaders, "")
+	if headerStr != "" {
+		if err = json.Unmarshal([]byte(headerStr), &headers); err != nil {
+			return nil, fmt.Errorf("parse %v error: %v", KeyHTTPFileHeaders, err)
+		}
+	}
+
+	progress, err := RestoreProgress(meta.Dir)
+	if err != nil {
+		log.Errorf("Runner[%v] restore %v error %v, read all files from the beginning", meta.RunnerName, ProgressFile, err)
+		progress = make(map[string]Progress)
+	}
+	fetched := make(map[string]Progress, len(progress))
+	for k, v := range progress {
+		fetched[k] = v
+	}
+
+	// 文件内容的下载时间不可预期，只限制建立连接和等待响应头的时间
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		Dial: (&net.Dialer{
+			Timeout:   timeout,
+			KeepAlive: 30 * time.Second,
+		}).Dial,
+		ResponseHeaderTimeout: timeout,
+		TLSClientConfig:       tlspolicy.Apply(nil),
+	}
+
+	return &Reader{
+		meta:          meta,
+		status:        StatusInit,
+		routineStatus: StatusInit,
+		stopChan:      make(chan struct{}),
+		readChan:      make(chan readInfo),
+		errChan:       make(chan error),
+		urls:          urls,
+		index:         index,
+		pattern:       pattern,
+		interval:      interval,
+		headers:       headers,
+		progress:      progress,
+		fetched:       fetched,
+		client:        &http.Client{Transport: transport},
+	}, nil
+}
+
+func normalizeURL(u string) string {
+	u = strings.TrimSpace(u)
+	if !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+		return "http://" + u
+	}
+	return u
+}
+
+func progressPath(dir string) string {
+	return filepath.Join(dir, ProgressFile)
+}
+
+// RestoreProgress 从 meta 中恢复各文件的读取进度，文件不存在时返回空的进度
+func RestoreProgress(dir string) (map[string]Progress, error) {
+	progress := make(map[string]Progress)
+	data, err := ioutil.ReadFile(progressPath(dir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return progress, nil
+		}
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &progress); err != nil {
+		return nil, err
+	}
+	return progress, nil
+}
+
+// WriteProgress 将各文件的读取进度写入 meta
+func WriteProgress(dir string, progress map[string]Progress) error {
+	data, err := json.Marshal(progress)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(progressPath(dir), data, DefaultFilePerm)
+}
+
+func (r *Reader) isStopping() bool {
+	return atomic.LoadInt32(&r.status) == StatusStopping
+}
+
+func (r *Reader) hasStopped() bool {
+	return atomic.LoadInt32(&r.status) == StatusStopped
+}
+
+func (r *Reader) Name() string {
+	if r.index != "" {
+		return "httpfile:" + r.index
+	}
+	return "httpfile:" + strings.Join(r.urls, ",")
+}
+
+func (r *Reader) SetMode(mode string, v interface{}) error {
+	return errors.New("httpfile reader does not support read mode")
+}
+
+func (r *Reader) setStatsError(err string) {
+	r.statsLock.Lock()
+	defer r.statsLock.Unlock()
+	r.stats.LastError = err
+}
+
+func (r *Reader) sendError(err error) {
+	if err == nil {
+		return
+	}
+	defer func() {
+		if rec := recover(); rec != nil {
+			log.Errorf("Reader %q was panicked and recovered from %v", r.Name(), rec)
+		}
+	}()
+	r.errChan <- err
+}
+
+func (r *Reader) Start() error {
+	if r.isStopping() || r.hasStopped() {
+		return errors.New("reader is stopping or has stopped")
+	} else if !atomic.CompareAndSwapInt32(&r.status, StatusInit, StatusRunning) {
+		log.Warnf("Runner[%v] %q daemon has already started and is running", r.meta.RunnerName, r.Name())
+		return nil
+	}
+
+	go func() {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			r.run()
+			select {
+			case <-r.stopChan:
+				atomic.StoreInt32(&r.status, StatusStopped)
+				log.Infof("Runner[%v] %q daemon has stopped from running", r.meta.RunnerName, r.Name())
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	log.Infof("Runner[%v] %q daemon has started", r.meta.RunnerName, r.Name())
+	return nil
+}
+
+func (r *Reader) Source() string {
+	r.progressMux.Lock()
+	defer r.progressMux.Unlock()
+	if r.currentURL != "" {
+		return r.currentURL
+	}
+	if r.index != "" {
+		return r.index
+	}
+	return strings.Join(r.urls, ",")
+}
+
+func (r *Reader) ReadLine() (string, error) {
+	timer := time.NewTimer(time.Second)
+	defer timer.Stop()
+	for {
+		select {
+		case info, ok := <-r.readChan:
+			if !ok {
+				return "", nil
+			}
+			r.progressMux.Lock()
+			r.progress[info.url] = info.progress
+			if !info.marker {
+				r.currentURL = info.url
+			}
+			r.progressMux.Unlock()
+			if info.marker {
+				continue
+			}
+			return info.line, nil
+		case err := <-r.errChan:
+			return "", err
+		case <-timer.C:
+			return "", nil
+		}
+	}
+}
+
+func (r *Reader) Status() StatsInfo {
+	r.statsLock.RLock()
+	defer r.statsLock.RUnlock()
+	return r.stats
+}
+
+func (r *Reader) SyncMeta() {
+	r.progressMux.Lock()
+	defer r.progressMux.Unlock()
+	if err := WriteProgress(r.meta.Dir, r.progress); err != nil {
+		log.Errorf("Runner[%v] %v SyncMeta error %v", r.meta.RunnerName, r.Name(), err)
+	}
+}
+
+func (r *Reader) Close() error {
+	if !atomic.CompareAndSwapInt32(&r.status, StatusRunning, StatusStopping) {
+		log.Warnf("Runner[%v] reader %q is not running, close operation ignored", r.meta.RunnerName, r.Name())
+		return nil
+	}
+	log.Debugf("Runner[%v] %q daemon is stopping", r.meta.RunnerName, r.Name())
+	close(r.stopChan)
+
+	// 如果此时没有 routine 正在运行，则在此处关闭数据管道，否则由 routine 在退出时负责关闭
+	if atomic.CompareAndSwapInt32(&r.routineStatus, StatusInit, StatusStopping) {
+		close(r.readChan)
+		close(r.errChan)
+	}
+	return nil
+}
+
+// run 获取文件列表并依次读取所有未读完的文件，单个文件失败不影响其他文件，下次扫描时从失败的位置继续读取
+func (r *Reader) run() {
+	// 未在准备状态（StatusInit）时无法执行此次任务
+	if !atomic.CompareAndSwapInt32(&r.routineStatus, StatusInit, StatusRunning) {
+		if r.isStopping() || r.hasStopped() {
+			log.Warnf("Runner[%v] %q daemon has stopped, this task does not need to be executed and is skipped this time", r.meta.RunnerName, r.Name())
+		} else {
+			log.Errorf("Runner[%v] %q daemon is still working on last task, this task will not be executed and is skipped this time", r.meta.RunnerName, r.Name())
+		}
+		return
+	}
+	defer func() {
+		// 如果 reader 在 routine 运行时关闭，则需要此 routine 负责关闭数据管道
+		if r.isStopping() || r.hasStopped() {
+			if atomic.CompareAndSwapInt32(&r.routineStatus, StatusRunning, StatusStopping) {
+				close(r.readChan)
+				close(r.errChan)
+			}
+			return
+		}
+		atomic.StoreInt32(&r.routineStatus, StatusInit)
+	}()
+
+	urls, err := r.listURLs()
+	if err != nil {
+		r.onError(fmt.Errorf("list files from %v error: %v", r.index, err))
+	}
+	for _, u := range urls {
+		if r.isStopping() || r.hasStopped() {
+			return
+		}
+		if err := r.fetch(u); err != nil {
+			r.onError(fmt.Errorf("fetch %v error: %v", u, err))
+		}
+	}
+}
+
+func (r *Reader) onError(err error) {
+	log.Errorf("Runner[%v] %q %v", r.meta.RunnerName, r.Name(), err)
+	r.setStatsError(err.Error())
+	r.sendError(err)
+}
+
+// listURLs 返回配置的文件地址以及目录索引中匹配的文件地址，目录索引获取失败时仍返回配置的文件地址
+func (r *Reader) listURLs() ([]string, error) {
+	urls := make([]string, len(r.urls))
+	copy(urls, r.urls)
+	if r.index == "" {
+		return urls, nil
+	}
+	indexed, err := r.listIndex()
+	if err != nil {
+		return urls, err
+	}
+	seen := make(map[string]bool, len(urls))
+	for _, u := range urls {
+		seen[u] = true
+	}
+	for _, u := range indexed {
+		if !seen[u] {
+			seen[u] = true
+			urls = append(urls, u)
+		}
+	}
+	return urls, nil
+}
+
+func (r *Reader) newRequest(rawurl string) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodGet, rawurl, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range r.headers {
+		req.Header.Set(k, v)
+	}
+	return req, nil
+}
+
+// listIndex 解析目录索引页面中的链接，忽略子目录、上级目录以及其他站点的链接
+func (r *Reader) listIndex() ([]string, error) {
+	base, err := url.Parse(r.index)
+	if err != nil {
+		return nil, err
+	}
+	req, err := r.newRequest(r.index)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %v", resp.Status)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxIndexSize))
+	if err != nil {
+		return nil, err
+	}
+	return ParseIndex(base, string(body), r.pattern), nil
+}
+
+// ParseIndex 从目录索引页面中解析出文件名匹配 pattern 的文件地址，按地址排序
+func ParseIndex(base *url.URL, body, pattern string) []string {
+	basePath := base.Path
+	if !strings.HasSuffix(basePath, "/") {
+		basePath = path.Dir(basePath) + "/"
+	}
+	seen := make(map[string]bool)
+	var urls []string
+	for _, match := range hrefRegexp.FindAllStringSubmatch(body, -1) {
+		ref, err := url.Parse(strings.TrimSpace(match[1]))
+		if err != nil {
+			continue
+		}
+		u := base.ResolveReference(ref)
+		u.Fragment = ""
+		if u.Host != base.Host || strings.HasSuffix(u.Path, "/") || !strings.HasPrefix(u.Path, basePath) {
+			continue
+		}
+		name := strings.TrimPrefix(u.Path, basePath)
+		if strings.Contains(name, "/") {
+			continue
+		}
+		if ok, _ := path.Match(pattern, name); !ok {
+			continue
+		}
+		if s := u.String(); !seen[s] {
+			seen[s] = true
+			urls = append(urls, s)
+		}
+	}
+	sort.Strings(urls)
+	return urls
+}
+
+// fetch 从上次读取的位置开始读取文件，读到文件末尾后标记为已读完，之后不再读取
+func (r *Reader) fetch(rawurl string) error {
+	p := r.fetched[rawurl]
+	if p.Done {
+		return nil
+	}
+	req, err := r.newRequest(rawurl)
+	if err != nil {
+		return err
+	}
+	if p.Offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", p.Offset))
+		// 文件被替换时服务端会忽略 Range 返回完整的文件
+		if p.ETag != "" {
+			req.Header.Set("If-Range", p.ETag)
+		}
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	etag := resp.Header.Get("ETag")
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		if etag == "" {
+			etag = p.ETag
+		}
+	case http.StatusOK:
+		if p.Offset > 0 {
+			if p.ETag != "" && etag != "" && etag != p.ETag {
+				log.Warnf("Runner[%v] %q etag of %v changed from %v to %v, read it from the beginning", r.meta.RunnerName, r.Name(), rawurl, p.ETag, etag)
+				p.Offset = 0
+			} else {
+				// 服务端不支持 Range，跳过已经读取的部分
+				if _, err = io.CopyN(ioutil.Discard, resp.Body, p.Offset); err != nil {
+					return fmt.Errorf("skip %d bytes error: %v", p.Offset, err)
+				}
+			}
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		// 已经读到文件末尾
+		p.Done = true
+		r.fetched[rawurl] = p
+		r.send(readInfo{url: rawurl, progress: p, marker: true})
+		return nil
+	default:
+		return fmt.Errorf("unexpected status %v", resp.Status)
+	}
+	p.ETag = etag
+
+	br := bufio.NewReader(resp.Body)
+	for {
+		line, err := br.ReadString('\n')
+		if len(line) > 0 {
+			p.Offset += int64(len(line))
+			r.fetched[rawurl] = p
+			if !r.send(readInfo{url: rawurl, line: strings.TrimRight(line, "\r\n"), progress: p}) {
+				return nil
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	p.Done = true
+	r.fetched[rawurl] = p
+	r.send(readInfo{url: rawurl, progress: p, marker: true})
+	log.Infof("Runner[%v] %q %v has been read done, total %d bytes", r.meta.RunnerName, r.Name(), rawurl, p.Offset)
+	return nil
+}
+
+// send 发送数据，reader 关闭时返回 false
+func (r *Reader) send(info readInfo) bool {
+	select {
+	case <-r.stopChan:
+		return false
+	case r.readChan <- info:
+		return true
+	}
+}
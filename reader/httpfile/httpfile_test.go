@@ -0,0 +1,114 @@
+package httpfile
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/reader"
+	. "github.com/qiniu/logkit/reader/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+func TestParseIndex(t *testing.T) {
+	base, err := url.Parse("http://example.com/logs/")
+	assert.NoError(t, err)
+	body := `<html><body>
+<a href="../">../</a>
+<a href="sub/">sub/</a>
+<a href="a.log">a.log</a>
+<a href='b.log?download=1'>b.log</a>
+<a href="/logs/c.txt">c.txt</a>
+<a href="http://other.com/logs/d.log">d.log</a>
+<a href="a.log#top">a.log</a>
+<a href="?C=M;O=A">Last modified</a>
+</body></html>`
+	assert.Equal(t, []string{"http://example.com/logs/a.log", "http://example.com/logs/b.log?download=1"}, ParseIndex(base, body, "*.log"))
+	assert.Equal(t, []string{"http://example.com/logs/a.log", "http://example.com/logs/b.log?download=1", "http://example.com/logs/c.txt"}, ParseIndex(base, body, "*"))
+}
+
+func TestHTTPFileReader(t *testing.T) {
+	metaDir := "TestHTTPFileReader"
+	defer os.RemoveAll(metaDir)
+
+	content := "line1\nline2\nline3\nline4"
+	etag := `"v1"`
+	ranges := make(chan string, 10)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/logs/", func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(`<a href="a.log">a.log</a><a href="b.txt">b.txt</a>`))
+	})
+	mux.HandleFunc("/logs/a.log", func(w http.ResponseWriter, req *http.Request) {
+		ranges <- req.Header.Get("Range")
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, req, "a.log", time.Time{}, bytes.NewReader([]byte(content)))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	c := conf.MapConf{
+		KeyMetaPath:         metaDir,
+		KeyMode:             ModeHTTPFile,
+		KeyRunnerName:       "TestHTTPFileReader",
+		KeyHTTPFileIndex:    server.URL + "/logs/",
+		KeyHTTPFilePattern:  "*.log",
+		KeyHTTPFileInterval: "1h",
+	}
+	newReader := func() *Reader {
+		meta, err := reader.NewMetaWithConf(c)
+		assert.NoError(t, err)
+		r, err := NewReader(meta, c)
+		assert.NoError(t, err)
+		assert.NoError(t, r.(*Reader).Start())
+		return r.(*Reader)
+	}
+	readLine := func(r *Reader) string {
+		for i := 0; i < 5; i++ {
+			line, err := r.ReadLine()
+			assert.NoError(t, err)
+			if line != "" {
+				return line
+			}
+		}
+		return ""
+	}
+
+	r := newReader()
+	assert.Equal(t, "line1", readLine(r))
+	assert.Equal(t, "line2", readLine(r))
+	assert.Equal(t, server.URL+"/logs/a.log", r.Source())
+	r.SyncMeta()
+	assert.NoError(t, r.Close())
+	assert.Equal(t, "", <-ranges)
+
+	// 重启后通过 Range 请求从上次读取的位置继续
+	r = newReader()
+	assert.Equal(t, "line3", readLine(r))
+	assert.Equal(t, "line4", readLine(r))
+	assert.Equal(t, "bytes=12-", <-ranges)
+	line, err := r.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "", line)
+	r.SyncMeta()
+	assert.NoError(t, r.Close())
+
+	progress, err := RestoreProgress(metaDir)
+	assert.NoError(t, err)
+	assert.Equal(t, Progress{Offset: int64(len(content)), ETag: etag, Done: true}, progress[server.URL+"/logs/a.log"])
+
+	// 文件被替换后 ETag 变化，从头开始读取
+	etag = `"v2"`
+	progress[server.URL+"/logs/a.log"] = Progress{Offset: 6, ETag: `"v1"`}
+	assert.NoError(t, WriteProgress(metaDir, progress))
+	r = newReader()
+	assert.Equal(t, "line1", readLine(r))
+	assert.Equal(t, "bytes=6-", <-ranges)
+	assert.NoError(t, r.Close())
+}
@@ -0,0 +1,474 @@
+package journald
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/json-iterator/go"
+
+	"github.com/qiniu/log"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/reader"
+	. "github.com/qiniu/logkit/reader/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+var (
+	_ reader.DaemonReader = &Reader{}
+	_ reader.StatsReader  = &Reader{}
+	_ reader.DataReader   = &Reader{}
+	_ reader.Reader       = &Reader{}
+)
+
+// 数据中的字段，日志中不以下划线开头的其他字段(如 CODE_FILE 以及应用自定义的字段)以小写的字段名添加，与下列字段重名时忽略
+const (
+	FieldMessage    = "message"
+	FieldUnit       = "unit"
+	FieldPriority   = "priority"
+	FieldLevel      = "level"
+	FieldHostname   = "hostname"
+	FieldTimestamp  = "timestamp"
+	FieldIdentifier = "identifier"
+	FieldPid        = "pid"
+	FieldComm       = "comm"
+	FieldBootID     = "boot_id"
+	FieldTransport  = "transport"
+)
+
+const (
+	DefaultJournalctl = "journalctl"
+
+	// journalctl 异常退出后重新启动的间隔
+	restartInterval = 3 * time.Second
+)
+
+var levels = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// journal 中的字段与数据中字段的对应关系，单元优先使用 _SYSTEMD_UNIT，systemd 自身关于单元的日志使用 UNIT
+var fieldMapping = []struct {
+	journal []string
+	field   string
+}{
+	{[]string{"MESSAGE"}, FieldMessage},
+	{[]string{"_SYSTEMD_UNIT", "UNIT", "_SYSTEMD_USER_UNIT", "USER_UNIT"}, FieldUnit},
+	{[]string{"_HOSTNAME"}, FieldHostname},
+	{[]string{"SYSLOG_IDENTIFIER"}, FieldIdentifier},
+	{[]string{"_PID"}, FieldPid},
+	{[]string{"_COMM"}, FieldComm},
+	{[]string{"_BOOT_ID"}, FieldBootID},
+	{[]string{"_TRANSPORT"}, FieldTransport},
+}
+
+func init() {
+	reader.RegisterConstructor(ModeJournald, NewReader)
+}
+
+type readInfo struct {
+	data   Data
+	cursor string
+	bytes  int64
+}
+
+type Reader struct {
+	meta *reader.Meta
+	// Note: 原子操作，用于表示 reader 整体的运行状态
+	status int32
+
+	stopChan chan struct{}
+	readChan chan readInfo
+	errChan  chan error
+
+	stats     StatsInfo
+	statsLock sync.RWMutex
+
+	command   string
+	units     []string
+	priority  string
+	directory string
+	whence    string
+
+	cmdLock sync.Mutex
+	cmd     *exec.Cmd
+
+	// cursor 最近一条已经被上层读取的日志位置，记录在 meta 中
+	cursorLock sync.RWMutex
+	cursor     string
+	// sentCursor 最近一条已经放入 readChan 的日志位置，journalctl 重启后从这里继续读取，只在 run 中使用
+	sentCursor string
+}
+
+// validPriority 检查优先级，可以是级别名称或者 0-7 的数字，也可以是以 .. 连接的范围
+func validPriority(priority string) bool {
+	parts := strings.Split(priority, "..")
+	if len(parts) > 2 {
+		return false
+	}
+	for _, p := range parts {
+		if n, err := strconv.Atoi(p); err == nil {
+			if n < 0 || n >= len(levels) {
+				return false
+			}
+			continue
+		}
+		if _, ok := levelPriority(p); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func levelPriority(level string) (int, bool) {
+	for i, l := range levels {
+		if strings.EqualFold(l, level) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func NewReader(meta *reader.Meta, c conf.MapConf) (reader.Reader, error) {
+	command, _ := c.GetStringOr(KeyJournaldCommand, DefaultJournalctl)
+	units, _ := c.GetStringListOr(KeyJournaldUnits, []string{})
+	priority, _ := c.GetStringOr(KeyJournaldPriority, "")
+	directory, _ := c.GetStringOr(KeyJournaldDirectory, "")
+	whence, _ := c.GetStringOr(KeyWhence, WhenceOldest)
+	if whence != WhenceOldest && whence != WhenceNewest {
+		return nil, fmt.Errorf("%v should be %v or %v", KeyWhence, WhenceOldest, WhenceNewest)
+	}
+	if priority != "" && !validPriority(priority) {
+		return nil, fmt.Errorf("%v %q is invalid, should be one of %v, 0-7, or a range like err..warning", KeyJournaldPriority, priority, levels)
+	}
+	if _, err := exec.LookPath(command); err != nil {
+		return nil, fmt.Errorf("%v %q not found: %v", KeyJournaldCommand, command, err)
+	}
+
+	r := &Reader{
+		meta:      meta,
+		status:    StatusInit,
+		stopChan:  make(chan struct{}),
+		readChan:  make(chan readInfo, 1000),
+		errChan:   make(chan error),
+		command:   command,
+		priority:  priority,
+		directory: directory,
+		whence:    whence,
+	}
+	for _, unit := range units {
+		if unit = strings.TrimSpace(unit); unit != "" {
+			r.units = append(r.units, unit)
+		}
+	}
+	// journal 的位置在机器重启后依然有效，不需要区分开机 id
+	if cursor, _, err := meta.ReadOffset(); err == nil && cursor != "" {
+		r.cursor = cursor
+		r.sentCursor = cursor
+	}
+	return r, nil
+}
+
+func (r *Reader) isStopping() bool {
+	return atomic.LoadInt32(&r.status) == StatusStopping
+}
+
+func (r *Reader) hasStopped() bool {
+	return atomic.LoadInt32(&r.status) == StatusStopped
+}
+
+func (r *Reader) Name() string {
+	if r.directory != "" {
+		return "journald:" + r.directory
+	}
+	return "journald"
+}
+
+func (r *Reader) SetMode(mode string, v interface{}) error {
+	return errors.New("journald reader does not support read mode")
+}
+
+func (r *Reader) setStatsError(err string) {
+	r.statsLock.Lock()
+	defer r.statsLock.Unlock()
+	r.stats.LastError = err
+}
+
+func (r *Reader) sendError(err error) {
+	if err == nil {
+		return
+	}
+	defer func() {
+		if rec := recover(); rec != nil {
+			log.Errorf("Reader %q was panicked and recovered from %v", r.Name(), rec)
+		}
+	}()
+	select {
+	case r.errChan <- err:
+	case <-r.stopChan:
+	}
+}
+
+// args 返回 journalctl 的参数，有读取记录时从记录的位置之后继续读取
+func (r *Reader) args() []string {
+	args := []string{"--output=json", "--follow", "--no-pager", "--quiet"}
+	if r.sentCursor != "" {
+		args = append(args, "--after-cursor="+r.sentCursor)
+	} else if r.whence == WhenceNewest {
+		args = append(args, "--lines=0")
+	} else {
+		args = append(args, "--no-tail")
+	}
+	for _, unit := range r.units {
+		args = append(args, "--unit="+unit)
+	}
+	if r.priority != "" {
+		args = append(args, "--priority="+r.priority)
+	}
+	if r.directory != "" {
+		args = append(args, "--directory="+r.directory)
+	}
+	return args
+}
+
+func (r *Reader) Start() error {
+	if r.isStopping() || r.hasStopped() {
+		return errors.New("reader is stopping or has stopped")
+	}
+	if !atomic.CompareAndSwapInt32(&r.status, StatusInit, StatusRunning) {
+		log.Warnf("Runner[%v] %q daemon has already started and is running", r.meta.RunnerName, r.Name())
+		return nil
+	}
+
+	go r.run()
+	log.Infof("Runner[%v] %q daemon has started", r.meta.RunnerName, r.Name())
+	return nil
+}
+
+func (r *Reader) run() {
+	defer func() {
+		atomic.StoreInt32(&r.status, StatusStopped)
+		log.Infof("Runner[%v] %q daemon has stopped from running", r.meta.RunnerName, r.Name())
+	}()
+
+	for {
+		err := r.follow()
+		if r.isStopping() || r.hasStopped() {
+			return
+		}
+		if err == nil {
+			err = errors.New("journalctl exited unexpectedly")
+		}
+		err = fmt.Errorf("%v, restart in %v", err, restartInterval)
+		log.Errorf("Runner[%v] %q %v", r.meta.RunnerName, r.Name(), err)
+		r.setStatsError(err.Error())
+		r.sendError(err)
+		select {
+		case <-r.stopChan:
+			return
+		case <-time.After(restartInterval):
+		}
+	}
+}
+
+// follow 启动 journalctl 并持续读取输出，直到 journalctl 退出或者 reader 被关闭
+func (r *Reader) follow() error {
+	cmd := exec.Command(r.command, r.args()...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	r.cmdLock.Lock()
+	if r.isStopping() || r.hasStopped() {
+		r.cmdLock.Unlock()
+		return nil
+	}
+	if err = cmd.Start(); err != nil {
+		r.cmdLock.Unlock()
+		return fmt.Errorf("start %v error: %v", r.command, err)
+	}
+	r.cmd = cmd
+	r.cmdLock.Unlock()
+
+	readErr := r.readEntries(stdout)
+	// 读取中断时结束 journalctl，避免阻塞在写入
+	cmd.Process.Kill()
+	waitErr := cmd.Wait()
+	if readErr != nil {
+		return readErr
+	}
+	if waitErr != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%v: %v", waitErr, TruncateStrSize(msg, DefaultTruncateMaxSize))
+		}
+		return waitErr
+	}
+	return nil
+}
+
+func (r *Reader) readEntries(stdout io.Reader) error {
+	br := bufio.NewReader(stdout)
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			data, cursor, parseErr := ParseEntry(line)
+			if parseErr != nil {
+				log.Errorf("Runner[%v] %q %v", r.meta.RunnerName, r.Name(), parseErr)
+				r.setStatsError(parseErr.Error())
+			} else {
+				select {
+				case <-r.stopChan:
+					return nil
+				case r.readChan <- readInfo{data: data, cursor: cursor, bytes: int64(len(line))}:
+					r.sentCursor = cursor
+				}
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			if r.isStopping() || r.hasStopped() {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// journalValue 将 journalctl 输出的字段值转换为字符串，非 UTF-8 的值输出为字节数组，同名的多个值输出为数组
+func journalValue(v interface{}) interface{} {
+	arr, ok := v.([]interface{})
+	if !ok {
+		return v
+	}
+	buf := make([]byte, 0, len(arr))
+	for _, b := range arr {
+		n, ok := b.(float64)
+		if !ok {
+			values := make([]interface{}, 0, len(arr))
+			for _, item := range arr {
+				values = append(values, journalValue(item))
+			}
+			return values
+		}
+		buf = append(buf, byte(n))
+	}
+	return string(buf)
+}
+
+// ParseEntry 解析 journalctl --output=json 输出的一条日志，返回转换后的数据以及日志的位置
+func ParseEntry(line []byte) (Data, string, error) {
+	var entry map[string]interface{}
+	if err := jsoniter.Unmarshal(line, &entry); err != nil {
+		return nil, "", fmt.Errorf("invalid journal entry %q: %v", TruncateStrSize(string(line), 256), err)
+	}
+	cursor, _ := entry["__CURSOR"].(string)
+	if cursor == "" {
+		return nil, "", fmt.Errorf("journal entry %q has no __CURSOR", TruncateStrSize(string(line), 256))
+	}
+	data := make(Data, len(fieldMapping)+4)
+	for _, m := range fieldMapping {
+		for _, key := range m.journal {
+			if v, ok := entry[key]; ok {
+				data[m.field] = journalValue(v)
+				break
+			}
+		}
+	}
+	if pid, ok := data[FieldPid].(string); ok {
+		if n, err := strconv.ParseInt(pid, 10, 64); err == nil {
+			data[FieldPid] = n
+		}
+	}
+	if p, ok := entry["PRIORITY"].(string); ok {
+		if n, err := strconv.Atoi(p); err == nil && n >= 0 && n < len(levels) {
+			data[FieldPriority] = n
+			data[FieldLevel] = levels[n]
+		}
+	}
+	if ts, ok := entry["__REALTIME_TIMESTAMP"].(string); ok {
+		if us, err := strconv.ParseInt(ts, 10, 64); err == nil {
+			data[FieldTimestamp] = time.Unix(0, us*int64(time.Microsecond)).Format(time.RFC3339Nano)
+		}
+	}
+	// 以下划线开头的是 journald 添加的可信字段，只保留上面转换过的字段
+	for k, v := range entry {
+		if strings.HasPrefix(k, "_") || k == "MESSAGE" || k == "PRIORITY" {
+			continue
+		}
+		k = strings.ToLower(k)
+		if _, ok := data[k]; !ok {
+			data[k] = journalValue(v)
+		}
+	}
+	return data, cursor, nil
+}
+
+func (r *Reader) Source() string {
+	return r.Name()
+}
+
+func (r *Reader) ReadLine() (string, error) {
+	return "", errors.New("method ReadLine is not supported, please use ReadData")
+}
+
+func (r *Reader) ReadData() (Data, int64, error) {
+	timer := time.NewTimer(time.Second)
+	defer timer.Stop()
+	select {
+	case info := <-r.readChan:
+		r.cursorLock.Lock()
+		r.cursor = info.cursor
+		r.cursorLock.Unlock()
+		return info.data, info.bytes, nil
+	case err := <-r.errChan:
+		return nil, 0, err
+	case <-timer.C:
+	}
+
+	return nil, 0, nil
+}
+
+func (r *Reader) Status() StatsInfo {
+	r.statsLock.RLock()
+	defer r.statsLock.RUnlock()
+	return r.stats
+}
+
+func (r *Reader) SyncMeta() {
+	r.cursorLock.RLock()
+	cursor := r.cursor
+	r.cursorLock.RUnlock()
+	if cursor == "" {
+		return
+	}
+	if err := r.meta.WriteOffset(cursor, 0); err != nil {
+		log.Errorf("Runner[%v] %v SyncMeta error %v", r.meta.RunnerName, r.Name(), err)
+	}
+}
+
+func (r *Reader) Close() error {
+	if !atomic.CompareAndSwapInt32(&r.status, StatusRunning, StatusStopping) {
+		log.Warnf("Runner[%v] reader %q is not running, close operation ignored", r.meta.RunnerName, r.Name())
+		return nil
+	}
+	log.Debugf("Runner[%v] %q daemon is stopping", r.meta.RunnerName, r.Name())
+	close(r.stopChan)
+	// 结束 journalctl 以打断阻塞中的读取
+	r.cmdLock.Lock()
+	defer r.cmdLock.Unlock()
+	if r.cmd != nil && r.cmd.Process != nil {
+		r.cmd.Process.Kill()
+	}
+	return nil
+}
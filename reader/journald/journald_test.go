@@ -0,0 +1,135 @@
+package journald
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/reader"
+	. "github.com/qiniu/logkit/reader/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const testEntries = `{"__CURSOR":"s=1;i=1","__REALTIME_TIMESTAMP":"1514764800000000","PRIORITY":"6","_HOSTNAME":"host-1","_SYSTEMD_UNIT":"nginx.service","SYSLOG_IDENTIFIER":"nginx","_PID":"42","MESSAGE":"started"}
+{"__CURSOR":"s=1;i=2","__REALTIME_TIMESTAMP":"1514764801000000","PRIORITY":"3","_HOSTNAME":"host-1","_SYSTEMD_UNIT":"nginx.service","MESSAGE":"failed","REQUEST_ID":"abc"}
+`
+
+func TestParseEntry(t *testing.T) {
+	data, cursor, err := ParseEntry([]byte(`{"__CURSOR":"s=1;i=1","__REALTIME_TIMESTAMP":"1514764800000000","__MONOTONIC_TIMESTAMP":"1","PRIORITY":"3",
+		"_HOSTNAME":"host-1","_SYSTEMD_UNIT":"nginx.service","_PID":"42","_UID":"0","SYSLOG_IDENTIFIER":"nginx",
+		"MESSAGE":[104,105,255],"CODE_FILE":"main.c","TAG":["a","b"],"HOSTNAME":"ignored"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "s=1;i=1", cursor)
+	assert.Equal(t, Data{
+		FieldMessage:        "hi\xff",
+		FieldUnit:           "nginx.service",
+		FieldHostname:       "host-1",
+		FieldIdentifier:     "nginx",
+		FieldPid:            int64(42),
+		FieldPriority:       3,
+		FieldLevel:          "err",
+		FieldTimestamp:      time.Unix(1514764800, 0).Format(time.RFC3339Nano),
+		"code_file":         "main.c",
+		"tag":               []interface{}{"a", "b"},
+		"syslog_identifier": "nginx",
+	}, data)
+
+	// systemd 自身关于单元的日志使用 UNIT 字段
+	data, _, err = ParseEntry([]byte(`{"__CURSOR":"c","_SYSTEMD_UNIT":"init.scope","UNIT":"nginx.service","MESSAGE":"Started nginx"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "init.scope", data[FieldUnit])
+	data, _, err = ParseEntry([]byte(`{"__CURSOR":"c","UNIT":"nginx.service","MESSAGE":"Started nginx"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "nginx.service", data[FieldUnit])
+
+	_, _, err = ParseEntry([]byte(`not json`))
+	assert.Error(t, err)
+	_, _, err = ParseEntry([]byte(`{"MESSAGE":"no cursor"}`))
+	assert.Error(t, err)
+}
+
+func TestValidPriority(t *testing.T) {
+	for _, p := range []string{"err", "WARNING", "3", "0..4", "emerg..err"} {
+		assert.True(t, validPriority(p), p)
+	}
+	for _, p := range []string{"8", "-1", "error", "1..2..3", ""} {
+		assert.False(t, validPriority(p), p)
+	}
+}
+
+func TestJournaldReader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journald")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// 模拟 journalctl 输出日志后继续等待新的日志
+	entries := filepath.Join(dir, "entries")
+	argsFile := filepath.Join(dir, "args")
+	command := filepath.Join(dir, "journalctl")
+	assert.NoError(t, ioutil.WriteFile(entries, []byte(testEntries), 0644))
+	assert.NoError(t, ioutil.WriteFile(command, []byte("#!/bin/sh\necho \"$@\" >> "+argsFile+"\ncat "+entries+"\nexec sleep 60\n"), 0755))
+
+	c := conf.MapConf{
+		KeyMetaPath:         filepath.Join(dir, "meta"),
+		KeyMode:             ModeJournald,
+		KeyRunnerName:       "TestJournaldReader",
+		KeyJournaldCommand:  command,
+		KeyJournaldUnits:    "nginx.service, sshd.service",
+		KeyJournaldPriority: "info",
+	}
+	newReader := func() *Reader {
+		meta, err := reader.NewMetaWithConf(c)
+		assert.NoError(t, err)
+		r, err := NewReader(meta, c)
+		assert.NoError(t, err)
+		assert.NoError(t, r.(*Reader).Start())
+		return r.(*Reader)
+	}
+
+	r := newReader()
+	var datas []Data
+	for i := 0; i < 10 && len(datas) < 2; i++ {
+		data, _, err := r.ReadData()
+		assert.NoError(t, err)
+		if data != nil {
+			datas = append(datas, data)
+		}
+	}
+	if assert.Len(t, datas, 2) {
+		assert.Equal(t, "started", datas[0][FieldMessage])
+		assert.Equal(t, "info", datas[0][FieldLevel])
+		assert.Equal(t, "failed", datas[1][FieldMessage])
+		assert.Equal(t, "abc", datas[1]["request_id"])
+	}
+	r.SyncMeta()
+	assert.NoError(t, r.Close())
+
+	// 重启后从记录的位置之后继续读取
+	r = newReader()
+	for i := 0; i < 20; i++ {
+		args, _ := ioutil.ReadFile(argsFile)
+		if strings.Count(string(args), "\n") >= 2 {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	assert.NoError(t, r.Close())
+	args, err := ioutil.ReadFile(argsFile)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(args)), "\n")
+	if assert.Len(t, lines, 2) {
+		assert.Equal(t, "--output=json --follow --no-pager --quiet --no-tail --unit=nginx.service --unit=sshd.service --priority=info", lines[0])
+		assert.Equal(t, "--output=json --follow --no-pager --quiet --after-cursor=s=1;i=2 --unit=nginx.service --unit=sshd.service --priority=info", lines[1])
+	}
+
+	_, err = NewReader(nil, conf.MapConf{KeyJournaldCommand: command, KeyJournaldPriority: "verbose"})
+	assert.Error(t, err)
+	_, err = NewReader(nil, conf.MapConf{KeyJournaldCommand: filepath.Join(dir, "not_exist")})
+	assert.Error(t, err)
+}
@@ -0,0 +1,374 @@
+package kafka
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/qiniu/log"
+
+	"github.com/qiniu/logkit/conf"
+	. "github.com/qiniu/logkit/reader/config"
+	. "github.com/qiniu/logkit/utils/models"
+	"github.com/qiniu/logkit/utils/tlspolicy"
+)
+
+// groupRetryInterval 加入 consumer 组失败后重试的间隔
+const groupRetryInterval = 3 * time.Second
+
+var _ sarama.ConsumerGroupHandler = &groupHandler{}
+
+// newBrokerConfig 根据配置生成直接连接 broker 时使用的 sarama 配置，包括协议版本、rebalance 策略以及 SASL 和 TLS 认证
+func newBrokerConfig(c conf.MapConf) (*sarama.Config, error) {
+	config := sarama.NewConfig()
+	versionStr, _ := c.GetStringOr(KeyKafkaVersion, DefaultKafkaVersion)
+	version, err := sarama.ParseKafkaVersion(versionStr)
+	if err != nil {
+		return nil, fmt.Errorf("%s %q is invalid: %v", KeyKafkaVersion, versionStr, err)
+	}
+	if !version.IsAtLeast(sarama.V0_10_2_0) {
+		return nil, fmt.Errorf("%s %q is too old, consumer group requires kafka %s or later", KeyKafkaVersion, versionStr, DefaultKafkaVersion)
+	}
+	config.Version = version
+
+	strategy, _ := c.GetStringOr(KeyKafkaRebalanceStrategy, KafkaRebalanceRange)
+	switch strings.ToLower(strategy) {
+	case KafkaRebalanceRange, "":
+		config.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRange
+	case KafkaRebalanceRoundRobin:
+		config.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRoundRobin
+	case KafkaRebalanceSticky:
+		config.Consumer.Group.Rebalance.Strategy = stickyStrategy{}
+	default:
+		return nil, fmt.Errorf("%s %q is invalid, should be one of %s, %s and %s", KeyKafkaRebalanceStrategy, strategy,
+			KafkaRebalanceRange, KafkaRebalanceRoundRobin, KafkaRebalanceSticky)
+	}
+
+	mechanism, _ := c.GetStringOr(KeyKafkaSASLMechanism, "")
+	mechanism = strings.ToUpper(mechanism)
+	switch mechanism {
+	case "":
+	case KafkaSASLPlain, KafkaSASLScramSHA256, KafkaSASLScramSHA512:
+		config.Net.SASL.Enable = true
+		config.Net.SASL.Handshake = true
+		config.Net.SASL.Mechanism = sarama.SASLMechanism(mechanism)
+		config.Net.SASL.User, _ = c.GetStringOr(KeyKafkaSASLUser, "")
+		config.Net.SASL.Password, _ = c.GetPasswordEnvStringOr(KeyKafkaSASLPassword, "")
+		if config.Net.SASL.User == "" {
+			return nil, fmt.Errorf("%s is required when %s is %s", KeyKafkaSASLUser, KeyKafkaSASLMechanism, mechanism)
+		}
+		if mechanism != KafkaSASLPlain {
+			// sarama 通过 SaslAuthenticate 请求交换 SCRAM 消息，需要 kafka 1.0 及以上版本
+			if !version.IsAtLeast(sarama.V1_0_0_0) {
+				return nil, fmt.Errorf("%s %s requires %s %s or later", KeyKafkaSASLMechanism, mechanism, KeyKafkaVersion, sarama.V1_0_0_0)
+			}
+			config.Net.SASL.SCRAMClientGeneratorFunc = scramClientGenerator(mechanism)
+		}
+	default:
+		return nil, fmt.Errorf("%s %q is invalid, should be one of %s, %s and %s", KeyKafkaSASLMechanism, mechanism,
+			KafkaSASLPlain, KafkaSASLScramSHA256, KafkaSASLScramSHA512)
+	}
+
+	tlsEnable, _ := c.GetBoolOr(KeyKafkaTLSEnable, false)
+	if tlsEnable {
+		tlsConfig, err := newTLSConfig(c)
+		if err != nil {
+			return nil, err
+		}
+		config.Net.TLS.Enable = true
+		config.Net.TLS.Config = tlsConfig
+	}
+	return config, nil
+}
+
+func newTLSConfig(c conf.MapConf) (*tls.Config, error) {
+	caFile, _ := c.GetStringOr(KeyKafkaTLSCA, "")
+	certFile, _ := c.GetStringOr(KeyKafkaTLSCert, "")
+	keyFile, _ := c.GetStringOr(KeyKafkaTLSKey, "")
+	insecureSkipVerify, _ := c.GetBoolOr(KeyKafkaTLSInsecureSkipVerify, false)
+
+	cfg := &tls.Config{InsecureSkipVerify: insecureSkipVerify}
+	if caFile != "" {
+		ca, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("read %v error: %v", KeyKafkaTLSCA, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificate found in %v %v", KeyKafkaTLSCA, caFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if (certFile == "") != (keyFile == "") {
+		return nil, fmt.Errorf("%s and %s should be set together", KeyKafkaTLSCert, KeyKafkaTLSKey)
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load %s and %s error: %v", KeyKafkaTLSCert, KeyKafkaTLSKey, err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return tlspolicy.Apply(cfg), nil
+}
+
+// joinGroup 连接 broker 并创建 consumer 组，Start 之后才开始消费
+func (r *Reader) joinGroup() error {
+	client, err := sarama.NewClient(r.Brokers, r.brokerConfig)
+	if err != nil {
+		return err
+	}
+	group, err := sarama.NewConsumerGroupFromClient(r.ConsumerGroup, client)
+	if err != nil {
+		client.Close()
+		return err
+	}
+	messages := make(chan *sarama.ConsumerMessage, r.brokerConfig.ChannelBufferSize)
+	errs := make(chan error, r.brokerConfig.ChannelBufferSize)
+	r.groupClient = client
+	r.group = group
+	r.handler = &groupHandler{r: r, messages: messages}
+	r.groupErrs = errs
+	r.readChan = messages
+	r.errChan = errs
+	r.meta.Go("kafka group errors", func() {
+		for err := range group.Errors() {
+			r.sendGroupError(err)
+		}
+	})
+	return nil
+}
+
+func (r *Reader) sendGroupError(err error) {
+	select {
+	case r.groupErrs <- err:
+	default:
+		log.Errorf("Runner[%v] reader %q error channel is full, drop error: %v", r.meta.RunnerName, r.Name(), err)
+	}
+}
+
+// consumeGroup 持续加入 consumer 组消费，每次 rebalance 之后 Consume 都会返回，需要重新加入
+func (r *Reader) consumeGroup(ctx context.Context) {
+	for {
+		err := r.group.Consume(ctx, r.Topics, r.handler)
+		if ctx.Err() != nil || err == sarama.ErrClosedConsumerGroup {
+			return
+		}
+		if err == nil {
+			continue
+		}
+		log.Errorf("Runner[%v] reader %q consume kafka consumer group %s error: %v, retry after %v", r.meta.RunnerName, r.Name(), r.ConsumerGroup, err, groupRetryInterval)
+		r.sendGroupError(err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(groupRetryInterval):
+		}
+	}
+}
+
+// stopGroup 退出 consumer 组，退出前会提交已经读取的 offset
+func (r *Reader) stopGroup() error {
+	if r.cancelGroup != nil {
+		r.cancelGroup()
+	}
+	err := r.group.Close()
+	if err != nil {
+		log.Errorf("Runner[%v] reader %q close kafka consumer group error: %v", r.meta.RunnerName, r.Name(), err)
+	}
+	if cerr := r.groupClient.Close(); cerr != nil && cerr != sarama.ErrClosedClient {
+		log.Errorf("Runner[%v] reader %q close kafka client error: %v", r.meta.RunnerName, r.Name(), cerr)
+		if err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// markGroupOffset 将已经读取的 offset 标记到当前的 session 中，由 sarama 定期提交，调用时需要持有 r.lock
+func (r *Reader) markGroupOffset() {
+	if r.session == nil {
+		return
+	}
+	for topic, partitions := range r.currentOffsets {
+		for partition, offset := range partitions {
+			// 提交的是下一条需要读取的消息的 offset
+			r.session.MarkOffset(topic, partition, offset+1, "")
+		}
+	}
+}
+
+// groupLag 计算当前分到的每个分区中还没有读取的消息数
+func (r *Reader) groupLag() (*LagInfo, error) {
+	r.lock.Lock()
+	next := make(map[string]map[int32]int64, len(r.claims))
+	for topic, partitions := range r.claims {
+		next[topic] = make(map[int32]int64, len(partitions))
+		for _, partition := range partitions {
+			offset, ok := r.claimStarts[topic][partition]
+			if current, read := r.currentOffsets[topic][partition]; read {
+				offset, ok = current+1, true
+			}
+			if ok {
+				next[topic][partition] = offset
+			}
+		}
+	}
+	r.lock.Unlock()
+
+	rl := &LagInfo{SizeUnit: "records", Partitions: make(map[string]int64)}
+	for topic, partitions := range next {
+		for partition, offset := range partitions {
+			newest, err := r.groupClient.GetOffset(topic, partition, sarama.OffsetNewest)
+			if err != nil {
+				return nil, fmt.Errorf("get newest offset of %s/%d error: %v", topic, partition, err)
+			}
+			// 还没有读到消息时起始位置可能是 OffsetOldest 或者 OffsetNewest
+			if offset < 0 {
+				if offset, err = r.groupClient.GetOffset(topic, partition, offset); err != nil {
+					return nil, fmt.Errorf("get offset of %s/%d error: %v", topic, partition, err)
+				}
+			}
+			lag := newest - offset
+			if lag < 0 {
+				lag = 0
+			}
+			rl.Partitions[fmt.Sprintf("%s/%d", topic, partition)] = lag
+			rl.Size += lag
+		}
+	}
+	return rl, nil
+}
+
+// seekClaimsToTime 将分到的分区中组内没有提交过 offset 的分区设置为从 r.StartTime 开始读取
+func (r *Reader) seekClaimsToTime(sess sarama.ConsumerGroupSession) error {
+	coordinator, err := r.groupClient.Coordinator(r.ConsumerGroup)
+	if err != nil {
+		return err
+	}
+	req := &sarama.OffsetFetchRequest{ConsumerGroup: r.ConsumerGroup, Version: 1}
+	for topic, partitions := range sess.Claims() {
+		for _, partition := range partitions {
+			req.AddPartition(topic, partition)
+		}
+	}
+	resp, err := coordinator.FetchOffset(req)
+	if err != nil {
+		return err
+	}
+	for topic, partitions := range sess.Claims() {
+		for _, partition := range partitions {
+			block := resp.GetBlock(topic, partition)
+			if block == nil {
+				return fmt.Errorf("no committed offset of %s/%d in response", topic, partition)
+			}
+			if block.Err != sarama.ErrNoError {
+				return fmt.Errorf("fetch committed offset of %s/%d error: %v", topic, partition, block.Err)
+			}
+			if block.Offset >= 0 {
+				continue
+			}
+			offset, err := offsetForTime(r.groupClient, topic, partition, r.StartTime)
+			if err != nil {
+				return err
+			}
+			sess.MarkOffset(topic, partition, offset, "")
+			log.Infof("Runner[%v] kafka consumer group %s starts consuming %s/%d from offset %d at %v", r.meta.RunnerName, r.ConsumerGroup, topic, partition, offset, r.StartTime)
+		}
+	}
+	return nil
+}
+
+// groupHandler 将分到的所有分区的消息汇总到 messages 中，Setup 和 Cleanup 在每次 rebalance 前后被调用
+type groupHandler struct {
+	r        *Reader
+	messages chan *sarama.ConsumerMessage
+}
+
+func (h *groupHandler) Setup(sess sarama.ConsumerGroupSession) error {
+	r := h.r
+	claims := sess.Claims()
+	if !r.StartTime.IsZero() {
+		if err := r.seekClaimsToTime(sess); err != nil {
+			return fmt.Errorf("seek kafka consumer group %s to %v error: %v", r.ConsumerGroup, r.StartTime, err)
+		}
+	}
+
+	r.lock.Lock()
+	r.session = sess
+	r.claims = claims
+	r.claimStarts = make(map[string]map[int32]int64, len(claims))
+	// 不再属于自己的分区由其他成员继续读取，这里不再记录
+	for topic, offsets := range r.currentOffsets {
+		claimed := make(map[int32]bool, len(claims[topic]))
+		for _, partition := range claims[topic] {
+			claimed[partition] = true
+		}
+		for partition := range offsets {
+			if !claimed[partition] {
+				delete(offsets, partition)
+			}
+		}
+	}
+	r.lock.Unlock()
+
+	if _, ok := r.brokerConfig.Consumer.Group.Rebalance.Strategy.(stickyStrategy); ok {
+		// 与 Consume 在同一个 goroutine 中，下一次加入组时会读取
+		r.brokerConfig.Consumer.Group.Member.UserData = encodeStickyUserData(claims)
+	}
+	log.Infof("Runner[%v] kafka consumer group %s member %s joined generation %d, assigned partitions: %v",
+		r.meta.RunnerName, r.ConsumerGroup, sess.MemberID(), sess.GenerationID(), claims)
+	return nil
+}
+
+func (h *groupHandler) Cleanup(sess sarama.ConsumerGroupSession) error {
+	r := h.r
+	r.lock.Lock()
+	r.markGroupOffset()
+	r.session = nil
+	r.claims = nil
+	r.lock.Unlock()
+
+	// 还没有被取走的消息没有提交 offset，分区的新成员会重新读取，这里丢弃避免重复
+	for {
+		select {
+		case <-h.messages:
+		default:
+			log.Infof("Runner[%v] kafka consumer group %s member %s left generation %d, revoked partitions: %v",
+				r.meta.RunnerName, r.ConsumerGroup, sess.MemberID(), sess.GenerationID(), sess.Claims())
+			return nil
+		}
+	}
+}
+
+func (h *groupHandler) ConsumeClaim(sess sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	r := h.r
+	r.lock.Lock()
+	if r.claimStarts[claim.Topic()] == nil {
+		r.claimStarts[claim.Topic()] = make(map[int32]int64)
+	}
+	r.claimStarts[claim.Topic()][claim.Partition()] = claim.InitialOffset()
+	r.lock.Unlock()
+
+	for {
+		select {
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			select {
+			case h.messages <- msg:
+			case <-sess.Context().Done():
+				return nil
+			}
+		case <-sess.Context().Done():
+			return nil
+		}
+	}
+}
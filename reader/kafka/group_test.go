@@ -0,0 +1,218 @@
+package kafka
+
+import (
+	"context"
+	"crypto/sha512"
+	"os"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/reader"
+	. "github.com/qiniu/logkit/reader/config"
+	. "github.com/qiniu/logkit/reader/test"
+)
+
+func TestNewBrokerConfig(t *testing.T) {
+	config, err := newBrokerConfig(conf.MapConf{})
+	require.NoError(t, err)
+	assert.Equal(t, sarama.V0_10_2_0, config.Version)
+	assert.Equal(t, sarama.BalanceStrategyRange, config.Consumer.Group.Rebalance.Strategy)
+	assert.False(t, config.Net.SASL.Enable)
+	assert.False(t, config.Net.TLS.Enable)
+
+	config, err = newBrokerConfig(conf.MapConf{
+		KeyKafkaVersion:               "2.0.0",
+		KeyKafkaRebalanceStrategy:     KafkaRebalanceSticky,
+		KeyKafkaSASLMechanism:         "plain",
+		KeyKafkaSASLUser:              "user",
+		KeyKafkaSASLPassword:          "pass",
+		KeyKafkaTLSEnable:             "true",
+		KeyKafkaTLSInsecureSkipVerify: "true",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, sarama.V2_0_0_0, config.Version)
+	assert.Equal(t, stickyStrategyName, config.Consumer.Group.Rebalance.Strategy.Name())
+	assert.True(t, config.Net.SASL.Enable)
+	assert.Equal(t, "user", config.Net.SASL.User)
+	assert.Equal(t, "pass", config.Net.SASL.Password)
+	assert.True(t, config.Net.TLS.Enable)
+	assert.True(t, config.Net.TLS.Config.InsecureSkipVerify)
+
+	config, err = newBrokerConfig(conf.MapConf{
+		KeyKafkaVersion:       "1.0.0",
+		KeyKafkaSASLMechanism: "scram-sha-512",
+		KeyKafkaSASLUser:      "user",
+		KeyKafkaSASLPassword:  "pass",
+	})
+	require.NoError(t, err)
+	assert.EqualValues(t, sarama.SASLTypeSCRAMSHA512, config.Net.SASL.Mechanism)
+	require.NotNil(t, config.Net.SASL.SCRAMClientGeneratorFunc)
+	assert.Equal(t, sha512.Size, config.Net.SASL.SCRAMClientGeneratorFunc().(*scramClient).hash().Size())
+	assert.NoError(t, config.Validate())
+
+	for _, c := range []conf.MapConf{
+		{KeyKafkaVersion: "0.10.1.0"},
+		{KeyKafkaVersion: "abc"},
+		{KeyKafkaRebalanceStrategy: "cooperative"},
+		{KeyKafkaSASLMechanism: KafkaSASLPlain},
+		{KeyKafkaSASLMechanism: KafkaSASLScramSHA512, KeyKafkaVersion: "1.0.0"},
+		{KeyKafkaSASLMechanism: KafkaSASLScramSHA256, KeyKafkaSASLUser: "user", KeyKafkaSASLPassword: "pass"},
+		{KeyKafkaSASLMechanism: "GSSAPI", KeyKafkaSASLUser: "user"},
+		{KeyKafkaTLSEnable: "true", KeyKafkaTLSCert: "client.crt"},
+		{KeyKafkaTLSEnable: "true", KeyKafkaTLSCA: "not_exist.crt"},
+	} {
+		_, err = newBrokerConfig(c)
+		assert.Error(t, err, c)
+	}
+}
+
+func planOf(t *testing.T, members map[string]sarama.ConsumerGroupMemberMetadata, topics map[string][]int32) map[string][]int32 {
+	plan, err := stickyStrategy{}.Plan(members, topics)
+	require.NoError(t, err)
+	assignment := make(map[string][]int32)
+	for id, claims := range plan {
+		for _, partitions := range claims {
+			assignment[id] = append(assignment[id], partitions...)
+		}
+		sort.Slice(assignment[id], func(i, j int) bool { return assignment[id][i] < assignment[id][j] })
+	}
+	return assignment
+}
+
+func TestStickyStrategy(t *testing.T) {
+	topics := map[string][]int32{"topic1": {0, 1, 2, 3, 4, 5}}
+	member := func(claims map[string][]int32) sarama.ConsumerGroupMemberMetadata {
+		return sarama.ConsumerGroupMemberMetadata{Topics: []string{"topic1"}, UserData: encodeStickyUserData(claims)}
+	}
+
+	assert.Equal(t, map[string][]int32{"a": {0, 2, 4}, "b": {1, 3, 5}}, planOf(t, map[string]sarama.ConsumerGroupMemberMetadata{
+		"a": member(nil),
+		"b": member(nil),
+	}, topics))
+
+	// 新成员加入时只从原有的成员迁移必要的分区
+	assignment := planOf(t, map[string]sarama.ConsumerGroupMemberMetadata{
+		"a": member(map[string][]int32{"topic1": {0, 1, 2}}),
+		"b": member(map[string][]int32{"topic1": {3, 4, 5}}),
+		"c": member(nil),
+	}, topics)
+	assert.Len(t, assignment["a"], 2)
+	assert.Len(t, assignment["b"], 2)
+	assert.Len(t, assignment["c"], 2)
+	for _, partition := range assignment["a"] {
+		assert.Contains(t, []int32{0, 1, 2}, partition)
+	}
+	for _, partition := range assignment["b"] {
+		assert.Contains(t, []int32{3, 4, 5}, partition)
+	}
+
+	// 成员离开后其分区分给剩下的成员，重复上报和已经不存在的分区被忽略
+	assert.Equal(t, map[string][]int32{"a": {0, 1, 2}, "b": {3, 4, 5}}, planOf(t, map[string]sarama.ConsumerGroupMemberMetadata{
+		"a": member(map[string][]int32{"topic1": {0, 1, 7}}),
+		"b": member(map[string][]int32{"topic1": {1, 3, 4, 5}}),
+	}, topics))
+
+	// 只分给订阅了该 topic 的成员
+	topics["topic2"] = []int32{0, 1}
+	assignment = planOf(t, map[string]sarama.ConsumerGroupMemberMetadata{
+		"a": member(nil),
+		"b": {Topics: []string{"topic2"}},
+	}, topics)
+	assert.Equal(t, []int32{0, 1, 2, 3, 4, 5}, assignment["a"])
+	assert.Equal(t, []int32{0, 1}, assignment["b"])
+}
+
+// fakeSession 只记录 MarkOffset 的 sarama.ConsumerGroupSession
+type fakeSession struct {
+	claims map[string][]int32
+	marked map[string]map[int32]int64
+}
+
+func (s *fakeSession) Claims() map[string][]int32 { return s.claims }
+func (s *fakeSession) MemberID() string           { return "member1" }
+func (s *fakeSession) GenerationID() int32        { return 1 }
+func (s *fakeSession) MarkOffset(topic string, partition int32, offset int64, metadata string) {
+	if s.marked[topic] == nil {
+		s.marked[topic] = make(map[int32]int64)
+	}
+	s.marked[topic][partition] = offset
+}
+func (s *fakeSession) ResetOffset(topic string, partition int32, offset int64, metadata string) {}
+func (s *fakeSession) MarkMessage(msg *sarama.ConsumerMessage, metadata string)                 {}
+func (s *fakeSession) Context() context.Context                                                 { return context.Background() }
+
+func TestGroupHandler(t *testing.T) {
+	meta, err := reader.NewMetaWithConf(conf.MapConf{
+		KeyMetaPath: MetaDir,
+		KeyFileDone: MetaDir,
+		KeyMode:     ModeKafka,
+	})
+	require.NoError(t, err)
+	defer os.RemoveAll(MetaDir)
+
+	start := time.Date(2019, 8, 7, 10, 0, 0, 0, time.UTC)
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("topic1", 0, broker.BrokerID()).
+			SetLeader("topic1", 1, broker.BrokerID()),
+		"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(t).
+			SetCoordinator(sarama.CoordinatorGroup, "group1", broker),
+		"OffsetFetchRequest": sarama.NewMockOffsetFetchResponse(t).
+			SetOffset("group1", "topic1", 0, -1, "", sarama.ErrNoError).
+			SetOffset("group1", "topic1", 1, 3, "", sarama.ErrNoError),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).SetVersion(1).
+			SetOffset("topic1", 0, start.UnixNano()/int64(time.Millisecond), 2).
+			SetOffset("topic1", 0, sarama.OffsetNewest, 10).
+			SetOffset("topic1", 1, sarama.OffsetNewest, 10),
+	})
+
+	config, err := newBrokerConfig(conf.MapConf{KeyKafkaRebalanceStrategy: KafkaRebalanceSticky})
+	require.NoError(t, err)
+	client, err := sarama.NewClient([]string{broker.Addr()}, config)
+	require.NoError(t, err)
+	defer client.Close()
+
+	messages := make(chan *sarama.ConsumerMessage, 1)
+	r := &Reader{
+		meta:           meta,
+		Topics:         []string{"topic1"},
+		ConsumerGroup:  "group1",
+		brokerConfig:   config,
+		groupClient:    client,
+		currentOffsets: map[string]map[int32]int64{"topic1": {1: 4, 2: 8}},
+		StartTime:      start,
+		lock:           new(sync.Mutex),
+		statsLock:      new(sync.RWMutex),
+	}
+	h := &groupHandler{r: r, messages: messages}
+
+	// 没有提交过 offset 的分区从 StartTime 开始读取，不再属于自己的分区不再记录
+	sess := &fakeSession{claims: map[string][]int32{"topic1": {0, 1}}, marked: make(map[string]map[int32]int64)}
+	require.NoError(t, h.Setup(sess))
+	assert.Equal(t, map[string]map[int32]int64{"topic1": {0: 2}}, sess.marked)
+	assert.Equal(t, map[string]map[int32]int64{"topic1": {1: 4}}, r.currentOffsets)
+	assert.Equal(t, map[string][]int32{"topic1": {0, 1}}, decodeStickyUserData(config.Consumer.Group.Member.UserData))
+
+	r.claimStarts["topic1"] = map[int32]int64{0: 2, 1: 3}
+	lag, err := r.groupLag()
+	require.NoError(t, err)
+	assert.EqualValues(t, 13, lag.Size)
+	assert.Equal(t, map[string]int64{"topic1/0": 8, "topic1/1": 5}, lag.Partitions)
+
+	// rebalance 前提交已经读取的 offset，丢弃还没有被取走的消息
+	messages <- &sarama.ConsumerMessage{Topic: "topic1", Partition: 0, Offset: 2}
+	require.NoError(t, h.Cleanup(sess))
+	assert.Equal(t, map[string]map[int32]int64{"topic1": {0: 2, 1: 5}}, sess.marked)
+	assert.Len(t, messages, 0)
+	assert.Nil(t, r.session)
+}
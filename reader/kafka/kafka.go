@@ -1,6 +1,7 @@
 package kafka
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"strings"
@@ -10,6 +11,7 @@ import (
 
 	"github.com/Shopify/sarama"
 	"github.com/wvanbergen/kafka/consumergroup"
+	"github.com/wvanbergen/kazoo-go"
 
 	"github.com/qiniu/log"
 
@@ -20,9 +22,13 @@ import (
 )
 
 var (
-	_ reader.StatsReader = &Reader{}
-	_ reader.LagReader   = &Reader{}
-	_ reader.Reader      = &Reader{}
+	_ reader.StatsReader     = &Reader{}
+	_ reader.LagReader       = &Reader{}
+	_ reader.OnceReader      = &Reader{}
+	_ reader.PartitionReader = &Reader{}
+	_ reader.Reader          = &Reader{}
+
+	_ reader.RecordTagsReader = &Reader{}
 )
 
 func init() {
@@ -40,12 +46,37 @@ type Reader struct {
 
 	Consumer       *consumergroup.ConsumerGroup
 	currentOffsets map[string]map[int32]int64 // <topic,<partition,offset>>
+	// partition 最近一次读出的消息所在的 topic 和分区
+	partition string
+
+	// 配置了 kafka_record_meta 时 lastRecordTags 为最近一次读出的消息的元数据
+	recordMeta     bool
+	recordPrefix   string
+	lastRecordTags map[string]interface{}
+
+	// 配置了 kafka_brokers 时直接连接 broker，通过 consumer 组 API 消费，不再依赖 zookeeper
+	group        sarama.ConsumerGroup
+	groupClient  sarama.Client
+	brokerConfig *sarama.Config
+	handler      *groupHandler
+	groupErrs    chan error
+	cancelGroup  context.CancelFunc
+	// 当前 session 以及分到的分区，rebalance 时更新
+	session     sarama.ConsumerGroupSession
+	claims      map[string][]int32
+	claimStarts map[string]map[int32]int64 // 分到的分区开始读取的 offset
+
+	// 配置了 kafka_end_time 时不加入 consumer 组，只重放 [StartTime, EndTime) 之间的消息
+	replay    *replayer
+	StartTime time.Time
+	EndTime   time.Time
 
 	stats     StatsInfo
 	statsLock *sync.RWMutex
 
 	ConsumerGroup    string
 	Topics           []string
+	Brokers          []string
 	ZookeeperPeers   []string
 	ZookeeperChroot  string
 	ZookeeperTimeout time.Duration
@@ -70,11 +101,34 @@ func NewReader(meta *reader.Meta, conf conf.MapConf) (reader.Reader, error) {
 		return nil, fmt.Errorf("parse %s %s err %v", KeyKafkaMaxProcessTime, maxProcessingTime, err)
 	}
 
-	zookeeper, err := conf.GetStringList(KeyKafkaZookeeper)
+	brokers, _ := conf.GetStringListOr(KeyKafkaBrokers, nil)
+	zookeeper, _ := conf.GetStringListOr(KeyKafkaZookeeper, nil)
+	if len(brokers) == 0 && len(zookeeper) == 0 {
+		return nil, fmt.Errorf("%s or %s is required", KeyKafkaBrokers, KeyKafkaZookeeper)
+	}
+	zkchroot, _ := conf.GetStringOr(KeyKafkaZookeeperChroot, "")
+	startTimeStr, _ := conf.GetStringOr(KeyKafkaStartTime, "")
+	startTime, err := parseTime(KeyKafkaStartTime, startTimeStr)
 	if err != nil {
 		return nil, err
 	}
-	zkchroot, _ := conf.GetStringOr(KeyKafkaZookeeperChroot, "")
+	endTimeStr, _ := conf.GetStringOr(KeyKafkaEndTime, "")
+	endTime, err := parseTime(KeyKafkaEndTime, endTimeStr)
+	if err != nil {
+		return nil, err
+	}
+	if !endTime.IsZero() && (startTime.IsZero() || !endTime.After(startTime)) {
+		return nil, fmt.Errorf("%s should be set and earlier than %s", KeyKafkaStartTime, KeyKafkaEndTime)
+	}
+	recordMeta, _ := conf.GetBoolOr(KeyKafkaRecordMeta, false)
+	recordPrefix, _ := conf.GetStringOr(KeyKafkaRecordMetaPrefix, DefaultKafkaMetaPrefix)
+	// 通过 zookeeper 按时间段重放时查询 offset 和读取消息使用的协议版本
+	offsetVersion := sarama.V0_10_1_0
+	if recordMeta {
+		if offsetVersion, err = recordMetaVersion(conf); err != nil {
+			return nil, err
+		}
+	}
 	offsets := make(map[string]map[int32]int64)
 	for _, v := range topics {
 		offsets[v] = make(map[int32]int64)
@@ -83,6 +137,7 @@ func NewReader(meta *reader.Meta, conf conf.MapConf) (reader.Reader, error) {
 	kr := &Reader{
 		meta:             meta,
 		ConsumerGroup:    consumerGroup,
+		Brokers:          brokers,
 		ZookeeperPeers:   zookeeper,
 		ZookeeperTimeout: time.Duration(zookeeperTimeout) * time.Second,
 		ZookeeperChroot:  zkchroot,
@@ -91,6 +146,10 @@ func NewReader(meta *reader.Meta, conf conf.MapConf) (reader.Reader, error) {
 		lock:             new(sync.Mutex),
 		statsLock:        new(sync.RWMutex),
 		currentOffsets:   offsets,
+		StartTime:        startTime,
+		EndTime:          endTime,
+		recordMeta:       recordMeta,
+		recordPrefix:     recordPrefix,
 	}
 
 	config := consumergroup.NewConfig()
@@ -98,6 +157,9 @@ func NewReader(meta *reader.Meta, conf conf.MapConf) (reader.Reader, error) {
 	config.Zookeeper.Timeout = kr.ZookeeperTimeout
 	config.Consumer.Return.Errors = true
 	config.Consumer.MaxProcessingTime = maxProcessingTimeDur
+	if kr.recordMeta {
+		config.Version = offsetVersion
+	}
 
 	/*********************  kafka offset *************************/
 	/* 这里设定的offset不影响原有的offset，因为kafka client会去获取   */
@@ -113,6 +175,41 @@ func NewReader(meta *reader.Meta, conf conf.MapConf) (reader.Reader, error) {
 	}
 	/*************************************************************/
 
+	if len(kr.Brokers) > 0 {
+		if kr.brokerConfig, err = newBrokerConfig(conf); err != nil {
+			return nil, err
+		}
+		kr.brokerConfig.Consumer.Return.Errors = true
+		kr.brokerConfig.Consumer.MaxProcessingTime = maxProcessingTimeDur
+		kr.brokerConfig.Consumer.Offsets.Initial = config.Offsets.Initial
+	}
+
+	if !kr.EndTime.IsZero() {
+		if err = kr.startReplay(config.Zookeeper, offsetVersion); err != nil {
+			err = fmt.Errorf("runner[%v] kafka reader replay from %v to %v err: %v", kr.meta.RunnerName, kr.StartTime, kr.EndTime, err)
+			log.Error(err)
+			return nil, err
+		}
+		return kr, nil
+	}
+	if len(kr.Brokers) > 0 {
+		// 没有提交过 offset 的分区从 kafka_start_time 开始读取，在每次分到分区时设置
+		if err = kr.joinGroup(); err != nil {
+			err = fmt.Errorf("runner[%v] kafka reader join group %s err: %v", kr.meta.RunnerName, kr.ConsumerGroup, err)
+			log.Error(err)
+			return nil, err
+		}
+		return kr, nil
+	}
+	if !kr.StartTime.IsZero() {
+		err = seekGroupToTime(kr.meta, kr.ConsumerGroup, kr.Topics, kr.ZookeeperPeers, config.Zookeeper, kr.StartTime)
+		if err != nil {
+			err = fmt.Errorf("runner[%v] kafka reader set consumer group offsets to %v err: %v", kr.meta.RunnerName, kr.StartTime, err)
+			log.Error(err)
+			return nil, err
+		}
+	}
+
 	var consumerErr error
 	kr.Consumer, consumerErr = consumergroup.JoinConsumerGroup(
 		kr.ConsumerGroup,
@@ -130,6 +227,46 @@ func NewReader(meta *reader.Meta, conf conf.MapConf) (reader.Reader, error) {
 	return kr, nil
 }
 
+// startReplay 查找时间段对应的 offset，从上次重放的进度继续读取
+func (r *Reader) startReplay(zkConf *kazoo.Config, offsetVersion sarama.KafkaVersion) error {
+	var (
+		client sarama.Client
+		err    error
+	)
+	if len(r.Brokers) > 0 {
+		client, err = sarama.NewClient(r.Brokers, r.brokerConfig)
+	} else {
+		client, err = newOffsetClient(r.ZookeeperPeers, zkConf, offsetVersion)
+	}
+	if err != nil {
+		return err
+	}
+	starts, err := offsetsForTime(client, r.Topics, r.StartTime)
+	if err != nil {
+		client.Close()
+		return err
+	}
+	ends, err := offsetsForTime(client, r.Topics, r.EndTime)
+	if err != nil {
+		client.Close()
+		return err
+	}
+	for topic, partitions := range readReplayProgress(r.meta, r.StartTime, r.EndTime) {
+		for partition, offset := range partitions {
+			if _, ok := starts[topic][partition]; ok {
+				starts[topic][partition] = offset
+			}
+		}
+	}
+	r.replay, err = newReplayer(r.meta, client, starts, ends)
+	if err != nil {
+		return err
+	}
+	r.readChan = r.replay.messages
+	r.errChan = r.replay.errors
+	return nil
+}
+
 func (r *Reader) startMarkOffset() {
 	ticker := time.NewTicker(5 * time.Second)
 	defer ticker.Stop()
@@ -170,6 +307,12 @@ func (r *Reader) Source() string {
 	return fmt.Sprintf("[%s],[%s]", strings.Join(r.Topics, ","), r.ConsumerGroup)
 }
 
+func (r *Reader) Partition() string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.partition
+}
+
 func (r *Reader) ReadLine() (string, error) {
 	timer := time.NewTimer(time.Second)
 	defer timer.Stop()
@@ -187,6 +330,10 @@ func (r *Reader) ReadLine() (string, error) {
 				tp[msg.Partition] = msg.Offset
 				r.currentOffsets[msg.Topic] = tp
 			}
+			r.partition = fmt.Sprintf("%s/%d", msg.Topic, msg.Partition)
+			if r.recordMeta {
+				r.lastRecordTags = recordTags(r.recordPrefix, msg)
+			}
 			r.lock.Unlock()
 		} else {
 			log.Debugf("runner[%v] Consumer read empty message: %v", r.meta.RunnerName, msg)
@@ -212,11 +359,30 @@ func (r *Reader) Status() StatsInfo {
 }
 
 func (r *Reader) Start() error {
-	go r.startMarkOffset()
+	if r.isStopping() || r.hasStopped() {
+		return errors.New("reader is stopping or has stopped")
+	} else if !atomic.CompareAndSwapInt32(&r.status, StatusInit, StatusRunning) {
+		log.Warnf("Runner[%v] %q daemon has already started and is running", r.meta.RunnerName, r.Name())
+		return nil
+	}
+	if r.group != nil {
+		var ctx context.Context
+		ctx, r.cancelGroup = context.WithCancel(context.Background())
+		r.meta.Go("kafka consumer group", func() { r.consumeGroup(ctx) })
+	}
+	r.meta.Go("kafka mark offset", r.startMarkOffset)
 	return nil
 }
 
 func (r *Reader) Lag() (*LagInfo, error) {
+	if r.replay != nil {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		return &LagInfo{SizeUnit: "records", Size: r.replay.lag(r.currentOffsets)}, nil
+	}
+	if r.group != nil {
+		return r.groupLag()
+	}
 	if r.Consumer == nil {
 		return nil, errors.New("kafka consumer is closed")
 	}
@@ -244,9 +410,25 @@ func (r *Reader) SyncMeta() {
 	r.markOffset()
 }
 
+// ReadDone 按时间段重放时所有分区都已经读完
+func (r *Reader) ReadDone() bool {
+	return r.replay != nil && r.replay.done()
+}
+
 func (r *Reader) markOffset() {
 	r.lock.Lock()
 	defer r.lock.Unlock()
+	if r.replay != nil {
+		progress := &replayProgress{Start: r.StartTime, End: r.EndTime, Offsets: r.replay.progress(r.currentOffsets)}
+		if err := writeReplayProgress(r.meta, progress); err != nil {
+			log.Errorf("Runner[%v] reader %q write kafka replay progress error: %v", r.meta.RunnerName, r.Name(), err)
+		}
+		return
+	}
+	if r.group != nil {
+		r.markGroupOffset()
+		return
+	}
 	for topic, partOffset := range r.currentOffsets {
 		if partOffset == nil {
 			continue
@@ -264,9 +446,25 @@ func (r *Reader) markOffset() {
 func (r *Reader) stop() error {
 	r.markOffset()
 
+	if r.group != nil {
+		// 退出组时会调用 groupHandler.Cleanup，其中需要获取 r.lock
+		err := r.stopGroup()
+		atomic.StoreInt32(&r.status, StatusStopped)
+		return err
+	}
+
 	r.lock.Lock()
 	defer r.lock.Unlock()
 
+	if r.replay != nil {
+		err := r.replay.close()
+		if err != nil {
+			log.Errorf("Runner[%v] reader %q close kafka replay error: %v", r.meta.RunnerName, r.Name(), err)
+		}
+		atomic.StoreInt32(&r.status, StatusStopped)
+		return err
+	}
+
 	err := r.Consumer.FlushOffsets()
 	if err != nil {
 		log.Errorf("Runner[%v] reader %q flush kafka offset error: %v", r.meta.RunnerName, r.Name(), err.Error())
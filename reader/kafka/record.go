@@ -0,0 +1,72 @@
+package kafka
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/qiniu/logkit/conf"
+	. "github.com/qiniu/logkit/reader/config"
+)
+
+// 配置了 kafka_record_meta 时附加的字段名，实际的字段名加上 kafka_record_meta_prefix 前缀
+const (
+	recordTopic     = "topic"
+	recordPartition = "partition"
+	recordOffset    = "offset"
+	recordTimestamp = "timestamp"
+	recordKey       = "key"
+	recordHeaders   = "headers"
+)
+
+// recordMetaVersion 消息 headers 从 kafka 0.11 开始支持，sarama 只有在协议版本不低于 0.11 时才使用能够携带 headers 的 fetch v4，
+// 否则 broker 返回的消息中不会有 headers，因此配置了 kafka_record_meta 时要求 kafka_version 不低于 0.11.0.0
+func recordMetaVersion(c conf.MapConf) (sarama.KafkaVersion, error) {
+	versionStr, _ := c.GetStringOr(KeyKafkaVersion, DefaultKafkaVersion)
+	version, err := sarama.ParseKafkaVersion(versionStr)
+	if err != nil {
+		return version, fmt.Errorf("%s %q is invalid: %v", KeyKafkaVersion, versionStr, err)
+	}
+	if !version.IsAtLeast(sarama.V0_11_0_0) {
+		return version, fmt.Errorf("%s requires %s %s or later to read headers, got %q", KeyKafkaRecordMeta, KeyKafkaVersion, sarama.V0_11_0_0, versionStr)
+	}
+	return version, nil
+}
+
+// recordTags 返回消息的元数据字段，timestamp 和 key 只在消息中存在时附加，headers 作为嵌套字段，
+// 同名的 header 保留最后一个
+func recordTags(prefix string, msg *sarama.ConsumerMessage) map[string]interface{} {
+	tags := map[string]interface{}{
+		prefix + recordTopic:     msg.Topic,
+		prefix + recordPartition: int64(msg.Partition),
+		prefix + recordOffset:    msg.Offset,
+	}
+	if !msg.Timestamp.IsZero() {
+		tags[prefix+recordTimestamp] = msg.Timestamp.Format(time.RFC3339Nano)
+	}
+	if len(msg.Key) > 0 {
+		tags[prefix+recordKey] = string(msg.Key)
+	}
+	if len(msg.Headers) > 0 {
+		headers := make(map[string]interface{}, len(msg.Headers))
+		for _, header := range msg.Headers {
+			if header == nil {
+				continue
+			}
+			headers[string(header.Key)] = string(header.Value)
+		}
+		tags[prefix+recordHeaders] = headers
+	}
+	return tags
+}
+
+func (r *Reader) RecordTagsEnabled() bool {
+	return r.recordMeta
+}
+
+func (r *Reader) RecordTags() map[string]interface{} {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.lastRecordTags
+}
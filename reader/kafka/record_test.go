@@ -0,0 +1,145 @@
+package kafka
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/reader"
+	. "github.com/qiniu/logkit/reader/config"
+	. "github.com/qiniu/logkit/reader/test"
+)
+
+func TestRecordTags(t *testing.T) {
+	msg := &sarama.ConsumerMessage{Topic: "topic1", Partition: 2, Offset: 10, Value: []byte("abc")}
+	assert.Equal(t, map[string]interface{}{
+		"kafka_topic":     "topic1",
+		"kafka_partition": int64(2),
+		"kafka_offset":    int64(10),
+	}, recordTags("kafka_", msg))
+
+	msg.Key = []byte("key1")
+	msg.Timestamp = time.Date(2019, 8, 7, 10, 0, 0, 0, time.UTC)
+	msg.Headers = []*sarama.RecordHeader{
+		{Key: []byte("service"), Value: []byte("a")},
+		{Key: []byte("trace"), Value: []byte("t1")},
+		{Key: []byte("service"), Value: []byte("b")},
+		nil,
+	}
+	assert.Equal(t, map[string]interface{}{
+		"topic":     "topic1",
+		"partition": int64(2),
+		"offset":    int64(10),
+		"timestamp": "2019-08-07T10:00:00Z",
+		"key":       "key1",
+		"headers":   map[string]interface{}{"service": "b", "trace": "t1"},
+	}, recordTags("", msg))
+}
+
+func TestRecordMetaVersion(t *testing.T) {
+	version, err := recordMetaVersion(conf.MapConf{KeyKafkaVersion: "0.11.0.0"})
+	assert.NoError(t, err)
+	assert.Equal(t, sarama.V0_11_0_0, version)
+	version, err = recordMetaVersion(conf.MapConf{KeyKafkaVersion: "2.0.0"})
+	assert.NoError(t, err)
+	assert.Equal(t, sarama.V2_0_0_0, version)
+	for _, c := range []conf.MapConf{
+		{},
+		{KeyKafkaVersion: "0.10.2.0"},
+		{KeyKafkaVersion: "abc"},
+	} {
+		_, err = recordMetaVersion(c)
+		assert.Error(t, err, c)
+	}
+
+	// 默认的 kafka_version 低于 0.11，开启 kafka_record_meta 时不会被悄悄提高，而是直接报错
+	meta, err := reader.NewMetaWithConf(conf.MapConf{
+		KeyMetaPath: MetaDir,
+		KeyFileDone: MetaDir,
+		KeyMode:     ModeKafka,
+	})
+	require.NoError(t, err)
+	defer os.RemoveAll(MetaDir)
+	_, err = NewReader(meta, conf.MapConf{
+		KeyKafkaGroupID:    "group1",
+		KeyKafkaTopic:      "topic1",
+		KeyKafkaBrokers:    "127.0.0.1:9092",
+		KeyKafkaRecordMeta: "true",
+	})
+	assert.Error(t, err)
+}
+
+// kafka_version 为 0.11 时 headers 需要经过 fetch v4 的解码读取出来
+func TestRecordHeadersFetched(t *testing.T) {
+	meta, err := reader.NewMetaWithConf(conf.MapConf{
+		KeyMetaPath: MetaDir,
+		KeyFileDone: MetaDir,
+		KeyMode:     ModeKafka,
+	})
+	require.NoError(t, err)
+	defer os.RemoveAll(MetaDir)
+
+	start := time.Date(2019, 8, 7, 10, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	startMs, endMs := start.UnixNano()/int64(time.Millisecond), end.UnixNano()/int64(time.Millisecond)
+
+	fetch := &sarama.FetchResponse{Version: 4}
+	fetch.AddRecord("topic1", 0, sarama.StringEncoder("key1"), sarama.StringEncoder("abc"), 0)
+	block := fetch.GetBlock("topic1", 0)
+	block.HighWaterMarkOffset = 1
+	block.RecordsSet[0].RecordBatch.Records[0].Headers = []*sarama.RecordHeader{
+		{Key: []byte("trace"), Value: []byte("t1")},
+	}
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("topic1", 0, broker.BrokerID()),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).SetVersion(1).
+			SetOffset("topic1", 0, startMs, 0).
+			SetOffset("topic1", 0, endMs, 1).
+			SetOffset("topic1", 0, sarama.OffsetNewest, 1).
+			SetOffset("topic1", 0, sarama.OffsetOldest, 0),
+		"FetchRequest": sarama.NewMockWrapper(fetch),
+	})
+
+	rd, err := NewReader(meta, conf.MapConf{
+		KeyKafkaGroupID:    "group1",
+		KeyKafkaTopic:      "topic1",
+		KeyKafkaBrokers:    broker.Addr(),
+		KeyKafkaStartTime:  start.Format(time.RFC3339),
+		KeyKafkaEndTime:    end.Format(time.RFC3339),
+		KeyKafkaVersion:    "0.11.0.0",
+		KeyKafkaRecordMeta: "true",
+	})
+	require.NoError(t, err)
+	r := rd.(*Reader)
+	defer r.Close()
+	assert.Equal(t, sarama.V0_11_0_0, r.brokerConfig.Version)
+	require.NoError(t, r.Start())
+
+	var line string
+	for i := 0; i < 10 && line == ""; i++ {
+		line, err = r.ReadLine()
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, "abc", line)
+	tags := r.RecordTags()
+	assert.Equal(t, "key1", tags[DefaultKafkaMetaPrefix+recordKey])
+	assert.Equal(t, map[string]interface{}{"trace": "t1"}, tags[DefaultKafkaMetaPrefix+recordHeaders])
+
+	var fetched bool
+	for _, rr := range broker.History() {
+		if req, ok := rr.Request.(*sarama.FetchRequest); ok {
+			fetched = true
+			assert.EqualValues(t, 4, req.Version)
+		}
+	}
+	assert.True(t, fetched)
+}
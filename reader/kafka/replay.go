@@ -0,0 +1,319 @@
+package kafka
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/json-iterator/go"
+	"github.com/wvanbergen/kazoo-go"
+
+	"github.com/qiniu/log"
+
+	"github.com/qiniu/logkit/reader"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const (
+	// startTimeMetaFile 记录已经按 kafka_start_time 设置过 consumer 组 offset 的时间，避免重启后重复设置
+	startTimeMetaFile = "kafka_start_time.meta"
+	// replayMetaFile 记录按时间段重放的进度
+	replayMetaFile = "kafka_replay.meta"
+)
+
+// timeLayouts kafka_start_time 和 kafka_end_time 支持的时间格式，没有时区的按本地时间解析
+var timeLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"}
+
+func parseTime(key, value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%s %q should be in format of %q", key, value, strings.Join(timeLayouts, `" or "`))
+}
+
+// newOffsetClient 从 zookeeper 获取 broker 列表并创建用于按时间查询 offset 的 client，
+// 按时间查询 offset 需要 kafka 0.10.1 及以上的版本，重放时读取 headers 需要 0.11 及以上的版本
+func newOffsetClient(zookeeper []string, zkConf *kazoo.Config, version sarama.KafkaVersion) (sarama.Client, error) {
+	kz, err := kazoo.NewKazoo(zookeeper, zkConf)
+	if err != nil {
+		return nil, err
+	}
+	defer kz.Close()
+	brokers, err := kz.BrokerList()
+	if err != nil {
+		return nil, err
+	}
+	config := sarama.NewConfig()
+	config.Version = version
+	config.Consumer.Return.Errors = true
+	return sarama.NewClient(brokers, config)
+}
+
+// offsetsForTime 查找每个分区中时间戳不早于 t 的第一条消息的 offset，没有这样的消息时为分区最新的 offset
+func offsetsForTime(client sarama.Client, topics []string, t time.Time) (map[string]map[int32]int64, error) {
+	offsets := make(map[string]map[int32]int64, len(topics))
+	for _, topic := range topics {
+		partitions, err := client.Partitions(topic)
+		if err != nil {
+			return nil, fmt.Errorf("get partitions of topic %s error: %v", topic, err)
+		}
+		offsets[topic] = make(map[int32]int64, len(partitions))
+		for _, partition := range partitions {
+			if offsets[topic][partition], err = offsetForTime(client, topic, partition, t); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return offsets, nil
+}
+
+// offsetForTime 查找分区中时间戳不早于 t 的第一条消息的 offset，没有这样的消息时为分区最新的 offset
+func offsetForTime(client sarama.Client, topic string, partition int32, t time.Time) (int64, error) {
+	offset, err := client.GetOffset(topic, partition, t.UnixNano()/int64(time.Millisecond))
+	if err == nil && offset < 0 {
+		offset, err = client.GetOffset(topic, partition, sarama.OffsetNewest)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("get offset of %s/%d at %v error: %v", topic, partition, t, err)
+	}
+	return offset, nil
+}
+
+// startTimeApplied 是否已经按 start 设置过 consumer 组的 offset
+func startTimeApplied(meta *reader.Meta, start time.Time) bool {
+	data, err := ioutil.ReadFile(filepath.Join(meta.Dir, startTimeMetaFile))
+	if err != nil {
+		return false
+	}
+	applied, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(string(data)))
+	return err == nil && applied.Equal(start)
+}
+
+func markStartTimeApplied(meta *reader.Meta, start time.Time) error {
+	return ioutil.WriteFile(filepath.Join(meta.Dir, startTimeMetaFile), []byte(start.Format(time.RFC3339Nano)), DefaultFilePerm)
+}
+
+// seekGroupToTime 加入 consumer 组之前将组在 zookeeper 中记录的 offset 设置为 start 对应的 offset，
+// 组内其他正在消费的实例会覆盖设置的 offset，需要先停止这些实例
+func seekGroupToTime(meta *reader.Meta, group string, topics []string, zookeeper []string, zkConf *kazoo.Config, start time.Time) error {
+	if startTimeApplied(meta, start) {
+		log.Debugf("Runner[%v] kafka consumer group %s has been set to %v before, ignore...", meta.RunnerName, group, start)
+		return nil
+	}
+	client, err := newOffsetClient(zookeeper, zkConf, sarama.V0_10_1_0)
+	if err != nil {
+		return err
+	}
+	offsets, err := offsetsForTime(client, topics, start)
+	client.Close()
+	if err != nil {
+		return err
+	}
+	kz, err := kazoo.NewKazoo(zookeeper, zkConf)
+	if err != nil {
+		return err
+	}
+	defer kz.Close()
+	cg := kz.Consumergroup(group)
+	exists, err := cg.Exists()
+	if err != nil {
+		return err
+	}
+	if !exists {
+		if err = cg.Create(); err != nil {
+			return err
+		}
+	}
+	for topic, partitions := range offsets {
+		for partition, offset := range partitions {
+			// zookeeper 中记录的是下一条需要读取的消息的 offset
+			if err = cg.CommitOffset(topic, partition, offset); err != nil {
+				return fmt.Errorf("set offset of %s/%d to %d error: %v", topic, partition, offset, err)
+			}
+		}
+	}
+	log.Infof("Runner[%v] kafka consumer group %s was set to consume from %v", meta.RunnerName, group, start)
+	return markStartTimeApplied(meta, start)
+}
+
+// replayProgress 按时间段重放的进度
+type replayProgress struct {
+	Start   time.Time                  `json:"start"`
+	End     time.Time                  `json:"end"`
+	Offsets map[string]map[int32]int64 `json:"offsets"` // 每个分区下一条需要读取的消息的 offset
+}
+
+func readReplayProgress(meta *reader.Meta, start, end time.Time) map[string]map[int32]int64 {
+	data, err := ioutil.ReadFile(filepath.Join(meta.Dir, replayMetaFile))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warnf("Runner[%v] read kafka replay progress error %v, replay from %v", meta.RunnerName, err, start)
+		}
+		return nil
+	}
+	var progress replayProgress
+	if err = jsoniter.Unmarshal(data, &progress); err != nil {
+		log.Warnf("Runner[%v] parse kafka replay progress error %v, replay from %v", meta.RunnerName, err, start)
+		return nil
+	}
+	// 重放的时间段变化后重新开始
+	if !progress.Start.Equal(start) || !progress.End.Equal(end) {
+		return nil
+	}
+	return progress.Offsets
+}
+
+func writeReplayProgress(meta *reader.Meta, progress *replayProgress) error {
+	data, err := jsoniter.Marshal(progress)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(meta.Dir, replayMetaFile), data, DefaultFilePerm)
+}
+
+// replayer 不加入 consumer 组，按分区读取 [starts, ends) 之间的消息，所有分区都读完后结束
+type replayer struct {
+	runnerName string
+	client     sarama.Client
+	consumer   sarama.Consumer
+	starts     map[string]map[int32]int64
+	ends       map[string]map[int32]int64
+
+	messages  chan *sarama.ConsumerMessage
+	errors    chan error
+	remaining int32 // 还没有读完的分区数
+	stopper   chan struct{}
+	wg        sync.WaitGroup
+}
+
+func newReplayer(meta *reader.Meta, client sarama.Client, starts, ends map[string]map[int32]int64) (*replayer, error) {
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return nil, err
+	}
+	rp := &replayer{
+		runnerName: meta.RunnerName,
+		client:     client,
+		consumer:   consumer,
+		starts:     starts,
+		ends:       ends,
+		messages:   make(chan *sarama.ConsumerMessage, 256),
+		errors:     make(chan error, 16),
+		stopper:    make(chan struct{}),
+	}
+	for topic, partitions := range ends {
+		for partition, end := range partitions {
+			start := starts[topic][partition]
+			if start >= end {
+				continue
+			}
+			pc, err := consumer.ConsumePartition(topic, partition, start)
+			if err != nil {
+				rp.close()
+				return nil, fmt.Errorf("consume %s/%d from %d error: %v", topic, partition, start, err)
+			}
+			atomic.AddInt32(&rp.remaining, 1)
+			rp.wg.Add(1)
+			partitionEnd := end
+			meta.Go(fmt.Sprintf("kafka replay %s/%d", topic, partition), func() { rp.consume(pc, partitionEnd) })
+		}
+	}
+	return rp, nil
+}
+
+func (rp *replayer) consume(pc sarama.PartitionConsumer, end int64) {
+	defer rp.wg.Done()
+	defer pc.AsyncClose()
+	for {
+		select {
+		case msg, ok := <-pc.Messages():
+			if !ok {
+				return
+			}
+			if msg.Offset >= end {
+				atomic.AddInt32(&rp.remaining, -1)
+				return
+			}
+			select {
+			case rp.messages <- msg:
+			case <-rp.stopper:
+				return
+			}
+			if msg.Offset+1 >= end {
+				log.Infof("Runner[%v] kafka replay of %s/%d finished at offset %d", rp.runnerName, msg.Topic, msg.Partition, msg.Offset)
+				atomic.AddInt32(&rp.remaining, -1)
+				return
+			}
+		case err, ok := <-pc.Errors():
+			if !ok {
+				return
+			}
+			select {
+			case rp.errors <- err:
+			case <-rp.stopper:
+				return
+			}
+		case <-rp.stopper:
+			return
+		}
+	}
+}
+
+// done 所有分区都已经读完并且读到的消息都已经被取走
+func (rp *replayer) done() bool {
+	return atomic.LoadInt32(&rp.remaining) <= 0 && len(rp.messages) == 0
+}
+
+// lag 根据每个分区最后读取的 offset 计算还没有重放的消息数
+func (rp *replayer) lag(current map[string]map[int32]int64) int64 {
+	var lag int64
+	for topic, partitions := range rp.ends {
+		for partition, end := range partitions {
+			next := rp.starts[topic][partition]
+			if offset, ok := current[topic][partition]; ok && offset+1 > next {
+				next = offset + 1
+			}
+			if end > next {
+				lag += end - next
+			}
+		}
+	}
+	return lag
+}
+
+// progress 根据每个分区最后读取的 offset 计算下一条需要读取的 offset
+func (rp *replayer) progress(current map[string]map[int32]int64) map[string]map[int32]int64 {
+	offsets := make(map[string]map[int32]int64, len(rp.starts))
+	for topic, partitions := range rp.starts {
+		offsets[topic] = make(map[int32]int64, len(partitions))
+		for partition, start := range partitions {
+			offsets[topic][partition] = start
+			if offset, ok := current[topic][partition]; ok && offset+1 > start {
+				offsets[topic][partition] = offset + 1
+			}
+		}
+	}
+	return offsets
+}
+
+func (rp *replayer) close() error {
+	close(rp.stopper)
+	rp.wg.Wait()
+	err := rp.consumer.Close()
+	if cerr := rp.client.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
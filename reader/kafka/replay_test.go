@@ -0,0 +1,142 @@
+package kafka
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/reader"
+	. "github.com/qiniu/logkit/reader/config"
+	. "github.com/qiniu/logkit/reader/test"
+)
+
+func TestParseTime(t *testing.T) {
+	tm, err := parseTime(KeyKafkaStartTime, "")
+	assert.NoError(t, err)
+	assert.True(t, tm.IsZero())
+
+	tm, err = parseTime(KeyKafkaStartTime, "2019-08-07T10:00:00Z")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2019, 8, 7, 10, 0, 0, 0, time.UTC).Unix(), tm.Unix())
+
+	tm, err = parseTime(KeyKafkaStartTime, "2019-08-07 10:00:00")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2019, 8, 7, 10, 0, 0, 0, time.Local).Unix(), tm.Unix())
+
+	_, err = parseTime(KeyKafkaStartTime, "08/07/2019")
+	assert.Error(t, err)
+}
+
+func TestKafkaReplay(t *testing.T) {
+	meta, err := reader.NewMetaWithConf(conf.MapConf{
+		KeyMetaPath: MetaDir,
+		KeyFileDone: MetaDir,
+		KeyMode:     ModeKafka,
+	})
+	assert.NoError(t, err)
+	defer os.RemoveAll(MetaDir)
+
+	start := time.Date(2019, 8, 7, 10, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	startMs, endMs := start.UnixNano()/int64(time.Millisecond), end.UnixNano()/int64(time.Millisecond)
+
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	fetch := sarama.NewMockFetchResponse(t, 1).SetVersion(3).SetHighWaterMark("topic1", 0, 10)
+	for i := int64(0); i < 10; i++ {
+		fetch.SetMessage("topic1", 0, i, sarama.StringEncoder(string('a'+rune(i))))
+	}
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("topic1", 0, broker.BrokerID()),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).SetVersion(1).
+			SetOffset("topic1", 0, startMs, 2).
+			SetOffset("topic1", 0, endMs, 5).
+			SetOffset("topic1", 0, sarama.OffsetNewest, 10).
+			SetOffset("topic1", 0, sarama.OffsetOldest, 0),
+		"FetchRequest": fetch,
+	})
+	newReader := func() *Reader {
+		config := sarama.NewConfig()
+		config.Version = sarama.V0_10_1_0
+		config.Consumer.Return.Errors = true
+		client, err := sarama.NewClient([]string{broker.Addr()}, config)
+		assert.NoError(t, err)
+		starts, err := offsetsForTime(client, []string{"topic1"}, start)
+		assert.NoError(t, err)
+		ends, err := offsetsForTime(client, []string{"topic1"}, end)
+		assert.NoError(t, err)
+		for topic, partitions := range readReplayProgress(meta, start, end) {
+			for partition, offset := range partitions {
+				starts[topic][partition] = offset
+			}
+		}
+		rp, err := newReplayer(meta, client, starts, ends)
+		assert.NoError(t, err)
+		return &Reader{
+			meta:           meta,
+			Topics:         []string{"topic1"},
+			ConsumerGroup:  "group1",
+			replay:         rp,
+			readChan:       rp.messages,
+			errChan:        rp.errors,
+			currentOffsets: map[string]map[int32]int64{"topic1": {}},
+			StartTime:      start,
+			EndTime:        end,
+			lock:           new(sync.Mutex),
+			statsLock:      new(sync.RWMutex),
+		}
+	}
+
+	// 只读取时间段内 offset 为 [2, 5) 的消息，第一条读完后重启从记录的进度继续
+	r := newReader()
+	assert.NoError(t, r.Start())
+	lag, err := r.Lag()
+	assert.NoError(t, err)
+	assert.EqualValues(t, 3, lag.Size)
+	line, err := r.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "c", line)
+	r.SyncMeta()
+	assert.NoError(t, r.Close())
+
+	r = newReader()
+	assert.NoError(t, r.Start())
+	defer r.Close()
+	var lines []string
+	for i := 0; i < 10 && !r.ReadDone(); i++ {
+		if line, _ = r.ReadLine(); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	assert.Equal(t, []string{"d", "e"}, lines)
+	assert.True(t, r.ReadDone())
+	lag, err = r.Lag()
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, lag.Size)
+	r.SyncMeta()
+	assert.Equal(t, map[string]map[int32]int64{"topic1": {0: 5}}, readReplayProgress(meta, start, end))
+	// 时间段变化后重新开始重放
+	assert.Nil(t, readReplayProgress(meta, start, end.Add(time.Hour)))
+}
+
+func TestStartTimeApplied(t *testing.T) {
+	meta, err := reader.NewMetaWithConf(conf.MapConf{
+		KeyMetaPath: MetaDir,
+		KeyFileDone: MetaDir,
+		KeyMode:     ModeKafka,
+	})
+	assert.NoError(t, err)
+	defer os.RemoveAll(MetaDir)
+	start := time.Date(2019, 8, 7, 10, 0, 0, 0, time.UTC)
+	assert.False(t, startTimeApplied(meta, start))
+	assert.NoError(t, markStartTimeApplied(meta, start))
+	assert.True(t, startTimeApplied(meta, start))
+	assert.False(t, startTimeApplied(meta, start.Add(time.Second)))
+}
@@ -0,0 +1,174 @@
+package kafka
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+
+	"github.com/Shopify/sarama"
+
+	. "github.com/qiniu/logkit/reader/config"
+)
+
+var _ sarama.SCRAMClient = &scramClient{}
+
+// scramClient 按照 RFC 5802 实现 SCRAM-SHA-256、SCRAM-SHA-512 的客户端，不支持 channel binding。
+// 与 kafka 的 java 客户端一样，密码不做 SASLprep，直接使用 UTF-8 编码
+type scramClient struct {
+	hash  func() hash.Hash
+	nonce func() (string, error)
+
+	user     string
+	password string
+	step     int
+	done     bool
+
+	clientNonce     string
+	clientFirstBare string
+	serverSignature []byte
+}
+
+// scramClientGenerator 返回 sarama 每次建立连接时创建 SCRAM 客户端的函数
+func scramClientGenerator(mechanism string) func() sarama.SCRAMClient {
+	h := sha256.New
+	if mechanism == KafkaSASLScramSHA512 {
+		h = sha512.New
+	}
+	return func() sarama.SCRAMClient {
+		return &scramClient{hash: h, nonce: scramNonce}
+	}
+}
+
+func scramNonce() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawStdEncoding.EncodeToString(b), nil
+}
+
+func (c *scramClient) Begin(user, password, authzID string) error {
+	if authzID != "" {
+		return errors.New("SCRAM authzid is not supported")
+	}
+	c.user, c.password = user, password
+	c.step, c.done = 0, false
+	return nil
+}
+
+func (c *scramClient) Step(challenge string) (string, error) {
+	c.step++
+	switch c.step {
+	case 1:
+		return c.clientFirst()
+	case 2:
+		return c.clientFinal(challenge)
+	case 3:
+		return "", c.verifyServerFinal(challenge)
+	}
+	return "", errors.New("SCRAM exchange is already finished")
+}
+
+func (c *scramClient) Done() bool {
+	return c.done
+}
+
+func (c *scramClient) clientFirst() (string, error) {
+	nonce, err := c.nonce()
+	if err != nil {
+		return "", err
+	}
+	c.clientNonce = nonce
+	user := strings.NewReplacer("=", "=3D", ",", "=2C").Replace(c.user)
+	c.clientFirstBare = "n=" + user + ",r=" + nonce
+	return "n,," + c.clientFirstBare, nil
+}
+
+func (c *scramClient) clientFinal(serverFirst string) (string, error) {
+	attrs := parseScramAttrs(serverFirst)
+	if e, ok := attrs["e"]; ok {
+		return "", fmt.Errorf("SCRAM server error: %v", e)
+	}
+	nonce := attrs["r"]
+	if !strings.HasPrefix(nonce, c.clientNonce) || len(nonce) == len(c.clientNonce) {
+		return "", errors.New("SCRAM server nonce does not extend the client nonce")
+	}
+	salt, err := base64.StdEncoding.DecodeString(attrs["s"])
+	if err != nil || len(salt) == 0 {
+		return "", fmt.Errorf("SCRAM server salt %q is invalid", attrs["s"])
+	}
+	iterations, err := strconv.Atoi(attrs["i"])
+	if err != nil || iterations <= 0 {
+		return "", fmt.Errorf("SCRAM server iteration count %q is invalid", attrs["i"])
+	}
+
+	// gs2 头 "n,," 表示不使用 channel binding
+	withoutProof := "c=biws,r=" + nonce
+	authMessage := []byte(c.clientFirstBare + "," + serverFirst + "," + withoutProof)
+	saltedPassword := scramHi(c.hash, []byte(c.password), salt, iterations)
+	clientKey := scramHMAC(c.hash, saltedPassword, []byte("Client Key"))
+	storedKey := c.hash()
+	storedKey.Write(clientKey)
+	proof := scramHMAC(c.hash, storedKey.Sum(nil), authMessage)
+	for i := range proof {
+		proof[i] ^= clientKey[i]
+	}
+	c.serverSignature = scramHMAC(c.hash, scramHMAC(c.hash, saltedPassword, []byte("Server Key")), authMessage)
+	return withoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof), nil
+}
+
+func (c *scramClient) verifyServerFinal(serverFinal string) error {
+	attrs := parseScramAttrs(serverFinal)
+	if e, ok := attrs["e"]; ok {
+		return fmt.Errorf("SCRAM server error: %v", e)
+	}
+	signature, err := base64.StdEncoding.DecodeString(attrs["v"])
+	if err != nil || !hmac.Equal(signature, c.serverSignature) {
+		return errors.New("SCRAM server signature does not match")
+	}
+	c.done = true
+	return nil
+}
+
+// parseScramAttrs 解析 "k=v,k=v" 格式的 SCRAM 消息，v 中可能包含 '='
+func parseScramAttrs(msg string) map[string]string {
+	attrs := make(map[string]string)
+	for _, part := range strings.Split(msg, ",") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) == 2 {
+			attrs[kv[0]] = kv[1]
+		}
+	}
+	return attrs
+}
+
+func scramHMAC(h func() hash.Hash, key, data []byte) []byte {
+	mac := hmac.New(h, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// scramHi 即输出长度为一个摘要长度的 PBKDF2
+func scramHi(h func() hash.Hash, password, salt []byte, iterations int) []byte {
+	mac := hmac.New(h, password)
+	mac.Write(salt)
+	mac.Write([]byte{0, 0, 0, 1})
+	u := mac.Sum(nil)
+	result := append([]byte(nil), u...)
+	for i := 1; i < iterations; i++ {
+		mac.Reset()
+		mac.Write(u)
+		u = mac.Sum(u[:0])
+		for j := range result {
+			result[j] ^= u[j]
+		}
+	}
+	return result
+}
@@ -0,0 +1,178 @@
+package kafka
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/qiniu/logkit/conf"
+	. "github.com/qiniu/logkit/reader/config"
+)
+
+// RFC 7677 中 SCRAM-SHA-256 的示例
+func TestScramClient(t *testing.T) {
+	c := &scramClient{hash: sha256.New, nonce: func() (string, error) { return "rOprNGfwEbeRWgbNEkqO", nil }}
+	require.NoError(t, c.Begin("user", "pencil", ""))
+	msg, err := c.Step("")
+	require.NoError(t, err)
+	assert.Equal(t, "n,,n=user,r=rOprNGfwEbeRWgbNEkqO", msg)
+	assert.False(t, c.Done())
+
+	msg, err = c.Step("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096")
+	require.NoError(t, err)
+	assert.Equal(t, "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ=", msg)
+	assert.False(t, c.Done())
+
+	_, err = c.Step("v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=")
+	require.NoError(t, err)
+	assert.True(t, c.Done())
+
+	// 服务端签名不正确或者 nonce 不是客户端 nonce 的延续时认证失败
+	c = &scramClient{hash: sha256.New, nonce: func() (string, error) { return "rOprNGfwEbeRWgbNEkqO", nil }}
+	require.NoError(t, c.Begin("user", "pencil", ""))
+	_, err = c.Step("")
+	require.NoError(t, err)
+	_, err = c.Step("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096")
+	require.NoError(t, err)
+	_, err = c.Step("v=" + base64.StdEncoding.EncodeToString(make([]byte, sha256.Size)))
+	assert.Error(t, err)
+	assert.False(t, c.Done())
+
+	c = &scramClient{hash: sha256.New, nonce: func() (string, error) { return "rOprNGfwEbeRWgbNEkqO", nil }}
+	require.NoError(t, c.Begin("user", "pencil", ""))
+	_, err = c.Step("")
+	require.NoError(t, err)
+	_, err = c.Step("r=other,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096")
+	assert.Error(t, err)
+}
+
+// scramBroker 模拟 broker 的 v1 SASL 握手以及之后通过 SaslAuthenticate 请求进行的 SCRAM 交互
+func scramBroker(t *testing.T, ln net.Listener, password string) {
+	conn, err := ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	// readRequest 读取一个请求，返回 api_key、correlation_id 以及 client_id 之后的请求体
+	readRequest := func() (int16, []byte, []byte) {
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return -1, nil, nil
+		}
+		frame := make([]byte, binary.BigEndian.Uint32(header))
+		if _, err := io.ReadFull(conn, frame); err != nil || len(frame) < 10 {
+			return -1, nil, nil
+		}
+		clientIDLen := int(binary.BigEndian.Uint16(frame[8:]))
+		return int16(binary.BigEndian.Uint16(frame)), frame[4:8], frame[10+clientIDLen:]
+	}
+	writeResponse := func(correlationID, body []byte) {
+		buf := make([]byte, 4, 4+len(correlationID)+len(body))
+		binary.BigEndian.PutUint32(buf, uint32(len(correlationID)+len(body)))
+		conn.Write(append(append(buf, correlationID...), body...))
+	}
+	// authenticate 读取 SaslAuthenticate 请求中的 SCRAM 消息
+	authenticate := func() ([]byte, string) {
+		key, correlationID, body := readRequest()
+		if !assert.EqualValues(t, 36, key) || len(body) < 4 {
+			return nil, ""
+		}
+		return correlationID, string(body[4:])
+	}
+	// authenticated 返回 SaslAuthenticate 的响应：error_code、error_message 和 auth_bytes
+	authenticated := func(correlationID []byte, kerr sarama.KError, msg string) {
+		body := []byte{byte(uint16(kerr) >> 8), byte(kerr), 0xff, 0xff, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(body[4:], uint32(len(msg)))
+		writeResponse(correlationID, append(body, msg...))
+	}
+
+	// SaslHandshakeRequest v1: mechanism
+	key, correlationID, body := readRequest()
+	if !assert.EqualValues(t, 17, key) || len(body) < 2 {
+		return
+	}
+	mechanism := string(body[2:])
+	assert.Equal(t, KafkaSASLScramSHA256, mechanism)
+	writeResponse(correlationID, append([]byte{0, 0, 0, 0, 0, 1, 0, byte(len(mechanism))}, mechanism...))
+
+	correlationID, clientFirst := authenticate()
+	if !assert.True(t, strings.HasPrefix(clientFirst, "n,,n=user,r=")) {
+		return
+	}
+	clientFirstBare := strings.TrimPrefix(clientFirst, "n,,")
+	salt := []byte("logkit-salt")
+	serverFirst := "r=" + parseScramAttrs(clientFirstBare)["r"] + "server,s=" + base64.StdEncoding.EncodeToString(salt) + ",i=4096"
+	authenticated(correlationID, sarama.ErrNoError, serverFirst)
+
+	correlationID, clientFinal := authenticate()
+	idx := strings.Index(clientFinal, ",p=")
+	if !assert.True(t, idx > 0) {
+		return
+	}
+	proof, _ := base64.StdEncoding.DecodeString(clientFinal[idx+3:])
+	authMessage := []byte(clientFirstBare + "," + serverFirst + "," + clientFinal[:idx])
+	saltedPassword := scramHi(sha256.New, []byte(password), salt, 4096)
+	storedKey := sha256.Sum256(scramHMAC(sha256.New, saltedPassword, []byte("Client Key")))
+	clientKey := scramHMAC(sha256.New, storedKey[:], authMessage)
+	for i := range clientKey {
+		if i < len(proof) {
+			clientKey[i] ^= proof[i]
+		}
+	}
+	if sum := sha256.Sum256(clientKey); !hmac.Equal(sum[:], storedKey[:]) {
+		authenticated(correlationID, sarama.ErrSASLAuthenticationFailed, "")
+		return
+	}
+	serverKey := scramHMAC(sha256.New, saltedPassword, []byte("Server Key"))
+	authenticated(correlationID, sarama.ErrNoError, "v="+base64.StdEncoding.EncodeToString(scramHMAC(sha256.New, serverKey, authMessage)))
+	// 等待客户端关闭连接
+	readRequest()
+}
+
+func TestScramBrokerAuth(t *testing.T) {
+	for _, tc := range []struct {
+		password  string
+		connected bool
+	}{
+		{"pencil", true},
+		{"wrong", false},
+	} {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			scramBroker(t, ln, "pencil")
+		}()
+
+		config, err := newBrokerConfig(conf.MapConf{
+			KeyKafkaVersion:       "1.0.0",
+			KeyKafkaSASLMechanism: KafkaSASLScramSHA256,
+			KeyKafkaSASLUser:      "user",
+			KeyKafkaSASLPassword:  tc.password,
+		})
+		require.NoError(t, err)
+		broker := sarama.NewBroker(ln.Addr().String())
+		require.NoError(t, broker.Open(config))
+		connected, err := broker.Connected()
+		assert.Equal(t, tc.connected, connected, tc.password)
+		if tc.connected {
+			assert.NoError(t, err)
+		} else {
+			assert.Error(t, err)
+		}
+		broker.Close()
+		ln.Close()
+		<-done
+	}
+}
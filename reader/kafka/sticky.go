@@ -0,0 +1,137 @@
+package kafka
+
+import (
+	"math"
+	"sort"
+
+	"github.com/Shopify/sarama"
+	"github.com/json-iterator/go"
+)
+
+// stickyStrategyName 与 java 客户端的 sticky 策略使用不同的名称，两者的 UserData 格式不兼容，不能在同一个组内混用
+const stickyStrategyName = "logkit-sticky"
+
+var _ sarama.BalanceStrategy = stickyStrategy{}
+
+// stickyStrategy 在分区数量均衡的前提下尽量保留每个成员上一次分到的分区，减少 rebalance 时分区在成员之间的迁移，
+// 每个成员上一次分到的分区通过加入组时的 UserData 上报。
+// 不支持 cooperative(增量) rebalance：sarama 的 consumer 组每次 rebalance 都会先释放全部分区，
+// 没有 KIP-429 的增量协议，sticky 只能减少分区的迁移，无法避免 rebalance 期间的暂停
+type stickyStrategy struct{}
+
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+func (stickyStrategy) Name() string { return stickyStrategyName }
+
+func (stickyStrategy) Plan(members map[string]sarama.ConsumerGroupMemberMetadata, topics map[string][]int32) (sarama.BalanceStrategyPlan, error) {
+	memberIDs := make([]string, 0, len(members))
+	subscribed := make(map[string]map[string]bool, len(members))
+	for id, meta := range members {
+		memberIDs = append(memberIDs, id)
+		subscribed[id] = make(map[string]bool, len(meta.Topics))
+		for _, topic := range meta.Topics {
+			subscribed[id][topic] = true
+		}
+	}
+	sort.Strings(memberIDs)
+
+	var all []topicPartition
+	exists := make(map[topicPartition]bool)
+	for topic, partitions := range topics {
+		for _, partition := range partitions {
+			tp := topicPartition{topic, partition}
+			all = append(all, tp)
+			exists[tp] = true
+		}
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].topic != all[j].topic {
+			return all[i].topic < all[j].topic
+		}
+		return all[i].partition < all[j].partition
+	})
+
+	owner := make(map[topicPartition]string, len(all))
+	load := make(map[string]int, len(memberIDs))
+	// 保留上一次的分配，同一个分区被多个成员上报时按成员 ID 的顺序保留第一个
+	for _, id := range memberIDs {
+		for topic, partitions := range decodeStickyUserData(members[id].UserData) {
+			if !subscribed[id][topic] {
+				continue
+			}
+			for _, partition := range partitions {
+				tp := topicPartition{topic, partition}
+				if _, ok := owner[tp]; ok || !exists[tp] {
+					continue
+				}
+				owner[tp] = id
+				load[id]++
+			}
+		}
+	}
+
+	// 剩下的分区分给订阅了该 topic 并且分区最少的成员
+	leastLoaded := func(tp topicPartition, below int) string {
+		var best string
+		for _, id := range memberIDs {
+			if subscribed[id][tp.topic] && load[id] < below && (best == "" || load[id] < load[best]) {
+				best = id
+			}
+		}
+		return best
+	}
+	for _, tp := range all {
+		if _, ok := owner[tp]; ok {
+			continue
+		}
+		if id := leastLoaded(tp, math.MaxInt32); id != "" {
+			owner[tp] = id
+			load[id]++
+		}
+	}
+
+	// 分区数相差超过 1 时从分区多的成员迁移到分区少的成员，每次迁移都会减小分区数的方差，因此一定会结束
+	for moved := true; moved; {
+		moved = false
+		for _, tp := range all {
+			cur, ok := owner[tp]
+			if !ok {
+				continue
+			}
+			if id := leastLoaded(tp, load[cur]-1); id != "" {
+				owner[tp] = id
+				load[cur]--
+				load[id]++
+				moved = true
+			}
+		}
+	}
+
+	plan := make(sarama.BalanceStrategyPlan, len(memberIDs))
+	for _, tp := range all {
+		if id, ok := owner[tp]; ok {
+			plan.Add(id, tp.topic, tp.partition)
+		}
+	}
+	return plan, nil
+}
+
+// encodeStickyUserData 将当前分到的分区编码后在下一次加入组时上报
+func encodeStickyUserData(claims map[string][]int32) []byte {
+	data, _ := jsoniter.Marshal(claims)
+	return data
+}
+
+func decodeStickyUserData(data []byte) map[string][]int32 {
+	if len(data) == 0 {
+		return nil
+	}
+	var claims map[string][]int32
+	if err := jsoniter.Unmarshal(data, &claims); err != nil {
+		return nil
+	}
+	return claims
+}
@@ -0,0 +1,443 @@
+package kmsg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/qiniu/log"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/reader"
+	. "github.com/qiniu/logkit/reader/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+var (
+	_ reader.DaemonReader = &Reader{}
+	_ reader.StatsReader  = &Reader{}
+	_ reader.DataReader   = &Reader{}
+	_ reader.Reader       = &Reader{}
+)
+
+// 数据中的字段，设备信息等 key=value 形式的附加信息以小写的 key 作为字段名，与下列字段重名时忽略
+const (
+	FieldMessage   = "message"
+	FieldFacility  = "facility"
+	FieldLevel     = "level"
+	FieldPriority  = "priority"
+	FieldSeq       = "seq"
+	FieldMonotonic = "monotonic_us"
+	FieldTimestamp = "timestamp"
+	FieldBootID    = "boot_id"
+)
+
+const (
+	DefaultKmsgPath = "/dev/kmsg"
+	bootIDPath      = "/proc/sys/kernel/random/boot_id"
+	uptimePath      = "/proc/uptime"
+
+	// 单条内核日志最大为 1024 字节，加上附加信息不会超过一页
+	maxRecordSize = 8192
+)
+
+var facilities = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+var levels = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// 读取开机 id 以及开机时间的方法，测试时替换
+var (
+	readBootID = func() (string, error) {
+		id, err := ioutil.ReadFile(bootIDPath)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(id)), nil
+	}
+	readBootTime = func() (time.Time, error) {
+		content, err := ioutil.ReadFile(uptimePath)
+		if err != nil {
+			return time.Time{}, err
+		}
+		fields := strings.Fields(string(content))
+		if len(fields) == 0 {
+			return time.Time{}, fmt.Errorf("invalid %v content %q", uptimePath, content)
+		}
+		uptime, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Now().Add(-time.Duration(uptime * float64(time.Second))), nil
+	}
+)
+
+func init() {
+	reader.RegisterConstructor(ModeKmsg, NewReader)
+}
+
+// Record 是 /dev/kmsg 中的一条日志
+type Record struct {
+	Priority  int
+	Seq       int64
+	Monotonic int64 // 开机以来的微秒数
+	Message   string
+	Dict      map[string]string
+}
+
+// Facility 返回日志的 facility 名称
+func (r Record) Facility() string {
+	if f := r.Priority >> 3; f < len(facilities) {
+		return facilities[f]
+	}
+	return strconv.Itoa(r.Priority >> 3)
+}
+
+// Level 返回日志的级别名称
+func (r Record) Level() string {
+	return levels[r.Priority&7]
+}
+
+// ParseRecord 解析一条 /dev/kmsg 日志，格式为 "priority,seq,timestamp,flags[,...];message"，之后的每行以空格开头，为 key=value 形式的附加信息
+func ParseRecord(raw []byte) (Record, error) {
+	var record Record
+	raw = bytes.TrimRight(raw, "\n")
+	sep := bytes.IndexByte(raw, ';')
+	if sep < 0 {
+		return record, fmt.Errorf("invalid kmsg record %q: missing ';'", raw)
+	}
+	prefix := strings.Split(string(raw[:sep]), ",")
+	if len(prefix) < 3 {
+		return record, fmt.Errorf("invalid kmsg record %q: prefix should have at least 3 fields", raw)
+	}
+	var err error
+	if record.Priority, err = strconv.Atoi(prefix[0]); err != nil {
+		return record, fmt.Errorf("invalid kmsg priority %q: %v", prefix[0], err)
+	}
+	if record.Seq, err = strconv.ParseInt(prefix[1], 10, 64); err != nil {
+		return record, fmt.Errorf("invalid kmsg sequence %q: %v", prefix[1], err)
+	}
+	if record.Monotonic, err = strconv.ParseInt(prefix[2], 10, 64); err != nil {
+		return record, fmt.Errorf("invalid kmsg timestamp %q: %v", prefix[2], err)
+	}
+
+	lines := strings.Split(string(raw[sep+1:]), "\n")
+	record.Message = lines[0]
+	for _, line := range lines[1:] {
+		if !strings.HasPrefix(line, " ") {
+			continue
+		}
+		kv := strings.SplitN(line[1:], "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		if record.Dict == nil {
+			record.Dict = make(map[string]string)
+		}
+		record.Dict[kv[0]] = kv[1]
+	}
+	return record, nil
+}
+
+// splitRecords 将一次读取的内容拆分为多条日志，从设备读取时每次只会返回一条，从普通文件读取时可能有多条
+func splitRecords(chunk []byte) [][]byte {
+	var records [][]byte
+	start := 0
+	for i := 0; i < len(chunk); i++ {
+		if chunk[i] != '\n' || i+1 >= len(chunk) || chunk[i+1] == ' ' {
+			continue
+		}
+		records = append(records, chunk[start:i+1])
+		start = i + 1
+	}
+	if start < len(chunk) {
+		records = append(records, chunk[start:])
+	}
+	return records
+}
+
+type readInfo struct {
+	data  Data
+	seq   int64
+	bytes int64
+}
+
+type Reader struct {
+	meta *reader.Meta
+	// Note: 原子操作，用于表示 reader 整体的运行状态
+	status int32
+
+	stopChan chan struct{}
+	readChan chan readInfo
+	errChan  chan error
+
+	stats     StatsInfo
+	statsLock sync.RWMutex
+
+	path     string
+	whence   string
+	file     io.ReadCloser
+	bootID   string
+	bootTime time.Time
+	// lastSeq 最近一条已经被上层读取的日志序号，与 bootID 一起记录在 meta 中
+	lastSeq int64
+	// skipSeq 恢复时跳过序号不大于该值的日志
+	skipSeq int64
+}
+
+func NewReader(meta *reader.Meta, c conf.MapConf) (reader.Reader, error) {
+	path, _ := c.GetStringOr(KeyKmsgPath, DefaultKmsgPath)
+	whence, _ := c.GetStringOr(KeyWhence, WhenceOldest)
+	if whence != WhenceOldest && whence != WhenceNewest {
+		return nil, fmt.Errorf("%v should be %v or %v", KeyWhence, WhenceOldest, WhenceNewest)
+	}
+
+	bootID, err := readBootID()
+	if err != nil {
+		log.Warnf("Runner[%v] read boot id failed, resume across reboot is disabled: %v", meta.RunnerName, err)
+	}
+	bootTime, err := readBootTime()
+	if err != nil {
+		log.Warnf("Runner[%v] read boot time failed, timestamp field is disabled: %v", meta.RunnerName, err)
+	}
+
+	r := &Reader{
+		meta:     meta,
+		status:   StatusInit,
+		stopChan: make(chan struct{}),
+		readChan: make(chan readInfo, 1000),
+		errChan:  make(chan error),
+		path:     path,
+		whence:   whence,
+		bootID:   bootID,
+		bootTime: bootTime,
+		lastSeq:  -1,
+		skipSeq:  -1,
+	}
+	// 只有同一次开机的序号才有意义，重启后内核日志缓冲区是全新的，从头读取
+	lastBootID, lastSeq, err := meta.ReadOffset()
+	if err == nil && bootID != "" && lastBootID == bootID {
+		r.lastSeq = lastSeq
+		r.skipSeq = lastSeq
+	} else if err == nil && lastBootID != bootID {
+		log.Infof("Runner[%v] boot id changed from %q to %q, read kmsg from the beginning", meta.RunnerName, lastBootID, bootID)
+		r.whence = WhenceOldest
+	}
+	return r, nil
+}
+
+func (r *Reader) isStopping() bool {
+	return atomic.LoadInt32(&r.status) == StatusStopping
+}
+
+func (r *Reader) hasStopped() bool {
+	return atomic.LoadInt32(&r.status) == StatusStopped
+}
+
+func (r *Reader) Name() string {
+	return "kmsg:" + r.path
+}
+
+func (r *Reader) SetMode(mode string, v interface{}) error {
+	return errors.New("kmsg reader does not support read mode")
+}
+
+func (r *Reader) setStatsError(err string) {
+	r.statsLock.Lock()
+	defer r.statsLock.Unlock()
+	r.stats.LastError = err
+}
+
+func (r *Reader) sendError(err error) {
+	if err == nil {
+		return
+	}
+	defer func() {
+		if rec := recover(); rec != nil {
+			log.Errorf("Reader %q was panicked and recovered from %v", r.Name(), rec)
+		}
+	}()
+	r.errChan <- err
+}
+
+func (r *Reader) open() (io.ReadCloser, error) {
+	f, err := os.Open(r.path)
+	if err != nil {
+		return nil, err
+	}
+	// 没有读取记录且从最新位置读取时跳过缓冲区中已有的日志
+	if r.skipSeq < 0 && r.whence == WhenceNewest {
+		if _, err = f.Seek(0, io.SeekEnd); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+func (r *Reader) Start() error {
+	if r.isStopping() || r.hasStopped() {
+		return errors.New("reader is stopping or has stopped")
+	}
+	if r.file == nil {
+		f, err := r.open()
+		if err != nil {
+			return err
+		}
+		r.file = f
+	}
+	if !atomic.CompareAndSwapInt32(&r.status, StatusInit, StatusRunning) {
+		log.Warnf("Runner[%v] %q daemon has already started and is running", r.meta.RunnerName, r.Name())
+		return nil
+	}
+
+	go r.run()
+	log.Infof("Runner[%v] %q daemon has started", r.meta.RunnerName, r.Name())
+	return nil
+}
+
+func (r *Reader) run() {
+	defer func() {
+		atomic.StoreInt32(&r.status, StatusStopped)
+		close(r.readChan)
+		close(r.errChan)
+		log.Infof("Runner[%v] %q daemon has stopped from running", r.meta.RunnerName, r.Name())
+	}()
+
+	buf := make([]byte, maxRecordSize)
+	for {
+		n, err := r.file.Read(buf)
+		if r.isStopping() || r.hasStopped() {
+			return
+		}
+		if err != nil {
+			// EPIPE 表示未读取的日志已经被内核覆盖，继续读取之后的日志即可
+			if pe, ok := err.(*os.PathError); ok && pe.Err == syscall.EPIPE {
+				log.Warnf("Runner[%v] %q some kernel messages were overwritten before being read", r.meta.RunnerName, r.Name())
+				continue
+			}
+			if err == io.EOF {
+				// 普通文件读取完毕，等待追加
+				select {
+				case <-r.stopChan:
+					return
+				case <-time.After(time.Second):
+				}
+				continue
+			}
+			err = fmt.Errorf("read %v error: %v", r.path, err)
+			log.Errorf("Runner[%v] %q %v", r.meta.RunnerName, r.Name(), err)
+			r.setStatsError(err.Error())
+			r.sendError(err)
+			return
+		}
+		for _, raw := range splitRecords(buf[:n]) {
+			record, err := ParseRecord(raw)
+			if err != nil {
+				log.Errorf("Runner[%v] %q %v", r.meta.RunnerName, r.Name(), err)
+				r.setStatsError(err.Error())
+				continue
+			}
+			if record.Seq <= r.skipSeq {
+				continue
+			}
+			select {
+			case <-r.stopChan:
+				return
+			case r.readChan <- readInfo{data: r.convert(record), seq: record.Seq, bytes: int64(len(raw))}:
+			}
+		}
+	}
+}
+
+func (r *Reader) convert(record Record) Data {
+	data := Data{
+		FieldMessage:   record.Message,
+		FieldFacility:  record.Facility(),
+		FieldLevel:     record.Level(),
+		FieldPriority:  record.Priority,
+		FieldSeq:       record.Seq,
+		FieldMonotonic: record.Monotonic,
+	}
+	if !r.bootTime.IsZero() {
+		data[FieldTimestamp] = r.bootTime.Add(time.Duration(record.Monotonic) * time.Microsecond).Format(time.RFC3339Nano)
+	}
+	if r.bootID != "" {
+		data[FieldBootID] = r.bootID
+	}
+	for k, v := range record.Dict {
+		k = strings.ToLower(k)
+		if _, ok := data[k]; !ok {
+			data[k] = v
+		}
+	}
+	return data
+}
+
+func (r *Reader) Source() string {
+	return r.path
+}
+
+func (r *Reader) ReadLine() (string, error) {
+	return "", errors.New("method ReadLine is not supported, please use ReadData")
+}
+
+func (r *Reader) ReadData() (Data, int64, error) {
+	timer := time.NewTimer(time.Second)
+	defer timer.Stop()
+	select {
+	case info, ok := <-r.readChan:
+		if !ok {
+			return nil, 0, nil
+		}
+		atomic.StoreInt64(&r.lastSeq, info.seq)
+		return info.data, info.bytes, nil
+	case err := <-r.errChan:
+		return nil, 0, err
+	case <-timer.C:
+	}
+
+	return nil, 0, nil
+}
+
+func (r *Reader) Status() StatsInfo {
+	r.statsLock.RLock()
+	defer r.statsLock.RUnlock()
+	return r.stats
+}
+
+func (r *Reader) SyncMeta() {
+	seq := atomic.LoadInt64(&r.lastSeq)
+	if seq < 0 {
+		return
+	}
+	if err := r.meta.WriteOffset(r.bootID, seq); err != nil {
+		log.Errorf("Runner[%v] %v SyncMeta error %v", r.meta.RunnerName, r.Name(), err)
+	}
+}
+
+func (r *Reader) Close() error {
+	if !atomic.CompareAndSwapInt32(&r.status, StatusRunning, StatusStopping) {
+		log.Warnf("Runner[%v] reader %q is not running, close operation ignored", r.meta.RunnerName, r.Name())
+		if r.file != nil {
+			return r.file.Close()
+		}
+		return nil
+	}
+	log.Debugf("Runner[%v] %q daemon is stopping", r.meta.RunnerName, r.Name())
+	close(r.stopChan)
+	// 关闭文件以打断阻塞中的读取
+	return r.file.Close()
+}
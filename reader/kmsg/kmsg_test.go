@@ -0,0 +1,128 @@
+package kmsg
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/reader"
+	. "github.com/qiniu/logkit/reader/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const testKmsg = `6,339,5140900,-;NET: Registered protocol family 10
+ SUBSYSTEM=net
+ DEVICE=+net:lo
+30,340,5690716,-;udevd[80]: starting version 181
+3,341,6000000,c;usb 1-1: device descriptor read/64, error -71
+`
+
+func TestParseRecord(t *testing.T) {
+	record, err := ParseRecord([]byte("6,339,5140900,-;NET: Registered protocol family 10\n SUBSYSTEM=net\n DEVICE=+net:lo\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, Record{
+		Priority:  6,
+		Seq:       339,
+		Monotonic: 5140900,
+		Message:   "NET: Registered protocol family 10",
+		Dict:      map[string]string{"SUBSYSTEM": "net", "DEVICE": "+net:lo"},
+	}, record)
+	assert.Equal(t, "kern", record.Facility())
+	assert.Equal(t, "info", record.Level())
+
+	record, err = ParseRecord([]byte("30,340,5690716,-,caller=T1;udevd[80]: a;b\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, "daemon", record.Facility())
+	assert.Equal(t, "info", record.Level())
+	assert.Equal(t, "udevd[80]: a;b", record.Message)
+
+	record, err = ParseRecord([]byte("186,1,1,-;x"))
+	assert.NoError(t, err)
+	assert.Equal(t, "local7", record.Facility())
+	assert.Equal(t, "crit", record.Level())
+
+	_, err = ParseRecord([]byte("no separator"))
+	assert.Error(t, err)
+	_, err = ParseRecord([]byte("6,abc,1,-;x"))
+	assert.Error(t, err)
+	_, err = ParseRecord([]byte("6,1;x"))
+	assert.Error(t, err)
+}
+
+func TestKmsgReader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kmsg")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "kmsg")
+	assert.NoError(t, ioutil.WriteFile(path, []byte(testKmsg), 0644))
+
+	bootID := "boot-1"
+	bootTime := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	readBootID = func() (string, error) { return bootID, nil }
+	readBootTime = func() (time.Time, error) { return bootTime, nil }
+
+	c := conf.MapConf{
+		KeyMetaPath:   filepath.Join(dir, "meta"),
+		KeyMode:       ModeKmsg,
+		KeyRunnerName: "TestKmsgReader",
+		KeyKmsgPath:   path,
+	}
+	newReader := func() *Reader {
+		meta, err := reader.NewMetaWithConf(c)
+		assert.NoError(t, err)
+		r, err := NewReader(meta, c)
+		assert.NoError(t, err)
+		assert.NoError(t, r.(*Reader).Start())
+		return r.(*Reader)
+	}
+	readData := func(r *Reader) Data {
+		for i := 0; i < 5; i++ {
+			data, _, err := r.ReadData()
+			assert.NoError(t, err)
+			if data != nil {
+				return data
+			}
+		}
+		return nil
+	}
+
+	r := newReader()
+	assert.Equal(t, Data{
+		FieldMessage:   "NET: Registered protocol family 10",
+		FieldFacility:  "kern",
+		FieldLevel:     "info",
+		FieldPriority:  6,
+		FieldSeq:       int64(339),
+		FieldMonotonic: int64(5140900),
+		FieldTimestamp: "2018-01-01T00:00:05.1409Z",
+		FieldBootID:    "boot-1",
+		"subsystem":    "net",
+		"device":       "+net:lo",
+	}, readData(r))
+	data := readData(r)
+	assert.Equal(t, "udevd[80]: starting version 181", data[FieldMessage])
+	assert.Equal(t, "daemon", data[FieldFacility])
+	r.SyncMeta()
+	assert.NoError(t, r.Close())
+
+	// 同一次开机内重启，从上次读取的序号之后继续
+	r = newReader()
+	data = readData(r)
+	assert.Equal(t, int64(341), data[FieldSeq])
+	assert.Equal(t, "err", data[FieldLevel])
+	r.SyncMeta()
+	assert.NoError(t, r.Close())
+
+	// 机器重启后开机 id 变化，从头读取
+	bootID = "boot-2"
+	r = newReader()
+	data = readData(r)
+	assert.Equal(t, int64(339), data[FieldSeq])
+	assert.Equal(t, "boot-2", data[FieldBootID])
+	assert.NoError(t, r.Close())
+}
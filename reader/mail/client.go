@@ -0,0 +1,281 @@
+package mail
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// 单个 IMAP 字面量的最大长度，即单封邮件的最大大小
+	maxLiteralSize = 64 << 20
+)
+
+var (
+	literalRegex     = regexp.MustCompile(`\{(\d+)\}$`)
+	uidValidityRegex = regexp.MustCompile(`(?i)\[UIDVALIDITY (\d+)\]`)
+)
+
+func dial(address string, tlsConfig *tls.Config, timeout time.Duration) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	if tlsConfig != nil {
+		return tls.DialWithDialer(dialer, "tcp", address, tlsConfig)
+	}
+	return dialer.Dial("tcp", address)
+}
+
+// imapClient 实现读取邮件需要的 IMAP4rev1 命令子集
+type imapClient struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	tag     int
+	timeout time.Duration
+}
+
+type imapResponse struct {
+	line     string // 去掉字面量之后的响应内容
+	literals [][]byte
+}
+
+func dialIMAP(address string, tlsConfig *tls.Config, timeout time.Duration) (*imapClient, error) {
+	conn, err := dial(address, tlsConfig, timeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &imapClient{conn: conn, r: bufio.NewReader(conn), timeout: timeout}
+	conn.SetDeadline(time.Now().Add(timeout))
+	greeting, err := c.readResponse()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !strings.HasPrefix(greeting.line, "* OK") && !strings.HasPrefix(greeting.line, "* PREAUTH") {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected imap greeting %q", greeting.line)
+	}
+	return c, nil
+}
+
+func (c *imapClient) readResponse() (imapResponse, error) {
+	var resp imapResponse
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			return resp, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		match := literalRegex.FindStringSubmatch(line)
+		if match == nil {
+			resp.line += line
+			return resp, nil
+		}
+		size, err := strconv.Atoi(match[1])
+		if err != nil || size > maxLiteralSize {
+			return resp, fmt.Errorf("invalid imap literal size %q", match[1])
+		}
+		resp.line += line[:len(line)-len(match[0])]
+		literal := make([]byte, size)
+		if _, err = io.ReadFull(c.r, literal); err != nil {
+			return resp, err
+		}
+		resp.literals = append(resp.literals, literal)
+	}
+}
+
+// command 发送命令并读取到对应的结束响应，返回其间的所有未标记响应，name 只用于错误信息，避免泄露密码
+func (c *imapClient) command(name, format string, args ...interface{}) ([]imapResponse, error) {
+	c.tag++
+	tag := "a" + strconv.Itoa(c.tag)
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	if _, err := fmt.Fprintf(c.conn, tag+" "+format+"\r\n", args...); err != nil {
+		return nil, err
+	}
+	var responses []imapResponse
+	for {
+		resp, err := c.readResponse()
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(resp.line, tag+" ") {
+			responses = append(responses, resp)
+			continue
+		}
+		status := strings.TrimPrefix(resp.line, tag+" ")
+		if !strings.HasPrefix(strings.ToUpper(status), "OK") {
+			return nil, fmt.Errorf("imap %s failed: %s", name, status)
+		}
+		return responses, nil
+	}
+}
+
+// quoteIMAP 将字符串转换为 IMAP 的 quoted string
+func quoteIMAP(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+func (c *imapClient) login(username, password string) error {
+	_, err := c.command("LOGIN", "LOGIN %s %s", quoteIMAP(username), quoteIMAP(password))
+	return err
+}
+
+// selectMailbox 以只读方式打开邮箱，返回 UIDVALIDITY
+func (c *imapClient) selectMailbox(mailbox string) (uint32, error) {
+	responses, err := c.command("EXAMINE", "EXAMINE %s", quoteIMAP(mailbox))
+	if err != nil {
+		return 0, err
+	}
+	for _, resp := range responses {
+		if match := uidValidityRegex.FindStringSubmatch(resp.line); match != nil {
+			validity, err := strconv.ParseUint(match[1], 10, 32)
+			if err != nil {
+				return 0, fmt.Errorf("invalid imap UIDVALIDITY %q", match[1])
+			}
+			return uint32(validity), nil
+		}
+	}
+	return 0, errors.New("imap EXAMINE response has no UIDVALIDITY")
+}
+
+// searchUIDs 返回所有 UID 大于 after 的邮件，按 UID 升序排列
+func (c *imapClient) searchUIDs(after uint32) ([]uint32, error) {
+	responses, err := c.command("UID SEARCH", "UID SEARCH UID %d:*", after+1)
+	if err != nil {
+		return nil, err
+	}
+	var uids []uint32
+	for _, resp := range responses {
+		fields := strings.Fields(resp.line)
+		if len(fields) < 2 || fields[0] != "*" || !strings.EqualFold(fields[1], "SEARCH") {
+			continue
+		}
+		for _, field := range fields[2:] {
+			uid, err := strconv.ParseUint(field, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid imap UID %q", field)
+			}
+			// n:* 总是包含最大的 UID，即使它小于 n
+			if uint32(uid) > after {
+				uids = append(uids, uint32(uid))
+			}
+		}
+	}
+	sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+	return uids, nil
+}
+
+// fetch 获取邮件的完整内容，使用 BODY.PEEK 不会将邮件标记为已读
+func (c *imapClient) fetch(uid uint32) ([]byte, error) {
+	responses, err := c.command("UID FETCH", "UID FETCH %d BODY.PEEK[]", uid)
+	if err != nil {
+		return nil, err
+	}
+	for _, resp := range responses {
+		if strings.Contains(strings.ToUpper(resp.line), "FETCH") && len(resp.literals) > 0 {
+			return resp.literals[0], nil
+		}
+	}
+	return nil, fmt.Errorf("imap message %d not found", uid)
+}
+
+func (c *imapClient) close() error {
+	c.command("LOGOUT", "LOGOUT")
+	return c.conn.Close()
+}
+
+// pop3Client 实现读取邮件需要的 POP3 命令子集
+type pop3Client struct {
+	conn    net.Conn
+	tp      *textproto.Conn
+	timeout time.Duration
+}
+
+type pop3Message struct {
+	number int
+	uid    string
+}
+
+func dialPOP3(address string, tlsConfig *tls.Config, timeout time.Duration) (*pop3Client, error) {
+	conn, err := dial(address, tlsConfig, timeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &pop3Client{conn: conn, tp: textproto.NewConn(conn), timeout: timeout}
+	conn.SetDeadline(time.Now().Add(timeout))
+	greeting, err := c.tp.ReadLine()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !strings.HasPrefix(greeting, "+OK") {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected pop3 greeting %q", greeting)
+	}
+	return c, nil
+}
+
+// command 发送命令，multiline 为 true 时读取以 "." 结尾的多行响应，name 只用于错误信息，避免泄露密码
+func (c *pop3Client) command(name string, multiline bool, format string, args ...interface{}) ([]byte, error) {
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	if err := c.tp.PrintfLine(format, args...); err != nil {
+		return nil, err
+	}
+	line, err := c.tp.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "+OK") {
+		return nil, fmt.Errorf("pop3 %s failed: %s", name, line)
+	}
+	if !multiline {
+		return nil, nil
+	}
+	return c.tp.ReadDotBytes()
+}
+
+func (c *pop3Client) login(username, password string) error {
+	if _, err := c.command("USER", false, "USER %s", username); err != nil {
+		return err
+	}
+	_, err := c.command("PASS", false, "PASS %s", password)
+	return err
+}
+
+// uidl 返回邮箱中所有邮件的序号以及 UID，按序号升序排列
+func (c *pop3Client) uidl() ([]pop3Message, error) {
+	data, err := c.command("UIDL", true, "UIDL")
+	if err != nil {
+		return nil, err
+	}
+	var messages []pop3Message
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		number, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid pop3 UIDL line %q", line)
+		}
+		messages = append(messages, pop3Message{number: number, uid: fields[1]})
+	}
+	sort.Slice(messages, func(i, j int) bool { return messages[i].number < messages[j].number })
+	return messages, nil
+}
+
+func (c *pop3Client) retr(number int) ([]byte, error) {
+	return c.command("RETR", true, "RETR %d", number)
+}
+
+func (c *pop3Client) close() error {
+	c.command("QUIT", false, "QUIT")
+	return c.conn.Close()
+}
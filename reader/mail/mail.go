@@ -0,0 +1,512 @@
+package mail
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/qiniu/log"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/reader"
+	. "github.com/qiniu/logkit/reader/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+var (
+	_ reader.DaemonReader = &Reader{}
+	_ reader.StatsReader  = &Reader{}
+	_ reader.DataReader   = &Reader{}
+	_ reader.Reader       = &Reader{}
+)
+
+// 数据中的字段，每封邮件为一条数据
+const (
+	FieldUID           = "mail_uid"
+	FieldMailbox       = "mail_mailbox"
+	FieldMessageID     = "message_id"
+	FieldSubject       = "subject"
+	FieldFrom          = "from"
+	FieldTo            = "to"
+	FieldCc            = "cc"
+	FieldDate          = "date"
+	FieldContentType   = "content_type"
+	FieldBody          = "body"
+	FieldBodyTruncated = "body_truncated"
+	FieldAttachments   = "attachments"
+	FieldSize          = "size"
+)
+
+const (
+	DefaultMailbox      = "INBOX"
+	DefaultPollInterval = time.Minute
+	DefaultMaxBodySize  = 1 << 20
+
+	// 单个命令的超时时间，拉取大邮件时包含传输的时间
+	commandTimeout = time.Minute
+)
+
+func init() {
+	reader.RegisterConstructor(ModeMail, NewReader)
+}
+
+// state 是记录在 meta 中的读取进度，IMAP 记录 UIDVALIDITY 以及最后读取的 UID，POP3 记录服务器上仍然存在且已经读取的邮件 UID
+type state struct {
+	UIDValidity uint32   `json:"uid_validity,omitempty"`
+	LastUID     uint32   `json:"last_uid,omitempty"`
+	UIDLs       []string `json:"uidls,omitempty"`
+}
+
+type readInfo struct {
+	data        Data
+	uidValidity uint32
+	uid         uint32
+	uidl        string
+	bytes       int64
+}
+
+type Reader struct {
+	meta *reader.Meta
+	// Note: 原子操作，用于表示 reader 整体的运行状态
+	status int32
+
+	stopChan chan struct{}
+	readChan chan readInfo
+
+	stats     StatsInfo
+	statsLock sync.RWMutex
+
+	protocol     string
+	address      string
+	username     string
+	password     string
+	mailbox      string
+	tlsConfig    *tls.Config
+	pollInterval time.Duration
+	maxBodySize  int
+	whence       string
+
+	// 已经被上层读取的进度，SyncMeta 时写入 meta
+	stateLock   sync.Mutex
+	uidValidity uint32
+	lastUID     uint32
+	seen        map[string]bool
+	dirty       bool
+
+	// 以下字段只在拉取邮件的 goroutine 中使用，记录已经放入 readChan 的进度
+	fresh           bool // meta 中没有读取记录
+	fetchedValidity uint32
+	fetchedUID      uint32
+	fetched         map[string]bool
+}
+
+func NewReader(meta *reader.Meta, c conf.MapConf) (reader.Reader, error) {
+	protocol, _ := c.GetStringOr(KeyMailProtocol, MailProtocolIMAP)
+	if protocol != MailProtocolIMAP && protocol != MailProtocolPOP3 {
+		return nil, fmt.Errorf("%v should be %v or %v", KeyMailProtocol, MailProtocolIMAP, MailProtocolPOP3)
+	}
+	address, err := c.GetString(KeyMailAddress)
+	if err != nil {
+		return nil, err
+	}
+	username, err := c.GetString(KeyMailUsername)
+	if err != nil {
+		return nil, err
+	}
+	password, err := c.GetPasswordEnvString(KeyMailPassword)
+	if err != nil {
+		return nil, err
+	}
+	mailbox, _ := c.GetStringOr(KeyMailMailbox, DefaultMailbox)
+	useTLS, _ := c.GetBoolOr(KeyMailTLS, true)
+	insecureSkipVerify, _ := c.GetBoolOr(KeyMailInsecureSkipVerify, false)
+	pollIntervalStr, _ := c.GetStringOr(KeyMailPollInterval, DefaultPollInterval.String())
+	pollInterval, err := time.ParseDuration(pollIntervalStr)
+	if err != nil {
+		return nil, fmt.Errorf("parse %v %q error: %v", KeyMailPollInterval, pollIntervalStr, err)
+	}
+	if pollInterval <= 0 {
+		pollInterval = DefaultPollInterval
+	}
+	maxBodySize, _ := c.GetIntOr(KeyMailMaxBodySize, DefaultMaxBodySize)
+	whence, _ := c.GetStringOr(KeyWhence, WhenceOldest)
+	if whence != WhenceOldest && whence != WhenceNewest {
+		return nil, fmt.Errorf("%v should be %v or %v", KeyWhence, WhenceOldest, WhenceNewest)
+	}
+
+	r := &Reader{
+		meta:         meta,
+		status:       StatusInit,
+		stopChan:     make(chan struct{}),
+		readChan:     make(chan readInfo, 100),
+		protocol:     protocol,
+		address:      address,
+		username:     username,
+		password:     password,
+		mailbox:      mailbox,
+		pollInterval: pollInterval,
+		maxBodySize:  maxBodySize,
+		whence:       whence,
+		seen:         make(map[string]bool),
+		fetched:      make(map[string]bool),
+	}
+	if useTLS {
+		r.tlsConfig = &tls.Config{InsecureSkipVerify: insecureSkipVerify}
+	}
+
+	st, err := readState(meta)
+	if err != nil {
+		if os.IsNotExist(err) {
+			log.Debugf("Runner[%v] %v recover from meta error %v, ignore...", meta.RunnerName, r.Name(), err)
+		} else {
+			log.Warnf("Runner[%v] %v recover from meta error %v, ignore...", meta.RunnerName, r.Name(), err)
+		}
+		r.fresh = true
+		return r, nil
+	}
+	r.uidValidity, r.lastUID = st.UIDValidity, st.LastUID
+	r.fetchedValidity, r.fetchedUID = st.UIDValidity, st.LastUID
+	for _, uidl := range st.UIDLs {
+		r.seen[uidl] = true
+		r.fetched[uidl] = true
+	}
+	return r, nil
+}
+
+func readState(meta *reader.Meta) (st state, err error) {
+	_, _, bufsize, err := meta.ReadBufMeta()
+	if err != nil {
+		return st, err
+	}
+	buf := make([]byte, bufsize)
+	if _, err = meta.ReadBuf(buf); err != nil {
+		return st, err
+	}
+	err = json.Unmarshal(buf, &st)
+	return st, err
+}
+
+func (r *Reader) isStopping() bool {
+	return atomic.LoadInt32(&r.status) == StatusStopping
+}
+
+func (r *Reader) hasStopped() bool {
+	return atomic.LoadInt32(&r.status) == StatusStopped
+}
+
+func (r *Reader) Name() string {
+	if r.protocol == MailProtocolIMAP {
+		return "mail:" + r.protocol + "://" + r.username + "@" + r.address + "/" + r.mailbox
+	}
+	return "mail:" + r.protocol + "://" + r.username + "@" + r.address
+}
+
+func (r *Reader) SetMode(mode string, v interface{}) error {
+	return errors.New("mail reader does not support read mode")
+}
+
+func (r *Reader) setStatsError(err string) {
+	r.statsLock.Lock()
+	defer r.statsLock.Unlock()
+	r.stats.LastError = err
+}
+
+func (r *Reader) Start() error {
+	if r.isStopping() || r.hasStopped() {
+		return errors.New("reader is stopping or has stopped")
+	}
+	if !atomic.CompareAndSwapInt32(&r.status, StatusInit, StatusRunning) {
+		log.Warnf("Runner[%v] %q daemon has already started and is running", r.meta.RunnerName, r.Name())
+		return nil
+	}
+
+	go r.run()
+	log.Infof("Runner[%v] %q daemon has started", r.meta.RunnerName, r.Name())
+	return nil
+}
+
+func (r *Reader) run() {
+	defer func() {
+		atomic.StoreInt32(&r.status, StatusStopped)
+		close(r.readChan)
+		log.Infof("Runner[%v] %q daemon has stopped from running", r.meta.RunnerName, r.Name())
+	}()
+
+	ticker := time.NewTicker(r.pollInterval)
+	defer ticker.Stop()
+	for {
+		var err error
+		if r.protocol == MailProtocolIMAP {
+			err = r.pollIMAP()
+		} else {
+			err = r.pollPOP3()
+		}
+		if r.isStopping() || r.hasStopped() {
+			return
+		}
+		// 网络或者服务器错误在下一次拉取时重试
+		if err != nil {
+			log.Errorf("Runner[%v] %q poll mailbox error: %v", r.meta.RunnerName, r.Name(), err)
+			r.setStatsError(err.Error())
+		}
+		select {
+		case <-r.stopChan:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Reader) pollIMAP() error {
+	c, err := dialIMAP(r.address, r.tlsConfig, commandTimeout)
+	if err != nil {
+		return err
+	}
+	defer c.close()
+	if err = c.login(r.username, r.password); err != nil {
+		return err
+	}
+	validity, err := c.selectMailbox(r.mailbox)
+	if err != nil {
+		return err
+	}
+	// UIDVALIDITY 变化说明邮箱被重建，之前记录的 UID 已经没有意义，从头读取
+	if validity != r.fetchedValidity {
+		if r.fetchedValidity != 0 {
+			log.Warnf("Runner[%v] %q UIDVALIDITY changed from %d to %d, read mailbox from the beginning", r.meta.RunnerName, r.Name(), r.fetchedValidity, validity)
+		}
+		r.fetchedValidity, r.fetchedUID = validity, 0
+	}
+	uids, err := c.searchUIDs(r.fetchedUID)
+	if err != nil {
+		return err
+	}
+	if r.fresh {
+		r.fresh = false
+		// 没有读取记录且从最新位置读取时跳过邮箱中已有的邮件
+		if r.whence == WhenceNewest {
+			if len(uids) > 0 {
+				r.fetchedUID = uids[len(uids)-1]
+			}
+			r.commit(readInfo{uidValidity: validity, uid: r.fetchedUID})
+			return nil
+		}
+	}
+	for _, uid := range uids {
+		raw, err := c.fetch(uid)
+		if err != nil {
+			return err
+		}
+		if !r.emit(raw, readInfo{uidValidity: validity, uid: uid}, strconv.FormatUint(uint64(uid), 10)) {
+			return nil
+		}
+		r.fetchedUID = uid
+	}
+	return nil
+}
+
+func (r *Reader) pollPOP3() error {
+	c, err := dialPOP3(r.address, r.tlsConfig, commandTimeout)
+	if err != nil {
+		return err
+	}
+	defer c.close()
+	if err = c.login(r.username, r.password); err != nil {
+		return err
+	}
+	messages, err := c.uidl()
+	if err != nil {
+		return err
+	}
+	present := make(map[string]bool, len(messages))
+	for _, m := range messages {
+		present[m.uid] = true
+	}
+	// 只保留服务器上仍然存在的邮件，避免读取记录无限增长
+	for uidl := range r.fetched {
+		if !present[uidl] {
+			delete(r.fetched, uidl)
+		}
+	}
+	r.prune(present)
+	if r.fresh {
+		r.fresh = false
+		if r.whence == WhenceNewest {
+			for _, m := range messages {
+				r.fetched[m.uid] = true
+				r.commit(readInfo{uidl: m.uid})
+			}
+			return nil
+		}
+	}
+	for _, m := range messages {
+		if r.fetched[m.uid] {
+			continue
+		}
+		raw, err := c.retr(m.number)
+		if err != nil {
+			return err
+		}
+		if !r.emit(raw, readInfo{uidl: m.uid}, m.uid) {
+			return nil
+		}
+		r.fetched[m.uid] = true
+	}
+	return nil
+}
+
+// emit 解析邮件并放入 readChan，reader 停止时返回 false，无法解析的邮件记录错误后跳过
+func (r *Reader) emit(raw []byte, info readInfo, uid string) bool {
+	msg, err := ParseMessage(raw, r.maxBodySize)
+	if err != nil {
+		err = fmt.Errorf("parse mail %v error: %v", uid, err)
+		log.Errorf("Runner[%v] %q %v", r.meta.RunnerName, r.Name(), err)
+		r.setStatsError(err.Error())
+		return true
+	}
+	info.data = r.convert(msg, uid, len(raw))
+	info.bytes = int64(len(raw))
+	select {
+	case <-r.stopChan:
+		return false
+	case r.readChan <- info:
+		return true
+	}
+}
+
+func (r *Reader) convert(msg *Message, uid string, size int) Data {
+	data := Data{
+		FieldUID:           uid,
+		FieldMessageID:     msg.MessageID,
+		FieldSubject:       msg.Subject,
+		FieldFrom:          msg.From,
+		FieldContentType:   msg.ContentType,
+		FieldBody:          msg.Body,
+		FieldBodyTruncated: msg.Truncated,
+		FieldSize:          size,
+	}
+	if r.protocol == MailProtocolIMAP {
+		data[FieldMailbox] = r.mailbox
+	}
+	if len(msg.To) > 0 {
+		data[FieldTo] = msg.To
+	}
+	if len(msg.Cc) > 0 {
+		data[FieldCc] = msg.Cc
+	}
+	if !msg.Date.IsZero() {
+		data[FieldDate] = msg.Date.Format(time.RFC3339)
+	}
+	if len(msg.Attachments) > 0 {
+		attachments := make([]interface{}, 0, len(msg.Attachments))
+		for _, a := range msg.Attachments {
+			attachments = append(attachments, map[string]interface{}{
+				"filename":     a.Filename,
+				"content_type": a.ContentType,
+				"size":         a.Size,
+			})
+		}
+		data[FieldAttachments] = attachments
+	}
+	return data
+}
+
+// commit 记录已经被上层读取的邮件
+func (r *Reader) commit(info readInfo) {
+	r.stateLock.Lock()
+	defer r.stateLock.Unlock()
+	r.dirty = true
+	if r.protocol == MailProtocolPOP3 {
+		r.seen[info.uidl] = true
+		return
+	}
+	if info.uidValidity != r.uidValidity {
+		r.uidValidity, r.lastUID = info.uidValidity, info.uid
+		return
+	}
+	if info.uid > r.lastUID {
+		r.lastUID = info.uid
+	}
+}
+
+func (r *Reader) prune(present map[string]bool) {
+	r.stateLock.Lock()
+	defer r.stateLock.Unlock()
+	for uidl := range r.seen {
+		if !present[uidl] {
+			delete(r.seen, uidl)
+			r.dirty = true
+		}
+	}
+}
+
+func (r *Reader) Source() string {
+	return r.address
+}
+
+func (r *Reader) ReadLine() (string, error) {
+	return "", errors.New("method ReadLine is not supported, please use ReadData")
+}
+
+func (r *Reader) ReadData() (Data, int64, error) {
+	timer := time.NewTimer(time.Second)
+	defer timer.Stop()
+	select {
+	case info, ok := <-r.readChan:
+		if !ok {
+			return nil, 0, nil
+		}
+		r.commit(info)
+		return info.data, info.bytes, nil
+	case <-timer.C:
+	}
+
+	return nil, 0, nil
+}
+
+func (r *Reader) Status() StatsInfo {
+	r.statsLock.RLock()
+	defer r.statsLock.RUnlock()
+	return r.stats
+}
+
+func (r *Reader) SyncMeta() {
+	r.stateLock.Lock()
+	if !r.dirty {
+		r.stateLock.Unlock()
+		return
+	}
+	st := state{UIDValidity: r.uidValidity, LastUID: r.lastUID}
+	for uidl := range r.seen {
+		st.UIDLs = append(st.UIDLs, uidl)
+	}
+	r.dirty = false
+	r.stateLock.Unlock()
+
+	sort.Strings(st.UIDLs)
+	buf, err := json.Marshal(st)
+	if err != nil {
+		log.Errorf("Runner[%v] %v marshal meta error %v", r.meta.RunnerName, r.Name(), err)
+		return
+	}
+	if err = r.meta.WriteBuf(buf, 0, 0, len(buf)); err != nil {
+		log.Errorf("Runner[%v] %v SyncMeta error %v", r.meta.RunnerName, r.Name(), err)
+	}
+}
+
+func (r *Reader) Close() error {
+	if !atomic.CompareAndSwapInt32(&r.status, StatusRunning, StatusStopping) {
+		log.Warnf("Runner[%v] reader %q is not running, close operation ignored", r.meta.RunnerName, r.Name())
+		return nil
+	}
+	log.Debugf("Runner[%v] %q daemon is stopping", r.meta.RunnerName, r.Name())
+	close(r.stopChan)
+	return nil
+}
@@ -0,0 +1,338 @@
+package mail
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/reader"
+	. "github.com/qiniu/logkit/reader/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const testMultipart = "Message-ID: <report-1@appliance>\r\n" +
+	"From: =?UTF-8?B?5ZGK6K2m?= <alert@appliance.local>\r\n" +
+	"To: ops@example.com, \"Dev Team\" <dev@example.com>\r\n" +
+	"Cc: boss@example.com\r\n" +
+	"Subject: =?GBK?B?yM7O8bGouOY=?=\r\n" +
+	"Date: Mon, 02 Jan 2006 15:04:05 +0800\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=\"outer\"\r\n" +
+	"\r\n" +
+	"--outer\r\n" +
+	"Content-Type: multipart/alternative; boundary=\"inner\"\r\n" +
+	"\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/html; charset=utf-8\r\n" +
+	"\r\n" +
+	"<p>disk full</p>\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"disk =E7=A3=81=E7=9B=98 full\r\n" +
+	"--inner--\r\n" +
+	"--outer\r\n" +
+	"Content-Type: application/octet-stream; name=\"report.bin\"\r\n" +
+	"Content-Disposition: attachment; filename=\"report.bin\"\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"AAECAwQ=\r\n" +
+	"--outer--\r\n"
+
+func TestParseMessage(t *testing.T) {
+	msg, err := ParseMessage([]byte(testMultipart), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "report-1@appliance", msg.MessageID)
+	assert.Equal(t, "任务报告", msg.Subject)
+	assert.Equal(t, "告警 <alert@appliance.local>", msg.From)
+	assert.Equal(t, []string{"ops@example.com", "Dev Team <dev@example.com>"}, msg.To)
+	assert.Equal(t, []string{"boss@example.com"}, msg.Cc)
+	assert.Equal(t, time.Date(2006, 1, 2, 7, 4, 5, 0, time.UTC), msg.Date.UTC())
+	assert.Equal(t, "text/plain", msg.ContentType)
+	assert.Equal(t, "disk 磁盘 full", msg.Body)
+	assert.False(t, msg.Truncated)
+	assert.Equal(t, []Attachment{{Filename: "report.bin", ContentType: "application/octet-stream", Size: 5}}, msg.Attachments)
+
+	// 截断时不截断多字节字符
+	msg, err = ParseMessage([]byte(testMultipart), 7)
+	assert.NoError(t, err)
+	assert.Equal(t, "disk ", msg.Body)
+	assert.True(t, msg.Truncated)
+
+	msg, err = ParseMessage([]byte("Subject: hi\r\nContent-Type: text/html\r\n\r\n<b>only html</b>"), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "hi", msg.Subject)
+	assert.Equal(t, "text/html", msg.ContentType)
+	assert.Equal(t, "<b>only html</b>", msg.Body)
+	assert.Empty(t, msg.Attachments)
+}
+
+func testMessage(id int) string {
+	return fmt.Sprintf("Message-ID: <%d@test>\r\nSubject: report %d\r\n\r\nbody %d\r\n.leading dot\r\n", id, id, id)
+}
+
+// fakeMailbox 模拟只支持 reader 所需命令的 IMAP 以及 POP3 服务器
+type fakeMailbox struct {
+	mutex       sync.Mutex
+	uidValidity uint32
+	uids        []uint32
+	messages    map[uint32]string
+}
+
+func newFakeMailbox() *fakeMailbox {
+	return &fakeMailbox{uidValidity: 1, messages: make(map[uint32]string)}
+}
+
+func (m *fakeMailbox) add(uid uint32) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.uids = append(m.uids, uid)
+	m.messages[uid] = testMessage(int(uid))
+}
+
+func (m *fakeMailbox) remove(uid uint32) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for i, u := range m.uids {
+		if u == uid {
+			m.uids = append(m.uids[:i], m.uids[i+1:]...)
+			break
+		}
+	}
+	delete(m.messages, uid)
+}
+
+func (m *fakeMailbox) serve(t *testing.T, handle func(conn net.Conn)) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handle(conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func (m *fakeMailbox) handleIMAP(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	fmt.Fprint(conn, "* OK fake imap ready\r\n")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		tag, cmd := fields[0], fields[1]
+		if len(fields) > 2 {
+			cmd += " " + fields[2]
+		}
+		m.mutex.Lock()
+		switch {
+		case strings.HasPrefix(cmd, "LOGIN"):
+		case strings.HasPrefix(cmd, "EXAMINE"):
+			fmt.Fprintf(conn, "* %d EXISTS\r\n* OK [UIDVALIDITY %d] UIDs valid\r\n", len(m.uids), m.uidValidity)
+		case cmd == "UID SEARCH":
+			from, _ := strconv.Atoi(strings.TrimSuffix(fields[4], ":*"))
+			var found []string
+			for _, uid := range m.uids {
+				if int(uid) >= from || uid == m.uids[len(m.uids)-1] {
+					found = append(found, strconv.Itoa(int(uid)))
+				}
+			}
+			fmt.Fprintf(conn, "* SEARCH %s\r\n", strings.Join(found, " "))
+		case cmd == "UID FETCH":
+			uid, _ := strconv.Atoi(fields[3])
+			raw := m.messages[uint32(uid)]
+			fmt.Fprintf(conn, "* 1 FETCH (UID %d BODY[] {%d}\r\n%s)\r\n", uid, len(raw), raw)
+		case cmd == "LOGOUT":
+			fmt.Fprintf(conn, "* BYE\r\n%s OK LOGOUT completed\r\n", tag)
+			m.mutex.Unlock()
+			return
+		}
+		m.mutex.Unlock()
+		fmt.Fprintf(conn, "%s OK completed\r\n", tag)
+	}
+}
+
+func (m *fakeMailbox) handlePOP3(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	fmt.Fprint(conn, "+OK fake pop3 ready\r\n")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		m.mutex.Lock()
+		switch fields[0] {
+		case "UIDL":
+			fmt.Fprint(conn, "+OK\r\n")
+			for i, uid := range m.uids {
+				fmt.Fprintf(conn, "%d pop-%d\r\n", i+1, uid)
+			}
+			fmt.Fprint(conn, ".\r\n")
+		case "RETR":
+			n, _ := strconv.Atoi(fields[1])
+			raw := m.messages[m.uids[n-1]]
+			fmt.Fprintf(conn, "+OK\r\n%s.\r\n", strings.Replace(raw, "\r\n.", "\r\n..", -1))
+		case "QUIT":
+			fmt.Fprint(conn, "+OK bye\r\n")
+			m.mutex.Unlock()
+			return
+		default:
+			fmt.Fprint(conn, "+OK\r\n")
+		}
+		m.mutex.Unlock()
+	}
+}
+
+func readSubjects(t *testing.T, r *Reader, n int) []string {
+	var subjects []string
+	deadline := time.Now().Add(5 * time.Second)
+	for len(subjects) < n && time.Now().Before(deadline) {
+		data, _, err := r.ReadData()
+		assert.NoError(t, err)
+		if data != nil {
+			subjects = append(subjects, data[FieldSubject].(string))
+		}
+	}
+	return subjects
+}
+
+func newTestReader(t *testing.T, c conf.MapConf) *Reader {
+	meta, err := reader.NewMetaWithConf(c)
+	assert.NoError(t, err)
+	r, err := NewReader(meta, c)
+	assert.NoError(t, err)
+	assert.NoError(t, r.(*Reader).Start())
+	return r.(*Reader)
+}
+
+func TestIMAPReader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mail")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	box := newFakeMailbox()
+	box.add(1)
+	box.add(2)
+	c := conf.MapConf{
+		KeyMetaPath:         filepath.Join(dir, "meta"),
+		KeyMode:             ModeMail,
+		KeyRunnerName:       "TestIMAPReader",
+		KeyMailAddress:      box.serve(t, box.handleIMAP),
+		KeyMailUsername:     "user",
+		KeyMailPassword:     "pass",
+		KeyMailTLS:          "false",
+		KeyMailPollInterval: "50ms",
+	}
+
+	r := newTestReader(t, c)
+	data, _, err := r.ReadData()
+	assert.NoError(t, err)
+	assert.Equal(t, Data{
+		FieldUID:           "1",
+		FieldMailbox:       DefaultMailbox,
+		FieldMessageID:     "1@test",
+		FieldSubject:       "report 1",
+		FieldFrom:          "",
+		FieldContentType:   "text/plain",
+		FieldBody:          "body 1\r\n.leading dot\r\n",
+		FieldBodyTruncated: false,
+		FieldSize:          len(testMessage(1)),
+	}, data)
+	assert.Equal(t, []string{"report 2"}, readSubjects(t, r, 1))
+	r.SyncMeta()
+	assert.NoError(t, r.Close())
+
+	// 重启后只读取新的邮件
+	box.add(3)
+	r = newTestReader(t, c)
+	assert.Equal(t, []string{"report 3"}, readSubjects(t, r, 1))
+	box.add(4)
+	assert.Equal(t, []string{"report 4"}, readSubjects(t, r, 1))
+	r.SyncMeta()
+	assert.NoError(t, r.Close())
+
+	// UIDVALIDITY 变化后从头读取
+	box.mutex.Lock()
+	box.uidValidity = 2
+	box.mutex.Unlock()
+	r = newTestReader(t, c)
+	assert.Equal(t, []string{"report 1", "report 2", "report 3", "report 4"}, readSubjects(t, r, 4))
+	assert.NoError(t, r.Close())
+
+	// 没有读取记录时从最新位置读取
+	c[KeyMetaPath] = filepath.Join(dir, "meta_newest")
+	c[KeyWhence] = WhenceNewest
+	r = newTestReader(t, c)
+	data, _, err = r.ReadData()
+	assert.NoError(t, err)
+	assert.Nil(t, data)
+	box.add(5)
+	assert.Equal(t, []string{"report 5"}, readSubjects(t, r, 1))
+	assert.NoError(t, r.Close())
+}
+
+func TestPOP3Reader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mail")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	box := newFakeMailbox()
+	box.add(1)
+	box.add(2)
+	c := conf.MapConf{
+		KeyMetaPath:         filepath.Join(dir, "meta"),
+		KeyMode:             ModeMail,
+		KeyRunnerName:       "TestPOP3Reader",
+		KeyMailProtocol:     MailProtocolPOP3,
+		KeyMailAddress:      box.serve(t, box.handlePOP3),
+		KeyMailUsername:     "user",
+		KeyMailPassword:     "pass",
+		KeyMailTLS:          "false",
+		KeyMailPollInterval: "50ms",
+	}
+
+	r := newTestReader(t, c)
+	data, _, err := r.ReadData()
+	assert.NoError(t, err)
+	assert.Equal(t, "pop-1", data[FieldUID])
+	assert.Equal(t, "body 1\n.leading dot\n", data[FieldBody])
+	assert.NotContains(t, data, FieldMailbox)
+	assert.Equal(t, []string{"report 2"}, readSubjects(t, r, 1))
+	r.SyncMeta()
+	assert.NoError(t, r.Close())
+
+	// 服务器上删除的邮件不再记录，重启后只读取新的邮件
+	box.remove(1)
+	box.add(3)
+	r = newTestReader(t, c)
+	assert.Equal(t, []string{"report 3"}, readSubjects(t, r, 1))
+	time.Sleep(100 * time.Millisecond)
+	r.SyncMeta()
+	assert.NoError(t, r.Close())
+
+	st, err := readState(r.meta)
+	assert.NoError(t, err)
+	assert.Equal(t, state{UIDLs: []string{"pop-2", "pop-3"}}, st)
+}
@@ -0,0 +1,211 @@
+package mail
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/axgle/mahonia"
+)
+
+// Attachment 是附件的元信息，不包含附件的内容
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Size        int64
+}
+
+// Message 是解析之后的一封邮件
+type Message struct {
+	MessageID   string
+	Subject     string
+	From        string
+	To          []string
+	Cc          []string
+	Date        time.Time
+	ContentType string // 正文的类型，优先使用 text/plain，没有时使用 text/html
+	Body        string
+	Truncated   bool // 正文超过最大长度被截断
+	Attachments []Attachment
+}
+
+var wordDecoder = &mime.WordDecoder{CharsetReader: charsetReader}
+
+// charsetReader 将其他字符集的内容转换为 utf-8
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	charset = strings.ToLower(charset)
+	if charset == "" || charset == "utf-8" || charset == "us-ascii" {
+		return input, nil
+	}
+	decoder := mahonia.NewDecoder(charset)
+	if decoder == nil {
+		return nil, fmt.Errorf("unsupported charset %q", charset)
+	}
+	return decoder.NewReader(input), nil
+}
+
+func decodeHeader(value string) string {
+	decoded, err := wordDecoder.DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
+
+func parseAddressList(header mail.Header, key string) []string {
+	value := header.Get(key)
+	if value == "" {
+		return nil
+	}
+	addrs, err := (&mail.AddressParser{WordDecoder: wordDecoder}).ParseList(value)
+	if err != nil {
+		return []string{decodeHeader(value)}
+	}
+	list := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		list = append(list, formatAddress(addr))
+	}
+	return list
+}
+
+// formatAddress 格式化为 "name <address>"，与 mail.Address.String 不同，不对非 ASCII 的名字编码
+func formatAddress(addr *mail.Address) string {
+	if addr.Name == "" {
+		return addr.Address
+	}
+	return addr.Name + " <" + addr.Address + ">"
+}
+
+// ParseMessage 解析 RFC 5322 格式的邮件，正文超过 maxBodySize 时截断，maxBodySize 小于等于 0 时不限制
+func ParseMessage(raw []byte, maxBodySize int) (*Message, error) {
+	m, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	msg := &Message{
+		MessageID: strings.Trim(m.Header.Get("Message-Id"), "<> "),
+		Subject:   decodeHeader(m.Header.Get("Subject")),
+		To:        parseAddressList(m.Header, "To"),
+		Cc:        parseAddressList(m.Header, "Cc"),
+	}
+	if from := parseAddressList(m.Header, "From"); len(from) > 0 {
+		msg.From = from[0]
+	}
+	if date, err := m.Header.Date(); err == nil {
+		msg.Date = date
+	}
+
+	var plain, html string
+	err = walkPart(mailHeader(m.Header), m.Body, func(contentType string, params map[string]string, disposition string, filename string, body []byte) {
+		if filename != "" || disposition == "attachment" || (!strings.HasPrefix(contentType, "text/") && contentType != "") {
+			msg.Attachments = append(msg.Attachments, Attachment{Filename: filename, ContentType: contentType, Size: int64(len(body))})
+			return
+		}
+		text := decodeCharset(params["charset"], body)
+		switch {
+		case contentType == "text/html" && html == "":
+			html = text
+		case contentType != "text/html" && plain == "":
+			plain = text
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	msg.ContentType, msg.Body = "text/plain", plain
+	if plain == "" && html != "" {
+		msg.ContentType, msg.Body = "text/html", html
+	}
+	if maxBodySize > 0 && len(msg.Body) > maxBodySize {
+		msg.Body = truncateUTF8(msg.Body, maxBodySize)
+		msg.Truncated = true
+	}
+	return msg, nil
+}
+
+// partHeader 统一 net/mail 与 mime/multipart 的头部
+type partHeader interface {
+	Get(key string) string
+}
+
+type mailHeader mail.Header
+
+func (h mailHeader) Get(key string) string {
+	return mail.Header(h).Get(key)
+}
+
+type partFunc func(contentType string, params map[string]string, disposition string, filename string, body []byte)
+
+// walkPart 递归遍历 multipart 的每一个部分，对非 multipart 的部分解码之后调用 fn
+func walkPart(header partHeader, body io.Reader, fn partFunc) error {
+	contentType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		contentType, params = "text/plain", map[string]string{}
+	}
+	if strings.HasPrefix(contentType, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err = walkPart(part.Header, part, fn); err != nil {
+				return err
+			}
+		}
+	}
+
+	data, err := ioutil.ReadAll(decodeTransfer(header.Get("Content-Transfer-Encoding"), body))
+	if err != nil {
+		return err
+	}
+	disposition, dparams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	filename := dparams["filename"]
+	if filename == "" {
+		filename = params["name"]
+	}
+	fn(contentType, params, disposition, decodeHeader(filename), data)
+	return nil
+}
+
+// decodeTransfer 按照 Content-Transfer-Encoding 解码，multipart.Reader 会自动解码 quoted-printable 并去掉该头部
+func decodeTransfer(encoding string, body io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	}
+	return body
+}
+
+func decodeCharset(charset string, body []byte) string {
+	r, err := charsetReader(charset, bytes.NewReader(body))
+	if err != nil {
+		return string(body)
+	}
+	text, err := ioutil.ReadAll(r)
+	if err != nil {
+		return string(body)
+	}
+	return string(text)
+}
+
+// truncateUTF8 截断为不超过 size 字节，并且不截断多字节字符
+func truncateUTF8(s string, size int) string {
+	for size > 0 && size < len(s) && s[size]&0xC0 == 0x80 {
+		size--
+	}
+	return s[:size]
+}
@@ -30,6 +30,8 @@ const (
 	bufMetaFilePath   = "buf.meta"
 	bufFilePath       = "buf.dat"
 	lineCacheFilePath = "cache.dat"
+	pendingFilePath   = "pending.dat"
+	fileIDFileName    = "file.id"
 	statisticFileName = "statistic.meta"
 	doneFileRetention = "donefile_retention"
 	FtSaveLogPath     = "ft_log" // ft log 在 meta 中的文件夹名字
@@ -63,6 +65,8 @@ type Meta struct {
 	bufMetaFilePath   string // 记录buf的offset数据
 	bufFilePath       string // 记录buf数据
 	lineCacheFile     string //记录多行的缓存line
+	pendingFile       string //记录已经读出但还未发送的一行数据
+	fileIDFile        string //记录 offset 所属文件的设备号与 inode
 	donefileretention int    // done.file保留时间，单位为天
 	encodingWay       string //文件编码格式，默认为utf-8
 	logpath           string
@@ -81,6 +85,10 @@ type Meta struct {
 	subMetaExpiredLock sync.Mutex
 	subMetaExpired     map[string]bool // 上次扫描后已知的过期 submeta
 	LastKey            string          // 记录从s3 最近一次拉取的文件
+	metaCodec          MetaCodec       // file.meta 和 buf.meta 的写入格式，默认为兼容旧版本的纯文本格式
+
+	panicLock    sync.RWMutex
+	panicHandler func(reason string) // reader 启动的 goroutine panic 时的处理函数
 }
 
 func getValidDir(dir string) (realPath string, err error) {
@@ -139,6 +147,8 @@ func NewMeta(metadir, filedonedir, logpath, mode, tagfile string, donefileRetent
 		bufFilePath:       filepath.Join(metadir, bufFilePath),
 		bufMetaFilePath:   filepath.Join(metadir, bufMetaFilePath),
 		lineCacheFile:     filepath.Join(metadir, lineCacheFilePath),
+		pendingFile:       filepath.Join(metadir, pendingFilePath),
+		fileIDFile:        filepath.Join(metadir, fileIDFileName),
 		statisticPath:     filepath.Join(metadir, statisticFileName),
 		ftSaveLogPath:     filepath.Join(metadir, FtSaveLogPath),
 		donefileretention: donefileRetention,
@@ -190,6 +200,11 @@ func NewMetaWithConf(conf conf.MapConf) (meta *Meta, err error) {
 	if decoder != "" {
 		meta.SetEncodingWay(strings.ToLower(decoder))
 	}
+	metaEncoding, _ := conf.GetStringOr(KeyMetaEncoding, MetaEncodingText)
+	if err = meta.SetMetaEncoding(metaEncoding); err != nil {
+		log.Warnf("Runner[%v] %s - set meta encoding failed, err:%v", runnerName, metaPath, err)
+		return nil, err
+	}
 	meta.dataSourceTag = datasourceTag
 	meta.encodeTag = encodeTag
 	meta.Readlimit = readlimit * 1024 * 1024 //readlimit*MB
@@ -339,14 +354,107 @@ func (m *Meta) WriteCacheLine(lines string) error {
 	return ioutil.WriteFile(m.CacheLineFile(), []byte(lines), DefaultFilePerm)
 }
 
+func (m *Meta) PendingLineFile() string {
+	return m.pendingFile
+}
+
+// ReadPendingLine 读取关闭时已经读出但还未发送的数据，文件不存在时返回空
+func (m *Meta) ReadPendingLine() (string, error) {
+	data, err := ioutil.ReadFile(m.PendingLineFile())
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	return string(data), err
+}
+
+// WritePendingLine 记录已经读出但还未发送的数据，line 为空时删除记录
+func (m *Meta) WritePendingLine(line string) error {
+	if line == "" {
+		err := os.Remove(m.PendingLineFile())
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return ioutil.WriteFile(m.PendingLineFile(), []byte(line), DefaultFilePerm)
+}
+
+// FileIDRecord 记录 offset 所属文件的标识(设备号与 inode)、路径以及该文件的 offset
+type FileIDRecord struct {
+	ID     string
+	Path   string
+	Offset int64
+}
+
+func readFileIDRecord(path string) (rec FileIDRecord, err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return rec, err
+	}
+	parts := strings.SplitN(strings.TrimSuffix(string(data), "\n"), "\t", 3)
+	if len(parts) != 3 || parts[0] == "" {
+		return rec, fmt.Errorf("file id record %q format error", string(data))
+	}
+	rec.Offset, err = strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return rec, fmt.Errorf("file id record %q format error: %v", string(data), err)
+	}
+	rec.ID, rec.Path = parts[0], parts[2]
+	return rec, nil
+}
+
+// ReadFileID 读取 offset 所属文件的标识，没有记录时返回空的记录
+func (m *Meta) ReadFileID() (FileIDRecord, error) {
+	rec, err := readFileIDRecord(m.fileIDFile)
+	if os.IsNotExist(err) {
+		return FileIDRecord{}, nil
+	}
+	return rec, err
+}
+
+// WriteFileID 记录 offset 所属文件的标识，文件被改名轮转后可以根据标识找到原来的 offset。
+// 与 file.meta 分开记录，两者不一致时以该记录为准判断文件是否被轮转
+func (m *Meta) WriteFileID(rec FileIDRecord) error {
+	return writeMetaFile(m.fileIDFile, []byte(fmt.Sprintf("%s\t%d\t%s\n", rec.ID, rec.Offset, rec.Path)))
+}
+
+// ReadSubMetaFileIDs 读取 dir 下所有 submeta 中记录的文件标识，返回文件标识到记录的映射
+func ReadSubMetaFileIDs(dir string) (map[string]FileIDRecord, error) {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	recs := make(map[string]FileIDRecord)
+	for _, fi := range fis {
+		if !fi.IsDir() {
+			continue
+		}
+		rec, err := readFileIDRecord(filepath.Join(dir, fi.Name(), fileIDFileName))
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log.Warnf("read file id record in %v error %v, ignore...", fi.Name(), err)
+			}
+			continue
+		}
+		recs[rec.ID] = rec
+	}
+	return recs, nil
+}
+
 func (m *Meta) ReadBufMeta() (r, w, bufsize int, err error) {
-	f, err := os.Open(m.BufMetaFile())
+	data, err := ioutil.ReadFile(m.BufMetaFile())
 	if err != nil {
 		return
 	}
-	defer f.Close()
-	_, err = fmt.Fscanf(f, bufMetaFormat, &r, &w, &bufsize)
-	return
+	codec, err := DetectMetaCodec(data)
+	if err != nil {
+		return
+	}
+	rec, err := codec.DecodeBufMeta(data)
+	if err != nil {
+		return
+	}
+	return rec.Read, rec.Write, rec.BufSize, nil
 }
 
 func (m *Meta) ReadBuf(buf []byte) (n int, err error) {
@@ -376,7 +484,12 @@ func (m *Meta) WriteBuf(buf []byte, r, w, bufsize int) (err error) {
 	if err != nil {
 		return
 	}
-	_, err = fmt.Fprintf(f, bufMetaFormat, r, w, bufsize)
+	bufMeta, err := m.getMetaCodec().EncodeBufMeta(BufMetaRecord{Read: r, Write: w, BufSize: bufsize})
+	if err != nil {
+		f.Close()
+		return
+	}
+	_, err = f.Write(bufMeta)
 	if err != nil {
 		f.Close()
 		return
@@ -407,17 +520,22 @@ func (m *Meta) WriteBuf(buf []byte, r, w, bufsize int) (err error) {
 
 // ReadOffset 读取当前读取的文件和offset
 func (m *Meta) ReadOffset() (currFile string, offset int64, err error) {
-	f, err := os.Open(m.MetaFile())
+	data, err := ioutil.ReadFile(m.MetaFile())
 	if err != nil {
 		return
 	}
-	defer f.Close()
-
-	_, err = fmt.Fscanf(f, metaFormat, &currFile, &offset)
+	// 根据内容自动识别编码方式，兼容旧版本写入的 meta
+	codec, err := DetectMetaCodec(data)
 	if err != nil {
 		log.Debugf("meta file format err %v", err)
 		return
 	}
+	rec, err := codec.DecodeOffset(data)
+	if err != nil {
+		log.Debugf("meta file format err %v", err)
+		return
+	}
+	currFile, offset = rec.File, rec.Offset
 	if m.mode == ModeDir || m.mode == ModeFile {
 		_, err = os.Stat(currFile)
 		if err != nil {
@@ -471,7 +589,12 @@ func (m *Meta) WriteOffset(currFile string, offset int64) (err error) {
 	if err != nil {
 		return err
 	}
-	_, err = fmt.Fprintf(f, metaFormat, currFile, offset)
+	content, err := m.getMetaCodec().EncodeOffset(OffsetRecord{File: currFile, Offset: offset})
+	if err != nil {
+		f.Close()
+		return err
+	}
+	_, err = f.Write(content)
 	if err != nil {
 		f.Close()
 		return err
@@ -696,6 +819,36 @@ func (m *Meta) SetEncodingWay(e string) {
 	}
 }
 
+// SetMetaEncoding 设置 file.meta 和 buf.meta 的写入格式，读取时会根据内容自动识别
+func (m *Meta) SetMetaEncoding(name string) error {
+	codec, err := GetMetaCodec(name)
+	if err != nil {
+		return err
+	}
+	m.metaCodec = codec
+
+	m.subMetaLock.RLock()
+	defer m.subMetaLock.RUnlock()
+
+	for _, mv := range m.subMetas {
+		mv.metaCodec = codec
+	}
+	return nil
+}
+
+// GetMetaEncoding 获取 file.meta 和 buf.meta 的写入格式
+func (m *Meta) GetMetaEncoding() string {
+	return m.getMetaCodec().Name()
+}
+
+func (m *Meta) getMetaCodec() MetaCodec {
+	if m.metaCodec == nil {
+		codec, _ := GetMetaCodec(MetaEncodingText)
+		return codec
+	}
+	return m.metaCodec
+}
+
 //GetEncodingWay 获取文件编码方式
 func (m *Meta) GetEncodingWay() (e string) {
 	return m.encodingWay
@@ -725,6 +878,30 @@ func (m *Meta) GetTags() map[string]interface{} {
 	return m.tags
 }
 
+// SetPanicHandler 设置 reader 通过 Go 启动的 goroutine panic 时的处理函数，由 runner 在启动 reader 之前设置
+func (m *Meta) SetPanicHandler(handler func(reason string)) {
+	m.panicLock.Lock()
+	defer m.panicLock.Unlock()
+	m.panicHandler = handler
+}
+
+// Go 在新的 goroutine 中运行 reader 的后台任务，panic 时不会导致进程退出，而是交给 SetPanicHandler 设置的处理函数，
+// 由 runner 交给 supervisor 重启
+func (m *Meta) Go(name string, fn func()) {
+	if m == nil {
+		utils.GoSafe(name, fn, nil)
+		return
+	}
+	utils.GoSafe(fmt.Sprintf("Runner[%v] reader %s", m.RunnerName, name), fn, func(reason string) {
+		m.panicLock.RLock()
+		handler := m.panicHandler
+		m.panicLock.RUnlock()
+		if handler != nil {
+			handler(reason)
+		}
+	})
+}
+
 func (m *Meta) Reset() error {
 	if m == nil {
 		return errors.New("Reset error as meta is nil ")
@@ -0,0 +1,289 @@
+package reader
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const (
+	MetaEncodingText = "text" // 兼容旧版本的纯文本格式
+	MetaEncodingJSON = "json" // 带版本号和长度前缀的 JSON 格式
+
+	// metaHeaderPrefix 自描述格式的头部，完整格式为 "#logkit-meta <codec> v<version> <length>\n<payload>"
+	metaHeaderPrefix = "#logkit-meta "
+	// MetaRecordVersion 当前写入的 meta 记录版本，新增字段时递增，解码时兼容所有不高于该版本的记录
+	MetaRecordVersion = 1
+)
+
+var ErrMetaVersion = errors.New("meta record version is not supported")
+
+// OffsetRecord 对应 file.meta 中记录的读取进度
+type OffsetRecord struct {
+	Version int    `json:"version"`
+	File    string `json:"file"`
+	Offset  int64  `json:"offset"`
+}
+
+// BufMetaRecord 对应 buf.meta 中记录的缓冲区信息
+type BufMetaRecord struct {
+	Version int `json:"version"`
+	Read    int `json:"read"`
+	Write   int `json:"write"`
+	BufSize int `json:"bufsize"`
+}
+
+// MetaCodec 定义 meta 记录的编解码方式，新的编码方式通过 RegisterMetaCodec 注册
+type MetaCodec interface {
+	Name() string
+	EncodeOffset(rec OffsetRecord) ([]byte, error)
+	DecodeOffset(data []byte) (OffsetRecord, error)
+	EncodeBufMeta(rec BufMetaRecord) ([]byte, error)
+	DecodeBufMeta(data []byte) (BufMetaRecord, error)
+}
+
+var (
+	metaCodecsLock sync.RWMutex
+	metaCodecs     = map[string]MetaCodec{}
+)
+
+func init() {
+	RegisterMetaCodec(textMetaCodec{})
+	RegisterMetaCodec(jsonMetaCodec{})
+}
+
+// RegisterMetaCodec 注册 meta 编码方式，同名的编码方式会被覆盖
+func RegisterMetaCodec(codec MetaCodec) {
+	metaCodecsLock.Lock()
+	defer metaCodecsLock.Unlock()
+	metaCodecs[codec.Name()] = codec
+}
+
+// GetMetaCodec 根据名称获取 meta 编码方式
+func GetMetaCodec(name string) (MetaCodec, error) {
+	if name == "" {
+		name = MetaEncodingText
+	}
+	metaCodecsLock.RLock()
+	defer metaCodecsLock.RUnlock()
+	codec, ok := metaCodecs[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("meta encoding %q is not supported", name)
+	}
+	return codec, nil
+}
+
+// DetectMetaCodec 根据内容自动识别 meta 的编码方式，没有头部的内容均视为旧版本的纯文本格式
+func DetectMetaCodec(data []byte) (MetaCodec, error) {
+	if !bytes.HasPrefix(data, []byte(metaHeaderPrefix)) {
+		return GetMetaCodec(MetaEncodingText)
+	}
+	name, _, _, err := parseMetaHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	return GetMetaCodec(name)
+}
+
+// encodeMetaPayload 为 payload 加上自描述头部
+func encodeMetaPayload(name string, version int, payload []byte) []byte {
+	header := fmt.Sprintf("%s%s v%d %d\n", metaHeaderPrefix, name, version, len(payload))
+	return append([]byte(header), payload...)
+}
+
+func parseMetaHeader(data []byte) (name string, version int, payload []byte, err error) {
+	idx := bytes.IndexByte(data, '\n')
+	if idx < 0 || !bytes.HasPrefix(data, []byte(metaHeaderPrefix)) {
+		return "", 0, nil, errors.New("meta header not found")
+	}
+	fields := strings.Fields(string(data[len(metaHeaderPrefix):idx]))
+	if len(fields) != 3 || !strings.HasPrefix(fields[1], "v") {
+		return "", 0, nil, fmt.Errorf("invalid meta header %q", string(data[:idx]))
+	}
+	version, err = strconv.Atoi(strings.TrimPrefix(fields[1], "v"))
+	if err != nil {
+		return "", 0, nil, fmt.Errorf("invalid meta header version %q", fields[1])
+	}
+	length, err := strconv.Atoi(fields[2])
+	if err != nil || length < 0 {
+		return "", 0, nil, fmt.Errorf("invalid meta header length %q", fields[2])
+	}
+	payload = data[idx+1:]
+	if len(payload) < length {
+		return "", 0, nil, fmt.Errorf("meta payload truncated, expect %d bytes but got %d", length, len(payload))
+	}
+	return fields[0], version, payload[:length], nil
+}
+
+// textMetaCodec 旧版本的纯文本格式，文件路径中不能包含空白字符
+type textMetaCodec struct{}
+
+func (textMetaCodec) Name() string {
+	return MetaEncodingText
+}
+
+func (textMetaCodec) EncodeOffset(rec OffsetRecord) ([]byte, error) {
+	return []byte(fmt.Sprintf(metaFormat, rec.File, rec.Offset)), nil
+}
+
+func (textMetaCodec) DecodeOffset(data []byte) (rec OffsetRecord, err error) {
+	_, err = fmt.Sscanf(string(data), metaFormat, &rec.File, &rec.Offset)
+	return
+}
+
+func (textMetaCodec) EncodeBufMeta(rec BufMetaRecord) ([]byte, error) {
+	return []byte(fmt.Sprintf(bufMetaFormat, rec.Read, rec.Write, rec.BufSize)), nil
+}
+
+func (textMetaCodec) DecodeBufMeta(data []byte) (rec BufMetaRecord, err error) {
+	_, err = fmt.Sscanf(string(data), bufMetaFormat, &rec.Read, &rec.Write, &rec.BufSize)
+	return
+}
+
+// jsonMetaCodec 带版本号和长度前缀的 JSON 格式，可以识别不完整的写入，并且方便后续增加字段
+type jsonMetaCodec struct{}
+
+func (jsonMetaCodec) Name() string {
+	return MetaEncodingJSON
+}
+
+func (c jsonMetaCodec) encode(v interface{}) ([]byte, error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return encodeMetaPayload(c.Name(), MetaRecordVersion, payload), nil
+}
+
+func (c jsonMetaCodec) decode(data []byte, v interface{}) error {
+	name, version, payload, err := parseMetaHeader(data)
+	if err != nil {
+		return err
+	}
+	if name != c.Name() {
+		return fmt.Errorf("meta encoding %q mismatch, expect %q", name, c.Name())
+	}
+	if version > MetaRecordVersion {
+		return ErrMetaVersion
+	}
+	return json.Unmarshal(payload, v)
+}
+
+func (c jsonMetaCodec) EncodeOffset(rec OffsetRecord) ([]byte, error) {
+	rec.Version = MetaRecordVersion
+	return c.encode(rec)
+}
+
+func (c jsonMetaCodec) DecodeOffset(data []byte) (rec OffsetRecord, err error) {
+	err = c.decode(data, &rec)
+	return
+}
+
+func (c jsonMetaCodec) EncodeBufMeta(rec BufMetaRecord) ([]byte, error) {
+	rec.Version = MetaRecordVersion
+	return c.encode(rec)
+}
+
+func (c jsonMetaCodec) DecodeBufMeta(data []byte) (rec BufMetaRecord, err error) {
+	err = c.decode(data, &rec)
+	return
+}
+
+// writeMetaFile 先写临时文件再 rename，避免写入中途退出导致 meta 损坏
+func writeMetaFile(fileName string, data []byte) error {
+	tmpFileName := fmt.Sprintf("%s.%d.tmp", fileName, rand.Int())
+	f, err := os.OpenFile(tmpFileName, os.O_RDWR|os.O_CREATE|os.O_TRUNC, DefaultFilePerm)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err != nil {
+		f.Close()
+		os.RemoveAll(tmpFileName)
+		return err
+	}
+	f.Sync()
+	f.Close()
+	return os.Rename(tmpFileName, fileName)
+}
+
+// ConvertMetaDir 将 dir 下(包含子目录中 tailx、dirx 的 submeta)的 file.meta 和 buf.meta 转换为 to 指定的编码方式，返回转换的文件列表。
+// buf.dat 不做转换
+func ConvertMetaDir(dir, to string) (converted []string, err error) {
+	target, err := GetMetaCodec(to)
+	if err != nil {
+		return nil, err
+	}
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		switch info.Name() {
+		case metaFileName, bufMetaFilePath:
+		case bufFilePath:
+			// buf.dat 保存的是 bufreader 缓冲区中的原始数据或者 tailx 序列化后的 cacheMap，是数据本身而不是 meta 记录，
+			// 读写时不经过 MetaCodec，没有 "#logkit-meta" 头部，任何编码方式下内容都相同
+			return nil
+		default:
+			return nil
+		}
+		changed, err := convertMetaFile(path, target)
+		if err != nil {
+			return fmt.Errorf("convert %v error: %v", path, err)
+		}
+		if changed {
+			converted = append(converted, path)
+		}
+		return nil
+	})
+	return converted, err
+}
+
+func convertMetaFile(path string, target MetaCodec) (changed bool, err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	source, err := DetectMetaCodec(data)
+	if err != nil {
+		return false, err
+	}
+	if source.Name() == target.Name() {
+		return false, nil
+	}
+
+	var encoded []byte
+	if filepath.Base(path) == bufMetaFilePath {
+		rec, err := source.DecodeBufMeta(data)
+		if err != nil {
+			return false, err
+		}
+		encoded, err = target.EncodeBufMeta(rec)
+		if err != nil {
+			return false, err
+		}
+	} else {
+		rec, err := source.DecodeOffset(data)
+		if err != nil {
+			return false, err
+		}
+		encoded, err = target.EncodeOffset(rec)
+		if err != nil {
+			return false, err
+		}
+	}
+	return true, writeMetaFile(path, encoded)
+}
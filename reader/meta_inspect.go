@@ -0,0 +1,318 @@
+package reader
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	. "github.com/qiniu/logkit/utils/models"
+	utilsos "github.com/qiniu/logkit/utils/os"
+)
+
+// meta 检查发现的问题类型
+const (
+	// ProblemCorruptMeta file.meta 无法解析，修复时删除，reader 按照 read_from 的配置重新定位
+	ProblemCorruptMeta = "corrupt_meta"
+	// ProblemOffsetBeyondSize offset 超过了文件大小，文件被截断或者替换过，修复时从头读取
+	ProblemOffsetBeyondSize = "offset_beyond_size"
+	// ProblemOrphanSubMeta tailx、dirx 的 submeta 对应的文件或目录已经不存在，修复时删除
+	ProblemOrphanSubMeta = "orphan_submeta"
+	// ProblemFileMissing 记录的当前文件已经不存在，只提示不修复
+	ProblemFileMissing = "file_missing"
+)
+
+// MetaOffset file.meta 中记录的读取位置，Size 为文件当前的大小，文件不存在时为 -1
+type MetaOffset struct {
+	Dir      string    `json:"dir"`
+	Encoding string    `json:"encoding"`
+	File     string    `json:"file"`
+	Offset   int64     `json:"offset"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mod_time"`
+}
+
+// MetaProblem 检查发现的问题，Path 为有问题的 meta 文件或者 submeta 目录
+type MetaProblem struct {
+	Kind   string `json:"kind"`
+	Path   string `json:"path"`
+	Detail string `json:"detail"`
+}
+
+// Repairable 返回问题是否可以被 RepairMeta 修复
+func (p MetaProblem) Repairable() bool {
+	return p.Kind != ProblemFileMissing
+}
+
+// MetaReport meta 目录的检查结果，Offset 为顶层 file.meta 的记录，没有时为 nil
+type MetaReport struct {
+	Dir      string        `json:"dir"`
+	Offset   *MetaOffset   `json:"offset,omitempty"`
+	SubMetas []MetaOffset  `json:"submetas"`
+	Problems []MetaProblem `json:"problems"`
+}
+
+// subMetaName 与 tailx、dirx 中 submeta 目录的命名方式相同
+func subMetaName(path string) string {
+	name := strings.Replace(path, string(os.PathSeparator), "_", -1)
+	if runtime.GOOS == "windows" {
+		name = strings.Replace(name, ":", "_", -1)
+	}
+	return name
+}
+
+// InspectMeta 检查 dir 下的 file.meta 以及 tailx、dirx 的 submeta，不会修改任何文件
+func InspectMeta(dir string) (*MetaReport, error) {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, ErrFileNotDir
+	}
+	report := &MetaReport{Dir: dir}
+	offset, problem, err := inspectOffset(dir, true)
+	if err != nil {
+		return nil, err
+	}
+	report.Offset = offset
+	if problem != nil {
+		report.Problems = append(report.Problems, *problem)
+	}
+
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, fi := range fis {
+		if !fi.IsDir() || fi.Name() == FtSaveLogPath {
+			continue
+		}
+		subDir := filepath.Join(dir, fi.Name())
+		sub, problem, err := inspectOffset(subDir, false)
+		if err != nil {
+			return nil, err
+		}
+		if problem != nil {
+			report.Problems = append(report.Problems, *problem)
+		}
+		if sub == nil {
+			continue
+		}
+		report.SubMetas = append(report.SubMetas, *sub)
+		if orphan := checkOrphan(fi.Name(), sub); orphan != nil {
+			report.Problems = append(report.Problems, *orphan)
+		}
+	}
+	return report, nil
+}
+
+// inspectOffset 读取 dir 下的 file.meta，没有 file.meta 时返回 nil，top 表示 dir 为 runner 的 meta 目录而不是 submeta
+func inspectOffset(dir string, top bool) (*MetaOffset, *MetaProblem, error) {
+	path := filepath.Join(dir, metaFileName)
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	codec, err := DetectMetaCodec(data)
+	if err != nil {
+		return nil, &MetaProblem{Kind: ProblemCorruptMeta, Path: path, Detail: err.Error()}, nil
+	}
+	rec, err := codec.DecodeOffset(data)
+	if err != nil {
+		return nil, &MetaProblem{Kind: ProblemCorruptMeta, Path: path, Detail: err.Error()}, nil
+	}
+	offset := &MetaOffset{Dir: dir, Encoding: codec.Name(), File: rec.File, Offset: rec.Offset, Size: -1}
+	if fi, err := os.Stat(rec.File); err == nil {
+		offset.Size, offset.ModTime = fi.Size(), fi.ModTime()
+	}
+	switch {
+	case offset.Size < 0 && top:
+		return offset, &MetaProblem{Kind: ProblemFileMissing, Path: path, Detail: fmt.Sprintf("%v does not exist", rec.File)}, nil
+	case offset.Offset > offset.Size && offset.Size >= 0:
+		return offset, &MetaProblem{
+			Kind:   ProblemOffsetBeyondSize,
+			Path:   path,
+			Detail: fmt.Sprintf("offset %d is beyond the size %d of %v", offset.Offset, offset.Size, rec.File),
+		}, nil
+	}
+	// submeta 的文件不存在时由 checkOrphan 判断
+	return offset, nil, nil
+}
+
+// checkOrphan 判断 submeta 是否已经没有对应的文件或目录。
+// tailx 的 submeta 以文件路径命名，文件不存在即为孤立；dirx 的 submeta 以目录路径命名，目录不存在才是孤立的，
+// 当前文件被删除时 dirx 会继续读取目录中的下一个文件
+func checkOrphan(name string, sub *MetaOffset) *MetaProblem {
+	if sub.Size >= 0 {
+		return nil
+	}
+	path := sub.File
+	if name != subMetaName(sub.File) && name == subMetaName(filepath.Dir(sub.File)) {
+		path = filepath.Dir(sub.File)
+	}
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	return &MetaProblem{
+		Kind:   ProblemOrphanSubMeta,
+		Path:   sub.Dir,
+		Detail: fmt.Sprintf("%v does not exist any more", path),
+	}
+}
+
+// RepairMeta 修复 report 中可以修复的问题，修复前将 dir 备份到同级的 <dir>.backup-<时间> 目录中(不包含 ft_log)，
+// 返回备份目录和已经修复的问题。修复时需要先停止对应的 runner
+func RepairMeta(report *MetaReport) (backup string, repaired []MetaProblem, err error) {
+	var problems []MetaProblem
+	for _, p := range report.Problems {
+		if p.Repairable() {
+			problems = append(problems, p)
+		}
+	}
+	if len(problems) == 0 {
+		return "", nil, nil
+	}
+	dir := filepath.Clean(report.Dir)
+	backup = fmt.Sprintf("%s.backup-%s", dir, time.Now().Format("20060102150405"))
+	if err = copyMetaDir(dir, backup); err != nil {
+		return "", nil, fmt.Errorf("backup %v to %v error: %v", dir, backup, err)
+	}
+	for _, p := range problems {
+		if err = repairProblem(p); err != nil {
+			return backup, repaired, fmt.Errorf("repair %v of %v error: %v", p.Kind, p.Path, err)
+		}
+		repaired = append(repaired, p)
+	}
+	return backup, repaired, nil
+}
+
+func repairProblem(p MetaProblem) error {
+	switch p.Kind {
+	case ProblemCorruptMeta:
+		return os.Remove(p.Path)
+	case ProblemOrphanSubMeta:
+		return os.RemoveAll(p.Path)
+	case ProblemOffsetBeyondSize:
+		data, err := ioutil.ReadFile(p.Path)
+		if err != nil {
+			return err
+		}
+		codec, err := DetectMetaCodec(data)
+		if err != nil {
+			return err
+		}
+		rec, err := codec.DecodeOffset(data)
+		if err != nil {
+			return err
+		}
+		rec.Offset = 0
+		if data, err = codec.EncodeOffset(rec); err != nil {
+			return err
+		}
+		if err = writeMetaFile(p.Path, data); err != nil {
+			return err
+		}
+		return resetFileIDOffset(filepath.Join(filepath.Dir(p.Path), fileIDFileName), rec.File)
+	}
+	return fmt.Errorf("problem %v can not be repaired", p.Kind)
+}
+
+// PruneOrphanSubMetas 删除 report 中孤立并且超过 expire 没有更新的 submeta，过期的判断与 CleanExpiredSubMetas 相同，
+// 删除后从 report 中移除，返回被删除的 submeta。expire 为 0 时不删除
+func PruneOrphanSubMetas(report *MetaReport, expire time.Duration) (pruned []MetaOffset, err error) {
+	if expire <= 0 {
+		return nil, nil
+	}
+	removed := make(map[string]bool)
+	problems := report.Problems[:0]
+	for _, p := range report.Problems {
+		if p.Kind != ProblemOrphanSubMeta || !hasSubMetaExpired(p.Path, expire) {
+			problems = append(problems, p)
+			continue
+		}
+		if err = os.RemoveAll(p.Path); err != nil {
+			problems = append(problems, p)
+			continue
+		}
+		removed[p.Path] = true
+	}
+	report.Problems = problems
+
+	subMetas := report.SubMetas[:0]
+	for _, sub := range report.SubMetas {
+		if removed[sub.Dir] {
+			pruned = append(pruned, sub)
+			continue
+		}
+		subMetas = append(subMetas, sub)
+	}
+	report.SubMetas = subMetas
+	return pruned, err
+}
+
+// resetFileIDOffset 文件标识记录的是被截断的文件本身时同样从头读取，记录的是被轮转的其他文件时保持不变
+func resetFileIDOffset(path, file string) error {
+	rec, err := readFileIDRecord(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	id, err := utilsos.GetFileIDByPath(file)
+	if err != nil || id.String() != rec.ID {
+		return nil
+	}
+	return writeMetaFile(path, []byte(fmt.Sprintf("%s\t%d\t%s\n", rec.ID, 0, rec.Path)))
+}
+
+// copyMetaDir 复制 meta 目录，ft_log 中的发送队列可能很大并且修复时不会改动，不做备份
+func copyMetaDir(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			if rel == FtSaveLogPath {
+				return filepath.SkipDir
+			}
+			return os.MkdirAll(target, DefaultDirPerm)
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		return copyMetaFile(path, target)
+	})
+}
+
+func copyMetaFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, DefaultFilePerm)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
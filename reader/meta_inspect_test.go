@@ -0,0 +1,125 @@
+package reader
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/qiniu/logkit/reader/config"
+)
+
+func TestInspectAndRepairMeta(t *testing.T) {
+	root, err := ioutil.TempDir("", "TestInspectAndRepairMeta")
+	assert.NoError(t, err)
+	defer os.RemoveAll(root)
+	logDir := filepath.Join(root, "logs")
+	assert.NoError(t, os.MkdirAll(logDir, 0755))
+	truncated := filepath.Join(logDir, "truncated.log")
+	assert.NoError(t, ioutil.WriteFile(truncated, []byte("abc\n"), 0644))
+	normal := filepath.Join(logDir, "normal.log")
+	assert.NoError(t, ioutil.WriteFile(normal, []byte("abc\n"), 0644))
+	removed := filepath.Join(logDir, "removed.log")
+	metaDir := filepath.Join(root, "meta")
+
+	writeSubMeta := func(path string, offset int64) *Meta {
+		subDir := filepath.Join(metaDir, subMetaName(path))
+		m, err := NewMetaWithRunnerName("runner", subDir, subDir, path, ModeFile, "", DefautFileRetention)
+		assert.NoError(t, err)
+		assert.NoError(t, m.WriteOffset(path, offset))
+		return m
+	}
+	m := writeSubMeta(truncated, 100)
+	assert.NoError(t, m.SetMetaEncoding(MetaEncodingJSON))
+	assert.NoError(t, m.WriteOffset(truncated, 100))
+	writeSubMeta(normal, 2)
+	writeSubMeta(removed, 2)
+	// dirx 的 submeta 以目录命名，当前文件被删除但目录仍然存在时不是孤立的
+	dirSub := filepath.Join(metaDir, subMetaName(logDir))
+	dm, err := NewMetaWithRunnerName("runner", dirSub, dirSub, logDir, ModeDir, "", DefautFileRetention)
+	assert.NoError(t, err)
+	assert.NoError(t, dm.WriteOffset(removed, 2))
+	corrupt := filepath.Join(metaDir, "corrupt", metaFileName)
+	assert.NoError(t, os.MkdirAll(filepath.Dir(corrupt), 0755))
+	assert.NoError(t, ioutil.WriteFile(corrupt, []byte("#logkit-meta json v1 100\n{}"), 0644))
+	assert.NoError(t, os.MkdirAll(filepath.Join(metaDir, FtSaveLogPath), 0755))
+
+	report, err := InspectMeta(metaDir)
+	assert.NoError(t, err)
+	assert.Nil(t, report.Offset)
+	assert.Len(t, report.SubMetas, 4)
+	assert.Equal(t, []MetaProblem{
+		{Kind: ProblemOrphanSubMeta, Path: filepath.Join(metaDir, subMetaName(removed)), Detail: removed + " does not exist any more"},
+		{Kind: ProblemOffsetBeyondSize, Path: m.MetaFile(), Detail: "offset 100 is beyond the size 4 of " + truncated},
+		{Kind: ProblemCorruptMeta, Path: corrupt, Detail: "meta payload truncated, expect 100 bytes but got 2"},
+	}, report.Problems)
+
+	backup, repaired, err := RepairMeta(report)
+	assert.NoError(t, err)
+	assert.Equal(t, report.Problems, repaired)
+	_, err = os.Stat(filepath.Join(backup, subMetaName(removed), metaFileName))
+	assert.NoError(t, err)
+	file, offset, err := m.ReadOffset()
+	assert.NoError(t, err)
+	assert.Equal(t, truncated, file)
+	assert.EqualValues(t, 0, offset)
+
+	report, err = InspectMeta(metaDir)
+	assert.NoError(t, err)
+	assert.Len(t, report.Problems, 0)
+	assert.Len(t, report.SubMetas, 3)
+	backup, repaired, err = RepairMeta(report)
+	assert.NoError(t, err)
+	assert.Equal(t, "", backup)
+	assert.Len(t, repaired, 0)
+
+	// 顶层 meta 记录的文件不存在时只提示
+	top, err := NewMetaWithRunnerName("runner", metaDir, metaDir, logDir, ModeDir, "", DefautFileRetention)
+	assert.NoError(t, err)
+	assert.NoError(t, top.WriteOffset(removed, 2))
+	report, err = InspectMeta(metaDir)
+	assert.NoError(t, err)
+	assert.Equal(t, removed, report.Offset.File)
+	assert.EqualValues(t, -1, report.Offset.Size)
+	assert.Len(t, report.Problems, 1)
+	assert.False(t, report.Problems[0].Repairable())
+}
+
+func TestPruneOrphanSubMetas(t *testing.T) {
+	root, err := ioutil.TempDir("", "TestPruneOrphanSubMetas")
+	assert.NoError(t, err)
+	defer os.RemoveAll(root)
+	metaDir := filepath.Join(root, "meta")
+	expired := filepath.Join(root, "expired.log")
+	recent := filepath.Join(root, "recent.log")
+	for _, path := range []string{expired, recent} {
+		subDir := filepath.Join(metaDir, subMetaName(path))
+		m, err := NewMetaWithRunnerName("runner", subDir, subDir, path, ModeFile, "", DefautFileRetention)
+		assert.NoError(t, err)
+		assert.NoError(t, m.WriteOffset(path, 2))
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	expiredMeta := filepath.Join(metaDir, subMetaName(expired), metaFileName)
+	assert.NoError(t, os.Chtimes(expiredMeta, old, old))
+
+	report, err := InspectMeta(metaDir)
+	assert.NoError(t, err)
+	assert.Len(t, report.Problems, 2)
+	pruned, err := PruneOrphanSubMetas(report, 0)
+	assert.NoError(t, err)
+	assert.Len(t, pruned, 0)
+
+	// 只删除孤立并且过期的 submeta
+	pruned, err = PruneOrphanSubMetas(report, time.Hour)
+	assert.NoError(t, err)
+	assert.Len(t, pruned, 1)
+	assert.Equal(t, expired, pruned[0].File)
+	assert.Len(t, report.SubMetas, 1)
+	assert.Equal(t, recent, report.SubMetas[0].File)
+	assert.Len(t, report.Problems, 1)
+	_, err = os.Stat(filepath.Dir(expiredMeta))
+	assert.True(t, os.IsNotExist(err))
+}
@@ -279,3 +279,65 @@ func TestMeta_CleanExpiredSubMetas(t *testing.T) {
 	m.CleanExpiredSubMetas(time.Nanosecond)
 	assert.True(t, utils.IsExist(subMeta1File))
 }
+
+func TestMeta_MetaEncoding(t *testing.T) {
+	metaDir := "TestMeta_MetaEncoding"
+	defer os.RemoveAll(metaDir)
+	m, err := NewMeta(metaDir, metaDir, "logpath", ModeTailx, "", 7)
+	assert.NoError(t, err)
+	assert.Equal(t, MetaEncodingText, m.GetMetaEncoding())
+
+	// 旧版本的纯文本格式
+	assert.NoError(t, m.WriteOffset("/tmp/a.log", 10))
+	assert.NoError(t, m.WriteBuf([]byte("abc"), 1, 3, 4096))
+	content, err := ioutil.ReadFile(m.MetaFile())
+	assert.NoError(t, err)
+	assert.Equal(t, "/tmp/a.log\t10\n", string(content))
+
+	// 切换为 json 格式后，依然能读取旧格式的记录
+	assert.NoError(t, m.SetMetaEncoding(MetaEncodingJSON))
+	file, offset, err := m.ReadOffset()
+	assert.NoError(t, err)
+	assert.Equal(t, "/tmp/a.log", file)
+	assert.EqualValues(t, 10, offset)
+
+	assert.NoError(t, m.WriteOffset("/tmp/path with space.log", 20))
+	content, err = ioutil.ReadFile(m.MetaFile())
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(content), "#logkit-meta json v1 "))
+	file, offset, err = m.ReadOffset()
+	assert.NoError(t, err)
+	assert.Equal(t, "/tmp/path with space.log", file)
+	assert.EqualValues(t, 20, offset)
+
+	// 转换工具，buf.dat 中是 tailx 的 cacheMap 等数据本身，不做转换
+	cacheMap := []byte(`{"/tmp/a.log":"partial line"}`)
+	assert.NoError(t, ioutil.WriteFile(m.BufFile(), cacheMap, 0644))
+	converted, err := ConvertMetaDir(metaDir, MetaEncodingJSON)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{m.BufMetaFile()}, converted)
+	r, w, bufsize, err := m.ReadBufMeta()
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 3, 4096}, []int{r, w, bufsize})
+
+	converted, err = ConvertMetaDir(metaDir, MetaEncodingText)
+	assert.NoError(t, err)
+	assert.Len(t, converted, 2)
+	content, err = ioutil.ReadFile(m.BufMetaFile())
+	assert.NoError(t, err)
+	assert.Equal(t, "read:1\nwrite:3\nbufsize:4096\n", string(content))
+	assert.NotContains(t, converted, m.BufFile())
+	content, err = ioutil.ReadFile(m.BufFile())
+	assert.NoError(t, err)
+	assert.Equal(t, cacheMap, content)
+
+	// 更高版本或不完整的记录
+	assert.NoError(t, ioutil.WriteFile(m.MetaFile(), []byte("#logkit-meta json v2 2\n{}"), 0644))
+	_, _, err = m.ReadOffset()
+	assert.Equal(t, ErrMetaVersion, err)
+	assert.NoError(t, ioutil.WriteFile(m.MetaFile(), []byte("#logkit-meta json v1 100\n{}"), 0644))
+	_, _, err = m.ReadOffset()
+	assert.Error(t, err)
+
+	assert.Error(t, m.SetMetaEncoding("protobuf"))
+}
@@ -0,0 +1,257 @@
+package mmap
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/qiniu/log"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/reader"
+	. "github.com/qiniu/logkit/reader/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+var (
+	_ reader.DaemonReader = &Reader{}
+	_ reader.StatsReader  = &Reader{}
+	_ reader.LagReader    = &Reader{}
+	_ reader.OnceReader   = &Reader{}
+	_ reader.Reader       = &Reader{}
+)
+
+// 已经切分好等待读取的块数，每块最多 chunkSize 字节
+const chunkQueueSize = 4
+
+func init() {
+	reader.RegisterConstructor(ModeMmap, NewReader)
+}
+
+// chunk 一次切分出的行，ends[i] 为 lines[i] 结束(包括换行符)时在文件中的偏移
+type chunk struct {
+	lines []string
+	ends  []int64
+}
+
+// Reader 将不再变化的文件映射到内存中，后台按块切分行，适合一次性导入历史大文件
+type Reader struct {
+	meta *reader.Meta
+	// Note: 原子操作，用于表示 reader 整体的运行状态
+	status int32
+
+	stopChan chan struct{}
+	readChan chan chunk
+
+	stats     StatsInfo
+	statsLock sync.RWMutex
+
+	path      string
+	chunkSize int
+	data      []byte
+	size      int64
+	// start 本次开始切分的偏移，offset 已经被上层读取的偏移
+	start  int64
+	offset int64
+
+	current chunk
+	index   int
+	done    int32
+}
+
+func NewReader(meta *reader.Meta, c conf.MapConf) (reader.Reader, error) {
+	path, err := c.GetString(KeyLogPath)
+	if err != nil {
+		return nil, err
+	}
+	chunkSize, _ := c.GetIntOr(KeyMmapChunkSize, DefaultMmapChunkSize)
+	if chunkSize <= 0 {
+		return nil, fmt.Errorf("%v should be positive, got %v", KeyMmapChunkSize, chunkSize)
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.Mode().IsRegular() {
+		return nil, fmt.Errorf("%v is not a regular file", path)
+	}
+
+	r := &Reader{
+		meta:      meta,
+		status:    StatusInit,
+		stopChan:  make(chan struct{}),
+		readChan:  make(chan chunk, chunkQueueSize),
+		path:      path,
+		chunkSize: chunkSize,
+		size:      fi.Size(),
+	}
+	lastPath, offset, err := meta.ReadOffset()
+	if err == nil && lastPath == path {
+		if offset > r.size {
+			log.Warnf("Runner[%v] %v offset %v in meta is larger than file size %v, read from the beginning", meta.RunnerName, path, offset, r.size)
+		} else {
+			r.start = offset
+			r.offset = offset
+		}
+	}
+	if r.size > 0 {
+		if r.data, err = mmapFile(path, r.size); err != nil {
+			return nil, fmt.Errorf("mmap %v error: %v", path, err)
+		}
+	}
+	return r, nil
+}
+
+func (r *Reader) isStopping() bool {
+	return atomic.LoadInt32(&r.status) == StatusStopping
+}
+
+func (r *Reader) hasStopped() bool {
+	return atomic.LoadInt32(&r.status) == StatusStopped
+}
+
+func (r *Reader) Name() string {
+	return "mmap:" + r.path
+}
+
+func (r *Reader) SetMode(mode string, v interface{}) error {
+	return errors.New("mmap reader does not support read mode")
+}
+
+func (r *Reader) Start() error {
+	if r.isStopping() || r.hasStopped() {
+		return errors.New("reader is stopping or has stopped")
+	}
+	if !atomic.CompareAndSwapInt32(&r.status, StatusInit, StatusRunning) {
+		log.Warnf("Runner[%v] %q daemon has already started and is running", r.meta.RunnerName, r.Name())
+		return nil
+	}
+
+	go r.run()
+	log.Infof("Runner[%v] %q daemon has started", r.meta.RunnerName, r.Name())
+	return nil
+}
+
+func (r *Reader) run() {
+	defer func() {
+		close(r.readChan)
+		log.Infof("Runner[%v] %q daemon has stopped from running", r.meta.RunnerName, r.Name())
+	}()
+
+	pos := r.start
+	for pos < r.size {
+		c, next := splitChunk(r.data, pos, r.chunkSize)
+		pos = next
+		select {
+		case <-r.stopChan:
+			return
+		case r.readChan <- c:
+		}
+	}
+}
+
+// splitChunk 从 pos 开始切分出不少于 chunkSize 字节的完整行，返回这些行以及下一块的起始偏移。
+// 块的边界会延伸到下一个换行符，文件最后一行没有换行符时也作为一行返回
+func splitChunk(data []byte, pos int64, chunkSize int) (chunk, int64) {
+	end := pos + int64(chunkSize)
+	if end >= int64(len(data)) {
+		end = int64(len(data))
+	} else if idx := bytes.IndexByte(data[end:], '\n'); idx >= 0 {
+		end += int64(idx) + 1
+	} else {
+		end = int64(len(data))
+	}
+
+	var c chunk
+	buf := data[pos:end]
+	for len(buf) > 0 {
+		idx := bytes.IndexByte(buf, '\n')
+		var line []byte
+		if idx < 0 {
+			line, buf = buf, nil
+			pos += int64(len(line))
+		} else {
+			line, buf = buf[:idx], buf[idx+1:]
+			pos += int64(idx) + 1
+		}
+		// 从映射的内存中复制出来，关闭后映射会被释放
+		c.lines = append(c.lines, string(bytes.TrimSuffix(line, []byte{'\r'})))
+		c.ends = append(c.ends, pos)
+	}
+	return c, end
+}
+
+func (r *Reader) Source() string {
+	return r.path
+}
+
+func (r *Reader) ReadLine() (string, error) {
+	if r.index >= len(r.current.lines) {
+		timer := time.NewTimer(time.Second)
+		defer timer.Stop()
+		select {
+		case c, ok := <-r.readChan:
+			if !ok {
+				atomic.StoreInt32(&r.done, 1)
+				return "", nil
+			}
+			r.current, r.index = c, 0
+		case <-timer.C:
+			return "", nil
+		}
+	}
+	line := r.current.lines[r.index]
+	atomic.StoreInt64(&r.offset, r.current.ends[r.index])
+	r.index++
+	return line, nil
+}
+
+// ReadDone 文件所有的行都已经被读取
+func (r *Reader) ReadDone() bool {
+	return atomic.LoadInt32(&r.done) == 1
+}
+
+func (r *Reader) Status() StatsInfo {
+	r.statsLock.RLock()
+	defer r.statsLock.RUnlock()
+	return r.stats
+}
+
+func (r *Reader) Lag() (*LagInfo, error) {
+	return &LagInfo{
+		Size:     r.size - atomic.LoadInt64(&r.offset),
+		SizeUnit: "bytes",
+		Total:    r.size,
+	}, nil
+}
+
+func (r *Reader) SyncMeta() {
+	if err := r.meta.WriteOffset(r.path, atomic.LoadInt64(&r.offset)); err != nil {
+		log.Errorf("Runner[%v] %v SyncMeta error %v", r.meta.RunnerName, r.Name(), err)
+		r.statsLock.Lock()
+		r.stats.LastError = err.Error()
+		r.statsLock.Unlock()
+	}
+}
+
+func (r *Reader) Close() error {
+	if atomic.CompareAndSwapInt32(&r.status, StatusRunning, StatusStopping) {
+		log.Debugf("Runner[%v] %q daemon is stopping", r.meta.RunnerName, r.Name())
+		close(r.stopChan)
+		// 等待切分线程退出后才能释放映射的内存
+		for range r.readChan {
+		}
+	}
+	atomic.StoreInt32(&r.status, StatusStopped)
+	if r.data == nil {
+		return nil
+	}
+	data := r.data
+	r.data = nil
+	return munmapFile(data)
+}
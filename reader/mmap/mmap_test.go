@@ -0,0 +1,123 @@
+package mmap
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/reader"
+	. "github.com/qiniu/logkit/reader/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+func TestSplitChunk(t *testing.T) {
+	data := []byte("a\r\nbb\ncccc\nd")
+	c, next := splitChunk(data, 0, 4)
+	assert.Equal(t, []string{"a", "bb"}, c.lines)
+	assert.Equal(t, []int64{3, 6}, c.ends)
+	assert.Equal(t, int64(6), next)
+
+	// 超过块大小的行完整返回
+	c, next = splitChunk(data, next, 1)
+	assert.Equal(t, []string{"cccc"}, c.lines)
+	assert.Equal(t, int64(11), next)
+
+	// 没有换行符的最后一行
+	c, next = splitChunk(data, next, 4)
+	assert.Equal(t, []string{"d"}, c.lines)
+	assert.Equal(t, []int64{12}, c.ends)
+	assert.Equal(t, int64(len(data)), next)
+}
+
+func readAll(t *testing.T, r *Reader) []string {
+	var lines []string
+	for !r.ReadDone() {
+		line, err := r.ReadLine()
+		assert.NoError(t, err)
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func TestMmapReader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestMmapReader")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "history.log")
+	var expect []string
+	for i := 0; i < 1000; i++ {
+		expect = append(expect, fmt.Sprintf("line %d %s", i, strings.Repeat("x", i%7)))
+	}
+	assert.NoError(t, ioutil.WriteFile(path, []byte(strings.Join(expect, "\n")+"\n"), 0644))
+
+	c := conf.MapConf{
+		KeyMetaPath:      filepath.Join(dir, "meta"),
+		KeyMode:          ModeMmap,
+		KeyRunnerName:    "TestMmapReader",
+		KeyLogPath:       path,
+		KeyMmapChunkSize: "100",
+	}
+	newReader := func() *Reader {
+		meta, err := reader.NewMetaWithConf(c)
+		assert.NoError(t, err)
+		r, err := NewReader(meta, c)
+		assert.NoError(t, err)
+		assert.NoError(t, r.(*Reader).Start())
+		return r.(*Reader)
+	}
+
+	r := newReader()
+	var got []string
+	for i := 0; i < 10; i++ {
+		line, err := r.ReadLine()
+		assert.NoError(t, err)
+		got = append(got, line)
+	}
+	r.SyncMeta()
+	lag, err := r.Lag()
+	assert.NoError(t, err)
+	assert.True(t, lag.Size > 0 && lag.Size < lag.Total)
+	assert.NoError(t, r.Close())
+
+	// 重启后从上次同步的位置继续读取
+	r = newReader()
+	got = append(got, readAll(t, r)...)
+	assert.Equal(t, expect, got)
+	lag, err = r.Lag()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), lag.Size)
+	assert.NoError(t, r.Close())
+
+	_, err = NewReader(nil, conf.MapConf{KeyLogPath: dir})
+	assert.Error(t, err)
+}
+
+func TestMmapReaderEmptyFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestMmapReaderEmptyFile")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "empty.log")
+	assert.NoError(t, ioutil.WriteFile(path, nil, 0644))
+
+	c := conf.MapConf{
+		KeyMetaPath:   filepath.Join(dir, "meta"),
+		KeyMode:       ModeMmap,
+		KeyRunnerName: "TestMmapReaderEmptyFile",
+		KeyLogPath:    path,
+	}
+	meta, err := reader.NewMetaWithConf(c)
+	assert.NoError(t, err)
+	r, err := NewReader(meta, c)
+	assert.NoError(t, err)
+	assert.NoError(t, r.(*Reader).Start())
+	assert.Empty(t, readAll(t, r.(*Reader)))
+	assert.NoError(t, r.Close())
+}
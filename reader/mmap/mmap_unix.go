@@ -0,0 +1,23 @@
+// +build !windows
+
+package mmap
+
+import (
+	"os"
+	"syscall"
+)
+
+// mmapFile 以只读方式将文件的前 size 字节映射到内存中
+func mmapFile(path string, size int64) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	// 映射建立后关闭文件不影响映射
+	defer f.Close()
+	return syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+func munmapFile(data []byte) error {
+	return syscall.Munmap(data)
+}
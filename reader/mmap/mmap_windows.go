@@ -0,0 +1,26 @@
+// +build windows
+
+package mmap
+
+import (
+	"io"
+	"os"
+)
+
+// mmapFile windows 下直接将文件的前 size 字节读取到内存中
+func mmapFile(path string, size int64) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data := make([]byte, size)
+	if _, err = io.ReadFull(f, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func munmapFile(data []byte) error {
+	return nil
+}
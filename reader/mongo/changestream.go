@@ -0,0 +1,463 @@
+package mongo
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/json-iterator/go"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/qiniu/log"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/reader"
+	. "github.com/qiniu/logkit/reader/config"
+	"github.com/qiniu/logkit/utils"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+var (
+	_ reader.DaemonReader = &ChangeStreamReader{}
+	_ reader.StatsReader  = &ChangeStreamReader{}
+	_ reader.Reader       = &ChangeStreamReader{}
+)
+
+const (
+	FullDocumentUpdateLookup = "updateLookup"
+	FullDocumentDefault      = "default"
+
+	// tokenPrefix 为 meta 中 resume token 的前缀，用于区分定时查询模式记录的 offset
+	tokenPrefix = "token:"
+	// awaitTimeMS 为 getMore 没有新的变更时在服务端等待的时间
+	awaitTimeMS = 1000
+)
+
+// 无法通过 resume token 恢复读取的错误码，此时只能从当前时间开始读取
+var resumeErrorCodes = map[int]string{
+	260: "InvalidResumeToken",
+	280: "ChangeStreamFatalError",
+	286: "ChangeStreamHistoryLost",
+}
+
+var errCursorClosed = errors.New("change stream cursor is closed by server")
+
+// mongoSession 执行数据库命令，mgo 没有提供 change stream 的接口，因此直接执行 aggregate 和 getMore 命令
+type mongoSession interface {
+	command(db string, cmd bson.D, result interface{}) error
+	Refresh()
+	Close()
+}
+
+type mgoSession struct {
+	*mgo.Session
+}
+
+func (s mgoSession) command(db string, cmd bson.D, result interface{}) error {
+	return s.DB(db).Run(cmd, result)
+}
+
+// dialSession 建立 change stream 使用的连接，测试时替换为模拟的连接
+var dialSession = func(host string) (mongoSession, error) {
+	session, err := utils.MongoDail(host, "", 0)
+	if err != nil {
+		return nil, err
+	}
+	session.SetSocketTimeout(time.Second * 10)
+	session.SetSyncTimeout(time.Second * 5)
+	return mgoSession{session}, nil
+}
+
+type cursorResult struct {
+	Cursor struct {
+		ID                   int64      `bson:"id"`
+		FirstBatch           []bson.Raw `bson:"firstBatch"`
+		NextBatch            []bson.Raw `bson:"nextBatch"`
+		PostBatchResumeToken bson.Raw   `bson:"postBatchResumeToken"`
+	} `bson:"cursor"`
+}
+
+// changeEvent 为 readChan 中的一条变更，token 为读取该变更后的 resume token，
+// data 为空时只推进 resume token
+type changeEvent struct {
+	data  []byte
+	token *bson.Raw
+}
+
+// ChangeStreamReader 通过 change stream 持续读取数据表或者数据库的变更，
+// 读取位置以 resume token 的形式记录在 meta 中，重启或者主节点切换后从记录的位置继续读取
+type ChangeStreamReader struct {
+	meta *reader.Meta
+	// Note: 原子操作，用于表示 reader 整体的运行状态
+	status int32
+
+	stopChan chan struct{}
+	readChan chan changeEvent
+	errChan  chan error
+
+	stats     StatsInfo
+	statsLock sync.RWMutex
+
+	host         string
+	database     string
+	collection   string
+	batchSize    int
+	fullDocument string
+	pipeline     []interface{}
+
+	session mongoSession
+	// token 为已经被 ReadLine 读取的位置，sentToken 为已经放入 readChan 的位置，重新连接时从 sentToken 继续
+	token     *bson.Raw
+	sentToken *bson.Raw
+	synced    *bson.Raw
+}
+
+func newChangeStreamReader(meta *reader.Meta, conf conf.MapConf) (reader.Reader, error) {
+	batchSize, _ := conf.GetIntOr(KeyMongoReadBatch, 100)
+	database, err := conf.GetString(KeyMongoDatabase)
+	if err != nil {
+		return nil, err
+	}
+	collection, _ := conf.GetStringOr(KeyMongoCollection, "")
+	host, err := conf.GetPasswordEnvString(KeyMongoHost)
+	if err != nil {
+		return nil, err
+	}
+	fullDocument, _ := conf.GetStringOr(KeyMongoFullDocument, FullDocumentUpdateLookup)
+	if fullDocument != FullDocumentUpdateLookup && fullDocument != FullDocumentDefault {
+		return nil, fmt.Errorf("%v must be %v or %v, got %q", KeyMongoFullDocument, FullDocumentUpdateLookup, FullDocumentDefault, fullDocument)
+	}
+	pipelineStr, _ := conf.GetStringOr(KeyMongoChangeStreamPipeline, "")
+	if batchSize <= 0 {
+		batchSize = 100
+	}
+
+	r := &ChangeStreamReader{
+		meta:         meta,
+		status:       StatusInit,
+		stopChan:     make(chan struct{}),
+		readChan:     make(chan changeEvent),
+		errChan:      make(chan error),
+		host:         host,
+		database:     database,
+		collection:   collection,
+		batchSize:    batchSize,
+		fullDocument: fullDocument,
+	}
+	if pipelineStr != "" {
+		if err = jsoniter.Unmarshal([]byte(pipelineStr), &r.pipeline); err != nil {
+			return nil, fmt.Errorf("malformed %v: %v", KeyMongoChangeStreamPipeline, err)
+		}
+	}
+
+	offset, _, err := meta.ReadOffset()
+	if err != nil {
+		log.Errorf("Runner[%v] %v -meta data is corrupted err: %v, omit meta data...", meta.RunnerName, meta.MetaFile(), err)
+	} else if strings.HasPrefix(offset, tokenPrefix) {
+		token, err := decodeToken(offset)
+		if err != nil {
+			log.Errorf("Runner[%v] %v -meta data is corrupted err: %v, omit meta data...", meta.RunnerName, meta.MetaFile(), err)
+		} else {
+			r.token, r.sentToken, r.synced = token, token, token
+		}
+	}
+	return r, nil
+}
+
+// encodeToken 将 resume token 编码为不含空白字符的字符串以便写入 meta
+func encodeToken(token *bson.Raw) string {
+	return tokenPrefix + base64.RawURLEncoding.EncodeToString(token.Data)
+}
+
+func decodeToken(s string) (*bson.Raw, error) {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(s, tokenPrefix))
+	if err != nil {
+		return nil, fmt.Errorf("invalid resume token %q: %v", s, err)
+	}
+	var m bson.M
+	if err = bson.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("invalid resume token %q: %v", s, err)
+	}
+	return &bson.Raw{Kind: 0x03, Data: data}, nil
+}
+
+func sameToken(a, b *bson.Raw) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return bytes.Equal(a.Data, b.Data)
+}
+
+func (r *ChangeStreamReader) isStopping() bool {
+	return atomic.LoadInt32(&r.status) == StatusStopping
+}
+
+func (r *ChangeStreamReader) hasStopped() bool {
+	return atomic.LoadInt32(&r.status) == StatusStopped
+}
+
+func (r *ChangeStreamReader) Name() string {
+	return "MongoChangeStreamReader<" + r.Source() + ">"
+}
+
+func (r *ChangeStreamReader) Source() string {
+	return r.host + "_" + r.database + "_" + r.collection
+}
+
+func (*ChangeStreamReader) SetMode(_ string, _ interface{}) error {
+	return errors.New("MongoDB change stream reader does not support read mode")
+}
+
+func (r *ChangeStreamReader) setStatsError(err string) {
+	r.statsLock.Lock()
+	defer r.statsLock.Unlock()
+	r.stats.LastError = err
+}
+
+func (r *ChangeStreamReader) Status() StatsInfo {
+	r.statsLock.RLock()
+	defer r.statsLock.RUnlock()
+	return r.stats
+}
+
+func (r *ChangeStreamReader) sendError(err error) {
+	if err == nil {
+		return
+	}
+	select {
+	case r.errChan <- err:
+	case <-r.stopChan:
+	}
+}
+
+func (r *ChangeStreamReader) Start() error {
+	if r.isStopping() || r.hasStopped() {
+		return errors.New("reader is stopping or has stopped")
+	} else if !atomic.CompareAndSwapInt32(&r.status, StatusInit, StatusRunning) {
+		log.Warnf("Runner[%v] %q daemon has already started and is running", r.meta.RunnerName, r.Name())
+		return nil
+	}
+	go r.run()
+	log.Infof("Runner[%v] %q daemon has started", r.meta.RunnerName, r.Name())
+	return nil
+}
+
+func (r *ChangeStreamReader) run() {
+	defer func() {
+		if r.session != nil {
+			r.session.Close()
+		}
+		atomic.StoreInt32(&r.status, StatusStopped)
+		log.Infof("Runner[%v] %q daemon has stopped from running", r.meta.RunnerName, r.Name())
+	}()
+	for {
+		err := r.watch()
+		if r.isStopping() || r.hasStopped() {
+			return
+		}
+		if code, ok := resumeErrorCode(err); ok && r.sentToken != nil {
+			// 记录的位置已经不在 oplog 中或者已经失效，只能从当前时间开始读取，期间的变更会丢失
+			log.Errorf("Runner[%v] %q cannot resume change stream (%v), restart from now: %v", r.meta.RunnerName, r.Name(), code, err)
+			r.sentToken = nil
+		} else {
+			log.Errorf("Runner[%v] %q change stream failed, will resume later: %v", r.meta.RunnerName, r.Name(), err)
+		}
+		r.setStatsError(err.Error())
+		r.sendError(err)
+
+		select {
+		case <-r.stopChan:
+			return
+		case <-time.After(3 * time.Second):
+		}
+	}
+}
+
+func resumeErrorCode(err error) (string, bool) {
+	if qerr, ok := err.(*mgo.QueryError); ok {
+		name, ok := resumeErrorCodes[qerr.Code]
+		return name, ok
+	}
+	return "", false
+}
+
+// watch 打开 change stream 并持续读取，直到出错或者 reader 关闭
+func (r *ChangeStreamReader) watch() error {
+	if r.session == nil {
+		session, err := dialSession(r.host)
+		if err != nil {
+			return err
+		}
+		r.session = session
+	} else {
+		// 主节点切换或者网络中断后重新选择节点
+		r.session.Refresh()
+	}
+
+	options := bson.D{{Name: "fullDocument", Value: r.fullDocument}}
+	if r.sentToken != nil {
+		options = append(options, bson.DocElem{Name: "resumeAfter", Value: *r.sentToken})
+	}
+	pipeline := append([]interface{}{bson.M{"$changeStream": options}}, r.pipeline...)
+	// 数据表名称为空时读取整个数据库的变更
+	var aggregate interface{} = 1
+	collection := "$cmd.aggregate"
+	if r.collection != "" {
+		aggregate, collection = r.collection, r.collection
+	}
+	var result cursorResult
+	err := r.session.command(r.database, bson.D{
+		{Name: "aggregate", Value: aggregate},
+		{Name: "pipeline", Value: pipeline},
+		{Name: "cursor", Value: bson.M{"batchSize": r.batchSize}},
+	}, &result)
+	if err != nil {
+		return err
+	}
+	log.Infof("Runner[%v] %q change stream opened, resume token: %v", r.meta.RunnerName, r.Name(), r.sentToken != nil)
+
+	cursorID := result.Cursor.ID
+	defer func() {
+		if cursorID != 0 {
+			var res bson.M
+			r.session.command(r.database, bson.D{
+				{Name: "killCursors", Value: collection},
+				{Name: "cursors", Value: []int64{cursorID}},
+			}, &res)
+		}
+	}()
+	batch, postToken := result.Cursor.FirstBatch, result.Cursor.PostBatchResumeToken
+	for {
+		if err = r.sendBatch(batch, postToken); err != nil {
+			return err
+		}
+		if cursorID == 0 {
+			return errCursorClosed
+		}
+		select {
+		case <-r.stopChan:
+			return nil
+		default:
+		}
+
+		result = cursorResult{}
+		err = r.session.command(r.database, bson.D{
+			{Name: "getMore", Value: cursorID},
+			{Name: "collection", Value: collection},
+			{Name: "batchSize", Value: r.batchSize},
+			{Name: "maxTimeMS", Value: awaitTimeMS},
+		}, &result)
+		if err != nil {
+			return err
+		}
+		cursorID = result.Cursor.ID
+		batch, postToken = result.Cursor.NextBatch, result.Cursor.PostBatchResumeToken
+	}
+}
+
+// sendBatch 将一批变更放入 readChan，postToken 为服务端返回的这批变更之后的位置
+func (r *ChangeStreamReader) sendBatch(batch []bson.Raw, postToken bson.Raw) error {
+	invalidated := false
+	for _, raw := range batch {
+		data, token, invalidate, err := convertEvent(raw)
+		if err != nil {
+			return err
+		}
+		select {
+		case r.readChan <- changeEvent{data: data, token: token}:
+		case <-r.stopChan:
+			return nil
+		}
+		r.sentToken = token
+		if invalidate {
+			// 数据表被删除或者重命名，之后的变更无法通过 resume token 恢复，重新从当前时间开始读取
+			r.sentToken = nil
+			invalidated = true
+		}
+	}
+	// 没有新的变更时也推进读取位置，避免重启后从很早的位置开始读取
+	if postToken.Kind == 0x03 && !invalidated && !sameToken(&postToken, r.sentToken) {
+		token := &bson.Raw{Kind: postToken.Kind, Data: postToken.Data}
+		select {
+		case r.readChan <- changeEvent{token: token}:
+		case <-r.stopChan:
+			return nil
+		}
+		r.sentToken = token
+	}
+	return nil
+}
+
+// convertEvent 将变更转换为 JSON，去掉作为 resume token 的 _id，clusterTime 转换为时间
+func convertEvent(raw bson.Raw) (data []byte, token *bson.Raw, invalidate bool, err error) {
+	var id struct {
+		ID bson.Raw `bson:"_id"`
+	}
+	if err = raw.Unmarshal(&id); err != nil {
+		return nil, nil, false, fmt.Errorf("invalid change event: %v", err)
+	}
+	if id.ID.Kind != 0x03 {
+		return nil, nil, false, errors.New("change event does not have a resume token, the $changeStream pipeline should not modify _id")
+	}
+	var event bson.M
+	if err = raw.Unmarshal(&event); err != nil {
+		return nil, nil, false, fmt.Errorf("invalid change event: %v", err)
+	}
+	delete(event, "_id")
+	if ts, ok := event["clusterTime"].(bson.MongoTimestamp); ok {
+		event["clusterTime"] = time.Unix(int64(ts)>>32, 0).UTC().Format(time.RFC3339)
+	}
+	invalidate = event["operationType"] == "invalidate"
+	data, err = jsoniter.Marshal(event)
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("marshal change event error: %v", err)
+	}
+	return data, &bson.Raw{Kind: id.ID.Kind, Data: id.ID.Data}, invalidate, nil
+}
+
+func (r *ChangeStreamReader) ReadLine() (string, error) {
+	timer := time.NewTimer(time.Second)
+	defer timer.Stop()
+	for {
+		select {
+		case event := <-r.readChan:
+			r.token = event.token
+			if len(event.data) == 0 {
+				continue
+			}
+			return string(event.data), nil
+		case err := <-r.errChan:
+			return "", err
+		case <-timer.C:
+			return "", nil
+		}
+	}
+}
+
+// SyncMeta 记录已经读取的 resume token
+func (r *ChangeStreamReader) SyncMeta() {
+	if r.token == nil || sameToken(r.token, r.synced) {
+		return
+	}
+	if err := r.meta.WriteOffset(encodeToken(r.token), 0); err != nil {
+		log.Errorf("Runner[%v] %v SyncMeta error %v", r.meta.RunnerName, r.Name(), err)
+		return
+	}
+	r.synced = r.token
+}
+
+func (r *ChangeStreamReader) Close() error {
+	if !atomic.CompareAndSwapInt32(&r.status, StatusRunning, StatusStopping) {
+		log.Warnf("Runner[%v] reader %q is not running, close operation ignored", r.meta.RunnerName, r.Name())
+		return nil
+	}
+	log.Debugf("Runner[%v] %q daemon is stopping", r.meta.RunnerName, r.Name())
+	close(r.stopChan)
+	return nil
+}
@@ -0,0 +1,249 @@
+package mongo
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/json-iterator/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/reader"
+	. "github.com/qiniu/logkit/reader/config"
+	. "github.com/qiniu/logkit/reader/test"
+)
+
+// fakeSession 按顺序返回预设的命令结果
+type fakeSession struct {
+	mu        sync.Mutex
+	cmds      []bson.D
+	results   []interface{}
+	refreshed int
+}
+
+func (s *fakeSession) command(db string, cmd bson.D, result interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cmd[0].Name == "killCursors" {
+		return nil
+	}
+	s.cmds = append(s.cmds, cmd)
+	if len(s.results) == 0 {
+		// 没有新的变更
+		time.Sleep(10 * time.Millisecond)
+		return marshalResult(bson.M{"cursor": bson.M{"id": int64(1), "nextBatch": []bson.M{}}}, result)
+	}
+	res := s.results[0]
+	s.results = s.results[1:]
+	if err, ok := res.(error); ok {
+		return err
+	}
+	return marshalResult(res, result)
+}
+
+func (s *fakeSession) Refresh() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refreshed++
+}
+
+func (s *fakeSession) Close() {}
+
+func (s *fakeSession) commands() []bson.D {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]bson.D(nil), s.cmds...)
+}
+
+func marshalResult(res interface{}, result interface{}) error {
+	data, err := bson.Marshal(res)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(data, result)
+}
+
+func testEvent(token, op string, id int) bson.M {
+	return bson.M{
+		"_id":           bson.M{"_data": token},
+		"operationType": op,
+		"clusterTime":   bson.MongoTimestamp(1546300800 << 32),
+		"ns":            bson.M{"db": "testdb", "coll": "coll"},
+		"documentKey":   bson.M{"_id": id},
+		"fullDocument":  bson.M{"_id": id, "name": "foo"},
+	}
+}
+
+func cursor(id int64, batchKey string, postToken string, events ...bson.M) bson.M {
+	c := bson.M{"id": id, batchKey: events}
+	if postToken != "" {
+		c["postBatchResumeToken"] = bson.M{"_data": postToken}
+	}
+	return bson.M{"cursor": c}
+}
+
+func resumeAfter(cmd bson.D) interface{} {
+	stage := cmd[1].Value.([]interface{})[0].(bson.M)["$changeStream"].(bson.D)
+	for _, e := range stage {
+		if e.Name == "resumeAfter" {
+			var token bson.M
+			raw := e.Value.(bson.Raw)
+			raw.Unmarshal(&token)
+			return token["_data"]
+		}
+	}
+	return nil
+}
+
+func readLines(t *testing.T, r reader.Reader, n int) []map[string]interface{} {
+	var lines []map[string]interface{}
+	for i := 0; i < 100 && len(lines) < n; i++ {
+		line, err := r.ReadLine()
+		if err != nil || line == "" {
+			continue
+		}
+		var m map[string]interface{}
+		require.NoError(t, jsoniter.Unmarshal([]byte(line), &m))
+		lines = append(lines, m)
+	}
+	require.Len(t, lines, n)
+	return lines
+}
+
+func TestChangeStreamReader(t *testing.T) {
+	defer os.RemoveAll(MetaDir)
+	session := &fakeSession{results: []interface{}{
+		cursor(42, "firstBatch", "", testEvent("t1", "insert", 1), testEvent("t2", "update", 1)),
+		// 主节点切换
+		&mgo.QueryError{Code: 10107, Message: "not master"},
+		cursor(43, "firstBatch", "", testEvent("t3", "delete", 1)),
+		cursor(43, "nextBatch", "t4"),
+	}}
+	dialSession = func(host string) (mongoSession, error) {
+		return session, nil
+	}
+	c := conf.MapConf{
+		KeyMetaPath:                  MetaDir,
+		KeyFileDone:                  MetaDir,
+		KeyMode:                      ModeMongo,
+		KeyMongoHost:                 "127.0.0.1:27017",
+		KeyMongoDatabase:             "testdb",
+		KeyMongoCollection:           "coll",
+		KeyMongoChangeStream:         "true",
+		KeyMongoChangeStreamPipeline: `[{"$match": {"operationType": {"$in": ["insert", "update", "delete"]}}}]`,
+	}
+	meta, err := reader.NewMetaWithConf(c)
+	require.NoError(t, err)
+	r, err := NewReader(meta, c)
+	require.NoError(t, err)
+	csr := r.(*ChangeStreamReader)
+	assert.Equal(t, "MongoChangeStreamReader<127.0.0.1:27017_testdb_coll>", csr.Name())
+	require.NoError(t, csr.Start())
+
+	lines := readLines(t, csr, 2)
+	assert.Equal(t, "insert", lines[0]["operationType"])
+	assert.Equal(t, "2019-01-01T00:00:00Z", lines[0]["clusterTime"])
+	assert.Equal(t, map[string]interface{}{"_id": float64(1), "name": "foo"}, lines[0]["fullDocument"])
+	_, ok := lines[0]["_id"]
+	assert.False(t, ok)
+	assert.Equal(t, "update", lines[1]["operationType"])
+	csr.SyncMeta()
+
+	// 出错后从最后发送的变更继续读取
+	_, err = csr.ReadLine()
+	assert.Error(t, err)
+	lines = readLines(t, csr, 1)
+	assert.Equal(t, "delete", lines[0]["operationType"])
+	for i := 0; i < 20 && csr.token != nil && resumeAfterToken(csr.token) != "t4"; i++ {
+		csr.ReadLine()
+	}
+	csr.SyncMeta()
+	require.NoError(t, csr.Close())
+
+	cmds := session.commands()
+	require.True(t, len(cmds) >= 4)
+	assert.Equal(t, "aggregate", cmds[0][0].Name)
+	assert.Equal(t, "coll", cmds[0][0].Value)
+	assert.Nil(t, resumeAfter(cmds[0]))
+	assert.Len(t, cmds[0][1].Value, 2)
+	assert.Equal(t, "getMore", cmds[1][0].Name)
+	assert.Equal(t, int64(42), cmds[1][0].Value)
+	assert.Equal(t, "aggregate", cmds[2][0].Name)
+	assert.Equal(t, "t2", resumeAfter(cmds[2]))
+	assert.Equal(t, 1, session.refreshed)
+
+	// 重启后从 meta 中记录的位置继续
+	offset, _, err := meta.ReadOffset()
+	require.NoError(t, err)
+	token, err := decodeToken(offset)
+	require.NoError(t, err)
+	assert.Equal(t, "t4", resumeAfterToken(token))
+	r, err = NewReader(meta, c)
+	require.NoError(t, err)
+	assert.Equal(t, "t4", resumeAfterToken(r.(*ChangeStreamReader).sentToken))
+}
+
+func resumeAfterToken(token *bson.Raw) interface{} {
+	var m bson.M
+	token.Unmarshal(&m)
+	return m["_data"]
+}
+
+func TestChangeStreamHistoryLost(t *testing.T) {
+	defer os.RemoveAll(MetaDir)
+	session := &fakeSession{results: []interface{}{
+		&mgo.QueryError{Code: 286, Message: "resume point may no longer be in the oplog"},
+		cursor(7, "firstBatch", "", testEvent("t9", "insert", 2)),
+	}}
+	dialSession = func(host string) (mongoSession, error) {
+		return session, nil
+	}
+	c := conf.MapConf{
+		KeyMetaPath:          MetaDir,
+		KeyFileDone:          MetaDir,
+		KeyMode:              ModeMongo,
+		KeyMongoHost:         "127.0.0.1:27017",
+		KeyMongoDatabase:     "testdb",
+		KeyMongoChangeStream: "true",
+		KeyMongoFullDocument: FullDocumentDefault,
+	}
+	meta, err := reader.NewMetaWithConf(c)
+	require.NoError(t, err)
+	token := &bson.Raw{Kind: 0x03}
+	token.Data, _ = bson.Marshal(bson.M{"_data": "t1"})
+	require.NoError(t, meta.WriteOffset(encodeToken(token), 0))
+
+	r, err := NewReader(meta, c)
+	require.NoError(t, err)
+	csr := r.(*ChangeStreamReader)
+	require.NoError(t, csr.Start())
+	_, err = csr.ReadLine()
+	assert.Error(t, err)
+	lines := readLines(t, csr, 1)
+	assert.Equal(t, "insert", lines[0]["operationType"])
+	for i := 0; i < 100 && len(session.commands()) < 3; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.NoError(t, csr.Close())
+
+	cmds := session.commands()
+	require.True(t, len(cmds) >= 3)
+	// 读取整个数据库的变更
+	assert.Equal(t, 1, cmds[0][0].Value)
+	assert.Equal(t, "t1", resumeAfter(cmds[0]))
+	assert.Nil(t, resumeAfter(cmds[1]))
+	assert.Equal(t, "$cmd.aggregate", cmds[2][1].Value)
+
+	c[KeyMongoFullDocument] = "whenAvailable"
+	_, err = NewReader(meta, c)
+	assert.Error(t, err)
+	c[KeyMongoFullDocument] = FullDocumentDefault
+	c[KeyMongoChangeStreamPipeline] = "{"
+	_, err = NewReader(meta, c)
+	assert.Error(t, err)
+}
@@ -81,6 +81,9 @@ type Reader struct {
 }
 
 func NewReader(meta *reader.Meta, conf conf.MapConf) (reader.Reader, error) {
+	if changeStream, _ := conf.GetBoolOr(KeyMongoChangeStream, false); changeStream {
+		return newChangeStreamReader(meta, conf)
+	}
 	readBatch, _ := conf.GetIntOr(KeyMongoReadBatch, 100)
 	database, err := conf.GetString(KeyMongoDatabase)
 	if err != nil {
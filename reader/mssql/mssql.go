@@ -57,6 +57,7 @@ type MssqlReader struct {
 	datasource  string //数据源
 	database    string //数据库名称
 	rawDatabase string // 记录原始数据库
+	rawTable    string // 记录原始数据表名，支持魔法变量
 	rawSQLs     string // 原始sql执行列表
 
 	isLoop       bool
@@ -87,7 +88,7 @@ type readInfo struct {
 
 func NewMssqlReader(meta *reader.Meta, conf conf.MapConf) (reader.Reader, error) {
 	var readBatch int
-	var dataSource, rawDatabase, rawSQLs, cronSchedule, offsetKey, dbSchema string
+	var dataSource, rawDatabase, rawTable, rawSQLs, cronSchedule, offsetKey, dbSchema string
 	var execOnStart bool
 	logpath, _ := conf.GetStringOr(KeyLogPath, "")
 
@@ -108,6 +109,7 @@ func NewMssqlReader(meta *reader.Meta, conf conf.MapConf) (reader.Reader, error)
 		return nil, err
 	}
 	dbSchema, _ = conf.GetStringOr(KeyMssqlSchema, "dbo")
+	rawTable, _ = conf.GetStringOr(KeyMssqlTable, "")
 	rawSQLs, _ = conf.GetStringOr(KeyMssqlSQL, "")
 	cronSchedule, _ = conf.GetStringOr(KeyMssqlCron, "")
 	execOnStart, _ = conf.GetBoolOr(KeyMssqlExecOnStart, true)
@@ -142,6 +144,7 @@ func NewMssqlReader(meta *reader.Meta, conf conf.MapConf) (reader.Reader, error)
 		datasource:    dataSource,
 		database:      rawDatabase,
 		rawDatabase:   rawDatabase,
+		rawTable:      rawTable,
 		rawSQLs:       rawSQLs,
 		Cron:          cron.New(),
 		readBatch:     readBatch,
@@ -156,9 +159,12 @@ func NewMssqlReader(meta *reader.Meta, conf conf.MapConf) (reader.Reader, error)
 	if r.rawDatabase == "" {
 		r.rawDatabase = "*"
 	}
+	if r.rawTable == "" {
+		r.rawTable = "*"
+	}
 
 	if r.rawSQLs == "" {
-		valid := CheckMagic(r.database)
+		valid := CheckMagic(r.database) && CheckMagic(r.rawTable)
 		if !valid {
 			err = fmt.Errorf(SupportReminder)
 			return nil, err
@@ -272,15 +278,15 @@ func (r *MssqlReader) execReadDB(curDB string, now time.Time, recordTablesDone T
 	var sqls string
 	if r.rawSQLs == "" {
 		// 获取符合条件的数据表和获取所有数据的语句
-		tables, sqls, err = r.getValidData(curDB, db)
+		tables, sqls, err = r.getValidData(curDB, now, db)
 		if err != nil {
-			log.Errorf("Runner[%s] %s rawTable: %v rawSQLs: %v get tables and sqls error %v", r.meta.RunnerName, r.Name(), "", r.rawSQLs, err)
+			log.Errorf("Runner[%s] %s rawTable: %v rawSQLs: %v get tables and sqls error %v", r.meta.RunnerName, r.Name(), r.rawTable, r.rawSQLs, err)
 			if len(tables) == 0 && sqls == "" {
 				return err
 			}
 		}
 
-		log.Infof("Runner[%s] %s default sqls %v", r.meta.RunnerName, r.Name(), sqls)
+		log.Infof("Runner[%s] %s default tables %v sqls %v", r.meta.RunnerName, r.Name(), tables, sqls)
 		if r.omitDoneDBRecords && !recordTablesDone.RestoreTableDone(r.meta, curDB, tables) {
 			// 兼容
 			r.syncRecords.SetTableRecords(curDB, recordTablesDone)
@@ -317,11 +323,9 @@ func (r *MssqlReader) execReadDB(curDB string, now time.Time, recordTablesDone T
 				}
 			}
 
-			r.muxOffsets.RLock()
-			defer r.muxOffsets.RUnlock()
 			execSQL := r.getSQL(rawSql, idx)
 			// 执行每条 sql 语句
-			exit, readSize, err = r.execReadSql(execSQL, curDB, idx, tables, db)
+			exit, readSize, err = r.execReadSql(curDB, execSQL, idx, tables, db)
 			if err != nil {
 				return err
 			}
@@ -346,8 +350,17 @@ func (r *MssqlReader) execReadDB(curDB string, now time.Time, recordTablesDone T
 	return nil
 }
 
-// 获取有效数据
-func (r *MssqlReader) getValidData(curDB string, db *sql.DB) (validData []string, sqls string, err error) {
+// 获取有效数据，mssql_table 中有魔法变量时只读取与渲染结果匹配的数据表
+func (r *MssqlReader) getValidData(curDB string, now time.Time, db *sql.DB) (validData []string, sqls string, err error) {
+	var magicRes *MagicRes
+	if r.rawTable != "*" {
+		res, err := GoMagicIndex(r.rawTable, now)
+		if err != nil {
+			return nil, "", err
+		}
+		magicRes = &res
+	}
+
 	// get all databases and check validate database
 	query := strings.Replace(DefaultMSSQLTable, "DATABASE_NAME", curDB, -1)
 	query = strings.Replace(query, "SCHEMA_NAME", r.dbSchema, -1)
@@ -371,6 +384,10 @@ func (r *MssqlReader) getValidData(curDB string, db *sql.DB) (validData []string
 			continue
 		}
 
+		if !r.matchTable(curDB, s, magicRes) {
+			continue
+		}
+
 		tableName := fmt.Sprintf("\"%s\".\"%s\"", r.dbSchema, s)
 		sqls += "Select * From " + tableName + ";"
 
@@ -380,6 +397,30 @@ func (r *MssqlReader) getValidData(curDB string, db *sql.DB) (validData []string
 	return validData, sqls, nil
 }
 
+// matchTable 判断数据表是否与 mssql_table 的渲染结果匹配，与 mysql_table 相同，
+// 只执行一次时读取与渲染结果相同的数据表，定时任务读取上次读到的数据表到渲染结果之间的数据表
+func (r *MssqlReader) matchTable(curDB, table string, magicRes *MagicRes) bool {
+	if magicRes == nil {
+		return true
+	}
+	magicRemainStr := GetRemainStr(magicRes.Ret, magicRes.RemainIndex)
+	if !CompareRemainStr(table, magicRemainStr, magicRes.Ret, magicRes.RemainIndex) {
+		log.Debugf("Runner[%v] %v current table: %v, current time table: %v, remain str: %v, timeIndex: %v", r.meta.RunnerName, curDB, table, magicRes.Ret, magicRemainStr, magicRes.RemainIndex)
+		return false
+	}
+	if !r.isLoop && !r.cronSchedule {
+		return EqualTime(table, magicRes.Ret, magicRes.TimeStart, magicRes.TimeEnd)
+	}
+	if !CompareTime(table, magicRes.Ret, magicRes.TimeStart, magicRes.TimeEnd, true) {
+		return false
+	}
+	// 取大于等于最后一次读取的数据表
+	if len(r.lastTable) == 0 || !CompareRemainStr(r.lastTable, magicRemainStr, magicRes.Ret, magicRes.RemainIndex) {
+		return true
+	}
+	return CompareTime(table, r.lastTable, magicRes.TimeStart, magicRes.TimeEnd, false)
+}
+
 func (r *MssqlReader) Name() string {
 	return "MSSQL_Reader:" + r.rawDatabase + "_" + models.Hash(r.rawSQLs)
 }
@@ -473,7 +514,7 @@ func (r *MssqlReader) checkExit(idx int, db *sql.DB) (bool, int64) {
 		return true, -1
 	}
 	rawSQL := strings.TrimSuffix(strings.TrimSpace(r.syncSQLs[idx]), ";")
-	rawSQLIdx := strings.Index(rawSQL, "from")
+	rawSQLIdx := strings.Index(strings.ToLower(rawSQL), "from")
 	if rawSQLIdx < 0 {
 		return true, -1
 	}
@@ -722,6 +763,9 @@ func (r *MssqlReader) updateOffset(idx, offsetKeyIndex int, maxOffset int64, sca
 }
 
 func (r *MssqlReader) getSQL(rawSql string, idx int) string {
+	r.muxOffsets.RLock()
+	defer r.muxOffsets.RUnlock()
+
 	var rawSQL = strings.TrimSuffix(strings.TrimSpace(rawSql), ";")
 	if len(r.offsetKey) > 0 && len(r.offsets) > idx {
 		return fmt.Sprintf("%s WHERE CAST(%v AS BIGINT) >= %d AND CAST(%v AS BIGINT) < %d;", rawSQL, r.offsetKey, r.offsets[idx], r.offsetKey, r.offsets[idx]+int64(r.readBatch))
@@ -0,0 +1,67 @@
+package mssql
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/reader"
+	. "github.com/qiniu/logkit/reader/config"
+	. "github.com/qiniu/logkit/reader/sql"
+)
+
+func getMeta(t *testing.T) (*reader.Meta, func()) {
+	dir, err := ioutil.TempDir("", "mssql_test")
+	require.NoError(t, err)
+	meta, err := reader.NewMetaWithConf(conf.MapConf{
+		KeyMetaPath: dir,
+		KeyFileDone: dir,
+		KeyMode:     ModeMSSQL,
+	})
+	require.NoError(t, err)
+	return meta, func() { os.RemoveAll(dir) }
+}
+
+func TestMssqlGetSQL(t *testing.T) {
+	r := &MssqlReader{
+		readBatch: 100,
+		offsetKey: "id",
+		offsets:   []int64{200},
+	}
+	assert.Equal(t, `Select * From "dbo"."t" WHERE CAST(id AS BIGINT) >= 200 AND CAST(id AS BIGINT) < 300;`, r.getSQL(`Select * From "dbo"."t";`, 0))
+	// 没有对应的 offset 时使用原始的 sql
+	assert.Equal(t, "select * from t", r.getSQL("select * from t", 1))
+	r.offsetKey = ""
+	assert.Equal(t, "select * from t;", r.getSQL("select * from t;", 0))
+}
+
+func TestMssqlMatchTable(t *testing.T) {
+	meta, clean := getMeta(t)
+	defer clean()
+	now := time.Date(2019, 10, 16, 12, 0, 0, 0, time.Local)
+	magicRes, err := GoMagicIndex("logs_@(YYYY)@(MM)@(DD)", now)
+	require.NoError(t, err)
+
+	r := &MssqlReader{meta: meta}
+	// 未配置 mssql_table 时匹配所有数据表
+	assert.True(t, r.matchTable("db", "anything", nil))
+
+	// 只执行一次时只读取渲染结果对应的数据表
+	assert.True(t, r.matchTable("db", "logs_20191016", &magicRes))
+	assert.False(t, r.matchTable("db", "logs_20191015", &magicRes))
+	assert.False(t, r.matchTable("db", "other_20191016", &magicRes))
+
+	// 定时任务读取上次读到的数据表到渲染结果之间的数据表
+	r.cronSchedule = true
+	assert.True(t, r.matchTable("db", "logs_20191015", &magicRes))
+	assert.False(t, r.matchTable("db", "logs_20191017", &magicRes))
+	r.lastTable = "logs_20191015"
+	assert.True(t, r.matchTable("db", "logs_20191015", &magicRes))
+	assert.True(t, r.matchTable("db", "logs_20191016", &magicRes))
+	assert.False(t, r.matchTable("db", "logs_20191014", &magicRes))
+}
@@ -100,6 +100,8 @@ type MysqlReader struct {
 	CurrentCount      int64
 	countLock         sync.RWMutex
 	sqlsRecord        map[string]string
+	chunkWorkers      int            // 全量导出的并发数
+	chunkProgress     *ChunkProgress // 全量导出的分片进度
 }
 
 func NewMysqlReader(meta *reader.Meta, conf conf.MapConf) (reader.Reader, error) {
@@ -167,6 +169,7 @@ func NewMysqlReader(meta *reader.Meta, conf conf.MapConf) (reader.Reader, error)
 		encoder = strings.Replace(strings.ToLower(encoder), "-", "", -1)
 	}
 	historyAll, _ = conf.GetBoolOr(KeyMysqlHistoryAll, false)
+	chunkWorkers, _ := conf.GetIntOr(KeySQLChunkWorkers, 0)
 	table, _ = conf.GetStringOr(KyeMysqlTable, "")
 	table = strings.TrimSpace(table)
 	rawSchemas, _ := conf.GetStringListOr(KeySQLSchema, []string{})
@@ -223,6 +226,8 @@ func NewMysqlReader(meta *reader.Meta, conf conf.MapConf) (reader.Reader, error)
 		encoder:     encoder,
 		dbSchema:    dbSchema,
 		sqlsRecord:  make(map[string]string),
+
+		chunkWorkers: chunkWorkers,
 	}
 
 	if r.rawDatabase == "" {
@@ -235,6 +240,13 @@ func NewMysqlReader(meta *reader.Meta, conf conf.MapConf) (reader.Reader, error)
 	if r.timestampKey != "" {
 		r.restoreTimestamp()
 	}
+	if r.isChunkDump() {
+		r.chunkProgress, err = RestoreChunkProgress(r.meta.DoneFilePath)
+		if err != nil {
+			log.Errorf("Runner[%v] %v restore chunk progress error %v, omit it", r.meta.RunnerName, r.Name(), err)
+			r.chunkProgress = nil
+		}
+	}
 	// 没有任何信息并且填写了sql语句时恢复
 	if r.isRecordSqls() {
 		r.sqlsRecord = RestoreSqls(r.meta)
@@ -444,6 +456,11 @@ func (r *MysqlReader) SyncMeta() {
 	}
 	r.muxOffsets.RLock()
 	defer r.muxOffsets.RUnlock()
+	if r.chunkProgress != nil {
+		if err := WriteChunkProgress(r.meta.DoneFilePath, r.chunkProgress); err != nil {
+			log.Errorf("Runner[%v] %v SyncMeta WriteChunkProgress error %v", r.meta.RunnerName, r.Name(), err)
+		}
+	}
 	for _, offset := range r.offsets {
 		encodeSQLs = append(encodeSQLs, strconv.FormatInt(offset, 10))
 	}
@@ -723,6 +740,16 @@ func (r *MysqlReader) execReadDB(curDB string, now time.Time, recordTablesDone T
 	tablesLen := len(tables)
 	log.Infof("Runner[%v] %v start to work, sqls %v offsets %v", r.meta.RunnerName, r.Name(), r.syncSQLs, r.offsets)
 
+	if r.isChunkDump() {
+		if err = r.execChunkDump(db); err != nil {
+			return err
+		}
+		if r.isStopping() || r.hasStopped() {
+			log.Warnf("Runner[%v] %v stopped from running", r.meta.RunnerName, r.Name())
+			return nil
+		}
+	}
+
 	sqlsRecordMap := make(map[string]bool)
 	if sqlStr, ok := r.sqlsRecord[curDB]; ok {
 		sqls := strings.Split(sqlStr, ",")
@@ -782,6 +809,100 @@ func (r *MysqlReader) isRecordSqls() bool {
 	return r.timestampKey == "" && r.offsetKey == "" && r.rawSQLs != ""
 }
 
+// 是否需要在增量读取前并发导出全表数据
+func (r *MysqlReader) isChunkDump() bool {
+	return r.chunkWorkers > 1 && r.rawSQLs != "" && r.offsetKey != "" && r.timestampKey == ""
+}
+
+// execChunkDump 首次读取时按 offset 列的范围切分分片并发导出，完成后从最大值的下一个开始增量读取
+func (r *MysqlReader) execChunkDump(db *sql.DB) error {
+	if len(r.syncSQLs) != 1 {
+		log.Warnf("Runner[%v] %v chunk dump only support single sql, got %d sqls, skip it", r.meta.RunnerName, r.Name(), len(r.syncSQLs))
+		return nil
+	}
+	rawSQL := r.syncSQLs[0]
+	r.muxOffsets.RLock()
+	progress := r.chunkProgress
+	offset := r.offsets[0]
+	r.muxOffsets.RUnlock()
+
+	if progress == nil || progress.SQL != rawSQL {
+		// 已经开始增量读取的不再进行全量导出
+		if offset > 0 {
+			return nil
+		}
+		min, max, ok, err := QueryKeyRange(db, rawSQL, r.offsetKey)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		progress = NewChunkProgress(rawSQL, min, max, r.chunkWorkers)
+		r.muxOffsets.Lock()
+		r.chunkProgress = progress
+		r.muxOffsets.Unlock()
+		log.Infof("Runner[%v] %v start chunk dump, %v range [%d, %d] split into %d chunks", r.meta.RunnerName, r.Name(), r.offsetKey, min, max, len(progress.Chunks))
+	}
+
+	if !progress.Done() {
+		trimSQL := strings.TrimSuffix(strings.TrimSpace(rawSQL), ";")
+		dumper := &ChunkDumper{
+			Progress: progress,
+			Workers:  r.chunkWorkers,
+			Step:     int64(r.readBatch),
+			BuildSQL: func(start, end int64) string {
+				return fmt.Sprintf("%s WHERE %v >= %d AND %v < %d;", trimSQL, r.offsetKey, start, r.offsetKey, end)
+			},
+			Read: func(execSQL string) error {
+				return r.execChunkSql(execSQL, db)
+			},
+			Stopped: func() bool {
+				return r.isStopping() || r.hasStopped()
+			},
+		}
+		if err := dumper.Run(); err != nil {
+			return err
+		}
+		if !progress.Done() {
+			return nil
+		}
+		log.Infof("Runner[%v] %v chunk dump finished, switch to incremental mode", r.meta.RunnerName, r.Name())
+	}
+
+	r.muxOffsets.Lock()
+	if r.offsets[0] <= progress.Max {
+		r.offsets[0] = progress.Max + 1
+	}
+	r.muxOffsets.Unlock()
+	return nil
+}
+
+// execChunkSql 执行全量导出的单个批次，会被多个 worker 并发调用
+func (r *MysqlReader) execChunkSql(execSQL string, db *sql.DB) error {
+	log.Debugf("Runner[%v] reader <%v> start to exec chunk sql <%v>", r.meta.RunnerName, r.Name(), execSQL)
+	rows, err := db.Query(execSQL)
+	if err != nil {
+		return fmt.Errorf("runner[%v] %v prepare <%v> query error %v", r.meta.RunnerName, r.Name(), execSQL, err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return fmt.Errorf("runner[%v] %v prepare <%v> columns error %v", r.meta.RunnerName, r.Name(), execSQL, err)
+	}
+	scanArgs, nochiced := GetInitScans(len(columns), rows, r.schemas, r.meta.RunnerName, r.Name())
+	alldatas, closed := r.getAllDatas(rows, scanArgs, columns, nochiced)
+	if closed {
+		return nil
+	}
+	for _, v := range alldatas {
+		r.readChan <- v
+		atomic.AddInt64(&r.CurrentCount, 1)
+	}
+	return rows.Err()
+}
+
 func (r *MysqlReader) getSQL(idx int, rawSQL string) string {
 	r.muxOffsets.RLock()
 	defer r.muxOffsets.RUnlock()
@@ -0,0 +1,335 @@
+package nats
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NATS 客户端协议见 https://docs.nats.io/reference/reference-protocols/nats-protocol，
+// 这里只实现 reader 需要的部分：连接、订阅、发布以及接收 MSG 和 HMSG
+
+const (
+	dialTimeout     = 10 * time.Second
+	clientName      = "logkit"
+	protocolVersion = 1
+)
+
+type serverInfo struct {
+	ServerID     string `json:"server_id"`
+	Version      string `json:"version"`
+	TLSRequired  bool   `json:"tls_required"`
+	AuthRequired bool   `json:"auth_required"`
+	Headers      bool   `json:"headers"`
+	MaxPayload   int64  `json:"max_payload"`
+}
+
+type connectInfo struct {
+	Verbose      bool   `json:"verbose"`
+	Pedantic     bool   `json:"pedantic"`
+	TLSRequired  bool   `json:"tls_required"`
+	Name         string `json:"name"`
+	Lang         string `json:"lang"`
+	Version      string `json:"version"`
+	Protocol     int    `json:"protocol"`
+	Headers      bool   `json:"headers"`
+	NoResponders bool   `json:"no_responders"`
+	User         string `json:"user,omitempty"`
+	Pass         string `json:"pass,omitempty"`
+	Token        string `json:"auth_token,omitempty"`
+}
+
+// message 服务端推送的消息，status 为 HMSG 头部中的状态码，如 JetStream 拉取结束时的 404、408
+type message struct {
+	subject string
+	sid     string
+	reply   string
+	status  string
+	data    []byte
+}
+
+type dialOptions struct {
+	user      string
+	password  string
+	token     string
+	tlsConfig *tls.Config
+}
+
+type natsConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+	info serverInfo
+
+	// writeLock 发布消息可能来自读取数据的协程和 SyncMeta 所在的协程
+	writeLock sync.Mutex
+	bw        *bufio.Writer
+}
+
+// parseServer 解析 nats://[user:password@]host:port 或者 tls://host:port 形式的地址，没有 scheme 时为 nats
+func parseServer(server string) (*url.URL, error) {
+	server = strings.TrimSpace(server)
+	if !strings.Contains(server, "://") {
+		server = "nats://" + server
+	}
+	u, err := url.Parse(server)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "nats", "tls":
+	default:
+		return nil, fmt.Errorf("scheme %q is not supported, only nats and tls", u.Scheme)
+	}
+	if u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	return u, nil
+}
+
+// dial 连接服务端，完成 INFO、TLS 升级和 CONNECT，收到 PONG 后返回
+func dial(u *url.URL, opts *dialOptions) (*natsConn, error) {
+	conn, err := net.DialTimeout("tcp", u.Host, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &natsConn{conn: conn, br: bufio.NewReader(conn)}
+	if err = c.handshake(u, opts); err != nil {
+		c.conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *natsConn) handshake(u *url.URL, opts *dialOptions) error {
+	c.conn.SetDeadline(time.Now().Add(dialTimeout))
+	defer c.conn.SetDeadline(time.Time{})
+
+	line, err := c.readLine()
+	if err != nil {
+		return err
+	}
+	op, args := splitOp(line)
+	if op != "INFO" {
+		return fmt.Errorf("expect INFO from server, got %q", line)
+	}
+	if err = json.Unmarshal([]byte(args), &c.info); err != nil {
+		return fmt.Errorf("parse server INFO error: %v", err)
+	}
+
+	useTLS := u.Scheme == "tls" || c.info.TLSRequired
+	if useTLS {
+		cfg := &tls.Config{}
+		if opts.tlsConfig != nil {
+			cfg = opts.tlsConfig.Clone()
+		}
+		if cfg.ServerName == "" {
+			cfg.ServerName = u.Hostname()
+		}
+		tc := tls.Client(c.conn, cfg)
+		if err = tc.Handshake(); err != nil {
+			return fmt.Errorf("tls handshake error: %v", err)
+		}
+		c.conn, c.br = tc, bufio.NewReader(tc)
+	}
+	c.bw = bufio.NewWriter(c.conn)
+
+	info := connectInfo{
+		TLSRequired:  useTLS,
+		Name:         clientName,
+		Lang:         "go",
+		Version:      "1.0.0",
+		Protocol:     protocolVersion,
+		Headers:      c.info.Headers,
+		NoResponders: c.info.Headers,
+		User:         opts.user,
+		Pass:         opts.password,
+		Token:        opts.token,
+	}
+	if u.User != nil {
+		if password, ok := u.User.Password(); ok {
+			info.User, info.Pass = u.User.Username(), password
+		} else {
+			info.Token = u.User.Username()
+		}
+	}
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	if err = c.write("CONNECT " + string(data) + "\r\nPING\r\n"); err != nil {
+		return err
+	}
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return err
+		}
+		switch op, args := splitOp(line); op {
+		case "PONG":
+			return nil
+		case "+OK", "INFO":
+		case "-ERR":
+			return fmt.Errorf("connect error: %v", strings.Trim(args, "' "))
+		default:
+			return fmt.Errorf("unexpected %q while connecting", line)
+		}
+	}
+}
+
+func splitOp(line string) (string, string) {
+	parts := strings.SplitN(line, " ", 2)
+	op := strings.ToUpper(parts[0])
+	if len(parts) == 1 {
+		return op, ""
+	}
+	return op, strings.TrimSpace(parts[1])
+}
+
+func (c *natsConn) readLine() (string, error) {
+	line, err := c.br.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func (c *natsConn) write(s string) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	if _, err := c.bw.WriteString(s); err != nil {
+		return err
+	}
+	return c.bw.Flush()
+}
+
+func (c *natsConn) subscribe(subject, queue, sid string) error {
+	if queue != "" {
+		return c.write(fmt.Sprintf("SUB %s %s %s\r\n", subject, queue, sid))
+	}
+	return c.write(fmt.Sprintf("SUB %s %s\r\n", subject, sid))
+}
+
+func (c *natsConn) publish(subject, reply string, data []byte) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	if err := c.writePub(subject, reply, data); err != nil {
+		return err
+	}
+	return c.bw.Flush()
+}
+
+// publishAll 向多个主题发布相同的内容，只在最后刷新一次，用于批量确认 JetStream 消息
+func (c *natsConn) publishAll(subjects []string, data []byte) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	for _, subject := range subjects {
+		if err := c.writePub(subject, "", data); err != nil {
+			return err
+		}
+	}
+	return c.bw.Flush()
+}
+
+func (c *natsConn) writePub(subject, reply string, data []byte) error {
+	var err error
+	if reply != "" {
+		_, err = fmt.Fprintf(c.bw, "PUB %s %s %d\r\n", subject, reply, len(data))
+	} else {
+		_, err = fmt.Fprintf(c.bw, "PUB %s %d\r\n", subject, len(data))
+	}
+	if err != nil {
+		return err
+	}
+	if _, err = c.bw.Write(data); err != nil {
+		return err
+	}
+	_, err = c.bw.WriteString("\r\n")
+	return err
+}
+
+// readMsg 读取下一条消息，自动回复服务端的 PING，忽略 PONG、+OK 和 INFO
+func (c *natsConn) readMsg() (*message, error) {
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+		op, args := splitOp(line)
+		switch op {
+		case "MSG", "HMSG":
+			return c.readPayload(op == "HMSG", strings.Fields(args))
+		case "PING":
+			if err = c.write("PONG\r\n"); err != nil {
+				return nil, err
+			}
+		case "PONG", "+OK", "INFO":
+		case "-ERR":
+			return nil, fmt.Errorf("server error: %v", strings.Trim(args, "' "))
+		default:
+			return nil, fmt.Errorf("unknown protocol operation %q", line)
+		}
+	}
+}
+
+// readPayload 读取 MSG <subject> <sid> [reply] <size> 或者 HMSG <subject> <sid> [reply] <header size> <total size> 的消息体
+func (c *natsConn) readPayload(withHeader bool, fields []string) (*message, error) {
+	n := 3
+	if withHeader {
+		n = 4
+	}
+	if len(fields) != n && len(fields) != n+1 {
+		return nil, fmt.Errorf("invalid message arguments %q", strings.Join(fields, " "))
+	}
+	msg := &message{subject: fields[0], sid: fields[1]}
+	if len(fields) == n+1 {
+		msg.reply = fields[2]
+	}
+	total, err := strconv.Atoi(fields[len(fields)-1])
+	if err != nil || total < 0 {
+		return nil, fmt.Errorf("invalid message size %q", fields[len(fields)-1])
+	}
+	headerSize := 0
+	if withHeader {
+		if headerSize, err = strconv.Atoi(fields[len(fields)-2]); err != nil || headerSize < 0 || headerSize > total {
+			return nil, fmt.Errorf("invalid message header size %q", fields[len(fields)-2])
+		}
+	}
+	buf := make([]byte, total+2)
+	if _, err = io.ReadFull(c.br, buf); err != nil {
+		return nil, err
+	}
+	if buf[total] != '\r' || buf[total+1] != '\n' {
+		return nil, errors.New("message payload is not terminated by CRLF")
+	}
+	if withHeader {
+		msg.status = headerStatus(buf[:headerSize])
+	}
+	msg.data = buf[headerSize:total]
+	return msg, nil
+}
+
+// headerStatus 返回 NATS/1.0 <status> [description] 中的状态码
+func headerStatus(header []byte) string {
+	line := string(header)
+	if i := strings.Index(line, "\r\n"); i >= 0 {
+		line = line[:i]
+	}
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return ""
+	}
+	return fields[1]
+}
+
+func (c *natsConn) Close() error {
+	return c.conn.Close()
+}
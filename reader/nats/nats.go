@@ -0,0 +1,583 @@
+package nats
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/qiniu/log"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/reader"
+	. "github.com/qiniu/logkit/reader/config"
+	. "github.com/qiniu/logkit/utils/models"
+	"github.com/qiniu/logkit/utils/tlspolicy"
+)
+
+var (
+	_ reader.DaemonReader = &Reader{}
+	_ reader.StatsReader  = &Reader{}
+	_ reader.Reader       = &Reader{}
+)
+
+const (
+	// ProgressFile 记录 JetStream 已经确认的 stream 序号的 meta 文件名
+	ProgressFile = "nats.records"
+
+	// pullExpires JetStream 每次拉取的等待时间，没有新消息时服务端在到期后返回 408
+	pullExpires = 5 * time.Second
+	// requestTimeout 等待 JetStream API 响应的时间，拉取时在 pullExpires 的基础上再等待该时间
+	requestTimeout = 5 * time.Second
+	reconnectWait  = 2 * time.Second
+)
+
+func init() {
+	reader.RegisterConstructor(ModeNATS, NewReader)
+}
+
+// Progress JetStream 消费的进度，durable consumer 被删除后从 StreamSeq 的下一条消息重新创建
+type Progress struct {
+	Stream    string `json:"stream"`
+	Durable   string `json:"durable"`
+	StreamSeq uint64 `json:"stream_seq"`
+}
+
+type apiError struct {
+	Code        int    `json:"code"`
+	ErrCode     int    `json:"err_code"`
+	Description string `json:"description"`
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("jetstream api error %d(%d): %s", e.Code, e.ErrCode, e.Description)
+}
+
+type apiResponse struct {
+	Error *apiError `json:"error"`
+}
+
+type consumerConfig struct {
+	Durable        string   `json:"durable_name"`
+	AckPolicy      string   `json:"ack_policy"`
+	DeliverPolicy  string   `json:"deliver_policy"`
+	OptStartSeq    uint64   `json:"opt_start_seq,omitempty"`
+	FilterSubject  string   `json:"filter_subject,omitempty"`
+	FilterSubjects []string `json:"filter_subjects,omitempty"`
+}
+
+type createConsumerRequest struct {
+	Stream string         `json:"stream_name"`
+	Config consumerConfig `json:"config"`
+}
+
+type pullRequest struct {
+	Batch   int           `json:"batch"`
+	Expires time.Duration `json:"expires"`
+}
+
+// Reader 订阅 NATS 的主题读取消息，每条消息为一行数据。配置了 JetStream stream 时通过 durable pull consumer 读取，
+// 消息在 runner 发送成功后才确认，已经确认的 stream 序号记录在 meta 中
+type Reader struct {
+	meta *reader.Meta
+	// Note: 原子操作，用于表示 reader 整体的运行状态
+	status int32
+
+	servers    []*url.URL
+	subjects   []string
+	queueGroup string
+	opts       *dialOptions
+	stream     string
+	durable    string
+	batch      int
+
+	conn       *natsConn
+	nextServer int
+	connLock   sync.Mutex
+
+	stopChan chan struct{}
+	readChan chan *message
+	wg       sync.WaitGroup
+
+	// currentSource 最近一次 ReadLine 读出的消息的主题，只在 ReadLine 所在的协程中访问
+	currentSource string
+
+	// unacked 已经读出、等待 SyncMeta 确认的 JetStream 消息的确认主题
+	unacked    []string
+	pendingSeq uint64
+	progress   Progress
+	ackLock    sync.Mutex
+
+	stats     StatsInfo
+	statsLock sync.RWMutex
+}
+
+func NewReader(meta *reader.Meta, c conf.MapConf) (reader.Reader, error) {
+	serverList, _ := c.GetStringListOr(KeyNATSServers, []string{DefaultNATSServer})
+	subjects, _ := c.GetStringListOr(KeyNATSSubjects, []string{})
+	queueGroup, _ := c.GetStringOr(KeyNATSQueueGroup, "")
+	user, _ := c.GetStringOr(KeyNATSUser, "")
+	password, _ := c.GetPasswordEnvStringOr(KeyNATSPassword, "")
+	token, _ := c.GetPasswordEnvStringOr(KeyNATSToken, "")
+	caFile, _ := c.GetStringOr(KeyNATSTLSCA, "")
+	stream, _ := c.GetStringOr(KeyNATSStream, "")
+	durable, _ := c.GetStringOr(KeyNATSDurable, "")
+	batch, _ := c.GetIntOr(KeyNATSBatch, DefaultNATSBatch)
+
+	var servers []*url.URL
+	for _, server := range serverList {
+		u, err := parseServer(server)
+		if err != nil {
+			return nil, fmt.Errorf("%v %q is invalid: %v", KeyNATSServers, server, err)
+		}
+		servers = append(servers, u)
+	}
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("%v is empty", KeyNATSServers)
+	}
+	opts := &dialOptions{user: user, password: password, token: token}
+	cfg := &tls.Config{}
+	if caFile != "" {
+		ca, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("read %v error: %v", KeyNATSTLSCA, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificate found in %v %v", KeyNATSTLSCA, caFile)
+		}
+		cfg.RootCAs = pool
+	}
+	opts.tlsConfig = tlspolicy.Apply(cfg)
+
+	readChanSize := 1000
+	if stream == "" {
+		if len(subjects) == 0 {
+			return nil, fmt.Errorf("%v is required when %v is not set", KeyNATSSubjects, KeyNATSStream)
+		}
+	} else {
+		if queueGroup != "" {
+			return nil, fmt.Errorf("%v is not used with JetStream, readers with the same %v share the messages", KeyNATSQueueGroup, KeyNATSDurable)
+		}
+		if durable == "" && meta != nil {
+			durable = invalidNameChars.Replace("logkit_" + meta.RunnerName)
+		}
+		if !validName(stream) {
+			return nil, fmt.Errorf("%v %q is invalid", KeyNATSStream, stream)
+		}
+		if !validName(durable) {
+			return nil, fmt.Errorf("%v %q is invalid", KeyNATSDurable, durable)
+		}
+		if batch <= 0 {
+			return nil, fmt.Errorf("%v should be positive", KeyNATSBatch)
+		}
+		// 拉取到但还没有读出的消息也在计算确认超时，不宜缓存太多
+		readChanSize = batch
+	}
+
+	r := &Reader{
+		meta:       meta,
+		status:     StatusInit,
+		servers:    servers,
+		subjects:   subjects,
+		queueGroup: queueGroup,
+		opts:       opts,
+		stream:     stream,
+		durable:    durable,
+		batch:      batch,
+		stopChan:   make(chan struct{}),
+		readChan:   make(chan *message, readChanSize),
+		progress:   Progress{Stream: stream, Durable: durable},
+	}
+	if stream != "" && meta != nil {
+		progress, err := RestoreProgress(meta.Dir)
+		if err != nil {
+			log.Errorf("Runner[%v] restore %v error %v, consume from the position of the durable consumer", meta.RunnerName, ProgressFile, err)
+		} else if progress.Stream == stream && progress.Durable == durable {
+			r.progress = progress
+		}
+	}
+	return r, nil
+}
+
+var invalidNameChars = strings.NewReplacer(".", "_", "*", "_", ">", "_", " ", "_", "\t", "_")
+
+// validName stream 和 durable 的名称会作为主题的一部分，不能包含 . * > 和空白字符
+func validName(name string) bool {
+	return name != "" && invalidNameChars.Replace(name) == name
+}
+
+func progressPath(dir string) string {
+	return filepath.Join(dir, ProgressFile)
+}
+
+// RestoreProgress 从 meta 中恢复 JetStream 的消费进度，文件不存在时返回空的进度
+func RestoreProgress(dir string) (Progress, error) {
+	var progress Progress
+	data, err := ioutil.ReadFile(progressPath(dir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return progress, nil
+		}
+		return progress, err
+	}
+	err = json.Unmarshal(data, &progress)
+	return progress, err
+}
+
+// WriteProgress 将 JetStream 的消费进度写入 meta
+func WriteProgress(dir string, progress Progress) error {
+	data, err := json.Marshal(progress)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(progressPath(dir), data, DefaultFilePerm)
+}
+
+func (r *Reader) isStopping() bool {
+	return atomic.LoadInt32(&r.status) == StatusStopping
+}
+
+func (r *Reader) hasStopped() bool {
+	return atomic.LoadInt32(&r.status) == StatusStopped
+}
+
+func (r *Reader) Name() string {
+	if r.stream != "" {
+		return "nats_jetstream:" + r.stream + "/" + r.durable
+	}
+	return "nats:" + strings.Join(r.subjects, ",")
+}
+
+func (r *Reader) SetMode(mode string, v interface{}) error {
+	return errors.New("nats reader does not support read mode")
+}
+
+func (r *Reader) setStatsError(err string) {
+	r.statsLock.Lock()
+	defer r.statsLock.Unlock()
+	r.stats.LastError = err
+}
+
+func (r *Reader) Start() error {
+	if r.isStopping() || r.hasStopped() {
+		return errors.New("reader is stopping or has stopped")
+	} else if !atomic.CompareAndSwapInt32(&r.status, StatusInit, StatusRunning) {
+		log.Warnf("Runner[%v] %q daemon has already started and is running", r.meta.RunnerName, r.Name())
+		return nil
+	}
+
+	r.wg.Add(1)
+	go r.run()
+	log.Infof("Runner[%v] %q daemon has started", r.meta.RunnerName, r.Name())
+	return nil
+}
+
+// run 连接断开后依次尝试其它的服务端地址重新连接，直到 reader 停止
+func (r *Reader) run() {
+	defer r.wg.Done()
+	for {
+		err := r.consume()
+		if r.isStopping() || r.hasStopped() {
+			return
+		}
+		if err != nil {
+			log.Errorf("Runner[%v] %q consume error: %v, reconnect after %v", r.meta.RunnerName, r.Name(), err, reconnectWait)
+			r.setStatsError(err.Error())
+		}
+		select {
+		case <-r.stopChan:
+			return
+		case <-time.After(reconnectWait):
+		}
+	}
+}
+
+func (r *Reader) connect() (*natsConn, error) {
+	var lastErr error
+	for i := range r.servers {
+		u := r.servers[(r.nextServer+i)%len(r.servers)]
+		c, err := dial(u, r.opts)
+		if err == nil {
+			log.Infof("Runner[%v] %q connected to %v, server version %v", r.meta.RunnerName, r.Name(), u.Host, c.info.Version)
+			return c, nil
+		}
+		log.Warnf("Runner[%v] %q connect to %v error: %v", r.meta.RunnerName, r.Name(), u.Host, err)
+		lastErr = err
+	}
+	// 下次从下一个地址开始尝试，避免总是连接同一个服务端
+	r.nextServer = (r.nextServer + 1) % len(r.servers)
+	return nil, lastErr
+}
+
+func (r *Reader) consume() error {
+	c, err := r.connect()
+	if err != nil {
+		return err
+	}
+	r.connLock.Lock()
+	// Close 已经关闭了连接，不能再使用新的连接
+	if r.isStopping() || r.hasStopped() {
+		r.connLock.Unlock()
+		c.Close()
+		return nil
+	}
+	r.conn = c
+	r.connLock.Unlock()
+	defer func() {
+		r.connLock.Lock()
+		r.conn = nil
+		r.connLock.Unlock()
+		c.Close()
+	}()
+
+	if r.stream == "" {
+		return r.consumeCore(c)
+	}
+	return r.consumeJetStream(c)
+}
+
+// consumeCore 订阅所有主题，配置了 queue group 时同一个 group 中的订阅者分担消息
+func (r *Reader) consumeCore(c *natsConn) error {
+	for i, subject := range r.subjects {
+		if err := c.subscribe(subject, r.queueGroup, strconv.Itoa(i+1)); err != nil {
+			return err
+		}
+	}
+	for {
+		msg, err := c.readMsg()
+		if err != nil {
+			return err
+		}
+		if !r.send(msg) {
+			return nil
+		}
+	}
+}
+
+func newInbox() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return "_INBOX." + hex.EncodeToString(b)
+}
+
+// consumeJetStream 确保 durable consumer 存在后循环拉取消息。多个 reader 使用相同的 durable consumer 时分担 stream 中的消息
+func (r *Reader) consumeJetStream(c *natsConn) error {
+	inbox := newInbox()
+	if err := c.subscribe(inbox+".*", "", "1"); err != nil {
+		return err
+	}
+	if err := r.ensureConsumer(c, inbox+".api"); err != nil {
+		return err
+	}
+
+	next := fmt.Sprintf("$JS.API.CONSUMER.MSG.NEXT.%s.%s", r.stream, r.durable)
+	pull, err := json.Marshal(pullRequest{Batch: r.batch, Expires: pullExpires})
+	if err != nil {
+		return err
+	}
+	for {
+		if err = c.publish(next, inbox+".pull", pull); err != nil {
+			return err
+		}
+		for received := 0; received < r.batch; {
+			c.conn.SetReadDeadline(time.Now().Add(pullExpires + requestTimeout))
+			msg, err := c.readMsg()
+			if err != nil {
+				return err
+			}
+			if msg.status != "" {
+				// 404 没有新消息，408 拉取到期，其余的状态(如 409 consumer 被删除)需要重新连接
+				if msg.status == "404" || msg.status == "408" {
+					break
+				}
+				return fmt.Errorf("pull from consumer %v error, status %v", r.durable, msg.status)
+			}
+			if !strings.HasPrefix(msg.reply, "$JS.ACK.") {
+				continue
+			}
+			received++
+			if !r.send(msg) {
+				return nil
+			}
+		}
+	}
+}
+
+// ensureConsumer 查询 durable consumer，不存在时创建，meta 中有消费进度时从已经确认的下一条消息开始
+func (r *Reader) ensureConsumer(c *natsConn, inbox string) error {
+	var info apiResponse
+	if err := r.request(c, fmt.Sprintf("$JS.API.CONSUMER.INFO.%s.%s", r.stream, r.durable), inbox, nil, &info); err != nil {
+		return err
+	}
+	if info.Error == nil {
+		return nil
+	}
+	if info.Error.Code != 404 {
+		return info.Error
+	}
+
+	config := consumerConfig{Durable: r.durable, AckPolicy: "explicit", DeliverPolicy: "all"}
+	r.ackLock.Lock()
+	if seq := r.progress.StreamSeq; seq > 0 {
+		config.DeliverPolicy, config.OptStartSeq = "by_start_sequence", seq+1
+	}
+	r.ackLock.Unlock()
+	if len(r.subjects) == 1 {
+		config.FilterSubject = r.subjects[0]
+	} else if len(r.subjects) > 1 {
+		config.FilterSubjects = r.subjects
+	}
+	data, err := json.Marshal(createConsumerRequest{Stream: r.stream, Config: config})
+	if err != nil {
+		return err
+	}
+	var created apiResponse
+	if err = r.request(c, fmt.Sprintf("$JS.API.CONSUMER.DURABLE.CREATE.%s.%s", r.stream, r.durable), inbox, data, &created); err != nil {
+		return err
+	}
+	if created.Error != nil {
+		return created.Error
+	}
+	log.Infof("Runner[%v] %q created durable consumer %v, deliver policy %v, start sequence %v", r.meta.RunnerName, r.Name(),
+		r.durable, config.DeliverPolicy, config.OptStartSeq)
+	return nil
+}
+
+// request 调用 JetStream API，等待 inbox 上的响应
+func (r *Reader) request(c *natsConn, subject, inbox string, data []byte, v interface{}) error {
+	if err := c.publish(subject, inbox, data); err != nil {
+		return err
+	}
+	c.conn.SetReadDeadline(time.Now().Add(requestTimeout))
+	defer c.conn.SetReadDeadline(time.Time{})
+	for {
+		msg, err := c.readMsg()
+		if err != nil {
+			return err
+		}
+		if msg.subject != inbox {
+			continue
+		}
+		if msg.status == "503" {
+			return errors.New("no responders for JetStream API, JetStream may not be enabled")
+		}
+		return json.Unmarshal(msg.data, v)
+	}
+}
+
+func (r *Reader) send(msg *message) bool {
+	select {
+	case r.readChan <- msg:
+		return true
+	case <-r.stopChan:
+		return false
+	}
+}
+
+// streamSeq 从确认主题中解析 stream 序号，确认主题为 $JS.ACK.<stream>.<consumer>.<delivered>.<stream seq>.<consumer seq>.<timestamp>.<pending>，
+// 新版本的服务端在 $JS.ACK 后增加了 domain 和 account hash
+func streamSeq(reply string) uint64 {
+	tokens := strings.Split(reply, ".")
+	idx := 5
+	if len(tokens) >= 11 {
+		idx = 7
+	} else if len(tokens) != 9 {
+		return 0
+	}
+	seq, _ := strconv.ParseUint(tokens[idx], 10, 64)
+	return seq
+}
+
+func (r *Reader) Source() string {
+	return r.currentSource
+}
+
+func (r *Reader) ReadLine() (string, error) {
+	timer := time.NewTimer(time.Second)
+	defer timer.Stop()
+	select {
+	case msg := <-r.readChan:
+		r.currentSource = msg.subject
+		if r.stream != "" && msg.reply != "" {
+			r.ackLock.Lock()
+			r.unacked = append(r.unacked, msg.reply)
+			if seq := streamSeq(msg.reply); seq > r.pendingSeq {
+				r.pendingSeq = seq
+			}
+			r.ackLock.Unlock()
+		}
+		return string(msg.data), nil
+	case <-timer.C:
+	}
+
+	return "", nil
+}
+
+func (r *Reader) Status() StatsInfo {
+	r.statsLock.RLock()
+	defer r.statsLock.RUnlock()
+	return r.stats
+}
+
+// SyncMeta 在 runner 发送成功后确认已经读出的 JetStream 消息，并记录已经确认的 stream 序号。
+// 没有连接时留到下次确认，超过 ack_wait 没有确认的消息会被服务端重新投递
+func (r *Reader) SyncMeta() {
+	if r.stream == "" {
+		return
+	}
+	r.ackLock.Lock()
+	defer r.ackLock.Unlock()
+	if len(r.unacked) == 0 {
+		return
+	}
+	r.connLock.Lock()
+	c := r.conn
+	r.connLock.Unlock()
+	if c == nil {
+		log.Warnf("Runner[%v] %q is not connected, %d messages will be acknowledged later", r.meta.RunnerName, r.Name(), len(r.unacked))
+		return
+	}
+	if err := c.publishAll(r.unacked, []byte("+ACK")); err != nil {
+		log.Errorf("Runner[%v] %q acknowledge %d messages error: %v", r.meta.RunnerName, r.Name(), len(r.unacked), err)
+		r.setStatsError(err.Error())
+		return
+	}
+	r.unacked = r.unacked[:0]
+	if r.pendingSeq <= r.progress.StreamSeq {
+		return
+	}
+	r.progress.StreamSeq = r.pendingSeq
+	if err := WriteProgress(r.meta.Dir, r.progress); err != nil {
+		log.Errorf("Runner[%v] %v SyncMeta error %v", r.meta.RunnerName, r.Name(), err)
+	}
+}
+
+func (r *Reader) Close() error {
+	if !atomic.CompareAndSwapInt32(&r.status, StatusRunning, StatusStopping) {
+		log.Warnf("Runner[%v] reader %q is not running, close operation ignored", r.meta.RunnerName, r.Name())
+		return nil
+	}
+	log.Debugf("Runner[%v] %q daemon is stopping", r.meta.RunnerName, r.Name())
+	close(r.stopChan)
+	r.connLock.Lock()
+	if r.conn != nil {
+		r.conn.Close()
+	}
+	r.connLock.Unlock()
+	r.wg.Wait()
+	atomic.StoreInt32(&r.status, StatusStopped)
+	return nil
+}
@@ -0,0 +1,292 @@
+package nats
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/reader"
+	. "github.com/qiniu/logkit/reader/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+// fakeServer 模拟 NATS 服务端，记录客户端的 SUB 和 PUB，PUB 交给 onPub 处理
+type fakeServer struct {
+	ln    net.Listener
+	onPub func(w io.Writer, subject, reply string, payload []byte)
+
+	lock sync.Mutex
+	ops  []string
+	conn net.Conn
+}
+
+func newFakeServer(t *testing.T, onPub func(w io.Writer, subject, reply string, payload []byte)) *fakeServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	s := &fakeServer{ln: ln, onPub: onPub}
+	go s.serve()
+	return s
+}
+
+func (s *fakeServer) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.lock.Lock()
+		s.conn = conn
+		s.lock.Unlock()
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeServer) handle(conn net.Conn) {
+	defer conn.Close()
+	fmt.Fprintf(conn, "INFO {\"server_id\":\"fake\",\"version\":\"2.10.0\",\"headers\":true,\"max_payload\":1048576}\r\n")
+	br := bufio.NewReader(conn)
+	var wlock sync.Mutex
+	w := writerFunc(func(p []byte) (int, error) {
+		wlock.Lock()
+		defer wlock.Unlock()
+		return conn.Write(p)
+	})
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		fields := strings.Fields(line)
+		switch fields[0] {
+		case "PING":
+			w.Write([]byte("PONG\r\n"))
+		case "SUB":
+			s.record(line)
+		case "PUB":
+			size, _ := strconv.Atoi(fields[len(fields)-1])
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(br, payload); err != nil {
+				return
+			}
+			payload = payload[:size]
+			reply := ""
+			if len(fields) == 4 {
+				reply = fields[2]
+			}
+			s.record(fields[1] + " " + string(payload))
+			if s.onPub != nil {
+				s.onPub(w, fields[1], reply, payload)
+			}
+		}
+	}
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
+
+func (s *fakeServer) record(op string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.ops = append(s.ops, op)
+}
+
+// waitOps 等待服务端收到满足 match 的操作，返回所有满足条件的操作
+func (s *fakeServer) waitOps(match func(string) bool, n int) []string {
+	var matched []string
+	for i := 0; i < 50; i++ {
+		matched = matched[:0]
+		s.lock.Lock()
+		for _, op := range s.ops {
+			if match(op) {
+				matched = append(matched, op)
+			}
+		}
+		s.lock.Unlock()
+		if len(matched) >= n {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return matched
+}
+
+func (s *fakeServer) send(data string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.conn.Write([]byte(data))
+}
+
+func (s *fakeServer) Close() {
+	s.ln.Close()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.conn != nil {
+		s.conn.Close()
+	}
+}
+
+func hasPrefix(prefix string) func(string) bool {
+	return func(op string) bool {
+		return strings.HasPrefix(op, prefix)
+	}
+}
+
+func newNATSReader(t *testing.T, c conf.MapConf) *Reader {
+	meta, err := reader.NewMetaWithConf(c)
+	assert.NoError(t, err)
+	rd, err := NewReader(meta, c)
+	assert.NoError(t, err)
+	r := rd.(*Reader)
+	assert.NoError(t, r.Start())
+	return r
+}
+
+func TestNATSReader(t *testing.T) {
+	s := newFakeServer(t, nil)
+	defer s.Close()
+	dir, err := ioutil.TempDir("", "TestNATSReader")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	r := newNATSReader(t, conf.MapConf{
+		KeyMode:           ModeNATS,
+		KeyRunnerName:     "TestNATSReader",
+		KeyMetaPath:       dir,
+		KeyNATSServers:    "nats://127.0.0.1:1," + s.ln.Addr().String(),
+		KeyNATSSubjects:   "logs.>,metrics.*",
+		KeyNATSQueueGroup: "logkit",
+	})
+	defer r.Close()
+
+	subs := s.waitOps(hasPrefix("SUB"), 2)
+	assert.Equal(t, []string{"SUB logs.> logkit 1", "SUB metrics.* logkit 2"}, subs)
+	s.send("PING\r\nMSG logs.app 1 5\r\nhello\r\nHMSG metrics.cpu 2 reply 12 17\r\nNATS/1.0\r\n\r\nworld\r\n")
+	line, err := r.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", line)
+	assert.Equal(t, "logs.app", r.Source())
+	line, err = r.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "world", line)
+	assert.Equal(t, "metrics.cpu", r.Source())
+	// 非 JetStream 模式不确认消息
+	r.SyncMeta()
+	assert.Len(t, s.waitOps(hasPrefix("reply"), 0), 0)
+}
+
+func TestNATSJetStream(t *testing.T) {
+	var (
+		lock    sync.Mutex
+		creates []string
+		pulls   int
+	)
+	s := newFakeServer(t, func(w io.Writer, subject, reply string, payload []byte) {
+		switch {
+		case subject == "$JS.API.CONSUMER.INFO.LOGS.worker":
+			resp := `{"error":{"code":404,"err_code":10014,"description":"consumer not found"}}`
+			fmt.Fprintf(w, "MSG %s 1 %d\r\n%s\r\n", reply, len(resp), resp)
+		case subject == "$JS.API.CONSUMER.DURABLE.CREATE.LOGS.worker":
+			lock.Lock()
+			creates = append(creates, string(payload))
+			lock.Unlock()
+			fmt.Fprintf(w, "MSG %s 1 2\r\n{}\r\n", reply)
+		case subject == "$JS.API.CONSUMER.MSG.NEXT.LOGS.worker":
+			lock.Lock()
+			pulls++
+			first := pulls == 1
+			lock.Unlock()
+			if !first {
+				return
+			}
+			fmt.Fprintf(w, "MSG logs.app 1 $JS.ACK.LOGS.worker.1.5.1.1700000000000000000.1 2\r\nm1\r\n")
+			fmt.Fprintf(w, "MSG logs.app 1 $JS.ACK.hub.ACCHASH.LOGS.worker.1.6.2.1700000000000000000.0.token 2\r\nm2\r\n")
+			header := "NATS/1.0 408 Request Timeout\r\n\r\n"
+			fmt.Fprintf(w, "HMSG %s 1 %d %d\r\n%s\r\n", reply, len(header), len(header), header)
+		}
+	})
+	defer s.Close()
+	dir, err := ioutil.TempDir("", "TestNATSJetStream")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	c := conf.MapConf{
+		KeyMode:         ModeNATS,
+		KeyRunnerName:   "TestNATSJetStream",
+		KeyMetaPath:     dir,
+		KeyNATSServers:  s.ln.Addr().String(),
+		KeyNATSSubjects: "logs.*",
+		KeyNATSStream:   "LOGS",
+		KeyNATSDurable:  "worker",
+		KeyNATSBatch:    "10",
+	}
+	r := newNATSReader(t, c)
+
+	assert.Equal(t, "m1", readLine(t, r))
+	assert.Equal(t, "m2", readLine(t, r))
+	assert.Equal(t, "logs.app", r.Source())
+	// 发送成功(SyncMeta)后才确认
+	assert.Len(t, s.waitOps(hasPrefix("$JS.ACK"), 0), 0)
+	r.SyncMeta()
+	acks := s.waitOps(hasPrefix("$JS.ACK"), 2)
+	assert.Equal(t, []string{
+		"$JS.ACK.LOGS.worker.1.5.1.1700000000000000000.1 +ACK",
+		"$JS.ACK.hub.ACCHASH.LOGS.worker.1.6.2.1700000000000000000.0.token +ACK",
+	}, acks)
+	progress, err := RestoreProgress(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, Progress{Stream: "LOGS", Durable: "worker", StreamSeq: 6}, progress)
+	assert.NoError(t, r.Close())
+
+	// consumer 不存在时从 meta 中记录的下一条消息开始创建
+	r = newNATSReader(t, c)
+	defer r.Close()
+	s.waitOps(hasPrefix("$JS.API.CONSUMER.DURABLE.CREATE"), 2)
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Len(t, creates, 2)
+	assert.Equal(t, `{"stream_name":"LOGS","config":{"durable_name":"worker","ack_policy":"explicit","deliver_policy":"all","filter_subject":"logs.*"}}`, creates[0])
+	assert.Equal(t, `{"stream_name":"LOGS","config":{"durable_name":"worker","ack_policy":"explicit","deliver_policy":"by_start_sequence","opt_start_seq":7,"filter_subject":"logs.*"}}`, creates[1])
+}
+
+func readLine(t *testing.T, r *Reader) string {
+	for i := 0; i < 5; i++ {
+		line, err := r.ReadLine()
+		assert.NoError(t, err)
+		if line != "" {
+			return line
+		}
+	}
+	return ""
+}
+
+func TestStreamSeq(t *testing.T) {
+	assert.Equal(t, uint64(5), streamSeq("$JS.ACK.LOGS.worker.1.5.1.1700000000.1"))
+	assert.Equal(t, uint64(6), streamSeq("$JS.ACK.hub.ACCHASH.LOGS.worker.1.6.2.1700000000.0"))
+	assert.Equal(t, uint64(0), streamSeq("$JS.ACK.LOGS"))
+}
+
+func TestNewReaderError(t *testing.T) {
+	for _, c := range []conf.MapConf{
+		{},
+		{KeyNATSSubjects: "a", KeyNATSServers: "http://127.0.0.1:4222"},
+		{KeyNATSStream: "LOGS.1", KeyNATSDurable: "d"},
+		{KeyNATSStream: "LOGS", KeyNATSDurable: "a.b"},
+		{KeyNATSStream: "LOGS", KeyNATSDurable: "d", KeyNATSQueueGroup: "q"},
+		{KeyNATSStream: "LOGS", KeyNATSDurable: "d", KeyNATSBatch: "0"},
+	} {
+		_, err := NewReader(nil, c)
+		assert.Error(t, err, c)
+	}
+}
@@ -0,0 +1,338 @@
+package pgcdc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq/oid"
+
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+// 数据中的字段，每条数据为一行的变更，data 为变更后的行，old 为更新和删除前的行，
+// 没有设置 REPLICA IDENTITY FULL 时 old 只包含主键
+const (
+	FieldLSN        = "lsn"
+	FieldXID        = "xid"
+	FieldCommitTime = "commit_time"
+	FieldSchema     = "schema"
+	FieldTable      = "table"
+	FieldOp         = "op"
+	FieldData       = "data"
+	FieldOld        = "old"
+)
+
+// op 字段的取值
+const (
+	OpInsert   = "insert"
+	OpUpdate   = "update"
+	OpDelete   = "delete"
+	OpTruncate = "truncate"
+)
+
+// parseLSN 解析 16/B374D848 形式的 LSN
+func parseLSN(s string) (uint64, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("invalid LSN %q", s)
+	}
+	hi, err := strconv.ParseUint(parts[0], 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid LSN %q", s)
+	}
+	lo, err := strconv.ParseUint(parts[1], 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid LSN %q", s)
+	}
+	return hi<<32 | lo, nil
+}
+
+func formatLSN(lsn uint64) string {
+	return fmt.Sprintf("%X/%X", lsn>>32, uint32(lsn))
+}
+
+// decoder 解码逻辑解码插件输出的消息，每个复制连接使用一个 decoder
+type decoder interface {
+	// options 返回 START_REPLICATION 中插件的参数
+	options() string
+	// decode 解码一条 XLogData 中的消息，返回其中的行变更，commit 为 true 时表示事务已经提交
+	decode(lsn uint64, msg []byte) (rows []Data, commit bool, err error)
+}
+
+// quoteLiteral 将 s 转换为 SQL 字符串常量
+func quoteLiteral(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+// quoteIdent 将 s 转换为 SQL 标识符
+func quoteIdent(s string) string {
+	return `"` + strings.Replace(s, `"`, `""`, -1) + `"`
+}
+
+// pgoutputDecoder 解码 pgoutput 协议版本 1 的消息，见 https://www.postgresql.org/docs/current/protocol-logicalrep-message-formats.html
+type pgoutputDecoder struct {
+	publication string
+	relations   map[int32]*relation
+
+	xid        int64
+	commitTime string
+}
+
+type relation struct {
+	schema  string
+	table   string
+	columns []column
+}
+
+type column struct {
+	name string
+	typ  oid.Oid
+}
+
+func newPgoutputDecoder(publication string) *pgoutputDecoder {
+	return &pgoutputDecoder{publication: publication, relations: make(map[int32]*relation)}
+}
+
+func (d *pgoutputDecoder) options() string {
+	return fmt.Sprintf("(proto_version '1', publication_names %v)", quoteLiteral(quoteIdent(d.publication)))
+}
+
+func (d *pgoutputDecoder) decode(lsn uint64, msg []byte) ([]Data, bool, error) {
+	r := &msgReader{b: msg}
+	var rows []Data
+	commit := false
+	switch typ := r.byte(); typ {
+	case 'B':
+		r.uint64()
+		t := int64(r.uint64())
+		d.xid = int64(uint32(r.int32()))
+		d.commitTime = pgTime(t)
+	case 'C':
+		commit = true
+	case 'R':
+		id := r.int32()
+		rel := &relation{schema: r.string(), table: r.string()}
+		r.byte()
+		n := int(r.int16())
+		for i := 0; i < n && r.err == nil; i++ {
+			r.byte()
+			name := r.string()
+			typ := oid.Oid(r.int32())
+			r.int32()
+			rel.columns = append(rel.columns, column{name: name, typ: typ})
+		}
+		if r.err == nil {
+			d.relations[id] = rel
+		}
+	case 'I', 'U', 'D':
+		id := r.int32()
+		rel, ok := d.relations[id]
+		if r.err != nil {
+			break
+		}
+		if !ok {
+			return nil, false, fmt.Errorf("unknown relation %d in %c message at %v", id, typ, formatLSN(lsn))
+		}
+		row := d.row(lsn, rel)
+		switch typ {
+		case 'I':
+			row[FieldOp] = OpInsert
+			r.byte()
+			row[FieldData] = rel.tuple(r)
+		case 'U':
+			row[FieldOp] = OpUpdate
+			// 有旧值时先是 K 或 O 开头的旧值，然后是 N 开头的新值
+			if kind := r.byte(); kind == 'K' || kind == 'O' {
+				row[FieldOld] = rel.tuple(r)
+				r.byte()
+			}
+			row[FieldData] = rel.tuple(r)
+		case 'D':
+			row[FieldOp] = OpDelete
+			r.byte()
+			row[FieldOld] = rel.tuple(r)
+		}
+		rows = append(rows, row)
+	case 'T':
+		n := int(r.int32())
+		r.byte()
+		for i := 0; i < n && r.err == nil; i++ {
+			rel, ok := d.relations[r.int32()]
+			if !ok {
+				continue
+			}
+			row := d.row(lsn, rel)
+			row[FieldOp] = OpTruncate
+			rows = append(rows, row)
+		}
+	default:
+		// Origin、Type 以及更高版本协议中的消息不影响输出
+	}
+	if r.err != nil {
+		return nil, false, fmt.Errorf("invalid pgoutput message at %v: %v", formatLSN(lsn), r.err)
+	}
+	return rows, commit, nil
+}
+
+func (d *pgoutputDecoder) row(lsn uint64, rel *relation) Data {
+	return Data{
+		FieldLSN:        formatLSN(lsn),
+		FieldXID:        d.xid,
+		FieldCommitTime: d.commitTime,
+		FieldSchema:     rel.schema,
+		FieldTable:      rel.table,
+	}
+}
+
+// tuple 读取 TupleData，NULL 输出为 nil，未变化的 TOAST 值不输出
+func (rel *relation) tuple(r *msgReader) map[string]interface{} {
+	n := int(r.int16())
+	values := make(map[string]interface{}, n)
+	for i := 0; i < n && r.err == nil; i++ {
+		kind := r.byte()
+		var name string
+		var typ oid.Oid
+		if i < len(rel.columns) {
+			name, typ = rel.columns[i].name, rel.columns[i].typ
+		} else {
+			name = "column" + strconv.Itoa(i+1)
+		}
+		switch kind {
+		case 'n':
+			values[name] = nil
+		case 'u':
+		case 't':
+			values[name] = convertText(typ, string(r.next(int(r.int32()))))
+		default:
+			r.err = fmt.Errorf("unknown tuple data kind %q", kind)
+		}
+	}
+	return values
+}
+
+// convertText 将文本格式的值按类型转换，无法转换或者 JSON 无法表示的值保留为字符串
+func convertText(typ oid.Oid, s string) interface{} {
+	switch typ {
+	case oid.T_bool:
+		return s == "t"
+	case oid.T_int2, oid.T_int4, oid.T_int8, oid.T_oid:
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return n
+		}
+	case oid.T_float4, oid.T_float8:
+		if f, err := strconv.ParseFloat(s, 64); err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) {
+			return f
+		}
+	case oid.T_json, oid.T_jsonb:
+		dec := json.NewDecoder(strings.NewReader(s))
+		dec.UseNumber()
+		var v interface{}
+		if err := dec.Decode(&v); err == nil {
+			return v
+		}
+	}
+	return s
+}
+
+func pgTime(us int64) string {
+	return pgEpoch.Add(time.Duration(us) * time.Microsecond).Format(time.RFC3339Nano)
+}
+
+// wal2jsonDecoder 解码 wal2json format-version 2 的消息，每条消息为一个 JSON 对象
+type wal2jsonDecoder struct {
+	xid        int64
+	commitTime string
+}
+
+type wal2jsonColumn struct {
+	Name  string      `json:"name"`
+	Value interface{} `json:"value"`
+}
+
+type wal2jsonMessage struct {
+	Action    string           `json:"action"`
+	XID       int64            `json:"xid"`
+	Timestamp string           `json:"timestamp"`
+	Schema    string           `json:"schema"`
+	Table     string           `json:"table"`
+	Columns   []wal2jsonColumn `json:"columns"`
+	Identity  []wal2jsonColumn `json:"identity"`
+}
+
+func (d *wal2jsonDecoder) options() string {
+	return `("format-version" '2', "include-xids" '1', "include-timestamp" '1', "include-types" '0')`
+}
+
+func (d *wal2jsonDecoder) decode(lsn uint64, msg []byte) ([]Data, bool, error) {
+	var m wal2jsonMessage
+	dec := json.NewDecoder(bytes.NewReader(msg))
+	dec.UseNumber()
+	if err := dec.Decode(&m); err != nil {
+		return nil, false, fmt.Errorf("invalid wal2json message at %v: %v", formatLSN(lsn), err)
+	}
+	row := Data{
+		FieldLSN:        formatLSN(lsn),
+		FieldXID:        d.xid,
+		FieldCommitTime: d.commitTime,
+		FieldSchema:     m.Schema,
+		FieldTable:      m.Table,
+	}
+	switch m.Action {
+	case "B":
+		d.xid = m.XID
+		d.commitTime = ""
+		if t, err := parseWal2jsonTime(m.Timestamp); err == nil {
+			d.commitTime = t.Format(time.RFC3339Nano)
+		}
+		return nil, false, nil
+	case "C":
+		return nil, true, nil
+	case "I":
+		row[FieldOp] = OpInsert
+		row[FieldData] = wal2jsonValues(m.Columns)
+	case "U":
+		row[FieldOp] = OpUpdate
+		row[FieldData] = wal2jsonValues(m.Columns)
+		if len(m.Identity) > 0 {
+			row[FieldOld] = wal2jsonValues(m.Identity)
+		}
+	case "D":
+		row[FieldOp] = OpDelete
+		row[FieldOld] = wal2jsonValues(m.Identity)
+	case "T":
+		row[FieldOp] = OpTruncate
+	default:
+		// M 为 pg_logical_emit_message 写入的消息，不是行变更
+		return nil, false, nil
+	}
+	return []Data{row}, false, nil
+}
+
+// parseWal2jsonTime 解析 2019-01-02 03:04:05.123456+08 形式的时间
+func parseWal2jsonTime(s string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02 15:04:05.999999999-07", s); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02 15:04:05.999999999-07:00", s)
+}
+
+func wal2jsonValues(columns []wal2jsonColumn) map[string]interface{} {
+	values := make(map[string]interface{}, len(columns))
+	for _, c := range columns {
+		v := c.Value
+		if n, ok := v.(json.Number); ok {
+			if i, err := n.Int64(); err == nil {
+				v = i
+			} else if f, err := n.Float64(); err == nil {
+				v = f
+			}
+		}
+		values[c.Name] = v
+	}
+	return values
+}
@@ -0,0 +1,406 @@
+package pgcdc
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/qiniu/log"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/reader"
+	. "github.com/qiniu/logkit/reader/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+var (
+	_ reader.DaemonReader = &Reader{}
+	_ reader.StatsReader  = &Reader{}
+	_ reader.DataReader   = &Reader{}
+	_ reader.Reader       = &Reader{}
+)
+
+const (
+	// 复制出错后重新连接的间隔
+	reconnectInterval = 3 * time.Second
+	// readTimeout 服务端默认每 30 秒(wal_sender_timeout 的一半)发送一次 keepalive，超过该时间没有收到任何数据时认为连接已经断开
+	readTimeout = 2 * time.Minute
+)
+
+func init() {
+	reader.RegisterConstructor(ModePostgresCDC, NewReader)
+}
+
+// readInfo 放入 readChan 的一行变更，commit 为 true 时没有数据，表示 lsn 之前的事务都已经读出
+type readInfo struct {
+	data   Data
+	lsn    uint64
+	commit bool
+	bytes  int64
+}
+
+// Reader 通过逻辑复制槽读取 PostgreSQL 的行变更，支持 pgoutput 和 wal2json 插件，每行变更为一条数据。
+// 事务提交的位置在 runner 发送成功(SyncMeta)后写入 meta 并报告给服务端，服务端随后可以回收之前的 WAL，
+// 重启后从 meta 中的位置继续读取，没有提交的事务会重新读取
+type Reader struct {
+	meta *reader.Meta
+	// Note: 原子操作，用于表示 reader 整体的运行状态
+	status int32
+
+	stopChan chan struct{}
+	readChan chan readInfo
+	errChan  chan error
+
+	stats     StatsInfo
+	statsLock sync.RWMutex
+
+	cfg            *connConfig
+	slot           string
+	plugin         string
+	publication    string
+	createSlot     bool
+	statusInterval time.Duration
+
+	conn     *pgConn
+	connLock sync.Mutex
+
+	// lsn 最近一个已经被上层读取完的事务的提交位置，只在 ReadData 和 SyncMeta 中使用
+	lsnLock sync.Mutex
+	lsn     uint64
+	// confirmed 已经写入 meta 的位置，原子操作，定时报告给服务端
+	confirmed uint64
+	// sentLSN 最近一个已经全部放入 readChan 的事务的提交位置，重新连接时从这里继续读取，只在 run 中使用
+	sentLSN uint64
+}
+
+func NewReader(meta *reader.Meta, c conf.MapConf) (reader.Reader, error) {
+	dataSource, err := c.GetPasswordEnvString(KeyPGCDCDataSource)
+	if err != nil {
+		return nil, err
+	}
+	slot, _ := c.GetStringOr(KeyPGCDCSlot, DefaultPGCDCSlot)
+	plugin, _ := c.GetStringOr(KeyPGCDCPlugin, PGCDCPluginPgoutput)
+	publication, _ := c.GetStringOr(KeyPGCDCPublication, DefaultPGCDCPublication)
+	createSlot, _ := c.GetBoolOr(KeyPGCDCCreateSlot, true)
+	statusInterval, _ := c.GetStringOr(KeyPGCDCStatusInterval, "10s")
+
+	cfg, err := parseDataSource(dataSource)
+	if err != nil {
+		return nil, fmt.Errorf("%v is invalid: %v", KeyPGCDCDataSource, err)
+	}
+	if slot == "" {
+		return nil, fmt.Errorf("%v can not be empty", KeyPGCDCSlot)
+	}
+	if plugin != PGCDCPluginPgoutput && plugin != PGCDCPluginWal2json {
+		return nil, fmt.Errorf("%v should be %v or %v", KeyPGCDCPlugin, PGCDCPluginPgoutput, PGCDCPluginWal2json)
+	}
+	if plugin == PGCDCPluginPgoutput && publication == "" {
+		return nil, fmt.Errorf("%v can not be empty when %v is %v", KeyPGCDCPublication, KeyPGCDCPlugin, PGCDCPluginPgoutput)
+	}
+	interval, err := time.ParseDuration(statusInterval)
+	if err != nil || interval <= 0 {
+		return nil, fmt.Errorf("%v %q should be a positive duration like 10s", KeyPGCDCStatusInterval, statusInterval)
+	}
+
+	r := &Reader{
+		meta:           meta,
+		status:         StatusInit,
+		stopChan:       make(chan struct{}),
+		readChan:       make(chan readInfo, 1000),
+		errChan:        make(chan error),
+		cfg:            cfg,
+		slot:           slot,
+		plugin:         plugin,
+		publication:    publication,
+		createSlot:     createSlot,
+		statusInterval: interval,
+	}
+	if offset, _, err := meta.ReadOffset(); err == nil && offset != "" {
+		lsn, err := parseLSN(offset)
+		if err != nil {
+			log.Warnf("Runner[%v] %q ignore the invalid offset in meta: %v", meta.RunnerName, r.Name(), err)
+		} else {
+			r.lsn, r.confirmed, r.sentLSN = lsn, lsn, lsn
+		}
+	}
+	return r, nil
+}
+
+func (r *Reader) isStopping() bool {
+	return atomic.LoadInt32(&r.status) == StatusStopping
+}
+
+func (r *Reader) hasStopped() bool {
+	return atomic.LoadInt32(&r.status) == StatusStopped
+}
+
+func (r *Reader) Name() string {
+	return "postgres_cdc:" + net.JoinHostPort(r.cfg.host, r.cfg.port) + "/" + r.cfg.database + "/" + r.slot
+}
+
+func (r *Reader) SetMode(mode string, v interface{}) error {
+	return errors.New("postgres cdc reader does not support read mode")
+}
+
+func (r *Reader) setStatsError(err string) {
+	r.statsLock.Lock()
+	defer r.statsLock.Unlock()
+	r.stats.LastError = err
+}
+
+func (r *Reader) sendError(err error) {
+	if err == nil {
+		return
+	}
+	defer func() {
+		if rec := recover(); rec != nil {
+			log.Errorf("Reader %q was panicked and recovered from %v", r.Name(), rec)
+		}
+	}()
+	select {
+	case r.errChan <- err:
+	case <-r.stopChan:
+	}
+}
+
+func (r *Reader) Start() error {
+	if r.isStopping() || r.hasStopped() {
+		return errors.New("reader is stopping or has stopped")
+	}
+	if !atomic.CompareAndSwapInt32(&r.status, StatusInit, StatusRunning) {
+		log.Warnf("Runner[%v] %q daemon has already started and is running", r.meta.RunnerName, r.Name())
+		return nil
+	}
+
+	go r.run()
+	log.Infof("Runner[%v] %q daemon has started", r.meta.RunnerName, r.Name())
+	return nil
+}
+
+func (r *Reader) run() {
+	defer func() {
+		atomic.StoreInt32(&r.status, StatusStopped)
+		log.Infof("Runner[%v] %q daemon has stopped from running", r.meta.RunnerName, r.Name())
+	}()
+
+	for {
+		err := r.stream()
+		if r.isStopping() || r.hasStopped() {
+			return
+		}
+		err = fmt.Errorf("%v, reconnect in %v", err, reconnectInterval)
+		log.Errorf("Runner[%v] %q %v", r.meta.RunnerName, r.Name(), err)
+		r.setStatsError(err.Error())
+		r.sendError(err)
+		select {
+		case <-r.stopChan:
+			return
+		case <-time.After(reconnectInterval):
+		}
+	}
+}
+
+func (r *Reader) newDecoder() decoder {
+	if r.plugin == PGCDCPluginWal2json {
+		return &wal2jsonDecoder{}
+	}
+	return newPgoutputDecoder(r.publication)
+}
+
+// stream 连接服务端并从 sentLSN 开始复制，直到出错或者 reader 被关闭
+func (r *Reader) stream() error {
+	conn, err := dial(r.cfg)
+	if err != nil {
+		return err
+	}
+	r.connLock.Lock()
+	// Close 已经执行，不能再使用新的连接
+	if r.isStopping() || r.hasStopped() {
+		r.connLock.Unlock()
+		conn.Close()
+		return nil
+	}
+	r.conn = conn
+	r.connLock.Unlock()
+	defer func() {
+		r.connLock.Lock()
+		r.conn = nil
+		r.connLock.Unlock()
+		conn.Close()
+	}()
+
+	if r.createSlot {
+		_, err = conn.query(fmt.Sprintf("CREATE_REPLICATION_SLOT %v LOGICAL %v NOEXPORT_SNAPSHOT", quoteIdent(r.slot), r.plugin))
+		if err == nil {
+			log.Infof("Runner[%v] %q created replication slot %v", r.meta.RunnerName, r.Name(), r.slot)
+		} else if e, ok := err.(*pgError); !ok || e.code != codeDuplicateObject {
+			return fmt.Errorf("create replication slot %v error: %v", r.slot, err)
+		}
+	}
+	dec := r.newDecoder()
+	err = conn.startReplication(fmt.Sprintf("START_REPLICATION SLOT %v LOGICAL %v %v", quoteIdent(r.slot), formatLSN(r.sentLSN), dec.options()))
+	if err != nil {
+		return fmt.Errorf("start replication from %v error: %v", formatLSN(r.sentLSN), err)
+	}
+	log.Infof("Runner[%v] %q start replication from %v", r.meta.RunnerName, r.Name(), formatLSN(r.sentLSN))
+
+	done := make(chan struct{})
+	defer close(done)
+	go r.reportStatus(conn, done)
+
+	for {
+		conn.conn.SetReadDeadline(time.Now().Add(readTimeout))
+		typ, body, err := conn.readMessage()
+		if err != nil {
+			return err
+		}
+		switch typ {
+		case 'd':
+			if err = r.handleCopyData(conn, dec, body); err != nil {
+				return err
+			}
+		case 'c':
+			return errors.New("replication stream is closed by server")
+		case 'E':
+			return parseError(body)
+		case 'N':
+			log.Warnf("Runner[%v] %q notice from server: %v", r.meta.RunnerName, r.Name(), parseError(body))
+		}
+		select {
+		case <-r.stopChan:
+			return nil
+		default:
+		}
+	}
+}
+
+// handleCopyData 处理复制流中的 XLogData 和 Primary keepalive 消息
+func (r *Reader) handleCopyData(conn *pgConn, dec decoder, body []byte) error {
+	m := &msgReader{b: body}
+	switch m.byte() {
+	case 'w':
+		lsn := m.uint64()
+		m.uint64()
+		m.uint64()
+		if m.err != nil {
+			return fmt.Errorf("invalid XLogData message: %v", m.err)
+		}
+		rows, commit, err := dec.decode(lsn, m.b)
+		if err != nil {
+			// 无法解析的消息跳过，避免重新连接后反复读取
+			log.Errorf("Runner[%v] %q %v", r.meta.RunnerName, r.Name(), err)
+			r.setStatsError(err.Error())
+		}
+		for _, row := range rows {
+			if !r.send(readInfo{data: row, lsn: lsn, bytes: int64(len(m.b))}) {
+				return nil
+			}
+		}
+		if commit {
+			if !r.send(readInfo{lsn: lsn, commit: true}) {
+				return nil
+			}
+			r.sentLSN = lsn
+		}
+	case 'k':
+		m.uint64()
+		m.uint64()
+		if m.byte() == 1 {
+			return conn.sendStatus(atomic.LoadUint64(&r.confirmed))
+		}
+	}
+	return nil
+}
+
+func (r *Reader) send(info readInfo) bool {
+	select {
+	case <-r.stopChan:
+		return false
+	case r.readChan <- info:
+		return true
+	}
+}
+
+// reportStatus 定时向服务端报告已经写入 meta 的位置，同时作为心跳避免服务端因为 wal_sender_timeout 断开连接
+func (r *Reader) reportStatus(conn *pgConn, done chan struct{}) {
+	ticker := time.NewTicker(r.statusInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := conn.sendStatus(atomic.LoadUint64(&r.confirmed)); err != nil {
+				log.Warnf("Runner[%v] %q send status update error: %v", r.meta.RunnerName, r.Name(), err)
+			}
+		}
+	}
+}
+
+func (r *Reader) Source() string {
+	return r.Name()
+}
+
+func (r *Reader) ReadLine() (string, error) {
+	return "", errors.New("method ReadLine is not supported, please use ReadData")
+}
+
+func (r *Reader) ReadData() (Data, int64, error) {
+	timer := time.NewTimer(time.Second)
+	defer timer.Stop()
+	for {
+		select {
+		case info := <-r.readChan:
+			if info.commit {
+				r.lsnLock.Lock()
+				r.lsn = info.lsn
+				r.lsnLock.Unlock()
+				continue
+			}
+			return info.data, info.bytes, nil
+		case err := <-r.errChan:
+			return nil, 0, err
+		case <-timer.C:
+			return nil, 0, nil
+		}
+	}
+}
+
+func (r *Reader) Status() StatsInfo {
+	r.statsLock.RLock()
+	defer r.statsLock.RUnlock()
+	return r.stats
+}
+
+// SyncMeta 记录已经读出的事务的提交位置，之后由 reportStatus 报告给服务端
+func (r *Reader) SyncMeta() {
+	r.lsnLock.Lock()
+	lsn := r.lsn
+	r.lsnLock.Unlock()
+	if lsn == 0 || lsn == atomic.LoadUint64(&r.confirmed) {
+		return
+	}
+	if err := r.meta.WriteOffset(formatLSN(lsn), 0); err != nil {
+		log.Errorf("Runner[%v] %v SyncMeta error %v", r.meta.RunnerName, r.Name(), err)
+		return
+	}
+	atomic.StoreUint64(&r.confirmed, lsn)
+}
+
+func (r *Reader) Close() error {
+	if !atomic.CompareAndSwapInt32(&r.status, StatusRunning, StatusStopping) {
+		log.Warnf("Runner[%v] reader %q is not running, close operation ignored", r.meta.RunnerName, r.Name())
+		return nil
+	}
+	log.Debugf("Runner[%v] %q daemon is stopping", r.meta.RunnerName, r.Name())
+	close(r.stopChan)
+	r.connLock.Lock()
+	if r.conn != nil {
+		r.conn.Close()
+	}
+	r.connLock.Unlock()
+	return nil
+}
@@ -85,6 +85,8 @@ type PostgresReader struct {
 	schemas           map[string]string
 	dbSchema          string
 	magicLagDur       time.Duration
+	chunkWorkers      int            // 全量导出的并发数
+	chunkProgress     *ChunkProgress // 全量导出的分片进度
 
 	firstPrinted bool
 }
@@ -108,6 +110,7 @@ func NewPostgresReader(meta *reader.Meta, conf conf.MapConf) (reader.Reader, err
 	logpath, _ := conf.GetStringOr(KeyLogPath, "")
 	readBatch, _ = conf.GetIntOr(KeyPGsqlReadBatch, 100)
 	offsetKey, _ = conf.GetStringOr(KeyPGsqlOffsetKey, "")
+	chunkWorkers, _ := conf.GetIntOr(KeySQLChunkWorkers, 0)
 	if logpath == "" {
 		dataSource, err = conf.GetPasswordEnvString(KeyPGsqlDataSource)
 	} else {
@@ -215,11 +218,19 @@ func NewPostgresReader(meta *reader.Meta, conf conf.MapConf) (reader.Reader, err
 		magicLagDur:     mgld,
 		schemas:         schemas,
 		dbSchema:        dbSchema,
+		chunkWorkers:    chunkWorkers,
 	}
 
 	if r.rawDatabase == "" {
 		r.rawDatabase = "*"
 	}
+	if r.isChunkDump() {
+		r.chunkProgress, err = RestoreChunkProgress(r.meta.DoneFilePath)
+		if err != nil {
+			log.Errorf("Runner[%v] %v restore chunk progress error %v, omit it", r.meta.RunnerName, r.Name(), err)
+			r.chunkProgress = nil
+		}
+	}
 	if r.timestampKey != "" {
 		if r.timestampKeyInt {
 			tm, cache, err := RestoreTimestampIntOffset(r.meta.DoneFilePath)
@@ -432,6 +443,11 @@ func (r *PostgresReader) SyncMeta() {
 	}
 	r.muxOffsets.RLock()
 	defer r.muxOffsets.RUnlock()
+	if r.chunkProgress != nil {
+		if err := WriteChunkProgress(r.meta.DoneFilePath, r.chunkProgress); err != nil {
+			log.Errorf("Runner[%v] %v SyncMeta WriteChunkProgress error %v", r.meta.RunnerName, r.Name(), err)
+		}
+	}
 	for _, offset := range r.offsets {
 		encodeSQLs = append(encodeSQLs, strconv.FormatInt(offset, 10))
 	}
@@ -564,6 +580,16 @@ func (r *PostgresReader) execReadDB(curDB string, now time.Time, recordTablesDon
 	tablesLen := len(tables)
 	log.Infof("Runner[%v] %v start to work, sqls %v offsets %v", r.meta.RunnerName, r.Name(), r.syncSQLs, r.offsets)
 
+	if r.isChunkDump() {
+		if err = r.execChunkDump(db); err != nil {
+			return err
+		}
+		if r.isStopping() || r.hasStopped() {
+			log.Warnf("Runner[%v] %v stopped from running", r.meta.RunnerName, r.Name())
+			return nil
+		}
+	}
+
 	for idx, rawSql := range r.syncSQLs {
 		//分sql执行
 		var (
@@ -607,6 +633,99 @@ func (r *PostgresReader) execReadDB(curDB string, now time.Time, recordTablesDon
 	return nil
 }
 
+// 是否需要在增量读取前并发导出全表数据
+func (r *PostgresReader) isChunkDump() bool {
+	return r.chunkWorkers > 1 && r.rawSQLs != "" && r.offsetKey != "" && r.timestampKey == ""
+}
+
+// execChunkDump 首次读取时按 offset 列的范围切分分片并发导出，完成后从最大值的下一个开始增量读取
+func (r *PostgresReader) execChunkDump(db *sql.DB) error {
+	if len(r.syncSQLs) != 1 {
+		log.Warnf("Runner[%v] %v chunk dump only support single sql, got %d sqls, skip it", r.meta.RunnerName, r.Name(), len(r.syncSQLs))
+		return nil
+	}
+	rawSQL := r.syncSQLs[0]
+	r.muxOffsets.RLock()
+	progress := r.chunkProgress
+	offset := r.offsets[0]
+	r.muxOffsets.RUnlock()
+
+	if progress == nil || progress.SQL != rawSQL {
+		// 已经开始增量读取的不再进行全量导出
+		if offset > 0 {
+			return nil
+		}
+		min, max, ok, err := QueryKeyRange(db, rawSQL, r.offsetKey)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		progress = NewChunkProgress(rawSQL, min, max, r.chunkWorkers)
+		r.muxOffsets.Lock()
+		r.chunkProgress = progress
+		r.muxOffsets.Unlock()
+		log.Infof("Runner[%v] %v start chunk dump, %v range [%d, %d] split into %d chunks", r.meta.RunnerName, r.Name(), r.offsetKey, min, max, len(progress.Chunks))
+	}
+
+	if !progress.Done() {
+		trimSQL := strings.TrimSuffix(strings.TrimSpace(rawSQL), ";")
+		dumper := &ChunkDumper{
+			Progress: progress,
+			Workers:  r.chunkWorkers,
+			Step:     int64(r.readBatch),
+			BuildSQL: func(start, end int64) string {
+				return fmt.Sprintf("%s WHERE %v >= %d AND %v < %d;", trimSQL, r.offsetKey, start, r.offsetKey, end)
+			},
+			Read: func(execSQL string) error {
+				return r.execChunkSql(execSQL, db)
+			},
+			Stopped: func() bool {
+				return r.isStopping() || r.hasStopped()
+			},
+		}
+		if err := dumper.Run(); err != nil {
+			return err
+		}
+		if !progress.Done() {
+			return nil
+		}
+		log.Infof("Runner[%v] %v chunk dump finished, switch to incremental mode", r.meta.RunnerName, r.Name())
+	}
+
+	r.muxOffsets.Lock()
+	if r.offsets[0] <= progress.Max {
+		r.offsets[0] = progress.Max + 1
+	}
+	r.muxOffsets.Unlock()
+	return nil
+}
+
+// execChunkSql 执行全量导出的单个批次，会被多个 worker 并发调用
+func (r *PostgresReader) execChunkSql(execSQL string, db *sql.DB) error {
+	log.Debugf("Runner[%v] reader <%v> start to exec chunk sql <%v>", r.meta.RunnerName, r.Name(), execSQL)
+	rows, err := db.Query(execSQL)
+	if err != nil {
+		return fmt.Errorf("runner[%v] %v prepare <%v> query error %v", r.meta.RunnerName, r.Name(), execSQL, err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return fmt.Errorf("runner[%v] %v prepare <%v> columns error %v", r.meta.RunnerName, r.Name(), execSQL, err)
+	}
+	scanArgs, nochiced := GetInitScans(len(columns), rows, r.schemas, r.meta.RunnerName, r.Name())
+	alldatas, closed := r.getAllDatas(rows, scanArgs, columns, nochiced)
+	if closed {
+		return nil
+	}
+	for _, v := range alldatas {
+		r.readChan <- v
+	}
+	return rows.Err()
+}
+
 func (r *PostgresReader) getSQL(idx int, rawSQL string) string {
 	r.muxOffsets.RLock()
 	defer r.muxOffsets.RUnlock()
@@ -0,0 +1,180 @@
+package prometheus
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	MetricTypeCounter   = "counter"
+	MetricTypeGauge     = "gauge"
+	MetricTypeHistogram = "histogram"
+	MetricTypeSummary   = "summary"
+	MetricTypeUntyped   = "untyped"
+
+	labelMetricName = "__name__"
+	labelAddress    = "__address__"
+)
+
+// Sample 是 Prometheus text 格式中的一个样本
+type Sample struct {
+	Name   string
+	Type   string
+	Labels map[string]string
+	Value  float64
+	// Timestamp 为毫秒时间戳，样本中未携带时为 0
+	Timestamp int64
+}
+
+// ParseText 解析 Prometheus text 格式(0.0.4)的指标数据
+func ParseText(r io.Reader) ([]Sample, error) {
+	var (
+		samples []Sample
+		types   = make(map[string]string)
+		scanner = bufio.NewScanner(r)
+		lineNo  int
+	)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if line[0] == '#' {
+			fields := strings.Fields(line[1:])
+			if len(fields) >= 3 && fields[0] == "TYPE" {
+				types[fields[1]] = strings.ToLower(fields[2])
+			}
+			continue
+		}
+		sample, err := parseSample(line)
+		if err != nil {
+			return samples, fmt.Errorf("line %d: %v", lineNo, err)
+		}
+		sample.Type = metricType(types, sample.Name)
+		samples = append(samples, sample)
+	}
+	return samples, scanner.Err()
+}
+
+// metricType 查找样本所属的指标类型，histogram 和 summary 的样本名带有 _bucket、_sum、_count 后缀
+func metricType(types map[string]string, name string) string {
+	if typ, ok := types[name]; ok {
+		return typ
+	}
+	for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+		if !strings.HasSuffix(name, suffix) {
+			continue
+		}
+		typ, ok := types[strings.TrimSuffix(name, suffix)]
+		if ok && (typ == MetricTypeHistogram || typ == MetricTypeSummary) {
+			return typ
+		}
+	}
+	return MetricTypeUntyped
+}
+
+func parseSample(line string) (Sample, error) {
+	sample := Sample{Labels: make(map[string]string)}
+	idx := strings.IndexAny(line, "{ \t")
+	if idx <= 0 {
+		return sample, fmt.Errorf("invalid sample %q", line)
+	}
+	sample.Name = line[:idx]
+	rest := line[idx:]
+	if rest[0] == '{' {
+		n, err := parseLabels(rest[1:], sample.Labels)
+		if err != nil {
+			return sample, err
+		}
+		rest = rest[1+n:]
+	}
+
+	fields := strings.Fields(rest)
+	if len(fields) == 0 || len(fields) > 2 {
+		return sample, fmt.Errorf("invalid sample %q", line)
+	}
+	value, err := parseValue(fields[0])
+	if err != nil {
+		return sample, fmt.Errorf("invalid value %q: %v", fields[0], err)
+	}
+	sample.Value = value
+	if len(fields) == 2 {
+		sample.Timestamp, err = strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return sample, fmt.Errorf("invalid timestamp %q: %v", fields[1], err)
+		}
+	}
+	return sample, nil
+}
+
+// parseLabels 解析 label 列表直到右花括号，返回消耗的字节数(包含右花括号)
+func parseLabels(s string, labels map[string]string) (int, error) {
+	i := 0
+	for {
+		for i < len(s) && (s[i] == ' ' || s[i] == ',') {
+			i++
+		}
+		if i >= len(s) {
+			return 0, fmt.Errorf("labels are not closed")
+		}
+		if s[i] == '}' {
+			return i + 1, nil
+		}
+		eq := strings.IndexByte(s[i:], '=')
+		if eq <= 0 {
+			return 0, fmt.Errorf("invalid label in %q", s)
+		}
+		name := strings.TrimSpace(s[i : i+eq])
+		i += eq + 1
+		for i < len(s) && s[i] == ' ' {
+			i++
+		}
+		if i >= len(s) || s[i] != '"' {
+			return 0, fmt.Errorf("label %v value should be quoted", name)
+		}
+		i++
+		var value strings.Builder
+		closed := false
+		for ; i < len(s); i++ {
+			c := s[i]
+			if c == '\\' && i+1 < len(s) {
+				i++
+				switch s[i] {
+				case 'n':
+					value.WriteByte('\n')
+				default:
+					value.WriteByte(s[i])
+				}
+				continue
+			}
+			if c == '"' {
+				closed = true
+				i++
+				break
+			}
+			value.WriteByte(c)
+		}
+		if !closed {
+			return 0, fmt.Errorf("label %v value is not closed", name)
+		}
+		labels[name] = value.String()
+	}
+}
+
+func parseValue(s string) (float64, error) {
+	switch s {
+	case "+Inf", "Inf":
+		return math.Inf(1), nil
+	case "-Inf":
+		return math.Inf(-1), nil
+	case "NaN":
+		return math.NaN(), nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
@@ -0,0 +1,364 @@
+package prometheus
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/qiniu/log"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/reader"
+	. "github.com/qiniu/logkit/reader/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+var (
+	_ reader.DaemonReader = &Reader{}
+	_ reader.StatsReader  = &Reader{}
+	_ reader.DataReader   = &Reader{}
+	_ reader.Reader       = &Reader{}
+)
+
+const acceptHeader = "text/plain;version=0.0.4;q=1,*/*;q=0.1"
+
+// 数据中的保留字段，与之重名的 label 会加上 label_ 前缀
+const (
+	KeyMetricName  = "metric"
+	KeyMetricValue = "value"
+	KeyMetricType  = "metric_type"
+	KeyInstance    = "instance"
+	labelPrefix    = "label_"
+)
+
+func init() {
+	reader.RegisterConstructor(ModePrometheus, NewReader)
+}
+
+type readInfo struct {
+	data  Data
+	bytes int64
+}
+
+type Reader struct {
+	meta *reader.Meta
+	// Note: 原子操作，用于表示 reader 整体的运行状态
+	status int32
+	/*
+		Note: 原子操作，用于表示获取数据的线程运行状态
+
+		- StatusInit: 当前没有任务在执行
+		- StatusRunning: 当前有任务正在执行
+		- StatusStopping: 数据管道已经由上层关闭，执行中的任务完成时直接退出无需再处理
+	*/
+	routineStatus int32
+
+	stopChan chan struct{}
+	readChan chan readInfo
+	errChan  chan error
+
+	stats     StatsInfo
+	statsLock sync.RWMutex
+
+	urls     []string
+	interval time.Duration
+	headers  map[string]string
+	relabels []RelabelConfig
+
+	client *http.Client
+}
+
+func NewReader(meta *reader.Meta, c conf.MapConf) (reader.Reader, error) {
+	urls, err := c.GetStringList(KeyPrometheusURLs)
+	if err != nil {
+		return nil, err
+	}
+	for i, u := range urls {
+		if !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+			urls[i] = "http://" + u
+		}
+	}
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("%v should not be empty", KeyPrometheusURLs)
+	}
+	intervalStr, _ := c.GetStringOr(KeyPrometheusInterval, "30s")
+	interval, err := time.ParseDuration(intervalStr)
+	if err != nil {
+		return nil, err
+	}
+	if interval <= 0 {
+		return nil, fmt.Errorf("%v should be positive", KeyPrometheusInterval)
+	}
+	timeoutStr, _ := c.GetStringOr(KeyPrometheusTimeout, "10s")
+	timeout, err := time.ParseDuration(timeoutStr)
+	if err != nil {
+		return nil, err
+	}
+
+	var headers map[string]string
+	headerStr, _ := c.GetStringOr(KeyPrometheusHeaders, "")
+	if headerStr != "" {
+		if err = json.Unmarshal([]byte(headerStr), &headers); err != nil {
+			return nil, fmt.Errorf("parse %v error: %v", KeyPrometheusHeaders, err)
+		}
+	}
+	var relabels []RelabelConfig
+	relabelStr, _ := c.GetStringOr(KeyPrometheusRelabelConfigs, "")
+	if relabelStr != "" {
+		if err = json.Unmarshal([]byte(relabelStr), &relabels); err != nil {
+			return nil, fmt.Errorf("parse %v error: %v", KeyPrometheusRelabelConfigs, err)
+		}
+	}
+	for i := range relabels {
+		if err = relabels[i].init(); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Reader{
+		meta:          meta,
+		status:        StatusInit,
+		routineStatus: StatusInit,
+		stopChan:      make(chan struct{}),
+		readChan:      make(chan readInfo, 1000),
+		errChan:       make(chan error),
+		urls:          urls,
+		interval:      interval,
+		headers:       headers,
+		relabels:      relabels,
+		client:        &http.Client{Timeout: timeout},
+	}, nil
+}
+
+func (r *Reader) isStopping() bool {
+	return atomic.LoadInt32(&r.status) == StatusStopping
+}
+
+func (r *Reader) hasStopped() bool {
+	return atomic.LoadInt32(&r.status) == StatusStopped
+}
+
+func (r *Reader) Name() string {
+	return "prometheus:" + strings.Join(r.urls, ",")
+}
+
+func (r *Reader) SetMode(mode string, v interface{}) error {
+	return errors.New("prometheus reader does not support read mode")
+}
+
+func (r *Reader) setStatsError(err string) {
+	r.statsLock.Lock()
+	defer r.statsLock.Unlock()
+	r.stats.LastError = err
+}
+
+func (r *Reader) sendError(err error) {
+	if err == nil {
+		return
+	}
+	defer func() {
+		if rec := recover(); rec != nil {
+			log.Errorf("Reader %q was panicked and recovered from %v", r.Name(), rec)
+		}
+	}()
+	r.errChan <- err
+}
+
+func (r *Reader) Start() error {
+	if r.isStopping() || r.hasStopped() {
+		return errors.New("reader is stopping or has stopped")
+	} else if !atomic.CompareAndSwapInt32(&r.status, StatusInit, StatusRunning) {
+		log.Warnf("Runner[%v] %q daemon has already started and is running", r.meta.RunnerName, r.Name())
+		return nil
+	}
+
+	go func() {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			r.Gather()
+			select {
+			case <-r.stopChan:
+				atomic.StoreInt32(&r.status, StatusStopped)
+				log.Infof("Runner[%v] %q daemon has stopped from running", r.meta.RunnerName, r.Name())
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	log.Infof("Runner[%v] %q daemon has started", r.meta.RunnerName, r.Name())
+	return nil
+}
+
+func (r *Reader) Source() string {
+	return strings.Join(r.urls, ",")
+}
+
+func (r *Reader) ReadLine() (string, error) {
+	return "", errors.New("method ReadLine is not supported, please use ReadData")
+}
+
+func (r *Reader) ReadData() (Data, int64, error) {
+	timer := time.NewTimer(time.Second)
+	defer timer.Stop()
+	select {
+	case info := <-r.readChan:
+		return info.data, info.bytes, nil
+	case err := <-r.errChan:
+		return nil, 0, err
+	case <-timer.C:
+	}
+
+	return nil, 0, nil
+}
+
+func (r *Reader) Status() StatsInfo {
+	r.statsLock.RLock()
+	defer r.statsLock.RUnlock()
+	return r.stats
+}
+
+func (r *Reader) SyncMeta() {}
+
+func (r *Reader) Close() error {
+	if !atomic.CompareAndSwapInt32(&r.status, StatusRunning, StatusStopping) {
+		log.Warnf("Runner[%v] reader %q is not running, close operation ignored", r.meta.RunnerName, r.Name())
+		return nil
+	}
+	log.Debugf("Runner[%v] %q daemon is stopping", r.meta.RunnerName, r.Name())
+	close(r.stopChan)
+
+	// 如果此时没有 routine 正在运行，则在此处关闭数据管道，否则由 routine 在退出时负责关闭
+	if atomic.CompareAndSwapInt32(&r.routineStatus, StatusInit, StatusStopping) {
+		close(r.readChan)
+		close(r.errChan)
+	}
+	return nil
+}
+
+// Gather 依次抓取所有地址，单个地址失败不影响其他地址
+func (r *Reader) Gather() {
+	// 未在准备状态（StatusInit）时无法执行此次任务
+	if !atomic.CompareAndSwapInt32(&r.routineStatus, StatusInit, StatusRunning) {
+		if r.isStopping() || r.hasStopped() {
+			log.Warnf("Runner[%v] %q daemon has stopped, this task does not need to be executed and is skipped this time", r.meta.RunnerName, r.Name())
+		} else {
+			log.Errorf("Runner[%v] %q daemon is still working on last task, this task will not be executed and is skipped this time", r.meta.RunnerName, r.Name())
+		}
+		return
+	}
+	defer func() {
+		// 如果 reader 在 routine 运行时关闭，则需要此 routine 负责关闭数据管道
+		if r.isStopping() || r.hasStopped() {
+			if atomic.CompareAndSwapInt32(&r.routineStatus, StatusRunning, StatusStopping) {
+				close(r.readChan)
+				close(r.errChan)
+			}
+			return
+		}
+		atomic.StoreInt32(&r.routineStatus, StatusInit)
+	}()
+
+	for _, u := range r.urls {
+		if r.isStopping() || r.hasStopped() {
+			return
+		}
+		datas, err := r.scrape(u)
+		if err != nil {
+			err = fmt.Errorf("scrape %v error: %v", u, err)
+			log.Errorf("Runner[%v] %q %v", r.meta.RunnerName, r.Name(), err)
+			r.setStatsError(err.Error())
+			r.sendError(err)
+			continue
+		}
+		for _, data := range datas {
+			select {
+			case <-r.stopChan:
+				return
+			case r.readChan <- readInfo{data, int64(len(fmt.Sprintf("%v", data)))}:
+			}
+		}
+	}
+}
+
+func (r *Reader) scrape(rawurl string) ([]Data, error) {
+	req, err := http.NewRequest(http.MethodGet, rawurl, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", acceptHeader)
+	for k, v := range r.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %v", resp.Status)
+	}
+	samples, err := ParseText(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var address string
+	if u, perr := url.Parse(rawurl); perr == nil {
+		address = u.Host
+	}
+	now := time.Now()
+	datas := make([]Data, 0, len(samples))
+	for _, s := range samples {
+		// NaN 和 Inf 无法序列化为 json，直接忽略
+		if math.IsNaN(s.Value) || math.IsInf(s.Value, 0) {
+			continue
+		}
+		if data := r.convert(s, address, now); data != nil {
+			datas = append(datas, data)
+		}
+	}
+	return datas, nil
+}
+
+// convert 将样本转换为一条数据，label 作为字段，被 relabel 规则丢弃时返回 nil
+func (r *Reader) convert(s Sample, address string, now time.Time) Data {
+	labels := s.Labels
+	labels[labelMetricName] = s.Name
+	labels[labelAddress] = address
+	if _, ok := labels[KeyInstance]; !ok {
+		labels[KeyInstance] = address
+	}
+	if !relabel(labels, r.relabels) {
+		return nil
+	}
+
+	ts := now
+	if s.Timestamp > 0 {
+		ts = time.Unix(0, s.Timestamp*int64(time.Millisecond))
+	}
+	data := Data{
+		KeyMetricName:  labels[labelMetricName],
+		KeyMetricValue: s.Value,
+		KeyMetricType:  s.Type,
+		KeyTimestamp:   ts.Format(time.RFC3339Nano),
+	}
+	for k, v := range labels {
+		// 双下划线开头的为内部 label，不输出
+		if strings.HasPrefix(k, "__") {
+			continue
+		}
+		if _, ok := data[k]; ok {
+			k = labelPrefix + k
+		}
+		data[k] = v
+	}
+	return data
+}
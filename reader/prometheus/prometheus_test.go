@@ -0,0 +1,136 @@
+package prometheus
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/reader"
+	. "github.com/qiniu/logkit/reader/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const testMetrics = `# HELP http_requests_total The total number of HTTP requests.
+# TYPE http_requests_total counter
+http_requests_total{method="post",code="200"} 1027 1395066363000
+http_requests_total{method="post",code="400"}    3 1395066363000
+
+# TYPE rpc_duration_seconds summary
+rpc_duration_seconds{quantile="0.5"} NaN
+rpc_duration_seconds_sum 1.7560473e+07
+rpc_duration_seconds_count 2693
+# TYPE http_request_duration_seconds histogram
+http_request_duration_seconds_bucket{le="+Inf"} 144320
+go_goroutines 12
+msdos_file_access_time_seconds{path="C:\\DIR\\FILE.TXT",error="Cannot find file:\n\"FILE.TXT\"",value="x"} 1.458255915e9
+`
+
+func TestParseText(t *testing.T) {
+	samples, err := ParseText(strings.NewReader(testMetrics))
+	assert.NoError(t, err)
+	assert.Len(t, samples, 8)
+
+	assert.Equal(t, "http_requests_total", samples[0].Name)
+	assert.Equal(t, MetricTypeCounter, samples[0].Type)
+	assert.Equal(t, map[string]string{"method": "post", "code": "200"}, samples[0].Labels)
+	assert.Equal(t, float64(1027), samples[0].Value)
+	assert.Equal(t, int64(1395066363000), samples[0].Timestamp)
+	assert.True(t, math.IsNaN(samples[2].Value))
+	assert.Equal(t, MetricTypeSummary, samples[3].Type)
+	assert.Equal(t, MetricTypeSummary, samples[4].Type)
+	assert.Equal(t, MetricTypeHistogram, samples[5].Type)
+	assert.Equal(t, "+Inf", samples[5].Labels["le"])
+	assert.Equal(t, MetricTypeUntyped, samples[6].Type)
+	assert.Equal(t, `C:\DIR\FILE.TXT`, samples[7].Labels["path"])
+	assert.Equal(t, "Cannot find file:\n\"FILE.TXT\"", samples[7].Labels["error"])
+
+	_, err = ParseText(strings.NewReader(`foo{a="b" 1`))
+	assert.Error(t, err)
+	_, err = ParseText(strings.NewReader(`foo abc`))
+	assert.Error(t, err)
+}
+
+func TestRelabel(t *testing.T) {
+	configs := []RelabelConfig{
+		{SourceLabels: []string{"__name__"}, Regex: "go_.*", Action: "drop"},
+		{SourceLabels: []string{"method", "code"}, Regex: "(.*);(.*)", TargetLabel: "route", Replacement: "${1}_${2}"},
+		{Regex: "code", Action: "labeldrop"},
+	}
+	for i := range configs {
+		assert.NoError(t, configs[i].init())
+	}
+	labels := map[string]string{"__name__": "http_requests_total", "method": "post", "code": "200"}
+	assert.True(t, relabel(labels, configs))
+	assert.Equal(t, map[string]string{"__name__": "http_requests_total", "method": "post", "route": "post_200"}, labels)
+	assert.False(t, relabel(map[string]string{"__name__": "go_goroutines"}, configs))
+
+	keep := []RelabelConfig{{SourceLabels: []string{"__name__"}, Regex: "http_.*", Action: "keep"}}
+	assert.NoError(t, keep[0].init())
+	assert.False(t, relabel(map[string]string{"__name__": "go_goroutines"}, keep))
+
+	bad := RelabelConfig{Action: "unknown"}
+	assert.Error(t, bad.init())
+	bad = RelabelConfig{Action: "replace"}
+	assert.Error(t, bad.init())
+}
+
+func TestPrometheusReader(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, testMetrics)
+	}))
+	defer server.Close()
+
+	meta, err := reader.NewMetaWithConf(conf.MapConf{
+		KeyMetaPath:   "./TestPrometheusReader",
+		KeyLogPath:    "TestPrometheusReader",
+		KeyMode:       ModePrometheus,
+		KeyRunnerName: "TestPrometheusReader",
+	})
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, meta.Delete())
+	}()
+
+	r, err := NewReader(meta, conf.MapConf{
+		KeyPrometheusURLs:           server.URL,
+		KeyPrometheusInterval:       "1h",
+		KeyPrometheusRelabelConfigs: `[{"source_labels":["__name__"],"regex":"go_.*|rpc_.*|http_request_duration_.*","action":"drop"}]`,
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, r.(reader.DaemonReader).Start())
+	defer r.Close()
+
+	dr := r.(reader.DataReader)
+	var datas []Data
+	for i := 0; i < 10 && len(datas) < 3; i++ {
+		data, _, err := dr.ReadData()
+		assert.NoError(t, err)
+		if data != nil {
+			datas = append(datas, data)
+		}
+	}
+	assert.Len(t, datas, 3)
+	instance := strings.TrimPrefix(server.URL, "http://")
+	assert.Equal(t, Data{
+		KeyMetricName:  "http_requests_total",
+		KeyMetricValue: float64(1027),
+		KeyMetricType:  MetricTypeCounter,
+		KeyTimestamp:   time.Unix(1395066363, 0).Format(time.RFC3339Nano),
+		"method":       "post",
+		"code":         "200",
+		KeyInstance:    instance,
+	}, datas[0])
+	// 与保留字段重名的 label 加上前缀
+	assert.Equal(t, "x", datas[2][labelPrefix+KeyMetricValue])
+	assert.Equal(t, 1.458255915e9, datas[2][KeyMetricValue])
+
+	_, err = NewReader(meta, conf.MapConf{KeyPrometheusURLs: server.URL, KeyPrometheusRelabelConfigs: `[{"action":"replace"}]`})
+	assert.Error(t, err)
+}
@@ -0,0 +1,123 @@
+package prometheus
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	RelabelReplace   = "replace"
+	RelabelKeep      = "keep"
+	RelabelDrop      = "drop"
+	RelabelLabelMap  = "labelmap"
+	RelabelLabelDrop = "labeldrop"
+	RelabelLabelKeep = "labelkeep"
+)
+
+// RelabelConfig 与 Prometheus 的 relabel_config 含义一致
+type RelabelConfig struct {
+	SourceLabels []string `json:"source_labels"`
+	Separator    string   `json:"separator"`
+	Regex        string   `json:"regex"`
+	TargetLabel  string   `json:"target_label"`
+	Replacement  string   `json:"replacement"`
+	Action       string   `json:"action"`
+
+	regex *regexp.Regexp
+}
+
+func (c *RelabelConfig) init() error {
+	if c.Action == "" {
+		c.Action = RelabelReplace
+	}
+	c.Action = strings.ToLower(c.Action)
+	if c.Separator == "" {
+		c.Separator = ";"
+	}
+	if c.Regex == "" {
+		c.Regex = "(.*)"
+	}
+	if c.Replacement == "" {
+		c.Replacement = "$1"
+	}
+	var err error
+	// 与 Prometheus 一致，正则需要完整匹配
+	c.regex, err = regexp.Compile("^(?:" + c.Regex + ")$")
+	if err != nil {
+		return fmt.Errorf("relabel regex %q is invalid: %v", c.Regex, err)
+	}
+
+	switch c.Action {
+	case RelabelReplace:
+		if c.TargetLabel == "" {
+			return fmt.Errorf("relabel action %v requires target_label", c.Action)
+		}
+	case RelabelKeep, RelabelDrop:
+		if len(c.SourceLabels) == 0 {
+			return fmt.Errorf("relabel action %v requires source_labels", c.Action)
+		}
+	case RelabelLabelMap, RelabelLabelDrop, RelabelLabelKeep:
+	default:
+		return fmt.Errorf("relabel action %q is not supported", c.Action)
+	}
+	return nil
+}
+
+// relabel 依次执行 relabel 规则，返回 false 表示该样本被丢弃
+func relabel(labels map[string]string, configs []RelabelConfig) bool {
+	for i := range configs {
+		c := &configs[i]
+		values := make([]string, len(c.SourceLabels))
+		for j, name := range c.SourceLabels {
+			values[j] = labels[name]
+		}
+		value := strings.Join(values, c.Separator)
+
+		switch c.Action {
+		case RelabelKeep:
+			if !c.regex.MatchString(value) {
+				return false
+			}
+		case RelabelDrop:
+			if c.regex.MatchString(value) {
+				return false
+			}
+		case RelabelReplace:
+			match := c.regex.FindStringSubmatchIndex(value)
+			if match == nil {
+				continue
+			}
+			target := string(c.regex.ExpandString(nil, c.TargetLabel, value, match))
+			res := string(c.regex.ExpandString(nil, c.Replacement, value, match))
+			if res == "" {
+				delete(labels, target)
+				continue
+			}
+			labels[target] = res
+		case RelabelLabelMap:
+			mapped := make(map[string]string)
+			for name, v := range labels {
+				if c.regex.MatchString(name) {
+					mapped[c.regex.ReplaceAllString(name, c.Replacement)] = v
+				}
+			}
+			for name, v := range mapped {
+				labels[name] = v
+			}
+		case RelabelLabelDrop:
+			for name := range labels {
+				if c.regex.MatchString(name) {
+					delete(labels, name)
+				}
+			}
+		case RelabelLabelKeep:
+			for name := range labels {
+				if !c.regex.MatchString(name) {
+					delete(labels, name)
+				}
+			}
+		}
+	}
+	return true
+}
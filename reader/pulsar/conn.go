@@ -0,0 +1,214 @@
+package pulsar
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	dialTimeout    = 10 * time.Second
+	requestTimeout = 30 * time.Second
+	// readTimeout broker 默认每 30 秒发送一次 PING，超过该时间没有收到任何数据时认为连接已经断开
+	readTimeout = 90 * time.Second
+)
+
+type dialOptions struct {
+	token     string
+	tlsConfig *tls.Config
+	// tlsAuth 配置了客户端证书并且没有 token 时使用 TLS 认证
+	tlsAuth bool
+}
+
+type pulsarConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+
+	// writeLock 写入可能来自消费消息的协程和 SyncMeta 所在的协程
+	writeLock sync.Mutex
+	requestID uint64
+}
+
+// parseServiceURL 解析 pulsar://host:port 或者 pulsar+ssl://host:port 形式的地址，默认端口分别为 6650 和 6651
+func parseServiceURL(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	port := ""
+	switch u.Scheme {
+	case "pulsar":
+		port = "6650"
+	case "pulsar+ssl":
+		port = "6651"
+	default:
+		return nil, fmt.Errorf("scheme %q is not supported, only pulsar and pulsar+ssl", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("host is empty in %q", s)
+	}
+	if u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), port)
+	}
+	return u, nil
+}
+
+// dial 连接 u 并完成 CONNECT 握手，proxyToBroker 不为空时 u 为 proxy，由 proxy 转发到 broker
+func dial(u *url.URL, opts *dialOptions, proxyToBroker string) (*pulsarConn, error) {
+	conn, err := net.DialTimeout("tcp", u.Host, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "pulsar+ssl" {
+		cfg := opts.tlsConfig.Clone()
+		if cfg.ServerName == "" {
+			cfg.ServerName = u.Hostname()
+		}
+		tc := tls.Client(conn, cfg)
+		tc.SetDeadline(time.Now().Add(dialTimeout))
+		if err = tc.Handshake(); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("tls handshake error: %v", err)
+		}
+		tc.SetDeadline(time.Time{})
+		conn = tc
+	}
+	c := &pulsarConn{conn: conn, br: bufio.NewReader(conn)}
+	if err = c.write(connectCommand(opts.token, opts.tlsAuth, proxyToBroker)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if _, err = c.wait(cmdConnected, 0); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("connect to %v error: %v", u.Host, err)
+	}
+	return c, nil
+}
+
+func (c *pulsarConn) write(frame []byte) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(requestTimeout))
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+func (c *pulsarConn) nextRequestID() uint64 {
+	c.requestID++
+	return c.requestID
+}
+
+// read 读取下一个命令，自动回复 PING
+func (c *pulsarConn) read(timeout time.Duration) (*command, error) {
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(timeout))
+		cmd, err := readCommand(c.br)
+		if err != nil {
+			return nil, err
+		}
+		switch cmd.typ {
+		case cmdPing:
+			if err = c.write(emptyCommand(cmdPong)); err != nil {
+				return nil, err
+			}
+		case cmdPong:
+		default:
+			return cmd, nil
+		}
+	}
+}
+
+// wait 等待类型为 typ 的响应，requestID 不为 0 时还需要请求编号相同，收到 ERROR 时返回错误。
+// 只在订阅之前使用，此时服务端不会推送消息
+func (c *pulsarConn) wait(typ int, requestID uint64) (*command, error) {
+	for {
+		cmd, err := c.read(requestTimeout)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case cmd.typ == cmdError:
+			return nil, serverError(cmd.fields, 2, 3)
+		case cmd.typ != typ:
+			continue
+		case requestID != 0 && cmd.fields.uint(requestFieldOf(typ), 0) != requestID:
+			continue
+		}
+		return cmd, nil
+	}
+}
+
+// requestFieldOf 返回响应中 request_id 的字段编号
+func requestFieldOf(typ int) int {
+	switch typ {
+	case cmdPartitionedMetadataResponse:
+		return 2
+	case cmdLookupResponse:
+		return 4
+	}
+	return 1
+}
+
+// partitions 查询 topic 的分区数，非分区的 topic 返回 0
+func (c *pulsarConn) partitions(topic string) (int, error) {
+	id := c.nextRequestID()
+	if err := c.write(partitionedMetadataCommand(topic, id)); err != nil {
+		return 0, err
+	}
+	cmd, err := c.wait(cmdPartitionedMetadataResponse, id)
+	if err != nil {
+		return 0, err
+	}
+	// response 为 Failed
+	if cmd.fields.uint(3, 0) == 1 {
+		return 0, serverError(cmd.fields, 4, 5)
+	}
+	return int(cmd.fields.uint(1, 0)), nil
+}
+
+type lookupResult struct {
+	brokerURL     string
+	authoritative bool
+	redirect      bool
+	proxyThrough  bool
+}
+
+// lookup 查询 topic 所在的 broker
+func (c *pulsarConn) lookup(topic string, authoritative, useTLS bool) (*lookupResult, error) {
+	id := c.nextRequestID()
+	if err := c.write(lookupCommand(topic, id, authoritative)); err != nil {
+		return nil, err
+	}
+	cmd, err := c.wait(cmdLookupResponse, id)
+	if err != nil {
+		return nil, err
+	}
+	f := cmd.fields
+	res := &lookupResult{
+		brokerURL:     f.string(1),
+		authoritative: f.uint(5, 0) == 1,
+		proxyThrough:  f.uint(8, 0) == 1,
+	}
+	if useTLS {
+		res.brokerURL = f.string(2)
+	}
+	switch f.uint(3, lookupFailed) {
+	case lookupRedirect:
+		res.redirect = true
+	case lookupConnect:
+	default:
+		return nil, serverError(f, 6, 7)
+	}
+	if res.brokerURL == "" {
+		return nil, fmt.Errorf("no broker url of %v in lookup response", topic)
+	}
+	return res, nil
+}
+
+func (c *pulsarConn) Close() error {
+	return c.conn.Close()
+}
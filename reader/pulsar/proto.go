@@ -0,0 +1,408 @@
+package pulsar
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/pierrec/lz4"
+)
+
+// Pulsar 二进制协议见 https://pulsar.apache.org/docs/developing-binary-protocol/，
+// 命令为 PulsarApi.proto 中的 BaseCommand，这里只编码和解析 reader 需要的命令和字段
+
+// BaseCommand 中的命令类型，同时也是对应命令在 BaseCommand 中的字段编号
+const (
+	cmdConnect                     = 2
+	cmdConnected                   = 3
+	cmdSubscribe                   = 4
+	cmdMessage                     = 9
+	cmdAck                         = 10
+	cmdFlow                        = 11
+	cmdSuccess                     = 13
+	cmdError                       = 14
+	cmdCloseConsumer               = 16
+	cmdPing                        = 18
+	cmdPong                        = 19
+	cmdPartitionedMetadata         = 21
+	cmdPartitionedMetadataResponse = 22
+	cmdLookup                      = 23
+	cmdLookupResponse              = 24
+)
+
+// CommandSubscribe.SubType
+const (
+	subTypeExclusive = 0
+	subTypeShared    = 1
+	subTypeFailover  = 2
+)
+
+// CommandAck.AckType
+const (
+	ackIndividual = 0
+	ackCumulative = 1
+)
+
+// CommandLookupTopicResponse.LookupType
+const (
+	lookupRedirect = 0
+	lookupConnect  = 1
+	lookupFailed   = 2
+)
+
+// MessageMetadata.compression
+const (
+	compressionNone   = 0
+	compressionLZ4    = 1
+	compressionZLIB   = 2
+	compressionZSTD   = 3
+	compressionSnappy = 4
+)
+
+const (
+	protocolVersion = 10
+	clientVersion   = "logkit"
+	// maxFrameSize 单个帧的最大长度，broker 默认的最大消息为 5MB
+	maxFrameSize = 64 * 1024 * 1024
+)
+
+var (
+	magicCrc32c    = []byte{0x0e, 0x01}
+	crc32cTable    = crc32.MakeTable(crc32.Castagnoli)
+	errNotProtobuf = errors.New("invalid protobuf message")
+)
+
+// pbBuilder 按 protobuf 的编码依次追加字段
+type pbBuilder []byte
+
+func (b *pbBuilder) varint(field int, v uint64) {
+	*b = append(*b, proto.EncodeVarint(uint64(field)<<3|proto.WireVarint)...)
+	*b = append(*b, proto.EncodeVarint(v)...)
+}
+
+func (b *pbBuilder) bytes(field int, v []byte) {
+	*b = append(*b, proto.EncodeVarint(uint64(field)<<3|proto.WireBytes)...)
+	*b = append(*b, proto.EncodeVarint(uint64(len(v)))...)
+	*b = append(*b, v...)
+}
+
+func (b *pbBuilder) string(field int, v string) {
+	b.bytes(field, []byte(v))
+}
+
+// pbFields 解析后的 protobuf 消息，varint 与 fixed 类型的字段保存在 ints 中，length-delimited 的字段保存在 raw 中
+type pbFields struct {
+	ints map[int][]uint64
+	raw  map[int][][]byte
+}
+
+func parseFields(b []byte) (*pbFields, error) {
+	f := &pbFields{ints: make(map[int][]uint64), raw: make(map[int][][]byte)}
+	for len(b) > 0 {
+		key, n := proto.DecodeVarint(b)
+		if n == 0 {
+			return nil, errNotProtobuf
+		}
+		b = b[n:]
+		field := int(key >> 3)
+		switch key & 7 {
+		case proto.WireVarint:
+			v, n := proto.DecodeVarint(b)
+			if n == 0 {
+				return nil, errNotProtobuf
+			}
+			f.ints[field] = append(f.ints[field], v)
+			b = b[n:]
+		case proto.WireFixed64:
+			if len(b) < 8 {
+				return nil, errNotProtobuf
+			}
+			f.ints[field] = append(f.ints[field], binary.LittleEndian.Uint64(b))
+			b = b[8:]
+		case proto.WireFixed32:
+			if len(b) < 4 {
+				return nil, errNotProtobuf
+			}
+			f.ints[field] = append(f.ints[field], uint64(binary.LittleEndian.Uint32(b)))
+			b = b[4:]
+		case proto.WireBytes:
+			l, n := proto.DecodeVarint(b)
+			if n == 0 || l > uint64(len(b)-n) {
+				return nil, errNotProtobuf
+			}
+			f.raw[field] = append(f.raw[field], b[n:n+int(l)])
+			b = b[n+int(l):]
+		default:
+			return nil, errNotProtobuf
+		}
+	}
+	return f, nil
+}
+
+func (f *pbFields) has(field int) bool {
+	return len(f.ints[field]) > 0 || len(f.raw[field]) > 0
+}
+
+// uint 返回字段的值，repeated 字段返回最后一个值，与 protobuf 的合并规则相同
+func (f *pbFields) uint(field int, def uint64) uint64 {
+	if v := f.ints[field]; len(v) > 0 {
+		return v[len(v)-1]
+	}
+	return def
+}
+
+func (f *pbFields) string(field int) string {
+	if v := f.raw[field]; len(v) > 0 {
+		return string(v[len(v)-1])
+	}
+	return ""
+}
+
+func (f *pbFields) message(field int) (*pbFields, error) {
+	v := f.raw[field]
+	if len(v) == 0 {
+		return parseFields(nil)
+	}
+	return parseFields(v[len(v)-1])
+}
+
+// messageID 对应 MessageIdData，只使用 ledgerId 和 entryId，批量消息以整个 entry 为单位确认
+type messageID struct {
+	ledger uint64
+	entry  uint64
+}
+
+func (id messageID) marshal() []byte {
+	var b pbBuilder
+	b.varint(1, id.ledger)
+	b.varint(2, id.entry)
+	return b
+}
+
+func (id messageID) String() string {
+	return fmt.Sprintf("%d:%d", id.ledger, id.entry)
+}
+
+// command 服务端发来的命令，payload 为 MESSAGE 命令之后的消息元数据和内容
+type command struct {
+	typ     int
+	fields  *pbFields
+	payload []byte
+}
+
+// encodeCommand 将命令包装为 BaseCommand 并加上帧的头部：总长度、命令长度
+func encodeCommand(typ int, cmd []byte) []byte {
+	var base pbBuilder
+	base.varint(1, uint64(typ))
+	base.bytes(typ, cmd)
+	frame := make([]byte, 8, 8+len(base))
+	binary.BigEndian.PutUint32(frame, uint32(4+len(base)))
+	binary.BigEndian.PutUint32(frame[4:], uint32(len(base)))
+	return append(frame, base...)
+}
+
+// readCommand 读取一个帧并解析其中的 BaseCommand
+func readCommand(r io.Reader) (*command, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	total := binary.BigEndian.Uint32(header[:])
+	if total < 4 || total > maxFrameSize {
+		return nil, fmt.Errorf("invalid frame size %d", total)
+	}
+	frame := make([]byte, total)
+	if _, err := io.ReadFull(r, frame); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(frame)
+	if uint64(size) > uint64(total-4) {
+		return nil, fmt.Errorf("invalid command size %d in frame of %d bytes", size, total)
+	}
+	base, err := parseFields(frame[4 : 4+size])
+	if err != nil {
+		return nil, err
+	}
+	cmd := &command{typ: int(base.uint(1, 0)), payload: frame[4+size:]}
+	if cmd.fields, err = base.message(cmd.typ); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
+// decodeMessages 解析 MESSAGE 命令之后的内容：可选的 magic 与 crc32c 校验值、元数据长度、MessageMetadata 以及消息内容，
+// 批量消息的每一条之前有 SingleMessageMetadata，被压缩整理(compacted_out)和值为空的消息会被跳过
+func decodeMessages(payload []byte) ([][]byte, int, error) {
+	if bytes.HasPrefix(payload, magicCrc32c) {
+		if len(payload) < 6 {
+			return nil, 0, errors.New("message checksum is truncated")
+		}
+		checksum := binary.BigEndian.Uint32(payload[2:])
+		payload = payload[6:]
+		if crc32.Checksum(payload, crc32cTable) != checksum {
+			return nil, 0, errors.New("message checksum mismatch")
+		}
+	}
+	if len(payload) < 4 {
+		return nil, 0, errors.New("message metadata is truncated")
+	}
+	size := binary.BigEndian.Uint32(payload)
+	if uint64(size) > uint64(len(payload)-4) {
+		return nil, 0, fmt.Errorf("invalid message metadata size %d", size)
+	}
+	meta, err := parseFields(payload[4 : 4+size])
+	if err != nil {
+		return nil, 0, err
+	}
+	data, err := decompress(int(meta.uint(8, compressionNone)), int(meta.uint(9, 0)), payload[4+size:])
+	if err != nil {
+		return nil, 0, err
+	}
+	if !meta.has(11) {
+		return [][]byte{data}, 1, nil
+	}
+
+	num := int(meta.uint(11, 1))
+	messages := make([][]byte, 0, num)
+	for i := 0; i < num; i++ {
+		if len(data) < 4 {
+			return nil, 0, fmt.Errorf("batch message %d is truncated", i)
+		}
+		size := binary.BigEndian.Uint32(data)
+		if uint64(size) > uint64(len(data)-4) {
+			return nil, 0, fmt.Errorf("invalid single message metadata size %d", size)
+		}
+		single, err := parseFields(data[4 : 4+size])
+		if err != nil {
+			return nil, 0, err
+		}
+		data = data[4+size:]
+		payloadSize := single.uint(3, 0)
+		if payloadSize > uint64(len(data)) {
+			return nil, 0, fmt.Errorf("invalid payload size %d of batch message %d", payloadSize, i)
+		}
+		if single.uint(4, 0) == 0 && single.uint(9, 0) == 0 {
+			messages = append(messages, data[:payloadSize])
+		}
+		data = data[payloadSize:]
+	}
+	return messages, num, nil
+}
+
+func decompress(compression, size int, data []byte) ([]byte, error) {
+	switch compression {
+	case compressionNone:
+		return data, nil
+	case compressionLZ4:
+		if size <= 0 || size > maxFrameSize {
+			return nil, fmt.Errorf("invalid uncompressed size %d", size)
+		}
+		dst := make([]byte, size)
+		n, err := lz4.UncompressBlock(data, dst, 0)
+		if err != nil {
+			return nil, fmt.Errorf("lz4 decompress error: %v", err)
+		}
+		return dst[:n], nil
+	case compressionZLIB:
+		zr, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("zlib decompress error: %v", err)
+		}
+		defer zr.Close()
+		return ioutil.ReadAll(io.LimitReader(zr, maxFrameSize))
+	case compressionSnappy:
+		return snappy.Decode(nil, data)
+	case compressionZSTD:
+		return nil, errors.New("zstd compression is not supported, please use lz4, zlib or snappy in producers")
+	}
+	return nil, fmt.Errorf("unknown compression type %d", compression)
+}
+
+func connectCommand(token string, tlsAuth bool, proxyToBroker string) []byte {
+	var b pbBuilder
+	b.string(1, clientVersion)
+	b.varint(4, protocolVersion)
+	switch {
+	case token != "":
+		b.bytes(3, []byte(token))
+		b.string(5, "token")
+	case tlsAuth:
+		b.string(5, "tls")
+	}
+	if proxyToBroker != "" {
+		b.string(6, proxyToBroker)
+	}
+	return encodeCommand(cmdConnect, b)
+}
+
+func partitionedMetadataCommand(topic string, requestID uint64) []byte {
+	var b pbBuilder
+	b.string(1, topic)
+	b.varint(2, requestID)
+	return encodeCommand(cmdPartitionedMetadata, b)
+}
+
+func lookupCommand(topic string, requestID uint64, authoritative bool) []byte {
+	var b pbBuilder
+	b.string(1, topic)
+	b.varint(2, requestID)
+	if authoritative {
+		b.varint(3, 1)
+	}
+	return encodeCommand(cmdLookup, b)
+}
+
+type subscribeOptions struct {
+	topic           string
+	subscription    string
+	subType         int
+	consumerID      uint64
+	requestID       uint64
+	consumerName    string
+	initialPosition int
+}
+
+func subscribeCommand(o subscribeOptions) []byte {
+	var b pbBuilder
+	b.string(1, o.topic)
+	b.string(2, o.subscription)
+	b.varint(3, uint64(o.subType))
+	b.varint(4, o.consumerID)
+	b.varint(5, o.requestID)
+	b.string(6, o.consumerName)
+	b.varint(13, uint64(o.initialPosition))
+	return encodeCommand(cmdSubscribe, b)
+}
+
+func flowCommand(consumerID uint64, permits int) []byte {
+	var b pbBuilder
+	b.varint(1, consumerID)
+	b.varint(2, uint64(permits))
+	return encodeCommand(cmdFlow, b)
+}
+
+func ackCommand(consumerID uint64, ackType int, ids []messageID) []byte {
+	var b pbBuilder
+	b.varint(1, consumerID)
+	b.varint(2, uint64(ackType))
+	for _, id := range ids {
+		b.bytes(3, id.marshal())
+	}
+	return encodeCommand(cmdAck, b)
+}
+
+func emptyCommand(typ int) []byte {
+	return encodeCommand(typ, nil)
+}
+
+// serverError 解析 CommandError 以及 lookup 等响应中的错误信息
+func serverError(f *pbFields, errorField, messageField int) error {
+	return fmt.Errorf("server error %d: %s", f.uint(errorField, 0), f.string(messageField))
+}
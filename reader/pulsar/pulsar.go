@@ -0,0 +1,554 @@
+package pulsar
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/qiniu/log"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/reader"
+	. "github.com/qiniu/logkit/reader/config"
+	. "github.com/qiniu/logkit/utils/models"
+	"github.com/qiniu/logkit/utils/tlspolicy"
+)
+
+var (
+	_ reader.DaemonReader = &Reader{}
+	_ reader.StatsReader  = &Reader{}
+	_ reader.Reader       = &Reader{}
+)
+
+const (
+	reconnectWait      = 2 * time.Second
+	maxLookupRedirects = 10
+)
+
+func init() {
+	reader.RegisterConstructor(ModePulsar, NewReader)
+}
+
+// message 读出的一条消息，last 表示是 entry 中的最后一条，读出后整个 entry 可以被确认，
+// marker 为 true 时没有数据，用于确认没有可读消息的 entry
+type message struct {
+	c      *consumer
+	id     messageID
+	data   []byte
+	last   bool
+	marker bool
+}
+
+// Reader 以 shared 或 failover 订阅消费 Pulsar topic 中的消息，每条消息为一行数据。分区 topic 的每个分区各使用一个连接，
+// 消费位置由 broker 上的订阅记录，消息在 runner 发送成功(SyncMeta)后才确认：failover 和 exclusive 订阅使用累积确认，
+// shared 订阅不支持累积确认，逐条确认
+type Reader struct {
+	meta *reader.Meta
+	// Note: 原子操作，用于表示 reader 整体的运行状态
+	status int32
+
+	serviceURL      *url.URL
+	opts            *dialOptions
+	topics          []string
+	subscription    string
+	subType         int
+	initialPosition int
+	queueSize       int
+	consumerName    string
+
+	stopChan chan struct{}
+	readChan chan *message
+	wg       sync.WaitGroup
+
+	consumers     []*consumer
+	consumersLock sync.Mutex
+	nextID        uint64
+
+	// currentSource 最近一次 ReadLine 读出的消息的 topic，只在 ReadLine 所在的协程中访问
+	currentSource string
+	ackLock       sync.Mutex
+
+	stats     StatsInfo
+	statsLock sync.RWMutex
+}
+
+// consumer 消费一个非分区 topic 或者分区 topic 的一个分区
+type consumer struct {
+	r     *Reader
+	topic string
+	id    uint64
+
+	conn     *pulsarConn
+	connLock sync.Mutex
+
+	// done 已经全部读出、等待确认的 entry，按照读出的顺序排列，由 Reader.ackLock 保护
+	done []messageID
+}
+
+func NewReader(meta *reader.Meta, c conf.MapConf) (reader.Reader, error) {
+	serviceURL, _ := c.GetStringOr(KeyPulsarServiceURL, DefaultPulsarServiceURL)
+	topics, _ := c.GetStringListOr(KeyPulsarTopics, []string{})
+	subscription, _ := c.GetStringOr(KeyPulsarSubscription, DefaultPulsarSubscription)
+	subTypeStr, _ := c.GetStringOr(KeyPulsarSubscriptionType, PulsarSubscriptionFailover)
+	position, _ := c.GetStringOr(KeyPulsarInitialPosition, PulsarPositionLatest)
+	queueSize, _ := c.GetIntOr(KeyPulsarReceiverQueueSize, DefaultPulsarReceiverQueueSize)
+	token, _ := c.GetPasswordEnvStringOr(KeyPulsarToken, "")
+	caFile, _ := c.GetStringOr(KeyPulsarTLSCA, "")
+	certFile, _ := c.GetStringOr(KeyPulsarTLSCert, "")
+	keyFile, _ := c.GetStringOr(KeyPulsarTLSKey, "")
+
+	u, err := parseServiceURL(serviceURL)
+	if err != nil {
+		return nil, fmt.Errorf("%v %q is invalid: %v", KeyPulsarServiceURL, serviceURL, err)
+	}
+	if len(topics) == 0 {
+		return nil, fmt.Errorf("%v can not be empty", KeyPulsarTopics)
+	}
+	for i, topic := range topics {
+		topics[i] = fullTopicName(topic)
+	}
+	if subscription == "" {
+		return nil, fmt.Errorf("%v can not be empty", KeyPulsarSubscription)
+	}
+	r := &Reader{
+		meta:         meta,
+		status:       StatusInit,
+		serviceURL:   u,
+		topics:       topics,
+		subscription: subscription,
+		queueSize:    queueSize,
+		stopChan:     make(chan struct{}),
+	}
+	switch subTypeStr {
+	case PulsarSubscriptionShared:
+		r.subType = subTypeShared
+	case PulsarSubscriptionFailover:
+		r.subType = subTypeFailover
+	case PulsarSubscriptionExclusive:
+		r.subType = subTypeExclusive
+	default:
+		return nil, fmt.Errorf("%v %q is invalid, should be %v, %v or %v", KeyPulsarSubscriptionType, subTypeStr,
+			PulsarSubscriptionShared, PulsarSubscriptionFailover, PulsarSubscriptionExclusive)
+	}
+	switch position {
+	case PulsarPositionLatest:
+	case PulsarPositionEarliest:
+		r.initialPosition = 1
+	default:
+		return nil, fmt.Errorf("%v %q is invalid, should be %v or %v", KeyPulsarInitialPosition, position, PulsarPositionLatest, PulsarPositionEarliest)
+	}
+	if queueSize <= 0 {
+		return nil, fmt.Errorf("%v should be positive", KeyPulsarReceiverQueueSize)
+	}
+	r.readChan = make(chan *message, queueSize)
+
+	cfg := &tls.Config{}
+	if caFile != "" {
+		ca, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("read %v error: %v", KeyPulsarTLSCA, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificate found in %v %v", KeyPulsarTLSCA, caFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if (certFile == "") != (keyFile == "") {
+		return nil, fmt.Errorf("%v and %v should be set together", KeyPulsarTLSCert, KeyPulsarTLSKey)
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load %v and %v error: %v", KeyPulsarTLSCert, KeyPulsarTLSKey, err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	r.opts = &dialOptions{token: token, tlsConfig: tlspolicy.Apply(cfg), tlsAuth: certFile != "" && token == ""}
+
+	// failover 订阅按照消费者名称选择活跃的消费者，名称中包含主机名以便区分
+	hostname, _ := os.Hostname()
+	r.consumerName = "logkit"
+	if hostname != "" {
+		r.consumerName += "_" + hostname
+	}
+	if meta != nil {
+		r.consumerName += "_" + meta.RunnerName
+	}
+	return r, nil
+}
+
+// fullTopicName 将 my-topic、tenant/namespace/my-topic 形式的名称补全为 persistent://public/default/my-topic 形式
+func fullTopicName(topic string) string {
+	topic = strings.TrimSpace(topic)
+	if strings.Contains(topic, "://") {
+		return topic
+	}
+	if strings.Count(topic, "/") == 0 {
+		return "persistent://public/default/" + topic
+	}
+	return "persistent://" + topic
+}
+
+func (r *Reader) isStopping() bool {
+	return atomic.LoadInt32(&r.status) == StatusStopping
+}
+
+func (r *Reader) hasStopped() bool {
+	return atomic.LoadInt32(&r.status) == StatusStopped
+}
+
+func (r *Reader) Name() string {
+	return "pulsar:" + strings.Join(r.topics, ",") + "/" + r.subscription
+}
+
+func (r *Reader) SetMode(mode string, v interface{}) error {
+	return errors.New("pulsar reader does not support read mode")
+}
+
+func (r *Reader) setStatsError(err string) {
+	r.statsLock.Lock()
+	defer r.statsLock.Unlock()
+	r.stats.LastError = err
+}
+
+func (r *Reader) Start() error {
+	if r.isStopping() || r.hasStopped() {
+		return errors.New("reader is stopping or has stopped")
+	} else if !atomic.CompareAndSwapInt32(&r.status, StatusInit, StatusRunning) {
+		log.Warnf("Runner[%v] %q daemon has already started and is running", r.meta.RunnerName, r.Name())
+		return nil
+	}
+
+	for _, topic := range r.topics {
+		r.wg.Add(1)
+		go r.run(topic)
+	}
+	log.Infof("Runner[%v] %q daemon has started", r.meta.RunnerName, r.Name())
+	return nil
+}
+
+// wait 等待重试，reader 关闭时返回 false
+func (r *Reader) wait() bool {
+	select {
+	case <-r.stopChan:
+		return false
+	case <-time.After(reconnectWait):
+		return true
+	}
+}
+
+// run 查询 topic 的分区数，为每个分区启动一个 consumer
+func (r *Reader) run(topic string) {
+	defer r.wg.Done()
+	var partitions int
+	for {
+		var err error
+		if partitions, err = r.partitions(topic); err == nil {
+			break
+		}
+		if r.isStopping() || r.hasStopped() {
+			return
+		}
+		log.Errorf("Runner[%v] %q get partitions of %v error: %v, retry after %v", r.meta.RunnerName, r.Name(), topic, err, reconnectWait)
+		r.setStatsError(err.Error())
+		if !r.wait() {
+			return
+		}
+	}
+	topics := []string{topic}
+	if partitions > 0 {
+		topics = topics[:0]
+		for i := 0; i < partitions; i++ {
+			topics = append(topics, topic+"-partition-"+strconv.Itoa(i))
+		}
+	}
+	for _, t := range topics {
+		c := &consumer{r: r, topic: t, id: atomic.AddUint64(&r.nextID, 1)}
+		r.consumersLock.Lock()
+		r.consumers = append(r.consumers, c)
+		r.consumersLock.Unlock()
+		r.wg.Add(1)
+		go c.run()
+	}
+}
+
+func (r *Reader) partitions(topic string) (int, error) {
+	conn, err := dial(r.serviceURL, r.opts, "")
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	return conn.partitions(topic)
+}
+
+// run 连接断开后重新查找 broker 并订阅，未确认的消息会被 broker 重新投递
+func (c *consumer) run() {
+	r := c.r
+	defer r.wg.Done()
+	for {
+		err := c.consume()
+		if r.isStopping() || r.hasStopped() {
+			return
+		}
+		if err != nil {
+			log.Errorf("Runner[%v] %q consume %v error: %v, reconnect after %v", r.meta.RunnerName, r.Name(), c.topic, err, reconnectWait)
+			r.setStatsError(err.Error())
+		}
+		if !r.wait() {
+			return
+		}
+	}
+}
+
+// connect 通过 lookup 找到 topic 所在的 broker 并连接，broker 要求时通过 proxy 转发
+func (c *consumer) connect() (*pulsarConn, error) {
+	r := c.r
+	useTLS := r.serviceURL.Scheme == "pulsar+ssl"
+	target, proxy := r.serviceURL, ""
+	conn, err := dial(target, r.opts, proxy)
+	if err != nil {
+		return nil, err
+	}
+	authoritative := false
+	for i := 0; i < maxLookupRedirects; i++ {
+		res, err := conn.lookup(c.topic, authoritative, useTLS)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("lookup %v error: %v", c.topic, err)
+		}
+		broker, err := parseServiceURL(res.brokerURL)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("broker url %q of %v is invalid: %v", res.brokerURL, c.topic, err)
+		}
+		nextTarget, nextProxy := broker, ""
+		if res.proxyThrough {
+			nextTarget, nextProxy = r.serviceURL, broker.Host
+		}
+		if nextTarget.Host != target.Host || nextProxy != proxy {
+			conn.Close()
+			target, proxy = nextTarget, nextProxy
+			if conn, err = dial(target, r.opts, proxy); err != nil {
+				return nil, err
+			}
+		}
+		if !res.redirect {
+			return conn, nil
+		}
+		authoritative = res.authoritative
+	}
+	conn.Close()
+	return nil, fmt.Errorf("too many lookup redirects for %v", c.topic)
+}
+
+func (c *consumer) consume() error {
+	r := c.r
+	conn, err := c.connect()
+	if err != nil {
+		return err
+	}
+	c.connLock.Lock()
+	// Close 已经关闭了连接，不能再使用新的连接
+	if r.isStopping() || r.hasStopped() {
+		c.connLock.Unlock()
+		conn.Close()
+		return nil
+	}
+	c.conn = conn
+	c.connLock.Unlock()
+	defer func() {
+		c.connLock.Lock()
+		c.conn = nil
+		c.connLock.Unlock()
+		conn.Close()
+	}()
+
+	requestID := conn.nextRequestID()
+	err = conn.write(subscribeCommand(subscribeOptions{
+		topic:           c.topic,
+		subscription:    r.subscription,
+		subType:         r.subType,
+		consumerID:      c.id,
+		requestID:       requestID,
+		consumerName:    r.consumerName,
+		initialPosition: r.initialPosition,
+	}))
+	if err != nil {
+		return err
+	}
+	if _, err = conn.wait(cmdSuccess, requestID); err != nil {
+		return fmt.Errorf("subscribe %v error: %v", c.topic, err)
+	}
+	if err = conn.write(flowCommand(c.id, r.queueSize)); err != nil {
+		return err
+	}
+	log.Infof("Runner[%v] %q subscribed %v", r.meta.RunnerName, r.Name(), c.topic)
+	// 重连之前读出的消息在这里确认
+	c.ack()
+
+	// 每处理完一半的 permits 就补充，broker 在 permits 用完之前可以持续推送
+	consumed := 0
+	for {
+		cmd, err := conn.read(readTimeout)
+		if err != nil {
+			return err
+		}
+		switch cmd.typ {
+		case cmdMessage:
+			idFields, err := cmd.fields.message(2)
+			if err != nil {
+				return err
+			}
+			id := messageID{ledger: idFields.uint(1, 0), entry: idFields.uint(2, 0)}
+			messages, num, err := decodeMessages(cmd.payload)
+			if err != nil {
+				// 无法解析的消息同样需要确认，否则会被反复投递
+				log.Errorf("Runner[%v] %q skip message %v of %v: %v", r.meta.RunnerName, r.Name(), id, c.topic, err)
+				r.setStatsError(err.Error())
+				messages, num = nil, 1
+			}
+			if !c.send(id, messages) {
+				return nil
+			}
+			if consumed += num; consumed >= (r.queueSize+1)/2 {
+				if err = conn.write(flowCommand(c.id, consumed)); err != nil {
+					return err
+				}
+				consumed = 0
+			}
+		case cmdCloseConsumer:
+			return fmt.Errorf("consumer of %v is closed by broker", c.topic)
+		case cmdError:
+			return serverError(cmd.fields, 2, 3)
+		}
+	}
+}
+
+// send 将一个 entry 中的消息依次发送给 ReadLine，reader 关闭时返回 false
+func (c *consumer) send(id messageID, messages [][]byte) bool {
+	if len(messages) == 0 {
+		return c.r.send(&message{c: c, id: id, last: true, marker: true})
+	}
+	for i, data := range messages {
+		if !c.r.send(&message{c: c, id: id, data: data, last: i == len(messages)-1}) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *Reader) send(msg *message) bool {
+	select {
+	case <-r.stopChan:
+		return false
+	case r.readChan <- msg:
+		return true
+	}
+}
+
+// ack 确认已经读出的 entry，没有连接或者发送失败时留到下次确认
+func (c *consumer) ack() {
+	r := c.r
+	r.ackLock.Lock()
+	ids := c.done
+	c.done = nil
+	r.ackLock.Unlock()
+	if len(ids) == 0 {
+		return
+	}
+
+	c.connLock.Lock()
+	conn := c.conn
+	c.connLock.Unlock()
+	var err error
+	if conn == nil {
+		err = errors.New("not connected")
+	} else if r.subType == subTypeShared {
+		err = conn.write(ackCommand(c.id, ackIndividual, ids))
+	} else {
+		// 累积确认只需要最后一个 entry
+		err = conn.write(ackCommand(c.id, ackCumulative, ids[len(ids)-1:]))
+	}
+	if err != nil {
+		log.Warnf("Runner[%v] %q acknowledge %d messages of %v error: %v, will retry later", r.meta.RunnerName, r.Name(), len(ids), c.topic, err)
+		r.ackLock.Lock()
+		c.done = append(ids, c.done...)
+		r.ackLock.Unlock()
+	}
+}
+
+func (r *Reader) Source() string {
+	if r.currentSource != "" {
+		return r.currentSource
+	}
+	return r.Name()
+}
+
+func (r *Reader) ReadLine() (string, error) {
+	timer := time.NewTimer(time.Second)
+	defer timer.Stop()
+	for {
+		select {
+		case msg := <-r.readChan:
+			if msg.last {
+				r.ackLock.Lock()
+				msg.c.done = append(msg.c.done, msg.id)
+				r.ackLock.Unlock()
+			}
+			if msg.marker {
+				continue
+			}
+			r.currentSource = msg.c.topic
+			return string(msg.data), nil
+		case <-timer.C:
+			return "", nil
+		}
+	}
+}
+
+func (r *Reader) Status() StatsInfo {
+	r.statsLock.RLock()
+	defer r.statsLock.RUnlock()
+	return r.stats
+}
+
+// SyncMeta 在 runner 发送成功后确认已经读出的消息，消费位置由 broker 记录，不需要写入 meta
+func (r *Reader) SyncMeta() {
+	r.consumersLock.Lock()
+	consumers := append([]*consumer(nil), r.consumers...)
+	r.consumersLock.Unlock()
+	for _, c := range consumers {
+		c.ack()
+	}
+}
+
+func (r *Reader) Close() error {
+	if !atomic.CompareAndSwapInt32(&r.status, StatusRunning, StatusStopping) {
+		log.Warnf("Runner[%v] reader %q is not running, close operation ignored", r.meta.RunnerName, r.Name())
+		return nil
+	}
+	log.Debugf("Runner[%v] %q daemon is stopping", r.meta.RunnerName, r.Name())
+	close(r.stopChan)
+	r.consumersLock.Lock()
+	for _, c := range r.consumers {
+		c.connLock.Lock()
+		if c.conn != nil {
+			c.conn.Close()
+		}
+		c.connLock.Unlock()
+	}
+	r.consumersLock.Unlock()
+	r.wg.Wait()
+	atomic.StoreInt32(&r.status, StatusStopped)
+	return nil
+}
@@ -0,0 +1,356 @@
+package pulsar
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/pierrec/lz4"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/reader"
+	. "github.com/qiniu/logkit/reader/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+// entry broker 推送的一个 entry，payload 为 MESSAGE 命令之后的全部内容
+type entry struct {
+	id      messageID
+	payload []byte
+}
+
+// fakeBroker 模拟 Pulsar broker，lookup 总是返回自己，收到 FLOW 后推送 entries 中对应 topic 的消息
+type fakeBroker struct {
+	ln         net.Listener
+	partitions int
+	entries    map[string][]entry
+
+	lock     sync.Mutex
+	tokens   []string
+	subs     []string
+	acks     []string
+	permits  int
+	conns    []net.Conn
+	flowSent map[string]bool
+}
+
+func newFakeBroker(t *testing.T, partitions int, entries map[string][]entry) *fakeBroker {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	b := &fakeBroker{ln: ln, partitions: partitions, entries: entries, flowSent: make(map[string]bool)}
+	go b.serve()
+	return b
+}
+
+func (b *fakeBroker) serve() {
+	for {
+		conn, err := b.ln.Accept()
+		if err != nil {
+			return
+		}
+		b.lock.Lock()
+		b.conns = append(b.conns, conn)
+		b.lock.Unlock()
+		go b.handle(conn)
+	}
+}
+
+func (b *fakeBroker) handle(conn net.Conn) {
+	defer conn.Close()
+	var wlock sync.Mutex
+	write := func(frame []byte) {
+		wlock.Lock()
+		defer wlock.Unlock()
+		conn.Write(frame)
+	}
+	topics := make(map[uint64]string)
+	for {
+		cmd, err := readCommand(conn)
+		if err != nil {
+			return
+		}
+		f := cmd.fields
+		var resp pbBuilder
+		switch cmd.typ {
+		case cmdConnect:
+			b.lock.Lock()
+			b.tokens = append(b.tokens, f.string(5)+":"+f.string(3))
+			b.lock.Unlock()
+			resp.string(1, "fake")
+			resp.varint(2, protocolVersion)
+			write(encodeCommand(cmdConnected, resp))
+			// 握手后立即发送 PING，客户端需要回复 PONG
+			write(emptyCommand(cmdPing))
+		case cmdPartitionedMetadata:
+			resp.varint(1, uint64(b.partitions))
+			resp.varint(2, f.uint(2, 0))
+			write(encodeCommand(cmdPartitionedMetadataResponse, resp))
+		case cmdLookup:
+			resp.string(1, "pulsar://"+b.ln.Addr().String())
+			resp.varint(3, lookupConnect)
+			resp.varint(4, f.uint(2, 0))
+			write(encodeCommand(cmdLookupResponse, resp))
+		case cmdSubscribe:
+			topics[f.uint(4, 0)] = f.string(1)
+			b.lock.Lock()
+			b.subs = append(b.subs, f.string(1)+" "+f.string(2)+" "+string('0'+byte(f.uint(3, 0))))
+			b.lock.Unlock()
+			resp.varint(1, f.uint(5, 0))
+			write(encodeCommand(cmdSuccess, resp))
+		case cmdFlow:
+			consumerID := f.uint(1, 0)
+			topic := topics[consumerID]
+			b.lock.Lock()
+			b.permits += int(f.uint(2, 0))
+			sent := b.flowSent[topic]
+			b.flowSent[topic] = true
+			b.lock.Unlock()
+			if sent {
+				continue
+			}
+			for _, e := range b.entries[topic] {
+				var msg pbBuilder
+				msg.varint(1, consumerID)
+				msg.bytes(2, e.id.marshal())
+				frame := encodeCommand(cmdMessage, msg)
+				binary.BigEndian.PutUint32(frame, uint32(len(frame)-4+len(e.payload)))
+				write(append(frame, e.payload...))
+			}
+		case cmdAck:
+			var ids []string
+			for _, raw := range f.raw[3] {
+				id, _ := parseFields(raw)
+				ids = append(ids, messageID{ledger: id.uint(1, 0), entry: id.uint(2, 0)}.String())
+			}
+			b.lock.Lock()
+			b.acks = append(b.acks, topics[f.uint(1, 0)]+" "+string('0'+byte(f.uint(2, 0)))+" "+strings.Join(ids, ","))
+			b.lock.Unlock()
+		}
+	}
+}
+
+func (b *fakeBroker) waitAcks(n int) []string {
+	for i := 0; i < 100; i++ {
+		b.lock.Lock()
+		acks := append([]string(nil), b.acks...)
+		b.lock.Unlock()
+		if len(acks) >= n {
+			sort.Strings(acks)
+			return acks
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return nil
+}
+
+func (b *fakeBroker) Close() {
+	b.ln.Close()
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for _, conn := range b.conns {
+		conn.Close()
+	}
+}
+
+// metadata 构造 MessageMetadata，num 小于 0 时不是批量消息
+func metadata(compression, uncompressed, num int) []byte {
+	var m pbBuilder
+	m.string(1, "producer")
+	m.varint(2, 1)
+	m.varint(3, 1700000000000)
+	if compression != compressionNone {
+		m.varint(8, uint64(compression))
+		m.varint(9, uint64(uncompressed))
+	}
+	if num >= 0 {
+		m.varint(11, uint64(num))
+	}
+	return m
+}
+
+// payload 构造 MESSAGE 命令之后的内容，checksum 为 true 时带有 magic 和 crc32c 校验值
+func payload(meta, data []byte, checksum bool) []byte {
+	body := make([]byte, 4, 4+len(meta)+len(data))
+	binary.BigEndian.PutUint32(body, uint32(len(meta)))
+	body = append(append(body, meta...), data...)
+	if !checksum {
+		return body
+	}
+	head := make([]byte, 6)
+	copy(head, magicCrc32c)
+	binary.BigEndian.PutUint32(head[2:], crc32.Checksum(body, crc32.MakeTable(crc32.Castagnoli)))
+	return append(head, body...)
+}
+
+// batch 构造批量消息的内容，nil 表示被压缩整理的消息
+func batch(messages ...[]byte) []byte {
+	var buf []byte
+	for _, msg := range messages {
+		var single pbBuilder
+		single.varint(3, uint64(len(msg)))
+		if msg == nil {
+			single.varint(4, 1)
+		}
+		size := make([]byte, 4)
+		binary.BigEndian.PutUint32(size, uint32(len(single)))
+		buf = append(append(append(buf, size...), single...), msg...)
+	}
+	return buf
+}
+
+func zlibData(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	_, err := zw.Write(data)
+	assert.NoError(t, err)
+	assert.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func newPulsarReader(t *testing.T, c conf.MapConf) *Reader {
+	meta, err := reader.NewMetaWithConf(c)
+	assert.NoError(t, err)
+	rd, err := NewReader(meta, c)
+	assert.NoError(t, err)
+	r := rd.(*Reader)
+	assert.NoError(t, r.Start())
+	return r
+}
+
+func readLines(t *testing.T, r *Reader, n int) []string {
+	var lines []string
+	for i := 0; i < 20 && len(lines) < n; i++ {
+		line, err := r.ReadLine()
+		assert.NoError(t, err)
+		if line != "" {
+			lines = append(lines, r.Source()+" "+line)
+		}
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+func TestPulsarFailover(t *testing.T) {
+	topic := "persistent://public/default/logs"
+	b := newFakeBroker(t, 2, map[string][]entry{
+		topic + "-partition-0": {
+			{id: messageID{1, 1}, payload: payload(metadata(compressionNone, 0, -1), []byte("a"), true)},
+			{id: messageID{1, 2}, payload: payload(metadata(compressionZLIB, 0, 2), zlibData(t, batch([]byte("b"), []byte("c"))), true)},
+		},
+		topic + "-partition-1": {
+			{id: messageID{2, 1}, payload: payload(metadata(compressionNone, 0, -1), []byte("d"), false)},
+		},
+	})
+	defer b.Close()
+
+	r := newPulsarReader(t, conf.MapConf{
+		KeyMode:             ModePulsar,
+		KeyRunnerName:       "TestPulsarFailover",
+		KeyMetaPath:         "TestPulsarFailover",
+		KeyPulsarServiceURL: "pulsar://" + b.ln.Addr().String(),
+		KeyPulsarTopics:     "logs",
+		KeyPulsarToken:      "secret",
+	})
+	defer r.Close()
+	assert.Equal(t, []string{
+		topic + "-partition-0 a",
+		topic + "-partition-0 b",
+		topic + "-partition-0 c",
+		topic + "-partition-1 d",
+	}, readLines(t, r, 4))
+
+	// 发送成功(SyncMeta)后才确认，failover 订阅只累积确认最后一个 entry
+	b.lock.Lock()
+	assert.Len(t, b.acks, 0)
+	assert.NotEmpty(t, b.tokens)
+	for _, token := range b.tokens {
+		assert.Equal(t, "token:secret", token)
+	}
+	sort.Strings(b.subs)
+	assert.Equal(t, []string{topic + "-partition-0 logkit 2", topic + "-partition-1 logkit 2"}, b.subs)
+	b.lock.Unlock()
+	r.SyncMeta()
+	assert.Equal(t, []string{topic + "-partition-0 1 1:2", topic + "-partition-1 1 2:1"}, b.waitAcks(2))
+	assert.NoError(t, r.Close())
+	os.RemoveAll("TestPulsarFailover")
+}
+
+func TestPulsarShared(t *testing.T) {
+	topic := "persistent://tenant/ns/logs"
+	a, b := strings.Repeat("a", 64), strings.Repeat("b", 64)
+	data := batch([]byte(a), nil, []byte(b))
+	lz := make([]byte, lz4.CompressBlockBound(len(data)))
+	n, err := lz4.CompressBlock(data, lz, 0)
+	assert.NoError(t, err)
+	assert.True(t, n > 0)
+	corrupt := payload(metadata(compressionNone, 0, -1), []byte("x"), true)
+	corrupt[len(corrupt)-1] = 'y'
+	broker := newFakeBroker(t, 0, map[string][]entry{
+		topic: {
+			{id: messageID{3, 1}, payload: payload(metadata(compressionLZ4, len(data), 3), lz[:n], true)},
+			{id: messageID{3, 2}, payload: corrupt},
+			{id: messageID{3, 3}, payload: payload(metadata(compressionSnappy, 0, -1), snappy.Encode(nil, []byte("c")), true)},
+			{id: messageID{3, 4}, payload: payload(metadata(compressionNone, 0, 1), batch(nil), true)},
+		},
+	})
+	defer broker.Close()
+
+	r := newPulsarReader(t, conf.MapConf{
+		KeyMode:                    ModePulsar,
+		KeyRunnerName:              "TestPulsarShared",
+		KeyMetaPath:                "TestPulsarShared",
+		KeyPulsarServiceURL:        "pulsar://" + broker.ln.Addr().String(),
+		KeyPulsarTopics:            "tenant/ns/logs",
+		KeyPulsarSubscription:      "sub",
+		KeyPulsarSubscriptionType:  PulsarSubscriptionShared,
+		KeyPulsarReceiverQueueSize: "2",
+	})
+	defer r.Close()
+	assert.Equal(t, []string{topic + " " + a, topic + " " + b, topic + " c"}, readLines(t, r, 3))
+	// 最后一个 entry 只有被压缩整理的消息，读取时跳过
+	line, err := r.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "", line)
+	assert.Equal(t, "checksum mismatch", strings.TrimPrefix(r.Status().LastError, "message "))
+
+	r.SyncMeta()
+	assert.Equal(t, []string{topic + " 0 3:1,3:2,3:3,3:4"}, broker.waitAcks(1))
+	broker.lock.Lock()
+	assert.Equal(t, []string{topic + " sub 1"}, broker.subs)
+	// 初始的 2 个以及每处理一半补充的 permits
+	assert.True(t, broker.permits > 2)
+	broker.lock.Unlock()
+	assert.NoError(t, r.Close())
+	os.RemoveAll("TestPulsarShared")
+}
+
+func TestFullTopicName(t *testing.T) {
+	assert.Equal(t, "persistent://public/default/logs", fullTopicName("logs"))
+	assert.Equal(t, "persistent://a/b/logs", fullTopicName("a/b/logs"))
+	assert.Equal(t, "non-persistent://a/b/logs", fullTopicName("non-persistent://a/b/logs"))
+}
+
+func TestNewReaderError(t *testing.T) {
+	for _, c := range []conf.MapConf{
+		{},
+		{KeyPulsarTopics: "logs", KeyPulsarServiceURL: "http://127.0.0.1:6650"},
+		{KeyPulsarTopics: "logs", KeyPulsarSubscriptionType: "key_shared"},
+		{KeyPulsarTopics: "logs", KeyPulsarInitialPosition: "middle"},
+		{KeyPulsarTopics: "logs", KeyPulsarReceiverQueueSize: "0"},
+		{KeyPulsarTopics: "logs", KeyPulsarTLSCert: "cert.pem"},
+		{KeyPulsarTopics: "logs", KeyPulsarTLSCA: "not_exist.pem"},
+	} {
+		_, err := NewReader(nil, c)
+		assert.Error(t, err, c)
+	}
+}
@@ -55,10 +55,55 @@ type LagReader interface {
 	Lag() (*LagInfo, error)
 }
 
+// DetailStatusReader 代表了可以返回每个文件读取进度的读取器
+type DetailStatusReader interface {
+	DetailStatus() []FileStatus
+}
+
+// ClientStatusReader 代表了监听端口接收数据、可以返回每个客户端统计的读取器
+type ClientStatusReader interface {
+	ClientStatus() []ClientStatus
+}
+
 type OnceReader interface {
 	ReadDone() bool
 }
 
+// OpenFilesReader 代表了同时打开多个文件的读取器，OpenFiles 返回当前打开的文件数，
+// SetOpenFilesLimit 在 max_open_files 之外进一步限制打开的文件数，小于等于 0 表示不额外限制
+type OpenFilesReader interface {
+	OpenFiles() int
+	SetOpenFilesLimit(limit int)
+}
+
+// SourceTagsReader 代表了可以按数据源为数据附加额外字段的读取器，如容器日志文件对应的 k8s 元数据，
+// SourceTagsEnabled 返回 false 时 runner 不会调用 SourceTags
+type SourceTagsReader interface {
+	SourceTagsEnabled() bool
+	// SourceTags 返回数据源 source 读出的数据需要附加的字段，没有时返回 nil
+	SourceTags(source string) map[string]interface{}
+}
+
+// RecordTagsReader 代表了可以为每条数据附加不同字段的读取器，如 kafka 消息的 topic、offset 和 headers，
+// RecordTagsEnabled 返回 false 时 runner 不会调用 RecordTags
+type RecordTagsReader interface {
+	RecordTagsEnabled() bool
+	// RecordTags 返回最近一次 ReadLine 读出的数据需要附加的字段，没有时返回 nil
+	RecordTags() map[string]interface{}
+}
+
+// EventReader 代表了会产生自身事件数据的读取器，如 tailx 的文件生命周期事件。
+// 事件数据不经过 parser，runner 在每批数据解析之后调用 Events 取出并追加到这一批数据中
+type EventReader interface {
+	Events() []Data
+}
+
+// PartitionReader 代表了数据来自多个有序分区的读取器，如 kafka，Partition 返回最近一次 ReadLine 读出的数据所在的分区，
+// runner 并行解析时用分区代替 Source 保证同一分区的数据有序
+type PartitionReader interface {
+	Partition() string
+}
+
 // FileReader reader 接口方法
 type FileReader interface {
 	Name() string
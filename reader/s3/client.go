@@ -0,0 +1,227 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/aws/aws-sdk-go/private/protocol/rest"
+)
+
+// 错误响应体的读取上限
+const maxErrorBody = 4096
+
+// object 对象存储中的一个对象
+type object struct {
+	Key  string `xml:"Key"`
+	ETag string `xml:"ETag"`
+	Size int64  `xml:"Size"`
+}
+
+type listResult struct {
+	IsTruncated bool     `xml:"IsTruncated"`
+	NextMarker  string   `xml:"NextMarker"`
+	Contents    []object `xml:"Contents"`
+}
+
+type sqsMessage struct {
+	ReceiptHandle string `xml:"ReceiptHandle"`
+	Body          string `xml:"Body"`
+}
+
+type receiveResult struct {
+	Messages []sqsMessage `xml:"ReceiveMessageResult>Message"`
+}
+
+// client 只实现读取日志需要的 S3 ListObjects、GetObject 以及 SQS ReceiveMessage、DeleteMessage 接口，
+// 使用 v4 签名，兼容 MinIO、七牛 Kodo 等提供 S3 接口的对象存储
+type client struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	pathStyle bool
+	signer    *v4.Signer
+	http      *http.Client
+}
+
+func newClient(endpoint, region, bucket, ak, sk string, pathStyle bool, httpClient *http.Client) (*client, error) {
+	if endpoint == "" {
+		endpoint = defaultEndpoint(region)
+	} else if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+		endpoint = "https://" + endpoint
+	}
+	u, err := url.Parse(strings.TrimRight(endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+	return &client{
+		endpoint:  u,
+		region:    region,
+		bucket:    bucket,
+		pathStyle: pathStyle,
+		signer: v4.NewSigner(credentials.NewStaticCredentials(ak, sk, ""), func(s *v4.Signer) {
+			// S3 的路径不需要再次转义
+			s.DisableURIPathEscaping = true
+		}),
+		http: httpClient,
+	}, nil
+}
+
+func defaultEndpoint(region string) string {
+	if strings.HasPrefix(region, "cn-") {
+		return "https://s3." + region + ".amazonaws.com.cn"
+	}
+	return "https://s3." + region + ".amazonaws.com"
+}
+
+// objectURL 返回对象的地址，key 为空时返回 bucket 的地址
+func (c *client) objectURL(key string) *url.URL {
+	u := *c.endpoint
+	p := "/" + key
+	if c.pathStyle {
+		p = "/" + c.bucket + p
+	} else {
+		u.Host = c.bucket + "." + u.Host
+	}
+	u.Path = u.Path + p
+	u.RawPath = rest.EscapePath(u.Path, false)
+	return &u
+}
+
+func (c *client) do(ctx context.Context, req *http.Request, body []byte, service string) (*http.Response, error) {
+	var seeker io.ReadSeeker
+	if body != nil {
+		seeker = bytes.NewReader(body)
+	}
+	if _, err := c.signer.Sign(req, seeker, service, c.region, time.Now()); err != nil {
+		return nil, err
+	}
+	return c.http.Do(req.WithContext(ctx))
+}
+
+func responseError(resp *http.Response) error {
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	return fmt.Errorf("unexpected status %v: %s", resp.Status, strings.TrimSpace(string(body)))
+}
+
+// list 列出 prefix 下 marker 之后的一页对象
+func (c *client) list(ctx context.Context, prefix, marker string) (*listResult, error) {
+	u := c.objectURL("")
+	q := url.Values{}
+	q.Set("prefix", prefix)
+	if marker != "" {
+		q.Set("marker", marker)
+	}
+	u.RawQuery = q.Encode()
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(ctx, req, nil, "s3")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp)
+	}
+	var result listResult
+	if err = xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// listAll 列出 prefix 下的所有对象
+func (c *client) listAll(ctx context.Context, prefix string) ([]object, error) {
+	var (
+		objects []object
+		marker  string
+	)
+	for {
+		result, err := c.list(ctx, prefix, marker)
+		if err != nil {
+			return objects, err
+		}
+		objects = append(objects, result.Contents...)
+		if !result.IsTruncated || len(result.Contents) == 0 {
+			return objects, nil
+		}
+		marker = result.NextMarker
+		if marker == "" {
+			marker = result.Contents[len(result.Contents)-1].Key
+		}
+	}
+}
+
+// get 从 offset 开始获取对象内容，etag 不为空时要求对象没有被替换，调用方负责关闭返回的 Body
+func (c *client) get(ctx context.Context, key string, offset int64, etag string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, c.objectURL(key).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+	}
+	if etag != "" {
+		req.Header.Set("If-Match", `"`+etag+`"`)
+	}
+	return c.do(ctx, req, nil, "s3")
+}
+
+func (c *client) sqs(ctx context.Context, queueURL string, params url.Values) (*http.Response, error) {
+	params.Set("Version", "2012-11-05")
+	body := []byte(params.Encode())
+	req, err := http.NewRequest(http.MethodPost, queueURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return c.do(ctx, req, body, "sqs")
+}
+
+// receive 从 SQS 队列中长轮询获取消息
+func (c *client) receive(ctx context.Context, queueURL string, wait time.Duration) ([]sqsMessage, error) {
+	resp, err := c.sqs(ctx, queueURL, url.Values{
+		"Action":              {"ReceiveMessage"},
+		"MaxNumberOfMessages": {"10"},
+		"WaitTimeSeconds":     {strconv.Itoa(int(wait / time.Second))},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp)
+	}
+	var result receiveResult
+	if err = xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return result.Messages, nil
+}
+
+func (c *client) deleteMessage(ctx context.Context, queueURL, receiptHandle string) error {
+	resp, err := c.sqs(ctx, queueURL, url.Values{
+		"Action":        {"DeleteMessage"},
+		"ReceiptHandle": {receiptHandle},
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package s3
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/qiniu/log"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/reader"
+	. "github.com/qiniu/logkit/reader/config"
+	"github.com/qiniu/logkit/reader/nats"
+)
+
+// MinIO 和 Ceph RGW 的 bucket 通知与 S3 事件格式相同，除 SQS 外还可以通过 webhook 和 NATS 接收：
+// MinIO 的 webhook 和 Ceph RGW 的 HTTP push endpoint 以 POST 推送事件，MinIO 的 NATS 目标将事件发布到指定的主题
+
+// maxEventSize webhook 单个请求的最大长度
+const maxEventSize = 10 * 1024 * 1024
+
+// notifyObjects 将事件中的对象交给 run 立即读取，reader 关闭时返回 false
+func (r *Reader) notifyObjects(objects []object) bool {
+	for _, obj := range objects {
+		select {
+		case <-r.stopChan:
+			return false
+		case r.notify <- obj:
+		}
+	}
+	return true
+}
+
+// startWebhook 监听 webhook 地址，在 Start 中调用以便及时返回监听失败的错误
+func (r *Reader) startWebhook() error {
+	ln, err := net.Listen("tcp", r.webhookAddress)
+	if err != nil {
+		return err
+	}
+	r.webhook = &http.Server{Handler: http.HandlerFunc(r.handleWebhook)}
+	go func() {
+		if err := r.webhook.Serve(ln); err != nil && err != http.ErrServerClosed {
+			log.Errorf("Runner[%v] %q webhook serve error: %v", r.meta.RunnerName, r.Name(), err)
+			r.setStatsError(err.Error())
+		}
+	}()
+	log.Infof("Runner[%v] %q webhook is listening on %v", r.meta.RunnerName, r.Name(), ln.Addr())
+	return nil
+}
+
+// handleWebhook 接收 POST 的事件通知，对象进入读取队列后返回 200，GET 和 HEAD 请求用于服务端检查 endpoint 是否可用
+func (r *Reader) handleWebhook(w http.ResponseWriter, req *http.Request) {
+	if r.webhookToken != "" {
+		auth := req.Header.Get("Authorization")
+		if auth != r.webhookToken && auth != "Bearer "+r.webhookToken {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		return
+	case http.MethodPost:
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, maxEventSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	objects, err := ParseEvent(string(body), r.bucket)
+	if err != nil {
+		log.Warnf("Runner[%v] %q ignore invalid s3 event %q from %v: %v", r.meta.RunnerName, r.Name(), body, req.RemoteAddr, err)
+		http.Error(w, "invalid event: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !r.notifyObjects(objects) {
+		http.Error(w, "reader is stopping", http.StatusServiceUnavailable)
+	}
+}
+
+// newNATSSubscriber 使用 nats reader 订阅 MinIO 发布事件的主题
+func newNATSSubscriber(meta *reader.Meta, servers, subject, queueGroup string) (*nats.Reader, error) {
+	sub, err := nats.NewReader(meta, conf.MapConf{
+		KeyNATSServers:    servers,
+		KeyNATSSubjects:   subject,
+		KeyNATSQueueGroup: queueGroup,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sub.(*nats.Reader), nil
+}
+
+// consumeNATS 从 NATS 订阅中读取事件通知，交给 run 立即读取，断线重连由 nats reader 负责
+func (r *Reader) consumeNATS() {
+	for atomic.LoadInt32(&r.status) == StatusRunning {
+		body, err := r.natsSub.ReadLine()
+		if err != nil {
+			r.onError(err)
+			continue
+		}
+		if body == "" {
+			continue
+		}
+		objects, err := ParseEvent(body, r.bucket)
+		if err != nil {
+			log.Warnf("Runner[%v] %q ignore invalid s3 event %q from %v: %v", r.meta.RunnerName, r.Name(), body, r.natsSub.Source(), err)
+		}
+		if !r.notifyObjects(objects) {
+			return
+		}
+	}
+}
+
+// closeNotifiers 关闭 webhook 和 NATS 订阅
+func (r *Reader) closeNotifiers() {
+	if r.webhook != nil {
+		if err := r.webhook.Close(); err != nil {
+			log.Errorf("Runner[%v] %q close webhook error: %v", r.meta.RunnerName, r.Name(), err)
+		}
+	}
+	if r.natsSub != nil {
+		if err := r.natsSub.Close(); err != nil {
+			log.Errorf("Runner[%v] %q close nats subscription error: %v", r.meta.RunnerName, r.Name(), err)
+		}
+	}
+}
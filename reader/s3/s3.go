@@ -0,0 +1,612 @@
+package s3
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/qiniu/log"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/reader"
+	. "github.com/qiniu/logkit/reader/config"
+	"github.com/qiniu/logkit/reader/nats"
+	. "github.com/qiniu/logkit/utils/models"
+	"github.com/qiniu/logkit/utils/tlspolicy"
+)
+
+var (
+	_ reader.DaemonReader = &Reader{}
+	_ reader.StatsReader  = &Reader{}
+	_ reader.Reader       = &Reader{}
+)
+
+const (
+	// ProgressFile 记录每个对象读取进度的 meta 文件名
+	ProgressFile = "s3.records"
+
+	// SQS 长轮询的等待时间，以及出错后重试的间隔
+	sqsWaitTime      = 20 * time.Second
+	sqsRetryInterval = 10 * time.Second
+)
+
+func init() {
+	reader.RegisterConstructor(ModeS3, NewReader)
+}
+
+// Progress 单个对象的读取进度，Offset 为已经被读取的字节数，gzip 压缩的对象为解压后的字节数
+type Progress struct {
+	Offset int64  `json:"offset"`
+	ETag   string `json:"etag,omitempty"`
+	Gzip   bool   `json:"gzip,omitempty"`
+	Done   bool   `json:"done,omitempty"`
+}
+
+type readInfo struct {
+	key      string
+	line     string
+	progress Progress
+	// marker 为 true 时只更新进度，没有数据
+	marker bool
+	// remove 为 true 时删除该对象的进度，对象已经从 bucket 中删除
+	remove bool
+}
+
+type Reader struct {
+	meta *reader.Meta
+	// Note: 原子操作，用于表示 reader 整体的运行状态
+	status int32
+
+	stopChan chan struct{}
+	readChan chan readInfo
+	errChan  chan error
+	// notify 为 SQS、webhook 或 NATS 事件通知中新创建的对象
+	notify chan object
+
+	stats     StatsInfo
+	statsLock sync.RWMutex
+
+	bucket   string
+	prefix   string
+	pattern  string
+	interval time.Duration
+	queueURL string
+
+	webhookAddress string
+	webhookToken   string
+	webhook        *http.Server
+	natsSub        *nats.Reader
+
+	client *client
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// progress 为已经被 ReadLine 读取的进度，用于写入 meta
+	progress    map[string]Progress
+	currentKey  string
+	progressMux sync.Mutex
+	// fetched 为已经发送到 readChan 的进度，只在获取数据的线程中使用
+	fetched map[string]Progress
+}
+
+func NewReader(meta *reader.Meta, c conf.MapConf) (reader.Reader, error) {
+	region, _ := c.GetStringOr(KeyS3Region, "us-east-1")
+	ak, _ := c.GetPasswordEnvString(KeyS3AccessKey)
+	sk, _ := c.GetPasswordEnvString(KeyS3SecretKey)
+	if ak == "" || sk == "" {
+		return nil, fmt.Errorf("%v and %v can not be empty", KeyS3AccessKey, KeyS3SecretKey)
+	}
+	bucket, _ := c.GetString(KeyS3Bucket)
+	if bucket == "" {
+		return nil, fmt.Errorf("%v can not be empty", KeyS3Bucket)
+	}
+	prefix, _ := c.GetStringOr(KeyS3Prefix, "")
+	prefix = strings.TrimPrefix(prefix, "/")
+	endpoint, _ := c.GetStringOr(KeyS3Endpoint, "")
+	pathStyle, _ := c.GetBoolOr(KeyS3PathStyle, false)
+	pattern, _ := c.GetStringOr(KeyS3Pattern, "*")
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("%v %q is invalid: %v", KeyS3Pattern, pattern, err)
+	}
+	intervalStr, _ := c.GetStringOr(KeyS3Interval, "1m")
+	interval, err := time.ParseDuration(intervalStr)
+	if err != nil {
+		return nil, err
+	}
+	if interval <= 0 {
+		return nil, fmt.Errorf("%v should be positive", KeyS3Interval)
+	}
+	queueURL, _ := c.GetStringOr(KeyS3SQSQueueURL, "")
+	webhookAddress, _ := c.GetStringOr(KeyS3NotifyWebhookAddress, "")
+	webhookToken, _ := c.GetPasswordEnvStringOr(KeyS3NotifyWebhookToken, "")
+	natsServers, _ := c.GetStringOr(KeyS3NotifyNATSServers, "")
+	natsSubject, _ := c.GetStringOr(KeyS3NotifyNATSSubject, "")
+	natsQueueGroup, _ := c.GetStringOr(KeyS3NotifyNATSQueueGroup, "")
+	var natsSub *nats.Reader
+	if natsSubject != "" {
+		if natsServers == "" {
+			return nil, fmt.Errorf("%v can not be empty when %v is set", KeyS3NotifyNATSServers, KeyS3NotifyNATSSubject)
+		}
+		if natsSub, err = newNATSSubscriber(meta, natsServers, natsSubject, natsQueueGroup); err != nil {
+			return nil, err
+		}
+	}
+
+	// 对象内容的下载时间不可预期，只限制建立连接和等待响应头的时间，需要大于 SQS 长轮询的时间
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		Dial: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).Dial,
+		ResponseHeaderTimeout: sqsWaitTime + 30*time.Second,
+		TLSClientConfig:       tlspolicy.Apply(nil),
+	}
+	cli, err := newClient(endpoint, region, bucket, ak, sk, pathStyle, &http.Client{Transport: transport})
+	if err != nil {
+		return nil, fmt.Errorf("%v is invalid: %v", KeyS3Endpoint, err)
+	}
+
+	progress, err := RestoreProgress(meta.Dir)
+	if err != nil {
+		log.Errorf("Runner[%v] restore %v error %v, read all objects from the beginning", meta.RunnerName, ProgressFile, err)
+		progress = make(map[string]Progress)
+	}
+	fetched := make(map[string]Progress, len(progress))
+	for k, v := range progress {
+		fetched[k] = v
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Reader{
+		meta:     meta,
+		status:   StatusInit,
+		stopChan: make(chan struct{}),
+		readChan: make(chan readInfo),
+		errChan:  make(chan error),
+		notify:   make(chan object, 100),
+		bucket:   bucket,
+		prefix:   prefix,
+		pattern:  pattern,
+		interval: interval,
+		queueURL: queueURL,
+		client:   cli,
+		ctx:      ctx,
+		cancel:   cancel,
+		progress: progress,
+		fetched:  fetched,
+
+		webhookAddress: webhookAddress,
+		webhookToken:   webhookToken,
+		natsSub:        natsSub,
+	}, nil
+}
+
+func progressPath(dir string) string {
+	return filepath.Join(dir, ProgressFile)
+}
+
+// RestoreProgress 从 meta 中恢复各对象的读取进度，文件不存在时返回空的进度
+func RestoreProgress(dir string) (map[string]Progress, error) {
+	progress := make(map[string]Progress)
+	data, err := ioutil.ReadFile(progressPath(dir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return progress, nil
+		}
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &progress); err != nil {
+		return nil, err
+	}
+	return progress, nil
+}
+
+// WriteProgress 将各对象的读取进度写入 meta
+func WriteProgress(dir string, progress map[string]Progress) error {
+	data, err := json.Marshal(progress)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(progressPath(dir), data, DefaultFilePerm)
+}
+
+func (r *Reader) isStopping() bool {
+	return atomic.LoadInt32(&r.status) == StatusStopping
+}
+
+func (r *Reader) hasStopped() bool {
+	return atomic.LoadInt32(&r.status) == StatusStopped
+}
+
+func (r *Reader) Name() string {
+	return "s3://" + r.bucket + "/" + r.prefix
+}
+
+func (r *Reader) SetMode(mode string, v interface{}) error {
+	return errors.New("s3 reader does not support read mode")
+}
+
+func (r *Reader) setStatsError(err string) {
+	r.statsLock.Lock()
+	defer r.statsLock.Unlock()
+	r.stats.LastError = err
+}
+
+func (r *Reader) sendError(err error) {
+	if err == nil {
+		return
+	}
+	defer func() {
+		if rec := recover(); rec != nil {
+			log.Errorf("Reader %q was panicked and recovered from %v", r.Name(), rec)
+		}
+	}()
+	select {
+	case r.errChan <- err:
+	case <-r.stopChan:
+	}
+}
+
+func (r *Reader) onError(err error) {
+	log.Errorf("Runner[%v] %q %v", r.meta.RunnerName, r.Name(), err)
+	r.setStatsError(err.Error())
+	r.sendError(err)
+}
+
+func (r *Reader) Start() error {
+	if r.isStopping() || r.hasStopped() {
+		return errors.New("reader is stopping or has stopped")
+	} else if !atomic.CompareAndSwapInt32(&r.status, StatusInit, StatusRunning) {
+		log.Warnf("Runner[%v] %q daemon has already started and is running", r.meta.RunnerName, r.Name())
+		return nil
+	}
+
+	if r.webhookAddress != "" {
+		if err := r.startWebhook(); err != nil {
+			atomic.StoreInt32(&r.status, StatusInit)
+			return err
+		}
+	}
+	if r.natsSub != nil {
+		if err := r.natsSub.Start(); err != nil {
+			r.closeNotifiers()
+			atomic.StoreInt32(&r.status, StatusInit)
+			return err
+		}
+		go r.consumeNATS()
+	}
+	go r.run()
+	if r.queueURL != "" {
+		go r.consumeEvents()
+	}
+	log.Infof("Runner[%v] %q daemon has started", r.meta.RunnerName, r.Name())
+	return nil
+}
+
+// run 定期列出 prefix 下的所有对象并读取新的对象，同时读取事件通知中的对象
+func (r *Reader) run() {
+	defer func() {
+		atomic.StoreInt32(&r.status, StatusStopped)
+		log.Infof("Runner[%v] %q daemon has stopped from running", r.meta.RunnerName, r.Name())
+	}()
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	r.scan()
+	for {
+		select {
+		case <-r.stopChan:
+			return
+		case <-ticker.C:
+			r.scan()
+		case obj := <-r.notify:
+			if err := r.fetch(obj); err != nil {
+				r.onError(fmt.Errorf("fetch %v error: %v", obj.Key, err))
+			}
+		}
+	}
+}
+
+func (r *Reader) match(key string) bool {
+	// 目录占位对象
+	if !strings.HasPrefix(key, r.prefix) || strings.HasSuffix(key, "/") {
+		return false
+	}
+	ok, _ := path.Match(r.pattern, path.Base(key))
+	return ok
+}
+
+// scan 读取所有未读完的对象，已经从 bucket 中删除的对象不再记录进度，单个对象失败不影响其他对象，下次扫描时从失败的位置继续读取
+func (r *Reader) scan() {
+	objects, err := r.client.listAll(r.ctx, r.prefix)
+	if err != nil {
+		if r.isStopping() || r.hasStopped() {
+			return
+		}
+		r.onError(fmt.Errorf("list objects error: %v", err))
+	} else {
+		exist := make(map[string]bool, len(objects))
+		for _, obj := range objects {
+			exist[obj.Key] = true
+		}
+		for key, p := range r.fetched {
+			if !exist[key] && p.Done {
+				delete(r.fetched, key)
+				if !r.send(readInfo{key: key, remove: true}) {
+					return
+				}
+			}
+		}
+	}
+	for _, obj := range objects {
+		if r.isStopping() || r.hasStopped() {
+			return
+		}
+		if err := r.fetch(obj); err != nil {
+			r.onError(fmt.Errorf("fetch %v error: %v", obj.Key, err))
+		}
+	}
+}
+
+// fetch 从上次读取的位置开始读取对象，读到末尾后标记为已读完，对象的 ETag 变化时从头读取
+func (r *Reader) fetch(obj object) error {
+	if !r.match(obj.Key) {
+		return nil
+	}
+	etag := strings.Trim(obj.ETag, `"`)
+	p := r.fetched[obj.Key]
+	if etag != "" && p.ETag != "" && etag != p.ETag {
+		log.Warnf("Runner[%v] %q etag of %v changed from %v to %v, read it from the beginning", r.meta.RunnerName, r.Name(), obj.Key, p.ETag, etag)
+		p = Progress{}
+	}
+	if p.Done {
+		return nil
+	}
+
+	// gzip 压缩的对象无法从中间解压，需要从头读取并跳过已经读取的部分
+	offset := p.Offset
+	if p.Gzip {
+		offset = 0
+	}
+	resp, err := r.client.get(r.ctx, obj.Key, offset, p.ETag)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	skip := int64(0)
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		// 服务端不支持 Range 时同样需要跳过已经读取的部分
+		skip = offset
+	case http.StatusPreconditionFailed:
+		// 对象在两次读取之间被替换
+		log.Warnf("Runner[%v] %q %v has been replaced, read it from the beginning", r.meta.RunnerName, r.Name(), obj.Key)
+		r.fetched[obj.Key] = Progress{}
+		return r.fetch(object{Key: obj.Key})
+	case http.StatusRequestedRangeNotSatisfiable:
+		// 已经读到末尾
+		p.Done = true
+		r.fetched[obj.Key] = p
+		r.send(readInfo{key: obj.Key, progress: p, marker: true})
+		return nil
+	default:
+		return responseError(resp)
+	}
+	p.ETag = strings.Trim(resp.Header.Get("ETag"), `"`)
+
+	br := bufio.NewReader(resp.Body)
+	if skip > 0 {
+		if _, err = io.CopyN(ioutil.Discard, br, skip); err != nil {
+			return fmt.Errorf("skip %d bytes error: %v", skip, err)
+		}
+	}
+	if p.Offset == 0 {
+		magic, _ := br.Peek(2)
+		p.Gzip = len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b
+	}
+	if p.Gzip {
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gr.Close()
+		if p.Offset > 0 {
+			if _, err = io.CopyN(ioutil.Discard, gr, p.Offset); err != nil {
+				return fmt.Errorf("skip %d bytes error: %v", p.Offset, err)
+			}
+		}
+		br = bufio.NewReader(gr)
+	}
+
+	for {
+		line, err := br.ReadString('\n')
+		if len(line) > 0 {
+			p.Offset += int64(len(line))
+			r.fetched[obj.Key] = p
+			if !r.send(readInfo{key: obj.Key, line: strings.TrimRight(line, "\r\n"), progress: p}) {
+				return nil
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	p.Done = true
+	r.fetched[obj.Key] = p
+	r.send(readInfo{key: obj.Key, progress: p, marker: true})
+	log.Infof("Runner[%v] %q %v has been read done, total %d bytes", r.meta.RunnerName, r.Name(), obj.Key, p.Offset)
+	return nil
+}
+
+// send 发送数据，reader 关闭时返回 false
+func (r *Reader) send(info readInfo) bool {
+	select {
+	case <-r.stopChan:
+		return false
+	case r.readChan <- info:
+		return true
+	}
+}
+
+// s3Event S3 事件通知，经过 SNS 转发时原始通知在 Message 中
+type s3Event struct {
+	Message string `json:"Message"`
+	Records []struct {
+		EventName string `json:"eventName"`
+		S3        struct {
+			Bucket struct {
+				Name string `json:"name"`
+			} `json:"bucket"`
+			Object struct {
+				Key  string `json:"key"`
+				ETag string `json:"eTag"`
+			} `json:"object"`
+		} `json:"s3"`
+	} `json:"Records"`
+}
+
+// ParseEvent 解析 S3 事件通知中 bucket 内新创建的对象，事件中的 key 经过了 url 编码
+func ParseEvent(body, bucket string) ([]object, error) {
+	var event s3Event
+	if err := json.Unmarshal([]byte(body), &event); err != nil {
+		return nil, err
+	}
+	if len(event.Records) == 0 && event.Message != "" {
+		return ParseEvent(event.Message, bucket)
+	}
+	var objects []object
+	for _, rec := range event.Records {
+		if !strings.HasPrefix(rec.EventName, "ObjectCreated:") && !strings.HasPrefix(rec.EventName, "s3:ObjectCreated:") {
+			continue
+		}
+		if rec.S3.Bucket.Name != bucket {
+			continue
+		}
+		key, err := url.QueryUnescape(rec.S3.Object.Key)
+		if err != nil {
+			return objects, err
+		}
+		objects = append(objects, object{Key: key, ETag: rec.S3.Object.ETag})
+	}
+	return objects, nil
+}
+
+// consumeEvents 从 SQS 队列获取对象创建的事件通知，交给 run 立即读取，无法解析的消息同样会被删除
+func (r *Reader) consumeEvents() {
+	for {
+		select {
+		case <-r.stopChan:
+			return
+		default:
+		}
+		messages, err := r.client.receive(r.ctx, r.queueURL, sqsWaitTime)
+		if err != nil {
+			if r.isStopping() || r.hasStopped() {
+				return
+			}
+			r.onError(fmt.Errorf("receive messages from %v error: %v", r.queueURL, err))
+			select {
+			case <-r.stopChan:
+				return
+			case <-time.After(sqsRetryInterval):
+			}
+			continue
+		}
+		for _, msg := range messages {
+			objects, err := ParseEvent(msg.Body, r.bucket)
+			if err != nil {
+				log.Warnf("Runner[%v] %q ignore invalid s3 event %q: %v", r.meta.RunnerName, r.Name(), msg.Body, err)
+			}
+			if !r.notifyObjects(objects) {
+				return
+			}
+			if err = r.client.deleteMessage(r.ctx, r.queueURL, msg.ReceiptHandle); err != nil && !r.isStopping() && !r.hasStopped() {
+				log.Errorf("Runner[%v] %q delete message from %v error: %v", r.meta.RunnerName, r.Name(), r.queueURL, err)
+			}
+		}
+	}
+}
+
+func (r *Reader) Source() string {
+	r.progressMux.Lock()
+	defer r.progressMux.Unlock()
+	if r.currentKey != "" {
+		return "s3://" + r.bucket + "/" + r.currentKey
+	}
+	return r.Name()
+}
+
+func (r *Reader) ReadLine() (string, error) {
+	timer := time.NewTimer(time.Second)
+	defer timer.Stop()
+	for {
+		select {
+		case info := <-r.readChan:
+			r.progressMux.Lock()
+			if info.remove {
+				delete(r.progress, info.key)
+			} else {
+				r.progress[info.key] = info.progress
+			}
+			if !info.marker && !info.remove {
+				r.currentKey = info.key
+			}
+			r.progressMux.Unlock()
+			if info.marker || info.remove {
+				continue
+			}
+			return info.line, nil
+		case err := <-r.errChan:
+			return "", err
+		case <-timer.C:
+			return "", nil
+		}
+	}
+}
+
+func (r *Reader) Status() StatsInfo {
+	r.statsLock.RLock()
+	defer r.statsLock.RUnlock()
+	return r.stats
+}
+
+func (r *Reader) SyncMeta() {
+	r.progressMux.Lock()
+	defer r.progressMux.Unlock()
+	if err := WriteProgress(r.meta.Dir, r.progress); err != nil {
+		log.Errorf("Runner[%v] %v SyncMeta error %v", r.meta.RunnerName, r.Name(), err)
+	}
+}
+
+func (r *Reader) Close() error {
+	if !atomic.CompareAndSwapInt32(&r.status, StatusRunning, StatusStopping) {
+		log.Warnf("Runner[%v] reader %q is not running, close operation ignored", r.meta.RunnerName, r.Name())
+		return nil
+	}
+	log.Debugf("Runner[%v] %q daemon is stopping", r.meta.RunnerName, r.Name())
+	close(r.stopChan)
+	// 打断进行中的请求
+	r.cancel()
+	r.closeNotifiers()
+	return nil
+}
@@ -0,0 +1,375 @@
+package s3
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/reader"
+	. "github.com/qiniu/logkit/reader/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+// fakeStore 模拟 S3 的 ListObjects、GetObject 以及 SQS 接口，每页最多返回 2 个对象
+type fakeStore struct {
+	mux      sync.Mutex
+	objects  map[string][]byte
+	messages []string
+	deleted  []string
+}
+
+func etagOf(data []byte) string {
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *fakeStore) put(key string, data []byte) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.objects[key] = data
+}
+
+func (s *fakeStore) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !strings.HasPrefix(req.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=ak/") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if req.Method == http.MethodPost {
+		req.ParseForm()
+		s.mux.Lock()
+		defer s.mux.Unlock()
+		switch req.Form.Get("Action") {
+		case "ReceiveMessage":
+			if len(s.messages) == 0 {
+				// 模拟长轮询
+				s.mux.Unlock()
+				time.Sleep(50 * time.Millisecond)
+				s.mux.Lock()
+			}
+			// 客户端已经放弃的请求不消费消息
+			if req.Context().Err() != nil {
+				return
+			}
+			var buf bytes.Buffer
+			buf.WriteString("<ReceiveMessageResponse><ReceiveMessageResult>")
+			for i, body := range s.messages {
+				fmt.Fprintf(&buf, "<Message><ReceiptHandle>handle-%d</ReceiptHandle><Body>%s</Body></Message>", i, strings.Replace(body, "\"", "&quot;", -1))
+			}
+			buf.WriteString("</ReceiveMessageResult></ReceiveMessageResponse>")
+			s.messages = nil
+			w.Write(buf.Bytes())
+		case "DeleteMessage":
+			s.deleted = append(s.deleted, req.Form.Get("ReceiptHandle"))
+		}
+		return
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if req.URL.Path == "/bucket/" {
+		prefix, marker := req.URL.Query().Get("prefix"), req.URL.Query().Get("marker")
+		var keys []string
+		for key := range s.objects {
+			if strings.HasPrefix(key, prefix) && key > marker {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		truncated := len(keys) > 2
+		if truncated {
+			keys = keys[:2]
+		}
+		var buf bytes.Buffer
+		fmt.Fprintf(&buf, "<ListBucketResult><IsTruncated>%v</IsTruncated>", truncated)
+		for _, key := range keys {
+			fmt.Fprintf(&buf, "<Contents><Key>%s</Key><ETag>&quot;%s&quot;</ETag><Size>%d</Size></Contents>", key, etagOf(s.objects[key]), len(s.objects[key]))
+		}
+		buf.WriteString("</ListBucketResult>")
+		w.Write(buf.Bytes())
+		return
+	}
+
+	data, ok := s.objects[strings.TrimPrefix(req.URL.Path, "/bucket/")]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	etag := `"` + etagOf(data) + `"`
+	if match := req.Header.Get("If-Match"); match != "" && match != etag {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+	w.Header().Set("ETag", etag)
+	if rng := req.Header.Get("Range"); rng != "" {
+		offset, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(rng, "bytes="), "-"))
+		if offset >= len(data) {
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(data[offset:])
+		return
+	}
+	w.Write(data)
+}
+
+func gzipData(t *testing.T, data string) []byte {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	_, err := gw.Write([]byte(data))
+	assert.NoError(t, err)
+	assert.NoError(t, gw.Close())
+	return buf.Bytes()
+}
+
+func readLines(t *testing.T, r *Reader, n int) []string {
+	var lines []string
+	for i := 0; i < 20 && len(lines) < n; i++ {
+		line, err := r.ReadLine()
+		assert.NoError(t, err)
+		if line != "" {
+			lines = append(lines, r.Source()+" "+line)
+		}
+	}
+	return lines
+}
+
+// drain 读取已经读完的对象的进度标记
+func drain(t *testing.T, r *Reader) {
+	line, err := r.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "", line)
+}
+
+func TestS3Reader(t *testing.T) {
+	store := &fakeStore{objects: map[string][]byte{
+		"logs/a.log":     []byte("a1\na2\n"),
+		"logs/b.log.gz":  gzipData(t, "b1\nb2\n"),
+		"logs/c.txt":     []byte("ignored\n"),
+		"logs/sub/":      nil,
+		"logs/sub/d.log": []byte("d1\r\n"),
+		"other/e.log":    []byte("ignored\n"),
+	}}
+	server := httptest.NewServer(store)
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "TestS3Reader")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := conf.MapConf{
+		KeyMetaPath:      dir,
+		KeyMode:          ModeS3,
+		KeyRunnerName:    "TestS3Reader",
+		KeyS3Endpoint:    server.URL,
+		KeyS3PathStyle:   "true",
+		KeyS3AccessKey:   "ak",
+		KeyS3SecretKey:   "sk",
+		KeyS3Bucket:      "bucket",
+		KeyS3Prefix:      "logs/",
+		KeyS3Pattern:     "*.log*",
+		KeyS3Interval:    "1h",
+		KeyS3SQSQueueURL: server.URL + "/queue",
+	}
+	newReader := func() *Reader {
+		meta, err := reader.NewMetaWithConf(c)
+		assert.NoError(t, err)
+		r, err := NewReader(meta, c)
+		assert.NoError(t, err)
+		assert.NoError(t, r.(*Reader).Start())
+		return r.(*Reader)
+	}
+
+	r := newReader()
+	assert.Equal(t, []string{
+		"s3://bucket/logs/a.log a1",
+		"s3://bucket/logs/a.log a2",
+		"s3://bucket/logs/b.log.gz b1",
+		"s3://bucket/logs/b.log.gz b2",
+		"s3://bucket/logs/sub/d.log d1",
+	}, readLines(t, r, 5))
+	drain(t, r)
+	r.SyncMeta()
+	assert.NoError(t, r.Close())
+
+	progress, err := RestoreProgress(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, Progress{Offset: 6, ETag: etagOf(store.objects["logs/b.log.gz"]), Gzip: true, Done: true}, progress["logs/b.log.gz"])
+
+	// 没有读完的对象从上次的位置继续读取，被替换的对象从头读取，删除的对象不再记录进度
+	store.put("logs/a.log", []byte("a1\na2\na3\n"))
+	store.put("logs/f.log", []byte("f1\nf2\n"))
+	store.mux.Lock()
+	delete(store.objects, "logs/sub/d.log")
+	store.mux.Unlock()
+	progress["logs/f.log"] = Progress{Offset: 3, ETag: etagOf([]byte("f1\nf2\n"))}
+	assert.NoError(t, WriteProgress(dir, progress))
+
+	r = newReader()
+	assert.Equal(t, []string{
+		"s3://bucket/logs/a.log a1",
+		"s3://bucket/logs/a.log a2",
+		"s3://bucket/logs/a.log a3",
+		"s3://bucket/logs/f.log f2",
+	}, readLines(t, r, 4))
+
+	// SQS 通知的新对象不需要等待下一次列举
+	store.put("logs/g.log", []byte("g1\n"))
+	store.mux.Lock()
+	store.messages = []string{
+		`{"Records":[{"eventName":"ObjectCreated:Put","s3":{"bucket":{"name":"bucket"},"object":{"key":"logs/g.log"}}}]}`,
+		`not json`,
+	}
+	store.mux.Unlock()
+	assert.Equal(t, []string{"s3://bucket/logs/g.log g1"}, readLines(t, r, 1))
+	drain(t, r)
+	r.SyncMeta()
+	assert.NoError(t, r.Close())
+	time.Sleep(100 * time.Millisecond)
+
+	store.mux.Lock()
+	assert.Equal(t, []string{"handle-0", "handle-1"}, store.deleted)
+	store.mux.Unlock()
+	progress, err = RestoreProgress(dir)
+	assert.NoError(t, err)
+	_, ok := progress["logs/sub/d.log"]
+	assert.False(t, ok)
+	assert.True(t, progress["logs/g.log"].Done)
+
+	c[KeyS3Pattern] = "["
+	_, err = NewReader(nil, c)
+	assert.Error(t, err)
+}
+
+// fakeNATS 模拟 MinIO 的 NATS 通知目标，客户端订阅后向其推送 events
+func fakeNATS(t *testing.T, events chan string) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		fmt.Fprintf(conn, "INFO {\"server_id\":\"fake\",\"version\":\"2.10.0\"}\r\n")
+		br := bufio.NewReader(conn)
+		for {
+			line, err := br.ReadString('\n')
+			if err != nil {
+				return
+			}
+			fields := strings.Fields(line)
+			switch fields[0] {
+			case "PING":
+				fmt.Fprintf(conn, "PONG\r\n")
+			case "SUB":
+				go func() {
+					for event := range events {
+						fmt.Fprintf(conn, "MSG %s %s %d\r\n%s\r\n", fields[1], fields[len(fields)-1], len(event), event)
+					}
+				}()
+			}
+		}
+	}()
+	return ln
+}
+
+func TestS3Notify(t *testing.T) {
+	store := &fakeStore{objects: map[string][]byte{"logs/a.log": []byte("a1\n")}}
+	server := httptest.NewServer(store)
+	defer server.Close()
+	events := make(chan string, 1)
+	defer close(events)
+	ln := fakeNATS(t, events)
+	defer ln.Close()
+
+	dir, err := ioutil.TempDir("", "TestS3Notify")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	c := conf.MapConf{
+		KeyMetaPath:               dir,
+		KeyMode:                   ModeS3,
+		KeyRunnerName:             "TestS3Notify",
+		KeyS3Endpoint:             server.URL,
+		KeyS3PathStyle:            "true",
+		KeyS3AccessKey:            "ak",
+		KeyS3SecretKey:            "sk",
+		KeyS3Bucket:               "bucket",
+		KeyS3Interval:             "1h",
+		KeyS3NotifyWebhookAddress: "127.0.0.1:0",
+		KeyS3NotifyWebhookToken:   "secret",
+		KeyS3NotifyNATSServers:    ln.Addr().String(),
+		KeyS3NotifyNATSSubject:    "minio.events",
+	}
+	meta, err := reader.NewMetaWithConf(c)
+	assert.NoError(t, err)
+	rd, err := NewReader(meta, c)
+	assert.NoError(t, err)
+	r := rd.(*Reader)
+	assert.NoError(t, r.Start())
+	defer r.Close()
+	assert.Equal(t, []string{"s3://bucket/logs/a.log a1"}, readLines(t, r, 1))
+
+	webhook := func(method, auth, body string) int {
+		req := httptest.NewRequest(method, "/", strings.NewReader(body))
+		req.Header.Set("Authorization", auth)
+		w := httptest.NewRecorder()
+		r.handleWebhook(w, req)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusUnauthorized, webhook(http.MethodPost, "wrong", "{}"))
+	assert.Equal(t, http.StatusOK, webhook(http.MethodHead, "secret", ""))
+	assert.Equal(t, http.StatusBadRequest, webhook(http.MethodPost, "secret", "not json"))
+
+	// Ceph RGW HTTP endpoint 推送的通知
+	store.put("logs/b.log", []byte("b1\n"))
+	assert.Equal(t, http.StatusOK, webhook(http.MethodPost, "Bearer secret",
+		`{"Records":[{"eventVersion":"2.2","eventSource":"ceph:s3","eventName":"ObjectCreated:Put","s3":{"bucket":{"name":"bucket"},"object":{"key":"logs/b.log"}}}]}`))
+	assert.Equal(t, []string{"s3://bucket/logs/b.log b1"}, readLines(t, r, 1))
+
+	// MinIO 发布到 NATS 的通知
+	store.put("logs/c.log", []byte("c1\n"))
+	events <- `{"EventName":"s3:ObjectCreated:Put","Key":"bucket/logs/c.log","Records":[{"eventName":"s3:ObjectCreated:Put","s3":{"bucket":{"name":"bucket"},"object":{"key":"logs%2Fc.log"}}}]}`
+	assert.Equal(t, []string{"s3://bucket/logs/c.log c1"}, readLines(t, r, 1))
+
+	delete(c, KeyS3NotifyNATSServers)
+	_, err = NewReader(meta, c)
+	assert.Error(t, err)
+}
+
+func TestParseEvent(t *testing.T) {
+	objects, err := ParseEvent(`{"Records":[
+		{"eventName":"ObjectCreated:Put","s3":{"bucket":{"name":"bucket"},"object":{"key":"logs/a+b%3D1.log","eTag":"abc"}}},
+		{"eventName":"ObjectRemoved:Delete","s3":{"bucket":{"name":"bucket"},"object":{"key":"logs/c.log"}}},
+		{"eventName":"s3:ObjectCreated:Put","s3":{"bucket":{"name":"bucket"},"object":{"key":"logs/minio.log"}}},
+		{"eventName":"ObjectCreated:Put","s3":{"bucket":{"name":"other"},"object":{"key":"logs/d.log"}}}]}`, "bucket")
+	assert.NoError(t, err)
+	assert.Equal(t, []object{{Key: "logs/a b=1.log", ETag: "abc"}, {Key: "logs/minio.log"}}, objects)
+
+	// 经过 SNS 转发的通知
+	objects, err = ParseEvent(`{"Type":"Notification","Message":"{\"Records\":[{\"eventName\":\"ObjectCreated:Post\",\"s3\":{\"bucket\":{\"name\":\"bucket\"},\"object\":{\"key\":\"x.log\"}}}]}"}`, "bucket")
+	assert.NoError(t, err)
+	assert.Equal(t, []object{{Key: "x.log"}}, objects)
+
+	// 测试事件
+	objects, err = ParseEvent(`{"Service":"Amazon S3","Event":"s3:TestEvent"}`, "bucket")
+	assert.NoError(t, err)
+	assert.Len(t, objects, 0)
+}
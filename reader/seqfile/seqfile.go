@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -93,6 +94,7 @@ func getStartFile(path, whence string, meta *reader.Meta, sf *SeqFile) (f *os.Fi
 		}
 	} else {
 		log.Debugf("%v restore meta success", dir)
+		currFile, offset = restoreRotatedFile(meta, dir, currFile, offset)
 	}
 	f, err = os.Open(currFile)
 	if err != nil {
@@ -106,6 +108,40 @@ func getStartFile(path, whence string, meta *reader.Meta, sf *SeqFile) (f *os.Fi
 	return
 }
 
+// restoreRotatedFile 根据 meta 中记录的文件标识判断 currFile 在 logkit 停止期间是否被改名轮转，
+// 被改名的文件仍在目录中时从记录的 offset 继续读取，否则 currFile 为新文件，从头读取
+func restoreRotatedFile(meta *reader.Meta, dir, currFile string, offset int64) (string, int64) {
+	rec, err := meta.ReadFileID()
+	if err != nil {
+		log.Warnf("Runner[%v] read file id from meta error %v, ignore...", meta.RunnerName, err)
+		return currFile, offset
+	}
+	if rec.ID == "" || rec.Path != currFile {
+		return currFile, offset
+	}
+	id, err := utilsos.GetFileIDByPath(currFile)
+	if err != nil || id.String() == rec.ID {
+		return currFile, offset
+	}
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		log.Warnf("Runner[%v] read dir %v error %v, ignore...", meta.RunnerName, dir, err)
+		return currFile, offset
+	}
+	for _, fi := range fis {
+		if fi.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, fi.Name())
+		if fid, err := utilsos.GetFileIDByPath(path); err == nil && fid.String() == rec.ID {
+			log.Infof("Runner[%v] %v was rotated to %v, continue reading from offset %d", meta.RunnerName, currFile, path, rec.Offset)
+			return path, rec.Offset
+		}
+	}
+	log.Infof("Runner[%v] %v was rotated and the old file is not found, read the new file from the beginning", meta.RunnerName, currFile)
+	return currFile, 0
+}
+
 func NewSeqFile(meta *reader.Meta, path string, ignoreHidden, newFileNewLine bool, suffixes []string, validFileRegex, whence string, expireMap map[string]int64, inodeSensitive bool) (sf *SeqFile, err error) {
 	sf = &SeqFile{
 		ignoreFileSuffix: suffixes,
@@ -305,7 +341,7 @@ func (sf *SeqFile) Read(p []byte) (n int, err error) {
 			sf.hasSkiped = false
 		}
 		n1, err = sf.ratereader.Read(p[n:])
-		if err != nil && strings.Contains(err.Error(), "stale NFS file handle") {
+		if IsStaleFileHandle(err) {
 			nerr := sf.reopenForESTALE()
 			if nerr != nil {
 				log.Errorf("Runner[%v] %v meet eror %v reopen error %v", sf.meta.RunnerName, sf.dir, err, nerr)
@@ -631,9 +667,20 @@ func (sf *SeqFile) SyncMeta() (err error) {
 		log.Debugf("Runner[%v] %v was just syncd %v %v ignore it...", sf.meta.RunnerName, sf.Name(), sf.lastSyncPath, sf.lastSyncOffset)
 		return nil
 	}
+	if err = sf.meta.WriteOffset(sf.currFile, sf.offset); err != nil {
+		return err
+	}
 	sf.lastSyncOffset = sf.offset
 	sf.lastSyncPath = sf.currFile
-	return sf.meta.WriteOffset(sf.currFile, sf.offset)
+	if sf.f == nil {
+		return nil
+	}
+	// 同时记录文件标识，重启时判断 currFile 是否已经被改名轮转
+	id, err := utilsos.GetFileIDByFile(sf.f)
+	if err != nil || id.IsZero() {
+		return nil
+	}
+	return sf.meta.WriteFileID(reader.FileIDRecord{ID: id.String(), Path: sf.currFile, Offset: sf.offset})
 }
 
 func (sf *SeqFile) Lag() (rl *LagInfo, err error) {
@@ -332,13 +332,13 @@ func TestLag(t *testing.T) {
 	CreateDir()
 	rl, err := sf.Lag()
 	assert.NoError(t, err)
-	assert.Equal(t, &LagInfo{0, "bytes", 0, 0}, rl)
+	assert.Equal(t, &LagInfo{0, "bytes", 0, 0, nil}, rl)
 	createQiniuLogFile(Dir)
 	createInvalidSuffixFile(Dir)
 
 	rl, err = sf.Lag()
 	assert.NoError(t, err)
-	assert.Equal(t, &LagInfo{8, "bytes", 0, 0}, rl)
+	assert.Equal(t, &LagInfo{8, "bytes", 0, 0, nil}, rl)
 }
 
 func Test_NewFileNewLine2(t *testing.T) {
@@ -550,3 +550,49 @@ func Test_deleteNotExist(t *testing.T) {
 		}
 	}
 }
+
+func TestRestoreRotatedFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestRestoreRotatedFile")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	logDir := filepath.Join(dir, "logs")
+	metaDir := filepath.Join(dir, "meta")
+	assert.NoError(t, os.MkdirAll(logDir, DefaultDirPerm))
+	logFile := filepath.Join(logDir, "app.log")
+	assert.NoError(t, ioutil.WriteFile(logFile, []byte("abcdef\n"), DefaultFilePerm))
+
+	meta, err := reader.NewMeta(metaDir, metaDir, logDir, ModeDir, "", reader.DefautFileRetention)
+	assert.NoError(t, err)
+	sf, err := NewSeqFile(meta, logDir, false, false, nil, "*", WhenceOldest, nil, true)
+	assert.NoError(t, err)
+	buffer := make([]byte, 3)
+	_, err = sf.Read(buffer)
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", string(buffer))
+	assert.NoError(t, sf.SyncMeta())
+	assert.NoError(t, sf.Close())
+
+	// 停止期间 app.log 被改名，并创建了同名的新文件
+	assert.NoError(t, os.Rename(logFile, logFile+".1"))
+	assert.NoError(t, ioutil.WriteFile(logFile, []byte("xyz\n"), DefaultFilePerm))
+	sf, err = NewSeqFile(meta, logDir, false, false, nil, "*", WhenceOldest, nil, true)
+	assert.NoError(t, err)
+	assert.Equal(t, logFile+".1", sf.currFile)
+	buffer = make([]byte, 4)
+	_, err = sf.Read(buffer)
+	assert.NoError(t, err)
+	assert.Equal(t, "def\n", string(buffer))
+	assert.NoError(t, sf.SyncMeta())
+	assert.NoError(t, sf.Close())
+
+	// 改名后的文件已经不在目录中，新文件从头读取
+	assert.NoError(t, os.Remove(logFile+".1"))
+	assert.NoError(t, os.Rename(logFile, logFile+".1"))
+	assert.NoError(t, ioutil.WriteFile(logFile+".1", []byte("new\n"), DefaultFilePerm))
+	sf, err = NewSeqFile(meta, logDir, false, false, nil, "*", WhenceOldest, nil, true)
+	assert.NoError(t, err)
+	_, err = sf.Read(buffer)
+	assert.NoError(t, err)
+	assert.Equal(t, "new\n", string(buffer))
+	assert.NoError(t, sf.Close())
+}
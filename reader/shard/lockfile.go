@@ -0,0 +1,199 @@
+package shard
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const (
+	membersDir = "members"
+	locksDir   = "locks"
+	lockSuffix = ".lock"
+)
+
+// lease 锁文件和实例心跳文件的内容，Expire 为过期时间的 unix 纳秒数
+type lease struct {
+	Owner  string `json:"owner"`
+	Expire int64  `json:"expire"`
+}
+
+// lockFileBackend 使用共享目录中的文件保存心跳和租约，
+// 创建锁文件依赖 O_EXCL 的原子性，接管过期的锁文件依赖 rename 的原子性，NFSv3 及以上版本都可以保证
+type lockFileBackend struct {
+	membersDir string
+	locksDir   string
+}
+
+func newLockFileBackend(dir, group string) (*lockFileBackend, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("shard lock dir is empty")
+	}
+	b := &lockFileBackend{
+		membersDir: filepath.Join(dir, group, membersDir),
+		locksDir:   filepath.Join(dir, group, locksDir),
+	}
+	for _, d := range []string{b.membersDir, b.locksDir} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+func readLease(path string) (*lease, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	l := &lease{}
+	if err = json.Unmarshal(data, l); err != nil {
+		return nil, fmt.Errorf("parse lease file %s error %v", path, err)
+	}
+	return l, nil
+}
+
+// writeLease 先写临时文件再 rename，避免其他实例读到写了一半的内容
+func writeLease(path string, l lease) error {
+	data, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	tmp := path + "." + l.Owner + ".tmp"
+	if err = ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (b *lockFileBackend) heartbeat(id string, ttl time.Duration) error {
+	return writeLease(filepath.Join(b.membersDir, id), lease{Owner: id, Expire: time.Now().Add(ttl).UnixNano()})
+}
+
+func (b *lockFileBackend) members() ([]string, error) {
+	fis, err := ioutil.ReadDir(b.membersDir)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UnixNano()
+	var members []string
+	for _, fi := range fis {
+		if fi.IsDir() || filepath.Ext(fi.Name()) == ".tmp" {
+			continue
+		}
+		l, err := readLease(filepath.Join(b.membersDir, fi.Name()))
+		if err != nil || l.Expire < now {
+			continue
+		}
+		members = append(members, l.Owner)
+	}
+	return members, nil
+}
+
+func (b *lockFileBackend) lock(key, id string, ttl time.Duration) (bool, error) {
+	path := filepath.Join(b.locksDir, key+lockSuffix)
+	now := time.Now()
+	current := lease{Owner: id, Expire: now.Add(ttl).UnixNano()}
+	old, err := readLease(path)
+	if os.IsNotExist(err) {
+		return b.create(path, current)
+	}
+	if err != nil {
+		// 其他实例刚刚创建锁文件还没有写入内容，或者写入时异常退出，按照修改时间判断是否过期
+		fi, serr := os.Stat(path)
+		if serr != nil {
+			return false, err
+		}
+		if fi.ModTime().Add(ttl).After(now) {
+			return false, nil
+		}
+		old = &lease{}
+	}
+	if old.Expire >= now.UnixNano() {
+		if old.Owner != id {
+			return false, nil
+		}
+		// 自己持有并且没有过期，其他实例不会接管，可以直接覆盖
+		if err = writeLease(path, current); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+
+	// 租约已经过期，先把锁文件 rename 走，只有一个实例能够 rename 成功，
+	// rename 走的如果不是刚才读到的过期租约，说明其他实例已经接管，需要还回去
+	stale := path + "." + id + "." + strconv.FormatInt(now.UnixNano(), 10) + ".stale"
+	if err = os.Rename(path, stale); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	taken, err := readLease(stale)
+	if err != nil {
+		taken = &lease{}
+	}
+	if *taken != *old {
+		os.Rename(stale, path)
+		return false, nil
+	}
+	os.Remove(stale)
+	return b.create(path, current)
+}
+
+func (b *lockFileBackend) create(path string, l lease) (bool, error) {
+	data, err := json.Marshal(l)
+	if err != nil {
+		return false, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		if os.IsExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+		return false, err
+	}
+	return true, nil
+}
+
+func (b *lockFileBackend) unlock(key, id string) error {
+	path := filepath.Join(b.locksDir, key+lockSuffix)
+	l, err := readLease(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if l.Owner != id {
+		return nil
+	}
+	if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (b *lockFileBackend) leave(id string) error {
+	err := os.Remove(filepath.Join(b.membersDir, id))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (b *lockFileBackend) close() error {
+	return nil
+}
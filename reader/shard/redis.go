@@ -0,0 +1,94 @@
+package shard
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+const redisKeyPrefix = "logkit:shard:"
+
+// 持有者相同时续约，不存在时获取，否则返回 0
+var redisLockScript = `
+local v = redis.call('GET', KEYS[1])
+if v == false then
+	redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+	return 1
+end
+if v == ARGV[1] then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+	return 1
+end
+return 0
+`
+
+var redisUnlockScript = `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`
+
+// redisBackend 租约保存为带过期时间的 key，实例心跳保存在以过期时间为 score 的 sorted set 中
+type redisBackend struct {
+	client     *redis.Client
+	prefix     string
+	membersKey string
+}
+
+func newRedisBackend(address, password string, db int, group string) (*redisBackend, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     address,
+		DB:       db,
+		Password: password,
+	})
+	if err := client.Ping().Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+	prefix := redisKeyPrefix + group + ":"
+	return &redisBackend{
+		client:     client,
+		prefix:     prefix,
+		membersKey: prefix + "members",
+	}, nil
+}
+
+func toMillisecond(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10)
+}
+
+func (b *redisBackend) heartbeat(id string, ttl time.Duration) error {
+	now := time.Now()
+	expire := float64(now.Add(ttl).UnixNano() / int64(time.Millisecond))
+	if err := b.client.ZAdd(b.membersKey, redis.Z{Score: expire, Member: id}).Err(); err != nil {
+		return err
+	}
+	return b.client.ZRemRangeByScore(b.membersKey, "-inf", "("+toMillisecond(now)).Err()
+}
+
+func (b *redisBackend) members() ([]string, error) {
+	return b.client.ZRangeByScore(b.membersKey, redis.ZRangeBy{Min: toMillisecond(time.Now()), Max: "+inf"}).Result()
+}
+
+func (b *redisBackend) lock(key, id string, ttl time.Duration) (bool, error) {
+	ret, err := b.client.Eval(redisLockScript, []string{b.prefix + "lock:" + key}, id, int64(ttl/time.Millisecond)).Result()
+	if err != nil {
+		return false, err
+	}
+	n, _ := ret.(int64)
+	return n == 1, nil
+}
+
+func (b *redisBackend) unlock(key, id string) error {
+	return b.client.Eval(redisUnlockScript, []string{b.prefix + "lock:" + key}, id).Err()
+}
+
+func (b *redisBackend) leave(id string) error {
+	return b.client.ZRem(b.membersKey, id).Err()
+}
+
+func (b *redisBackend) close() error {
+	return b.client.Close()
+}
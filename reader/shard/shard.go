@@ -0,0 +1,334 @@
+package shard
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/qiniu/log"
+
+	"github.com/qiniu/logkit/conf"
+	. "github.com/qiniu/logkit/reader/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const DefaultLeaseTTL = 30 * time.Second
+
+// backend 保存实例的心跳和文件的租约，所有实例共享同一个 backend
+type backend interface {
+	// heartbeat 登记实例，ttl 时间内没有再次登记的实例视为已经退出
+	heartbeat(id string, ttl time.Duration) error
+	// members 返回当前存活的实例
+	members() ([]string, error)
+	// lock 获取或续约 key 的租约，被其他实例持有并且没有过期时返回 false
+	lock(key, id string, ttl time.Duration) (bool, error)
+	// unlock 释放自己持有的租约
+	unlock(key, id string) error
+	// leave 注销实例
+	leave(id string) error
+	close() error
+}
+
+// Coordinator 在多个读取同一批文件的 logkit 实例之间分配文件，每个文件同一时刻只属于一个实例。
+// 新文件通过 rendezvous hash 分配给存活实例中的一个，其他实例等待一个租约时长后如果文件仍然无人认领才会尝试获取，
+// 已经被认领的文件不会因为实例数量变化而迁移，持有者退出并且租约过期后才由其他实例接管。
+// 实例之间不共享读取进度，接管的实例按照 whence 的配置从头或者从尾部开始读取。
+type Coordinator struct {
+	runnerName string
+	id         string
+	ttl        time.Duration
+	backend    backend
+
+	mutex     sync.Mutex
+	owned     map[string]time.Time // path -> 上次成功续约的时间
+	firstSeen map[string]time.Time // 不是首选实例时，第一次尝试获取文件的时间
+	alive     []string
+
+	stopChan chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewCoordinator 根据配置创建 Coordinator，没有开启分片时返回 nil
+func NewCoordinator(runnerName string, c conf.MapConf, defaultGroup string) (*Coordinator, error) {
+	mode, _ := c.GetStringOr(KeyShardMode, ShardModeNone)
+	if mode == ShardModeNone || mode == "" {
+		return nil, nil
+	}
+	group, _ := c.GetStringOr(KeyShardGroup, "")
+	if group == "" {
+		group = defaultGroup
+	}
+	group = hashKey(group)
+	id, _ := c.GetStringOr(KeyShardInstanceID, "")
+	if id == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("get hostname for %q failed: %v", KeyShardInstanceID, err)
+		}
+		id = hostname + "-" + strconv.Itoa(os.Getpid())
+	}
+	ttlStr, _ := c.GetStringOr(KeyShardLeaseTTL, DefaultLeaseTTL.String())
+	ttl, err := time.ParseDuration(ttlStr)
+	if err != nil {
+		return nil, err
+	}
+	if ttl < time.Second {
+		return nil, fmt.Errorf("%q value %v is less than 1s", KeyShardLeaseTTL, ttl)
+	}
+
+	var b backend
+	switch mode {
+	case ShardModeLockFile:
+		dir, err := c.GetString(KeyShardLockDir)
+		if err != nil {
+			return nil, err
+		}
+		if b, err = newLockFileBackend(dir, group); err != nil {
+			return nil, err
+		}
+	case ShardModeRedis:
+		address, _ := c.GetStringOr(KeyShardRedisAddress, "127.0.0.1:6379")
+		password, _ := c.GetPasswordEnvStringOr(KeyShardRedisPassword, "")
+		db, _ := c.GetIntOr(KeyShardRedisDB, 0)
+		if b, err = newRedisBackend(address, password, db, group); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%q value %q is not supported", KeyShardMode, mode)
+	}
+	return newCoordinator(runnerName, id, ttl, b)
+}
+
+func newCoordinator(runnerName, id string, ttl time.Duration, b backend) (*Coordinator, error) {
+	if err := b.heartbeat(id, ttl); err != nil {
+		b.close()
+		return nil, err
+	}
+	co := &Coordinator{
+		runnerName: runnerName,
+		id:         id,
+		ttl:        ttl,
+		backend:    b,
+		owned:      make(map[string]time.Time),
+		firstSeen:  make(map[string]time.Time),
+		stopChan:   make(chan struct{}),
+	}
+	co.refreshMembers()
+	co.wg.Add(1)
+	go co.run()
+	return co, nil
+}
+
+// ID 返回本实例在分片组内的标识
+func (co *Coordinator) ID() string {
+	return co.id
+}
+
+func (co *Coordinator) run() {
+	defer co.wg.Done()
+	ticker := time.NewTicker(co.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-co.stopChan:
+			return
+		case <-ticker.C:
+		}
+		if err := co.backend.heartbeat(co.id, co.ttl); err != nil {
+			co.logError("shard instance %s heartbeat error %v", co.id, err)
+		}
+		co.refreshMembers()
+		co.renew()
+	}
+}
+
+func (co *Coordinator) refreshMembers() {
+	members, err := co.backend.members()
+	if err != nil {
+		co.logError("shard instance %s get members error %v", co.id, err)
+		return
+	}
+	co.mutex.Lock()
+	co.alive = members
+	co.mutex.Unlock()
+}
+
+// renew 续约所有持有的文件，被其他实例接管或者超过租约时长没有续约成功的文件不再属于自己
+func (co *Coordinator) renew() {
+	co.mutex.Lock()
+	paths := make([]string, 0, len(co.owned))
+	for path := range co.owned {
+		paths = append(paths, path)
+	}
+	co.mutex.Unlock()
+
+	for _, path := range paths {
+		ok, err := co.backend.lock(hashKey(path), co.id, co.ttl)
+		now := time.Now()
+		co.mutex.Lock()
+		last, held := co.owned[path]
+		if !held {
+			co.mutex.Unlock()
+			continue
+		}
+		switch {
+		case err == nil && ok:
+			co.owned[path] = now
+		case err == nil && !ok:
+			delete(co.owned, path)
+			log.Warnf("Runner[%s] shard instance %s lost the lease of %s to other instance", co.runnerName, co.id, path)
+		case now.Sub(last) >= co.ttl:
+			delete(co.owned, path)
+			log.Warnf("Runner[%s] shard instance %s renew the lease of %s error %v, lease expired", co.runnerName, co.id, path, err)
+		default:
+			co.logError("shard instance %s renew the lease of %s error %v, will retry", co.id, path, err)
+		}
+		co.mutex.Unlock()
+	}
+}
+
+// preferred 使用 rendezvous hash 从存活实例中选出文件的首选实例，实例增减时只影响少量文件
+func (co *Coordinator) preferred(path string) string {
+	var (
+		best      = co.id
+		bestScore = score(co.id, path)
+	)
+	for _, member := range co.alive {
+		if s := score(member, path); s > bestScore || (s == bestScore && member < best) {
+			best, bestScore = member, s
+		}
+	}
+	return best
+}
+
+func score(member, path string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(member))
+	h.Write([]byte{0})
+	h.Write([]byte(path))
+	return h.Sum64()
+}
+
+// Acquire 尝试获取文件的归属，返回文件是否属于本实例
+func (co *Coordinator) Acquire(path string) bool {
+	now := time.Now()
+	co.mutex.Lock()
+	if _, ok := co.owned[path]; ok {
+		co.mutex.Unlock()
+		return true
+	}
+	if preferred := co.preferred(path); preferred != co.id {
+		first, ok := co.firstSeen[path]
+		if !ok {
+			co.firstSeen[path] = now
+		}
+		// 首选实例在一个租约时长内没有认领，可能是达到了打开文件数的上限或者已经异常，由其他实例认领
+		if !ok || now.Sub(first) < co.ttl {
+			co.mutex.Unlock()
+			log.Debugf("Runner[%s] shard instance %s: %s is preferred by %s, ignore...", co.runnerName, co.id, path, preferred)
+			return false
+		}
+	}
+	co.mutex.Unlock()
+
+	ok, err := co.backend.lock(hashKey(path), co.id, co.ttl)
+	if err != nil {
+		co.logError("shard instance %s acquire the lease of %s error %v", co.id, path, err)
+		return false
+	}
+	if !ok {
+		log.Debugf("Runner[%s] shard instance %s: %s is owned by other instance, ignore...", co.runnerName, co.id, path)
+		return false
+	}
+	co.mutex.Lock()
+	co.owned[path] = time.Now()
+	delete(co.firstSeen, path)
+	co.mutex.Unlock()
+	return true
+}
+
+// Owned 判断文件是否仍然属于本实例
+func (co *Coordinator) Owned(path string) bool {
+	co.mutex.Lock()
+	defer co.mutex.Unlock()
+	_, ok := co.owned[path]
+	return ok
+}
+
+// Release 释放文件的归属，文件读完过期或者不再读取时调用
+func (co *Coordinator) Release(path string) {
+	co.mutex.Lock()
+	_, ok := co.owned[path]
+	delete(co.owned, path)
+	delete(co.firstSeen, path)
+	co.mutex.Unlock()
+	if !ok {
+		return
+	}
+	if err := co.backend.unlock(hashKey(path), co.id); err != nil {
+		co.logError("shard instance %s release the lease of %s error %v", co.id, path, err)
+	}
+}
+
+// Forget 清除没有再匹配到的文件的等待记录
+func (co *Coordinator) Forget(matched map[string]bool) {
+	co.mutex.Lock()
+	defer co.mutex.Unlock()
+	for path := range co.firstSeen {
+		if !matched[path] {
+			delete(co.firstSeen, path)
+		}
+	}
+}
+
+// Close 释放所有持有的文件并注销实例
+func (co *Coordinator) Close() error {
+	select {
+	case <-co.stopChan:
+		return nil
+	default:
+	}
+	close(co.stopChan)
+	co.wg.Wait()
+
+	co.mutex.Lock()
+	paths := make([]string, 0, len(co.owned))
+	for path := range co.owned {
+		paths = append(paths, path)
+	}
+	co.mutex.Unlock()
+	for _, path := range paths {
+		co.Release(path)
+	}
+	var errs []string
+	if err := co.backend.leave(co.id); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if err := co.backend.close(); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if len(errs) > 0 {
+		return errors.New(fmt.Sprint(errs))
+	}
+	return nil
+}
+
+func (co *Coordinator) logError(format string, v ...interface{}) {
+	v = append([]interface{}{co.runnerName}, v...)
+	if !IsSelfRunner(co.runnerName) {
+		log.Errorf("Runner[%s] "+format, v...)
+	} else {
+		log.Debugf("Runner[%s] "+format, v...)
+	}
+}
+
+// hashKey 将文件路径等转换为可以作为文件名或 redis key 的字符串
+func hashKey(s string) string {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return strconv.FormatUint(h.Sum64(), 16)
+}
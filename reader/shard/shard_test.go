@@ -0,0 +1,144 @@
+package shard
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/qiniu/logkit/conf"
+	. "github.com/qiniu/logkit/reader/config"
+)
+
+func newTestCoordinator(t *testing.T, dir, id string, ttl time.Duration) *Coordinator {
+	b, err := newLockFileBackend(dir, "group")
+	assert.NoError(t, err)
+	co, err := newCoordinator("TestShard", id, ttl, b)
+	assert.NoError(t, err)
+	return co
+}
+
+func TestNewCoordinator(t *testing.T) {
+	co, err := NewCoordinator("TestNewCoordinator", conf.MapConf{}, "/tmp/*.log")
+	assert.NoError(t, err)
+	assert.Nil(t, co)
+
+	_, err = NewCoordinator("TestNewCoordinator", conf.MapConf{KeyShardMode: ShardModeLockFile}, "/tmp/*.log")
+	assert.Error(t, err)
+	_, err = NewCoordinator("TestNewCoordinator", conf.MapConf{KeyShardMode: "zookeeper"}, "/tmp/*.log")
+	assert.Error(t, err)
+
+	dir, err := ioutil.TempDir("", "TestNewCoordinator")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	co, err = NewCoordinator("TestNewCoordinator", conf.MapConf{
+		KeyShardMode:    ShardModeLockFile,
+		KeyShardLockDir: dir,
+	}, "/tmp/*.log")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, co.ID())
+	assert.NoError(t, co.Close())
+}
+
+func TestCoordinatorBalance(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestCoordinatorBalance")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	a := newTestCoordinator(t, dir, "a", time.Minute)
+	b := newTestCoordinator(t, dir, "b", time.Minute)
+	defer b.Close()
+	a.refreshMembers()
+	b.refreshMembers()
+
+	owners := make(map[string]int)
+	for i := 0; i < 100; i++ {
+		path := fmt.Sprintf("/logs/%d.log", i)
+		okA := a.Acquire(path)
+		okB := b.Acquire(path)
+		assert.False(t, okA && okB, path)
+		assert.True(t, okA || okB, path)
+		if okA {
+			owners["a"]++
+		} else {
+			owners["b"]++
+		}
+		// 再次获取时结果不变
+		assert.Equal(t, okA, a.Acquire(path))
+		assert.Equal(t, okB, b.Acquire(path))
+	}
+	assert.True(t, owners["a"] > 20 && owners["b"] > 20, fmt.Sprint(owners))
+
+	// a 退出后释放所有文件，b 不再等待首选实例
+	assert.NoError(t, a.Close())
+	b.refreshMembers()
+	for i := 0; i < 100; i++ {
+		assert.True(t, b.Acquire(fmt.Sprintf("/logs/%d.log", i)))
+	}
+}
+
+func TestLockFileTakeover(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestLockFileTakeover")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	b, err := newLockFileBackend(dir, "group")
+	assert.NoError(t, err)
+	ok, err := b.lock("key", "a", 100*time.Millisecond)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = b.lock("key", "b", 100*time.Millisecond)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	// 续约
+	ok, err = b.lock("key", "a", 100*time.Millisecond)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	time.Sleep(150 * time.Millisecond)
+	ok, err = b.lock("key", "b", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = b.lock("key", "a", time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// 只能释放自己持有的租约
+	assert.NoError(t, b.unlock("key", "a"))
+	l, err := readLease(filepath.Join(b.locksDir, "key"+lockSuffix))
+	assert.NoError(t, err)
+	assert.Equal(t, "b", l.Owner)
+	assert.NoError(t, b.unlock("key", "b"))
+	_, err = os.Stat(filepath.Join(b.locksDir, "key"+lockSuffix))
+	assert.True(t, os.IsNotExist(err))
+
+	// 刚创建还没有写入内容的锁文件视为被持有
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(b.locksDir, "empty"+lockSuffix), nil, 0644))
+	ok, err = b.lock("empty", "a", time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = b.lock("empty", "a", time.Nanosecond)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestCoordinatorLostLease(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestCoordinatorLostLease")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	a := newTestCoordinator(t, dir, "a", time.Minute)
+	defer a.Close()
+	assert.True(t, a.Acquire("/logs/a.log"))
+	assert.True(t, a.Owned("/logs/a.log"))
+
+	// 模拟租约过期后被其他实例接管
+	assert.NoError(t, writeLease(filepath.Join(a.backend.(*lockFileBackend).locksDir, hashKey("/logs/a.log")+lockSuffix),
+		lease{Owner: "b", Expire: time.Now().Add(time.Minute).UnixNano()}))
+	a.renew()
+	assert.False(t, a.Owned("/logs/a.log"))
+}
@@ -29,9 +29,17 @@ type SingleFile struct {
 	ratereader io.ReadCloser
 	offset     int64 // 当前处理文件offset
 	stopped    int32
+	network    bool // 文件在网络文件系统上，使用文件指纹代替 inode 判断轮转
+
+	// 开启文件标识追踪后记录当前打开文件的设备号与 inode，同步 meta 时一起记录，
+	// 文件被改名轮转时通过 onRotate 通知被轮转文件的标识和已经读取的 offset
+	trackID  bool
+	fileID   utilsos.FileID
+	onRotate func(id utilsos.FileID, offset int64)
 
 	lastSyncPath   string
 	lastSyncOffset int64
+	lastSyncID     utilsos.FileID
 
 	mux  sync.Mutex
 	meta *reader.Meta // 记录offset的元数据
@@ -225,6 +233,34 @@ func (sf *SingleFile) Close() (err error) {
 	return nil
 }
 
+// SetNetworkFS 设置文件是否在 NFS/CIFS 等网络文件系统上
+func (sf *SingleFile) SetNetworkFS(network bool) {
+	sf.mux.Lock()
+	defer sf.mux.Unlock()
+	sf.network = network
+}
+
+// TrackFileID 开启文件标识追踪，onRotate 可以为空
+func (sf *SingleFile) TrackFileID(onRotate func(id utilsos.FileID, offset int64)) error {
+	sf.mux.Lock()
+	defer sf.mux.Unlock()
+	id, err := utilsos.GetFileIdentityIDByFile(sf.f, sf.network)
+	if err != nil {
+		return err
+	}
+	sf.trackID = true
+	sf.fileID = id
+	sf.onRotate = onRotate
+	return nil
+}
+
+// FileID 返回当前打开文件的标识，没有开启文件标识追踪时返回空
+func (sf *SingleFile) FileID() utilsos.FileID {
+	sf.mux.Lock()
+	defer sf.mux.Unlock()
+	return sf.fileID
+}
+
 func (sf *SingleFile) detectMovedName(inode uint64) (name string) {
 	dir := filepath.Dir(sf.realpath)
 	fis, err := ioutil.ReadDir(dir)
@@ -241,7 +277,7 @@ func (sf *SingleFile) detectMovedName(inode uint64) (name string) {
 		if fi.IsDir() || !strings.HasPrefix(fi.Name(), sf.pfi.Name()) {
 			continue
 		}
-		newInode, err := utilsos.GetIdentifyIDByPath(filepath.Join(dir, fi.Name()))
+		newInode, err := utilsos.GetFileIdentityByPath(filepath.Join(dir, fi.Name()), sf.network)
 		if err != nil {
 			if !IsSelfRunner(sf.meta.RunnerName) {
 				log.Error(err)
@@ -259,11 +295,11 @@ func (sf *SingleFile) detectMovedName(inode uint64) (name string) {
 }
 
 func (sf *SingleFile) Reopen() (err error) {
-	newInode, err := utilsos.GetIdentifyIDByPath(sf.originpath)
+	newInode, err := utilsos.GetFileIdentityByPath(sf.originpath, sf.network)
 	if err != nil {
 		return
 	}
-	oldInode, err := utilsos.GetIdentifyIDByFile(sf.f)
+	oldInode, err := utilsos.GetFileIdentityByFile(sf.f, sf.network)
 	if err != nil {
 		return
 	}
@@ -271,6 +307,9 @@ func (sf *SingleFile) Reopen() (err error) {
 	if newInode == oldInode {
 		return
 	}
+	if sf.trackID && sf.onRotate != nil && !sf.fileID.IsZero() {
+		sf.onRotate(sf.fileID, sf.offset)
+	}
 	sf.f.Close()
 	sf.f = nil
 	detectStr := sf.detectMovedName(oldInode)
@@ -305,6 +344,12 @@ func (sf *SingleFile) Reopen() (err error) {
 		sf.ratereader = f
 	}
 	sf.offset = 0
+	if sf.trackID {
+		if sf.fileID, err = utilsos.GetFileIdentityIDByFile(f, sf.network); err != nil {
+			log.Warnf("Runner[%v] get file id of %s error %v", sf.meta.RunnerName, sf.originpath, err)
+			sf.fileID, err = utilsos.FileID{}, nil
+		}
+	}
 	return
 }
 
@@ -344,7 +389,7 @@ func (sf *SingleFile) Read(p []byte) (n int, err error) {
 	sf.mux.Lock()
 	defer sf.mux.Unlock()
 	n, err = sf.ratereader.Read(p)
-	if err != nil && strings.Contains(err.Error(), "stale NFS file handle") {
+	if IsStaleFileHandle(err) {
 		nerr := sf.reopenForESTALE()
 		if nerr != nil {
 			if !IsSelfRunner(sf.meta.RunnerName) {
@@ -376,14 +421,34 @@ func (sf *SingleFile) Read(p []byte) (n int, err error) {
 func (sf *SingleFile) SyncMeta() error {
 	sf.mux.Lock()
 	defer sf.mux.Unlock()
-	if sf.lastSyncOffset == sf.offset && sf.lastSyncPath == sf.originpath {
+	if sf.lastSyncOffset == sf.offset && sf.lastSyncPath == sf.originpath && sf.lastSyncID == sf.fileID {
 		log.Debugf("Runner[%v] %v was just syncd %v %v ignore it...", sf.meta.RunnerName, sf.Name(), sf.lastSyncPath, sf.lastSyncOffset)
 		return nil
 	}
 	log.Debugf("Runner[%v] %v Sync file success: %v", sf.meta.RunnerName, sf.Name(), sf.offset)
+	if err := sf.meta.WriteOffset(sf.originpath, sf.offset); err != nil {
+		return err
+	}
 	sf.lastSyncOffset = sf.offset
 	sf.lastSyncPath = sf.originpath
-	return sf.meta.WriteOffset(sf.originpath, sf.offset)
+	if !sf.trackID {
+		return nil
+	}
+	if sf.fileID.IsZero() {
+		// 网络文件系统上的文件内容不足以计算指纹时，等文件写入更多内容后再获取
+		if sf.fileID, _ = utilsos.GetFileIdentityIDByFile(sf.f, sf.network); sf.fileID.IsZero() {
+			return nil
+		}
+	}
+	sf.lastSyncID = sf.fileID
+	return sf.meta.WriteFileID(reader.FileIDRecord{ID: sf.fileID.String(), Path: sf.originpath, Offset: sf.offset})
+}
+
+// Offset 返回当前文件已经读取到的位置
+func (sf *SingleFile) Offset() int64 {
+	sf.mux.Lock()
+	defer sf.mux.Unlock()
+	return sf.offset
 }
 
 func (sf *SingleFile) Lag() (rl *LagInfo, err error) {
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"math"
 	"net"
+	"os"
 	"os/exec"
 	"strconv"
 	"strings"
@@ -22,6 +23,7 @@ import (
 	"github.com/qiniu/logkit/conf"
 	"github.com/qiniu/logkit/reader"
 	. "github.com/qiniu/logkit/reader/config"
+	"github.com/qiniu/logkit/utils/bundle"
 	. "github.com/qiniu/logkit/utils/models"
 )
 
@@ -81,6 +83,53 @@ type Reader struct {
 	Tables          []Table
 	Fields          []Field
 	ConnectionCache []snmpConnection
+
+	// MIB 数据包更新后按原始配置重新翻译 oid，只在 Gather 中使用
+	tableHost     string
+	rawTables     []Table
+	rawFields     []Field
+	mibGeneration int64
+}
+
+// mibGeneration SNMP MIB 数据包每次更新后加一，reader 发现变化后重新翻译 tables、fields 中的 oid
+var mibGeneration int64
+
+func init() {
+	bundle.RegisterUpdateHook(onBundleUpdate)
+}
+
+// onBundleUpdate 将更新后的 MIB 目录加入 snmptranslate 使用的 MIBDIRS，并清空 oid 翻译结果的缓存
+func onBundleUpdate(typ, path string) error {
+	if typ != bundle.TypeMIB {
+		return nil
+	}
+	if err := addMIBDir(path); err != nil {
+		return err
+	}
+	snmpTranslateCachesLock.Lock()
+	snmpTranslateCaches = nil
+	snmpTranslateCachesLock.Unlock()
+	snmpTableCachesLock.Lock()
+	snmpTableCaches = nil
+	snmpTableCachesLock.Unlock()
+	atomic.AddInt64(&mibGeneration, 1)
+	return nil
+}
+
+// addMIBDir 以 + 开头时 net-snmp 在默认的 MIB 目录之后查找 MIBDIRS 中的目录
+func addMIBDir(dir string) error {
+	dirs := os.Getenv("MIBDIRS")
+	for _, d := range strings.Split(strings.TrimPrefix(dirs, "+"), string(os.PathListSeparator)) {
+		if d == dir {
+			return nil
+		}
+	}
+	if dirs == "" {
+		dirs = "+" + dir
+	} else {
+		dirs += string(os.PathListSeparator) + dir
+	}
+	return os.Setenv("MIBDIRS", dirs)
 }
 
 var execCommand = exec.Command
@@ -151,6 +200,8 @@ func NewReader(meta *reader.Meta, c conf.MapConf) (reader.Reader, error) {
 	if len(tables) == 0 && len(fields) == 0 {
 		return nil, fmt.Errorf("'snmp_tables' and 'snmp_fields' are both empty, must have one of them")
 	}
+	rawTables, rawFields := copyTables(tables), copyFields(fields)
+	generation := atomic.LoadInt64(&mibGeneration)
 
 	for i := range tables {
 		if subErr := tables[i].init(tableHost); subErr != nil {
@@ -190,9 +241,54 @@ func NewReader(meta *reader.Meta, c conf.MapConf) (reader.Reader, error) {
 		Tables:          tables,
 		Fields:          fields,
 		ConnectionCache: make([]snmpConnection, len(agents)),
+		tableHost:       tableHost,
+		rawTables:       rawTables,
+		rawFields:       rawFields,
+		mibGeneration:   generation,
 	}, nil
 }
 
+func copyFields(fields []Field) []Field {
+	if fields == nil {
+		return nil
+	}
+	return append([]Field{}, fields...)
+}
+
+func copyTables(tables []Table) []Table {
+	ret := make([]Table, len(tables))
+	for i, t := range tables {
+		t.InheritTags = append([]string{}, t.InheritTags...)
+		t.Fields = copyFields(t.Fields)
+		ret[i] = t
+	}
+	return ret
+}
+
+// reloadMIB 在 MIB 数据包更新后按原始配置重新翻译 tables、fields，失败时继续使用原来的翻译结果
+func (r *Reader) reloadMIB() {
+	gen := atomic.LoadInt64(&mibGeneration)
+	if gen == r.mibGeneration || (len(r.rawTables) == 0 && len(r.rawFields) == 0) {
+		return
+	}
+	r.mibGeneration = gen
+	tables, fields := copyTables(r.rawTables), copyFields(r.rawFields)
+	for i := range tables {
+		if err := tables[i].init(r.tableHost); err != nil {
+			log.Errorf("Runner[%v] %q reload MIB for table %s error: %v, keep using the old translation", r.meta.RunnerName, r.Name(), tables[i].Name, err)
+			return
+		}
+	}
+	for i := range fields {
+		if err := fields[i].init(); err != nil {
+			log.Errorf("Runner[%v] %q reload MIB for field %s error: %v, keep using the old translation", r.meta.RunnerName, r.Name(), fields[i].Name, err)
+			return
+		}
+	}
+	r.Tables, r.Fields = tables, fields
+	log.Infof("Runner[%v] %q tables and fields are translated again with the updated MIB", r.meta.RunnerName, r.Name())
+}
+
 func (r *Reader) isStopping() bool {
 	return atomic.LoadInt32(&r.status) == StatusStopping
 }
@@ -422,6 +518,7 @@ func (r *Reader) Gather() error {
 		atomic.StoreInt32(&r.routineStatus, StatusInit)
 	}()
 
+	r.reloadMIB()
 	var wg sync.WaitGroup
 	datas := make([]Data, 0)
 	errChan := make(chan error, len(r.Agents))
@@ -4,6 +4,7 @@ package snmp
 import (
 	"fmt"
 	"net"
+	"os"
 	"os/exec"
 	"sync"
 	"testing"
@@ -15,6 +16,7 @@ import (
 	"github.com/qiniu/logkit/conf"
 	"github.com/qiniu/logkit/reader"
 	. "github.com/qiniu/logkit/reader/config"
+	"github.com/qiniu/logkit/utils/bundle"
 	. "github.com/qiniu/logkit/utils/models"
 )
 
@@ -153,6 +155,35 @@ func TestSnmpInit(t *testing.T) {
 	}, s.Fields[0])
 }
 
+func TestReloadMIB(t *testing.T) {
+	defer os.Setenv("MIBDIRS", os.Getenv("MIBDIRS"))
+	os.Unsetenv("MIBDIRS")
+	c := conf.MapConf{
+		"snmp_fields": `[{"field_oid": "TEST::hostname"}]`,
+	}
+	ss, err := NewReader(&reader.Meta{RunnerName: "TestReloadMIB"}, c)
+	assert.NoError(t, err)
+	s := ss.(*Reader)
+	assert.Equal(t, Field{Oid: ".1.0.0.1.1", Name: "hostname"}, s.Fields[0])
+
+	// 其他类型的数据包不影响已经翻译的结果
+	assert.NoError(t, onBundleUpdate(bundle.TypeGrok, "/tmp/patterns"))
+	s.reloadMIB()
+	assert.Equal(t, Field{Oid: ".1.0.0.1.1", Name: "hostname"}, s.Fields[0])
+
+	assert.NoError(t, onBundleUpdate(bundle.TypeMIB, "/tmp/mibs"))
+	assert.NoError(t, onBundleUpdate(bundle.TypeMIB, "/tmp/mibs"))
+	assert.Equal(t, "+/tmp/mibs", os.Getenv("MIBDIRS"))
+	assert.Nil(t, snmpTranslateCaches)
+	snmpTranslateCaches = map[string]snmpTranslateCache{
+		"TEST::hostname": {mibName: "TEST", oidNum: ".1.0.0.1.9", oidText: "host"},
+	}
+	s.reloadMIB()
+	assert.Equal(t, Field{Oid: ".1.0.0.1.9", Name: "host"}, s.Fields[0])
+	assert.Equal(t, []Field{{Oid: "TEST::hostname"}}, s.rawFields)
+	snmpTranslateCaches = nil
+}
+
 func TestSnmpInit_noTranslate(t *testing.T) {
 	// override execCommand so it returns exec.ErrNotFound
 	defer func(ec func(string, ...string) *exec.Cmd) { execCommand = ec }(execCommand)
@@ -0,0 +1,185 @@
+package flow
+
+import (
+	"encoding/hex"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+// 流量记录中的字段，NetFlow v5、v9、IPFIX 和 sFlow 中含义相同的信息使用相同的字段名
+const (
+	FieldSrcAddr       = "src_addr"
+	FieldDstAddr       = "dst_addr"
+	FieldNextHop       = "next_hop"
+	FieldSrcPort       = "src_port"
+	FieldDstPort       = "dst_port"
+	FieldProtocol      = "protocol"
+	FieldTOS           = "tos"
+	FieldTCPFlags      = "tcp_flags"
+	FieldBytes         = "bytes"
+	FieldPackets       = "packets"
+	FieldInput         = "input_snmp"
+	FieldOutput        = "output_snmp"
+	FieldSrcAS         = "src_as"
+	FieldDstAS         = "dst_as"
+	FieldSrcMask       = "src_mask"
+	FieldDstMask       = "dst_mask"
+	FieldSrcMac        = "src_mac"
+	FieldDstMac        = "dst_mac"
+	FieldSrcVlan       = "src_vlan"
+	FieldDstVlan       = "dst_vlan"
+	FieldFlowStart     = "flow_start"
+	FieldFlowEnd       = "flow_end"
+	FieldSamplingRate  = "sampling_rate"
+	FieldIPVersion     = "ip_version"
+	FieldEngineType    = "engine_type"
+	FieldEngineID      = "engine_id"
+	FieldSourceID      = "source_id"
+	FieldObservationID = "observation_domain_id"
+)
+
+type fieldKind int
+
+const (
+	kindUint fieldKind = iota
+	kindIP
+	kindMac
+	kindString
+	kindSeconds
+	kindMillis
+	kindSysUptime // 相对于设备启动时间的毫秒数
+)
+
+type fieldDef struct {
+	name string
+	kind fieldKind
+}
+
+// fieldDefs NetFlow v9 与 IPFIX 的字段定义，IPFIX 沿用了 NetFlow v9 的字段编号，
+// 未列出的字段输出为 field_<编号>
+var fieldDefs = map[uint16]fieldDef{
+	1:   {FieldBytes, kindUint},
+	2:   {FieldPackets, kindUint},
+	3:   {"flows", kindUint},
+	4:   {FieldProtocol, kindUint},
+	5:   {FieldTOS, kindUint},
+	6:   {FieldTCPFlags, kindUint},
+	7:   {FieldSrcPort, kindUint},
+	8:   {FieldSrcAddr, kindIP},
+	9:   {FieldSrcMask, kindUint},
+	10:  {FieldInput, kindUint},
+	11:  {FieldDstPort, kindUint},
+	12:  {FieldDstAddr, kindIP},
+	13:  {FieldDstMask, kindUint},
+	14:  {FieldOutput, kindUint},
+	15:  {FieldNextHop, kindIP},
+	16:  {FieldSrcAS, kindUint},
+	17:  {FieldDstAS, kindUint},
+	18:  {"bgp_next_hop", kindIP},
+	21:  {FieldFlowEnd, kindSysUptime},
+	22:  {FieldFlowStart, kindSysUptime},
+	23:  {"out_bytes", kindUint},
+	24:  {"out_packets", kindUint},
+	27:  {FieldSrcAddr, kindIP},
+	28:  {FieldDstAddr, kindIP},
+	29:  {FieldSrcMask, kindUint},
+	30:  {FieldDstMask, kindUint},
+	31:  {"flow_label", kindUint},
+	32:  {"icmp_type", kindUint},
+	34:  {FieldSamplingRate, kindUint},
+	35:  {"sampling_algorithm", kindUint},
+	38:  {FieldEngineType, kindUint},
+	39:  {FieldEngineID, kindUint},
+	56:  {FieldSrcMac, kindMac},
+	57:  {"out_dst_mac", kindMac},
+	58:  {FieldSrcVlan, kindUint},
+	59:  {FieldDstVlan, kindUint},
+	60:  {FieldIPVersion, kindUint},
+	61:  {"direction", kindUint},
+	62:  {FieldNextHop, kindIP},
+	63:  {"bgp_next_hop", kindIP},
+	80:  {FieldDstMac, kindMac},
+	81:  {"out_src_mac", kindMac},
+	82:  {"interface_name", kindString},
+	83:  {"interface_description", kindString},
+	85:  {"bytes_total", kindUint},
+	86:  {"packets_total", kindUint},
+	89:  {"forwarding_status", kindUint},
+	94:  {"application_description", kindString},
+	95:  {"application_id", kindUint},
+	96:  {"application_name", kindString},
+	136: {"flow_end_reason", kindUint},
+	148: {"flow_id", kindUint},
+	150: {FieldFlowStart, kindSeconds},
+	151: {FieldFlowEnd, kindSeconds},
+	152: {FieldFlowStart, kindMillis},
+	153: {FieldFlowEnd, kindMillis},
+	176: {"icmp_type", kindUint},
+	177: {"icmp_code", kindUint},
+	225: {"nat_src_addr", kindIP},
+	226: {"nat_dst_addr", kindIP},
+	227: {"nat_src_port", kindUint},
+	228: {"nat_dst_port", kindUint},
+}
+
+// setField 按字段定义将 value 写入 data，bootTime 为零值时相对于设备启动时间的字段输出原始毫秒数
+func setField(data Data, f templateField, value []byte, bootTime time.Time) {
+	if f.enterprise != 0 {
+		data["field_"+strconv.FormatUint(uint64(f.enterprise), 10)+"_"+strconv.Itoa(int(f.id))] = hex.EncodeToString(value)
+		return
+	}
+	def, ok := fieldDefs[f.id]
+	if !ok {
+		name := "field_" + strconv.Itoa(int(f.id))
+		if len(value) <= 8 {
+			data[name] = uintValue(value)
+		} else {
+			data[name] = hex.EncodeToString(value)
+		}
+		return
+	}
+	switch def.kind {
+	case kindIP:
+		if len(value) == net.IPv4len || len(value) == net.IPv6len {
+			data[def.name] = net.IP(value).String()
+			return
+		}
+	case kindMac:
+		if len(value) == 6 {
+			data[def.name] = net.HardwareAddr(value).String()
+			return
+		}
+	case kindString:
+		data[def.name] = strings.TrimRight(string(value), "\x00")
+		return
+	case kindSeconds:
+		if len(value) <= 8 {
+			data[def.name] = formatTime(time.Unix(int64(uintValue(value)), 0))
+			return
+		}
+	case kindMillis:
+		if len(value) <= 8 {
+			data[def.name] = formatTime(time.Unix(0, int64(uintValue(value))*int64(time.Millisecond)))
+			return
+		}
+	case kindSysUptime:
+		if len(value) <= 8 {
+			ms := uintValue(value)
+			if bootTime.IsZero() {
+				data[def.name+"_sysuptime"] = ms
+			} else {
+				data[def.name] = formatTime(bootTime.Add(time.Duration(ms) * time.Millisecond))
+			}
+			return
+		}
+	}
+	if len(value) <= 8 {
+		data[def.name] = uintValue(value)
+	} else {
+		data[def.name] = hex.EncodeToString(value)
+	}
+}
@@ -0,0 +1,220 @@
+package flow
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+// 支持的流量协议
+const (
+	ProtocolAuto      = "auto"
+	ProtocolNetflowV5 = "netflow_v5"
+	ProtocolNetflowV9 = "netflow_v9"
+	ProtocolIPFIX     = "ipfix"
+	ProtocolSflowV5   = "sflow_v5"
+)
+
+// 每条流量记录中公共的字段
+const (
+	FieldVersion  = "flow_version"
+	FieldExporter = "exporter"
+	FieldSequence = "sequence"
+)
+
+var (
+	ErrTooShort = errors.New("flow packet too short")
+
+	// 模板过期时间，NetFlow v9 和 IPFIX 的导出设备会周期性重发模板，超过该时间未刷新的模板将被丢弃
+	templateTTL = 2 * time.Hour
+)
+
+// Protocols 所有可选的流量协议
+var Protocols = []string{ProtocolAuto, ProtocolNetflowV5, ProtocolNetflowV9, ProtocolIPFIX, ProtocolSflowV5}
+
+// Decoder 将 NetFlow v5/v9、IPFIX 以及 sFlow v5 数据报解析为流量记录，每条流一条记录，
+// NetFlow v9 和 IPFIX 的模板按导出设备地址、source id(observation domain id) 和模板 id 缓存，
+// 同一个 Decoder 可以被多个 goroutine 同时使用
+type Decoder struct {
+	protocol string
+
+	mux       sync.Mutex
+	templates map[templateKey]*template
+}
+
+type templateKey struct {
+	exporter string
+	domain   uint32
+	id       uint16
+}
+
+type template struct {
+	fields     []templateField
+	scopeCount int // options template 中 scope 字段的数量
+	updated    time.Time
+}
+
+type templateField struct {
+	id         uint16
+	length     uint16
+	enterprise uint32
+}
+
+func NewDecoder(protocol string) (*Decoder, error) {
+	if protocol == "" {
+		protocol = ProtocolAuto
+	}
+	valid := false
+	for _, p := range Protocols {
+		if p == protocol {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return nil, fmt.Errorf("flow protocol %q is not supported, should be one of %v", protocol, strings.Join(Protocols, ","))
+	}
+	return &Decoder{
+		protocol:  protocol,
+		templates: make(map[templateKey]*template),
+	}, nil
+}
+
+// Decode 解析来自 exporter 的一个数据报，exporter 为导出设备的地址，可以带端口号，
+// 数据中引用的模板尚未收到时相应的记录会被忽略
+func (d *Decoder) Decode(exporter string, packet []byte) ([]Data, error) {
+	if host, _, err := net.SplitHostPort(exporter); err == nil {
+		exporter = host
+	}
+	protocol, err := detect(packet)
+	if err != nil {
+		return nil, err
+	}
+	if d.protocol != ProtocolAuto && d.protocol != protocol {
+		return nil, fmt.Errorf("expect %s packet but got %s", d.protocol, protocol)
+	}
+	var datas []Data
+	switch protocol {
+	case ProtocolNetflowV5:
+		datas, err = decodeNetflowV5(packet)
+	case ProtocolNetflowV9:
+		datas, err = d.decodeNetflowV9(exporter, packet)
+	case ProtocolIPFIX:
+		datas, err = d.decodeIPFIX(exporter, packet)
+	case ProtocolSflowV5:
+		datas, err = decodeSflowV5(packet)
+	}
+	for _, data := range datas {
+		data[FieldVersion] = protocol
+		data[FieldExporter] = exporter
+	}
+	return datas, err
+}
+
+// detect 根据数据报开头的版本号判断协议，NetFlow 和 IPFIX 的版本号为 2 字节，sFlow 为 4 字节
+func detect(packet []byte) (string, error) {
+	if len(packet) < 4 {
+		return "", ErrTooShort
+	}
+	switch binary.BigEndian.Uint16(packet) {
+	case 5:
+		return ProtocolNetflowV5, nil
+	case 9:
+		return ProtocolNetflowV9, nil
+	case 10:
+		return ProtocolIPFIX, nil
+	case 0:
+		if binary.BigEndian.Uint32(packet) == 5 {
+			return ProtocolSflowV5, nil
+		}
+	}
+	return "", fmt.Errorf("unknown flow packet version %#x", packet[:4])
+}
+
+func (d *Decoder) setTemplate(key templateKey, t *template) {
+	t.updated = time.Now()
+	d.mux.Lock()
+	d.templates[key] = t
+	d.mux.Unlock()
+}
+
+func (d *Decoder) getTemplate(key templateKey) *template {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	t, ok := d.templates[key]
+	if !ok {
+		return nil
+	}
+	if time.Since(t.updated) > templateTTL {
+		delete(d.templates, key)
+		return nil
+	}
+	return t
+}
+
+// reader 按大端序顺序读取数据，越界时 err 置为 ErrTooShort，之后的读取均返回零值
+type reader struct {
+	buf []byte
+	err error
+}
+
+func (r *reader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || len(r.buf) < n {
+		r.err = ErrTooShort
+		r.buf = nil
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *reader) uint8() uint8 {
+	if b := r.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *reader) uint16() uint16 {
+	if b := r.next(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *reader) uint32() uint32 {
+	if b := r.next(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *reader) ip(n int) string {
+	if b := r.next(n); b != nil {
+		return net.IP(b).String()
+	}
+	return ""
+}
+
+// uintValue 将不超过 8 字节的大端序整数转换为 uint64
+func uintValue(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
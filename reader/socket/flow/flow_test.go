@@ -0,0 +1,260 @@
+package flow
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+func pack(values ...interface{}) []byte {
+	var buf bytes.Buffer
+	for _, v := range values {
+		switch v := v.(type) {
+		case net.IP:
+			if ip := v.To4(); ip != nil {
+				buf.Write(ip)
+			} else {
+				buf.Write(v.To16())
+			}
+		case []byte:
+			buf.Write(v)
+		default:
+			binary.Write(&buf, binary.BigEndian, v)
+		}
+	}
+	return buf.Bytes()
+}
+
+func set(id uint16, body []byte) []byte {
+	return append(pack(id, uint16(len(body)+4)), body...)
+}
+
+func TestDetect(t *testing.T) {
+	for packet, protocol := range map[string]string{
+		"\x00\x05\x00\x00":     ProtocolNetflowV5,
+		"\x00\x09\x00\x00":     ProtocolNetflowV9,
+		"\x00\x0a\x00\x00":     ProtocolIPFIX,
+		"\x00\x00\x00\x05\x00": ProtocolSflowV5,
+	} {
+		p, err := detect([]byte(packet))
+		assert.NoError(t, err)
+		assert.Equal(t, protocol, p)
+	}
+	_, err := detect([]byte("\x00\x05"))
+	assert.Equal(t, ErrTooShort, err)
+	_, err = detect([]byte("\x00\x00\x00\x04"))
+	assert.Error(t, err)
+
+	_, err = NewDecoder("netflow_v7")
+	assert.Error(t, err)
+	d, err := NewDecoder(ProtocolSflowV5)
+	assert.NoError(t, err)
+	_, err = d.Decode("10.0.0.1:2055", pack(uint16(5), uint16(0), make([]byte, 20)))
+	assert.Error(t, err)
+}
+
+func TestNetflowV5(t *testing.T) {
+	packet := pack(uint16(5), uint16(2), uint32(10000), uint32(1514764800), uint32(0), uint32(7), uint8(1), uint8(2), uint16(0x4000|100))
+	for i := 0; i < 2; i++ {
+		packet = append(packet, pack(net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2"), net.ParseIP("10.0.0.254"), uint16(1), uint16(2),
+			uint32(10+i), uint32(1000+i), uint32(4000), uint32(9000), uint16(51234), uint16(443), uint8(0), uint8(0x1b), uint8(6), uint8(0),
+			uint16(64512), uint16(64513), uint8(24), uint8(16), uint16(0))...)
+	}
+	d, err := NewDecoder(ProtocolAuto)
+	assert.NoError(t, err)
+	datas, err := d.Decode("192.168.1.1:2055", packet)
+	assert.NoError(t, err)
+	if !assert.Len(t, datas, 2) {
+		return
+	}
+	assert.Equal(t, Data{
+		FieldVersion:      ProtocolNetflowV5,
+		FieldExporter:     "192.168.1.1",
+		FieldSequence:     uint64(7),
+		FieldEngineType:   uint64(1),
+		FieldEngineID:     uint64(2),
+		FieldSamplingRate: uint64(100),
+		FieldSrcAddr:      "10.0.0.1",
+		FieldDstAddr:      "10.0.0.2",
+		FieldNextHop:      "10.0.0.254",
+		FieldInput:        uint64(1),
+		FieldOutput:       uint64(2),
+		FieldPackets:      uint64(10),
+		FieldBytes:        uint64(1000),
+		FieldFlowStart:    "2017-12-31T23:59:54Z",
+		FieldFlowEnd:      "2017-12-31T23:59:59Z",
+		FieldSrcPort:      uint64(51234),
+		FieldDstPort:      uint64(443),
+		FieldTCPFlags:     uint64(0x1b),
+		FieldProtocol:     uint64(6),
+		FieldTOS:          uint64(0),
+		FieldSrcAS:        uint64(64512),
+		FieldDstAS:        uint64(64513),
+		FieldSrcMask:      uint64(24),
+		FieldDstMask:      uint64(16),
+	}, datas[0])
+	assert.Equal(t, uint64(1001), datas[1][FieldBytes])
+
+	_, err = d.Decode("192.168.1.1", packet[:len(packet)-1])
+	assert.Error(t, err)
+}
+
+func TestNetflowV9(t *testing.T) {
+	header := func(seq uint32) []byte {
+		return pack(uint16(9), uint16(1), uint32(10000), uint32(1514764800), seq, uint32(3))
+	}
+	templates := set(0, pack(uint16(256), uint16(6),
+		uint16(8), uint16(4), uint16(12), uint16(4), uint16(7), uint16(2), uint16(11), uint16(2), uint16(1), uint16(4), uint16(22), uint16(4)))
+	options := set(1, pack(uint16(257), uint16(4), uint16(4), uint16(1), uint16(4), uint16(34), uint16(4), uint16(0)))
+	record := func(bytes uint32) []byte {
+		return pack(net.ParseIP("10.1.1.1"), net.ParseIP("10.1.1.2"), uint16(53), uint16(40000), bytes, uint32(4000))
+	}
+	// 两条记录加 2 字节填充
+	data := set(256, append(append(record(64), record(128)...), 0, 0))
+
+	d, err := NewDecoder(ProtocolNetflowV9)
+	assert.NoError(t, err)
+	// 模板到达前的数据被忽略
+	datas, err := d.Decode("192.168.1.1:2055", append(header(1), data...))
+	assert.NoError(t, err)
+	assert.Len(t, datas, 0)
+
+	datas, err = d.Decode("192.168.1.1:2055", append(append(append(header(2), templates...), options...), data...))
+	assert.NoError(t, err)
+	if assert.Len(t, datas, 2) {
+		assert.Equal(t, Data{
+			FieldVersion:   ProtocolNetflowV9,
+			FieldExporter:  "192.168.1.1",
+			FieldSequence:  uint64(2),
+			FieldSourceID:  uint64(3),
+			FieldSrcAddr:   "10.1.1.1",
+			FieldDstAddr:   "10.1.1.2",
+			FieldSrcPort:   uint64(53),
+			FieldDstPort:   uint64(40000),
+			FieldBytes:     uint64(64),
+			FieldFlowStart: "2017-12-31T23:59:54Z",
+		}, datas[0])
+		assert.Equal(t, uint64(128), datas[1][FieldBytes])
+	}
+
+	// 模板缓存按导出设备区分，options 数据不输出
+	datas, err = d.Decode("192.168.1.2:2055", append(header(3), data...))
+	assert.NoError(t, err)
+	assert.Len(t, datas, 0)
+	datas, err = d.Decode("192.168.1.1:2055", append(header(4), set(257, pack(uint32(1), uint32(100)))...))
+	assert.NoError(t, err)
+	assert.Len(t, datas, 0)
+
+	// 模板过期后数据被忽略
+	d.mux.Lock()
+	d.templates[templateKey{exporter: "192.168.1.1", domain: 3, id: 256}].updated = time.Now().Add(-templateTTL - time.Minute)
+	d.mux.Unlock()
+	datas, err = d.Decode("192.168.1.1:2055", append(header(5), data...))
+	assert.NoError(t, err)
+	assert.Len(t, datas, 0)
+
+	_, err = d.Decode("192.168.1.1:2055", append(header(6), pack(uint16(256), uint16(100), uint32(0))...))
+	assert.Error(t, err)
+}
+
+func TestIPFIX(t *testing.T) {
+	message := func(sets ...[]byte) []byte {
+		body := bytes.Join(sets, nil)
+		return append(pack(uint16(10), uint16(16+len(body)), uint32(1514764800), uint32(9), uint32(42)), body...)
+	}
+	templates := set(2, pack(uint16(300), uint16(5),
+		uint16(27), uint16(16), uint16(28), uint16(16), uint16(152), uint16(8), uint16(96), uint16(65535),
+		uint16(0x8000|1), uint16(2), uint32(9)))
+	record := pack(net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2"), uint64(1514764800123), uint8(5), []byte("https"), uint16(0xbeef))
+
+	d, err := NewDecoder(ProtocolAuto)
+	assert.NoError(t, err)
+	datas, err := d.Decode("[2001:db8::fe]:4739", message(templates, set(300, record)))
+	assert.NoError(t, err)
+	if assert.Len(t, datas, 1) {
+		assert.Equal(t, Data{
+			FieldVersion:       ProtocolIPFIX,
+			FieldExporter:      "2001:db8::fe",
+			FieldSequence:      uint64(9),
+			FieldObservationID: uint64(42),
+			FieldSrcAddr:       "2001:db8::1",
+			FieldDstAddr:       "2001:db8::2",
+			FieldFlowStart:     "2018-01-01T00:00:00.123Z",
+			"application_name": "https",
+			"field_9_1":        "beef",
+		}, datas[0])
+	}
+
+	// 撤销模板
+	datas, err = d.Decode("[2001:db8::fe]:4739", message(set(2, pack(uint16(300), uint16(0))), set(300, record)))
+	assert.NoError(t, err)
+	assert.Len(t, datas, 0)
+
+	// 未知字段以及长度超过 8 字节的字段
+	datas, err = d.Decode("10.0.0.1", message(set(2, pack(uint16(301), uint16(2), uint16(999), uint16(2), uint16(1000), uint16(10))),
+		set(301, pack(uint16(7), []byte("0123456789")))))
+	assert.NoError(t, err)
+	if assert.Len(t, datas, 1) {
+		assert.Equal(t, uint64(7), datas[0]["field_999"])
+		assert.Equal(t, "30313233343536373839", datas[0]["field_1000"])
+	}
+
+	_, err = d.Decode("10.0.0.1", pack(uint16(10), uint16(100), uint32(0), uint32(0), uint32(0)))
+	assert.Error(t, err)
+}
+
+func TestSflowV5(t *testing.T) {
+	// 以太网 + VLAN + IPv4 + TCP 报文头
+	header := pack([]byte{0, 1, 2, 3, 4, 5}, []byte{6, 7, 8, 9, 10, 11}, uint16(0x8100), uint16(10), uint16(0x0800),
+		uint8(0x45), uint8(0x10), uint16(60), uint32(0), uint8(64), uint8(6), uint16(0), net.ParseIP("172.16.0.1"), net.ParseIP("172.16.0.2"),
+		uint16(33000), uint16(80), uint32(0), uint32(0), uint8(0x50), uint8(0x02), uint16(0))
+	raw := pack(uint32(1), uint32(1514), uint32(4), uint32(len(header)), header, []byte{0, 0}) // 补齐 4 字节
+	flowSample := pack(uint32(1), uint32(5), uint32(512), uint32(100000), uint32(0), uint32(3), uint32(0x40000000|4),
+		uint32(2), uint32(1), uint32(len(raw)), raw, uint32(1001), uint32(16), uint32(10), uint32(0), uint32(20), uint32(0))
+	counterSample := pack(uint32(1), uint32(5), uint32(0))
+
+	packet := pack(uint32(5), uint32(1), net.ParseIP("192.168.0.1"), uint32(0), uint32(77), uint32(3600000), uint32(2),
+		uint32(2), uint32(len(counterSample)), counterSample, uint32(1), uint32(len(flowSample)), flowSample)
+
+	d, err := NewDecoder(ProtocolAuto)
+	assert.NoError(t, err)
+	datas, err := d.Decode("192.168.0.1:6343", packet)
+	assert.NoError(t, err)
+	if assert.Len(t, datas, 1) {
+		assert.Equal(t, Data{
+			FieldVersion:      ProtocolSflowV5,
+			FieldExporter:     "192.168.0.1",
+			FieldAgent:        "192.168.0.1",
+			FieldSubAgent:     uint64(0),
+			FieldSequence:     uint64(77),
+			FieldSamplingRate: uint64(512),
+			FieldInput:        uint64(3),
+			FieldOutput:       uint64(4),
+			FieldPackets:      uint64(1),
+			FieldFrameLen:     uint64(1514),
+			FieldBytes:        uint64(1514),
+			FieldDstMac:       "00:01:02:03:04:05",
+			FieldSrcMac:       "06:07:08:09:0a:0b",
+			FieldEtherType:    uint64(0x0800),
+			FieldSrcVlan:      uint64(10),
+			FieldDstVlan:      uint64(20),
+			FieldIPVersion:    uint64(4),
+			FieldTOS:          uint64(0x10),
+			FieldProtocol:     uint64(6),
+			FieldSrcAddr:      "172.16.0.1",
+			FieldDstAddr:      "172.16.0.2",
+			FieldSrcPort:      uint64(33000),
+			FieldDstPort:      uint64(80),
+			FieldTCPFlags:     uint64(0x02),
+		}, datas[0])
+	}
+
+	_, err = d.Decode("192.168.0.1:6343", packet[:len(packet)-8])
+	assert.Error(t, err)
+}
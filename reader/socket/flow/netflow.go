@@ -0,0 +1,295 @@
+package flow
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/qiniu/log"
+
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const (
+	netflowV5HeaderLen = 24
+	netflowV5RecordLen = 48
+	netflowV9HeaderLen = 20
+	ipfixHeaderLen     = 16
+
+	netflowV9TemplateSetID        = 0
+	netflowV9OptionsTemplateSetID = 1
+	ipfixTemplateSetID            = 2
+	ipfixOptionsTemplateSetID     = 3
+	minDataSetID                  = 256
+
+	// IPFIX 中长度为该值的字段为变长字段
+	ipfixVariableLength = 65535
+)
+
+// decodeNetflowV5 解析 NetFlow v5 数据报，v5 的记录格式固定，不需要模板
+func decodeNetflowV5(packet []byte) ([]Data, error) {
+	r := &reader{buf: packet}
+	r.uint16() // version
+	count := int(r.uint16())
+	sysUptime := r.uint32()
+	unixSecs := r.uint32()
+	unixNsecs := r.uint32()
+	sequence := r.uint32()
+	engineType := r.uint8()
+	engineID := r.uint8()
+	sampling := r.uint16()
+	if r.err != nil {
+		return nil, r.err
+	}
+	if len(r.buf) < count*netflowV5RecordLen {
+		return nil, fmt.Errorf("netflow v5 packet declares %d records but only has %d bytes", count, len(r.buf))
+	}
+	bootTime := time.Unix(int64(unixSecs), int64(unixNsecs)).Add(-time.Duration(sysUptime) * time.Millisecond)
+
+	datas := make([]Data, 0, count)
+	for i := 0; i < count; i++ {
+		data := Data{
+			FieldSequence:     uint64(sequence),
+			FieldEngineType:   uint64(engineType),
+			FieldEngineID:     uint64(engineID),
+			FieldSamplingRate: uint64(sampling & 0x3fff), // 高 2 位为采样模式
+		}
+		data[FieldSrcAddr] = r.ip(4)
+		data[FieldDstAddr] = r.ip(4)
+		data[FieldNextHop] = r.ip(4)
+		data[FieldInput] = uint64(r.uint16())
+		data[FieldOutput] = uint64(r.uint16())
+		data[FieldPackets] = uint64(r.uint32())
+		data[FieldBytes] = uint64(r.uint32())
+		data[FieldFlowStart] = formatTime(bootTime.Add(time.Duration(r.uint32()) * time.Millisecond))
+		data[FieldFlowEnd] = formatTime(bootTime.Add(time.Duration(r.uint32()) * time.Millisecond))
+		data[FieldSrcPort] = uint64(r.uint16())
+		data[FieldDstPort] = uint64(r.uint16())
+		r.uint8() // pad1
+		data[FieldTCPFlags] = uint64(r.uint8())
+		data[FieldProtocol] = uint64(r.uint8())
+		data[FieldTOS] = uint64(r.uint8())
+		data[FieldSrcAS] = uint64(r.uint16())
+		data[FieldDstAS] = uint64(r.uint16())
+		data[FieldSrcMask] = uint64(r.uint8())
+		data[FieldDstMask] = uint64(r.uint8())
+		r.uint16() // pad2
+		datas = append(datas, data)
+	}
+	return datas, r.err
+}
+
+// decodeNetflowV9 解析 NetFlow v9 数据报，模板 flowset 更新模板缓存，数据 flowset 按缓存的模板解析，
+// options 数据描述的是导出设备本身而不是流量，不输出
+func (d *Decoder) decodeNetflowV9(exporter string, packet []byte) ([]Data, error) {
+	r := &reader{buf: packet}
+	r.uint16() // version
+	r.uint16() // count
+	sysUptime := r.uint32()
+	unixSecs := r.uint32()
+	sequence := r.uint32()
+	sourceID := r.uint32()
+	if r.err != nil {
+		return nil, r.err
+	}
+	bootTime := time.Unix(int64(unixSecs), 0).Add(-time.Duration(sysUptime) * time.Millisecond)
+
+	var datas []Data
+	for len(r.buf) >= 4 {
+		setID := r.uint16()
+		length := int(r.uint16())
+		body := r.next(length - 4)
+		if r.err != nil {
+			return datas, fmt.Errorf("netflow v9 flowset %d length %d is invalid", setID, length)
+		}
+		switch {
+		case setID == netflowV9TemplateSetID:
+			d.parseTemplates(exporter, sourceID, body, false)
+		case setID == netflowV9OptionsTemplateSetID:
+			d.parseNetflowV9OptionsTemplates(exporter, sourceID, body)
+		case setID >= minDataSetID:
+			key := templateKey{exporter: exporter, domain: sourceID, id: setID}
+			records, err := d.parseDataSet(key, body, bootTime)
+			if err != nil {
+				return datas, err
+			}
+			for _, data := range records {
+				data[FieldSequence] = uint64(sequence)
+				data[FieldSourceID] = uint64(sourceID)
+				datas = append(datas, data)
+			}
+		}
+	}
+	return datas, nil
+}
+
+// decodeIPFIX 解析 IPFIX 数据报，与 NetFlow v9 的区别在于支持企业字段和变长字段，
+// 并且报文头中没有设备启动时间
+func (d *Decoder) decodeIPFIX(exporter string, packet []byte) ([]Data, error) {
+	r := &reader{buf: packet}
+	r.uint16() // version
+	length := int(r.uint16())
+	r.uint32() // export time
+	sequence := r.uint32()
+	domainID := r.uint32()
+	if r.err != nil {
+		return nil, r.err
+	}
+	if length < ipfixHeaderLen || length > len(packet) {
+		return nil, fmt.Errorf("ipfix message length %d is invalid, packet size is %d", length, len(packet))
+	}
+	r.buf = packet[ipfixHeaderLen:length]
+
+	var datas []Data
+	for len(r.buf) >= 4 {
+		setID := r.uint16()
+		setLen := int(r.uint16())
+		body := r.next(setLen - 4)
+		if r.err != nil {
+			return datas, fmt.Errorf("ipfix set %d length %d is invalid", setID, setLen)
+		}
+		switch {
+		case setID == ipfixTemplateSetID:
+			d.parseTemplates(exporter, domainID, body, true)
+		case setID == ipfixOptionsTemplateSetID:
+			d.parseIPFIXOptionsTemplates(exporter, domainID, body)
+		case setID >= minDataSetID:
+			key := templateKey{exporter: exporter, domain: domainID, id: setID}
+			records, err := d.parseDataSet(key, body, time.Time{})
+			if err != nil {
+				return datas, err
+			}
+			for _, data := range records {
+				data[FieldSequence] = uint64(sequence)
+				data[FieldObservationID] = uint64(domainID)
+				datas = append(datas, data)
+			}
+		}
+	}
+	return datas, nil
+}
+
+// readFields 读取 count 个字段定义，ipfix 为 true 时字段编号最高位表示其后带有 4 字节的企业编号
+func readFields(r *reader, count int, ipfix bool) []templateField {
+	fields := make([]templateField, 0, count)
+	for i := 0; i < count && r.err == nil; i++ {
+		f := templateField{id: r.uint16(), length: r.uint16()}
+		if ipfix && f.id&0x8000 != 0 {
+			f.id &= 0x7fff
+			f.enterprise = r.uint32()
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+func (d *Decoder) parseTemplates(exporter string, domain uint32, body []byte, ipfix bool) {
+	r := &reader{buf: body}
+	for len(r.buf) >= 4 {
+		id := r.uint16()
+		count := int(r.uint16())
+		if id < minDataSetID {
+			// 剩余的是填充
+			return
+		}
+		key := templateKey{exporter: exporter, domain: domain, id: id}
+		if count == 0 {
+			// IPFIX 撤销模板
+			d.mux.Lock()
+			delete(d.templates, key)
+			d.mux.Unlock()
+			continue
+		}
+		fields := readFields(r, count, ipfix)
+		if r.err != nil {
+			log.Debugf("flow template %d from %s is truncated", id, exporter)
+			return
+		}
+		d.setTemplate(key, &template{fields: fields})
+	}
+}
+
+func (d *Decoder) parseNetflowV9OptionsTemplates(exporter string, domain uint32, body []byte) {
+	r := &reader{buf: body}
+	for len(r.buf) >= 6 {
+		id := r.uint16()
+		scopeLen := int(r.uint16())
+		optionLen := int(r.uint16())
+		if id < minDataSetID {
+			return
+		}
+		scopes := readFields(r, scopeLen/4, false)
+		options := readFields(r, optionLen/4, false)
+		if r.err != nil {
+			log.Debugf("netflow v9 options template %d from %s is truncated", id, exporter)
+			return
+		}
+		d.setTemplate(templateKey{exporter: exporter, domain: domain, id: id}, &template{fields: append(scopes, options...), scopeCount: len(scopes)})
+	}
+}
+
+func (d *Decoder) parseIPFIXOptionsTemplates(exporter string, domain uint32, body []byte) {
+	r := &reader{buf: body}
+	for len(r.buf) >= 6 {
+		id := r.uint16()
+		count := int(r.uint16())
+		scopeCount := int(r.uint16())
+		if id < minDataSetID {
+			return
+		}
+		fields := readFields(r, count, true)
+		if r.err != nil {
+			log.Debugf("ipfix options template %d from %s is truncated", id, exporter)
+			return
+		}
+		if scopeCount == 0 {
+			scopeCount = 1 // options template 至少有一个 scope 字段，避免被当作流量记录
+		}
+		d.setTemplate(templateKey{exporter: exporter, domain: domain, id: id}, &template{fields: fields, scopeCount: scopeCount})
+	}
+}
+
+// parseDataSet 按模板解析数据 set 中的所有记录，模板未知时忽略整个 set
+func (d *Decoder) parseDataSet(key templateKey, body []byte, bootTime time.Time) ([]Data, error) {
+	t := d.getTemplate(key)
+	if t == nil {
+		log.Debugf("flow template %d from %s (domain %d) has not been received yet, data ignored", key.id, key.exporter, key.domain)
+		return nil, nil
+	}
+	if t.scopeCount > 0 {
+		return nil, nil
+	}
+	minLen := 0
+	for _, f := range t.fields {
+		if f.length == ipfixVariableLength {
+			minLen++
+		} else {
+			minLen += int(f.length)
+		}
+	}
+	if minLen == 0 {
+		return nil, fmt.Errorf("flow template %d from %s has no field", key.id, key.exporter)
+	}
+
+	var datas []Data
+	r := &reader{buf: body}
+	// 不足一条记录的剩余部分是填充
+	for len(r.buf) >= minLen {
+		data := Data{}
+		for _, f := range t.fields {
+			length := int(f.length)
+			if f.length == ipfixVariableLength {
+				length = int(r.uint8())
+				if length == 255 {
+					length = int(r.uint16())
+				}
+			}
+			value := r.next(length)
+			if r.err != nil {
+				return datas, fmt.Errorf("flow record of template %d from %s is truncated", key.id, key.exporter)
+			}
+			setField(data, f, value, bootTime)
+		}
+		datas = append(datas, data)
+	}
+	return datas, nil
+}
@@ -0,0 +1,243 @@
+package flow
+
+import (
+	"fmt"
+	"net"
+
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const (
+	FieldAgent     = "agent"
+	FieldSubAgent  = "sub_agent_id"
+	FieldFrameLen  = "frame_length"
+	FieldEtherType = "ether_type"
+
+	sflowFlowSample         = 1
+	sflowExpandedFlowSample = 3
+
+	sflowRawPacketHeader = 1
+	sflowEthernetFrame   = 2
+	sflowIPv4            = 3
+	sflowIPv6            = 4
+	sflowExtendedSwitch  = 1001
+	sflowExtendedRouter  = 1002
+
+	headerProtocolEthernet = 1
+	headerProtocolIPv4     = 11
+	headerProtocolIPv6     = 12
+
+	etherTypeIPv4 = 0x0800
+	etherTypeIPv6 = 0x86dd
+	etherTypeVlan = 0x8100
+
+	protocolTCP = 6
+	protocolUDP = 17
+)
+
+// decodeSflowV5 解析 sFlow v5 数据报，每个流量采样输出一条记录，计数器采样不输出
+func decodeSflowV5(packet []byte) ([]Data, error) {
+	r := &reader{buf: packet}
+	r.uint32() // version
+	agent, err := sflowAddress(r)
+	if err != nil {
+		return nil, err
+	}
+	subAgent := r.uint32()
+	sequence := r.uint32()
+	r.uint32() // uptime
+	count := int(r.uint32())
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	var datas []Data
+	for i := 0; i < count; i++ {
+		format := r.uint32()
+		body := r.next(int(r.uint32()))
+		if r.err != nil {
+			return datas, fmt.Errorf("sflow sample %d of %d is truncated", i+1, count)
+		}
+		// 只处理标准格式，高 20 位为企业编号
+		if format>>12 != 0 {
+			continue
+		}
+		var data Data
+		switch format & 0xfff {
+		case sflowFlowSample:
+			data, err = decodeSflowFlowSample(body, false)
+		case sflowExpandedFlowSample:
+			data, err = decodeSflowFlowSample(body, true)
+		default:
+			continue
+		}
+		if err != nil {
+			return datas, err
+		}
+		data[FieldAgent] = agent
+		data[FieldSubAgent] = uint64(subAgent)
+		data[FieldSequence] = uint64(sequence)
+		datas = append(datas, data)
+	}
+	return datas, nil
+}
+
+func sflowAddress(r *reader) (string, error) {
+	switch typ := r.uint32(); typ {
+	case 1:
+		return r.ip(net.IPv4len), r.err
+	case 2:
+		return r.ip(net.IPv6len), r.err
+	case 0:
+		return "", r.err
+	default:
+		return "", fmt.Errorf("sflow address type %d is unknown", typ)
+	}
+}
+
+func decodeSflowFlowSample(body []byte, expanded bool) (Data, error) {
+	r := &reader{buf: body}
+	data := Data{FieldPackets: uint64(1)}
+	r.uint32() // sample sequence
+	if expanded {
+		r.uint32() // source id type
+		r.uint32() // source id index
+	} else {
+		r.uint32() // source id
+	}
+	data[FieldSamplingRate] = uint64(r.uint32())
+	r.uint32() // sample pool
+	r.uint32() // drops
+	if expanded {
+		r.uint32() // input format
+		data[FieldInput] = uint64(r.uint32())
+		r.uint32() // output format
+		data[FieldOutput] = uint64(r.uint32())
+	} else {
+		// 高 2 位为格式
+		data[FieldInput] = uint64(r.uint32() & 0x3fffffff)
+		data[FieldOutput] = uint64(r.uint32() & 0x3fffffff)
+	}
+	count := int(r.uint32())
+	for i := 0; i < count && r.err == nil; i++ {
+		format := r.uint32()
+		record := &reader{buf: r.next(int(r.uint32()))}
+		if r.err != nil || format>>12 != 0 {
+			continue
+		}
+		switch format & 0xfff {
+		case sflowRawPacketHeader:
+			protocol := record.uint32()
+			data[FieldFrameLen] = uint64(record.uint32())
+			data[FieldBytes] = data[FieldFrameLen]
+			record.uint32() // stripped
+			header := record.next(int(record.uint32()))
+			if record.err == nil {
+				decodeHeader(data, protocol, header)
+			}
+		case sflowEthernetFrame:
+			data[FieldFrameLen] = uint64(record.uint32())
+			data[FieldBytes] = data[FieldFrameLen]
+			if src := record.next(8); src != nil {
+				data[FieldSrcMac] = net.HardwareAddr(src[:6]).String()
+			}
+			if dst := record.next(8); dst != nil {
+				data[FieldDstMac] = net.HardwareAddr(dst[:6]).String()
+			}
+			data[FieldEtherType] = uint64(record.uint32())
+		case sflowIPv4, sflowIPv6:
+			ipLen := net.IPv4len
+			if format&0xfff == sflowIPv6 {
+				ipLen = net.IPv6len
+			}
+			record.uint32() // length
+			data[FieldProtocol] = uint64(record.uint32())
+			data[FieldSrcAddr] = record.ip(ipLen)
+			data[FieldDstAddr] = record.ip(ipLen)
+			data[FieldSrcPort] = uint64(record.uint32())
+			data[FieldDstPort] = uint64(record.uint32())
+			data[FieldTCPFlags] = uint64(record.uint32())
+			data[FieldTOS] = uint64(record.uint32())
+		case sflowExtendedSwitch:
+			data[FieldSrcVlan] = uint64(record.uint32())
+			record.uint32() // src priority
+			data[FieldDstVlan] = uint64(record.uint32())
+		case sflowExtendedRouter:
+			if nextHop, err := sflowAddress(record); err == nil && nextHop != "" {
+				data[FieldNextHop] = nextHop
+			}
+			data[FieldSrcMask] = uint64(record.uint32())
+			data[FieldDstMask] = uint64(record.uint32())
+		}
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("sflow flow sample is truncated")
+	}
+	return data, nil
+}
+
+// decodeHeader 解析采样到的报文头中的以太网、IP 以及 TCP/UDP 端口信息，报文头被截断时尽量解析
+func decodeHeader(data Data, protocol uint32, header []byte) {
+	var etherType uint16
+	switch protocol {
+	case headerProtocolEthernet:
+		if len(header) < 14 {
+			return
+		}
+		data[FieldDstMac] = net.HardwareAddr(header[0:6]).String()
+		data[FieldSrcMac] = net.HardwareAddr(header[6:12]).String()
+		etherType = uint16(header[12])<<8 | uint16(header[13])
+		header = header[14:]
+		if etherType == etherTypeVlan && len(header) >= 4 {
+			data[FieldSrcVlan] = uint64(uint16(header[0])<<8|uint16(header[1])) & 0xfff
+			etherType = uint16(header[2])<<8 | uint16(header[3])
+			header = header[4:]
+		}
+		data[FieldEtherType] = uint64(etherType)
+	case headerProtocolIPv4:
+		etherType = etherTypeIPv4
+	case headerProtocolIPv6:
+		etherType = etherTypeIPv6
+	default:
+		return
+	}
+
+	var proto byte
+	switch etherType {
+	case etherTypeIPv4:
+		if len(header) < 20 {
+			return
+		}
+		ihl := int(header[0]&0x0f) * 4
+		data[FieldIPVersion] = uint64(4)
+		data[FieldTOS] = uint64(header[1])
+		proto = header[9]
+		data[FieldSrcAddr] = net.IP(header[12:16]).String()
+		data[FieldDstAddr] = net.IP(header[16:20]).String()
+		if ihl < 20 || len(header) < ihl {
+			data[FieldProtocol] = uint64(proto)
+			return
+		}
+		header = header[ihl:]
+	case etherTypeIPv6:
+		if len(header) < 40 {
+			return
+		}
+		data[FieldIPVersion] = uint64(6)
+		data[FieldTOS] = uint64((header[0]&0x0f)<<4 | header[1]>>4)
+		proto = header[6]
+		data[FieldSrcAddr] = net.IP(header[8:24]).String()
+		data[FieldDstAddr] = net.IP(header[24:40]).String()
+		header = header[40:]
+	default:
+		return
+	}
+	data[FieldProtocol] = uint64(proto)
+	if (proto == protocolTCP || proto == protocolUDP) && len(header) >= 4 {
+		data[FieldSrcPort] = uint64(uint16(header[0])<<8 | uint16(header[1]))
+		data[FieldDstPort] = uint64(uint16(header[2])<<8 | uint16(header[3]))
+	}
+	if proto == protocolTCP && len(header) >= 14 {
+		data[FieldTCPFlags] = uint64(header[13])
+	}
+}
@@ -23,6 +23,7 @@ import (
 	"github.com/qiniu/logkit/conf"
 	"github.com/qiniu/logkit/reader"
 	. "github.com/qiniu/logkit/reader/config"
+	"github.com/qiniu/logkit/reader/socket/flow"
 	. "github.com/qiniu/logkit/utils/models"
 )
 
@@ -76,7 +77,7 @@ func (ssr *streamSocketReader) listen() {
 			}
 		}
 
-		go ssr.read(c)
+		ssr.meta.Go("socket connection "+c.RemoteAddr().String(), func() { ssr.read(c) })
 	}
 
 	ssr.connectionsMtx.Lock()
@@ -115,7 +116,7 @@ func (ssr *streamSocketReader) read(c net.Conn) {
 	defer ssr.removeConnection(c)
 	defer c.Close()
 
-	if ssr.IsSplitByLine ||
+	if ssr.framing != nil || ssr.IsSplitByLine ||
 		ssr.SocketRule == SocketRuleLine ||
 		ssr.SocketRule == SocketRulePacket {
 		ssr.packetAndLineRead(c)
@@ -131,6 +132,10 @@ func (ssr *streamSocketReader) packetAndLineRead(c net.Conn) {
 	var err error
 	defer ssr.sendError(err)
 	scnr := bufio.NewScanner(c)
+	if ssr.framing != nil {
+		scnr.Buffer(make([]byte, 0, 4096), reader.MaxRecordSize+8)
+		scnr.Split(ssr.framing.Split)
+	}
 	for {
 		if atomic.LoadInt32(&ssr.status) == StatusStopped || atomic.LoadInt32(&ssr.status) == StatusStopping {
 			return
@@ -160,7 +165,10 @@ func (ssr *streamSocketReader) packetAndLineRead(c net.Conn) {
 		}
 
 		val := string(scnr.Bytes())
-		if ssr.IsSplitByLine || ssr.SocketRule == SocketRuleLine {
+		if ssr.framing != nil {
+			// 按分隔符或长度前缀切分出的是完整的一条记录
+			ssr.sendReadChan(address, val)
+		} else if ssr.IsSplitByLine || ssr.SocketRule == SocketRuleLine {
 			vals := strings.Split(val, "\n")
 			for _, value := range vals {
 				if value = strings.TrimSpace(value); value != "" {
@@ -292,6 +300,10 @@ func (psr *packetSocketReader) listen() {
 				address = localAddr.String()
 			}
 		}
+		if psr.flowDecoder != nil {
+			psr.sendFlows(address, buf[:n])
+			continue
+		}
 		val := string(buf[:n])
 
 		if psr.IsSplitByLine || psr.SocketRule == SocketRuleLine {
@@ -307,6 +319,22 @@ func (psr *packetSocketReader) listen() {
 	}
 }
 
+// sendFlows 将一个流量数据报解析为多条流量记录，每条记录序列化为 json 发送
+func (psr *packetSocketReader) sendFlows(address string, packet []byte) {
+	datas, err := psr.flowDecoder.Decode(address, packet)
+	if err != nil {
+		log.Warnf("Runner[%v] %q decode flow packet from %s error: %v", psr.meta.RunnerName, psr.Name(), address, err)
+	}
+	for _, data := range datas {
+		bytes, err := jsoniter.Marshal(data)
+		if err != nil {
+			log.Errorf("Runner[%v] %q marshal flow record error: %v", psr.meta.RunnerName, psr.Name(), err)
+			continue
+		}
+		psr.sendReadChan(address, string(bytes))
+	}
+}
+
 func init() {
 	reader.RegisterConstructor(ModeSocket, NewReader)
 }
@@ -331,7 +359,9 @@ type Reader struct {
 	IsSplitByLine   bool
 	SocketRule      string
 	HeadPattern     *regexp.Regexp
+	framing         *reader.RecordFraming // 不为 nil 时 tcp 连接中的数据按分隔符或长度前缀切分
 	decoder         mahonia.Decoder
+	flowDecoder     *flow.Decoder // 不为 nil 时 udp 数据报按 NetFlow/IPFIX/sFlow 解析
 
 	closer io.Closer
 }
@@ -366,6 +396,10 @@ func NewReader(meta *reader.Meta, conf conf.MapConf) (reader.Reader, error) {
 			return nil, err
 		}
 	}
+	framing, err := reader.NewRecordFraming(conf)
+	if err != nil {
+		return nil, err
+	}
 	var decoder mahonia.Decoder
 	encoding, _ := conf.GetStringOr(KeyEncoding, "")
 	encoding = strings.ToUpper(encoding)
@@ -375,6 +409,12 @@ func NewReader(meta *reader.Meta, conf conf.MapConf) (reader.Reader, error) {
 			log.Warnf("Encoding Way [%v] is not supported, will read as utf-8", encoding)
 		}
 	}
+	var flowDecoder *flow.Decoder
+	if flowProtocol, _ := conf.GetStringOr(KeySocketFlowProtocol, ""); flowProtocol != "" {
+		if flowDecoder, err = flow.NewDecoder(flowProtocol); err != nil {
+			return nil, err
+		}
+	}
 	return &Reader{
 		meta:            meta,
 		status:          StatusInit,
@@ -389,7 +429,9 @@ func NewReader(meta *reader.Meta, conf conf.MapConf) (reader.Reader, error) {
 		IsSplitByLine:   IsSplitByLine,
 		SocketRule:      socketRule,
 		HeadPattern:     headPattern,
+		framing:         framing,
 		decoder:         decoder,
+		flowDecoder:     flowDecoder,
 	}, nil
 }
 
@@ -462,6 +504,9 @@ func (r *Reader) Start() (err error) {
 
 	switch spl[0] {
 	case "tcp", "tcp4", "tcp6", "unix", "unixpacket":
+		if r.flowDecoder != nil {
+			return fmt.Errorf("%s only works with packet protocols such as udp, got %s", KeySocketFlowProtocol, spl[0])
+		}
 		l, err := net.Listen(spl[0], spl[1])
 		if err != nil {
 			return err
@@ -481,7 +526,7 @@ func (r *Reader) Start() (err error) {
 		}
 
 		r.closer = l
-		go ssr.listen()
+		r.meta.Go("socket listen", ssr.listen)
 	case "udp", "udp4", "udp6", "ip", "ip4", "ip6", "unixgram":
 		pc, err := net.ListenPacket(spl[0], spl[1])
 		if err != nil {
@@ -503,7 +548,7 @@ func (r *Reader) Start() (err error) {
 		}
 
 		r.closer = pc
-		go psr.listen()
+		r.meta.Go("socket listen", psr.listen)
 	default:
 		return fmt.Errorf("unknown protocol '%s' in '%s'", spl[0], r.ServiceAddress)
 	}
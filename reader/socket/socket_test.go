@@ -383,3 +383,93 @@ func TestSocketReaderClosePanic(t *testing.T) {
 	assert.Equal(t, "", line)
 	sysLog.Emerg("this is OK")
 }
+
+func TestTCPSocketReaderWithDelimiter(t *testing.T) {
+	logkitConf := conf.MapConf{
+		KeyMetaPath:             MetaDir,
+		KeyFileDone:             MetaDir,
+		KeyRunnerName:           "TestTCPSocketReaderWithDelimiter",
+		KeyMode:                 ModeSocket,
+		KeySocketServiceAddress: "tcp://127.0.0.1:5149",
+		KeyRecordDelimiter:      `\0`,
+	}
+	meta, err := reader.NewMetaWithConf(logkitConf)
+	assert.NoError(t, err)
+	defer os.RemoveAll(MetaDir)
+
+	ssr, err := NewReader(meta, logkitConf)
+	assert.NoError(t, err)
+	sr := ssr.(*Reader)
+	assert.NoError(t, sr.Start())
+	defer sr.Close()
+
+	conn, err := net.Dial("tcp", "127.0.0.1:5149")
+	assert.NoError(t, err)
+	_, err = conn.Write([]byte("first\nline\x00sec"))
+	assert.NoError(t, err)
+	_, err = conn.Write([]byte("ond\x00"))
+	assert.NoError(t, err)
+	time.Sleep(30 * time.Millisecond)
+	line, err := sr.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "first\nline", line)
+	line, err = sr.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "second", line)
+	conn.Close()
+}
+
+func TestUdpSocketReaderWithFlow(t *testing.T) {
+	logkitConf := conf.MapConf{
+		KeyMetaPath:             MetaDir,
+		KeyFileDone:             MetaDir,
+		KeyRunnerName:           "TestUdpSocketReaderWithFlow",
+		KeyMode:                 ModeSocket,
+		KeySocketServiceAddress: "udp://127.0.0.1:5142",
+		KeySocketFlowProtocol:   "auto",
+	}
+	meta, err := reader.NewMetaWithConf(logkitConf)
+	assert.NoError(t, err)
+	defer os.RemoveAll(MetaDir)
+
+	_, err = NewReader(meta, conf.MapConf{KeySocketServiceAddress: "udp://127.0.0.1:5142", KeySocketFlowProtocol: "netflow_v1"})
+	assert.Error(t, err)
+	tcpReader, err := NewReader(meta, conf.MapConf{KeySocketServiceAddress: "tcp://127.0.0.1:5142", KeySocketFlowProtocol: "auto"})
+	assert.NoError(t, err)
+	assert.Error(t, tcpReader.(*Reader).Start())
+
+	ssr, err := NewReader(meta, logkitConf)
+	assert.NoError(t, err)
+	sr := ssr.(*Reader)
+	assert.NoError(t, sr.Start())
+
+	conn, err := net.Dial("udp", "127.0.0.1:5142")
+	assert.NoError(t, err)
+	defer conn.Close()
+	// 包含两条流的 NetFlow v5 数据报
+	packet := make([]byte, 24+2*48)
+	packet[1], packet[3] = 5, 2
+	for i := 0; i < 2; i++ {
+		record := packet[24+i*48:]
+		copy(record, net.ParseIP("10.0.0.1").To4())
+		copy(record[4:], net.ParseIP("10.0.0.2").To4())
+		record[23] = byte(100 + i) // bytes
+		record[38] = 17            // protocol
+	}
+	_, err = conn.Write(packet)
+	assert.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		line, err := sr.ReadLine()
+		assert.NoError(t, err)
+		var data Data
+		assert.NoError(t, json.Unmarshal([]byte(line), &data))
+		assert.Equal(t, "netflow_v5", data["flow_version"])
+		assert.Equal(t, "10.0.0.1", data["src_addr"])
+		assert.Equal(t, "10.0.0.2", data["dst_addr"])
+		assert.Equal(t, float64(100+i), data["bytes"])
+		assert.Equal(t, float64(17), data["protocol"])
+		assert.Contains(t, sr.Source(), "127.0.0.1")
+	}
+	assert.NoError(t, sr.Close())
+}
@@ -0,0 +1,218 @@
+package sql
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/qiniu/logkit/reader"
+	"github.com/qiniu/logkit/utils/models"
+)
+
+// 每个 worker 平均分到的分片数，分片多于 worker 数可以避免个别稀疏分片拖慢整体进度
+const chunksPerWorker = 4
+
+// Chunk 全量导出时按主键划分的区间 [Start, End)，Next 为该区间下一次读取的起始主键
+type Chunk struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+	Next  int64 `json:"next"`
+}
+
+func (c Chunk) Done() bool {
+	return c.Next >= c.End
+}
+
+// ChunkProgress 全量导出的进度，导出完成后从 Max+1 开始增量读取
+type ChunkProgress struct {
+	SQL    string  `json:"sql"`
+	Max    int64   `json:"max"`
+	Chunks []Chunk `json:"chunks"`
+
+	mux sync.RWMutex
+}
+
+// NewChunkProgress 将 [min, max] 的主键范围划分为 workers*chunksPerWorker 个分片
+func NewChunkProgress(sqlStr string, min, max int64, workers int) *ChunkProgress {
+	p := &ChunkProgress{SQL: sqlStr, Max: max}
+	if max < min {
+		return p
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	count := int64(workers * chunksPerWorker)
+	total := max - min + 1
+	if count > total {
+		count = total
+	}
+	size := total / count
+	if total%count != 0 {
+		size++
+	}
+	for start := min; start <= max; start += size {
+		end := start + size
+		if end > max+1 {
+			end = max + 1
+		}
+		p.Chunks = append(p.Chunks, Chunk{Start: start, End: end, Next: start})
+	}
+	return p
+}
+
+func (p *ChunkProgress) Done() bool {
+	p.mux.RLock()
+	defer p.mux.RUnlock()
+	for _, c := range p.Chunks {
+		if !c.Done() {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *ChunkProgress) chunk(idx int) Chunk {
+	p.mux.RLock()
+	defer p.mux.RUnlock()
+	return p.Chunks[idx]
+}
+
+func (p *ChunkProgress) advance(idx int, next int64) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.Chunks[idx].Next = next
+}
+
+// Marshal 序列化当前进度，用于写入 meta
+func (p *ChunkProgress) Marshal() ([]byte, error) {
+	p.mux.RLock()
+	defer p.mux.RUnlock()
+	return json.Marshal(p)
+}
+
+func chunkRecordsPath(doneFilePath string) string {
+	return filepath.Join(doneFilePath, fmt.Sprintf("%v.%v", reader.DoneFileName, ChunkRecordsFile))
+}
+
+// RestoreChunkProgress 从 meta 中恢复全量导出的进度，文件不存在时返回 nil
+func RestoreChunkProgress(doneFilePath string) (*ChunkProgress, error) {
+	data, err := ioutil.ReadFile(chunkRecordsPath(doneFilePath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	p := &ChunkProgress{}
+	if err = json.Unmarshal(data, p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// WriteChunkProgress 将全量导出的进度写入 meta
+func WriteChunkProgress(doneFilePath string, p *ChunkProgress) error {
+	data, err := p.Marshal()
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(chunkRecordsPath(doneFilePath), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, models.DefaultFilePerm)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err = f.Write(data); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// QueryKeyRange 查询 sql 结果中主键的最小值和最大值，没有数据时 ok 为 false
+func QueryKeyRange(db *sql.DB, rawSQL, key string) (min, max int64, ok bool, err error) {
+	rawSQL = strings.TrimSuffix(strings.TrimSpace(rawSQL), ";")
+	query := fmt.Sprintf("SELECT MIN(%v), MAX(%v) FROM (%v) logkit_chunk_range;", key, key, rawSQL)
+	var minKey, maxKey sql.NullInt64
+	if err = db.QueryRow(query).Scan(&minKey, &maxKey); err != nil {
+		return 0, 0, false, fmt.Errorf("query <%v> error %v", query, err)
+	}
+	if !minKey.Valid || !maxKey.Valid {
+		return 0, 0, false, nil
+	}
+	return minKey.Int64, maxKey.Int64, true, nil
+}
+
+// ChunkDumper 使用多个 worker 并行读取各个分片，每个分片内按 Step 大小的主键范围分批读取
+type ChunkDumper struct {
+	Progress *ChunkProgress
+	Workers  int
+	Step     int64
+	// BuildSQL 生成读取主键范围 [start, end) 数据的 sql
+	BuildSQL func(start, end int64) string
+	// Read 执行 sql 并发送数据，多个 worker 会并发调用
+	Read func(execSQL string) error
+	// Stopped 返回 true 时所有 worker 在当前批次结束后退出
+	Stopped func() bool
+}
+
+// Run 读取所有未完成的分片，返回第一个出现的错误，已完成的批次会记录在 Progress 中
+func (d *ChunkDumper) Run() error {
+	workers := d.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	step := d.Step
+	if step < 1 {
+		step = 1
+	}
+
+	idxChan := make(chan int, len(d.Progress.Chunks))
+	for idx, c := range d.Progress.Chunks {
+		if !c.Done() {
+			idxChan <- idx
+		}
+	}
+	close(idxChan)
+
+	var (
+		wg       sync.WaitGroup
+		failed   int32
+		errOnce  sync.Once
+		firstErr error
+	)
+	exit := func() bool {
+		return atomic.LoadInt32(&failed) > 0 || (d.Stopped != nil && d.Stopped())
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range idxChan {
+				c := d.Progress.chunk(idx)
+				for next := c.Next; next < c.End; {
+					if exit() {
+						return
+					}
+					end := next + step
+					if end > c.End {
+						end = c.End
+					}
+					if err := d.Read(d.BuildSQL(next, end)); err != nil {
+						errOnce.Do(func() { firstErr = err })
+						atomic.StoreInt32(&failed, 1)
+						return
+					}
+					d.Progress.advance(idx, end)
+					next = end
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return firstErr
+}
@@ -0,0 +1,112 @@
+package sql
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewChunkProgress(t *testing.T) {
+	p := NewChunkProgress("select * from t", 1, 100, 2)
+	assert.Len(t, p.Chunks, 8)
+	assert.Equal(t, Chunk{Start: 1, End: 14, Next: 1}, p.Chunks[0])
+	assert.Equal(t, Chunk{Start: 92, End: 101, Next: 92}, p.Chunks[7])
+	for i := 1; i < len(p.Chunks); i++ {
+		assert.Equal(t, p.Chunks[i-1].End, p.Chunks[i].Start)
+	}
+	assert.False(t, p.Done())
+
+	// 主键范围小于分片数时每个主键一个分片
+	p = NewChunkProgress("select * from t", 5, 7, 4)
+	assert.Len(t, p.Chunks, 3)
+
+	p = NewChunkProgress("select * from t", 7, 5, 4)
+	assert.Len(t, p.Chunks, 0)
+	assert.True(t, p.Done())
+}
+
+func TestChunkDumper(t *testing.T) {
+	p := NewChunkProgress("select * from t", 0, 999, 3)
+	var (
+		mux   sync.Mutex
+		execs []string
+		count int
+	)
+	dumper := &ChunkDumper{
+		Progress: p,
+		Workers:  3,
+		Step:     30,
+		BuildSQL: func(start, end int64) string {
+			return fmt.Sprintf("%d-%d", start, end)
+		},
+		Read: func(execSQL string) error {
+			mux.Lock()
+			defer mux.Unlock()
+			count++
+			if count == 10 {
+				return errors.New("read error")
+			}
+			execs = append(execs, execSQL)
+			return nil
+		},
+	}
+	assert.Error(t, dumper.Run())
+	assert.False(t, p.Done())
+
+	// 从中断处继续导出，每个范围只读取一次且覆盖全部主键
+	assert.NoError(t, dumper.Run())
+	assert.True(t, p.Done())
+	var covered int64
+	var starts []int
+	seen := make(map[string]bool)
+	for _, e := range execs {
+		assert.False(t, seen[e], e)
+		seen[e] = true
+		var start, end int64
+		fmt.Sscanf(e, "%d-%d", &start, &end)
+		assert.True(t, end-start <= 30)
+		covered += end - start
+		starts = append(starts, int(start))
+	}
+	sort.Ints(starts)
+	assert.Equal(t, 0, starts[0])
+	assert.Equal(t, int64(1000), covered)
+
+	p = NewChunkProgress("select * from t", 0, 99, 1)
+	dumper = &ChunkDumper{
+		Progress: p,
+		Workers:  1,
+		Step:     10,
+		BuildSQL: func(start, end int64) string { return "" },
+		Read:     func(string) error { return nil },
+		Stopped:  func() bool { return true },
+	}
+	assert.NoError(t, dumper.Run())
+	assert.False(t, p.Done())
+}
+
+func TestChunkProgressMeta(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestChunkProgressMeta")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	p, err := RestoreChunkProgress(dir)
+	assert.NoError(t, err)
+	assert.Nil(t, p)
+
+	p = NewChunkProgress("select * from t", 1, 10, 1)
+	p.advance(0, 3)
+	assert.NoError(t, WriteChunkProgress(dir, p))
+	restored, err := RestoreChunkProgress(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, p.SQL, restored.SQL)
+	assert.Equal(t, p.Max, restored.Max)
+	assert.Equal(t, p.Chunks, restored.Chunks)
+	assert.Equal(t, int64(3), restored.Chunks[0].Next)
+}
@@ -17,6 +17,7 @@ const (
 	DefaultDoneSqlsFile    = "sqls"
 	TimestampRecordsFile   = "timestamp.records"
 	CacheMapFile           = "cachemap.records"
+	ChunkRecordsFile       = "chunk.records"
 )
 
 const (
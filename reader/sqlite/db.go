@@ -0,0 +1,645 @@
+package sqlite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// 只读方式解析 SQLite 数据库文件，不依赖 cgo。WAL 模式下先读取 -wal 文件中已经提交的页，覆盖数据库文件中的旧版本，
+// 读取期间数据库被修改可能导致解析失败，在下一次读取时重试即可
+
+const (
+	headerSize   = 100
+	headerMagic  = "SQLite format 3\x00"
+	walSuffix    = "-wal"
+	walHeaderLen = 32
+	walFrameLen  = 24
+
+	pageInteriorTable = 0x05
+	pageLeafTable     = 0x0d
+
+	encodingUTF8    = 1
+	encodingUTF16LE = 2
+	encodingUTF16BE = 3
+
+	// 遍历 b-tree 的最大深度，防止损坏的文件导致无限递归
+	maxTreeDepth = 64
+)
+
+var errCorrupt = errors.New("sqlite database is corrupt or being modified")
+
+// snapshot 一次读取时数据库的快照
+type snapshot struct {
+	file       *os.File
+	pageSize   int
+	usableSize int
+	encoding   uint32
+	// walPages WAL 文件中已经提交的页的最新版本
+	walPages map[uint32][]byte
+	// 以下字段用于判断读取期间数据库文件是否被修改(包括 checkpoint)
+	path      string
+	size      int64
+	modTime   time.Time
+	walHeader []byte
+}
+
+func openSnapshot(path string) (*snapshot, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &snapshot{file: f, path: path}
+	if err = s.init(path); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *snapshot) init(path string) error {
+	info, err := s.file.Stat()
+	if err != nil {
+		return err
+	}
+	s.size, s.modTime = info.Size(), info.ModTime()
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(s.file, header); err != nil {
+		return fmt.Errorf("read sqlite header error: %v", err)
+	}
+	if string(header[:16]) != headerMagic {
+		return fmt.Errorf("%v is not a sqlite database", path)
+	}
+	s.pageSize = int(binary.BigEndian.Uint16(header[16:18]))
+	if s.pageSize == 1 {
+		s.pageSize = 65536
+	}
+	if s.pageSize < 512 || s.pageSize&(s.pageSize-1) != 0 {
+		return fmt.Errorf("invalid sqlite page size %d", s.pageSize)
+	}
+	s.usableSize = s.pageSize - int(header[20])
+	s.encoding = binary.BigEndian.Uint32(header[56:60])
+
+	wal, err := ioutil.ReadFile(path + walSuffix)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("read sqlite wal file error: %v", err)
+	}
+	if len(wal) >= walHeaderLen {
+		s.walHeader = wal[:walHeaderLen]
+	}
+	if s.walPages, err = parseWAL(wal, s.pageSize); err != nil {
+		return err
+	}
+	// WAL 中可能有更新的第一页，以其中的编码为准
+	if page, ok := s.walPages[1]; ok {
+		s.encoding = binary.BigEndian.Uint32(page[56:60])
+	}
+	if s.encoding == 0 {
+		s.encoding = encodingUTF8
+	}
+	return nil
+}
+
+// modified 判断打开快照之后数据库文件是否被修改或者 WAL 是否被重置，此时读取到的数据可能不一致，需要重新读取。
+// WAL 模式下新的事务只追加到 WAL 文件中，不会影响快照
+func (s *snapshot) modified() (bool, error) {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return false, err
+	}
+	if info.Size() != s.size || !info.ModTime().Equal(s.modTime) {
+		return true, nil
+	}
+	f, err := os.Open(s.path + walSuffix)
+	if os.IsNotExist(err) {
+		return s.walHeader != nil, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	header := make([]byte, walHeaderLen)
+	n, err := io.ReadFull(f, header)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return s.walHeader != nil, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return !bytes.Equal(header[:n], s.walHeader), nil
+}
+
+func (s *snapshot) close() error {
+	return s.file.Close()
+}
+
+// parseWAL 读取 WAL 文件中已经提交且校验和正确的帧，同一页以最后一次提交的版本为准
+func parseWAL(wal []byte, pageSize int) (map[uint32][]byte, error) {
+	pages := make(map[uint32][]byte)
+	if len(wal) < walHeaderLen {
+		return pages, nil
+	}
+	magic := binary.BigEndian.Uint32(wal[0:4])
+	if magic&0xfffffffe != 0x377f0682 {
+		return nil, errors.New("invalid sqlite wal header")
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if magic&1 == 1 {
+		order = binary.BigEndian
+	}
+	if int(binary.BigEndian.Uint32(wal[8:12])) != pageSize {
+		// 页大小不一致说明 WAL 文件已经失效
+		return pages, nil
+	}
+	s0, s1 := walChecksum(order, wal[:24], 0, 0)
+	if s0 != binary.BigEndian.Uint32(wal[24:28]) || s1 != binary.BigEndian.Uint32(wal[28:32]) {
+		return pages, nil
+	}
+	salt := wal[16:24]
+
+	pending := make(map[uint32][]byte)
+	for off := walHeaderLen; off+walFrameLen+pageSize <= len(wal); off += walFrameLen + pageSize {
+		frame := wal[off : off+walFrameLen]
+		data := wal[off+walFrameLen : off+walFrameLen+pageSize]
+		// salt 不一致说明是上一轮 WAL 留下的旧数据
+		if !bytes.Equal(frame[8:16], salt) {
+			break
+		}
+		s0, s1 = walChecksum(order, frame[:8], s0, s1)
+		s0, s1 = walChecksum(order, data, s0, s1)
+		if s0 != binary.BigEndian.Uint32(frame[16:20]) || s1 != binary.BigEndian.Uint32(frame[20:24]) {
+			break
+		}
+		pending[binary.BigEndian.Uint32(frame[0:4])] = data
+		// 数据库大小不为 0 的帧是事务的提交帧，之前的帧才是有效的
+		if binary.BigEndian.Uint32(frame[4:8]) != 0 {
+			for pgno, page := range pending {
+				pages[pgno] = page
+			}
+			pending = make(map[uint32][]byte)
+		}
+	}
+	return pages, nil
+}
+
+func walChecksum(order binary.ByteOrder, b []byte, s0, s1 uint32) (uint32, uint32) {
+	for i := 0; i+8 <= len(b); i += 8 {
+		s0 += order.Uint32(b[i:]) + s1
+		s1 += order.Uint32(b[i+4:]) + s0
+	}
+	return s0, s1
+}
+
+func (s *snapshot) page(pgno uint32) ([]byte, error) {
+	if pgno == 0 {
+		return nil, errCorrupt
+	}
+	if page, ok := s.walPages[pgno]; ok {
+		return page, nil
+	}
+	page := make([]byte, s.pageSize)
+	if _, err := s.file.ReadAt(page, int64(pgno-1)*int64(s.pageSize)); err != nil {
+		if err == io.EOF {
+			return nil, errCorrupt
+		}
+		return nil, err
+	}
+	return page, nil
+}
+
+// readVarint 解析 SQLite 的变长整数，返回值和占用的字节数，数据不完整时字节数为 0
+func readVarint(b []byte) (int64, int) {
+	var v uint64
+	for i := 0; i < 8; i++ {
+		if i >= len(b) {
+			return 0, 0
+		}
+		v = v<<7 | uint64(b[i]&0x7f)
+		if b[i]&0x80 == 0 {
+			return int64(v), i + 1
+		}
+	}
+	if len(b) < 9 {
+		return 0, 0
+	}
+	return int64(v<<8 | uint64(b[8])), 9
+}
+
+// row 表中的一行，values 按照建表语句中列的顺序排列
+type row struct {
+	rowid  int64
+	values []interface{}
+	size   int
+}
+
+// walkFunc 返回 false 时停止遍历
+type walkFunc func(r row) (bool, error)
+
+// walk 按照 rowid 从小到大遍历表中 rowid 大于 after 的行
+func (s *snapshot) walk(root uint32, after int64, fn walkFunc) error {
+	_, err := s.walkPage(root, after, fn, 0)
+	return err
+}
+
+func (s *snapshot) walkPage(pgno uint32, after int64, fn walkFunc, depth int) (bool, error) {
+	if depth > maxTreeDepth {
+		return false, errCorrupt
+	}
+	page, err := s.page(pgno)
+	if err != nil {
+		return false, err
+	}
+	hdr := 0
+	if pgno == 1 {
+		hdr = headerSize
+	}
+	if hdr+8 > len(page) {
+		return false, errCorrupt
+	}
+	cells := int(binary.BigEndian.Uint16(page[hdr+3 : hdr+5]))
+	switch page[hdr] {
+	case pageLeafTable:
+		ptrs := hdr + 8
+		if ptrs+2*cells > len(page) {
+			return false, errCorrupt
+		}
+		for i := 0; i < cells; i++ {
+			off := int(binary.BigEndian.Uint16(page[ptrs+2*i:]))
+			r, err := s.leafCell(page, off)
+			if err != nil {
+				return false, err
+			}
+			if r.rowid <= after {
+				continue
+			}
+			if ok, err := fn(r); !ok || err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	case pageInteriorTable:
+		ptrs := hdr + 12
+		if ptrs+2*cells > len(page) {
+			return false, errCorrupt
+		}
+		for i := 0; i < cells; i++ {
+			off := int(binary.BigEndian.Uint16(page[ptrs+2*i:]))
+			if off+4 > len(page) {
+				return false, errCorrupt
+			}
+			child := binary.BigEndian.Uint32(page[off:])
+			key, n := readVarint(page[off+4:])
+			if n == 0 {
+				return false, errCorrupt
+			}
+			// 左子树中的 rowid 都不大于 key，可以整体跳过
+			if key <= after {
+				continue
+			}
+			if ok, err := s.walkPage(child, after, fn, depth+1); !ok || err != nil {
+				return false, err
+			}
+		}
+		return s.walkPage(binary.BigEndian.Uint32(page[hdr+8:]), after, fn, depth+1)
+	}
+	return false, fmt.Errorf("unexpected sqlite page type %d of page %d, only rowid tables are supported", page[hdr], pgno)
+}
+
+// maxRowid 返回表中最大的 rowid，表为空时返回 0
+func (s *snapshot) maxRowid(root uint32) (int64, error) {
+	pgno := root
+	for depth := 0; depth <= maxTreeDepth; depth++ {
+		page, err := s.page(pgno)
+		if err != nil {
+			return 0, err
+		}
+		hdr := 0
+		if pgno == 1 {
+			hdr = headerSize
+		}
+		cells := int(binary.BigEndian.Uint16(page[hdr+3 : hdr+5]))
+		switch page[hdr] {
+		case pageInteriorTable:
+			pgno = binary.BigEndian.Uint32(page[hdr+8:])
+			continue
+		case pageLeafTable:
+			if cells == 0 {
+				return 0, nil
+			}
+			ptr := hdr + 8 + 2*(cells-1)
+			if ptr+2 > len(page) {
+				return 0, errCorrupt
+			}
+			off := int(binary.BigEndian.Uint16(page[ptr:]))
+			if off >= len(page) {
+				return 0, errCorrupt
+			}
+			_, n := readVarint(page[off:])
+			if n == 0 {
+				return 0, errCorrupt
+			}
+			rowid, m := readVarint(page[off+n:])
+			if m == 0 {
+				return 0, errCorrupt
+			}
+			return rowid, nil
+		default:
+			return 0, fmt.Errorf("unexpected sqlite page type %d of page %d", page[hdr], pgno)
+		}
+	}
+	return 0, errCorrupt
+}
+
+// leafCell 解析表的叶子页中的一行，超出页内大小的数据保存在溢出页中
+func (s *snapshot) leafCell(page []byte, off int) (row, error) {
+	if off >= len(page) {
+		return row{}, errCorrupt
+	}
+	size, n := readVarint(page[off:])
+	if n == 0 || size < 0 {
+		return row{}, errCorrupt
+	}
+	off += n
+	rowid, n := readVarint(page[off:])
+	if n == 0 {
+		return row{}, errCorrupt
+	}
+	off += n
+
+	u := s.usableSize
+	local := int(size)
+	maxLocal := u - 35
+	if local > maxLocal {
+		minLocal := (u-12)*32/255 - 23
+		local = minLocal + int((size-int64(minLocal))%int64(u-4))
+		if local > maxLocal {
+			local = minLocal
+		}
+	}
+	if off+local > len(page) {
+		return row{}, errCorrupt
+	}
+	payload := make([]byte, 0, size)
+	payload = append(payload, page[off:off+local]...)
+	if int64(local) < size {
+		if off+local+4 > len(page) {
+			return row{}, errCorrupt
+		}
+		next := binary.BigEndian.Uint32(page[off+local:])
+		for int64(len(payload)) < size {
+			overflow, err := s.page(next)
+			if err != nil {
+				return row{}, err
+			}
+			next = binary.BigEndian.Uint32(overflow)
+			remain := int(size) - len(payload)
+			if remain > u-4 {
+				remain = u - 4
+			}
+			payload = append(payload, overflow[4:4+remain]...)
+		}
+	}
+	values, err := s.decodeRecord(payload)
+	if err != nil {
+		return row{}, err
+	}
+	return row{rowid: rowid, values: values, size: int(size)}, nil
+}
+
+// decodeRecord 解析记录格式，INTEGER 为 int64，REAL 为 float64，TEXT 为 string，BLOB 为 []byte，NULL 为 nil
+func (s *snapshot) decodeRecord(b []byte) ([]interface{}, error) {
+	hdrSize, n := readVarint(b)
+	if n == 0 || hdrSize < int64(n) || hdrSize > int64(len(b)) {
+		return nil, errCorrupt
+	}
+	var types []int64
+	for pos := n; pos < int(hdrSize); {
+		t, m := readVarint(b[pos:hdrSize])
+		if m == 0 {
+			return nil, errCorrupt
+		}
+		types = append(types, t)
+		pos += m
+	}
+	values := make([]interface{}, 0, len(types))
+	body := b[hdrSize:]
+	for _, t := range types {
+		var size int
+		switch {
+		case t == 0, t == 8, t == 9:
+		case t >= 1 && t <= 4:
+			size = int(t)
+		case t == 5:
+			size = 6
+		case t == 6, t == 7:
+			size = 8
+		case t >= 12:
+			size = int(t-12) / 2
+		default:
+			return nil, errCorrupt
+		}
+		if size > len(body) {
+			return nil, errCorrupt
+		}
+		v := body[:size]
+		body = body[size:]
+		switch {
+		case t == 0:
+			values = append(values, nil)
+		case t == 8:
+			values = append(values, int64(0))
+		case t == 9:
+			values = append(values, int64(1))
+		case t <= 6:
+			// 大端序的有符号整数
+			x := int64(int8(v[0]))
+			for _, c := range v[1:] {
+				x = x<<8 | int64(c)
+			}
+			values = append(values, x)
+		case t == 7:
+			values = append(values, math.Float64frombits(binary.BigEndian.Uint64(v)))
+		case t%2 == 0:
+			values = append(values, append([]byte(nil), v...))
+		default:
+			values = append(values, s.decodeText(v))
+		}
+	}
+	return values, nil
+}
+
+func (s *snapshot) decodeText(b []byte) string {
+	if s.encoding == encodingUTF8 {
+		return string(b)
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if s.encoding == encodingUTF16BE {
+		order = binary.BigEndian
+	}
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = order.Uint16(b[2*i:])
+	}
+	return string(utf16.Decode(u))
+}
+
+// table 建表语句中的表结构
+type table struct {
+	name    string
+	root    uint32
+	columns []string
+	// rowidColumn 为 INTEGER PRIMARY KEY 列的下标，该列的值保存在 rowid 中，记录中为 NULL，没有时为 -1
+	rowidColumn int
+	// realColumns 为 REAL 亲和性的列，SQLite 会把其中的整数值按整数保存，读取时需要转换为浮点数
+	realColumns map[int]bool
+	// withoutRowid WITHOUT ROWID 的表以主键组织，不支持增量读取
+	withoutRowid bool
+}
+
+// tables 读取 sqlite_master 中的所有表
+func (s *snapshot) tables() (map[string]*table, error) {
+	tables := make(map[string]*table)
+	err := s.walk(1, math.MinInt64, func(r row) (bool, error) {
+		if len(r.values) < 5 {
+			return true, nil
+		}
+		typ, _ := r.values[0].(string)
+		name, _ := r.values[1].(string)
+		root, _ := r.values[3].(int64)
+		sql, _ := r.values[4].(string)
+		if typ != "table" || root <= 0 || strings.HasPrefix(name, "sqlite_") {
+			return true, nil
+		}
+		t, err := parseCreateTable(name, sql)
+		if err != nil {
+			return false, err
+		}
+		t.root = uint32(root)
+		tables[name] = t
+		return true, nil
+	})
+	return tables, err
+}
+
+// parseCreateTable 从建表语句中解析列名以及 INTEGER PRIMARY KEY 列，不支持 WITHOUT ROWID 的表
+func parseCreateTable(name, sql string) (*table, error) {
+	start, end := strings.Index(sql, "("), strings.LastIndex(sql, ")")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("can not parse create table statement of %v: %v", name, sql)
+	}
+	if strings.Contains(strings.ToUpper(sql[end:]), "WITHOUT") {
+		return &table{name: name, rowidColumn: -1, withoutRowid: true}, nil
+	}
+	t := &table{name: name, rowidColumn: -1, realColumns: make(map[int]bool)}
+	var pkColumns []string
+	types := make(map[string]string)
+	for _, def := range splitDefinitions(sql[start+1 : end]) {
+		fields := strings.Fields(def)
+		if len(fields) == 0 {
+			continue
+		}
+		upper := strings.ToUpper(def)
+		constraint := strings.ToUpper(fields[0])
+		// 具名的表约束 CONSTRAINT name PRIMARY KEY (...)
+		if constraint == "CONSTRAINT" && len(fields) > 2 {
+			constraint = strings.ToUpper(fields[2])
+		}
+		switch constraint {
+		case "CONSTRAINT", "UNIQUE", "CHECK", "FOREIGN":
+			continue
+		case "PRIMARY":
+			if open, end := strings.Index(def, "("), strings.LastIndex(def, ")"); open >= 0 && end > open {
+				pkColumns = splitDefinitions(def[open+1 : end])
+			}
+			continue
+		}
+		column, rest := splitColumnName(strings.TrimSpace(def))
+		restFields := strings.Fields(strings.ToUpper(rest))
+		if len(restFields) > 0 {
+			types[column] = restFields[0]
+			if isRealType(restFields[0]) {
+				t.realColumns[len(t.columns)] = true
+			}
+		}
+		if len(restFields) > 0 && restFields[0] == "INTEGER" && strings.Contains(upper, "PRIMARY KEY") && !strings.Contains(upper, "PRIMARY KEY DESC") {
+			t.rowidColumn = len(t.columns)
+		}
+		t.columns = append(t.columns, column)
+	}
+	if len(pkColumns) == 1 {
+		pk, _ := splitColumnName(strings.TrimSpace(pkColumns[0]))
+		for i, c := range t.columns {
+			if strings.EqualFold(c, pk) && types[c] == "INTEGER" {
+				t.rowidColumn = i
+			}
+		}
+	}
+	return t, nil
+}
+
+// isRealType 按照 SQLite 的类型亲和性规则判断声明的类型是否为 REAL
+func isRealType(typ string) bool {
+	if strings.Contains(typ, "INT") {
+		return false
+	}
+	return strings.Contains(typ, "REAL") || strings.Contains(typ, "FLOA") || strings.Contains(typ, "DOUB")
+}
+
+// splitDefinitions 按照不在括号以及引号中的逗号分隔
+func splitDefinitions(s string) []string {
+	var (
+		defs  []string
+		depth int
+		quote rune
+		last  int
+	)
+	for i, c := range s {
+		switch {
+		case quote != 0:
+			if c == quote || (quote == '[' && c == ']') {
+				quote = 0
+			}
+		case c == '"' || c == '\'' || c == '`' || c == '[':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			defs = append(defs, s[last:i])
+			last = i + 1
+		}
+	}
+	return append(defs, s[last:])
+}
+
+// splitColumnName 返回去掉引号的列名以及列定义的剩余部分
+func splitColumnName(def string) (string, string) {
+	if def == "" {
+		return "", ""
+	}
+	var closing byte
+	switch def[0] {
+	case '"', '\'', '`':
+		closing = def[0]
+	case '[':
+		closing = ']'
+	}
+	if closing != 0 {
+		if end := strings.IndexByte(def[1:], closing); end >= 0 {
+			return def[1 : end+1], def[end+2:]
+		}
+	}
+	if i := strings.IndexAny(def, " \t\r\n"); i >= 0 {
+		return def[:i], def[i:]
+	}
+	return def, ""
+}
@@ -0,0 +1,586 @@
+package sqlite
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/qiniu/log"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/reader"
+	. "github.com/qiniu/logkit/reader/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+var (
+	_ reader.DaemonReader = &Reader{}
+	_ reader.StatsReader  = &Reader{}
+	_ reader.DataReader   = &Reader{}
+	_ reader.Reader       = &Reader{}
+)
+
+// 数据中除了表的列以外的字段
+const (
+	FieldTable = "sqlite_table"
+	FieldRowid = "sqlite_rowid"
+)
+
+const (
+	DefaultPollInterval = 10 * time.Second
+	DefaultBatchSize    = 1000
+)
+
+func init() {
+	reader.RegisterConstructor(ModeSQLite, NewReader)
+}
+
+// tableSpec 读取的表，column 不为空时按该列的值增量读取，否则按 rowid 增量读取
+type tableSpec struct {
+	name   string
+	column string
+}
+
+// cursor 是每张表的读取进度，按列读取时以 (列的值, rowid) 排序，只读取大于进度的行
+type cursor struct {
+	Rowid   int64       `json:"rowid"`
+	Updated interface{} `json:"updated,omitempty"`
+}
+
+type readInfo struct {
+	data   Data
+	table  string
+	cursor cursor
+	bytes  int64
+}
+
+type Reader struct {
+	meta *reader.Meta
+	// Note: 原子操作，用于表示 reader 整体的运行状态
+	status int32
+
+	stopChan chan struct{}
+	readChan chan readInfo
+
+	stats     StatsInfo
+	statsLock sync.RWMutex
+
+	path         string
+	specs        []tableSpec
+	pollInterval time.Duration
+	batchSize    int
+	whence       string
+
+	// 已经被上层读取的进度，SyncMeta 时写入 meta
+	stateLock sync.Mutex
+	cursors   map[string]cursor
+	dirty     bool
+
+	// 以下字段只在读取数据库的 goroutine 中使用，记录已经放入 readChan 的进度
+	fresh   bool // meta 中没有读取记录
+	fetched map[string]cursor
+}
+
+func NewReader(meta *reader.Meta, c conf.MapConf) (reader.Reader, error) {
+	path, err := c.GetString(KeySQLitePath)
+	if err != nil {
+		return nil, err
+	}
+	tables, _ := c.GetStringOr(KeySQLiteTables, "")
+	specs, err := parseTables(tables)
+	if err != nil {
+		return nil, err
+	}
+	pollIntervalStr, _ := c.GetStringOr(KeySQLitePollInterval, DefaultPollInterval.String())
+	pollInterval, err := time.ParseDuration(pollIntervalStr)
+	if err != nil {
+		return nil, fmt.Errorf("parse %v %q error: %v", KeySQLitePollInterval, pollIntervalStr, err)
+	}
+	if pollInterval <= 0 {
+		pollInterval = DefaultPollInterval
+	}
+	batchSize, _ := c.GetIntOr(KeySQLiteBatchSize, DefaultBatchSize)
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	whence, _ := c.GetStringOr(KeyWhence, WhenceOldest)
+	if whence != WhenceOldest && whence != WhenceNewest {
+		return nil, fmt.Errorf("%v should be %v or %v", KeyWhence, WhenceOldest, WhenceNewest)
+	}
+
+	r := &Reader{
+		meta:         meta,
+		status:       StatusInit,
+		stopChan:     make(chan struct{}),
+		readChan:     make(chan readInfo, 100),
+		path:         path,
+		specs:        specs,
+		pollInterval: pollInterval,
+		batchSize:    batchSize,
+		whence:       whence,
+		cursors:      make(map[string]cursor),
+		fetched:      make(map[string]cursor),
+	}
+
+	cursors, err := readState(meta)
+	if err != nil {
+		if os.IsNotExist(err) {
+			log.Debugf("Runner[%v] %v recover from meta error %v, ignore...", meta.RunnerName, r.Name(), err)
+		} else {
+			log.Warnf("Runner[%v] %v recover from meta error %v, ignore...", meta.RunnerName, r.Name(), err)
+		}
+		r.fresh = true
+		return r, nil
+	}
+	for name, cur := range cursors {
+		r.cursors[name] = cur
+		r.fetched[name] = cur
+	}
+	return r, nil
+}
+
+// parseTables 解析 sqlite_tables，格式为 "events,devices:updated_at"，为空时读取所有表
+func parseTables(s string) ([]tableSpec, error) {
+	var specs []tableSpec
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		spec := tableSpec{name: item}
+		if idx := strings.Index(item, ":"); idx >= 0 {
+			spec.name, spec.column = strings.TrimSpace(item[:idx]), strings.TrimSpace(item[idx+1:])
+			if spec.name == "" || spec.column == "" {
+				return nil, fmt.Errorf("invalid %v %q, should be like table or table:column", KeySQLiteTables, item)
+			}
+		}
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+func readState(meta *reader.Meta) (map[string]cursor, error) {
+	_, _, bufsize, err := meta.ReadBufMeta()
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, bufsize)
+	if _, err = meta.ReadBuf(buf); err != nil {
+		return nil, err
+	}
+	var cursors map[string]cursor
+	decoder := json.NewDecoder(bytes.NewReader(buf))
+	decoder.UseNumber()
+	if err = decoder.Decode(&cursors); err != nil {
+		return nil, err
+	}
+	// 数字类型的列按照 SQLite 的类型恢复为整数或者浮点数
+	for name, cur := range cursors {
+		if n, ok := cur.Updated.(json.Number); ok {
+			if i, err := n.Int64(); err == nil {
+				cur.Updated = i
+			} else if f, err := n.Float64(); err == nil {
+				cur.Updated = f
+			}
+			cursors[name] = cur
+		}
+	}
+	return cursors, nil
+}
+
+func (r *Reader) isStopping() bool {
+	return atomic.LoadInt32(&r.status) == StatusStopping
+}
+
+func (r *Reader) hasStopped() bool {
+	return atomic.LoadInt32(&r.status) == StatusStopped
+}
+
+func (r *Reader) Name() string {
+	return "sqlite:" + r.path
+}
+
+func (r *Reader) SetMode(mode string, v interface{}) error {
+	return errors.New("sqlite reader does not support read mode")
+}
+
+func (r *Reader) setStatsError(err string) {
+	r.statsLock.Lock()
+	defer r.statsLock.Unlock()
+	r.stats.LastError = err
+}
+
+func (r *Reader) Start() error {
+	if r.isStopping() || r.hasStopped() {
+		return errors.New("reader is stopping or has stopped")
+	}
+	if !atomic.CompareAndSwapInt32(&r.status, StatusInit, StatusRunning) {
+		log.Warnf("Runner[%v] %q daemon has already started and is running", r.meta.RunnerName, r.Name())
+		return nil
+	}
+
+	go r.run()
+	log.Infof("Runner[%v] %q daemon has started", r.meta.RunnerName, r.Name())
+	return nil
+}
+
+func (r *Reader) run() {
+	defer func() {
+		atomic.StoreInt32(&r.status, StatusStopped)
+		close(r.readChan)
+		log.Infof("Runner[%v] %q daemon has stopped from running", r.meta.RunnerName, r.Name())
+	}()
+
+	ticker := time.NewTicker(r.pollInterval)
+	defer ticker.Stop()
+	for {
+		err := r.poll()
+		if r.isStopping() || r.hasStopped() {
+			return
+		}
+		// 读取期间数据库被修改时在下一次读取时重试
+		if err == errCorrupt {
+			log.Debugf("Runner[%v] %q database is being modified, retry later", r.meta.RunnerName, r.Name())
+		} else if err != nil {
+			log.Errorf("Runner[%v] %q read database error: %v", r.meta.RunnerName, r.Name(), err)
+			r.setStatsError(err.Error())
+		}
+		select {
+		case <-r.stopChan:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll 从数据库的快照中读取每张表的新数据，确认读取期间数据库没有被修改之后才放入 readChan
+func (r *Reader) poll() error {
+	snap, err := openSnapshot(r.path)
+	if err != nil {
+		return err
+	}
+	defer snap.close()
+	tables, err := snap.tables()
+	if err != nil {
+		return err
+	}
+	specs := r.specs
+	if len(specs) == 0 {
+		for name := range tables {
+			specs = append(specs, tableSpec{name: name})
+		}
+		sort.Slice(specs, func(i, j int) bool { return specs[i].name < specs[j].name })
+	}
+
+	var (
+		infos  []readInfo
+		latest = make(map[string]cursor)
+		resets []string
+	)
+	for _, spec := range specs {
+		t, ok := tables[spec.name]
+		if !ok {
+			log.Warnf("Runner[%v] %q table %v does not exist, skip it", r.meta.RunnerName, r.Name(), spec.name)
+			continue
+		}
+		if t.withoutRowid {
+			log.Warnf("Runner[%v] %q table %v is a WITHOUT ROWID table which is not supported, skip it", r.meta.RunnerName, r.Name(), spec.name)
+			continue
+		}
+		column := -1
+		if spec.column != "" {
+			for i, c := range t.columns {
+				if strings.EqualFold(c, spec.column) {
+					column = i
+				}
+			}
+			if column < 0 {
+				log.Warnf("Runner[%v] %q column %v does not exist in table %v, skip it", r.meta.RunnerName, r.Name(), spec.column, spec.name)
+				continue
+			}
+		}
+
+		cur := r.fetched[t.name]
+		if r.fresh && r.whence == WhenceNewest {
+			// 没有读取记录且从最新位置读取时跳过表中已有的数据
+			if latest[t.name], err = r.newest(snap, t, column); err != nil {
+				return err
+			}
+			continue
+		}
+		if column < 0 {
+			max, err := snap.maxRowid(t.root)
+			if err != nil {
+				return err
+			}
+			// 最大的 rowid 小于读取进度说明表被清空或者重建，从头读取
+			if max < cur.Rowid {
+				log.Warnf("Runner[%v] %q max rowid %d of table %v is less than read position %d, read from the beginning", r.meta.RunnerName, r.Name(), max, t.name, cur.Rowid)
+				cur = cursor{}
+				resets = append(resets, t.name)
+			}
+		}
+		rows, err := r.scan(snap, t, column, cur)
+		if err != nil {
+			return err
+		}
+		infos = append(infos, rows...)
+	}
+	if modified, err := snap.modified(); err != nil {
+		return err
+	} else if modified {
+		return errCorrupt
+	}
+
+	for _, name := range resets {
+		r.fetched[name] = cursor{}
+		r.commit(readInfo{table: name})
+	}
+	if r.fresh {
+		r.fresh = false
+		if r.whence == WhenceNewest {
+			for name, cur := range latest {
+				r.fetched[name] = cur
+				r.commit(readInfo{table: name, cursor: cur})
+			}
+			return nil
+		}
+	}
+	for _, info := range infos {
+		select {
+		case <-r.stopChan:
+			return nil
+		case r.readChan <- info:
+			r.fetched[info.table] = info.cursor
+		}
+	}
+	return nil
+}
+
+// scan 按照读取进度顺序读取表中最多 batchSize 行新数据
+func (r *Reader) scan(snap *snapshot, t *table, column int, cur cursor) ([]readInfo, error) {
+	var infos []readInfo
+	if column < 0 {
+		err := snap.walk(t.root, cur.Rowid, func(rw row) (bool, error) {
+			infos = append(infos, r.convert(t, rw, cursor{Rowid: rw.rowid}))
+			return len(infos) < r.batchSize, nil
+		})
+		return infos, err
+	}
+
+	// 按列读取时需要遍历整张表，只保留最小的 batchSize 行，避免占用过多内存
+	sortInfos := func() {
+		sort.Slice(infos, func(i, j int) bool { return compareCursor(infos[i].cursor, infos[j].cursor) < 0 })
+		if len(infos) > r.batchSize {
+			infos = infos[:r.batchSize]
+		}
+	}
+	err := snap.walk(t.root, math.MinInt64, func(rw row) (bool, error) {
+		next := cursor{Rowid: rw.rowid, Updated: t.value(rw, column)}
+		if compareCursor(next, cur) <= 0 {
+			return true, nil
+		}
+		infos = append(infos, r.convert(t, rw, next))
+		if len(infos) >= 2*r.batchSize {
+			sortInfos()
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortInfos()
+	return infos, nil
+}
+
+// newest 返回表中最新一行的读取进度
+func (r *Reader) newest(snap *snapshot, t *table, column int) (cursor, error) {
+	if column < 0 {
+		max, err := snap.maxRowid(t.root)
+		return cursor{Rowid: max}, err
+	}
+	var cur cursor
+	err := snap.walk(t.root, math.MinInt64, func(rw row) (bool, error) {
+		next := cursor{Rowid: rw.rowid, Updated: t.value(rw, column)}
+		if compareCursor(next, cur) > 0 {
+			cur = next
+		}
+		return true, nil
+	})
+	return cur, err
+}
+
+func (r *Reader) convert(t *table, rw row, cur cursor) readInfo {
+	data := make(Data, len(t.columns)+2)
+	for i, name := range t.columns {
+		switch v := t.value(rw, i).(type) {
+		case nil:
+		case []byte:
+			data[name] = base64.StdEncoding.EncodeToString(v)
+		default:
+			data[name] = v
+		}
+	}
+	data[FieldTable] = t.name
+	data[FieldRowid] = rw.rowid
+	return readInfo{data: data, table: t.name, cursor: cur, bytes: int64(rw.size)}
+}
+
+// value 返回行中第 i 列的值，INTEGER PRIMARY KEY 列的值为 rowid，ALTER TABLE 新增的列在旧的行中不存在，为 NULL
+func (t *table) value(rw row, i int) interface{} {
+	if i == t.rowidColumn {
+		return rw.rowid
+	}
+	if i >= len(rw.values) {
+		return nil
+	}
+	if v, ok := rw.values[i].(int64); ok && t.realColumns[i] {
+		return float64(v)
+	}
+	return rw.values[i]
+}
+
+func compareCursor(a, b cursor) int {
+	if c := compareValue(a.Updated, b.Updated); c != 0 {
+		return c
+	}
+	switch {
+	case a.Rowid < b.Rowid:
+		return -1
+	case a.Rowid > b.Rowid:
+		return 1
+	}
+	return 0
+}
+
+// compareValue 按照 SQLite 的规则比较两个值，NULL 小于数字，数字小于文本，文本小于 BLOB，文本按照字节比较
+func compareValue(a, b interface{}) int {
+	ca, cb := valueClass(a), valueClass(b)
+	if ca != cb {
+		if ca < cb {
+			return -1
+		}
+		return 1
+	}
+	switch x := a.(type) {
+	case int64:
+		if y, ok := b.(int64); ok {
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+			return 0
+		}
+	case string:
+		return strings.Compare(x, b.(string))
+	case []byte:
+		return bytes.Compare(x, b.([]byte))
+	}
+	if ca == 1 {
+		x, y := toFloat(a), toFloat(b)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	}
+	return 0
+}
+
+func valueClass(v interface{}) int {
+	switch v.(type) {
+	case nil:
+		return 0
+	case int64, float64:
+		return 1
+	case string:
+		return 2
+	}
+	return 3
+}
+
+func toFloat(v interface{}) float64 {
+	if i, ok := v.(int64); ok {
+		return float64(i)
+	}
+	return v.(float64)
+}
+
+// commit 记录已经被上层读取的数据
+func (r *Reader) commit(info readInfo) {
+	r.stateLock.Lock()
+	defer r.stateLock.Unlock()
+	r.cursors[info.table] = info.cursor
+	r.dirty = true
+}
+
+func (r *Reader) Source() string {
+	return r.path
+}
+
+func (r *Reader) ReadLine() (string, error) {
+	return "", errors.New("method ReadLine is not supported, please use ReadData")
+}
+
+func (r *Reader) ReadData() (Data, int64, error) {
+	timer := time.NewTimer(time.Second)
+	defer timer.Stop()
+	select {
+	case info, ok := <-r.readChan:
+		if !ok {
+			return nil, 0, nil
+		}
+		r.commit(info)
+		return info.data, info.bytes, nil
+	case <-timer.C:
+	}
+
+	return nil, 0, nil
+}
+
+func (r *Reader) Status() StatsInfo {
+	r.statsLock.RLock()
+	defer r.statsLock.RUnlock()
+	return r.stats
+}
+
+func (r *Reader) SyncMeta() {
+	r.stateLock.Lock()
+	if !r.dirty {
+		r.stateLock.Unlock()
+		return
+	}
+	buf, err := json.Marshal(r.cursors)
+	r.dirty = false
+	r.stateLock.Unlock()
+
+	if err != nil {
+		log.Errorf("Runner[%v] %v marshal meta error %v", r.meta.RunnerName, r.Name(), err)
+		return
+	}
+	if err = r.meta.WriteBuf(buf, 0, 0, len(buf)); err != nil {
+		log.Errorf("Runner[%v] %v SyncMeta error %v", r.meta.RunnerName, r.Name(), err)
+	}
+}
+
+func (r *Reader) Close() error {
+	if !atomic.CompareAndSwapInt32(&r.status, StatusRunning, StatusStopping) {
+		log.Warnf("Runner[%v] reader %q is not running, close operation ignored", r.meta.RunnerName, r.Name())
+		return nil
+	}
+	log.Debugf("Runner[%v] %q daemon is stopping", r.meta.RunnerName, r.Name())
+	close(r.stopChan)
+	return nil
+}
@@ -0,0 +1,227 @@
+package sqlite
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/reader"
+	. "github.com/qiniu/logkit/reader/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+// testdata 中的数据库由 sqlite3 生成，页大小为 1024:
+// app.db 中 events 表有 200 行，devices 表有 a(updated_at=5)、b(updated_at=3) 两行，kv 为 WITHOUT ROWID 的表；
+// app_wal.db 为 WAL 模式，WAL 中新增 events 的 201(msg 需要溢出页)、202 两行，更新 b 的 updated_at 为 10，新增 c(updated_at=7)
+func TestSnapshot(t *testing.T) {
+	s, err := openSnapshot("testdata/app.db")
+	require.NoError(t, err)
+	defer s.close()
+	assert.Empty(t, s.walPages)
+	tables, err := s.tables()
+	require.NoError(t, err)
+	require.Len(t, tables, 3)
+	events := tables["events"]
+	assert.Equal(t, []string{"id", "level", "msg", "cost"}, events.columns)
+	assert.Equal(t, 0, events.rowidColumn)
+	devices := tables["devices"]
+	assert.Equal(t, []string{"name", "updated_at", "payload"}, devices.columns)
+	assert.Equal(t, -1, devices.rowidColumn)
+	assert.True(t, tables["kv"].withoutRowid)
+
+	max, err := s.maxRowid(events.root)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(200), max)
+	var rowids []int64
+	assert.NoError(t, s.walk(events.root, 0, func(r row) (bool, error) {
+		rowids = append(rowids, r.rowid)
+		return true, nil
+	}))
+	require.Len(t, rowids, 200)
+	assert.Equal(t, int64(200), rowids[199])
+	var first row
+	assert.NoError(t, s.walk(events.root, 149, func(r row) (bool, error) {
+		first = r
+		return false, nil
+	}))
+	assert.Equal(t, int64(150), first.rowid)
+	assert.Equal(t, int64(150), events.value(first, 0))
+	assert.Equal(t, "error", events.value(first, 1))
+	assert.Equal(t, "event 150", events.value(first, 2))
+	// REAL 列中的整数值需要转换为浮点数
+	assert.Equal(t, float64(75), events.value(first, 3))
+
+	ws, err := openSnapshot("testdata/app_wal.db")
+	require.NoError(t, err)
+	defer ws.close()
+	assert.NotEmpty(t, ws.walPages)
+	tables, err = ws.tables()
+	require.NoError(t, err)
+	var rows []row
+	assert.NoError(t, ws.walk(tables["events"].root, 200, func(r row) (bool, error) {
+		rows = append(rows, r)
+		return true, nil
+	}))
+	require.Len(t, rows, 2)
+	assert.Equal(t, []interface{}{nil, "warn", strings.Repeat("x", 3000), int64(1)}, rows[0].values)
+	assert.Equal(t, []interface{}{nil, "info", "last", nil}, rows[1].values)
+	modified, err := ws.modified()
+	assert.NoError(t, err)
+	assert.False(t, modified)
+}
+
+func TestParseCreateTable(t *testing.T) {
+	tb, err := parseCreateTable("t", "CREATE TABLE t(\n  [a b] INTEGER, 'c' VARCHAR(10, 2) NOT NULL DEFAULT 'x,y', `d` DOUBLE PRECISION,\n  e, CONSTRAINT pk PRIMARY KEY (\"a b\"), FOREIGN KEY(e) REFERENCES p(id))")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a b", "c", "d", "e"}, tb.columns)
+	assert.Equal(t, 0, tb.rowidColumn)
+	assert.Equal(t, map[int]bool{2: true}, tb.realColumns)
+
+	tb, err = parseCreateTable("t", "CREATE TABLE t(id integer primary key desc, v)")
+	assert.NoError(t, err)
+	assert.Equal(t, -1, tb.rowidColumn)
+
+	_, err = parseCreateTable("t", "CREATE TABLE t AS SELECT 1")
+	assert.Error(t, err)
+}
+
+func copyFile(t *testing.T, src, dst string) {
+	data, err := ioutil.ReadFile(src)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(dst+".tmp", data, 0644))
+	require.NoError(t, os.Rename(dst+".tmp", dst))
+}
+
+func newTestReader(t *testing.T, c conf.MapConf) *Reader {
+	meta, err := reader.NewMetaWithConf(c)
+	require.NoError(t, err)
+	r, err := NewReader(meta, c)
+	require.NoError(t, err)
+	require.NoError(t, r.(*Reader).Start())
+	return r.(*Reader)
+}
+
+func readDatas(t *testing.T, r *Reader, n int) []Data {
+	var datas []Data
+	deadline := time.Now().Add(5 * time.Second)
+	for len(datas) < n && time.Now().Before(deadline) {
+		data, _, err := r.ReadData()
+		assert.NoError(t, err)
+		if data != nil {
+			datas = append(datas, data)
+		}
+	}
+	require.Len(t, datas, n)
+	return datas
+}
+
+func TestReader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlite")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.db")
+	copyFile(t, "testdata/app.db", path)
+
+	c := conf.MapConf{
+		KeyMetaPath:           filepath.Join(dir, "meta"),
+		KeyMode:               ModeSQLite,
+		KeyRunnerName:         "TestSQLiteReader",
+		KeySQLitePath:         path,
+		KeySQLiteTables:       "events, devices:updated_at, missing",
+		KeySQLitePollInterval: "50ms",
+		KeySQLiteBatchSize:    "150",
+	}
+	r := newTestReader(t, c)
+	assert.Equal(t, "sqlite:"+path, r.Name())
+	datas := readDatas(t, r, 202)
+	assert.Equal(t, Data{
+		"id":       int64(1),
+		"level":    "info",
+		"msg":      "event 1",
+		"cost":     0.5,
+		FieldTable: "events",
+		FieldRowid: int64(1),
+	}, datas[0])
+	// 每张表每次最多读取 150 行，devices 按 updated_at 排序
+	assert.Equal(t, int64(150), datas[149][FieldRowid])
+	assert.Equal(t, Data{"name": "b", "updated_at": int64(3), FieldTable: "devices", FieldRowid: int64(2)}, datas[150])
+	assert.Equal(t, Data{"name": "a", "updated_at": int64(5), "payload": "AQI=", FieldTable: "devices", FieldRowid: int64(1)}, datas[151])
+	assert.Equal(t, int64(151), datas[152][FieldRowid])
+	assert.Equal(t, int64(200), datas[201][FieldRowid])
+	r.SyncMeta()
+	assert.NoError(t, r.Close())
+
+	// 重启后只读取 WAL 中新增以及更新的行
+	copyFile(t, "testdata/app_wal.db", path)
+	copyFile(t, "testdata/app_wal.db-wal", path+walSuffix)
+	r = newTestReader(t, c)
+	datas = readDatas(t, r, 4)
+	assert.Equal(t, "warn", datas[0]["level"])
+	assert.Equal(t, Data{"id": int64(202), "level": "info", "msg": "last", FieldTable: "events", FieldRowid: int64(202)}, datas[1])
+	assert.Equal(t, "c", datas[2]["name"])
+	assert.Equal(t, Data{"name": "b", "updated_at": int64(10), FieldTable: "devices", FieldRowid: int64(2)}, datas[3])
+	data, _, err := r.ReadData()
+	assert.NoError(t, err)
+	assert.Nil(t, data)
+	r.SyncMeta()
+	assert.NoError(t, r.Close())
+
+	cursors, err := readState(r.meta)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]cursor{
+		"events":  {Rowid: 202},
+		"devices": {Rowid: 2, Updated: int64(10)},
+	}, cursors)
+
+	// 表被清空或者重建后从头读取
+	copyFile(t, "testdata/app.db", path)
+	assert.NoError(t, os.Remove(path+walSuffix))
+	c[KeySQLiteTables] = "events"
+	r = newTestReader(t, c)
+	datas = readDatas(t, r, 150)
+	assert.Equal(t, int64(1), datas[0][FieldRowid])
+	assert.NoError(t, r.Close())
+
+	// 没有读取记录时从最新位置读取
+	c[KeyMetaPath] = filepath.Join(dir, "meta_newest")
+	c[KeyWhence] = WhenceNewest
+	c[KeySQLiteTables] = "events,devices:updated_at"
+	r = newTestReader(t, c)
+	data, _, err = r.ReadData()
+	assert.NoError(t, err)
+	assert.Nil(t, data)
+	r.SyncMeta()
+	assert.NoError(t, r.Close())
+	cursors, err = readState(r.meta)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]cursor{
+		"events":  {Rowid: 200},
+		"devices": {Rowid: 1, Updated: int64(5)},
+	}, cursors)
+}
+
+func TestNewReaderError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlite")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	c := conf.MapConf{
+		KeyMetaPath:   filepath.Join(dir, "meta"),
+		KeyMode:       ModeSQLite,
+		KeyRunnerName: "TestSQLiteReader",
+	}
+	meta, err := reader.NewMetaWithConf(c)
+	require.NoError(t, err)
+	_, err = NewReader(meta, c)
+	assert.Error(t, err)
+	c[KeySQLitePath] = "app.db"
+	c[KeySQLiteTables] = "events,:updated_at"
+	_, err = NewReader(meta, c)
+	assert.Error(t, err)
+}
@@ -0,0 +1,264 @@
+package statsd
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/qiniu/logkit/reader/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const (
+	MetricTypeCounter = "counter"
+	MetricTypeGauge   = "gauge"
+	MetricTypeTimer   = "timer"
+	MetricTypeSet     = "set"
+)
+
+// 数据中的保留字段，与之重名的 tag 会加上 tag_ 前缀
+const (
+	KeyMetricName  = "metric"
+	KeyMetricValue = "value"
+	KeyMetricType  = "metric_type"
+	KeyCount       = "count"
+	KeySum         = "sum"
+	KeyMean        = "mean"
+	KeyMin         = "min"
+	KeyMax         = "max"
+	tagPrefix      = "tag_"
+)
+
+// Metric 是 statsd 协议中的一个指标
+type Metric struct {
+	Name  string
+	Type  string
+	Value float64
+	// Delta gauge 的值带有 +/- 符号时表示增量
+	Delta bool
+	// SetValue set 类型的原始值
+	SetValue   string
+	SampleRate float64
+	Tags       map[string]string
+}
+
+// ParseLine 解析一行 statsd/dogstatsd 数据，格式为 name:value|type[|@rate][|#tag1:v1,tag2]
+func ParseLine(line string) (Metric, error) {
+	m := Metric{SampleRate: 1}
+	idx := strings.LastIndex(line, ":")
+	pipe := strings.Index(line, "|")
+	// tag 中也可能包含冒号，name 和 value 的分隔符取第一个竖线之前的最后一个冒号
+	if pipe > 0 {
+		idx = strings.LastIndex(line[:pipe], ":")
+	}
+	if idx <= 0 || pipe < idx {
+		return m, fmt.Errorf("invalid statsd line %q", line)
+	}
+	m.Name = line[:idx]
+	parts := strings.Split(line[idx+1:], "|")
+	if len(parts) < 2 {
+		return m, fmt.Errorf("invalid statsd line %q, type is missing", line)
+	}
+	valueStr := parts[0]
+
+	switch parts[1] {
+	case "c":
+		m.Type = MetricTypeCounter
+	case "g":
+		m.Type = MetricTypeGauge
+	case "ms", "h", "d":
+		m.Type = MetricTypeTimer
+	case "s":
+		m.Type = MetricTypeSet
+	default:
+		return m, fmt.Errorf("invalid statsd line %q, type %q is not supported", line, parts[1])
+	}
+
+	for _, part := range parts[2:] {
+		if part == "" {
+			continue
+		}
+		switch part[0] {
+		case '@':
+			rate, err := strconv.ParseFloat(part[1:], 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return m, fmt.Errorf("invalid statsd line %q, sample rate %q is invalid", line, part)
+			}
+			m.SampleRate = rate
+		case '#':
+			m.Tags = parseTags(part[1:])
+		}
+	}
+
+	if m.Type == MetricTypeSet {
+		m.SetValue = valueStr
+		return m, nil
+	}
+	if m.Type == MetricTypeGauge && (strings.HasPrefix(valueStr, "+") || strings.HasPrefix(valueStr, "-")) {
+		m.Delta = true
+	}
+	value, err := strconv.ParseFloat(valueStr, 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return m, fmt.Errorf("invalid statsd line %q, value %q is invalid", line, valueStr)
+	}
+	m.Value = value
+	return m, nil
+}
+
+func parseTags(s string) map[string]string {
+	tags := make(map[string]string)
+	for _, tag := range strings.Split(s, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			continue
+		}
+		if idx := strings.Index(tag, ":"); idx >= 0 {
+			tags[tag[:idx]] = tag[idx+1:]
+		} else {
+			tags[tag] = ""
+		}
+	}
+	return tags
+}
+
+type aggregate struct {
+	name   string
+	typ    string
+	tags   map[string]string
+	value  float64
+	values []float64
+	set    map[string]struct{}
+	// count 为按采样率换算后的个数
+	count float64
+}
+
+// Aggregator 在刷新间隔内聚合指标
+type Aggregator struct {
+	percentiles []float64
+
+	lock    sync.Mutex
+	metrics map[string]*aggregate
+}
+
+func NewAggregator(percentiles []float64) *Aggregator {
+	return &Aggregator{
+		percentiles: percentiles,
+		metrics:     make(map[string]*aggregate),
+	}
+}
+
+func aggregateKey(m Metric) string {
+	keys := make([]string, 0, len(m.Tags))
+	for k := range m.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteString(m.Type)
+	b.WriteByte('|')
+	b.WriteString(m.Name)
+	for _, k := range keys {
+		b.WriteByte('|')
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(m.Tags[k])
+	}
+	return b.String()
+}
+
+func (a *Aggregator) Add(m Metric) {
+	key := aggregateKey(m)
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	agg, ok := a.metrics[key]
+	if !ok {
+		agg = &aggregate{name: m.Name, typ: m.Type, tags: m.Tags}
+		a.metrics[key] = agg
+	}
+	switch m.Type {
+	case MetricTypeCounter:
+		agg.value += m.Value / m.SampleRate
+	case MetricTypeGauge:
+		if m.Delta {
+			agg.value += m.Value
+		} else {
+			agg.value = m.Value
+		}
+	case MetricTypeTimer:
+		agg.values = append(agg.values, m.Value)
+		agg.count += 1 / m.SampleRate
+	case MetricTypeSet:
+		if agg.set == nil {
+			agg.set = make(map[string]struct{})
+		}
+		agg.set[m.SetValue] = struct{}{}
+	}
+}
+
+// Flush 输出聚合结果并清空
+func (a *Aggregator) Flush(now time.Time) []Data {
+	a.lock.Lock()
+	metrics := a.metrics
+	a.metrics = make(map[string]*aggregate)
+	a.lock.Unlock()
+
+	keys := make([]string, 0, len(metrics))
+	for k := range metrics {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	timestamp := now.Format(time.RFC3339Nano)
+	datas := make([]Data, 0, len(metrics))
+	for _, k := range keys {
+		agg := metrics[k]
+		data := Data{
+			KeyMetricName: agg.name,
+			KeyMetricType: agg.typ,
+			KeyTimestamp:  timestamp,
+		}
+		switch agg.typ {
+		case MetricTypeCounter, MetricTypeGauge:
+			data[KeyMetricValue] = agg.value
+		case MetricTypeSet:
+			data[KeyMetricValue] = len(agg.set)
+		case MetricTypeTimer:
+			a.timerStats(data, agg)
+		}
+		for tk, tv := range agg.tags {
+			if _, ok := data[tk]; ok {
+				tk = tagPrefix + tk
+			}
+			data[tk] = tv
+		}
+		datas = append(datas, data)
+	}
+	return datas
+}
+
+func (a *Aggregator) timerStats(data Data, agg *aggregate) {
+	values := agg.values
+	sort.Float64s(values)
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+	data[KeyCount] = agg.count
+	data[KeySum] = sum
+	data[KeyMean] = mean
+	data[KeyMin] = values[0]
+	data[KeyMax] = values[len(values)-1]
+	data[KeyMetricValue] = mean
+	for _, p := range a.percentiles {
+		// 最近秩法计算百分位
+		idx := int(math.Ceil(p/100*float64(len(values)))) - 1
+		if idx < 0 {
+			idx = 0
+		}
+		data["p"+strconv.FormatFloat(p, 'f', -1, 64)] = values[idx]
+	}
+}
@@ -0,0 +1,227 @@
+package statsd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/qiniu/log"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/reader"
+	. "github.com/qiniu/logkit/reader/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+var (
+	_ reader.DaemonReader = &Reader{}
+	_ reader.StatsReader  = &Reader{}
+	_ reader.DataReader   = &Reader{}
+	_ reader.Reader       = &Reader{}
+)
+
+// maxPacketSize UDP 包的最大长度
+const maxPacketSize = 64 * 1024
+
+func init() {
+	reader.RegisterConstructor(ModeStatsd, NewReader)
+}
+
+type readInfo struct {
+	data  Data
+	bytes int64
+}
+
+type Reader struct {
+	meta *reader.Meta
+	// Note: 原子操作，用于表示 reader 整体的运行状态
+	status int32
+
+	address       string
+	flushInterval time.Duration
+	aggregator    *Aggregator
+
+	conn     net.PacketConn
+	stopChan chan struct{}
+	readChan chan readInfo
+	wg       sync.WaitGroup
+
+	stats     StatsInfo
+	statsLock sync.RWMutex
+}
+
+func NewReader(meta *reader.Meta, c conf.MapConf) (reader.Reader, error) {
+	address, _ := c.GetStringOr(KeyStatsdServiceAddress, DefaultStatsdServiceAddress)
+	intervalStr, _ := c.GetStringOr(KeyStatsdFlushInterval, "10s")
+	interval, err := time.ParseDuration(intervalStr)
+	if err != nil {
+		return nil, err
+	}
+	if interval <= 0 {
+		return nil, fmt.Errorf("%v should be positive", KeyStatsdFlushInterval)
+	}
+	percentileList, _ := c.GetStringListOr(KeyStatsdPercentiles, []string{"90"})
+	var percentiles []float64
+	for _, p := range percentileList {
+		v, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil || v <= 0 || v > 100 {
+			return nil, fmt.Errorf("%v %q is invalid, should be in (0, 100]", KeyStatsdPercentiles, p)
+		}
+		percentiles = append(percentiles, v)
+	}
+
+	return &Reader{
+		meta:          meta,
+		status:        StatusInit,
+		address:       address,
+		flushInterval: interval,
+		aggregator:    NewAggregator(percentiles),
+		stopChan:      make(chan struct{}),
+		readChan:      make(chan readInfo, 1000),
+	}, nil
+}
+
+func (r *Reader) isStopping() bool {
+	return atomic.LoadInt32(&r.status) == StatusStopping
+}
+
+func (r *Reader) hasStopped() bool {
+	return atomic.LoadInt32(&r.status) == StatusStopped
+}
+
+func (r *Reader) Name() string {
+	return "statsd:" + r.address
+}
+
+func (r *Reader) SetMode(mode string, v interface{}) error {
+	return errors.New("statsd reader does not support read mode")
+}
+
+func (r *Reader) setStatsError(err string) {
+	r.statsLock.Lock()
+	defer r.statsLock.Unlock()
+	r.stats.LastError = err
+}
+
+func (r *Reader) Start() error {
+	if r.isStopping() || r.hasStopped() {
+		return errors.New("reader is stopping or has stopped")
+	} else if !atomic.CompareAndSwapInt32(&r.status, StatusInit, StatusRunning) {
+		log.Warnf("Runner[%v] %q daemon has already started and is running", r.meta.RunnerName, r.Name())
+		return nil
+	}
+
+	conn, err := net.ListenPacket("udp", r.address)
+	if err != nil {
+		atomic.StoreInt32(&r.status, StatusInit)
+		return err
+	}
+	r.conn = conn
+
+	r.wg.Add(2)
+	r.meta.Go("statsd listen", r.listen)
+	r.meta.Go("statsd flush", r.flushLoop)
+	log.Infof("Runner[%v] %q daemon has started, listening on %v", r.meta.RunnerName, r.Name(), conn.LocalAddr())
+	return nil
+}
+
+func (r *Reader) listen() {
+	defer r.wg.Done()
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, _, err := r.conn.ReadFrom(buf)
+		if err != nil {
+			if r.isStopping() || r.hasStopped() {
+				return
+			}
+			log.Errorf("Runner[%v] %q read packet error: %v", r.meta.RunnerName, r.Name(), err)
+			r.setStatsError(err.Error())
+			continue
+		}
+		r.handlePacket(string(buf[:n]))
+	}
+}
+
+// handlePacket 一个包中可以包含多行数据，解析失败的行直接丢弃
+func (r *Reader) handlePacket(packet string) {
+	for _, line := range strings.Split(packet, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		m, err := ParseLine(line)
+		if err != nil {
+			log.Debugf("Runner[%v] %q %v", r.meta.RunnerName, r.Name(), err)
+			r.setStatsError(err.Error())
+			continue
+		}
+		r.aggregator.Add(m)
+	}
+}
+
+func (r *Reader) flushLoop() {
+	defer r.wg.Done()
+	ticker := time.NewTicker(r.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stopChan:
+			return
+		case now := <-ticker.C:
+			for _, data := range r.aggregator.Flush(now) {
+				select {
+				case <-r.stopChan:
+					return
+				case r.readChan <- readInfo{data, int64(len(fmt.Sprintf("%v", data)))}:
+				}
+			}
+		}
+	}
+}
+
+func (r *Reader) Source() string {
+	return r.address
+}
+
+func (r *Reader) ReadLine() (string, error) {
+	return "", errors.New("method ReadLine is not supported, please use ReadData")
+}
+
+func (r *Reader) ReadData() (Data, int64, error) {
+	timer := time.NewTimer(time.Second)
+	defer timer.Stop()
+	select {
+	case info := <-r.readChan:
+		return info.data, info.bytes, nil
+	case <-timer.C:
+	}
+
+	return nil, 0, nil
+}
+
+func (r *Reader) Status() StatsInfo {
+	r.statsLock.RLock()
+	defer r.statsLock.RUnlock()
+	return r.stats
+}
+
+func (r *Reader) SyncMeta() {}
+
+func (r *Reader) Close() error {
+	if !atomic.CompareAndSwapInt32(&r.status, StatusRunning, StatusStopping) {
+		log.Warnf("Runner[%v] reader %q is not running, close operation ignored", r.meta.RunnerName, r.Name())
+		return nil
+	}
+	log.Debugf("Runner[%v] %q daemon is stopping", r.meta.RunnerName, r.Name())
+	close(r.stopChan)
+	r.conn.Close()
+	r.wg.Wait()
+	close(r.readChan)
+	atomic.StoreInt32(&r.status, StatusStopped)
+	return nil
+}
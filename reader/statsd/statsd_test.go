@@ -0,0 +1,124 @@
+package statsd
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/reader"
+	. "github.com/qiniu/logkit/reader/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+func TestParseLine(t *testing.T) {
+	m, err := ParseLine("page.views:1|c|@0.5|#env:prod,region:us-east:1")
+	assert.NoError(t, err)
+	assert.Equal(t, Metric{
+		Name:       "page.views",
+		Type:       MetricTypeCounter,
+		Value:      1,
+		SampleRate: 0.5,
+		Tags:       map[string]string{"env": "prod", "region": "us-east:1"},
+	}, m)
+
+	m, err = ParseLine("temp:-3|g")
+	assert.NoError(t, err)
+	assert.True(t, m.Delta)
+	assert.Equal(t, float64(-3), m.Value)
+
+	m, err = ParseLine("users:alice|s")
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", m.SetValue)
+
+	for _, line := range []string{"abc", "a:1", "a:1|x", "a:x|c", "a:1|c|@2"} {
+		_, err = ParseLine(line)
+		assert.Error(t, err, line)
+	}
+}
+
+func TestAggregator(t *testing.T) {
+	a := NewAggregator([]float64{50, 90})
+	for _, line := range []string{
+		"hits:1|c", "hits:2|c|@0.5",
+		"temp:10|g", "temp:+5|g",
+		"users:a|s", "users:b|s", "users:a|s",
+		"lat:1|ms", "lat:2|ms", "lat:3|ms", "lat:4|ms|#host:h1",
+	} {
+		m, err := ParseLine(line)
+		assert.NoError(t, err)
+		a.Add(m)
+	}
+	now := time.Now()
+	datas := a.Flush(now)
+	assert.Len(t, datas, 5)
+	ts := now.Format(time.RFC3339Nano)
+	assert.Equal(t, Data{KeyMetricName: "hits", KeyMetricType: MetricTypeCounter, KeyMetricValue: float64(5), KeyTimestamp: ts}, datas[0])
+	assert.Equal(t, Data{KeyMetricName: "temp", KeyMetricType: MetricTypeGauge, KeyMetricValue: float64(15), KeyTimestamp: ts}, datas[1])
+	assert.Equal(t, Data{KeyMetricName: "users", KeyMetricType: MetricTypeSet, KeyMetricValue: 2, KeyTimestamp: ts}, datas[2])
+	assert.Equal(t, Data{
+		KeyMetricName:  "lat",
+		KeyMetricType:  MetricTypeTimer,
+		KeyMetricValue: float64(2),
+		KeyCount:       float64(3),
+		KeySum:         float64(6),
+		KeyMean:        float64(2),
+		KeyMin:         float64(1),
+		KeyMax:         float64(3),
+		"p50":          float64(2),
+		"p90":          float64(3),
+		KeyTimestamp:   ts,
+	}, datas[3])
+	assert.Equal(t, "h1", datas[4]["host"])
+
+	// 刷新后清空
+	assert.Len(t, a.Flush(now), 0)
+}
+
+func TestStatsdReader(t *testing.T) {
+	meta, err := reader.NewMetaWithConf(conf.MapConf{
+		KeyMetaPath:   "./TestStatsdReader",
+		KeyLogPath:    "TestStatsdReader",
+		KeyMode:       ModeStatsd,
+		KeyRunnerName: "TestStatsdReader",
+	})
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, meta.Delete())
+	}()
+
+	r, err := NewReader(meta, conf.MapConf{
+		KeyStatsdServiceAddress: "127.0.0.1:0",
+		KeyStatsdFlushInterval:  "100ms",
+	})
+	assert.NoError(t, err)
+	sr := r.(*Reader)
+	assert.NoError(t, sr.Start())
+
+	conn, err := net.Dial("udp", sr.conn.LocalAddr().String())
+	assert.NoError(t, err)
+	_, err = conn.Write([]byte("hits:1|c\nhits:1|c|#metric:x\nbad line\nhits:2|c"))
+	assert.NoError(t, err)
+	conn.Close()
+
+	var datas []Data
+	for i := 0; i < 5 && len(datas) < 2; i++ {
+		data, _, err := sr.ReadData()
+		assert.NoError(t, err)
+		if data != nil {
+			datas = append(datas, data)
+		}
+	}
+	assert.Len(t, datas, 2)
+	assert.Equal(t, float64(3), datas[0][KeyMetricValue])
+	// 与保留字段重名的 tag 加上前缀
+	assert.Equal(t, "x", datas[1][tagPrefix+KeyMetricName])
+	assert.Equal(t, "hits", datas[1][KeyMetricName])
+	assert.NotEmpty(t, sr.Status().LastError)
+	assert.NoError(t, sr.Close())
+
+	_, err = NewReader(meta, conf.MapConf{KeyStatsdPercentiles: "101"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,595 @@
+package syslog
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/qiniu/log"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/reader"
+	. "github.com/qiniu/logkit/reader/config"
+	. "github.com/qiniu/logkit/utils/models"
+	"github.com/qiniu/logkit/utils/parse/syslog"
+	"github.com/qiniu/logkit/utils/tlspolicy"
+)
+
+var (
+	_ reader.DaemonReader       = &Reader{}
+	_ reader.StatsReader        = &Reader{}
+	_ reader.DataReader         = &Reader{}
+	_ reader.ClientStatusReader = &Reader{}
+	_ reader.Reader             = &Reader{}
+)
+
+const (
+	// maxPacketSize UDP 包的最大长度
+	maxPacketSize = 64 * 1024
+	// maxOctetCountDigits octet-counting 长度前缀的最大位数
+	maxOctetCountDigits = 10
+	// maxClients 最多记录的客户端数，超出后新客户端的统计合并到 otherClient 中
+	maxClients  = 1024
+	otherClient = "other"
+)
+
+func init() {
+	reader.RegisterConstructor(ModeSyslog, NewReader)
+}
+
+type readInfo struct {
+	data   Data
+	bytes  int64
+	source string
+}
+
+type listenAddress struct {
+	network string
+	address string
+	tls     bool
+}
+
+// protocol 返回用于统计的协议名称
+func (a listenAddress) protocol() string {
+	if a.tls {
+		return "tls"
+	}
+	return strings.TrimRight(a.network, "46")
+}
+
+type Reader struct {
+	meta *reader.Meta
+	// Note: 原子操作，用于表示 reader 整体的运行状态
+	status int32
+
+	addresses      []listenAddress
+	format         syslog.Format
+	framing        string
+	maxMessageSize int
+	maxConnections int
+	readTimeout    time.Duration
+	tlsConfig      *tls.Config
+
+	listeners   []net.Listener
+	packetConns []net.PacketConn
+	conns       map[net.Conn]struct{}
+	connLock    sync.Mutex
+
+	stopChan chan struct{}
+	readChan chan readInfo
+	wg       sync.WaitGroup
+
+	// currentSource 最近一次 ReadData 读出的数据所属的客户端，只在 ReadData 所在的协程中访问
+	currentSource string
+
+	clients     map[string]*ClientStatus
+	clientsLock sync.Mutex
+
+	stats     StatsInfo
+	statsLock sync.RWMutex
+}
+
+func NewReader(meta *reader.Meta, c conf.MapConf) (reader.Reader, error) {
+	addressList, _ := c.GetStringListOr(KeySyslogAddress, []string{DefaultSyslogAddress})
+	rfc, _ := c.GetStringOr(KeySyslogRFC, "auto")
+	framing, _ := c.GetStringOr(KeySyslogFraming, SyslogFramingAuto)
+	maxMessageSize, _ := c.GetIntOr(KeySyslogMaxMessageSize, DefaultSyslogMaxMessageSize)
+	maxConnections, _ := c.GetIntOr(KeySyslogMaxConnections, 0)
+	readTimeoutStr, _ := c.GetStringOr(KeySyslogReadTimeout, "0")
+	certFile, _ := c.GetStringOr(KeySyslogTLSCert, "")
+	keyFile, _ := c.GetStringOr(KeySyslogTLSKey, "")
+	caFile, _ := c.GetStringOr(KeySyslogTLSCA, "")
+
+	switch strings.ToLower(rfc) {
+	case "auto", "rfc3164", "rfc5424":
+	default:
+		return nil, fmt.Errorf("%v %q is not supported, only auto, rfc3164 or rfc5424", KeySyslogRFC, rfc)
+	}
+	switch framing {
+	case SyslogFramingAuto, SyslogFramingOctetCounting, SyslogFramingNonTransparent:
+	default:
+		return nil, fmt.Errorf("%v %q is not supported, only %v, %v or %v", KeySyslogFraming, framing,
+			SyslogFramingAuto, SyslogFramingOctetCounting, SyslogFramingNonTransparent)
+	}
+	if maxMessageSize <= 0 {
+		return nil, fmt.Errorf("%v should be positive", KeySyslogMaxMessageSize)
+	}
+	readTimeout, err := time.ParseDuration(readTimeoutStr)
+	if err != nil {
+		return nil, fmt.Errorf("parse %v error: %v", KeySyslogReadTimeout, err)
+	}
+
+	var (
+		addresses []listenAddress
+		needTLS   bool
+	)
+	for _, addr := range addressList {
+		la, err := parseAddress(addr)
+		if err != nil {
+			return nil, err
+		}
+		needTLS = needTLS || la.tls
+		addresses = append(addresses, la)
+	}
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("%v is empty", KeySyslogAddress)
+	}
+	var tlsConfig *tls.Config
+	if needTLS {
+		if tlsConfig, err = loadTLSConfig(certFile, keyFile, caFile); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Reader{
+		meta:           meta,
+		status:         StatusInit,
+		addresses:      addresses,
+		format:         syslog.GetFormt(rfc),
+		framing:        framing,
+		maxMessageSize: maxMessageSize,
+		maxConnections: maxConnections,
+		readTimeout:    readTimeout,
+		tlsConfig:      tlsConfig,
+		conns:          make(map[net.Conn]struct{}),
+		stopChan:       make(chan struct{}),
+		readChan:       make(chan readInfo, 1000),
+		clients:        make(map[string]*ClientStatus),
+	}, nil
+}
+
+// parseAddress 解析 [udp|tcp|tls]://[ip]:port 形式的监听地址
+func parseAddress(addr string) (listenAddress, error) {
+	addr = strings.TrimSpace(addr)
+	parts := strings.SplitN(addr, "://", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return listenAddress{}, fmt.Errorf("%v %q is invalid, should be like udp://:5140", KeySyslogAddress, addr)
+	}
+	switch network := strings.ToLower(parts[0]); network {
+	case "udp", "udp4", "udp6", "tcp", "tcp4", "tcp6":
+		return listenAddress{network: network, address: parts[1]}, nil
+	case "tls":
+		return listenAddress{network: "tcp", address: parts[1], tls: true}, nil
+	default:
+		return listenAddress{}, fmt.Errorf("%v %q is invalid, only udp, tcp and tls are supported", KeySyslogAddress, addr)
+	}
+}
+
+func loadTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("%v and %v are required to listen on tls", KeySyslogTLSCert, KeySyslogTLSKey)
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load certificate %v and key %v error: %v", certFile, keyFile, err)
+	}
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if caFile != "" {
+		ca, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("read %v error: %v", KeySyslogTLSCA, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificate found in %v %v", KeySyslogTLSCA, caFile)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlspolicy.Apply(cfg), nil
+}
+
+func (r *Reader) isStopping() bool {
+	return atomic.LoadInt32(&r.status) == StatusStopping
+}
+
+func (r *Reader) hasStopped() bool {
+	return atomic.LoadInt32(&r.status) == StatusStopped
+}
+
+func (r *Reader) Name() string {
+	names := make([]string, 0, len(r.addresses))
+	for _, addr := range r.addresses {
+		names = append(names, addr.protocol()+"://"+addr.address)
+	}
+	return "syslog:" + strings.Join(names, ",")
+}
+
+func (r *Reader) SetMode(mode string, v interface{}) error {
+	return errors.New("syslog reader does not support read mode")
+}
+
+func (r *Reader) setStatsError(err string) {
+	r.statsLock.Lock()
+	defer r.statsLock.Unlock()
+	r.stats.LastError = err
+}
+
+func (r *Reader) Start() error {
+	if r.isStopping() || r.hasStopped() {
+		return errors.New("reader is stopping or has stopped")
+	} else if !atomic.CompareAndSwapInt32(&r.status, StatusInit, StatusRunning) {
+		log.Warnf("Runner[%v] %q daemon has already started and is running", r.meta.RunnerName, r.Name())
+		return nil
+	}
+
+	var listening []string
+	for _, addr := range r.addresses {
+		if strings.HasPrefix(addr.network, "udp") {
+			conn, err := net.ListenPacket(addr.network, addr.address)
+			if err != nil {
+				r.closeListeners()
+				atomic.StoreInt32(&r.status, StatusInit)
+				return err
+			}
+			r.packetConns = append(r.packetConns, conn)
+			listening = append(listening, addr.protocol()+"://"+conn.LocalAddr().String())
+			continue
+		}
+		ln, err := net.Listen(addr.network, addr.address)
+		if err != nil {
+			r.closeListeners()
+			atomic.StoreInt32(&r.status, StatusInit)
+			return err
+		}
+		listening = append(listening, addr.protocol()+"://"+ln.Addr().String())
+		if addr.tls {
+			ln = tls.NewListener(ln, r.tlsConfig)
+		}
+		r.listeners = append(r.listeners, ln)
+	}
+
+	var (
+		listenerIdx int
+		connIdx     int
+	)
+	for _, addr := range r.addresses {
+		r.wg.Add(1)
+		protocol := addr.protocol()
+		if strings.HasPrefix(addr.network, "udp") {
+			pc := r.packetConns[connIdx]
+			r.meta.Go("syslog listen "+protocol, func() { r.listenPacket(pc, protocol) })
+			connIdx++
+		} else {
+			ln := r.listeners[listenerIdx]
+			r.meta.Go("syslog accept "+protocol, func() { r.accept(ln, protocol) })
+			listenerIdx++
+		}
+	}
+	log.Infof("Runner[%v] %q daemon has started, listening on %v", r.meta.RunnerName, r.Name(), strings.Join(listening, ","))
+	return nil
+}
+
+func (r *Reader) closeListeners() {
+	for _, conn := range r.packetConns {
+		conn.Close()
+	}
+	for _, ln := range r.listeners {
+		ln.Close()
+	}
+	r.packetConns, r.listeners = nil, nil
+}
+
+func (r *Reader) listenPacket(conn net.PacketConn, protocol string) {
+	defer r.wg.Done()
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if r.isStopping() || r.hasStopped() {
+				return
+			}
+			log.Errorf("Runner[%v] %q read packet error: %v", r.meta.RunnerName, r.Name(), err)
+			r.setStatsError(err.Error())
+			continue
+		}
+		// 每个 UDP 包是一条消息(RFC5426)
+		r.handleMessage(protocol, hostOf(addr), buf[:n])
+	}
+}
+
+func (r *Reader) accept(ln net.Listener, protocol string) {
+	defer r.wg.Done()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if r.isStopping() || r.hasStopped() {
+				return
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				log.Warnf("Runner[%v] %q accept error: %v, retry after 100ms", r.meta.RunnerName, r.Name(), err)
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			log.Errorf("Runner[%v] %q accept error: %v, stop listening on %v", r.meta.RunnerName, r.Name(), err, ln.Addr())
+			r.setStatsError(err.Error())
+			return
+		}
+
+		r.connLock.Lock()
+		// Close 已经关闭了所有的连接，不能再加入新的连接
+		if r.isStopping() || r.hasStopped() {
+			r.connLock.Unlock()
+			conn.Close()
+			return
+		}
+		if r.maxConnections > 0 && len(r.conns) >= r.maxConnections {
+			r.connLock.Unlock()
+			log.Warnf("Runner[%v] %q reject connection from %v, %v connections reached %v", r.meta.RunnerName, r.Name(),
+				conn.RemoteAddr(), len(r.conns), KeySyslogMaxConnections)
+			conn.Close()
+			continue
+		}
+		r.conns[conn] = struct{}{}
+		r.wg.Add(1)
+		r.connLock.Unlock()
+		r.meta.Go("syslog connection "+conn.RemoteAddr().String(), func() { r.handleConn(conn, protocol) })
+	}
+}
+
+func (r *Reader) handleConn(conn net.Conn, protocol string) {
+	client := hostOf(conn.RemoteAddr())
+	r.updateConnections(protocol, client, 1)
+	defer func() {
+		conn.Close()
+		r.connLock.Lock()
+		delete(r.conns, conn)
+		r.connLock.Unlock()
+		r.updateConnections(protocol, client, -1)
+		r.wg.Done()
+	}()
+
+	br := bufio.NewReaderSize(conn, r.maxMessageSize)
+	for {
+		if r.readTimeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(r.readTimeout))
+		}
+		frame, err := readFrame(br, r.framing, r.maxMessageSize)
+		if len(frame) > 0 {
+			r.handleMessage(protocol, client, frame)
+		}
+		if err == nil {
+			continue
+		}
+		if err != io.EOF && !r.isStopping() && !r.hasStopped() {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				log.Debugf("Runner[%v] %q connection from %v is idle for %v, close it", r.meta.RunnerName, r.Name(), client, r.readTimeout)
+			} else {
+				log.Warnf("Runner[%v] %q read from %v error: %v, close the connection", r.meta.RunnerName, r.Name(), client, err)
+				r.setStatsError(err.Error())
+				r.updateClient(protocol, client, 0, err)
+			}
+		}
+		return
+	}
+}
+
+// readFrame 按 RFC6587 读取一条消息，octet-counting 为 "长度 消息"，non-transparent 以换行结尾。
+// 以换行分帧时超过 maxSize 的部分被丢弃，返回的数据在下一次读取前有效
+func readFrame(br *bufio.Reader, framing string, maxSize int) ([]byte, error) {
+	octet := framing == SyslogFramingOctetCounting
+	if framing == SyslogFramingAuto {
+		b, err := br.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+		octet = b[0] >= '1' && b[0] <= '9'
+	}
+	if !octet {
+		line, err := br.ReadSlice('\n')
+		if err != bufio.ErrBufferFull {
+			return line, err
+		}
+		msg := append([]byte(nil), line...)
+		for err == bufio.ErrBufferFull {
+			_, err = br.ReadSlice('\n')
+		}
+		return msg, err
+	}
+
+	var size int
+	for i := 0; ; i++ {
+		c, err := br.ReadByte()
+		if err != nil {
+			if err == io.EOF && i > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		if c == ' ' && i > 0 {
+			break
+		}
+		if c < '0' || c > '9' || i >= maxOctetCountDigits {
+			return nil, fmt.Errorf("invalid octet counting frame, unexpected character %q in message length", c)
+		}
+		size = size*10 + int(c-'0')
+	}
+	if size > maxSize {
+		return nil, fmt.Errorf("message length %v exceeds %v %v", size, KeySyslogMaxMessageSize, maxSize)
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(br, msg); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return msg, nil
+}
+
+func hostOf(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// handleMessage 解析一条消息后交给 ReadData，解析失败时原始消息放在 pandora_stash 字段中
+func (r *Reader) handleMessage(protocol, client string, msg []byte) {
+	size := len(msg)
+	msg = bytes.TrimRight(msg, "\r\n\x00")
+	if len(msg) == 0 {
+		return
+	}
+	data, err := r.parse(msg)
+	r.updateClient(protocol, client, size, err)
+	if err != nil {
+		log.Debugf("Runner[%v] %q parse message from %v error: %v", r.meta.RunnerName, r.Name(), client, err)
+		r.setStatsError(fmt.Sprintf("parse message from %v error: %v", client, err))
+	}
+	select {
+	case <-r.stopChan:
+	case r.readChan <- readInfo{data: data, bytes: int64(size), source: client}:
+	}
+}
+
+func (r *Reader) parse(msg []byte) (Data, error) {
+	p := r.format.GetParser(msg)
+	if err := p.Parse(); err != nil && err.Error() != "No structured data" {
+		return Data{KeyPandoraStash: string(msg)}, err
+	}
+	return Data(p.Dump()), nil
+}
+
+// client 返回客户端的统计，调用方需要持有 clientsLock
+func (r *Reader) client(protocol, host string) *ClientStatus {
+	key := protocol + "://" + host
+	cs, ok := r.clients[key]
+	if ok {
+		return cs
+	}
+	if len(r.clients) >= maxClients {
+		key, host = protocol+"://"+otherClient, otherClient
+		if cs, ok = r.clients[key]; ok {
+			return cs
+		}
+	}
+	cs = &ClientStatus{Client: host, Protocol: protocol}
+	r.clients[key] = cs
+	return cs
+}
+
+func (r *Reader) updateClient(protocol, host string, size int, err error) {
+	r.clientsLock.Lock()
+	defer r.clientsLock.Unlock()
+	cs := r.client(protocol, host)
+	cs.LastSeen = time.Now()
+	cs.Bytes += int64(size)
+	if size > 0 {
+		cs.Messages++
+	}
+	if err != nil {
+		cs.Errors++
+		cs.LastError = TruncateStrSize(err.Error(), DefaultTruncateMaxSize)
+	}
+}
+
+func (r *Reader) updateConnections(protocol, host string, delta int64) {
+	r.clientsLock.Lock()
+	defer r.clientsLock.Unlock()
+	cs := r.client(protocol, host)
+	cs.Connections += delta
+	if delta > 0 {
+		cs.LastSeen = time.Now()
+	}
+}
+
+// ClientStatus 返回每个客户端的统计，按协议和地址排序
+func (r *Reader) ClientStatus() []ClientStatus {
+	r.clientsLock.Lock()
+	defer r.clientsLock.Unlock()
+	clients := make([]ClientStatus, 0, len(r.clients))
+	for _, cs := range r.clients {
+		clients = append(clients, *cs)
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		if clients[i].Protocol != clients[j].Protocol {
+			return clients[i].Protocol < clients[j].Protocol
+		}
+		return clients[i].Client < clients[j].Client
+	})
+	return clients
+}
+
+func (r *Reader) Source() string {
+	return r.currentSource
+}
+
+func (r *Reader) ReadLine() (string, error) {
+	return "", errors.New("method ReadLine is not supported, please use ReadData")
+}
+
+func (r *Reader) ReadData() (Data, int64, error) {
+	timer := time.NewTimer(time.Second)
+	defer timer.Stop()
+	select {
+	case info := <-r.readChan:
+		r.currentSource = info.source
+		return info.data, info.bytes, nil
+	case <-timer.C:
+	}
+
+	return nil, 0, nil
+}
+
+func (r *Reader) Status() StatsInfo {
+	r.statsLock.RLock()
+	defer r.statsLock.RUnlock()
+	return r.stats
+}
+
+func (r *Reader) SyncMeta() {}
+
+func (r *Reader) Close() error {
+	if !atomic.CompareAndSwapInt32(&r.status, StatusRunning, StatusStopping) {
+		log.Warnf("Runner[%v] reader %q is not running, close operation ignored", r.meta.RunnerName, r.Name())
+		return nil
+	}
+	log.Debugf("Runner[%v] %q daemon is stopping", r.meta.RunnerName, r.Name())
+	close(r.stopChan)
+	r.closeListeners()
+	r.connLock.Lock()
+	for conn := range r.conns {
+		conn.Close()
+	}
+	r.connLock.Unlock()
+	r.wg.Wait()
+	close(r.readChan)
+	atomic.StoreInt32(&r.status, StatusStopped)
+	return nil
+}
@@ -0,0 +1,200 @@
+package syslog
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/reader"
+	. "github.com/qiniu/logkit/reader/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+func readDatas(t *testing.T, r *Reader, n int) []Data {
+	var datas []Data
+	for i := 0; i < 5 && len(datas) < n; i++ {
+		data, _, err := r.ReadData()
+		assert.NoError(t, err)
+		if data != nil {
+			datas = append(datas, data)
+		}
+	}
+	return datas
+}
+
+// writeCert 生成自签名的证书，返回证书和私钥的路径
+func writeCert(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	certPath, keyPath := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+	assert.NoError(t, ioutil.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.NoError(t, ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return certPath, keyPath
+}
+
+func TestSyslogReader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestSyslogReader")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	certPath, keyPath := writeCert(t, dir)
+
+	c := conf.MapConf{
+		KeyMode:                 ModeSyslog,
+		KeyRunnerName:           "TestSyslogReader",
+		KeySyslogAddress:        "udp://127.0.0.1:0,tcp://127.0.0.1:0,tls://127.0.0.1:0",
+		KeySyslogTLSCert:        certPath,
+		KeySyslogTLSKey:         keyPath,
+		KeySyslogMaxConnections: "2",
+	}
+	meta, err := reader.NewMetaWithConf(c)
+	assert.NoError(t, err)
+	rd, err := NewReader(meta, c)
+	assert.NoError(t, err)
+	r := rd.(*Reader)
+	assert.NoError(t, r.Start())
+	defer r.Close()
+
+	// udp 每个包一条消息
+	udp, err := net.Dial("udp", r.packetConns[0].LocalAddr().String())
+	assert.NoError(t, err)
+	defer udp.Close()
+	_, err = udp.Write([]byte("<34>Oct 11 22:14:15 mymachine su: 'su root' failed for lonvick on /dev/pts/8\n"))
+	assert.NoError(t, err)
+	datas := readDatas(t, r, 1)
+	assert.Len(t, datas, 1)
+	assert.Equal(t, "mymachine", datas[0]["hostname"])
+	assert.Equal(t, "su", datas[0]["tag"])
+	assert.Equal(t, "'su root' failed for lonvick on /dev/pts/8", datas[0]["content"])
+	assert.Equal(t, 34, datas[0]["priority"])
+	assert.Equal(t, 4, datas[0]["facility"])
+	assert.Equal(t, 2, datas[0]["severity"])
+	assert.Equal(t, "127.0.0.1", r.Source())
+
+	// tcp 混合 octet-counting 和换行分帧，octet-counting 的消息中可以包含换行
+	tcp, err := net.Dial("tcp", r.listeners[0].Addr().String())
+	assert.NoError(t, err)
+	msg5424 := "<165>1 2003-10-11T22:14:15.003Z host1 app 1234 ID47 - first\nsecond"
+	lines := "<13>1 2003-10-11T22:14:16Z host2 app - - - newline\nbad message\n"
+	_, err = fmt.Fprintf(tcp, "%d %s%s", len(msg5424), msg5424, lines)
+	assert.NoError(t, err)
+	datas = readDatas(t, r, 3)
+	assert.Len(t, datas, 3)
+	assert.Equal(t, "host1", datas[0]["hostname"])
+	assert.Equal(t, "app", datas[0]["app_name"])
+	assert.Equal(t, "1234", datas[0]["proc_id"])
+	assert.Equal(t, "ID47", datas[0]["msg_id"])
+	assert.Equal(t, "first\nsecond", datas[0]["message"])
+	assert.Equal(t, 20, datas[0]["facility"])
+	assert.Equal(t, 5, datas[0]["severity"])
+	assert.Equal(t, time.Date(2003, 10, 11, 22, 14, 15, 3000000, time.UTC), datas[0]["timestamp"].(time.Time).UTC())
+	assert.Equal(t, "host2", datas[1]["hostname"])
+	assert.Equal(t, "newline", datas[1]["message"])
+	assert.Equal(t, Data{KeyPandoraStash: "bad message"}, datas[2])
+
+	// tls
+	tlsConn, err := tls.Dial("tcp", r.listeners[1].Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	assert.NoError(t, err)
+	_, err = tlsConn.Write([]byte("<14>1 2003-10-11T22:14:17Z host3 app - - - secure\n"))
+	assert.NoError(t, err)
+	datas = readDatas(t, r, 1)
+	assert.Len(t, datas, 1)
+	assert.Equal(t, "secure", datas[0]["message"])
+
+	// 超过最大连接数的连接被拒绝
+	rejected, err := net.Dial("tcp", r.listeners[0].Addr().String())
+	assert.NoError(t, err)
+	rejected.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = rejected.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+	rejected.Close()
+
+	clients := r.ClientStatus()
+	assert.Len(t, clients, 3)
+	assert.Equal(t, ClientStatus{Client: "127.0.0.1", Protocol: "tcp", Connections: 1, Messages: 3, Bytes: int64(len(msg5424) + len(lines)), Errors: 1,
+		LastSeen: clients[0].LastSeen, LastError: clients[0].LastError}, clients[0])
+	assert.NotEmpty(t, clients[0].LastError)
+	assert.Equal(t, "tls", clients[1].Protocol)
+	assert.Equal(t, int64(1), clients[1].Connections)
+	assert.Equal(t, int64(1), clients[1].Messages)
+	assert.Equal(t, "udp", clients[2].Protocol)
+	assert.Equal(t, int64(0), clients[2].Connections)
+	assert.Equal(t, int64(1), clients[2].Messages)
+
+	tcp.Close()
+	tlsConn.Close()
+	time.Sleep(100 * time.Millisecond)
+	clients = r.ClientStatus()
+	assert.Equal(t, int64(0), clients[0].Connections)
+	assert.Equal(t, int64(0), clients[1].Connections)
+}
+
+func TestReadFrame(t *testing.T) {
+	br := bufio.NewReaderSize(strings.NewReader("0123456789abcdefghij\nshort\n5 <1>ab"), 16)
+	frame, err := readFrame(br, SyslogFramingNonTransparent, 16)
+	assert.NoError(t, err)
+	assert.Equal(t, "0123456789abcdef", string(frame))
+	frame, err = readFrame(br, SyslogFramingAuto, 16)
+	assert.NoError(t, err)
+	assert.Equal(t, "short\n", string(frame))
+	frame, err = readFrame(br, SyslogFramingAuto, 16)
+	assert.NoError(t, err)
+	assert.Equal(t, "<1>ab", string(frame))
+	_, err = readFrame(br, SyslogFramingAuto, 16)
+	assert.Equal(t, io.EOF, err)
+
+	_, err = readFrame(bufio.NewReader(strings.NewReader("17 <1>message")), SyslogFramingOctetCounting, 16)
+	assert.Error(t, err)
+	_, err = readFrame(bufio.NewReader(strings.NewReader("12x <1>message")), SyslogFramingOctetCounting, 16)
+	assert.Error(t, err)
+	_, err = readFrame(bufio.NewReader(strings.NewReader("10 <1>ab")), SyslogFramingOctetCounting, 16)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+func TestNewReaderError(t *testing.T) {
+	for _, c := range []conf.MapConf{
+		{KeySyslogAddress: "127.0.0.1:5140"},
+		{KeySyslogAddress: "http://:5140"},
+		{KeySyslogAddress: "tls://:6514"},
+		{KeySyslogRFC: "rfc1234"},
+		{KeySyslogFraming: "length"},
+		{KeySyslogMaxMessageSize: "0"},
+		{KeySyslogReadTimeout: "abc"},
+	} {
+		_, err := NewReader(nil, c)
+		assert.Error(t, err, c)
+	}
+}
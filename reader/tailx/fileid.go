@@ -0,0 +1,180 @@
+package tailx
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/qiniu/log"
+
+	"github.com/qiniu/logkit/reader"
+	. "github.com/qiniu/logkit/utils/models"
+	utilsos "github.com/qiniu/logkit/utils/os"
+)
+
+// rotatedFile 被改名轮转的文件在轮转之前的路径与已经读取的 offset
+type rotatedFile struct {
+	path     string
+	offset   int64
+	rotateAt time.Time
+}
+
+// subMetaPath 返回文件 realPath 对应的 submeta 目录，目录名为去掉路径分隔符后的文件路径
+func subMetaPath(metaDir, realPath string) string {
+	rpath := strings.Replace(realPath, string(os.PathSeparator), "_", -1)
+	if runtime.GOOS == "windows" {
+		rpath = strings.Replace(rpath, ":", "_", -1)
+	}
+	return filepath.Join(metaDir, rpath)
+}
+
+func (r *Reader) getFileID(path string) utilsos.FileID {
+	id, err := utilsos.GetFileIdentityIDByPath(path, r.isNetworkFS(path))
+	if err != nil {
+		log.Debugf("Runner[%s] get file id of %s failed: %v", r.meta.RunnerName, path, err)
+		return utilsos.FileID{}
+	}
+	return id
+}
+
+// loadFileIDs 启动后第一次发现文件前，从所有 submeta 中加载文件标识，
+// logkit 停止期间被改名轮转的文件可以根据标识找到之前读取的 offset
+func (r *Reader) loadFileIDs() {
+	r.fileIDsMux.Lock()
+	defer r.fileIDsMux.Unlock()
+	if r.fileIDsLoaded {
+		return
+	}
+	r.fileIDsLoaded = true
+	recs, err := reader.ReadSubMetaFileIDs(r.meta.Dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warnf("Runner[%s] load file ids from submeta error %v, ignore...", r.meta.RunnerName, err)
+		}
+		return
+	}
+	now := time.Now()
+	for id, rec := range recs {
+		r.rotatedFiles[id] = rotatedFile{path: rec.Path, offset: rec.Offset, rotateAt: now}
+	}
+}
+
+// onFileRotated 返回 realPath 被改名轮转时记录被轮转文件 offset 的回调
+func (r *Reader) onFileRotated(realPath string) func(id utilsos.FileID, offset int64) {
+	return func(id utilsos.FileID, offset int64) {
+		r.fileIDsMux.Lock()
+		r.rotatedFiles[id.String()] = rotatedFile{path: realPath, offset: offset, rotateAt: time.Now()}
+		r.fileIDsMux.Unlock()
+		log.Debugf("Runner[%s] %s with file id %v is rotated at offset %d", r.meta.RunnerName, realPath, id, offset)
+	}
+}
+
+// takeRotatedOffset realPath 是其他路径被改名轮转后的文件时，返回轮转之前读取到的 offset
+func (r *Reader) takeRotatedOffset(realPath string, id utilsos.FileID) (int64, bool) {
+	key := id.String()
+	r.fileIDsMux.Lock()
+	rf, ok := r.rotatedFiles[key]
+	r.fileIDsMux.Unlock()
+	if !ok || rf.path == realPath {
+		return 0, false
+	}
+	// 原路径仍然是同一个文件(比如硬链接)，不是轮转
+	if r.getFileID(rf.path) == id {
+		return 0, false
+	}
+	r.fileIDsMux.Lock()
+	delete(r.rotatedFiles, key)
+	r.fileIDsMux.Unlock()
+	return rf.offset, true
+}
+
+// restoreOffset 根据 submeta 中记录的文件标识判断记录的 offset 是否属于 realPath 当前的文件：
+// 属于其他文件时说明 realPath 已经被轮转，被轮转文件的 offset 留给改名后的文件继续读取，当前文件从头读取；
+// realPath 是其他路径被改名轮转后的文件时，从轮转之前读取到的 offset 继续读取
+func (r *Reader) restoreOffset(subMeta *reader.Meta, realPath string, originOffset int64) int64 {
+	id := r.getFileID(realPath)
+	if id.IsZero() {
+		return originOffset
+	}
+	rec, err := subMeta.ReadFileID()
+	if err != nil {
+		log.Warnf("Runner[%s] %s read file id from submeta error %v, ignore...", r.meta.RunnerName, realPath, err)
+		rec = reader.FileIDRecord{}
+	}
+	if rec.ID == id.String() {
+		return originOffset
+	}
+	if rec.ID != "" {
+		r.fileIDsMux.Lock()
+		r.rotatedFiles[rec.ID] = rotatedFile{path: realPath, offset: rec.Offset, rotateAt: time.Now()}
+		r.fileIDsMux.Unlock()
+	}
+	offset, rotated := r.takeRotatedOffset(realPath, id)
+	if rotated {
+		if !IsSelfRunner(r.meta.RunnerName) {
+			log.Infof("Runner[%s] %s with file id %v was rotated from other path, continue reading from offset %d", r.meta.RunnerName, realPath, id, offset)
+		} else {
+			log.Debugf("Runner[%s] %s with file id %v was rotated from other path, continue reading from offset %d", r.meta.RunnerName, realPath, id, offset)
+		}
+	}
+	if rec.ID == "" {
+		// 没有记录过文件标识的 submeta 保持原来的行为
+		if rotated && subMeta.IsNotExist() {
+			return offset
+		}
+		return originOffset
+	}
+	// submeta 中的 offset 属于被轮转的文件，当前文件从 offset(新文件为 0) 开始读取
+	if err = subMeta.WriteOffset(realPath, offset); err == nil {
+		err = subMeta.WriteFileID(reader.FileIDRecord{ID: id.String(), Path: realPath, Offset: offset})
+	}
+	if err != nil {
+		log.Errorf("Runner[%s] %s reset submeta offset error %v", r.meta.RunnerName, realPath, err)
+	}
+	if !rotated {
+		if !IsSelfRunner(r.meta.RunnerName) {
+			log.Infof("Runner[%s] %s was rotated from file id %s to %v, read the new file from the beginning", r.meta.RunnerName, realPath, rec.ID, id)
+		} else {
+			log.Debugf("Runner[%s] %s was rotated from file id %s to %v, read the new file from the beginning", r.meta.RunnerName, realPath, rec.ID, id)
+		}
+	}
+	return originOffset
+}
+
+// isFileIDReading 文件被改名后，原路径的 ActiveReader 还没有读完轮转前的文件时，不能重复读取
+func (r *Reader) isFileIDReading(realPath string) bool {
+	id := r.getFileID(realPath)
+	if id.IsZero() {
+		return false
+	}
+	for _, ar := range r.getActiveReaders() {
+		if ar.realpath != realPath && ar.fileID() == id {
+			log.Debugf("Runner[%s] %s with file id %v is still being read as %s, wait for next stat...", r.meta.RunnerName, realPath, id, ar.realpath)
+			return true
+		}
+	}
+	return false
+}
+
+// cleanRotatedFiles 清理过期的轮转记录，改名后的文件不匹配 logpath 时记录不会被使用
+func (r *Reader) cleanRotatedFiles(expire time.Duration) {
+	if expire <= 0 {
+		return
+	}
+	r.fileIDsMux.Lock()
+	defer r.fileIDsMux.Unlock()
+	for id, rf := range r.rotatedFiles {
+		if time.Since(rf.rotateAt) > expire {
+			delete(r.rotatedFiles, id)
+		}
+	}
+}
+
+func (ar *ActiveReader) fileID() utilsos.FileID {
+	if ar.sf == nil {
+		return utilsos.FileID{}
+	}
+	return ar.sf.FileID()
+}
@@ -0,0 +1,125 @@
+package tailx
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// 路径中表示任意层目录的通配符
+const globStar = "**"
+
+// DefaultGlobMaxDepth ** 默认最多匹配的目录层数
+const DefaultGlobMaxDepth = 10
+
+// hasGlobStar 判断路径模式中是否有单独作为一级目录的 **
+func hasGlobStar(pattern string) bool {
+	for _, seg := range strings.Split(filepath.ToSlash(pattern), "/") {
+		if seg == globStar {
+			return true
+		}
+	}
+	return false
+}
+
+// globRecursive 在 filepath.Glob 的基础上支持单独作为一级目录的 **，匹配零层或多层目录，
+// 如 /var/log/containers/**/*.log。所有 ** 一共最多向下匹配 maxDepth 层目录，
+// 遍历时不进入指向目录的软链接，避免循环
+func globRecursive(pattern string, maxDepth int) ([]string, error) {
+	if !hasGlobStar(pattern) {
+		return filepath.Glob(pattern)
+	}
+	segs := strings.Split(filepath.ToSlash(pattern), "/")
+	first := 0
+	for i, seg := range segs {
+		if seg == globStar {
+			first = i
+			break
+		}
+		if _, err := filepath.Match(seg, ""); err != nil {
+			return nil, err
+		}
+	}
+	for _, seg := range segs[first:] {
+		if _, err := filepath.Match(seg, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	// 第一个 ** 之前的部分仍然使用 filepath.Glob 匹配
+	prefix := strings.Join(segs[:first], "/")
+	if first == 0 {
+		prefix = "."
+	} else if prefix == "" {
+		prefix = "/"
+	}
+	bases, err := filepath.Glob(filepath.FromSlash(prefix))
+	if err != nil {
+		return nil, err
+	}
+	g := &globber{maxDepth: maxDepth, found: make(map[string]bool)}
+	for _, base := range bases {
+		if first == 0 {
+			base = ""
+		}
+		g.match(base, segs[first:], 0)
+	}
+	matches := make([]string, 0, len(g.found))
+	for m := range g.found {
+		matches = append(matches, m)
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+type globber struct {
+	maxDepth int
+	found    map[string]bool
+}
+
+func (g *globber) match(dir string, segs []string, depth int) {
+	if len(segs) == 0 {
+		if dir != "" {
+			g.found[dir] = true
+		}
+		return
+	}
+	seg := segs[0]
+	if seg == globStar {
+		// ** 匹配零层目录
+		g.match(dir, segs[1:], depth)
+		if depth >= g.maxDepth {
+			return
+		}
+		for _, fi := range readDir(dir) {
+			if fi.IsDir() {
+				g.match(filepath.Join(dir, fi.Name()), segs, depth+1)
+			}
+		}
+		return
+	}
+	// 连续的 ** 之后，最后一级为空说明模式以 / 结尾
+	if seg == "" {
+		g.match(dir, segs[1:], depth)
+		return
+	}
+	for _, fi := range readDir(dir) {
+		if ok, _ := filepath.Match(seg, fi.Name()); ok {
+			g.match(filepath.Join(dir, fi.Name()), segs[1:], depth)
+		}
+	}
+}
+
+// readDir 读取目录失败(不是目录、没有权限或已被删除)时当作空目录
+func readDir(dir string) []os.FileInfo {
+	if dir == "" {
+		dir = "."
+	}
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	return fis
+}
@@ -0,0 +1,280 @@
+package tailx
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/json-iterator/go"
+
+	"github.com/qiniu/log"
+
+	"github.com/qiniu/logkit/conf"
+	. "github.com/qiniu/logkit/reader/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+// 开启 k8s_meta 时添加到数据中的字段
+const (
+	K8sPod         = "k8s_pod"
+	K8sNamespace   = "k8s_namespace"
+	K8sContainer   = "k8s_container"
+	K8sContainerID = "k8s_container_id"
+	K8sPodUID      = "k8s_pod_uid"
+	// K8sLabelPrefix pod 标签对应字段的前缀
+	K8sLabelPrefix = "k8s_label_"
+)
+
+const (
+	defaultK8sTokenFile       = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	defaultK8sRefreshInterval = time.Minute
+	// 遇到未知的 pod 时触发刷新的最小间隔，避免新 pod 的日志频繁请求 kubelet
+	k8sMinRefreshGap = 10 * time.Second
+)
+
+var containerIDRegex = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// containerLog 容器日志路径中包含的 k8s 信息
+type containerLog struct {
+	pod         string
+	namespace   string
+	container   string
+	containerID string
+	podUID      string
+}
+
+// parseContainerLog 解析 kubelet 创建的容器日志路径，支持以下两种格式：
+// /var/log/containers/<pod>_<namespace>_<container>-<container_id>.log
+// /var/log/pods/<namespace>_<pod>_<pod_uid>/<container>/<restart_count>.log
+func parseContainerLog(path string) (containerLog, bool) {
+	base := filepath.Base(path)
+	if !strings.HasSuffix(base, ".log") {
+		return containerLog{}, false
+	}
+	name := strings.TrimSuffix(base, ".log")
+	// pod、namespace 以及容器的名称中都不能包含下划线
+	if parts := strings.Split(name, "_"); len(parts) == 3 && parts[0] != "" && parts[1] != "" {
+		if idx := strings.LastIndex(parts[2], "-"); idx > 0 && containerIDRegex.MatchString(parts[2][idx+1:]) {
+			return containerLog{
+				pod:         parts[0],
+				namespace:   parts[1],
+				container:   parts[2][:idx],
+				containerID: parts[2][idx+1:],
+			}, true
+		}
+	}
+	if name == "" || strings.TrimLeft(name, "0123456789") != "" {
+		return containerLog{}, false
+	}
+	containerDir := filepath.Dir(path)
+	parts := strings.Split(filepath.Base(filepath.Dir(containerDir)), "_")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return containerLog{}, false
+	}
+	return containerLog{
+		namespace: parts[0],
+		pod:       parts[1],
+		podUID:    parts[2],
+		container: filepath.Base(containerDir),
+	}, true
+}
+
+// labelField 返回 pod 标签对应的字段名，标签名中字母、数字以外的字符替换为下划线
+func labelField(label string) string {
+	return K8sLabelPrefix + strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, label)
+}
+
+type k8sPod struct {
+	uid    string
+	labels map[string]string
+}
+
+// k8sMeta 为容器日志添加 k8s 元数据，配置 kubeletURL 时定期从 kubelet 获取 pod 的标签
+type k8sMeta struct {
+	runnerName string
+	kubeletURL string
+	tokenFile  string
+	interval   time.Duration
+	client     *http.Client
+	refresh    chan struct{}
+
+	mux  sync.RWMutex
+	pods map[string]k8sPod // namespace/pod -> pod 信息
+	prev map[string]k8sPod // 上一次获取到的 pod 信息，已经删除的 pod 可能还有日志没有读完
+}
+
+// newK8sMeta 没有开启 k8s_meta 时返回 nil
+func newK8sMeta(runnerName string, c conf.MapConf) (*k8sMeta, error) {
+	enabled, _ := c.GetBoolOr(KeyK8sMeta, false)
+	if !enabled {
+		return nil, nil
+	}
+	kubeletURL, _ := c.GetStringOr(KeyK8sKubeletURL, "")
+	tokenFile, _ := c.GetStringOr(KeyK8sTokenFile, defaultK8sTokenFile)
+	insecureSkipVerify, _ := c.GetBoolOr(KeyK8sInsecureSkipVerify, false)
+	intervalStr, _ := c.GetStringOr(KeyK8sRefreshInterval, defaultK8sRefreshInterval.String())
+	interval, err := time.ParseDuration(intervalStr)
+	if err != nil {
+		return nil, err
+	}
+	if interval <= 0 {
+		return nil, fmt.Errorf("%q value %q should be positive", KeyK8sRefreshInterval, intervalStr)
+	}
+	return &k8sMeta{
+		runnerName: runnerName,
+		kubeletURL: strings.TrimRight(kubeletURL, "/"),
+		tokenFile:  tokenFile,
+		interval:   interval,
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: insecureSkipVerify},
+			},
+		},
+		refresh: make(chan struct{}, 1),
+		pods:    make(map[string]k8sPod),
+	}, nil
+}
+
+// tags 返回 source 对应的 k8s 字段，不是容器日志时返回 nil
+func (k *k8sMeta) tags(source string) map[string]interface{} {
+	cl, ok := parseContainerLog(source)
+	if !ok {
+		return nil
+	}
+	tags := map[string]interface{}{
+		K8sPod:       cl.pod,
+		K8sNamespace: cl.namespace,
+		K8sContainer: cl.container,
+	}
+	if cl.containerID != "" {
+		tags[K8sContainerID] = cl.containerID
+	}
+	if cl.podUID != "" {
+		tags[K8sPodUID] = cl.podUID
+	}
+	if k.kubeletURL == "" {
+		return tags
+	}
+
+	key := cl.namespace + "/" + cl.pod
+	k.mux.RLock()
+	pod, ok := k.pods[key]
+	if !ok {
+		pod, ok = k.prev[key]
+	}
+	k.mux.RUnlock()
+	if !ok {
+		select {
+		case k.refresh <- struct{}{}:
+		default:
+		}
+		return tags
+	}
+	if pod.uid != "" {
+		tags[K8sPodUID] = pod.uid
+	}
+	for label, value := range pod.labels {
+		tags[labelField(label)] = value
+	}
+	return tags
+}
+
+// fetchPods 从 kubelet 的 /pods 接口获取当前节点上的所有 pod
+func (k *k8sMeta) fetchPods() (map[string]k8sPod, error) {
+	req, err := http.NewRequest(http.MethodGet, k.kubeletURL+"/pods", nil)
+	if err != nil {
+		return nil, err
+	}
+	// token 会被定期轮换，每次请求时重新读取
+	if k.tokenFile != "" {
+		token, err := ioutil.ReadFile(k.tokenFile)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if len(token) > 0 {
+			req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+		}
+	}
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("kubelet %s returned %s: %s", req.URL, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var list struct {
+		Items []struct {
+			Metadata struct {
+				Name      string            `json:"name"`
+				Namespace string            `json:"namespace"`
+				UID       string            `json:"uid"`
+				Labels    map[string]string `json:"labels"`
+			} `json:"metadata"`
+		} `json:"items"`
+	}
+	if err = jsoniter.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("decode kubelet pods error: %v", err)
+	}
+	pods := make(map[string]k8sPod, len(list.Items))
+	for _, item := range list.Items {
+		pods[item.Metadata.Namespace+"/"+item.Metadata.Name] = k8sPod{uid: item.Metadata.UID, labels: item.Metadata.Labels}
+	}
+	return pods, nil
+}
+
+func (k *k8sMeta) update() {
+	pods, err := k.fetchPods()
+	if err != nil {
+		if !IsSelfRunner(k.runnerName) {
+			log.Warnf("Runner[%s] fetch pods from kubelet %s error: %v", k.runnerName, k.kubeletURL, err)
+		} else {
+			log.Debugf("Runner[%s] fetch pods from kubelet %s error: %v", k.runnerName, k.kubeletURL, err)
+		}
+		return
+	}
+	k.mux.Lock()
+	k.prev, k.pods = k.pods, pods
+	k.mux.Unlock()
+}
+
+// run 定期刷新 pod 信息，遇到未知的 pod 时提前刷新，直到 stop 被关闭
+func (k *k8sMeta) run(stop <-chan struct{}) {
+	if k.kubeletURL == "" {
+		return
+	}
+	k.update()
+	last := time.Now()
+	ticker := time.NewTicker(k.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		case <-k.refresh:
+			if time.Since(last) < k8sMinRefreshGap {
+				continue
+			}
+		}
+		k.update()
+		last = time.Now()
+	}
+}
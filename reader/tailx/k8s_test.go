@@ -0,0 +1,99 @@
+package tailx
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/qiniu/logkit/conf"
+	. "github.com/qiniu/logkit/reader/config"
+)
+
+func TestParseContainerLog(t *testing.T) {
+	id := strings.Repeat("0123456789abcdef", 4)
+	cl, ok := parseContainerLog("/var/log/containers/web-7d9f8c6b5-x2k4p_default_nginx-proxy-" + id + ".log")
+	assert.True(t, ok)
+	assert.Equal(t, containerLog{pod: "web-7d9f8c6b5-x2k4p", namespace: "default", container: "nginx-proxy", containerID: id}, cl)
+
+	cl, ok = parseContainerLog("/var/log/pods/kube-system_coredns-abc_6f1c2d3e-uid/coredns/3.log")
+	assert.True(t, ok)
+	assert.Equal(t, containerLog{pod: "coredns-abc", namespace: "kube-system", container: "coredns", podUID: "6f1c2d3e-uid"}, cl)
+
+	for _, path := range []string{
+		"/var/log/messages",
+		"/var/log/app/access.log",
+		"/var/log/containers/web_default_nginx.log",
+		"/var/log/containers/web_default_nginx-" + id + ".log.1",
+		"/var/log/pods/default_web/nginx/0.log",
+		"/var/log/pods/default_web_uid/nginx/current.log",
+	} {
+		_, ok = parseContainerLog(path)
+		assert.False(t, ok, path)
+	}
+}
+
+func TestK8sMeta(t *testing.T) {
+	k, err := newK8sMeta("TestK8sMeta", conf.MapConf{})
+	assert.NoError(t, err)
+	assert.Nil(t, k)
+	_, err = newK8sMeta("TestK8sMeta", conf.MapConf{KeyK8sMeta: "true", KeyK8sRefreshInterval: "0s"})
+	assert.Error(t, err)
+
+	dir, err := ioutil.TempDir("", "TestK8sMeta")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+	assert.NoError(t, ioutil.WriteFile(tokenFile, []byte("secret\n"), 0644))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/pods" || req.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"kind":"PodList","items":[{"metadata":{"name":"web-1","namespace":"default","uid":"uid-1",
+			"labels":{"app":"web","app.kubernetes.io/version":"v1"}}}]}`))
+	}))
+	defer server.Close()
+
+	k, err = newK8sMeta("TestK8sMeta", conf.MapConf{
+		KeyK8sMeta:       "true",
+		KeyK8sKubeletURL: server.URL + "/",
+		KeyK8sTokenFile:  tokenFile,
+	})
+	assert.NoError(t, err)
+	id := strings.Repeat("a", 64)
+	source := "/var/log/containers/web-1_default_app-" + id + ".log"
+	// 获取到 pod 信息之前只添加从文件名中解析出的字段，并触发刷新
+	assert.Equal(t, map[string]interface{}{
+		K8sPod:         "web-1",
+		K8sNamespace:   "default",
+		K8sContainer:   "app",
+		K8sContainerID: id,
+	}, k.tags(source))
+	assert.Len(t, k.refresh, 1)
+
+	k.update()
+	assert.Equal(t, map[string]interface{}{
+		K8sPod:                                "web-1",
+		K8sNamespace:                          "default",
+		K8sContainer:                          "app",
+		K8sContainerID:                        id,
+		K8sPodUID:                             "uid-1",
+		"k8s_label_app":                       "web",
+		"k8s_label_app_kubernetes_io_version": "v1",
+	}, k.tags(source))
+	assert.Nil(t, k.tags("/var/log/syslog"))
+
+	// 鉴权失败时保留上次获取到的信息
+	assert.NoError(t, ioutil.WriteFile(tokenFile, []byte("expired"), 0644))
+	_, err = k.fetchPods()
+	assert.Error(t, err)
+	k.update()
+	assert.Equal(t, "uid-1", k.tags(source)[K8sPodUID])
+}
@@ -0,0 +1,169 @@
+package tailx
+
+import (
+	"fmt"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"github.com/qiniu/log"
+
+	. "github.com/qiniu/logkit/reader/config"
+)
+
+// 缓存超出上限时每个文件至少可以保留的字节数，避免文件很多时单条日志被截断得过短
+const minCacheShare = 4096
+
+// CacheStats 为所有 ActiveReader 缓存的统计信息
+type CacheStats struct {
+	Limit          int64  `json:"limit"`           // 缓存的最大字节数
+	Policy         string `json:"policy"`          // 超出上限时的处理方式
+	Used           int64  `json:"used"`            // 当前缓存的字节数
+	Peak           int64  `json:"peak"`            // 缓存字节数的峰值
+	Readers        int64  `json:"readers"`         // 参与统计的 ActiveReader 数量
+	Flushed        int64  `json:"flushed"`         // 提前发送不完整多行日志的次数
+	Paused         int64  `json:"paused"`          // 因超出上限暂停读取的次数
+	Truncated      int64  `json:"truncated"`       // 被截断的日志条数
+	TruncatedBytes int64  `json:"truncated_bytes"` // 截断丢弃的字节数
+}
+
+// cacheBudget 统计所有 ActiveReader 的 readcache 和多行缓存占用的字节数，为 nil 时表示不限制
+type cacheBudget struct {
+	limit  int64
+	policy string
+
+	used           int64
+	peak           int64
+	readers        int64
+	flushed        int64
+	paused         int64
+	truncated      int64
+	truncatedBytes int64
+}
+
+func newCacheBudget(limit int64, policy string) (*cacheBudget, error) {
+	switch policy {
+	case TailxCacheOverflowFlush, TailxCacheOverflowTruncate:
+	default:
+		return nil, fmt.Errorf("%q value %q is invalid, must be %q or %q", KeyTailxCacheOverflow, policy, TailxCacheOverflowFlush, TailxCacheOverflowTruncate)
+	}
+	if limit <= 0 {
+		return nil, nil
+	}
+	return &cacheBudget{limit: limit, policy: policy}, nil
+}
+
+func (b *cacheBudget) register() {
+	atomic.AddInt64(&b.readers, 1)
+}
+
+func (b *cacheBudget) unregister() {
+	atomic.AddInt64(&b.readers, -1)
+}
+
+// add 增加 delta 字节的占用，delta 可以为负数
+func (b *cacheBudget) add(delta int64) {
+	if delta == 0 {
+		return
+	}
+	used := atomic.AddInt64(&b.used, delta)
+	for {
+		peak := atomic.LoadInt64(&b.peak)
+		if used <= peak || atomic.CompareAndSwapInt64(&b.peak, peak, used) {
+			return
+		}
+	}
+}
+
+func (b *cacheBudget) exceeded() bool {
+	return atomic.LoadInt64(&b.used) > b.limit
+}
+
+// share 返回超出上限时每个 ActiveReader 可以保留的字节数
+func (b *cacheBudget) share() int64 {
+	share := b.limit
+	if readers := atomic.LoadInt64(&b.readers); readers > 1 {
+		share /= readers
+	}
+	if share < minCacheShare {
+		share = minCacheShare
+		if share > b.limit {
+			share = b.limit
+		}
+	}
+	return share
+}
+
+// truncate 在加上 line 之后超出上限时将 line 截断到 share 字节以内，不会截断在多字节字符的中间
+func (b *cacheBudget) truncate(line string) string {
+	if atomic.LoadInt64(&b.used)+int64(len(line)) <= b.limit {
+		return line
+	}
+	share := b.share()
+	if int64(len(line)) <= share {
+		return line
+	}
+	n := int(share)
+	for n > 0 && !utf8.RuneStart(line[n]) {
+		n--
+	}
+	atomic.AddInt64(&b.truncated, 1)
+	atomic.AddInt64(&b.truncatedBytes, int64(len(line)-n))
+	return line[:n]
+}
+
+func (b *cacheBudget) stats() CacheStats {
+	return CacheStats{
+		Limit:          b.limit,
+		Policy:         b.policy,
+		Used:           atomic.LoadInt64(&b.used),
+		Peak:           atomic.LoadInt64(&b.peak),
+		Readers:        atomic.LoadInt64(&b.readers),
+		Flushed:        atomic.LoadInt64(&b.flushed),
+		Paused:         atomic.LoadInt64(&b.paused),
+		Truncated:      atomic.LoadInt64(&b.truncated),
+		TruncatedBytes: atomic.LoadInt64(&b.truncatedBytes),
+	}
+}
+
+// CacheStats 返回缓存的统计信息，没有配置缓存上限时返回 nil
+func (r *Reader) CacheStats() *CacheStats {
+	if r.budget == nil {
+		return nil
+	}
+	stats := r.budget.stats()
+	return &stats
+}
+
+// account 重新计算 ActiveReader 缓存的字节数并更新到 budget 中
+func (ar *ActiveReader) account() {
+	if ar.budget == nil {
+		return
+	}
+	held := int64(len(ar.readcache) + ar.br.MultiLineSize())
+	ar.budget.add(held - atomic.SwapInt64(&ar.cached, held))
+}
+
+// release 在 ActiveReader 关闭时归还占用的字节数，多次调用只生效一次
+func (ar *ActiveReader) release() {
+	if ar.budget == nil || !atomic.CompareAndSwapInt32(&ar.released, 0, 1) {
+		return
+	}
+	ar.budget.add(-atomic.SwapInt64(&ar.cached, 0))
+	ar.budget.unregister()
+}
+
+// overflow 在 flush 策略下缓存超出上限且 readcache 为空时调用，提前取出多行缓存作为 readcache，
+// 没有多行缓存时返回 false 表示需要暂停读取，等待其他 ActiveReader 释放缓存
+func (ar *ActiveReader) overflow() bool {
+	if line := ar.br.FlushMultiLine(); line != "" {
+		atomic.AddInt64(&ar.budget.flushed, 1)
+		ar.cacheLineMux.Lock()
+		ar.readcache = line
+		ar.cacheLineMux.Unlock()
+		log.Debugf("Runner[%s] %s cache exceeds %d bytes, flush %d bytes of multiline cache", ar.runnerName, ar.originpath, ar.budget.limit, len(line))
+		return true
+	}
+	atomic.AddInt64(&ar.budget.paused, 1)
+	log.Debugf("Runner[%s] %s cache exceeds %d bytes, pause reading", ar.runnerName, ar.originpath, ar.budget.limit)
+	return false
+}
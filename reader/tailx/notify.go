@@ -0,0 +1,187 @@
+package tailx
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/qiniu/log"
+
+	. "github.com/qiniu/logkit/reader/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+// 收到目录事件后等待这段时间再扫描，合并短时间内的大量事件
+const notifyDelay = 100 * time.Millisecond
+
+// notifier 监听日志路径模式中各级目录的创建和改名事件，有新文件或新目录时通知立即扫描，
+// 定时扫描仍然保留，用于弥补丢失的事件
+type notifier struct {
+	runnerName string
+	watcher    *fsnotify.Watcher
+	watched    map[string]bool // 只在扫描的 goroutine 中访问
+	trigger    chan struct{}
+}
+
+func newNotifier(runnerName string) (*notifier, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	return &notifier{
+		runnerName: runnerName,
+		watcher:    watcher,
+		watched:    make(map[string]bool),
+		trigger:    make(chan struct{}, 1),
+	}, nil
+}
+
+// refresh 按最新的目录列表增减监听，新建的目录在下一次扫描后加入
+func (n *notifier) refresh(dirs []string) {
+	current := make(map[string]bool, len(dirs))
+	for _, dir := range dirs {
+		current[dir] = true
+		if n.watched[dir] {
+			continue
+		}
+		if err := n.watcher.Add(dir); err != nil {
+			log.Debugf("Runner[%s] watch dir %s error %v", n.runnerName, dir, err)
+			continue
+		}
+		n.watched[dir] = true
+	}
+	for dir := range n.watched {
+		if !current[dir] {
+			// 目录被删除时监听已经自动移除，忽略错误
+			n.watcher.Remove(dir)
+			delete(n.watched, dir)
+		}
+	}
+}
+
+// run 处理目录事件直到 stopChan 关闭，关闭时释放监听
+func (n *notifier) run(stopChan <-chan struct{}) {
+	defer n.watcher.Close()
+	var delay <-chan time.Time
+	for {
+		select {
+		case <-stopChan:
+			return
+		case event, ok := <-n.watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op&(fsnotify.Create|fsnotify.Rename) == 0 {
+				continue
+			}
+			if delay == nil {
+				delay = time.After(notifyDelay)
+			}
+		case err, ok := <-n.watcher.Errors:
+			if !ok {
+				return
+			}
+			// 事件队列溢出等错误可能丢失事件，同样触发一次扫描
+			log.Warnf("Runner[%s] watch log dirs error %v", n.runnerName, err)
+			if delay == nil {
+				delay = time.After(notifyDelay)
+			}
+		case <-delay:
+			delay = nil
+			select {
+			case n.trigger <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// startNotify 开启 notify 模式，系统不支持文件事件监听或监听数量超过限制时退回定时扫描
+func (r *Reader) startNotify() {
+	if r.statMode != StatModeNotify {
+		return
+	}
+	n, err := newNotifier(r.meta.RunnerName)
+	if err != nil {
+		if !IsSelfRunner(r.meta.RunnerName) {
+			log.Warnf("Runner[%s] create fsnotify watcher error %v, fall back to polling every %v", r.meta.RunnerName, err, r.statInterval)
+		} else {
+			log.Debugf("Runner[%s] create fsnotify watcher error %v, fall back to polling every %v", r.meta.RunnerName, err, r.statInterval)
+		}
+		return
+	}
+	r.notify = n
+	r.meta.Go("tailx notify", func() { n.run(r.stopChan) })
+}
+
+// notifyTrigger 返回 notify 模式下需要立即扫描时的通知，定时扫描时返回 nil
+func (r *Reader) notifyTrigger() <-chan struct{} {
+	if r.notify == nil {
+		return nil
+	}
+	return r.notify.trigger
+}
+
+func (r *Reader) refreshWatches() {
+	if r.notify == nil {
+		return
+	}
+	r.notify.refresh(watchDirs(r.logPathPattern, r.globMaxDepth))
+}
+
+// watchDirs 返回日志路径模式需要监听的目录：不含通配符的前缀中最深的已存在目录，
+// 以及之后每一级目录模式当前匹配的目录，这样新建的目录和其中的文件都能及时发现
+func watchDirs(pattern string, maxDepth int) []string {
+	segs := strings.Split(filepath.ToSlash(filepath.Dir(pattern)), "/")
+	static := len(segs)
+	for i, seg := range segs {
+		if seg == globStar || strings.ContainsAny(seg, "*?[") {
+			static = i
+			break
+		}
+	}
+
+	found := make(map[string]bool)
+	var dirs []string
+	add := func(dir string) {
+		if found[dir] {
+			return
+		}
+		if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+			return
+		}
+		found[dir] = true
+		dirs = append(dirs, dir)
+	}
+	// 静态前缀的目录还不存在时，监听最近的已存在的上级目录
+	if static == 0 {
+		add(".")
+	}
+	for i := static; i > 0; i-- {
+		dir := joinSegs(segs[:i])
+		if fi, err := os.Stat(dir); err == nil && fi.IsDir() {
+			add(dir)
+			break
+		}
+	}
+	for i := static + 1; i <= len(segs); i++ {
+		matches, err := globRecursive(joinSegs(segs[:i]), maxDepth)
+		if err != nil {
+			continue
+		}
+		for _, m := range matches {
+			add(m)
+		}
+	}
+	return dirs
+}
+
+func joinSegs(segs []string) string {
+	p := strings.Join(segs, "/")
+	if p == "" {
+		p = "/"
+	}
+	return filepath.FromSlash(p)
+}
@@ -0,0 +1,149 @@
+package tailx
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// 路径模式中命名捕获组的开头，如 /var/log/(?P<service>[^/]+)/access.log
+const namedGroupPrefix = "(?P<"
+
+// 命名捕获组在替换过程中的占位符，路径中不会出现该字符
+const groupPlaceholder = '\x00'
+
+// pathPattern 包含命名捕获组的路径模式，glob 用于扫描文件，regex 用于过滤扫描结果并提取捕获组的值
+type pathPattern struct {
+	glob  string
+	regex *regexp.Regexp
+}
+
+// parsePathPattern 解析包含命名捕获组的路径模式，每个捕获组在扫描文件时当作 *，只能匹配一级目录中的部分，
+// 其余部分仍然按照 glob 匹配，扫描到的文件需要同时满足捕获组中的正则。没有命名捕获组时返回 nil
+func parsePathPattern(pattern string) (*pathPattern, error) {
+	if !strings.Contains(pattern, namedGroupPrefix) {
+		return nil, nil
+	}
+
+	var (
+		glob, placeholders strings.Builder
+		groups             []string
+	)
+	for i := 0; i < len(pattern); {
+		if !strings.HasPrefix(pattern[i:], namedGroupPrefix) {
+			glob.WriteByte(pattern[i])
+			placeholders.WriteByte(pattern[i])
+			i++
+			continue
+		}
+		end, err := groupEnd(pattern, i)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, pattern[i:end])
+		glob.WriteByte('*')
+		placeholders.WriteByte(groupPlaceholder)
+		i = end
+	}
+
+	segs := strings.Split(filepath.ToSlash(placeholders.String()), "/")
+	var expr strings.Builder
+	expr.WriteByte('^')
+	for i, seg := range segs {
+		last := i == len(segs)-1
+		if seg == globStar {
+			// ** 匹配零层或多层目录，与后面的 / 一起匹配
+			if last {
+				expr.WriteString(".*")
+			} else {
+				expr.WriteString("(?:.*/)?")
+			}
+			continue
+		}
+		expr.WriteString(globToRegex(seg, &groups))
+		if !last {
+			expr.WriteByte('/')
+		}
+	}
+	expr.WriteByte('$')
+
+	regex, err := regexp.Compile(expr.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid named capture group in %q: %v", pattern, err)
+	}
+	return &pathPattern{glob: glob.String(), regex: regex}, nil
+}
+
+// groupEnd 返回从 start 开始的捕获组结束括号之后的位置
+func groupEnd(pattern string, start int) (int, error) {
+	depth := 0
+	for i := start; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i + 1, nil
+			}
+		}
+	}
+	return 0, errors.New("unclosed named capture group in " + pattern)
+}
+
+// globToRegex 将一级目录的 glob 转换为正则，占位符按顺序替换为 groups 中的捕获组
+func globToRegex(seg string, groups *[]string) string {
+	var expr strings.Builder
+	for i := 0; i < len(seg); i++ {
+		c := seg[i]
+		switch c {
+		case groupPlaceholder:
+			expr.WriteString((*groups)[0])
+			*groups = (*groups)[1:]
+		case '*':
+			expr.WriteString("[^/]*")
+		case '?':
+			expr.WriteString("[^/]")
+		case '\\':
+			if i+1 < len(seg) {
+				i++
+				expr.WriteString(regexp.QuoteMeta(seg[i : i+1]))
+			}
+		case '[':
+			end := strings.IndexByte(seg[i+1:], ']')
+			if end < 0 {
+				expr.WriteString(`\[`)
+				continue
+			}
+			expr.WriteString(seg[i : i+end+2])
+			i += end + 1
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return expr.String()
+}
+
+// match 判断扫描到的文件是否满足捕获组中的正则
+func (p *pathPattern) match(path string) bool {
+	return p.regex.MatchString(filepath.ToSlash(path))
+}
+
+// tags 返回文件路径中各命名捕获组的值，没有匹配到的捕获组不返回
+func (p *pathPattern) tags(path string) map[string]interface{} {
+	sub := p.regex.FindStringSubmatch(filepath.ToSlash(path))
+	if sub == nil {
+		return nil
+	}
+	tags := make(map[string]interface{})
+	for i, name := range p.regex.SubexpNames() {
+		if name != "" && sub[i] != "" {
+			tags[name] = sub[i]
+		}
+	}
+	return tags
+}
@@ -0,0 +1,85 @@
+package tailx
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/reader"
+	. "github.com/qiniu/logkit/reader/config"
+)
+
+func TestParsePathPattern(t *testing.T) {
+	p, err := parsePathPattern("/var/log/*/access.log")
+	assert.NoError(t, err)
+	assert.Nil(t, p)
+
+	p, err = parsePathPattern(`/var/log/(?P<service>[^/]+)/(?P<env>prod|test)-*.log`)
+	require.NoError(t, err)
+	assert.Equal(t, "/var/log/*/*-*.log", p.glob)
+	assert.True(t, p.match("/var/log/web/prod-1.log"))
+	assert.False(t, p.match("/var/log/web/dev-1.log"))
+	assert.False(t, p.match("/var/log/web/prod-1.log.bak"))
+	assert.Equal(t, map[string]interface{}{"service": "web", "env": "prod"}, p.tags("/var/log/web/prod-1.log"))
+	assert.Nil(t, p.tags("/var/log/web/dev-1.log"))
+
+	// ** 匹配零层或多层目录，glob 中的其他字符按原义匹配
+	p, err = parsePathPattern(`/data/(?P<app>\w+)/**/app.[lL]og`)
+	require.NoError(t, err)
+	assert.Equal(t, "/data/*/**/app.[lL]og", p.glob)
+	assert.True(t, p.match("/data/api/app.log"))
+	assert.True(t, p.match("/data/api/a/b/app.Log"))
+	assert.False(t, p.match("/data/api/appxlog"))
+	assert.Equal(t, map[string]interface{}{"app": "api"}, p.tags("/data/api/a/app.log"))
+
+	for _, pattern := range []string{
+		"/var/log/(?P<service>[^/]+/access.log",
+		"/var/log/(?P<service*)/access.log",
+	} {
+		_, err = parsePathPattern(pattern)
+		assert.Error(t, err, pattern)
+	}
+}
+
+func TestPathTags(t *testing.T) {
+	t.Parallel()
+	dirName := "TestPathTags"
+	metaDir := filepath.Join(dirName, "meta")
+	createDirWithName(dirName)
+	createDirWithName(filepath.Join(dirName, "web"))
+	createDirWithName(filepath.Join(dirName, "api"))
+	createDirWithName(filepath.Join(dirName, "123"))
+	defer os.RemoveAll(dirName)
+	createFileWithContent(filepath.Join(dirName, "web", "access.log"), "abc\n")
+	createFileWithContent(filepath.Join(dirName, "api", "access.log"), "abc\n")
+	createFileWithContent(filepath.Join(dirName, "123", "access.log"), "abc\n")
+
+	c := conf.MapConf{
+		"log_path":      filepath.Join(dirName, "(?P<service>[a-z]+)", "access.log"),
+		"meta_path":     metaDir,
+		"mode":          ModeTailx,
+		"read_from":     "oldest",
+		"stat_interval": "1h",
+	}
+	meta, err := reader.NewMetaWithConf(c)
+	require.NoError(t, err)
+	mmr, err := NewReader(meta, c)
+	require.NoError(t, err)
+	mr := mmr.(*Reader)
+	defer mr.Close()
+	assert.Equal(t, filepath.Join(dirName, "*", "access.log"), mr.logPathPattern)
+	assert.True(t, mr.SourceTagsEnabled())
+
+	// 不满足捕获组中正则的文件不读取
+	mr.statLogPath()
+	assert.Equal(t, 2, len(mr.fileReaders))
+	assert.Equal(t, map[string]interface{}{"service": "web"}, mr.SourceTags(filepath.Join(dirName, "web", "access.log")))
+
+	c["log_path"] = filepath.Join(dirName, "(?P<service>[a-z", "access.log")
+	_, err = NewReader(meta, c)
+	assert.Error(t, err)
+}
@@ -0,0 +1,40 @@
+package tailx
+
+import (
+	"math"
+	"time"
+)
+
+// rateWindow 按指数加权平均计算读取速度的时间窗口，越大越平滑
+const rateWindow = time.Minute
+
+// readRate 根据累计读取的字节数估计最近的读取速度，调用方负责加锁
+type readRate struct {
+	total int64     // 上次采样时累计读取的字节数
+	at    time.Time // 上次采样的时间
+	rate  float64   // 每秒读取的字节数
+	valid bool      // 是否已经有过一次完整的采样
+}
+
+func newReadRate(now time.Time) *readRate {
+	return &readRate{at: now}
+}
+
+// update 根据当前累计读取的字节数更新读取速度，距离上次采样不足一秒时直接返回上次的结果
+func (r *readRate) update(total int64, now time.Time) float64 {
+	elapsed := now.Sub(r.at)
+	if elapsed <= 0 || (r.valid && elapsed < time.Second) {
+		return r.rate
+	}
+	current := float64(total-r.total) / elapsed.Seconds()
+	if r.valid {
+		r.rate += (1 - math.Exp(-elapsed.Seconds()/rateWindow.Seconds())) * (current - r.rate)
+	} else {
+		r.rate = current
+	}
+	// 第一次采样不足一秒时只作为估计，不更新采样点
+	if elapsed >= time.Second {
+		r.total, r.at, r.valid = total, now, true
+	}
+	return r.rate
+}
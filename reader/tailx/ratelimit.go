@@ -0,0 +1,30 @@
+package tailx
+
+import "time"
+
+// lineLimiter 限制单个文件每秒读取的行数，最多累积一秒的额度用于突发，
+// 只在读取该文件的 worker 中使用，不需要加锁
+type lineLimiter struct {
+	interval time.Duration // 每读取一行需要的时间
+	next     time.Time     // 下一行可以读取的时间
+}
+
+// newLineLimiter linesPerSec 不大于 0 时不限制，返回 nil
+func newLineLimiter(linesPerSec int) *lineLimiter {
+	if linesPerSec <= 0 {
+		return nil
+	}
+	return &lineLimiter{interval: time.Second / time.Duration(linesPerSec)}
+}
+
+// take 取得读取一行的额度，额度不足时返回需要等待的时间
+func (l *lineLimiter) take(now time.Time) time.Duration {
+	if burst := now.Add(-time.Second); l.next.Before(burst) {
+		l.next = burst
+	}
+	if l.next.After(now) {
+		return l.next.Sub(now)
+	}
+	l.next = l.next.Add(l.interval)
+	return 0
+}
@@ -0,0 +1,254 @@
+package tailx
+
+import (
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/qiniu/log"
+
+	"github.com/qiniu/logkit/reader"
+	. "github.com/qiniu/logkit/reader/config"
+)
+
+// 一次调度读取之后 ActiveReader 的状态
+const (
+	arReady     = iota // 还有数据没有读完，重新放入就绪队列
+	arRetry            // 暂时没有读到数据，稍后重试
+	arIdle             // 读到文件末尾，等待文件发生变化
+	arThrottled        // 超过单个文件的读取速度限制，等待 throttle 之后重新放入就绪队列
+	arStopped          // 已经停止或者读取出错
+)
+
+const (
+	minWorkers          = 4
+	defaultPollInterval = time.Second
+	// 每次调度默认最多读取的行数，避免一个文件长时间占用 worker
+	defaultBatchLines = 1024
+	// 连续读到空行的次数超过该值后进入空闲状态
+	maxEmptyLines = 3
+	retryInterval = time.Second
+)
+
+func defaultWorkers() int {
+	workers := runtime.NumCPU() * 2
+	if workers < minWorkers {
+		workers = minWorkers
+	}
+	return workers
+}
+
+// fileSnapshot 记录 ActiveReader 进入空闲状态时文件的大小和修改时间，size 小于 0 时表示下次检查时直接唤醒
+type fileSnapshot struct {
+	size    int64
+	modTime time.Time
+}
+
+func statSnapshot(path string) fileSnapshot {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fileSnapshot{size: -1}
+	}
+	return fileSnapshot{size: fi.Size(), modTime: fi.ModTime()}
+}
+
+// changed 判断文件相对于快照是否发生了变化，文件不存在时不唤醒，交给 expire 回收
+func (s fileSnapshot) changed(path string) bool {
+	if s.size < 0 {
+		return true
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	return fi.Size() != s.size || !fi.ModTime().Equal(s.modTime)
+}
+
+// scheduler 使用固定数量的 worker 轮流读取所有的 ActiveReader，每次最多读取 batchLines 行后放回队尾，
+// 读到文件末尾的 ActiveReader 进入空闲列表，由 poller 定期检查文件的大小和修改时间，发生变化后重新放入就绪队列
+type scheduler struct {
+	runnerName   string
+	workers      int
+	batchLines   int
+	pollInterval time.Duration
+	runTime      reader.RunTime
+
+	mutex    sync.Mutex
+	cond     *sync.Cond
+	ready    []*ActiveReader
+	idle     map[*ActiveReader]fileSnapshot
+	stopped  bool
+	stopChan chan struct{}
+	wg       sync.WaitGroup
+}
+
+func newScheduler(runnerName string, workers, batchLines int, pollInterval time.Duration) *scheduler {
+	if workers <= 0 {
+		workers = defaultWorkers()
+	}
+	if batchLines <= 0 {
+		batchLines = defaultBatchLines
+	}
+	if pollInterval <= 0 {
+		pollInterval = defaultPollInterval
+	}
+	s := &scheduler{
+		runnerName:   runnerName,
+		workers:      workers,
+		batchLines:   batchLines,
+		pollInterval: pollInterval,
+		idle:         make(map[*ActiveReader]fileSnapshot),
+		stopChan:     make(chan struct{}),
+	}
+	s.cond = sync.NewCond(&s.mutex)
+	return s
+}
+
+// start 启动 worker 和轮询的 goroutine，goroutine 通过 meta.Go 启动，panic 时交给 runner 重启
+func (s *scheduler) start(runTime reader.RunTime, meta *reader.Meta) {
+	s.runTime = runTime
+	s.wg.Add(s.workers + 1)
+	for i := 0; i < s.workers; i++ {
+		meta.Go("tailx worker", s.work)
+	}
+	meta.Go("tailx poll", s.poll)
+	log.Debugf("Runner[%s] tailx scheduler started with %d workers", s.runnerName, s.workers)
+}
+
+// stop 停止所有 worker 并等待正在进行的读取结束，需要在 ActiveReader 都停止之后调用
+func (s *scheduler) stop() {
+	s.mutex.Lock()
+	if s.stopped {
+		s.mutex.Unlock()
+		return
+	}
+	s.stopped = true
+	s.ready = nil
+	s.idle = make(map[*ActiveReader]fileSnapshot)
+	close(s.stopChan)
+	s.cond.Broadcast()
+	s.mutex.Unlock()
+	s.wg.Wait()
+}
+
+// push 将 ActiveReader 放入就绪队列，已经在队列中或者正在被读取的 ActiveReader 不会重复放入
+func (s *scheduler) push(ar *ActiveReader) {
+	if !atomic.CompareAndSwapInt32(&ar.queued, 0, 1) {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.idle, ar)
+	if s.stopped {
+		atomic.StoreInt32(&ar.queued, 0)
+		return
+	}
+	s.ready = append(s.ready, ar)
+	s.cond.Signal()
+}
+
+// next 取出下一个就绪的 ActiveReader，scheduler 停止后返回 nil
+func (s *scheduler) next() *ActiveReader {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for len(s.ready) == 0 && !s.stopped {
+		s.cond.Wait()
+	}
+	if s.stopped {
+		return nil
+	}
+	ar := s.ready[0]
+	s.ready[0] = nil
+	s.ready = s.ready[1:]
+	return ar
+}
+
+// requeue 将读取之后仍然有数据的 ActiveReader 放回队尾，让其他文件也有机会被读取
+func (s *scheduler) requeue(ar *ActiveReader) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.stopped {
+		atomic.StoreInt32(&ar.queued, 0)
+		return
+	}
+	s.ready = append(s.ready, ar)
+	s.cond.Signal()
+}
+
+// release 读取结束后释放 ActiveReader，idle 为 true 时放入空闲列表等待文件变化
+func (s *scheduler) release(ar *ActiveReader, idle bool, snapshot fileSnapshot) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	atomic.StoreInt32(&ar.queued, 0)
+	if idle && !s.stopped && atomic.LoadInt32(&ar.status) == StatusRunning {
+		s.idle[ar] = snapshot
+	}
+}
+
+// remove 将停止的 ActiveReader 从空闲列表中移除
+func (s *scheduler) remove(ar *ActiveReader) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.idle, ar)
+}
+
+func (s *scheduler) work() {
+	defer s.wg.Done()
+	for {
+		ar := s.next()
+		if ar == nil {
+			return
+		}
+		switch ar.process(s.batchLines) {
+		case arReady:
+			s.requeue(ar)
+		case arRetry:
+			s.release(ar, false, fileSnapshot{})
+			time.AfterFunc(retryInterval, func() { s.push(ar) })
+		case arThrottled:
+			// 让出 worker 给其他文件，等到有额度时再读取
+			wait := ar.throttle
+			s.release(ar, false, fileSnapshot{})
+			time.AfterFunc(wait, func() { s.push(ar) })
+		case arIdle:
+			// 有等待发送的多行日志时，即使文件没有变化也在超时后重新读取
+			wait := ar.flushWait
+			s.release(ar, true, ar.snapshot)
+			if wait > 0 {
+				time.AfterFunc(wait, func() { s.push(ar) })
+			}
+		default:
+			s.release(ar, false, fileSnapshot{})
+		}
+	}
+}
+
+func (s *scheduler) poll() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopChan:
+			return
+		case <-ticker.C:
+		}
+		now := time.Now()
+		if !reader.InRunTime(now.Hour(), now.Minute(), s.runTime) {
+			continue
+		}
+		s.mutex.Lock()
+		idle := make(map[*ActiveReader]fileSnapshot, len(s.idle))
+		for ar, snapshot := range s.idle {
+			idle[ar] = snapshot
+		}
+		s.mutex.Unlock()
+		for ar, snapshot := range idle {
+			if snapshot.changed(ar.realpath) {
+				s.push(ar)
+			}
+		}
+	}
+}
@@ -7,7 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
-	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -23,6 +23,7 @@ import (
 	"github.com/qiniu/logkit/reader/bufreader"
 	. "github.com/qiniu/logkit/reader/config"
 	"github.com/qiniu/logkit/reader/extract"
+	"github.com/qiniu/logkit/reader/shard"
 	"github.com/qiniu/logkit/reader/singlefile"
 	"github.com/qiniu/logkit/utils"
 	. "github.com/qiniu/logkit/utils/models"
@@ -30,12 +31,16 @@ import (
 )
 
 var (
-	_ reader.DaemonReader  = &Reader{}
-	_ reader.StatsReader   = &Reader{}
-	_ reader.LagReader     = &Reader{}
-	_ reader.Reader        = &Reader{}
-	_ Resetable            = &Reader{}
-	_ reader.RunTimeReader = &Reader{}
+	_ reader.DaemonReader       = &Reader{}
+	_ reader.StatsReader        = &Reader{}
+	_ reader.DetailStatusReader = &Reader{}
+	_ reader.LagReader          = &Reader{}
+	_ reader.Reader             = &Reader{}
+	_ Resetable                 = &Reader{}
+	_ reader.RunTimeReader      = &Reader{}
+	_ reader.CompletionReader   = &Reader{}
+	_ reader.OpenFilesReader    = &Reader{}
+	_ reader.SourceTagsReader   = &Reader{}
 )
 
 func init() {
@@ -58,6 +63,7 @@ type Reader struct {
 	armapmux    sync.Mutex
 	currentFile string
 	headRegexp  *regexp.Regexp
+	framing     *reader.RecordFraming
 	cacheMap    map[string]string
 
 	expireMap map[string]int64 // expire file offset map, key is inode_path
@@ -69,17 +75,82 @@ type Reader struct {
 	submetaExpire        time.Duration
 	expireDelete         bool
 	deleteDirs           chan string
+	afterReadAction      string // 文件读到末尾、过期并且读取进度已经记录后的处理方式
+	moveTo               string // after_read_action 为 move 时的归档目录
 	runTime              reader.RunTime
 	statInterval         time.Duration
 	maxOpenFiles         int
+	fileLinesPerSec      int   // 单个文件每秒最多读取的行数，0 表示不限制
+	openFilesLimit       int32 // 管理端按配额设置的打开文件数上限，原子操作，0 表示只受 maxOpenFiles 限制
 	whence               string
+	fileEvents           bool
+	minQuietTime         time.Duration // 新发现的文件需要静默这段时间后才开始读取
+	ignoreOlderThan      time.Duration
+	minFileSize          int64
+	maxFileSize          int64
+	completionChecksum   bool // 文件读完过期后记录其校验值
+	fsType               string
+	globMaxDepth         int // log_path 中 ** 最多匹配的目录层数
+	statMode             string
+	flushTimeout         time.Duration // 多行日志读到文件末尾后等待后续行的时间
+	readCompressed       bool          // 流式解压读取 .gz、.bz2 文件，并按解压后的字节数记录读取位置
+
+	eventsMux  sync.Mutex
+	events     []Data               // 尚未被 runner 取走的文件生命周期事件，不经过 parser，eventsMux
+	fileStates map[string]fileState // 开启 fileEvents 时记录文件状态用于判断轮转和截断，armapmux
+	symlinks   map[string]string    // 匹配到的软链接 -> 上次扫描时指向的目标，用于发现目标切换，armapmux
+
+	notify *notifier // notify 模式下监听目录事件，为 nil 时只定时扫描
+
+	sched *scheduler // 所有 ActiveReader 由固定数量的 worker 调度读取
+
+	budget *cacheBudget // 所有 ActiveReader 缓存的上限，为 nil 时不限制
+
+	shard *shard.Coordinator // 与其他 logkit 实例分配文件，为 nil 时读取所有匹配的文件
+
+	k8s *k8sMeta // 为容器日志添加 k8s 元数据，为 nil 时不添加
+
+	pathTags *pathPattern // log_path 中包含命名捕获组时为捕获组的值添加字段，为 nil 时不添加
+
+	// 文件标识(设备号与 inode) -> 被改名轮转的文件在轮转前读取到的 offset，用于改名后的文件继续读取
+	rotatedFiles  map[string]rotatedFile
+	fileIDsLoaded bool
+	fileIDsMux    sync.Mutex
 
 	notFirstTime bool
 }
 
+// 文件生命周期事件类型
+const (
+	FileEventDiscovered = "discovered"
+	FileEventRotated    = "rotated"
+	FileEventTruncated  = "truncated"
+	FileEventExpired    = "expired"
+	FileEventDeleted    = "deleted"
+)
+
+// 文件生命周期事件数据中的字段
+const (
+	KeyFileEvent     = "file_event"
+	KeyFileEventPath = "file_path"
+	KeyFileOffset    = "file_offset"
+	KeyFileSize      = "file_size"
+	KeyFileEventTime = "file_event_time"
+)
+
+// networkModifiedSlack NFS 客户端默认最多缓存文件属性 60s(acregmax)，判断网络文件系统上的文件是否修改时额外放宽的时间
+const networkModifiedSlack = time.Minute
+
+type fileState struct {
+	inode uint64
+	size  int64
+}
+
 type ActiveReader struct {
 	cacheLineMux sync.RWMutex
 	br           *bufreader.BufReader
+	sf           *singlefile.SingleFile    // 非压缩文件时用于获取当前读取文件的标识
+	df           *extract.DecompressedFile // 开启 read_compressed 时流式解压读取的压缩文件
 	realpath     string
 	originpath   string
 	readcache    string
@@ -92,8 +163,27 @@ type ActiveReader struct {
 
 	emptyLineCnt int
 
+	sched    *scheduler
+	queued   int32        // 在就绪队列中或者正在被 worker 读取，避免重复调度
+	busy     int32        // worker 正在读取，Stop 时需要等待读取结束
+	snapshot fileSnapshot // 进入空闲状态时文件的快照，只在 worker 中读写
+	retired  int32        // 软链接已经切换到其他目标，读完后关闭
+
+	limiter   *lineLimiter  // 单个文件的读取速度限制，为 nil 时不限制，只在 worker 中使用
+	throttle  time.Duration // 超过读取速度限制时需要等待的时间，只在 worker 中读写
+	flushWait time.Duration // 空闲时多行日志还需要等待多久发送，为 0 时只等待文件变化，只在 worker 中读写
+
+	budget   *cacheBudget
+	cached   int64 // 已经计入 budget 的字节数
+	released int32
+
 	stats     StatsInfo
 	statsLock sync.RWMutex
+	lastRead  int64 // 最后一次读到数据的时间，UnixNano
+	readBytes int64 // 累计读取的字节数，用于估计读取速度
+
+	rateMux sync.Mutex
+	rate    *readRate
 }
 
 type Result struct {
@@ -102,16 +192,13 @@ type Result struct {
 }
 
 func NewActiveReader(originPath, realPath, whence, inode string, r *Reader) (ar *ActiveReader, err error) {
-	rpath := strings.Replace(realPath, string(os.PathSeparator), "_", -1)
-	if runtime.GOOS == "windows" {
-		rpath = strings.Replace(rpath, ":", "_", -1)
-	}
-	subMetaPath := filepath.Join(r.meta.Dir, rpath)
-	subMeta, err := reader.NewMetaWithRunnerName(r.meta.RunnerName, subMetaPath, subMetaPath, realPath, ModeFile, r.meta.TagFile, reader.DefautFileRetention)
+	subMetaDir := subMetaPath(r.meta.Dir, realPath)
+	subMeta, err := reader.NewMetaWithRunnerName(r.meta.RunnerName, subMetaDir, subMetaDir, realPath, ModeFile, r.meta.TagFile, reader.DefautFileRetention)
 	if err != nil {
 		return nil, err
 	}
 	subMeta.SetEncodingWay(r.meta.GetEncodingWay())
+	subMeta.SetMetaEncoding(r.meta.GetMetaEncoding())
 	subMeta.Readlimit = r.meta.Readlimit
 	isNewFile := r.meta.IsStatisticFileExist() || r.notFirstTime //是否为存量文件
 	if isNewFile && subMeta.IsNotExist() {
@@ -123,17 +210,34 @@ func NewActiveReader(originPath, realPath, whence, inode string, r *Reader) (ar
 	if len(r.expireMap) != 0 {
 		originOffset = r.expireMap[inode+"_"+realPath]
 	}
-	var fr reader.FileReader
-	if reader.CompressedFile(realPath) {
+	var (
+		fr reader.FileReader
+		sf *singlefile.SingleFile
+		df *extract.DecompressedFile
+	)
+	if r.readCompressed && extract.StreamFormat(realPath) != "" {
+		df, err = extract.NewDecompressedFile(subMeta, realPath)
+		if err != nil {
+			return
+		}
+		fr = df
+	} else if reader.CompressedFile(realPath) {
 		fr, err = extract.NewReader(subMeta, realPath, extract.Opts{IgnoreHidden: true})
 		if err != nil {
 			return
 		}
 	} else {
-		fr, err = singlefile.NewSingleFile(subMeta, realPath, whence, originOffset, true)
+		originOffset = r.restoreOffset(subMeta, realPath, originOffset)
+		sf, err = singlefile.NewSingleFile(subMeta, realPath, whence, originOffset, true)
 		if err != nil {
 			return
 		}
+		sf.SetNetworkFS(r.isNetworkFS(realPath))
+		if err = sf.TrackFileID(r.onFileRotated(realPath)); err != nil {
+			log.Warnf("Runner[%s] %s track file id error %v, ignore...", r.meta.RunnerName, realPath, err)
+			err = nil
+		}
+		fr = sf
 	}
 	bf, err := bufreader.NewReaderSize(fr, subMeta, bufreader.DefaultBufSize)
 	if err != nil {
@@ -141,9 +245,28 @@ func NewActiveReader(originPath, realPath, whence, inode string, r *Reader) (ar
 		fr.Close()
 		return
 	}
+	bf.SetRecordFraming(r.framing)
+	bf.SetFlushTimeout(r.flushTimeout)
+	// 上次关闭时已经读出但还未发送的数据，恢复后删除记录，避免异常退出后重复恢复
+	readcache, err := subMeta.ReadPendingLine()
+	if err != nil {
+		log.Warnf("Runner[%s] %s read pending line from meta error %v, ignore...", r.meta.RunnerName, realPath, err)
+		readcache = ""
+	} else if readcache != "" {
+		log.Debugf("Runner[%s] %s restore pending line success: [%s]", r.meta.RunnerName, realPath, readcache)
+		if err = subMeta.WritePendingLine(""); err != nil {
+			log.Warnf("Runner[%s] %s remove pending line from meta error %v", r.meta.RunnerName, realPath, err)
+		}
+	}
+	if r.budget != nil {
+		r.budget.register()
+	}
 	return &ActiveReader{
 		cacheLineMux: sync.RWMutex{},
 		br:           bf,
+		sf:           sf,
+		df:           df,
+		readcache:    readcache,
 		realpath:     realPath,
 		originpath:   originPath,
 		msgchan:      r.msgChan,
@@ -154,16 +277,16 @@ func NewActiveReader(originPath, realPath, whence, inode string, r *Reader) (ar
 		status:       StatusInit,
 		statsLock:    sync.RWMutex{},
 		runtime:      r.runTime,
+		sched:        r.sched,
+		limiter:      newLineLimiter(r.fileLinesPerSec),
+		budget:       r.budget,
+		rate:         newReadRate(time.Now()),
 	}, nil
 
 }
 
+// Start 将 ActiveReader 交给 scheduler 调度读取，已经在调度中的 ActiveReader 会被立即唤醒
 func (ar *ActiveReader) Start() {
-	if atomic.LoadInt32(&ar.status) == StatusRunning {
-		log.Warnf("Runner[%s] ActiveReader %s was already running", ar.runnerName, ar.originpath)
-		return
-	}
-
 	if atomic.LoadInt32(&ar.status) == StatusStopping {
 		cnt := 0
 		// 等待结束
@@ -180,9 +303,11 @@ func (ar *ActiveReader) Start() {
 		log.Warnf("Runner[%s] ActiveReader %s was stopped", ar.runnerName, ar.originpath)
 	}
 
-	atomic.StoreInt32(&ar.status, StatusInit)
-
-	go ar.Run()
+	if atomic.LoadInt32(&ar.status) != StatusRunning {
+		ar.emptyLineCnt = 0
+		atomic.StoreInt32(&ar.status, StatusRunning)
+	}
+	ar.sched.push(ar)
 }
 
 func (ar *ActiveReader) Stop() error {
@@ -200,20 +325,24 @@ func (ar *ActiveReader) Stop() error {
 	}
 
 	cnt := 0
-	// 等待结束
-	for atomic.LoadInt32(&ar.status) != StatusStopped {
+	// 等待 worker 结束本次读取
+	for atomic.LoadInt32(&ar.busy) > 0 {
 		cnt++
-		//超过3个1s，即3s，就强行退出
-		if cnt > 3 {
+		//超过300个10ms，即3s，就强行退出
+		if cnt > 300 {
 			log.Debugf("Runner[%s] ActiveReader %s was not closed after 3s, force closing it", ar.runnerName, ar.originpath)
 			break
 		}
-		time.Sleep(1 * time.Second)
+		time.Sleep(10 * time.Millisecond)
+	}
+	atomic.StoreInt32(&ar.status, StatusStopped)
+	if ar.sched != nil {
+		ar.sched.remove(ar)
 	}
-
 	return nil
 }
 
+// Run 在当前 goroutine 中读取直到文件末尾或者被停止，Reader 中的 ActiveReader 由 scheduler 调度读取，不使用 Run
 func (ar *ActiveReader) Run() {
 	if !atomic.CompareAndSwapInt32(&ar.status, StatusInit, StatusRunning) {
 		if !IsSelfRunner(ar.runnerName) {
@@ -224,29 +353,64 @@ func (ar *ActiveReader) Run() {
 		return
 	}
 
-	var err error
-	timer := time.NewTicker(time.Second)
-	defer timer.Stop()
 	for {
-		if atomic.LoadInt32(&ar.status) == StatusStopped || atomic.LoadInt32(&ar.status) == StatusStopping {
-			atomic.CompareAndSwapInt32(&ar.status, StatusStopping, StatusStopped)
-			if !IsSelfRunner(ar.runnerName) {
-				log.Warnf("Runner[%s] ActiveReader %s was stopped", ar.runnerName, ar.originpath)
-			} else {
-				log.Debugf("Runner[%s] ActiveReader %s was stopped", ar.runnerName, ar.originpath)
+		switch ar.process(defaultBatchLines) {
+		case arReady:
+		case arRetry:
+			time.Sleep(retryInterval)
+		case arThrottled:
+			time.Sleep(ar.throttle)
+		case arIdle:
+			// 等待多行日志后续的行，超时后发送
+			if ar.flushWait <= 0 {
+				atomic.CompareAndSwapInt32(&ar.status, StatusRunning, StatusStopped)
+				return
 			}
+			time.Sleep(ar.flushWait)
+		default:
+			atomic.CompareAndSwapInt32(&ar.status, StatusRunning, StatusStopped)
 			return
 		}
-		now := time.Now()
-		if !reader.InRunTime(now.Hour(), now.Minute(), ar.runtime) {
-			time.Sleep(time.Minute)
-			continue
-		}
+	}
+}
+
+// process 读取最多 maxLines 行数据并发送到 msgchan，返回读取之后的状态，同一时刻只会有一个 worker 调用
+func (ar *ActiveReader) process(maxLines int) int {
+	atomic.StoreInt32(&ar.busy, 1)
+	defer atomic.StoreInt32(&ar.busy, 0)
+	if atomic.LoadInt32(&ar.status) != StatusRunning {
+		return arStopped
+	}
+	now := time.Now()
+	if !reader.InRunTime(now.Hour(), now.Minute(), ar.runtime) {
+		// 不在运行时间内，进入运行时间后直接唤醒
+		ar.snapshot = fileSnapshot{size: -1}
+		return arIdle
+	}
 
+	var (
+		err         error
+		snapshotted bool
+	)
+	for lines := 0; lines < maxLines; {
+		if ar.readcache == "" && ar.budget != nil && ar.budget.policy == TailxCacheOverflowFlush && ar.budget.exceeded() {
+			if !ar.overflow() {
+				return arRetry
+			}
+		}
+		if ar.readcache == "" && ar.limiter != nil {
+			if ar.throttle = ar.limiter.take(time.Now()); ar.throttle > 0 {
+				return arThrottled
+			}
+		}
 		if ar.readcache == "" {
 			ar.cacheLineMux.Lock()
 			ar.readcache, err = ar.br.ReadLine()
+			if ar.budget != nil && ar.budget.policy == TailxCacheOverflowTruncate {
+				ar.readcache = ar.budget.truncate(ar.readcache)
+			}
 			ar.cacheLineMux.Unlock()
+			ar.account()
 			if err != nil && err != io.EOF && err != os.ErrClosed {
 				if !IsSelfRunner(ar.runnerName) {
 					log.Warnf("Runner[%s] ActiveReader %s read error: %v, stop it", ar.runnerName, ar.originpath, err)
@@ -255,59 +419,84 @@ func (ar *ActiveReader) Run() {
 				}
 				ar.setStatsError(err.Error())
 				ar.sendError(err)
-				ar.Stop()
-				return
+				atomic.StoreInt32(&ar.status, StatusStopped)
+				return arStopped
 			}
 			if ar.readcache == "" {
-				ar.emptyLineCnt++
-				//文件EOF，同时没有任何内容，代表不是第一次EOF，休息时间设置长一些
 				if err == io.EOF {
+					// 先记录文件快照再确认一次是否读完，避免遗漏记录快照之前写入的数据
+					if !snapshotted {
+						ar.snapshot = statSnapshot(ar.realpath)
+						snapshotted = true
+						continue
+					}
 					atomic.StoreInt32(&ar.inactive, 1)
-					log.Debugf("Runner[%s] %s meet EOF, ActiveReader was inactive now, stop it", ar.runnerName, ar.originpath)
-					ar.Stop()
-					return
+					log.Debugf("Runner[%s] %s meet EOF, ActiveReader was inactive now", ar.runnerName, ar.originpath)
+					ar.flushWait = ar.br.FlushWait()
+					return arIdle
 				}
-				// 3s 没读到内容，设置为inactive
-				if ar.emptyLineCnt > 3 {
+				ar.emptyLineCnt++
+				// 连续多次没读到内容，设置为inactive
+				if ar.emptyLineCnt > maxEmptyLines {
 					atomic.StoreInt32(&ar.inactive, 1)
-					log.Debugf("Runner[%s] %s meet EOF, ActiveReader was inactive now, stop it", ar.runnerName, ar.originpath)
-					ar.Stop()
-					return
+					ar.snapshot = statSnapshot(ar.realpath)
+					log.Debugf("Runner[%s] %s read nothing for %d times, ActiveReader was inactive now", ar.runnerName, ar.originpath, ar.emptyLineCnt)
+					ar.flushWait = ar.br.FlushWait()
+					return arIdle
 				}
-				//读取的结果为空，无论如何都sleep 1s
-				time.Sleep(time.Second)
-				continue
+				return arRetry
 			}
 		}
 		log.Debugf("Runner[%s] %s >>>>>>readcache <%s> linecache <%s>", ar.runnerName, ar.originpath, strings.TrimSpace(ar.readcache), string(ar.br.FormMutiLine()))
-		repeat := 0
-		for {
-			if ar.readcache == "" {
-				break
-			}
-			repeat++
-			if repeat%3000 == 0 {
-				if !IsSelfRunner(ar.runnerName) {
-					log.Errorf("Runner[%s] %s ActiveReader has timeout 3000 times with readcache %s", ar.runnerName, ar.originpath, strings.TrimSpace(ar.readcache))
-				} else {
-					log.Debugf("Runner[%s] %s ActiveReader has timeout 3000 times with readcache %s", ar.runnerName, ar.originpath, strings.TrimSpace(ar.readcache))
-				}
-			}
+		atomic.StoreInt32(&ar.inactive, 0)
+		ar.emptyLineCnt = 0
+		snapshotted = false
+		atomic.StoreInt64(&ar.lastRead, time.Now().UnixNano())
+		atomic.AddInt64(&ar.readBytes, int64(len(ar.readcache)))
+		if !ar.send() {
+			return arStopped
+		}
+		ar.account()
+		lines++
+	}
+	return arReady
+}
 
-			atomic.StoreInt32(&ar.inactive, 0)
-			ar.emptyLineCnt = 0
-			//做这一层结构为了快速结束
-			if atomic.LoadInt32(&ar.status) == StatusStopped || atomic.LoadInt32(&ar.status) == StatusStopping {
-				log.Debugf("Runner[%s] %s ActiveReader was stopped when waiting to send data", ar.runnerName, ar.originpath)
-				atomic.CompareAndSwapInt32(&ar.status, StatusStopping, StatusStopped)
-				return
-			}
-			select {
-			case ar.msgchan <- Result{result: ar.readcache, logpath: ar.originpath}:
-				ar.cacheLineMux.Lock()
-				ar.readcache = ""
-				ar.cacheLineMux.Unlock()
-			case <-timer.C:
+// send 将 readcache 发送到 msgchan，发送过程中 ActiveReader 被停止时返回 false
+func (ar *ActiveReader) send() bool {
+	result := Result{result: ar.readcache, logpath: ar.originpath}
+	select {
+	case ar.msgchan <- result:
+		ar.cacheLineMux.Lock()
+		ar.readcache = ""
+		ar.cacheLineMux.Unlock()
+		return true
+	default:
+	}
+
+	timer := time.NewTicker(time.Second)
+	defer timer.Stop()
+	repeat := 0
+	for {
+		//做这一层结构为了快速结束
+		if atomic.LoadInt32(&ar.status) != StatusRunning {
+			log.Debugf("Runner[%s] %s ActiveReader was stopped when waiting to send data", ar.runnerName, ar.originpath)
+			return false
+		}
+		select {
+		case ar.msgchan <- result:
+			ar.cacheLineMux.Lock()
+			ar.readcache = ""
+			ar.cacheLineMux.Unlock()
+			return true
+		case <-timer.C:
+		}
+		repeat++
+		if repeat%3000 == 0 {
+			if !IsSelfRunner(ar.runnerName) {
+				log.Errorf("Runner[%s] %s ActiveReader has timeout 3000 times with readcache %s", ar.runnerName, ar.originpath, strings.TrimSpace(ar.readcache))
+			} else {
+				log.Debugf("Runner[%s] %s ActiveReader has timeout 3000 times with readcache %s", ar.runnerName, ar.originpath, strings.TrimSpace(ar.readcache))
 			}
 		}
 	}
@@ -325,14 +514,31 @@ func (ar *ActiveReader) Close() error {
 	defer func() {
 		log.Debugf("Runner[%s] ActiveReader %s was closed", ar.runnerName, ar.originpath)
 	}()
-	ar.SyncMeta()
+	// 先等待 worker 结束读取，再记录 meta，保证记录的读取进度与缓存的数据一致
+	err := ar.Stop()
+	ar.persist()
 	brCloseErr := ar.br.Close()
-	if err := ar.Stop(); err != nil {
+	ar.release()
+	if err != nil {
 		return brCloseErr
 	}
 	return nil
 }
 
+// persist 将读取进度、多行缓存以及还未发送的 readcache 一起记录到 submeta 中，重新打开时恢复
+func (ar *ActiveReader) persist() {
+	ar.cacheLineMux.Lock()
+	defer ar.cacheLineMux.Unlock()
+	ar.br.SyncMeta()
+	if err := ar.br.Meta.WritePendingLine(ar.readcache); err != nil {
+		if !IsSelfRunner(ar.runnerName) {
+			log.Errorf("Runner[%s] %s cannot write pending line, err :%v", ar.runnerName, ar.originpath, err)
+		} else {
+			log.Debugf("Runner[%s] %s cannot write pending line, err :%v", ar.runnerName, ar.originpath, err)
+		}
+	}
+}
+
 func (ar *ActiveReader) setStatsError(err string) {
 	ar.statsLock.Lock()
 	defer ar.statsLock.Unlock()
@@ -365,6 +571,43 @@ func (ar *ActiveReader) Lag() (rl *LagInfo, err error) {
 	return ar.br.Lag()
 }
 
+// detailStatus 返回文件的读取进度，Offset 是底层文件的读取位置，包括已读入缓存但还未发送的数据
+func (ar *ActiveReader) detailStatus() FileStatus {
+	st := FileStatus{
+		Path:     ar.originpath,
+		Size:     -1,
+		Inactive: atomic.LoadInt32(&ar.inactive) > 0,
+	}
+	if ar.realpath != ar.originpath {
+		st.RealPath = ar.realpath
+	}
+	if fi, err := os.Stat(ar.realpath); err == nil {
+		st.Size = fi.Size()
+	} else if !os.IsNotExist(err) {
+		st.Error = err.Error()
+	}
+	if lag, err := ar.Lag(); err != nil {
+		st.Error = err.Error()
+	} else if lag != nil {
+		st.Lag = lag.Size
+	}
+	// 压缩包解压后读取，没有对应的读取位置，流式解压的压缩文件为解压后的读取位置
+	if ar.sf != nil {
+		st.Offset = ar.sf.Offset()
+	} else if ar.df != nil {
+		st.Offset = ar.df.Offset()
+	}
+	if ns := atomic.LoadInt64(&ar.lastRead); ns > 0 {
+		st.LastRead = time.Unix(0, ns)
+	}
+	ar.rateMux.Lock()
+	rate := ar.rate.update(atomic.LoadInt64(&ar.readBytes), time.Now())
+	ar.rateMux.Unlock()
+	st.ReadRate = int64(rate)
+	st.ETA = CatchUpETA(st.Lag, rate)
+	return st
+}
+
 //除了sync自己的bufreader，还要sync一行linecache
 func (ar *ActiveReader) SyncMeta() string {
 	ar.cacheLineMux.Lock()
@@ -377,6 +620,48 @@ func (ar *ActiveReader) ReadDone() bool {
 	return ar.br.ReadDone()
 }
 
+// finished 软链接切换后旧目标的 ActiveReader 是否已经读到文件末尾，并且读到的数据都已发送
+func (ar *ActiveReader) finished() bool {
+	if atomic.LoadInt32(&ar.retired) == 0 || atomic.LoadInt32(&ar.inactive) == 0 {
+		return false
+	}
+	ar.cacheLineMux.RLock()
+	readcache := ar.readcache
+	ar.cacheLineMux.RUnlock()
+	if readcache != "" {
+		return false
+	}
+	lag, err := ar.Lag()
+	return err == nil && lag.Size <= 0
+}
+
+// syncedToEnd 文件是否已经读到末尾，读到的数据都已发送，并且 meta 中记录的读取位置已经到达文件末尾
+func (ar *ActiveReader) syncedToEnd() bool {
+	ar.cacheLineMux.RLock()
+	readcache := ar.readcache
+	ar.cacheLineMux.RUnlock()
+	if readcache != "" || ar.br.Buffered() > 0 {
+		return false
+	}
+	lag, err := ar.Lag()
+	if err != nil || lag.Size > 0 {
+		return false
+	}
+	// 压缩包解压后读取，没有对应的读取位置，只能按是否读完判断
+	if ar.sf == nil && ar.df == nil {
+		return ar.ReadDone()
+	}
+	_, offset, err := ar.br.Meta.ReadOffset()
+	if err != nil {
+		return false
+	}
+	if ar.df != nil {
+		return ar.df.ReadDone() && offset >= ar.df.Offset()
+	}
+	fi, err := os.Stat(ar.realpath)
+	return err == nil && offset >= fi.Size()
+}
+
 func (ar *ActiveReader) expired(expire time.Duration) bool {
 	// 如果过期时间为 0，则永不过期
 	if expire.Nanoseconds() == 0 {
@@ -406,6 +691,13 @@ func NewReader(meta *reader.Meta, conf conf.MapConf) (reader.Reader, error) {
 	if err != nil {
 		return nil, err
 	}
+	pathTags, err := parsePathPattern(logPathPattern)
+	if err != nil {
+		return nil, err
+	}
+	if pathTags != nil {
+		logPathPattern = pathTags.glob
+	}
 	whence, _ := conf.GetStringOr(KeyWhence, WhenceOldest)
 
 	statIntervalDur, _ := conf.GetStringOr(KeyStatInterval, "3m")
@@ -429,11 +721,95 @@ func NewReader(meta *reader.Meta, conf conf.MapConf) (reader.Reader, error) {
 		return nil, fmt.Errorf("%q valus is less than %q", KeySubmetaExpire, KeyExpire)
 	}
 	expireDelete, _ := conf.GetBoolOr(KeyExpireDelete, false)
+	afterReadAction, _ := conf.GetStringOr(KeyAfterReadAction, AfterReadNone)
+	moveTo, _ := conf.GetStringOr(KeyMoveTo, "")
+	switch afterReadAction {
+	case AfterReadNone, AfterReadDelete:
+	case AfterReadMove:
+		if moveTo == "" {
+			return nil, fmt.Errorf("%q is required when %q is %q", KeyMoveTo, KeyAfterReadAction, AfterReadMove)
+		}
+		if moveTo, err = filepath.Abs(moveTo); err != nil {
+			return nil, err
+		}
+		if err = os.MkdirAll(moveTo, DefaultDirPerm); err != nil {
+			return nil, fmt.Errorf("create %q %s error: %v", KeyMoveTo, moveTo, err)
+		}
+	default:
+		return nil, fmt.Errorf("%q value %q is not supported", KeyAfterReadAction, afterReadAction)
+	}
+	fileEvents, _ := conf.GetBoolOr(KeyFileEvents, false)
+	minQuietTimeDur, _ := conf.GetStringOr(KeyMinQuietTime, "0s")
+	minQuietTime, err := time.ParseDuration(minQuietTimeDur)
+	if err != nil {
+		return nil, err
+	}
+	ignoreOlderThanDur, _ := conf.GetStringOr(KeyIgnoreOlderThan, "0s")
+	ignoreOlderThan, err := time.ParseDuration(ignoreOlderThanDur)
+	if err != nil {
+		return nil, err
+	}
+	minFileSize, _ := conf.GetInt64Or(KeyMinFileSize, 0)
+	maxFileSize, _ := conf.GetInt64Or(KeyMaxFileSize, 0)
+	completionChecksum, _ := conf.GetBoolOr(KeyCompletionChecksum, false)
+	fsType, _ := conf.GetStringOr(KeyFsType, FsTypeLocal)
+	switch fsType {
+	case FsTypeLocal, FsTypeNetwork, FsTypeAuto:
+	default:
+		return nil, fmt.Errorf("%q value %q is not supported", KeyFsType, fsType)
+	}
+	globMaxDepth, _ := conf.GetIntOr(KeyGlobMaxDepth, DefaultGlobMaxDepth)
+	if globMaxDepth < 0 {
+		return nil, fmt.Errorf("%q value %d should not be negative", KeyGlobMaxDepth, globMaxDepth)
+	}
+	framing, err := reader.NewRecordFraming(conf)
+	if err != nil {
+		return nil, err
+	}
+	flushTimeout, err := reader.HeadPatternFlushTimeout(conf)
+	if err != nil {
+		return nil, err
+	}
+	readCompressed, _ := conf.GetBoolOr(KeyReadCompressed, false)
+	statMode, _ := conf.GetStringOr(KeyStatMode, StatModePoll)
+	switch statMode {
+	case StatModePoll, StatModeNotify:
+	default:
+		return nil, fmt.Errorf("%q value %q is not supported", KeyStatMode, statMode)
+	}
+	workers, _ := conf.GetIntOr(KeyTailxWorkers, 0)
+	batchLines, _ := conf.GetIntOr(KeyTailxBatchLines, defaultBatchLines)
+	maxLinesPerFilePerSec, _ := conf.GetIntOr(KeyMaxLinesPerFilePerSec, 0)
+	if batchLines < 0 || maxLinesPerFilePerSec < 0 {
+		return nil, fmt.Errorf("%q and %q should not be negative", KeyTailxBatchLines, KeyMaxLinesPerFilePerSec)
+	}
+	pollIntervalDur, _ := conf.GetStringOr(KeyTailxPollInterval, defaultPollInterval.String())
+	pollInterval, err := time.ParseDuration(pollIntervalDur)
+	if err != nil {
+		return nil, err
+	}
+	maxCacheSize, _ := conf.GetInt64Or(KeyTailxMaxCacheSize, 0)
+	cacheOverflow, _ := conf.GetStringOr(KeyTailxCacheOverflow, TailxCacheOverflowFlush)
+	budget, err := newCacheBudget(maxCacheSize, cacheOverflow)
+	if err != nil {
+		return nil, err
+	}
+	if maxFileSize > 0 && minFileSize > maxFileSize {
+		return nil, fmt.Errorf("%q value %d is greater than %q value %d", KeyMinFileSize, minFileSize, KeyMaxFileSize, maxFileSize)
+	}
 
 	statInterval, err := time.ParseDuration(statIntervalDur)
 	if err != nil {
 		return nil, err
 	}
+	k8s, err := newK8sMeta(meta.RunnerName, conf)
+	if err != nil {
+		return nil, err
+	}
+	coordinator, err := shard.NewCoordinator(meta.RunnerName, conf, logPathPattern)
+	if err != nil {
+		return nil, err
+	}
 	_, _, bufsize, err := meta.ReadBufMeta()
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -486,14 +862,80 @@ func NewReader(meta *reader.Meta, conf conf.MapConf) (reader.Reader, error) {
 		submetaExpire:        submetaExpire,
 		expireDelete:         expireDelete,
 		deleteDirs:           make(chan string, 10),
+		afterReadAction:      afterReadAction,
+		moveTo:               moveTo,
 		statInterval:         statInterval,
 		maxOpenFiles:         maxOpenFiles,
+		fileLinesPerSec:      maxLinesPerFilePerSec,
 		fileReaders:          make(map[string]*ActiveReader), //armapmux
 		cacheMap:             cacheMap,                       //armapmux
 		expireMap:            make(map[string]int64),
+		fileEvents:           fileEvents,
+		minQuietTime:         minQuietTime,
+		ignoreOlderThan:      ignoreOlderThan,
+		minFileSize:          minFileSize,
+		maxFileSize:          maxFileSize,
+		completionChecksum:   completionChecksum,
+		fsType:               fsType,
+		globMaxDepth:         globMaxDepth,
+		statMode:             statMode,
+		framing:              framing,
+		flushTimeout:         flushTimeout,
+		readCompressed:       readCompressed,
+		fileStates:           make(map[string]fileState),
+		symlinks:             make(map[string]string),
+		sched:                newScheduler(meta.RunnerName, workers, batchLines, pollInterval),
+		budget:               budget,
+		shard:                coordinator,
+		k8s:                  k8s,
+		pathTags:             pathTags,
+		rotatedFiles:         make(map[string]rotatedFile),
 	}, nil
 }
 
+// isNetworkFS 判断文件是否在网络文件系统上，网络文件系统上的 inode 不可靠，修改时间的精度也较低
+func (r *Reader) isNetworkFS(path string) bool {
+	switch r.fsType {
+	case FsTypeNetwork:
+		return true
+	case FsTypeAuto:
+		return utilsos.IsNetworkFS(path)
+	}
+	return false
+}
+
+// isFileModified 判断文件在上次扫描之后是否被修改过，网络文件系统的客户端会缓存文件属性，需要放宽判断的时间
+func (r *Reader) isFileModified(path string, now time.Time) bool {
+	interval := r.statInterval
+	if r.isNetworkFS(path) {
+		interval += networkModifiedSlack
+	}
+	return IsFileModified(path, interval, now)
+}
+
+// OpenFiles 返回当前追踪的文件数
+func (r *Reader) OpenFiles() int {
+	r.armapmux.Lock()
+	defer r.armapmux.Unlock()
+	return len(r.fileReaders)
+}
+
+// SetOpenFilesLimit 限制打开的文件数，已经打开的文件不受影响，只是不再追踪新的文件
+func (r *Reader) SetOpenFilesLimit(limit int) {
+	if limit < 0 {
+		limit = 0
+	}
+	atomic.StoreInt32(&r.openFilesLimit, int32(limit))
+}
+
+func (r *Reader) getMaxOpenFiles() int {
+	limit := int(atomic.LoadInt32(&r.openFilesLimit))
+	if limit > 0 && limit < r.maxOpenFiles {
+		return limit
+	}
+	return r.maxOpenFiles
+}
+
 func (r *Reader) isStopping() bool {
 	return atomic.LoadInt32(&r.status) == StatusStopping
 }
@@ -547,22 +989,82 @@ func (r *Reader) sendError(err error) {
 // checkExpiredFiles 函数关闭过期的文件，再更新
 func (r *Reader) checkExpiredFiles() {
 	r.armapmux.Lock()
-	defer r.armapmux.Unlock()
-
-	var paths []string
+	var (
+		paths       []string
+		lostPaths   []string
+		deletePaths []string
+		completions []reader.CompletionRecord
+		readDone    []string // 需要按 after_read_action 处理的文件
+	)
 	for path, ar := range r.fileReaders {
-		if ar.expired(r.expire) || (r.expireDelete && ar.ReadDone()) {
+		// 租约被其他实例接管，不再读取，也不当作读完处理
+		if r.shard != nil && !r.shard.Owned(path) {
+			ar.Close()
+			delete(r.fileReaders, path)
+			delete(r.fileStates, path)
+			lostPaths = append(lostPaths, path)
+			continue
+		}
+		finished := ar.finished()
+		expired := ar.expired(r.expire)
+		if finished || expired || (r.expireDelete && ar.ReadDone()) {
+			_, statErr := os.Stat(ar.realpath)
+			// 需要在关闭之前判断，关闭时会将当前的读取位置写入 meta
+			if r.afterReadAction != AfterReadNone && expired && statErr == nil && ar.syncedToEnd() {
+				readDone = append(readDone, ar.realpath)
+			}
+			if r.fileEvents {
+				event := FileEventExpired
+				if os.IsNotExist(statErr) {
+					event = FileEventDeleted
+				}
+				r.emitFileEvent(event, ar, -1)
+				delete(r.fileStates, path)
+			}
 			ar.Close()
+			if r.completionChecksum && statErr == nil {
+				record := reader.CompletionRecord{Path: ar.realpath}
+				if ar.br != nil && ar.br.Meta != nil {
+					_, record.Offset, _ = ar.br.Meta.ReadOffset()
+				}
+				completions = append(completions, record)
+			}
 			delete(r.fileReaders, path)
 			delete(r.cacheMap, path)
 			r.meta.RemoveSubMeta(path)
+			if r.shard != nil {
+				r.shard.Release(path)
+			}
 			paths = append(paths, path)
-			if r.expireDelete {
-				log.Infof("Runner[%v] %q start to delete expire and read done dir %s", r.meta.RunnerName, r.Name(), path)
-				r.deleteDirs <- path
+			// 软链接切换前的目标可能会在下次发布时被重新使用，不能删除
+			if !finished {
+				deletePaths = append(deletePaths, path)
 			}
 		}
 	}
+	r.armapmux.Unlock()
+
+	// 计算校验值比较耗时，放在锁外进行，并且需要在删除文件之前完成
+	for _, record := range completions {
+		r.recordCompletion(record)
+	}
+	for _, path := range readDone {
+		r.afterRead(path)
+	}
+	if r.expireDelete {
+		for _, path := range deletePaths {
+			log.Infof("Runner[%v] %q start to delete expire and read done dir %s", r.meta.RunnerName, r.Name(), path)
+			r.deleteDirs <- path
+		}
+	}
+	if len(lostPaths) > 0 {
+		if !IsSelfRunner(r.meta.RunnerName) {
+			log.Warnf("Runner[%s] logpath owned by other shard instance: %s", r.meta.RunnerName, strings.Join(lostPaths, ", "))
+		} else {
+			log.Debugf("Runner[%s] logpath owned by other shard instance: %s", r.meta.RunnerName, strings.Join(lostPaths, ", "))
+		}
+	}
+	r.cleanRotatedFiles(r.submetaExpire)
 	if len(paths) > 0 {
 		if !IsSelfRunner(r.meta.RunnerName) {
 			log.Infof("Runner[%s] expired logpath: %s", r.meta.RunnerName, strings.Join(paths, ", "))
@@ -572,17 +1074,216 @@ func (r *Reader) checkExpiredFiles() {
 	}
 }
 
+// afterRead 按 after_read_action 删除或者归档已经读完的文件
+func (r *Reader) afterRead(path string) {
+	var (
+		target string
+		err    error
+	)
+	switch r.afterReadAction {
+	case AfterReadDelete:
+		err = os.Remove(path)
+	case AfterReadMove:
+		target, err = moveFile(path, r.moveTo)
+	default:
+		return
+	}
+	if err != nil {
+		log.Errorf("Runner[%s] %s read done file %s error: %v", r.meta.RunnerName, r.afterReadAction, path, err)
+		return
+	}
+	if target != "" {
+		log.Infof("Runner[%s] read done file %s was moved to %s", r.meta.RunnerName, path, target)
+	} else {
+		log.Infof("Runner[%s] read done file %s was deleted", r.meta.RunnerName, path)
+	}
+}
+
+// moveFile 将文件移动到 dir 目录下，同名文件已经存在时在文件名后追加当前时间，跨设备时复制后删除原文件
+func moveFile(path, dir string) (string, error) {
+	target := filepath.Join(dir, filepath.Base(path))
+	if _, err := os.Lstat(target); err == nil {
+		target += "." + time.Now().Format("20060102150405.000000000")
+	}
+	err := os.Rename(path, target)
+	if err == nil {
+		return target, nil
+	}
+	if cerr := copyFile(path, target); cerr != nil {
+		return "", fmt.Errorf("%v, copy error: %v", err, cerr)
+	}
+	return target, os.Remove(path)
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, DefaultFilePerm)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dst)
+	}
+	return err
+}
+
+// recordCompletion 计算读完文件的校验值并记录到 meta 中
+func (r *Reader) recordCompletion(record reader.CompletionRecord) {
+	checksum, size, err := reader.FileChecksum(record.Path)
+	if err != nil {
+		log.Errorf("Runner[%s] compute checksum of %s failed: %v", r.meta.RunnerName, record.Path, err)
+		return
+	}
+	record.Algorithm = reader.ChecksumSHA256
+	record.Checksum = checksum
+	record.Size = size
+	record.CompletedAt = time.Now()
+	if err = r.meta.AppendCompletion(record); err != nil {
+		log.Errorf("Runner[%s] record completion of %s failed: %v", r.meta.RunnerName, record.Path, err)
+		return
+	}
+	if record.Offset != size {
+		log.Warnf("Runner[%s] %s completed with offset %d, but file size is %d", r.meta.RunnerName, record.Path, record.Offset, size)
+	}
+}
+
+// Completions 返回所有已读完文件的校验记录
+func (r *Reader) Completions() ([]reader.CompletionRecord, error) {
+	return r.meta.ReadCompletions()
+}
+
+// getFileState 获取文件当前的 inode 与大小
+func (r *Reader) getFileState(path string, size int64) fileState {
+	inode, err := utilsos.GetFileIdentityByPath(path, r.isNetworkFS(path))
+	if err != nil {
+		log.Debugf("Runner[%s] get file %s inode failed: %v", r.meta.RunnerName, path, err)
+	}
+	return fileState{inode: inode, size: size}
+}
+
+// checkFileState 对比文件前后两次的状态，inode 变化视为轮转，大小变小视为截断
+func (r *Reader) checkFileState(path string, size int64, ar *ActiveReader) {
+	state := r.getFileState(path, size)
+	r.armapmux.Lock()
+	defer r.armapmux.Unlock()
+	last, ok := r.fileStates[path]
+	r.fileStates[path] = state
+	if !ok {
+		return
+	}
+	switch {
+	case state.inode != last.inode:
+		r.emitFileEvent(FileEventRotated, ar, size)
+	case state.size < last.size:
+		r.emitFileEvent(FileEventTruncated, ar, size)
+	}
+}
+
+// emitFileEvent 记录一条文件生命周期事件，等待 runner 通过 Events 取走，size 小于 0 时表示文件大小未知
+// offset 为该文件已经同步到 meta 中的 offset，即下游已确认收到的位置
+func (r *Reader) emitFileEvent(event string, ar *ActiveReader, size int64) {
+	var offset int64
+	if ar.br != nil && ar.br.Meta != nil {
+		_, offset, _ = ar.br.Meta.ReadOffset()
+	}
+	record := Data{
+		KeyFileEvent:     event,
+		KeyFileEventPath: ar.originpath,
+		KeyFileOffset:    offset,
+		KeyFileEventTime: time.Now().Format(time.RFC3339Nano),
+	}
+	if size >= 0 {
+		record[KeyFileSize] = size
+	}
+	r.eventsMux.Lock()
+	r.events = append(r.events, record)
+	r.eventsMux.Unlock()
+}
+
+// Events 取走尚未发送的文件生命周期事件，事件不会因为下游阻塞而丢弃
+func (r *Reader) Events() []Data {
+	r.eventsMux.Lock()
+	defer r.eventsMux.Unlock()
+	events := r.events
+	r.events = nil
+	return events
+}
+
+// filterFile 根据文件的修改时间和大小判断新发现的文件是否需要忽略，返回忽略的原因，不忽略时返回空字符串
+func (r *Reader) filterFile(fi os.FileInfo, now time.Time) string {
+	if r.ignoreOlderThan > 0 && fi.ModTime().Add(r.ignoreOlderThan).Before(now) {
+		return fmt.Sprintf("is older than %v", r.ignoreOlderThan)
+	}
+	if r.minFileSize > 0 && fi.Size() < r.minFileSize {
+		return fmt.Sprintf("size %d is less than %d", fi.Size(), r.minFileSize)
+	}
+	if r.maxFileSize > 0 && fi.Size() > r.maxFileSize {
+		return fmt.Sprintf("size %d is greater than %d", fi.Size(), r.maxFileSize)
+	}
+	return ""
+}
+
+// updateSymlinkTarget 记录软链接 path 当前指向的目标 target，目标发生变化时返回之前的目标
+func (r *Reader) updateSymlinkTarget(path, target string) string {
+	r.armapmux.Lock()
+	defer r.armapmux.Unlock()
+	last, ok := r.symlinks[path]
+	r.symlinks[path] = target
+	if !ok || last == target {
+		return ""
+	}
+	return last
+}
+
+// retireSymlinkTargets 软链接的目标被原子替换(如蓝绿发布切换日志目录)后，旧目标如果不再被任何路径匹配，
+// 其 ActiveReader 继续读完剩余的内容后由 checkExpiredFiles 关闭，新目标作为新文件从头读取
+func (r *Reader) retireSymlinkTargets(switched map[string]string, matched, links map[string]bool) {
+	r.armapmux.Lock()
+	defer r.armapmux.Unlock()
+	for path := range r.symlinks {
+		if !links[path] {
+			delete(r.symlinks, path)
+		}
+	}
+	for last, link := range switched {
+		if matched[last] {
+			continue
+		}
+		ar, ok := r.fileReaders[last]
+		if !ok {
+			continue
+		}
+		atomic.StoreInt32(&ar.retired, 1)
+		if !IsSelfRunner(r.meta.RunnerName) {
+			log.Infof("Runner[%s] symlink %s target changed from %s to %s, finish reading the old target", r.meta.RunnerName, link, last, r.symlinks[link])
+		} else {
+			log.Debugf("Runner[%s] symlink %s target changed from %s to %s, finish reading the old target", r.meta.RunnerName, link, last, r.symlinks[link])
+		}
+	}
+}
+
 func (r *Reader) statLogPath() {
 	//达到最大打开文件数，不再追踪
-	if len(r.fileReaders) >= r.maxOpenFiles {
+	maxOpenFiles := r.getMaxOpenFiles()
+	if r.OpenFiles() >= maxOpenFiles {
 		if !IsSelfRunner(r.meta.RunnerName) {
-			log.Warnf("Runner[%s] %s meet maxOpenFiles limit %d, ignore Stat new log...", r.meta.RunnerName, r.Name(), r.maxOpenFiles)
+			log.Warnf("Runner[%s] %s meet maxOpenFiles limit %d, ignore Stat new log...", r.meta.RunnerName, r.Name(), maxOpenFiles)
 		} else {
-			log.Debugf("Runner[%s] %s meet maxOpenFiles limit %d, ignore Stat new log...", r.meta.RunnerName, r.Name(), r.maxOpenFiles)
+			log.Debugf("Runner[%s] %s meet maxOpenFiles limit %d, ignore Stat new log...", r.meta.RunnerName, r.Name(), maxOpenFiles)
 		}
 		return
 	}
-	matches, err := filepath.Glob(r.logPathPattern)
+	matches, err := globRecursive(r.logPathPattern, r.globMaxDepth)
 	if err != nil {
 		if !IsSelfRunner(r.meta.RunnerName) {
 			log.Errorf("Runner[%s] stat logPathPattern error %v", r.meta.RunnerName, err)
@@ -596,9 +1297,11 @@ func (r *Reader) statLogPath() {
 		log.Debugf("Runner[%s] statLogPath %s find matches: %s", r.meta.RunnerName, r.logPathPattern, strings.Join(matches, ", "))
 	}
 
+	r.loadFileIDs()
+
 	var unmatchMap = make(map[string]bool)
 	if r.ignoreLogPathPattern != "" {
-		unmatches, err := filepath.Glob(r.ignoreLogPathPattern)
+		unmatches, err := globRecursive(r.ignoreLogPathPattern, r.globMaxDepth)
 		if err != nil {
 			log.Errorf("Runner[%s] stat ignoreLogPathPattern error %v", r.meta.RunnerName, err)
 			r.setStatsError("Runner[" + r.meta.RunnerName + "] stat ignoreLogPathPattern error " + err.Error())
@@ -611,12 +1314,20 @@ func (r *Reader) statLogPath() {
 			log.Debugf("Runner[%s] %d unmatches found after stated ignore log path %q: %v", r.meta.RunnerName, len(unmatches), r.ignoreLogPathPattern, unmatches)
 		}
 	}
-	var newaddsPath []string
+	var (
+		newaddsPath []string
+		matched     = make(map[string]bool) // 本次扫描匹配到的文件的真实路径
+		links       = make(map[string]bool) // 本次扫描匹配到的软链接
+		switched    = make(map[string]string)
+	)
 	now := time.Now()
 	for _, mc := range matches {
 		if unmatchMap[mc] {
 			continue
 		}
+		if r.pathTags != nil && !r.pathTags.match(mc) {
+			continue
+		}
 		rp, fi, err := GetRealPath(mc)
 		if err != nil {
 			if !IsSelfRunner(r.meta.RunnerName) {
@@ -630,16 +1341,32 @@ func (r *Reader) statLogPath() {
 			log.Debugf("Runner[%s] %s is dir, mode[tailx] only support read file, ignore this match...", r.meta.RunnerName, mc)
 			continue
 		}
+		matched[rp] = true
+		if absPath, err := filepath.Abs(mc); err == nil && absPath != rp {
+			links[mc] = true
+			if last := r.updateSymlinkTarget(mc, rp); last != "" {
+				switched[last] = mc
+			}
+		}
 		r.armapmux.Lock()
 		filear, ok := r.fileReaders[rp]
 		r.armapmux.Unlock()
 		if ok {
-			if IsFileModified(rp, r.statInterval, now) {
+			// 软链接切换回了原来的目标，继续读取
+			atomic.StoreInt32(&filear.retired, 0)
+			if r.fileEvents {
+				r.checkFileState(rp, fi.Size(), filear)
+			}
+			if r.isFileModified(rp, now) {
 				filear.Start()
 			}
 			log.Debugf("Runner[%s] <%s> is collecting, ignore...", r.meta.RunnerName, rp)
 			continue
 		}
+		if reason := r.filterFile(fi, now); reason != "" {
+			log.Debugf("Runner[%s] <%s> %s, ignore...", r.meta.RunnerName, mc, reason)
+			continue
+		}
 		r.armapmux.Lock()
 		cacheline := r.cacheMap[rp]
 		r.armapmux.Unlock()
@@ -650,7 +1377,7 @@ func (r *Reader) statLogPath() {
 		if cacheline == "" &&
 			r.expire.Nanoseconds() > 0 && fi.ModTime().Add(r.expire).Before(time.Now()) {
 			if r.whence == WhenceNewest {
-				inode, err := utilsos.GetIdentifyIDByPath(rp)
+				inode, err := utilsos.GetFileIdentityByPath(rp, r.isNetworkFS(rp))
 				if err != nil {
 					log.Errorf("Runner[%s] <%s> update expire map get file: %s inode failed, ignore...", r.meta.RunnerName, rp, err)
 				} else {
@@ -661,9 +1388,28 @@ func (r *Reader) statLogPath() {
 			log.Debugf("Runner[%s] <%s> is expired, ignore...", r.meta.RunnerName, mc)
 			continue
 		}
+		// 新文件可能还在被批处理任务写入，静默足够时间后再读取，下次扫描时再检查
+		if r.minQuietTime > 0 && fi.ModTime().Add(r.minQuietTime).After(now) {
+			log.Debugf("Runner[%s] <%s> was modified within %v, wait for next stat...", r.meta.RunnerName, mc, r.minQuietTime)
+			continue
+		}
+		if r.isFileIDReading(rp) {
+			continue
+		}
+		// 本次扫描新追踪的文件同样受打开文件数的限制
+		if r.OpenFiles() >= maxOpenFiles {
+			log.Debugf("Runner[%s] <%s> meet maxOpenFiles limit %d, wait for next stat...", r.meta.RunnerName, rp, maxOpenFiles)
+			continue
+		}
+		if r.shard != nil && !r.shard.Acquire(rp) {
+			continue
+		}
 
 		ar, err := NewActiveReader(mc, rp, r.whence, inodeStr, r)
 		if err != nil {
+			if r.shard != nil {
+				r.shard.Release(rp)
+			}
 			err = fmt.Errorf("Runner[%s] NewActiveReader for matches %s error %v ", r.meta.RunnerName, rp, err)
 			r.sendError(err)
 			if !IsSelfRunner(r.meta.RunnerName) {
@@ -673,7 +1419,10 @@ func (r *Reader) statLogPath() {
 			}
 			continue
 		}
-		ar.readcache = cacheline
+		if ar.readcache == "" {
+			ar.readcache = cacheline
+		}
+		ar.account()
 		if r.headRegexp != nil {
 			err = ar.br.SetMode(ReadModeHeadPatternRegexp, r.headRegexp)
 			if err != nil {
@@ -711,6 +1460,10 @@ func (r *Reader) statLogPath() {
 				}
 			}
 			r.fileReaders[rp] = ar
+			if r.fileEvents {
+				r.fileStates[rp] = r.getFileState(rp, fi.Size())
+				r.emitFileEvent(FileEventDiscovered, ar, fi.Size())
+			}
 		} else {
 			if !IsSelfRunner(r.meta.RunnerName) {
 				log.Warnf("Runner[%v] %v NewActiveReader but reader was stopped, ignore this...", r.meta.RunnerName, mc)
@@ -730,6 +1483,11 @@ func (r *Reader) statLogPath() {
 		}
 	}
 
+	r.retireSymlinkTargets(switched, matched, links)
+	if r.shard != nil {
+		r.shard.Forget(matched)
+	}
+
 	if !r.notFirstTime {
 		r.notFirstTime = true
 	}
@@ -754,15 +1512,22 @@ func (r *Reader) Start() error {
 		return nil
 	}
 
-	go func() {
+	r.sched.start(r.runTime, r.meta)
+	r.startNotify()
+	if r.k8s != nil {
+		r.meta.Go("tailx k8s", func() { r.k8s.run(r.stopChan) })
+	}
+	r.meta.Go("tailx stat", func() {
 		ticker := time.NewTicker(r.statInterval)
 		defer ticker.Stop()
+		trigger := r.notifyTrigger()
 		for {
 			now := time.Now()
 			if reader.InRunTime(now.Hour(), now.Minute(), r.runTime) {
 				r.checkExpiredFiles()
 				utils.CheckNotExistFile(r.meta.RunnerName, r.expireMap)
 				r.statLogPath()
+				r.refreshWatches()
 			}
 
 			select {
@@ -775,12 +1540,13 @@ func (r *Reader) Start() error {
 				}
 				return
 			case <-ticker.C:
+			case <-trigger:
 			}
 		}
-	}()
+	})
 
 	if r.expireDelete {
-		go func() {
+		r.meta.Go("tailx expire delete", func() {
 			for {
 				select {
 				case <-r.stopChan:
@@ -794,11 +1560,11 @@ func (r *Reader) Start() error {
 					}
 				}
 			}
-		}()
+		})
 	}
 
 	if IsSubMetaExpire(r.submetaExpire, r.expire) {
-		go func() {
+		r.meta.Go("tailx submeta expire", func() {
 			ticker := time.NewTicker(time.Hour)
 			defer ticker.Stop()
 			for {
@@ -810,7 +1576,7 @@ func (r *Reader) Start() error {
 				case <-ticker.C:
 				}
 			}
-		}()
+		})
 	}
 
 	if !IsSelfRunner(r.meta.RunnerName) {
@@ -835,6 +1601,30 @@ func (r *Reader) Source() string {
 	return r.currentFile
 }
 
+func (r *Reader) SourceTagsEnabled() bool {
+	return r.k8s != nil || r.pathTags != nil
+}
+
+// SourceTags 返回容器日志文件对应的 k8s 元数据以及文件路径中命名捕获组的值，同名时以 k8s 元数据为准
+func (r *Reader) SourceTags(source string) map[string]interface{} {
+	var tags map[string]interface{}
+	if r.k8s != nil {
+		tags = r.k8s.tags(source)
+	}
+	if r.pathTags == nil {
+		return tags
+	}
+	if tags == nil {
+		return r.pathTags.tags(source)
+	}
+	for k, v := range r.pathTags.tags(source) {
+		if _, ok := tags[k]; !ok {
+			tags[k] = v
+		}
+	}
+	return tags
+}
+
 // Note: 对 currentFile 的操作非线程安全，需由上层逻辑保证同步调用 ReadLine
 func (r *Reader) ReadLine() (string, error) {
 	timer := time.NewTimer(time.Second)
@@ -887,18 +1677,44 @@ func (r *Reader) Lag() (*LagInfo, error) {
 	return lagInfo, err
 }
 
+// DetailStatus 返回正在读取的每个文件的读取进度，按文件路径排序
+func (r *Reader) DetailStatus() []FileStatus {
+	ars := r.getActiveReaders()
+	files := make([]FileStatus, 0, len(ars))
+	for _, ar := range ars {
+		files = append(files, ar.detailStatus())
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+	return files
+}
+
 // SyncMeta 从队列取数据时同步队列，作用在于保证数据不重复
 func (r *Reader) SyncMeta() {
 	ars := r.getActiveReaders()
 	for _, ar := range ars {
-		readcache := ar.SyncMeta()
-		if readcache == "" {
-			continue
-		}
-		r.armapmux.Lock()
-		r.cacheMap[ar.realpath] = readcache
-		r.armapmux.Unlock()
+		r.updateCacheMap(ar.realpath, ar.SyncMeta())
+	}
+	r.writeCacheMap()
+
+	if IsSubMetaExpire(r.submetaExpire, r.expire) {
+		r.meta.CleanExpiredSubMetas(r.submetaExpire)
 	}
+}
+
+// updateCacheMap 记录 ActiveReader 还未发送的数据，已经发送完的清除记录，避免重启后重复发送
+func (r *Reader) updateCacheMap(path, readcache string) {
+	r.armapmux.Lock()
+	defer r.armapmux.Unlock()
+	if readcache == "" {
+		delete(r.cacheMap, path)
+		return
+	}
+	r.cacheMap[path] = readcache
+}
+
+func (r *Reader) writeCacheMap() {
 	r.armapmux.Lock()
 	buf, err := jsoniter.Marshal(r.cacheMap)
 	r.armapmux.Unlock()
@@ -919,10 +1735,6 @@ func (r *Reader) SyncMeta() {
 		}
 		return
 	}
-
-	if IsSubMetaExpire(r.submetaExpire, r.expire) {
-		r.meta.CleanExpiredSubMetas(r.submetaExpire)
-	}
 }
 
 func (r *Reader) Close() error {
@@ -957,6 +1769,20 @@ func (r *Reader) Close() error {
 		}(ar)
 	}
 	wg.Wait()
+	r.sched.stop()
+	// ActiveReader 关闭前可能又读出了新的数据，重新记录一次，与 submeta 中的记录保持一致
+	for _, ar := range ars {
+		ar.cacheLineMux.RLock()
+		readcache := ar.readcache
+		ar.cacheLineMux.RUnlock()
+		r.updateCacheMap(ar.realpath, readcache)
+	}
+	r.writeCacheMap()
+	if r.shard != nil {
+		if err := r.shard.Close(); err != nil {
+			log.Errorf("Runner[%s] close shard coordinator error %v", r.meta.RunnerName, err)
+		}
+	}
 
 	// 在所有 active readers 关闭完成后再关闭管道
 	close(r.msgChan)
@@ -1,14 +1,19 @@
 package tailx
 
 import (
+	"bytes"
 	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -1445,3 +1450,1033 @@ func TestReaderErrMiddle(t *testing.T) {
 	err = mr.Close()
 	assert.Nil(t, err)
 }
+
+func TestFileEvents(t *testing.T) {
+	t.Parallel()
+	dirName := "TestFileEvents"
+	metaDir := filepath.Join(dirName, "meta")
+	file1 := filepath.Join(dirName, "file1.log")
+
+	createDirWithName(dirName)
+	defer os.RemoveAll(dirName)
+	createFileWithContent(file1, "abc111\nabc112\n")
+
+	c := conf.MapConf{
+		"log_path":      filepath.Join(dirName, "*.log"),
+		"meta_path":     metaDir,
+		"mode":          ModeTailx,
+		"read_from":     "oldest",
+		"stat_interval": "1h",
+		"file_events":   "true",
+	}
+	meta, err := reader.NewMetaWithConf(c)
+	assert.NoError(t, err)
+	mmr, err := NewReader(meta, c)
+	assert.NoError(t, err)
+	mr := mmr.(*Reader)
+	assert.True(t, mr.fileEvents)
+
+	getEvent := func() Data {
+		events := mr.Events()
+		if len(events) != 1 {
+			t.Fatalf("expect 1 file event, got %v", events)
+		}
+		return events[0]
+	}
+
+	mr.statLogPath()
+	event := getEvent()
+	assert.Equal(t, FileEventDiscovered, event[KeyFileEvent])
+	assert.Equal(t, file1, event[KeyFileEventPath])
+	assert.EqualValues(t, 14, event[KeyFileSize])
+
+	// 截断
+	assert.NoError(t, os.Truncate(file1, 0))
+	mr.statLogPath()
+	event = getEvent()
+	assert.Equal(t, FileEventTruncated, event[KeyFileEvent])
+
+	// 轮转
+	assert.NoError(t, os.Rename(file1, file1+".1"))
+	createFileWithContent(file1, "abc113\n")
+	mr.statLogPath()
+	event = getEvent()
+	assert.Equal(t, FileEventRotated, event[KeyFileEvent])
+
+	// 删除
+	assert.NoError(t, os.Remove(file1))
+	mr.expire = time.Nanosecond
+	mr.checkExpiredFiles()
+	event = getEvent()
+	assert.Equal(t, FileEventDeleted, event[KeyFileEvent])
+	assert.Equal(t, 0, len(mr.fileStates))
+}
+
+func TestMinQuietTime(t *testing.T) {
+	t.Parallel()
+	dirName := "TestMinQuietTime"
+	metaDir := filepath.Join(dirName, "meta")
+	file1 := filepath.Join(dirName, "file1.log")
+
+	createDirWithName(dirName)
+	defer os.RemoveAll(dirName)
+	createFileWithContent(file1, "abc111\nabc112\n")
+
+	c := conf.MapConf{
+		"log_path":       filepath.Join(dirName, "*.log"),
+		"meta_path":      metaDir,
+		"mode":           ModeTailx,
+		"read_from":      "oldest",
+		"stat_interval":  "1h",
+		"min_quiet_time": "1h",
+	}
+	meta, err := reader.NewMetaWithConf(c)
+	assert.NoError(t, err)
+	mmr, err := NewReader(meta, c)
+	assert.NoError(t, err)
+	mr := mmr.(*Reader)
+	assert.Equal(t, time.Hour, mr.minQuietTime)
+
+	// 文件刚刚被修改过，不开始读取
+	mr.statLogPath()
+	assert.Equal(t, 0, len(mr.fileReaders))
+
+	// 文件静默足够时间后开始读取
+	old := time.Now().Add(-2 * time.Hour)
+	assert.NoError(t, os.Chtimes(file1, old, old))
+	mr.statLogPath()
+	assert.Equal(t, 1, len(mr.fileReaders))
+	assert.NoError(t, mr.Close())
+
+	c["min_quiet_time"] = "abc"
+	_, err = NewReader(meta, c)
+	assert.Error(t, err)
+}
+
+func TestFilterFile(t *testing.T) {
+	t.Parallel()
+	dirName := "TestFilterFile"
+	metaDir := filepath.Join(dirName, "meta")
+	empty := filepath.Join(dirName, "empty.log")
+	old := filepath.Join(dirName, "old.log")
+	large := filepath.Join(dirName, "large.log")
+	normal := filepath.Join(dirName, "normal.log")
+
+	createDirWithName(dirName)
+	defer os.RemoveAll(dirName)
+	createFileWithContent(empty, "")
+	createFileWithContent(old, "abc111\n")
+	createFileWithContent(large, "abc111\nabc112\nabc113\nabc114\n")
+	createFileWithContent(normal, "abc111\n")
+	oldTime := time.Now().Add(-8 * 24 * time.Hour)
+	assert.NoError(t, os.Chtimes(old, oldTime, oldTime))
+
+	c := conf.MapConf{
+		"log_path":          filepath.Join(dirName, "*.log"),
+		"meta_path":         metaDir,
+		"mode":              ModeTailx,
+		"read_from":         "oldest",
+		"stat_interval":     "1h",
+		"expire":            "0s",
+		"ignore_older_than": "168h",
+		"min_size":          "1",
+		"max_size":          "20",
+	}
+	meta, err := reader.NewMetaWithConf(c)
+	assert.NoError(t, err)
+	mmr, err := NewReader(meta, c)
+	assert.NoError(t, err)
+	mr := mmr.(*Reader)
+
+	mr.statLogPath()
+	assert.Equal(t, 1, len(mr.fileReaders))
+	absNormal, err := filepath.Abs(normal)
+	assert.NoError(t, err)
+	_, ok := mr.fileReaders[absNormal]
+	assert.True(t, ok)
+
+	// 占位文件写入数据后开始读取
+	createFileWithContent(empty, "abc111\n")
+	mr.statLogPath()
+	assert.Equal(t, 2, len(mr.fileReaders))
+	assert.NoError(t, mr.Close())
+
+	c["min_size"] = "30"
+	_, err = NewReader(meta, c)
+	assert.Error(t, err)
+}
+
+func TestCompletionChecksum(t *testing.T) {
+	t.Parallel()
+	dirName := "TestCompletionChecksum"
+	metaDir := filepath.Join(dirName, "meta")
+	file1 := filepath.Join(dirName, "file1.log")
+
+	createDirWithName(dirName)
+	defer os.RemoveAll(dirName)
+	createFileWithContent(file1, "abc111\nabc112\n")
+
+	c := conf.MapConf{
+		"log_path":            filepath.Join(dirName, "*.log"),
+		"meta_path":           metaDir,
+		"mode":                ModeTailx,
+		"read_from":           "oldest",
+		"stat_interval":       "1h",
+		"completion_checksum": "true",
+	}
+	meta, err := reader.NewMetaWithConf(c)
+	assert.NoError(t, err)
+	mmr, err := NewReader(meta, c)
+	assert.NoError(t, err)
+	mr := mmr.(*Reader)
+
+	mr.statLogPath()
+	assert.Equal(t, 1, len(mr.fileReaders))
+	records, err := mr.Completions()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(records))
+
+	mr.expire = time.Nanosecond
+	for _, ar := range mr.fileReaders {
+		atomic.StoreInt32(&ar.inactive, 1)
+	}
+	mr.checkExpiredFiles()
+	assert.Equal(t, 0, len(mr.fileReaders))
+
+	records, err = mr.Completions()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(records))
+	absFile1, err := filepath.Abs(file1)
+	assert.NoError(t, err)
+	sum := sha256.Sum256([]byte("abc111\nabc112\n"))
+	assert.Equal(t, absFile1, records[0].Path)
+	assert.EqualValues(t, 14, records[0].Size)
+	assert.Equal(t, reader.ChecksumSHA256, records[0].Algorithm)
+	assert.Equal(t, hex.EncodeToString(sum[:]), records[0].Checksum)
+}
+
+func TestSchedulerWorkerPool(t *testing.T) {
+	t.Parallel()
+	dirName := "TestSchedulerWorkerPool"
+	metaDir := filepath.Join(dirName, "meta")
+	createDirWithName(dirName)
+	defer os.RemoveAll(dirName)
+	files := 50
+	for i := 0; i < files; i++ {
+		createFileWithContent(filepath.Join(dirName, "file"+strconv.Itoa(i)+".log"), "abc"+strconv.Itoa(i)+"\n")
+	}
+
+	c := conf.MapConf{
+		"log_path":            filepath.Join(dirName, "*.log"),
+		"meta_path":           metaDir,
+		"mode":                ModeTailx,
+		"read_from":           "oldest",
+		"stat_interval":       "1h",
+		"tailx_workers":       "2",
+		"tailx_poll_interval": "50ms",
+	}
+	meta, err := reader.NewMetaWithConf(c)
+	assert.NoError(t, err)
+	mmr, err := NewReader(meta, c)
+	assert.NoError(t, err)
+	mr := mmr.(*Reader)
+	assert.Equal(t, 2, mr.sched.workers)
+	assert.NoError(t, mr.Start())
+
+	readLines := func(n int) map[string]bool {
+		lines := make(map[string]bool)
+		for i := 0; i < 100 && len(lines) < n; i++ {
+			line, err := mr.ReadLine()
+			assert.NoError(t, err)
+			if line != "" {
+				lines[line] = true
+			}
+		}
+		return lines
+	}
+	assert.Len(t, readLines(files), files)
+	assert.Equal(t, files, len(mr.getActiveReaders()))
+
+	// 所有文件读完后进入空闲状态，文件变化后由 poller 唤醒继续读取
+	for i := 0; i < 100; i++ {
+		mr.sched.mutex.Lock()
+		idle := len(mr.sched.idle)
+		mr.sched.mutex.Unlock()
+		if idle == files {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	mr.sched.mutex.Lock()
+	assert.Len(t, mr.sched.idle, files)
+	mr.sched.mutex.Unlock()
+	appendFileWithContent(filepath.Join(dirName, "file7.log"), "abc_new\n")
+	assert.Equal(t, map[string]bool{"abc_new\n": true}, readLines(1))
+	assert.NoError(t, mr.Close())
+}
+
+func TestCacheBudget(t *testing.T) {
+	t.Parallel()
+	_, err := newCacheBudget(10, "drop")
+	assert.Error(t, err)
+	budget, err := newCacheBudget(0, TailxCacheOverflowFlush)
+	assert.NoError(t, err)
+	assert.Nil(t, budget)
+
+	dirName := "TestCacheBudget"
+	createDirWithName(dirName)
+	defer os.RemoveAll(dirName)
+	meta, err := reader.NewMeta(filepath.Join(dirName, "meta"), filepath.Join(dirName, "meta"), dirName, ModeTailx, "", reader.DefautFileRetention)
+	assert.NoError(t, err)
+	newActiveReader := func(name, content string, budget *cacheBudget) (*ActiveReader, *Reader) {
+		path, err := filepath.Abs(filepath.Join(dirName, name))
+		assert.NoError(t, err)
+		createFileWithContent(path, content)
+		r := &Reader{
+			msgChan: make(chan Result, 10),
+			errChan: make(chan error, 10),
+			meta:    meta,
+			budget:  budget,
+		}
+		ar, err := NewActiveReader(path, path, WhenceOldest, "", r)
+		assert.NoError(t, err)
+		atomic.StoreInt32(&ar.status, StatusRunning)
+		return ar, r
+	}
+
+	// truncate 时超出上限的日志被截断，不会截断在多字节字符的中间
+	budget, err = newCacheBudget(10, TailxCacheOverflowTruncate)
+	assert.NoError(t, err)
+	ar, r := newActiveReader("truncate.log", "中中中中\nabc\n", budget)
+	assert.Equal(t, arReady, ar.process(2))
+	assert.Equal(t, "中中中", (<-r.msgChan).result)
+	assert.Equal(t, "abc\n", (<-r.msgChan).result)
+	stats := r.CacheStats()
+	assert.Equal(t, int64(1), stats.Truncated)
+	assert.Equal(t, int64(4), stats.TruncatedBytes)
+	assert.Equal(t, int64(9), stats.Peak)
+	assert.Equal(t, int64(0), stats.Used)
+	assert.Equal(t, int64(1), stats.Readers)
+	ar.Close()
+	assert.Equal(t, int64(0), r.CacheStats().Readers)
+
+	// flush 时先发送不完整的多行日志，没有可以发送的缓存时暂停读取
+	budget, err = newCacheBudget(4, TailxCacheOverflowFlush)
+	assert.NoError(t, err)
+	ar, r = newActiveReader("flush.log", "abc1\nx\nx\nabc2\n", budget)
+	assert.NoError(t, ar.br.SetMode(ReadModeHeadPatternString, "^abc"))
+	assert.Equal(t, arReady, ar.process(1))
+	assert.Equal(t, "abc1\nx\nx\n", (<-r.msgChan).result)
+	assert.Equal(t, int64(5), r.CacheStats().Used)
+	assert.Equal(t, arReady, ar.process(1))
+	assert.Equal(t, "abc2\n", (<-r.msgChan).result)
+	assert.Equal(t, int64(1), r.CacheStats().Flushed)
+	assert.Equal(t, int64(0), r.CacheStats().Used)
+
+	budget.add(100)
+	assert.Equal(t, arRetry, ar.process(1))
+	assert.Equal(t, int64(1), r.CacheStats().Paused)
+	budget.add(-100)
+	assert.Equal(t, arIdle, ar.process(1))
+	ar.Close()
+	assert.Equal(t, CacheStats{Limit: 4, Policy: TailxCacheOverflowFlush, Peak: 100, Flushed: 1, Paused: 1}, *r.CacheStats())
+}
+
+func TestSymlinkTargetChanged(t *testing.T) {
+	t.Parallel()
+	dirName := "TestSymlinkTargetChanged"
+	metaDir := filepath.Join(dirName, "meta")
+	blue := filepath.Join(dirName, "blue")
+	green := filepath.Join(dirName, "green")
+	farm := filepath.Join(dirName, "farm")
+	createDirWithName(dirName)
+	defer os.RemoveAll(dirName)
+	for _, dir := range []string{blue, green, farm} {
+		createDirWithName(dir)
+	}
+	createFileWithContent(filepath.Join(blue, "app.log"), "blue1\n")
+	createFileWithContent(filepath.Join(green, "app.log"), "green1\n")
+	link := filepath.Join(farm, "app.log")
+	// 工作目录本身可能在软链接下，使用解析后的路径
+	absBlue, err := filepath.Abs(filepath.Join(blue, "app.log"))
+	assert.NoError(t, err)
+	absBlue, err = filepath.EvalSymlinks(absBlue)
+	assert.NoError(t, err)
+	absGreen, err := filepath.Abs(filepath.Join(green, "app.log"))
+	assert.NoError(t, err)
+	absGreen, err = filepath.EvalSymlinks(absGreen)
+	assert.NoError(t, err)
+	// 原子替换软链接的目标
+	switchLink := func(target string) {
+		tmp := link + ".tmp"
+		assert.NoError(t, os.Symlink(target, tmp))
+		assert.NoError(t, os.Rename(tmp, link))
+	}
+	switchLink(absBlue)
+
+	c := conf.MapConf{
+		"log_path":            filepath.Join(farm, "*.log"),
+		"meta_path":           metaDir,
+		"mode":                ModeTailx,
+		"read_from":           "oldest",
+		"stat_interval":       "1h",
+		"tailx_poll_interval": "50ms",
+		"expire_delete":       "true",
+	}
+	meta, err := reader.NewMetaWithConf(c)
+	assert.NoError(t, err)
+	mmr, err := NewReader(meta, c)
+	assert.NoError(t, err)
+	mr := mmr.(*Reader)
+	mr.sched.start(mr.runTime, mr.meta)
+	defer mr.sched.stop()
+
+	readLines := func(n int) []string {
+		var lines []string
+		for i := 0; i < 10 && len(lines) < n; i++ {
+			line, err := mr.ReadLine()
+			assert.NoError(t, err)
+			if line != "" {
+				lines = append(lines, line)
+			}
+		}
+		return lines
+	}
+
+	mr.statLogPath()
+	assert.Equal(t, []string{"blue1\n"}, readLines(1))
+	oldReader := mr.fileReaders[absBlue]
+	assert.NotNil(t, oldReader)
+
+	// 切换到 green 后旧目标还有数据没读完
+	switchLink(absGreen)
+	appendFileWithContent(filepath.Join(blue, "app.log"), "blue2\n")
+	mr.statLogPath()
+	assert.Len(t, mr.getActiveReaders(), 2)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&oldReader.retired))
+	assert.Equal(t, absGreen, mr.symlinks[link])
+	oldReader.Start()
+	lines := readLines(2)
+	assert.Len(t, lines, 2)
+	assert.Contains(t, lines, "blue2\n")
+	assert.Contains(t, lines, "green1\n")
+
+	// 旧目标读完后关闭，但不删除
+	for i := 0; i < 100 && !oldReader.finished(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	mr.checkExpiredFiles()
+	assert.Len(t, mr.getActiveReaders(), 1)
+	_, ok := mr.fileReaders[absGreen]
+	assert.True(t, ok)
+	_, err = os.Stat(absBlue)
+	assert.NoError(t, err)
+
+	// 切换回 blue，从记录的 offset 继续读取，不重复读
+	switchLink(absBlue)
+	mr.statLogPath()
+	assert.Len(t, mr.getActiveReaders(), 2)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&mr.fileReaders[absGreen].retired))
+	appendFileWithContent(absBlue, "blue3\n")
+	mr.fileReaders[absBlue].Start()
+	assert.Equal(t, []string{"blue3\n"}, readLines(1))
+
+	// 切换后立即切回，正在读取的目标不关闭
+	switchLink(absGreen)
+	mr.statLogPath()
+	assert.EqualValues(t, 1, atomic.LoadInt32(&mr.fileReaders[absBlue].retired))
+	switchLink(absBlue)
+	mr.statLogPath()
+	assert.EqualValues(t, 0, atomic.LoadInt32(&mr.fileReaders[absBlue].retired))
+}
+
+func TestPersistPendingOnClose(t *testing.T) {
+	t.Parallel()
+	dirName := "TestPersistPendingOnClose"
+	createDirWithName(dirName)
+	defer os.RemoveAll(dirName)
+	meta, err := reader.NewMeta(filepath.Join(dirName, "meta"), filepath.Join(dirName, "meta"), dirName, ModeTailx, "", reader.DefautFileRetention)
+	assert.NoError(t, err)
+	path, err := filepath.Abs(filepath.Join(dirName, "pending.log"))
+	assert.NoError(t, err)
+	createFileWithContent(path, "abc1\nx\nabc2\nx\n")
+	r := &Reader{
+		msgChan: make(chan Result, 10),
+		errChan: make(chan error, 10),
+		meta:    meta,
+	}
+	newActiveReader := func() *ActiveReader {
+		ar, err := NewActiveReader(path, path, WhenceOldest, "", r)
+		assert.NoError(t, err)
+		assert.NoError(t, ar.br.SetMode(ReadModeHeadPatternString, "^abc"))
+		atomic.StoreInt32(&ar.status, StatusRunning)
+		return ar
+	}
+
+	// 关闭时 readcache 中还未发送的数据和不完整的多行日志都记录在 submeta 中
+	ar := newActiveReader()
+	ar.readcache, err = ar.br.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "abc1\nx\n", ar.readcache)
+	assert.Equal(t, "abc2\n", string(ar.br.FormMutiLine()))
+	assert.NoError(t, ar.Close())
+	pending, err := ar.br.Meta.ReadPendingLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "abc1\nx\n", pending)
+
+	// 重新打开时恢复，并删除 submeta 中的记录
+	ar = newActiveReader()
+	assert.Equal(t, "abc1\nx\n", ar.readcache)
+	_, err = os.Stat(ar.br.Meta.PendingLineFile())
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, "abc2\n", string(ar.br.FormMutiLine()))
+	assert.Equal(t, arIdle, ar.process(10))
+	assert.Equal(t, "abc1\nx\n", (<-r.msgChan).result)
+	assert.Equal(t, "abc2\nx\n", (<-r.msgChan).result)
+	assert.NoError(t, ar.Close())
+}
+
+func TestRotateByRename(t *testing.T) {
+	t.Parallel()
+	dirName := "TestRotateByRename"
+	createDirWithName(dirName)
+	defer os.RemoveAll(dirName)
+	logPath, err := filepath.Abs(filepath.Join(dirName, "app.log"))
+	assert.NoError(t, err)
+	logPath, err = filepath.EvalSymlinks(filepath.Dir(logPath))
+	assert.NoError(t, err)
+	logPath = filepath.Join(logPath, "app.log")
+	createFileWithContent(logPath, "a1\na2\n")
+
+	c := conf.MapConf{
+		"log_path":            filepath.Join(dirName, "app.log*"),
+		"meta_path":           filepath.Join(dirName, "meta"),
+		"mode":                ModeTailx,
+		"read_from":           "oldest",
+		"stat_interval":       "1h",
+		"tailx_poll_interval": "50ms",
+	}
+	newReader := func() *Reader {
+		meta, err := reader.NewMetaWithConf(c)
+		assert.NoError(t, err)
+		mmr, err := NewReader(meta, c)
+		assert.NoError(t, err)
+		mr := mmr.(*Reader)
+		mr.sched.start(mr.runTime, mr.meta)
+		return mr
+	}
+	readLines := func(mr *Reader, n int) []string {
+		var lines []string
+		for i := 0; i < 10 && len(lines) < n; i++ {
+			line, err := mr.ReadLine()
+			assert.NoError(t, err)
+			if line != "" {
+				lines = append(lines, line)
+			}
+		}
+		return lines
+	}
+
+	mr := newReader()
+	mr.statLogPath()
+	assert.Equal(t, []string{"a1\n", "a2\n"}, readLines(mr, 2))
+
+	// 运行中被改名轮转，原路径读完轮转前的文件后读取新文件，改名后的文件从轮转时的 offset 继续读取
+	appendFileWithContent(logPath, "a3\n")
+	assert.NoError(t, os.Rename(logPath, logPath+".1"))
+	createFileWithContent(logPath, "b1\n")
+	mr.statLogPath()
+	lines := readLines(mr, 2)
+	assert.Equal(t, []string{"a3\n", "b1\n"}, lines)
+	appendFileWithContent(logPath+".1", "a4\n")
+	mr.statLogPath()
+	assert.Len(t, mr.getActiveReaders(), 2)
+	assert.Equal(t, []string{"a4\n"}, readLines(mr, 1))
+	mr.SyncMeta()
+	assert.NoError(t, mr.Close())
+
+	// 停止期间被改名轮转，重启后改名后的文件从记录的 offset 继续读取，新文件从头读取
+	appendFileWithContent(logPath, "b2\n")
+	assert.NoError(t, os.Rename(logPath, logPath+".2"))
+	createFileWithContent(logPath, "c1\n")
+	mr = newReader()
+	mr.statLogPath()
+	lines = readLines(mr, 2)
+	assert.Len(t, lines, 2)
+	assert.Contains(t, lines, "b2\n")
+	assert.Contains(t, lines, "c1\n")
+	line, err := mr.ReadLine()
+	assert.NoError(t, err)
+	assert.Empty(t, line)
+	assert.NoError(t, mr.Close())
+}
+
+func TestGlobRecursive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestGlobRecursive")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	files := []string{"a.log", "x/b.log", "x/y/c.log", "x/y/z/d.log", "x/y/z/e.txt"}
+	for _, f := range files {
+		path := filepath.Join(dir, filepath.FromSlash(f))
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), DefaultDirPerm))
+		createFileWithContent(path, "abc\n")
+	}
+	join := func(fs ...string) []string {
+		var ret []string
+		for _, f := range fs {
+			ret = append(ret, filepath.Join(dir, filepath.FromSlash(f)))
+		}
+		return ret
+	}
+
+	matches, err := globRecursive(filepath.Join(dir, "**", "*.log"), DefaultGlobMaxDepth)
+	assert.NoError(t, err)
+	assert.Equal(t, join("a.log", "x/b.log", "x/y/c.log", "x/y/z/d.log"), matches)
+
+	// 最多向下匹配两层目录
+	matches, err = globRecursive(filepath.Join(dir, "**", "*.log"), 2)
+	assert.NoError(t, err)
+	assert.Equal(t, join("a.log", "x/b.log", "x/y/c.log"), matches)
+
+	matches, err = globRecursive(filepath.Join(dir, "x", "**", "z", "*"), DefaultGlobMaxDepth)
+	assert.NoError(t, err)
+	assert.Equal(t, join("x/y/z/d.log", "x/y/z/e.txt"), matches)
+
+	matches, err = globRecursive(filepath.Join(dir, "*", "**", "c.log"), DefaultGlobMaxDepth)
+	assert.NoError(t, err)
+	assert.Equal(t, join("x/y/c.log"), matches)
+
+	// 没有 ** 时与 filepath.Glob 一致
+	matches, err = globRecursive(filepath.Join(dir, "*", "*.log"), DefaultGlobMaxDepth)
+	assert.NoError(t, err)
+	assert.Equal(t, join("x/b.log"), matches)
+
+	_, err = globRecursive(filepath.Join(dir, "**", "[.log"), DefaultGlobMaxDepth)
+	assert.Error(t, err)
+}
+
+func TestOpenFilesLimit(t *testing.T) {
+	t.Parallel()
+	dirName := "TestOpenFilesLimit"
+	createDirWithName(dirName)
+	defer os.RemoveAll(dirName)
+	for _, name := range []string{"a.log", "b.log", "c.log"} {
+		createFileWithContent(filepath.Join(dirName, name), "abc\n")
+	}
+	c := conf.MapConf{
+		"log_path":       filepath.Join(dirName, "*.log"),
+		"meta_path":      filepath.Join(dirName, "meta"),
+		"mode":           ModeTailx,
+		"read_from":      "oldest",
+		"stat_interval":  "1h",
+		"max_open_files": "2",
+	}
+	meta, err := reader.NewMetaWithConf(c)
+	assert.NoError(t, err)
+	mmr, err := NewReader(meta, c)
+	assert.NoError(t, err)
+	mr := mmr.(*Reader)
+	defer mr.Close()
+
+	mr.SetOpenFilesLimit(1)
+	mr.statLogPath()
+	assert.Equal(t, 1, mr.OpenFiles())
+	// 大于 max_open_files 时仍然受 max_open_files 限制
+	mr.SetOpenFilesLimit(10)
+	mr.statLogPath()
+	assert.Equal(t, 2, mr.OpenFiles())
+}
+
+func TestStatModeNotify(t *testing.T) {
+	t.Parallel()
+	dirName := "TestStatModeNotify"
+	createDirWithName(dirName)
+	defer os.RemoveAll(dirName)
+	c := conf.MapConf{
+		"log_path":      filepath.Join(dirName, "logs", "*", "*.log"),
+		"meta_path":     filepath.Join(dirName, "meta"),
+		"mode":          ModeTailx,
+		"read_from":     "oldest",
+		"stat_interval": "1h",
+		"stat_mode":     StatModeNotify,
+	}
+	meta, err := reader.NewMetaWithConf(c)
+	assert.NoError(t, err)
+	mmr, err := NewReader(meta, c)
+	assert.NoError(t, err)
+	mr := mmr.(*Reader)
+	defer mr.Close()
+	assert.NoError(t, mr.Start())
+	assert.NotNil(t, mr.notify)
+	time.Sleep(200 * time.Millisecond)
+
+	// 日志目录和日志文件都在启动之后创建，不需要等待下一次定时扫描
+	createDirWithName(filepath.Join(dirName, "logs"))
+	time.Sleep(500 * time.Millisecond)
+	createDirWithName(filepath.Join(dirName, "logs", "app"))
+	time.Sleep(500 * time.Millisecond)
+	createFileWithContent(filepath.Join(dirName, "logs", "app", "a.log"), "abc\n")
+	var data string
+	for i := 0; i < 10 && data == ""; i++ {
+		data, _ = mr.ReadLine()
+	}
+	assert.Equal(t, "abc\n", data)
+
+	_, err = NewReader(meta, conf.MapConf{
+		"log_path":  filepath.Join(dirName, "*.log"),
+		"meta_path": filepath.Join(dirName, "meta"),
+		"mode":      ModeTailx,
+		"stat_mode": "inotify",
+	})
+	assert.Error(t, err)
+}
+
+func TestWatchDirs(t *testing.T) {
+	t.Parallel()
+	dirName := "TestWatchDirs"
+	for _, dir := range []string{"a/x", "a/y/z", "b"} {
+		assert.NoError(t, os.MkdirAll(filepath.Join(dirName, filepath.FromSlash(dir)), DefaultDirPerm))
+	}
+	defer os.RemoveAll(dirName)
+	assert.Equal(t, []string{dirName, filepath.Join(dirName, "a"), filepath.Join(dirName, "b")},
+		watchDirs(filepath.Join(dirName, "*", "*.log"), DefaultGlobMaxDepth))
+	assert.Equal(t, []string{filepath.Join(dirName, "a"), filepath.Join(dirName, "a", "x"), filepath.Join(dirName, "a", "y"), filepath.Join(dirName, "a", "y", "z")},
+		watchDirs(filepath.Join(dirName, "a", "**", "*.log"), DefaultGlobMaxDepth))
+	// 目录不存在时监听已存在的上级目录
+	assert.Equal(t, []string{dirName}, watchDirs(filepath.Join(dirName, "c", "d", "*.log"), DefaultGlobMaxDepth))
+}
+
+func TestLineLimiter(t *testing.T) {
+	t.Parallel()
+	assert.Nil(t, newLineLimiter(0))
+	l := newLineLimiter(10)
+	now := time.Now()
+	// 最多累积一秒的额度
+	for i := 0; i <= 10; i++ {
+		assert.Equal(t, time.Duration(0), l.take(now))
+	}
+	assert.Equal(t, 100*time.Millisecond, l.take(now))
+	assert.Equal(t, time.Duration(0), l.take(now.Add(100*time.Millisecond)))
+	assert.Equal(t, 50*time.Millisecond, l.take(now.Add(150*time.Millisecond)))
+}
+
+func TestReadRate(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	r := newReadRate(now)
+	// 第一次采样不足一秒时按已经读取的字节数估计
+	assert.Equal(t, float64(200), r.update(100, now.Add(500*time.Millisecond)))
+	assert.Equal(t, float64(100), r.update(100, now.Add(time.Second)))
+	// 距离上次采样不足一秒时不更新
+	assert.Equal(t, float64(100), r.update(1000, now.Add(1500*time.Millisecond)))
+	// 停止读取后速度逐渐下降
+	rate := r.update(100, now.Add(time.Minute+time.Second))
+	assert.True(t, rate > 30 && rate < 40, rate)
+}
+
+func TestMaxLinesPerFilePerSec(t *testing.T) {
+	t.Parallel()
+	dirName := "TestMaxLinesPerFilePerSec"
+	createDirWithName(dirName)
+	defer os.RemoveAll(dirName)
+	createFileWithContent(filepath.Join(dirName, "big.log"), strings.Repeat("big\n", 1000))
+	createFileWithContent(filepath.Join(dirName, "small.log"), strings.Repeat("small\n", 5))
+	c := conf.MapConf{
+		"log_path":                   filepath.Join(dirName, "*.log"),
+		"meta_path":                  filepath.Join(dirName, "meta"),
+		"mode":                       ModeTailx,
+		"read_from":                  "oldest",
+		"stat_interval":              "1h",
+		"tailx_workers":              "1",
+		"tailx_batch_lines":          "10",
+		"max_lines_per_file_per_sec": "100",
+	}
+	meta, err := reader.NewMetaWithConf(c)
+	assert.NoError(t, err)
+	mmr, err := NewReader(meta, c)
+	assert.NoError(t, err)
+	mr := mmr.(*Reader)
+	defer mr.Close()
+	assert.Equal(t, 10, mr.sched.batchLines)
+
+	start := time.Now()
+	assert.NoError(t, mr.Start())
+	counts := make(map[string]int)
+	for i := 0; i < 300 && counts["big\n"] < 160; i++ {
+		data, _ := mr.ReadLine()
+		counts[data]++
+	}
+	// 第一秒的额度用完之后按每秒 100 行读取
+	assert.Equal(t, 160, counts["big\n"])
+	assert.True(t, time.Since(start) >= 400*time.Millisecond, time.Since(start).String())
+	// 只有一个 worker，大文件被限速时让出 worker，小文件仍然能读完
+	assert.Equal(t, 5, counts["small\n"])
+
+	_, err = NewReader(meta, conf.MapConf{
+		"log_path":                   filepath.Join(dirName, "*.log"),
+		"meta_path":                  filepath.Join(dirName, "meta"),
+		"mode":                       ModeTailx,
+		"max_lines_per_file_per_sec": "-1",
+	})
+	assert.Error(t, err)
+}
+
+func TestDetailStatus(t *testing.T) {
+	t.Parallel()
+	dirName := "TestDetailStatus"
+	createDirWithName(dirName)
+	defer os.RemoveAll(dirName)
+	createFileWithContent(filepath.Join(dirName, "a.log"), "a1\na2\n")
+	createFileWithContent(filepath.Join(dirName, "b.log"), "b1\n")
+	c := conf.MapConf{
+		"log_path":      filepath.Join(dirName, "*.log"),
+		"meta_path":     filepath.Join(dirName, "meta"),
+		"mode":          ModeTailx,
+		"read_from":     "oldest",
+		"stat_interval": "1h",
+	}
+	meta, err := reader.NewMetaWithConf(c)
+	assert.NoError(t, err)
+	mmr, err := NewReader(meta, c)
+	assert.NoError(t, err)
+	mr := mmr.(*Reader)
+	defer mr.Close()
+	assert.NoError(t, mr.Start())
+
+	start := time.Now()
+	var lines []string
+	for i := 0; i < 100 && len(lines) < 3; i++ {
+		data, _ := mr.ReadLine()
+		if data != "" {
+			lines = append(lines, data)
+		}
+	}
+	assert.Len(t, lines, 3)
+
+	var files []FileStatus
+	for i := 0; i < 50; i++ {
+		files = mr.DetailStatus()
+		if len(files) == 2 && files[0].Lag == 0 && files[1].Lag == 0 {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	assert.Len(t, files, 2)
+	for i, expect := range []struct {
+		name string
+		size int64
+	}{{"a.log", 6}, {"b.log", 3}} {
+		path, err := filepath.Abs(filepath.Join(dirName, expect.name))
+		assert.NoError(t, err)
+		assert.Equal(t, filepath.Join(dirName, expect.name), files[i].Path)
+		assert.Equal(t, path, files[i].RealPath)
+		assert.Equal(t, expect.size, files[i].Size)
+		assert.Equal(t, expect.size, files[i].Offset)
+		assert.EqualValues(t, 0, files[i].Lag)
+		assert.True(t, !files[i].LastRead.Before(start.Add(-time.Second)), files[i].LastRead.String())
+		assert.True(t, files[i].ReadRate > 0)
+		assert.EqualValues(t, 0, files[i].ETA)
+		assert.Empty(t, files[i].Error)
+	}
+
+	// 新写入的数据在读取前计入 lag
+	appendFileWithContent(filepath.Join(dirName, "b.log"), "b2\n")
+	files = mr.DetailStatus()
+	assert.EqualValues(t, 6, files[1].Size)
+	assert.EqualValues(t, 6, files[1].Offset+files[1].Lag)
+	if files[1].Lag > 0 {
+		assert.True(t, files[1].ETA >= 1, files[1].ETA)
+	}
+}
+
+func TestHeadPatternFlushTimeout(t *testing.T) {
+	t.Parallel()
+	dirName := "TestHeadPatternFlushTimeout"
+	createDirWithName(dirName)
+	defer os.RemoveAll(dirName)
+	logPath := filepath.Join(dirName, "a.log")
+	createFileWithContent(logPath, "2020 a\n a1\n2020 b\n b1\n")
+	c := conf.MapConf{
+		"log_path":                   filepath.Join(dirName, "*.log"),
+		"meta_path":                  filepath.Join(dirName, "meta"),
+		"mode":                       ModeTailx,
+		"read_from":                  "oldest",
+		"stat_interval":              "1h",
+		"head_pattern_flush_timeout": "500ms",
+	}
+	meta, err := reader.NewMetaWithConf(c)
+	assert.NoError(t, err)
+	mmr, err := NewReader(meta, c)
+	assert.NoError(t, err)
+	mr := mmr.(*Reader)
+	defer mr.Close()
+	assert.NoError(t, mr.SetMode(ReadModeHeadPatternString, "^2020"))
+	assert.NoError(t, mr.Start())
+
+	readLine := func(timeout time.Duration) string {
+		for start := time.Now(); time.Since(start) < timeout; {
+			if data, _ := mr.ReadLine(); data != "" {
+				return data
+			}
+		}
+		return ""
+	}
+	assert.Equal(t, "2020 a\n a1\n", readLine(3*time.Second))
+	// 超时之前写入的行属于缓存中的日志，文件末尾的日志在超时之后才发送
+	time.Sleep(100 * time.Millisecond)
+	appendFileWithContent(logPath, " b2\n")
+	start := time.Now()
+	assert.Equal(t, "2020 b\n b1\n b2\n", readLine(5*time.Second))
+	assert.True(t, time.Since(start) >= 300*time.Millisecond, time.Since(start).String())
+}
+
+func TestReadCompressed(t *testing.T) {
+	t.Parallel()
+	dirName := "TestReadCompressed"
+	createDirWithName(dirName)
+	defer os.RemoveAll(dirName)
+	createFileWithContent(filepath.Join(dirName, "a.log"), "new1\n")
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write([]byte("old1\nold2\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dirName, "a.log.1.gz"), buf.Bytes(), 0644))
+
+	c := conf.MapConf{
+		"log_path":        filepath.Join(dirName, "a.log*"),
+		"meta_path":       filepath.Join(dirName, "meta"),
+		"mode":            ModeTailx,
+		"read_from":       "oldest",
+		"stat_interval":   "1h",
+		"read_compressed": "true",
+	}
+	meta, err := reader.NewMetaWithConf(c)
+	assert.NoError(t, err)
+	mmr, err := NewReader(meta, c)
+	assert.NoError(t, err)
+	mr := mmr.(*Reader)
+	defer mr.Close()
+	assert.NoError(t, mr.Start())
+
+	var lines []string
+	for i := 0; i < 100 && len(lines) < 3; i++ {
+		if data, _ := mr.ReadLine(); data != "" {
+			lines = append(lines, data)
+		}
+	}
+	sort.Strings(lines)
+	assert.Equal(t, []string{"new1\n", "old1\n", "old2\n"}, lines)
+
+	var files []FileStatus
+	for i := 0; i < 50; i++ {
+		if files = mr.DetailStatus(); len(files) == 2 && files[1].Offset == 10 {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	assert.Len(t, files, 2)
+	// 压缩文件的读取位置按解压后的字节数计算
+	assert.EqualValues(t, 10, files[1].Offset)
+}
+
+func TestAfterReadAction(t *testing.T) {
+	t.Parallel()
+	dirName := "TestAfterReadAction"
+	createDirWithName(dirName)
+	defer os.RemoveAll(dirName)
+	logDir := filepath.Join(dirName, "logs")
+	archiveDir := filepath.Join(dirName, "archive")
+	createDirWithName(logDir)
+	file1 := filepath.Join(logDir, "a.log")
+	createFileWithContent(file1, "abc111\nabc112\n")
+
+	c := conf.MapConf{
+		"log_path":          filepath.Join(logDir, "*.log"),
+		"meta_path":         filepath.Join(dirName, "meta"),
+		"mode":              ModeTailx,
+		"read_from":         "oldest",
+		"stat_interval":     "1h",
+		"after_read_action": "move",
+	}
+	meta, err := reader.NewMetaWithConf(c)
+	assert.NoError(t, err)
+	_, err = NewReader(meta, c)
+	assert.Error(t, err)
+	c["after_read_action"] = "archive"
+	_, err = NewReader(meta, c)
+	assert.Error(t, err)
+
+	c["after_read_action"] = "move"
+	c["move_to"] = archiveDir
+	mmr, err := NewReader(meta, c)
+	assert.NoError(t, err)
+	mr := mmr.(*Reader)
+	defer mr.Close()
+	assert.NoError(t, mr.Start())
+
+	var lines []string
+	for i := 0; i < 100 && len(lines) < 2; i++ {
+		if data, _ := mr.ReadLine(); data != "" {
+			lines = append(lines, data)
+		}
+	}
+	assert.Equal(t, []string{"abc111\n", "abc112\n"}, lines)
+
+	var ar *ActiveReader
+	mr.armapmux.Lock()
+	for _, fr := range mr.fileReaders {
+		ar = fr
+	}
+	mr.armapmux.Unlock()
+	if !assert.NotNil(t, ar) {
+		return
+	}
+	// 读取进度还没有记录到 meta 中，不能处理
+	assert.False(t, ar.syncedToEnd())
+
+	synced := false
+	for i := 0; i < 50 && !synced; i++ {
+		mr.SyncMeta()
+		synced = ar.syncedToEnd()
+		time.Sleep(20 * time.Millisecond)
+	}
+	assert.True(t, synced)
+
+	mr.expire = time.Nanosecond
+	atomic.StoreInt32(&ar.inactive, 1)
+	mr.checkExpiredFiles()
+	_, err = os.Stat(file1)
+	assert.True(t, os.IsNotExist(err))
+	data, err := ioutil.ReadFile(filepath.Join(archiveDir, "a.log"))
+	assert.NoError(t, err)
+	assert.Equal(t, "abc111\nabc112\n", string(data))
+}
+
+func TestMoveFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestMoveFile")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "a.log")
+	archive := filepath.Join(dir, "archive")
+	assert.NoError(t, os.Mkdir(archive, 0755))
+
+	assert.NoError(t, ioutil.WriteFile(src, []byte("first"), 0644))
+	target, err := moveFile(src, archive)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(archive, "a.log"), target)
+
+	// 同名文件已经存在时不覆盖
+	assert.NoError(t, ioutil.WriteFile(src, []byte("second"), 0644))
+	target, err = moveFile(src, archive)
+	assert.NoError(t, err)
+	assert.NotEqual(t, filepath.Join(archive, "a.log"), target)
+	data, err := ioutil.ReadFile(filepath.Join(archive, "a.log"))
+	assert.NoError(t, err)
+	assert.Equal(t, "first", string(data))
+	data, err = ioutil.ReadFile(target)
+	assert.NoError(t, err)
+	assert.Equal(t, "second", string(data))
+	_, err = os.Stat(src)
+	assert.True(t, os.IsNotExist(err))
+}
@@ -14,6 +14,7 @@ import (
 	"github.com/json-iterator/go"
 
 	"github.com/qiniu/log"
+	"github.com/qiniu/logkit/conf"
 	"github.com/qiniu/logkit/reader/config"
 	"github.com/qiniu/logkit/utils/models"
 )
@@ -91,6 +92,27 @@ func NotCondition(f1 func(os.FileInfo) bool) func(os.FileInfo) bool {
 	}
 }
 
+// HeadPatternFlushTimeout 解析 head_pattern_flush_timeout，纯数字表示秒，没有配置时返回 0
+func HeadPatternFlushTimeout(c conf.MapConf) (time.Duration, error) {
+	value, _ := c.GetStringOr(config.KeyHeadPatternFlushTimeout, "")
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		seconds, serr := strconv.ParseFloat(value, 64)
+		if serr != nil {
+			return 0, fmt.Errorf("%v %q is invalid: %v", config.KeyHeadPatternFlushTimeout, value, err)
+		}
+		timeout = time.Duration(seconds * float64(time.Second))
+	}
+	if timeout < 0 {
+		return 0, fmt.Errorf("%v should not be negative", config.KeyHeadPatternFlushTimeout)
+	}
+	return timeout, nil
+}
+
 func HeadPatternMode(mode string, v interface{}) (reg *regexp.Regexp, err error) {
 	switch mode {
 	case config.ReadModeHeadPatternString:
@@ -11,6 +11,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 
+	"github.com/qiniu/logkit/conf"
 	. "github.com/qiniu/logkit/reader/config"
 	. "github.com/qiniu/logkit/reader/test"
 	. "github.com/qiniu/logkit/utils/models"
@@ -517,3 +518,27 @@ func TestParseRunTimeWithMode(t *testing.T) {
 		assert.EqualValues(t, test.expectRunTime, actualRunTime)
 	}
 }
+
+func TestHeadPatternFlushTimeout(t *testing.T) {
+	tests := []struct {
+		value  string
+		expect time.Duration
+		hasErr bool
+	}{
+		{value: "", expect: 0},
+		{value: "5s", expect: 5 * time.Second},
+		{value: "2", expect: 2 * time.Second},
+		{value: "1.5", expect: 1500 * time.Millisecond},
+		{value: "-1s", hasErr: true},
+		{value: "abc", hasErr: true},
+	}
+	for _, ti := range tests {
+		timeout, err := HeadPatternFlushTimeout(conf.MapConf{KeyHeadPatternFlushTimeout: ti.value})
+		if ti.hasErr {
+			assert.Error(t, err, ti.value)
+			continue
+		}
+		assert.NoError(t, err, ti.value)
+		assert.Equal(t, ti.expect, timeout, ti.value)
+	}
+}
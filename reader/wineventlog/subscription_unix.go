@@ -0,0 +1,6 @@
+// +build !windows
+
+package wineventlog
+
+// openSubscription 只在 windows 上实现，其他平台上为空，NewReader 时返回错误
+var openSubscription func(query, bookmark string, fromOldest bool) (subscription, error)
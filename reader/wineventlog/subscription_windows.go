@@ -0,0 +1,267 @@
+// +build windows
+
+package wineventlog
+
+import (
+	"encoding/xml"
+	"fmt"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// wevtapi 的函数说明见 https://docs.microsoft.com/en-us/windows/desktop/wes/windows-event-log-functions
+var (
+	modwevtapi = windows.NewLazySystemDLL("wevtapi.dll")
+
+	procEvtSubscribe             = modwevtapi.NewProc("EvtSubscribe")
+	procEvtNext                  = modwevtapi.NewProc("EvtNext")
+	procEvtRender                = modwevtapi.NewProc("EvtRender")
+	procEvtClose                 = modwevtapi.NewProc("EvtClose")
+	procEvtCreateBookmark        = modwevtapi.NewProc("EvtCreateBookmark")
+	procEvtUpdateBookmark        = modwevtapi.NewProc("EvtUpdateBookmark")
+	procEvtOpenPublisherMetadata = modwevtapi.NewProc("EvtOpenPublisherMetadata")
+	procEvtFormatMessage         = modwevtapi.NewProc("EvtFormatMessage")
+)
+
+// EVT_SUBSCRIBE_FLAGS
+const (
+	evtSubscribeToFutureEvents      = 1
+	evtSubscribeStartAtOldestRecord = 2
+	evtSubscribeStartAfterBookmark  = 3
+)
+
+// EVT_RENDER_FLAGS 与 EVT_FORMAT_MESSAGE_FLAGS
+const (
+	evtRenderEventXml    = 1
+	evtRenderBookmark    = 2
+	evtFormatMessageXml  = 9
+	evtNextBatchSize     = 100
+	initRenderBufferSize = 4096
+)
+
+const (
+	errorNoMoreItems      syscall.Errno = 259
+	errorInvalidOperation syscall.Errno = 4317
+)
+
+var openSubscription = subscribe
+
+type winSubscription struct {
+	handle   uintptr
+	signal   windows.Handle
+	bookmark uintptr
+	// publishers 发布者元数据的缓存，打开失败时为 0，事件只输出不含 RenderingInfo 的 XML
+	publishers map[string]uintptr
+	buf        []uint16
+}
+
+// subscribe 以拉取的方式订阅 query，有书签时从书签之后开始，书签无效时按 fromOldest 从最早或者最新的事件开始
+func subscribe(query, bookmark string, fromOldest bool) (subscription, error) {
+	s := &winSubscription{publishers: make(map[string]uintptr), buf: make([]uint16, initRenderBufferSize)}
+	var flags uintptr = evtSubscribeToFutureEvents
+	if fromOldest {
+		flags = evtSubscribeStartAtOldestRecord
+	}
+	if bookmark != "" {
+		if bookmarkXML, err := decodeBookmark(bookmark); err == nil {
+			if h, err := createBookmark(bookmarkXML); err == nil {
+				s.bookmark = h
+				flags = evtSubscribeStartAfterBookmark
+			}
+		}
+	}
+	if s.bookmark == 0 {
+		h, err := createBookmark("")
+		if err != nil {
+			return nil, fmt.Errorf("create bookmark error: %v", err)
+		}
+		s.bookmark = h
+	}
+
+	signal, err := windows.CreateEvent(nil, 1, 1, nil)
+	if err != nil {
+		s.close()
+		return nil, fmt.Errorf("create signal event error: %v", err)
+	}
+	s.signal = signal
+	q, err := syscall.UTF16PtrFromString(query)
+	if err != nil {
+		s.close()
+		return nil, err
+	}
+	h, _, err := procEvtSubscribe.Call(0, uintptr(signal), 0, uintptr(unsafe.Pointer(q)), s.bookmark, 0, 0, flags)
+	if h == 0 {
+		s.close()
+		return nil, err
+	}
+	s.handle = h
+	return s, nil
+}
+
+func createBookmark(bookmark string) (uintptr, error) {
+	var p *uint16
+	if bookmark != "" {
+		var err error
+		if p, err = syscall.UTF16PtrFromString(bookmark); err != nil {
+			return 0, err
+		}
+	}
+	h, _, err := procEvtCreateBookmark.Call(uintptr(unsafe.Pointer(p)))
+	if h == 0 {
+		return 0, err
+	}
+	return h, nil
+}
+
+func evtClose(h uintptr) {
+	if h != 0 {
+		procEvtClose.Call(h)
+	}
+}
+
+func (s *winSubscription) next(timeout time.Duration) ([]rawEvent, error) {
+	events, err := s.fetch()
+	if err != nil || len(events) > 0 {
+		return events, err
+	}
+	if err = windows.ResetEvent(s.signal); err != nil {
+		return nil, err
+	}
+	// 重置信号后再读取一次，避免错过在读取和重置之间到达的事件
+	if events, err = s.fetch(); err != nil || len(events) > 0 {
+		return events, err
+	}
+	ev, err := windows.WaitForSingleObject(s.signal, uint32(timeout/time.Millisecond))
+	if err != nil {
+		return nil, err
+	}
+	if ev != windows.WAIT_OBJECT_0 {
+		return nil, nil
+	}
+	return s.fetch()
+}
+
+// fetch 读取一批已经到达的事件，没有事件时返回空
+func (s *winSubscription) fetch() ([]rawEvent, error) {
+	var handles [evtNextBatchSize]uintptr
+	var returned uint32
+	ok, _, err := procEvtNext.Call(s.handle, evtNextBatchSize, uintptr(unsafe.Pointer(&handles[0])), 0, 0, uintptr(unsafe.Pointer(&returned)))
+	if ok == 0 {
+		if err == errorNoMoreItems || (err == errorInvalidOperation && returned == 0) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read events error: %v", err)
+	}
+	events := make([]rawEvent, 0, returned)
+	for _, h := range handles[:returned] {
+		e := s.renderEvent(h)
+		evtClose(h)
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+// renderEvent 输出事件的 XML 并更新书签，无法输出的事件同样更新书签，由 reader 记录错误后跳过
+func (s *winSubscription) renderEvent(h uintptr) rawEvent {
+	var e rawEvent
+	if xmlStr, err := s.render(h, evtRenderEventXml); err != nil {
+		e.err = fmt.Errorf("render event error: %v", err)
+	} else {
+		e.xml = xmlStr
+		if pm := s.publisher(providerName(xmlStr)); pm != 0 {
+			if formatted, err := s.formatXML(pm, h); err == nil {
+				e.xml = formatted
+			}
+		}
+	}
+	if ok, _, err := procEvtUpdateBookmark.Call(s.bookmark, h); ok == 0 {
+		e.err = fmt.Errorf("update bookmark error: %v", err)
+		return e
+	}
+	bookmarkXML, err := s.render(s.bookmark, evtRenderBookmark)
+	if err != nil {
+		e.err = fmt.Errorf("render bookmark error: %v", err)
+		return e
+	}
+	if e.bookmark, err = encodeBookmark(bookmarkXML); err != nil {
+		e.err = err
+	}
+	return e
+}
+
+// render 调用 EvtRender，缓冲区的大小以字节为单位
+func (s *winSubscription) render(h, flags uintptr) (string, error) {
+	for {
+		var used, count uint32
+		ok, _, err := procEvtRender.Call(0, h, flags, uintptr(len(s.buf)*2), uintptr(unsafe.Pointer(&s.buf[0])),
+			uintptr(unsafe.Pointer(&used)), uintptr(unsafe.Pointer(&count)))
+		if ok != 0 {
+			return windows.UTF16ToString(s.buf[:used/2]), nil
+		}
+		if err != windows.ERROR_INSUFFICIENT_BUFFER {
+			return "", err
+		}
+		s.buf = make([]uint16, used/2+1)
+	}
+}
+
+// formatXML 调用 EvtFormatMessage 输出包含 RenderingInfo 的 XML，缓冲区的大小以字符为单位
+func (s *winSubscription) formatXML(pm, h uintptr) (string, error) {
+	for {
+		var used uint32
+		ok, _, err := procEvtFormatMessage.Call(pm, h, 0, 0, 0, evtFormatMessageXml, uintptr(len(s.buf)),
+			uintptr(unsafe.Pointer(&s.buf[0])), uintptr(unsafe.Pointer(&used)))
+		if ok != 0 {
+			return windows.UTF16ToString(s.buf[:used]), nil
+		}
+		if err != windows.ERROR_INSUFFICIENT_BUFFER {
+			return "", err
+		}
+		s.buf = make([]uint16, used+1)
+	}
+}
+
+// publisher 返回发布者的元数据，用于输出事件的消息以及级别、任务等的名称
+func (s *winSubscription) publisher(name string) uintptr {
+	if name == "" {
+		return 0
+	}
+	if pm, ok := s.publishers[name]; ok {
+		return pm
+	}
+	var pm uintptr
+	if p, err := syscall.UTF16PtrFromString(name); err == nil {
+		pm, _, _ = procEvtOpenPublisherMetadata.Call(0, uintptr(unsafe.Pointer(p)), 0, 0, 0)
+	}
+	s.publishers[name] = pm
+	return pm
+}
+
+func providerName(s string) string {
+	var e struct {
+		System struct {
+			Provider struct {
+				Name string `xml:"Name,attr"`
+			} `xml:"Provider"`
+		} `xml:"System"`
+	}
+	if err := xml.Unmarshal([]byte(s), &e); err != nil {
+		return ""
+	}
+	return e.System.Provider.Name
+}
+
+func (s *winSubscription) close() error {
+	evtClose(s.handle)
+	evtClose(s.bookmark)
+	for _, pm := range s.publishers {
+		evtClose(pm)
+	}
+	if s.signal != 0 {
+		return windows.CloseHandle(s.signal)
+	}
+	return nil
+}
@@ -0,0 +1,544 @@
+package wineventlog
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/qiniu/log"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/reader"
+	. "github.com/qiniu/logkit/reader/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+var (
+	_ reader.DaemonReader = &Reader{}
+	_ reader.StatsReader  = &Reader{}
+	_ reader.DataReader   = &Reader{}
+	_ reader.Reader       = &Reader{}
+)
+
+// 数据中的字段，事件中的 EventData 以 Data 的 Name 为键放在 event_data 中，没有 Name 的依次命名为 param1、param2...
+const (
+	FieldChannel     = "channel"
+	FieldComputer    = "computer"
+	FieldProvider    = "provider"
+	FieldEventID     = "event_id"
+	FieldVersion     = "version"
+	FieldLevel       = "level"
+	FieldLevelName   = "level_name"
+	FieldTask        = "task"
+	FieldTaskName    = "task_name"
+	FieldOpcode      = "opcode"
+	FieldOpcodeName  = "opcode_name"
+	FieldKeywords    = "keywords"
+	FieldRecordID    = "record_id"
+	FieldTimeCreated = "time_created"
+	FieldProcessID   = "process_id"
+	FieldThreadID    = "thread_id"
+	FieldUserSID     = "user_sid"
+	FieldActivityID  = "activity_id"
+	FieldMessage     = "message"
+	FieldEventData   = "event_data"
+	FieldUserData    = "user_data"
+	FieldXML         = "xml"
+)
+
+const (
+	// 订阅出错后重新订阅的间隔
+	restartInterval = 3 * time.Second
+	// 每次等待新事件的最长时间，超时后检查 reader 是否已经关闭
+	waitTimeout = time.Second
+)
+
+// 没有 RenderingInfo 时级别的名称，0 为 LogAlways，安全日志中的事件都是这个级别
+var levelNames = []string{"information", "critical", "error", "warning", "information", "verbose"}
+
+func init() {
+	reader.RegisterConstructor(ModeWinEventLog, NewReader)
+}
+
+// subscription 事件日志的订阅，由各平台实现
+type subscription interface {
+	// next 返回新的事件，没有新事件时最多等待 timeout 后返回空
+	next(timeout time.Duration) ([]rawEvent, error)
+	close() error
+}
+
+// rawEvent 订阅返回的一个事件，xml 为事件的 XML，存在发布者元数据时包含 RenderingInfo，
+// bookmark 为读取到该事件为止的书签(encodeBookmark 的格式)，err 不为空时表示该事件无法读取
+type rawEvent struct {
+	xml      string
+	bookmark string
+	err      error
+}
+
+type readInfo struct {
+	data     Data
+	bookmark string
+	bytes    int64
+}
+
+type Reader struct {
+	meta *reader.Meta
+	// Note: 原子操作，用于表示 reader 整体的运行状态
+	status int32
+
+	stopChan chan struct{}
+	readChan chan readInfo
+	errChan  chan error
+
+	stats     StatsInfo
+	statsLock sync.RWMutex
+
+	channels   []string
+	query      string
+	whence     string
+	includeXML bool
+
+	// bookmark 最近一个已经被上层读取的事件的书签，记录在 meta 中
+	bookmarkLock sync.RWMutex
+	bookmark     string
+	// sentBookmark 最近一个已经放入 readChan 的事件的书签，重新订阅时从这里继续读取，只在 run 中使用
+	sentBookmark string
+}
+
+func NewReader(meta *reader.Meta, c conf.MapConf) (reader.Reader, error) {
+	if openSubscription == nil {
+		return nil, errors.New("windows event log reader is only supported on windows")
+	}
+	channels, _ := c.GetStringListOr(KeyWinEventLogChannels, []string{"Application", "System"})
+	xpath, _ := c.GetStringOr(KeyWinEventLogQuery, "*")
+	includeXML, _ := c.GetBoolOr(KeyWinEventLogIncludeXML, false)
+	whence, _ := c.GetStringOr(KeyWhence, WhenceOldest)
+	if whence != WhenceOldest && whence != WhenceNewest {
+		return nil, fmt.Errorf("%v should be %v or %v", KeyWhence, WhenceOldest, WhenceNewest)
+	}
+
+	r := &Reader{
+		meta:       meta,
+		status:     StatusInit,
+		stopChan:   make(chan struct{}),
+		readChan:   make(chan readInfo, 1000),
+		errChan:    make(chan error),
+		whence:     whence,
+		includeXML: includeXML,
+	}
+	for _, channel := range channels {
+		if channel = strings.TrimSpace(channel); channel != "" {
+			r.channels = append(r.channels, channel)
+		}
+	}
+	query, err := buildQuery(r.channels, xpath)
+	if err != nil {
+		return nil, err
+	}
+	r.query = query
+	if bookmark, _, err := meta.ReadOffset(); err == nil && bookmark != "" {
+		r.bookmark = bookmark
+		r.sentBookmark = bookmark
+	}
+	return r, nil
+}
+
+// buildQuery 生成订阅使用的 QueryList，xpath 本身是 QueryList 时直接使用，否则对每个通道使用同一个 XPath 过滤
+func buildQuery(channels []string, xpath string) (string, error) {
+	xpath = strings.TrimSpace(xpath)
+	if strings.HasPrefix(xpath, "<QueryList") {
+		return xpath, nil
+	}
+	if len(channels) == 0 {
+		return "", fmt.Errorf("%v is empty", KeyWinEventLogChannels)
+	}
+	if xpath == "" {
+		xpath = "*"
+	}
+	var buf bytes.Buffer
+	buf.WriteString(`<QueryList><Query Id="0">`)
+	for _, channel := range channels {
+		buf.WriteString(`<Select Path="`)
+		xml.EscapeText(&buf, []byte(channel))
+		buf.WriteString(`">`)
+		xml.EscapeText(&buf, []byte(xpath))
+		buf.WriteString(`</Select>`)
+	}
+	buf.WriteString(`</Query></QueryList>`)
+	return buf.String(), nil
+}
+
+// encodeBookmark 将书签的 XML 转换为 Channel=RecordId 以 & 连接的形式，meta 中的记录不能包含空白字符
+func encodeBookmark(s string) (string, error) {
+	var list struct {
+		Bookmarks []struct {
+			Channel  string `xml:"Channel,attr"`
+			RecordID string `xml:"RecordId,attr"`
+		} `xml:"Bookmark"`
+	}
+	if err := xml.Unmarshal([]byte(s), &list); err != nil {
+		return "", fmt.Errorf("invalid bookmark %q: %v", s, err)
+	}
+	values := url.Values{}
+	for _, b := range list.Bookmarks {
+		values.Set(b.Channel, b.RecordID)
+	}
+	return values.Encode(), nil
+}
+
+// decodeBookmark 将 encodeBookmark 的结果还原为书签的 XML
+func decodeBookmark(s string) (string, error) {
+	values, err := url.ParseQuery(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid bookmark %q: %v", s, err)
+	}
+	channels := make([]string, 0, len(values))
+	for channel := range values {
+		channels = append(channels, channel)
+	}
+	sort.Strings(channels)
+	var buf bytes.Buffer
+	buf.WriteString("<BookmarkList>")
+	for i, channel := range channels {
+		buf.WriteString("<Bookmark Channel='")
+		xml.EscapeText(&buf, []byte(channel))
+		buf.WriteString("' RecordId='")
+		xml.EscapeText(&buf, []byte(values.Get(channel)))
+		if i == 0 {
+			buf.WriteString("' IsCurrent='true")
+		}
+		buf.WriteString("'/>")
+	}
+	buf.WriteString("</BookmarkList>")
+	return buf.String(), nil
+}
+
+func (r *Reader) isStopping() bool {
+	return atomic.LoadInt32(&r.status) == StatusStopping
+}
+
+func (r *Reader) hasStopped() bool {
+	return atomic.LoadInt32(&r.status) == StatusStopped
+}
+
+func (r *Reader) Name() string {
+	return "wineventlog:" + strings.Join(r.channels, ",")
+}
+
+func (r *Reader) SetMode(mode string, v interface{}) error {
+	return errors.New("windows event log reader does not support read mode")
+}
+
+func (r *Reader) setStatsError(err string) {
+	r.statsLock.Lock()
+	defer r.statsLock.Unlock()
+	r.stats.LastError = err
+}
+
+func (r *Reader) sendError(err error) {
+	if err == nil {
+		return
+	}
+	defer func() {
+		if rec := recover(); rec != nil {
+			log.Errorf("Reader %q was panicked and recovered from %v", r.Name(), rec)
+		}
+	}()
+	select {
+	case r.errChan <- err:
+	case <-r.stopChan:
+	}
+}
+
+func (r *Reader) Start() error {
+	if r.isStopping() || r.hasStopped() {
+		return errors.New("reader is stopping or has stopped")
+	}
+	if !atomic.CompareAndSwapInt32(&r.status, StatusInit, StatusRunning) {
+		log.Warnf("Runner[%v] %q daemon has already started and is running", r.meta.RunnerName, r.Name())
+		return nil
+	}
+
+	go r.run()
+	log.Infof("Runner[%v] %q daemon has started", r.meta.RunnerName, r.Name())
+	return nil
+}
+
+func (r *Reader) run() {
+	defer func() {
+		atomic.StoreInt32(&r.status, StatusStopped)
+		log.Infof("Runner[%v] %q daemon has stopped from running", r.meta.RunnerName, r.Name())
+	}()
+
+	for {
+		err := r.subscribe()
+		if r.isStopping() || r.hasStopped() {
+			return
+		}
+		err = fmt.Errorf("%v, subscribe again in %v", err, restartInterval)
+		log.Errorf("Runner[%v] %q %v", r.meta.RunnerName, r.Name(), err)
+		r.setStatsError(err.Error())
+		r.sendError(err)
+		select {
+		case <-r.stopChan:
+			return
+		case <-time.After(restartInterval):
+		}
+	}
+}
+
+// subscribe 订阅事件并持续读取，直到出错或者 reader 被关闭，有书签时从书签之后继续读取
+func (r *Reader) subscribe() error {
+	sub, err := openSubscription(r.query, r.sentBookmark, r.whence == WhenceOldest)
+	if err != nil {
+		return fmt.Errorf("subscribe error: %v", err)
+	}
+	defer sub.close()
+
+	for {
+		select {
+		case <-r.stopChan:
+			return nil
+		default:
+		}
+		events, err := sub.next(waitTimeout)
+		if err != nil {
+			return err
+		}
+		for _, e := range events {
+			var data Data
+			err := e.err
+			if err == nil {
+				data, err = ParseEvent(e.xml)
+			}
+			if err != nil {
+				log.Errorf("Runner[%v] %q %v", r.meta.RunnerName, r.Name(), err)
+				r.setStatsError(err.Error())
+				// 无法解析的事件同样推进书签，避免重新订阅时反复读取
+				if e.bookmark != "" {
+					r.sentBookmark = e.bookmark
+				}
+				continue
+			}
+			if r.includeXML {
+				data[FieldXML] = e.xml
+			}
+			select {
+			case <-r.stopChan:
+				return nil
+			case r.readChan <- readInfo{data: data, bookmark: e.bookmark, bytes: int64(len(e.xml))}:
+				r.sentBookmark = e.bookmark
+			}
+		}
+	}
+}
+
+type xmlEvent struct {
+	System struct {
+		Provider struct {
+			Name string `xml:"Name,attr"`
+		} `xml:"Provider"`
+		EventID     string `xml:"EventID"`
+		Version     string `xml:"Version"`
+		Level       string `xml:"Level"`
+		Task        string `xml:"Task"`
+		Opcode      string `xml:"Opcode"`
+		Keywords    string `xml:"Keywords"`
+		TimeCreated struct {
+			SystemTime string `xml:"SystemTime,attr"`
+		} `xml:"TimeCreated"`
+		EventRecordID string `xml:"EventRecordID"`
+		Correlation   struct {
+			ActivityID string `xml:"ActivityID,attr"`
+		} `xml:"Correlation"`
+		Execution struct {
+			ProcessID string `xml:"ProcessID,attr"`
+			ThreadID  string `xml:"ThreadID,attr"`
+		} `xml:"Execution"`
+		Channel  string `xml:"Channel"`
+		Computer string `xml:"Computer"`
+		Security struct {
+			UserID string `xml:"UserID,attr"`
+		} `xml:"Security"`
+	} `xml:"System"`
+	EventData struct {
+		Data []struct {
+			Name  string `xml:"Name,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"Data"`
+		Binary string `xml:"Binary"`
+	} `xml:"EventData"`
+	UserData struct {
+		Inner []byte `xml:",innerxml"`
+	} `xml:"UserData"`
+	RenderingInfo struct {
+		Message  string   `xml:"Message"`
+		Level    string   `xml:"Level"`
+		Task     string   `xml:"Task"`
+		Opcode   string   `xml:"Opcode"`
+		Keywords []string `xml:"Keywords>Keyword"`
+	} `xml:"RenderingInfo"`
+}
+
+// ParseEvent 将事件的 XML 转换为数据，数字类型的字段转换为整数，RenderingInfo 中的名称优先于数字的说明
+func ParseEvent(s string) (Data, error) {
+	var e xmlEvent
+	if err := xml.Unmarshal([]byte(s), &e); err != nil {
+		return nil, fmt.Errorf("invalid event %q: %v", TruncateStrSize(s, 256), err)
+	}
+	sys := e.System
+	if sys.Channel == "" || sys.EventRecordID == "" {
+		return nil, fmt.Errorf("event %q has no Channel or EventRecordID", TruncateStrSize(s, 256))
+	}
+	data := Data{
+		FieldChannel:  sys.Channel,
+		FieldComputer: sys.Computer,
+		FieldProvider: sys.Provider.Name,
+	}
+	setInt(data, FieldEventID, sys.EventID)
+	setInt(data, FieldVersion, sys.Version)
+	setInt(data, FieldLevel, sys.Level)
+	setInt(data, FieldTask, sys.Task)
+	setInt(data, FieldOpcode, sys.Opcode)
+	setInt(data, FieldRecordID, sys.EventRecordID)
+	setInt(data, FieldProcessID, sys.Execution.ProcessID)
+	setInt(data, FieldThreadID, sys.Execution.ThreadID)
+	setString(data, FieldKeywords, sys.Keywords)
+	setString(data, FieldUserSID, sys.Security.UserID)
+	setString(data, FieldActivityID, sys.Correlation.ActivityID)
+	if t, err := time.Parse(time.RFC3339Nano, sys.TimeCreated.SystemTime); err == nil {
+		data[FieldTimeCreated] = t.Format(time.RFC3339Nano)
+	}
+
+	info := e.RenderingInfo
+	setString(data, FieldMessage, strings.TrimSpace(info.Message))
+	setString(data, FieldLevelName, info.Level)
+	setString(data, FieldTaskName, info.Task)
+	setString(data, FieldOpcodeName, info.Opcode)
+	if len(info.Keywords) > 0 {
+		data[FieldKeywords] = info.Keywords
+	}
+	if _, ok := data[FieldLevelName]; !ok {
+		if level, ok := data[FieldLevel].(int64); ok && level >= 0 && level < int64(len(levelNames)) {
+			data[FieldLevelName] = levelNames[level]
+		}
+	}
+
+	if len(e.EventData.Data) > 0 || e.EventData.Binary != "" {
+		eventData := make(map[string]interface{}, len(e.EventData.Data)+1)
+		param := 0
+		for _, d := range e.EventData.Data {
+			name := d.Name
+			if name == "" {
+				param++
+				name = "param" + strconv.Itoa(param)
+			}
+			eventData[name] = d.Value
+		}
+		if e.EventData.Binary != "" {
+			eventData["binary"] = e.EventData.Binary
+		}
+		data[FieldEventData] = eventData
+	}
+	if userData := parseUserData(e.UserData.Inner); len(userData) > 0 {
+		data[FieldUserData] = userData
+	}
+	return data, nil
+}
+
+func setInt(data Data, key, value string) {
+	if value == "" {
+		return
+	}
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		data[key] = n
+	}
+}
+
+func setString(data Data, key, value string) {
+	if value != "" {
+		data[key] = value
+	}
+}
+
+// parseUserData 解析 UserData 中由发布者定义的元素，返回其子元素的名称与文本
+func parseUserData(inner []byte) map[string]interface{} {
+	if len(bytes.TrimSpace(inner)) == 0 {
+		return nil
+	}
+	var root struct {
+		Fields []struct {
+			XMLName xml.Name
+			Value   string `xml:",chardata"`
+		} `xml:",any"`
+	}
+	if err := xml.Unmarshal(inner, &root); err != nil {
+		return nil
+	}
+	fields := make(map[string]interface{}, len(root.Fields))
+	for _, f := range root.Fields {
+		fields[f.XMLName.Local] = strings.TrimSpace(f.Value)
+	}
+	return fields
+}
+
+func (r *Reader) Source() string {
+	return r.Name()
+}
+
+func (r *Reader) ReadLine() (string, error) {
+	return "", errors.New("method ReadLine is not supported, please use ReadData")
+}
+
+func (r *Reader) ReadData() (Data, int64, error) {
+	timer := time.NewTimer(time.Second)
+	defer timer.Stop()
+	select {
+	case info := <-r.readChan:
+		r.bookmarkLock.Lock()
+		r.bookmark = info.bookmark
+		r.bookmarkLock.Unlock()
+		return info.data, info.bytes, nil
+	case err := <-r.errChan:
+		return nil, 0, err
+	case <-timer.C:
+	}
+
+	return nil, 0, nil
+}
+
+func (r *Reader) Status() StatsInfo {
+	r.statsLock.RLock()
+	defer r.statsLock.RUnlock()
+	return r.stats
+}
+
+func (r *Reader) SyncMeta() {
+	r.bookmarkLock.RLock()
+	bookmark := r.bookmark
+	r.bookmarkLock.RUnlock()
+	if bookmark == "" {
+		return
+	}
+	if err := r.meta.WriteOffset(bookmark, 0); err != nil {
+		log.Errorf("Runner[%v] %v SyncMeta error %v", r.meta.RunnerName, r.Name(), err)
+	}
+}
+
+func (r *Reader) Close() error {
+	if !atomic.CompareAndSwapInt32(&r.status, StatusRunning, StatusStopping) {
+		log.Warnf("Runner[%v] reader %q is not running, close operation ignored", r.meta.RunnerName, r.Name())
+		return nil
+	}
+	log.Debugf("Runner[%v] %q daemon is stopping", r.meta.RunnerName, r.Name())
+	close(r.stopChan)
+	return nil
+}
@@ -0,0 +1,241 @@
+package wineventlog
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/reader"
+	. "github.com/qiniu/logkit/reader/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const testEvent = `<Event xmlns='http://schemas.microsoft.com/win/2004/08/events/event'>
+  <System>
+    <Provider Name='Microsoft-Windows-Security-Auditing' Guid='{54849625-5478-4994-a5ba-3e3b0328c30d}'/>
+    <EventID>4624</EventID>
+    <Version>2</Version>
+    <Level>0</Level>
+    <Task>12544</Task>
+    <Opcode>0</Opcode>
+    <Keywords>0x8020000000000000</Keywords>
+    <TimeCreated SystemTime='2019-01-02T03:04:05.1234567Z'/>
+    <EventRecordID>1024</EventRecordID>
+    <Correlation ActivityID='{a1b2c3d4-0000-0000-0000-000000000000}'/>
+    <Execution ProcessID='636' ThreadID='3704'/>
+    <Channel>Security</Channel>
+    <Computer>host-1</Computer>
+    <Security/>
+  </System>
+  <EventData>
+    <Data Name='SubjectUserSid'>S-1-5-18</Data>
+    <Data Name='LogonType'>5</Data>
+  </EventData>
+  <RenderingInfo Culture='en-US'>
+    <Message>An account was successfully logged on.</Message>
+    <Level>Information</Level>
+    <Task>Logon</Task>
+    <Opcode>Info</Opcode>
+    <Keywords>
+      <Keyword>Audit Success</Keyword>
+    </Keywords>
+  </RenderingInfo>
+</Event>`
+
+func TestParseEvent(t *testing.T) {
+	data, err := ParseEvent(testEvent)
+	assert.NoError(t, err)
+	assert.Equal(t, Data{
+		FieldChannel:     "Security",
+		FieldComputer:    "host-1",
+		FieldProvider:    "Microsoft-Windows-Security-Auditing",
+		FieldEventID:     int64(4624),
+		FieldVersion:     int64(2),
+		FieldLevel:       int64(0),
+		FieldLevelName:   "Information",
+		FieldTask:        int64(12544),
+		FieldTaskName:    "Logon",
+		FieldOpcode:      int64(0),
+		FieldOpcodeName:  "Info",
+		FieldKeywords:    []string{"Audit Success"},
+		FieldRecordID:    int64(1024),
+		FieldTimeCreated: "2019-01-02T03:04:05.1234567Z",
+		FieldProcessID:   int64(636),
+		FieldThreadID:    int64(3704),
+		FieldActivityID:  "{a1b2c3d4-0000-0000-0000-000000000000}",
+		FieldMessage:     "An account was successfully logged on.",
+		FieldEventData:   map[string]interface{}{"SubjectUserSid": "S-1-5-18", "LogonType": "5"},
+	}, data)
+
+	// 没有 RenderingInfo 时使用级别的数字说明，没有名称的 Data 依次命名，UserData 输出为子元素
+	data, err = ParseEvent(`<Event><System><Provider Name='Service Control Manager'/><EventID Qualifiers='16384'>7036</EventID>
+<Level>3</Level><Keywords>0x8080000000000000</Keywords><EventRecordID>7</EventRecordID><Channel>System</Channel>
+<Security UserID='S-1-5-18'/></System><EventData><Data>Print Spooler</Data><Data>stopped</Data><Binary>4C00</Binary></EventData>
+<UserData><LogFileCleared xmlns='http://manifests.microsoft.com/win/2004/08/windows/eventlog'><SubjectUserName>admin</SubjectUserName>
+<SubjectDomainName>WORKGROUP</SubjectDomainName></LogFileCleared></UserData></Event>`)
+	assert.NoError(t, err)
+	assert.Equal(t, Data{
+		FieldChannel:   "System",
+		FieldComputer:  "",
+		FieldProvider:  "Service Control Manager",
+		FieldEventID:   int64(7036),
+		FieldLevel:     int64(3),
+		FieldLevelName: "warning",
+		FieldKeywords:  "0x8080000000000000",
+		FieldRecordID:  int64(7),
+		FieldUserSID:   "S-1-5-18",
+		FieldEventData: map[string]interface{}{"param1": "Print Spooler", "param2": "stopped", "binary": "4C00"},
+		FieldUserData:  map[string]interface{}{"SubjectUserName": "admin", "SubjectDomainName": "WORKGROUP"},
+	}, data)
+
+	_, err = ParseEvent("not xml")
+	assert.Error(t, err)
+	_, err = ParseEvent("<Event><System><EventID>1</EventID></System></Event>")
+	assert.Error(t, err)
+}
+
+func TestBookmark(t *testing.T) {
+	bookmark, err := encodeBookmark(`<BookmarkList>
+  <Bookmark Channel='System' RecordId='42'/>
+  <Bookmark Channel='Windows PowerShell' RecordId='7' IsCurrent='true'/>
+</BookmarkList>`)
+	assert.NoError(t, err)
+	assert.Equal(t, "System=42&Windows+PowerShell=7", bookmark)
+	bookmarkXML, err := decodeBookmark(bookmark)
+	assert.NoError(t, err)
+	assert.Equal(t, "<BookmarkList><Bookmark Channel='System' RecordId='42' IsCurrent='true'/>"+
+		"<Bookmark Channel='Windows PowerShell' RecordId='7'/></BookmarkList>", bookmarkXML)
+
+	_, err = encodeBookmark("<BookmarkList>")
+	assert.Error(t, err)
+	_, err = decodeBookmark("%zz")
+	assert.Error(t, err)
+}
+
+func TestBuildQuery(t *testing.T) {
+	query, err := buildQuery([]string{"Application", "Microsoft-Windows-PowerShell/Operational"}, "*[System[Level<=3]]")
+	assert.NoError(t, err)
+	assert.Equal(t, `<QueryList><Query Id="0"><Select Path="Application">*[System[Level&lt;=3]]</Select>`+
+		`<Select Path="Microsoft-Windows-PowerShell/Operational">*[System[Level&lt;=3]]</Select></Query></QueryList>`, query)
+
+	query, err = buildQuery([]string{"System"}, "")
+	assert.NoError(t, err)
+	assert.Equal(t, `<QueryList><Query Id="0"><Select Path="System">*</Select></Query></QueryList>`, query)
+
+	list := `<QueryList><Query Id="0"><Select Path="Security">*[System[EventID=4624]]</Select></Query></QueryList>`
+	query, err = buildQuery(nil, list)
+	assert.NoError(t, err)
+	assert.Equal(t, list, query)
+
+	_, err = buildQuery(nil, "*")
+	assert.Error(t, err)
+}
+
+// fakeSubscription 依次返回 batches 中的事件，返回完后返回 err
+type fakeSubscription struct {
+	batches [][]rawEvent
+	err     error
+}
+
+func (s *fakeSubscription) next(timeout time.Duration) ([]rawEvent, error) {
+	if len(s.batches) == 0 {
+		if s.err != nil {
+			return nil, s.err
+		}
+		time.Sleep(timeout)
+		return nil, nil
+	}
+	events := s.batches[0]
+	s.batches = s.batches[1:]
+	return events, nil
+}
+
+func (s *fakeSubscription) close() error {
+	return nil
+}
+
+func TestWinEventLogReader(t *testing.T) {
+	metaDir := "TestWinEventLogReader"
+	defer os.RemoveAll(metaDir)
+
+	var lock sync.Mutex
+	var bookmarks []string
+	var oldest []bool
+	open := func(query, bookmark string, fromOldest bool) (subscription, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		bookmarks = append(bookmarks, bookmark)
+		oldest = append(oldest, fromOldest)
+		if len(bookmarks) == 1 {
+			return &fakeSubscription{batches: [][]rawEvent{
+				{{xml: testEvent, bookmark: "Security=1024"}},
+				{{bookmark: "Security=1025", err: errors.New("render event error")}},
+			}, err: errors.New("subscription is broken")}, nil
+		}
+		return &fakeSubscription{batches: [][]rawEvent{
+			{{xml: "<Event><System><EventRecordID>3</EventRecordID><Channel>Application</Channel></System></Event>", bookmark: "Application=3&Security=1025"}},
+		}}, nil
+	}
+	defer func(old func(string, string, bool) (subscription, error)) { openSubscription = old }(openSubscription)
+
+	c := conf.MapConf{
+		KeyMode:                  ModeWinEventLog,
+		KeyRunnerName:            "TestWinEventLogReader",
+		KeyMetaPath:              metaDir,
+		KeyWinEventLogChannels:   "Security, Application",
+		KeyWinEventLogIncludeXML: "true",
+		KeyWhence:                WhenceNewest,
+	}
+	openSubscription = nil
+	meta, err := reader.NewMetaWithConf(c)
+	assert.NoError(t, err)
+	_, err = NewReader(meta, c)
+	assert.Error(t, err)
+
+	openSubscription = open
+	rd, err := NewReader(meta, c)
+	assert.NoError(t, err)
+	r := rd.(*Reader)
+	assert.Equal(t, "wineventlog:Security,Application", r.Name())
+	assert.NoError(t, r.Start())
+	defer r.Close()
+
+	data, size, err := r.ReadData()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(testEvent)), size)
+	assert.Equal(t, int64(4624), data[FieldEventID])
+	assert.Equal(t, testEvent, data[FieldXML])
+	r.SyncMeta()
+	bookmark, _, err := meta.ReadOffset()
+	assert.NoError(t, err)
+	assert.Equal(t, "Security=1024", bookmark)
+
+	// 订阅出错后返回错误，并从最后一个事件(包括无法读取的事件)的书签之后重新订阅
+	_, _, err = r.ReadData()
+	assert.Error(t, err)
+	assert.Contains(t, r.Status().LastError, "subscription is broken")
+	for i := 0; i < 10 && data[FieldChannel] != "Application"; i++ {
+		data, _, err = r.ReadData()
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, "Application", data[FieldChannel])
+	lock.Lock()
+	assert.Equal(t, []string{"", "Security=1025"}, bookmarks)
+	assert.Equal(t, []bool{false, false}, oldest)
+	lock.Unlock()
+	r.SyncMeta()
+	bookmark, _, err = meta.ReadOffset()
+	assert.NoError(t, err)
+	assert.Equal(t, "Application=3&Security=1025", bookmark)
+	assert.NoError(t, r.Close())
+
+	// 重启后从 meta 中的书签继续
+	rd, err = NewReader(meta, c)
+	assert.NoError(t, err)
+	assert.Equal(t, "Application=3&Security=1025", rd.(*Reader).sentBookmark)
+}
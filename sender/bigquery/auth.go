@@ -0,0 +1,216 @@
+package bigquery
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qiniu/logkit/sender"
+	. "github.com/qiniu/logkit/sender/config"
+)
+
+const (
+	insertDataScope    = "https://www.googleapis.com/auth/bigquery.insertdata"
+	defaultTokenURI    = "https://oauth2.googleapis.com/token"
+	jwtBearerGrantType = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+	// 服务账号签发的 assertion 最长有效期为一小时
+	assertionLifetime = time.Hour
+	// 在 access token 过期之前提前刷新
+	tokenRefreshAhead = time.Minute
+)
+
+// 可以在测试中替换为本地的地址
+var metadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+// serviceAccount json 格式的服务账号密钥中用到的字段
+type serviceAccount struct {
+	Type         string `json:"type"`
+	ClientEmail  string `json:"client_email"`
+	PrivateKeyID string `json:"private_key_id"`
+	PrivateKey   string `json:"private_key"`
+	TokenURI     string `json:"token_uri"`
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// tokenSource 获取并缓存 access token，配置了服务账号密钥时使用签名的 JWT 换取，否则从 GCE 元数据服务获取
+type tokenSource struct {
+	client  *http.Client
+	account *serviceAccount
+	key     *rsa.PrivateKey
+
+	mutex  sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+func newTokenSource(credentialsFile string, client *http.Client) (*tokenSource, error) {
+	ts := &tokenSource{client: client}
+	if credentialsFile == "" {
+		return ts, nil
+	}
+	content, err := ioutil.ReadFile(credentialsFile)
+	if err != nil {
+		return nil, err
+	}
+	account := &serviceAccount{}
+	if err = json.Unmarshal(content, account); err != nil {
+		return nil, fmt.Errorf("parse credentials file %v error: %v", credentialsFile, err)
+	}
+	if account.Type != "" && account.Type != "service_account" {
+		return nil, fmt.Errorf("credentials type %q is not supported, only service_account is supported", account.Type)
+	}
+	if account.ClientEmail == "" || account.PrivateKey == "" {
+		return nil, fmt.Errorf("credentials file %v should contain client_email and private_key", credentialsFile)
+	}
+	if account.TokenURI == "" {
+		account.TokenURI = defaultTokenURI
+	}
+	if ts.key, err = parsePrivateKey(account.PrivateKey); err != nil {
+		return nil, err
+	}
+	ts.account = account
+	return ts, nil
+}
+
+func parsePrivateKey(s string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(s))
+	if block == nil {
+		return nil, errors.New("private_key is not in PEM format")
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		rsaKey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("private_key is not a RSA key")
+		}
+		return rsaKey, nil
+	}
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse private_key error: %v", err)
+	}
+	return key, nil
+}
+
+// Token 返回未过期的 access token，过期前自动刷新
+func (ts *tokenSource) Token() (string, error) {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	now := time.Now()
+	if ts.token != "" && now.Add(tokenRefreshAhead).Before(ts.expiry) {
+		return ts.token, nil
+	}
+	var (
+		resp *tokenResponse
+		err  error
+	)
+	if ts.account != nil {
+		resp, err = ts.exchangeJWT(now)
+	} else {
+		resp, err = ts.fromMetadata()
+	}
+	if err != nil {
+		return "", err
+	}
+	if resp.AccessToken == "" {
+		return "", errors.New("get access token error: access_token is empty")
+	}
+	ts.token = resp.AccessToken
+	ts.expiry = now.Add(time.Duration(resp.ExpiresIn) * time.Second)
+	return ts.token, nil
+}
+
+// Invalidate 服务端返回 401 时丢弃缓存的 token，下次重新获取
+func (ts *tokenSource) Invalidate() {
+	ts.mutex.Lock()
+	ts.token = ""
+	ts.mutex.Unlock()
+}
+
+func (ts *tokenSource) exchangeJWT(now time.Time) (*tokenResponse, error) {
+	assertion, err := ts.assertion(now)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{"grant_type": {jwtBearerGrantType}, "assertion": {assertion}}
+	resp, err := ts.client.PostForm(ts.account.TokenURI, form)
+	if err != nil {
+		return nil, err
+	}
+	return parseTokenResponse(resp)
+}
+
+func (ts *tokenSource) fromMetadata() (*tokenResponse, error) {
+	req, err := http.NewRequest(http.MethodGet, metadataTokenURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := ts.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("get access token from metadata server error: %v, %v is required outside GCE", err, KeyBigQueryCredentialsFile)
+	}
+	return parseTokenResponse(resp)
+}
+
+func parseTokenResponse(resp *http.Response) (*tokenResponse, error) {
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		// 密钥错误或者被禁用时返回 400/401，都按认证失败处理
+		code := resp.StatusCode
+		if code >= 400 && code < 500 {
+			code = http.StatusUnauthorized
+		}
+		return nil, &sender.HTTPStatusError{StatusCode: code, Body: "get access token error: " + strings.TrimSpace(string(body))}
+	}
+	ret := &tokenResponse{}
+	if err = json.Unmarshal(body, ret); err != nil {
+		return nil, fmt.Errorf("parse access token response error: %v", err)
+	}
+	return ret, nil
+}
+
+// assertion 生成 RS256 签名的 JWT
+func (ts *tokenSource) assertion(now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": ts.account.PrivateKeyID})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":   ts.account.ClientEmail,
+		"scope": insertDataScope,
+		"aud":   ts.account.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(assertionLifetime).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, ts.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
@@ -0,0 +1,513 @@
+package bigquery
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/qiniu/log"
+	"github.com/qiniu/pandora-go-sdk/base/reqerr"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/sender"
+	. "github.com/qiniu/logkit/sender/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+var _ sender.SkipDeepCopySender = &Sender{}
+
+const (
+	DefaultBigQueryEndpoint   = "https://bigquery.googleapis.com"
+	DefaultBigQueryBatchSize  = 500
+	DefaultBigQueryMaxRetries = 3
+
+	// insertAll 单次请求最大 10MB，留出请求体其余部分的余量
+	maxRequestBytes = 9 * MB
+)
+
+// 重试的初始等待时间，之后每次翻倍，测试时可以修改
+var retryInterval = time.Second
+
+func init() {
+	sender.RegisterConstructor(TypeBigQuery, NewSender)
+}
+
+// column bigquery_schema 中一个字段与列的对应关系
+type column struct {
+	field string
+	name  string
+	typ   string
+}
+
+// Sender 通过 tabledata.insertAll 流式写入 BigQuery，超过配额、限流或者服务端错误时在 sender 内部按指数退避重试，
+// 单条数据格式或者类型不符合表结构时只拒绝该条数据，其余数据正常写入
+type Sender struct {
+	name          string
+	runnerName    string
+	insertURL     string
+	schema        []column
+	insertIDField string
+	ignoreUnknown bool
+	batchSize     int
+	maxRetries    int
+
+	client *http.Client
+	tokens *tokenSource
+}
+
+func NewSender(c conf.MapConf) (sender.Sender, error) {
+	project, err := c.GetString(KeyBigQueryProject)
+	if err != nil {
+		return nil, err
+	}
+	dataset, err := c.GetString(KeyBigQueryDataset)
+	if err != nil {
+		return nil, err
+	}
+	table, err := c.GetString(KeyBigQueryTable)
+	if err != nil {
+		return nil, err
+	}
+	endpoint, _ := c.GetStringOr(KeyBigQueryEndpoint, DefaultBigQueryEndpoint)
+	credentialsFile, _ := c.GetStringOr(KeyBigQueryCredentialsFile, "")
+	schemaStr, _ := c.GetStringOr(KeyBigQuerySchema, "")
+	schema, err := parseSchema(schemaStr)
+	if err != nil {
+		return nil, err
+	}
+	insertIDField, _ := c.GetStringOr(KeyBigQueryInsertIDField, "")
+	ignoreUnknown, _ := c.GetBoolOr(KeyBigQueryIgnoreUnknownValues, false)
+	batchSize, _ := c.GetIntOr(KeyBigQueryBatchSize, DefaultBigQueryBatchSize)
+	if batchSize <= 0 {
+		return nil, fmt.Errorf("%v must be positive", KeyBigQueryBatchSize)
+	}
+	maxRetries, _ := c.GetIntOr(KeyBigQueryMaxRetries, DefaultBigQueryMaxRetries)
+	if maxRetries < 0 {
+		return nil, fmt.Errorf("%v should not be negative", KeyBigQueryMaxRetries)
+	}
+	timeout, _ := c.GetStringOr(KeyHttpTimeout, "30s")
+	dur, err := time.ParseDuration(timeout)
+	if err != nil {
+		return nil, errors.New("timeout configure " + timeout + " is invalid")
+	}
+	runnerName, _ := c.GetStringOr(KeyRunnerName, UnderfinedRunnerName)
+	name, _ := c.GetStringOr(KeyName, fmt.Sprintf("bigquerySender:(%v.%v.%v)", project, dataset, table))
+
+	client := &http.Client{Timeout: dur}
+	tokens, err := newTokenSource(credentialsFile, client)
+	if err != nil {
+		return nil, err
+	}
+	return &Sender{
+		name:       name,
+		runnerName: runnerName,
+		insertURL: fmt.Sprintf("%v/bigquery/v2/projects/%v/datasets/%v/tables/%v/insertAll", strings.TrimRight(endpoint, "/"),
+			url.PathEscape(project), url.PathEscape(dataset), url.PathEscape(table)),
+		schema:        schema,
+		insertIDField: insertIDField,
+		ignoreUnknown: ignoreUnknown,
+		batchSize:     batchSize,
+		maxRetries:    maxRetries,
+		client:        client,
+		tokens:        tokens,
+	}, nil
+}
+
+// parseSchema 解析 "字段名 [列名] 类型" 的列表
+func parseSchema(s string) ([]column, error) {
+	var schema []column
+	for _, item := range strings.Split(s, ",") {
+		parts := strings.Fields(item)
+		var col column
+		switch len(parts) {
+		case 0:
+			continue
+		case 2:
+			col = column{field: parts[0], name: parts[0], typ: parts[1]}
+		case 3:
+			col = column{field: parts[0], name: parts[1], typ: parts[2]}
+		default:
+			return nil, fmt.Errorf("%v %q is invalid, should be like \"field [column] TYPE\"", KeyBigQuerySchema, strings.TrimSpace(item))
+		}
+		switch col.typ = strings.ToUpper(col.typ); col.typ {
+		case "STRING", "INTEGER", "FLOAT", "NUMERIC", "BOOLEAN", "TIMESTAMP", "DATE", "DATETIME", "JSON":
+		case "INT64":
+			col.typ = "INTEGER"
+		case "FLOAT64":
+			col.typ = "FLOAT"
+		case "BOOL":
+			col.typ = "BOOLEAN"
+		default:
+			return nil, fmt.Errorf("%v type %q of column %v is not supported", KeyBigQuerySchema, parts[len(parts)-1], col.name)
+		}
+		schema = append(schema, col)
+	}
+	return schema, nil
+}
+
+func (s *Sender) Name() string {
+	return s.name
+}
+
+type insertRow struct {
+	InsertID string          `json:"insertId,omitempty"`
+	JSON     json.RawMessage `json:"json"`
+}
+
+type insertRequest struct {
+	SkipInvalidRows     bool        `json:"skipInvalidRows"`
+	IgnoreUnknownValues bool        `json:"ignoreUnknownValues"`
+	Rows                []insertRow `json:"rows"`
+}
+
+type errorProto struct {
+	Reason   string `json:"reason"`
+	Location string `json:"location"`
+	Message  string `json:"message"`
+}
+
+type insertResponse struct {
+	InsertErrors []struct {
+		Index  int          `json:"index"`
+		Errors []errorProto `json:"errors"`
+	} `json:"insertErrors"`
+}
+
+type errorResponse struct {
+	Error struct {
+		Code    int          `json:"code"`
+		Message string       `json:"message"`
+		Errors  []errorProto `json:"errors"`
+	} `json:"error"`
+}
+
+// Send 按 bigquery_batch_size 和请求大小分批写入，被 BigQuery 拒绝的数据不再重试，
+// 如果同时还有需要重试的数据，被拒绝的数据只计入错误数并记录日志
+func (s *Sender) Send(datas []Data) error {
+	var (
+		se       = &StatsError{}
+		rows     = make([]insertRow, 0, len(datas))
+		rowDatas = make([]Data, 0, len(datas))
+		rejected []Data
+		failed   []Data
+		lastErr  error
+		failType = reqerr.TypeDefault
+	)
+	for _, d := range datas {
+		row, err := s.convert(d)
+		if err != nil {
+			rejected = append(rejected, d)
+			lastErr = err
+			continue
+		}
+		rows = append(rows, row)
+		rowDatas = append(rowDatas, d)
+	}
+	for start := 0; start < len(rows); {
+		end, size := start, 0
+		for end < len(rows) && end-start < s.batchSize && (end == start || size+len(rows[end].JSON) <= maxRequestBytes) {
+			size += len(rows[end].JSON)
+			end++
+		}
+		result := s.insertBatch(rows[start:end])
+		for _, i := range result.rejected {
+			rejected = append(rejected, rowDatas[start+i])
+		}
+		for _, i := range result.failed {
+			failed = append(failed, rowDatas[start+i])
+		}
+		if result.err != nil {
+			lastErr = result.err
+			if len(result.failed) > 0 {
+				failType = sender.ErrorTypeOf(result.err)
+			}
+		}
+		start = end
+	}
+	se.Success = int64(len(datas) - len(rejected) - len(failed))
+	se.Errors = int64(len(rejected) + len(failed))
+	if se.Errors == 0 {
+		return nil
+	}
+	se.LastError = lastErr.Error()
+	switch {
+	case len(failed) > 0:
+		if len(rejected) > 0 {
+			log.Errorf("Runner[%v] Sender[%v] %d records are rejected by BigQuery and dropped, last error: %v", s.runnerName, s.Name(), len(rejected), lastErr)
+		}
+		se.SendError = reqerr.NewSendError("insert into BigQuery failed, last error is: "+lastErr.Error(), sender.ConvertDatasBack(failed), failType)
+	default:
+		se.SendError = reqerr.NewSendError("records are rejected by BigQuery, last error is: "+lastErr.Error(), sender.ConvertDatasBack(rejected), sender.TypeRejected)
+	}
+	return se
+}
+
+// convert 按 bigquery_schema 映射并转换数据，没有配置时原样写入
+func (s *Sender) convert(d Data) (insertRow, error) {
+	var row insertRow
+	if s.insertIDField != "" {
+		if v, ok := d[s.insertIDField]; ok && v != nil {
+			row.InsertID = fmt.Sprint(v)
+		}
+	}
+	var (
+		value interface{} = map[string]interface{}(d)
+		err   error
+	)
+	if len(s.schema) > 0 {
+		m := make(map[string]interface{}, len(s.schema))
+		for _, col := range s.schema {
+			v, ok := d[col.field]
+			if !ok || v == nil {
+				continue
+			}
+			if m[col.name], err = convertValue(v, col.typ); err != nil {
+				return row, fmt.Errorf("convert field %v to %v column %v error: %v", col.field, col.typ, col.name, err)
+			}
+		}
+		value = m
+	}
+	if row.JSON, err = json.Marshal(value); err != nil {
+		return row, err
+	}
+	return row, nil
+}
+
+func convertValue(v interface{}, typ string) (interface{}, error) {
+	switch typ {
+	case "STRING":
+		switch x := v.(type) {
+		case string:
+			return x, nil
+		case []byte:
+			return string(x), nil
+		case time.Time:
+			return x.Format(time.RFC3339Nano), nil
+		case map[string]interface{}, []interface{}, Data:
+			b, err := json.Marshal(x)
+			return string(b), err
+		}
+		return fmt.Sprint(v), nil
+	case "INTEGER":
+		switch x := v.(type) {
+		case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+			return x, nil
+		case float32:
+			return int64(x), nil
+		case float64:
+			return int64(x), nil
+		case json.Number:
+			return x.Int64()
+		case string:
+			return strconv.ParseInt(strings.TrimSpace(x), 10, 64)
+		}
+	case "FLOAT", "NUMERIC":
+		switch x := v.(type) {
+		case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+			return x, nil
+		case json.Number:
+			return x.Float64()
+		case string:
+			return strconv.ParseFloat(strings.TrimSpace(x), 64)
+		}
+	case "BOOLEAN":
+		switch x := v.(type) {
+		case bool:
+			return x, nil
+		case string:
+			return strconv.ParseBool(strings.TrimSpace(x))
+		}
+	case "TIMESTAMP":
+		switch x := v.(type) {
+		case time.Time:
+			return x.Format(time.RFC3339Nano), nil
+		case string:
+			return x, nil
+		case int, int32, int64, float32, float64, json.Number:
+			// 数字为 unix 时间戳，单位为秒
+			return x, nil
+		}
+	case "DATE":
+		switch x := v.(type) {
+		case time.Time:
+			return x.Format("2006-01-02"), nil
+		case string:
+			return x, nil
+		}
+	case "DATETIME":
+		switch x := v.(type) {
+		case time.Time:
+			return x.Format("2006-01-02 15:04:05.999999"), nil
+		case string:
+			return x, nil
+		}
+	case "JSON":
+		if x, ok := v.(string); ok {
+			return x, nil
+		}
+		b, err := json.Marshal(v)
+		return string(b), err
+	}
+	return nil, fmt.Errorf("value %v(%T) can not be converted", v, v)
+}
+
+type batchResult struct {
+	rejected []int // 被 BigQuery 拒绝的数据在批次中的下标
+	failed   []int // 重试后仍然失败的数据在批次中的下标
+	err      error
+}
+
+// insertBatch 写入一批数据，遇到可以重试的错误时按指数退避重试，
+// 因为同批次中其他数据无效而没有写入的数据也会重新写入
+func (s *Sender) insertBatch(rows []insertRow) (ret batchResult) {
+	pending := make([]int, len(rows))
+	for i := range pending {
+		pending[i] = i
+	}
+	wait := retryInterval
+	for retries := 0; ; retries++ {
+		reqRows := make([]insertRow, len(pending))
+		for i, idx := range pending {
+			reqRows[i] = rows[idx]
+		}
+		resp, retryable, err := s.insertAll(reqRows)
+		if err == nil {
+			var stopped []int
+			for _, ie := range resp.InsertErrors {
+				if ie.Index < 0 || ie.Index >= len(pending) {
+					continue
+				}
+				if rowRetryable(ie.Errors) {
+					stopped = append(stopped, pending[ie.Index])
+					continue
+				}
+				ret.rejected = append(ret.rejected, pending[ie.Index])
+				ret.err = fmt.Errorf("row rejected: %v", describeErrors(ie.Errors))
+			}
+			if len(stopped) == 0 {
+				return ret
+			}
+			pending = stopped
+			retryable = true
+			err = fmt.Errorf("%d rows are not inserted, should retry", len(stopped))
+		}
+		if !retryable || retries >= s.maxRetries {
+			ret.failed = pending
+			ret.err = err
+			return ret
+		}
+		if !IsSelfRunner(s.runnerName) {
+			log.Warnf("Runner[%v] Sender[%v] insert %d rows error %v, retry after %v", s.runnerName, s.Name(), len(pending), err, wait)
+		} else {
+			log.Debugf("Runner[%v] Sender[%v] insert %d rows error %v, retry after %v", s.runnerName, s.Name(), len(pending), err, wait)
+		}
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+// insertAll 发送一次写入请求，返回的 retryable 表示请求整体失败但是可以重试
+func (s *Sender) insertAll(rows []insertRow) (*insertResponse, bool, error) {
+	body, err := json.Marshal(insertRequest{
+		SkipInvalidRows:     true,
+		IgnoreUnknownValues: s.ignoreUnknown,
+		Rows:                rows,
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	token, err := s.tokens.Token()
+	if err != nil {
+		// 获取 token 的网络错误可以重试，认证失败不重试
+		_, isStatus := err.(*sender.HTTPStatusError)
+		return nil, !isStatus, err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.insertURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, false, err
+	}
+	req.Header.Set(ContentTypeHeader, ApplicationJson)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, true, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, true, err
+	}
+	if resp.StatusCode == http.StatusOK {
+		ret := &insertResponse{}
+		if err = json.Unmarshal(respBody, ret); err != nil {
+			return nil, false, fmt.Errorf("parse insertAll response error: %v", err)
+		}
+		return ret, false, nil
+	}
+
+	errResp := &errorResponse{}
+	json.Unmarshal(respBody, errResp)
+	msg := strings.TrimSpace(string(respBody))
+	if errResp.Error.Message != "" {
+		msg = errResp.Error.Message
+	}
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		s.tokens.Invalidate()
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return nil, true, &sender.HTTPStatusError{StatusCode: resp.StatusCode, Body: msg}
+	case resp.StatusCode == http.StatusForbidden && quotaExceeded(errResp.Error.Errors):
+		// 配额不足时返回 403，不是认证失败，重试之后仍然失败时交给上层重试
+		return nil, true, fmt.Errorf("quota exceeded: %v", msg)
+	}
+	return nil, false, &sender.HTTPStatusError{StatusCode: resp.StatusCode, Body: msg}
+}
+
+func quotaExceeded(errs []errorProto) bool {
+	for _, e := range errs {
+		if e.Reason == "quotaExceeded" || e.Reason == "rateLimitExceeded" {
+			return true
+		}
+	}
+	return false
+}
+
+// rowRetryable stopped 表示该条数据有效，只是因为同批次中其他数据无效而没有写入
+func rowRetryable(errs []errorProto) bool {
+	if len(errs) == 0 {
+		return true
+	}
+	for _, e := range errs {
+		switch e.Reason {
+		case "stopped", "backendError", "timeout", "internalError":
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func describeErrors(errs []errorProto) string {
+	msgs := make([]string, 0, len(errs))
+	for _, e := range errs {
+		msg := e.Reason + ": " + e.Message
+		if e.Location != "" {
+			msg += " (" + e.Location + ")"
+		}
+		msgs = append(msgs, msg)
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (s *Sender) Close() error {
+	return nil
+}
+
+func (*Sender) SkipDeepCopy() bool { return true }
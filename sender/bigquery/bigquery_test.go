@@ -0,0 +1,279 @@
+package bigquery
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/sender"
+	. "github.com/qiniu/logkit/sender/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const insertPath = "/bigquery/v2/projects/p1/datasets/d1/tables/t1/insertAll"
+
+// mockBigQuery 同时模拟 token 接口和 insertAll 接口，handle 返回 insertAll 的状态码和响应
+type mockBigQuery struct {
+	t      *testing.T
+	key    *rsa.PrivateKey
+	server *httptest.Server
+
+	mutex    sync.Mutex
+	tokens   int
+	requests []insertRequest
+	handle   func(n int, req insertRequest) (int, string)
+}
+
+func newMockBigQuery(t *testing.T) *mockBigQuery {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	m := &mockBigQuery{t: t, key: key}
+	m.server = httptest.NewServer(http.HandlerFunc(m.serve))
+	return m
+}
+
+func (m *mockBigQuery) serve(w http.ResponseWriter, r *http.Request) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	switch r.URL.Path {
+	case "/token":
+		assert.NoError(m.t, r.ParseForm())
+		assert.Equal(m.t, jwtBearerGrantType, r.PostForm.Get("grant_type"))
+		m.verifyAssertion(r.PostForm.Get("assertion"))
+		m.tokens++
+		w.Write([]byte(`{"access_token":"token1","expires_in":3600,"token_type":"Bearer"}`))
+	case insertPath:
+		assert.Equal(m.t, "Bearer token1", r.Header.Get("Authorization"))
+		var req insertRequest
+		body, _ := ioutil.ReadAll(r.Body)
+		assert.NoError(m.t, json.Unmarshal(body, &req))
+		m.requests = append(m.requests, req)
+		code, resp := http.StatusOK, `{"kind":"bigquery#tableDataInsertAllResponse"}`
+		if m.handle != nil {
+			code, resp = m.handle(len(m.requests), req)
+		}
+		w.WriteHeader(code)
+		w.Write([]byte(resp))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (m *mockBigQuery) verifyAssertion(assertion string) {
+	parts := strings.Split(assertion, ".")
+	if !assert.Len(m.t, parts, 3) {
+		return
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	assert.NoError(m.t, err)
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	assert.NoError(m.t, rsa.VerifyPKCS1v15(&m.key.PublicKey, crypto.SHA256, digest[:], sig))
+	claims, err := base64.RawURLEncoding.DecodeString(parts[1])
+	assert.NoError(m.t, err)
+	var c map[string]interface{}
+	assert.NoError(m.t, json.Unmarshal(claims, &c))
+	assert.Equal(m.t, "logkit@p1.iam.gserviceaccount.com", c["iss"])
+	assert.Equal(m.t, insertDataScope, c["scope"])
+	assert.Equal(m.t, m.server.URL+"/token", c["aud"])
+}
+
+// credentials 在 dir 中写入服务账号密钥文件
+func (m *mockBigQuery) credentials(dir string) string {
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(m.key)
+	require.NoError(m.t, err)
+	content, err := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"client_email":   "logkit@p1.iam.gserviceaccount.com",
+		"private_key_id": "key1",
+		"private_key":    string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes})),
+		"token_uri":      m.server.URL + "/token",
+	})
+	require.NoError(m.t, err)
+	path := filepath.Join(dir, "credentials.json")
+	require.NoError(m.t, ioutil.WriteFile(path, content, 0600))
+	return path
+}
+
+func (m *mockBigQuery) conf(dir string) conf.MapConf {
+	return conf.MapConf{
+		KeyBigQueryProject:         "p1",
+		KeyBigQueryDataset:         "d1",
+		KeyBigQueryTable:           "t1",
+		KeyBigQueryCredentialsFile: m.credentials(dir),
+		KeyBigQueryEndpoint:        m.server.URL,
+		KeySenderType:              TypeBigQuery,
+	}
+}
+
+func rowsOf(t *testing.T, req insertRequest) []map[string]interface{} {
+	var ret []map[string]interface{}
+	for _, row := range req.Rows {
+		var m map[string]interface{}
+		assert.NoError(t, json.Unmarshal(row.JSON, &m))
+		ret = append(ret, m)
+	}
+	return ret
+}
+
+func TestBigQuerySender(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestBigQuerySender")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	m := newMockBigQuery(t)
+	defer m.server.Close()
+
+	c := m.conf(dir)
+	c[KeyBigQuerySchema] = "host hostname STRING, cost INTEGER, ok BOOLEAN, time ts TIMESTAMP, detail JSON"
+	c[KeyBigQueryInsertIDField] = "id"
+	c[KeyBigQueryBatchSize] = "2"
+	s, err := NewSender(c)
+	require.NoError(t, err)
+	assert.Equal(t, "bigquerySender:(p1.d1.t1)", s.Name())
+
+	ts := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	err = s.Send([]Data{
+		{"id": "a", "host": "h1", "cost": "12", "ok": "true", "time": ts, "detail": map[string]interface{}{"k": "v"}, "extra": 1},
+		{"id": 2, "host": "h2", "cost": 3.0},
+		{"host": "h3"},
+	})
+	assert.NoError(t, err)
+	require.Len(t, m.requests, 2)
+	assert.Equal(t, 1, m.tokens)
+	assert.True(t, m.requests[0].SkipInvalidRows)
+	assert.False(t, m.requests[0].IgnoreUnknownValues)
+	assert.Equal(t, "a", m.requests[0].Rows[0].InsertID)
+	assert.Equal(t, "2", m.requests[0].Rows[1].InsertID)
+	assert.Equal(t, "", m.requests[1].Rows[0].InsertID)
+	assert.Equal(t, []map[string]interface{}{
+		{"hostname": "h1", "cost": float64(12), "ok": true, "ts": "2026-01-02T03:04:05Z", "detail": `{"k":"v"}`},
+		{"hostname": "h2", "cost": float64(3)},
+	}, rowsOf(t, m.requests[0]))
+	assert.Equal(t, []map[string]interface{}{{"hostname": "h3"}}, rowsOf(t, m.requests[1]))
+
+	// 不配置 schema 时原样写入，token 缓存复用
+	c = m.conf(dir)
+	c[KeyBigQueryIgnoreUnknownValues] = "true"
+	s, err = NewSender(c)
+	require.NoError(t, err)
+	assert.NoError(t, s.Send([]Data{{"a": "b", "n": 1}}))
+	require.Len(t, m.requests, 3)
+	assert.True(t, m.requests[2].IgnoreUnknownValues)
+	assert.Equal(t, []map[string]interface{}{{"a": "b", "n": float64(1)}}, rowsOf(t, m.requests[2]))
+}
+
+func TestBigQuerySenderRetry(t *testing.T) {
+	retryInterval = time.Millisecond
+	dir, err := ioutil.TempDir("", "TestBigQuerySenderRetry")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	m := newMockBigQuery(t)
+	defer m.server.Close()
+
+	// 配额不足和限流时在 sender 内部重试
+	m.handle = func(n int, req insertRequest) (int, string) {
+		switch n {
+		case 1:
+			return http.StatusForbidden, `{"error":{"code":403,"message":"Exceeded rate limits","errors":[{"reason":"quotaExceeded","message":"Exceeded rate limits"}]}}`
+		case 2:
+			return http.StatusTooManyRequests, `{"error":{"code":429,"message":"too many requests"}}`
+		}
+		return http.StatusOK, `{}`
+	}
+	s, err := NewSender(m.conf(dir))
+	require.NoError(t, err)
+	assert.NoError(t, s.Send([]Data{{"a": 1}}))
+	assert.Len(t, m.requests, 3)
+
+	// 超过重试次数后交给上层重试
+	m.requests = nil
+	m.handle = func(n int, req insertRequest) (int, string) {
+		return http.StatusServiceUnavailable, `{"error":{"code":503,"message":"backend error"}}`
+	}
+	err = s.Send([]Data{{"a": 1}, {"a": 2}})
+	assert.Len(t, m.requests, DefaultBigQueryMaxRetries+1)
+	se, ok := err.(*StatsError)
+	require.True(t, ok)
+	assert.Equal(t, int64(2), se.Errors)
+	assert.Equal(t, sender.ErrorClassRetryable, sender.ClassifyError(err))
+	assert.Len(t, se.SendError.GetFailDatas(), 2)
+
+	// 认证失败不在 sender 内部重试
+	m.requests = nil
+	m.handle = func(n int, req insertRequest) (int, string) {
+		return http.StatusForbidden, `{"error":{"code":403,"message":"Access Denied","errors":[{"reason":"accessDenied"}]}}`
+	}
+	err = s.Send([]Data{{"a": 1}})
+	assert.Len(t, m.requests, 1)
+	assert.Equal(t, sender.ErrorClassAuth, sender.ClassifyError(err))
+}
+
+func TestBigQuerySenderRejected(t *testing.T) {
+	retryInterval = time.Millisecond
+	dir, err := ioutil.TempDir("", "TestBigQuerySenderRejected")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	m := newMockBigQuery(t)
+	defer m.server.Close()
+
+	c := m.conf(dir)
+	c[KeyBigQuerySchema] = "cost INTEGER"
+	s, err := NewSender(c)
+	require.NoError(t, err)
+
+	// 第二条数据被拒绝，第三条数据需要重新写入
+	m.handle = func(n int, req insertRequest) (int, string) {
+		if n == 1 {
+			return http.StatusOK, `{"insertErrors":[{"index":1,"errors":[{"reason":"invalid","location":"cost","message":"out of range"}]},{"index":2,"errors":[{"reason":"stopped"}]}]}`
+		}
+		return http.StatusOK, `{}`
+	}
+	err = s.Send([]Data{{"cost": 1}, {"cost": 2}, {"cost": 3}, {"cost": "abc"}})
+	require.Len(t, m.requests, 2)
+	assert.Equal(t, []map[string]interface{}{{"cost": float64(3)}}, rowsOf(t, m.requests[1]))
+	se, ok := err.(*StatsError)
+	require.True(t, ok)
+	assert.Equal(t, int64(2), se.Success)
+	assert.Equal(t, int64(2), se.Errors)
+	assert.Equal(t, sender.ErrorClassRejected, sender.ClassifyError(err))
+	assert.Equal(t, sender.TypeRejected, se.SendError.ErrorType)
+	assert.Equal(t, []map[string]interface{}{{"cost": "abc"}, {"cost": 2}}, se.SendError.GetFailDatas())
+	assert.Contains(t, se.LastError, "out of range")
+}
+
+func TestParseSchema(t *testing.T) {
+	schema, err := parseSchema("a STRING, b c int64,, d bool")
+	assert.NoError(t, err)
+	assert.Equal(t, []column{{"a", "a", "STRING"}, {"b", "c", "INTEGER"}, {"d", "d", "BOOLEAN"}}, schema)
+
+	_, err = parseSchema("a")
+	assert.Error(t, err)
+	_, err = parseSchema("a b c d")
+	assert.Error(t, err)
+	_, err = parseSchema("a RECORD")
+	assert.Error(t, err)
+
+	_, err = NewSender(conf.MapConf{KeyBigQueryProject: "p1", KeyBigQueryDataset: "d1"})
+	assert.Error(t, err)
+	_, err = NewSender(conf.MapConf{KeyBigQueryProject: "p1", KeyBigQueryDataset: "d1", KeyBigQueryTable: "t1", KeyBigQueryBatchSize: "0"})
+	assert.Error(t, err)
+	_, err = NewSender(conf.MapConf{KeyBigQueryProject: "p1", KeyBigQueryDataset: "d1", KeyBigQueryTable: "t1", KeyBigQueryCredentialsFile: "not_exist.json"})
+	assert.Error(t, err)
+}
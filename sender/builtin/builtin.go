@@ -1,6 +1,7 @@
 package builtin
 
 import (
+	_ "github.com/qiniu/logkit/sender/bigquery"
 	_ "github.com/qiniu/logkit/sender/csv"
 	_ "github.com/qiniu/logkit/sender/discard"
 	_ "github.com/qiniu/logkit/sender/elasticsearch"
@@ -8,9 +9,12 @@ import (
 	_ "github.com/qiniu/logkit/sender/http"
 	_ "github.com/qiniu/logkit/sender/influxdb"
 	_ "github.com/qiniu/logkit/sender/kafka"
+	_ "github.com/qiniu/logkit/sender/kodo"
+	_ "github.com/qiniu/logkit/sender/localstore"
 	_ "github.com/qiniu/logkit/sender/mock"
 	_ "github.com/qiniu/logkit/sender/mongodb"
 	_ "github.com/qiniu/logkit/sender/mysql"
+	_ "github.com/qiniu/logkit/sender/notify"
 	_ "github.com/qiniu/logkit/sender/open_falcon"
 	_ "github.com/qiniu/logkit/sender/pandora"
 	_ "github.com/qiniu/logkit/sender/sqlfile"
@@ -22,6 +22,10 @@ var ModeUsages = KeyValueSlice{
 	{TypeSQLFile, "SqlFile文件", ""},
 	{TypeCSV, "CSV文件", ""},
 	{TypeOpenFalconTransfer, "open-falcon 平台", ""},
+	{TypeKodo, "七牛对象存储(Kodo)", ""},
+	{TypeNotify, "飞书/钉钉/企业微信群机器人通知", ""},
+	{TypeBigQuery, "Google BigQuery", ""},
+	{TypeLocalStore, "本地短期存储(可查询)", ""},
 }
 
 var (
@@ -44,6 +48,25 @@ var (
 		Advance:      true,
 		ToolTip:      `为了避免速率太快导致磁盘压力加大，可以根据系统情况自行限定写入本地磁盘的速率，单位MB/s`,
 	}
+	OptionFtRateLimit = Option{
+		KeyName:      KeyFtRateLimit,
+		ChooseOnly:   false,
+		Default:      "",
+		DefaultNoUse: false,
+		Description:  "发送限速(ft_rate_limit)",
+		CheckRegex:   "\\d+",
+		Advance:      true,
+		ToolTip:      `发送数据的速率限制，单位KB/s，不填或0为不限速，当前时间命中 ft_rate_schedule 中的时间段时以时间段的配置为准`,
+	}
+	OptionFtRateSchedule = Option{
+		KeyName:      KeyFtRateSchedule,
+		ChooseOnly:   false,
+		Default:      "",
+		DefaultNoUse: false,
+		Description:  "分时段发送限速(ft_rate_schedule)",
+		Advance:      true,
+		ToolTip:      `按时间段设置发送速率，多个时间段以逗号分隔，格式为 HH:MM-HH:MM=限速，单位KB/s，0为不限速，如 00:00-06:00=0 表示凌晨全速发送，支持跨零点的时间段`,
+	}
 	OptionFtStrategy = Option{
 		KeyName:       KeyFtStrategy,
 		ChooseOnly:    true,
@@ -163,6 +186,76 @@ var (
 		Secret:        true,
 		Advance:       true,
 	}
+	OptionShardEndpoints = Option{
+		KeyName:      KeyShardEndpoints,
+		ChooseOnly:   false,
+		Default:      "",
+		DefaultNoUse: false,
+		Description:  "多下游分发(shard_endpoints)",
+		Advance:      true,
+		ToolTip:      `配置多个等价的下游进行水平扩展，json 数组，每一项为该下游需要覆盖的配置，如 [{"kafka_host":"a:9092"},{"kafka_host":"b:9092"}]，不填则只发送到一个下游`,
+	}
+	OptionShardStrategy = Option{
+		KeyName:       KeyShardStrategy,
+		ChooseOnly:    true,
+		ChooseOptions: []interface{}{ShardStrategyRoundRobin, ShardStrategyHash},
+		Default:       ShardStrategyRoundRobin,
+		DefaultNoUse:  false,
+		Description:   "多下游分发策略(shard_strategy)",
+		Advance:       true,
+		AdvanceDepend: KeyShardEndpoints,
+		ToolTip:       `round_robin 为每批数据轮流发送到各个下游，hash 为按 shard_field 字段的值分发，相同值的数据发送到同一个下游`,
+	}
+	OptionShardField = Option{
+		KeyName:       KeyShardField,
+		ChooseOnly:    false,
+		Default:       "",
+		DefaultNoUse:  false,
+		Description:   "多下游分发字段(shard_field)",
+		Advance:       true,
+		AdvanceDepend: KeyShardEndpoints,
+		ToolTip:       `hash 策略下用于计算分片的字段，不填时按整条数据计算`,
+	}
+	OptionShardUnhealthyInterval = Option{
+		KeyName:       KeyShardUnhealthyInterval,
+		ChooseOnly:    false,
+		Default:       "30s",
+		DefaultNoUse:  false,
+		Description:   "下游失败后排除时长(shard_unhealthy_interval)",
+		Advance:       true,
+		AdvanceDepend: KeyShardEndpoints,
+		ToolTip:       `下游发送失败后在该时长内不再分发数据，其数据由其他健康的下游发送，到期后自动恢复`,
+	}
+	OptionProtobufDescriptor = Option{
+		KeyName:      KeyProtobufDescriptor,
+		Element:      Text,
+		ChooseOnly:   false,
+		Default:      "",
+		Placeholder:  "message Log { string host = 1; int64 ts = 2; }",
+		DefaultNoUse: true,
+		Description:  "protobuf定义(protobuf_descriptor)",
+		Advance:      true,
+		ToolTip:      `以 protobuf 格式发送时必填，填写 .proto 中的 message 定义或 .proto 文件的路径，支持标量类型、enum、嵌套 message、repeated、map 和 oneof`,
+	}
+	OptionProtobufMessage = Option{
+		KeyName:      KeyProtobufMessage,
+		ChooseOnly:   false,
+		Default:      "",
+		DefaultNoUse: false,
+		Description:  "protobuf消息名称(protobuf_message)",
+		Advance:      true,
+		ToolTip:      `以 protobuf 格式发送时使用的 message，不填时使用定义中的第一个 message`,
+	}
+	OptionProtobufFieldMap = Option{
+		KeyName:      KeyProtobufFieldMap,
+		ChooseOnly:   false,
+		Default:      "",
+		Placeholder:  "host=hostname,ts=timestamp",
+		DefaultNoUse: false,
+		Description:  "protobuf字段映射(protobuf_field_map)",
+		Advance:      true,
+		ToolTip:      `以 protobuf 格式发送时 proto 字段与数据字段的对应关系，格式为 proto字段=数据字段，多个以逗号分隔，没有配置的字段使用同名的数据字段`,
+	}
 	OptionMaxSendRate = Option{
 		KeyName:      KeyMaxSendRate,
 		ChooseOnly:   false,
@@ -261,6 +354,34 @@ var ModeKeyOptions = map[string][]Option{
 			CheckRegex:   "^[a-zA-Z][a-zA-Z0-9_]{0,127}$",
 			ToolTip:      "新增或现有的七牛大数据平台Pipeline中的实时仓库名称",
 		},
+		{
+			KeyName:      KeyPandoraRepoField,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "按字段值选择实时仓库(pandora_repo_field)",
+			Advance:      true,
+			ToolTip:      "根据每条数据中该字段的值作为实时仓库名称发送，字段不存在或不在白名单中时发送到pandora_repo_name",
+		},
+		{
+			KeyName:      KeyPandoraRepoAllowList,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "允许路由的实时仓库白名单(pandora_repo_allowlist)",
+			Advance:      true,
+			ToolTip:      "逗号分隔多个仓库名称，为空时由 pandora_route_max_repos 限制仓库数量",
+		},
+		{
+			KeyName:      KeyPandoraRouteMaxRepos,
+			ChooseOnly:   false,
+			Default:      strconv.Itoa(DefaultRouteMaxTargets),
+			DefaultNoUse: false,
+			Description:  "最多路由的仓库数量(pandora_route_max_repos)",
+			CheckRegex:   "^[1-9][0-9]*$",
+			Advance:      true,
+			ToolTip:      "没有配置白名单时最多路由到多少个仓库，之后出现的新仓库名称的数据发送到默认仓库，避免异常的字段值创建大量仓库",
+		},
 		OptionSaveLogPath,
 		OptionLogkitSendTime,
 		{
@@ -287,6 +408,37 @@ var ModeKeyOptions = map[string][]Option{
 			},
 			MultiDefaultDepend: KeyPandoraRegion,
 		},
+		{
+			KeyName:      KeyPandoraStandbyHosts,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "备用大数据平台域名(pandora_standby_hosts)",
+			Advance:      true,
+			ToolTip:      "逗号分隔多个备用域名，pandora_host 持续发送失败时依次切换到备用域名",
+		},
+		{
+			KeyName:       KeyPandoraFailoverThreshold,
+			ChooseOnly:    false,
+			Default:       "5",
+			DefaultNoUse:  false,
+			Description:   "连续失败多少次后切换域名(pandora_failover_threshold)",
+			CheckRegex:    "^[1-9][0-9]*$",
+			Advance:       true,
+			AdvanceDepend: KeyPandoraStandbyHosts,
+			ToolTip:       "当前域名连续发送失败达到该次数后切换到下一个域名，认证失败和数据被拒绝不计入",
+		},
+		{
+			KeyName:       KeyPandoraFailbackInterval,
+			ChooseOnly:    false,
+			Default:       "10m",
+			DefaultNoUse:  false,
+			Description:   "切回主域名的间隔(pandora_failback_interval)",
+			CheckRegex:    "\\d+[hms]",
+			Advance:       true,
+			AdvanceDepend: KeyPandoraStandbyHosts,
+			ToolTip:       "使用备用域名超过该时间后，在发送成功时尝试切回 pandora_host",
+		},
 		{
 			KeyName:       KeyPandoraSchemaFree,
 			Element:       Radio,
@@ -586,6 +738,8 @@ var ModeKeyOptions = map[string][]Option{
 			Advance:       true,
 		},
 		OptionFtWriteLimit,
+		OptionFtRateLimit,
+		OptionFtRateSchedule,
 		OptionFtStrategy,
 		OptionFtProcs,
 		OptionFtDiscardErr,
@@ -680,6 +834,34 @@ var ModeKeyOptions = map[string][]Option{
 			CheckRegex:   "\\d+[hms]",
 			ToolTip:      `设置发送数据超时时间，写法为：数字加单位符号，支持时h、分m、秒s为单位，例如3h(3小时)、10m(10分钟)、5s(5秒)，默认的timeout时间是30s，当timeout时间为0s时表示永不超时`,
 		},
+		{
+			KeyName:      KeyPandoraCredentialFile,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "密钥文件路径(pandora_credential_file)",
+			Advance:      true,
+			ToolTip:      `json 格式的密钥文件，例如 {"pandora_ak":"xxx","pandora_sk":"xxx"}，也可以包含 token 配置，文件变化后自动使用新的密钥，无需重启`,
+		},
+		{
+			KeyName:      KeyPandoraCredentialURL,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "密钥回调地址(pandora_credential_url)",
+			Advance:      true,
+			ToolTip:      "通过 GET 请求获取与密钥文件格式相同的 json，与 pandora_credential_file 同时配置时优先使用文件",
+		},
+		{
+			KeyName:      KeyPandoraCredentialRefreshInterval,
+			ChooseOnly:   false,
+			Default:      "1m",
+			DefaultNoUse: false,
+			Description:  "密钥刷新间隔(pandora_credential_refresh_interval)",
+			CheckRegex:   "\\d+[hms]",
+			Advance:      true,
+			ToolTip:      "定期检查密钥文件或回调地址，认证失败时会立即刷新",
+		},
 	},
 	TypeMongodbAccumulate: {
 		{
@@ -730,6 +912,8 @@ var ModeKeyOptions = map[string][]Option{
 		},
 		OptionSaveLogPath,
 		OptionFtWriteLimit,
+		OptionFtRateLimit,
+		OptionFtRateSchedule,
 		OptionFtStrategy,
 		OptionFtProcs,
 		OptionFtDiscardErr,
@@ -836,7 +1020,13 @@ var ModeKeyOptions = map[string][]Option{
 			Advance:       true,
 		},
 		OptionSaveLogPath,
+		OptionShardEndpoints,
+		OptionShardStrategy,
+		OptionShardField,
+		OptionShardUnhealthyInterval,
 		OptionFtWriteLimit,
+		OptionFtRateLimit,
+		OptionFtRateSchedule,
 		OptionFtStrategy,
 		OptionFtProcs,
 		OptionFtDiscardErr,
@@ -902,10 +1092,74 @@ var ModeKeyOptions = map[string][]Option{
 			Description:   "索引时区(Local(本地)|UTC(标准时间)|PRC(北京时间))(elastic_time_zone)",
 			Advance:       true,
 		},
+		{
+			KeyName:      KeyElasticIndexField,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "按字段值选择索引(elastic_index_field)",
+			Advance:      true,
+			ToolTip:      "根据每条数据中该字段的值作为索引名称，字段不存在或不在白名单中时使用elastic_index",
+		},
+		{
+			KeyName:      KeyElasticIndexAllowList,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "允许路由的索引白名单(elastic_index_allowlist)",
+			Advance:      true,
+			ToolTip:      "逗号分隔多个索引名称，为空时由 elastic_route_max_indices 限制索引数量",
+		},
+		{
+			KeyName:      KeyElasticRouteMaxIndex,
+			ChooseOnly:   false,
+			Default:      strconv.Itoa(DefaultRouteMaxTargets),
+			DefaultNoUse: false,
+			Description:  "最多路由的索引数量(elastic_route_max_indices)",
+			CheckRegex:   "^[1-9][0-9]*$",
+			Advance:      true,
+			ToolTip:      "没有配置白名单时最多路由到多少个索引，之后出现的新索引名称的数据写入默认索引，避免异常的字段值创建大量索引",
+		},
+		{
+			KeyName:      KeyElasticPipeline,
+			ChooseOnly:   false,
+			Default:      "",
+			Placeholder:  "my-pipeline",
+			DefaultNoUse: false,
+			Description:  "ingest pipeline名称(elastic_pipeline)",
+			Advance:      true,
+			ToolTip:      `写入时使用的 ingest pipeline，可以使用"{{key}}"引用数据中的字段，字段不存在时不使用 pipeline，ES 3.x 版本不支持`,
+		},
+		{
+			KeyName:      KeyElasticRouting,
+			ChooseOnly:   false,
+			Default:      "",
+			Placeholder:  "{{user_id}}",
+			DefaultNoUse: false,
+			Description:  "路由参数(elastic_routing)",
+			Advance:      true,
+			ToolTip:      `写入时的 routing 参数，可以使用"{{key}}"引用数据中的字段，字段不存在时不设置`,
+		},
+		{
+			KeyName:      KeyElasticID,
+			ChooseOnly:   false,
+			Default:      "",
+			Placeholder:  "{{host}}-{{seq}}",
+			DefaultNoUse: false,
+			Description:  "文档ID(elastic_id)",
+			Advance:      true,
+			ToolTip:      `使用"{{key}}"引用数据中的字段组成文档 _id，相同 _id 的数据会覆盖写入，用于避免重复数据，字段不存在时由 ES 自动生成`,
+		},
 		OptionEnableGzip,
 		OptionLogkitSendTime,
 		OptionSaveLogPath,
+		OptionShardEndpoints,
+		OptionShardStrategy,
+		OptionShardField,
+		OptionShardUnhealthyInterval,
 		OptionFtWriteLimit,
+		OptionFtRateLimit,
+		OptionFtRateSchedule,
 		OptionFtStrategy,
 		OptionFtProcs,
 		OptionFtDiscardErr,
@@ -976,6 +1230,19 @@ var ModeKeyOptions = map[string][]Option{
 			Description:  "kafka的keepalive时间(kafka_keep_alive)",
 			Advance:      true,
 		},
+		{
+			KeyName:       KeyKafkaEncoding,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{SendProtocolJson, SendProtocolMsgpack, SendProtocolProtobuf},
+			Default:       SendProtocolJson,
+			DefaultNoUse:  false,
+			Description:   "消息序列化方式[json|msgpack|protobuf](kafka_encoding)",
+			Advance:       true,
+			ToolTip:       "使用protobuf时需要填写protobuf定义",
+		},
+		OptionProtobufDescriptor,
+		OptionProtobufMessage,
+		OptionProtobufFieldMap,
 		{
 			KeyName:            KeyGZIPCompressionLevel,
 			ChooseOnly:         true,
@@ -987,7 +1254,13 @@ var ModeKeyOptions = map[string][]Option{
 			AdvanceDependValue: KeyKafkaCompressionGzip,
 		},
 		OptionSaveLogPath,
+		OptionShardEndpoints,
+		OptionShardStrategy,
+		OptionShardField,
+		OptionShardUnhealthyInterval,
 		OptionFtWriteLimit,
+		OptionFtRateLimit,
+		OptionFtRateSchedule,
 		OptionFtStrategy,
 		OptionFtProcs,
 		OptionFtDiscardErr,
@@ -1010,11 +1283,14 @@ var ModeKeyOptions = map[string][]Option{
 		{
 			KeyName:       KeyHttpSenderProtocol,
 			ChooseOnly:    true,
-			ChooseOptions: []interface{}{SendProtocolJson, SendProtocolCSV, SendProtocolWholeJson, SendProtocolRaw},
+			ChooseOptions: []interface{}{SendProtocolJson, SendProtocolCSV, SendProtocolWholeJson, SendProtocolRaw, SendProtocolMsgpack, SendProtocolProtobuf},
 			Default:       "json",
 			Description:   "发送数据时使用的格式(http_sender_protocol)",
-			ToolTip:       `使用raw格式发送时，需使用raw解析方式，发送时将raw字段的值取出作为http body发送`,
+			ToolTip:       `使用raw格式发送时，需使用raw解析方式，发送时将raw字段的值取出作为http body发送；msgpack格式整批数据为一个msgpack数组；protobuf格式每条数据为一个带varint长度前缀的message，需要填写protobuf定义`,
 		},
+		OptionProtobufDescriptor,
+		OptionProtobufMessage,
+		OptionProtobufFieldMap,
 		{
 			KeyName:      KeyHttpSenderTemplate,
 			Element:      Text,
@@ -1051,8 +1327,24 @@ var ModeKeyOptions = map[string][]Option{
 			DefaultNoUse: false,
 			Description:  "发送超时时间(http_sender_timeout)",
 		},
+		{
+			KeyName:      KeyHttpSenderCaptureRequests,
+			ChooseOnly:   false,
+			Default:      "0",
+			DefaultNoUse: false,
+			Description:  "记录最近发送的请求数(http_sender_capture_requests)",
+			CheckRegex:   "\\d+",
+			Advance:      true,
+			ToolTip:      "记录最近发送的完整请求以及目的端的响应，可以通过管理接口查看和重放，用于排查目的端拒绝数据的原因，最多记录100个，0表示不记录",
+		},
 		OptionSaveLogPath,
+		OptionShardEndpoints,
+		OptionShardStrategy,
+		OptionShardField,
+		OptionShardUnhealthyInterval,
 		OptionFtWriteLimit,
+		OptionFtRateLimit,
+		OptionFtRateSchedule,
 		OptionFtStrategy,
 		OptionFtProcs,
 		OptionFtDiscardErr,
@@ -1094,6 +1386,38 @@ var ModeKeyOptions = map[string][]Option{
 		},
 		OptionMaxSendRate,
 	},
+	TypeLocalStore: {
+		{
+			KeyName:      KeyLocalStorePath,
+			ChooseOnly:   false,
+			Default:      "",
+			Placeholder:  "/home/user/logkit_localstore",
+			DefaultNoUse: true,
+			Required:     true,
+			Description:  "本地存储目录(localstore_path)",
+			ToolTip:      `数据按小时写入该目录下的分段文件，每个 sender 需要使用单独的目录，可以通过 /logkit/localstore/<runner名称> 接口按时间范围、字段以及关键字查询`,
+		},
+		{
+			KeyName:      KeyLocalStoreRetention,
+			ChooseOnly:   false,
+			Default:      "24h",
+			DefaultNoUse: false,
+			Description:  "数据保留时间(localstore_retention)",
+			CheckRegex:   "\\d+[hms]",
+			ToolTip:      `超过保留时间的数据按小时整体删除，不能小于 1h`,
+		},
+		{
+			KeyName:      KeyLocalStoreMaxSize,
+			ChooseOnly:   false,
+			Default:      "0",
+			DefaultNoUse: false,
+			Description:  "最大占用磁盘字节数(localstore_max_size)",
+			CheckRegex:   "\\d+",
+			Advance:      true,
+			ToolTip:      `超过后删除最早的数据，正在写入的一个小时的数据不会被删除，0 表示只按保留时间删除`,
+		},
+		OptionMaxSendRate,
+	},
 	TypeCSV: {
 		{
 			KeyName:      KeyCSVFields,
@@ -1196,4 +1520,295 @@ var ModeKeyOptions = map[string][]Option{
 			ToolTip:      "格式：tag1=xx,tag2=yy",
 		},
 	},
+	TypeKodo: {
+		{
+			KeyName:      KeyKodoAk,
+			ChooseOnly:   false,
+			Default:      "",
+			Required:     true,
+			Placeholder:  "在此填写您七牛账号ak(access_key)",
+			DefaultNoUse: true,
+			Description:  "七牛公钥(kodo_ak)",
+		},
+		{
+			KeyName:      KeyKodoSk,
+			ChooseOnly:   false,
+			Default:      "",
+			Required:     true,
+			Placeholder:  "在此填写您七牛账号的sk(secret_key)",
+			DefaultNoUse: true,
+			Description:  "七牛私钥(kodo_sk)",
+			Secret:       true,
+		},
+		{
+			KeyName:      KeyKodoBucket,
+			ChooseOnly:   false,
+			Default:      "",
+			Required:     true,
+			Placeholder:  "存储空间名称",
+			DefaultNoUse: true,
+			Description:  "存储空间(kodo_bucket)",
+		},
+		{
+			KeyName:      KeyKodoUpHost,
+			ChooseOnly:   false,
+			Default:      "https://up.qiniup.com",
+			DefaultNoUse: false,
+			Description:  "上传域名(kodo_up_host)",
+			ToolTip:      "存储空间所在区域的上传域名，如华东为 https://up.qiniup.com，华北为 https://up-z1.qiniup.com",
+		},
+		{
+			KeyName:      KeyKodoKeyTemplate,
+			ChooseOnly:   false,
+			Default:      "logkit/${runner}/%Y%m%d/%H%M%S-${hostname}-${id}${ext}",
+			DefaultNoUse: false,
+			Description:  "文件名模版(kodo_key_template)",
+			Advance:      true,
+			ToolTip:      "上传到存储空间中的文件名，支持 %Y%m%d 等魔法变量渲染文件开始写入的时间，以及 ${runner}、${hostname}、${id}(文件唯一标识)、${ext}(文件后缀) 变量",
+		},
+		{
+			KeyName:       KeyKodoCompress,
+			Element:       Radio,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{"true", "false"},
+			Default:       "true",
+			DefaultNoUse:  false,
+			Description:   "gzip压缩(kodo_compress)",
+			ToolTip:       "上传的文件使用 gzip 压缩",
+		},
+		{
+			KeyName:       KeyKodoWriteRaw,
+			Element:       Radio,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{"false", "true"},
+			Default:       "false",
+			DefaultNoUse:  false,
+			Description:   "只写入原始数据(kodo_write_raw)",
+			Advance:       true,
+			ToolTip:       "为 true 时只写入 raw 字段的内容，否则每条数据序列化为一行 json",
+		},
+		{
+			KeyName:      KeyKodoRotateSize,
+			ChooseOnly:   false,
+			Default:      "67108864",
+			DefaultNoUse: false,
+			Description:  "文件切割大小(kodo_rotate_size)",
+			CheckRegex:   "\\d+",
+			ToolTip:      "默认为67108864（64MB），本地缓存文件超过该大小（压缩后）时上传",
+		},
+		{
+			KeyName:      KeyKodoRotateInterval,
+			ChooseOnly:   false,
+			Default:      "5m",
+			DefaultNoUse: false,
+			Description:  "文件切割间隔(kodo_rotate_interval)",
+			CheckRegex:   "\\d+[hms]",
+			ToolTip:      "本地缓存文件开始写入超过该时间后上传",
+		},
+		{
+			KeyName:      KeyKodoPartSize,
+			ChooseOnly:   false,
+			Default:      "4194304",
+			DefaultNoUse: false,
+			Description:  "分片大小(kodo_part_size)",
+			CheckRegex:   "\\d+",
+			Advance:      true,
+			ToolTip:      "超过该大小的文件使用分片上传，中断后从未完成的分片继续上传，不能小于1048576（1MB）",
+		},
+		{
+			KeyName:      KeyKodoSpoolDir,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: true,
+			Description:  "本地缓存目录(kodo_spool_dir)",
+			Advance:      true,
+			ToolTip:      "等待上传的文件的存放目录，默认为 kodo_spool/<runner名称>_<存储空间>",
+		},
+	},
+	TypeNotify: {
+		{
+			KeyName:       KeyNotifyPlatform,
+			Element:       Radio,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{NotifyPlatformFeishu, NotifyPlatformDingTalk, NotifyPlatformWeCom},
+			Default:       NotifyPlatformFeishu,
+			DefaultNoUse:  false,
+			Description:   "通知平台(notify_platform)",
+			ToolTip:       "feishu 为飞书，dingtalk 为钉钉，wecom 为企业微信",
+		},
+		{
+			KeyName:      KeyNotifyWebhookURL,
+			ChooseOnly:   false,
+			Default:      "",
+			Placeholder:  "https://open.feishu.cn/open-apis/bot/v2/hook/xxx",
+			DefaultNoUse: true,
+			Required:     true,
+			Description:  "群机器人 webhook 地址(notify_webhook_url)",
+			ToolTip:      "群机器人的 webhook 地址，可以使用 ${YOUR_ENV} 的方式从环境变量中读取",
+		},
+		{
+			KeyName:      KeyNotifySecret,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: true,
+			Secret:       true,
+			Description:  "签名密钥(notify_secret)",
+			ToolTip:      "飞书和钉钉机器人开启签名校验时填写，企业微信不支持",
+		},
+		{
+			KeyName:      KeyNotifyTitle,
+			ChooseOnly:   false,
+			Default:      "",
+			Placeholder:  "[{{severity}}] {{runner}} 告警",
+			DefaultNoUse: true,
+			Description:  "消息标题模版(notify_title)",
+			ToolTip:      `使用"{{key}}"作为占位符，渲染为该字段的值，嵌套字段使用"{{a.b}}"，默认为 logkit 告警(runner名称)`,
+		},
+		{
+			KeyName:      KeyNotifyTemplate,
+			Element:      Text,
+			ChooseOnly:   false,
+			Default:      "",
+			Placeholder:  "**主机**: {{hostname}}\n**错误**: {{message}}",
+			DefaultNoUse: true,
+			Description:  "消息内容模版(notify_template)",
+			ToolTip:      `markdown 格式的消息内容，占位符与标题相同，默认按字段名排序列出所有字段`,
+		},
+		{
+			KeyName:      KeyNotifyRateLimit,
+			ChooseOnly:   false,
+			Default:      "20",
+			DefaultNoUse: false,
+			Description:  "每分钟最多发送的消息数(notify_rate_limit)",
+			CheckRegex:   "\\d+",
+			ToolTip:      "超过限制的数据不发送，忽略的条数会在下一条消息中提示，群机器人通常限制每分钟 20 条消息",
+		},
+		{
+			KeyName:       KeyNotifyAtAll,
+			Element:       Radio,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{"false", "true"},
+			Default:       "false",
+			DefaultNoUse:  false,
+			Description:   "是否@所有人(notify_at_all)",
+			Advance:       true,
+		},
+		{
+			KeyName:      KeyNotifyAtMobiles,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: true,
+			Description:  "@指定手机号的群成员(notify_at_mobiles)",
+			Advance:      true,
+			ToolTip:      "多个手机号用逗号(,)分隔，仅支持钉钉和企业微信",
+		},
+		{
+			KeyName:      KeyHttpTimeout,
+			Default:      "30s",
+			DefaultNoUse: false,
+			Description:  "发送超时时间(http_sender_timeout)",
+		},
+	},
+	TypeBigQuery: {
+		{
+			KeyName:      KeyBigQueryProject,
+			ChooseOnly:   false,
+			Default:      "",
+			Required:     true,
+			Placeholder:  "my-project",
+			DefaultNoUse: true,
+			Description:  "项目ID(bigquery_project)",
+		},
+		{
+			KeyName:      KeyBigQueryDataset,
+			ChooseOnly:   false,
+			Default:      "",
+			Required:     true,
+			Placeholder:  "logs",
+			DefaultNoUse: true,
+			Description:  "数据集(bigquery_dataset)",
+		},
+		{
+			KeyName:      KeyBigQueryTable,
+			ChooseOnly:   false,
+			Default:      "",
+			Required:     true,
+			Placeholder:  "app_logs",
+			DefaultNoUse: true,
+			Description:  "数据表(bigquery_table)",
+			ToolTip:      "数据表需要事先创建好",
+		},
+		{
+			KeyName:      KeyBigQueryCredentialsFile,
+			ChooseOnly:   false,
+			Default:      "",
+			Placeholder:  "/path/to/service-account.json",
+			DefaultNoUse: true,
+			Description:  "服务账号密钥文件(bigquery_credentials_file)",
+			ToolTip:      "json 格式的服务账号密钥文件路径，服务账号需要有数据表的写入权限；不填时从 GCE 元数据服务获取所在虚拟机的服务账号凭证",
+		},
+		{
+			KeyName:      KeyBigQuerySchema,
+			ChooseOnly:   false,
+			Default:      "",
+			Placeholder:  "host hostname STRING,cost INTEGER,time TIMESTAMP",
+			DefaultNoUse: true,
+			Description:  "字段映射(bigquery_schema)",
+			ToolTip:      "多个列用逗号(,)分隔，每个列的格式为\"字段名 [列名] 类型\"，列名不填时与字段名相同，类型支持 STRING、INTEGER、FLOAT、NUMERIC、BOOLEAN、TIMESTAMP、DATE、DATETIME、JSON；填写后只写入映射的字段，并按类型转换，不填时所有字段原样写入",
+		},
+		{
+			KeyName:      KeyBigQueryInsertIDField,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: true,
+			Description:  "去重字段(bigquery_insert_id_field)",
+			Advance:      true,
+			ToolTip:      "使用该字段的值作为 insertId，BigQuery 会在短时间内对相同 insertId 的数据尽量去重，避免重试导致数据重复",
+		},
+		{
+			KeyName:       KeyBigQueryIgnoreUnknownValues,
+			Element:       Radio,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{"false", "true"},
+			Default:       "false",
+			DefaultNoUse:  false,
+			Description:   "忽略表中不存在的列(bigquery_ignore_unknown_values)",
+			Advance:       true,
+			ToolTip:       "为 false 时包含表中不存在的列的数据会被拒绝写入",
+		},
+		{
+			KeyName:      KeyBigQueryBatchSize,
+			ChooseOnly:   false,
+			Default:      "500",
+			DefaultNoUse: false,
+			Description:  "单次请求的最大条数(bigquery_batch_size)",
+			CheckRegex:   "\\d+",
+			Advance:      true,
+			ToolTip:      "每次写入请求最多包含的数据条数，请求的大小同时限制在 9MB 以内",
+		},
+		{
+			KeyName:      KeyBigQueryMaxRetries,
+			ChooseOnly:   false,
+			Default:      "3",
+			DefaultNoUse: false,
+			Description:  "配额不足时的重试次数(bigquery_max_retries)",
+			CheckRegex:   "\\d+",
+			Advance:      true,
+			ToolTip:      "超过配额、限流或者服务端错误时按指数退避重试的次数，重试后仍然失败的数据交给上层重试",
+		},
+		{
+			KeyName:      KeyBigQueryEndpoint,
+			ChooseOnly:   false,
+			Default:      "https://bigquery.googleapis.com",
+			DefaultNoUse: false,
+			Description:  "服务地址(bigquery_endpoint)",
+			Advance:      true,
+		},
+		{
+			KeyName:      KeyHttpTimeout,
+			Default:      "30s",
+			DefaultNoUse: false,
+			Description:  "发送超时时间(http_sender_timeout)",
+		},
+	},
 }
@@ -20,6 +20,22 @@ const (
 	KeyPandoraAutoCreate           = "pandora_auto_create"
 	KeyPandoraSchemaFree           = "pandora_schema_free"
 	KeyPandoraExtraInfo            = "pandora_extra_info"
+	KeyPandoraRepoField            = "pandora_repo_field"
+	KeyPandoraRepoAllowList        = "pandora_repo_allowlist"
+
+	// 没有配置白名单时最多路由到多少个仓库或索引，超过后发送到默认的仓库或索引
+	KeyPandoraRouteMaxRepos = "pandora_route_max_repos"
+	DefaultRouteMaxTargets  = 100
+
+	// 主备域名切换
+	KeyPandoraStandbyHosts      = "pandora_standby_hosts"
+	KeyPandoraFailoverThreshold = "pandora_failover_threshold"
+	KeyPandoraFailbackInterval  = "pandora_failback_interval"
+
+	// 从文件或者回调地址定期刷新 ak/sk 和 token
+	KeyPandoraCredentialFile            = "pandora_credential_file"
+	KeyPandoraCredentialURL             = "pandora_credential_url"
+	KeyPandoraCredentialRefreshInterval = "pandora_credential_refresh_interval"
 
 	KeyPandoraEnableLogDB    = "pandora_enable_logdb"
 	KeyPandoraLogDBName      = "pandora_logdb_name"
@@ -95,6 +111,10 @@ const (
 	TypeCSV                = "csv"
 	TypeSQLFile            = "sqlfile"
 	TypeOpenFalconTransfer = "open_falcon"
+	TypeKodo               = "kodo"   // 七牛对象存储
+	TypeNotify             = "notify" // 飞书、钉钉、企业微信群机器人通知
+	TypeBigQuery           = "bigquery"
+	TypeLocalStore         = "localstore" // 本地短期存储，可以通过 API 查询
 
 	InnerUserAgent = "_useragent"
 	InnerSendRaw   = "_send_raw"
@@ -105,19 +125,28 @@ const (
 	KeyAuthUsername            = "auth_username"
 	KeyAuthPassword            = "auth_password"
 	KeyEnableGzip              = "enable_gzip"
+	KeyProtobufDescriptor      = "protobuf_descriptor" // .proto 中的 message 定义，或者定义文件的路径
+	KeyProtobufMessage         = "protobuf_message"    // 使用的 message 名称，默认为第一个 message
+	KeyProtobufFieldMap        = "protobuf_field_map"  // proto 字段与数据字段的对应关系，如 host=hostname,ts=timestamp
 	DefaultJJHPipelineEndpoint = "https://jjh-pipeline.qiniuapi.com"
 	DefaultJJHLogDBEndpoint    = "https://jjh-insight.qiniuapi.com"
 	NBRegion                   = "nb"
 	JJHRegion                  = "jjh"
 
 	// Elastic
-	KeyElasticHost          = "elastic_host"
-	KeyElasticVersion       = "elastic_version"
-	KeyElasticIndex         = "elastic_index"
-	KeyElasticType          = "elastic_type"
-	KeyElasticAlias         = "elastic_keys"
-	KeyElasticIndexStrategy = "elastic_index_strategy"
-	KeyElasticTimezone      = "elastic_time_zone"
+	KeyElasticHost           = "elastic_host"
+	KeyElasticVersion        = "elastic_version"
+	KeyElasticIndex          = "elastic_index"
+	KeyElasticType           = "elastic_type"
+	KeyElasticAlias          = "elastic_keys"
+	KeyElasticIndexStrategy  = "elastic_index_strategy"
+	KeyElasticTimezone       = "elastic_time_zone"
+	KeyElasticIndexField     = "elastic_index_field"
+	KeyElasticIndexAllowList = "elastic_index_allowlist"
+	KeyElasticRouteMaxIndex  = "elastic_route_max_indices"
+	KeyElasticPipeline       = "elastic_pipeline"
+	KeyElasticRouting        = "elastic_routing"
+	KeyElasticID             = "elastic_id"
 
 	KeyDefaultIndexStrategy = "default"
 	KeyYearIndexStrategy    = "year"
@@ -149,9 +178,20 @@ const (
 	KeyFtMemoryChannel     = "ft_memory_channel"
 	KeyFtMemoryChannelSize = "ft_memory_channel_size"
 	KeyFtLongDataDiscard   = "ft_long_data_discard"
+	KeyFtRateLimit         = "ft_rate_limit"    // 发送限速，单位KB/s，不在 ft_rate_schedule 时间段内时生效
+	KeyFtRateSchedule      = "ft_rate_schedule" // 按时间段发送限速，如 00:00-06:00=0,09:00-18:00=10240
 
 	KeySenderTest = "sender_test" // dataflow中测试发送，不需要ft sender
 
+	// shard 多个等价下游之间分发数据
+	KeyShardEndpoints         = "shard_endpoints"          // 每个下游覆盖的配置项，json 数组，如 [{"kafka_host":"a:9092"},{"kafka_host":"b:9092"}]
+	KeyShardStrategy          = "shard_strategy"           // 分发策略
+	KeyShardField             = "shard_field"              // hash 策略下用于计算分片的字段
+	KeyShardUnhealthyInterval = "shard_unhealthy_interval" // 发送失败的下游被排除的时长
+
+	ShardStrategyRoundRobin = "round_robin"
+	ShardStrategyHash       = "hash"
+
 	// queue
 	KeyMaxDiskUsedBytes = "max_disk_used_bytes"
 	KeyMaxSizePerFile   = "max_size_per_file"
@@ -187,6 +227,11 @@ const (
 	SendProtocolJson      = "json"
 	SendProtocolWholeJson = "body_json"
 	SendProtocolRaw       = "raw"
+	SendProtocolMsgpack   = "msgpack"  // 单条数据为 msgpack map，http 发送时整批数据为一个 msgpack array
+	SendProtocolProtobuf  = "protobuf" // 单条数据为 protobuf message，http 发送时每条数据加上 varint 长度前缀
+
+	// 记录最近发送的请求数，用于排查目的端拒绝数据的原因，0 表示不记录
+	KeyHttpSenderCaptureRequests = "http_sender_capture_requests"
 
 	// Influxdb sender 的可配置字段
 	KeyInfluxdbHost                  = "influxdb_host"
@@ -216,6 +261,7 @@ const (
 	KeyKafkaCompression               = "kafka_compression"      //压缩模式,有none, gzip, snappy
 	KeyKafkaTimeout                   = "kafka_timeout"          //连接超时时间
 	KeyKafkaKeepAlive                 = "kafka_keep_alive"       //保持连接时长
+	KeyKafkaEncoding                  = "kafka_encoding"         //消息的序列化方式,有json, msgpack, protobuf
 	KeyMaxMessageBytes                = "max_message_bytes"      //每条消息最大字节数
 	KeyGZIPCompressionLevel           = "gzip_compression_level" //GZIP压缩日志的策略
 	KeyGZIPCompressionNo              = "仅打包不压缩"
@@ -224,6 +270,9 @@ const (
 	KeyGZIPCompressionDefault         = "默认压缩比"
 	KeyGZIPCompressionHuffmanOnly     = "哈夫曼压缩"
 
+	// kafka_transactional_id 暂不支持，配置后创建 sender 时报错，见 kafka.Sender 的说明
+	KeyKafkaTransactionalID = "kafka_transactional_id"
+
 	// Mongodb
 	// 可选参数 当sender_type 为mongodb_* 的时候，需要必填的字段
 	KeyMongodbHost       = "mongodb_host"
@@ -248,9 +297,53 @@ const (
 	KeySQLFileTable      = "sqlfile_table"
 	KeySQLFilePathPrefix = "sqlfile_path_prefix"
 
+	// localstore
+	KeyLocalStorePath      = "localstore_path"
+	KeyLocalStoreRetention = "localstore_retention"
+	KeyLocalStoreMaxSize   = "localstore_max_size"
+
 	// open-falcon
 	KeyOpenFalconTransferHost = "open_falcon_transfer_host"
 	KeyOpenFalconTransferURL  = "open_falcon_transfer_url"
+
+	// kodo
+	KeyKodoAk             = "kodo_ak"
+	KeyKodoSk             = "kodo_sk"
+	KeyKodoBucket         = "kodo_bucket"
+	KeyKodoUpHost         = "kodo_up_host"
+	KeyKodoKeyTemplate    = "kodo_key_template"
+	KeyKodoCompress       = "kodo_compress"
+	KeyKodoWriteRaw       = "kodo_write_raw"
+	KeyKodoRotateSize     = "kodo_rotate_size"
+	KeyKodoRotateInterval = "kodo_rotate_interval"
+	KeyKodoPartSize       = "kodo_part_size"
+	KeyKodoSpoolDir       = "kodo_spool_dir"
+
+	// notify
+	KeyNotifyPlatform   = "notify_platform"
+	KeyNotifyWebhookURL = "notify_webhook_url"
+	KeyNotifySecret     = "notify_secret"
+	KeyNotifyTitle      = "notify_title"
+	KeyNotifyTemplate   = "notify_template"
+	KeyNotifyRateLimit  = "notify_rate_limit"
+	KeyNotifyAtAll      = "notify_at_all"
+	KeyNotifyAtMobiles  = "notify_at_mobiles"
+
+	NotifyPlatformFeishu   = "feishu"
+	NotifyPlatformDingTalk = "dingtalk"
+	NotifyPlatformWeCom    = "wecom"
+
+	// bigquery
+	KeyBigQueryProject             = "bigquery_project"
+	KeyBigQueryDataset             = "bigquery_dataset"
+	KeyBigQueryTable               = "bigquery_table"
+	KeyBigQueryCredentialsFile     = "bigquery_credentials_file" // 服务账号的 json 密钥文件，为空时从 GCE 元数据服务获取
+	KeyBigQuerySchema              = "bigquery_schema"           // 字段与列的对应关系及列类型，如 host hostname STRING,cost INTEGER
+	KeyBigQueryInsertIDField       = "bigquery_insert_id_field"  // 作为 insertId 去重的字段
+	KeyBigQueryIgnoreUnknownValues = "bigquery_ignore_unknown_values"
+	KeyBigQueryBatchSize           = "bigquery_batch_size"
+	KeyBigQueryMaxRetries          = "bigquery_max_retries"
+	KeyBigQueryEndpoint            = "bigquery_endpoint"
 )
 
 // NotAsyncSender return when sender is not async
@@ -0,0 +1,131 @@
+package sender
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+// DefaultDeadLetterMaxSize 死信文件默认的最大字节数
+const DefaultDeadLetterMaxSize = 100 * 1024 * 1024
+
+// DeadLetterSender 表示 sender 内部会异步重试，被拒绝的数据需要由 sender 自己写入死信文件。
+// 只有 runner 配置了 send_error_policy 时才会调用 SetDeadLetter，之后被拒绝的数据不再重试，dl 为 nil 时直接丢弃；
+// 没有调用时被拒绝的数据与其他错误一样重试
+type DeadLetterSender interface {
+	SetDeadLetter(*DeadLetter)
+}
+
+// DeadLetterRecord 死信文件中的一行
+type DeadLetterRecord struct {
+	Time   time.Time `json:"time"`
+	Sender string    `json:"sender"`
+	Error  string    `json:"error"`
+	Data   Data      `json:"data,omitempty"`
+	Raw    string    `json:"raw,omitempty"`
+}
+
+// DeadLetter 将被拒绝、重试也不会成功的数据以 json 行的形式追加写入文件，文件达到 maxSize 后丢弃之后的数据
+type DeadLetter struct {
+	path    string
+	maxSize int64
+
+	mutex   sync.Mutex
+	file    *os.File
+	size    int64
+	written int64
+	dropped int64
+}
+
+func NewDeadLetter(path string, maxSize int64) (*DeadLetter, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultDeadLetterMaxSize
+	}
+	if err := os.MkdirAll(filepath.Dir(path), DefaultDirPerm); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, DefaultFilePerm)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &DeadLetter{path: path, maxSize: maxSize, file: f, size: fi.Size()}, nil
+}
+
+// Path 返回死信文件的路径
+func (d *DeadLetter) Path() string {
+	return d.path
+}
+
+// Write 写入被拒绝的数据，返回写入成功的条数
+func (d *DeadLetter) Write(senderName string, sendErr error, datas []Data) (int, error) {
+	records := make([]DeadLetterRecord, len(datas))
+	for i, data := range datas {
+		records[i] = DeadLetterRecord{Sender: senderName, Data: data}
+	}
+	return d.write(sendErr, records)
+}
+
+// WriteRaw 写入被拒绝的原始数据，返回写入成功的条数
+func (d *DeadLetter) WriteRaw(senderName string, sendErr error, lines []string) (int, error) {
+	records := make([]DeadLetterRecord, len(lines))
+	for i, line := range lines {
+		records[i] = DeadLetterRecord{Sender: senderName, Raw: line}
+	}
+	return d.write(sendErr, records)
+}
+
+func (d *DeadLetter) write(sendErr error, records []DeadLetterRecord) (int, error) {
+	now := time.Now()
+	var errStr string
+	if sendErr != nil {
+		errStr = sendErr.Error()
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	written := 0
+	for _, record := range records {
+		record.Time = now
+		record.Error = errStr
+		line, err := json.Marshal(record)
+		if err != nil {
+			d.dropped++
+			continue
+		}
+		line = append(line, '\n')
+		if d.size+int64(len(line)) > d.maxSize {
+			d.dropped += int64(len(records) - written)
+			return written, ErrDeadLetterFull
+		}
+		n, err := d.file.Write(line)
+		d.size += int64(n)
+		if err != nil {
+			d.dropped += int64(len(records) - written)
+			return written, err
+		}
+		written++
+		d.written++
+	}
+	return written, nil
+}
+
+// Stats 返回写入和因为文件已满或出错丢弃的条数
+func (d *DeadLetter) Stats() (written, dropped int64) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.written, d.dropped
+}
+
+func (d *DeadLetter) Close() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.file.Close()
+}
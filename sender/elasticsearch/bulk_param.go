@@ -0,0 +1,58 @@
+package elasticsearch
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/sven0726/fasttemplate"
+
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+// bulkParam bulk 请求中每条数据的参数(pipeline、routing、_id)，支持使用 {{field}} 引用数据中的字段
+type bulkParam struct {
+	static   string
+	template *fasttemplate.Template
+}
+
+func newBulkParam(s string) *bulkParam {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil
+	}
+	if !strings.Contains(s, "{{") {
+		return &bulkParam{static: s}
+	}
+	return &bulkParam{template: fasttemplate.New(s, "{{", "}}")}
+}
+
+// render 渲染参数，引用的字段不存在时返回空字符串，此时不设置该参数
+func (p *bulkParam) render(doc Data) string {
+	if p == nil {
+		return ""
+	}
+	if p.template == nil {
+		return p.static
+	}
+	missing := false
+	ret := p.template.ExecuteFuncString(func(w io.Writer, tag string) (int, error) {
+		v, ok := doc[strings.TrimSpace(tag)]
+		if !ok || v == nil {
+			missing = true
+			return 0, nil
+		}
+		switch value := v.(type) {
+		case string:
+			return io.WriteString(w, value)
+		case []byte:
+			return w.Write(value)
+		default:
+			return io.WriteString(w, fmt.Sprint(value))
+		}
+	})
+	if missing {
+		return ""
+	}
+	return ret
+}
@@ -0,0 +1,25 @@
+package elasticsearch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+func TestBulkParam(t *testing.T) {
+	var p *bulkParam
+	assert.Equal(t, "", p.render(Data{"a": "b"}))
+	assert.Nil(t, newBulkParam("  "))
+
+	p = newBulkParam("my-pipeline")
+	assert.Equal(t, "my-pipeline", p.render(Data{}))
+
+	p = newBulkParam("{{host}}-{{ seq }}")
+	assert.Equal(t, "web1-42", p.render(Data{"host": "web1", "seq": int64(42)}))
+	assert.Equal(t, "web1-1.5", p.render(Data{"host": "web1", "seq": 1.5}))
+	// 引用的字段不存在时不设置
+	assert.Equal(t, "", p.render(Data{"host": "web1"}))
+	assert.Equal(t, "", p.render(Data{"host": "web1", "seq": nil}))
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/json-iterator/go"
@@ -39,9 +40,22 @@ type Sender struct {
 
 	aliasFields map[string]string
 
+	indexField     string          // 按该字段的值选择索引，为空时全部使用 indexName
+	indexAllowList map[string]bool // 允许路由的索引，为空时由 routeMaxIndex 限制索引数量
+	routeMaxIndex  int             // 没有白名单时最多路由的索引数量
+	// routeIndices 没有白名单时已经允许路由的索引
+	routeIndices     map[string]bool
+	routeLimitWarned bool
+	routeMux         sync.Mutex
+
 	intervalIndex  int
 	timeZone       *time.Location
 	logkitSendTime bool
+
+	// 每条数据的 bulk 参数，使用别名替换前的字段名渲染
+	pipeline *bulkParam
+	routing  *bulkParam
+	docID    *bulkParam
 }
 
 func init() {
@@ -77,6 +91,25 @@ func NewSender(conf conf.MapConf) (elasticSender sender.Sender, err error) {
 	name, _ := conf.GetStringOr(KeyName, fmt.Sprintf("elasticSender:(elasticUrl:%s,index:%s,type:%s)", host, index, eType))
 	fields, _ := conf.GetAliasMapOr(KeyElasticAlias, make(map[string]string))
 	eVersion, _ := conf.GetStringOr(KeyElasticVersion, ElasticVersion5)
+	indexField, _ := conf.GetStringOr(KeyElasticIndexField, "")
+	allowList, _ := conf.GetStringListOr(KeyElasticIndexAllowList, []string{})
+	routeMaxIndex, _ := conf.GetIntOr(KeyElasticRouteMaxIndex, DefaultRouteMaxTargets)
+	if routeMaxIndex <= 0 {
+		return nil, fmt.Errorf("%v must be positive", KeyElasticRouteMaxIndex)
+	}
+	indexAllowList := make(map[string]bool, len(allowList))
+	for _, v := range allowList {
+		if v = strings.TrimSpace(v); v != "" {
+			indexAllowList[v] = true
+		}
+	}
+
+	pipeline, _ := conf.GetStringOr(KeyElasticPipeline, "")
+	routing, _ := conf.GetStringOr(KeyElasticRouting, "")
+	docID, _ := conf.GetStringOr(KeyElasticID, "")
+	if strings.TrimSpace(pipeline) != "" && eVersion == ElasticVersion3 {
+		return nil, fmt.Errorf("elasticsearch version %v does not support ingest pipeline", eVersion)
+	}
 
 	strategy := []string{KeyDefaultIndexStrategy, KeyYearIndexStrategy, KeyMonthIndexStrategy, KeyDayIndexStrategy}
 
@@ -158,9 +191,16 @@ func NewSender(conf conf.MapConf) (elasticSender sender.Sender, err error) {
 		elasticV6Client: elasticV6Client,
 		eType:           eType,
 		aliasFields:     fields,
+		indexField:      strings.TrimSpace(indexField),
+		indexAllowList:  indexAllowList,
+		routeMaxIndex:   routeMaxIndex,
+		routeIndices:    make(map[string]bool),
 		intervalIndex:   i,
 		timeZone:        timeZone,
 		logkitSendTime:  logkitSendTime,
+		pipeline:        newBulkParam(pipeline),
+		routing:         newBulkParam(routing),
+		docID:           newBulkParam(docID),
 	}, nil
 }
 
@@ -194,7 +234,8 @@ func (s *Sender) Send(datas []Data) error {
 		var indexName string
 		for _, doc := range datas {
 			//计算索引
-			indexName = buildIndexName(s.indexName, s.timeZone, s.intervalIndex)
+			indexName = buildIndexName(s.getIndexName(doc), s.timeZone, s.intervalIndex)
+			pipeline, routing, id := s.bulkParams(doc)
 			//字段名称替换
 			if makeDoc {
 				doc = s.wrapDoc(doc)
@@ -204,7 +245,17 @@ func (s *Sender) Send(datas []Data) error {
 				doc[KeySendTime] = time.Now().In(s.timeZone).UnixNano() / 1000000
 			}
 			doc2 := doc
-			bulkService.Add(elasticV6.NewBulkIndexRequest().UseEasyJSON(true).Index(indexName).Type(s.eType).Doc(&doc2))
+			req := elasticV6.NewBulkIndexRequest().UseEasyJSON(true).Index(indexName).Type(s.eType).Doc(&doc2)
+			if pipeline != "" {
+				req.Pipeline(pipeline)
+			}
+			if routing != "" {
+				req.Routing(routing)
+			}
+			if id != "" {
+				req.Id(id)
+			}
+			bulkService.Add(req)
 		}
 
 		resp, err := bulkService.Do(context.Background())
@@ -217,12 +268,14 @@ func (s *Sender) Send(datas []Data) error {
 			lastFailedResult *elasticV6.BulkResponseItem
 			failedDatas      = make([]map[string]interface{}, len(datas))
 			failedDatasIdx   = 0
+			failedStatuses   []int
 		)
 		for i, item := range resp.Items {
 			for _, result := range item {
 				if !(result.Status >= 200 && result.Status <= 299) {
 					failedDatas[failedDatasIdx] = datas[i]
 					failedDatasIdx++
+					failedStatuses = append(failedStatuses, result.Status)
 					lastFailedResult = result
 					break // 任一情况的失败都算该条数据整体操作失败，没有必要重复检查
 				}
@@ -246,7 +299,8 @@ func (s *Sender) Send(datas []Data) error {
 			SendError: reqerr.NewSendError(
 				fmt.Sprintf("bulk failed with last error: %s", lastError),
 				failedDatas,
-				reqerr.TypeBinaryUnpack,
+				// 失败的数据都是被拒绝的，例如 mapping 冲突，不需要二分重试
+				sender.StatusesErrorType(failedStatuses, reqerr.TypeBinaryUnpack),
 			),
 		}
 
@@ -261,6 +315,10 @@ func (s *Sender) Send(datas []Data) error {
 		indexName := buildIndexName(s.indexName, s.timeZone, s.intervalIndex)
 		for _, doc := range datas {
 			//计算索引
+			if s.indexField != "" {
+				indexName = buildIndexName(s.getIndexName(doc), s.timeZone, s.intervalIndex)
+			}
+			pipeline, routing, id := s.bulkParams(doc)
 			//字段名称替换
 			if makeDoc {
 				doc = s.wrapDoc(doc)
@@ -270,7 +328,17 @@ func (s *Sender) Send(datas []Data) error {
 				doc[KeySendTime] = curTime
 			}
 			doc2 := doc
-			bulkService.Add(elasticV5.NewBulkIndexRequest().Index(indexName).Type(s.eType).Doc(&doc2))
+			req := elasticV5.NewBulkIndexRequest().Index(indexName).Type(s.eType).Doc(&doc2)
+			if pipeline != "" {
+				req.Pipeline(pipeline)
+			}
+			if routing != "" {
+				req.Routing(routing)
+			}
+			if id != "" {
+				req.Id(id)
+			}
+			bulkService.Add(req)
 		}
 
 		resp, err := bulkService.Do(context.Background())
@@ -283,12 +351,14 @@ func (s *Sender) Send(datas []Data) error {
 			lastFailedResult *elasticV5.BulkResponseItem
 			failedDatas      = make([]map[string]interface{}, len(datas))
 			failedDatasIdx   = 0
+			failedStatuses   []int
 		)
 		for i, item := range resp.Items {
 			for _, result := range item {
 				if !(result.Status >= 200 && result.Status <= 299) {
 					failedDatas[failedDatasIdx] = datas[i]
 					failedDatasIdx++
+					failedStatuses = append(failedStatuses, result.Status)
 					lastFailedResult = result
 					break // 任一情况的失败都算该条数据整体操作失败，没有必要重复检查
 				}
@@ -311,7 +381,8 @@ func (s *Sender) Send(datas []Data) error {
 			SendError: reqerr.NewSendError(
 				fmt.Sprintf("bulk failed with last error: %s", lastError),
 				failedDatas,
-				reqerr.TypeBinaryUnpack,
+				// 失败的数据都是被拒绝的，例如 mapping 冲突，不需要二分重试
+				sender.StatusesErrorType(failedStatuses, reqerr.TypeBinaryUnpack),
 			),
 		}
 
@@ -325,7 +396,8 @@ func (s *Sender) Send(datas []Data) error {
 		var indexName string
 		for _, doc := range datas {
 			//计算索引
-			indexName = buildIndexName(s.indexName, s.timeZone, s.intervalIndex)
+			indexName = buildIndexName(s.getIndexName(doc), s.timeZone, s.intervalIndex)
+			_, routing, id := s.bulkParams(doc)
 			//字段名称替换
 			if makeDoc {
 				doc = s.wrapDoc(doc)
@@ -335,7 +407,14 @@ func (s *Sender) Send(datas []Data) error {
 				doc[KeySendTime] = time.Now().In(s.timeZone).UnixNano() / 1000000
 			}
 			doc2 := doc
-			bulkService.Add(elasticV3.NewBulkIndexRequest().Index(indexName).Type(s.eType).Doc(&doc2))
+			req := elasticV3.NewBulkIndexRequest().Index(indexName).Type(s.eType).Doc(&doc2)
+			if routing != "" {
+				req.Routing(routing)
+			}
+			if id != "" {
+				req.Id(id)
+			}
+			bulkService.Add(req)
 		}
 
 		resp, err := bulkService.Do()
@@ -348,12 +427,14 @@ func (s *Sender) Send(datas []Data) error {
 			lastFailedResult *elasticV3.BulkResponseItem
 			failedDatas      = make([]map[string]interface{}, len(datas))
 			failedDatasIdx   = 0
+			failedStatuses   []int
 		)
 		for i, item := range resp.Items {
 			for _, result := range item {
 				if !(result.Status >= 200 && result.Status <= 299) {
 					failedDatas[failedDatasIdx] = datas[i]
 					failedDatasIdx++
+					failedStatuses = append(failedStatuses, result.Status)
 					lastFailedResult = result
 					break // 任一情况的失败都算该条数据整体操作失败，没有必要重复检查
 				}
@@ -376,12 +457,63 @@ func (s *Sender) Send(datas []Data) error {
 			SendError: reqerr.NewSendError(
 				fmt.Sprintf("bulk failed with last error: %s", lastError),
 				failedDatas,
-				reqerr.TypeBinaryUnpack,
+				// 失败的数据都是被拒绝的，例如 mapping 冲突，不需要二分重试
+				sender.StatusesErrorType(failedStatuses, reqerr.TypeBinaryUnpack),
 			),
 		}
 	}
 }
 
+// bulkParams 渲染单条数据的 pipeline、routing 和 _id，为空时不设置
+func (s *Sender) bulkParams(doc Data) (pipeline, routing, id string) {
+	return s.pipeline.render(doc), s.routing.render(doc), s.docID.render(doc)
+}
+
+// getIndexName 根据 indexField 字段的值返回数据应当写入的索引，不满足条件时返回默认索引
+func (s *Sender) getIndexName(doc Data) string {
+	if s.indexField == "" {
+		return s.indexName
+	}
+	index, ok := doc[s.indexField].(string)
+	if !ok {
+		return s.indexName
+	}
+	index = strings.TrimSpace(index)
+	if index == "" {
+		return s.indexName
+	}
+	if len(s.indexAllowList) > 0 {
+		if !s.indexAllowList[index] {
+			return s.indexName
+		}
+		return index
+	}
+	if index == s.indexName || !s.reserveRouteIndex(index) {
+		return s.indexName
+	}
+	return index
+}
+
+// reserveRouteIndex 没有白名单时记录新出现的索引，已经路由的索引达到 routeMaxIndex 后返回 false，
+// 避免异常或者恶意的字段值创建无限多的索引
+func (s *Sender) reserveRouteIndex(index string) bool {
+	s.routeMux.Lock()
+	defer s.routeMux.Unlock()
+	if s.routeIndices[index] {
+		return true
+	}
+	if len(s.routeIndices) >= s.routeMaxIndex {
+		if !s.routeLimitWarned {
+			s.routeLimitWarned = true
+			log.Warnf("Sender[%v]: routed indices reach the limit %d of %v, records of new indices such as %q are written to %v",
+				s.name, s.routeMaxIndex, KeyElasticRouteMaxIndex, index, s.indexName)
+		}
+		return false
+	}
+	s.routeIndices[index] = true
+	return true
+}
+
 func buildIndexName(indexName string, timeZone *time.Location, size int) string {
 	now := time.Now().In(timeZone)
 	intervals := []string{strconv.Itoa(now.Year()), strconv.Itoa(int(now.Month())), strconv.Itoa(now.Day())}
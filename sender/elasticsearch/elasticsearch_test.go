@@ -0,0 +1,31 @@
+package elasticsearch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+func TestGetIndexName(t *testing.T) {
+	s := &Sender{
+		indexName:      "default",
+		indexField:     "tenant",
+		indexAllowList: map[string]bool{"a": true},
+		routeMaxIndex:  1,
+		routeIndices:   make(map[string]bool),
+	}
+	assert.Equal(t, "a", s.getIndexName(Data{"tenant": "a"}))
+	assert.Equal(t, "default", s.getIndexName(Data{"tenant": "b"}))
+	assert.Equal(t, "default", s.getIndexName(Data{"tenant": 1}))
+	assert.Equal(t, "default", s.getIndexName(Data{"tenant": " "}))
+
+	// 没有白名单时只路由到前 routeMaxIndex 个索引
+	s.indexAllowList = map[string]bool{}
+	assert.Equal(t, "default", s.getIndexName(Data{"tenant": "default"}))
+	assert.Equal(t, "b", s.getIndexName(Data{"tenant": "b"}))
+	assert.Equal(t, "default", s.getIndexName(Data{"tenant": "c"}))
+	assert.Equal(t, "b", s.getIndexName(Data{"tenant": "b"}))
+	assert.Len(t, s.routeIndices, 1)
+}
@@ -0,0 +1,135 @@
+package sender
+
+import (
+	"reflect"
+	"sync"
+
+	"github.com/json-iterator/go"
+
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const (
+	// EncodeFormatJSON 单条数据序列化为 json 对象
+	EncodeFormatJSON = "json"
+	// EncodeFormatMsgpack 单条数据序列化为 msgpack map
+	EncodeFormatMsgpack = "msgpack"
+	// EncodeFormatProtobuf 单条数据按照 ProtoSchema 序列化为 protobuf message
+	EncodeFormatProtobuf = "protobuf"
+)
+
+// EncodeCacheSender 表示 sender 可以复用同一个 runner 中其他 sender 对同一条数据的序列化结果
+type EncodeCacheSender interface {
+	SetEncodeCache(*EncodeCache)
+}
+
+type encodeKey struct {
+	data   uintptr
+	format string
+}
+
+type encodeEntry struct {
+	// 持有数据的引用，保证缓存有效期间数据不会被回收，地址不会被其他数据复用
+	data  Data
+	bytes []byte
+}
+
+// EncodeCache 以数据的地址和序列化格式为 key 缓存序列化结果，多个 sender 发送同一批数据时每种格式只需要序列化一次。
+// 只有不会修改数据的 sender（即 SkipDeepCopySender）才能共享同一批数据，每批数据发送完毕后需要调用 Reset 释放
+type EncodeCache struct {
+	mutex   sync.Mutex
+	entries map[encodeKey]encodeEntry
+	hits    int64
+	misses  int64
+}
+
+func NewEncodeCache() *EncodeCache {
+	return &EncodeCache{entries: make(map[encodeKey]encodeEntry)}
+}
+
+// ShareEncodeCache 为支持的 sender 设置同一个 EncodeCache，少于两个 sender 支持时没有复用的意义，返回 nil
+func ShareEncodeCache(senders []Sender) *EncodeCache {
+	var cacheSenders []EncodeCacheSender
+	for _, s := range senders {
+		if cs, ok := s.(EncodeCacheSender); ok {
+			cacheSenders = append(cacheSenders, cs)
+		}
+	}
+	if len(cacheSenders) < 2 {
+		return nil
+	}
+	cache := NewEncodeCache()
+	for _, cs := range cacheSenders {
+		cs.SetEncodeCache(cache)
+	}
+	return cache
+}
+
+// Encode 返回 data 以 format 格式序列化的结果，没有缓存时调用 encode 并缓存。cache 为 nil 时直接调用 encode。
+// 返回的结果会被多个 sender 共享，不能修改
+func (c *EncodeCache) Encode(data Data, format string, encode func(Data) ([]byte, error)) ([]byte, error) {
+	if c == nil || data == nil {
+		return encode(data)
+	}
+	key := encodeKey{data: reflect.ValueOf(data).Pointer(), format: format}
+	c.mutex.Lock()
+	entry, ok := c.entries[key]
+	if ok {
+		c.hits++
+	} else {
+		c.misses++
+	}
+	c.mutex.Unlock()
+	if ok {
+		return entry.bytes, nil
+	}
+
+	// 序列化在锁外进行，并发时可能重复序列化，但结果一致
+	bytes, err := encode(data)
+	if err != nil {
+		return nil, err
+	}
+	c.mutex.Lock()
+	c.entries[key] = encodeEntry{data: data, bytes: bytes}
+	c.mutex.Unlock()
+	return bytes, nil
+}
+
+// EncodeJSON 返回 data 序列化为 json 的结果
+func (c *EncodeCache) EncodeJSON(data Data) ([]byte, error) {
+	return c.Encode(data, EncodeFormatJSON, marshalJSON)
+}
+
+func marshalJSON(data Data) ([]byte, error) {
+	return jsoniter.Marshal(data)
+}
+
+// EncodeMsgpack 返回 data 序列化为 msgpack 的结果
+func (c *EncodeCache) EncodeMsgpack(data Data) ([]byte, error) {
+	return c.Encode(data, EncodeFormatMsgpack, marshalMsgpack)
+}
+
+// EncodeProtobuf 返回 data 按照 schema 序列化为 protobuf 的结果
+func (c *EncodeCache) EncodeProtobuf(data Data, schema *ProtoSchema) ([]byte, error) {
+	return c.Encode(data, schema.format, schema.Marshal)
+}
+
+// Stats 返回命中和未命中缓存的次数
+func (c *EncodeCache) Stats() (hits, misses int64) {
+	if c == nil {
+		return 0, 0
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.hits, c.misses
+}
+
+// Reset 清空缓存，释放对数据的引用
+func (c *EncodeCache) Reset() {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	c.entries = make(map[encodeKey]encodeEntry)
+	c.mutex.Unlock()
+}
@@ -0,0 +1,87 @@
+package sender
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+type cacheSender struct {
+	name  string
+	cache *EncodeCache
+}
+
+func (s *cacheSender) Name() string                      { return s.name }
+func (s *cacheSender) Send([]Data) error                 { return nil }
+func (s *cacheSender) Close() error                      { return nil }
+func (s *cacheSender) SetEncodeCache(cache *EncodeCache) { s.cache = cache }
+
+type plainSender struct{}
+
+func (s *plainSender) Name() string      { return "plain" }
+func (s *plainSender) Send([]Data) error { return nil }
+func (s *plainSender) Close() error      { return nil }
+
+func TestEncodeCache(t *testing.T) {
+	var calls int
+	encode := func(d Data) ([]byte, error) {
+		calls++
+		if d["bad"] != nil {
+			return nil, errors.New("bad data")
+		}
+		return []byte(fmt.Sprint(d["a"])), nil
+	}
+
+	var nilCache *EncodeCache
+	bytes, err := nilCache.Encode(Data{"a": 1}, "f", encode)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", string(bytes))
+	nilCache.Reset()
+
+	cache := NewEncodeCache()
+	d1, d2 := Data{"a": 1}, Data{"a": 2}
+	for i := 0; i < 3; i++ {
+		bytes, err = cache.Encode(d1, "f", encode)
+		assert.NoError(t, err)
+		assert.Equal(t, "1", string(bytes))
+		bytes, err = cache.Encode(d2, "f", encode)
+		assert.NoError(t, err)
+		assert.Equal(t, "2", string(bytes))
+	}
+	assert.Equal(t, 3, calls)
+	// 不同格式分别缓存
+	_, err = cache.Encode(d1, "g", encode)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, calls)
+	hits, misses := cache.Stats()
+	assert.EqualValues(t, 4, hits)
+	assert.EqualValues(t, 3, misses)
+
+	// 序列化失败不缓存
+	bad := Data{"bad": true}
+	_, err = cache.Encode(bad, "f", encode)
+	assert.Error(t, err)
+	_, err = cache.Encode(bad, "f", encode)
+	assert.Error(t, err)
+	assert.Equal(t, 6, calls)
+
+	cache.Reset()
+	_, err = cache.Encode(d1, "f", encode)
+	assert.NoError(t, err)
+	assert.Equal(t, 7, calls)
+}
+
+func TestShareEncodeCache(t *testing.T) {
+	s1, s2 := &cacheSender{name: "s1"}, &cacheSender{name: "s2"}
+	assert.Nil(t, ShareEncodeCache([]Sender{s1, &plainSender{}}))
+	assert.Nil(t, s1.cache)
+
+	cache := ShareEncodeCache([]Sender{s1, &plainSender{}, s2})
+	assert.NotNil(t, cache)
+	assert.Equal(t, cache, s1.cache)
+	assert.Equal(t, cache, s2.cache)
+}
@@ -0,0 +1,117 @@
+package sender
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/qiniu/pandora-go-sdk/base/reqerr"
+
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+// ErrorClass 发送错误的分类，决定失败的数据如何处理
+type ErrorClass string
+
+const (
+	// ErrorClassRetryable 网络超时、连接失败、服务端 5xx、限流等暂时性的错误，等待后重试
+	ErrorClassRetryable ErrorClass = "retryable"
+	// ErrorClassRejected 数据格式、schema 不符合要求等被服务端拒绝的错误，重试也不会成功，不再重试
+	ErrorClassRejected ErrorClass = "rejected"
+	// ErrorClassAuth 认证或鉴权失败，需要修改配置后才能恢复，暂停发送并告警
+	ErrorClassAuth ErrorClass = "auth"
+)
+
+const (
+	// TypeRejected 表示数据被服务端拒绝，重试不会成功
+	TypeRejected = reqerr.SendErrorType("Data Rejected")
+	// TypeAuthFailed 表示认证或鉴权失败
+	TypeAuthFailed = reqerr.SendErrorType("Auth Failed")
+)
+
+// ErrDeadLetterFull 死信文件已经达到最大字节数
+var ErrDeadLetterFull = errors.New("dead letter file is full")
+
+// HTTPStatusError 服务端返回了非成功的状态码，sender 返回该错误以便根据状态码对错误分类
+type HTTPStatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *HTTPStatusError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("response code is %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return e.Body
+}
+
+// StatusErrorType 根据 HTTP 状态码返回对应的 SendErrorType
+func StatusErrorType(code int) reqerr.SendErrorType {
+	switch code {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusProxyAuthRequired:
+		return TypeAuthFailed
+	case http.StatusRequestEntityTooLarge:
+		return reqerr.TypeBinaryUnpack
+	case http.StatusBadRequest, http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusGone,
+		http.StatusUnsupportedMediaType, http.StatusUnprocessableEntity:
+		return TypeRejected
+	}
+	return reqerr.TypeDefault
+}
+
+// StatusesErrorType 根据一批数据各自失败的状态码返回整体的 SendErrorType，
+// 只要有可以重试的失败就返回 deft，否则有认证失败时返回 TypeAuthFailed，其余返回 TypeRejected
+func StatusesErrorType(codes []int, deft reqerr.SendErrorType) reqerr.SendErrorType {
+	if len(codes) == 0 {
+		return deft
+	}
+	ret := TypeRejected
+	for _, code := range codes {
+		switch StatusErrorType(code) {
+		case TypeRejected:
+		case TypeAuthFailed:
+			ret = TypeAuthFailed
+		default:
+			return deft
+		}
+	}
+	return ret
+}
+
+// ErrorTypeOf 根据客户端返回的原始错误返回对应的 SendErrorType，无法判断时返回 TypeDefault
+func ErrorTypeOf(err error) reqerr.SendErrorType {
+	switch e := err.(type) {
+	case *HTTPStatusError:
+		return StatusErrorType(e.StatusCode)
+	case *reqerr.RequestError:
+		return StatusErrorType(e.StatusCode)
+	case *reqerr.SendError:
+		return e.ErrorType
+	}
+	return reqerr.TypeDefault
+}
+
+// ClassOf 返回 SendErrorType 对应的错误分类
+func ClassOf(t reqerr.SendErrorType) ErrorClass {
+	switch t {
+	case TypeRejected, TypeMarshalError:
+		return ErrorClassRejected
+	case TypeAuthFailed:
+		return ErrorClassAuth
+	}
+	return ErrorClassRetryable
+}
+
+// ClassifyError 返回 sender 发送错误的分类，err 为 nil 时返回空
+func ClassifyError(err error) ErrorClass {
+	if err == nil {
+		return ""
+	}
+	if se, ok := err.(*StatsError); ok {
+		if se.SendError == nil {
+			return ErrorClassRetryable
+		}
+		return ClassOf(se.SendError.ErrorType)
+	}
+	return ClassOf(ErrorTypeOf(err))
+}
@@ -0,0 +1,80 @@
+package sender
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/qiniu/pandora-go-sdk/base/reqerr"
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+func TestClassifyError(t *testing.T) {
+	assert.Equal(t, ErrorClass(""), ClassifyError(nil))
+	assert.Equal(t, ErrorClassRetryable, ClassifyError(errors.New("connection refused")))
+	assert.Equal(t, ErrorClassRetryable, ClassifyError(&HTTPStatusError{StatusCode: 503}))
+	assert.Equal(t, ErrorClassRetryable, ClassifyError(&HTTPStatusError{StatusCode: 429}))
+	assert.Equal(t, ErrorClassRejected, ClassifyError(&HTTPStatusError{StatusCode: 400}))
+	assert.Equal(t, ErrorClassAuth, ClassifyError(&HTTPStatusError{StatusCode: 401}))
+	assert.Equal(t, ErrorClassAuth, ClassifyError(&reqerr.RequestError{Message: "unauthorized", StatusCode: 403}))
+	assert.Equal(t, ErrorClassRejected, ClassifyError(reqerr.NewSendError("marshal", nil, TypeMarshalError)))
+	assert.Equal(t, ErrorClassRetryable, ClassifyError(reqerr.NewSendError("unpack", nil, reqerr.TypeBinaryUnpack)))
+	assert.Equal(t, ErrorClassRetryable, ClassifyError(&StatsError{}))
+	assert.Equal(t, ErrorClassAuth, ClassifyError(&StatsError{SendError: reqerr.NewSendError("auth", nil, TypeAuthFailed)}))
+
+	assert.Equal(t, TypeRejected, StatusesErrorType([]int{400, 404}, reqerr.TypeDefault))
+	assert.Equal(t, TypeAuthFailed, StatusesErrorType([]int{400, 401}, reqerr.TypeDefault))
+	assert.Equal(t, reqerr.TypeBinaryUnpack, StatusesErrorType([]int{400, 500}, reqerr.TypeBinaryUnpack))
+	assert.Equal(t, reqerr.TypeDefault, StatusesErrorType(nil, reqerr.TypeDefault))
+}
+
+func TestDeadLetter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestDeadLetter")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "runner", "sender.deadletter")
+	dl, err := NewDeadLetter(path, 300)
+	assert.NoError(t, err)
+	n, err := dl.Write("s", errors.New("bad schema"), []Data{{"a": 1}})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	n, err = dl.WriteRaw("s", errors.New("bad line"), []string{"line1"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	// 超过最大字节数后丢弃
+	n, err = dl.WriteRaw("s", errors.New("bad line"), []string{"line2", "line3", "line4"})
+	assert.Equal(t, ErrDeadLetterFull, err)
+	written, dropped := dl.Stats()
+	assert.Equal(t, int64(2+n), written)
+	assert.Equal(t, int64(3-n), dropped)
+	assert.NoError(t, dl.Close())
+
+	f, err := os.Open(path)
+	assert.NoError(t, err)
+	defer f.Close()
+	var records []DeadLetterRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var record DeadLetterRecord
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		records = append(records, record)
+	}
+	assert.Len(t, records, 2+n)
+	assert.Equal(t, "bad schema", records[0].Error)
+	assert.Equal(t, Data{"a": float64(1)}, records[0].Data)
+	assert.Equal(t, "line1", records[1].Raw)
+
+	// 重新打开时从已有的大小继续计算
+	dl, err = NewDeadLetter(path, 300)
+	assert.NoError(t, err)
+	defer dl.Close()
+	_, err = dl.WriteRaw("s", errors.New("bad line"), []string{"line5", "line6", "line7"})
+	assert.Equal(t, ErrDeadLetterFull, err)
+}
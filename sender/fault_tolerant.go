@@ -31,13 +31,28 @@ const (
 	DefaultSplitSize  = 64 * 1024 // 默认分割为 64 kb
 	// TypeMarshalError 表示marshal出错
 	TypeMarshalError = reqerr.SendErrorType("Data Marshal failed")
+	// authFailSleep 认证失败后重试前等待的秒数，修改配置之前重试不会成功
+	authFailSleep = 30
 )
 
 var _ SkipDeepCopySender = &FtSender{}
 var _ RawSender = &FtSender{}
+var _ EncodeCacheSender = &FtSender{}
+var _ QueueSender = &FtSender{}
+var _ QueueBytesSender = &FtSender{}
+var _ DeadLetterSender = &FtSender{}
+var _ ProcsSender = &FtSender{}
+var _ RequestCaptureSender = &FtSender{}
+var _ FlushSender = &FtSender{}
+
+// Flush 时检查队列是否发送完成的间隔
+const flushCheckInterval = 100 * time.Millisecond
 
 // FtSender fault tolerance sender wrapper
 type FtSender struct {
+	// inflight 已经从队列中取出还未发送完成的数据批数，原子操作，放在开头保证 32 位平台上 64 位对齐
+	inflight int64
+
 	stopped         int32
 	exitChan        chan struct{}
 	innerSender     Sender
@@ -46,6 +61,7 @@ type FtSender struct {
 	writeLimit      int // 写入速度限制，单位MB
 	strategy        string
 	procs           int //发送并发数
+	procsMutex      sync.Mutex
 	runnerName      string
 	opt             *FtOption
 	stats           StatsInfo
@@ -53,6 +69,10 @@ type FtSender struct {
 	jsontool        jsoniter.API
 	pandoraKeyCache map[string]KeyInfo
 	discardErr      bool
+
+	deadLetterMux sync.RWMutex
+	rejects       bool        // 设置了 SetDeadLetter 之后被拒绝的数据不再重试
+	deadLetter    *DeadLetter // 被拒绝的数据写入的死信文件，为 nil 时丢弃
 }
 
 type FtOption struct {
@@ -70,6 +90,7 @@ type FtOption struct {
 	maxSizePerFile    int32
 	discardErr        bool
 	sendRaw           bool
+	rateSchedule      *RateSchedule // 按时间段发送限速，为 nil 时不限速
 }
 
 type datasContext struct {
@@ -101,6 +122,16 @@ func NewFtSender(innerSender Sender, conf conf.MapConf, ftSaveLogPath string) (*
 		MaxProcs = NumCPU
 	}
 	procs, _ := conf.GetIntOr(KeyFtProcs, MaxProcs)
+	var rateSchedule *RateSchedule
+	rateLimit, _ := conf.GetInt64Or(KeyFtRateLimit, 0)
+	schedule, _ := conf.GetStringOr(KeyFtRateSchedule, "")
+	if rateLimit > 0 || schedule != "" {
+		var err error
+		rateSchedule, err = ParseRateSchedule(schedule, rateLimit)
+		if err != nil {
+			return nil, err
+		}
+	}
 	sendraw, _ := conf.GetBoolOr(InnerSendRaw, false)
 	if sendraw {
 		_, ok := innerSender.(RawSender)
@@ -124,6 +155,7 @@ func NewFtSender(innerSender Sender, conf conf.MapConf, ftSaveLogPath string) (*
 		maxSizePerFile:    maxSizePerFile,
 		discardErr:        discardErr,
 		sendRaw:           sendraw,
+		rateSchedule:      rateSchedule,
 	}
 
 	return newFtSender(innerSender, runnerName, opt)
@@ -193,7 +225,8 @@ func newFtSender(innerSender Sender, runnerName string, opt *FtOption) (*FtSende
 	if opt.innerSenderType == TypePandora {
 		ftSender.pandoraKeyCache = make(map[string]KeyInfo)
 	}
-	go ftSender.asyncSendLogFromQueue()
+	// 只启动发送协程，同步调用保证 SetProcs 之前 procs 个协程已经启动
+	ftSender.asyncSendLogFromQueue()
 	return &ftSender, nil
 }
 
@@ -333,6 +366,45 @@ func (ft *FtSender) Send(datas []Data) error {
 	return se
 }
 
+// QueueDepth 返回磁盘队列以及备份队列中等待发送的数据批数
+func (ft *FtSender) QueueDepth() int64 {
+	return ft.BackupQueue.Depth() + ft.logQueue.Depth()
+}
+
+// Flush 等待队列中以及正在发送的数据全部发送完成，然后 flush 内部的 sender，
+// 超过 timeout 仍未发送完成时返回剩余的数据批数，发送失败的数据会一直重试，因此不会提前返回
+func (ft *FtSender) Flush(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		pending := ft.QueueDepth() + atomic.LoadInt64(&ft.inflight)
+		if pending == 0 {
+			break
+		}
+		if ft.isStopped() {
+			return fmt.Errorf("sender is stopped with %d batches pending", pending)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%d batches are still pending after %v", pending, timeout)
+		}
+		time.Sleep(flushCheckInterval)
+	}
+	if fs, ok := ft.innerSender.(FlushSender); ok {
+		return fs.Flush(time.Until(deadline))
+	}
+	return nil
+}
+
+// QueueBytes 返回磁盘队列以及备份队列占用的磁盘字节数
+func (ft *FtSender) QueueBytes() int64 {
+	var size int64
+	for _, q := range []queue.BackendQueue{ft.logQueue, ft.BackupQueue} {
+		if dq, ok := q.(queue.DiskUsageQueue); ok {
+			size += dq.DiskUsedBytes()
+		}
+	}
+	return size
+}
+
 func (ft *FtSender) Stats() StatsInfo {
 	ft.statsMutex.RLock()
 	defer ft.statsMutex.RUnlock()
@@ -358,8 +430,11 @@ func (ft *FtSender) Close() error {
 	log.Warnf("Runner[%v] wait for Sender[%v] to completely exit", ft.runnerName, ft.Name())
 	// 等待错误恢复流程退出
 	<-ft.exitChan
-	// 等待正常发送流程退出
-	for i := 0; i < ft.procs; i++ {
+	// 等待正常发送流程退出，stopped 设置之后并发数不会再增加
+	ft.procsMutex.Lock()
+	procs := ft.procs
+	ft.procsMutex.Unlock()
+	for i := 0; i < procs; i++ {
 		<-ft.exitChan
 	}
 
@@ -379,6 +454,20 @@ func (ft *FtSender) TokenRefresh(mapConf conf.MapConf) (err error) {
 	return
 }
 
+func (ft *FtSender) CapturedRequests() []CapturedRequest {
+	if cs, ok := ft.innerSender.(RequestCaptureSender); ok {
+		return cs.CapturedRequests()
+	}
+	return nil
+}
+
+func (ft *FtSender) ReplayRequest(id int64) (*CapturedRequest, error) {
+	if cs, ok := ft.innerSender.(RequestCaptureSender); ok {
+		return cs.ReplayRequest(id)
+	}
+	return nil, fmt.Errorf("sender %v does not support request capture", ft.innerSender.Name())
+}
+
 // marshalData 将数据序列化
 func (ft *FtSender) marshalRaws(datas []string) ([]byte, error) {
 	bs, err := jsoniter.Marshal(&datasContext{
@@ -455,19 +544,7 @@ func (ft *FtSender) saveToFile(datas []Data) error {
 
 func (ft *FtSender) asyncSendLogFromQueue() {
 	for i := 0; i < ft.procs; i++ {
-		if ft.opt.sendRaw {
-			readLinesChan := make(<-chan []string)
-			if dqueue, ok := ft.logQueue.(queue.LinesQueue); ok {
-				readLinesChan = dqueue.ReadLinesChan()
-			}
-			go ft.sendRawFromQueue(ft.logQueue.Name(), ft.logQueue.ReadChan(), readLinesChan, false)
-		} else {
-			readDatasChan := make(<-chan []Data)
-			if dqueue, ok := ft.logQueue.(queue.DataQueue); ok {
-				readDatasChan = dqueue.ReadDatasChan()
-			}
-			go ft.sendFromQueue(ft.logQueue.Name(), ft.logQueue.ReadChan(), readDatasChan, false)
-		}
+		ft.startQueueSender()
 	}
 	if ft.opt.sendRaw {
 		readLinesChan := make(<-chan []string)
@@ -478,6 +555,43 @@ func (ft *FtSender) asyncSendLogFromQueue() {
 	}
 }
 
+// startQueueSender 启动一个从 logQueue 中读取数据发送的协程
+func (ft *FtSender) startQueueSender() {
+	if ft.opt.sendRaw {
+		readLinesChan := make(<-chan []string)
+		if dqueue, ok := ft.logQueue.(queue.LinesQueue); ok {
+			readLinesChan = dqueue.ReadLinesChan()
+		}
+		go ft.sendRawFromQueue(ft.logQueue.Name(), ft.logQueue.ReadChan(), readLinesChan, false)
+	} else {
+		readDatasChan := make(<-chan []Data)
+		if dqueue, ok := ft.logQueue.(queue.DataQueue); ok {
+			readDatasChan = dqueue.ReadDatasChan()
+		}
+		go ft.sendFromQueue(ft.logQueue.Name(), ft.logQueue.ReadChan(), readDatasChan, false)
+	}
+}
+
+// Procs 返回当前发送 logQueue 中数据的并发数
+func (ft *FtSender) Procs() int {
+	ft.procsMutex.Lock()
+	defer ft.procsMutex.Unlock()
+	return ft.procs
+}
+
+// SetProcs 增加发送 logQueue 中数据的协程，sender 关闭后不再增加
+func (ft *FtSender) SetProcs(procs int) int {
+	ft.procsMutex.Lock()
+	defer ft.procsMutex.Unlock()
+	if atomic.LoadInt32(&ft.stopped) > 0 {
+		return ft.procs
+	}
+	for ; ft.procs < procs; ft.procs++ {
+		ft.startQueueSender()
+	}
+	return ft.procs
+}
+
 // trySend 从bytes反序列化数据后尝试发送数据
 func (ft *FtSender) trySendBytes(dat []byte, failSleep int, isRetry bool) (backDataContext []*datasContext, err error) {
 	if ft.opt.sendRaw {
@@ -499,6 +613,13 @@ func (ft *FtSender) trySendRaws(datas []string, failSleep int, isRetry bool) (ba
 	if !ok {
 		return nil, errors.New("inner sender not support Raw Sender")
 	}
+	if ft.opt.rateSchedule != nil {
+		var size int64
+		for _, line := range datas {
+			size += int64(len(line))
+		}
+		ft.opt.rateSchedule.Wait(size, ft.isStopped)
+	}
 	err = rawSender.RawSend(datas)
 	dataLen := int64(len(datas))
 
@@ -506,6 +627,17 @@ func (ft *FtSender) trySendRaws(datas []string, failSleep int, isRetry bool) (ba
 	if empty := isErrorEmpty(err); empty {
 		return nil, nil
 	}
+	switch ClassifyError(err) {
+	case ErrorClassRejected:
+		if ft.rejectEnabled() {
+			ft.reject(err, nil, datas)
+			return nil, err
+		}
+	case ErrorClassAuth:
+		if failSleep < authFailSleep {
+			failSleep = authFailSleep
+		}
+	}
 
 	retDatasContext := ft.handleRawSendError(err, datas)
 	for _, v := range retDatasContext {
@@ -529,6 +661,13 @@ func (ft *FtSender) trySendRaws(datas []string, failSleep int, isRetry bool) (ba
 // trySendDatas 尝试发送数据，如果失败，将失败数据加入backup queue，并睡眠指定时间。返回结果为是否正常发送
 // isRetry 只有在 FtStrategy 为 BackupOnly 或
 func (ft *FtSender) trySendDatas(datas []Data, failSleep int, isRetry bool) (backDataContext []*datasContext, err error) {
+	if ft.opt.rateSchedule != nil {
+		var size int64
+		for _, data := range datas {
+			size += estimateSize(data)
+		}
+		ft.opt.rateSchedule.Wait(size, ft.isStopped)
+	}
 	err = ft.innerSender.Send(datas)
 	dataLen := int64(0)
 	if datas != nil {
@@ -539,6 +678,22 @@ func (ft *FtSender) trySendDatas(datas []Data, failSleep int, isRetry bool) (bac
 	if empty := isErrorEmpty(err); empty {
 		return nil, nil
 	}
+	switch ClassifyError(err) {
+	case ErrorClassRejected:
+		if !ft.rejectEnabled() {
+			break
+		}
+		failDatas := datas
+		if se, ok := err.(*StatsError); ok && se.SendError != nil {
+			failDatas = ConvertDatas(se.SendError.GetFailDatas())
+		}
+		ft.reject(err, failDatas, nil)
+		return nil, err
+	case ErrorClassAuth:
+		if failSleep < authFailSleep {
+			failSleep = authFailSleep
+		}
+	}
 
 	retDatasContext := ft.handleSendError(err, datas)
 	for _, v := range retDatasContext {
@@ -818,15 +973,19 @@ func (ft *FtSender) sendRawFromQueue(queueName string, readChan <-chan []byte, r
 		if curIdx < len(curDataContext) {
 			backDataContext, err = ft.trySendRaws(curDataContext[curIdx].Lines, numWaits, isRetry)
 			curIdx++
+			atomic.AddInt64(&ft.inflight, -1)
 		} else {
 			select {
 			case bytes := <-readChan:
+				atomic.AddInt64(&ft.inflight, 1)
 				backDataContext, err = ft.trySendBytes(bytes, numWaits, isRetry)
 			case datas := <-readDatasChan:
+				atomic.AddInt64(&ft.inflight, 1)
 				backDataContext, err = ft.trySendRaws(datas, numWaits, isRetry)
 			case <-timer.C:
 				continue
 			}
+			atomic.AddInt64(&ft.inflight, -1)
 		}
 		if err == nil {
 			numWaits = 1
@@ -840,6 +999,8 @@ func (ft *FtSender) sendRawFromQueue(queueName string, readChan <-chan []byte, r
 			}
 		}
 		if backDataContext != nil {
+			// 拆分后需要重试的数据同样算作正在发送
+			atomic.AddInt64(&ft.inflight, int64(len(backDataContext)))
 			otherDataContext = append(otherDataContext, backDataContext...)
 		}
 		if curIdx == len(curDataContext) {
@@ -866,15 +1027,19 @@ func (ft *FtSender) sendFromQueue(queueName string, readChan <-chan []byte, read
 		if curIdx < len(curDataContext) {
 			backDataContext, err = ft.trySendDatas(curDataContext[curIdx].Datas, numWaits, isRetry)
 			curIdx++
+			atomic.AddInt64(&ft.inflight, -1)
 		} else {
 			select {
 			case bytes := <-readChan:
+				atomic.AddInt64(&ft.inflight, 1)
 				backDataContext, err = ft.trySendBytes(bytes, numWaits, isRetry)
 			case datas := <-readDatasChan:
+				atomic.AddInt64(&ft.inflight, 1)
 				backDataContext, err = ft.trySendDatas(datas, numWaits, isRetry)
 			case <-timer.C:
 				continue
 			}
+			atomic.AddInt64(&ft.inflight, -1)
 		}
 		if err == nil {
 			numWaits = 1
@@ -888,6 +1053,8 @@ func (ft *FtSender) sendFromQueue(queueName string, readChan <-chan []byte, read
 			}
 		}
 		if backDataContext != nil {
+			// 拆分后需要重试的数据同样算作正在发送
+			atomic.AddInt64(&ft.inflight, int64(len(backDataContext)))
 			otherDataContext = append(otherDataContext, backDataContext...)
 		}
 		if curIdx == len(curDataContext) {
@@ -898,6 +1065,10 @@ func (ft *FtSender) sendFromQueue(queueName string, readChan <-chan []byte, read
 	}
 }
 
+func (ft *FtSender) isStopped() bool {
+	return atomic.LoadInt32(&ft.stopped) > 0
+}
+
 func (ft *FtSender) SkipDeepCopy() bool {
 	ss, ok := ft.innerSender.(SkipDeepCopySender)
 	if ok {
@@ -906,6 +1077,52 @@ func (ft *FtSender) SkipDeepCopy() bool {
 	return false
 }
 
+// SetDeadLetter 设置被拒绝的数据写入的死信文件，之后被拒绝的数据不再放回队列重试，dl 为 nil 时丢弃
+func (ft *FtSender) SetDeadLetter(dl *DeadLetter) {
+	ft.deadLetterMux.Lock()
+	ft.rejects = true
+	ft.deadLetter = dl
+	ft.deadLetterMux.Unlock()
+}
+
+func (ft *FtSender) rejectEnabled() bool {
+	ft.deadLetterMux.RLock()
+	defer ft.deadLetterMux.RUnlock()
+	return ft.rejects
+}
+
+// reject 被服务端拒绝的数据重试也不会成功，写入死信文件后不再放回队列
+func (ft *FtSender) reject(err error, datas []Data, lines []string) {
+	ft.deadLetterMux.RLock()
+	dl := ft.deadLetter
+	ft.deadLetterMux.RUnlock()
+	total := len(datas) + len(lines)
+	if dl == nil {
+		log.Errorf("Runner[%v] Sender[%v] %d datas are rejected and discarded: %v", ft.runnerName, ft.innerSender.Name(), total, err)
+		return
+	}
+	var (
+		written int
+		werr    error
+	)
+	if lines != nil {
+		written, werr = dl.WriteRaw(ft.innerSender.Name(), err, lines)
+	} else {
+		written, werr = dl.Write(ft.innerSender.Name(), err, datas)
+	}
+	if werr != nil {
+		log.Errorf("Runner[%v] Sender[%v] write rejected datas to dead letter %v error %v, %d datas are discarded", ft.runnerName, ft.innerSender.Name(), dl.Path(), werr, total-written)
+	}
+	log.Warnf("Runner[%v] Sender[%v] %d datas are rejected and written to dead letter %v: %v", ft.runnerName, ft.innerSender.Name(), written, dl.Path(), err)
+}
+
+// SetEncodeCache 只有内部的 sender 支持时才会设置，容错队列中恢复的数据是新的对象，不会命中缓存
+func (ft *FtSender) SetEncodeCache(cache *EncodeCache) {
+	if cs, ok := ft.innerSender.(EncodeCacheSender); ok {
+		cs.SetEncodeCache(cache)
+	}
+}
+
 //优先使用'\n'对数据进行切分，切分后单个分片仍大于batchsize再按指定大小进行切分
 func SplitData(data string) (valArray []string) {
 	start := 0
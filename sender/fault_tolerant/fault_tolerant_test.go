@@ -450,6 +450,12 @@ func TestFtSenderConcurrent(t *testing.T) {
 		{"cd": "cdcdcd"},
 	}
 	for i := 0; i < 100; i++ {
+		if i == 50 {
+			// 运行时增加并发数，不会减少
+			assert.Equal(t, 3, fts.Procs())
+			assert.Equal(t, 5, fts.SetProcs(5))
+			assert.Equal(t, 5, fts.SetProcs(2))
+		}
 		err = fts.Send(datas)
 		se, ok := err.(*StatsError)
 		if !ok {
@@ -458,6 +464,7 @@ func TestFtSenderConcurrent(t *testing.T) {
 		assert.Nil(t, se.SendError)
 	}
 	fts.Close()
+	assert.Equal(t, 5, fts.SetProcs(8))
 	ms := s.(*mock.Sender)
 	assert.Equal(t, 100, ms.SendCount())
 	assert.Equal(t, len(datas)*100, len(ms.Datas))
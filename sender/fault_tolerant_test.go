@@ -2,13 +2,19 @@ package sender
 
 import (
 	"errors"
+	"io/ioutil"
+	"os"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/qiniu/pandora-go-sdk/base/reqerr"
 
+	"github.com/qiniu/logkit/conf"
+	. "github.com/qiniu/logkit/sender/config"
 	"github.com/qiniu/logkit/utils/models"
 )
 
@@ -141,3 +147,64 @@ func Test_isErrorEmpty(t *testing.T) {
 		assert.Equal(t, test.res, isErrorEmpty(test.err))
 	}
 }
+
+// flushTestSender 在 gate 关闭之前阻塞发送，记录 Flush 的调用次数
+type flushTestSender struct {
+	gate    chan struct{}
+	mux     sync.Mutex
+	sent    int
+	flushed int
+}
+
+func (s *flushTestSender) Name() string { return "flushTestSender" }
+
+func (s *flushTestSender) Send(datas []models.Data) error {
+	<-s.gate
+	s.mux.Lock()
+	s.sent += len(datas)
+	s.mux.Unlock()
+	return nil
+}
+
+func (s *flushTestSender) Flush(timeout time.Duration) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.flushed++
+	return nil
+}
+
+func (s *flushTestSender) Close() error { return nil }
+
+func TestFtSenderFlush(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestFtSenderFlush")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	inner := &flushTestSender{gate: make(chan struct{})}
+	ft, err := NewFtSender(inner, conf.MapConf{
+		KeyFtStrategy:      KeyFtStrategyAlwaysSave,
+		KeyFtMemoryChannel: "true",
+		KeyFtProcs:         "1",
+	}, dir)
+	require.NoError(t, err)
+	defer ft.Close()
+
+	// 数据放入队列后返回的 StatsError 中只有统计信息
+	for i := 1; i <= 2; i++ {
+		se, ok := ft.Send([]models.Data{{"a": i}}).(*models.StatsError)
+		require.True(t, ok)
+		assert.Equal(t, int64(0), se.Errors)
+	}
+	// 内部 sender 阻塞时 flush 超时，返回剩余的批数，不会 flush 内部 sender
+	err = ft.Flush(300 * time.Millisecond)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "2 batches are still pending")
+	assert.Equal(t, 0, inner.flushed)
+
+	close(inner.gate)
+	assert.NoError(t, ft.Flush(5*time.Second))
+	inner.mux.Lock()
+	defer inner.mux.Unlock()
+	assert.Equal(t, 2, inner.sent)
+	assert.Equal(t, 1, inner.flushed)
+}
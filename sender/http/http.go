@@ -10,7 +10,6 @@ import (
 	"strings"
 	"time"
 
-	"github.com/json-iterator/go"
 	"github.com/sven0726/fasttemplate"
 
 	"github.com/qiniu/pandora-go-sdk/pipeline"
@@ -23,6 +22,9 @@ import (
 )
 
 var _ sender.SkipDeepCopySender = &Sender{}
+var _ sender.EncodeCacheSender = &Sender{}
+var _ sender.RequestCaptureSender = &Sender{}
+var _ sender.RequestPreviewSender = &Sender{}
 
 type Sender struct {
 	url      string
@@ -35,6 +37,10 @@ type Sender struct {
 	client         *http.Client
 	templateRender *fasttemplate.Template
 	runnerName     string
+	encodeCache    *sender.EncodeCache
+	protoSchema    *sender.ProtoSchema
+	// capture 记录最近发送的请求，未开启时为 nil
+	capture *sender.RequestCapture
 }
 
 func init() {
@@ -58,16 +64,22 @@ func NewSender(c conf.MapConf) (sender.Sender, error) {
 	protocol, _ := c.GetStringOr(KeyHttpSenderProtocol, SendProtocolJson)
 	runnerName, _ := c.GetStringOr(KeyRunnerName, UnderfinedRunnerName)
 	timeout, _ := c.GetStringOr(KeyHttpTimeout, "30s")
+	captureRequests, _ := c.GetIntOr(KeyHttpSenderCaptureRequests, 0)
 	dur, err := time.ParseDuration(timeout)
 	if err != nil {
 		return nil, errors.New("timeout configure " + timeout + " is invalid")
 	}
+	var protoSchema *sender.ProtoSchema
 	switch protocol {
 	case SendProtocolCSV:
 		if csvSplit == "" {
 			csvSplit = "\t"
 		}
-	case SendProtocolJson, SendProtocolWholeJson, SendProtocolRaw:
+	case SendProtocolJson, SendProtocolWholeJson, SendProtocolRaw, SendProtocolMsgpack:
+	case SendProtocolProtobuf:
+		if protoSchema, err = sender.NewProtoSchemaFromConf(c); err != nil {
+			return nil, fmt.Errorf("runner[%v] create sender error, %v", runnerName, err)
+		}
 	default:
 		return nil, fmt.Errorf("runner[%v] create sender error, protocol %v is not support", runnerName, protocol)
 	}
@@ -87,6 +99,8 @@ func NewSender(c conf.MapConf) (sender.Sender, error) {
 		runnerName:     runnerName,
 		templateRender: templateRender,
 		client:         &http.Client{Timeout: dur},
+		protoSchema:    protoSchema,
+		capture:        sender.NewRequestCapture(captureRequests),
 	}
 	return httpSender, nil
 }
@@ -95,29 +109,32 @@ func (h *Sender) Name() string {
 	return "httpSender_" + h.url + "_"
 }
 
-func (h *Sender) Send(data []Data) (err error) {
-	var sendBytes []byte
+func (h *Sender) Send(data []Data) error {
+	sendBytes, err := h.encode(data)
+	if err != nil {
+		return err
+	}
+	return h.sendData(sendBytes)
+}
+
+func (h *Sender) encode(data []Data) (sendBytes []byte, err error) {
 	switch h.protocol {
 	case SendProtocolJson:
-		if sendBytes, err = h.convertToJsonBytes(data); err != nil {
-			return err
-		}
+		sendBytes, err = h.convertToJsonBytes(data)
 	case SendProtocolCSV:
-		if sendBytes, err = h.convertToCsvBytes(data); err != nil {
-			return err
-		}
+		sendBytes, err = h.convertToCsvBytes(data)
 	case SendProtocolWholeJson:
-		if sendBytes, err = h.convertToBodyJsonBytes(data); err != nil {
-			return err
-		}
+		sendBytes, err = h.convertToBodyJsonBytes(data)
 	case SendProtocolRaw:
-		if sendBytes, err = h.convertToRawBytes(data); err != nil {
-			return err
-		}
+		sendBytes, err = h.convertToRawBytes(data)
+	case SendProtocolMsgpack:
+		sendBytes, err = h.convertToMsgpackBytes(data)
+	case SendProtocolProtobuf:
+		sendBytes, err = h.convertToProtobufBytes(data)
 	default:
-		return fmt.Errorf("runner[%v] Sender[%v] send data error, protocol %v is not support", h.runnerName, h.Name(), h.protocol)
+		err = fmt.Errorf("runner[%v] Sender[%v] send data error, protocol %v is not support", h.runnerName, h.Name(), h.protocol)
 	}
-	return h.sendData(sendBytes)
+	return sendBytes, err
 }
 
 func (h *Sender) Close() error {
@@ -128,7 +145,7 @@ func (h *Sender) renderTemplate(data Data) (string, error) {
 	if h.template != "" && h.templateRender != nil {
 		return h.templateRender.ExecuteString(data), nil
 	} else {
-		db, err := jsoniter.Marshal(data)
+		db, err := h.encodeCache.EncodeJSON(data)
 		if err != nil {
 			return "", err
 		}
@@ -184,6 +201,32 @@ func (h *Sender) convertToBodyJsonBytes(datas []Data) (byteData []byte, err erro
 	return byteData, nil
 }
 
+// convertToMsgpackBytes 整批数据序列化为一个 msgpack array
+func (h *Sender) convertToMsgpackBytes(datas []Data) ([]byte, error) {
+	byteData := sender.AppendMsgpackArrayHeader(nil, len(datas))
+	for _, data := range datas {
+		db, err := h.encodeCache.EncodeMsgpack(data)
+		if err != nil {
+			return nil, err
+		}
+		byteData = append(byteData, db...)
+	}
+	return byteData, nil
+}
+
+// convertToProtobufBytes 每条数据序列化为 protobuf message，并加上 varint 编码的长度前缀
+func (h *Sender) convertToProtobufBytes(datas []Data) ([]byte, error) {
+	var byteData []byte
+	for _, data := range datas {
+		db, err := h.encodeCache.EncodeProtobuf(data, h.protoSchema)
+		if err != nil {
+			return nil, err
+		}
+		byteData = sender.AppendProtoDelimited(byteData, db)
+	}
+	return byteData, nil
+}
+
 func (h *Sender) convertToCsvBytes(datas []Data) (byteData []byte, err error) {
 	keySet := NewHashSet()
 	for _, data := range datas {
@@ -224,45 +267,112 @@ func (h *Sender) convertToCsvBytes(datas []Data) (byteData []byte, err error) {
 	return
 }
 
-func (h *Sender) sendData(byteData []byte) (err error) {
+func (h *Sender) sendData(byteData []byte) error {
+	req, body, err := h.newRequest(byteData)
+	if err != nil {
+		return err
+	}
+	return h.do(req, body, 0)
+}
+
+// newRequest 生成发送 byteData 的请求，同时返回实际发送的请求体
+func (h *Sender) newRequest(byteData []byte) (req *http.Request, body []byte, err error) {
 	if h.gZip {
 		if byteData, err = gzipData(byteData); err != nil {
 			log.Errorf("Runner[%v] Sender[%v] write gzip error %v\n", h.runnerName, h.Name(), err)
-			return err
+			return nil, nil, err
 		}
 	}
-	req, err := http.NewRequest(http.MethodPost, h.url, bytes.NewReader(byteData))
+	req, err = http.NewRequest(http.MethodPost, h.url, bytes.NewReader(byteData))
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 	switch h.protocol {
 	case SendProtocolJson, SendProtocolWholeJson:
 		req.Header.Set(ContentTypeHeader, ApplicationJson)
 	case SendProtocolCSV, SendProtocolRaw:
 		req.Header.Set(ContentTypeHeader, TextPlain)
+	case SendProtocolMsgpack:
+		req.Header.Set(ContentTypeHeader, ApplicationMsgpack)
+	case SendProtocolProtobuf:
+		req.Header.Set(ContentTypeHeader, ApplicationProtobuf)
 	default:
 	}
 	if h.gZip {
 		req.Header.Set(ContentEncodingHeader, "gzip")
 	}
+	return req, byteData, nil
+}
+
+// do 发送请求，开启了请求记录时记录请求以及响应，replayOf 不为 0 时表示重放该 id 的请求
+func (h *Sender) do(req *http.Request, body []byte, replayOf int64) error {
+	start := time.Now()
 	resp, err := h.client.Do(req)
 	if err != nil {
+		h.capture.Add(req, body, nil, nil, err, time.Since(start), replayOf)
 		log.Errorf("Runner[%v] Sender[%v] post data error %v\n", h.runnerName, h.Name(), err)
 		return err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		body, err := ioutil.ReadAll(resp.Body)
+		respBody, err := ioutil.ReadAll(resp.Body)
+		h.capture.Add(req, body, resp, respBody, err, time.Since(start), replayOf)
 		if err != nil {
 			log.Errorf("Runner[%v] Sender[%v] read response body error %v\n", h.runnerName, h.Name(), err)
 			return err
 		}
-		log.Errorf("Runner[%v] Sender[%v] response code is %v, response body is %v\n", h.runnerName, h.Name(), resp.StatusCode, string(body))
-		return fmt.Errorf(string(body))
+		log.Errorf("Runner[%v] Sender[%v] response code is %v, response body is %v\n", h.runnerName, h.Name(), resp.StatusCode, string(respBody))
+		return &sender.HTTPStatusError{StatusCode: resp.StatusCode, Body: string(respBody)}
 	}
+	h.capture.Add(req, body, resp, nil, nil, time.Since(start), replayOf)
 	return nil
 }
 
+// CapturedRequests 返回最近发送的请求，需要配置 http_sender_capture_requests
+func (h *Sender) CapturedRequests() []sender.CapturedRequest {
+	return h.capture.List()
+}
+
+// ReplayRequest 按记录的原样重新发送请求，重放的结果不计入 sender 的统计
+func (h *Sender) ReplayRequest(id int64) (*sender.CapturedRequest, error) {
+	if h.capture == nil {
+		return nil, fmt.Errorf("request capture is not enabled, please set %v", KeyHttpSenderCaptureRequests)
+	}
+	req := h.capture.Get(id)
+	if req == nil {
+		return nil, fmt.Errorf("request %d is not found or has been overwritten", id)
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	h.do(req, body, id)
+	for _, r := range h.capture.List() {
+		if r.ReplayOf == id {
+			return &r, nil
+		}
+	}
+	return nil, fmt.Errorf("request %d is replayed but the result has been overwritten", id)
+}
+
+// PreviewRequest 生成发送 datas 的请求但不发送，用于检查数据模板和格式是否符合目的端的要求
+func (h *Sender) PreviewRequest(datas []Data) (*sender.CapturedRequest, error) {
+	byteData, err := h.encode(datas)
+	if err != nil {
+		return nil, err
+	}
+	req, body, err := h.newRequest(byteData)
+	if err != nil {
+		return nil, err
+	}
+	capture := sender.NewRequestCapture(1)
+	capture.Add(req, body, nil, nil, nil, 0, 0)
+	preview := capture.List()[0]
+	preview.ID = 0
+	return &preview, nil
+}
+
 func gzipData(datas []byte) (byteData []byte, err error) {
 	var buf bytes.Buffer
 	g := gzip.NewWriter(&buf)
@@ -294,3 +404,7 @@ func (h *Sender) interfaceJoin(dataArray []interface{}, sep string) (string, err
 }
 
 func (*Sender) SkipDeepCopy() bool { return true }
+
+func (h *Sender) SetEncodeCache(cache *sender.EncodeCache) {
+	h.encodeCache = cache
+}
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"compress/gzip"
 	"io/ioutil"
+	nethttp "net/http"
+	"net/http/httptest"
 	"os"
 	"strings"
 	"sync"
@@ -622,3 +624,113 @@ func TestGzipData(t *testing.T) {
 		assert.Equal(t, val, string(data))
 	}
 }
+
+func TestHTTPSenderMsgpackAndProtobuf(t *testing.T) {
+	t.Parallel()
+	var (
+		contentType string
+		body        []byte
+	)
+	server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		contentType = r.Header.Get(ContentTypeHeader)
+		body, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	datas := []Data{{"a": int64(1)}, {"a": int64(2), "b": "x"}}
+	httpSender, err := NewSender(conf.MapConf{
+		KeyHttpSenderProtocol: SendProtocolMsgpack,
+		KeyHttpSenderUrl:      server.URL,
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, httpSender.Send(datas))
+	assert.Equal(t, ApplicationMsgpack, contentType)
+	assert.Equal(t, []byte{0x92, 0x81, 0xa1, 'a', 0x01, 0x82, 0xa1, 'a', 0x02, 0xa1, 'b', 0xa1, 'x'}, body)
+
+	_, err = NewSender(conf.MapConf{
+		KeyHttpSenderProtocol: SendProtocolProtobuf,
+		KeyHttpSenderUrl:      server.URL,
+	})
+	assert.Error(t, err)
+	httpSender, err = NewSender(conf.MapConf{
+		KeyHttpSenderProtocol: SendProtocolProtobuf,
+		KeyHttpSenderUrl:      server.URL,
+		KeyProtobufDescriptor: `message Log { int64 num = 1; string b = 2; }`,
+		KeyProtobufFieldMap:   "num=a",
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, httpSender.Send(datas))
+	assert.Equal(t, ApplicationProtobuf, contentType)
+	assert.Equal(t, []byte{2, 0x08, 0x01, 5, 0x08, 0x02, 0x12, 1, 'x'}, body)
+}
+
+func TestHTTPSenderCaptureRequests(t *testing.T) {
+	t.Parallel()
+	var (
+		mutex  sync.Mutex
+		bodies []string
+		reject = true
+	)
+	server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		gr, err := gzip.NewReader(r.Body)
+		assert.NoError(t, err)
+		body, _ := ioutil.ReadAll(gr)
+		mutex.Lock()
+		defer mutex.Unlock()
+		bodies = append(bodies, string(body))
+		if reject && strings.Contains(string(body), `"keyA":1`) {
+			w.WriteHeader(nethttp.StatusBadRequest)
+			w.Write([]byte(`{"error":"field keyA must be a string"}`))
+		}
+	}))
+	defer server.Close()
+
+	s, err := NewSender(conf.MapConf{
+		KeyHttpSenderUrl:             server.URL + "/data?access_token=abc",
+		KeyHttpSenderGzip:            "true",
+		KeyHttpSenderTemplate:        templateExp,
+		KeyHttpSenderCaptureRequests: "2",
+	})
+	assert.NoError(t, err)
+	httpSender := s.(*Sender)
+	assert.Error(t, httpSender.Send([]Data{{"a": 1, "c": "x"}}))
+	assert.NoError(t, httpSender.Send([]Data{{"a": 2, "c": "y"}}))
+
+	requests := httpSender.CapturedRequests()
+	assert.Len(t, requests, 2)
+	first := requests[1]
+	assert.Equal(t, int64(1), first.ID)
+	assert.Equal(t, nethttp.StatusBadRequest, first.StatusCode)
+	assert.Equal(t, `{"error":"field keyA must be a string"}`, first.Response)
+	assert.Equal(t, `{"keyA":1,"keyC":"x"}`, first.Body)
+	assert.Equal(t, server.URL+"/data?access_token=%2A%2A%2A%2A%2A%2A", first.URL)
+	assert.Equal(t, "gzip", first.Header.Get(ContentEncodingHeader))
+
+	// 目的端修复之后重放被拒绝的请求
+	mutex.Lock()
+	reject = false
+	mutex.Unlock()
+	replay, err := httpSender.ReplayRequest(first.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), replay.ID)
+	assert.Equal(t, first.ID, replay.ReplayOf)
+	assert.Equal(t, nethttp.StatusOK, replay.StatusCode)
+	mutex.Lock()
+	assert.Equal(t, []string{`{"keyA":1,"keyC":"x"}`, `{"keyA":2,"keyC":"y"}`, `{"keyA":1,"keyC":"x"}`}, bodies)
+	mutex.Unlock()
+	// 超出记录数的请求已经被覆盖
+	_, err = httpSender.ReplayRequest(first.ID)
+	assert.Error(t, err)
+
+	preview, err := httpSender.PreviewRequest([]Data{{"a": 3, "c": "z"}, {"a": 4, "c": "w"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "{\"keyA\":3,\"keyC\":\"z\"}\n{\"keyA\":4,\"keyC\":\"w\"}", preview.Body)
+	assert.Equal(t, ApplicationJson, preview.Header.Get(ContentTypeHeader))
+	assert.Len(t, httpSender.CapturedRequests(), 2)
+
+	s, err = NewSender(conf.MapConf{KeyHttpSenderUrl: server.URL})
+	assert.NoError(t, err)
+	assert.Nil(t, s.(*Sender).CapturedRequests())
+	_, err = s.(*Sender).ReplayRequest(1)
+	assert.Error(t, err)
+}
@@ -23,6 +23,7 @@ import (
 )
 
 var _ sender.SkipDeepCopySender = &Sender{}
+var _ sender.EncodeCacheSender = &Sender{}
 
 // Sender write datas into influxdb
 type Sender struct {
@@ -39,6 +40,7 @@ type Sender struct {
 	timestamp             string            // 时间戳列名
 	timePrec              int64
 	ignoreBeyondRetention bool
+	encodeCache           *sender.EncodeCache
 }
 
 func init() {
@@ -112,20 +114,23 @@ func (s *Sender) Close() error {
 }
 
 func (s *Sender) Send(datas []Data) error {
-	ps := Points{}
+	var (
+		lines  = make([][]byte, 0, len(datas))
+		format = s.lineFormat()
+	)
 	for _, d := range datas {
-		p, err := s.makePoint(d)
+		line, err := s.encodeCache.Encode(d, format, s.encodeLine)
 		if err != nil {
 			log.Warnf("Runner[%s] %s make point format err : %v", s.runnerName, s.Name(), err)
 			continue
 		}
-		ps = append(ps, p)
+		lines = append(lines, line)
 	}
-	err := s.sendPoints(ps)
+	buff := bytes.Join(lines, []byte{'\n'})
+	err := s.sendPoints(buff)
 	if err != nil {
 		if s.ignoreBeyondRetention && isBeyondRetentionErr(err) {
 			var partialData string
-			buff := ps.Buffer()
 			if len(buff) > 2048 {
 				partialData = string(buff[:2048])
 			} else {
@@ -134,11 +139,29 @@ func (s *Sender) Send(datas []Data) error {
 			log.Warnf("Runner[%s] %s data is out of retention and ignore_beyond_retention is true, error: %v, some of ignored data %s it", s.runnerName, s.Name(), err, partialData)
 			return nil
 		}
-		return reqerr.NewSendError(s.Name()+" Cannot write data into influxdb, error is "+err.Error(), sender.ConvertDatasBack(datas), reqerr.TypeDefault)
+		return reqerr.NewSendError(s.Name()+" Cannot write data into influxdb, error is "+err.Error(), sender.ConvertDatasBack(datas), sender.ErrorTypeOf(err))
 	}
 	return nil
 }
 
+func (s *Sender) SetEncodeCache(cache *sender.EncodeCache) {
+	s.encodeCache = cache
+}
+
+// lineFormat 返回 line protocol 的序列化格式，只有 measurement、tags、fields 和时间戳配置都相同的 sender 才能共享序列化结果
+func (s *Sender) lineFormat() string {
+	return fmt.Sprintf("influxdb:%s:%v:%v:%s:%d", s.measurement, s.tags, s.fields, s.timestamp, s.timePrec)
+}
+
+// encodeLine 将一条数据序列化为一行 line protocol
+func (s *Sender) encodeLine(d Data) ([]byte, error) {
+	p, err := s.makePoint(d)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(p.String()), nil
+}
+
 func postForm(host string, influxdbSql string, sender string) (err error) {
 	data := url.Values{}
 	data.Set("q", influxdbSql)
@@ -175,7 +198,7 @@ func CreateInfluxdbRetention(host, database, retention, duration, sender string)
 	return postForm(host, influxdbSql, sender)
 }
 
-func (s *Sender) sendPoints(ps Points) (err error) {
+func (s *Sender) sendPoints(body []byte) (err error) {
 	host := s.host
 	if !strings.HasPrefix(host, "http://") {
 		host = "http://" + host
@@ -185,7 +208,7 @@ func (s *Sender) sendPoints(ps Points) (err error) {
 	if s.retention != "" {
 		u = u + "&rp=" + s.retention
 	}
-	req, err := http.NewRequest("POST", u, bytes.NewReader(ps.Buffer()))
+	req, err := http.NewRequest("POST", u, bytes.NewReader(body))
 	if err != nil {
 		log.Errorf("%s writePoints NewRequest error: %v", s.Name(), err)
 		return
@@ -210,7 +233,7 @@ func (s *Sender) sendPoints(ps Points) (err error) {
 		return err
 	}
 	if resp.StatusCode != 204 {
-		return fmt.Errorf(strings.Replace(string(b), "\\", "", -1))
+		return &sender.HTTPStatusError{StatusCode: resp.StatusCode, Body: strings.Replace(string(b), "\\", "", -1)}
 	}
 
 	return nil
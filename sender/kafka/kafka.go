@@ -9,7 +9,6 @@ import (
 	"time"
 
 	"github.com/Shopify/sarama"
-	"github.com/json-iterator/go"
 	"github.com/rcrowley/go-metrics"
 
 	"github.com/qiniu/log"
@@ -23,15 +22,26 @@ import (
 
 var _ sender.SkipDeepCopySender = &Sender{}
 var _ sender.RawSender = &Sender{}
+var _ sender.BatchSender = &Sender{}
+var _ sender.EncodeCacheSender = &Sender{}
 
+// Sender 仅提供 at-least-once 语义。
+// 暂不支持 kafka reader 到 kafka sender 的 exactly-once 事务转发：kafka reader 基于 zookeeper 的 consumergroup 保存 offset，
+// 无法在 producer 事务中通过 TxnOffsetCommit 提交；当前 vendor 的 sarama 也只有事务相关的协议请求，没有事务 producer 的实现。
+// 需要升级 sarama 并将 kafka reader 改为 broker 端的 consumer group 后才能实现。
+// 手动实现事务协议无法做到 producer epoch 和 consumer 组 generation 的隔离，rebalance 后旧的实例仍可能提交，
+// 因此配置了 kafka_transactional_id 时直接报错，避免误以为得到了 exactly-once 语义。
 type Sender struct {
 	name  string
 	hosts []string
 	topic []string
 	cfg   *sarama.Config
 
-	lastError error //用于防止所有的错误都被 kafka熔断的错误提示刷掉
-	producer  sarama.SyncProducer
+	lastError   error //用于防止所有的错误都被 kafka熔断的错误提示刷掉
+	producer    sarama.SyncProducer
+	encodeCache *sender.EncodeCache
+	encoding    string              //消息的序列化方式，为空时使用 json
+	protoSchema *sender.ProtoSchema //encoding 为 protobuf 时使用
 }
 
 var (
@@ -57,6 +67,9 @@ func init() {
 
 // kafka sender
 func NewSender(conf conf.MapConf) (kafkaSender sender.Sender, err error) {
+	if transactionalID, _ := conf.GetStringOr(KeyKafkaTransactionalID, ""); transactionalID != "" {
+		return nil, fmt.Errorf("%s is not supported, kafka sender only provides at-least-once delivery", KeyKafkaTransactionalID)
+	}
 	hosts, err := conf.GetStringList(KeyKafkaHost)
 	if err != nil {
 		return
@@ -86,6 +99,17 @@ func NewSender(conf conf.MapConf) (kafkaSender sender.Sender, err error) {
 	keepAlive, _ := conf.GetStringOr(KeyKafkaKeepAlive, "0")
 	maxMessageBytes, _ := conf.GetIntOr(KeyMaxMessageBytes, 4*1024*1024)
 	gzipCompressionLevel, _ := conf.GetStringOr(KeyGZIPCompressionLevel, KeyGZIPCompressionDefault)
+	encoding, _ := conf.GetStringOr(KeyKafkaEncoding, SendProtocolJson)
+	var protoSchema *sender.ProtoSchema
+	switch encoding {
+	case SendProtocolJson, SendProtocolMsgpack:
+	case SendProtocolProtobuf:
+		if protoSchema, err = sender.NewProtoSchemaFromConf(conf); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown kafka encoding: '%v'", encoding)
+	}
 
 	name, _ := conf.GetStringOr(KeyName, fmt.Sprintf("kafkaSender:(kafkaUrl:%s,topic:%s)", hosts, topic))
 	metrics.UseNilMetrics = true
@@ -130,7 +154,10 @@ func NewSender(conf conf.MapConf) (kafkaSender sender.Sender, err error) {
 		return
 	}
 
-	kafkaSender = newSender(name, hosts, topic, cfg, producer)
+	k := newSender(name, hosts, topic, cfg, producer)
+	k.encoding = encoding
+	k.protoSchema = protoSchema
+	kafkaSender = k
 	return
 }
 
@@ -183,6 +210,8 @@ func (this *Sender) RawSend(datas []string) error {
 			//发送错误为message too large时，启用二分策略重新发送
 			if v.Err == sarama.ErrMessageSizeTooLarge {
 				statsError.SendError = reqerr.NewRawSendError("Sender[Kafka]:Message was too large, server rejected it to avoid allocation error", datas, reqerr.TypeBinaryUnpack)
+			} else if errorType := kafkaErrorType(v.Err); errorType != reqerr.TypeDefault {
+				statsError.SendError = reqerr.NewRawSendError("Sender[Kafka]:"+v.Err.Error(), datas, errorType)
 			}
 			break
 		}
@@ -201,10 +230,8 @@ func (this *Sender) RawSend(datas []string) error {
 
 func (this *Sender) Send(data []Data) error {
 	var (
-		producer        = this.producer
 		msgs            []*sarama.ProducerMessage
 		statsError      = &StatsError{}
-		statsLastError  string
 		ignoreDataCount int
 		failedDatas     = make([]map[string]interface{}, 0)
 	)
@@ -223,8 +250,67 @@ func (this *Sender) Send(data []Data) error {
 	if statsError.LastError != "" {
 		statsError.LastError = fmt.Sprintf("ignore %d datas, last error: %s", ignoreDataCount, statsError.LastError) + "\n"
 	}
+	return this.sendMessages(msgs, statsError, failedDatas, func() []map[string]interface{} {
+		return sender.ConvertDatasBack(data)
+	})
+}
 
-	err := producer.SendMessages(msgs)
+// SendBatch 直接将 Batch 的每一行序列化为 json 发送，省去转换为 Data 的开销，其他序列化方式转换为 Data 后发送
+func (this *Sender) SendBatch(batch *Batch) error {
+	if !this.encodeJSON() {
+		return this.Send(batch.Datas())
+	}
+	var (
+		msgs            = make([]*sarama.ProducerMessage, 0, batch.Len())
+		statsError      = &StatsError{}
+		ignoreDataCount int
+		failedDatas     = make([]map[string]interface{}, 0)
+		topicCol        = -1
+		buf             []byte
+	)
+	if len(this.topic) == 2 {
+		topicCol = batch.FieldIndex(this.topic[0])
+	}
+	for row := 0; row < batch.Len(); row++ {
+		// 所有消息共用一个不断增长的 buf，每条消息引用其中的一段，buf 扩容后旧的消息仍然引用原来的内存
+		start := len(buf)
+		var err error
+		buf, err = batch.AppendJSON(buf, row)
+		if err != nil {
+			buf = buf[:start]
+			log.Debugf("Dropping event: %v", err)
+			statsError.AddErrors()
+			statsError.LastError = err.Error()
+			failedDatas = append(failedDatas, batch.Data(row))
+			ignoreDataCount++
+			continue
+		}
+		topic := this.topic[0]
+		if len(this.topic) == 2 {
+			topic = this.topic[1]
+			if topicCol >= 0 {
+				if mytopic, ok := batch.Value(row, topicCol).(string); ok && mytopic != "" {
+					topic = mytopic
+				}
+			}
+		}
+		msgs = append(msgs, &sarama.ProducerMessage{
+			Topic: topic,
+			Value: sarama.ByteEncoder(buf[start:len(buf):len(buf)]),
+		})
+	}
+	if statsError.LastError != "" {
+		statsError.LastError = fmt.Sprintf("ignore %d datas, last error: %s", ignoreDataCount, statsError.LastError) + "\n"
+	}
+	return this.sendMessages(msgs, statsError, failedDatas, func() []map[string]interface{} {
+		return sender.ConvertDatasBack(batch.Datas())
+	})
+}
+
+// sendMessages 发送消息并统计结果，datas 仅在消息过大需要二分重发时调用
+func (this *Sender) sendMessages(msgs []*sarama.ProducerMessage, statsError *StatsError, failedDatas []map[string]interface{}, datas func() []map[string]interface{}) error {
+	var statsLastError string
+	err := this.producer.SendMessages(msgs)
 	if err != nil {
 		statsError.AddErrorsNum(len(msgs))
 		pde, ok := err.(sarama.ProducerErrors)
@@ -244,7 +330,9 @@ func (this *Sender) Send(data []Data) error {
 			this.lastError = v
 			//发送错误为message too large时，启用二分策略重新发送
 			if v.Err == sarama.ErrMessageSizeTooLarge {
-				statsError.SendError = reqerr.NewSendError("Sender[Kafka]:Message was too large, server rejected it to avoid allocation error", sender.ConvertDatasBack(data), reqerr.TypeBinaryUnpack)
+				statsError.SendError = reqerr.NewSendError("Sender[Kafka]:Message was too large, server rejected it to avoid allocation error", datas(), reqerr.TypeBinaryUnpack)
+			} else if errorType := kafkaErrorType(v.Err); errorType != reqerr.TypeDefault {
+				statsError.SendError = reqerr.NewSendError("Sender[Kafka]:"+v.Err.Error(), datas(), errorType)
 			}
 			break
 		}
@@ -287,17 +375,29 @@ func (kf *Sender) getEventMessage(event map[string]interface{}) (pm *sarama.Prod
 	} else {
 		topic = kf.topic[0]
 	}
-	value, err := jsoniter.Marshal(event)
+	var value []byte
+	switch kf.encoding {
+	case SendProtocolMsgpack:
+		value, err = kf.encodeCache.EncodeMsgpack(event)
+	case SendProtocolProtobuf:
+		value, err = kf.encodeCache.EncodeProtobuf(event, kf.protoSchema)
+	default:
+		value, err = kf.encodeCache.EncodeJSON(event)
+	}
 	if err != nil {
 		return
 	}
 	pm = &sarama.ProducerMessage{
 		Topic: topic,
-		Value: sarama.StringEncoder(string(value)),
+		Value: sarama.ByteEncoder(value),
 	}
 	return
 }
 
+func (kf *Sender) encodeJSON() bool {
+	return kf.encoding == "" || kf.encoding == SendProtocolJson
+}
+
 func (this *Sender) Close() (err error) {
 	log.Infof("kafka sender was closed")
 	this.producer.Close()
@@ -306,3 +406,18 @@ func (this *Sender) Close() (err error) {
 }
 
 func (*Sender) SkipDeepCopy() bool { return true }
+
+func (this *Sender) SetEncodeCache(cache *sender.EncodeCache) {
+	this.encodeCache = cache
+}
+
+// kafkaErrorType 将 kafka 返回的错误映射为 SendErrorType，认证失败和无效的 topic 重试不会成功
+func kafkaErrorType(err error) reqerr.SendErrorType {
+	switch err {
+	case sarama.ErrTopicAuthorizationFailed, sarama.ErrClusterAuthorizationFailed, sarama.ErrSASLAuthenticationFailed:
+		return sender.TypeAuthFailed
+	case sarama.ErrInvalidTopic:
+		return sender.TypeRejected
+	}
+	return reqerr.TypeDefault
+}
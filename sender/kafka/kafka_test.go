@@ -0,0 +1,152 @@
+package kafka
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/parser/nginx"
+	"github.com/qiniu/logkit/sender"
+	. "github.com/qiniu/logkit/sender/config"
+	"github.com/qiniu/logkit/utils"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+// discardProducer 只对消息进行编码，不实际发送
+type discardProducer struct {
+	values []string
+	keep   bool
+}
+
+func (p *discardProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	return 0, 0, p.SendMessages([]*sarama.ProducerMessage{msg})
+}
+
+func (p *discardProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	for _, msg := range msgs {
+		value, err := msg.Value.Encode()
+		if err != nil {
+			return err
+		}
+		if p.keep {
+			p.values = append(p.values, msg.Topic+" "+string(value))
+		}
+	}
+	return nil
+}
+
+func (p *discardProducer) Close() error {
+	return nil
+}
+
+var nginxConf = conf.MapConf{
+	"name":                   "nginx",
+	"nginx_log_format_regex": `^(?P<remote_addr>[^ ]*) - (?P<remote_user>[^ ]*) \[(?P<time_local>[^]]*)\] "(?P<request>[^"]*)" (?P<status>[^ ]*) (?P<body_bytes_sent>[^ ]*) "(?P<http_referer>[^"]*)" "(?P<http_user_agent>[^"]*)" (?P<request_time>[^ ]*)$`,
+	"nginx_schema":           "remote_addr string, time_local date, request string, status long, body_bytes_sent long, request_time float",
+}
+
+const nginxLine = `111.111.111.101 - - [30/Aug/2016:14:03:37 +0800] "GET /s5/M00/CE/91/xaxsxsxsxs HTTP/1.1" 200 4962 "http://www.abc.cn" "Mozilla/5.0 (Windows NT 6.1; WOW64)" 0.204`
+
+func TestSendBatch(t *testing.T) {
+	p, err := nginx.NewNginxAccParser(nginxConf)
+	assert.NoError(t, err)
+	lines := []string{nginxLine, `192.168.0.1 - - [30/Aug/2016:14:03:38 +0800] "POST /topic_a HTTP/1.1" 404 0 "-" "curl" 1.5`}
+	datas, err := p.Parse(lines)
+	assert.NoError(t, err)
+	batch, err := p.ParseBatch(lines)
+	assert.NoError(t, err)
+
+	producer := &discardProducer{keep: true}
+	s := &Sender{topic: []string{"remote_user", "default"}, producer: producer}
+	assert.NoError(t, s.SendBatch(batch))
+	assert.Len(t, producer.values, 2)
+	for i, v := range producer.values {
+		assert.Equal(t, "- {", v[:3])
+		exp, err := json.Marshal(datas[i])
+		assert.NoError(t, err)
+		assert.JSONEq(t, string(exp), v[2:])
+	}
+}
+
+func TestSendEncoding(t *testing.T) {
+	c := conf.MapConf{KeyKafkaHost: "127.0.0.1:9092", KeyKafkaTopic: "nginx", KeyKafkaEncoding: "xml"}
+	_, err := NewSender(c)
+	assert.Error(t, err)
+	c[KeyKafkaEncoding] = SendProtocolProtobuf
+	_, err = NewSender(c)
+	assert.Error(t, err)
+
+	p, err := nginx.NewNginxAccParser(nginxConf)
+	assert.NoError(t, err)
+	batch, err := p.ParseBatch([]string{nginxLine})
+	assert.NoError(t, err)
+
+	producer := &discardProducer{keep: true}
+	s := &Sender{topic: []string{"nginx"}, producer: producer, encoding: SendProtocolMsgpack}
+	assert.NoError(t, s.SendBatch(batch))
+	assert.NoError(t, s.Send([]Data{{"a": int64(1)}}))
+	assert.Len(t, producer.values, 2)
+	exp, err := sender.AppendMsgpack(nil, batch.Data(0))
+	assert.NoError(t, err)
+	assert.Equal(t, "nginx "+string(exp), producer.values[0])
+	assert.Equal(t, "nginx \x81\xa1a\x01", producer.values[1])
+
+	schema, err := sender.ParseProtoSchema(`message Log { string addr = 1; int64 status = 2; }`, "", "addr=remote_addr")
+	assert.NoError(t, err)
+	producer = &discardProducer{keep: true}
+	s = &Sender{topic: []string{"nginx"}, producer: producer, encoding: SendProtocolProtobuf, protoSchema: schema}
+	assert.NoError(t, s.SendBatch(batch))
+	assert.Equal(t, []string{"nginx \x0a\x0f111.111.111.101\x10\xc8\x01"}, producer.values)
+}
+
+// 对比 nginx 解析后经过 Data 与经过 Batch 发送到 kafka 的吞吐
+func BenchmarkNginxToKafkaData(b *testing.B) {
+	p, err := nginx.NewNginxAccParser(nginxConf)
+	if err != nil {
+		b.Fatal(err)
+	}
+	s := &Sender{topic: []string{"nginx"}, producer: &discardProducer{}}
+	lines := utils.GetParseTestData(nginxLine, DefaultMaxBatchSize)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		datas, err := p.Parse(lines)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err = s.Send(datas); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkNginxToKafkaBatch(b *testing.B) {
+	p, err := nginx.NewNginxAccParser(nginxConf)
+	if err != nil {
+		b.Fatal(err)
+	}
+	s := &Sender{topic: []string{"nginx"}, producer: &discardProducer{}}
+	lines := utils.GetParseTestData(nginxLine, DefaultMaxBatchSize)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		batch, err := p.ParseBatch(lines)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err = s.SendBatch(batch); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestTransactionalIDRejected(t *testing.T) {
+	_, err := NewSender(conf.MapConf{
+		KeyKafkaHost:            "127.0.0.1:9092",
+		KeyKafkaTopic:           "topic1",
+		KeyKafkaTransactionalID: "relay1",
+	})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), KeyKafkaTransactionalID)
+}
@@ -0,0 +1,196 @@
+package kodo
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errCodeNoSuchUpload 分片上传任务不存在或者已经过期，需要重新初始化
+const errCodeNoSuchUpload = 612
+
+// apiError 是 kodo 上传接口返回的错误
+type apiError struct {
+	Code    int
+	Message string `json:"error"`
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("kodo upload error, code: %d, message: %s", e.Code, e.Message)
+}
+
+func isNoSuchUpload(err error) bool {
+	ae, ok := err.(*apiError)
+	return ok && ae.Code == errCodeNoSuchUpload
+}
+
+type part struct {
+	PartNumber int    `json:"partNumber"`
+	ETag       string `json:"etag"`
+}
+
+// client 实现 kodo 的表单上传以及分片上传 v2 接口
+type client struct {
+	ak       string
+	sk       string
+	bucket   string
+	upHost   string
+	tokenTTL time.Duration
+	http     *http.Client
+}
+
+type putPolicy struct {
+	Scope    string `json:"scope"`
+	Deadline int64  `json:"deadline"`
+}
+
+// uploadToken 生成只能上传 key 的凭证，重试上传同一个文件时会覆盖之前上传的内容
+func (c *client) uploadToken(key string) string {
+	policy, _ := json.Marshal(putPolicy{
+		Scope:    c.bucket + ":" + key,
+		Deadline: time.Now().Add(c.tokenTTL).Unix(),
+	})
+	encodedPolicy := base64.URLEncoding.EncodeToString(policy)
+	mac := hmac.New(sha1.New, []byte(c.sk))
+	mac.Write([]byte(encodedPolicy))
+	sign := base64.URLEncoding.EncodeToString(mac.Sum(nil))
+	return c.ak + ":" + sign + ":" + encodedPolicy
+}
+
+func (c *client) objectURL(key string) string {
+	return strings.TrimRight(c.upHost, "/") + "/buckets/" + c.bucket + "/objects/" + base64.URLEncoding.EncodeToString([]byte(key)) + "/uploads"
+}
+
+func (c *client) do(req *http.Request, key string, ret interface{}) error {
+	req.Header.Set("Authorization", "UpToken "+c.uploadToken(key))
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		ae := &apiError{Code: resp.StatusCode}
+		if json.Unmarshal(body, ae) != nil || ae.Message == "" {
+			ae.Message = string(body)
+		}
+		return ae
+	}
+	if ret == nil {
+		return nil
+	}
+	if err = json.Unmarshal(body, ret); err != nil {
+		return fmt.Errorf("unmarshal kodo response %q error: %v", body, err)
+	}
+	return nil
+}
+
+// put 使用表单上传一个完整的文件，适用于不超过一个分片大小的文件
+func (c *client) put(key string, data []byte) error {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	if err := w.WriteField("token", c.uploadToken(key)); err != nil {
+		return err
+	}
+	if err := w.WriteField("key", key); err != nil {
+		return err
+	}
+	fw, err := w.CreateFormFile("file", key)
+	if err != nil {
+		return err
+	}
+	if _, err = fw.Write(data); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, c.upHost, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	return c.do(req, key, nil)
+}
+
+// initMultipart 初始化分片上传任务，返回 uploadId
+func (c *client) initMultipart(key string) (string, error) {
+	req, err := http.NewRequest(http.MethodPost, c.objectURL(key), nil)
+	if err != nil {
+		return "", err
+	}
+	var ret struct {
+		UploadID string `json:"uploadId"`
+	}
+	if err = c.do(req, key, &ret); err != nil {
+		return "", err
+	}
+	if ret.UploadID == "" {
+		return "", fmt.Errorf("kodo init multipart upload of %v returns empty uploadId", key)
+	}
+	return ret.UploadID, nil
+}
+
+// uploadPart 上传一个分片，partNumber 从 1 开始，返回分片的 etag
+func (c *client) uploadPart(key, uploadID string, partNumber int, data []byte) (string, error) {
+	url := c.objectURL(key) + "/" + uploadID + "/" + strconv.Itoa(partNumber)
+	req, err := http.NewRequest(http.MethodPut, url, bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	sum := md5.Sum(data)
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-MD5", hex.EncodeToString(sum[:]))
+	var ret struct {
+		ETag string `json:"etag"`
+	}
+	if err = c.do(req, key, &ret); err != nil {
+		return "", err
+	}
+	return ret.ETag, nil
+}
+
+// completeMultipart 按照分片顺序合并为完整的文件
+func (c *client) completeMultipart(key, uploadID string, parts []part, mimeType string) error {
+	body, err := json.Marshal(struct {
+		Parts    []part `json:"parts"`
+		MimeType string `json:"mimeType,omitempty"`
+	}{parts, mimeType})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, c.objectURL(key)+"/"+uploadID, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return c.do(req, key, nil)
+}
+
+// readPart 读取文件中第 partNumber 个分片的内容
+func readPart(r io.ReaderAt, size, partSize int64, partNumber int) ([]byte, error) {
+	offset := int64(partNumber-1) * partSize
+	if offset+partSize < size {
+		size = offset + partSize
+	}
+	buf := make([]byte, size-offset)
+	if _, err := r.ReadAt(buf, offset); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return buf, nil
+}
@@ -0,0 +1,555 @@
+package kodo
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lestrrat-go/strftime"
+
+	"github.com/qiniu/log"
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/sender"
+	. "github.com/qiniu/logkit/sender/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+var (
+	_ sender.SkipDeepCopySender = &Sender{}
+	_ sender.EncodeCacheSender  = &Sender{}
+	_ sender.Sender             = &Sender{}
+)
+
+const (
+	DefaultKodoUpHost         = "https://up.qiniup.com"
+	DefaultKodoKeyTemplate    = "logkit/${runner}/%Y%m%d/%H%M%S-${hostname}-${id}${ext}"
+	DefaultKodoRotateSize     = 64 * MB
+	DefaultKodoRotateInterval = 5 * time.Minute
+	DefaultKodoPartSize       = 4 * MB
+
+	// kodo 分片上传要求除最后一个分片外每个分片不小于 1MB
+	minPartSize = 1 * MB
+
+	spoolFileExt  = ".log"
+	spoolStateExt = ".upload"
+
+	maxUploadBackoff = time.Minute
+)
+
+var errNoSpoolFile = errors.New("spool file not found")
+
+func init() {
+	sender.RegisterConstructor(TypeKodo, NewSender)
+}
+
+// uploadState 记录一个待上传文件的目标 key 以及分片上传的进度，与文件一起保存在本地缓存目录中，重启后继续上传
+type uploadState struct {
+	Key      string `json:"key"`
+	UploadID string `json:"upload_id,omitempty"`
+	PartSize int64  `json:"part_size,omitempty"`
+	Parts    []part `json:"parts,omitempty"`
+}
+
+// Sender 将数据按行写入本地缓存文件，文件达到指定大小或者时间后上传到 kodo。
+// 开启压缩时每次 Send 写入一个独立的 gzip member，缓存文件在任何时刻都是完整的 gzip 文件，异常退出后无需修复即可上传
+type Sender struct {
+	name           string
+	runner         string
+	hostname       string
+	client         *client
+	keyPattern     *strftime.Strftime
+	compress       bool
+	writeRaw       bool
+	rotateSize     int64
+	rotateInterval time.Duration
+	partSize       int64
+	spoolDir       string
+	encodeCache    *sender.EncodeCache
+
+	mutex     sync.Mutex
+	file      *os.File
+	fileID    string
+	fileStart time.Time
+	fileSize  int64
+
+	// uploadMutex 保证同一时间只有一个上传任务
+	uploadMutex sync.Mutex
+	notify      chan struct{}
+	stopChan    chan struct{}
+	wg          sync.WaitGroup
+}
+
+func NewSender(c conf.MapConf) (sender.Sender, error) {
+	ak, err := c.GetPasswordEnvString(KeyKodoAk)
+	if err != nil {
+		return nil, err
+	}
+	sk, err := c.GetPasswordEnvString(KeyKodoSk)
+	if err != nil {
+		return nil, err
+	}
+	bucket, err := c.GetString(KeyKodoBucket)
+	if err != nil {
+		return nil, err
+	}
+	upHost, _ := c.GetStringOr(KeyKodoUpHost, DefaultKodoUpHost)
+	keyTemplate, _ := c.GetStringOr(KeyKodoKeyTemplate, DefaultKodoKeyTemplate)
+	compress, _ := c.GetBoolOr(KeyKodoCompress, true)
+	writeRaw, _ := c.GetBoolOr(KeyKodoWriteRaw, false)
+	rotateSize, _ := c.GetInt64Or(KeyKodoRotateSize, DefaultKodoRotateSize)
+	rotateIntervalStr, _ := c.GetStringOr(KeyKodoRotateInterval, DefaultKodoRotateInterval.String())
+	partSize, _ := c.GetInt64Or(KeyKodoPartSize, DefaultKodoPartSize)
+	runnerName, _ := c.GetStringOr(KeyRunnerName, UnderfinedRunnerName)
+	spoolDir, _ := c.GetStringOr(KeyKodoSpoolDir, filepath.Join("kodo_spool", runnerName+"_"+bucket))
+	name, _ := c.GetStringOr(KeyName, fmt.Sprintf("kodoSender:(bucket:%v)", bucket))
+
+	rotateInterval, err := time.ParseDuration(rotateIntervalStr)
+	if err != nil {
+		return nil, fmt.Errorf("%v parse error: %v", KeyKodoRotateInterval, err)
+	}
+	if rotateInterval <= 0 || rotateSize <= 0 {
+		return nil, fmt.Errorf("%v and %v must be positive", KeyKodoRotateInterval, KeyKodoRotateSize)
+	}
+	if partSize < minPartSize {
+		return nil, fmt.Errorf("%v must be no less than %d", KeyKodoPartSize, minPartSize)
+	}
+	keyPattern, err := strftime.New(keyTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("%v parse error: %v", KeyKodoKeyTemplate, err)
+	}
+	if err = os.MkdirAll(spoolDir, DefaultDirPerm); err != nil {
+		return nil, fmt.Errorf("create kodo spool dir %v error: %v", spoolDir, err)
+	}
+	hostname, _ := os.Hostname()
+
+	s := &Sender{
+		name:     name,
+		runner:   runnerName,
+		hostname: hostname,
+		client: &client{
+			ak:       ak,
+			sk:       sk,
+			bucket:   bucket,
+			upHost:   upHost,
+			tokenTTL: time.Hour,
+			http:     &http.Client{Timeout: 5 * time.Minute},
+		},
+		keyPattern:     keyPattern,
+		compress:       compress,
+		writeRaw:       writeRaw,
+		rotateSize:     rotateSize,
+		rotateInterval: rotateInterval,
+		partSize:       partSize,
+		spoolDir:       spoolDir,
+		notify:         make(chan struct{}, 1),
+		stopChan:       make(chan struct{}),
+	}
+	if err = s.recoverSpool(); err != nil {
+		return nil, err
+	}
+	s.wg.Add(1)
+	go s.run()
+	return s, nil
+}
+
+func (s *Sender) Name() string {
+	return s.name
+}
+
+func (*Sender) SkipDeepCopy() bool { return true }
+
+func (s *Sender) SetEncodeCache(cache *sender.EncodeCache) {
+	s.encodeCache = cache
+}
+
+func (s *Sender) ext() string {
+	ext := ".json"
+	if s.writeRaw {
+		ext = ".log"
+	}
+	if s.compress {
+		ext += ".gz"
+	}
+	return ext
+}
+
+func (s *Sender) mimeType() string {
+	if s.compress {
+		return "application/gzip"
+	}
+	if s.writeRaw {
+		return "text/plain"
+	}
+	return "application/json"
+}
+
+// objectKey 根据文件开始写入的时间渲染 key 模版，支持 strftime 格式的时间以及 ${runner}、${hostname}、${id}、${ext} 变量
+func (s *Sender) objectKey(id string, start time.Time) string {
+	key := s.keyPattern.FormatString(start)
+	return strings.NewReplacer(
+		"${runner}", s.runner,
+		"${hostname}", s.hostname,
+		"${id}", id,
+		"${ext}", s.ext(),
+	).Replace(key)
+}
+
+func (s *Sender) spoolPath(id, ext string) string {
+	return filepath.Join(s.spoolDir, id+ext)
+}
+
+// recoverSpool 启动时将上次没有写完的缓存文件标记为待上传
+func (s *Sender) recoverSpool() error {
+	files, err := filepath.Glob(filepath.Join(s.spoolDir, "*"+spoolFileExt))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		id := strings.TrimSuffix(filepath.Base(file), spoolFileExt)
+		if _, err := os.Stat(s.spoolPath(id, spoolStateExt)); err == nil {
+			continue
+		}
+		nano, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			log.Warnf("Sender[%v] ignore unknown file %v in spool dir", s.name, file)
+			continue
+		}
+		if err = s.seal(id, time.Unix(0, nano)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// seal 生成文件的上传状态，之后文件不再写入，等待上传
+func (s *Sender) seal(id string, start time.Time) error {
+	return writeState(s.spoolPath(id, spoolStateExt), &uploadState{Key: s.objectKey(id, start)})
+}
+
+func readState(path string) (*uploadState, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	state := &uploadState{}
+	if err = json.Unmarshal(content, state); err != nil {
+		return nil, fmt.Errorf("unmarshal kodo upload state %v error: %v", path, err)
+	}
+	return state, nil
+}
+
+// writeState 先写临时文件再重命名，避免异常退出时状态文件不完整
+func writeState(path string, state *uploadState) error {
+	content, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err = ioutil.WriteFile(tmp, content, DefaultFilePerm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (s *Sender) encode(datas []Data, ste *StatsError) []byte {
+	var buf bytes.Buffer
+	for _, d := range datas {
+		if s.writeRaw {
+			switch raw := d["raw"].(type) {
+			case string:
+				buf.WriteString(raw)
+				if !strings.HasSuffix(raw, "\n") {
+					buf.WriteByte('\n')
+				}
+			case []byte:
+				buf.Write(raw)
+				if !bytes.HasSuffix(raw, []byte("\n")) {
+					buf.WriteByte('\n')
+				}
+			default:
+				ste.AddErrors()
+				ste.LastError = fmt.Sprintf("%s field raw not found or not a string", s.Name())
+				continue
+			}
+			ste.AddSuccess()
+			continue
+		}
+		bytes, err := s.encodeCache.EncodeJSON(d)
+		if err != nil {
+			ste.AddErrors()
+			ste.LastError = fmt.Sprintf("%s marshal data error: %v", s.Name(), err)
+			continue
+		}
+		buf.Write(bytes)
+		buf.WriteByte('\n')
+		ste.AddSuccess()
+	}
+	return buf.Bytes()
+}
+
+func (s *Sender) Send(datas []Data) error {
+	// 无法序列化的数据重试也无法成功，只上报错误信息
+	ste := &StatsError{Ft: true, FtNotRetry: true}
+	content := s.encode(datas, ste)
+	if len(content) > 0 {
+		if err := s.write(content); err != nil {
+			return fmt.Errorf("%s write spool file error: %v", s.Name(), err)
+		}
+	}
+	if ste.Errors > 0 {
+		return ste
+	}
+	return nil
+}
+
+func (s *Sender) write(content []byte) error {
+	if s.compress {
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(content); err != nil {
+			return err
+		}
+		if err := w.Close(); err != nil {
+			return err
+		}
+		content = buf.Bytes()
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.file == nil {
+		now := time.Now()
+		id := strconv.FormatInt(now.UnixNano(), 10)
+		f, err := os.OpenFile(s.spoolPath(id, spoolFileExt), os.O_CREATE|os.O_WRONLY|os.O_APPEND, DefaultFilePerm)
+		if err != nil {
+			return err
+		}
+		s.file, s.fileID, s.fileStart, s.fileSize = f, id, now, 0
+	}
+	n, err := s.file.Write(content)
+	if err != nil {
+		// 截断写入了一半的内容，保证文件仍然是完整的
+		if n > 0 {
+			if terr := s.file.Truncate(s.fileSize); terr != nil {
+				log.Errorf("Sender[%v] truncate spool file %v error: %v", s.name, s.file.Name(), terr)
+			}
+		}
+		return err
+	}
+	s.fileSize += int64(n)
+	if s.fileSize >= s.rotateSize {
+		return s.rotateLocked()
+	}
+	return nil
+}
+
+// rotateLocked 关闭当前文件并通知上传，调用者需要持有 mutex
+func (s *Sender) rotateLocked() error {
+	if s.file == nil {
+		return nil
+	}
+	if err := s.file.Close(); err != nil {
+		log.Errorf("Sender[%v] close spool file %v error: %v", s.name, s.file.Name(), err)
+	}
+	s.file = nil
+	if err := s.seal(s.fileID, s.fileStart); err != nil {
+		// 状态文件写入失败时重启后会重新生成
+		return err
+	}
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (s *Sender) rotateIfExpired() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.file == nil || time.Since(s.fileStart) < s.rotateInterval {
+		return
+	}
+	if err := s.rotateLocked(); err != nil {
+		log.Errorf("Sender[%v] rotate spool file error: %v", s.name, err)
+	}
+}
+
+// run 定时切割文件并上传，上传失败时等待一段时间后重试，等待时间每次翻倍
+func (s *Sender) run() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	var backoff time.Duration
+	var retryAt time.Time
+	for {
+		select {
+		case <-s.stopChan:
+			return
+		case <-s.notify:
+		case <-ticker.C:
+		}
+		s.rotateIfExpired()
+		if time.Now().Before(retryAt) {
+			continue
+		}
+		if err := s.uploadAll(); err != nil {
+			if backoff *= 2; backoff == 0 {
+				backoff = time.Second
+			} else if backoff > maxUploadBackoff {
+				backoff = maxUploadBackoff
+			}
+			retryAt = time.Now().Add(backoff)
+			log.Errorf("Sender[%v] upload to kodo error, retry after %v: %v", s.name, backoff, err)
+			continue
+		}
+		backoff = 0
+	}
+}
+
+// uploadAll 按照写入的顺序上传所有待上传的文件
+func (s *Sender) uploadAll() error {
+	s.uploadMutex.Lock()
+	defer s.uploadMutex.Unlock()
+	states, err := filepath.Glob(filepath.Join(s.spoolDir, "*"+spoolStateExt))
+	if err != nil {
+		return err
+	}
+	sort.Strings(states)
+	for _, state := range states {
+		if err = s.upload(strings.TrimSuffix(filepath.Base(state), spoolStateExt)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Sender) upload(id string) error {
+	statePath, filePath := s.spoolPath(id, spoolStateExt), s.spoolPath(id, spoolFileExt)
+	state, err := readState(statePath)
+	if err != nil {
+		return err
+	}
+	err = s.uploadFile(filePath, statePath, state)
+	if isNoSuchUpload(err) {
+		// 进度已经清空，立即重新上传整个文件
+		err = s.uploadFile(filePath, statePath, state)
+	}
+	if err == errNoSpoolFile {
+		return os.Remove(statePath)
+	}
+	if err != nil {
+		return fmt.Errorf("upload %v to %v error: %v", filePath, state.Key, err)
+	}
+	log.Infof("Sender[%v] uploaded %v to kodo %v", s.name, filePath, state.Key)
+	if err = os.Remove(filePath); err != nil {
+		return err
+	}
+	return os.Remove(statePath)
+}
+
+func (s *Sender) uploadFile(filePath, statePath string, state *uploadState) error {
+	f, err := os.Open(filePath)
+	if os.IsNotExist(err) {
+		return errNoSpoolFile
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+	if size == 0 {
+		return nil
+	}
+
+	if state.UploadID == "" && size <= s.partSize {
+		content, err := ioutil.ReadAll(f)
+		if err != nil {
+			return err
+		}
+		return s.client.put(state.Key, content)
+	}
+
+	if state.UploadID == "" {
+		if state.UploadID, err = s.client.initMultipart(state.Key); err != nil {
+			return err
+		}
+		state.PartSize, state.Parts = s.partSize, nil
+		if err = writeState(statePath, state); err != nil {
+			return err
+		}
+	}
+	partCount := int((size + state.PartSize - 1) / state.PartSize)
+	for partNumber := len(state.Parts) + 1; partNumber <= partCount; partNumber++ {
+		data, err := readPart(f, size, state.PartSize, partNumber)
+		if err != nil {
+			return err
+		}
+		etag, err := s.client.uploadPart(state.Key, state.UploadID, partNumber, data)
+		if err != nil {
+			return s.resetUpload(statePath, state, err)
+		}
+		state.Parts = append(state.Parts, part{PartNumber: partNumber, ETag: etag})
+		if err = writeState(statePath, state); err != nil {
+			return err
+		}
+	}
+	if err = s.client.completeMultipart(state.Key, state.UploadID, state.Parts, s.mimeType()); err != nil {
+		return s.resetUpload(statePath, state, err)
+	}
+	return nil
+}
+
+// resetUpload 分片上传任务过期时清空进度，之后重新上传整个文件
+func (s *Sender) resetUpload(statePath string, state *uploadState, err error) error {
+	if !isNoSuchUpload(err) {
+		return err
+	}
+	log.Warnf("Sender[%v] multipart upload %v of %v expired, upload again", s.name, state.UploadID, state.Key)
+	state.UploadID, state.PartSize, state.Parts = "", 0, nil
+	if werr := writeState(statePath, state); werr != nil {
+		log.Errorf("Sender[%v] write upload state %v error: %v", s.name, statePath, werr)
+	}
+	return err
+}
+
+// Flush 切割当前文件并立即上传所有待上传的文件，上传失败的文件保留在缓存目录中稍后重试
+func (s *Sender) Flush(timeout time.Duration) error {
+	s.mutex.Lock()
+	err := s.rotateLocked()
+	s.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	return s.uploadAll()
+}
+
+// Close 切割当前文件并尝试上传，上传失败的文件保留在缓存目录中，下次启动后继续上传
+func (s *Sender) Close() error {
+	s.mutex.Lock()
+	err := s.rotateLocked()
+	s.mutex.Unlock()
+	close(s.stopChan)
+	s.wg.Wait()
+	if err != nil {
+		return err
+	}
+	if err = s.uploadAll(); err != nil {
+		log.Warnf("Sender[%v] upload to kodo before close error, spool files are kept in %v: %v", s.name, s.spoolDir, err)
+	}
+	return nil
+}
@@ -0,0 +1,276 @@
+package kodo
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/qiniu/logkit/conf"
+	. "github.com/qiniu/logkit/sender/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const (
+	testAk     = "ak"
+	testSk     = "sk"
+	testBucket = "bucket"
+)
+
+// fakeKodo 模拟 kodo 的表单上传以及分片上传接口
+type fakeKodo struct {
+	mutex    sync.Mutex
+	objects  map[string][]byte
+	uploads  map[string]map[int][]byte
+	inits    int
+	parts    []int
+	failPart int
+}
+
+func newFakeKodo() *fakeKodo {
+	return &fakeKodo{objects: make(map[string][]byte), uploads: make(map[string]map[int][]byte)}
+}
+
+// checkToken 校验上传凭证的签名以及 scope，返回凭证中的 key
+func checkToken(token string) (string, error) {
+	items := strings.Split(token, ":")
+	if len(items) != 3 || items[0] != testAk {
+		return "", fmt.Errorf("invalid token %q", token)
+	}
+	mac := hmac.New(sha1.New, []byte(testSk))
+	mac.Write([]byte(items[2]))
+	if base64.URLEncoding.EncodeToString(mac.Sum(nil)) != items[1] {
+		return "", fmt.Errorf("invalid token sign %q", token)
+	}
+	policy, err := base64.URLEncoding.DecodeString(items[2])
+	if err != nil {
+		return "", err
+	}
+	var p putPolicy
+	if err = json.Unmarshal(policy, &p); err != nil {
+		return "", err
+	}
+	return strings.TrimPrefix(p.Scope, testBucket+":"), nil
+}
+
+func (k *fakeKodo) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if req.URL.Path == "/" {
+		key, err := checkToken(req.FormValue("token"))
+		if err != nil || key != req.FormValue("key") {
+			http.Error(w, `{"error":"bad token"}`, http.StatusUnauthorized)
+			return
+		}
+		f, _, err := req.FormFile("file")
+		if err != nil {
+			http.Error(w, `{"error":"no file"}`, http.StatusBadRequest)
+			return
+		}
+		k.objects[key], _ = ioutil.ReadAll(f)
+		w.Write([]byte(`{}`))
+		return
+	}
+
+	tokenKey, err := checkToken(strings.TrimPrefix(req.Header.Get("Authorization"), "UpToken "))
+	if err != nil {
+		http.Error(w, `{"error":"bad token"}`, http.StatusUnauthorized)
+		return
+	}
+	// /buckets/<bucket>/objects/<key>/uploads[/<uploadId>[/<partNumber>]]
+	paths := strings.Split(strings.TrimPrefix(req.URL.Path, "/"), "/")
+	encodedKey, _ := base64.URLEncoding.DecodeString(paths[3])
+	if len(paths) < 5 || paths[1] != testBucket || string(encodedKey) != tokenKey {
+		http.Error(w, `{"error":"bad request"}`, http.StatusBadRequest)
+		return
+	}
+	switch len(paths) {
+	case 5:
+		k.inits++
+		id := "upload" + strconv.Itoa(k.inits)
+		k.uploads[id] = make(map[int][]byte)
+		fmt.Fprintf(w, `{"uploadId":%q}`, id)
+	case 6:
+		parts, ok := k.uploads[paths[5]]
+		if !ok {
+			http.Error(w, `{"error":"no such uploadId"}`, errCodeNoSuchUpload)
+			return
+		}
+		var body struct {
+			Parts []part `json:"parts"`
+		}
+		json.NewDecoder(req.Body).Decode(&body)
+		var content []byte
+		for i, p := range body.Parts {
+			if p.PartNumber != i+1 || p.ETag != "etag"+strconv.Itoa(p.PartNumber) {
+				http.Error(w, `{"error":"bad part"}`, http.StatusBadRequest)
+				return
+			}
+			content = append(content, parts[p.PartNumber]...)
+		}
+		k.objects[tokenKey] = content
+		delete(k.uploads, paths[5])
+		w.Write([]byte(`{}`))
+	case 7:
+		parts, ok := k.uploads[paths[5]]
+		if !ok {
+			http.Error(w, `{"error":"no such uploadId"}`, errCodeNoSuchUpload)
+			return
+		}
+		n, _ := strconv.Atoi(paths[6])
+		if n == k.failPart {
+			http.Error(w, `{"error":"internal error"}`, http.StatusInternalServerError)
+			return
+		}
+		data, _ := ioutil.ReadAll(req.Body)
+		sum := md5.Sum(data)
+		if req.Header.Get("Content-MD5") != hex.EncodeToString(sum[:]) {
+			http.Error(w, `{"error":"bad md5"}`, http.StatusBadRequest)
+			return
+		}
+		parts[n] = data
+		k.parts = append(k.parts, n)
+		fmt.Fprintf(w, `{"etag":"etag%d"}`, n)
+	}
+}
+
+func (k *fakeKodo) objectList() map[string][]byte {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	objects := make(map[string][]byte, len(k.objects))
+	for key, v := range k.objects {
+		objects[key] = v
+	}
+	return objects
+}
+
+func newTestConf(url, dir string) conf.MapConf {
+	return conf.MapConf{
+		KeyKodoAk:          testAk,
+		KeyKodoSk:          testSk,
+		KeyKodoBucket:      testBucket,
+		KeyKodoUpHost:      url,
+		KeyKodoKeyTemplate: "logs/${runner}/%Y/${id}${ext}",
+		KeyKodoWriteRaw:    "true",
+		KeyKodoSpoolDir:    dir,
+		KeyRunnerName:      "runner1",
+	}
+}
+
+func TestKodoSender(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kodo")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	kodo := newFakeKodo()
+	server := httptest.NewServer(kodo)
+	defer server.Close()
+
+	s, err := NewSender(newTestConf(server.URL, dir))
+	assert.NoError(t, err)
+	assert.NoError(t, s.Send([]Data{{"raw": "line1"}, {"raw": "line2\n"}}))
+	assert.NoError(t, s.Send([]Data{{"raw": "line3"}}))
+	err = s.Send([]Data{{"raw": 1}, {"raw": "line4"}})
+	se, ok := err.(*StatsError)
+	assert.True(t, ok)
+	assert.Equal(t, int64(1), se.Errors)
+	assert.Equal(t, int64(1), se.Success)
+	assert.NoError(t, s.Close())
+
+	objects := kodo.objectList()
+	assert.Len(t, objects, 1)
+	for key, content := range objects {
+		assert.True(t, strings.HasPrefix(key, "logs/runner1/"), key)
+		assert.True(t, strings.HasSuffix(key, ".log.gz"), key)
+		// 每次 Send 写入一个 gzip member，整个文件可以作为一个 gzip 文件读取
+		r, err := gzip.NewReader(bytes.NewReader(content))
+		assert.NoError(t, err)
+		lines, err := ioutil.ReadAll(r)
+		assert.NoError(t, err)
+		assert.Equal(t, "line1\nline2\nline3\nline4\n", string(lines))
+	}
+	files, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, files, 0)
+}
+
+func TestKodoSenderResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kodo")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	kodo := newFakeKodo()
+	kodo.failPart = 2
+	server := httptest.NewServer(kodo)
+	defer server.Close()
+
+	c := newTestConf(server.URL, dir)
+	c[KeyKodoCompress] = "false"
+	c[KeyKodoPartSize] = strconv.Itoa(minPartSize)
+	line := strings.Repeat("a", 1023)
+	var datas []Data
+	for i := 0; i < 2500; i++ {
+		datas = append(datas, Data{"raw": line})
+	}
+
+	s, err := NewSender(c)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Send(datas))
+	// 第二个分片上传失败，文件以及上传进度保留在本地
+	assert.NoError(t, s.Close())
+	assert.Len(t, kodo.objectList(), 0)
+	states, err := filepath.Glob(filepath.Join(dir, "*"+spoolStateExt))
+	assert.NoError(t, err)
+	assert.Len(t, states, 1)
+	state, err := readState(states[0])
+	assert.NoError(t, err)
+	assert.Equal(t, "upload1", state.UploadID)
+	assert.Equal(t, []part{{PartNumber: 1, ETag: "etag1"}}, state.Parts)
+
+	// 重启后从第二个分片继续上传
+	kodo.mutex.Lock()
+	kodo.failPart = 0
+	kodo.mutex.Unlock()
+	s, err = NewSender(c)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Close())
+
+	objects := kodo.objectList()
+	assert.Len(t, objects, 1)
+	for key, content := range objects {
+		assert.Equal(t, state.Key, key)
+		assert.True(t, strings.HasSuffix(key, ".log"), key)
+		assert.Equal(t, strings.Repeat(line+"\n", 2500), string(content))
+	}
+	assert.Equal(t, 1, kodo.inits)
+	assert.Equal(t, []int{1, 2, 3}, kodo.parts)
+
+	// 分片上传任务过期后重新上传整个文件
+	content := []byte(strings.Repeat(line+"\n", 2000))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "1"+spoolFileExt), content, DefaultFilePerm))
+	assert.NoError(t, writeState(filepath.Join(dir, "1"+spoolStateExt), &uploadState{
+		Key:      "expired",
+		UploadID: "unknown",
+		PartSize: minPartSize,
+		Parts:    []part{{PartNumber: 1, ETag: "etag1"}},
+	}))
+	s, err = NewSender(c)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Close())
+	assert.Equal(t, string(content), string(kodo.objectList()["expired"]))
+	assert.Equal(t, 2, kodo.inits)
+}
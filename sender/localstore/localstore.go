@@ -0,0 +1,397 @@
+package localstore
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/json-iterator/go"
+
+	"github.com/qiniu/log"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/sender"
+	. "github.com/qiniu/logkit/sender/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+var (
+	_ sender.SkipDeepCopySender = &Sender{}
+	_ sender.Sender             = &Sender{}
+)
+
+const (
+	// 每个小时的数据写入一个分段文件，过期时按分段整体删除
+	segmentInterval = time.Hour
+	segmentPrefix   = "seg-"
+	segmentSuffix   = ".jsonl"
+
+	defaultRetention = 24 * time.Hour
+	purgeInterval    = time.Minute
+
+	DefaultQueryLimit = 100
+	MaxQueryLimit     = 10000
+)
+
+func init() {
+	sender.RegisterConstructor(TypeLocalStore, NewSender)
+}
+
+// record 分段文件中的一行
+type record struct {
+	Time int64 `json:"t"` // 写入时间，单位毫秒
+	Data Data  `json:"d"`
+}
+
+// Record 查询返回的一条数据，Time 为写入本地存储的时间
+type Record struct {
+	Time time.Time `json:"time"`
+	Data Data      `json:"data"`
+}
+
+// Query 查询条件，Start、End 为零值时不限制，Filters 中的字段(嵌套字段用点连接)需要与数据中对应字段的字符串形式完全相同，
+// Keyword 不为空时只返回序列化后包含该字符串的数据
+type Query struct {
+	Start   time.Time
+	End     time.Time
+	Filters map[string]string
+	Keyword string
+	Limit   int
+}
+
+type segment struct {
+	start time.Time
+	path  string
+	size  int64
+}
+
+// Sender 将数据保存在本地磁盘上最近一段时间，在中心系统不可用时仍然可以在本机查询
+type Sender struct {
+	name       string
+	runnerName string
+	dir        string
+	retention  time.Duration
+	maxSize    int64
+
+	mux       sync.Mutex
+	segments  []*segment // 按时间排序，最后一个为正在写入的分段
+	file      *os.File
+	lastPurge time.Time
+}
+
+var (
+	storesMux sync.RWMutex
+	stores    = make(map[string][]*Sender) // runner 名称 -> 该 runner 的所有本地存储
+)
+
+func NewSender(c conf.MapConf) (sender.Sender, error) {
+	dir, err := c.GetString(KeyLocalStorePath)
+	if err != nil {
+		return nil, err
+	}
+	runnerName, _ := c.GetStringOr(KeyRunnerName, UnderfinedRunnerName)
+	name, _ := c.GetStringOr(KeyName, "localStoreSender:"+dir)
+	retentionStr, _ := c.GetStringOr(KeyLocalStoreRetention, defaultRetention.String())
+	retention, err := time.ParseDuration(retentionStr)
+	if err != nil {
+		return nil, fmt.Errorf("%q value %q is invalid: %v", KeyLocalStoreRetention, retentionStr, err)
+	}
+	if retention < segmentInterval {
+		return nil, fmt.Errorf("%q value %q should not be less than %v", KeyLocalStoreRetention, retentionStr, segmentInterval)
+	}
+	maxSize, _ := c.GetInt64Or(KeyLocalStoreMaxSize, 0)
+	if maxSize < 0 {
+		return nil, fmt.Errorf("%q value %d should not be negative", KeyLocalStoreMaxSize, maxSize)
+	}
+	if err = os.MkdirAll(dir, DefaultDirPerm); err != nil {
+		return nil, err
+	}
+	segments, err := loadSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+	s := &Sender{
+		name:       name,
+		runnerName: runnerName,
+		dir:        dir,
+		retention:  retention,
+		maxSize:    maxSize,
+		segments:   segments,
+	}
+	s.purge(time.Now())
+
+	storesMux.Lock()
+	stores[runnerName] = append(stores[runnerName], s)
+	storesMux.Unlock()
+	return s, nil
+}
+
+// loadSegments 加载目录中已有的分段文件
+func loadSegments(dir string) ([]*segment, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var segments []*segment
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || !strings.HasPrefix(name, segmentPrefix) || !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		sec, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(name, segmentPrefix), segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, &segment{start: time.Unix(sec, 0), path: filepath.Join(dir, name), size: f.Size()})
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i].start.Before(segments[j].start) })
+	return segments, nil
+}
+
+func (s *Sender) Name() string {
+	return s.name
+}
+
+func (*Sender) SkipDeepCopy() bool { return true }
+
+func (s *Sender) Send(datas []Data) error {
+	// 无法序列化的数据只上报错误，重试也无法写入
+	ste := &StatsError{
+		Ft:         true,
+		FtNotRetry: true,
+	}
+	now := time.Now()
+	ms := now.UnixNano() / int64(time.Millisecond)
+	var buf bytes.Buffer
+	for _, d := range datas {
+		line, err := jsoniter.Marshal(record{Time: ms, Data: d})
+		if err != nil {
+			ste.Errors++
+			ste.LastError = fmt.Sprintf("%s marshal data error: %v", s.Name(), err)
+			continue
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+		ste.Success++
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if buf.Len() > 0 {
+		if err := s.write(now, buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	if now.Sub(s.lastPurge) >= purgeInterval {
+		s.purge(now)
+	}
+	if ste.Errors > 0 {
+		return ste
+	}
+	return nil
+}
+
+// write 将数据追加到 now 所在的分段，需要持有 mux
+func (s *Sender) write(now time.Time, data []byte) error {
+	start := now.Truncate(segmentInterval)
+	var last *segment
+	if len(s.segments) > 0 {
+		last = s.segments[len(s.segments)-1]
+	}
+	if s.file == nil || last == nil || !last.start.Equal(start) {
+		if s.file != nil {
+			s.file.Close()
+			s.file = nil
+		}
+		if last == nil || !last.start.Equal(start) {
+			last = &segment{start: start, path: filepath.Join(s.dir, segmentPrefix+strconv.FormatInt(start.Unix(), 10)+segmentSuffix)}
+			s.segments = append(s.segments, last)
+		}
+		f, err := os.OpenFile(last.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, DefaultFilePerm)
+		if err != nil {
+			return err
+		}
+		s.file = f
+	}
+	n, err := s.file.Write(data)
+	last.size += int64(n)
+	return err
+}
+
+// purge 删除超过保留时间的分段，以及超过容量上限时最早的分段，正在写入的分段不会被删除，需要持有 mux
+func (s *Sender) purge(now time.Time) {
+	s.lastPurge = now
+	var total int64
+	for _, seg := range s.segments {
+		total += seg.size
+	}
+	deadline := now.Add(-s.retention)
+	for len(s.segments) > 1 || (len(s.segments) == 1 && s.file == nil) {
+		seg := s.segments[0]
+		expired := !seg.start.Add(segmentInterval).After(deadline)
+		if !expired && (s.maxSize <= 0 || total <= s.maxSize || len(s.segments) == 1) {
+			break
+		}
+		if err := os.Remove(seg.path); err != nil && !os.IsNotExist(err) {
+			log.Errorf("Runner[%v] Sender[%v] remove expired segment %s error: %v", s.runnerName, s.name, seg.path, err)
+			break
+		}
+		total -= seg.size
+		s.segments = s.segments[1:]
+	}
+}
+
+// Query 按时间从新到旧返回满足条件的数据
+func (s *Sender) Query(q Query) ([]Record, error) {
+	if q.Limit <= 0 {
+		q.Limit = DefaultQueryLimit
+	}
+	if q.Limit > MaxQueryLimit {
+		q.Limit = MaxQueryLimit
+	}
+	s.mux.Lock()
+	segments := make([]segment, 0, len(s.segments))
+	for _, seg := range s.segments {
+		segments = append(segments, *seg)
+	}
+	s.mux.Unlock()
+
+	var records []Record
+	for i := len(segments) - 1; i >= 0 && len(records) < q.Limit; i-- {
+		seg := segments[i]
+		if !q.Start.IsZero() && !seg.start.Add(segmentInterval).After(q.Start) {
+			break
+		}
+		if !q.End.IsZero() && seg.start.After(q.End) {
+			continue
+		}
+		matches, err := s.scan(seg.path, q, q.Limit-len(records))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return records, err
+		}
+		for j := len(matches) - 1; j >= 0; j-- {
+			records = append(records, matches[j])
+		}
+	}
+	return records, nil
+}
+
+// scan 读取分段文件中满足条件的数据，只保留最新的 limit 条
+func (s *Sender) scan(path string, q Query, limit int) ([]Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var (
+		matches []Record
+		keyword = []byte(q.Keyword)
+		br      = bufio.NewReader(f)
+	)
+	for {
+		line, err := br.ReadBytes('\n')
+		// 没有换行符的最后一行可能正在写入，忽略
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(keyword) > 0 && !bytes.Contains(line, keyword) {
+			continue
+		}
+		var rec record
+		if jsoniter.Unmarshal(line, &rec) != nil {
+			continue
+		}
+		t := time.Unix(0, rec.Time*int64(time.Millisecond))
+		if (!q.Start.IsZero() && t.Before(q.Start)) || (!q.End.IsZero() && t.After(q.End)) || !match(rec.Data, q.Filters) {
+			continue
+		}
+		matches = append(matches, Record{Time: t, Data: rec.Data})
+		if len(matches) >= 2*limit {
+			matches = append(matches[:0], matches[len(matches)-limit:]...)
+		}
+	}
+	if len(matches) > limit {
+		matches = matches[len(matches)-limit:]
+	}
+	return matches, nil
+}
+
+func match(data Data, filters map[string]string) bool {
+	for key, value := range filters {
+		v, err := GetMapValue(data, GetKeys(key)...)
+		if err != nil || fmt.Sprint(v) != value {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Sender) Close() error {
+	storesMux.Lock()
+	list := stores[s.runnerName]
+	for i, store := range list {
+		if store == s {
+			list = append(list[:i], list[i+1:]...)
+			break
+		}
+	}
+	if len(list) == 0 {
+		delete(stores, s.runnerName)
+	} else {
+		stores[s.runnerName] = list
+	}
+	storesMux.Unlock()
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// QueryRunner 查询 runner 的所有本地存储，按时间从新到旧返回最多 Limit 条数据，runner 没有本地存储时返回 ErrNotSupport
+func QueryRunner(runnerName string, q Query) ([]Record, error) {
+	storesMux.RLock()
+	list := append([]*Sender(nil), stores[runnerName]...)
+	storesMux.RUnlock()
+	if len(list) == 0 {
+		return nil, ErrNotSupport
+	}
+	if q.Limit <= 0 {
+		q.Limit = DefaultQueryLimit
+	}
+	if q.Limit > MaxQueryLimit {
+		q.Limit = MaxQueryLimit
+	}
+	var records []Record
+	for _, s := range list {
+		recs, err := s.Query(q)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, recs...)
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Time.After(records[j].Time) })
+	if len(records) > q.Limit {
+		records = records[:q.Limit]
+	}
+	return records, nil
+}
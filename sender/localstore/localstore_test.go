@@ -0,0 +1,93 @@
+package localstore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/qiniu/logkit/conf"
+	. "github.com/qiniu/logkit/sender/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+func TestLocalStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestLocalStore")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	_, err = NewSender(conf.MapConf{KeyLocalStorePath: dir, KeyLocalStoreRetention: "30m"})
+	assert.Error(t, err)
+
+	s, err := NewSender(conf.MapConf{KeyLocalStorePath: dir, KeyRunnerName: "TestLocalStore"})
+	assert.NoError(t, err)
+	assert.NoError(t, s.Send([]Data{
+		{"level": "info", "msg": "started", "req": map[string]interface{}{"code": 200}},
+		{"level": "error", "msg": "connection refused", "req": map[string]interface{}{"code": 502}},
+	}))
+	assert.NoError(t, s.Send([]Data{{"level": "error", "msg": "timeout", "req": map[string]interface{}{"code": 504}}}))
+
+	// 按时间从新到旧返回
+	records, err := QueryRunner("TestLocalStore", Query{})
+	assert.NoError(t, err)
+	if assert.Len(t, records, 3) {
+		assert.Equal(t, "timeout", records[0].Data["msg"])
+		assert.Equal(t, "started", records[2].Data["msg"])
+	}
+	records, err = QueryRunner("TestLocalStore", Query{Filters: map[string]string{"level": "error"}, Limit: 1})
+	assert.NoError(t, err)
+	if assert.Len(t, records, 1) {
+		assert.Equal(t, "timeout", records[0].Data["msg"])
+	}
+	records, err = QueryRunner("TestLocalStore", Query{Filters: map[string]string{"req.code": "502"}})
+	assert.NoError(t, err)
+	assert.Len(t, records, 1)
+	records, err = QueryRunner("TestLocalStore", Query{Keyword: "refused"})
+	assert.NoError(t, err)
+	assert.Len(t, records, 1)
+	records, err = QueryRunner("TestLocalStore", Query{End: time.Now().Add(-time.Hour)})
+	assert.NoError(t, err)
+	assert.Len(t, records, 0)
+	records, err = QueryRunner("TestLocalStore", Query{Start: time.Now().Add(time.Minute)})
+	assert.NoError(t, err)
+	assert.Len(t, records, 0)
+
+	// 关闭后不能再查询，重新打开后可以查询到已经保存的数据
+	assert.NoError(t, s.Close())
+	_, err = QueryRunner("TestLocalStore", Query{})
+	assert.Equal(t, ErrNotSupport, err)
+	s, err = NewSender(conf.MapConf{KeyLocalStorePath: dir, KeyRunnerName: "TestLocalStore"})
+	assert.NoError(t, err)
+	defer s.Close()
+	records, err = QueryRunner("TestLocalStore", Query{})
+	assert.NoError(t, err)
+	assert.Len(t, records, 3)
+}
+
+func TestLocalStorePurge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestLocalStorePurge")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	s := &Sender{name: "TestLocalStorePurge", dir: dir, retention: 2 * time.Hour}
+	for i := 4; i >= 0; i-- {
+		assert.NoError(t, s.write(now.Add(-time.Duration(i)*time.Hour), []byte(`{"t":0,"d":{"a":1}}`+"\n")))
+	}
+	assert.Len(t, s.segments, 5)
+	s.purge(now)
+	// 保留时间内的分段以及与保留时间重叠的分段不会被删除
+	assert.Len(t, s.segments, 3)
+	files, err := filepath.Glob(filepath.Join(dir, segmentPrefix+"*"+segmentSuffix))
+	assert.NoError(t, err)
+	assert.Len(t, files, 3)
+
+	// 超过容量上限时删除最早的分段，正在写入的分段不会被删除
+	s.maxSize = 1
+	s.purge(now)
+	assert.Len(t, s.segments, 1)
+	assert.NoError(t, s.file.Close())
+}
@@ -32,6 +32,7 @@ type mockPandora struct {
 	SetMux      sync.Mutex
 	PostDataNum int
 	DumpDataNum int
+	LastRepo    string
 }
 
 //NewMockPandoraWithPrefix 测试的mock pandora server
@@ -129,6 +130,7 @@ func (s *mockPandora) PostRepos_Data() echo.HandlerFunc {
 		var r *bufio.Reader
 
 		log.Println(c.Get("reponame"), "post data!!!")
+		s.LastRepo = c.Param("reponame")
 		req := c.Request()
 		if req.Header.Get("Content-Encoding") == "gzip" {
 			reqBody, err := gzip.NewReader(req.Body)
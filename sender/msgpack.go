@@ -0,0 +1,235 @@
+package sender
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"time"
+
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+// AppendMsgpack 将 v 以 msgpack 格式追加到 buf 中。map 按 key 排序后输出，保证相同的数据序列化结果一致，
+// time.Time 与 json 一样序列化为 RFC3339Nano 格式的字符串
+func AppendMsgpack(buf []byte, v interface{}) ([]byte, error) {
+	switch val := v.(type) {
+	case nil:
+		return append(buf, 0xc0), nil
+	case bool:
+		if val {
+			return append(buf, 0xc3), nil
+		}
+		return append(buf, 0xc2), nil
+	case string:
+		return appendMsgpackString(buf, val), nil
+	case []byte:
+		return appendMsgpackBinary(buf, val), nil
+	case int:
+		return appendMsgpackInt(buf, int64(val)), nil
+	case int8:
+		return appendMsgpackInt(buf, int64(val)), nil
+	case int16:
+		return appendMsgpackInt(buf, int64(val)), nil
+	case int32:
+		return appendMsgpackInt(buf, int64(val)), nil
+	case int64:
+		return appendMsgpackInt(buf, val), nil
+	case uint:
+		return appendMsgpackUint(buf, uint64(val)), nil
+	case uint8:
+		return appendMsgpackUint(buf, uint64(val)), nil
+	case uint16:
+		return appendMsgpackUint(buf, uint64(val)), nil
+	case uint32:
+		return appendMsgpackUint(buf, uint64(val)), nil
+	case uint64:
+		return appendMsgpackUint(buf, val), nil
+	case float32:
+		buf = append(buf, 0xca)
+		return appendUint32(buf, math.Float32bits(val)), nil
+	case float64:
+		buf = append(buf, 0xcb)
+		return appendUint64(buf, math.Float64bits(val)), nil
+	case json.Number:
+		if i, err := val.Int64(); err == nil {
+			return appendMsgpackInt(buf, i), nil
+		}
+		f, err := val.Float64()
+		if err != nil {
+			return nil, err
+		}
+		return AppendMsgpack(buf, f)
+	case time.Time:
+		return appendMsgpackString(buf, val.Format(time.RFC3339Nano)), nil
+	case Data:
+		return appendMsgpackMap(buf, map[string]interface{}(val))
+	case map[string]interface{}:
+		return appendMsgpackMap(buf, val)
+	case []interface{}:
+		buf = appendMsgpackHeader(buf, len(val), 0x90, 0xdc, 0xdd)
+		var err error
+		for _, item := range val {
+			if buf, err = AppendMsgpack(buf, item); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	}
+	return appendMsgpackReflect(buf, reflect.ValueOf(v))
+}
+
+func appendMsgpackReflect(buf []byte, rv reflect.Value) ([]byte, error) {
+	var err error
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			return append(buf, 0xc0), nil
+		}
+		return AppendMsgpack(buf, rv.Elem().Interface())
+	case reflect.Slice, reflect.Array:
+		buf = appendMsgpackHeader(buf, rv.Len(), 0x90, 0xdc, 0xdd)
+		for i := 0; i < rv.Len(); i++ {
+			if buf, err = AppendMsgpack(buf, rv.Index(i).Interface()); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("msgpack unsupported map key type %v", rv.Type().Key())
+		}
+		m := make(map[string]interface{}, rv.Len())
+		for _, key := range rv.MapKeys() {
+			m[key.String()] = rv.MapIndex(key).Interface()
+		}
+		return appendMsgpackMap(buf, m)
+	case reflect.String:
+		return appendMsgpackString(buf, rv.String()), nil
+	case reflect.Bool:
+		return AppendMsgpack(buf, rv.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return appendMsgpackInt(buf, rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return appendMsgpackUint(buf, rv.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return AppendMsgpack(buf, rv.Float())
+	}
+	if s, ok := rv.Interface().(fmt.Stringer); ok {
+		return appendMsgpackString(buf, s.String()), nil
+	}
+	return nil, fmt.Errorf("msgpack unsupported type %T", rv.Interface())
+}
+
+func appendMsgpackMap(buf []byte, m map[string]interface{}) ([]byte, error) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	buf = appendMsgpackHeader(buf, len(keys), 0x80, 0xde, 0xdf)
+	var err error
+	for _, k := range keys {
+		buf = appendMsgpackString(buf, k)
+		if buf, err = AppendMsgpack(buf, m[k]); err != nil {
+			return nil, fmt.Errorf("msgpack encode field %v error: %v", k, err)
+		}
+	}
+	return buf, nil
+}
+
+// AppendMsgpackArrayHeader 写入长度为 n 的 msgpack array 头部，之后依次追加 n 个元素即为完整的 array
+func AppendMsgpackArrayHeader(buf []byte, n int) []byte {
+	return appendMsgpackHeader(buf, n, 0x90, 0xdc, 0xdd)
+}
+
+// appendMsgpackHeader 写入 array 或 map 的长度，长度小于 16 时使用 fix 格式
+func appendMsgpackHeader(buf []byte, n int, fix, code16, code32 byte) []byte {
+	switch {
+	case n < 16:
+		return append(buf, fix|byte(n))
+	case n <= math.MaxUint16:
+		return appendUint16(append(buf, code16), uint16(n))
+	default:
+		return appendUint32(append(buf, code32), uint32(n))
+	}
+}
+
+func appendMsgpackString(buf []byte, s string) []byte {
+	n := len(s)
+	switch {
+	case n < 32:
+		buf = append(buf, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		buf = append(buf, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		buf = appendUint16(append(buf, 0xda), uint16(n))
+	default:
+		buf = appendUint32(append(buf, 0xdb), uint32(n))
+	}
+	return append(buf, s...)
+}
+
+func appendMsgpackBinary(buf []byte, b []byte) []byte {
+	n := len(b)
+	switch {
+	case n <= math.MaxUint8:
+		buf = append(buf, 0xc4, byte(n))
+	case n <= math.MaxUint16:
+		buf = appendUint16(append(buf, 0xc5), uint16(n))
+	default:
+		buf = appendUint32(append(buf, 0xc6), uint32(n))
+	}
+	return append(buf, b...)
+}
+
+// appendMsgpackInt 使用能够表示该值的最短格式
+func appendMsgpackInt(buf []byte, i int64) []byte {
+	if i >= 0 {
+		return appendMsgpackUint(buf, uint64(i))
+	}
+	switch {
+	case i >= -32:
+		return append(buf, byte(i))
+	case i >= math.MinInt8:
+		return append(buf, 0xd0, byte(i))
+	case i >= math.MinInt16:
+		return appendUint16(append(buf, 0xd1), uint16(i))
+	case i >= math.MinInt32:
+		return appendUint32(append(buf, 0xd2), uint32(i))
+	default:
+		return appendUint64(append(buf, 0xd3), uint64(i))
+	}
+}
+
+func appendMsgpackUint(buf []byte, u uint64) []byte {
+	switch {
+	case u <= 127:
+		return append(buf, byte(u))
+	case u <= math.MaxUint8:
+		return append(buf, 0xcc, byte(u))
+	case u <= math.MaxUint16:
+		return appendUint16(append(buf, 0xcd), uint16(u))
+	case u <= math.MaxUint32:
+		return appendUint32(append(buf, 0xce), uint32(u))
+	default:
+		return appendUint64(append(buf, 0xcf), u)
+	}
+}
+
+func appendUint16(buf []byte, v uint16) []byte {
+	return append(buf, byte(v>>8), byte(v))
+}
+
+func appendUint32(buf []byte, v uint32) []byte {
+	return append(buf, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendUint64(buf []byte, v uint64) []byte {
+	return appendUint32(appendUint32(buf, uint32(v>>32)), uint32(v))
+}
+
+func marshalMsgpack(data Data) ([]byte, error) {
+	return AppendMsgpack(nil, data)
+}
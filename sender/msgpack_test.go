@@ -0,0 +1,69 @@
+package sender
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+func TestAppendMsgpack(t *testing.T) {
+	tests := []struct {
+		input interface{}
+		exp   []byte
+	}{
+		{input: nil, exp: []byte{0xc0}},
+		{input: true, exp: []byte{0xc3}},
+		{input: false, exp: []byte{0xc2}},
+		{input: 1, exp: []byte{0x01}},
+		{input: -1, exp: []byte{0xff}},
+		{input: -33, exp: []byte{0xd0, 0xdf}},
+		{input: 200, exp: []byte{0xcc, 0xc8}},
+		{input: int64(70000), exp: []byte{0xce, 0x00, 0x01, 0x11, 0x70}},
+		{input: uint64(math.MaxUint64), exp: []byte{0xcf, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{input: int64(math.MinInt64), exp: []byte{0xd3, 0x80, 0, 0, 0, 0, 0, 0, 0}},
+		{input: 1.5, exp: []byte{0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}},
+		{input: float32(1.5), exp: []byte{0xca, 0x3f, 0xc0, 0, 0}},
+		{input: json.Number("12"), exp: []byte{0x0c}},
+		{input: json.Number("1.5"), exp: []byte{0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}},
+		{input: "abc", exp: []byte{0xa3, 'a', 'b', 'c'}},
+		{input: []byte("ab"), exp: []byte{0xc4, 0x02, 'a', 'b'}},
+		{input: []interface{}{1, "a"}, exp: []byte{0x92, 0x01, 0xa1, 'a'}},
+		{input: []string{"a"}, exp: []byte{0x91, 0xa1, 'a'}},
+		{input: Data{"b": 2, "a": 1}, exp: []byte{0x82, 0xa1, 'a', 0x01, 0xa1, 'b', 0x02}},
+		{input: map[string]int{"a": 1}, exp: []byte{0x81, 0xa1, 'a', 0x01}},
+		{input: time.Date(2018, 9, 1, 0, 0, 0, 0, time.UTC), exp: append([]byte{0xb4}, "2018-09-01T00:00:00Z"...)},
+	}
+	for _, test := range tests {
+		got, err := AppendMsgpack(nil, test.input)
+		assert.NoError(t, err, "%v", test.input)
+		assert.Equal(t, test.exp, got, "%v", test.input)
+	}
+
+	long := strings.Repeat("a", 40)
+	got, err := AppendMsgpack(nil, long)
+	require.NoError(t, err)
+	assert.Equal(t, append([]byte{0xd9, 40}, long...), got)
+	long = strings.Repeat("a", 300)
+	got, err = AppendMsgpack(nil, long)
+	require.NoError(t, err)
+	assert.Equal(t, append([]byte{0xda, 0x01, 0x2c}, long...), got)
+
+	items := make([]interface{}, 20)
+	got, err = AppendMsgpack(nil, items)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0xdc, 0x00, 0x14}, got[:3])
+	assert.Len(t, got, 23)
+	assert.Equal(t, []byte{0x93}, AppendMsgpackArrayHeader(nil, 3))
+
+	_, err = AppendMsgpack(nil, map[int]int{1: 1})
+	assert.Error(t, err)
+	_, err = AppendMsgpack(nil, Data{"a": make(chan int)})
+	assert.Error(t, err)
+}
@@ -0,0 +1,389 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qiniu/log"
+	"github.com/qiniu/pandora-go-sdk/base/reqerr"
+	"github.com/sven0726/fasttemplate"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/sender"
+	. "github.com/qiniu/logkit/sender/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+var _ sender.Sender = &Sender{}
+
+const (
+	DefaultNotifyRateLimit = 20
+
+	rateWindow = time.Minute
+	// 单条消息内容的最大长度，超过时截断，避免超过平台的消息大小限制
+	maxContentSize = 4000
+)
+
+func init() {
+	sender.RegisterConstructor(TypeNotify, NewSender)
+}
+
+// Sender 将每条数据渲染为一条群机器人消息，发送到飞书、钉钉或者企业微信，适用于数据量很小的告警数据，
+// 通常与 sender router 一起使用，只将严重的错误发送给群机器人
+type Sender struct {
+	name       string
+	runnerName string
+	platform   string
+	webhook    string
+	secret     string
+	atAll      bool
+	atMobiles  []string
+	rateLimit  int
+
+	title   *fasttemplate.Template
+	content *fasttemplate.Template
+	client  *http.Client
+
+	mutex       sync.Mutex
+	windowStart time.Time
+	windowSent  int
+	// suppressed 因为超过发送频率限制而没有发送的数据条数，在下一条消息中提示
+	suppressed int64
+}
+
+func NewSender(c conf.MapConf) (sender.Sender, error) {
+	platform, _ := c.GetStringOr(KeyNotifyPlatform, NotifyPlatformFeishu)
+	if platform != NotifyPlatformFeishu && platform != NotifyPlatformDingTalk && platform != NotifyPlatformWeCom {
+		return nil, fmt.Errorf("%v %q is not supported, should be one of %v, %v or %v",
+			KeyNotifyPlatform, platform, NotifyPlatformFeishu, NotifyPlatformDingTalk, NotifyPlatformWeCom)
+	}
+	webhook, err := c.GetPasswordEnvString(KeyNotifyWebhookURL)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = url.Parse(webhook); err != nil {
+		return nil, fmt.Errorf("%v is invalid: %v", KeyNotifyWebhookURL, err)
+	}
+	secret, _ := c.GetPasswordEnvStringOr(KeyNotifySecret, "")
+	if secret != "" && platform == NotifyPlatformWeCom {
+		return nil, fmt.Errorf("%v is not supported by %v", KeyNotifySecret, NotifyPlatformWeCom)
+	}
+	runnerName, _ := c.GetStringOr(KeyRunnerName, UnderfinedRunnerName)
+	title, _ := c.GetStringOr(KeyNotifyTitle, "")
+	if strings.TrimSpace(title) == "" {
+		title = "logkit 告警(" + runnerName + ")"
+	}
+	content, _ := c.GetStringOr(KeyNotifyTemplate, "")
+	rateLimit, _ := c.GetIntOr(KeyNotifyRateLimit, DefaultNotifyRateLimit)
+	if rateLimit <= 0 {
+		return nil, fmt.Errorf("%v must be positive", KeyNotifyRateLimit)
+	}
+	atAll, _ := c.GetBoolOr(KeyNotifyAtAll, false)
+	atMobiles, _ := c.GetStringListOr(KeyNotifyAtMobiles, nil)
+	if len(atMobiles) > 0 && platform == NotifyPlatformFeishu {
+		return nil, fmt.Errorf("%v is not supported by %v", KeyNotifyAtMobiles, NotifyPlatformFeishu)
+	}
+	timeout, _ := c.GetStringOr(KeyHttpTimeout, "30s")
+	dur, err := time.ParseDuration(timeout)
+	if err != nil {
+		return nil, errors.New("timeout configure " + timeout + " is invalid")
+	}
+	name, _ := c.GetStringOr(KeyName, fmt.Sprintf("notifySender:(%v)", platform))
+
+	s := &Sender{
+		name:       name,
+		runnerName: runnerName,
+		platform:   platform,
+		webhook:    webhook,
+		secret:     secret,
+		atAll:      atAll,
+		atMobiles:  atMobiles,
+		rateLimit:  rateLimit,
+		title:      fasttemplate.New(title, "{{", "}}"),
+		client:     &http.Client{Timeout: dur},
+	}
+	if strings.TrimSpace(content) != "" {
+		s.content = fasttemplate.New(content, "{{", "}}")
+	}
+	return s, nil
+}
+
+func (s *Sender) Name() string {
+	return s.name
+}
+
+// allow 判断当前时间窗口内是否还可以发送消息，不能发送时记录忽略的条数
+func (s *Sender) allow(now time.Time) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if now.Sub(s.windowStart) >= rateWindow {
+		s.windowStart = now
+		s.windowSent = 0
+	}
+	if s.windowSent >= s.rateLimit {
+		s.suppressed++
+		return false
+	}
+	s.windowSent++
+	return true
+}
+
+// takeSuppressed 返回并清空被忽略的条数，发送失败时需要通过 restoreSuppressed 放回
+func (s *Sender) takeSuppressed() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	n := s.suppressed
+	s.suppressed = 0
+	return n
+}
+
+func (s *Sender) restoreSuppressed(n int64) {
+	s.mutex.Lock()
+	s.suppressed += n
+	s.mutex.Unlock()
+}
+
+// Send 每条数据发送一条消息，超过发送频率限制的数据直接忽略，不作为错误重试，避免告警堆积
+func (s *Sender) Send(datas []Data) error {
+	var (
+		failure []Data
+		lastErr error
+		ignored int
+		se      = &StatsError{}
+	)
+	for _, d := range datas {
+		if !s.allow(time.Now()) {
+			ignored++
+			se.AddSuccess()
+			continue
+		}
+		suppressed := s.takeSuppressed()
+		if err := s.post(s.message(d, suppressed)); err != nil {
+			s.restoreSuppressed(suppressed)
+			log.Errorf("Runner[%v] Sender[%v] send notify message error %v", s.runnerName, s.Name(), err)
+			lastErr = err
+			failure = append(failure, d)
+			se.AddErrors()
+			continue
+		}
+		se.AddSuccess()
+	}
+	if ignored > 0 {
+		log.Warnf("Runner[%v] Sender[%v] ignore %d records because of %v limit %d per minute", s.runnerName, s.Name(), ignored, KeyNotifyRateLimit, s.rateLimit)
+	}
+	if len(failure) > 0 {
+		se.LastError = lastErr.Error()
+		se.SendError = reqerr.NewSendError("send notify message failed, last error is: "+lastErr.Error(), sender.ConvertDatasBack(failure), sender.ErrorTypeOf(lastErr))
+		return se
+	}
+	return nil
+}
+
+// render 渲染模版，占位符为字段名，嵌套的字段使用 . 连接，字段不存在时渲染为空
+func render(t *fasttemplate.Template, d Data) string {
+	return t.ExecuteFuncString(func(w io.Writer, tag string) (int, error) {
+		val, err := GetMapValue(d, strings.Split(strings.TrimSpace(tag), ".")...)
+		if err != nil || val == nil {
+			return 0, nil
+		}
+		return w.Write([]byte(valueString(val)))
+	})
+}
+
+func valueString(val interface{}) string {
+	switch v := val.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case map[string]interface{}, []interface{}, Data:
+		bts, err := json.Marshal(v)
+		if err == nil {
+			return string(bts)
+		}
+	}
+	return fmt.Sprint(val)
+}
+
+// defaultContent 没有配置内容模版时按字段名排序列出所有字段
+func defaultContent(d Data) string {
+	keys := make([]string, 0, len(d))
+	for k := range d {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var buf bytes.Buffer
+	for _, k := range keys {
+		buf.WriteString("**" + k + "**: " + valueString(d[k]) + "\n")
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// message 生成各个平台的消息体，飞书使用消息卡片，钉钉和企业微信使用 markdown 消息
+func (s *Sender) message(d Data, suppressed int64) map[string]interface{} {
+	title := render(s.title, d)
+	var content string
+	if s.content != nil {
+		content = render(s.content, d)
+	} else {
+		content = defaultContent(d)
+	}
+	content = TruncateStrSize(content, maxContentSize)
+	if suppressed > 0 {
+		content += fmt.Sprintf("\n\n> 超过发送频率限制，已忽略 %d 条消息", suppressed)
+	}
+
+	switch s.platform {
+	case NotifyPlatformDingTalk:
+		for _, mobile := range s.atMobiles {
+			content += " @" + mobile
+		}
+		msg := map[string]interface{}{
+			"msgtype": "markdown",
+			"markdown": map[string]interface{}{
+				"title": title,
+				"text":  "### " + title + "\n\n" + content,
+			},
+		}
+		if s.atAll || len(s.atMobiles) > 0 {
+			msg["at"] = map[string]interface{}{
+				"atMobiles": s.atMobiles,
+				"isAtAll":   s.atAll,
+			}
+		}
+		return msg
+	case NotifyPlatformWeCom:
+		// 企业微信的 markdown 消息不支持@群成员，需要@时使用文本消息
+		if s.atAll || len(s.atMobiles) > 0 {
+			mentioned := append([]string(nil), s.atMobiles...)
+			if s.atAll {
+				mentioned = append(mentioned, "@all")
+			}
+			return map[string]interface{}{
+				"msgtype": "text",
+				"text": map[string]interface{}{
+					"content":               title + "\n" + content,
+					"mentioned_mobile_list": mentioned,
+				},
+			}
+		}
+		return map[string]interface{}{
+			"msgtype": "markdown",
+			"markdown": map[string]interface{}{
+				"content": "### " + title + "\n" + content,
+			},
+		}
+	default:
+		if s.atAll {
+			content += "\n<at id=all></at>"
+		}
+		return map[string]interface{}{
+			"msg_type": "interactive",
+			"card": map[string]interface{}{
+				"header": map[string]interface{}{
+					"title":    map[string]interface{}{"tag": "plain_text", "content": title},
+					"template": "red",
+				},
+				"elements": []interface{}{
+					map[string]interface{}{
+						"tag":  "div",
+						"text": map[string]interface{}{"tag": "lark_md", "content": content},
+					},
+				},
+			},
+		}
+	}
+}
+
+// sign 生成签名，飞书的签名放在消息体中，钉钉的签名放在 url 参数中
+func (s *Sender) sign(msg map[string]interface{}, now time.Time) (string, error) {
+	if s.secret == "" {
+		return s.webhook, nil
+	}
+	switch s.platform {
+	case NotifyPlatformDingTalk:
+		timestamp := strconv.FormatInt(now.UnixNano()/int64(time.Millisecond), 10)
+		mac := hmac.New(sha256.New, []byte(s.secret))
+		mac.Write([]byte(timestamp + "\n" + s.secret))
+		u, err := url.Parse(s.webhook)
+		if err != nil {
+			return "", err
+		}
+		query := u.Query()
+		query.Set("timestamp", timestamp)
+		query.Set("sign", base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+		u.RawQuery = query.Encode()
+		return u.String(), nil
+	case NotifyPlatformFeishu:
+		timestamp := strconv.FormatInt(now.Unix(), 10)
+		mac := hmac.New(sha256.New, []byte(timestamp+"\n"+s.secret))
+		msg["timestamp"] = timestamp
+		msg["sign"] = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	}
+	return s.webhook, nil
+}
+
+// webhookResponse 兼容各个平台的返回结果，飞书返回 code 和 msg，钉钉和企业微信返回 errcode 和 errmsg
+type webhookResponse struct {
+	Code    *int   `json:"code"`
+	Msg     string `json:"msg"`
+	ErrCode *int   `json:"errcode"`
+	ErrMsg  string `json:"errmsg"`
+}
+
+func (s *Sender) post(msg map[string]interface{}) error {
+	webhook, err := s.sign(msg, time.Now())
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, webhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set(ContentTypeHeader, ApplicationJson)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return &sender.HTTPStatusError{StatusCode: resp.StatusCode, Body: fmt.Sprintf("response code is %v, response body is %v", resp.StatusCode, string(respBody))}
+	}
+	var ret webhookResponse
+	if err = json.Unmarshal(respBody, &ret); err != nil {
+		return fmt.Errorf("unmarshal response body %v error: %v", string(respBody), err)
+	}
+	if ret.Code != nil && *ret.Code != 0 {
+		return fmt.Errorf("code %d: %v", *ret.Code, ret.Msg)
+	}
+	if ret.ErrCode != nil && *ret.ErrCode != 0 {
+		return fmt.Errorf("errcode %d: %v", *ret.ErrCode, ret.ErrMsg)
+	}
+	return nil
+}
+
+func (s *Sender) Close() error {
+	return nil
+}
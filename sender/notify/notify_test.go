@@ -0,0 +1,181 @@
+package notify
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/qiniu/logkit/conf"
+	. "github.com/qiniu/logkit/sender/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+type webhookServer struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	messages []map[string]interface{}
+	queries  []string
+	resp     string
+}
+
+func newWebhookServer(resp string) *webhookServer {
+	s := &webhookServer{resp: resp}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		msg := map[string]interface{}{}
+		json.Unmarshal(body, &msg)
+		s.mutex.Lock()
+		s.messages = append(s.messages, msg)
+		s.queries = append(s.queries, r.URL.RawQuery)
+		resp := s.resp
+		s.mutex.Unlock()
+		w.Write([]byte(resp))
+	}))
+	return s
+}
+
+func TestFeishuSender(t *testing.T) {
+	server := newWebhookServer(`{"code":0,"msg":"success"}`)
+	defer server.Close()
+	s, err := NewSender(conf.MapConf{
+		KeyRunnerName:       "runner1",
+		KeyNotifyPlatform:   NotifyPlatformFeishu,
+		KeyNotifyWebhookURL: server.URL,
+		KeyNotifySecret:     "secret",
+		KeyNotifyTitle:      "[{{severity}}] {{host.name}}",
+		KeyNotifyTemplate:   "**错误**: {{message}} {{missing}}",
+		KeyNotifyAtAll:      "true",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "notifySender:(feishu)", s.Name())
+
+	now := time.Now().Unix()
+	assert.NoError(t, s.Send([]Data{{
+		"severity": "error",
+		"host":     map[string]interface{}{"name": "h1"},
+		"message":  "disk full",
+	}}))
+	require.Len(t, server.messages, 1)
+	msg := server.messages[0]
+	assert.Equal(t, "interactive", msg["msg_type"])
+	card := msg["card"].(map[string]interface{})
+	assert.Equal(t, "[error] h1", card["header"].(map[string]interface{})["title"].(map[string]interface{})["content"])
+	text := card["elements"].([]interface{})[0].(map[string]interface{})["text"].(map[string]interface{})
+	assert.Equal(t, "**错误**: disk full \n<at id=all></at>", text["content"])
+
+	timestamp := msg["timestamp"].(string)
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	assert.NoError(t, err)
+	assert.InDelta(t, now, ts, 2)
+	mac := hmac.New(sha256.New, []byte(timestamp+"\nsecret"))
+	assert.Equal(t, base64.StdEncoding.EncodeToString(mac.Sum(nil)), msg["sign"])
+
+	// 平台返回错误时整条数据作为失败数据返回
+	server.resp = `{"code":9499,"msg":"too many request"}`
+	err = s.Send([]Data{{"message": "a"}, {"message": "b"}})
+	se, ok := err.(*StatsError)
+	require.True(t, ok)
+	assert.Equal(t, int64(2), se.Errors)
+	assert.Contains(t, se.LastError, "too many request")
+	assert.NotNil(t, se.SendError)
+}
+
+func TestDingTalkSender(t *testing.T) {
+	server := newWebhookServer(`{"errcode":0,"errmsg":"ok"}`)
+	defer server.Close()
+	s, err := NewSender(conf.MapConf{
+		KeyNotifyPlatform:   NotifyPlatformDingTalk,
+		KeyNotifyWebhookURL: server.URL + "/robot/send?access_token=token",
+		KeyNotifySecret:     "secret",
+		KeyNotifyAtMobiles:  "13800000000, 13900000000",
+		KeyNotifyRateLimit:  "2",
+	})
+	require.NoError(t, err)
+
+	datas := []Data{{"b": 1, "a": "x"}, {"a": "y"}, {"a": "z"}, {"a": "w"}}
+	assert.NoError(t, s.Send(datas))
+	require.Len(t, server.messages, 2)
+	msg := server.messages[0]
+	assert.Equal(t, "markdown", msg["msgtype"])
+	markdown := msg["markdown"].(map[string]interface{})
+	assert.Equal(t, "logkit 告警(UnderfinedRunnerName)", markdown["title"])
+	assert.Equal(t, "### logkit 告警(UnderfinedRunnerName)\n\n**a**: x\n**b**: 1 @13800000000 @13900000000", markdown["text"])
+	assert.Equal(t, map[string]interface{}{
+		"atMobiles": []interface{}{"13800000000", "13900000000"},
+		"isAtAll":   false,
+	}, msg["at"])
+
+	q, err := url.ParseQuery(server.queries[0])
+	require.NoError(t, err)
+	assert.Equal(t, "token", q.Get("access_token"))
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(q.Get("timestamp") + "\nsecret"))
+	assert.Equal(t, base64.StdEncoding.EncodeToString(mac.Sum(nil)), q.Get("sign"))
+
+	// 下一个时间窗口的第一条消息提示被忽略的条数
+	s.(*Sender).windowStart = time.Now().Add(-rateWindow)
+	assert.NoError(t, s.Send([]Data{{"a": "v"}}))
+	require.Len(t, server.messages, 3)
+	assert.Contains(t, server.messages[2]["markdown"].(map[string]interface{})["text"], "已忽略 2 条消息")
+	assert.Equal(t, int64(0), s.(*Sender).suppressed)
+}
+
+func TestWeComSender(t *testing.T) {
+	server := newWebhookServer(`{"errcode":0,"errmsg":"ok"}`)
+	defer server.Close()
+	s, err := NewSender(conf.MapConf{
+		KeyNotifyPlatform:   NotifyPlatformWeCom,
+		KeyNotifyWebhookURL: server.URL,
+		KeyNotifyTemplate:   "{{message}}",
+	})
+	require.NoError(t, err)
+	assert.NoError(t, s.Send([]Data{{"message": "m1"}}))
+	assert.Equal(t, map[string]interface{}{
+		"msgtype":  "markdown",
+		"markdown": map[string]interface{}{"content": "### logkit 告警(UnderfinedRunnerName)\nm1"},
+	}, server.messages[0])
+
+	s, err = NewSender(conf.MapConf{
+		KeyNotifyPlatform:   NotifyPlatformWeCom,
+		KeyNotifyWebhookURL: server.URL,
+		KeyNotifyTemplate:   "{{message}}",
+		KeyNotifyAtAll:      "true",
+		KeyNotifyAtMobiles:  "13800000000",
+	})
+	require.NoError(t, err)
+	server.resp = `{"errcode":45009,"errmsg":"api freq out of limit"}`
+	err = s.Send([]Data{{"message": "m2"}})
+	assert.Error(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"msgtype": "text",
+		"text": map[string]interface{}{
+			"content":               "logkit 告警(UnderfinedRunnerName)\nm2",
+			"mentioned_mobile_list": []interface{}{"13800000000", "@all"},
+		},
+	}, server.messages[1])
+}
+
+func TestNewSenderError(t *testing.T) {
+	_, err := NewSender(conf.MapConf{KeyNotifyPlatform: "slack", KeyNotifyWebhookURL: "http://127.0.0.1"})
+	assert.Error(t, err)
+	_, err = NewSender(conf.MapConf{KeyNotifyPlatform: NotifyPlatformFeishu})
+	assert.Error(t, err)
+	_, err = NewSender(conf.MapConf{KeyNotifyPlatform: NotifyPlatformWeCom, KeyNotifyWebhookURL: "http://127.0.0.1", KeyNotifySecret: "s"})
+	assert.Error(t, err)
+	_, err = NewSender(conf.MapConf{KeyNotifyPlatform: NotifyPlatformFeishu, KeyNotifyWebhookURL: "http://127.0.0.1", KeyNotifyAtMobiles: "13800000000"})
+	assert.Error(t, err)
+	_, err = NewSender(conf.MapConf{KeyNotifyWebhookURL: "http://127.0.0.1", KeyNotifyRateLimit: "0"})
+	assert.Error(t, err)
+}
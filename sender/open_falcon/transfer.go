@@ -139,14 +139,14 @@ func (ts *TransferSender) Send(datas []Data) error {
 	if len(transferDatas) == 0 {
 		log.Warnf("Runner[%v] Sender[%v] send no data", ts.runnerName, ts.Name())
 		ste.LastError = "no valid data to send"
-		ste.SendError = reqerr.NewSendError("no valid data to send", sender.ConvertDatasBack(datas), reqerr.TypeDefault)
+		ste.SendError = reqerr.NewSendError("no valid data to send", sender.ConvertDatasBack(datas), sender.TypeRejected)
 		return ste
 	}
 	byteData, err := json.Marshal(transferDatas)
 	if err != nil {
 		log.Errorf("Runner[%v] Sender[%v] marshal transferDatas %+v failed: %v", ts.runnerName, ts.Name(), transferDatas, err)
 		ste.LastError = err.Error()
-		ste.SendError = reqerr.NewSendError(err.Error(), sender.ConvertDatasBack(datas), reqerr.TypeDefault)
+		ste.SendError = reqerr.NewSendError(err.Error(), sender.ConvertDatasBack(datas), sender.TypeMarshalError)
 		return ste
 	}
 	req, err := http.NewRequest(http.MethodPost, ts.path, bytes.NewReader(byteData))
@@ -175,7 +175,7 @@ func (ts *TransferSender) Send(datas []Data) error {
 		}
 		log.Errorf("Runner[%v] Sender[%v] response code is %v, response body is %v\n", ts.runnerName, ts.Name(), resp.StatusCode, string(respBody))
 		ste.LastError = string(respBody)
-		ste.SendError = reqerr.NewSendError(string(respBody), sender.ConvertDatasBack(datas), reqerr.TypeDefault)
+		ste.SendError = reqerr.NewSendError(string(respBody), sender.ConvertDatasBack(datas), sender.StatusErrorType(resp.StatusCode))
 		return ste
 	}
 	respBody, err = ioutil.ReadAll(resp.Body)
@@ -198,11 +198,14 @@ func (ts *TransferSender) Send(datas []Data) error {
 	}
 	if respData.Msg != Success || respData.Data.Invalid != 0 {
 		log.Warnf("Runner[%v] Sender[%v] send to transfer failed %v\n", ts.runnerName, ts.Name(), respData)
+		errorType := reqerr.TypeDefault
 		if respData.Msg == Success {
 			ste.Errors = int64(respData.Data.Invalid)
 			ste.Success = int64(respData.Data.Total) - ste.Errors
+			// 请求成功但是有无效的数据，重试也不会成功
+			errorType = sender.TypeRejected
 		}
-		ste.SendError = reqerr.NewSendError(string(respBody), sender.ConvertDatasBack(datas), reqerr.TypeDefault)
+		ste.SendError = reqerr.NewSendError(string(respBody), sender.ConvertDatasBack(datas), errorType)
 		return ste
 	}
 	log.Infof("sender to open-falcon success: %+v", respData)
@@ -0,0 +1,240 @@
+package pandora
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qiniu/log"
+
+	logkitconf "github.com/qiniu/logkit/conf"
+	. "github.com/qiniu/logkit/sender/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const (
+	defaultCredentialRefreshInterval = time.Minute
+	credentialRequestTimeout         = 10 * time.Second
+)
+
+// credentialRefresher 定期从密钥文件或回调地址读取 ak/sk 和 token，内容变化时调用 apply，
+// 密钥轮换后长期运行的 logkit 不需要重启
+type credentialRefresher struct {
+	runnerName string
+	name       string
+	file       string
+	url        string
+	interval   time.Duration
+	client     *http.Client
+	apply      func(logkitconf.MapConf)
+
+	mutex   sync.Mutex
+	lastMod time.Time
+	lastRaw string
+
+	triggerChan chan struct{}
+	exitChan    chan struct{}
+	closeOnce   sync.Once
+	wg          sync.WaitGroup
+}
+
+// newCredentialRefresher 没有配置密钥文件和回调地址时返回 nil
+func newCredentialRefresher(runnerName, name string, conf logkitconf.MapConf) (*credentialRefresher, error) {
+	file, _ := conf.GetStringOr(KeyPandoraCredentialFile, "")
+	url, _ := conf.GetStringOr(KeyPandoraCredentialURL, "")
+	file, url = strings.TrimSpace(file), strings.TrimSpace(url)
+	if file == "" && url == "" {
+		return nil, nil
+	}
+	if file == "" && !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return nil, fmt.Errorf("%v %q should start with http:// or https://", KeyPandoraCredentialURL, url)
+	}
+	intervalStr, _ := conf.GetStringOr(KeyPandoraCredentialRefreshInterval, "")
+	interval := defaultCredentialRefreshInterval
+	if intervalStr != "" {
+		var err error
+		if interval, err = time.ParseDuration(intervalStr); err != nil {
+			return nil, fmt.Errorf("parse %v %q error: %v", KeyPandoraCredentialRefreshInterval, intervalStr, err)
+		}
+		if interval < time.Second {
+			return nil, fmt.Errorf("%v %v is less than 1s", KeyPandoraCredentialRefreshInterval, interval)
+		}
+	}
+	return &credentialRefresher{
+		runnerName:  runnerName,
+		name:        name,
+		file:        file,
+		url:         url,
+		interval:    interval,
+		client:      &http.Client{Timeout: credentialRequestTimeout},
+		triggerChan: make(chan struct{}, 1),
+		exitChan:    make(chan struct{}),
+	}, nil
+}
+
+// load 读取密钥，内容没有变化时返回 nil
+func (c *credentialRefresher) load() (logkitconf.MapConf, error) {
+	var (
+		raw []byte
+		err error
+	)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.file != "" {
+		fi, err := os.Stat(c.file)
+		if err != nil {
+			return nil, err
+		}
+		if fi.ModTime().Equal(c.lastMod) {
+			return nil, nil
+		}
+		if raw, err = ioutil.ReadFile(c.file); err != nil {
+			return nil, err
+		}
+		c.lastMod = fi.ModTime()
+	} else {
+		resp, err := c.client.Get(c.url)
+		if err != nil {
+			return nil, err
+		}
+		raw, err = ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("credential url returns status %v: %s", resp.Status, TruncateStrSize(string(raw), DefaultTruncateMaxSize))
+		}
+	}
+	if string(raw) == c.lastRaw {
+		return nil, nil
+	}
+	values := make(map[string]interface{})
+	if err = json.Unmarshal(raw, &values); err != nil {
+		return nil, fmt.Errorf("unmarshal credentials error: %v", err)
+	}
+	c.lastRaw = string(raw)
+	creds := make(logkitconf.MapConf, len(values))
+	for k, v := range values {
+		if str, ok := v.(string); ok {
+			creds[k] = str
+		} else {
+			creds[k] = fmt.Sprint(v)
+		}
+	}
+	return creds, nil
+}
+
+func (c *credentialRefresher) start(apply func(logkitconf.MapConf)) {
+	c.apply = apply
+	c.wg.Add(1)
+	go c.loop()
+}
+
+func (c *credentialRefresher) loop() {
+	defer c.wg.Done()
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.exitChan:
+			return
+		case <-ticker.C:
+		case <-c.triggerChan:
+		}
+		c.refresh()
+	}
+}
+
+func (c *credentialRefresher) refresh() {
+	creds, err := c.load()
+	if err != nil {
+		log.Errorf("Runner[%v] Sender[%v]: refresh pandora credentials failed: %v", c.runnerName, c.name, err)
+		return
+	}
+	if creds == nil {
+		return
+	}
+	c.apply(creds)
+	log.Infof("Runner[%v] Sender[%v]: pandora credentials are refreshed", c.runnerName, c.name)
+}
+
+// trigger 认证失败时立即刷新一次，不会阻塞发送
+func (c *credentialRefresher) trigger() {
+	select {
+	case c.triggerChan <- struct{}{}:
+	default:
+	}
+}
+
+func (c *credentialRefresher) close() {
+	c.closeOnce.Do(func() {
+		close(c.exitChan)
+	})
+	c.wg.Wait()
+}
+
+// hasTokens 判断密钥中是否包含 token 配置
+func hasTokens(creds logkitconf.MapConf) bool {
+	for k := range creds {
+		for _, prefix := range []string{SchemaFreeTokensPrefix, LogDBTokensPrefix, TsDBTokensPrefix, KodoTokensPrefix} {
+			if strings.HasPrefix(k, prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// applyCredentials 使用新的 ak/sk 重新创建 client，并更新 token，同时更新按字段路由的 sender
+func (s *Sender) applyCredentials(creds logkitconf.MapConf) {
+	ak, _ := creds.GetStringOr(KeyPandoraAk, "")
+	sk, _ := creds.GetStringOr(KeyPandoraSk, "")
+	var tokens *Tokens
+	if hasTokens(creds) {
+		t, err := getTokensFromConf(creds)
+		if err != nil {
+			log.Debug(err)
+		}
+		tokens = &t
+	}
+
+	senders := []*Sender{s}
+	s.routeMux.Lock()
+	for _, rs := range s.routeSenders {
+		senders = append(senders, rs)
+	}
+	s.routeMux.Unlock()
+	for _, rs := range senders {
+		rs.opt.tokenLock.Lock()
+		keyChanged := ak != "" && sk != "" && (ak != rs.opt.ak || sk != rs.opt.sk)
+		if keyChanged {
+			rs.opt.ak, rs.opt.sk = ak, sk
+		}
+		if tokens != nil {
+			rs.opt.tokens = *tokens
+		}
+		rs.opt.tokenLock.Unlock()
+		if !keyChanged || rs.opt.sendType == SendTypeRaw {
+			continue
+		}
+		rs.clientMux.RLock()
+		endpoint := rs.endpoint
+		rs.clientMux.RUnlock()
+		if err := rs.resetClient(endpoint); err != nil {
+			log.Errorf("Runner[%v] Sender[%v]: recreate pandora client with new ak/sk failed: %v", rs.opt.runnerName, rs.opt.name, err)
+		}
+	}
+}
+
+// requestCredentialRefresh 认证失败时通知立即刷新密钥
+func (s *Sender) requestCredentialRefresh() {
+	if s.credentials != nil {
+		s.credentials.trigger()
+	}
+}
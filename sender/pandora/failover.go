@@ -0,0 +1,145 @@
+package pandora
+
+import (
+	"sync"
+	"time"
+
+	"github.com/qiniu/log"
+	"github.com/qiniu/pandora-go-sdk/base/reqerr"
+	"github.com/qiniu/pandora-go-sdk/pipeline"
+
+	"github.com/qiniu/logkit/sender"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const (
+	defaultFailoverThreshold = 5
+	defaultFailbackInterval  = 10 * time.Minute
+	// 替换下来的 client 可能还有请求在进行中，等待一段时间后再关闭
+	defaultClientCloseDelay = time.Minute
+)
+
+// failover 在主备 pipeline 域名之间切换：当前域名连续发送失败 threshold 次后切换到下一个域名，
+// 使用备用域名超过 failbackInterval 后，在发送成功时尝试切回主域名
+type failover struct {
+	endpoints        []string
+	threshold        int
+	failbackInterval time.Duration
+
+	mutex      sync.Mutex
+	current    int
+	failures   int
+	switchedAt time.Time
+}
+
+// newFailover 没有备用域名时返回 nil
+func newFailover(primary string, standby []string, threshold int, failbackInterval time.Duration) *failover {
+	if len(standby) == 0 {
+		return nil
+	}
+	if threshold <= 0 {
+		threshold = defaultFailoverThreshold
+	}
+	if failbackInterval <= 0 {
+		failbackInterval = defaultFailbackInterval
+	}
+	return &failover{
+		endpoints:        append([]string{primary}, standby...),
+		threshold:        threshold,
+		failbackInterval: failbackInterval,
+	}
+}
+
+func (f *failover) endpoint() string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.endpoints[f.current]
+}
+
+// record 记录一次发送的结果，需要切换域名时返回新的域名
+func (f *failover) record(failed bool) (endpoint string, switched bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if !failed {
+		f.failures = 0
+		if f.current == 0 || time.Since(f.switchedAt) < f.failbackInterval {
+			return "", false
+		}
+		f.current = 0
+	} else {
+		f.failures++
+		if f.failures < f.threshold {
+			return "", false
+		}
+		f.failures = 0
+		f.current = (f.current + 1) % len(f.endpoints)
+	}
+	f.switchedAt = time.Now()
+	return f.endpoints[f.current], true
+}
+
+// isEndpointError 网络错误、服务端 5xx 等与域名相关的错误才计入切换，
+// 认证失败、数据被拒绝和数据过大与域名无关
+func isEndpointError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if se, ok := err.(*reqerr.SendError); ok && se.ErrorType == reqerr.TypeBinaryUnpack {
+		return false
+	}
+	return sender.ClassifyError(err) == sender.ErrorClassRetryable
+}
+
+func (s *Sender) getClient() pipeline.PipelineAPI {
+	s.clientMux.RLock()
+	defer s.clientMux.RUnlock()
+	return s.client
+}
+
+// resetClient 使用 endpoint 和当前的 ak/sk 创建新的 client 替换正在使用的 client
+func (s *Sender) resetClient(endpoint string) error {
+	client, err := s.newClient(endpoint)
+	if err != nil {
+		return err
+	}
+	s.clientMux.Lock()
+	old := s.client
+	s.client = client
+	s.endpoint = endpoint
+	s.clientMux.Unlock()
+
+	// 新的域名可能对应另一套仓库，下次发送前重新获取 schema
+	s.schemasMux.Lock()
+	s.lastUpdate = time.Time{}
+	s.schemasMux.Unlock()
+	if old != nil {
+		delay := s.opt.timeout
+		if delay <= 0 || delay > defaultClientCloseDelay {
+			delay = defaultClientCloseDelay
+		}
+		time.AfterFunc(delay, func() {
+			old.Close()
+		})
+	}
+	return nil
+}
+
+// recordSendResult 根据发送结果判断是否需要切换域名
+func (s *Sender) recordSendResult(err error) {
+	if s.failover == nil {
+		return
+	}
+	endpoint, switched := s.failover.record(isEndpointError(err))
+	if !switched {
+		return
+	}
+	if err := s.resetClient(endpoint); err != nil {
+		log.Errorf("Runner[%v] Sender[%v]: switch pandora endpoint to %v failed: %v", s.opt.runnerName, s.opt.name, endpoint, err)
+		return
+	}
+	if !IsSelfRunner(s.opt.runnerName) {
+		log.Warnf("Runner[%v] Sender[%v]: pandora endpoint is switched to %v", s.opt.runnerName, s.opt.name, endpoint)
+	} else {
+		log.Debugf("Runner[%v] Sender[%v]: pandora endpoint is switched to %v", s.opt.runnerName, s.opt.name, endpoint)
+	}
+}
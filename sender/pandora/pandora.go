@@ -40,6 +40,10 @@ const (
 // pandora sender
 type Sender struct {
 	client             pipeline.PipelineAPI
+	endpoint           string
+	clientMux          sync.RWMutex
+	failover           *failover
+	credentials        *credentialRefresher
 	schemas            map[string]pipeline.RepoSchemaEntry
 	schemasMux         sync.RWMutex
 	lastUpdate         time.Time
@@ -50,6 +54,13 @@ type Sender struct {
 	extraInfo          map[string]string
 	sendType           string
 	ipConfig           *pipeline.LocateIPConfig
+
+	// routeSenders 按字段值路由时，每个非默认仓库对应的 sender
+	routeSenders map[string]*Sender
+	// routeRepos 没有白名单时已经允许路由的仓库，数量不超过 routeMaxRepos
+	routeRepos       map[string]bool
+	routeLimitWarned bool
+	routeMux         sync.Mutex
 }
 
 // UserSchema was parsed pandora schema from user's raw schema
@@ -88,6 +99,14 @@ type PandoraOption struct {
 	withip         string
 	extraInfo      bool
 
+	repoField     string          // 按该字段的值选择发送的仓库，为空时全部发送到 repoName
+	repoAllowList map[string]bool // 允许路由的仓库，为空时由 routeMaxRepos 限制仓库数量
+	routeMaxRepos int             // 没有白名单时最多路由的仓库数量
+
+	standbyEndpoints  []string      // 备用域名，当前域名持续发送失败时依次切换
+	failoverThreshold int           // 连续发送失败多少次后切换域名
+	failbackInterval  time.Duration // 使用备用域名多久后尝试切回主域名
+
 	enableLogdb   bool
 	logdbReponame string
 	logdbendpoint string
@@ -190,6 +209,21 @@ func NewSender(conf logkitconf.MapConf) (pandoraSender sender.Sender, err error)
 	withIp, _ := conf.GetBoolOr(KeyPandoraWithIP, false)
 	runnerName, _ := conf.GetStringOr(KeyRunnerName, UnderfinedRunnerName)
 	extraInfo, _ := conf.GetBoolOr(KeyPandoraExtraInfo, false)
+	repoField, _ := conf.GetStringOr(KeyPandoraRepoField, "")
+	repoAllowList, _ := conf.GetStringListOr(KeyPandoraRepoAllowList, []string{})
+	routeMaxRepos, _ := conf.GetIntOr(KeyPandoraRouteMaxRepos, DefaultRouteMaxTargets)
+	if routeMaxRepos <= 0 {
+		return nil, fmt.Errorf("%v must be positive", KeyPandoraRouteMaxRepos)
+	}
+	standbyHosts, _ := conf.GetStringListOr(KeyPandoraStandbyHosts, []string{})
+	failoverThreshold, _ := conf.GetIntOr(KeyPandoraFailoverThreshold, defaultFailoverThreshold)
+	failbackIntervalStr, _ := conf.GetStringOr(KeyPandoraFailbackInterval, "")
+	failbackInterval := defaultFailbackInterval
+	if failbackIntervalStr != "" {
+		if failbackInterval, err = time.ParseDuration(failbackIntervalStr); err != nil {
+			return nil, fmt.Errorf("parse %v %q error: %v", KeyPandoraFailbackInterval, failbackIntervalStr, err)
+		}
+	}
 
 	enableLogdb, _ := conf.GetBoolOr(KeyPandoraEnableLogDB, false)
 	logdbreponame, _ := conf.GetStringOr(KeyPandoraLogDBName, repoName)
@@ -254,7 +288,32 @@ func NewSender(conf logkitconf.MapConf) (pandoraSender sender.Sender, err error)
 	var subErr error
 	var tokens Tokens
 	if tokens, subErr = getTokensFromConf(conf); subErr != nil {
-		log.Debugf(subErr.Error())
+		log.Debugf("%v", subErr)
+	}
+
+	var credentials *credentialRefresher
+	if sendType != SendTypeRaw {
+		if credentials, err = newCredentialRefresher(runnerName, name, conf); err != nil {
+			return nil, err
+		}
+	}
+	if credentials != nil {
+		// 密钥文件中的 ak/sk 和 token 优先于配置中的值
+		creds, err := credentials.load()
+		if err != nil {
+			return nil, fmt.Errorf("Runner[%v] Sender[%v] load pandora credentials error: %v", runnerName, name, err)
+		}
+		if ak, _ := creds.GetStringOr(KeyPandoraAk, ""); ak != "" {
+			akFromEnv = ak
+		}
+		if sk, _ := creds.GetStringOr(KeyPandoraSk, ""); sk != "" {
+			skFromEnv = sk
+		}
+		if hasTokens(creds) {
+			if tokens, subErr = getTokensFromConf(creds); subErr != nil {
+				log.Debugf("%v", subErr)
+			}
+		}
 	}
 
 	if skFromEnv == "" && tokens.SchemaFreeTokens.PipelinePostDataToken.Token == "" {
@@ -295,6 +354,13 @@ func NewSender(conf logkitconf.MapConf) (pandoraSender sender.Sender, err error)
 		uuid:           uuid,
 		extraInfo:      extraInfo,
 
+		repoField:     strings.TrimSpace(repoField),
+		repoAllowList: make(map[string]bool),
+		routeMaxRepos: routeMaxRepos,
+
+		failoverThreshold: failoverThreshold,
+		failbackInterval:  failbackInterval,
+
 		enableLogdb:   enableLogdb,
 		logdbReponame: logdbreponame,
 		logdbendpoint: logdbhost,
@@ -348,8 +414,26 @@ func NewSender(conf logkitconf.MapConf) (pandoraSender sender.Sender, err error)
 	if withIp {
 		opt.withip = "logkitIP"
 	}
+	for _, repo := range repoAllowList {
+		if repo = strings.TrimSpace(repo); repo != "" {
+			opt.repoAllowList[repo] = true
+		}
+	}
+	for _, standby := range standbyHosts {
+		if standby = strings.TrimSpace(standby); standby != "" && standby != host {
+			opt.standbyEndpoints = append(opt.standbyEndpoints, standby)
+		}
+	}
 
-	return newPandoraSender(opt)
+	s, err := newPandoraSender(opt)
+	if err != nil {
+		return nil, err
+	}
+	if credentials != nil {
+		s.credentials = credentials
+		credentials.start(s.applyCredentials)
+	}
+	return s, nil
 }
 
 func convertAnalyzerMap(analyzerStrs []string) map[string]string {
@@ -458,13 +542,44 @@ func (s *Sender) TokenRefresh(mapConf logkitconf.MapConf) error {
 		return err
 	} else {
 		s.opt.tokens = tokens
+		s.routeMux.Lock()
+		for _, rs := range s.routeSenders {
+			rs.opt.tokens = tokens
+		}
+		s.routeMux.Unlock()
 	}
 	return nil
 }
 
-func newPandoraSender(opt *PandoraOption) (s *Sender, err error) {
-	logger := pipelinebase.NewDefaultLogger()
+// newClient 使用当前的 ak/sk 创建连接 endpoint 的 pipeline client
+func (s *Sender) newClient(endpoint string) (pipeline.PipelineAPI, error) {
+	s.opt.tokenLock.RLock()
+	ak, sk := s.opt.ak, s.opt.sk
+	s.opt.tokenLock.RUnlock()
+	config := pipeline.NewConfig().
+		WithPipelineEndpoint(endpoint).
+		WithAccessKeySecretKey(ak, sk).
+		WithLogger(pipelinebase.NewDefaultLogger()).
+		WithLoggerLevel(pipelinebase.LogInfo).
+		WithRequestRateLimit(s.opt.reqRateLimit).
+		WithFlowRateLimit(s.opt.flowRateLimit).
+		WithGzipData(s.opt.gzip).
+		WithHeaderUserAgent(s.opt.useragent).
+		WithInsecureServer(s.opt.insecureServer).
+		WithDefaultRegion(s.opt.region).
+		WithResponseTimeout(s.opt.timeout)
 
+	if s.opt.logdbendpoint != "" {
+		config = config.WithLogDBEndpoint(s.opt.logdbendpoint)
+	}
+	client, err := pipeline.New(config)
+	if err != nil {
+		return nil, fmt.Errorf("cannot init pipelineClient %v", err)
+	}
+	return client, nil
+}
+
+func newPandoraSender(opt *PandoraOption) (s *Sender, err error) {
 	if opt.reqRateLimit > 0 {
 		if !IsSelfRunner(opt.runnerName) {
 			log.Warnf("Runner[%v] Sender[%v]: you have limited send speed within %v requests/s", opt.runnerName, opt.name, opt.reqRateLimit)
@@ -487,6 +602,9 @@ func newPandoraSender(opt *PandoraOption) (s *Sender, err error) {
 		schemas:    make(map[string]pipeline.RepoSchemaEntry),
 		extraInfo:  utilsos.GetExtraInfo(),
 		sendType:   opt.sendType,
+
+		routeSenders: make(map[string]*Sender),
+		routeRepos:   make(map[string]bool),
 	}
 
 	expandAttr := make([]string, 0)
@@ -521,27 +639,13 @@ func newPandoraSender(opt *PandoraOption) (s *Sender, err error) {
 	/*
 		以下是 repo 创建相关的，raw类型的不需要处理
 	*/
-	config := pipeline.NewConfig().
-		WithPipelineEndpoint(opt.endpoint).
-		WithAccessKeySecretKey(opt.ak, opt.sk).
-		WithLogger(logger).
-		WithLoggerLevel(pipelinebase.LogInfo).
-		WithRequestRateLimit(opt.reqRateLimit).
-		WithFlowRateLimit(opt.flowRateLimit).
-		WithGzipData(opt.gzip).
-		WithHeaderUserAgent(opt.useragent).
-		WithInsecureServer(opt.insecureServer).
-		WithDefaultRegion(s.opt.region).
-		WithResponseTimeout(opt.timeout)
-
-	if opt.logdbendpoint != "" {
-		config = config.WithLogDBEndpoint(opt.logdbendpoint)
-	}
-	client, err := pipeline.New(config)
+	client, err := s.newClient(opt.endpoint)
 	if err != nil {
-		return nil, fmt.Errorf("cannot init pipelineClient %v", err)
+		return nil, err
 	}
 	s.client = client
+	s.endpoint = opt.endpoint
+	s.failover = newFailover(opt.endpoint, opt.standbyEndpoints, opt.failoverThreshold, opt.failbackInterval)
 
 	dsl := strings.TrimSpace(opt.autoCreate)
 	schemas, err := pipeline.DSLtoSchema(dsl)
@@ -683,7 +787,7 @@ func (s *Sender) UpdateSchemas() error {
 	if s.opt.sendType == SendTypeRaw {
 		return nil
 	}
-	schemas, err := s.client.GetUpdateSchemasWithInput(
+	schemas, err := s.getClient().GetUpdateSchemasWithInput(
 		&pipeline.GetRepoInput{
 			RepoName:     s.opt.repoName,
 			PandoraToken: s.opt.tokens.SchemaFreeTokens.PipelineGetRepoToken,
@@ -896,7 +1000,7 @@ func (s *Sender) generatePoint(data Data) (point Data) {
 		if !ok {
 			//不存在，但是必填，需要加上默认值
 			if v.Required {
-				value = s.client.GetDefault(v)
+				value = s.getClient().GetDefault(v)
 			} else {
 				continue
 			}
@@ -961,10 +1065,156 @@ func (s *Sender) Send(datas []Data) (se error) {
 	case SendTypeRaw:
 		return s.rawSend(datas)
 	default:
+		if s.opt.repoField != "" {
+			return s.routeSend(datas)
+		}
 		return s.schemaFreeSend(datas)
 	}
 }
 
+// getRouteRepo 根据 repoField 字段的值返回数据应当发送的仓库，不满足条件时返回默认仓库
+func (s *Sender) getRouteRepo(d Data) string {
+	repo, ok := d[s.opt.repoField].(string)
+	if !ok {
+		return s.opt.repoName
+	}
+	repo = strings.TrimSpace(repo)
+	if repo == "" {
+		return s.opt.repoName
+	}
+	if len(s.opt.repoAllowList) > 0 {
+		if !s.opt.repoAllowList[repo] {
+			return s.opt.repoName
+		}
+		return repo
+	}
+	if repo == s.opt.repoName || !s.reserveRouteRepo(repo) {
+		return s.opt.repoName
+	}
+	return repo
+}
+
+// reserveRouteRepo 没有白名单时记录新出现的仓库，已经路由的仓库达到 routeMaxRepos 后返回 false，
+// 避免异常或者恶意的字段值创建无限多的仓库和 sender
+func (s *Sender) reserveRouteRepo(repo string) bool {
+	s.routeMux.Lock()
+	defer s.routeMux.Unlock()
+	if s.routeRepos[repo] {
+		return true
+	}
+	if len(s.routeRepos) >= s.opt.routeMaxRepos {
+		if !s.routeLimitWarned {
+			s.routeLimitWarned = true
+			log.Warnf("Runner[%v] Sender[%v]: routed repos reach the limit %d of %v, records of new repos such as %q are sent to %v",
+				s.opt.runnerName, s.opt.name, s.opt.routeMaxRepos, KeyPandoraRouteMaxRepos, repo, s.opt.repoName)
+		}
+		return false
+	}
+	s.routeRepos[repo] = true
+	return true
+}
+
+// getRouteSender 获取发送到指定仓库的 sender，不存在时使用当前配置新建
+func (s *Sender) getRouteSender(repo string) (*Sender, error) {
+	s.routeMux.Lock()
+	defer s.routeMux.Unlock()
+	if rs, ok := s.routeSenders[repo]; ok {
+		return rs, nil
+	}
+	opt := s.opt
+	opt.name = s.opt.name + "_" + repo
+	opt.repoName = repo
+	opt.repoField = ""
+	opt.repoAllowList = nil
+	// 跟随实时仓库名称的导出仓库也使用路由后的名称
+	if s.opt.logdbReponame == s.opt.repoName {
+		opt.logdbReponame = repo
+	}
+	if s.opt.tsdbReponame == s.opt.repoName {
+		opt.tsdbReponame = repo
+	}
+	if s.opt.bucketName == s.opt.repoName {
+		opt.bucketName = repo
+	}
+	rs, err := newPandoraSender(&opt)
+	if err != nil {
+		return nil, err
+	}
+	rs.credentials = s.credentials
+	s.routeSenders[repo] = rs
+	return rs, nil
+}
+
+// routeSend 将数据按仓库分组后分别发送，并汇总各组的发送结果
+func (s *Sender) routeSend(datas []Data) error {
+	var (
+		repos  []string
+		groups = make(map[string][]Data)
+	)
+	for _, d := range datas {
+		repo := s.getRouteRepo(d)
+		if _, ok := groups[repo]; !ok {
+			repos = append(repos, repo)
+		}
+		groups[repo] = append(groups[repo], d)
+	}
+
+	var (
+		statsError  = &StatsError{}
+		failedDatas []map[string]interface{}
+		errorType   = reqerr.TypeDefault
+		groupTypes  = make(map[reqerr.SendErrorType]bool)
+	)
+	for _, repo := range repos {
+		group := groups[repo]
+		var err error
+		if repo == s.opt.repoName {
+			err = s.schemaFreeSend(group)
+		} else {
+			var rs *Sender
+			if rs, err = s.getRouteSender(repo); err == nil {
+				err = rs.schemaFreeSend(group)
+			}
+		}
+		if err == nil {
+			statsError.AddSuccessNum(len(group))
+			continue
+		}
+
+		statsError.LastError = fmt.Sprintf("repo %s: %v", repo, err)
+		se, ok := err.(*StatsError)
+		if !ok || se.SendError == nil {
+			statsError.AddErrorsNum(len(group))
+			failedDatas = append(failedDatas, sender.ConvertDatasBack(group)...)
+			groupTypes[reqerr.TypeDefault] = true
+			continue
+		}
+		groupFailed := se.SendError.GetFailDatas()
+		statsError.AddErrorsNum(len(groupFailed))
+		statsError.AddSuccessNum(len(group) - len(groupFailed))
+		failedDatas = append(failedDatas, groupFailed...)
+		if se.SendError.ErrorType == reqerr.TypeBinaryUnpack {
+			errorType = reqerr.TypeBinaryUnpack
+		}
+		groupTypes[se.SendError.ErrorType] = true
+	}
+	if len(failedDatas) == 0 {
+		return nil
+	}
+	// 所有失败的仓库错误类型相同时才使用该类型，否则按照可以重试处理
+	if errorType != reqerr.TypeBinaryUnpack && len(groupTypes) == 1 {
+		for t := range groupTypes {
+			errorType = t
+		}
+	}
+	statsError.SendError = reqerr.NewSendError(
+		fmt.Sprintf("route send failed with last error: %s", statsError.LastError),
+		failedDatas,
+		errorType,
+	)
+	return statsError
+}
+
 func (s *Sender) rawSend(datas []Data) (se error) {
 	for idx, v := range datas {
 		if v["_repo"] == nil || v["_raw"] == nil || v["_ak"] == nil || v["_sk"] == nil {
@@ -1193,8 +1443,12 @@ func (s *Sender) schemaFreeSend(datas []Data) (se error) {
 		},
 	}
 	s.opt.tokenLock.RUnlock()
-	schemas, se := s.client.PostDataSchemaFree(schemaFreeInput)
+	schemas, se := s.getClient().PostDataSchemaFree(schemaFreeInput)
+	s.recordSendResult(se)
 	if se != nil {
+		if sender.ClassifyError(se) == sender.ErrorClassAuth {
+			s.requestCredentialRefresh()
+		}
 		if nse, ok := se.(*reqerr.SendError); ok {
 			return &StatsError{
 				StatsInfo: StatsInfo{
@@ -1206,12 +1460,17 @@ func (s *Sender) schemaFreeSend(datas []Data) (se error) {
 			}
 		}
 
-		return &StatsError{
+		statsError := &StatsError{
 			StatsInfo: StatsInfo{
 				Errors:    int64(len(datas)),
 				LastError: se.Error(),
 			},
 		}
+		// 认证失败和被拒绝的请求需要带上错误类型，避免被当作普通错误反复重试
+		if errorType := sender.ErrorTypeOf(se); errorType != reqerr.TypeDefault {
+			statsError.SendError = reqerr.NewSendError(se.Error(), sender.ConvertDatasBack(datas), errorType)
+		}
+		return statsError
 	}
 	// 发送失败时无需更新 schema，更新 schema 的操作在 checkSchemaUpdate 中进行
 	if schemas != nil {
@@ -1229,10 +1488,21 @@ func (s *Sender) Name() string {
 }
 
 func (s *Sender) Close() error {
-	if s.client == nil {
+	if s.credentials != nil {
+		s.credentials.close()
+	}
+	s.routeMux.Lock()
+	for repo, rs := range s.routeSenders {
+		if err := rs.Close(); err != nil {
+			log.Errorf("Runner[%v] Sender[%v]: close route sender of repo %v failed: %v", s.opt.runnerName, s.opt.name, repo, err)
+		}
+	}
+	s.routeMux.Unlock()
+	client := s.getClient()
+	if client == nil {
 		return nil
 	}
-	return s.client.Close()
+	return client.Close()
 }
 
 func getDefaultLocateIPDetails(key string) *pipeline.LocateIPDetails {
@@ -4,6 +4,9 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
 	"strconv"
 	"strings"
@@ -316,6 +319,82 @@ func TestStatsSender(t *testing.T) {
 	}
 }
 
+func TestRoutePandoraSender(t *testing.T) {
+	pandora, pt := mockPandora.NewMockPandoraWithPrefix("/v2")
+	opt := &PandoraOption{
+		name:           "TestRoutePandoraSender",
+		repoName:       "TestRoutePandoraSender",
+		region:         "nb",
+		endpoint:       "http://127.0.0.1:" + pt,
+		ak:             "ak",
+		sk:             "sk",
+		schema:         "",
+		autoCreate:     "x1 s",
+		updateInterval: time.Second,
+		schemaFree:     true,
+		tokenLock:      new(sync.RWMutex),
+		repoField:      "tenant",
+		repoAllowList:  map[string]bool{"tenant_a": true},
+	}
+	s, err := newPandoraSender(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	assert.Equal(t, "tenant_a", s.getRouteRepo(Data{"tenant": "tenant_a"}))
+	assert.Equal(t, "TestRoutePandoraSender", s.getRouteRepo(Data{"tenant": "tenant_b"}))
+	assert.Equal(t, "TestRoutePandoraSender", s.getRouteRepo(Data{"tenant": 1}))
+	assert.Equal(t, "TestRoutePandoraSender", s.getRouteRepo(Data{"x1": "hh"}))
+
+	err = s.Send([]Data{{"x1": "hh", "tenant": "tenant_a"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "tenant_a", pandora.LastRepo)
+	assert.Equal(t, 1, len(s.routeSenders))
+
+	err = s.Send([]Data{{"x1": "hh", "tenant": "tenant_b"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "TestRoutePandoraSender", pandora.LastRepo)
+	assert.Equal(t, 1, len(s.routeSenders))
+}
+
+func TestRoutePandoraSenderLimit(t *testing.T) {
+	_, pt := mockPandora.NewMockPandoraWithPrefix("/v2")
+	opt := &PandoraOption{
+		name:           "TestRoutePandoraSenderLimit",
+		repoName:       "TestRoutePandoraSenderLimit",
+		region:         "nb",
+		endpoint:       "http://127.0.0.1:" + pt,
+		ak:             "ak",
+		sk:             "sk",
+		autoCreate:     "x1 s",
+		updateInterval: time.Second,
+		schemaFree:     true,
+		tokenLock:      new(sync.RWMutex),
+		repoField:      "tenant",
+		repoAllowList:  map[string]bool{},
+		routeMaxRepos:  2,
+	}
+	s, err := newPandoraSender(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	// 没有白名单时只路由到前 routeMaxRepos 个仓库，默认仓库不计入
+	assert.Equal(t, "TestRoutePandoraSenderLimit", s.getRouteRepo(Data{"tenant": "TestRoutePandoraSenderLimit"}))
+	assert.Equal(t, "tenant_a", s.getRouteRepo(Data{"tenant": "tenant_a"}))
+	assert.Equal(t, "tenant_b", s.getRouteRepo(Data{"tenant": "tenant_b"}))
+	assert.Equal(t, "TestRoutePandoraSenderLimit", s.getRouteRepo(Data{"tenant": "tenant_c"}))
+	assert.Equal(t, "tenant_a", s.getRouteRepo(Data{"tenant": "tenant_a"}))
+
+	var datas []Data
+	for i := 0; i < 10; i++ {
+		datas = append(datas, Data{"x1": "hh", "tenant": fmt.Sprintf("tenant_%d", i)})
+	}
+	assert.NoError(t, s.Send(datas))
+	assert.Len(t, s.routeRepos, 2)
+	assert.True(t, len(s.routeSenders) <= 2)
+}
+
 func TestConvertDate(t *testing.T) {
 	tnow := time.Now()
 	var tt interface{}
@@ -1088,3 +1167,107 @@ func TestAliasPandoraSender(t *testing.T) {
 		assert.Equal(t, dataSlice[idx].expect, len(data))
 	}
 }
+
+func TestFailover(t *testing.T) {
+	assert.Nil(t, newFailover("primary", nil, 2, time.Minute))
+
+	f := newFailover("primary", []string{"standby"}, 2, 100*time.Millisecond)
+	assert.Equal(t, "primary", f.endpoint())
+	_, switched := f.record(true)
+	assert.False(t, switched)
+	// 成功后重新计数
+	_, switched = f.record(false)
+	assert.False(t, switched)
+	_, switched = f.record(true)
+	assert.False(t, switched)
+	endpoint, switched := f.record(true)
+	assert.True(t, switched)
+	assert.Equal(t, "standby", endpoint)
+
+	// 未到 failbackInterval 时不切回主域名
+	_, switched = f.record(false)
+	assert.False(t, switched)
+	time.Sleep(150 * time.Millisecond)
+	endpoint, switched = f.record(false)
+	assert.True(t, switched)
+	assert.Equal(t, "primary", endpoint)
+}
+
+func TestPandoraSenderFailover(t *testing.T) {
+	pandora, pt := mockPandora.NewMockPandoraWithPrefix("/v2")
+	opt := &PandoraOption{
+		name:              "TestPandoraSenderFailover",
+		repoName:          "TestPandoraSenderFailover",
+		region:            "nb",
+		endpoint:          "http://127.0.0.1:1",
+		ak:                "ak",
+		sk:                "sk",
+		autoCreate:        "x1 s",
+		updateInterval:    time.Second,
+		schemaFree:        true,
+		tokenLock:         new(sync.RWMutex),
+		timeout:           time.Second,
+		standbyEndpoints:  []string{"http://127.0.0.1:" + pt},
+		failoverThreshold: 2,
+		failbackInterval:  time.Hour,
+	}
+	s, err := newPandoraSender(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	assert.Error(t, s.Send([]Data{{"x1": "hh"}}))
+	assert.Equal(t, "http://127.0.0.1:1", s.endpoint)
+	assert.Error(t, s.Send([]Data{{"x1": "hh"}}))
+	assert.Equal(t, "http://127.0.0.1:"+pt, s.endpoint)
+
+	assert.NoError(t, s.Send([]Data{{"x1": "failover"}}))
+	assert.Contains(t, pandora.Body, "x1=failover")
+}
+
+func TestCredentialRefresh(t *testing.T) {
+	_, pt := mockPandora.NewMockPandoraWithPrefix("/v2")
+	dir, err := ioutil.TempDir("", "TestCredentialRefresh")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "credentials.json")
+	assert.NoError(t, ioutil.WriteFile(file, []byte(`{"pandora_ak":"ak1","pandora_sk":"sk1"}`), 0644))
+
+	_, err = NewSender(conf.MapConf{
+		KeyPandoraRepoName:                  "TestCredentialRefresh",
+		KeyPandoraRegion:                    "nb",
+		KeyPandoraHost:                      "http://127.0.0.1:" + pt,
+		KeyPandoraCredentialURL:             "127.0.0.1:" + pt,
+		KeyPandoraSchemaFree:                "true",
+		KeyPandoraCredentialRefreshInterval: "1m",
+	})
+	assert.Error(t, err)
+
+	sd, err := NewSender(conf.MapConf{
+		KeyPandoraRepoName:       "TestCredentialRefresh",
+		KeyPandoraRegion:         "nb",
+		KeyPandoraHost:           "http://127.0.0.1:" + pt,
+		KeyPandoraCredentialFile: file,
+		KeyPandoraSchemaFree:     "true",
+	})
+	assert.NoError(t, err)
+	s := sd.(*Sender)
+	defer s.Close()
+	assert.Equal(t, "ak1", s.opt.ak)
+	assert.Equal(t, "sk1", s.opt.sk)
+
+	// 文件没有变化时不重复加载
+	creds, err := s.credentials.load()
+	assert.NoError(t, err)
+	assert.Nil(t, creds)
+
+	assert.NoError(t, ioutil.WriteFile(file, []byte(`{"pandora_ak":"ak2","pandora_sk":"sk2"}`), 0644))
+	assert.NoError(t, os.Chtimes(file, time.Now(), time.Now().Add(time.Second)))
+	oldClient := s.getClient()
+	s.credentials.refresh()
+	assert.Equal(t, "ak2", s.opt.ak)
+	assert.Equal(t, "sk2", s.opt.sk)
+	assert.True(t, oldClient != s.getClient())
+	assert.Equal(t, "http://127.0.0.1:"+pt, s.endpoint)
+	assert.NoError(t, s.Send([]Data{{"x1": "hh"}}))
+}
@@ -0,0 +1,782 @@
+package sender
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/qiniu/logkit/conf"
+	. "github.com/qiniu/logkit/sender/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+// protobuf 的 wire type
+const (
+	protoWireVarint  = 0
+	protoWireFixed64 = 1
+	protoWireBytes   = 2
+	protoWireFixed32 = 5
+)
+
+// ProtoSchema 根据用户提供的 .proto 中的 message 定义将 Data 序列化为 protobuf，不需要预先生成代码。
+// 支持标量类型、enum、嵌套 message、repeated(数值类型使用 packed 编码)、map 和 oneof，不支持 group 和 extensions
+type ProtoSchema struct {
+	root     *protoMessage
+	fieldMap map[string]string // proto 字段名 -> 数据中的字段名，只作用于根 message
+	format   string            // EncodeCache 中区分不同 schema 的格式名称
+}
+
+type protoMessage struct {
+	name   string
+	fields []*protoField
+}
+
+type protoField struct {
+	name     string
+	number   int
+	typeName string
+	repeated bool
+	// map<key, value> 字段，序列化为 key 为 1，value 为 2 的 repeated message
+	isMap     bool
+	keyType   string
+	valueType string
+
+	message *protoMessage    // typeName 或 valueType 为 message 时解析后的定义
+	enum    map[string]int64 // typeName 或 valueType 为 enum 时的取值
+}
+
+var protoScalarTypes = map[string]bool{
+	"double": true, "float": true, "int32": true, "int64": true, "uint32": true, "uint64": true,
+	"sint32": true, "sint64": true, "fixed32": true, "fixed64": true, "sfixed32": true, "sfixed64": true,
+	"bool": true, "string": true, "bytes": true,
+}
+
+// ParseProtoSchema 解析 descriptor 中的 message 定义，messageName 为空时使用第一个顶层 message。
+// fieldMap 格式为 "proto字段名=数据字段名,..."，没有配置的字段使用同名的数据字段
+func ParseProtoSchema(descriptor, messageName, fieldMap string) (*ProtoSchema, error) {
+	p := &protoParser{
+		tokens:   tokenizeProto(descriptor),
+		messages: make(map[string]*protoMessage),
+		enums:    make(map[string]map[string]int64),
+	}
+	var unresolved []*protoField
+	var first *protoMessage
+	for !p.eof() {
+		tok := p.next()
+		switch tok {
+		case "message":
+			msg, fields, err := p.parseMessage()
+			if err != nil {
+				return nil, err
+			}
+			if first == nil {
+				first = msg
+			}
+			unresolved = append(unresolved, fields...)
+		case "enum":
+			if err := p.parseEnum(); err != nil {
+				return nil, err
+			}
+		case "syntax", "package", "import", "option":
+			p.skipStatement()
+		case ";":
+		default:
+			return nil, fmt.Errorf("protobuf descriptor: unexpected %q", tok)
+		}
+	}
+	if first == nil {
+		return nil, errors.New("protobuf descriptor: no message is defined")
+	}
+	for _, f := range unresolved {
+		if err := p.resolve(f); err != nil {
+			return nil, err
+		}
+	}
+
+	root := first
+	if messageName != "" {
+		var ok bool
+		if root, ok = p.messages[lastProtoName(messageName)]; !ok {
+			return nil, fmt.Errorf("protobuf descriptor: message %v is not defined", messageName)
+		}
+	}
+	schema := &ProtoSchema{
+		root:     root,
+		fieldMap: make(map[string]string),
+		format:   protoSchemaFormat(descriptor, messageName, fieldMap),
+	}
+	for _, item := range strings.Split(fieldMap, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" || strings.TrimSpace(kv[1]) == "" {
+			return nil, fmt.Errorf("protobuf field map %q is invalid, format should be proto_field=data_field", item)
+		}
+		schema.fieldMap[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return schema, nil
+}
+
+// NewProtoSchemaFromConf 根据 protobuf_descriptor、protobuf_message 和 protobuf_field_map 创建 ProtoSchema，
+// protobuf_descriptor 中不包含 "{" 时作为 .proto 文件的路径读取
+func NewProtoSchemaFromConf(c conf.MapConf) (*ProtoSchema, error) {
+	descriptor, err := c.GetString(KeyProtobufDescriptor)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.Contains(descriptor, "{") {
+		content, err := ioutil.ReadFile(strings.TrimSpace(descriptor))
+		if err != nil {
+			return nil, fmt.Errorf("read protobuf descriptor file %v error, %v", descriptor, err)
+		}
+		descriptor = string(content)
+	}
+	messageName, _ := c.GetStringOr(KeyProtobufMessage, "")
+	fieldMap, _ := c.GetStringOr(KeyProtobufFieldMap, "")
+	return ParseProtoSchema(descriptor, messageName, fieldMap)
+}
+
+// Marshal 将一条数据序列化为 protobuf，数据中没有或者为 nil 的字段不输出
+func (s *ProtoSchema) Marshal(data Data) ([]byte, error) {
+	var buf []byte
+	for _, f := range s.root.fields {
+		key := f.name
+		if mapped, ok := s.fieldMap[key]; ok {
+			key = mapped
+		}
+		var err error
+		if buf, err = appendProtoField(buf, f, data[key]); err != nil {
+			return nil, fmt.Errorf("protobuf encode field %v error: %v", key, err)
+		}
+	}
+	return buf, nil
+}
+
+// protoSchemaFormat 以配置的哈希值区分不同的 schema，相同配置的 sender 可以共享序列化结果
+func protoSchemaFormat(descriptor, messageName, fieldMap string) string {
+	h := fnv.New64a()
+	h.Write([]byte(descriptor + "\x00" + messageName + "\x00" + fieldMap))
+	return EncodeFormatProtobuf + ":" + strconv.FormatUint(h.Sum64(), 16)
+}
+
+func appendProtoMessage(buf []byte, msg *protoMessage, v interface{}) ([]byte, error) {
+	var m map[string]interface{}
+	switch val := v.(type) {
+	case map[string]interface{}:
+		m = val
+	case Data:
+		m = val
+	case string:
+		if err := json.Unmarshal([]byte(val), &m); err != nil {
+			return nil, fmt.Errorf("message %v expects an object: %v", msg.name, err)
+		}
+	default:
+		return nil, fmt.Errorf("message %v expects an object, got %T", msg.name, v)
+	}
+	var err error
+	for _, f := range msg.fields {
+		if buf, err = appendProtoField(buf, f, m[f.name]); err != nil {
+			return nil, fmt.Errorf("%v.%v: %v", msg.name, f.name, err)
+		}
+	}
+	return buf, nil
+}
+
+func appendProtoField(buf []byte, f *protoField, v interface{}) ([]byte, error) {
+	if v == nil {
+		return buf, nil
+	}
+	var err error
+	switch {
+	case f.isMap:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			if d, isData := v.(Data); isData {
+				m = d
+			} else {
+				return nil, fmt.Errorf("map field expects an object, got %T", v)
+			}
+		}
+		entry := &protoField{number: 2, typeName: f.valueType, message: f.message, enum: f.enum}
+		keyField := &protoField{number: 1, typeName: f.keyType}
+		// 按 key 排序输出，保证相同的数据序列化结果一致
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			val := m[k]
+			var body []byte
+			if body, err = appendProtoValue(body, keyField, k); err != nil {
+				return nil, err
+			}
+			if val != nil {
+				if body, err = appendProtoValue(body, entry, val); err != nil {
+					return nil, err
+				}
+			}
+			buf = appendProtoTag(buf, f.number, protoWireBytes)
+			buf = appendProtoBytes(buf, body)
+		}
+		return buf, nil
+	case f.repeated:
+		items := protoItems(v)
+		if len(items) == 0 {
+			return buf, nil
+		}
+		if f.message == nil && protoPackable(f.typeName) {
+			var body []byte
+			for _, item := range items {
+				if body, err = appendProtoScalar(body, f, item); err != nil {
+					return nil, err
+				}
+			}
+			buf = appendProtoTag(buf, f.number, protoWireBytes)
+			return appendProtoBytes(buf, body), nil
+		}
+		for _, item := range items {
+			if item == nil {
+				continue
+			}
+			if buf, err = appendProtoValue(buf, f, item); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	}
+	return appendProtoValue(buf, f, v)
+}
+
+// appendProtoValue 写入 tag 和单个值
+func appendProtoValue(buf []byte, f *protoField, v interface{}) ([]byte, error) {
+	if f.message != nil {
+		body, err := appendProtoMessage(nil, f.message, v)
+		if err != nil {
+			return nil, err
+		}
+		buf = appendProtoTag(buf, f.number, protoWireBytes)
+		return appendProtoBytes(buf, body), nil
+	}
+	buf = appendProtoTag(buf, f.number, protoWireType(f.typeName))
+	return appendProtoScalar(buf, f, v)
+}
+
+// appendProtoScalar 写入不带 tag 的标量值
+func appendProtoScalar(buf []byte, f *protoField, v interface{}) ([]byte, error) {
+	switch f.typeName {
+	case "double":
+		x, err := protoFloat(v)
+		if err != nil {
+			return nil, err
+		}
+		return appendUint64LE(buf, math.Float64bits(x)), nil
+	case "float":
+		x, err := protoFloat(v)
+		if err != nil {
+			return nil, err
+		}
+		return appendUint32LE(buf, math.Float32bits(float32(x))), nil
+	case "int32", "int64":
+		x, err := protoInt(v)
+		if err != nil {
+			return nil, err
+		}
+		return appendVarint(buf, uint64(x)), nil
+	case "uint32", "uint64":
+		x, err := protoUint(v)
+		if err != nil {
+			return nil, err
+		}
+		return appendVarint(buf, x), nil
+	case "sint32", "sint64":
+		x, err := protoInt(v)
+		if err != nil {
+			return nil, err
+		}
+		return appendVarint(buf, uint64(x<<1)^uint64(x>>63)), nil
+	case "fixed32", "sfixed32":
+		x, err := protoInt(v)
+		if err != nil {
+			return nil, err
+		}
+		return appendUint32LE(buf, uint32(x)), nil
+	case "fixed64", "sfixed64":
+		x, err := protoInt(v)
+		if err != nil {
+			return nil, err
+		}
+		return appendUint64LE(buf, uint64(x)), nil
+	case "bool":
+		x, err := protoBool(v)
+		if err != nil {
+			return nil, err
+		}
+		if x {
+			return append(buf, 1), nil
+		}
+		return append(buf, 0), nil
+	case "string":
+		return appendProtoBytes(buf, []byte(protoString(v))), nil
+	case "bytes":
+		if b, ok := v.([]byte); ok {
+			return appendProtoBytes(buf, b), nil
+		}
+		return appendProtoBytes(buf, []byte(protoString(v))), nil
+	}
+	if f.enum != nil {
+		if name, ok := v.(string); ok {
+			if x, ok := f.enum[name]; ok {
+				return appendVarint(buf, uint64(x)), nil
+			}
+		}
+		x, err := protoInt(v)
+		if err != nil {
+			return nil, fmt.Errorf("unknown enum value %v", v)
+		}
+		return appendVarint(buf, uint64(x)), nil
+	}
+	return nil, fmt.Errorf("unsupported type %v", f.typeName)
+}
+
+func protoWireType(typeName string) int {
+	switch typeName {
+	case "double", "fixed64", "sfixed64":
+		return protoWireFixed64
+	case "float", "fixed32", "sfixed32":
+		return protoWireFixed32
+	case "string", "bytes":
+		return protoWireBytes
+	}
+	return protoWireVarint
+}
+
+func protoPackable(typeName string) bool {
+	return typeName != "string" && typeName != "bytes"
+}
+
+func protoItems(v interface{}) []interface{} {
+	if items, ok := v.([]interface{}); ok {
+		return items
+	}
+	rv := reflect.ValueOf(v)
+	if (rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() != reflect.Uint8) || rv.Kind() == reflect.Array {
+		items := make([]interface{}, rv.Len())
+		for i := range items {
+			items[i] = rv.Index(i).Interface()
+		}
+		return items
+	}
+	return []interface{}{v}
+}
+
+func protoInt(v interface{}) (int64, error) {
+	switch val := v.(type) {
+	case string:
+		if i, err := strconv.ParseInt(strings.TrimSpace(val), 10, 64); err == nil {
+			return i, nil
+		}
+		f, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+		return int64(f), err
+	case json.Number:
+		if i, err := val.Int64(); err == nil {
+			return i, nil
+		}
+		f, err := val.Float64()
+		return int64(f), err
+	case bool:
+		if val {
+			return 1, nil
+		}
+		return 0, nil
+	case time.Time:
+		return val.UnixNano(), nil
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(rv.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return int64(rv.Float()), nil
+	}
+	return 0, fmt.Errorf("can not convert %T to integer", v)
+}
+
+func protoUint(v interface{}) (uint64, error) {
+	if s, ok := v.(string); ok {
+		if u, err := strconv.ParseUint(strings.TrimSpace(s), 10, 64); err == nil {
+			return u, nil
+		}
+	}
+	if rv := reflect.ValueOf(v); rv.Kind() >= reflect.Uint && rv.Kind() <= reflect.Uint64 {
+		return rv.Uint(), nil
+	}
+	i, err := protoInt(v)
+	if err != nil {
+		return 0, err
+	}
+	if i < 0 {
+		return 0, fmt.Errorf("negative value %v for unsigned field", i)
+	}
+	return uint64(i), nil
+}
+
+func protoFloat(v interface{}) (float64, error) {
+	switch val := v.(type) {
+	case string:
+		return strconv.ParseFloat(strings.TrimSpace(val), 64)
+	case json.Number:
+		return val.Float64()
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), nil
+	}
+	i, err := protoInt(v)
+	return float64(i), err
+}
+
+func protoBool(v interface{}) (bool, error) {
+	switch val := v.(type) {
+	case bool:
+		return val, nil
+	case string:
+		return strconv.ParseBool(strings.TrimSpace(val))
+	}
+	f, err := protoFloat(v)
+	return f != 0, err
+}
+
+func protoString(v interface{}) string {
+	switch val := v.(type) {
+	case string:
+		return val
+	case []byte:
+		return string(val)
+	case json.Number:
+		return val.String()
+	case time.Time:
+		return val.Format(time.RFC3339Nano)
+	case map[string]interface{}, Data, []interface{}:
+		b, err := json.Marshal(val)
+		if err == nil {
+			return string(b)
+		}
+	}
+	return fmt.Sprint(v)
+}
+
+func appendProtoTag(buf []byte, number, wireType int) []byte {
+	return appendVarint(buf, uint64(number)<<3|uint64(wireType))
+}
+
+func appendProtoBytes(buf []byte, b []byte) []byte {
+	return append(appendVarint(buf, uint64(len(b))), b...)
+}
+
+func appendVarint(buf []byte, x uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], x)
+	return append(buf, tmp[:n]...)
+}
+
+func appendUint32LE(buf []byte, v uint32) []byte {
+	return append(buf, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func appendUint64LE(buf []byte, v uint64) []byte {
+	return appendUint32LE(appendUint32LE(buf, uint32(v)), uint32(v>>32))
+}
+
+// AppendProtoDelimited 以 varint 长度前缀的方式追加一条 protobuf 消息，多条消息放在同一个请求中时使用
+func AppendProtoDelimited(buf []byte, msg []byte) []byte {
+	return appendProtoBytes(buf, msg)
+}
+
+// protoParser 只解析 message 和 enum 定义，其余语句跳过
+type protoParser struct {
+	tokens   []string
+	pos      int
+	messages map[string]*protoMessage
+	enums    map[string]map[string]int64
+}
+
+// tokenizeProto 去掉注释后切分为标识符、数字、字符串和符号
+func tokenizeProto(s string) []string {
+	var tokens []string
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case strings.HasPrefix(s[i:], "//"):
+			for i < len(s) && s[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(s[i:], "/*"):
+			end := strings.Index(s[i+2:], "*/")
+			if end < 0 {
+				return tokens
+			}
+			i += end + 4
+		case unicode.IsSpace(rune(c)):
+			i++
+		case c == '"' || c == '\'':
+			j := i + 1
+			for j < len(s) && s[j] != c {
+				if s[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(s) {
+				j = len(s) - 1
+			}
+			tokens = append(tokens, s[i:j+1])
+			i = j + 1
+		case c == '_' || c == '.' || c == '-' || unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c)):
+			j := i + 1
+			for j < len(s) && (s[j] == '_' || s[j] == '.' || unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j]))) {
+				j++
+			}
+			tokens = append(tokens, s[i:j])
+			i = j
+		default:
+			tokens = append(tokens, string(c))
+			i++
+		}
+	}
+	return tokens
+}
+
+func (p *protoParser) eof() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *protoParser) next() string {
+	if p.eof() {
+		return ""
+	}
+	tok := p.tokens[p.pos]
+	p.pos++
+	return tok
+}
+
+func (p *protoParser) peek() string {
+	if p.eof() {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *protoParser) expect(tok string) error {
+	if got := p.next(); got != tok {
+		return fmt.Errorf("protobuf descriptor: expect %q but got %q", tok, got)
+	}
+	return nil
+}
+
+// skipStatement 跳过到下一个 ; 为止，遇到 {} 时跳过整个块
+func (p *protoParser) skipStatement() {
+	depth := 0
+	for !p.eof() {
+		switch p.next() {
+		case "{":
+			depth++
+		case "}":
+			depth--
+			if depth <= 0 {
+				return
+			}
+		case ";":
+			if depth == 0 {
+				return
+			}
+		}
+	}
+}
+
+// parseMessage 解析 message 名称及其定义体，返回 message 以及需要解析类型的字段（包括嵌套 message 的字段）
+func (p *protoParser) parseMessage() (*protoMessage, []*protoField, error) {
+	msg := &protoMessage{name: p.next()}
+	if err := p.expect("{"); err != nil {
+		return nil, nil, err
+	}
+	p.messages[msg.name] = msg
+	var all []*protoField
+	for {
+		tok := p.next()
+		switch tok {
+		case "":
+			return nil, nil, fmt.Errorf("protobuf descriptor: message %v is not closed", msg.name)
+		case "}":
+			return msg, all, nil
+		case ";":
+		case "message":
+			_, fields, err := p.parseMessage()
+			if err != nil {
+				return nil, nil, err
+			}
+			all = append(all, fields...)
+		case "enum":
+			if err := p.parseEnum(); err != nil {
+				return nil, nil, err
+			}
+		case "option", "reserved", "extensions":
+			p.skipStatement()
+		case "oneof":
+			p.next()
+			if err := p.expect("{"); err != nil {
+				return nil, nil, err
+			}
+			for p.peek() != "}" && !p.eof() {
+				if p.peek() == "option" {
+					p.skipStatement()
+					continue
+				}
+				f, err := p.parseField(p.next())
+				if err != nil {
+					return nil, nil, err
+				}
+				msg.fields = append(msg.fields, f)
+				all = append(all, f)
+			}
+			p.next()
+		default:
+			f, err := p.parseField(tok)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%v in message %v", err, msg.name)
+			}
+			msg.fields = append(msg.fields, f)
+			all = append(all, f)
+		}
+	}
+}
+
+// parseField 解析 [repeated|optional] type name = number [options];
+func (p *protoParser) parseField(tok string) (*protoField, error) {
+	f := &protoField{}
+	switch tok {
+	case "repeated":
+		f.repeated = true
+		tok = p.next()
+	case "optional", "required":
+		tok = p.next()
+	}
+	if tok == "map" {
+		f.isMap = true
+		if err := p.expect("<"); err != nil {
+			return nil, err
+		}
+		f.keyType = p.next()
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+		f.valueType = p.next()
+		if err := p.expect(">"); err != nil {
+			return nil, err
+		}
+	} else {
+		f.typeName = tok
+	}
+	f.name = p.next()
+	if err := p.expect("="); err != nil {
+		return nil, err
+	}
+	number, err := strconv.Atoi(p.next())
+	if err != nil || number <= 0 {
+		return nil, fmt.Errorf("protobuf descriptor: field %v has invalid number", f.name)
+	}
+	f.number = number
+	if p.peek() == "[" {
+		for !p.eof() && p.next() != "]" {
+		}
+	}
+	if err := p.expect(";"); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (p *protoParser) parseEnum() error {
+	name := p.next()
+	if err := p.expect("{"); err != nil {
+		return err
+	}
+	values := make(map[string]int64)
+	for {
+		tok := p.next()
+		switch tok {
+		case "":
+			return fmt.Errorf("protobuf descriptor: enum %v is not closed", name)
+		case "}":
+			p.enums[name] = values
+			return nil
+		case ";":
+		case "option", "reserved":
+			p.skipStatement()
+		default:
+			if err := p.expect("="); err != nil {
+				return err
+			}
+			v, err := strconv.ParseInt(p.next(), 0, 64)
+			if err != nil {
+				return fmt.Errorf("protobuf descriptor: enum %v value %v is invalid", name, tok)
+			}
+			values[tok] = v
+			if p.peek() == "[" {
+				for !p.eof() && p.next() != "]" {
+				}
+			}
+		}
+	}
+}
+
+// resolve 将字段的类型名称解析为标量、enum 或 message
+func (p *protoParser) resolve(f *protoField) error {
+	typeName := f.typeName
+	if f.isMap {
+		if !protoScalarTypes[f.keyType] || f.keyType == "double" || f.keyType == "float" || f.keyType == "bytes" {
+			return fmt.Errorf("protobuf descriptor: map key type %v of field %v is not supported", f.keyType, f.name)
+		}
+		typeName = f.valueType
+	}
+	if protoScalarTypes[typeName] {
+		return nil
+	}
+	name := lastProtoName(typeName)
+	if msg, ok := p.messages[name]; ok {
+		f.message = msg
+		return nil
+	}
+	if enum, ok := p.enums[name]; ok {
+		f.enum = enum
+		if f.isMap {
+			f.valueType = "enum"
+		} else {
+			f.typeName = "enum"
+		}
+		return nil
+	}
+	return fmt.Errorf("protobuf descriptor: type %v of field %v is not defined", typeName, f.name)
+}
+
+func lastProtoName(name string) string {
+	if idx := strings.LastIndex(name, "."); idx >= 0 {
+		return name[idx+1:]
+	}
+	return name
+}
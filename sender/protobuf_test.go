@@ -0,0 +1,223 @@
+package sender
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/qiniu/logkit/conf"
+	. "github.com/qiniu/logkit/sender/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const testProtoDescriptor = `
+syntax = "proto3";
+package logkit.test;
+
+// 测试用的日志定义
+message Log {
+  string host = 1;
+  int64 ts = 2 [deprecated = true];
+  double cost = 3;
+  bool ok = 4;
+  Level level = 5;
+  repeated int32 codes = 6;
+  repeated string tags = 7;
+  Request req = 8;
+  map<string, string> labels = 9;
+  sint64 delta = 10;
+  fixed32 id = 11;
+  oneof extra {
+    string note = 12;
+  }
+
+  message Request {
+    string method = 1;
+    uint32 status = 2;
+  }
+}
+
+enum Level {
+  DEBUG = 0;
+  INFO = 1;
+  WARN = 2;
+}
+`
+
+type testLogRequest struct {
+	Method string `protobuf:"bytes,1,opt,name=method,proto3" json:"method,omitempty"`
+	Status uint32 `protobuf:"varint,2,opt,name=status,proto3" json:"status,omitempty"`
+}
+
+func (m *testLogRequest) Reset()         { *m = testLogRequest{} }
+func (m *testLogRequest) String() string { return proto.CompactTextString(m) }
+func (*testLogRequest) ProtoMessage()    {}
+
+type testLog struct {
+	Host   string            `protobuf:"bytes,1,opt,name=host,proto3" json:"host,omitempty"`
+	Ts     int64             `protobuf:"varint,2,opt,name=ts,proto3" json:"ts,omitempty"`
+	Cost   float64           `protobuf:"fixed64,3,opt,name=cost,proto3" json:"cost,omitempty"`
+	Ok     bool              `protobuf:"varint,4,opt,name=ok,proto3" json:"ok,omitempty"`
+	Level  int32             `protobuf:"varint,5,opt,name=level,proto3" json:"level,omitempty"`
+	Codes  []int32           `protobuf:"varint,6,rep,packed,name=codes" json:"codes,omitempty"`
+	Tags   []string          `protobuf:"bytes,7,rep,name=tags" json:"tags,omitempty"`
+	Req    *testLogRequest   `protobuf:"bytes,8,opt,name=req" json:"req,omitempty"`
+	Labels map[string]string `protobuf:"bytes,9,rep,name=labels" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	Delta  int64             `protobuf:"zigzag64,10,opt,name=delta,proto3" json:"delta,omitempty"`
+	Id     uint32            `protobuf:"fixed32,11,opt,name=id,proto3" json:"id,omitempty"`
+	Note   string            `protobuf:"bytes,12,opt,name=note,proto3" json:"note,omitempty"`
+}
+
+func (m *testLog) Reset()         { *m = testLog{} }
+func (m *testLog) String() string { return proto.CompactTextString(m) }
+func (*testLog) ProtoMessage()    {}
+
+func TestProtoSchemaMarshal(t *testing.T) {
+	schema, err := ParseProtoSchema(testProtoDescriptor, "", "host=hostname,ts=timestamp")
+	require.NoError(t, err)
+	data := Data{
+		"hostname":  "web-1",
+		"timestamp": int64(1538000000),
+		"cost":      "0.25",
+		"ok":        true,
+		"level":     "WARN",
+		"codes":     []interface{}{200, int64(-1), "404"},
+		"tags":      []string{"a", "b"},
+		"req":       map[string]interface{}{"method": "GET", "status": 200},
+		"labels":    map[string]interface{}{"zone": "nb", "app": "logkit"},
+		"delta":     -5,
+		"id":        7.0,
+		"note":      "hello",
+		"unknown":   "ignored",
+	}
+	b, err := schema.Marshal(data)
+	require.NoError(t, err)
+	var got testLog
+	require.NoError(t, proto.Unmarshal(b, &got))
+	assert.Equal(t, testLog{
+		Host:   "web-1",
+		Ts:     1538000000,
+		Cost:   0.25,
+		Ok:     true,
+		Level:  2,
+		Codes:  []int32{200, -1, 404},
+		Tags:   []string{"a", "b"},
+		Req:    &testLogRequest{Method: "GET", Status: 200},
+		Labels: map[string]string{"zone": "nb", "app": "logkit"},
+		Delta:  -5,
+		Id:     7,
+		Note:   "hello",
+	}, got)
+
+	// 相同数据的序列化结果一致
+	b2, err := schema.Marshal(data)
+	require.NoError(t, err)
+	assert.Equal(t, b, b2)
+
+	// 没有的字段不输出
+	b, err = schema.Marshal(Data{"hostname": "web-2"})
+	require.NoError(t, err)
+	got = testLog{}
+	require.NoError(t, proto.Unmarshal(b, &got))
+	assert.Equal(t, testLog{Host: "web-2"}, got)
+
+	// 配置了映射的字段不再读取同名的数据字段
+	_, err = schema.Marshal(Data{"ts": "abc"})
+	assert.NoError(t, err)
+	_, err = schema.Marshal(Data{"timestamp": "abc"})
+	assert.Error(t, err)
+	_, err = schema.Marshal(Data{"req": 1})
+	assert.Error(t, err)
+}
+
+func TestParseProtoSchema(t *testing.T) {
+	schema, err := ParseProtoSchema(testProtoDescriptor, "logkit.test.Log.Request", "")
+	require.NoError(t, err)
+	b, err := schema.Marshal(Data{"method": "POST", "status": "500"})
+	require.NoError(t, err)
+	var got testLogRequest
+	require.NoError(t, proto.Unmarshal(b, &got))
+	assert.Equal(t, testLogRequest{Method: "POST", Status: 500}, got)
+
+	tests := []struct {
+		descriptor string
+		message    string
+		fieldMap   string
+	}{
+		{descriptor: `syntax = "proto3";`},
+		{descriptor: `message A { string a = 1; }`, message: "B"},
+		{descriptor: `message A { Unknown a = 1; }`},
+		{descriptor: `message A { string a = 1 }`},
+		{descriptor: `message A { string a = 1; }`, fieldMap: "a"},
+	}
+	for _, test := range tests {
+		_, err := ParseProtoSchema(test.descriptor, test.message, test.fieldMap)
+		assert.Error(t, err, test.descriptor)
+	}
+
+	s1, err := ParseProtoSchema(`message A { string a = 1; }`, "", "")
+	require.NoError(t, err)
+	s2, err := ParseProtoSchema(`message A { string a = 1; }`, "", "a=b")
+	require.NoError(t, err)
+	assert.NotEqual(t, s1.format, s2.format)
+}
+
+func TestNewProtoSchemaFromConf(t *testing.T) {
+	_, err := NewProtoSchemaFromConf(conf.MapConf{})
+	assert.Error(t, err)
+
+	dir, err := ioutil.TempDir("", "TestNewProtoSchemaFromConf")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "log.proto")
+	require.NoError(t, ioutil.WriteFile(path, []byte(testProtoDescriptor), 0644))
+
+	schema, err := NewProtoSchemaFromConf(conf.MapConf{
+		KeyProtobufDescriptor: path,
+		KeyProtobufMessage:    "Request",
+	})
+	require.NoError(t, err)
+	b, err := schema.Marshal(Data{"method": "GET"})
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x0a, 3, 'G', 'E', 'T'}, b)
+
+	_, err = NewProtoSchemaFromConf(conf.MapConf{KeyProtobufDescriptor: filepath.Join(dir, "not_exist.proto")})
+	assert.Error(t, err)
+}
+
+func TestAppendProtoDelimited(t *testing.T) {
+	var buf []byte
+	buf = AppendProtoDelimited(buf, []byte("abc"))
+	buf = AppendProtoDelimited(buf, make([]byte, 200))
+	l, n := binary.Uvarint(buf)
+	assert.Equal(t, uint64(3), l)
+	assert.Equal(t, "abc", string(buf[n:n+3]))
+	buf = buf[n+3:]
+	l, n = binary.Uvarint(buf)
+	assert.Equal(t, uint64(200), l)
+	assert.Equal(t, 2, n)
+	assert.Len(t, buf[n:], 200)
+}
+
+func TestEncodeCacheProtobuf(t *testing.T) {
+	schema, err := ParseProtoSchema(`message A { string a = 1; }`, "", "")
+	require.NoError(t, err)
+	cache := NewEncodeCache()
+	data := Data{"a": "x"}
+	b1, err := cache.EncodeProtobuf(data, schema)
+	require.NoError(t, err)
+	b2, err := cache.EncodeProtobuf(data, schema)
+	require.NoError(t, err)
+	assert.Equal(t, b1, b2)
+	_, err = cache.EncodeMsgpack(data)
+	require.NoError(t, err)
+	hits, misses := cache.Stats()
+	assert.Equal(t, int64(1), hits)
+	assert.Equal(t, int64(2), misses)
+}
@@ -0,0 +1,164 @@
+package sender
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const minutesPerDay = 24 * 60
+
+// rateWindow 一天中的一个时间段及该时间段的限速，单位 byte/s，0 表示不限速
+type rateWindow struct {
+	start int // 距离 00:00 的分钟数
+	end   int
+	limit int64
+}
+
+func (w rateWindow) contains(minute int) bool {
+	if w.start <= w.end {
+		return minute >= w.start && minute < w.end
+	}
+	// 跨越零点的时间段，如 22:00-06:00
+	return minute >= w.start || minute < w.end
+}
+
+// RateSchedule 按时间段设置发送限速，未命中任何时间段时使用默认限速
+type RateSchedule struct {
+	windows      []rateWindow
+	defaultLimit int64
+
+	mux  sync.Mutex
+	next time.Time
+}
+
+// ParseRateSchedule 解析形如 "00:00-06:00=0,18:00-22:00=5120" 的配置，限速单位为 KB/s，0 表示不限速
+func ParseRateSchedule(schedule string, defaultLimitKB int64) (*RateSchedule, error) {
+	if defaultLimitKB < 0 {
+		return nil, fmt.Errorf("default rate limit %d should not be negative", defaultLimitKB)
+	}
+	rs := &RateSchedule{defaultLimit: defaultLimitKB * KB}
+	for _, item := range strings.Split(schedule, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		idx := strings.LastIndex(item, "=")
+		if idx < 0 {
+			return nil, fmt.Errorf("rate schedule %q is invalid, format should be HH:MM-HH:MM=limit", item)
+		}
+		period := strings.Split(item[:idx], "-")
+		if len(period) != 2 {
+			return nil, fmt.Errorf("rate schedule %q is invalid, format should be HH:MM-HH:MM=limit", item)
+		}
+		start, err := parseClock(period[0])
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseClock(period[1])
+		if err != nil {
+			return nil, err
+		}
+		if start == end {
+			return nil, fmt.Errorf("rate schedule %q is invalid, start is equal to end", item)
+		}
+		limit, err := strconv.ParseInt(strings.TrimSpace(item[idx+1:]), 10, 64)
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("rate schedule %q limit is invalid", item)
+		}
+		rs.windows = append(rs.windows, rateWindow{start: start, end: end, limit: limit * KB})
+	}
+	return rs, nil
+}
+
+func parseClock(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("time %q is invalid, format should be HH:MM", s)
+	}
+	hour, err := strconv.Atoi(parts[0])
+	if err != nil || hour < 0 || hour > 24 {
+		return 0, fmt.Errorf("time %q is invalid, format should be HH:MM", s)
+	}
+	minute, err := strconv.Atoi(parts[1])
+	if err != nil || minute < 0 || minute > 59 || (hour == 24 && minute != 0) {
+		return 0, fmt.Errorf("time %q is invalid, format should be HH:MM", s)
+	}
+	return (hour*60 + minute) % minutesPerDay, nil
+}
+
+// LimitAt 返回 t 时刻的限速，单位 byte/s，先配置的时间段优先
+func (rs *RateSchedule) LimitAt(t time.Time) int64 {
+	minute := t.Hour()*60 + t.Minute()
+	for _, w := range rs.windows {
+		if w.contains(minute) {
+			return w.limit
+		}
+	}
+	return rs.defaultLimit
+}
+
+// reserve 预留 size 字节的发送额度，返回需要等待的时间
+func (rs *RateSchedule) reserve(size int64, now time.Time) time.Duration {
+	limit := rs.LimitAt(now)
+	rs.mux.Lock()
+	defer rs.mux.Unlock()
+	if limit <= 0 {
+		rs.next = time.Time{}
+		return 0
+	}
+	if rs.next.Before(now) {
+		rs.next = now
+	}
+	wait := rs.next.Sub(now)
+	rs.next = rs.next.Add(time.Duration(float64(size) / float64(limit) * float64(time.Second)))
+	return wait
+}
+
+// Wait 按当前时间段的限速等待，stopped 返回 true 时立即返回，避免关闭 sender 时长时间阻塞
+func (rs *RateSchedule) Wait(size int64, stopped func() bool) {
+	wait := rs.reserve(size, time.Now())
+	for wait > 0 && !stopped() {
+		sleep := wait
+		if sleep > time.Second {
+			sleep = time.Second
+		}
+		time.Sleep(sleep)
+		wait -= sleep
+	}
+}
+
+// estimateSize 估算数据发送时的大小，用于限速
+func estimateSize(v interface{}) int64 {
+	switch val := v.(type) {
+	case nil:
+		return 0
+	case string:
+		return int64(len(val))
+	case []byte:
+		return int64(len(val))
+	case Data:
+		return estimateSize(map[string]interface{}(val))
+	case map[string]interface{}:
+		var size int64
+		for k, v := range val {
+			size += int64(len(k)) + estimateSize(v)
+		}
+		return size
+	case []interface{}:
+		var size int64
+		for _, v := range val {
+			size += estimateSize(v)
+		}
+		return size
+	case bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return 8
+	default:
+		return int64(len(fmt.Sprint(val)))
+	}
+}
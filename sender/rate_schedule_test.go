@@ -0,0 +1,35 @@
+package sender
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+func TestRateSchedule(t *testing.T) {
+	for _, schedule := range []string{"00:00-06:00", "00:00=1", "25:00-06:00=1", "06:00-06:00=1", "00:00-06:00=-1"} {
+		_, err := ParseRateSchedule(schedule, 0)
+		assert.Error(t, err, schedule)
+	}
+
+	rs, err := ParseRateSchedule("00:00-06:00=0, 22:00-24:00=0, 23:00-01:00=1, 12:00-13:00=100", 10240)
+	assert.NoError(t, err)
+	day := time.Date(2018, 6, 1, 0, 0, 0, 0, time.Local)
+	assert.EqualValues(t, 0, rs.LimitAt(day.Add(3*time.Hour)))
+	assert.EqualValues(t, 0, rs.LimitAt(day.Add(23*time.Hour)))
+	assert.EqualValues(t, 100*KB, rs.LimitAt(day.Add(12*time.Hour+30*time.Minute)))
+	assert.EqualValues(t, 10240*KB, rs.LimitAt(day.Add(6*time.Hour)))
+
+	now := day.Add(12 * time.Hour)
+	assert.EqualValues(t, 0, rs.reserve(200*KB, now))
+	assert.Equal(t, 2*time.Second, rs.reserve(100*KB, now))
+	assert.Equal(t, time.Second, rs.reserve(100*KB, now.Add(2*time.Second)))
+	// 不限速的时间段不需要等待，并清空之前的预留
+	assert.EqualValues(t, 0, rs.reserve(100*MB, day.Add(time.Hour)))
+	assert.EqualValues(t, 0, rs.reserve(100*KB, now))
+
+	assert.EqualValues(t, 17, estimateSize(Data{"a": "bc", "d": 1, "e": map[string]interface{}{"f": "g"}, "h": []interface{}{"i"}}))
+}
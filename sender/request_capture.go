@@ -0,0 +1,241 @@
+package sender
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+// MaxCapturedRequests 每个 sender 最多记录的请求数，请求体会完整保存用于重放，因此不宜过多
+const MaxCapturedRequests = 100
+
+// maxCapturedResponse 记录的响应体的最大长度
+const maxCapturedResponse = 4096
+
+const redactedValue = "******"
+
+// RequestCaptureSender 表示 sender 可以记录最近发送的完整请求，并按需重放，用于排查目的端拒绝数据的原因
+type RequestCaptureSender interface {
+	// CapturedRequests 按从新到旧的顺序返回记录的请求，未开启记录时返回 nil
+	CapturedRequests() []CapturedRequest
+	// ReplayRequest 重新发送记录的请求，重放的结果也会被记录
+	ReplayRequest(id int64) (*CapturedRequest, error)
+}
+
+// RequestPreviewSender 表示 sender 可以在不发送的情况下生成数据对应的请求，用于预览数据模板的渲染结果
+type RequestPreviewSender interface {
+	PreviewRequest(datas []Data) (*CapturedRequest, error)
+}
+
+// CapturedRequest 记录的请求以及目的端的响应，URL 和 Header 中的敏感信息已隐藏，
+// 请求体可以作为文本显示时放在 Body 中，否则以 base64 编码放在 BodyBase64 中，gzip 压缩的请求体显示的是解压后的内容
+type CapturedRequest struct {
+	ID         int64       `json:"id"`
+	Time       time.Time   `json:"time"`
+	Method     string      `json:"method"`
+	URL        string      `json:"url"`
+	Header     http.Header `json:"header"`
+	Body       string      `json:"body,omitempty"`
+	BodyBase64 string      `json:"body_base64,omitempty"`
+	BodySize   int         `json:"body_size"`
+	StatusCode int         `json:"status_code,omitempty"`
+	Response   string      `json:"response,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	LatencyMs  int64       `json:"latency_ms"`
+	// ReplayOf 不为 0 时表示该请求是重放 ReplayOf 的结果
+	ReplayOf int64 `json:"replay_of,omitempty"`
+}
+
+type capturedEntry struct {
+	info   CapturedRequest
+	method string
+	url    string
+	header http.Header
+	body   []byte
+}
+
+// RequestCapture 以环形缓冲的方式保存最近的 size 个请求
+type RequestCapture struct {
+	mu      sync.Mutex
+	entries []*capturedEntry
+	next    int
+	lastID  int64
+}
+
+// NewRequestCapture 创建最多保存 size 个请求的 RequestCapture，size 超过 MaxCapturedRequests 时按 MaxCapturedRequests 处理
+func NewRequestCapture(size int) *RequestCapture {
+	if size > MaxCapturedRequests {
+		size = MaxCapturedRequests
+	}
+	if size <= 0 {
+		return nil
+	}
+	return &RequestCapture{entries: make([]*capturedEntry, size)}
+}
+
+// Add 记录已发送的请求，body 为实际发送的请求体，resp 和 err 为发送的结果，返回记录的 id
+func (c *RequestCapture) Add(req *http.Request, body []byte, resp *http.Response, respBody []byte, err error, latency time.Duration, replayOf int64) int64 {
+	if c == nil {
+		return 0
+	}
+	e := &capturedEntry{
+		method: req.Method,
+		url:    req.URL.String(),
+		header: cloneHeader(req.Header),
+		body:   body,
+	}
+	e.info = CapturedRequest{
+		Time:      time.Now(),
+		Method:    req.Method,
+		URL:       RedactURL(req.URL.String()),
+		Header:    RedactHeader(req.Header),
+		BodySize:  len(body),
+		LatencyMs: int64(latency / time.Millisecond),
+		ReplayOf:  replayOf,
+	}
+	if resp != nil {
+		e.info.StatusCode = resp.StatusCode
+		if len(respBody) > maxCapturedResponse {
+			respBody = respBody[:maxCapturedResponse]
+		}
+		e.info.Response = string(respBody)
+	}
+	if err != nil {
+		e.info.Error = err.Error()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastID++
+	e.info.ID = c.lastID
+	c.entries[c.next] = e
+	c.next = (c.next + 1) % len(c.entries)
+	return e.info.ID
+}
+
+// List 按从新到旧的顺序返回记录的请求
+func (c *RequestCapture) List() []CapturedRequest {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	entries := make([]*capturedEntry, 0, len(c.entries))
+	for i := 1; i <= len(c.entries); i++ {
+		if e := c.entries[(c.next-i+len(c.entries))%len(c.entries)]; e != nil {
+			entries = append(entries, e)
+		}
+	}
+	c.mu.Unlock()
+
+	requests := make([]CapturedRequest, len(entries))
+	for i, e := range entries {
+		requests[i] = e.info
+		requests[i].Body, requests[i].BodyBase64 = displayBody(e.header, e.body)
+	}
+	return requests
+}
+
+// Get 返回 id 对应的请求，可以用来重放，请求已经被覆盖时返回 nil
+func (c *RequestCapture) Get(id int64) *http.Request {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, e := range c.entries {
+		if e == nil || e.info.ID != id {
+			continue
+		}
+		req, err := http.NewRequest(e.method, e.url, bytes.NewReader(e.body))
+		if err != nil {
+			return nil
+		}
+		req.Header = cloneHeader(e.header)
+		return req
+	}
+	return nil
+}
+
+func displayBody(header http.Header, body []byte) (string, string) {
+	if header.Get("Content-Encoding") == "gzip" {
+		if r, err := gzip.NewReader(bytes.NewReader(body)); err == nil {
+			if unzipped, err := ioutil.ReadAll(r); err == nil {
+				body = unzipped
+			}
+		}
+	}
+	if utf8.Valid(body) {
+		return string(body), ""
+	}
+	return "", base64.StdEncoding.EncodeToString(body)
+}
+
+func cloneHeader(h http.Header) http.Header {
+	clone := make(http.Header, len(h))
+	for k, v := range h {
+		clone[k] = append([]string(nil), v...)
+	}
+	return clone
+}
+
+// isSensitiveName 判断 header 或者 query 参数的名称是否可能包含密钥
+func isSensitiveName(name string) bool {
+	name = strings.ToLower(name)
+	switch name {
+	case "authorization", "proxy-authorization", "cookie", "set-cookie":
+		return true
+	}
+	for _, s := range []string{"token", "secret", "password", "passwd", "signature", "key", "credential"} {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactHeader 返回隐藏了敏感信息的 header 副本
+func RedactHeader(h http.Header) http.Header {
+	redacted := cloneHeader(h)
+	for k, v := range redacted {
+		if isSensitiveName(k) {
+			for i := range v {
+				v[i] = redactedValue
+			}
+		}
+	}
+	return redacted
+}
+
+// RedactURL 隐藏 URL 中的密码以及可能包含密钥的 query 参数
+func RedactURL(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return rawurl
+	}
+	if u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), redactedValue)
+		}
+	}
+	if u.RawQuery != "" {
+		query := u.Query()
+		for k, v := range query {
+			if isSensitiveName(k) {
+				for i := range v {
+					v[i] = redactedValue
+				}
+			}
+		}
+		u.RawQuery = query.Encode()
+	}
+	return u.String()
+}
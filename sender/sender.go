@@ -3,6 +3,7 @@ package sender
 import (
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/qiniu/logkit/conf"
 	. "github.com/qiniu/logkit/sender/config"
@@ -22,6 +23,34 @@ type RawSender interface {
 	RawSend([]string) error
 }
 
+// BatchSender 能够直接发送按列存储的 Batch，与 BatchParser 配合使用可以省去转换为 Data 的开销
+type BatchSender interface {
+	SendBatch(*Batch) error
+}
+
+// QueueSender 表示 sender 内部有等待发送的数据队列，QueueDepth 返回队列中等待发送的数据批数
+type QueueSender interface {
+	QueueDepth() int64
+}
+
+// QueueBytesSender 表示 sender 内部的数据队列保存在磁盘上，QueueBytes 返回队列占用的磁盘字节数
+type QueueBytesSender interface {
+	QueueBytes() int64
+}
+
+// ProcsSender 表示 sender 可以在运行时增加发送队列中数据的并发数
+type ProcsSender interface {
+	Procs() int
+	// SetProcs 将发送并发数增加到 procs，不会减少，返回调整后的并发数
+	SetProcs(procs int) int
+}
+
+// FlushSender 表示 sender 内部缓存了等待发送的数据，Flush 立即发送这些数据，
+// 在数据全部发送完成、发送失败或者超过 timeout 时返回
+type FlushSender interface {
+	Flush(timeout time.Duration) error
+}
+
 // SkipDeepCopySender 表示该 sender 不会对传入数据进行污染，凡是有次保证的 sender 需要实现该接口提升发送效率
 type SkipDeepCopySender interface {
 	// SkipDeepCopy 需要返回值是因为如果一个 sender 封装了其它 sender，需要根据实际封装的类型返回是否忽略深度拷贝
@@ -83,7 +112,11 @@ func (r *Registry) NewSender(conf conf.MapConf, ftSaveLogPath string) (sender Se
 	if !exist {
 		return nil, fmt.Errorf("sender type unsupported : %v", sendType)
 	}
-	sender, err = constructor(conf)
+	if endpoints, _ := conf.GetStringOr(KeyShardEndpoints, ""); endpoints != "" {
+		sender, err = NewShardSender(conf, constructor)
+	} else {
+		sender, err = constructor(conf)
+	}
 	if err != nil {
 		return
 	}
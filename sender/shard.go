@@ -0,0 +1,302 @@
+package sender
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/qiniu/log"
+	"github.com/qiniu/pandora-go-sdk/base/reqerr"
+
+	"github.com/qiniu/logkit/conf"
+	. "github.com/qiniu/logkit/sender/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const defaultShardUnhealthyInterval = 30 * time.Second
+
+var _ SkipDeepCopySender = &ShardSender{}
+var _ FlushSender = &ShardSender{}
+
+// ShardSender 将数据分发到多个等价的下游，发送失败的下游在 unhealthyInterval 内被排除，其数据交给其他健康的下游发送
+type ShardSender struct {
+	name              string
+	runnerName        string
+	shards            []*shard
+	strategy          string
+	field             string
+	unhealthyInterval time.Duration
+	next              uint32 // round_robin 下一次使用的下游
+}
+
+type shard struct {
+	sender Sender
+
+	mux            sync.Mutex
+	unhealthyUntil time.Time
+}
+
+func (s *shard) healthy(now time.Time) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return !now.Before(s.unhealthyUntil)
+}
+
+// setHealthy 设置下游的健康状态，返回状态是否发生了变化
+func (s *shard) setHealthy(healthy bool, until time.Time) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	wasHealthy := s.unhealthyUntil.IsZero()
+	if healthy {
+		s.unhealthyUntil = time.Time{}
+	} else {
+		s.unhealthyUntil = until
+	}
+	return wasHealthy != healthy
+}
+
+// NewShardSender 按照 shard_endpoints 中每一项覆盖 conf 后使用 constructor 创建各个下游
+func NewShardSender(c conf.MapConf, constructor Constructor) (*ShardSender, error) {
+	endpoints, err := c.GetString(KeyShardEndpoints)
+	if err != nil {
+		return nil, err
+	}
+	var overrides []map[string]string
+	if err = json.Unmarshal([]byte(endpoints), &overrides); err != nil {
+		return nil, fmt.Errorf("%v should be a json array of config objects: %v", KeyShardEndpoints, err)
+	}
+	if len(overrides) == 0 {
+		return nil, fmt.Errorf("%v should not be empty", KeyShardEndpoints)
+	}
+	strategy, _ := c.GetStringOr(KeyShardStrategy, ShardStrategyRoundRobin)
+	switch strategy {
+	case ShardStrategyRoundRobin, ShardStrategyHash:
+	default:
+		return nil, fmt.Errorf("%v %q is not supported", KeyShardStrategy, strategy)
+	}
+	field, _ := c.GetStringOr(KeyShardField, "")
+	interval, _ := c.GetStringOr(KeyShardUnhealthyInterval, "")
+	unhealthyInterval := defaultShardUnhealthyInterval
+	if interval != "" {
+		if unhealthyInterval, err = time.ParseDuration(interval); err != nil {
+			return nil, fmt.Errorf("parse %v %q error: %v", KeyShardUnhealthyInterval, interval, err)
+		}
+	}
+	runnerName, _ := c.GetStringOr(KeyRunnerName, UnderfinedRunnerName)
+
+	s := &ShardSender{
+		runnerName:        runnerName,
+		strategy:          strategy,
+		field:             field,
+		unhealthyInterval: unhealthyInterval,
+	}
+	names := make([]string, 0, len(overrides))
+	for i, override := range overrides {
+		shardConf := conf.MapConf{}
+		for k, v := range c {
+			switch k {
+			case KeyShardEndpoints, KeyShardStrategy, KeyShardField, KeyShardUnhealthyInterval:
+				continue
+			}
+			shardConf[k] = v
+		}
+		for k, v := range override {
+			shardConf[k] = v
+		}
+		inner, err := constructor(shardConf)
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("create shard %d sender error: %v", i, err)
+		}
+		s.shards = append(s.shards, &shard{sender: inner})
+		names = append(names, inner.Name())
+	}
+	s.name = "shard(" + strings.Join(names, ",") + ")"
+	return s, nil
+}
+
+func (s *ShardSender) Name() string {
+	return s.name
+}
+
+// Send 按策略将数据分配到各个下游并发发送，失败的数据依次尝试其他健康的下游，仍然失败的数据通过 StatsError 返回
+func (s *ShardSender) Send(datas []Data) error {
+	if len(datas) == 0 {
+		return nil
+	}
+	groups := s.split(datas, time.Now())
+	var (
+		wg        sync.WaitGroup
+		mux       sync.Mutex
+		failed    []Data
+		lastErr   error
+		errorType = reqerr.TypeDefault
+	)
+	for idx, group := range groups {
+		wg.Add(1)
+		go func(idx int, group []Data) {
+			defer wg.Done()
+			remain, err := s.sendTo(idx, group)
+			if err == nil {
+				return
+			}
+			mux.Lock()
+			defer mux.Unlock()
+			failed = append(failed, remain...)
+			lastErr = err
+			if se, ok := err.(*StatsError); ok && se.SendError != nil && se.SendError.ErrorType != reqerr.TypeDefault {
+				errorType = se.SendError.ErrorType
+			}
+		}(idx, group)
+	}
+	wg.Wait()
+	if lastErr == nil {
+		return nil
+	}
+	return &StatsError{
+		StatsInfo: StatsInfo{
+			Success:   int64(len(datas) - len(failed)),
+			Errors:    int64(len(failed)),
+			LastError: lastErr.Error(),
+		},
+		SendError: reqerr.NewSendError(
+			fmt.Sprintf("Sender[%v]: %d of %d datas failed, last error: %v", s.name, len(failed), len(datas), lastErr),
+			ConvertDatasBack(failed),
+			errorType,
+		),
+	}
+}
+
+// split 返回每个下游需要发送的数据
+func (s *ShardSender) split(datas []Data, now time.Time) map[int][]Data {
+	healthy := make([]int, 0, len(s.shards))
+	for i, sd := range s.shards {
+		if sd.healthy(now) {
+			healthy = append(healthy, i)
+		}
+	}
+	if s.strategy == ShardStrategyRoundRobin {
+		start := int(atomic.AddUint32(&s.next, 1)-1) % len(s.shards)
+		for i := 0; i < len(s.shards); i++ {
+			idx := (start + i) % len(s.shards)
+			if s.shards[idx].healthy(now) {
+				return map[int][]Data{idx: datas}
+			}
+		}
+		// 所有下游都不健康时仍然按顺序尝试，避免数据无处可发
+		return map[int][]Data{start: datas}
+	}
+
+	groups := make(map[int][]Data)
+	for _, data := range datas {
+		h := s.hash(data)
+		idx := int(h % uint32(len(s.shards)))
+		if !s.shards[idx].healthy(now) && len(healthy) > 0 {
+			idx = healthy[h%uint32(len(healthy))]
+		}
+		groups[idx] = append(groups[idx], data)
+	}
+	return groups
+}
+
+func (s *ShardSender) hash(data Data) uint32 {
+	h := fnv.New32a()
+	if s.field == "" {
+		b, _ := json.Marshal(data)
+		h.Write(b)
+	} else {
+		fmt.Fprint(h, data[s.field])
+	}
+	return h.Sum32()
+}
+
+// sendTo 将数据发送到第 idx 个下游，失败时依次交给其他健康的下游，返回最终没有发送成功的数据
+func (s *ShardSender) sendTo(idx int, datas []Data) ([]Data, error) {
+	tried := make(map[int]bool, len(s.shards))
+	for {
+		tried[idx] = true
+		sd := s.shards[idx]
+		err := sd.sender.Send(datas)
+		if err == nil {
+			if sd.setHealthy(true, time.Time{}) {
+				log.Infof("Runner[%v] Sender[%v] shard %v recovered", s.runnerName, s.name, sd.sender.Name())
+			}
+			return nil, nil
+		}
+		se, ok := err.(*StatsError)
+		if ok && se.SendError != nil {
+			datas = ConvertDatas(se.SendError.GetFailDatas())
+			// 数据本身的问题交给 ft sender 处理，换一个下游也无法解决
+			if se.SendError.ErrorType != reqerr.TypeDefault {
+				return datas, err
+			}
+			if len(datas) == 0 {
+				return nil, nil
+			}
+		}
+		now := time.Now()
+		if sd.setHealthy(false, now.Add(s.unhealthyInterval)) {
+			log.Warnf("Runner[%v] Sender[%v] shard %v is excluded for %v: %v", s.runnerName, s.name, sd.sender.Name(), s.unhealthyInterval, err)
+		}
+		next := -1
+		for i := 1; i < len(s.shards); i++ {
+			candidate := (idx + i) % len(s.shards)
+			if !tried[candidate] && s.shards[candidate].healthy(now) {
+				next = candidate
+				break
+			}
+		}
+		if next < 0 {
+			return datas, err
+		}
+		idx = next
+	}
+}
+
+// SkipDeepCopy 只有所有下游都不会修改数据时才能跳过深拷贝
+func (s *ShardSender) SkipDeepCopy() bool {
+	for _, sd := range s.shards {
+		ss, ok := sd.sender.(SkipDeepCopySender)
+		if !ok || !ss.SkipDeepCopy() {
+			return false
+		}
+	}
+	return true
+}
+
+// Flush 依次 flush 所有支持 flush 的下游
+func (s *ShardSender) Flush(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	var errs []string
+	for _, sd := range s.shards {
+		fs, ok := sd.sender.(FlushSender)
+		if !ok {
+			continue
+		}
+		if err := fs.Flush(time.Until(deadline)); err != nil {
+			errs = append(errs, sd.sender.Name()+": "+err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (s *ShardSender) Close() error {
+	var errs []string
+	for _, sd := range s.shards {
+		if err := sd.sender.Close(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package sender
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/qiniu/logkit/conf"
+	. "github.com/qiniu/logkit/sender/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+type shardTestSender struct {
+	name string
+	fail bool
+
+	mux   sync.Mutex
+	datas []Data
+}
+
+func (s *shardTestSender) Name() string { return s.name }
+
+func (s *shardTestSender) Send(datas []Data) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.fail {
+		return errors.New(s.name + " is down")
+	}
+	s.datas = append(s.datas, datas...)
+	return nil
+}
+
+func (s *shardTestSender) Close() error { return nil }
+
+func (s *shardTestSender) count() int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return len(s.datas)
+}
+
+func newShardTestSender(t *testing.T, c conf.MapConf) (*ShardSender, map[string]*shardTestSender) {
+	inners := make(map[string]*shardTestSender)
+	s, err := NewShardSender(c, func(c conf.MapConf) (Sender, error) {
+		assert.Empty(t, c[KeyShardEndpoints])
+		name, _ := c.GetString("test_host")
+		inner := &shardTestSender{name: name}
+		inners[name] = inner
+		return inner, nil
+	})
+	require.NoError(t, err)
+	return s, inners
+}
+
+func TestShardSenderRoundRobin(t *testing.T) {
+	s, inners := newShardTestSender(t, conf.MapConf{
+		KeyShardEndpoints: `[{"test_host":"a"},{"test_host":"b"},{"test_host":"c"}]`,
+	})
+	assert.Equal(t, "shard(a,b,c)", s.Name())
+	for i := 0; i < 6; i++ {
+		assert.NoError(t, s.Send([]Data{{"i": i}}))
+	}
+	for _, inner := range inners {
+		assert.Equal(t, 2, inner.count())
+	}
+
+	// b 失败后数据交给 c，且在排除时长内不再分发给 b
+	inners["b"].fail = true
+	for i := 0; i < 6; i++ {
+		assert.NoError(t, s.Send([]Data{{"i": i}}))
+	}
+	assert.Equal(t, 2, inners["b"].count())
+	assert.Equal(t, 10, inners["a"].count()+inners["c"].count())
+	assert.False(t, s.shards[1].healthy(time.Now()))
+
+	// 所有下游都失败时返回所有数据
+	inners["a"].fail, inners["c"].fail = true, true
+	err := s.Send([]Data{{"i": 1}, {"i": 2}})
+	se, ok := err.(*StatsError)
+	require.True(t, ok)
+	assert.Equal(t, int64(2), se.Errors)
+	assert.Len(t, se.SendError.GetFailDatas(), 2)
+	assert.NoError(t, s.Close())
+}
+
+func TestShardSenderHash(t *testing.T) {
+	s, inners := newShardTestSender(t, conf.MapConf{
+		KeyShardEndpoints:         `[{"test_host":"a"},{"test_host":"b"}]`,
+		KeyShardStrategy:          ShardStrategyHash,
+		KeyShardField:             "user",
+		KeyShardUnhealthyInterval: "1h",
+	})
+	datas := []Data{{"user": "u1"}, {"user": "u2"}, {"user": "u3"}, {"user": "u4"}, {"user": "u1"}}
+	assert.NoError(t, s.Send(datas))
+	assert.NoError(t, s.Send(datas))
+	assert.Equal(t, 10, inners["a"].count()+inners["b"].count())
+	// 相同字段值的数据总是发送到同一个下游
+	for _, inner := range inners {
+		users := make(map[interface{}]int)
+		for _, data := range inner.datas {
+			users[data["user"]]++
+		}
+		for user, n := range users {
+			if user == "u1" {
+				assert.Equal(t, 4, n)
+			} else {
+				assert.Equal(t, 2, n)
+			}
+		}
+	}
+
+	inners["a"].fail = true
+	before := inners["b"].count()
+	assert.NoError(t, s.Send(datas))
+	assert.Equal(t, before+5, inners["b"].count())
+	assert.False(t, s.shards[0].healthy(time.Now()))
+}
+
+func TestNewShardSenderError(t *testing.T) {
+	constructor := func(c conf.MapConf) (Sender, error) { return &shardTestSender{}, nil }
+	_, err := NewShardSender(conf.MapConf{KeyShardEndpoints: `[]`}, constructor)
+	assert.Error(t, err)
+	_, err = NewShardSender(conf.MapConf{KeyShardEndpoints: `{"a":"b"}`}, constructor)
+	assert.Error(t, err)
+	_, err = NewShardSender(conf.MapConf{KeyShardEndpoints: `[{}]`, KeyShardStrategy: "random"}, constructor)
+	assert.Error(t, err)
+}
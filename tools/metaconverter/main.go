@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/qiniu/logkit/reader"
+)
+
+const usage = `metaconverter convert reader meta files (file.meta, buf.meta) to specify encoding,
+buf.dat holds the buffered data itself and is left as it is
+
+Usage:
+
+  metaconverter [commands|flags]
+
+The commands & flags are:
+
+  -d  <dir>          meta directory, sub directories will be converted too
+  -to <encoding>     destination meta encoding, text or json, default is json
+
+Examples:
+
+  # stop logkit first, then convert meta to the versioned json encoding
+  metaconverter -d ./meta -to json
+
+  # roll back to the legacy text encoding
+  metaconverter -d ./meta -to text
+
+`
+
+var (
+	dir = flag.String("d", "", "meta directory")
+	to  = flag.String("to", reader.MetaEncodingJSON, "destination meta encoding")
+)
+
+func usageExit(rc int) {
+	fmt.Print(usage)
+	os.Exit(rc)
+}
+
+func main() {
+	flag.Usage = func() { usageExit(0) }
+	flag.Parse()
+	if *dir == "" {
+		usageExit(1)
+	}
+	converted, err := reader.ConvertMetaDir(*dir, *to)
+	for _, path := range converted {
+		fmt.Println("converted", path)
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Printf("%d meta files converted to %s\n", len(converted), *to)
+}
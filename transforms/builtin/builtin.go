@@ -4,6 +4,7 @@ import (
 	_ "github.com/qiniu/logkit/transforms/apps"
 	_ "github.com/qiniu/logkit/transforms/aws"
 	_ "github.com/qiniu/logkit/transforms/date"
+	_ "github.com/qiniu/logkit/transforms/detect"
 	_ "github.com/qiniu/logkit/transforms/ip"
 	_ "github.com/qiniu/logkit/transforms/mutate"
 	_ "github.com/qiniu/logkit/transforms/service"
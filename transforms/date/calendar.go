@@ -0,0 +1,492 @@
+package date
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/qiniu/logkit/times"
+	"github.com/qiniu/logkit/transforms"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+var (
+	_ transforms.StatsTransformer = &Calendar{}
+	_ transforms.Transformer      = &Calendar{}
+	_ transforms.Initializer      = &Calendar{}
+)
+
+// 输出的字段名，实际的字段名为 prefix 加上这些名称
+const (
+	CalendarWorkday       = "workday"
+	CalendarWeekend       = "weekend"
+	CalendarHoliday       = "holiday"
+	CalendarHolidayName   = "holiday_name"
+	CalendarBusinessHours = "business_hours"
+	CalendarPeriod        = "period"
+)
+
+// period 字段的取值，依次判断节假日、非工作日的周末以及工作日的工作时间
+const (
+	PeriodHoliday       = "holiday"
+	PeriodWeekend       = "weekend"
+	PeriodBusinessHours = "business_hours"
+	PeriodOffHours      = "off_hours"
+)
+
+const (
+	defaultCalendarPrefix = "calendar_"
+	// 日历文件修改后最多经过该时间重新加载
+	calendarReloadInterval = time.Minute
+	// 一个节假日区间最多包含的天数
+	maxHolidayDays = 366
+)
+
+// CalendarConfig 日历文件中的一个日历，日历文件为国家或地区代码到日历的 JSON 对象，如 {"CN": {...}, "US": {...}}
+type CalendarConfig struct {
+	// Timezone 日历所在的时区，如 Asia/Shanghai，为空时使用本地时区
+	Timezone string `json:"timezone"`
+	// BusinessHours 工作日的工作时间，如 ["09:00-12:00", "13:30-18:00"]，不配置时为 09:00-18:00
+	BusinessHours []string `json:"business_hours"`
+	// Weekend 周末，如 ["saturday", "sunday"]，不配置时为周六和周日，配置为空列表时没有周末
+	Weekend []string `json:"weekend"`
+	// Holidays 节假日，date 为 2006-01-02 格式的日期，配置 end 时为到 end 为止的区间，01-02 格式的日期每年都是节假日
+	Holidays []HolidayConfig `json:"holidays"`
+	// Workdays 调休的工作日，即使是周末也按工作日处理
+	Workdays []string `json:"workdays"`
+}
+
+// HolidayConfig 一个节假日或者节假日区间，name 为节假日的名称
+type HolidayConfig struct {
+	Date string `json:"date"`
+	End  string `json:"end"`
+	Name string `json:"name"`
+}
+
+type calendar struct {
+	loc *time.Location
+	// hours 工作时间的区间，单位为从零点开始的分钟数，左闭右开
+	hours    [][2]int
+	weekend  [7]bool
+	holidays map[string]string
+	annual   map[string]string
+	workdays map[string]bool
+}
+
+// Calendar 根据事件时间以及日历文件中的工作时间、周末、节假日和调休，标记数据是否为工作日、是否在工作时间等，
+// 便于下游告警在不同的时间段使用不同的阈值
+type Calendar struct {
+	Key          string `json:"key"`
+	CalendarFile string `json:"calendar_file"`
+	Country      string `json:"country"`
+	CountryKey   string `json:"country_key"`
+	Prefix       string `json:"prefix"`
+
+	keys        []string
+	countryKeys []string
+	calendars   map[string]*calendar
+	modTime     time.Time
+	lastCheck   time.Time
+	stats       StatsInfo
+}
+
+func (c *Calendar) Init() error {
+	if strings.TrimSpace(c.Key) == "" {
+		return errors.New("calendar transformer key can not be empty")
+	}
+	if c.CalendarFile == "" {
+		return errors.New("calendar transformer calendar_file can not be empty")
+	}
+	c.keys = GetKeys(c.Key)
+	if c.CountryKey != "" {
+		c.countryKeys = GetKeys(c.CountryKey)
+	}
+	if c.Prefix == "" {
+		c.Prefix = defaultCalendarPrefix
+	}
+	if err := c.load(); err != nil {
+		return err
+	}
+	if c.Country == "" && len(c.calendars) == 1 {
+		for country := range c.calendars {
+			c.Country = country
+		}
+	}
+	if c.Country != "" && c.calendars[c.Country] == nil {
+		return fmt.Errorf("country %v is not found in calendar file %v", c.Country, c.CalendarFile)
+	}
+	if c.Country == "" && c.CountryKey == "" {
+		return fmt.Errorf("country or country_key is required when calendar file %v has more than one calendar", c.CalendarFile)
+	}
+	return nil
+}
+
+// load 读取并解析日历文件，出错时保留之前的日历
+func (c *Calendar) load() error {
+	info, err := os.Stat(c.CalendarFile)
+	if err != nil {
+		return fmt.Errorf("stat calendar file %v error: %v", c.CalendarFile, err)
+	}
+	raw, err := ioutil.ReadFile(c.CalendarFile)
+	if err != nil {
+		return fmt.Errorf("read calendar file %v error: %v", c.CalendarFile, err)
+	}
+	var configs map[string]CalendarConfig
+	if err = json.Unmarshal(raw, &configs); err != nil {
+		return fmt.Errorf("parse calendar file %v error: %v", c.CalendarFile, err)
+	}
+	if len(configs) == 0 {
+		return fmt.Errorf("calendar file %v has no calendar", c.CalendarFile)
+	}
+	calendars := make(map[string]*calendar, len(configs))
+	for country, config := range configs {
+		cal, err := newCalendar(config)
+		if err != nil {
+			return fmt.Errorf("calendar %v in %v is invalid: %v", country, c.CalendarFile, err)
+		}
+		calendars[country] = cal
+	}
+	c.calendars = calendars
+	c.modTime = info.ModTime()
+	return nil
+}
+
+// reload 日历文件修改后重新加载，节假日每年都需要更新，不需要重启 runner
+func (c *Calendar) reload() error {
+	now := time.Now()
+	if now.Sub(c.lastCheck) < calendarReloadInterval {
+		return nil
+	}
+	c.lastCheck = now
+	info, err := os.Stat(c.CalendarFile)
+	if err != nil {
+		return fmt.Errorf("stat calendar file %v error: %v", c.CalendarFile, err)
+	}
+	if info.ModTime().Equal(c.modTime) {
+		return nil
+	}
+	return c.load()
+}
+
+func newCalendar(config CalendarConfig) (*calendar, error) {
+	cal := &calendar{
+		loc:      time.Local,
+		holidays: make(map[string]string),
+		annual:   make(map[string]string),
+		workdays: make(map[string]bool),
+	}
+	if config.Timezone != "" {
+		loc, err := time.LoadLocation(config.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timezone %q: %v", config.Timezone, err)
+		}
+		cal.loc = loc
+	}
+
+	hours := config.BusinessHours
+	if hours == nil {
+		hours = []string{"09:00-18:00"}
+	}
+	for _, h := range hours {
+		parts := strings.Split(h, "-")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid business hours %q, should be like 09:00-18:00", h)
+		}
+		start, err := parseClock(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseClock(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		if start >= end {
+			return nil, fmt.Errorf("invalid business hours %q, start should be before end", h)
+		}
+		cal.hours = append(cal.hours, [2]int{start, end})
+	}
+
+	weekend := config.Weekend
+	if weekend == nil {
+		weekend = []string{"saturday", "sunday"}
+	}
+	for _, day := range weekend {
+		weekday, err := parseWeekday(day)
+		if err != nil {
+			return nil, err
+		}
+		cal.weekend[weekday] = true
+	}
+
+	for _, h := range config.Holidays {
+		if _, err := time.Parse("01-02", h.Date); err == nil && h.End == "" {
+			cal.annual[h.Date] = h.Name
+			continue
+		}
+		start, err := time.Parse("2006-01-02", h.Date)
+		if err != nil {
+			return nil, fmt.Errorf("invalid holiday date %q, should be like 2006-01-02 or 01-02", h.Date)
+		}
+		end := start
+		if h.End != "" {
+			if end, err = time.Parse("2006-01-02", h.End); err != nil {
+				return nil, fmt.Errorf("invalid holiday end %q, should be like 2006-01-02", h.End)
+			}
+		}
+		if end.Before(start) || end.Sub(start) >= maxHolidayDays*24*time.Hour {
+			return nil, fmt.Errorf("invalid holiday %v to %v", h.Date, h.End)
+		}
+		for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+			cal.holidays[d.Format("2006-01-02")] = h.Name
+		}
+	}
+
+	for _, day := range config.Workdays {
+		if _, err := time.Parse("2006-01-02", day); err != nil {
+			return nil, fmt.Errorf("invalid workday %q, should be like 2006-01-02", day)
+		}
+		cal.workdays[day] = true
+	}
+	return cal, nil
+}
+
+// parseClock 将 09:30 转换为从零点开始的分钟数，允许 24:00
+func parseClock(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("invalid time %q, should be like 09:30", s)
+	}
+	hour, err1 := strconv.Atoi(parts[0])
+	minute, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil || hour < 0 || minute < 0 || minute >= 60 || hour*60+minute > 24*60 {
+		return 0, fmt.Errorf("invalid time %q, should be like 09:30", s)
+	}
+	return hour*60 + minute, nil
+}
+
+func parseWeekday(s string) (time.Weekday, error) {
+	name := strings.ToLower(strings.TrimSpace(s))
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		full := strings.ToLower(d.String())
+		if name == full || name == full[:3] {
+			return d, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid weekday %q", s)
+}
+
+// tag 返回 t 在日历中的标记
+func (cal *calendar) tag(t time.Time) map[string]interface{} {
+	t = t.In(cal.loc)
+	date := t.Format("2006-01-02")
+	holidayName, holiday := cal.holidays[date]
+	if !holiday {
+		holidayName, holiday = cal.annual[t.Format("01-02")]
+	}
+	weekend := cal.weekend[t.Weekday()]
+	workday := cal.workdays[date] || (!holiday && !weekend)
+
+	businessHours := false
+	if workday {
+		minute := t.Hour()*60 + t.Minute()
+		for _, h := range cal.hours {
+			if minute >= h[0] && minute < h[1] {
+				businessHours = true
+				break
+			}
+		}
+	}
+
+	period := PeriodOffHours
+	switch {
+	case businessHours:
+		period = PeriodBusinessHours
+	case workday:
+	case holiday:
+		period = PeriodHoliday
+	default:
+		period = PeriodWeekend
+	}
+	tags := map[string]interface{}{
+		CalendarWorkday:       workday,
+		CalendarWeekend:       weekend,
+		CalendarHoliday:       holiday && !cal.workdays[date],
+		CalendarBusinessHours: businessHours,
+		CalendarPeriod:        period,
+	}
+	if holiday && holidayName != "" && !cal.workdays[date] {
+		tags[CalendarHolidayName] = holidayName
+	}
+	return tags
+}
+
+// eventTime 将时间字段的值转换为时间，没有时区的字符串按照日历的时区解析
+func eventTime(v interface{}, loc *time.Location) (time.Time, error) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, nil
+	case *time.Time:
+		if t == nil {
+			return time.Time{}, errors.New("time is nil")
+		}
+		return *t, nil
+	case string:
+		return times.StrToTimeLocation(strings.Replace(t, ",", ".", -1), loc)
+	case json.Number:
+		return GetTime(t.String())
+	case int, int32, int64, uint32, uint64:
+		return GetTime(fmt.Sprint(t))
+	case float64:
+		return GetTime(strconv.FormatInt(int64(t), 10))
+	}
+	return time.Time{}, fmt.Errorf("can not parse %v type %T as date time", v, v)
+}
+
+func (c *Calendar) RawTransform(datas []string) ([]string, error) {
+	return datas, errors.New("calendar transformer not support rawTransform")
+}
+
+// calendarOf 返回数据使用的日历，country_key 的值没有对应的日历时使用 country 指定的日历
+func (c *Calendar) calendarOf(data Data) (*calendar, error) {
+	if len(c.countryKeys) > 0 {
+		if v, err := GetMapValue(data, c.countryKeys...); err == nil {
+			if cal, ok := c.calendars[fmt.Sprint(v)]; ok {
+				return cal, nil
+			}
+		}
+	}
+	if cal, ok := c.calendars[c.Country]; ok {
+		return cal, nil
+	}
+	return nil, fmt.Errorf("no calendar found for data, check country_key %v", c.CountryKey)
+}
+
+func (c *Calendar) Transform(datas []Data) ([]Data, error) {
+	if c.calendars == nil {
+		if err := c.Init(); err != nil {
+			return datas, err
+		}
+	}
+
+	var (
+		err, fmtErr error
+		errNum      int
+		dataLen     = len(datas)
+	)
+	if reloadErr := c.reload(); reloadErr != nil {
+		errNum, err = transforms.SetError(errNum, reloadErr, transforms.General, "")
+	}
+	for i := range datas {
+		val, getErr := GetMapValue(datas[i], c.keys...)
+		if getErr != nil {
+			errNum, err = transforms.SetError(errNum, getErr, transforms.GetErr, c.Key)
+			continue
+		}
+		cal, calErr := c.calendarOf(datas[i])
+		if calErr != nil {
+			errNum, err = transforms.SetError(errNum, calErr, transforms.General, "")
+			continue
+		}
+		t, timeErr := eventTime(val, cal.loc)
+		if timeErr != nil {
+			errNum, err = transforms.SetError(errNum, timeErr, transforms.General, "")
+			continue
+		}
+		for k, v := range cal.tag(t) {
+			datas[i][c.Prefix+k] = v
+		}
+	}
+
+	c.stats, fmtErr = transforms.SetStatsInfo(err, c.stats, int64(errNum), int64(dataLen), c.Type())
+	return datas, fmtErr
+}
+
+func (c *Calendar) Description() string {
+	return `根据事件时间和日历文件中的工作时间、周末、节假日以及调休，标记数据是否为工作日、周末、节假日以及是否在工作时间内，便于告警在不同时间段使用不同的阈值`
+}
+
+func (c *Calendar) Type() string {
+	return "calendar"
+}
+
+func (c *Calendar) SampleConfig() string {
+	return `{
+		"type":"calendar",
+		"key":"timestamp",
+		"calendar_file":"/etc/logkit/calendar.json",
+		"country":"CN",
+		"prefix":"calendar_"
+	}`
+}
+
+func (c *Calendar) ConfigOptions() []Option {
+	return []Option{
+		transforms.KeyFieldName,
+		{
+			KeyName:      "calendar_file",
+			ChooseOnly:   false,
+			Default:      "",
+			Required:     true,
+			Placeholder:  "/etc/logkit/calendar.json",
+			DefaultNoUse: true,
+			Description:  "日历文件路径(calendar_file)",
+			ToolTip:      `JSON 格式，国家或地区代码到日历的对象，如 {"CN": {"timezone": "Asia/Shanghai", "business_hours": ["09:00-18:00"], "weekend": ["saturday", "sunday"], "holidays": [{"date": "2019-10-01", "end": "2019-10-07", "name": "国庆节"}, {"date": "01-01", "name": "元旦"}], "workdays": ["2019-09-29"]}}，修改后一分钟内生效`,
+			Type:         transforms.TransformTypeString,
+		},
+		{
+			KeyName:      "country",
+			ChooseOnly:   false,
+			Default:      "",
+			Placeholder:  "CN",
+			DefaultNoUse: false,
+			Description:  "默认日历(country)",
+			ToolTip:      "使用日历文件中的哪个日历，日历文件只有一个日历时可以不填",
+			Type:         transforms.TransformTypeString,
+		},
+		{
+			KeyName:      "country_key",
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "国家或地区字段(country_key)",
+			Advance:      true,
+			ToolTip:      "按照该字段的值选择日历，没有对应的日历时使用默认日历",
+			Type:         transforms.TransformTypeString,
+		},
+		{
+			KeyName:      "prefix",
+			ChooseOnly:   false,
+			Default:      defaultCalendarPrefix,
+			DefaultNoUse: false,
+			Description:  "标记字段的前缀(prefix)",
+			Advance:      true,
+			ToolTip:      "生成 workday、weekend、holiday、holiday_name、business_hours、period 字段时添加的前缀",
+			Type:         transforms.TransformTypeString,
+		},
+	}
+}
+
+func (c *Calendar) Stage() string {
+	return transforms.StageAfterParser
+}
+
+func (c *Calendar) Stats() StatsInfo {
+	return c.stats
+}
+
+func (c *Calendar) SetStats(err string) StatsInfo {
+	c.stats.LastError = err
+	return c.stats
+}
+
+func init() {
+	transforms.Add("calendar", func() transforms.Transformer {
+		return &Calendar{}
+	})
+}
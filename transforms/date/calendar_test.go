@@ -0,0 +1,138 @@
+package date
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const testCalendars = `{
+	"CN": {
+		"timezone": "Asia/Shanghai",
+		"business_hours": ["09:00-12:00", "13:30-18:00"],
+		"holidays": [{"date": "2019-10-01", "end": "2019-10-07", "name": "国庆节"}, {"date": "01-01", "name": "元旦"}],
+		"workdays": ["2019-09-29"]
+	},
+	"AE": {
+		"timezone": "Asia/Dubai",
+		"weekend": ["fri", "saturday"]
+	}
+}`
+
+func TestCalendar(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestCalendar")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "calendar.json")
+	require.NoError(t, ioutil.WriteFile(file, []byte(testCalendars), 0644))
+
+	c := &Calendar{Key: "time", CalendarFile: file}
+	assert.Error(t, c.Init())
+	c = &Calendar{Key: "time", CalendarFile: file, Country: "US"}
+	assert.Error(t, c.Init())
+
+	c = &Calendar{Key: "time", CalendarFile: file, Country: "CN", CountryKey: "country"}
+	datas := []Data{
+		// 工作日的工作时间，字符串没有时区时按日历的时区解析
+		{"time": "2019-09-30 10:00:00"},
+		// 午休
+		{"time": "2019-09-30T04:45:00Z"},
+		// 国庆节
+		{"time": int64(1569981600)},
+		// 调休的周日
+		{"time": "2019-09-29 09:00:00"},
+		// 周末
+		{"time": time.Date(2019, 10, 12, 10, 0, 0, 0, time.UTC)},
+		// 每年的元旦
+		{"time": "2020-01-01 10:00:00"},
+		// 阿联酋的周五是周末，周日是工作日
+		{"time": "2019-10-11 10:00:00", "country": "AE"},
+		{"time": "2019-10-13 10:00:00", "country": "AE"},
+		{"time": "abc"},
+		{"other": 1},
+	}
+	datas, err = c.Transform(datas)
+	assert.Error(t, err)
+	assert.Equal(t, int64(2), c.Stats().Errors)
+
+	tags := func(workday, weekend, holiday, businessHours bool, period string, name ...string) map[string]interface{} {
+		m := map[string]interface{}{
+			"calendar_workday":        workday,
+			"calendar_weekend":        weekend,
+			"calendar_holiday":        holiday,
+			"calendar_business_hours": businessHours,
+			"calendar_period":         period,
+		}
+		if len(name) > 0 {
+			m["calendar_holiday_name"] = name[0]
+		}
+		return m
+	}
+	exps := []map[string]interface{}{
+		tags(true, false, false, true, PeriodBusinessHours),
+		tags(true, false, false, false, PeriodOffHours),
+		tags(false, false, true, false, PeriodHoliday, "国庆节"),
+		tags(true, true, false, true, PeriodBusinessHours),
+		tags(false, true, false, false, PeriodWeekend),
+		tags(false, false, true, false, PeriodHoliday, "元旦"),
+		tags(false, true, false, false, PeriodWeekend),
+		tags(true, false, false, true, PeriodBusinessHours),
+	}
+	for i, exp := range exps {
+		for k, v := range exp {
+			assert.Equal(t, v, datas[i][k], "data %d key %v", i, k)
+		}
+		_, ok := datas[i]["calendar_holiday_name"]
+		assert.Equal(t, exp["calendar_holiday_name"] != nil, ok, "data %d", i)
+	}
+	assert.Len(t, datas[8], 1)
+	assert.Len(t, datas[9], 1)
+
+	// 日历文件修改后重新加载
+	require.NoError(t, ioutil.WriteFile(file, []byte(`{"CN": {"timezone": "Asia/Shanghai", "holidays": [{"date": "2019-09-30"}]}}`), 0644))
+	require.NoError(t, os.Chtimes(file, time.Now(), time.Now().Add(time.Hour)))
+	c.lastCheck = time.Time{}
+	datas, err = c.Transform([]Data{{"time": "2019-09-30 10:00:00"}})
+	assert.NoError(t, err)
+	assert.Equal(t, PeriodHoliday, datas[0]["calendar_period"])
+	_, ok := datas[0]["calendar_holiday_name"]
+	assert.False(t, ok)
+
+	// 文件无效时保留之前的日历
+	require.NoError(t, ioutil.WriteFile(file, []byte(`{`), 0644))
+	require.NoError(t, os.Chtimes(file, time.Now(), time.Now().Add(2*time.Hour)))
+	c.lastCheck = time.Time{}
+	datas, err = c.Transform([]Data{{"time": "2019-09-30 10:00:00"}})
+	assert.Error(t, err)
+	assert.Equal(t, PeriodHoliday, datas[0]["calendar_period"])
+}
+
+func TestNewCalendar(t *testing.T) {
+	cal, err := newCalendar(CalendarConfig{Weekend: []string{}, BusinessHours: []string{"00:00-24:00"}})
+	assert.NoError(t, err)
+	tags := cal.tag(time.Date(2019, 10, 12, 23, 59, 0, 0, time.Local))
+	assert.Equal(t, true, tags[CalendarWorkday])
+	assert.Equal(t, true, tags[CalendarBusinessHours])
+
+	for _, config := range []CalendarConfig{
+		{Timezone: "Mars/Olympus"},
+		{BusinessHours: []string{"9-18"}},
+		{BusinessHours: []string{"18:00-09:00"}},
+		{BusinessHours: []string{"09:00-24:01"}},
+		{Weekend: []string{"someday"}},
+		{Holidays: []HolidayConfig{{Date: "2019/10/01"}}},
+		{Holidays: []HolidayConfig{{Date: "2019-10-07", End: "2019-10-01"}}},
+		{Holidays: []HolidayConfig{{Date: "2019-01-01", End: "2021-01-01"}}},
+		{Workdays: []string{"09-29"}},
+	} {
+		_, err = newCalendar(config)
+		assert.Error(t, err, config)
+	}
+}
@@ -0,0 +1,270 @@
+package detect
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/qiniu/log"
+
+	"github.com/qiniu/logkit/transforms"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+var (
+	_ transforms.StatsTransformer = &Detect{}
+	_ transforms.Transformer      = &Detect{}
+	_ transforms.Initializer      = &Detect{}
+)
+
+const (
+	defaultRuleIDsKey     = "rule_ids"
+	defaultRuleLevelKey   = "rule_level"
+	defaultReloadInterval = time.Minute
+)
+
+// Detect 使用规则文件中的规则逐条检查数据，将命中的规则 ID 用逗号连接后写入 new 字段，
+// 并将命中规则中最高的级别写入 level_key 字段，没有命中任何规则的数据保持不变。
+// 规则文件修改后在 reload_interval 内自动重新加载，加载失败时继续使用原有的规则
+type Detect struct {
+	RuleFile       string `json:"rule_file"`
+	New            string `json:"new"`
+	LevelKey       string `json:"level_key"`
+	ReloadInterval string `json:"reload_interval"`
+
+	news      []string
+	levelKeys []string
+	interval  time.Duration
+
+	lock      sync.RWMutex
+	rules     []rule
+	modTime   time.Time
+	lastCheck time.Time
+
+	matched int64
+	stats   StatsInfo
+}
+
+func (d *Detect) Init() error {
+	if strings.TrimSpace(d.RuleFile) == "" {
+		return errors.New("detect transformer rule_file can not be empty")
+	}
+	if d.New == "" {
+		d.New = defaultRuleIDsKey
+	}
+	d.news = GetKeys(d.New)
+	if d.LevelKey == "" {
+		d.LevelKey = defaultRuleLevelKey
+	}
+	// level_key 设置为 - 时不写入级别
+	if d.LevelKey != "-" {
+		d.levelKeys = GetKeys(d.LevelKey)
+	}
+	d.interval = defaultReloadInterval
+	if d.ReloadInterval != "" {
+		interval, err := time.ParseDuration(d.ReloadInterval)
+		if err != nil {
+			return fmt.Errorf("parse detect transformer reload_interval %q error: %v", d.ReloadInterval, err)
+		}
+		d.interval = interval
+	}
+
+	fi, err := os.Stat(d.RuleFile)
+	if err != nil {
+		return err
+	}
+	rules, err := loadRules(d.RuleFile)
+	if err != nil {
+		return err
+	}
+	d.lock.Lock()
+	d.rules = rules
+	d.modTime = fi.ModTime()
+	d.lastCheck = time.Now()
+	d.lock.Unlock()
+	return nil
+}
+
+// reload 距离上次检查超过 reload_interval 且规则文件被修改时重新加载规则，interval 不大于 0 时不重新加载
+func (d *Detect) reload() error {
+	if d.interval <= 0 {
+		return nil
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if time.Since(d.lastCheck) < d.interval {
+		return nil
+	}
+	d.lastCheck = time.Now()
+	fi, err := os.Stat(d.RuleFile)
+	if err != nil {
+		return err
+	}
+	if fi.ModTime().Equal(d.modTime) {
+		return nil
+	}
+	rules, err := loadRules(d.RuleFile)
+	if err != nil {
+		return err
+	}
+	d.rules = rules
+	d.modTime = fi.ModTime()
+	log.Infof("detect transformer reloaded %d rules from %v", len(rules), d.RuleFile)
+	return nil
+}
+
+func (d *Detect) getRules() []rule {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	return d.rules
+}
+
+// MatchedCount 返回累计命中规则的数据条数
+func (d *Detect) MatchedCount() int64 {
+	return atomic.LoadInt64(&d.matched)
+}
+
+func (d *Detect) RawTransform(datas []string) ([]string, error) {
+	return datas, errors.New("detect transformer not support rawTransform")
+}
+
+func (d *Detect) Transform(datas []Data) ([]Data, error) {
+	if d.news == nil {
+		if err := d.Init(); err != nil {
+			return datas, err
+		}
+	}
+	var (
+		err, fmtErr error
+		errNum      int
+		matchedNum  int64
+		dataLen     = len(datas)
+	)
+	reloadErr := d.reload()
+	if reloadErr != nil {
+		log.Errorf("detect transformer reload rule file %v error: %v, keep using the old rules", d.RuleFile, reloadErr)
+	}
+	rules := d.getRules()
+
+	var ids []string
+	for i := range datas {
+		ids = ids[:0]
+		level, rank := "", 0
+		for j := range rules {
+			if !rules[j].match(datas[i]) {
+				continue
+			}
+			ids = append(ids, rules[j].id)
+			if rules[j].rank > rank {
+				level, rank = rules[j].level, rules[j].rank
+			}
+		}
+		if len(ids) == 0 {
+			continue
+		}
+		matchedNum++
+		if setErr := SetMapValue(datas[i], strings.Join(ids, ","), false, d.news...); setErr != nil {
+			errNum, err = transforms.SetError(errNum, setErr, transforms.SetErr, d.New)
+			continue
+		}
+		if len(d.levelKeys) > 0 {
+			if setErr := SetMapValue(datas[i], level, false, d.levelKeys...); setErr != nil {
+				errNum, err = transforms.SetError(errNum, setErr, transforms.SetErr, d.LevelKey)
+			}
+		}
+	}
+	atomic.AddInt64(&d.matched, matchedNum)
+
+	d.stats, fmtErr = transforms.SetStatsInfo(err, d.stats, int64(errNum), int64(dataLen), d.Type())
+	// 重新加载失败不影响数据，只在 last_error 中提示
+	if reloadErr != nil && err == nil {
+		d.stats.LastError = fmt.Sprintf("reload rule file %v error: %v", d.RuleFile, reloadErr)
+	}
+	return datas, fmtErr
+}
+
+func (d *Detect) Description() string {
+	return `使用规则文件中的检测规则(正则、字段条件)检查每条数据，为命中的数据标记规则 ID 和最高的规则级别，规则文件修改后自动重新加载`
+}
+
+func (d *Detect) Type() string {
+	return "detect"
+}
+
+func (d *Detect) SampleConfig() string {
+	return `{
+		"type":"detect",
+		"rule_file":"/your/path/to/rules.yaml",
+		"new":"rule_ids",
+		"level_key":"rule_level",
+		"reload_interval":"1m"
+	}`
+}
+
+func (d *Detect) ConfigOptions() []Option {
+	return []Option{
+		{
+			KeyName:      "rule_file",
+			ChooseOnly:   false,
+			Default:      "",
+			Required:     true,
+			Placeholder:  "/your/path/to/rules.yaml",
+			DefaultNoUse: true,
+			Description:  "规则文件路径(rule_file)",
+			ToolTip:      "json 或 yaml 格式的规则文件，每条规则包含 id、level、match(all/any) 以及 conditions，条件支持 equals、contains、prefix、suffix、regex、exists",
+			Type:         transforms.TransformTypeString,
+		},
+		{
+			KeyName:      "new",
+			ChooseOnly:   false,
+			Default:      defaultRuleIDsKey,
+			DefaultNoUse: false,
+			Description:  "命中规则 ID 的字段名(new)",
+			ToolTip:      "命中的规则 ID 用逗号连接后写入该字段，没有命中时不写入",
+			Type:         transforms.TransformTypeString,
+		},
+		{
+			KeyName:      "level_key",
+			ChooseOnly:   false,
+			Default:      defaultRuleLevelKey,
+			DefaultNoUse: false,
+			Description:  "命中规则级别的字段名(level_key)",
+			Advance:      true,
+			ToolTip:      "命中规则中最高的级别写入该字段，填写 - 表示不写入",
+			Type:         transforms.TransformTypeString,
+		},
+		{
+			KeyName:      "reload_interval",
+			ChooseOnly:   false,
+			Default:      "1m",
+			DefaultNoUse: false,
+			Description:  "规则文件检查间隔(reload_interval)",
+			Advance:      true,
+			ToolTip:      "每隔该时长检查一次规则文件，修改后重新加载，设置为 0 表示不重新加载",
+			Type:         transforms.TransformTypeString,
+		},
+	}
+}
+
+func (d *Detect) Stage() string {
+	return transforms.StageAfterParser
+}
+
+func (d *Detect) Stats() StatsInfo {
+	return d.stats
+}
+
+func (d *Detect) SetStats(err string) StatsInfo {
+	d.stats.LastError = err
+	return d.stats
+}
+
+func init() {
+	transforms.Add("detect", func() transforms.Transformer {
+		return &Detect{}
+	})
+}
@@ -0,0 +1,118 @@
+package detect
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const testYamlRules = `
+rules:
+  - id: ssh-brute-force
+    level: high
+    conditions:
+      - field: program
+        values: [sshd]
+      - field: message
+        op: regex
+        values: ["Failed password for .* from \\d+\\.\\d+\\.\\d+\\.\\d+"]
+  - id: sudo
+    level: low
+    conditions:
+      - field: message
+        op: contains
+        ignore_case: true
+        values: [SUDO]
+  - id: root-or-admin
+    match: any
+    conditions:
+      - field: user.name
+        values: [root, admin]
+      - field: uid
+        values: ["0"]
+  - id: disabled
+    disabled: true
+    conditions:
+      - field: message
+        op: exists
+`
+
+func TestDetect(t *testing.T) {
+	dir, err := ioutil.TempDir("", "detect")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	ruleFile := filepath.Join(dir, "rules.yaml")
+	assert.NoError(t, ioutil.WriteFile(ruleFile, []byte(testYamlRules), 0644))
+
+	d := &Detect{RuleFile: ruleFile, ReloadInterval: "10ms"}
+	assert.NoError(t, d.Init())
+	datas := []Data{
+		{"program": "sshd", "message": "Failed password for root from 10.0.0.1 port 22", "user": map[string]interface{}{"name": "root"}},
+		{"program": "cron", "message": "sudo: session opened"},
+		{"program": "sshd", "message": "Accepted password", "uid": 0},
+		{"program": "nginx", "message": "GET /"},
+	}
+	res, err := d.Transform(datas)
+	assert.NoError(t, err)
+	assert.Equal(t, "ssh-brute-force,root-or-admin", res[0]["rule_ids"])
+	assert.Equal(t, LevelHigh, res[0]["rule_level"])
+	assert.Equal(t, "sudo", res[1]["rule_ids"])
+	assert.Equal(t, LevelLow, res[1]["rule_level"])
+	assert.Equal(t, "root-or-admin", res[2]["rule_ids"])
+	assert.Equal(t, LevelMedium, res[2]["rule_level"])
+	_, ok := res[3]["rule_ids"]
+	assert.False(t, ok)
+	assert.EqualValues(t, 3, d.MatchedCount())
+	assert.EqualValues(t, 4, d.Stats().Success)
+
+	// 规则文件修改后重新加载
+	jsonRules := `{"rules":[{"id":"nginx","level":"critical","conditions":[{"field":"program","op":"prefix","values":["ngi"]},{"field":"uid","op":"exists","not":true}]}]}`
+	assert.NoError(t, ioutil.WriteFile(ruleFile, []byte(jsonRules), 0644))
+	future := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(ruleFile, future, future))
+	time.Sleep(20 * time.Millisecond)
+	res, err = d.Transform([]Data{{"program": "nginx"}, {"program": "nginx", "uid": 1}})
+	assert.NoError(t, err)
+	assert.Equal(t, "nginx", res[0]["rule_ids"])
+	assert.Equal(t, LevelCritical, res[0]["rule_level"])
+	_, ok = res[1]["rule_ids"]
+	assert.False(t, ok)
+
+	// 加载失败时继续使用原有的规则
+	assert.NoError(t, ioutil.WriteFile(ruleFile, []byte("{"), 0644))
+	future = future.Add(time.Minute)
+	assert.NoError(t, os.Chtimes(ruleFile, future, future))
+	time.Sleep(20 * time.Millisecond)
+	res, err = d.Transform([]Data{{"program": "nginx"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "nginx", res[0]["rule_ids"])
+	assert.Contains(t, d.Stats().LastError, "reload rule file")
+
+	d = &Detect{RuleFile: ruleFile, New: "detect.ids", LevelKey: "-"}
+	assert.Error(t, d.Init())
+	assert.Error(t, (&Detect{}).Init())
+}
+
+func TestCompileRules(t *testing.T) {
+	_, err := compileRules([]Rule{{ID: "a", Conditions: []Condition{{Field: "x", Values: []string{"1"}}}}, {ID: "a", Conditions: []Condition{{Field: "x", Values: []string{"1"}}}}})
+	assert.Error(t, err)
+	_, err = compileRules([]Rule{{ID: "a", Level: "unknown", Conditions: []Condition{{Field: "x", Values: []string{"1"}}}}})
+	assert.Error(t, err)
+	_, err = compileRules([]Rule{{ID: "a", Conditions: []Condition{{Field: "x", Op: "regex", Values: []string{"("}}}}})
+	assert.Error(t, err)
+	_, err = compileRules([]Rule{{ID: "a", Conditions: []Condition{{Field: "x"}}}})
+	assert.Error(t, err)
+	_, err = compileRules([]Rule{{ID: "a"}})
+	assert.Error(t, err)
+
+	rules, err := compileRules([]Rule{{ID: "a", Conditions: []Condition{{Field: "x.y", Op: "suffix", Values: []string{".EXE"}, IgnoreCase: true}}}})
+	assert.NoError(t, err)
+	assert.True(t, rules[0].match(Data{"x": map[string]interface{}{"y": "c:\\cmd.exe"}}))
+	assert.False(t, rules[0].match(Data{"x": "cmd.exe"}))
+}
@@ -0,0 +1,268 @@
+package detect
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+// 规则的级别，与 Sigma 规则的 level 一致，按从低到高排列
+const (
+	LevelInformational = "informational"
+	LevelLow           = "low"
+	LevelMedium        = "medium"
+	LevelHigh          = "high"
+	LevelCritical      = "critical"
+)
+
+var levelRanks = map[string]int{
+	LevelInformational: 1,
+	LevelLow:           2,
+	LevelMedium:        3,
+	LevelHigh:          4,
+	LevelCritical:      5,
+}
+
+// 条件的比较方式
+const (
+	OpEquals   = "equals"
+	OpContains = "contains"
+	OpPrefix   = "prefix"
+	OpSuffix   = "suffix"
+	OpRegex    = "regex"
+	OpExists   = "exists"
+)
+
+// 规则中多个条件的组合方式
+const (
+	MatchAll = "all"
+	MatchAny = "any"
+)
+
+// RuleFile 规则文件的内容，支持 json 和 yaml 两种格式
+type RuleFile struct {
+	Rules []Rule `json:"rules" yaml:"rules"`
+}
+
+// Rule 一条检测规则，match 为 all 时所有条件都满足才命中，为 any 时满足任意一个条件即命中
+type Rule struct {
+	ID         string      `json:"id" yaml:"id"`
+	Title      string      `json:"title,omitempty" yaml:"title,omitempty"`
+	Level      string      `json:"level,omitempty" yaml:"level,omitempty"`
+	Match      string      `json:"match,omitempty" yaml:"match,omitempty"`
+	Disabled   bool        `json:"disabled,omitempty" yaml:"disabled,omitempty"`
+	Conditions []Condition `json:"conditions" yaml:"conditions"`
+}
+
+// Condition 对一个字段的判断，values 中的任意一个值满足 op 即认为条件成立，not 为 true 时取反
+type Condition struct {
+	Field      string   `json:"field" yaml:"field"`
+	Op         string   `json:"op,omitempty" yaml:"op,omitempty"`
+	Values     []string `json:"values,omitempty" yaml:"values,omitempty"`
+	IgnoreCase bool     `json:"ignore_case,omitempty" yaml:"ignore_case,omitempty"`
+	Not        bool     `json:"not,omitempty" yaml:"not,omitempty"`
+}
+
+type condition struct {
+	keys       []string
+	op         string
+	values     []string
+	regexps    []*regexp.Regexp
+	ignoreCase bool
+	not        bool
+}
+
+type rule struct {
+	id         string
+	level      string
+	rank       int
+	any        bool
+	conditions []condition
+}
+
+// loadRules 读取并编译规则文件，.yaml 和 .yml 后缀按 yaml 解析，其余按 json 解析
+func loadRules(path string) ([]rule, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rf RuleFile
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(raw, &rf)
+	default:
+		err = json.Unmarshal(raw, &rf)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal rule file %v error: %v", path, err)
+	}
+	return compileRules(rf.Rules)
+}
+
+func compileRules(rules []Rule) ([]rule, error) {
+	ret := make([]rule, 0, len(rules))
+	ids := make(map[string]bool, len(rules))
+	for _, r := range rules {
+		if r.Disabled {
+			continue
+		}
+		cr, err := compileRule(r)
+		if err != nil {
+			return nil, err
+		}
+		if ids[cr.id] {
+			return nil, fmt.Errorf("duplicate rule id %q", cr.id)
+		}
+		ids[cr.id] = true
+		ret = append(ret, cr)
+	}
+	return ret, nil
+}
+
+func compileRule(r Rule) (rule, error) {
+	id := strings.TrimSpace(r.ID)
+	if id == "" {
+		return rule{}, errors.New("rule id can not be empty")
+	}
+	if len(r.Conditions) == 0 {
+		return rule{}, fmt.Errorf("rule %q has no conditions", id)
+	}
+	cr := rule{id: id, level: strings.ToLower(strings.TrimSpace(r.Level))}
+	if cr.level == "" {
+		cr.level = LevelMedium
+	}
+	rank, ok := levelRanks[cr.level]
+	if !ok {
+		return rule{}, fmt.Errorf("rule %q has unknown level %q", id, r.Level)
+	}
+	cr.rank = rank
+	switch strings.ToLower(r.Match) {
+	case "", MatchAll:
+	case MatchAny:
+		cr.any = true
+	default:
+		return rule{}, fmt.Errorf("rule %q has unknown match %q, should be all or any", id, r.Match)
+	}
+	for _, c := range r.Conditions {
+		cc, err := compileCondition(c)
+		if err != nil {
+			return rule{}, fmt.Errorf("rule %q: %v", id, err)
+		}
+		cr.conditions = append(cr.conditions, cc)
+	}
+	return cr, nil
+}
+
+func compileCondition(c Condition) (condition, error) {
+	if strings.TrimSpace(c.Field) == "" {
+		return condition{}, errors.New("condition field can not be empty")
+	}
+	cc := condition{
+		keys:       GetKeys(c.Field),
+		op:         strings.ToLower(c.Op),
+		ignoreCase: c.IgnoreCase,
+		not:        c.Not,
+	}
+	if cc.op == "" {
+		cc.op = OpEquals
+	}
+	switch cc.op {
+	case OpExists:
+		return cc, nil
+	case OpEquals, OpContains, OpPrefix, OpSuffix:
+		for _, v := range c.Values {
+			if cc.ignoreCase {
+				v = strings.ToLower(v)
+			}
+			cc.values = append(cc.values, v)
+		}
+	case OpRegex:
+		for _, v := range c.Values {
+			if cc.ignoreCase {
+				v = "(?i)" + v
+			}
+			re, err := regexp.Compile(v)
+			if err != nil {
+				return condition{}, fmt.Errorf("compile regex %q of field %v error: %v", v, c.Field, err)
+			}
+			cc.regexps = append(cc.regexps, re)
+		}
+	default:
+		return condition{}, fmt.Errorf("unknown op %q of field %v", c.Op, c.Field)
+	}
+	if len(cc.values) == 0 && len(cc.regexps) == 0 {
+		return condition{}, fmt.Errorf("condition of field %v has no values", c.Field)
+	}
+	return cc, nil
+}
+
+func (c *condition) match(data Data) bool {
+	val, err := GetMapValue(data, c.keys...)
+	if err != nil || val == nil {
+		// 字段不存在时条件不成立，取反后成立
+		return c.not
+	}
+	if c.op == OpExists {
+		return !c.not
+	}
+	str := toString(val)
+	if c.ignoreCase && c.op != OpRegex {
+		str = strings.ToLower(str)
+	}
+	return c.matchString(str) != c.not
+}
+
+func (c *condition) matchString(str string) bool {
+	if c.op == OpRegex {
+		for _, re := range c.regexps {
+			if re.MatchString(str) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, v := range c.values {
+		var ok bool
+		switch c.op {
+		case OpEquals:
+			ok = str == v
+		case OpContains:
+			ok = strings.Contains(str, v)
+		case OpPrefix:
+			ok = strings.HasPrefix(str, v)
+		case OpSuffix:
+			ok = strings.HasSuffix(str, v)
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *rule) match(data Data) bool {
+	for i := range r.conditions {
+		if r.conditions[i].match(data) == r.any {
+			return r.any
+		}
+	}
+	return !r.any
+}
+
+func toString(val interface{}) string {
+	switch v := val.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	}
+	return fmt.Sprint(val)
+}
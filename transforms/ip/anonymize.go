@@ -0,0 +1,364 @@
+package ip
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/qiniu/logkit/transforms"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const (
+	ClassInternal = "internal"
+	ClassExternal = "external"
+	ClassCloud    = "cloud"
+
+	DefaultIPv4MaskBits = 24
+	DefaultIPv6MaskBits = 64
+)
+
+// defaultInternalCIDRs 私有地址、回环地址以及链路本地地址
+var defaultInternalCIDRs = []string{
+	"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "127.0.0.0/8", "169.254.0.0/16", "100.64.0.0/10",
+	"::1/128", "fc00::/7", "fe80::/10",
+}
+
+var (
+	_ transforms.StatsTransformer = &Anonymize{}
+	_ transforms.Transformer      = &Anonymize{}
+	_ transforms.Initializer      = &Anonymize{}
+)
+
+type classRange struct {
+	class string
+	ipNet *net.IPNet
+}
+
+type Anonymize struct {
+	Key           string `json:"key"`
+	New           string `json:"new"`
+	IPv4MaskBits  int    `json:"ipv4_mask_bits"`
+	IPv6MaskBits  int    `json:"ipv6_mask_bits"`
+	ClassKey      string `json:"class_key"`
+	InternalCIDRs string `json:"internal_cidrs"`
+	CloudCIDRs    string `json:"cloud_cidrs"`
+
+	keys      []string
+	news      []string
+	classKeys []string
+	ipv4Mask  net.IPMask
+	ipv6Mask  net.IPMask
+	// ranges 按配置顺序匹配，云厂商网段优先于内网网段
+	ranges []classRange
+	stats  StatsInfo
+
+	numRoutine int
+}
+
+func (g *Anonymize) Init() error {
+	g.keys = GetKeys(g.Key)
+	g.news = GetKeys(g.New)
+	g.classKeys = GetKeys(g.ClassKey)
+
+	if g.IPv4MaskBits <= 0 {
+		g.IPv4MaskBits = DefaultIPv4MaskBits
+	}
+	if g.IPv6MaskBits <= 0 {
+		g.IPv6MaskBits = DefaultIPv6MaskBits
+	}
+	if g.IPv4MaskBits > 32 || g.IPv6MaskBits > 128 {
+		return fmt.Errorf("ipv4_mask_bits should be in (0, 32] and ipv6_mask_bits should be in (0, 128], got %d and %d", g.IPv4MaskBits, g.IPv6MaskBits)
+	}
+	g.ipv4Mask = net.CIDRMask(g.IPv4MaskBits, 32)
+	g.ipv6Mask = net.CIDRMask(g.IPv6MaskBits, 128)
+
+	g.ranges = g.ranges[:0]
+	// 云厂商网段格式为 name=cidr，不写 name 时分类为 cloud
+	for _, item := range strings.Split(g.CloudCIDRs, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		class, cidr := ClassCloud, item
+		if idx := strings.Index(item, "="); idx >= 0 {
+			class, cidr = strings.TrimSpace(item[:idx]), strings.TrimSpace(item[idx+1:])
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("parse cloud cidr %q error: %v", item, err)
+		}
+		g.ranges = append(g.ranges, classRange{class: class, ipNet: ipNet})
+	}
+	internals := defaultInternalCIDRs
+	if strings.TrimSpace(g.InternalCIDRs) != "" {
+		internals = strings.Split(g.InternalCIDRs, ",")
+	}
+	for _, cidr := range internals {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("parse internal cidr %q error: %v", cidr, err)
+		}
+		g.ranges = append(g.ranges, classRange{class: ClassInternal, ipNet: ipNet})
+	}
+
+	numRoutine := MaxProcs
+	if numRoutine == 0 {
+		numRoutine = 1
+	}
+	g.numRoutine = numRoutine
+	return nil
+}
+
+// anonymize 将 ip 按掩码截断，默认 IPv4 保留前 24 位(最后一段置 0)，IPv6 保留前 64 位
+func (g *Anonymize) anonymize(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(g.ipv4Mask).String()
+	}
+	return ip.Mask(g.ipv6Mask).String()
+}
+
+func (g *Anonymize) classify(ip net.IP) string {
+	for _, r := range g.ranges {
+		if r.ipNet.Contains(ip) {
+			return r.class
+		}
+	}
+	return ClassExternal
+}
+
+func (g *Anonymize) Transform(datas []Data) ([]Data, error) {
+	if len(g.keys) == 0 {
+		if err := g.Init(); err != nil {
+			return datas, err
+		}
+	}
+
+	var (
+		dataLen     = len(datas)
+		err, fmtErr error
+		errNum      int
+		numRoutine  = g.numRoutine
+
+		dataPipeline = make(chan transforms.TransformInfo)
+		resultChan   = make(chan transforms.TransformResult)
+		wg           = new(sync.WaitGroup)
+	)
+
+	if dataLen < numRoutine {
+		numRoutine = dataLen
+	}
+
+	for i := 0; i < numRoutine; i++ {
+		wg.Add(1)
+		go g.transform(dataPipeline, resultChan, wg)
+	}
+
+	go func() {
+		wg.Wait()
+		close(resultChan)
+	}()
+
+	go func() {
+		for idx, data := range datas {
+			dataPipeline <- transforms.TransformInfo{
+				CurData: data,
+				Index:   idx,
+			}
+		}
+		close(dataPipeline)
+	}()
+
+	var transformResultSlice = make(transforms.TransformResultSlice, dataLen)
+	for resultInfo := range resultChan {
+		transformResultSlice[resultInfo.Index] = resultInfo
+	}
+
+	for _, transformResult := range transformResultSlice {
+		if transformResult.Err != nil {
+			err = transformResult.Err
+			errNum += transformResult.ErrNum
+		}
+		datas[transformResult.Index] = transformResult.CurData
+	}
+
+	g.stats, fmtErr = transforms.SetStatsInfo(err, g.stats, int64(errNum), int64(dataLen), g.Type())
+	return datas, fmtErr
+}
+
+func (g *Anonymize) RawTransform(datas []string) ([]string, error) {
+	return datas, errors.New("ip anonymize transformer not support rawTransform")
+}
+
+func (g *Anonymize) Description() string {
+	return `对 ip 字段进行脱敏(IPv4 默认将最后一段置为0，IPv6 默认只保留前64位)，并可根据配置的网段将 ip 分类为 internal/external/云厂商`
+}
+
+func (g *Anonymize) Type() string {
+	return "ipanonymize"
+}
+
+func (g *Anonymize) SampleConfig() string {
+	return `{
+       "type":"ipanonymize",
+       "key":"client_ip",
+       "ipv4_mask_bits":24,
+       "ipv6_mask_bits":64,
+       "class_key":"client_ip_class",
+       "cloud_cidrs":"aws=3.0.0.0/9,aliyun=47.92.0.0/14"
+    }`
+}
+
+func (g *Anonymize) ConfigOptions() []Option {
+	return []Option{
+		transforms.KeyFieldName,
+		transforms.KeyFieldNew,
+		{
+			KeyName:      "ipv4_mask_bits",
+			ChooseOnly:   false,
+			Default:      DefaultIPv4MaskBits,
+			DefaultNoUse: false,
+			Description:  "IPv4 保留的位数(ipv4_mask_bits)",
+			CheckRegex:   "\\d+",
+			Advance:      true,
+			ToolTip:      "IPv4 保留的前缀位数，默认为24，即将最后一段置为0",
+			Type:         transforms.TransformTypeLong,
+		},
+		{
+			KeyName:      "ipv6_mask_bits",
+			ChooseOnly:   false,
+			Default:      DefaultIPv6MaskBits,
+			DefaultNoUse: false,
+			Description:  "IPv6 保留的位数(ipv6_mask_bits)",
+			CheckRegex:   "\\d+",
+			Advance:      true,
+			ToolTip:      "IPv6 保留的前缀位数，默认为64",
+			Type:         transforms.TransformTypeLong,
+		},
+		{
+			KeyName:      "class_key",
+			ChooseOnly:   false,
+			Default:      "",
+			Placeholder:  "ip_class",
+			DefaultNoUse: false,
+			Description:  "网段分类字段名(class_key)",
+			CheckRegex:   CheckPatternKey,
+			ToolTip:      "填写后按脱敏前的 ip 所在网段写入分类：internal、external 或云厂商名称，不填则不分类",
+			Type:         transforms.TransformTypeString,
+		},
+		{
+			KeyName:      "internal_cidrs",
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "内网网段(internal_cidrs)",
+			Advance:      true,
+			ToolTip:      "逗号分隔的 CIDR 列表，不填默认为私有地址、回环地址及链路本地地址",
+			Type:         transforms.TransformTypeString,
+		},
+		{
+			KeyName:      "cloud_cidrs",
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "云厂商网段(cloud_cidrs)",
+			Advance:      true,
+			ToolTip:      "逗号分隔，格式为 name=cidr，如 aws=3.0.0.0/9，命中后分类为 name，不写 name 时分类为 cloud",
+			Type:         transforms.TransformTypeString,
+		},
+	}
+}
+
+func (g *Anonymize) Stage() string {
+	return transforms.StageAfterParser
+}
+
+func (g *Anonymize) Stats() StatsInfo {
+	return g.stats
+}
+
+func (g *Anonymize) SetStats(err string) StatsInfo {
+	g.stats.LastError = err
+	return g.stats
+}
+
+func init() {
+	transforms.Add("ipanonymize", func() transforms.Transformer {
+		return &Anonymize{}
+	})
+}
+
+func (g *Anonymize) transform(dataPipeline <-chan transforms.TransformInfo, resultChan chan transforms.TransformResult, wg *sync.WaitGroup) {
+	var (
+		err    error
+		errNum int
+	)
+	news := g.news
+	if len(news) == 0 {
+		news = g.keys
+	}
+	for transformInfo := range dataPipeline {
+		err = nil
+		errNum = 0
+
+		val, getErr := GetMapValue(transformInfo.CurData, g.keys...)
+		if getErr != nil {
+			errNum, err = transforms.SetError(errNum, getErr, transforms.GetErr, g.Key)
+			resultChan <- transforms.TransformResult{
+				Index:   transformInfo.Index,
+				CurData: transformInfo.CurData,
+				Err:     err,
+				ErrNum:  errNum,
+			}
+			continue
+		}
+		strVal, ok := val.(string)
+		if !ok {
+			typeErr := errors.New("transform key " + g.Key + " data type is not string")
+			errNum, err = transforms.SetError(errNum, typeErr, transforms.General, "")
+			resultChan <- transforms.TransformResult{
+				Index:   transformInfo.Index,
+				CurData: transformInfo.CurData,
+				Err:     err,
+				ErrNum:  errNum,
+			}
+			continue
+		}
+		ip := net.ParseIP(strings.TrimSpace(strVal))
+		if ip == nil {
+			errNum, err = transforms.SetError(errNum, errors.New("transform key "+g.Key+" value "+strVal+" is not a valid ip"), transforms.General, "")
+			resultChan <- transforms.TransformResult{
+				Index:   transformInfo.Index,
+				CurData: transformInfo.CurData,
+				Err:     err,
+				ErrNum:  errNum,
+			}
+			continue
+		}
+
+		if len(g.classKeys) > 0 {
+			setErr := SetMapValue(transformInfo.CurData, g.classify(ip), false, g.classKeys...)
+			if setErr != nil {
+				errNum, err = transforms.SetError(errNum, setErr, transforms.SetErr, g.ClassKey)
+			}
+		}
+		setErr := SetMapValue(transformInfo.CurData, g.anonymize(ip), false, news...)
+		if setErr != nil {
+			errNum, err = transforms.SetError(errNum, setErr, transforms.SetErr, g.New)
+		}
+
+		resultChan <- transforms.TransformResult{
+			Index:   transformInfo.Index,
+			CurData: transformInfo.CurData,
+			Err:     err,
+			ErrNum:  errNum,
+		}
+	}
+	wg.Done()
+}
@@ -0,0 +1,52 @@
+package ip
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+func TestAnonymizeTransformer(t *testing.T) {
+	g := &Anonymize{
+		Key:        "ip",
+		ClassKey:   "ip_class",
+		CloudCIDRs: "aws=3.0.0.0/9, 47.92.0.0/14",
+	}
+	assert.NoError(t, g.Init())
+	data := []Data{
+		{"ip": "192.168.1.23"},
+		{"ip": "3.1.2.3"},
+		{"ip": "47.93.1.1"},
+		{"ip": "8.8.8.8"},
+		{"ip": "2001:db8:1:2:3:4:5:6"},
+		{"ip": "fe80::1"},
+	}
+	res, err := g.Transform(data)
+	assert.NoError(t, err)
+	exp := []Data{
+		{"ip": "192.168.1.0", "ip_class": ClassInternal},
+		{"ip": "3.1.2.0", "ip_class": "aws"},
+		{"ip": "47.93.1.0", "ip_class": ClassCloud},
+		{"ip": "8.8.8.0", "ip_class": ClassExternal},
+		{"ip": "2001:db8:1:2::", "ip_class": ClassExternal},
+		{"ip": "fe80::", "ip_class": ClassInternal},
+	}
+	assert.Equal(t, exp, res)
+
+	g2 := &Anonymize{
+		Key:           "ip",
+		New:           "masked",
+		IPv4MaskBits:  16,
+		InternalCIDRs: "8.8.0.0/16",
+		ClassKey:      "class",
+	}
+	res, err = g2.Transform([]Data{{"ip": "8.8.4.4"}, {"ip": "abc"}, {"ip": 123}})
+	assert.Error(t, err)
+	assert.Equal(t, []Data{{"ip": "8.8.4.4", "masked": "8.8.0.0", "class": ClassInternal}, {"ip": "abc"}, {"ip": 123}}, res)
+	assert.EqualValues(t, 2, g2.Stats().Errors)
+
+	assert.Error(t, (&Anonymize{Key: "ip", CloudCIDRs: "aws=1.2.3"}).Init())
+	assert.Error(t, (&Anonymize{Key: "ip", IPv4MaskBits: 33}).Init())
+}
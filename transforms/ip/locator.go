@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"strings"
 	"sync"
+
+	"github.com/qiniu/logkit/utils/bundle"
 )
 
 const Null = "N/A"
@@ -81,7 +83,16 @@ func NewLocator(dataFile, language string) (Locator, error) {
 		return loc, nil
 	}
 
-	var err error
+	inner, err := openLocator(dataFile, language)
+	if err != nil {
+		return nil, err
+	}
+	loc = &reloadableLocator{path: dataFile, language: language, loc: inner}
+	locatorStore.Set(dataFile, loc)
+	return loc, nil
+}
+
+func openLocator(dataFile, language string) (loc Locator, err error) {
 	switch {
 	case strings.HasSuffix(dataFile, ".dat"):
 		loc, err = newDatLocator(dataFile)
@@ -95,7 +106,73 @@ func NewLocator(dataFile, language string) (Locator, error) {
 	if err != nil {
 		return nil, err
 	}
-
-	locatorStore.Set(dataFile, loc)
 	return loc, nil
 }
+
+// reloadableLocator 包装了实际的 Locator，数据文件更新后可以在不重建 transformer 的情况下原地替换
+type reloadableLocator struct {
+	path     string
+	language string
+
+	lock sync.RWMutex
+	loc  Locator
+}
+
+func (l *reloadableLocator) Find(ip string) (*LocationInfo, error) {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	return l.loc.Find(ip)
+}
+
+// Close 释放对 Locator 的引用
+func (l *reloadableLocator) Close() error {
+	locatorStore.Remove(l.path)
+	return nil
+}
+
+func (l *reloadableLocator) reload() error {
+	newLoc, err := openLocator(l.path, l.language)
+	if err != nil {
+		return err
+	}
+	l.lock.Lock()
+	old := l.loc
+	l.loc = newLoc
+	l.lock.Unlock()
+	// 替换后不会再有对旧数据的查询，可以释放 mmdb 的文件映射
+	if mmdb, ok := old.(*mmdbLocator); ok && mmdb.reader != nil {
+		mmdb.reader.Close()
+	}
+	return nil
+}
+
+// Reload 重新加载指定路径的数据文件，该路径没有被使用时直接返回
+func (s *LocatorStore) Reload(fpath string) error {
+	s.lock.RLock()
+	loc, ok := s.locators[fpath]
+	s.lock.RUnlock()
+	if !ok {
+		return nil
+	}
+	rl, ok := loc.(*reloadableLocator)
+	if !ok {
+		return nil
+	}
+	return rl.reload()
+}
+
+func init() {
+	bundle.RegisterValidator(bundle.TypeMMDB, func(path string) error {
+		loc, err := newMmdbLocator(path, "")
+		if err != nil {
+			return err
+		}
+		return loc.reader.Close()
+	})
+	bundle.RegisterUpdateHook(func(typ, path string) error {
+		if typ != bundle.TypeMMDB && typ != bundle.TypeFile {
+			return nil
+		}
+		return locatorStore.Reload(path)
+	})
+}
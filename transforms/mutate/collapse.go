@@ -0,0 +1,249 @@
+package mutate
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/qiniu/logkit/times"
+	"github.com/qiniu/logkit/transforms"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+var (
+	_ transforms.StatsTransformer = &Collapse{}
+	_ transforms.Transformer      = &Collapse{}
+	_ transforms.Initializer      = &Collapse{}
+)
+
+const (
+	defaultCollapseWindow   = "10s"
+	defaultCollapseCountKey = "repeat_count"
+	defaultCollapseFirstKey = "first_timestamp"
+	defaultCollapseLastKey  = "last_timestamp"
+)
+
+// Collapse 将同一批数据中连续重复的数据合并为一条，并记录重复次数以及第一条和最后一条的时间
+type Collapse struct {
+	Key      string `json:"key"`
+	TimeKey  string `json:"time_key"`
+	Window   string `json:"window"`
+	CountKey string `json:"count_key"`
+	FirstKey string `json:"first_key"`
+	LastKey  string `json:"last_key"`
+
+	keys     [][]string
+	timeKeys []string
+	window   time.Duration
+	stats    StatsInfo
+}
+
+type collapseGroup struct {
+	id    string
+	data  Data
+	count int64
+	first interface{}
+	last  interface{}
+	start time.Time
+}
+
+func (c *Collapse) Init() error {
+	if strings.TrimSpace(c.Key) == "" {
+		return errors.New("collapse transformer key can not be empty")
+	}
+	keys := strings.Split(c.Key, ",")
+	c.keys = make([][]string, 0, len(keys))
+	for _, key := range keys {
+		if key = strings.TrimSpace(key); key != "" {
+			c.keys = append(c.keys, GetKeys(key))
+		}
+	}
+	if c.TimeKey != "" {
+		c.timeKeys = GetKeys(c.TimeKey)
+	}
+	if c.Window == "" {
+		c.Window = defaultCollapseWindow
+	}
+	window, err := time.ParseDuration(c.Window)
+	if err != nil {
+		return fmt.Errorf("collapse transformer parse window %v error %v", c.Window, err)
+	}
+	c.window = window
+	if c.CountKey == "" {
+		c.CountKey = defaultCollapseCountKey
+	}
+	if c.FirstKey == "" {
+		c.FirstKey = defaultCollapseFirstKey
+	}
+	if c.LastKey == "" {
+		c.LastKey = defaultCollapseLastKey
+	}
+	return nil
+}
+
+func (c *Collapse) RawTransform(datas []string) ([]string, error) {
+	return datas, errors.New("collapse transformer not support rawTransform")
+}
+
+// groupID 以 key 中所有字段的值作为判断重复的依据，字段不存在时与空值区分
+func (c *Collapse) groupID(data Data) string {
+	values := make([]string, len(c.keys))
+	for i, keys := range c.keys {
+		val, err := GetMapValue(data, keys...)
+		if err != nil {
+			values[i] = "\x01"
+			continue
+		}
+		values[i] = fmt.Sprint(val)
+	}
+	return strings.Join(values, "\x00")
+}
+
+// eventTime 返回数据的时间，未配置 time_key 或者无法解析时返回处理时间，以及该时间对应的原始值
+func (c *Collapse) eventTime(data Data, now time.Time) (time.Time, interface{}) {
+	if len(c.timeKeys) == 0 {
+		return now, now.Format(time.RFC3339Nano)
+	}
+	val, err := GetMapValue(data, c.timeKeys...)
+	if err != nil {
+		return now, now.Format(time.RFC3339Nano)
+	}
+	switch v := val.(type) {
+	case time.Time:
+		return v, val
+	case string:
+		if t, err := times.StrToTime(v); err == nil {
+			return t, val
+		}
+	}
+	return now, val
+}
+
+func (c *Collapse) finish(group *collapseGroup) Data {
+	group.data[c.CountKey] = group.count
+	group.data[c.FirstKey] = group.first
+	group.data[c.LastKey] = group.last
+	return group.data
+}
+
+// Transform 只合并同一批数据中相邻的重复数据，合并后的数据保留第一条数据的内容
+func (c *Collapse) Transform(datas []Data) ([]Data, error) {
+	if c.keys == nil {
+		if err := c.Init(); err != nil {
+			return datas, err
+		}
+	}
+
+	var (
+		now    = time.Now()
+		result = make([]Data, 0, len(datas))
+		group  *collapseGroup
+	)
+	for _, data := range datas {
+		id := c.groupID(data)
+		t, raw := c.eventTime(data, now)
+		if group != nil && group.id == id && t.Sub(group.start) <= c.window {
+			group.count++
+			group.last = raw
+			continue
+		}
+		if group != nil {
+			result = append(result, c.finish(group))
+		}
+		group = &collapseGroup{id: id, data: data, count: 1, first: raw, last: raw, start: t}
+	}
+	if group != nil {
+		result = append(result, c.finish(group))
+	}
+
+	c.stats, _ = transforms.SetStatsInfo(nil, c.stats, 0, int64(len(datas)), c.Type())
+	return result, nil
+}
+
+func (c *Collapse) Description() string {
+	return `合并连续重复的数据, 以key指定的字段判断是否重复, 时间窗口内相邻的重复数据合并为一条, 并加入重复次数以及第一条和最后一条数据的时间`
+}
+
+func (c *Collapse) Type() string {
+	return "collapse"
+}
+
+func (c *Collapse) SampleConfig() string {
+	return `{
+		"type":"collapse",
+		"key":"message,level",
+		"time_key":"timestamp",
+		"window":"10s"
+	}`
+}
+
+func (c *Collapse) ConfigOptions() []Option {
+	return []Option{
+		transforms.KeyFieldName,
+		{
+			KeyName:      "time_key",
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "时间字段(time_key)",
+			ToolTip:      "用于计算时间窗口以及记录第一条和最后一条数据时间的字段，不填使用处理时间",
+			Type:         transforms.TransformTypeString,
+		},
+		{
+			KeyName:      "window",
+			ChooseOnly:   false,
+			Default:      defaultCollapseWindow,
+			DefaultNoUse: false,
+			Description:  "时间窗口(window)",
+			ToolTip:      "与第一条数据的时间间隔在窗口内的重复数据才会被合并，如 10s、1m",
+			Type:         transforms.TransformTypeString,
+		},
+		{
+			KeyName:      "count_key",
+			ChooseOnly:   false,
+			Default:      defaultCollapseCountKey,
+			DefaultNoUse: false,
+			Advance:      true,
+			Description:  "重复次数字段名(count_key)",
+			Type:         transforms.TransformTypeString,
+		},
+		{
+			KeyName:      "first_key",
+			ChooseOnly:   false,
+			Default:      defaultCollapseFirstKey,
+			DefaultNoUse: false,
+			Advance:      true,
+			Description:  "第一条数据时间字段名(first_key)",
+			Type:         transforms.TransformTypeString,
+		},
+		{
+			KeyName:      "last_key",
+			ChooseOnly:   false,
+			Default:      defaultCollapseLastKey,
+			DefaultNoUse: false,
+			Advance:      true,
+			Description:  "最后一条数据时间字段名(last_key)",
+			Type:         transforms.TransformTypeString,
+		},
+	}
+}
+
+func (c *Collapse) Stage() string {
+	return transforms.StageAfterParser
+}
+
+func (c *Collapse) Stats() StatsInfo {
+	return c.stats
+}
+
+func (c *Collapse) SetStats(err string) StatsInfo {
+	c.stats.LastError = err
+	return c.stats
+}
+
+func init() {
+	transforms.Add("collapse", func() transforms.Transformer {
+		return &Collapse{}
+	})
+}
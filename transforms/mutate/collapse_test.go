@@ -0,0 +1,51 @@
+package mutate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/qiniu/logkit/transforms"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+func TestCollapseTransformer(t *testing.T) {
+	t.Parallel()
+	c := &Collapse{Key: "message,level", TimeKey: "time", Window: "10s"}
+	assert.NoError(t, c.Init())
+	datas, err := c.Transform([]Data{
+		{"message": "disk full", "level": "error", "time": "2018-01-01T00:00:00Z"},
+		{"message": "disk full", "level": "error", "time": "2018-01-01T00:00:05Z"},
+		{"message": "disk full", "level": "error", "time": "2018-01-01T00:00:08Z"},
+		// 超过时间窗口重新计数
+		{"message": "disk full", "level": "error", "time": "2018-01-01T00:00:20Z"},
+		{"message": "disk full", "level": "warn", "time": "2018-01-01T00:00:21Z"},
+		{"message": "ok", "time": "2018-01-01T00:00:22Z"},
+		{"message": "ok", "time": "2018-01-01T00:00:23Z"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []Data{
+		{"message": "disk full", "level": "error", "time": "2018-01-01T00:00:00Z", "repeat_count": int64(3), "first_timestamp": "2018-01-01T00:00:00Z", "last_timestamp": "2018-01-01T00:00:08Z"},
+		{"message": "disk full", "level": "error", "time": "2018-01-01T00:00:20Z", "repeat_count": int64(1), "first_timestamp": "2018-01-01T00:00:20Z", "last_timestamp": "2018-01-01T00:00:20Z"},
+		{"message": "disk full", "level": "warn", "time": "2018-01-01T00:00:21Z", "repeat_count": int64(1), "first_timestamp": "2018-01-01T00:00:21Z", "last_timestamp": "2018-01-01T00:00:21Z"},
+		{"message": "ok", "time": "2018-01-01T00:00:22Z", "repeat_count": int64(2), "first_timestamp": "2018-01-01T00:00:22Z", "last_timestamp": "2018-01-01T00:00:23Z"},
+	}, datas)
+	assert.Equal(t, StatsInfo{Success: 7}, c.Stats())
+	assert.Equal(t, transforms.StageAfterParser, c.Stage())
+
+	// 不相邻的重复数据不合并，未配置时间字段时使用处理时间
+	c = &Collapse{Key: "message", CountKey: "cnt"}
+	datas, err = c.Transform([]Data{{"message": "a"}, {"message": "b"}, {"message": "a"}, {"message": "a"}, {"other": 1}})
+	assert.NoError(t, err)
+	assert.Len(t, datas, 4)
+	assert.Equal(t, int64(1), datas[0]["cnt"])
+	assert.Equal(t, int64(2), datas[2]["cnt"])
+	assert.Equal(t, datas[2]["first_timestamp"], datas[2]["last_timestamp"])
+	assert.Equal(t, Data{"other": 1, "cnt": int64(1), "first_timestamp": datas[3]["first_timestamp"], "last_timestamp": datas[3]["last_timestamp"]}, datas[3])
+
+	c = &Collapse{}
+	_, err = c.Transform([]Data{{"message": "a"}})
+	assert.Error(t, err)
+	c = &Collapse{Key: "message", Window: "abc"}
+	assert.Error(t, c.Init())
+}
@@ -0,0 +1,328 @@
+package mutate
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/qiniu/logkit/transforms"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+var (
+	_ transforms.StatsTransformer = &PII{}
+	_ transforms.Transformer      = &PII{}
+	_ transforms.Initializer      = &PII{}
+)
+
+// 内置的敏感信息类型
+const (
+	PIIMobile   = "mobile"   // 手机号码
+	PIIIDCard   = "idcard"   // 居民身份证号码
+	PIIBankCard = "bankcard" // 银行卡号
+
+	PIIActionMask = "mask"
+	PIIActionTag  = "tag"
+
+	defaultPIITagKey = "pii_types"
+)
+
+var (
+	allPIITypes = []string{PIIMobile, PIIIDCard, PIIBankCard}
+
+	// 连续的数字，身份证号码最后一位可能是 X
+	digitsRegex = regexp.MustCompile(`\d+[Xx]?`)
+
+	idCardWeights = []int{7, 9, 10, 5, 8, 4, 2, 1, 6, 3, 7, 9, 10, 5, 8, 4, 2}
+	idCardChecks  = "10X98765432"
+)
+
+// PII 识别手机号码、身份证号码以及银行卡号，并将其打码或者在数据中标记发现的类型。
+// 只识别前后不是数字的连续数字，身份证号码校验校验位和出生日期，银行卡号校验 Luhn 校验位
+type PII struct {
+	StageTime string `json:"stage"`
+	Key       string `json:"key"`
+	Detectors string `json:"detectors"`
+	Action    string `json:"action"`
+	TagKey    string `json:"tag_key"`
+
+	keys      []string
+	tagKeys   []string
+	detectors map[string]bool
+	stats     StatsInfo
+}
+
+func (p *PII) Init() error {
+	if p.Stage() == transforms.StageAfterParser && strings.TrimSpace(p.Key) == "" {
+		return errors.New("pii transformer key can not be empty")
+	}
+	p.keys = GetKeys(p.Key)
+	if p.Action == "" {
+		p.Action = PIIActionMask
+	}
+	if p.Action != PIIActionMask && p.Action != PIIActionTag {
+		return fmt.Errorf("pii transformer action should be %v or %v", PIIActionMask, PIIActionTag)
+	}
+	if p.Action == PIIActionTag && p.Stage() == transforms.StageBeforeParser {
+		return fmt.Errorf("pii transformer action %v is not supported in stage %v", PIIActionTag, transforms.StageBeforeParser)
+	}
+	if p.TagKey == "" {
+		p.TagKey = defaultPIITagKey
+	}
+	p.tagKeys = GetKeys(p.TagKey)
+
+	p.detectors = make(map[string]bool)
+	detectors := allPIITypes
+	if strings.TrimSpace(p.Detectors) != "" {
+		detectors = strings.Split(p.Detectors, ",")
+	}
+	for _, d := range detectors {
+		d = strings.TrimSpace(d)
+		if d != PIIMobile && d != PIIIDCard && d != PIIBankCard {
+			return fmt.Errorf("pii transformer detector %q is not supported, should be one of %v", d, allPIITypes)
+		}
+		p.detectors[d] = true
+	}
+	return nil
+}
+
+// detect 返回连续数字的敏感信息类型，不是敏感信息时返回空
+func (p *PII) detect(digits string) string {
+	switch {
+	case p.detectors[PIIMobile] && isMobile(digits):
+		return PIIMobile
+	case p.detectors[PIIIDCard] && isIDCard(digits):
+		return PIIIDCard
+	case p.detectors[PIIBankCard] && isBankCard(digits):
+		return PIIBankCard
+	}
+	return ""
+}
+
+// isMobile 判断是否为 11 位手机号码，允许带 86 前缀
+func isMobile(s string) bool {
+	if len(s) == 13 && strings.HasPrefix(s, "86") {
+		s = s[2:]
+	}
+	if len(s) != 11 || s[0] != '1' || s[1] < '3' || !isDigits(s) {
+		return false
+	}
+	return true
+}
+
+// isIDCard 判断是否为 18 位居民身份证号码，校验 GB 11643 校验位以及出生日期
+func isIDCard(s string) bool {
+	if len(s) != 18 || !isDigits(s[:17]) {
+		return false
+	}
+	sum := 0
+	for i, w := range idCardWeights {
+		sum += int(s[i]-'0') * w
+	}
+	if idCardChecks[sum%11] != strings.ToUpper(s[17:])[0] {
+		return false
+	}
+	birth, err := time.Parse("20060102", s[6:14])
+	return err == nil && birth.Year() >= 1900 && birth.Before(time.Now())
+}
+
+// isBankCard 判断是否为 16 到 19 位的银行卡号，校验 Luhn 校验位
+func isBankCard(s string) bool {
+	if len(s) < 16 || len(s) > 19 || !isDigits(s) {
+		return false
+	}
+	sum := 0
+	for i := len(s) - 1; i >= 0; i-- {
+		d := int(s[i] - '0')
+		if (len(s)-i)%2 == 0 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// maskPII 保留手机号码的前 3 位和后 4 位，身份证号码以及银行卡号的前 6 位和后 4 位，其余用 * 代替
+func maskPII(typ, s string) string {
+	prefix := 6
+	if typ == PIIMobile {
+		prefix = len(s) - 8
+	}
+	return s[:prefix] + strings.Repeat("*", len(s)-prefix-4) + s[len(s)-4:]
+}
+
+// process 返回打码之后的字符串以及发现的敏感信息类型
+func (p *PII) process(s string) (string, []string) {
+	var found []string
+	masked := digitsRegex.ReplaceAllStringFunc(s, func(match string) string {
+		digits, suffix := match, ""
+		typ := p.detect(digits)
+		// 结尾的 X 不是身份证号码的校验位时，只检查前面的数字
+		if typ == "" && !isDigits(match) {
+			digits, suffix = match[:len(match)-1], match[len(match)-1:]
+			typ = p.detect(digits)
+		}
+		if typ == "" {
+			return match
+		}
+		found = appendUnique(found, typ)
+		return maskPII(typ, digits) + suffix
+	})
+	return masked, found
+}
+
+func appendUnique(list []string, s string) []string {
+	for _, v := range list {
+		if v == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
+
+func (p *PII) RawTransform(datas []string) ([]string, error) {
+	if p.detectors == nil {
+		if err := p.Init(); err != nil {
+			return datas, err
+		}
+	}
+	for i := range datas {
+		datas[i], _ = p.process(datas[i])
+	}
+
+	p.stats, _ = transforms.SetStatsInfo(nil, p.stats, 0, int64(len(datas)), p.Type())
+	return datas, nil
+}
+
+func (p *PII) Transform(datas []Data) ([]Data, error) {
+	if p.detectors == nil {
+		if err := p.Init(); err != nil {
+			return datas, err
+		}
+	}
+
+	var (
+		err, fmtErr error
+		errNum      int
+		dataLen     = len(datas)
+	)
+	for i := range datas {
+		val, getErr := GetMapValue(datas[i], p.keys...)
+		if getErr != nil {
+			errNum, err = transforms.SetError(errNum, getErr, transforms.GetErr, p.Key)
+			continue
+		}
+		strVal, ok := val.(string)
+		if !ok {
+			errNum++
+			err = errors.New("transform key " + p.Key + " data type is not string")
+			continue
+		}
+		masked, found := p.process(strVal)
+		if len(found) == 0 {
+			continue
+		}
+		var setErr error
+		if p.Action == PIIActionTag {
+			setErr = SetMapValue(datas[i], found, false, p.tagKeys...)
+		} else {
+			setErr = SetMapValue(datas[i], masked, false, p.keys...)
+		}
+		if setErr != nil {
+			errNum, err = transforms.SetError(errNum, setErr, transforms.SetErr, p.Key)
+		}
+	}
+
+	p.stats, fmtErr = transforms.SetStatsInfo(err, p.stats, int64(errNum), int64(dataLen), p.Type())
+	return datas, fmtErr
+}
+
+func (p *PII) Description() string {
+	return `识别手机号码、身份证号码以及银行卡号，打码或者标记发现的敏感信息类型`
+}
+
+func (p *PII) Type() string {
+	return "pii"
+}
+
+func (p *PII) SampleConfig() string {
+	return `{
+		"type":"pii",
+		"key":"message",
+		"detectors":"mobile,idcard,bankcard",
+		"action":"mask"
+	}`
+}
+
+func (p *PII) ConfigOptions() []Option {
+	return []Option{
+		transforms.KeyStage,
+		transforms.KeyFieldName,
+		{
+			KeyName:      "detectors",
+			ChooseOnly:   false,
+			Default:      strings.Join(allPIITypes, ","),
+			DefaultNoUse: false,
+			Description:  "识别的敏感信息类型(detectors)",
+			ToolTip:      "mobile 为手机号码，idcard 为身份证号码，bankcard 为银行卡号，多个类型用逗号(,)连接",
+			Type:         transforms.TransformTypeString,
+		},
+		{
+			KeyName:       "action",
+			Element:       Radio,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{PIIActionMask, PIIActionTag},
+			Default:       PIIActionMask,
+			DefaultNoUse:  false,
+			Description:   "处理方式(action)",
+			ToolTip:       "mask 将敏感信息打码，tag 不修改原字段，将发现的敏感信息类型写入 tag_key 字段，parser 前只支持 mask",
+			Type:          transforms.TransformTypeString,
+		},
+		{
+			KeyName:      "tag_key",
+			ChooseOnly:   false,
+			Default:      defaultPIITagKey,
+			DefaultNoUse: false,
+			Description:  "标记的字段名(tag_key)",
+			Advance:      true,
+			ToolTip:      "action 为 tag 时，发现的敏感信息类型列表写入该字段",
+			Type:         transforms.TransformTypeString,
+		},
+	}
+}
+
+func (p *PII) Stage() string {
+	if p.StageTime == "" {
+		return transforms.StageAfterParser
+	}
+	return p.StageTime
+}
+
+func (p *PII) Stats() StatsInfo {
+	return p.stats
+}
+
+func (p *PII) SetStats(err string) StatsInfo {
+	p.stats.LastError = err
+	return p.stats
+}
+
+func init() {
+	transforms.Add("pii", func() transforms.Transformer {
+		return &PII{}
+	})
+}
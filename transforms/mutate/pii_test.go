@@ -0,0 +1,71 @@
+package mutate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/qiniu/logkit/transforms"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+func TestPIIDetectors(t *testing.T) {
+	assert.True(t, isMobile("13812345678"))
+	assert.True(t, isMobile("8613812345678"))
+	assert.False(t, isMobile("12812345678"))
+	assert.False(t, isMobile("1381234567"))
+
+	assert.True(t, isIDCard("11010519491231002X"))
+	assert.True(t, isIDCard("11010519491231002x"))
+	assert.True(t, isIDCard("440308199001011239"))
+	assert.False(t, isIDCard("440308199001011238"))
+	// 校验位正确但出生日期不合法
+	assert.False(t, isIDCard("440308199013011236"))
+
+	assert.True(t, isBankCard("6217001234567890122"))
+	assert.False(t, isBankCard("6217001234567890120"))
+	assert.False(t, isBankCard("4111111111111"))
+}
+
+func TestPII(t *testing.T) {
+	p := &PII{Key: "message"}
+	assert.NoError(t, p.Init())
+	datas := []Data{
+		{"message": "call +86 13812345678 or 8613912345678, order 1381234567812"},
+		{"message": "id 11010519491231002X card 6217001234567890122 bad 6217001234567890120"},
+		{"message": "id 11010519491231002Xyz"},
+		{"message": 13812345678},
+		{"other": "13812345678"},
+	}
+	res, err := p.Transform(datas)
+	assert.Error(t, err)
+	assert.Equal(t, "call +86 138****5678 or 86139****5678, order 1381234567812", res[0]["message"])
+	assert.Equal(t, "id 110105********002X card 621700*********0122 bad 6217001234567890120", res[1]["message"])
+	assert.Equal(t, "id 110105********002Xyz", res[2]["message"])
+	assert.Equal(t, 13812345678, res[3]["message"])
+	assert.EqualValues(t, 2, p.Stats().Errors)
+	assert.EqualValues(t, 3, p.Stats().Success)
+
+	p = &PII{Key: "a.msg", Detectors: "idcard, bankcard", Action: PIIActionTag}
+	assert.NoError(t, p.Init())
+	res, err = p.Transform([]Data{
+		{"a": map[string]interface{}{"msg": "13812345678 6217001234567890122 440308199001011239 6217001234567890122"}},
+		{"a": map[string]interface{}{"msg": "nothing"}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "13812345678 6217001234567890122 440308199001011239 6217001234567890122", res[0]["a"].(map[string]interface{})["msg"])
+	assert.Equal(t, []string{PIIBankCard, PIIIDCard}, res[0][defaultPIITagKey])
+	_, ok := res[1][defaultPIITagKey]
+	assert.False(t, ok)
+
+	p = &PII{StageTime: transforms.StageBeforeParser}
+	assert.NoError(t, p.Init())
+	raw, err := p.RawTransform([]string{"user 13812345678 login"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"user 138****5678 login"}, raw)
+
+	assert.Error(t, (&PII{}).Init())
+	assert.Error(t, (&PII{Key: "a", Detectors: "email"}).Init())
+	assert.Error(t, (&PII{Key: "a", Action: "drop"}).Init())
+	assert.Error(t, (&PII{StageTime: transforms.StageBeforeParser, Action: PIIActionTag}).Init())
+}
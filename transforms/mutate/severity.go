@@ -0,0 +1,252 @@
+package mutate
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/qiniu/logkit/transforms"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+var (
+	_ transforms.StatsTransformer = &Severity{}
+	_ transforms.Transformer      = &Severity{}
+	_ transforms.Initializer      = &Severity{}
+)
+
+// 统一后的日志级别，与 syslog 的级别一致
+const (
+	SeverityEmergency = "emergency"
+	SeverityAlert     = "alert"
+	SeverityCritical  = "critical"
+	SeverityError     = "error"
+	SeverityWarning   = "warning"
+	SeverityNotice    = "notice"
+	SeverityInfo      = "info"
+	SeverityDebug     = "debug"
+	SeverityUnknown   = "unknown"
+
+	defaultSeverityKey = "severity"
+)
+
+// defaultSeverityMap 内置的映射表，包括 syslog 数字级别、log4j、nginx 以及常见的中文级别，key 均为小写
+var defaultSeverityMap = map[string]string{
+	// syslog
+	"0": SeverityEmergency,
+	"1": SeverityAlert,
+	"2": SeverityCritical,
+	"3": SeverityError,
+	"4": SeverityWarning,
+	"5": SeverityNotice,
+	"6": SeverityInfo,
+	"7": SeverityDebug,
+
+	"emergency":     SeverityEmergency,
+	"emerg":         SeverityEmergency,
+	"panic":         SeverityEmergency,
+	"alert":         SeverityAlert,
+	"critical":      SeverityCritical,
+	"crit":          SeverityCritical,
+	"fatal":         SeverityCritical,
+	"error":         SeverityError,
+	"err":           SeverityError,
+	"severe":        SeverityError,
+	"warning":       SeverityWarning,
+	"warn":          SeverityWarning,
+	"notice":        SeverityNotice,
+	"info":          SeverityInfo,
+	"information":   SeverityInfo,
+	"informational": SeverityInfo,
+	"debug":         SeverityDebug,
+	"trace":         SeverityDebug,
+	"fine":          SeverityDebug,
+	"finer":         SeverityDebug,
+	"finest":        SeverityDebug,
+
+	"致命": SeverityCritical,
+	"严重": SeverityCritical,
+	"错误": SeverityError,
+	"警告": SeverityWarning,
+	"告警": SeverityWarning,
+	"提示": SeverityNotice,
+	"通知": SeverityNotice,
+	"信息": SeverityInfo,
+	"调试": SeverityDebug,
+}
+
+// Severity 将不同格式的日志级别统一为 syslog 的级别名称，无法识别的级别设置为 unknown 并计数
+type Severity struct {
+	Key     string `json:"key"`
+	New     string `json:"new"`
+	Map     string `json:"map"`
+	Unknown string `json:"unknown"`
+
+	keys    []string
+	news    []string
+	mapping map[string]string
+	unknown int64
+	stats   StatsInfo
+}
+
+func (s *Severity) Init() error {
+	if strings.TrimSpace(s.Key) == "" {
+		return errors.New("severity transformer key can not be empty")
+	}
+	s.keys = GetKeys(s.Key)
+	if s.New == "" {
+		s.New = defaultSeverityKey
+	}
+	s.news = GetKeys(s.New)
+	if s.Unknown == "" {
+		s.Unknown = SeverityUnknown
+	}
+	s.mapping = make(map[string]string, len(defaultSeverityMap))
+	for k, v := range defaultSeverityMap {
+		s.mapping[k] = v
+	}
+	// 自定义的映射关系优先于内置的映射关系
+	for k, v := range GetMapList(s.Map) {
+		s.mapping[strings.ToLower(k)] = v
+	}
+	return nil
+}
+
+func (s *Severity) RawTransform(datas []string) ([]string, error) {
+	return datas, errors.New("severity transformer not support rawTransform")
+}
+
+// normalize 返回统一后的级别，无法识别时 ok 为 false
+func (s *Severity) normalize(val interface{}) (string, bool) {
+	var str string
+	switch v := val.(type) {
+	case string:
+		str = v
+	case []byte:
+		str = string(v)
+	default:
+		str = fmt.Sprint(v)
+	}
+	ret, ok := s.mapping[strings.ToLower(strings.TrimSpace(str))]
+	return ret, ok
+}
+
+// UnknownCount 返回累计无法识别的级别数量
+func (s *Severity) UnknownCount() int64 {
+	return atomic.LoadInt64(&s.unknown)
+}
+
+func (s *Severity) Transform(datas []Data) ([]Data, error) {
+	if s.mapping == nil {
+		if err := s.Init(); err != nil {
+			return datas, err
+		}
+	}
+
+	var (
+		err, fmtErr error
+		errNum      int
+		unknownNum  int64
+		lastUnknown interface{}
+		dataLen     = len(datas)
+	)
+	for i := range datas {
+		val, getErr := GetMapValue(datas[i], s.keys...)
+		if getErr != nil {
+			errNum, err = transforms.SetError(errNum, getErr, transforms.GetErr, s.Key)
+			continue
+		}
+		severity, ok := s.normalize(val)
+		if !ok {
+			severity = s.Unknown
+			unknownNum++
+			lastUnknown = val
+		}
+		if setErr := SetMapValue(datas[i], severity, false, s.news...); setErr != nil {
+			errNum, err = transforms.SetError(errNum, setErr, transforms.SetErr, s.New)
+		}
+	}
+
+	s.stats, fmtErr = transforms.SetStatsInfo(err, s.stats, int64(errNum), int64(dataLen), s.Type())
+	if unknownNum > 0 {
+		total := atomic.AddInt64(&s.unknown, unknownNum)
+		// 无法识别的级别不算作错误，只在 last_error 中提示便于补充映射关系
+		if err == nil {
+			s.stats.LastError = fmt.Sprintf("find total %v unknown severity values in transform %v, last unknown value is %v", total, s.Type(), lastUnknown)
+		}
+	}
+	return datas, fmtErr
+}
+
+func (s *Severity) Description() string {
+	return `将 syslog 数字级别、log4j、nginx 以及中文等不同格式的日志级别统一为 emergency、alert、critical、error、warning、notice、info、debug，无法识别的级别统一为 unknown`
+}
+
+func (s *Severity) Type() string {
+	return "severity"
+}
+
+func (s *Severity) SampleConfig() string {
+	return `{
+		"type":"severity",
+		"key":"level",
+		"new":"severity",
+		"map":"严重错误 critical, W warning"
+	}`
+}
+
+func (s *Severity) ConfigOptions() []Option {
+	return []Option{
+		transforms.KeyFieldName,
+		{
+			KeyName:      "new",
+			ChooseOnly:   false,
+			Default:      defaultSeverityKey,
+			DefaultNoUse: false,
+			Description:  "统一后的级别字段名(new)",
+			ToolTip:      "统一后的级别写入该字段，可以与原字段相同",
+			Type:         transforms.TransformTypeString,
+		},
+		{
+			KeyName:      "map",
+			ChooseOnly:   false,
+			Default:      "",
+			Placeholder:  "严重错误 critical, W warning",
+			DefaultNoUse: true,
+			Description:  "自定义映射关系(map)",
+			Advance:      true,
+			ToolTip:      "原级别与统一后的级别用空格隔开，多组之间用逗号(,)连接，不区分大小写，优先于内置的映射关系",
+			Type:         transforms.TransformTypeString,
+		},
+		{
+			KeyName:      "unknown",
+			ChooseOnly:   false,
+			Default:      SeverityUnknown,
+			DefaultNoUse: false,
+			Description:  "无法识别时的级别(unknown)",
+			Advance:      true,
+			ToolTip:      "无法识别的级别统一为该值",
+			Type:         transforms.TransformTypeString,
+		},
+	}
+}
+
+func (s *Severity) Stage() string {
+	return transforms.StageAfterParser
+}
+
+func (s *Severity) Stats() StatsInfo {
+	return s.stats
+}
+
+func (s *Severity) SetStats(err string) StatsInfo {
+	s.stats.LastError = err
+	return s.stats
+}
+
+func init() {
+	transforms.Add("severity", func() transforms.Transformer {
+		return &Severity{}
+	})
+}
@@ -0,0 +1,55 @@
+package mutate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+func TestSeverity(t *testing.T) {
+	s := &Severity{
+		Key: "level",
+		Map: "严重错误 critical, W warning",
+	}
+	assert.NoError(t, s.Init())
+
+	datas := []Data{
+		{"level": 3},
+		{"level": "WARN"},
+		{"level": " crit "},
+		{"level": "错误"},
+		{"level": "w"},
+		{"level": "严重错误"},
+		{"level": float64(6)},
+		{"level": "whatever"},
+		{"message": "no level"},
+	}
+	res, err := s.Transform(datas)
+	assert.Error(t, err)
+	exp := []string{"error", "warning", "critical", "error", "warning", "critical", "info", "unknown"}
+	for i, e := range exp {
+		assert.Equal(t, e, res[i]["severity"], "index %d", i)
+	}
+	_, ok := res[8]["severity"]
+	assert.False(t, ok)
+	assert.EqualValues(t, 1, s.UnknownCount())
+	assert.EqualValues(t, 1, s.Stats().Errors)
+	assert.EqualValues(t, 8, s.Stats().Success)
+
+	res, err = s.Transform([]Data{{"level": "verbose"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "unknown", res[0]["severity"])
+	assert.EqualValues(t, 2, s.UnknownCount())
+	assert.Contains(t, s.Stats().LastError, "verbose")
+
+	s = &Severity{Key: "a.level", New: "a.level", Unknown: "other"}
+	assert.NoError(t, s.Init())
+	res, err = s.Transform([]Data{{"a": map[string]interface{}{"level": "Notice"}}, {"a": map[string]interface{}{"level": "x"}}})
+	assert.NoError(t, err)
+	assert.Equal(t, "notice", res[0]["a"].(map[string]interface{})["level"])
+	assert.Equal(t, "other", res[1]["a"].(map[string]interface{})["level"])
+
+	assert.Error(t, (&Severity{}).Init())
+}
@@ -0,0 +1,368 @@
+package mutate
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/qiniu/logkit/transforms"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+var (
+	_ transforms.StatsTransformer = &Unit{}
+	_ transforms.Transformer      = &Unit{}
+	_ transforms.Initializer      = &Unit{}
+)
+
+// 单位的类型
+const (
+	UnitTypeAuto     = "auto"
+	UnitTypeDuration = "duration"
+	UnitTypeSize     = "size"
+)
+
+// durationUnits 时间单位对应的秒数，key 均为小写
+var durationUnits = map[string]float64{
+	"ns":      1e-9,
+	"us":      1e-6,
+	"µs":      1e-6,
+	"μs":      1e-6,
+	"ms":      1e-3,
+	"s":       1,
+	"sec":     1,
+	"secs":    1,
+	"second":  1,
+	"seconds": 1,
+	"m":       60,
+	"min":     60,
+	"mins":    60,
+	"minute":  60,
+	"minutes": 60,
+	"h":       3600,
+	"hr":      3600,
+	"hour":    3600,
+	"hours":   3600,
+	"d":       86400,
+	"day":     86400,
+	"days":    86400,
+}
+
+// sizeUnitPowers 容量单位的幂次，K、KB 等十进制写法的底数由 size_base 决定，KiB 等二进制写法固定为 1024
+var sizeUnitPowers = map[string]int{
+	"b": 0, "byte": 0, "bytes": 0,
+	"k": 1, "kb": 1,
+	"m": 2, "mb": 2,
+	"g": 3, "gb": 3,
+	"t": 4, "tb": 4,
+	"p": 5, "pb": 5,
+}
+
+var binarySizeUnitPowers = map[string]int{
+	"kib": 1,
+	"mib": 2,
+	"gib": 3,
+	"tib": 4,
+	"pib": 5,
+}
+
+// Unit 将字段中带单位的时长(如 10ms、1h30m)转换为秒数，将容量(如 3.5MB、512KiB)转换为字节数，结果为 float64
+type Unit struct {
+	Key         string `json:"key"`
+	New         string `json:"new"`
+	UnitType    string `json:"unit_type"`
+	SizeBase    int    `json:"size_base"`
+	DefaultUnit string `json:"default_unit"`
+
+	keys  [][]string
+	news  [][]string
+	stats StatsInfo
+}
+
+func (u *Unit) Init() error {
+	if strings.TrimSpace(u.Key) == "" {
+		return errors.New("unit transformer key can not be empty")
+	}
+	u.keys = u.keys[:0]
+	for _, key := range strings.Split(u.Key, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			u.keys = append(u.keys, GetKeys(key))
+		}
+	}
+	u.news = u.keys
+	if strings.TrimSpace(u.New) != "" {
+		news := strings.Split(u.New, ",")
+		if len(news) != len(u.keys) {
+			return fmt.Errorf("unit transformer new %q should have the same number of fields as key %q", u.New, u.Key)
+		}
+		u.news = make([][]string, len(news))
+		for i, n := range news {
+			u.news[i] = GetKeys(strings.TrimSpace(n))
+		}
+	}
+	switch u.UnitType {
+	case "":
+		u.UnitType = UnitTypeAuto
+	case UnitTypeAuto, UnitTypeDuration, UnitTypeSize:
+	default:
+		return fmt.Errorf("unit transformer unit_type %q is not supported", u.UnitType)
+	}
+	switch u.SizeBase {
+	case 0:
+		u.SizeBase = 1024
+	case 1000, 1024:
+	default:
+		return fmt.Errorf("unit transformer size_base %d should be 1000 or 1024", u.SizeBase)
+	}
+	if u.DefaultUnit != "" {
+		if _, _, err := u.convert(1, u.DefaultUnit); err != nil {
+			return fmt.Errorf("unit transformer default_unit %v", err)
+		}
+	}
+	return nil
+}
+
+func (u *Unit) RawTransform(datas []string) ([]string, error) {
+	return datas, errors.New("unit transformer not support rawTransform")
+}
+
+// convert 将 unit 单位下的数值 num 转换为秒数或者字节数，isSize 表示是否按容量转换
+func (u *Unit) convert(num float64, unit string) (val float64, isSize bool, err error) {
+	unit = strings.ToLower(unit)
+	// 自动识别时以 b 结尾的单位(如 kb、mib)作为容量，其他作为时长
+	if u.UnitType == UnitTypeDuration || (u.UnitType == UnitTypeAuto && !strings.HasSuffix(unit, "b")) {
+		factor, ok := durationUnits[unit]
+		if !ok {
+			return 0, false, fmt.Errorf("unknown duration unit %q", unit)
+		}
+		return num * factor, false, nil
+	}
+	base, power := float64(u.SizeBase), 0
+	if p, ok := binarySizeUnitPowers[unit]; ok {
+		base, power = 1024, p
+	} else if p, ok = sizeUnitPowers[unit]; ok {
+		power = p
+	} else {
+		return 0, true, fmt.Errorf("unknown size unit %q", unit)
+	}
+	for i := 0; i < power; i++ {
+		num *= base
+	}
+	return num, true, nil
+}
+
+// parse 解析带单位的字符串，时长可以由多段组成(如 1h30m)，没有单位时按 default_unit 处理
+func (u *Unit) parse(str string) (float64, error) {
+	s := strings.TrimSpace(str)
+	if s == "" {
+		return 0, errors.New("empty value")
+	}
+	sign := 1.0
+	if s[0] == '-' || s[0] == '+' {
+		if s[0] == '-' {
+			sign = -1
+		}
+		s = strings.TrimSpace(s[1:])
+	}
+	var (
+		total    float64
+		segments int
+		sized    bool
+	)
+	for s != "" {
+		i := strings.IndexFunc(s, func(r rune) bool { return !unicode.IsDigit(r) && r != '.' })
+		if i == 0 {
+			return 0, fmt.Errorf("invalid value %q", str)
+		}
+		numStr := s
+		if i > 0 {
+			numStr = s[:i]
+		}
+		num, err := strconv.ParseFloat(numStr, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid value %q", str)
+		}
+		s = strings.TrimLeft(s[len(numStr):], " ")
+		j := strings.IndexFunc(s, func(r rune) bool { return unicode.IsDigit(r) || r == '.' || unicode.IsSpace(r) })
+		unit := s
+		if j >= 0 {
+			unit = s[:j]
+		}
+		s = strings.TrimSpace(s[len(unit):])
+		segments++
+		if unit == "" {
+			// 只有单独的数字可以省略单位
+			if s != "" || segments > 1 {
+				return 0, fmt.Errorf("invalid value %q", str)
+			}
+			if u.DefaultUnit == "" {
+				return sign * num, nil
+			}
+			unit = u.DefaultUnit
+		}
+		val, isSize, err := u.convert(num, unit)
+		if err != nil {
+			return 0, err
+		}
+		sized = sized || isSize
+		total += val
+	}
+	// 只有时长可以由多段组成
+	if segments > 1 && sized {
+		return 0, fmt.Errorf("invalid size %q", str)
+	}
+	return sign * total, nil
+}
+
+func (u *Unit) value(val interface{}) (float64, error) {
+	switch v := val.(type) {
+	case string:
+		return u.parse(v)
+	case []byte:
+		return u.parse(string(v))
+	case float64:
+		return u.parse(strconv.FormatFloat(v, 'f', -1, 64))
+	case float32:
+		return u.parse(strconv.FormatFloat(float64(v), 'f', -1, 32))
+	case int, int32, int64, uint, uint32, uint64:
+		return u.parse(fmt.Sprint(v))
+	}
+	return 0, fmt.Errorf("value %v of type %T is not supported", val, val)
+}
+
+func (u *Unit) Transform(datas []Data) ([]Data, error) {
+	if len(u.keys) == 0 {
+		if err := u.Init(); err != nil {
+			return datas, err
+		}
+	}
+
+	var (
+		err, fmtErr error
+		errNum      int
+		dataLen     = len(datas)
+	)
+	for i := range datas {
+		var failed error
+		for idx, keys := range u.keys {
+			val, getErr := GetMapValue(datas[i], keys...)
+			if getErr != nil {
+				failed = fmt.Errorf("transform key %s not exist in data", strings.Join(keys, "."))
+				continue
+			}
+			num, parseErr := u.value(val)
+			if parseErr != nil {
+				failed = fmt.Errorf("parse %s error: %v", strings.Join(keys, "."), parseErr)
+				continue
+			}
+			if setErr := SetMapValue(datas[i], num, false, u.news[idx]...); setErr != nil {
+				failed = fmt.Errorf("value of %s is not the type of map[string]interface{}", strings.Join(u.news[idx], "."))
+			}
+		}
+		// 一条数据中有多个字段出错时只计算一次
+		if failed != nil {
+			errNum, err = transforms.SetError(errNum, failed, transforms.General, "")
+		}
+	}
+
+	u.stats, fmtErr = transforms.SetStatsInfo(err, u.stats, int64(errNum), int64(dataLen), u.Type())
+	return datas, fmtErr
+}
+
+func (u *Unit) Description() string {
+	return `将带单位的时长(如 10ms、1h30m)转换为秒数，将容量(如 3.5MB、512KiB)转换为字节数，便于聚合计算`
+}
+
+func (u *Unit) Type() string {
+	return "unit"
+}
+
+func (u *Unit) SampleConfig() string {
+	return `{
+		"type":"unit",
+		"key":"latency,body_size",
+		"new":"latency_seconds,body_bytes",
+		"unit_type":"auto"
+	}`
+}
+
+func (u *Unit) ConfigOptions() []Option {
+	return []Option{
+		{
+			KeyName:      "key",
+			ChooseOnly:   false,
+			Default:      "",
+			Required:     true,
+			Placeholder:  "latency,body_size",
+			DefaultNoUse: true,
+			Description:  "要转换的字段名(key)",
+			ToolTip:      "多个字段用逗号(,)隔开，嵌套字段用点(.)连接",
+			Type:         transforms.TransformTypeString,
+		},
+		{
+			KeyName:      "new",
+			ChooseOnly:   false,
+			Default:      "",
+			Placeholder:  "latency_seconds,body_bytes",
+			DefaultNoUse: false,
+			Description:  "转换结果的字段名(new)",
+			ToolTip:      "与 key 中的字段一一对应，用逗号(,)隔开，不填时覆盖原字段",
+			Type:         transforms.TransformTypeString,
+		},
+		{
+			KeyName:       "unit_type",
+			Element:       Radio,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{UnitTypeAuto, UnitTypeDuration, UnitTypeSize},
+			Default:       UnitTypeAuto,
+			DefaultNoUse:  false,
+			Description:   "单位类型(unit_type)",
+			ToolTip:       "duration 转换为秒数，size 转换为字节数，auto 将以 b 结尾的单位(如 KB、MiB)作为容量，其他作为时长，没有 b 的容量单位(如 10M)需要选择 size",
+			Type:          transforms.TransformTypeString,
+		},
+		{
+			KeyName:       "size_base",
+			Element:       Radio,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{1024, 1000},
+			Default:       1024,
+			DefaultNoUse:  false,
+			Description:   "容量单位的进制(size_base)",
+			Advance:       true,
+			ToolTip:       "K、KB、M、MB 等单位的进制，KiB、MiB 等单位固定为 1024",
+			Type:          transforms.TransformTypeLong,
+		},
+		{
+			KeyName:      "default_unit",
+			ChooseOnly:   false,
+			Default:      "",
+			Placeholder:  "ms",
+			DefaultNoUse: false,
+			Description:  "没有单位时的默认单位(default_unit)",
+			Advance:      true,
+			ToolTip:      "值为数字或者没有单位时按该单位转换，不填时认为已经是秒数或者字节数",
+			Type:         transforms.TransformTypeString,
+		},
+	}
+}
+
+func (u *Unit) Stage() string {
+	return transforms.StageAfterParser
+}
+
+func (u *Unit) Stats() StatsInfo {
+	return u.stats
+}
+
+func (u *Unit) SetStats(err string) StatsInfo {
+	u.stats.LastError = err
+	return u.stats
+}
+
+func init() {
+	transforms.Add("unit", func() transforms.Transformer {
+		return &Unit{}
+	})
+}
@@ -0,0 +1,97 @@
+package mutate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+func TestUnitParse(t *testing.T) {
+	u := &Unit{Key: "a"}
+	assert.NoError(t, u.Init())
+	tests := map[string]float64{
+		"10ms":     0.01,
+		"1.5s":     1.5,
+		"250 us":   0.00025,
+		"1h30m":    5400,
+		"1m 30.5s": 90.5,
+		"2d":       172800,
+		"-5s":      -5,
+		"3.5MB":    3.5 * 1024 * 1024,
+		"512 KiB":  512 * 1024,
+		"1gb":      1 << 30,
+		"100B":     100,
+		"42":       42,
+	}
+	for str, exp := range tests {
+		got, err := u.parse(str)
+		assert.NoError(t, err, str)
+		assert.InDelta(t, exp, got, 1e-9, str)
+	}
+	for _, str := range []string{"", "ms", "10xs", "1.2.3s", "1MB2KB", "10 20s", "1h30"} {
+		_, err := u.parse(str)
+		assert.Error(t, err, str)
+	}
+
+	u = &Unit{Key: "a", UnitType: UnitTypeSize, SizeBase: 1000}
+	assert.NoError(t, u.Init())
+	got, err := u.parse("10M")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 10e6, got)
+	got, err = u.parse("1KiB")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1024, got)
+	_, err = u.parse("10ms")
+	assert.Error(t, err)
+
+	u = &Unit{Key: "a", UnitType: UnitTypeDuration, DefaultUnit: "ms"}
+	assert.NoError(t, u.Init())
+	got, err = u.parse("1500")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1.5, got)
+	_, err = u.parse("1MB")
+	assert.Error(t, err)
+
+	assert.Error(t, (&Unit{}).Init())
+	assert.Error(t, (&Unit{Key: "a", UnitType: "length"}).Init())
+	assert.Error(t, (&Unit{Key: "a", SizeBase: 1001}).Init())
+	assert.Error(t, (&Unit{Key: "a", DefaultUnit: "xs"}).Init())
+	assert.Error(t, (&Unit{Key: "a,b", New: "c"}).Init())
+}
+
+func TestUnit(t *testing.T) {
+	u := &Unit{
+		Key: "latency,resp.size",
+		New: "latency_seconds,resp.bytes",
+	}
+	assert.NoError(t, u.Init())
+	datas := []Data{
+		{"latency": "120ms", "resp": map[string]interface{}{"size": "1.5KB"}},
+		{"latency": float64(2), "resp": map[string]interface{}{"size": "20B"}},
+		{"latency": "slow", "resp": map[string]interface{}{"size": "bad"}},
+		{"resp": map[string]interface{}{"size": "1MiB"}},
+	}
+	res, err := u.Transform(datas)
+	assert.Error(t, err)
+	assert.InDelta(t, 0.12, res[0]["latency_seconds"], 1e-9)
+	assert.Equal(t, "120ms", res[0]["latency"])
+	assert.EqualValues(t, 1536, res[0]["resp"].(map[string]interface{})["bytes"])
+	assert.EqualValues(t, 2, res[1]["latency_seconds"])
+	assert.EqualValues(t, 20, res[1]["resp"].(map[string]interface{})["bytes"])
+	_, ok := res[2]["latency_seconds"]
+	assert.False(t, ok)
+	assert.EqualValues(t, 1<<20, res[3]["resp"].(map[string]interface{})["bytes"])
+	// 一条数据中多个字段出错只计算一次
+	assert.EqualValues(t, 2, u.Stats().Errors)
+	assert.EqualValues(t, 2, u.Stats().Success)
+
+	// 不配置 new 时覆盖原字段
+	u = &Unit{Key: "took", DefaultUnit: "ms"}
+	assert.NoError(t, u.Init())
+	res, err = u.Transform([]Data{{"took": 250}, {"took": "1s"}})
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0.25, res[0]["took"])
+	assert.EqualValues(t, 1, res[1]["took"])
+}
@@ -0,0 +1,503 @@
+// Package bundle 负责定期下载并原子替换 parser、transform 依赖的辅助数据(GeoIP 数据库、grok pattern、SNMP MIB 等)，
+// 下载的数据需通过签名校验，替换后调用方校验失败时会自动回滚到上一个版本。
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qiniu/log"
+
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const (
+	TypeMMDB = "mmdb" // 单个文件，如 GeoIP 数据库
+	TypeFile = "file" // 单个文件
+	TypeGrok = "grok" // tar.gz 压缩的 pattern 目录，更新后使用其中 pattern 文件的 grok parser 在下一批数据前重新编译
+	TypeMIB  = "mib"  // tar.gz 压缩的 MIB 目录，更新后加入 MIBDIRS，snmp reader 在下一次采集前重新翻译 oid
+
+	DefaultInterval = 24 * time.Hour
+
+	tmpSuffix     = ".tmp"
+	backupSuffix  = ".bak"
+	versionSuffix = ".version"
+	maxBundleSize = 1 << 30
+)
+
+var ErrNoBackup = errors.New("no backup to rollback")
+
+// Config 单个数据包的配置
+type Config struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	URL  string `json:"url"`
+	// SignatureURL 签名地址，默认为 URL + ".sig"，内容为 RSA-SHA256 签名(原始字节或 base64)
+	SignatureURL string `json:"signature_url"`
+	// PublicKey PEM 格式的 RSA 公钥文件路径
+	PublicKey          string `json:"public_key"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+	// Path 本地路径，即 parser、transform 中配置的路径
+	Path     string `json:"path"`
+	Interval string `json:"interval"`
+}
+
+// Status 数据包的当前状态
+type Status struct {
+	Name       string    `json:"name"`
+	Type       string    `json:"type"`
+	Path       string    `json:"path"`
+	Version    string    `json:"version"` // 当前数据的 sha256
+	HasBackup  bool      `json:"has_backup"`
+	LastCheck  time.Time `json:"last_check"`
+	LastUpdate time.Time `json:"last_update"`
+	LastError  string    `json:"last_error"`
+}
+
+// Validator 在替换前校验新下载的数据是否可用
+type Validator func(path string) error
+
+// UpdateHook 数据替换后被调用，用于重新加载数据，返回错误时会回滚
+type UpdateHook func(typ, path string) error
+
+var (
+	registryLock sync.RWMutex
+	validators   = map[string]Validator{}
+	hooks        []UpdateHook
+)
+
+// RegisterValidator 注册某一类数据包的校验方法
+func RegisterValidator(typ string, v Validator) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	validators[typ] = v
+}
+
+// RegisterUpdateHook 注册数据包替换后的回调
+func RegisterUpdateHook(h UpdateHook) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	hooks = append(hooks, h)
+}
+
+func getValidator(typ string) Validator {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	return validators[typ]
+}
+
+func runHooks(typ, path string) error {
+	registryLock.RLock()
+	hs := make([]UpdateHook, len(hooks))
+	copy(hs, hooks)
+	registryLock.RUnlock()
+	for _, h := range hs {
+		if err := h(typ, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type bundle struct {
+	Config
+	isDir     bool
+	interval  time.Duration
+	publicKey *rsa.PublicKey
+
+	// mux 保证同一个数据包的更新和回滚串行执行
+	mux    sync.Mutex
+	status Status
+	// failedVersion 加载失败而被回滚的版本，不再重复尝试
+	failedVersion string
+}
+
+type Manager struct {
+	bundles map[string]*bundle
+	client  *http.Client
+	exit    chan struct{}
+	wg      sync.WaitGroup
+}
+
+func NewManager(confs []Config) (*Manager, error) {
+	m := &Manager{
+		bundles: make(map[string]*bundle, len(confs)),
+		client:  &http.Client{Timeout: 10 * time.Minute},
+		exit:    make(chan struct{}),
+	}
+	for _, c := range confs {
+		b, err := newBundle(c)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := m.bundles[c.Name]; ok {
+			return nil, fmt.Errorf("data bundle %q is duplicated", c.Name)
+		}
+		m.bundles[c.Name] = b
+	}
+	return m, nil
+}
+
+func newBundle(c Config) (*bundle, error) {
+	if c.Name == "" || c.URL == "" || c.Path == "" {
+		return nil, fmt.Errorf("data bundle %q name, url and path are required", c.Name)
+	}
+	b := &bundle{Config: c, interval: DefaultInterval}
+	switch c.Type {
+	case TypeMMDB, TypeFile, "":
+	case TypeGrok, TypeMIB:
+		b.isDir = true
+	default:
+		return nil, fmt.Errorf("data bundle %q type %q is not supported", c.Name, c.Type)
+	}
+	if c.Interval != "" {
+		interval, err := time.ParseDuration(c.Interval)
+		if err != nil {
+			return nil, fmt.Errorf("data bundle %q interval %q is invalid: %v", c.Name, c.Interval, err)
+		}
+		b.interval = interval
+	}
+	if b.SignatureURL == "" {
+		b.SignatureURL = b.URL + ".sig"
+	}
+	if !c.InsecureSkipVerify {
+		if c.PublicKey == "" {
+			return nil, fmt.Errorf("data bundle %q public_key is required unless insecure_skip_verify is set", c.Name)
+		}
+		key, err := loadPublicKey(c.PublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("data bundle %q load public key error: %v", c.Name, err)
+		}
+		b.publicKey = key
+	}
+	version, _ := ioutil.ReadFile(b.Path + versionSuffix)
+	b.status = Status{
+		Name:      b.Name,
+		Type:      b.Type,
+		Path:      b.Path,
+		Version:   strings.TrimSpace(string(version)),
+		HasBackup: isExist(b.Path + backupSuffix),
+	}
+	return b, nil
+}
+
+func loadPublicKey(path string) (*rsa.PublicKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("public key is not RSA")
+	}
+	return key, nil
+}
+
+// Start 启动后立即检查一次更新，之后按各自的间隔定期检查
+func (m *Manager) Start() {
+	for _, b := range m.bundles {
+		m.wg.Add(1)
+		go m.run(b)
+	}
+}
+
+func (m *Manager) run(b *bundle) {
+	defer m.wg.Done()
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+	for {
+		if err := m.update(b); err != nil {
+			log.Errorf("update data bundle %v error: %v", b.Name, err)
+		}
+		select {
+		case <-m.exit:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *Manager) Stop() {
+	close(m.exit)
+	m.wg.Wait()
+}
+
+// Update 立即检查并更新指定的数据包
+func (m *Manager) Update(name string) error {
+	b, ok := m.bundles[name]
+	if !ok {
+		return fmt.Errorf("data bundle %q not found", name)
+	}
+	return m.update(b)
+}
+
+// Rollback 将指定的数据包回滚到上一个版本，再次回滚会恢复到回滚前的版本
+func (m *Manager) Rollback(name string) error {
+	b, ok := m.bundles[name]
+	if !ok {
+		return fmt.Errorf("data bundle %q not found", name)
+	}
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	if err := b.rollback(); err != nil {
+		b.status.LastError = err.Error()
+		return err
+	}
+	if err := runHooks(b.Type, b.Path); err != nil {
+		b.status.LastError = err.Error()
+		return err
+	}
+	b.status.LastError = ""
+	return nil
+}
+
+func (m *Manager) Status() []Status {
+	ret := make([]Status, 0, len(m.bundles))
+	for _, b := range m.bundles {
+		b.mux.Lock()
+		ret = append(ret, b.status)
+		b.mux.Unlock()
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret
+}
+
+func (m *Manager) update(b *bundle) (err error) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.status.LastCheck = time.Now()
+	defer func() {
+		if err != nil {
+			b.status.LastError = err.Error()
+		} else {
+			b.status.LastError = ""
+		}
+	}()
+
+	data, err := m.download(b.URL)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(data)
+	version := hex.EncodeToString(sum[:])
+	if version == b.status.Version && isExist(b.Path) {
+		return nil
+	}
+	if version == b.failedVersion {
+		return fmt.Errorf("version %v has been rolled back as reload failed, skip it", version)
+	}
+	if b.publicKey != nil {
+		sig, err := m.download(b.SignatureURL)
+		if err != nil {
+			return fmt.Errorf("download signature error: %v", err)
+		}
+		if err = verify(b.publicKey, sum[:], sig); err != nil {
+			return err
+		}
+	}
+
+	tmpPath := b.Path + tmpSuffix
+	os.RemoveAll(tmpPath)
+	defer os.RemoveAll(tmpPath)
+	if b.isDir {
+		err = extractTarGz(data, tmpPath)
+	} else {
+		err = writeFile(tmpPath, data)
+	}
+	if err != nil {
+		return err
+	}
+	if validate := getValidator(b.Type); validate != nil {
+		if err = validate(tmpPath); err != nil {
+			return fmt.Errorf("validate new data error: %v", err)
+		}
+	}
+	if err = ioutil.WriteFile(tmpPath+versionSuffix, []byte(version), DefaultFilePerm); err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath + versionSuffix)
+
+	// 旧数据保留为备份，新数据替换到正式路径
+	backupPath := b.Path + backupSuffix
+	os.RemoveAll(backupPath)
+	os.Remove(backupPath + versionSuffix)
+	if isExist(b.Path) {
+		if err = renameWithVersion(b.Path, backupPath); err != nil {
+			return err
+		}
+	}
+	if err = renameWithVersion(tmpPath, b.Path); err != nil {
+		if isExist(backupPath) {
+			renameWithVersion(backupPath, b.Path)
+		}
+		return err
+	}
+	b.status.HasBackup = isExist(backupPath)
+	b.status.Version = version
+	b.status.LastUpdate = time.Now()
+
+	if err = runHooks(b.Type, b.Path); err != nil {
+		log.Errorf("reload data bundle %v error: %v, rollback to previous version", b.Name, err)
+		if rerr := b.rollback(); rerr != nil {
+			return fmt.Errorf("reload error: %v, rollback error: %v", err, rerr)
+		}
+		// 回滚后备份中是加载失败的版本，直接删除，避免再次回滚到该版本
+		b.failedVersion = version
+		os.RemoveAll(backupPath)
+		os.Remove(backupPath + versionSuffix)
+		b.status.HasBackup = false
+		if herr := runHooks(b.Type, b.Path); herr != nil {
+			log.Errorf("reload data bundle %v after rollback error: %v", b.Name, herr)
+		}
+		return fmt.Errorf("reload error: %v, rollback to previous version", err)
+	}
+	log.Infof("data bundle %v has been updated to version %v", b.Name, version)
+	return nil
+}
+
+// rollback 交换当前版本与备份版本，调用方需要持有 b.mux
+func (b *bundle) rollback() error {
+	backupPath := b.Path + backupSuffix
+	if !isExist(backupPath) {
+		return ErrNoBackup
+	}
+	tmpPath := b.Path + tmpSuffix
+	os.RemoveAll(tmpPath)
+	os.Remove(tmpPath + versionSuffix)
+	if err := renameWithVersion(b.Path, tmpPath); err != nil {
+		return err
+	}
+	if err := renameWithVersion(backupPath, b.Path); err != nil {
+		renameWithVersion(tmpPath, b.Path)
+		return err
+	}
+	if err := renameWithVersion(tmpPath, backupPath); err != nil {
+		return err
+	}
+	version, _ := ioutil.ReadFile(b.Path + versionSuffix)
+	b.status.Version = strings.TrimSpace(string(version))
+	b.status.HasBackup = true
+	b.status.LastUpdate = time.Now()
+	return nil
+}
+
+func (m *Manager) download(url string) ([]byte, error) {
+	resp, err := m.client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download %v got status code %v", url, resp.StatusCode)
+	}
+	return ioutil.ReadAll(io.LimitReader(resp.Body, maxBundleSize))
+}
+
+func verify(key *rsa.PublicKey, hashed, sig []byte) error {
+	if decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig))); err == nil {
+		sig = decoded
+	}
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, hashed, sig); err != nil {
+		return fmt.Errorf("verify signature error: %v", err)
+	}
+	return nil
+}
+
+func writeFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), DefaultDirPerm); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, DefaultFilePerm)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	f.Sync()
+	return f.Close()
+}
+
+func extractTarGz(data []byte, dir string) error {
+	gr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer gr.Close()
+	if err = os.MkdirAll(dir, DefaultDirPerm); err != nil {
+		return err
+	}
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := filepath.Clean(hdr.Name)
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("invalid file path %q in archive", hdr.Name)
+		}
+		target := filepath.Join(dir, name)
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err = os.MkdirAll(target, DefaultDirPerm); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			content, err := ioutil.ReadAll(tr)
+			if err != nil {
+				return err
+			}
+			if err = writeFile(target, content); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func renameWithVersion(src, dst string) error {
+	if err := os.Rename(src, dst); err != nil {
+		return err
+	}
+	os.Remove(dst + versionSuffix)
+	if isExist(src + versionSuffix) {
+		return os.Rename(src+versionSuffix, dst+versionSuffix)
+	}
+	return nil
+}
+
+func isExist(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,181 @@
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testServer struct {
+	lock  sync.Mutex
+	files map[string][]byte
+}
+
+func (s *testServer) set(name string, data []byte) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.files[name] = data
+}
+
+func (s *testServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	data, ok := s.files[r.URL.Path]
+	s.lock.Unlock()
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Write(data)
+}
+
+func sign(t *testing.T, key *rsa.PrivateKey, data []byte) []byte {
+	hashed := sha256.Sum256(data)
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:])
+	assert.NoError(t, err)
+	return []byte(base64.StdEncoding.EncodeToString(sig))
+}
+
+func TestBundleUpdateAndRollback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestBundleUpdateAndRollback")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	pubBytes, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	assert.NoError(t, err)
+	pubPath := filepath.Join(dir, "pub.pem")
+	assert.NoError(t, ioutil.WriteFile(pubPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubBytes}), 0600))
+
+	ts := &testServer{files: map[string][]byte{}}
+	server := httptest.NewServer(ts)
+	defer server.Close()
+
+	var failHook bool
+	RegisterUpdateHook(func(typ, path string) error {
+		if failHook && typ == TypeFile {
+			return errors.New("reload failed")
+		}
+		return nil
+	})
+
+	path := filepath.Join(dir, "data.txt")
+	m, err := NewManager([]Config{{
+		Name:      "data",
+		Type:      TypeFile,
+		URL:       server.URL + "/data.txt",
+		PublicKey: pubPath,
+		Path:      path,
+	}})
+	assert.NoError(t, err)
+
+	// 签名不存在或不匹配时不更新
+	ts.set("/data.txt", []byte("v1"))
+	assert.Error(t, m.Update("data"))
+	ts.set("/data.txt.sig", sign(t, key, []byte("other")))
+	assert.Error(t, m.Update("data"))
+	assert.False(t, isExist(path))
+
+	ts.set("/data.txt.sig", sign(t, key, []byte("v1")))
+	assert.NoError(t, m.Update("data"))
+	content, _ := ioutil.ReadFile(path)
+	assert.Equal(t, "v1", string(content))
+	v1 := m.Status()[0].Version
+	assert.NotEmpty(t, v1)
+
+	ts.set("/data.txt", []byte("v2"))
+	ts.set("/data.txt.sig", sign(t, key, []byte("v2")))
+	assert.NoError(t, m.Update("data"))
+	content, _ = ioutil.ReadFile(path)
+	assert.Equal(t, "v2", string(content))
+	assert.True(t, m.Status()[0].HasBackup)
+
+	// 手动回滚，再次回滚恢复到回滚前的版本
+	assert.NoError(t, m.Rollback("data"))
+	content, _ = ioutil.ReadFile(path)
+	assert.Equal(t, "v1", string(content))
+	assert.Equal(t, v1, m.Status()[0].Version)
+	assert.NoError(t, m.Rollback("data"))
+	content, _ = ioutil.ReadFile(path)
+	assert.Equal(t, "v2", string(content))
+	v2 := m.Status()[0].Version
+
+	// 重新加载失败时自动回滚，且不再尝试该版本
+	ts.set("/data.txt", []byte("v3"))
+	ts.set("/data.txt.sig", sign(t, key, []byte("v3")))
+	failHook = true
+	assert.Error(t, m.Update("data"))
+	failHook = false
+	content, _ = ioutil.ReadFile(path)
+	assert.Equal(t, "v2", string(content))
+	assert.False(t, m.Status()[0].HasBackup)
+	assert.Equal(t, ErrNoBackup, m.Rollback("data"))
+	assert.Error(t, m.Update("data"))
+	content, _ = ioutil.ReadFile(path)
+	assert.Equal(t, "v2", string(content))
+
+	// 重启后能识别当前版本
+	m2, err := NewManager([]Config{{Name: "data", URL: server.URL + "/data.txt", Path: path, InsecureSkipVerify: true}})
+	assert.NoError(t, err)
+	assert.Equal(t, v2, m2.Status()[0].Version)
+
+	assert.Error(t, m.Update("none"))
+	_, err = NewManager([]Config{{Name: "data", URL: server.URL + "/data.txt", Path: path}})
+	assert.Error(t, err)
+}
+
+func TestBundleDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestBundleDir")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	content := []byte("MYPATTERN %{WORD:word}")
+	assert.NoError(t, tw.WriteHeader(&tar.Header{Name: "patterns/my", Mode: 0600, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+	_, err = tw.Write(content)
+	assert.NoError(t, err)
+	tw.Close()
+	gw.Close()
+
+	ts := &testServer{files: map[string][]byte{"/grok.tar.gz": buf.Bytes()}}
+	server := httptest.NewServer(ts)
+	defer server.Close()
+
+	RegisterValidator(TypeGrok, func(path string) error {
+		if !isExist(filepath.Join(path, "patterns", "my")) {
+			return errors.New("pattern not found")
+		}
+		return nil
+	})
+	path := filepath.Join(dir, "grok")
+	m, err := NewManager([]Config{{Name: "grok", Type: TypeGrok, URL: server.URL + "/grok.tar.gz", Path: path, InsecureSkipVerify: true}})
+	assert.NoError(t, err)
+	assert.NoError(t, m.Update("grok"))
+	got, err := ioutil.ReadFile(filepath.Join(path, "patterns", "my"))
+	assert.NoError(t, err)
+	assert.Equal(t, content, got)
+
+	// 校验不通过时保留旧数据
+	ts.set("/grok.tar.gz", []byte("not a tar"))
+	assert.Error(t, m.Update("grok"))
+	assert.True(t, isExist(filepath.Join(path, "patterns", "my")))
+}
@@ -0,0 +1,25 @@
+package utils
+
+import (
+	"fmt"
+	"runtime/debug"
+
+	"github.com/qiniu/log"
+)
+
+// GoSafe 在新的 goroutine 中运行 fn，fn panic 时记录堆栈并调用 onPanic 而不是让整个进程退出，
+// onPanic 为 nil 时只记录日志。name 用于在日志和 panic 原因中标识 goroutine
+func GoSafe(name string, fn func(), onPanic func(reason string)) {
+	go func() {
+		defer func() {
+			if rec := recover(); rec != nil {
+				reason := fmt.Sprintf("%s panic: %v", name, rec)
+				log.Errorf("%s\nstack: %s", reason, debug.Stack())
+				if onPanic != nil {
+					onPanic(reason)
+				}
+			}
+		}()
+		fn()
+	}()
+}
@@ -0,0 +1,226 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Batch 按列存储一批数据，同一批数据共享字段名，避免每条数据单独分配一个 map，适用于 parser 到 sender 的大流量场景
+// 值为 nil 表示该行没有这个字段，转换为 Data 时会被忽略
+type Batch struct {
+	fields  []string
+	index   map[string]int
+	columns [][]interface{}
+	rows    int
+}
+
+// NewBatch 创建一个包含 fields 字段的 Batch，capacity 为预估的行数
+func NewBatch(fields []string, capacity int) *Batch {
+	b := &Batch{
+		fields:  make([]string, 0, len(fields)),
+		index:   make(map[string]int, len(fields)),
+		columns: make([][]interface{}, 0, len(fields)),
+	}
+	for _, field := range fields {
+		if _, ok := b.index[field]; ok {
+			continue
+		}
+		b.index[field] = len(b.fields)
+		b.fields = append(b.fields, field)
+		b.columns = append(b.columns, make([]interface{}, 0, capacity))
+	}
+	return b
+}
+
+// BatchFromDatas 将 []Data 转换为 Batch，字段按照第一次出现的顺序排列
+func BatchFromDatas(datas []Data) *Batch {
+	b := NewBatch(nil, len(datas))
+	for _, data := range datas {
+		b.AppendData(data)
+	}
+	return b
+}
+
+// Fields 返回所有字段名，返回值不能修改
+func (b *Batch) Fields() []string {
+	return b.fields
+}
+
+// Len 返回行数
+func (b *Batch) Len() int {
+	return b.rows
+}
+
+// FieldIndex 返回字段所在的列，不存在时返回 -1
+func (b *Batch) FieldIndex(field string) int {
+	if col, ok := b.index[field]; ok {
+		return col
+	}
+	return -1
+}
+
+// AddField 增加一列并返回所在的列，已有的行在该列的值为 nil，字段已经存在时直接返回所在的列
+func (b *Batch) AddField(field string) int {
+	if col, ok := b.index[field]; ok {
+		return col
+	}
+	col := len(b.fields)
+	b.index[field] = col
+	b.fields = append(b.fields, field)
+	b.columns = append(b.columns, make([]interface{}, b.rows))
+	return col
+}
+
+// AppendRows 增加 n 个值全部为 nil 的行，返回第一个新增行的行号
+func (b *Batch) AppendRows(n int) int {
+	start := b.rows
+	for col := range b.columns {
+		for i := 0; i < n; i++ {
+			b.columns[col] = append(b.columns[col], nil)
+		}
+	}
+	b.rows += n
+	return start
+}
+
+// Set 设置某一行某一列的值，列和行必须已经存在，不同的行可以并发设置
+func (b *Batch) Set(row, col int, value interface{}) {
+	b.columns[col][row] = value
+}
+
+// Value 返回某一行某一列的值
+func (b *Batch) Value(row, col int) interface{} {
+	return b.columns[col][row]
+}
+
+// Get 返回某一行指定字段的值，字段不存在或者值为 nil 时 ok 为 false
+func (b *Batch) Get(row int, field string) (value interface{}, ok bool) {
+	col, exist := b.index[field]
+	if !exist {
+		return nil, false
+	}
+	value = b.columns[col][row]
+	return value, value != nil
+}
+
+// AppendData 将一条 Data 追加为新的一行，Data 中新出现的字段会新增一列
+func (b *Batch) AppendData(data Data) {
+	row := b.AppendRows(1)
+	for k, v := range data {
+		b.Set(row, b.AddField(k), v)
+	}
+}
+
+// Filter 只保留 keep 为 true 的行，keep 的长度必须与行数一致
+func (b *Batch) Filter(keep []bool) {
+	if len(keep) != b.rows {
+		panic(fmt.Sprintf("batch filter length %d not equal to rows %d", len(keep), b.rows))
+	}
+	rows := 0
+	for row, ok := range keep {
+		if !ok {
+			continue
+		}
+		if rows != row {
+			for col := range b.columns {
+				b.columns[col][rows] = b.columns[col][row]
+			}
+		}
+		rows++
+	}
+	for col := range b.columns {
+		// 清空被移除的行，避免继续引用原来的值
+		for row := rows; row < b.rows; row++ {
+			b.columns[col][row] = nil
+		}
+		b.columns[col] = b.columns[col][:rows]
+	}
+	b.rows = rows
+}
+
+// Data 将某一行转换为 Data
+func (b *Batch) Data(row int) Data {
+	data := make(Data, len(b.fields))
+	for col, field := range b.fields {
+		if v := b.columns[col][row]; v != nil {
+			data[field] = v
+		}
+	}
+	return data
+}
+
+// Datas 将 Batch 转换为 []Data，用于只支持 Data 的 transformer 和 sender
+func (b *Batch) Datas() []Data {
+	datas := make([]Data, b.rows)
+	for row := range datas {
+		datas[row] = b.Data(row)
+	}
+	return datas
+}
+
+// AppendJSON 将某一行序列化为 json 对象追加到 buf 中，字段按照列的顺序输出，无需先转换为 Data
+func (b *Batch) AppendJSON(buf []byte, row int) ([]byte, error) {
+	buf = append(buf, '{')
+	first := true
+	for col, field := range b.fields {
+		v := b.columns[col][row]
+		if v == nil {
+			continue
+		}
+		if !first {
+			buf = append(buf, ',')
+		}
+		first = false
+		buf = appendJSONString(buf, field)
+		buf = append(buf, ':')
+		var err error
+		if buf, err = appendJSONValue(buf, v); err != nil {
+			return buf, fmt.Errorf("marshal field %v error: %v", field, err)
+		}
+	}
+	return append(buf, '}'), nil
+}
+
+func appendJSONValue(buf []byte, v interface{}) ([]byte, error) {
+	switch value := v.(type) {
+	case string:
+		return appendJSONString(buf, value), nil
+	case int:
+		return strconv.AppendInt(buf, int64(value), 10), nil
+	case int64:
+		return strconv.AppendInt(buf, value, 10), nil
+	case float64:
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return buf, fmt.Errorf("unsupported value %v", value)
+		}
+		// 与 encoding/json 保持一致，过大或者过小的值使用科学计数法
+		format := byte('f')
+		if abs := math.Abs(value); abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+			format = 'e'
+		}
+		return strconv.AppendFloat(buf, value, format, -1, 64), nil
+	case bool:
+		return strconv.AppendBool(buf, value), nil
+	}
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		return buf, err
+	}
+	return append(buf, bytes...), nil
+}
+
+// appendJSONString 只包含无需转义的可见 ASCII 字符时直接写入，否则交给 encoding/json 处理
+func appendJSONString(buf []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 0x20 || c >= 0x7f || c == '"' || c == '\\' || c == '<' || c == '>' || c == '&' {
+			bytes, _ := json.Marshal(s)
+			return append(buf, bytes...)
+		}
+	}
+	buf = append(buf, '"')
+	buf = append(buf, s...)
+	return append(buf, '"')
+}
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatch(t *testing.T) {
+	datas := []Data{
+		{"a": "x", "b": int64(1)},
+		{"a": "y", "c": 1.5},
+		{"b": int64(3), "d": map[string]interface{}{"e": "f"}},
+	}
+	b := BatchFromDatas(datas)
+	assert.Equal(t, 3, b.Len())
+	assert.Equal(t, 4, len(b.Fields()))
+	assert.Equal(t, datas, b.Datas())
+
+	v, ok := b.Get(1, "c")
+	assert.True(t, ok)
+	assert.Equal(t, 1.5, v)
+	_, ok = b.Get(0, "c")
+	assert.False(t, ok)
+	_, ok = b.Get(0, "z")
+	assert.False(t, ok)
+	assert.Equal(t, -1, b.FieldIndex("z"))
+
+	b.Filter([]bool{true, false, true})
+	assert.Equal(t, []Data{datas[0], datas[2]}, b.Datas())
+
+	b = NewBatch([]string{"a", "a", "b"}, 2)
+	assert.Equal(t, []string{"a", "b"}, b.Fields())
+	row := b.AppendRows(2)
+	assert.Equal(t, 0, row)
+	b.Set(1, b.FieldIndex("b"), "v")
+	b.Set(1, b.AddField("c"), true)
+	assert.Equal(t, []Data{{}, {"b": "v", "c": true}}, b.Datas())
+}
+
+func TestBatchAppendJSON(t *testing.T) {
+	datas := []Data{
+		{"str": "plain", "escape": "a\"b\\c<d>\n中文", "int": 10, "int64": int64(-3), "float": 0.5,
+			"small": 1e-7, "big": 1e22, "bool": false, "map": map[string]interface{}{"k": []int{1, 2}}},
+		{},
+	}
+	b := BatchFromDatas(datas)
+	for row, data := range datas {
+		buf, err := b.AppendJSON(nil, row)
+		assert.NoError(t, err)
+		exp, err := json.Marshal(data)
+		assert.NoError(t, err)
+		assert.JSONEq(t, string(exp), string(buf))
+	}
+
+	b = BatchFromDatas([]Data{{"nan": math.NaN()}})
+	_, err := b.AppendJSON(nil, 0)
+	assert.Error(t, err)
+}
@@ -13,23 +13,35 @@ const (
 	ErrNothing = "L200"
 
 	// 单机版 Runner 操作
-	ErrConfigName     = "L1001"
-	ErrRunnerAdd      = "L1002"
-	ErrRunnerDelete   = "L1003"
-	ErrRunnerStart    = "L1004"
-	ErrRunnerStop     = "L1005"
-	ErrRunnerReset    = "L1006"
-	ErrRunnerUpdate   = "L1007"
-	ErrRunnerErrorGet = "L1008"
+	ErrConfigName          = "L1001"
+	ErrRunnerAdd           = "L1002"
+	ErrRunnerDelete        = "L1003"
+	ErrRunnerStart         = "L1004"
+	ErrRunnerStop          = "L1005"
+	ErrRunnerReset         = "L1006"
+	ErrRunnerUpdate        = "L1007"
+	ErrRunnerErrorGet      = "L1008"
+	ErrRunnerCompletionGet = "L1009"
+	ErrRunnerDrain         = "L1010"
+	ErrRunnerBulk          = "L1011"
+	ErrRunnerValidate      = "L1012"
+	ErrRunnerDebug         = "L1013"
+	ErrRunnerCapture       = "L1014"
+	ErrRunnerLocalStore    = "L1015"
+	ErrRunnerFlush         = "L1016"
 
 	// read 相关
-	ErrReadRead = "L1101"
+	ErrReadRead        = "L1101"
+	ErrReadHeadPattern = "L1102"
 	// parse 相关
 	ErrParseParse = "L1201"
 	// transform 相关
 	ErrTransformTransform = "L1301"
 	// send 相关
 	ErrSendSend = "L1401"
+	// 辅助数据包相关
+	ErrBundleUpdate   = "L1501"
+	ErrBundleRollback = "L1502"
 
 	// 集群版 master API
 	ErrClusterSlaves   = "L2001"
@@ -55,18 +67,31 @@ const (
 var ErrorCodeHumanize = map[string]string{
 	ErrNothing: "操作成功",
 
-	ErrConfigName:   "获取 Config 出现错误",
-	ErrRunnerAdd:    "添加 Runner 出现错误",
-	ErrRunnerDelete: "删除 Runner 出现错误",
-	ErrRunnerStart:  "开启 Runner 出现错误",
-	ErrRunnerStop:   "关闭 Runner 出现错误",
-	ErrRunnerReset:  "重置 Runner 出现错误",
-	ErrRunnerUpdate: "更新 Runner 出现错误",
+	ErrConfigName:          "获取 Config 出现错误",
+	ErrRunnerAdd:           "添加 Runner 出现错误",
+	ErrRunnerDelete:        "删除 Runner 出现错误",
+	ErrRunnerStart:         "开启 Runner 出现错误",
+	ErrRunnerStop:          "关闭 Runner 出现错误",
+	ErrRunnerReset:         "重置 Runner 出现错误",
+	ErrRunnerUpdate:        "更新 Runner 出现错误",
+	ErrRunnerCompletionGet: "获取 Runner 已读完文件的校验记录出现错误",
+	ErrRunnerDrain:         "排空并关闭 Runner 出现错误",
+	ErrRunnerBulk:          "批量操作 Runner 出现错误",
+	ErrRunnerValidate:      "检查 Runner 配置出现错误",
+	ErrRunnerDebug:         "修改 Runner 日志级别出现错误",
+	ErrRunnerCapture:       "抓取 Runner 数据出现错误",
+	ErrRunnerLocalStore:    "查询 Runner 本地存储的数据出现错误",
+	ErrRunnerFlush:         "发送 Runner 中缓存的数据出现错误",
+
+	ErrReadHeadPattern: "推断多行日志的行首正则出现错误",
 
 	ErrParseParse: "解析字符串失败",
 
 	ErrTransformTransform: "转化字段失败",
 
+	ErrBundleUpdate:   "更新数据包出现错误",
+	ErrBundleRollback: "回滚数据包出现错误",
+
 	ErrClusterSlaves:   "获取 Slaves 列表出现错误",
 	ErrClusterStatus:   "获取 Slaves 状态出现错误",
 	ErrClusterConfig:   "获取 Slaves Config 出现错误",
@@ -5,6 +5,7 @@ import (
 	"regexp"
 	"runtime"
 	"sync/atomic"
+	"time"
 
 	"github.com/qiniu/logkit/conf"
 	"github.com/qiniu/logkit/utils/equeue"
@@ -40,9 +41,11 @@ const (
 	ContentTypeHeader     = "Content-Type"
 	ContentEncodingHeader = "Content-Encoding"
 
-	ApplicationJson = "application/json"
-	TextPlain       = "text/plain"
-	ApplicationGzip = "application/gzip"
+	ApplicationJson     = "application/json"
+	TextPlain           = "text/plain"
+	ApplicationGzip     = "application/gzip"
+	ApplicationMsgpack  = "application/x-msgpack"
+	ApplicationProtobuf = "application/x-protobuf"
 
 	KeyPandoraStash      = "pandora_stash"       // 当只有一条数据且 sendError 时候，将其转化为 raw 发送到 pandora_stash 这个字段
 	KeyPandoraSeparateId = "pandora_separate_id" // 当一条数据大于2M且 sendError 时候，将其切片，切片记录到 pandora_separate_id 这个字段
@@ -177,6 +180,34 @@ type LagInfo struct {
 	SizeUnit string `json:"sizeunit"`
 	Ftlags   int64  `json:"ftlags"`
 	Total    int64  `json:"total"`
+	// Partitions 按分区统计的 lag，key 为 topic/partition，只有按分区读取的 reader 会返回
+	Partitions map[string]int64 `json:"partitions,omitempty"`
+}
+
+// FileStatus 读取器正在读取的单个文件的进度
+type FileStatus struct {
+	Path     string    `json:"path"`
+	RealPath string    `json:"realpath,omitempty"` // 软链接指向的实际文件
+	Offset   int64     `json:"offset"`             // 已经读取的字节数
+	Size     int64     `json:"size"`               // 文件当前大小，文件已被删除时为 -1
+	Lag      int64     `json:"lag"`                // 尚未读取的字节数
+	LastRead time.Time `json:"last_read"`          // 最后一次读到数据的时间
+	Inactive bool      `json:"inactive"`           // 已读到文件末尾，等待新数据
+	ReadRate int64     `json:"read_rate"`          // 最近每秒读取的字节数
+	ETA      int64     `json:"eta"`                // 按最近的读取速度读完 lag 预计需要的秒数，无法估计时为 -1
+	Error    string    `json:"error,omitempty"`
+}
+
+// ClientStatus 监听端口的读取器的单个客户端的统计
+type ClientStatus struct {
+	Client      string    `json:"client"`      // 客户端地址，不包含端口
+	Protocol    string    `json:"protocol"`    // udp、tcp 或者 tls
+	Connections int64     `json:"connections"` // 当前的连接数，udp 为 0
+	Messages    int64     `json:"messages"`    // 收到的消息数
+	Bytes       int64     `json:"bytes"`       // 收到的字节数
+	Errors      int64     `json:"errors"`      // 解析失败或者分帧错误的消息数
+	LastSeen    time.Time `json:"last_seen"`   // 最后一次收到数据的时间
+	LastError   string    `json:"last_error,omitempty"`
 }
 
 type StatsError struct {
@@ -13,6 +13,7 @@ import (
 	"hash/fnv"
 	"io"
 	"io/ioutil"
+	"math"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -620,10 +621,25 @@ func GetMapValue(m map[string]interface{}, keys ...string) (interface{}, error)
 	return val, err
 }
 
+// IsStaleFileHandle 判断是否为 NFS 等网络文件系统上文件句柄失效的错误
+func IsStaleFileHandle(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "stale NFS file handle")
+}
+
+// 网络文件系统上 stat 可能因为句柄失效临时出错，重试的次数和间隔
+const (
+	staleRetryTimes    = 3
+	staleRetryInterval = 100 * time.Millisecond
+)
+
 // GetRealPath 处理软链接等，找到文件真实路径
 func GetRealPath(path string) (newPath string, fi os.FileInfo, err error) {
 	newPath = path
 	fi, err = os.Lstat(path)
+	for i := 0; i < staleRetryTimes && IsStaleFileHandle(err); i++ {
+		time.Sleep(staleRetryInterval)
+		fi, err = os.Lstat(path)
+	}
 	if err != nil {
 		return
 	}
@@ -1039,6 +1055,68 @@ func AddTagsToData(tags map[string]interface{}, datas []Data, runnername string)
 	return datas
 }
 
+// 标签(tag 文件、环境变量、extra_info 等)与解析出的字段同名时的处理策略
+const (
+	// TagConflictParsedWins 保留解析出的字段，忽略标签，为默认策略
+	TagConflictParsedWins = "parsed_wins"
+	// TagConflictTagWins 使用标签覆盖解析出的字段
+	TagConflictTagWins = "tag_wins"
+	// TagConflictPrefixTags 保留解析出的字段，标签加上前缀后添加到数据中
+	TagConflictPrefixTags = "prefix_tags"
+
+	DefaultTagConflictPrefix = "tag_"
+)
+
+// TagConflictPolicy 标签与解析出的字段同名时的处理策略，零值为 parsed_wins
+type TagConflictPolicy struct {
+	Policy string
+	Prefix string
+}
+
+func NewTagConflictPolicy(policy, prefix string) (TagConflictPolicy, error) {
+	switch policy {
+	case "", TagConflictParsedWins, TagConflictTagWins:
+	case TagConflictPrefixTags:
+		if prefix == "" {
+			prefix = DefaultTagConflictPrefix
+		}
+	default:
+		return TagConflictPolicy{}, fmt.Errorf("tag conflict policy %q is not supported, only %q, %q or %q", policy, TagConflictParsedWins, TagConflictTagWins, TagConflictPrefixTags)
+	}
+	return TagConflictPolicy{Policy: policy, Prefix: prefix}, nil
+}
+
+// AddTag 将标签加到数据中，与数据中已有的字段冲突时返回 true
+func (p TagConflictPolicy) AddTag(data Data, key string, value interface{}) bool {
+	if _, ok := data[key]; !ok {
+		data[key] = value
+		return false
+	}
+	switch p.Policy {
+	case TagConflictTagWins:
+		data[key] = value
+	case TagConflictPrefixTags:
+		// 加上前缀之后仍然冲突时保留数据中的字段
+		if _, ok := data[p.Prefix+key]; !ok {
+			data[p.Prefix+key] = value
+		}
+	}
+	return true
+}
+
+// AddTags 将标签加到每条数据中，返回冲突的次数
+func (p TagConflictPolicy) AddTags(tags map[string]interface{}, datas []Data) int64 {
+	var conflicts int64
+	for _, data := range datas {
+		for k, v := range tags {
+			if p.AddTag(data, k, v) {
+				conflicts++
+			}
+		}
+	}
+	return conflicts
+}
+
 func CheckPath(path string) (string, error) {
 	realPath, fileInfo, err := GetRealPath(path)
 	if err != nil || fileInfo == nil {
@@ -1067,3 +1145,14 @@ func GetTime(timestamp string) (time.Time, error) {
 	}
 	return time.Unix(0, t), nil
 }
+
+// CatchUpETA 按读取速度（字节每秒）估计读完 lag 需要的秒数，没有 lag 时为 0，读取速度为 0 无法估计时返回 -1
+func CatchUpETA(lag int64, rate float64) int64 {
+	if lag <= 0 {
+		return 0
+	}
+	if rate <= 0 {
+		return -1
+	}
+	return int64(math.Ceil(float64(lag) / rate))
+}
@@ -1312,6 +1312,45 @@ func TestParseTimeZoneOffset(t *testing.T) {
 	}
 }
 
+func TestTagConflictPolicy(t *testing.T) {
+	tags := map[string]interface{}{"host": "h1", "env": "prod"}
+	newDatas := func() []Data {
+		return []Data{{"host": "parsed", "tag_host": "x"}, {"msg": "m"}}
+	}
+
+	p, err := NewTagConflictPolicy("", "")
+	assert.NoError(t, err)
+	datas := newDatas()
+	assert.Equal(t, int64(1), p.AddTags(tags, datas))
+	assert.Equal(t, []Data{
+		{"host": "parsed", "tag_host": "x", "env": "prod"},
+		{"msg": "m", "host": "h1", "env": "prod"},
+	}, datas)
+
+	p, err = NewTagConflictPolicy(TagConflictTagWins, "")
+	assert.NoError(t, err)
+	datas = newDatas()
+	assert.Equal(t, int64(1), p.AddTags(tags, datas))
+	assert.Equal(t, Data{"host": "h1", "tag_host": "x", "env": "prod"}, datas[0])
+
+	p, err = NewTagConflictPolicy(TagConflictPrefixTags, "")
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultTagConflictPrefix, p.Prefix)
+	datas = newDatas()
+	assert.Equal(t, int64(1), p.AddTags(tags, datas))
+	// 加上前缀之后仍然冲突时保留解析出的字段
+	assert.Equal(t, Data{"host": "parsed", "tag_host": "x", "env": "prod"}, datas[0])
+
+	p, err = NewTagConflictPolicy(TagConflictPrefixTags, "_")
+	assert.NoError(t, err)
+	datas = newDatas()
+	p.AddTags(tags, datas)
+	assert.Equal(t, Data{"host": "parsed", "tag_host": "x", "_host": "h1", "env": "prod"}, datas[0])
+
+	_, err = NewTagConflictPolicy("merge", "")
+	assert.Error(t, err)
+}
+
 func TestMergeEnvTags(t *testing.T) {
 	key := "TestMergeEnvTags"
 	os.Setenv(key, `{"a":"hello"}`)
@@ -3,7 +3,10 @@ package os
 import (
 	"errors"
 	"fmt"
+	"hash/fnv"
+	"io"
 	"net"
+	"os"
 
 	. "github.com/qiniu/logkit/utils/models"
 )
@@ -47,3 +50,87 @@ func GetLocalIP() (string, error) {
 	}
 	return "127.0.0.1", errors.New("no local IP found")
 }
+
+// NetworkFingerprintSize 网络文件系统上用于计算文件指纹的文件头部字节数
+const NetworkFingerprintSize = 1024
+
+// GetFingerprintByFile 根据文件开头 size 个字节计算文件指纹，文件不足 size 字节时还无法区分，返回 0
+func GetFingerprintByFile(f *os.File, size int) (uint64, error) {
+	buf := make([]byte, size)
+	n, err := f.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	if n < size {
+		return 0, nil
+	}
+	h := fnv.New64a()
+	h.Write(buf)
+	return h.Sum64(), nil
+}
+
+func GetFingerprintByPath(path string, size int) (uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return GetFingerprintByFile(f, size)
+}
+
+// GetFileIdentityByPath 获得文件的唯一标识，网络文件系统(NFS/CIFS等)上 inode 不可靠，使用文件指纹代替
+func GetFileIdentityByPath(path string, network bool) (uint64, error) {
+	if network {
+		return GetFingerprintByPath(path, NetworkFingerprintSize)
+	}
+	return GetIdentifyIDByPath(path)
+}
+
+func GetFileIdentityByFile(f *os.File, network bool) (uint64, error) {
+	if network {
+		return GetFingerprintByFile(f, NetworkFingerprintSize)
+	}
+	return GetIdentifyIDByFile(f)
+}
+
+// FileID 文件在本机上的唯一标识，unix 上为设备号与 inode，windows 上为卷序列号与文件索引，
+// 网络文件系统上 Device 为 0，Index 为文件头部的指纹
+type FileID struct {
+	Device uint64
+	Index  uint64
+}
+
+// IsZero 无法获得文件标识，比如网络文件系统上的文件还不足以计算指纹
+func (id FileID) IsZero() bool {
+	return id.Index == 0
+}
+
+func (id FileID) String() string {
+	return fmt.Sprintf("%d_%d", id.Device, id.Index)
+}
+
+// ParseFileID 解析 FileID.String 的结果
+func ParseFileID(str string) (FileID, error) {
+	var id FileID
+	if _, err := fmt.Sscanf(str, "%d_%d", &id.Device, &id.Index); err != nil {
+		return FileID{}, fmt.Errorf("invalid file id %q: %v", str, err)
+	}
+	return id, nil
+}
+
+// GetFileIdentityIDByPath 获得文件的设备号与 inode(windows 上为 fileID)，网络文件系统上使用文件指纹代替
+func GetFileIdentityIDByPath(path string, network bool) (FileID, error) {
+	if network {
+		fp, err := GetFingerprintByPath(path, NetworkFingerprintSize)
+		return FileID{Index: fp}, err
+	}
+	return GetFileIDByPath(path)
+}
+
+func GetFileIdentityIDByFile(f *os.File, network bool) (FileID, error) {
+	if network {
+		fp, err := GetFingerprintByFile(f, NetworkFingerprintSize)
+		return FileID{Index: fp}, err
+	}
+	return GetFileIDByFile(f)
+}
@@ -2,7 +2,9 @@ package os
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
 
 	. "github.com/qiniu/logkit/utils/models"
@@ -53,3 +55,40 @@ func Test_GetInode(t *testing.T) {
 	assert.True(t, inode > 0)
 	os.RemoveAll("abc")
 }
+
+func TestGetFileIdentityByPath(t *testing.T) {
+	filename := "TestGetFileIdentityByPath"
+	assert.NoError(t, ioutil.WriteFile(filename, []byte("short"), 0644))
+	defer os.Remove(filename)
+
+	// 文件还不足以计算指纹
+	id, err := GetFileIdentityByPath(filename, true)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), id)
+
+	head := strings.Repeat("a", NetworkFingerprintSize)
+	assert.NoError(t, ioutil.WriteFile(filename, []byte(head+"tail1"), 0644))
+	id, err = GetFileIdentityByPath(filename, true)
+	assert.NoError(t, err)
+	assert.NotEqual(t, uint64(0), id)
+
+	// 只和文件头部有关，追加写入不改变指纹
+	assert.NoError(t, ioutil.WriteFile(filename, []byte(head+"tail2"), 0644))
+	f, err := os.Open(filename)
+	assert.NoError(t, err)
+	defer f.Close()
+	fid, err := GetFileIdentityByFile(f, true)
+	assert.NoError(t, err)
+	assert.Equal(t, id, fid)
+
+	assert.NoError(t, ioutil.WriteFile(filename, []byte("b"+head), 0644))
+	nid, err := GetFileIdentityByPath(filename, true)
+	assert.NoError(t, err)
+	assert.NotEqual(t, id, nid)
+
+	inode, err := GetIdentifyIDByPath(filename)
+	assert.NoError(t, err)
+	id, err = GetFileIdentityByPath(filename, false)
+	assert.NoError(t, err)
+	assert.Equal(t, inode, id)
+}
@@ -6,6 +6,7 @@ import (
 	"os/exec"
 	"runtime"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/qiniu/log"
@@ -47,3 +48,27 @@ func _getInfo() string {
 	}
 	return out.String()
 }
+
+var networkFsTypes = map[string]bool{
+	"nfs":    true,
+	"smbfs":  true,
+	"afpfs":  true,
+	"webdav": true,
+	"cifs":   true,
+}
+
+// IsNetworkFS 判断 path 是否在 NFS/CIFS 等网络文件系统上
+func IsNetworkFS(path string) bool {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return false
+	}
+	name := make([]byte, 0, len(st.Fstypename))
+	for _, c := range st.Fstypename {
+		if c == 0 {
+			break
+		}
+		name = append(name, byte(c))
+	}
+	return networkFsTypes[string(name)]
+}
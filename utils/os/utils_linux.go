@@ -6,6 +6,7 @@ import (
 	"os/exec"
 	"runtime"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/qiniu/log"
@@ -47,3 +48,26 @@ func _getInfo() string {
 	}
 	return out.String()
 }
+
+// 网络文件系统的 statfs magic number
+var networkFsMagics = map[uint32]string{
+	0x6969:     "nfs",
+	0x517B:     "smb",
+	0xFF534D42: "cifs",
+	0xFE534D42: "smb2",
+	0x73757245: "coda",
+	0x5346414F: "afs",
+	0x00C36400: "ceph",
+	0x01021997: "9p",
+	0x0BD00BD0: "lustre",
+}
+
+// IsNetworkFS 判断 path 是否在 NFS/CIFS 等网络文件系统上
+func IsNetworkFS(path string) bool {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return false
+	}
+	_, ok := networkFsMagics[uint32(st.Type)]
+	return ok
+}
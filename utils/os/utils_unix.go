@@ -38,3 +38,28 @@ func GetIdentifyIDByFile(f *os.File) (uint64, error) {
 	inode := getInode(finfo)
 	return inode, nil
 }
+
+// getFileID 获得文件所在设备号与 inode
+func getFileID(f os.FileInfo) FileID {
+	s, ok := f.Sys().(*syscall.Stat_t)
+	if !ok {
+		return FileID{}
+	}
+	return FileID{Device: uint64(s.Dev), Index: uint64(s.Ino)}
+}
+
+func GetFileIDByPath(path string) (FileID, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return FileID{}, err
+	}
+	return getFileID(fi), nil
+}
+
+func GetFileIDByFile(f *os.File) (FileID, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return FileID{}, err
+	}
+	return getFileID(fi), nil
+}
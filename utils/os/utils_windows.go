@@ -7,10 +7,12 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"regexp"
 	"runtime"
 	"strings"
 	"syscall"
+	"unsafe"
 
 	"github.com/qiniu/log"
 )
@@ -37,6 +39,50 @@ func GetIdentifyIDByFile(f *os.File) (uint64, error) {
 	return inode, nil
 }
 
+func GetFileIDByPath(path string) (FileID, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return FileID{}, err
+	}
+	defer f.Close()
+	return GetFileIDByFile(f)
+}
+
+// GetFileIDByFile 使用卷序列号与文件索引(fileID)作为文件的唯一标识
+func GetFileIDByFile(f *os.File) (FileID, error) {
+	var d syscall.ByHandleFileInformation
+
+	if err := syscall.GetFileInformationByHandle(syscall.Handle(f.Fd()), &d); err != nil {
+		return FileID{}, fmt.Errorf(" syscall.GetFileInformationByHandle error %v", err)
+	}
+	return FileID{
+		Device: uint64(d.VolumeSerialNumber),
+		Index:  uint64(d.FileIndexHigh)<<32 | uint64(d.FileIndexLow),
+	}, nil
+}
+
+const driveRemote = 4
+
+var procGetDriveType = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDriveTypeW")
+
+// IsNetworkFS 判断 path 是否在网络共享目录上，包括 UNC 路径和映射的网络驱动器
+func IsNetworkFS(path string) bool {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	volume := filepath.VolumeName(abs)
+	if strings.HasPrefix(volume, `\\`) {
+		return true
+	}
+	root, err := syscall.UTF16PtrFromString(volume + `\`)
+	if err != nil {
+		return false
+	}
+	ret, _, _ := procGetDriveType.Call(uintptr(unsafe.Pointer(root)))
+	return ret == driveRemote
+}
+
 func GetOSInfo() *OSInfo {
 	// default osInfo
 	osInfo := &OSInfo{Kernel: "windows", Core: "unknown", Platform: runtime.GOARCH, OS: "windows"}
@@ -0,0 +1,8 @@
+// +build fips
+
+package tlspolicy
+
+// 使用 fips 构建标签编译时默认开启 TLS 策略，无需在配置文件中设置
+func init() {
+	Enable()
+}
@@ -0,0 +1,67 @@
+// Package tlspolicy 提供进程级别的 TLS 策略，开启后所有的 TLS 连接只允许使用经过认证的密码套件以及 TLS1.2
+package tlspolicy
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+)
+
+// PolicyFIPS 只允许使用 FIPS 140-2 认证的密码套件与椭圆曲线
+const PolicyFIPS = "fips"
+
+var enabled int32
+
+// ApprovedCipherSuites 允许使用的密码套件，均为 ECDHE 密钥交换与 AES-GCM 加密
+var ApprovedCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+}
+
+// ApprovedCurves 允许使用的椭圆曲线
+var ApprovedCurves = []tls.CurveID{tls.CurveP256, tls.CurveP384}
+
+// SetPolicy 根据配置开启 TLS 策略，policy 为空时不做限制
+func SetPolicy(policy string) error {
+	switch policy {
+	case "":
+		return nil
+	case PolicyFIPS:
+		Enable()
+		return nil
+	default:
+		return fmt.Errorf("unknown tls policy %q, only %q is supported", policy, PolicyFIPS)
+	}
+}
+
+// Enable 开启 TLS 策略，同时限制 http.DefaultTransport，使用默认 http client 的 reader 和 sender 都会受到限制
+func Enable() {
+	atomic.StoreInt32(&enabled, 1)
+	if t, ok := http.DefaultTransport.(*http.Transport); ok {
+		t.TLSClientConfig = Apply(t.TLSClientConfig)
+	}
+}
+
+func Enabled() bool {
+	return atomic.LoadInt32(&enabled) > 0
+}
+
+// Apply 在开启 TLS 策略时将 cfg 限制为允许的版本、密码套件和椭圆曲线，cfg 为 nil 时会新建一个，未开启时原样返回
+func Apply(cfg *tls.Config) *tls.Config {
+	if cfg == nil {
+		cfg = &tls.Config{}
+	}
+	if !Enabled() {
+		return cfg
+	}
+	// TLS1.3 的密码套件无法配置并且包含未经认证的 ChaCha20，因此固定为 TLS1.2
+	cfg.MinVersion = tls.VersionTLS12
+	cfg.MaxVersion = tls.VersionTLS12
+	cfg.CipherSuites = ApprovedCipherSuites
+	cfg.CurvePreferences = ApprovedCurves
+	cfg.PreferServerCipherSuites = true
+	return cfg
+}
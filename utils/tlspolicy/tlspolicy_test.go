@@ -0,0 +1,28 @@
+package tlspolicy
+
+import (
+	"crypto/tls"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApply(t *testing.T) {
+	assert.NoError(t, SetPolicy(""))
+	assert.False(t, Enabled())
+	cfg := Apply(nil)
+	assert.NotNil(t, cfg)
+	assert.Nil(t, cfg.CipherSuites)
+
+	assert.Error(t, SetPolicy("unknown"))
+	assert.False(t, Enabled())
+
+	assert.NoError(t, SetPolicy(PolicyFIPS))
+	assert.True(t, Enabled())
+	cfg = Apply(&tls.Config{InsecureSkipVerify: true})
+	assert.True(t, cfg.InsecureSkipVerify)
+	assert.EqualValues(t, tls.VersionTLS12, cfg.MinVersion)
+	assert.EqualValues(t, tls.VersionTLS12, cfg.MaxVersion)
+	assert.Equal(t, ApprovedCipherSuites, cfg.CipherSuites)
+	assert.Equal(t, ApprovedCurves, cfg.CurvePreferences)
+}
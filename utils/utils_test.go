@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -174,3 +175,18 @@ func createFileWithContent(filepathn, lines string) {
 	file.WriteString(lines)
 	file.Close()
 }
+
+func TestGoSafe(t *testing.T) {
+	reasons := make(chan string, 1)
+	GoSafe("worker", func() { panic("boom") }, func(reason string) { reasons <- reason })
+	select {
+	case reason := <-reasons:
+		assert.Equal(t, "worker panic: boom", reason)
+	case <-time.After(5 * time.Second):
+		t.Fatal("panic not reported")
+	}
+
+	done := make(chan struct{})
+	GoSafe("worker", func() { close(done) }, func(string) { t.Error("unexpected panic") })
+	<-done
+}